@@ -3,6 +3,9 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -12,19 +15,48 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/robsonek/aiPanel/internal/fsck"
 	"github.com/robsonek/aiPanel/internal/installer"
+	"github.com/robsonek/aiPanel/internal/installer/tui"
+	"github.com/robsonek/aiPanel/internal/modules/backup"
 	"github.com/robsonek/aiPanel/internal/modules/database"
+	"github.com/robsonek/aiPanel/internal/modules/firewall"
 	"github.com/robsonek/aiPanel/internal/modules/hosting"
 	"github.com/robsonek/aiPanel/internal/modules/iam"
+	"github.com/robsonek/aiPanel/internal/modules/mailqueue"
+	"github.com/robsonek/aiPanel/internal/modules/migration"
+	"github.com/robsonek/aiPanel/internal/modules/monitor"
+	"github.com/robsonek/aiPanel/internal/modules/monitoring"
+	"github.com/robsonek/aiPanel/internal/modules/proxies"
+	"github.com/robsonek/aiPanel/internal/modules/system"
+	"github.com/robsonek/aiPanel/internal/modules/versionmgr"
+	"github.com/robsonek/aiPanel/internal/platform/cabundle"
 	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/faultinject"
 	"github.com/robsonek/aiPanel/internal/platform/httpserver"
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
 	"github.com/robsonek/aiPanel/internal/platform/logger"
+	"github.com/robsonek/aiPanel/internal/platform/mailer"
+	"github.com/robsonek/aiPanel/internal/platform/metrics"
+	"github.com/robsonek/aiPanel/internal/platform/ports"
+	"github.com/robsonek/aiPanel/internal/platform/pty"
+	"github.com/robsonek/aiPanel/internal/platform/scheduler"
+	"github.com/robsonek/aiPanel/internal/platform/selfupdate"
+	"github.com/robsonek/aiPanel/internal/platform/settings"
 	"github.com/robsonek/aiPanel/internal/platform/sqlite"
 	"github.com/robsonek/aiPanel/internal/platform/systemd"
+	"github.com/robsonek/aiPanel/internal/platform/templates"
+	"github.com/robsonek/aiPanel/internal/platform/upload"
+	"github.com/robsonek/aiPanel/internal/recovery"
 )
 
 func newHandler(
@@ -33,12 +65,16 @@ func newHandler(
 	iamSvc *iam.Service,
 	hostingSvc *hosting.Service,
 	databaseSvc *database.Service,
+	opts ...httpserver.HandlerOptions,
 ) http.Handler {
-	return httpserver.NewHandler(cfg, log, iamSvc, hostingSvc, databaseSvc)
+	return httpserver.NewHandler(cfg, log, iamSvc, hostingSvc, databaseSvc, opts...)
 }
 
 var lookupCommandPath = exec.LookPath
 
+// version is set by release builds with -ldflags "-X main.version=vX.Y.Z".
+var version = "dev"
+
 const minAdminPasswordLength = installer.MinAdminPasswordLength
 
 func main() {
@@ -67,6 +103,36 @@ func main() {
 	case "update":
 		runUpdate(args[1:])
 		return
+	case "fsck":
+		runFsck(args[1:])
+		return
+	case "runtime":
+		runRuntime(args[1:])
+		return
+	case "verify-runtime":
+		runVerifyRuntime(args[1:])
+		return
+	case "lock":
+		runLock(args[1:])
+		return
+	case "credentials":
+		runCredentials(args[1:])
+		return
+	case "panel":
+		runPanel(args[1:])
+		return
+	case "power":
+		runPower(args[1:])
+		return
+	case "restore":
+		runRestore(args[1:])
+		return
+	case "backup":
+		runBackup(args[1:])
+		return
+	case "migrate":
+		runMigrate(args[1:])
+		return
 	default:
 		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", args[0])
 		printUsage(os.Stderr)
@@ -93,13 +159,34 @@ func printUsage(w io.Writer) {
 	_, _ = fmt.Fprintln(w, "  serve          start panel server (default when no command is provided)")
 	_, _ = fmt.Fprintln(w, "  admin create   create admin user")
 	_, _ = fmt.Fprintln(w, "  install        run installer")
-	_, _ = fmt.Fprintln(w, "  update         refresh runtime components only when lockfile changed")
+	_, _ = fmt.Fprintln(w, "  update         refresh runtime components only when lockfile changed; 'update self' upgrades the panel binary")
+	_, _ = fmt.Fprintln(w, "  fsck           check panel data integrity (use --repair to fix dangling rows)")
+	_, _ = fmt.Fprintln(w, "  runtime        list, enable or disable runtime components")
+	_, _ = fmt.Fprintln(w, "  verify-runtime rebuild runtime components from source and compare with the installed files")
+	_, _ = fmt.Fprintln(w, "  lock           add custom runtime components to the runtime lock (add-component)")
+	_, _ = fmt.Fprintln(w, "  credentials    show the install credentials file once, then delete it")
+	_, _ = fmt.Fprintln(w, "  panel          move the panel to a new domain (set-domain), optionally with a Let's Encrypt certificate")
+	_, _ = fmt.Fprintln(w, "  power          reboot or shut down the host once no jobs are running (reboot, shutdown, cancel, status)")
+	_, _ = fmt.Fprintln(w, "  restore        rebuild a fresh install from a backup: panel.db, sites, docroots, databases, certificates")
+	_, _ = fmt.Fprintln(w, "  migrate site   move a site with its databases to another aiPanel over its API (resumable)")
+	_, _ = fmt.Fprintln(w, "  backup         run, list and restore backups, mirror them to remote storage (sites, panel, sync, list, restore, pull)")
 	_, _ = fmt.Fprintln(w)
 	_, _ = fmt.Fprintln(w, "examples:")
 	_, _ = fmt.Fprintln(w, "  aipanel serve")
 	_, _ = fmt.Fprintln(w, "  aipanel admin create --email admin@example.com --password Secret123!")
 	_, _ = fmt.Fprintln(w, "  aipanel install")
 	_, _ = fmt.Fprintln(w, "  aipanel update")
+	_, _ = fmt.Fprintln(w, "  aipanel update self --check")
+	_, _ = fmt.Fprintln(w, "  aipanel fsck --repair")
+	_, _ = fmt.Fprintln(w, "  aipanel runtime disable postgresql")
+	_, _ = fmt.Fprintln(w, "  aipanel verify-runtime nginx")
+	_, _ = fmt.Fprintln(w, "  aipanel lock add-component --help")
+	_, _ = fmt.Fprintln(w, "  aipanel panel set-domain panel.example.com --lets-encrypt")
+	_, _ = fmt.Fprintln(w, "  aipanel power reboot --delay 5")
+	_, _ = fmt.Fprintln(w, "  aipanel restore --from https://backups.example.com/panel.tar.gz --identity-file backup.key")
+	_, _ = fmt.Fprintln(w, "  aipanel backup sites --site 3")
+	_, _ = fmt.Fprintln(w, "  aipanel migrate site 3 --to https://panel2.example.com --token aipt_...")
+	_, _ = fmt.Fprintln(w, "  aipanel backup pull --dest /root/recovered --endpoint https://s3.example.com --bucket panel-backups")
 }
 
 func ensureRequiredTools(scope string, required []string) error {
@@ -140,58 +227,1008 @@ func runServer() {
 	if err != nil {
 		panic(fmt.Errorf("load config: %w", err))
 	}
-	log := logger.New(cfg.Env)
+	log, logCtl, err := logger.Configure(logger.FromConfig(cfg))
+	if err != nil {
+		panic(fmt.Errorf("configure logger: %w", err))
+	}
+	defer func() {
+		_ = logCtl.Close()
+	}()
+	watchConfigReload(cfgPath, logCtl, log)
+	if cfg.CABundlePath != "" {
+		if err := cabundle.Apply(cfg.CABundlePath, filepath.Join(cfg.DataDir, "ca-bundle.pem")); err != nil {
+			panic(fmt.Errorf("load ca bundle: %w", err))
+		}
+	}
 	store := sqlite.New(cfg.DataDir)
 	if err := store.Init(context.Background()); err != nil {
 		panic(fmt.Errorf("init sqlite: %w", err))
 	}
-	iamSvc := iam.NewService(store, cfg, log)
-	runner := systemd.ExecRunner{}
+	settingsStore := settings.New(store, logger.ForModule(log, "settings"), settings.Builtin(cfg)...)
+	iamSvc := iam.NewService(store, cfg, logger.ForModule(log, "iam"))
+	iamSvc.SetSettings(settingsStore)
+	runner, err := withFaultInjection(systemd.ExecRunner{})
+	if err != nil {
+		panic(err)
+	}
+	if faultinject.Enabled() {
+		log.Warn("fault injection is active", "env", faultinject.EnvVar)
+	}
 	nginxAdapter := hosting.NewNginxAdapter(runner, hosting.NginxAdapterOptions{})
 	phpfpmAdapter := hosting.NewPHPFPMAdapter(runner, hosting.PHPFPMAdapterOptions{})
-	hostingSvc := hosting.NewService(store, cfg, log, runner, nginxAdapter, phpfpmAdapter)
-	mariadbAdapter := database.NewMariaDBAdapter(runner)
-	postgresAdapter := database.NewPostgreSQLAdapter(runner)
-	databaseSvc := database.NewService(store, cfg, log, mariadbAdapter, postgresAdapter)
+	hostingSvc := hosting.NewService(store, cfg, logger.ForModule(log, "hosting"), runner, nginxAdapter, phpfpmAdapter)
+	hostingSvc.SetSSHD(hosting.NewSSHDAdapter(runner, hosting.SSHDAdapterOptions{}))
+	hostingSvc.SetSettings(settingsStore)
+	mariadbAdapter := database.NewMariaDBAdapter(runner, database.MariaDBAdapterOptions{
+		BinlogDir: filepath.Join(cfg.DataDir, "runtime", "mariadb-binlog"),
+	})
+	postgresAdapter := database.NewPostgreSQLAdapter(runner, database.PostgreSQLAdapterOptions{
+		WALArchiveDir: filepath.Join(cfg.DataDir, "runtime", "postgresql-wal"),
+		ScratchDir:    filepath.Join(cfg.DataDir, "runtime", "postgresql-pitr"),
+	})
+	mysqlAdapter := database.NewMySQLAdapter(runner)
+	mongodbAdapter := database.NewMongoDBAdapter(runner, database.MongoDBAdapterOptions{
+		CredentialsFile: filepath.Join(cfg.DataDir, "runtime", "mongodb-admin.json"),
+	})
+	databaseSvc := database.NewService(store, cfg, logger.ForModule(log, "database"), mariadbAdapter, postgresAdapter, database.ServiceOptions{
+		MySQL:   mysqlAdapter,
+		MongoDB: mongodbAdapter,
+		SQLite:  database.NewSQLiteFileAdapter(runner),
+	})
+	databaseSvc.SetSettings(settingsStore)
+	mail := mailer.New(cfg, store, logger.ForModule(log, "mailer"))
+	queue := jobqueue.New(store, logger.ForModule(log, "jobqueue"))
+	// An empty path falls back to the installed /usr/local/bin/aipanel.
+	panelBinary, _ := os.Executable()
+	versionSvc := versionmgr.NewService(store, cfg, logger.ForModule(log, "versionmgr"), runner, queue, versionmgr.Options{
+		PanelBinary: panelBinary,
+		ConfigPath:  cfgPath,
+	})
+	monitoringSvc := monitoring.NewService(store, logger.ForModule(log, "monitoring"))
+	monitoringSvc.SetNginxStatusURL(cfg.NginxStatusURL)
+	monitorSvc := monitor.NewService(store, logger.ForModule(log, "monitor"))
+	systemSvc := system.NewService(store, logger.ForModule(log, "system"), runner, system.Options{})
+	backupSvc := backup.NewService(store, logger.ForModule(log, "backup"), backup.Options{})
+	templateStore := templates.New(templates.DefaultDir)
+	hostingSvc.SetTemplateStore(templateStore)
+	proxiesSvc := proxies.NewService(store, cfg, logger.ForModule(log, "proxies"), runner, nginxAdapter, proxies.Options{})
+	proxiesSvc.SetSettings(settingsStore)
+	if err := startBackgroundJobs(context.Background(), cfg, queue, log, iamSvc, hostingSvc, databaseSvc, versionSvc, monitoringSvc, monitorSvc, systemSvc, backupSvc, mail, settingsStore); err != nil {
+		panic(err)
+	}
 
-	log.Info("aiPanel starting", "addr", cfg.Addr, "env", cfg.Env, "config_path", cfgPath, "data_dir", cfg.DataDir)
+	log.Info("aiPanel starting", "version", version, "addr", cfg.Addr, "listen_addrs", cfg.ListenAddrs, "env", cfg.Env, "config_path", cfgPath, "data_dir", cfg.DataDir)
 
+	portAlloc := ports.New(store)
+	handler := newHandler(cfg, logger.ForModule(log, "http"), iamSvc, hostingSvc, databaseSvc, httpserver.HandlerOptions{
+		Mailer:      mail,
+		VersionMgr:  versionSvc,
+		Monitoring:  monitoringSvc,
+		Monitor:     monitorSvc,
+		PanelDomain: configurePanelDomain(cfg, cfgPath, runner),
+		Templates:   templateStore,
+		Proxies:     proxiesSvc,
+		MailQueue:   mailqueue.NewService(store, logger.ForModule(log, "mailqueue"), runner, mailqueue.Options{}),
+		Ports:       portAlloc,
+		System:      systemSvc,
+		Backups:     backupSvc,
+		Migrations:  migration.NewService(store, logger.ForModule(log, "migration"), hostingSvc, databaseSvc),
+		Firewall:    firewall.NewService(store, cfg, logger.ForModule(log, "firewall"), runner, firewall.Options{}),
+		Updater:     selfupdate.New(version, runner, selfupdate.Options{ManifestURL: cfg.PanelReleaseManifestURL}),
+		Settings:    settingsStore,
+		Jobs:        queue,
+	})
 	srv := &http.Server{
 		Addr:              cfg.Addr,
-		Handler:           newHandler(cfg, log, iamSvc, hostingSvc, databaseSvc),
+		Handler:           handler,
 		ReadTimeout:       15 * time.Second,
 		ReadHeaderTimeout: 5 * time.Second,
 		WriteTimeout:      15 * time.Second,
 		IdleTimeout:       60 * time.Second,
 	}
 
-	if err := srv.ListenAndServe(); err != nil {
-		log.Error("server exited", "error", err.Error())
-		os.Exit(1)
+	listeners, err := httpserver.ListenAll(cfg.Addr, cfg.ListenAddrs)
+	if err != nil {
+		log.Error("listen failed", "addr", cfg.Addr, "listen_addrs", cfg.ListenAddrs, "error", err.Error())
+		os.Exit(1)
+	}
+	claimPanelPorts(context.Background(), portAlloc, listeners, log)
+	// The server stops with the first listener that fails.
+	serveErr := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			serveErr <- srv.Serve(ln)
+		}(ln)
+	}
+	if err := <-serveErr; err != nil {
+		log.Error("server exited", "error", err.Error())
+		os.Exit(1)
+	}
+}
+
+// postAlertWebhook posts an operator alert as {"subject", "body"} JSON.
+func postAlertWebhook(ctx context.Context, url, subject, body string) error {
+	payload, err := json.Marshal(map[string]string{"subject": subject, "body": body})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(string(payload)))
+	if err != nil {
+		return fmt.Errorf("alert webhook: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("alert webhook: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// startBackgroundJobs starts the job queue worker and the recurring scheduler.
+func startBackgroundJobs(
+	ctx context.Context,
+	cfg config.Config,
+	queue *jobqueue.Queue,
+	log *slog.Logger,
+	iamSvc *iam.Service,
+	hostingSvc *hosting.Service,
+	databaseSvc *database.Service,
+	versionSvc *versionmgr.Service,
+	monitoringSvc *monitoring.Service,
+	monitorSvc *monitor.Service,
+	systemSvc *system.Service,
+	backupSvc *backup.Service,
+	mail *mailer.Mailer,
+	settingsStore *settings.Store,
+) error {
+	hostingSvc.RegisterJobs(queue)
+	databaseSvc.RegisterJobs(queue)
+	systemSvc.RegisterJobs(queue)
+	backupSvc.RegisterJobs(queue)
+	// Operator alerts go to the notification addresses, or the ACME
+	// contact when none are set, and to the alert webhook.
+	notify := func(ctx context.Context, subject, body string) error {
+		to := settingsStore.Strings(ctx, settings.Notifications, "emails")
+		if admin := settingsStore.String(ctx, settings.ACME, "email"); len(to) == 0 && admin != "" {
+			to = []string{admin}
+		}
+		webhook := settingsStore.String(ctx, settings.Notifications, "webhook_url")
+		if webhook == "" && (len(to) == 0 || !mail.Configured()) {
+			return mailer.ErrNotConfigured
+		}
+		var errs []error
+		if webhook != "" {
+			errs = append(errs, postAlertWebhook(ctx, webhook, subject, body))
+		}
+		if len(to) > 0 && mail.Configured() {
+			errs = append(errs, mail.Send(ctx, mailer.Message{To: to, Subject: subject, Body: body}))
+		}
+		return errors.Join(errs...)
+	}
+	hostingSvc.SetNotifier(notify)
+	// Site alerts reach the people working on the site, with the admin
+	// address copied.
+	hostingSvc.SetSiteNotifier(func(ctx context.Context, siteID int64, subject, body string) error {
+		if !mail.Configured() {
+			return mailer.ErrNotConfigured
+		}
+		to, err := iamSvc.SiteContacts(ctx, siteID)
+		if err != nil {
+			return err
+		}
+		if admin := settingsStore.String(ctx, settings.ACME, "email"); admin != "" && !slices.Contains(to, admin) {
+			to = append(to, admin)
+		}
+		if len(to) == 0 {
+			return mailer.ErrNotConfigured
+		}
+		return mail.Send(ctx, mailer.Message{To: to, Subject: subject, Body: body})
+	})
+	versionSvc.OnComponentChange(func(component string) {
+		if component == "php-fpm" {
+			hostingSvc.InvalidatePHPVersions()
+		}
+	})
+	hostingSvc.SetPHPStager(versionSvc.StagePHP)
+	// Restored files are a deploy as far as cached pages go.
+	backupSvc.OnRestore(func(ctx context.Context, siteID int64, actor string) {
+		if err := hostingSvc.PurgePageCache(ctx, siteID, actor); err != nil && !errors.Is(err, hosting.ErrPageCacheNotEnabled) {
+			log.Warn("purge page cache after restore", "site_id", siteID, "error", err.Error())
+		}
+	})
+	monitoringSvc.SetNotifier(notify)
+	systemSvc.SetNotifier(notify)
+	monitoringSvc.AddCheck("templates", hostingSvc.CheckTemplates)
+	monitoringSvc.AddCheck("nginx-config", hostingSvc.CheckNginxConfig)
+	monitoringSvc.AddCheck("certificates", hostingSvc.CheckCertificates)
+	monitoringSvc.AddCheck("clock", systemSvc.CheckClock)
+
+	sched := scheduler.New(logger.ForModule(log, "scheduler"))
+	if err := sched.Add("certificate-renewals", scheduler.Daily(3, 30), func(ctx context.Context) error {
+		check, err := hostingSvc.CheckRenewals(ctx)
+		if err != nil {
+			return err
+		}
+		log.Info("certificate renewal check", "checked", check.Checked, "due", len(check.Due), "job_id", check.JobID)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("schedule certificate renewals: %w", err)
+	}
+	if err := sched.Add("site-cron", scheduler.Every(time.Minute), func(ctx context.Context) error {
+		queued, err := hostingSvc.RunDueCronJobs(ctx)
+		if err != nil {
+			return err
+		}
+		if queued > 0 {
+			log.Debug("site cron jobs queued", "count", queued)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("schedule site cron jobs: %w", err)
+	}
+	if err := sched.Add("wordpress-vulnerabilities", scheduler.Daily(4, 15), func(ctx context.Context) error {
+		scanned, err := hostingSvc.ScanWordPressSites(ctx)
+		if err != nil {
+			return err
+		}
+		if scanned > 0 {
+			log.Info("wordpress vulnerability scan", "sites", scanned)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("schedule wordpress vulnerability scan: %w", err)
+	}
+	if err := sched.Add("database-backups", scheduler.Every(time.Minute), func(ctx context.Context) error {
+		queued, err := databaseSvc.RunDueBackups(ctx)
+		if err != nil {
+			return err
+		}
+		if queued > 0 {
+			log.Info("database backups queued", "count", queued)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("schedule database backups: %w", err)
+	}
+	if err := sched.Add("site-file-backups", scheduler.Daily(2, 0), func(ctx context.Context) error {
+		queued, err := backupSvc.RunNightly(ctx)
+		if err != nil {
+			return err
+		}
+		log.Info("site file backups queued", "count", queued)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("schedule site file backups: %w", err)
+	}
+	if err := sched.Add("panel-db-backup", scheduler.Daily(1, 30), func(ctx context.Context) error {
+		_, err := backupSvc.BackupPanel(ctx, backup.TriggerScheduled)
+		return err
+	}); err != nil {
+		return fmt.Errorf("schedule panel database backup: %w", err)
+	}
+	if err := sched.Add("backup-remote-sync", scheduler.Daily(5, 0), func(ctx context.Context) error {
+		if _, err := backupSvc.SyncRemote(ctx); err != nil && !errors.Is(err, backup.ErrRemoteNotConfigured) {
+			return err
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("schedule remote backup sync: %w", err)
+	}
+	if err := sched.Add("database-pitr", scheduler.Daily(2, 15), databaseSvc.MaintainPointInTime); err != nil {
+		return fmt.Errorf("schedule point-in-time maintenance: %w", err)
+	}
+	if err := sched.Add("admin-tools-check", scheduler.Daily(4, 45), func(ctx context.Context) error {
+		tools, err := versionSvc.CheckAdminTools(ctx, "system")
+		if err != nil {
+			return err
+		}
+		for _, t := range tools {
+			if t.UpdateAvailable {
+				log.Info("admin tool update available", "tool", t.Name, "installed", t.InstalledVersion, "latest", t.LatestVersion)
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("schedule admin tool release check: %w", err)
+	}
+	if err := sched.Add("os-updates", scheduler.Every(15*time.Minute), func(ctx context.Context) error {
+		queued, err := systemSvc.RunAutoUpdates(ctx)
+		if err != nil {
+			return err
+		}
+		if queued {
+			log.Info("automatic security updates queued")
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("schedule os updates: %w", err)
+	}
+	if err := sched.Add("self-test", scheduler.Every(time.Hour), func(ctx context.Context) error {
+		run, err := monitoringSvc.RunSelfTest(ctx)
+		if err != nil {
+			return err
+		}
+		if !run.OK {
+			log.Warn("self-test failed", "run_id", run.ID)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("schedule self-test: %w", err)
+	}
+	if err := sched.Add("nginx-status", scheduler.Every(15*time.Second), monitoringSvc.PollNginx); err != nil {
+		return fmt.Errorf("schedule nginx status: %w", err)
+	}
+	if err := sched.Add("resource-samples", scheduler.Every(monitor.ResourceSampleInterval), monitorSvc.SampleResources); err != nil {
+		return fmt.Errorf("schedule resource samples: %w", err)
+	}
+	if err := sched.Add("php-fpm-status", scheduler.Every(time.Minute), hostingSvc.CollectPHPFPMStatus); err != nil {
+		return fmt.Errorf("schedule php-fpm status: %w", err)
+	}
+	if err := sched.Add("retention-prune", scheduler.Daily(3, 45), func(ctx context.Context) error {
+		run, err := systemSvc.PruneRetention(ctx, false, "system")
+		if err != nil {
+			return err
+		}
+		var rows int64
+		var files int
+		for _, t := range run.Targets {
+			rows += t.Rows
+			files += t.Files
+		}
+		log.Info("retention pass", "rows", rows, "files", files)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("schedule retention pruning: %w", err)
+	}
+	if err := sched.Add("site-error-rates", scheduler.Every(time.Minute), hostingSvc.CollectErrorRates); err != nil {
+		return fmt.Errorf("schedule site error rates: %w", err)
+	}
+	if cfg.MetricsExportFormat != "" {
+		host, _ := os.Hostname()
+		exporter, err := metrics.NewExporter(metrics.ExportOptions{
+			Format:   cfg.MetricsExportFormat,
+			URL:      cfg.MetricsExportURL,
+			Token:    cfg.MetricsExportToken,
+			Username: cfg.MetricsExportUsername,
+			Password: cfg.MetricsExportPassword,
+			Labels:   map[string]string{"instance": host},
+		})
+		if err != nil {
+			return err
+		}
+		if err := sched.Add("metrics-export", scheduler.Every(cfg.MetricsExportInterval), func(ctx context.Context) error {
+			return exporter.Push(ctx, metrics.Default.Snapshot(), time.Now())
+		}); err != nil {
+			return fmt.Errorf("schedule metrics export: %w", err)
+		}
+	}
+	// No request is in flight yet, so every staged upload is a leftover.
+	uploadDir := upload.Dir(cfg.DataDir)
+	if removed, err := upload.CleanStale(uploadDir, 0); err != nil {
+		log.Warn("clean stale uploads", "error", err.Error())
+	} else if removed > 0 {
+		log.Info("removed stale uploads", "count", removed)
+	}
+	if err := sched.Add("upload-cleanup", scheduler.Every(time.Hour), func(context.Context) error {
+		_, err := upload.CleanStale(uploadDir, 24*time.Hour)
+		return err
+	}); err != nil {
+		return fmt.Errorf("schedule upload cleanup: %w", err)
+	}
+	queue.Start(ctx)
+	sched.Start(ctx)
+	return nil
+}
+
+// claimPanelPorts records the ports the panel bound, so provisioning never
+// hands them to another service. A port held by someone else is logged, not
+// fatal: the panel is already serving on it.
+func claimPanelPorts(ctx context.Context, alloc *ports.Allocator, listeners []net.Listener, log *slog.Logger) {
+	for _, ln := range listeners {
+		addr, ok := ln.Addr().(*net.TCPAddr)
+		if !ok {
+			continue
+		}
+		if err := alloc.Claim(ctx, "panel", addr.Port); err != nil {
+			log.Warn("panel port reservation failed", "port", addr.Port, "error", err.Error())
+		}
+	}
+}
+
+// withFaultInjection loads AIPANEL_FAULTS rules and wraps runner when any are set.
+func withFaultInjection(runner systemd.Runner) (systemd.Runner, error) {
+	if err := faultinject.LoadFromEnv(); err != nil {
+		return nil, fmt.Errorf("load fault injection rules: %w", err)
+	}
+	if !faultinject.Enabled() {
+		return runner, nil
+	}
+	return faultinject.WrapRunner(runner), nil
+}
+
+func runAdmin(args []string) {
+	if err := ensureRequiredTools("admin", []string{"sqlite3"}); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if len(args) == 0 || args[0] != "create" {
+		fmt.Fprintln(os.Stderr, "usage: aipanel admin create --email <email> --password <password>")
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("admin create", flag.ExitOnError)
+	email := fs.String("email", "", "admin email")
+	password := fs.String("password", "", "admin password")
+	_ = fs.Parse(args[1:])
+
+	if strings.TrimSpace(*email) == "" || strings.TrimSpace(*password) == "" {
+		fmt.Fprintln(os.Stderr, "email and password are required")
+		os.Exit(2)
+	}
+
+	cfgPath := resolveConfigPath()
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config: %v\n", err)
+		os.Exit(1)
+	}
+	log := logger.New(cfg.Env)
+	store := sqlite.New(cfg.DataDir)
+	if err := store.Init(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "init sqlite: %v\n", err)
+		os.Exit(1)
+	}
+	iamSvc := iam.NewService(store, cfg, log)
+	if err := iamSvc.CreateAdmin(context.Background(), *email, *password); err != nil {
+		fmt.Fprintf(os.Stderr, "create admin: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("admin user created")
+}
+
+func runCredentials(args []string) {
+	fs := flag.NewFlagSet("credentials", flag.ExitOnError)
+	file := fs.String("file", "", "credentials file written by install --credentials-file")
+	_ = fs.Parse(args)
+	if strings.TrimSpace(*file) == "" {
+		fmt.Fprintln(os.Stderr, "usage: aipanel credentials --file <path>")
+		os.Exit(2)
+	}
+	content, err := installer.ReadCredentialsFile(strings.TrimSpace(*file))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "%s does not exist; credentials files are deleted after the first read\n", *file)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "read credentials: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(content)
+}
+
+func runFsck(args []string) {
+	if err := ensureRequiredTools("fsck", []string{"sqlite3"}); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "delete dangling rows and expired sessions")
+	asJSON := fs.Bool("json", false, "print report as JSON")
+	_ = fs.Parse(args)
+
+	cfg, err := config.Load(resolveConfigPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config: %v\n", err)
+		os.Exit(1)
+	}
+	store := sqlite.New(cfg.DataDir)
+	if err := store.Init(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "init sqlite: %v\n", err)
+		os.Exit(1)
+	}
+	report, err := fsck.Run(context.Background(), store, fsck.Options{Repair: *repair})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fsck: %v\n", err)
+		os.Exit(1)
+	}
+	printFsckReport(os.Stdout, report, *asJSON)
+	if report.Unresolved() > 0 {
+		os.Exit(1)
+	}
+}
+
+func runRuntime(args []string) {
+	const usage = "usage: aipanel runtime list | enable <component> | disable <component>"
+	if len(args) == 0 || isHelpArg(args[0]) {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err := ensureRequiredTools("runtime", []string{"sqlite3", "systemctl"}); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	cfgPath := resolveConfigPath()
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config: %v\n", err)
+		os.Exit(1)
+	}
+	log := logger.New(cfg.Env)
+	store := sqlite.New(cfg.DataDir)
+	if err := store.Init(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "init sqlite: %v\n", err)
+		os.Exit(1)
+	}
+	svc := versionmgr.NewService(store, cfg, log, systemd.ExecRunner{}, jobqueue.New(store, log), versionmgr.Options{ConfigPath: cfgPath})
+
+	ctx := context.Background()
+	actor := "cli"
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		components, err := svc.ListComponents(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "list components: %v\n", err)
+			os.Exit(1)
+		}
+		for _, c := range components {
+			enabled := "enabled"
+			if !c.Enabled {
+				enabled = "disabled"
+			}
+			fmt.Printf("%-12s %-9s %s\n", c.Name, enabled, c.State)
+		}
+	case args[0] == "enable" && len(args) == 2:
+		if err := svc.EnableComponent(ctx, args[1], actor); err != nil {
+			fmt.Fprintf(os.Stderr, "enable %s: %v\n", args[1], err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s enabled\n", args[1])
+	case args[0] == "disable" && len(args) == 2:
+		if err := svc.DisableComponent(ctx, args[1], actor); err != nil {
+			fmt.Fprintf(os.Stderr, "disable %s: %v\n", args[1], err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s stopped, disabled and masked\n", args[1])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func printFsckReport(w io.Writer, report fsck.Report, asJSON bool) {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(report)
+		return
+	}
+	if len(report.Issues) == 0 {
+		_, _ = fmt.Fprintln(w, "fsck: no issues found")
+		return
+	}
+	for _, issue := range report.Issues {
+		status := "FOUND"
+		if issue.Repaired {
+			status = "REPAIRED"
+		}
+		_, _ = fmt.Fprintf(w, "%-8s %-24s %s: %s\n", status, issue.Kind, issue.Target, issue.Detail)
+	}
+	_, _ = fmt.Fprintf(w, "fsck: %d issue(s), %d unresolved\n", len(report.Issues), report.Unresolved())
+}
+
+// panelDomainOptions builds the installer options that move the running
+// panel to domain, reusing the configure_nginx/configure_tls steps.
+func panelDomainOptions(cfg config.Config, cfgPath, domain string, letsEncrypt bool, email string) installer.Options {
+	opts := installer.DefaultOptions()
+	opts.Addr = cfg.Addr
+	opts.Env = cfg.Env
+	opts.ConfigPath = cfgPath
+	opts.DataDir = cfg.DataDir
+	opts.LogFilePath = "/var/log/aipanel/panel-domain.log"
+	opts.ReverseProxy = true
+	opts.PanelDomain = domain
+	opts.EnableLetsEncrypt = letsEncrypt
+	opts.LetsEncryptEmail = email
+	opts.LetsEncryptStaging = cfg.ACMEStaging
+	opts.LetsEncryptWebroot = cfg.ACMEWebroot
+	return opts
+}
+
+// configurePanelDomain backs the setup wizard and the panel domain API.
+func configurePanelDomain(cfg config.Config, cfgPath string, runner systemd.Runner) httpserver.PanelDomainFunc {
+	return func(ctx context.Context, domain string, letsEncrypt bool, email string) error {
+		return installer.New(panelDomainOptions(cfg, cfgPath, domain, letsEncrypt, email), runner).ConfigurePanelDomain(ctx)
+	}
+}
+
+func runPanel(args []string) {
+	const usage = "usage: aipanel panel set-domain <domain> [--lets-encrypt] [--lets-encrypt-email <email>] [--dry-run]"
+	if len(args) < 2 || args[0] != "set-domain" || isHelpArg(args[1]) {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	domain := strings.ToLower(strings.TrimSpace(args[1]))
+	fs := flag.NewFlagSet("panel set-domain", flag.ExitOnError)
+	letsEncrypt := fs.Bool("lets-encrypt", false, "issue a Let's Encrypt certificate for the new domain")
+	email := fs.String("lets-encrypt-email", "", "email for Let's Encrypt registration (defaults to acme_email from the panel config)")
+	dryRun := fs.Bool("dry-run", false, "do not execute system commands")
+	_ = fs.Parse(args[2:])
+	if domain == "" || domain == "_" || strings.HasPrefix(domain, "-") {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfgPath := resolveConfigPath()
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config: %v\n", err)
+		os.Exit(1)
+	}
+	tlsEmail := strings.TrimSpace(*email)
+	if tlsEmail == "" {
+		tlsEmail = cfg.ACMEEmail
+	}
+	if *letsEncrypt {
+		if err := installer.ValidateLetsEncryptEmail(tlsEmail); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(2)
+		}
+	}
+	runner, err := withFaultInjection(systemd.ExecRunner{DryRun: *dryRun})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	opts := panelDomainOptions(cfg, cfgPath, domain, *letsEncrypt, tlsEmail)
+	if err := installer.New(opts, runner).ConfigurePanelDomain(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "set panel domain: %v\n", err)
+		os.Exit(1)
+	}
+	scheme := "http"
+	if *letsEncrypt {
+		scheme = "https"
+	}
+	fmt.Printf("panel domain set: %s://%s/ (config %s updated, nginx reloaded)\n", scheme, domain, cfgPath)
+}
+
+func runPower(args []string) {
+	const usage = "usage: aipanel power reboot|shutdown [--delay <minutes>] [--force] [--message <text>] | cancel | status"
+	if len(args) == 0 || isHelpArg(args[0]) {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	action := args[0]
+	fs := flag.NewFlagSet("power "+action, flag.ExitOnError)
+	delay := fs.Int("delay", 1, "minutes before the action; logged-in users are warned")
+	force := fs.Bool("force", false, "proceed over running jobs (never over a package upgrade)")
+	message := fs.String("message", "", "wall message shown to logged-in users")
+	_ = fs.Parse(args[1:])
+
+	if err := ensureRequiredTools("power", []string{"sqlite3"}); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	cfg, err := config.Load(resolveConfigPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config: %v\n", err)
+		os.Exit(1)
+	}
+	log := logger.New(cfg.Env)
+	store := sqlite.New(cfg.DataDir)
+	if err := store.Init(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "init sqlite: %v\n", err)
+		os.Exit(1)
+	}
+	svc := system.NewService(store, logger.ForModule(log, "system"), systemd.ExecRunner{}, system.Options{})
+	svc.RegisterJobs(jobqueue.New(store, logger.ForModule(log, "jobqueue")))
+
+	ctx := context.Background()
+	var status system.PowerStatus
+	switch action {
+	case system.PowerReboot, system.PowerShutdown:
+		status, err = svc.Power(ctx, system.PowerRequest{Action: action, DelayMinutes: *delay, Force: *force, Message: *message}, "cli")
+	case "cancel":
+		status, err = svc.CancelPower(ctx, "cli")
+	case "status":
+		status, err = svc.PowerStatus(ctx)
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "power %s: %v\n", action, err)
+		if errors.Is(err, system.ErrHostBusy) {
+			fmt.Fprintln(os.Stderr, "wait for the jobs to finish or pass --force")
+		}
+		os.Exit(1)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(status)
+}
+
+func runRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	from := fs.String("from", "", "backup to restore: a copy of <data_dir>/backups as a directory, file:// URL, or .tar.gz path or http(s) URL")
+	identityFile := fs.String("identity-file", "", "exported install key or client escrow key that opens the backups")
+	force := fs.Bool("force", false, "restore over a panel that already has sites, replacing its backup key")
+	skipCerts := fs.Bool("skip-certs", false, "do not reissue certificates")
+	asJSON := fs.Bool("json", false, "print report as JSON")
+	_ = fs.Parse(args)
+	if strings.TrimSpace(*from) == "" {
+		fmt.Fprintln(os.Stderr, "usage: aipanel restore --from <backup-url> [--identity-file <path>] [--force] [--skip-certs] [--json]")
+		os.Exit(2)
+	}
+	if err := ensureRequiredTools("restore", []string{"sqlite3"}); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	var identity string
+	if *identityFile != "" {
+		raw, err := os.ReadFile(*identityFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "read identity: %v\n", err)
+			os.Exit(1)
+		}
+		identity = string(raw)
+	}
+	cfg, err := config.Load(resolveConfigPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config: %v\n", err)
+		os.Exit(1)
+	}
+	log := logger.New(cfg.Env)
+	store := sqlite.New(cfg.DataDir)
+	if err := store.Init(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "init sqlite: %v\n", err)
+		os.Exit(1)
+	}
+	hostingSvc, databaseSvc, err := newSiteServices(cfg, store, log)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	backupSvc := backup.NewService(store, logger.ForModule(log, "backup"), backup.Options{})
+
+	report, err := recovery.New(store, hostingSvc, databaseSvc, backupSvc).Run(context.Background(), recovery.Options{
+		From:      *from,
+		Identity:  identity,
+		Force:     *force,
+		SkipCerts: *skipCerts,
+		Actor:     "cli",
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "restore: %v\n", err)
+		os.Exit(1)
+	}
+	printRestoreReport(os.Stdout, report, *asJSON)
+	if report.Failures() > 0 {
+		os.Exit(1)
+	}
+}
+
+func runBackup(args []string) {
+	const usage = "usage: aipanel backup sites [--site <id>] | panel | sync | list [--kind <kind>] [--site <id>] [--limit <n>] [--json] |\n" +
+		"       restore (--run <id> | --site <id> [--snapshot <id>]) [--path <path>]... [--identity-file <path>] |\n" +
+		"       pull --dest <dir> [--endpoint <url> --bucket <name> [--prefix <p>] [--region <r>] [--path-style]]"
+	if len(args) == 0 || isHelpArg(args[0]) {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	action := args[0]
+	var paths stringList
+	fs := flag.NewFlagSet("backup "+action, flag.ExitOnError)
+	siteID := fs.Int64("site", 0, "site ID")
+	kind := fs.String("kind", "", "run kind: site_files, database, panel or remote_sync")
+	limit := fs.Int("limit", 0, "number of runs to list")
+	asJSON := fs.Bool("json", false, "print runs as JSON")
+	runID := fs.Int64("run", 0, "backup run to restore")
+	snapshotID := fs.String("snapshot", "", "snapshot to restore (default: the latest)")
+	fs.Var(&paths, "path", "path inside the docroot to restore; repeatable (default: everything)")
+	identityFile := fs.String("identity-file", "", "client escrow key for snapshots the install key does not open")
+	dest := fs.String("dest", "", "directory to download the remote backups into")
+	endpoint := fs.String("endpoint", "", "S3 endpoint (default: the stored remote storage)")
+	bucket := fs.String("bucket", "", "bucket name")
+	prefix := fs.String("prefix", "", "key prefix inside the bucket")
+	region := fs.String("region", "", "bucket region")
+	pathStyle := fs.Bool("path-style", false, "use path-style bucket addressing")
+	_ = fs.Parse(args[1:])
+
+	if err := ensureRequiredTools("backup", []string{"sqlite3"}); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	cfg, err := config.Load(resolveConfigPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config: %v\n", err)
+		os.Exit(1)
+	}
+	log := logger.New(cfg.Env)
+	store := sqlite.New(cfg.DataDir)
+	if err := store.Init(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "init sqlite: %v\n", err)
+		os.Exit(1)
+	}
+	svc := backup.NewService(store, logger.ForModule(log, "backup"), backup.Options{})
+	svc.RegisterJobs(jobqueue.New(store, logger.ForModule(log, "jobqueue")))
+
+	ctx := context.Background()
+	var out any
+	switch action {
+	case "sites":
+		if *siteID > 0 {
+			out, err = svc.BackupSiteNow(ctx, *siteID, "cli")
+			break
+		}
+		var queued int
+		queued, err = svc.BackupAllSites(ctx, "cli")
+		out = map[string]any{"queued": queued}
+	case "panel":
+		var file string
+		file, err = svc.BackupPanel(ctx, backup.TriggerOnDemand)
+		out = map[string]any{"file": file}
+	case "sync":
+		out, err = svc.SyncRemote(ctx)
+	case "list":
+		var runs []backup.BackupRun
+		runs, err = svc.Runs(ctx, backup.RunFilter{Kind: *kind, SiteID: *siteID, Limit: *limit})
+		if err == nil && !*asJSON {
+			printBackupRuns(os.Stdout, runs)
+			return
+		}
+		out = runs
+	case "restore":
+		var identity string
+		if *identityFile != "" {
+			raw, readErr := os.ReadFile(*identityFile)
+			if readErr != nil {
+				fmt.Fprintf(os.Stderr, "read identity: %v\n", readErr)
+				os.Exit(1)
+			}
+			identity = string(raw)
+		}
+		switch {
+		case *runID > 0:
+			out, err = svc.RestoreRun(ctx, *runID, paths, identity, "cli")
+		case *siteID > 0:
+			snapshot := *snapshotID
+			if snapshot == "" {
+				var snaps []backup.Snapshot
+				if snaps, err = svc.Snapshots(ctx, *siteID); err == nil && len(snaps) == 0 {
+					err = backup.ErrSnapshotNotFound
+				}
+				if err != nil {
+					break
+				}
+				snapshot = snaps[0].ID
+			}
+			if len(paths) == 0 {
+				paths = []string{""}
+			}
+			out, err = svc.RestoreFiles(ctx, *siteID, snapshot, paths, identity, "cli")
+		default:
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+	case "pull":
+		if strings.TrimSpace(*dest) == "" {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		remote := backup.RemoteStorage{
+			Endpoint:  *endpoint,
+			Region:    *region,
+			Bucket:    *bucket,
+			Prefix:    strings.Trim(*prefix, "/"),
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			PathStyle: *pathStyle,
+		}
+		if remote.Bucket == "" {
+			// Pulling on the original server, or after a restore.
+			var settings backup.Settings
+			if settings, err = svc.Settings(ctx); err != nil {
+				break
+			}
+			remote = settings.Remote
+		}
+		var pulled int
+		pulled, err = backup.PullRemote(ctx, remote, *dest)
+		out = map[string]any{"downloaded": pulled, "dest": *dest}
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "backup %s: %v\n", action, err)
+		os.Exit(1)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(out)
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func printBackupRuns(w io.Writer, runs []backup.BackupRun) {
+	for _, run := range runs {
+		detail := run.Location
+		if run.Error != "" {
+			detail = run.Error
+		}
+		_, _ = fmt.Fprintf(w, "%-6d %s  %-11s %-7s %-24s %10d  %s\n", run.ID, run.FinishedAt.Local().Format("2006-01-02 15:04"),
+			run.Kind, run.Status, run.Target, run.Bytes, detail)
+	}
+}
+
+// newSiteServices builds the hosting and database services for commands
+// that provision sites outside aipanel serve.
+func newSiteServices(cfg config.Config, store *sqlite.Store, log *slog.Logger) (*hosting.Service, *database.Service, error) {
+	runner, err := withFaultInjection(systemd.ExecRunner{})
+	if err != nil {
+		return nil, nil, err
 	}
+	hostingSvc := hosting.NewService(store, cfg, logger.ForModule(log, "hosting"), runner,
+		hosting.NewNginxAdapter(runner, hosting.NginxAdapterOptions{}),
+		hosting.NewPHPFPMAdapter(runner, hosting.PHPFPMAdapterOptions{}))
+	hostingSvc.SetSSHD(hosting.NewSSHDAdapter(runner, hosting.SSHDAdapterOptions{}))
+	databaseSvc := database.NewService(store, cfg, logger.ForModule(log, "database"),
+		database.NewMariaDBAdapter(runner, database.MariaDBAdapterOptions{
+			BinlogDir: filepath.Join(cfg.DataDir, "runtime", "mariadb-binlog"),
+		}),
+		database.NewPostgreSQLAdapter(runner, database.PostgreSQLAdapterOptions{
+			WALArchiveDir: filepath.Join(cfg.DataDir, "runtime", "postgresql-wal"),
+			ScratchDir:    filepath.Join(cfg.DataDir, "runtime", "postgresql-pitr"),
+		}),
+		database.ServiceOptions{
+			MySQL: database.NewMySQLAdapter(runner),
+			MongoDB: database.NewMongoDBAdapter(runner, database.MongoDBAdapterOptions{
+				CredentialsFile: filepath.Join(cfg.DataDir, "runtime", "mongodb-admin.json"),
+			}),
+			SQLite: database.NewSQLiteFileAdapter(runner),
+		})
+	return hostingSvc, databaseSvc, nil
 }
 
-func runAdmin(args []string) {
-	if err := ensureRequiredTools("admin", []string{"sqlite3"}); err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
-		os.Exit(1)
+func runMigrate(args []string) {
+	const usage = "usage: aipanel migrate site <id> --to <target-panel-url> [--token <api-token>] [--no-final] [--json]"
+	if len(args) < 2 || args[0] != "site" {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
 	}
-	if len(args) == 0 || args[0] != "create" {
-		fmt.Fprintln(os.Stderr, "usage: aipanel admin create --email <email> --password <password>")
+	siteID, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || siteID <= 0 {
+		fmt.Fprintln(os.Stderr, usage)
 		os.Exit(2)
 	}
-	fs := flag.NewFlagSet("admin create", flag.ExitOnError)
-	email := fs.String("email", "", "admin email")
-	password := fs.String("password", "", "admin password")
-	_ = fs.Parse(args[1:])
-
-	if strings.TrimSpace(*email) == "" || strings.TrimSpace(*password) == "" {
-		fmt.Fprintln(os.Stderr, "email and password are required")
+	fs := flag.NewFlagSet("migrate site", flag.ExitOnError)
+	to := fs.String("to", "", "base URL of the target panel, e.g. https://panel2.example.com")
+	token := fs.String("token", os.Getenv("AIPANEL_MIGRATE_TOKEN"), "admin API token on the target (default: $AIPANEL_MIGRATE_TOKEN)")
+	noFinal := fs.Bool("no-final", false, "only copy the files; run again without it for the final pass and cutover")
+	asJSON := fs.Bool("json", false, "print report as JSON")
+	_ = fs.Parse(args[2:])
+	if strings.TrimSpace(*to) == "" {
+		fmt.Fprintln(os.Stderr, usage)
 		os.Exit(2)
 	}
-
-	cfgPath := resolveConfigPath()
-	cfg, err := config.Load(cfgPath)
+	if err := ensureRequiredTools("migrate", []string{"sqlite3"}); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	cfg, err := config.Load(resolveConfigPath())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "load config: %v\n", err)
 		os.Exit(1)
@@ -202,12 +1239,67 @@ func runAdmin(args []string) {
 		fmt.Fprintf(os.Stderr, "init sqlite: %v\n", err)
 		os.Exit(1)
 	}
-	iamSvc := iam.NewService(store, cfg, log)
-	if err := iamSvc.CreateAdmin(context.Background(), *email, *password); err != nil {
-		fmt.Fprintf(os.Stderr, "create admin: %v\n", err)
+	hostingSvc, databaseSvc, err := newSiteServices(cfg, store, log)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	svc := migration.NewService(store, logger.ForModule(log, "migration"), hostingSvc, databaseSvc)
+	opts := migration.SendOptions{To: *to, Token: *token, Final: !*noFinal, Actor: "cli"}
+	if !*asJSON {
+		opts.Progress = os.Stdout
+	}
+	report, err := svc.Send(context.Background(), siteID, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+		fmt.Fprintln(os.Stderr, "run the same command again to resume")
 		os.Exit(1)
 	}
-	fmt.Println("admin user created")
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(report)
+		return
+	}
+	for _, db := range report.Databases {
+		if db.Password != "" {
+			fmt.Printf("new password for %s: %s (update the site config)\n", db.Database.DBUser, db.Password)
+		}
+	}
+	if report.Migration.Status == migration.StatusCompleted {
+		fmt.Printf("migration %d completed; final pass took %s, point DNS for %s at the target now\n",
+			report.Migration.ID, time.Duration(report.FinalPassMS)*time.Millisecond, report.Migration.Domain)
+	}
+}
+
+func printRestoreReport(w io.Writer, report recovery.Report, asJSON bool) {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(report)
+		return
+	}
+	_, _ = fmt.Fprintf(w, "panel.db restored from %s (%d users)\n", report.PanelBackup, report.Users)
+	line := func(kind string, s recovery.Step) {
+		if s.Error != "" {
+			_, _ = fmt.Fprintf(w, "FAILED   %-12s %s: %s\n", kind, s.Target, s.Error)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "OK       %-12s %s: %s\n", kind, s.Target, s.Detail)
+	}
+	for _, s := range report.Sites {
+		line("site", s)
+	}
+	for _, s := range report.Databases {
+		line("database", s.Step)
+		if c := s.Connection; c != nil && c.Password != "" {
+			_, _ = fmt.Fprintf(w, "         new password for %s: %s (update the site config)\n", c.User, c.Password)
+		}
+	}
+	for _, s := range report.Certificates {
+		line("certificate", s)
+	}
+	_, _ = fmt.Fprintf(w, "restore: %d site(s), %d database(s), %d failure(s)\n", len(report.Sites), len(report.Databases), report.Failures())
 }
 
 func runInstall(args []string) {
@@ -219,6 +1311,10 @@ func runInstall(args []string) {
 	}
 
 	if len(args) == 0 {
+		if err := interactiveInputError(os.Stdin); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(2)
+		}
 		opts, dryRun, err := promptInstallOptions(defaults, os.Stdin, os.Stdout)
 		if err != nil {
 			if errors.Is(err, errInstallCancelled) || errors.Is(err, io.EOF) {
@@ -228,7 +1324,13 @@ func runInstall(args []string) {
 			fmt.Fprintf(os.Stderr, "interactive installer failed: %v\n", err)
 			os.Exit(1)
 		}
-		runInstaller(opts, dryRun)
+		// The built-in default password is public; never install with it.
+		generatedPassword, err := replaceDefaultAdminPassword(&opts, defaults)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "generate admin password: %v\n", err)
+			os.Exit(1)
+		}
+		runInstaller(opts, dryRun, uiAuto, generatedPassword)
 		return
 	}
 
@@ -241,10 +1343,63 @@ func runInstall(args []string) {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(2)
 	}
-	runInstaller(opts, dryRun)
+	generatedPassword, err := prepareFlagInstall(&opts, defaults)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	runInstaller(opts, dryRun, *values.ui, generatedPassword)
+}
+
+// prepareFlagInstall fills in the secrets of an install driven by flags.
+// Without --admin-password the public built-in password is replaced by a
+// random one, returned so it can be shown once, whether or not --yes was
+// given; the setup wizard also gets its token.
+func prepareFlagInstall(opts *installer.Options, defaults installer.Options) (string, error) {
+	generatedPassword, err := replaceDefaultAdminPassword(opts, defaults)
+	if err != nil {
+		return "", fmt.Errorf("generate admin password: %w", err)
+	}
+	if opts.SetupWizard {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return "", fmt.Errorf("generate setup token: %w", err)
+		}
+		opts.SetupToken = hex.EncodeToString(buf)
+	}
+	return generatedPassword, nil
+}
+
+// interactiveInputError explains how to install without prompts when in
+// is not a terminal (e.g. curl | bash, CI, cloud-init).
+func interactiveInputError(in *os.File) error {
+	if pty.IsTerminal(in) {
+		return nil
+	}
+	return errors.New("aipanel install: stdin is not a terminal, so the interactive installer cannot prompt\n" +
+		"re-run with --yes to install with defaults, or pass settings as flags (see 'aipanel install --help')")
+}
+
+// replaceDefaultAdminPassword swaps the built-in admin password, which is
+// public, for a random one and returns it; an explicit password is kept and
+// "" returned.
+func replaceDefaultAdminPassword(opts *installer.Options, defaults installer.Options) (string, error) {
+	if opts.AdminPassword != defaults.AdminPassword {
+		return "", nil
+	}
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	opts.AdminPassword = hex.EncodeToString(buf)
+	return opts.AdminPassword, nil
 }
 
 func runUpdate(args []string) {
+	if len(args) > 0 && args[0] == "self" {
+		runSelfUpdate(args[1:])
+		return
+	}
 	defaults := installer.DefaultOptions()
 	fs, values := newInstallFlagSet(defaults)
 	reinstallAll := fs.Bool("reinstall-all", false, "force full reinstall of all installer steps (legacy behavior)")
@@ -265,9 +1420,282 @@ func runUpdate(args []string) {
 		fmt.Fprintln(os.Stderr, "--only is not supported with update; use 'aipanel install --only <step>'")
 		os.Exit(2)
 	}
+	if opts.CABundlePath == "" {
+		// Downloads behind an intercepting proxy need the bundle the
+		// install recorded.
+		if cfg, err := config.Load(opts.ConfigPath); err == nil {
+			opts.CABundlePath = cfg.CABundlePath
+		}
+	}
 	opts.ForceAllSteps = *reinstallAll
 	opts.UpdateChangedOnly = !*reinstallAll
-	runInstaller(opts, dryRun)
+	runInstaller(opts, dryRun, *values.ui, "")
+}
+
+// runSelfUpdate replaces the panel binary with the latest verified
+// release and restarts the panel.
+func runSelfUpdate(args []string) {
+	fs := flag.NewFlagSet("update self", flag.ContinueOnError)
+	check := fs.Bool("check", false, "only report the running and latest version")
+	force := fs.Bool("force", false, "install the latest release even when it is not newer")
+	manifestURL := fs.String("manifest-url", "", "release manifest URL or path (default: panel_release_manifest_url, then the published manifest)")
+	binaryPath := fs.String("binary", selfupdate.DefaultBinaryPath, "panel binary to replace")
+	unit := fs.String("unit", selfupdate.DefaultUnit, "systemd unit restarted after the swap")
+	fs.Usage = func() {
+		out := fs.Output()
+		_, _ = fmt.Fprintln(out, "usage: aipanel update self [flags]")
+		_, _ = fmt.Fprintln(out)
+		_, _ = fmt.Fprintln(out, "Downloads the latest release from the release manifest, verifies its sha256")
+		_, _ = fmt.Fprintln(out, "and GPG signature, swaps the panel binary atomically (keeping <binary>.previous)")
+		_, _ = fmt.Fprintln(out, "and restarts the panel unit.")
+		_, _ = fmt.Fprintln(out)
+		_, _ = fmt.Fprintln(out, "flags:")
+		fs.PrintDefaults()
+	}
+	if len(args) == 1 && isHelpArg(args[0]) {
+		fs.SetOutput(os.Stdout)
+		fs.Usage()
+		return
+	}
+	fs.SetOutput(os.Stderr)
+	if err := fs.Parse(args); err != nil {
+		os.Exit(2)
+	}
+	ref := strings.TrimSpace(*manifestURL)
+	if cfg, err := config.Load(resolveConfigPath()); err == nil {
+		if ref == "" {
+			ref = cfg.PanelReleaseManifestURL
+		}
+		if bundle := cfg.CABundlePath; bundle != "" {
+			if err := cabundle.Apply(bundle, filepath.Join(cfg.DataDir, "ca-bundle.pem")); err != nil {
+				fmt.Fprintf(os.Stderr, "update self: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+	updater := selfupdate.New(version, systemd.ExecRunner{}, selfupdate.Options{
+		ManifestURL: ref,
+		BinaryPath:  strings.TrimSpace(*binaryPath),
+		Unit:        strings.TrimSpace(*unit),
+	})
+	ctx := context.Background()
+	if *check {
+		status, _, err := updater.Check(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "update self: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("running %s, latest %s\n", status.Current, status.Latest)
+		if status.UpdateAvailable {
+			fmt.Println("update available: run 'aipanel update self'")
+		}
+		return
+	}
+	if err := ensureRequiredTools("update self", []string{"gpg", "systemctl"}); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	status, err := updater.Apply(ctx, *force, func(format string, args ...any) {
+		fmt.Printf("[update] "+format+"\n", args...)
+	})
+	if errors.Is(err, selfupdate.ErrUpToDate) {
+		fmt.Printf("already running the latest release %s (use --force to reinstall)\n", status.Latest)
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "update self: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("updated to %s\n", status.Current)
+}
+
+func runVerifyRuntime(args []string) {
+	defaults := installer.DefaultOptions()
+	fs := flag.NewFlagSet("verify-runtime", flag.ContinueOnError)
+	against := fs.String("against", installer.VerifyAgainstInstalled, "compare the rebuild with: installed|attestation")
+	channel := fs.String("runtime-channel", defaults.RuntimeChannel, "runtime release channel: stable|edge")
+	lockPath := fs.String("runtime-lock-path", defaults.RuntimeLockPath, "runtime source lock file path")
+	lockURL := fs.String("runtime-lock-url", defaults.RuntimeLockURL, "runtime source lock URL (used when the lock file is missing)")
+	runtimeDir := fs.String("runtime-install-dir", defaults.RuntimeInstallDir, "runtime install directory")
+	var pinValues stringList
+	fs.Var(&pinValues, "pin", "verify a runtime component pinned to a version listed in the lock, e.g. php-fpm=8.3.15; repeatable")
+	logFile := fs.String("log-file", "/var/log/aipanel/verify-runtime.log", "build log path")
+	asJSON := fs.Bool("json", false, "print results as JSON")
+	fs.Usage = func() {
+		out := fs.Output()
+		_, _ = fmt.Fprintln(out, "usage: aipanel verify-runtime [flags] [component...]")
+		_, _ = fmt.Fprintln(out)
+		_, _ = fmt.Fprintln(out, "Rebuilds each component (all in the channel by default) from its pinned source")
+		_, _ = fmt.Fprintln(out, "and compares the output hashes with the installed tree, or with the CI")
+		_, _ = fmt.Fprintln(out, "attestation of the prebuilt artifact. Exits 1 when any component differs.")
+		_, _ = fmt.Fprintln(out)
+		_, _ = fmt.Fprintln(out, "flags:")
+		fs.PrintDefaults()
+	}
+	if len(args) == 1 && isHelpArg(args[0]) {
+		fs.SetOutput(os.Stdout)
+		fs.Usage()
+		return
+	}
+	fs.SetOutput(os.Stderr)
+	if err := fs.Parse(args); err != nil {
+		os.Exit(2)
+	}
+	if err := ensureRequiredTools("verify-runtime", []string{"unshare", "mount"}); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	opts := defaults
+	opts.RuntimeChannel = strings.TrimSpace(*channel)
+	opts.RuntimeLockPath = strings.TrimSpace(*lockPath)
+	opts.RuntimeLockURL = strings.TrimSpace(*lockURL)
+	opts.RuntimeInstallDir = strings.TrimSpace(*runtimeDir)
+	opts.LogFilePath = strings.TrimSpace(*logFile)
+	pins, err := installer.ParseRuntimePins(pinValues)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify-runtime: %v\n", err)
+		os.Exit(2)
+	}
+	opts.RuntimePins = pins
+	results, err := installer.New(opts, systemd.ExecRunner{}).VerifyRuntime(context.Background(), fs.Args(), *against)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify-runtime: %v\n", err)
+		os.Exit(1)
+	}
+	if !printRuntimeVerifications(os.Stdout, results, *asJSON) {
+		os.Exit(1)
+	}
+}
+
+func runLock(args []string) {
+	const usage = "usage: aipanel lock add-component <name> [flags]"
+	if len(args) == 0 || isHelpArg(args[0]) || args[0] != "add-component" {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	args = args[1:]
+	defaults := installer.DefaultOptions()
+	var channels, build stringList
+	fs := flag.NewFlagSet("lock add-component", flag.ContinueOnError)
+	lockPath := fs.String("runtime-lock-path", defaults.RuntimeLockPath, "runtime source lock file to edit")
+	fs.Var(&channels, "channel", "release channel to add the component to; repeatable (default: every channel)")
+	version := fs.String("version", "", "upstream version")
+	sourceURL := fs.String("source-url", "", "source tarball URL")
+	sourceSHA := fs.String("source-sha256", "", "SHA-256 of the source tarball")
+	signatureURL := fs.String("signature-url", "", "detached signature of the tarball")
+	fingerprint := fs.String("public-key-fingerprint", "", "fingerprint of the key that signs the tarball")
+	fs.Var(&build, "build", "build command run in the unpacked source; repeatable, in order")
+	unit := fs.String("unit", "", "systemd unit name (default: aipanel-runtime-<name>.service)")
+	execStart := fs.String("exec-start", "", "systemd ExecStart")
+	execReload := fs.String("exec-reload", "", "systemd ExecReload")
+	execStop := fs.String("exec-stop", "", "systemd ExecStop")
+	serviceType := fs.String("type", "", "systemd Type (default: simple)")
+	user := fs.String("user", "", "user the unit runs as (default: root)")
+	validate := fs.String("validate", "", "command that must succeed once the unit is running")
+	replace := fs.Bool("replace", false, "replace an existing custom component of that name")
+	fs.Usage = func() {
+		out := fs.Output()
+		_, _ = fmt.Fprintln(out, usage)
+		_, _ = fmt.Fprintln(out)
+		_, _ = fmt.Fprintln(out, "Adds a custom runtime component to the lock file. The installer builds it from the")
+		_, _ = fmt.Fprintln(out, "pinned source into /opt/aipanel/runtime/<name>/<version>, points current at it,")
+		_, _ = fmt.Fprintln(out, "writes and restarts its unit, then runs the validation command. Commands accept")
+		_, _ = fmt.Fprintln(out, "{{install_dir}}, {{runtime_dir}}, {{component}} and {{version}}. A lock refreshed")
+		_, _ = fmt.Fprintln(out, "from --runtime-lock-url keeps custom components the upstream lock does not define.")
+		_, _ = fmt.Fprintln(out)
+		_, _ = fmt.Fprintln(out, "example:")
+		_, _ = fmt.Fprintln(out, "  aipanel lock add-component varnish --version 7.7.1 \\")
+		_, _ = fmt.Fprintln(out, "    --source-url https://varnish-cache.org/downloads/varnish-7.7.1.tgz \\")
+		_, _ = fmt.Fprintln(out, "    --source-sha256 <sha256 of the tarball> \\")
+		_, _ = fmt.Fprintln(out, "    --build './configure --prefix={{install_dir}}' --build 'make -j$(nproc)' --build 'make install' \\")
+		_, _ = fmt.Fprintln(out, "    --exec-start '{{runtime_dir}}/varnish/current/sbin/varnishd -F -a 127.0.0.1:6081 -f /etc/aipanel/varnish/default.vcl' \\")
+		_, _ = fmt.Fprintln(out, "    --validate '{{install_dir}}/sbin/varnishd -V'")
+		_, _ = fmt.Fprintln(out, "  aipanel install --only varnish")
+		_, _ = fmt.Fprintln(out)
+		_, _ = fmt.Fprintln(out, "flags:")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 || isHelpArg(args[0]) {
+		fs.SetOutput(os.Stdout)
+		fs.Usage()
+		return
+	}
+	name := strings.ToLower(strings.TrimSpace(args[0]))
+	fs.SetOutput(os.Stderr)
+	if err := fs.Parse(args[1:]); err != nil {
+		os.Exit(2)
+	}
+	unitName := strings.TrimSpace(*unit)
+	if unitName == "" {
+		unitName = "aipanel-runtime-" + name + ".service"
+	}
+	component := installer.RuntimeComponentLock{
+		Version:              strings.TrimSpace(*version),
+		SourceURL:            strings.TrimSpace(*sourceURL),
+		SourceSHA256:         strings.ToLower(strings.TrimSpace(*sourceSHA)),
+		SignatureURL:         strings.TrimSpace(*signatureURL),
+		PublicKeyFingerprint: strings.TrimSpace(*fingerprint),
+		Build:                installer.RuntimeBuildSpec{Commands: build},
+		Systemd: installer.RuntimeSystemdUnitSpec{
+			Name:       unitName,
+			Type:       strings.TrimSpace(*serviceType),
+			User:       strings.TrimSpace(*user),
+			ExecStart:  strings.TrimSpace(*execStart),
+			ExecReload: strings.TrimSpace(*execReload),
+			ExecStop:   strings.TrimSpace(*execStop),
+		},
+		Validation: installer.RuntimeValidationSpec{Command: strings.TrimSpace(*validate)},
+	}
+	lock, err := installer.LoadRuntimeSourceLock(*lockPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lock add-component: %v\n", err)
+		os.Exit(1)
+	}
+	if err := installer.AddRuntimeComponent(lock, name, component, channels, *replace); err != nil {
+		fmt.Fprintf(os.Stderr, "lock add-component: %v\n", err)
+		os.Exit(1)
+	}
+	if err := installer.WriteRuntimeSourceLock(*lockPath, lock); err != nil {
+		fmt.Fprintf(os.Stderr, "lock add-component: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s %s added to %s\ninstall it with: aipanel install --only %s\n", name, component.Version, *lockPath, name)
+}
+
+// printRuntimeVerifications prints results and reports whether every
+// component was verified as reproducible.
+func printRuntimeVerifications(w io.Writer, results []installer.RuntimeVerification, asJSON bool) bool {
+	const shownDifferences = 20
+	ok := true
+	for _, r := range results {
+		ok = ok && r.Reproducible
+	}
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(results)
+		return ok
+	}
+	for _, r := range results {
+		switch {
+		case r.Error != "":
+			_, _ = fmt.Fprintf(w, "%-12s %-10s ERROR   %s\n", r.Component, r.Version, r.Error)
+		case r.Reproducible:
+			_, _ = fmt.Fprintf(w, "%-12s %-10s OK      %d file(s) match %s\n", r.Component, r.Version, r.Files, r.Against)
+		default:
+			_, _ = fmt.Fprintf(w, "%-12s %-10s DIFFERS %d of %d file(s) differ from %s\n",
+				r.Component, r.Version, len(r.Differences), r.Files, r.Against)
+			for idx, d := range r.Differences {
+				if idx == shownDifferences {
+					_, _ = fmt.Fprintf(w, "    ... %d more (use --json for the full list)\n", len(r.Differences)-shownDifferences)
+					break
+				}
+				_, _ = fmt.Fprintf(w, "    %s\n", d)
+			}
+		}
+	}
+	return ok
 }
 
 type installFlagValues struct {
@@ -275,6 +1703,7 @@ type installFlagValues struct {
 	env             *string
 	configPath      *string
 	dataDir         *string
+	listenAddrs     *string
 	panelBinary     *string
 	unitFile        *string
 	stateFile       *string
@@ -287,13 +1716,52 @@ type installFlagValues struct {
 	runtimeLockPath *string
 	runtimeLockURL  *string
 	runtimeInstall  *string
+	runtimePins     *stringList
+	caBundle        *string
 	reverseProxy    *bool
 	panelDomain     *string
+	catchAllMode    *string
 	letsEncrypt     *bool
 	letsEncryptMail *string
+	letsEncryptTest *bool
 	installPGAdmin  *bool
 	onlyStep        *string
+	stageRuntime    *bool
+	upgradeTools    *bool
+	pmaVersion      *string
+	pmaURL          *string
+	pmaSHA256       *string
+	pmaSHA256URL    *string
+	pmaSigURL       *string
+	pmaFingerprint  *string
+	pmaRoute        *string
+	pgaVersion      *string
+	pgaURL          *string
+	pgaSHA256       *string
+	pgaSigURL       *string
+	pgaFingerprint  *string
+	pgaRoute        *string
+	installAdminer  *bool
+	adminerVersion  *string
+	adminerURL      *string
+	adminerSHA256   *string
+	adminerRoute    *string
+	installRC       *bool
+	rcVersion       *string
+	rcURL           *string
+	rcSHA256        *string
+	rcRoute         *string
+	adminToolsAllow *string
+	randomizeRoutes *bool
 	skipHealthcheck *bool
+	sshPort         *int
+	firewall        *bool
+	createSwap      *string
+	ui              *string
+	summaryEmail    *string
+	credentialsFile *string
+	setupWizard     *bool
+	yes             *bool
 	dryRun          *bool
 }
 
@@ -304,6 +1772,7 @@ func newInstallFlagSet(defaults installer.Options) (*flag.FlagSet, *installFlagV
 		env:             fs.String("env", defaults.Env, "panel environment"),
 		configPath:      fs.String("config", defaults.ConfigPath, "panel config file path"),
 		dataDir:         fs.String("data-dir", defaults.DataDir, "panel data directory"),
+		listenAddrs:     fs.String("listen-addrs", strings.Join(defaults.ListenAddrs, ","), "comma-separated extra panel listen addresses (host:port, unix:/path or iface:<name>:<port>), e.g. a WireGuard address next to the reverse proxy upstream"),
 		panelBinary:     fs.String("panel-binary", defaults.PanelBinaryPath, "target panel binary path"),
 		unitFile:        fs.String("unit-file", defaults.UnitFilePath, "systemd unit file path"),
 		stateFile:       fs.String("state-file", defaults.StateFilePath, "installer checkpoint state path"),
@@ -311,20 +1780,60 @@ func newInstallFlagSet(defaults installer.Options) (*flag.FlagSet, *installFlagV
 		logFile:         fs.String("log-file", defaults.LogFilePath, "installer log path"),
 		adminEmail:      fs.String("admin-email", defaults.AdminEmail, "initial admin email"),
 		adminPassword:   fs.String("admin-password", defaults.AdminPassword, "initial admin password"),
-		installMode:     fs.String("install-mode", defaults.InstallMode, "runtime install mode: source-build"),
+		installMode:     fs.String("install-mode", defaults.InstallMode, "runtime install mode: source-build|binary (binary needs a runtime lock with signed binary artifacts)"),
 		runtimeChannel:  fs.String("runtime-channel", defaults.RuntimeChannel, "runtime release channel: stable|edge"),
 		runtimeLockPath: fs.String("runtime-lock-path", defaults.RuntimeLockPath, "runtime source lock file path"),
 		runtimeLockURL:  fs.String("runtime-lock-url", defaults.RuntimeLockURL, "runtime source lock URL (downloaded before install)"),
 		runtimeInstall:  fs.String("runtime-install-dir", defaults.RuntimeInstallDir, "runtime install directory for source runtime modes"),
+		caBundle:        fs.String("ca-bundle", "", "PEM file of extra CA certificates to trust for downloads, keyservers and ACME, e.g. a TLS-inspecting proxy's CA; kept in the panel config"),
 		reverseProxy:    fs.Bool("reverse-proxy", defaults.ReverseProxy, "bind panel to loopback and expose via nginx reverse proxy"),
 		panelDomain:     fs.String("panel-domain", "", "panel domain for nginx server_name (required with --reverse-proxy)"),
+		catchAllMode:    fs.String("catchall-mode", defaults.CatchAllMode, "answer requests for unknown hosts with drop (444), redirect (to the panel domain) or landing (a static page)"),
 		letsEncrypt:     fs.Bool("lets-encrypt", defaults.EnableLetsEncrypt, "issue Let's Encrypt certificate for panel domain (requires --reverse-proxy)"),
 		letsEncryptMail: fs.String("lets-encrypt-email", defaults.LetsEncryptEmail, "email for Let's Encrypt registration (required with --lets-encrypt)"),
+		letsEncryptTest: fs.Bool("lets-encrypt-staging", defaults.LetsEncryptStaging, "use the Let's Encrypt staging server (untrusted certificates, no rate limits)"),
 		installPGAdmin:  fs.Bool("install-pgadmin", !defaults.SkipPGAdmin, "install pgAdmin (service + nginx route)"),
-		onlyStep:        fs.String("only", "", "run one installer step or runtime component name (e.g. install_phpmyadmin, install_pgadmin, postgresql, mariadb, php-fpm, nginx)"),
+		onlyStep:        fs.String("only", "", "run one installer step or runtime component name (e.g. install_phpmyadmin, install_pgadmin, install_adminer, install_roundcube, postgresql, mariadb, php-fpm, nginx)"),
+		stageRuntime:    fs.Bool("stage-runtime", false, "install runtime components next to the active version without switching to them (blue/green PHP upgrades)"),
+		upgradeTools:    fs.Bool("upgrade-admin-tools", false, "replace phpMyAdmin/pgAdmin installs whose recorded version differs from the requested one"),
+		pmaVersion:      fs.String("phpmyadmin-version", defaults.PHPMyAdminVersion, "phpMyAdmin release version"),
+		pmaURL:          fs.String("phpmyadmin-url", defaults.PHPMyAdminURL, "phpMyAdmin release archive URL"),
+		pmaSHA256:       fs.String("phpmyadmin-sha256", defaults.PHPMyAdminSHA256, "pinned SHA-256 of the phpMyAdmin archive (overrides --phpmyadmin-sha256-url)"),
+		pmaSHA256URL:    fs.String("phpmyadmin-sha256-url", defaults.PHPMyAdminSHA256URL, "phpMyAdmin archive checksum URL"),
+		pmaSigURL:       fs.String("phpmyadmin-signature-url", defaults.PHPMyAdminSignatureURL, "phpMyAdmin archive signature URL"),
+		pmaFingerprint:  fs.String("phpmyadmin-fingerprint", defaults.PHPMyAdminFingerprint, "phpMyAdmin release key fingerprint"),
+		pmaRoute:        fs.String("phpmyadmin-route", defaults.PHPMyAdminRoutePath, "phpMyAdmin route path on the panel vhost"),
+		pgaVersion:      fs.String("pgadmin-version", defaults.PGAdminVersion, "pgAdmin release version"),
+		pgaURL:          fs.String("pgadmin-url", defaults.PGAdminURL, "pgAdmin wheel URL"),
+		pgaSHA256:       fs.String("pgadmin-sha256", defaults.PGAdminSHA256, "pinned SHA-256 of the pgAdmin wheel"),
+		pgaSigURL:       fs.String("pgadmin-signature-url", defaults.PGAdminSignatureURL, "pgAdmin wheel signature URL"),
+		pgaFingerprint:  fs.String("pgadmin-fingerprint", defaults.PGAdminFingerprint, "pgAdmin release key fingerprint"),
+		pgaRoute:        fs.String("pgadmin-route", defaults.PGAdminRoutePath, "pgAdmin route path on the panel vhost"),
+		installAdminer:  fs.Bool("install-adminer", !defaults.SkipAdminer, "install Adminer behind panel session authentication (requires --adminer-sha256)"),
+		adminerVersion:  fs.String("adminer-version", defaults.AdminerVersion, "Adminer release version"),
+		adminerURL:      fs.String("adminer-url", defaults.AdminerURL, "Adminer single-file release URL"),
+		adminerSHA256:   fs.String("adminer-sha256", defaults.AdminerSHA256, "pinned SHA-256 of the Adminer release file"),
+		adminerRoute:    fs.String("adminer-route", defaults.AdminerRoutePath, "Adminer route path on the panel vhost"),
+		installRC:       fs.Bool("install-roundcube", !defaults.SkipRoundcube, "install Roundcube webmail behind panel session authentication (requires --roundcube-sha256)"),
+		rcVersion:       fs.String("roundcube-version", defaults.RoundcubeVersion, "Roundcube release version"),
+		rcURL:           fs.String("roundcube-url", defaults.RoundcubeURL, "Roundcube complete release archive URL"),
+		rcSHA256:        fs.String("roundcube-sha256", defaults.RoundcubeSHA256, "pinned SHA-256 of the Roundcube release archive"),
+		rcRoute:         fs.String("roundcube-route", defaults.RoundcubeRoutePath, "Roundcube route path on the panel vhost"),
+		adminToolsAllow: fs.String("admin-tools-allow", strings.Join(defaults.AdminToolsAllow, ","), "comma-separated IPs/CIDRs allowed to reach phpMyAdmin, pgAdmin and Adminer (empty allows any address with a panel session)"),
+		randomizeRoutes: fs.Bool("randomize-admin-routes", defaults.RandomizeAdminRoutes, "serve admin tools left on their default route under a random path, kept across reruns (false uses the routes as given)"),
 		skipHealthcheck: fs.Bool("skip-healthcheck", false, "skip final /health check"),
+		sshPort:         fs.Int("ssh-port", defaults.SSHPort, "SSH port kept open by the firewall"),
+		firewall:        fs.Bool("firewall", !defaults.SkipFirewall, "write and enable the nftables firewall (drops inbound traffic except SSH, HTTP, HTTPS and the panel port)"),
+		createSwap:      fs.String("create-swap", "", "create, enable and persist a swapfile of this size (e.g. 2G, 1536M) before runtime builds"),
+		ui:              fs.String("ui", uiAuto, "progress display: auto (terminal UI on a TTY), tui or plain"),
+		summaryEmail:    fs.String("summary-email", "", "mail the install summary (URL, admin login, next steps; no password) through the SMTP relay in the panel config"),
+		credentialsFile: fs.String("credentials-file", "", "write the admin credentials to this 0600 file, deleted by 'aipanel credentials' on first read"),
+		setupWizard:     fs.Bool("setup-wizard", false, "skip creating the admin and print a one-time /setup link where the first admin, panel domain and TLS are set in the browser"),
+		yes:             fs.Bool("yes", false, "install with defaults without prompting; generates the admin password unless --admin-password is set"),
 		dryRun:          fs.Bool("dry-run", false, "do not execute system commands"),
+		runtimePins:     &stringList{},
 	}
+	fs.Var(values.runtimePins, "pin", "hold a runtime component on a version listed in the lock, e.g. php-fpm=8.3.15; repeatable")
 	return fs, values
 }
 
@@ -334,6 +1843,7 @@ func (v *installFlagValues) toOptions(defaults installer.Options) (installer.Opt
 	opts.Env = strings.TrimSpace(*v.env)
 	opts.ConfigPath = strings.TrimSpace(*v.configPath)
 	opts.DataDir = strings.TrimSpace(*v.dataDir)
+	opts.ListenAddrs = splitListenAddrs(*v.listenAddrs)
 	opts.PanelBinaryPath = strings.TrimSpace(*v.panelBinary)
 	opts.UnitFilePath = strings.TrimSpace(*v.unitFile)
 	opts.StateFilePath = strings.TrimSpace(*v.stateFile)
@@ -346,16 +1856,62 @@ func (v *installFlagValues) toOptions(defaults installer.Options) (installer.Opt
 	opts.RuntimeLockPath = strings.TrimSpace(*v.runtimeLockPath)
 	opts.RuntimeLockURL = strings.TrimSpace(*v.runtimeLockURL)
 	opts.RuntimeInstallDir = strings.TrimSpace(*v.runtimeInstall)
+	pins, err := installer.ParseRuntimePins(*v.runtimePins)
+	if err != nil {
+		return installer.Options{}, false, err
+	}
+	opts.RuntimePins = pins
+	opts.CABundlePath = strings.TrimSpace(*v.caBundle)
 	opts.OnlyStep = strings.ToLower(strings.TrimSpace(*v.onlyStep))
+	opts.StageRuntime = *v.stageRuntime
 	opts.SkipPGAdmin = !*v.installPGAdmin
 	if strings.EqualFold(opts.OnlyStep, "install_pgadmin") {
 		opts.SkipPGAdmin = false
 	}
+	opts.SkipAdminer = !*v.installAdminer
+	if strings.EqualFold(opts.OnlyStep, "install_adminer") {
+		opts.SkipAdminer = false
+	}
+	opts.AdminerVersion = strings.TrimSpace(*v.adminerVersion)
+	opts.AdminerURL = strings.TrimSpace(*v.adminerURL)
+	opts.AdminerSHA256 = strings.TrimSpace(*v.adminerSHA256)
+	opts.AdminerRoutePath = strings.TrimSpace(*v.adminerRoute)
+	opts.SkipRoundcube = !*v.installRC
+	if strings.EqualFold(opts.OnlyStep, "install_roundcube") {
+		opts.SkipRoundcube = false
+	}
+	opts.RoundcubeVersion = strings.TrimSpace(*v.rcVersion)
+	opts.RoundcubeURL = strings.TrimSpace(*v.rcURL)
+	opts.RoundcubeSHA256 = strings.TrimSpace(*v.rcSHA256)
+	opts.RoundcubeRoutePath = strings.TrimSpace(*v.rcRoute)
+	opts.AdminToolsAllow = nil
+	for _, entry := range strings.Split(*v.adminToolsAllow, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			opts.AdminToolsAllow = append(opts.AdminToolsAllow, entry)
+		}
+	}
+	opts.RandomizeAdminRoutes = *v.randomizeRoutes
+	opts.PHPMyAdminRoutePath = strings.TrimSpace(*v.pmaRoute)
+	opts.PGAdminRoutePath = strings.TrimSpace(*v.pgaRoute)
+	opts.UpgradeAdminTools = *v.upgradeTools
+	opts.PHPMyAdminVersion = strings.TrimSpace(*v.pmaVersion)
+	opts.PHPMyAdminURL = strings.TrimSpace(*v.pmaURL)
+	opts.PHPMyAdminSHA256 = strings.TrimSpace(*v.pmaSHA256)
+	opts.PHPMyAdminSHA256URL = strings.TrimSpace(*v.pmaSHA256URL)
+	opts.PHPMyAdminSignatureURL = strings.TrimSpace(*v.pmaSigURL)
+	opts.PHPMyAdminFingerprint = strings.TrimSpace(*v.pmaFingerprint)
+	opts.PGAdminVersion = strings.TrimSpace(*v.pgaVersion)
+	opts.PGAdminURL = strings.TrimSpace(*v.pgaURL)
+	opts.PGAdminSHA256 = strings.TrimSpace(*v.pgaSHA256)
+	opts.PGAdminSignatureURL = strings.TrimSpace(*v.pgaSigURL)
+	opts.PGAdminFingerprint = strings.TrimSpace(*v.pgaFingerprint)
 	if err := applyReverseProxySettings(&opts, *v.reverseProxy, strings.TrimSpace(*v.panelDomain)); err != nil {
 		return installer.Options{}, false, err
 	}
+	opts.CatchAllMode = strings.ToLower(strings.TrimSpace(*v.catchAllMode))
 	opts.EnableLetsEncrypt = *v.letsEncrypt
 	opts.LetsEncryptEmail = strings.TrimSpace(*v.letsEncryptMail)
+	opts.LetsEncryptStaging = *v.letsEncryptTest
 	if opts.EnableLetsEncrypt && !opts.ReverseProxy {
 		return installer.Options{}, false, fmt.Errorf("letsencrypt requires --reverse-proxy")
 	}
@@ -367,8 +1923,28 @@ func (v *installFlagValues) toOptions(defaults installer.Options) (installer.Opt
 	if err := validateAdminPassword(opts.AdminPassword); err != nil {
 		return installer.Options{}, false, err
 	}
+	opts.SummaryEmail = strings.TrimSpace(*v.summaryEmail)
+	opts.CredentialsFilePath = strings.TrimSpace(*v.credentialsFile)
+	opts.SetupWizard = *v.setupWizard
+	switch strings.TrimSpace(*v.ui) {
+	case uiAuto, uiTUI, uiPlain:
+	default:
+		return installer.Options{}, false, fmt.Errorf("invalid --ui %q (use auto, tui or plain)", *v.ui)
+	}
+	if raw := strings.TrimSpace(*v.createSwap); raw != "" {
+		sizeMB, err := installer.ParseSwapSize(raw)
+		if err != nil {
+			return installer.Options{}, false, err
+		}
+		opts.CreateSwapMB = sizeMB
+	}
 	opts.VerifyUpstreamSources = true
 	opts.SkipHealthcheck = *v.skipHealthcheck
+	if *v.sshPort < 1 || *v.sshPort > 65535 {
+		return installer.Options{}, false, fmt.Errorf("invalid --ssh-port %d (use 1-65535)", *v.sshPort)
+	}
+	opts.SSHPort = *v.sshPort
+	opts.SkipFirewall = !*v.firewall
 	return opts, *v.dryRun, nil
 }
 
@@ -378,7 +1954,9 @@ func printInstallUsage(w io.Writer, fs *flag.FlagSet) {
 	_, _ = fmt.Fprintln(w, "Interactive mode (recommended):")
 	_, _ = fmt.Fprintln(w, "  aipanel install")
 	_, _ = fmt.Fprintln(w)
-	_, _ = fmt.Fprintln(w, "Legacy non-interactive mode:")
+	_, _ = fmt.Fprintln(w, "Non-interactive mode (required when stdin is not a terminal):")
+	_, _ = fmt.Fprintln(w, "  aipanel install --yes")
+	_, _ = fmt.Fprintln(w, "  aipanel install --yes --setup-wizard   (admin, domain and TLS set later at /setup)")
 	_, _ = fmt.Fprintln(w, "  aipanel install [flags]")
 	_, _ = fmt.Fprintln(w)
 	_, _ = fmt.Fprintln(w, "flags:")
@@ -391,6 +1969,7 @@ func printUpdateUsage(w io.Writer, fs *flag.FlagSet) {
 	_, _ = fmt.Fprintln(w)
 	_, _ = fmt.Fprintln(w, "By default refreshes only runtime components that differ from lockfile metadata.")
 	_, _ = fmt.Fprintln(w, "Use --reinstall-all to force legacy full refresh.")
+	_, _ = fmt.Fprintln(w, "Use 'aipanel update self' to upgrade the panel binary itself.")
 	_, _ = fmt.Fprintln(w)
 	_, _ = fmt.Fprintln(w, "flags:")
 	fs.SetOutput(w)
@@ -399,6 +1978,16 @@ func printUpdateUsage(w io.Writer, fs *flag.FlagSet) {
 
 var errInstallCancelled = errors.New("installation cancelled")
 
+const (
+	// swapPromptBelowMB is the RAM size under which the interactive
+	// installer offers to create a swapfile.
+	swapPromptBelowMB = 2048
+	defaultSwapSize   = "2G"
+)
+
+// hostMemoryMB reads total RAM; tests replace it.
+var hostMemoryMB = installer.TotalMemoryMB
+
 type promptValidator func(string) error
 
 func promptInstallOptions(defaults installer.Options, in io.Reader, out io.Writer) (installer.Options, bool, error) {
@@ -423,8 +2012,12 @@ func promptInstallOptions(defaults installer.Options, in io.Reader, out io.Write
 		if opts.AdminEmail, err = promptString(reader, out, "Initial admin email", defaults.AdminEmail, nonEmptyValidator("admin-email")); err != nil {
 			return installer.Options{}, false, err
 		}
-		if opts.AdminPassword, err = promptString(reader, out, "Initial admin password", defaults.AdminPassword, adminPasswordValidator()); err != nil {
-			return installer.Options{}, false, err
+		password, promptErr := promptNewPassword(reader, in, out, "Initial admin password (empty to generate one)")
+		if promptErr != nil {
+			return installer.Options{}, false, promptErr
+		}
+		if password != "" {
+			opts.AdminPassword = password
 		}
 		if opts.RuntimeChannel, err = promptString(reader, out, "Runtime channel", defaults.RuntimeChannel, allowedValidator("runtime-channel", installer.RuntimeChannelStable, installer.RuntimeChannelEdge)); err != nil {
 			return installer.Options{}, false, err
@@ -447,6 +2040,15 @@ func promptInstallOptions(defaults installer.Options, in io.Reader, out io.Write
 		if panelDomain, err = promptString(reader, out, "Panel domain (e.g. panel.example.com)", "", nonEmptyValidator("panel domain")); err != nil {
 			return installer.Options{}, false, err
 		}
+		if !useDefaults {
+			// The panel moves to loopback behind nginx; extra addresses keep
+			// it reachable directly, e.g. over a VPN.
+			extra, promptErr := promptString(reader, out, "Extra panel listen addresses, comma-separated (e.g. 10.8.0.1:8080 or iface:wg0:8080; empty for none)", "", listenAddrsValidator())
+			if promptErr != nil {
+				return installer.Options{}, false, promptErr
+			}
+			opts.ListenAddrs = splitListenAddrs(extra)
+		}
 		if enableLetsEncrypt, err = promptBool(reader, out, "Enable Let's Encrypt certificate for panel domain", true); err != nil {
 			return installer.Options{}, false, err
 		}
@@ -471,6 +2073,20 @@ func promptInstallOptions(defaults installer.Options, in io.Reader, out io.Write
 	}
 	opts.EnableLetsEncrypt = enableLetsEncrypt
 	opts.LetsEncryptEmail = letsEncryptEmail
+	// Source builds get OOM-killed on small VPSes; offer swap up front.
+	if memMB, memErr := hostMemoryMB(opts.MemInfoPath); memErr == nil && memMB < swapPromptBelowMB {
+		createSwap, promptErr := promptBool(reader, out, fmt.Sprintf("Only %d MB RAM detected; create a swapfile before compiling the runtime", memMB), true)
+		if promptErr != nil {
+			return installer.Options{}, false, promptErr
+		}
+		if createSwap {
+			size, promptErr := promptString(reader, out, "Swapfile size", defaultSwapSize, swapSizeValidator())
+			if promptErr != nil {
+				return installer.Options{}, false, promptErr
+			}
+			opts.CreateSwapMB, _ = installer.ParseSwapSize(size)
+		}
+	}
 	if !useDefaults {
 		if dryRun, err = promptBool(reader, out, "Dry run (do not execute commands)", false); err != nil {
 			return installer.Options{}, false, err
@@ -502,10 +2118,34 @@ func applyReverseProxySettings(opts *installer.Options, enabled bool, domain str
 		return fmt.Errorf("panel domain is required when reverse proxy is enabled")
 	}
 	opts.PanelDomain = panelDomain
+	if _, unixAddr := config.UnixSocketPath(opts.Addr); unixAddr {
+		return nil
+	}
 	opts.Addr = net.JoinHostPort("127.0.0.1", parseListenPort(opts.Addr))
 	return nil
 }
 
+// watchConfigReload re-reads the config on SIGHUP and applies settings that
+// can change without a restart (currently logging).
+func watchConfigReload(cfgPath string, logCtl *logger.Controller, log *slog.Logger) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	go func() {
+		for range sigCh {
+			cfg, err := config.Load(cfgPath)
+			if err != nil {
+				log.Error("config reload failed", "config_path", cfgPath, "error", err.Error())
+				continue
+			}
+			if err := logCtl.Apply(logger.FromConfig(cfg)); err != nil {
+				log.Error("logger reload failed", "error", err.Error())
+				continue
+			}
+			log.Info("config reloaded", "config_path", cfgPath)
+		}
+	}()
+}
+
 func parseListenPort(addr string) string {
 	a := strings.TrimSpace(addr)
 	if a == "" {
@@ -530,9 +2170,10 @@ func nonEmptyValidator(field string) promptValidator {
 	}
 }
 
-func adminPasswordValidator() promptValidator {
+func swapSizeValidator() promptValidator {
 	return func(value string) error {
-		return validateAdminPassword(value)
+		_, err := installer.ParseSwapSize(value)
+		return err
 	}
 }
 
@@ -549,6 +2190,27 @@ func validateAdminPassword(password string) error {
 	return nil
 }
 
+func listenAddrsValidator() promptValidator {
+	return func(value string) error {
+		for _, addr := range splitListenAddrs(value) {
+			if err := config.ValidateListenAddr(addr); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func splitListenAddrs(value string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(value, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
 func allowedValidator(field string, allowed ...string) promptValidator {
 	allowedMap := make(map[string]struct{}, len(allowed))
 	for _, value := range allowed {
@@ -600,6 +2262,60 @@ func promptString(
 	}
 }
 
+// promptNewPassword reads a password twice with echo off and returns it,
+// or "" when the first entry is left empty so the caller generates one.
+func promptNewPassword(reader *bufio.Reader, in io.Reader, out io.Writer, label string) (string, error) {
+	restore := hideInput(in)
+	defer restore()
+	for {
+		password, err := promptSecret(reader, out, label)
+		if err != nil {
+			return "", err
+		}
+		if password == "" {
+			return "", nil
+		}
+		if err := validateAdminPassword(password); err != nil {
+			_, _ = fmt.Fprintf(out, "invalid value: %v\n", err)
+			continue
+		}
+		confirmation, err := promptSecret(reader, out, "Confirm password")
+		if err != nil {
+			return "", err
+		}
+		if confirmation != password {
+			_, _ = fmt.Fprintln(out, "invalid value: passwords do not match")
+			continue
+		}
+		return password, nil
+	}
+}
+
+// promptSecret reads one line without a default; the value is never
+// written to out.
+func promptSecret(reader *bufio.Reader, out io.Writer, label string) (string, error) {
+	_, _ = fmt.Fprintf(out, "%s: ", label)
+	line, err := reader.ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// hideInput turns off echo while a secret is typed when in is a terminal
+// and returns the function that turns it back on.
+func hideInput(in io.Reader) func() {
+	f, ok := in.(*os.File)
+	if !ok || !pty.IsTerminal(f) {
+		return func() {}
+	}
+	restore, err := pty.DisableEcho(f)
+	if err != nil {
+		return func() {}
+	}
+	return func() { _ = restore() }
+}
+
 func promptBool(reader *bufio.Reader, out io.Writer, label string, defaultValue bool) (bool, error) {
 	defaultLabel := "y/N"
 	if defaultValue {
@@ -632,8 +2348,46 @@ func promptBool(reader *bufio.Reader, out io.Writer, label string, defaultValue
 	}
 }
 
-func runInstaller(opts installer.Options, dryRun bool) {
-	runner := systemd.ExecRunner{DryRun: dryRun}
+// Progress display modes for --ui.
+const (
+	uiAuto  = "auto"
+	uiTUI   = "tui"
+	uiPlain = "plain"
+)
+
+// useTerminalUI reports whether the installer should draw the terminal UI:
+// always with --ui=tui, and with --ui=auto when stdout is a terminal that
+// can handle cursor movement.
+func useTerminalUI(mode string, out *os.File) bool {
+	switch strings.TrimSpace(mode) {
+	case uiTUI:
+		return true
+	case uiPlain:
+		return false
+	}
+	term := strings.TrimSpace(os.Getenv("TERM"))
+	return term != "" && term != "dumb" && pty.IsTerminal(out)
+}
+
+func terminalSize(f *os.File) func() (int, int) {
+	return func() (int, int) {
+		rows, cols, err := pty.Getsize(f)
+		if err != nil || rows == 0 || cols == 0 {
+			return 24, 80
+		}
+		return rows, cols
+	}
+}
+
+// runInstaller runs the installer and exits on failure. generatedPassword
+// is the admin password generated for this run, if any; it is shown once
+// on the terminal, or only in the credentials file when one is requested.
+func runInstaller(opts installer.Options, dryRun bool, ui string, generatedPassword string) {
+	runner, err := withFaultInjection(systemd.ExecRunner{DryRun: dryRun})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
 	ins := installer.New(opts, runner)
 	fmt.Printf(
 		"installer start: mode=%s channel=%s lock=%s lock_url=%s runtime_dir=%s only_step=%s force_all=%t verify_signatures=%t dry_run=%t\n",
@@ -647,7 +2401,16 @@ func runInstaller(opts installer.Options, dryRun bool) {
 		opts.VerifyUpstreamSources,
 		dryRun,
 	)
+	var display *tui.Display
+	if useTerminalUI(ui, os.Stdout) {
+		display = tui.New(os.Stdout, "aiPanel installer ("+opts.InstallMode+", "+opts.RuntimeChannel+")", terminalSize(os.Stdout))
+		ins.SetProgress(display)
+		display.Start()
+	}
 	report, err := ins.Run(context.Background())
+	if display != nil {
+		display.Stop()
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "install failed: %v\n", err)
 		if report != nil {
@@ -665,4 +2428,31 @@ func runInstaller(opts installer.Options, dryRun bool) {
 	}
 	fmt.Println("installation finished successfully")
 	fmt.Printf("report: %s\n", opts.ReportFilePath)
+	if dryRun {
+		return
+	}
+	// The install succeeded; delivery problems are only warnings.
+	if err := ins.MailSummary(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+	if opts.SetupWizard {
+		// The token only reaches the terminal; the install log and report
+		// have it redacted.
+		fmt.Printf("finish setup at: %ssetup#token=%s (one-time link; creates the first admin)\n", ins.Summary().PanelURL, opts.SetupToken)
+		if !opts.SkipPGAdmin && generatedPassword != "" {
+			fmt.Printf("pgAdmin login: %s / %s (generated)\n", opts.AdminEmail, generatedPassword)
+		}
+		return
+	}
+	if path := strings.TrimSpace(opts.CredentialsFilePath); path != "" {
+		err := ins.WriteCredentials(generatedPassword)
+		if err == nil {
+			fmt.Printf("credentials: %s (read once with: aipanel credentials --file %s)\n", path, path)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+	if generatedPassword != "" {
+		fmt.Printf("admin login: %s / %s (generated; change it after the first login)\n", opts.AdminEmail, generatedPassword)
+	}
 }
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -199,10 +200,13 @@ func TestPromptInstallOptions_CustomMode(t *testing.T) {
 		":18080",
 		"ops@aipanel.dev",
 		"VeryStrongPass123!",
+		"VeryStrongPass123!",
 		"edge",
 		"y",
 		"y",
 		"panel.example.com",
+		"10.8.0.1:8080, iface:wg0",
+		"10.8.0.1:8080, iface:wg0:8080",
 		"y",
 		"tls-admin@aipanel.dev",
 		"n",
@@ -235,6 +239,12 @@ func TestPromptInstallOptions_CustomMode(t *testing.T) {
 	if opts.PanelDomain != "panel.example.com" {
 		t.Fatalf("panel domain mismatch: got %q", opts.PanelDomain)
 	}
+	if len(opts.ListenAddrs) != 2 || opts.ListenAddrs[0] != "10.8.0.1:8080" || opts.ListenAddrs[1] != "iface:wg0:8080" {
+		t.Fatalf("listen addrs mismatch: got %#v", opts.ListenAddrs)
+	}
+	if !strings.Contains(out.String(), "expected iface:<name>:<port>") {
+		t.Fatalf("expected listen address validation message, got: %q", out.String())
+	}
 	if !opts.EnableLetsEncrypt {
 		t.Fatal("expected letsencrypt enabled")
 	}
@@ -251,6 +261,7 @@ func TestPromptInstallOptions_CustomModeRePromptsShortPassword(t *testing.T) {
 		"ops@aipanel.dev",
 		"short",
 		"VeryStrongPass123!",
+		"VeryStrongPass123!",
 		"stable",
 		"n",
 		"n",
@@ -274,6 +285,53 @@ func TestPromptInstallOptions_CustomModeRePromptsShortPassword(t *testing.T) {
 	}
 }
 
+func TestPromptInstallOptions_CustomModeRequiresPasswordConfirmation(t *testing.T) {
+	defaults := installer.DefaultOptions()
+	input := strings.Join([]string{
+		"n",
+		"",
+		"",
+		"VeryStrongPass123!",
+		"VeryStrongPass124!",
+		"OtherStrongPass456!",
+		"OtherStrongPass456!",
+		"stable",
+		"n",
+		"n",
+		"n",
+		"y",
+	}, "\n") + "\n"
+	out := &bytes.Buffer{}
+
+	opts, _, err := promptInstallOptions(defaults, strings.NewReader(input), out)
+	if err != nil {
+		t.Fatalf("promptInstallOptions error: %v", err)
+	}
+	if opts.AdminPassword != "OtherStrongPass456!" {
+		t.Fatalf("admin password mismatch: got %q", opts.AdminPassword)
+	}
+	if !strings.Contains(out.String(), "passwords do not match") {
+		t.Fatalf("expected mismatch message in output, got: %q", out.String())
+	}
+	if strings.Contains(out.String(), "StrongPass") || strings.Contains(out.String(), defaults.AdminPassword) {
+		t.Fatalf("expected passwords never to be echoed, got: %q", out.String())
+	}
+}
+
+func TestPromptInstallOptions_EmptyPasswordIsGenerated(t *testing.T) {
+	defaults := installer.DefaultOptions()
+	input := strings.Join([]string{"n", "", "", "", "stable", "n", "n", "n", "y"}, "\n") + "\n"
+
+	opts, _, err := promptInstallOptions(defaults, strings.NewReader(input), &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("promptInstallOptions error: %v", err)
+	}
+	generated, err := replaceDefaultAdminPassword(&opts, defaults)
+	if err != nil || generated == "" {
+		t.Fatalf("expected an empty entry to leave the password to be generated, got %q (%v)", generated, err)
+	}
+}
+
 func TestPromptInstallOptions_RePromptsInvalidLetsEncryptEmail(t *testing.T) {
 	defaults := installer.DefaultOptions()
 	input := strings.Join([]string{
@@ -318,6 +376,29 @@ func TestInstallFlagValuesToOptions_RejectsShortAdminPassword(t *testing.T) {
 	}
 }
 
+func TestInstallFlagValuesToOptions_RuntimePins(t *testing.T) {
+	defaults := installer.DefaultOptions()
+	fs, values := newInstallFlagSet(defaults)
+	if err := fs.Parse([]string{"--pin", "php-fpm=8.3.15", "--pin", "nginx=1.29.5"}); err != nil {
+		t.Fatalf("parse flags: %v", err)
+	}
+	opts, _, err := values.toOptions(defaults)
+	if err != nil {
+		t.Fatalf("toOptions error: %v", err)
+	}
+	if len(opts.RuntimePins) != 2 || opts.RuntimePins["php-fpm"] != "8.3.15" {
+		t.Fatalf("unexpected pins: %v", opts.RuntimePins)
+	}
+
+	fs, values = newInstallFlagSet(defaults)
+	if err := fs.Parse([]string{"--pin", "php-fpm"}); err != nil {
+		t.Fatalf("parse flags: %v", err)
+	}
+	if _, _, err := values.toOptions(defaults); err == nil || !strings.Contains(err.Error(), "component=version") {
+		t.Fatalf("expected invalid pin error, got %v", err)
+	}
+}
+
 func TestInstallFlagValuesToOptions_OnlyStep(t *testing.T) {
 	defaults := installer.DefaultOptions()
 	fs, values := newInstallFlagSet(defaults)
@@ -454,3 +535,129 @@ func TestApplyReverseProxySettings_RequiresDomain(t *testing.T) {
 		t.Fatal("expected error for missing domain")
 	}
 }
+
+func TestInstallFlagValuesToOptions_CreateSwap(t *testing.T) {
+	defaults := installer.DefaultOptions()
+	fs, values := newInstallFlagSet(defaults)
+	if err := fs.Parse([]string{"--create-swap", "2G"}); err != nil {
+		t.Fatalf("parse flags: %v", err)
+	}
+	opts, _, err := values.toOptions(defaults)
+	if err != nil {
+		t.Fatalf("toOptions error: %v", err)
+	}
+	if opts.CreateSwapMB != 2048 {
+		t.Fatalf("expected 2048 MB swap, got %d", opts.CreateSwapMB)
+	}
+
+	fs, values = newInstallFlagSet(defaults)
+	if err := fs.Parse([]string{"--create-swap", "lots"}); err != nil {
+		t.Fatalf("parse flags: %v", err)
+	}
+	if _, _, err := values.toOptions(defaults); err == nil || !strings.Contains(err.Error(), "invalid swap size") {
+		t.Fatalf("expected invalid swap size error, got %v", err)
+	}
+}
+
+func TestPromptInstallOptions_OffersSwapOnSmallHosts(t *testing.T) {
+	previous := hostMemoryMB
+	hostMemoryMB = func(string) (int, error) { return 1024, nil }
+	t.Cleanup(func() { hostMemoryMB = previous })
+
+	defaults := installer.DefaultOptions()
+	input := strings.Join([]string{"", "", "y", "512K", "1536M", ""}, "\n") + "\n"
+	out := &bytes.Buffer{}
+	opts, _, err := promptInstallOptions(defaults, strings.NewReader(input), out)
+	if err != nil {
+		t.Fatalf("promptInstallOptions error: %v", err)
+	}
+	if !strings.Contains(out.String(), "Only 1024 MB RAM detected") {
+		t.Fatalf("expected swap prompt, got: %q", out.String())
+	}
+	if opts.CreateSwapMB != 1536 {
+		t.Fatalf("expected 1536 MB swap, got %d", opts.CreateSwapMB)
+	}
+}
+
+func TestInteractiveInputError_RejectsPipedStdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = r.Close()
+		_ = w.Close()
+	})
+	err = interactiveInputError(r)
+	if err == nil || !strings.Contains(err.Error(), "--yes") {
+		t.Fatalf("expected non-terminal stdin to be rejected with a --yes hint, got %v", err)
+	}
+}
+
+func TestReplaceDefaultAdminPassword(t *testing.T) {
+	defaults := installer.DefaultOptions()
+	opts := defaults
+	generated, err := replaceDefaultAdminPassword(&opts, defaults)
+	if err != nil {
+		t.Fatalf("replace password: %v", err)
+	}
+	if generated == "" || opts.AdminPassword != generated || generated == defaults.AdminPassword {
+		t.Fatalf("expected a generated password, got %q (opts %q)", generated, opts.AdminPassword)
+	}
+	if err := validateAdminPassword(generated); err != nil {
+		t.Fatalf("generated password rejected: %v", err)
+	}
+
+	opts.AdminPassword = "operator-chosen-secret"
+	if generated, _ := replaceDefaultAdminPassword(&opts, defaults); generated != "" || opts.AdminPassword != "operator-chosen-secret" {
+		t.Fatalf("expected explicit password to be kept, got %q", opts.AdminPassword)
+	}
+}
+
+func TestPrepareFlagInstall_NeverKeepsDefaultPassword(t *testing.T) {
+	defaults := installer.DefaultOptions()
+	fs, values := newInstallFlagSet(defaults)
+	// Flags without --yes or the setup wizard used to install with the
+	// built-in password.
+	if err := fs.Parse([]string{"--admin-email", "ops@example.com", "--panel-domain", "panel.example.com"}); err != nil {
+		t.Fatalf("parse flags: %v", err)
+	}
+	opts, _, err := values.toOptions(defaults)
+	if err != nil {
+		t.Fatalf("toOptions: %v", err)
+	}
+	generated, err := prepareFlagInstall(&opts, defaults)
+	if err != nil {
+		t.Fatalf("prepare install: %v", err)
+	}
+	if generated == "" || opts.AdminPassword != generated || opts.AdminPassword == defaults.AdminPassword {
+		t.Fatalf("expected a generated admin password, got %q", opts.AdminPassword)
+	}
+	if opts.SetupToken != "" {
+		t.Fatalf("expected no setup token without the wizard, got %q", opts.SetupToken)
+	}
+
+	fs, values = newInstallFlagSet(defaults)
+	if err := fs.Parse([]string{"--admin-password", "operator-chosen-secret"}); err != nil {
+		t.Fatalf("parse flags: %v", err)
+	}
+	opts, _, _ = values.toOptions(defaults)
+	if generated, err := prepareFlagInstall(&opts, defaults); err != nil || generated != "" || opts.AdminPassword != "operator-chosen-secret" {
+		t.Fatalf("expected --admin-password kept, got %q (generated %q, %v)", opts.AdminPassword, generated, err)
+	}
+}
+
+func TestInstallFlags_SummaryDelivery(t *testing.T) {
+	defaults := installer.DefaultOptions()
+	fs, values := newInstallFlagSet(defaults)
+	if err := fs.Parse([]string{"--summary-email", "ops@example.com", "--credentials-file", "/root/aipanel-credentials.txt"}); err != nil {
+		t.Fatalf("parse flags: %v", err)
+	}
+	opts, _, err := values.toOptions(defaults)
+	if err != nil {
+		t.Fatalf("toOptions: %v", err)
+	}
+	if opts.SummaryEmail != "ops@example.com" || opts.CredentialsFilePath != "/root/aipanel-credentials.txt" {
+		t.Fatalf("unexpected summary options: %q %q", opts.SummaryEmail, opts.CredentialsFilePath)
+	}
+}
@@ -0,0 +1,191 @@
+// Package fsck validates panel data integrity (sqlite files, dangling rows, backup checksums).
+package fsck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/modules/backup"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+// Issue kinds reported by Run.
+const (
+	KindIntegrity       = "sqlite_integrity"
+	KindDanglingDB      = "dangling_site_database"
+	KindDanglingSession = "dangling_session"
+	KindExpiredSession  = "expired_session"
+	KindBackupChecksum  = "backup_checksum"
+	KindBackupUnchecked = "backup_missing_checksum"
+)
+
+// Issue is a single inconsistency found by the checker.
+type Issue struct {
+	Kind     string `json:"kind"`
+	Target   string `json:"target"`
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"`
+}
+
+// Report summarizes a checker run.
+type Report struct {
+	Issues []Issue `json:"issues"`
+}
+
+// Unresolved returns the number of issues that were not repaired.
+func (r Report) Unresolved() int {
+	n := 0
+	for _, issue := range r.Issues {
+		if !issue.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// Options configures a checker run.
+type Options struct {
+	// Repair deletes dangling rows and expired sessions. Integrity and
+	// checksum failures are reported only.
+	Repair bool
+	// Now overrides the clock used to detect expired sessions.
+	Now func() time.Time
+}
+
+// Run checks store consistency and verifies backup checksums under the data dir.
+func Run(ctx context.Context, store *sqlite.Store, opts Options) (Report, error) {
+	if store == nil {
+		return Report{}, fmt.Errorf("sqlite store is required")
+	}
+	now := time.Now
+	if opts.Now != nil {
+		now = opts.Now
+	}
+	var report Report
+
+	problems, err := store.IntegrityCheck(ctx)
+	if err != nil {
+		return Report{}, err
+	}
+	dbs := make([]string, 0, len(problems))
+	for db := range problems {
+		dbs = append(dbs, db)
+	}
+	sort.Strings(dbs)
+	for _, db := range dbs {
+		for _, msg := range problems[db] {
+			report.Issues = append(report.Issues, Issue{Kind: KindIntegrity, Target: db, Detail: msg})
+		}
+	}
+
+	checks := []struct {
+		kind   string
+		query  string
+		detail string
+		repair string
+	}{
+		{
+			kind:   KindDanglingDB,
+			query:  `SELECT id FROM site_databases WHERE site_id NOT IN (SELECT id FROM sites) ORDER BY id;`,
+			detail: "site_databases row references missing site",
+			repair: `DELETE FROM site_databases WHERE site_id NOT IN (SELECT id FROM sites);`,
+		},
+		{
+			kind:   KindDanglingSession,
+			query:  `SELECT token AS id FROM sessions WHERE user_id NOT IN (SELECT id FROM users) ORDER BY created_at;`,
+			detail: "session references missing user",
+			repair: `DELETE FROM sessions WHERE user_id NOT IN (SELECT id FROM users);`,
+		},
+		{
+			kind:   KindExpiredSession,
+			query:  fmt.Sprintf(`SELECT token AS id FROM sessions WHERE expires_at < %d ORDER BY created_at;`, now().Unix()),
+			detail: "session expired",
+			repair: fmt.Sprintf(`DELETE FROM sessions WHERE expires_at < %d;`, now().Unix()),
+		},
+	}
+	for _, check := range checks {
+		rows, err := store.QueryPanelJSON(ctx, check.query)
+		if err != nil {
+			return Report{}, fmt.Errorf("check %s: %w", check.kind, err)
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		repaired := false
+		if opts.Repair {
+			if err := store.ExecPanel(ctx, check.repair); err != nil {
+				return Report{}, fmt.Errorf("repair %s: %w", check.kind, err)
+			}
+			repaired = true
+		}
+		for _, row := range rows {
+			report.Issues = append(report.Issues, Issue{
+				Kind:     check.kind,
+				Target:   maskTarget(check.kind, fmt.Sprint(row["id"])),
+				Detail:   check.detail,
+				Repaired: repaired,
+			})
+		}
+	}
+
+	backupIssues, err := checkBackups(backup.Dir(store.DataDir), backup.FilesDir(store.DataDir))
+	if err != nil {
+		return Report{}, err
+	}
+	report.Issues = append(report.Issues, backupIssues...)
+	return report, nil
+}
+
+// checkBackups verifies the checksum sidecar of every archive in dir.
+// skip is the file backup repository, which verifies itself.
+func checkBackups(dir, skip string) ([]Issue, error) {
+	var issues []Issue
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == dir {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() && path == skip {
+			return fs.SkipDir
+		}
+		if d.IsDir() || strings.HasSuffix(path, backup.ChecksumSuffix) {
+			return nil
+		}
+		rel, relErr := filepath.Rel(dir, path)
+		if relErr != nil {
+			rel = path
+		}
+		if _, statErr := os.Stat(path + backup.ChecksumSuffix); statErr != nil {
+			issues = append(issues, Issue{Kind: KindBackupUnchecked, Target: rel, Detail: "no checksum file"})
+			return nil
+		}
+		if verifyErr := backup.VerifyChecksum(path); verifyErr != nil {
+			issues = append(issues, Issue{Kind: KindBackupChecksum, Target: rel, Detail: verifyErr.Error()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan backups: %w", err)
+	}
+	return issues, nil
+}
+
+// maskTarget keeps session tokens out of reports.
+func maskTarget(kind, id string) string {
+	if kind != KindDanglingSession && kind != KindExpiredSession {
+		return "site_databases#" + id
+	}
+	if len(id) > 8 {
+		id = id[:8] + "…"
+	}
+	return "session " + id
+}
@@ -0,0 +1,113 @@
+package fsck
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/modules/backup"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+func TestRun_ReportsAndRepairsDanglingRows(t *testing.T) {
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	seed := `
+INSERT INTO users(id, email, password_hash, role, created_at) VALUES(1, 'admin@example.com', 'x', 'admin', 1);
+INSERT INTO sessions(token, user_id, expires_at, created_at) VALUES('valid-session-token', 1, 4000000000, 1);
+INSERT INTO sessions(token, user_id, expires_at, created_at) VALUES('orphan-session-token', 99, 4000000000, 1);
+INSERT INTO sessions(token, user_id, expires_at, created_at) VALUES('expired-session-token', 1, 10, 1);
+INSERT INTO sites(id, domain, root_dir, php_version, system_user, status, created_at, updated_at)
+VALUES(1, 'ok.example.com', '/var/www/ok', '8.3', 'site_ok', 'active', 1, 1);
+INSERT INTO site_databases(site_id, db_name, db_user, db_engine, created_at) VALUES(1, 'ok_db', 'ok_user', 'mariadb', 1);
+INSERT INTO site_databases(site_id, db_name, db_user, db_engine, created_at) VALUES(42, 'lost_db', 'lost_user', 'mariadb', 1);
+`
+	if err := store.ExecPanel(ctx, seed); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	now := func() time.Time { return time.Unix(1000, 0) }
+
+	report, err := Run(ctx, store, Options{Now: now})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	kinds := map[string]int{}
+	for _, issue := range report.Issues {
+		kinds[issue.Kind]++
+	}
+	if kinds[KindDanglingDB] != 1 || kinds[KindDanglingSession] != 1 || kinds[KindExpiredSession] != 1 {
+		t.Fatalf("unexpected issues: %+v", report.Issues)
+	}
+	if report.Unresolved() != 3 {
+		t.Fatalf("expected 3 unresolved issues, got %d", report.Unresolved())
+	}
+
+	report, err = Run(ctx, store, Options{Repair: true, Now: now})
+	if err != nil {
+		t.Fatalf("repair run: %v", err)
+	}
+	if report.Unresolved() != 0 {
+		t.Fatalf("expected all issues repaired, got %+v", report.Issues)
+	}
+
+	report, err = Run(ctx, store, Options{Now: now})
+	if err != nil {
+		t.Fatalf("final run: %v", err)
+	}
+	if len(report.Issues) != 0 {
+		t.Fatalf("expected clean report after repair, got %+v", report.Issues)
+	}
+}
+
+func TestRun_VerifiesBackupChecksums(t *testing.T) {
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	dir := backup.Dir(store.DataDir)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		t.Fatalf("mkdir backups: %v", err)
+	}
+	good := filepath.Join(dir, "good.tar.gz")
+	bad := filepath.Join(dir, "bad.tar.gz")
+	unchecked := filepath.Join(dir, "unchecked.tar.gz")
+	for _, path := range []string{good, bad, unchecked} {
+		if err := os.WriteFile(path, []byte(filepath.Base(path)), 0o600); err != nil {
+			t.Fatalf("write backup: %v", err)
+		}
+	}
+	for _, path := range []string{good, bad} {
+		if err := backup.WriteChecksum(path); err != nil {
+			t.Fatalf("write checksum: %v", err)
+		}
+	}
+	if err := os.WriteFile(bad, []byte("tampered"), 0o600); err != nil {
+		t.Fatalf("tamper backup: %v", err)
+	}
+	// Chunks of the file backup repository have no sidecars.
+	chunk := filepath.Join(backup.FilesDir(store.DataDir), "chunks", "ab", "abcdef")
+	if err := os.MkdirAll(filepath.Dir(chunk), 0o700); err != nil {
+		t.Fatalf("mkdir chunks: %v", err)
+	}
+	if err := os.WriteFile(chunk, []byte("chunk"), 0o600); err != nil {
+		t.Fatalf("write chunk: %v", err)
+	}
+
+	report, err := Run(ctx, store, Options{})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	got := map[string]string{}
+	for _, issue := range report.Issues {
+		got[issue.Target] = issue.Kind
+	}
+	if len(got) != 2 || got["bad.tar.gz"] != KindBackupChecksum || got["unchecked.tar.gz"] != KindBackupUnchecked {
+		t.Fatalf("unexpected backup issues: %+v", report.Issues)
+	}
+}
@@ -0,0 +1,172 @@
+package installer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Admin tool names recorded in version markers.
+const (
+	AdminToolPHPMyAdmin = "phpmyadmin"
+	AdminToolPGAdmin    = "pgadmin"
+	AdminToolAdminer    = "adminer"
+	AdminToolRoundcube  = "roundcube"
+)
+
+// AdminToolVersionFile is written into an admin tool install directory and
+// records which release is installed there.
+const AdminToolVersionFile = ".aipanel-version"
+
+// AdminRoutesFile is stored in the panel data dir and records the route
+// each admin tool is served under, keyed by tool name.
+const AdminRoutesFile = "admin-routes.json"
+
+// AdminToolVersion is the content of AdminToolVersionFile.
+type AdminToolVersion struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	SHA256      string `json:"sha256"`
+	InstalledAt int64  `json:"installed_at"`
+}
+
+// ReadAdminToolVersion reads the version marker of an admin tool install.
+func ReadAdminToolVersion(installDir string) (AdminToolVersion, error) {
+	// Install directories come from installer options.
+	//nolint:gosec // G304
+	raw, err := os.ReadFile(filepath.Join(installDir, AdminToolVersionFile))
+	if err != nil {
+		return AdminToolVersion{}, err
+	}
+	var v AdminToolVersion
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return AdminToolVersion{}, fmt.Errorf("parse %s: %w", AdminToolVersionFile, err)
+	}
+	return v, nil
+}
+
+func writeAdminToolVersion(installDir string, v AdminToolVersion) error {
+	if v.InstalledAt == 0 {
+		v.InstalledAt = time.Now().Unix()
+	}
+	raw, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeTextFile(filepath.Join(installDir, AdminToolVersionFile), string(raw)+"\n", 0o644); err != nil {
+		return fmt.Errorf("write %s version marker: %w", v.Name, err)
+	}
+	return nil
+}
+
+// replacePHPMyAdmin swaps installDir for a fresh copy of sourceDir. The
+// release is staged next to installDir and renamed into place so a failed
+// copy leaves the old install serving; config.inc.php is carried over.
+func replacePHPMyAdmin(sourceDir, installDir string) error {
+	staging := installDir + ".aipanel-new"
+	previous := installDir + ".aipanel-old"
+	_ = os.RemoveAll(staging)
+	_ = os.RemoveAll(previous)
+	if err := copyDirectory(sourceDir, staging); err != nil {
+		_ = os.RemoveAll(staging)
+		return fmt.Errorf("stage phpMyAdmin files: %w", err)
+	}
+	localConfig := filepath.Join(installDir, "config.inc.php")
+	// Path is derived from the configured install directory.
+	//nolint:gosec // G304
+	if raw, err := os.ReadFile(localConfig); err == nil {
+		if err := os.WriteFile(filepath.Join(staging, "config.inc.php"), raw, 0o640); err != nil {
+			_ = os.RemoveAll(staging)
+			return fmt.Errorf("carry over phpMyAdmin config: %w", err)
+		}
+	}
+	if err := os.Rename(installDir, previous); err != nil {
+		_ = os.RemoveAll(staging)
+		return fmt.Errorf("move previous phpMyAdmin aside: %w", err)
+	}
+	if err := os.Rename(staging, installDir); err != nil {
+		_ = os.Rename(previous, installDir)
+		_ = os.RemoveAll(staging)
+		return fmt.Errorf("activate new phpMyAdmin: %w", err)
+	}
+	if err := os.RemoveAll(previous); err != nil {
+		return fmt.Errorf("remove previous phpMyAdmin: %w", err)
+	}
+	return nil
+}
+
+// ReadAdminRoutes reads the admin tool routes recorded in dataDir.
+func ReadAdminRoutes(dataDir string) (map[string]string, error) {
+	// Data dir comes from installer options or panel config.
+	//nolint:gosec // G304
+	raw, err := os.ReadFile(filepath.Join(dataDir, AdminRoutesFile))
+	if err != nil {
+		return nil, err
+	}
+	routes := map[string]string{}
+	if err := json.Unmarshal(raw, &routes); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", AdminRoutesFile, err)
+	}
+	return routes, nil
+}
+
+// adminRoutes resolves the routes admin tools are served under. With
+// RandomizeAdminRoutes, a tool left on its default route keeps the route
+// recorded by an earlier run, or gets a random one on a fresh install;
+// panels installed before routes were recorded keep the defaults so
+// existing bookmarks survive. An explicitly configured route always wins.
+func (i *Installer) adminRoutes() (map[string]string, error) {
+	dataDir := pathInRootFS(i.opts.RootFSPath, i.opts.DataDir)
+	stored, err := ReadAdminRoutes(dataDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	legacy := err != nil && fileExists(filepath.Join(i.opts.NginxSitesAvailableDir, "aipanel.conf"))
+	configured := []struct{ tool, route, fallback string }{
+		{AdminToolPHPMyAdmin, i.opts.PHPMyAdminRoutePath, defaultPHPMyAdminRoutePath},
+		{AdminToolPGAdmin, i.opts.PGAdminRoutePath, defaultPGAdminRoutePath},
+		{AdminToolAdminer, i.opts.AdminerRoutePath, defaultAdminerRoutePath},
+	}
+	routes := make(map[string]string, len(configured))
+	changed := false
+	for _, c := range configured {
+		route := normalizeWebSubpath(c.route, c.fallback)
+		if i.opts.RandomizeAdminRoutes && route == c.fallback {
+			if stored[c.tool] != "" {
+				route = stored[c.tool]
+			} else if !legacy {
+				if route, err = randomAdminRoute(c.fallback); err != nil {
+					return nil, err
+				}
+			}
+		}
+		routes[c.tool] = route
+		changed = changed || stored[c.tool] != route
+	}
+	if !changed {
+		return routes, nil
+	}
+	raw, err := json.MarshalIndent(routes, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dataDir, 0o750); err != nil {
+		return nil, fmt.Errorf("create data dir: %w", err)
+	}
+	if err := writeTextFile(filepath.Join(dataDir, AdminRoutesFile), string(raw)+"\n", 0o640); err != nil {
+		return nil, fmt.Errorf("write admin routes: %w", err)
+	}
+	return routes, nil
+}
+
+func randomAdminRoute(prefix string) (string, error) {
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate admin route: %w", err)
+	}
+	return prefix + "-" + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,62 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/installer/steps"
+	"github.com/robsonek/aiPanel/internal/modules/firewall"
+	"github.com/robsonek/aiPanel/internal/platform/systemd"
+)
+
+// configureFirewall writes the baseline nftables ruleset (SSH, HTTP,
+// HTTPS and the panel port open, everything else dropped) and enables
+// aipanel-firewall.service to load it at boot. An existing ruleset is the
+// panel's to manage and is kept.
+func (i *Installer) configureFirewall(ctx context.Context) error {
+	if i.opts.SkipFirewall && !strings.EqualFold(i.opts.OnlyStep, steps.ConfigureFirewall) {
+		i.logf("[configure_firewall] skipped by configuration")
+		return nil
+	}
+
+	rulesPath := pathInRootFS(i.opts.RootFSPath, firewall.DefaultRulesPath)
+	switch _, err := os.Stat(rulesPath); {
+	case err == nil:
+		i.logf("[configure_firewall] existing ruleset at %s, keeping as-is", rulesPath)
+	case os.IsNotExist(err):
+		base := firewall.Baseline{SSHPort: i.opts.SSHPort, PanelPort: firewall.PanelPort(i.opts.Addr)}
+		if err := os.MkdirAll(filepath.Dir(rulesPath), 0o750); err != nil {
+			return fmt.Errorf("create firewall dir: %w", err)
+		}
+		if err := writeTextFile(rulesPath, firewall.Render(base, nil), 0o600); err != nil {
+			return fmt.Errorf("write firewall ruleset: %w", err)
+		}
+		i.logf("[configure_firewall] baseline allows tcp ports %v", base.Ports())
+	default:
+		return fmt.Errorf("inspect firewall ruleset: %w", err)
+	}
+	if _, err := i.runner.Run(ctx, firewall.DefaultNftBin, "-c", "-f", rulesPath); err != nil {
+		return fmt.Errorf("check firewall ruleset: %w", err)
+	}
+
+	unitPath := filepath.Join(filepath.Dir(i.opts.UnitFilePath), firewall.UnitName)
+	unit := firewall.RenderUnit(firewall.DefaultNftBin, firewall.DefaultRulesPath)
+	if err := writeTextFile(unitPath, unit, 0o600); err != nil {
+		return fmt.Errorf("write firewall unit: %w", err)
+	}
+	if err := systemd.DaemonReload(ctx, i.runner); err != nil {
+		return fmt.Errorf("systemd daemon-reload: %w", err)
+	}
+	if err := systemd.EnableNow(ctx, i.runner, firewall.UnitName); err != nil {
+		return fmt.Errorf("enable firewall service: %w", err)
+	}
+	// enable --now leaves an already active oneshot alone; the restart
+	// loads a ruleset written since.
+	if err := systemd.Restart(ctx, i.runner, firewall.UnitName); err != nil {
+		return fmt.Errorf("load firewall ruleset: %w", err)
+	}
+	return nil
+}
@@ -32,18 +32,25 @@ import (
 
 	"github.com/robsonek/aiPanel/internal/installer/steps"
 	"github.com/robsonek/aiPanel/internal/modules/iam"
+	"github.com/robsonek/aiPanel/internal/platform/cabundle"
 	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/faultinject"
 	"github.com/robsonek/aiPanel/internal/platform/logger"
+	"github.com/robsonek/aiPanel/internal/platform/selfupdate"
 	"github.com/robsonek/aiPanel/internal/platform/sqlite"
 	"github.com/robsonek/aiPanel/internal/platform/systemd"
+	"github.com/robsonek/aiPanel/internal/platform/templates"
 )
 
 const MinAdminPasswordLength = 10
 
 const (
+	defaultPHPMyAdminVersion    = "5.2.3"
 	defaultPHPMyAdminURL        = "https://files.phpmyadmin.net/phpMyAdmin/5.2.3/phpMyAdmin-5.2.3-all-languages.tar.gz"
 	defaultPHPMyAdminSHA256URL  = "https://files.phpmyadmin.net/phpMyAdmin/5.2.3/phpMyAdmin-5.2.3-all-languages.tar.gz.sha256"
 	defaultPHPMyAdminInstallDir = "/usr/share/phpmyadmin"
+	defaultPHPMyAdminRoutePath  = "/phpmyadmin"
+	defaultPGAdminVersion       = "9.12"
 	defaultPGAdminURL           = "https://ftp.postgresql.org/pub/pgadmin/pgadmin4/v9.12/pip/pgadmin4-9.12-py3-none-any.whl"
 	defaultPGAdminSHA256        = "99936db81877edeaa3324fb678d87314ffd598872ea13d24c48d1dbf34eb2389"
 	defaultPGAdminSignatureURL  = "https://ftp.postgresql.org/pub/pgadmin/pgadmin4/v9.12/pip/pgadmin4-9.12-py3-none-any.whl.asc"
@@ -54,12 +61,23 @@ const (
 	defaultPGAdminListenAddr    = "127.0.0.1:5050"
 	defaultPGAdminRoutePath     = "/pgadmin"
 	defaultPGAdminUnitName      = "aipanel-pgadmin.service"
+	defaultAdminerVersion       = "5.4.1"
+	defaultAdminerURL           = "https://github.com/vrana/adminer/releases/download/v5.4.1/adminer-5.4.1.php"
+	defaultAdminerInstallDir    = "/usr/share/adminer"
+	defaultAdminerRoutePath     = "/adminer"
+	defaultRoundcubeVersion     = "1.6.11"
+	defaultRoundcubeURL         = "https://github.com/roundcube/roundcubemail/releases/download/1.6.11/roundcubemail-1.6.11-complete.tar.gz"
+	defaultRoundcubeInstallDir  = "/usr/share/roundcube"
+	defaultRoundcubeDataDir     = "/var/lib/roundcube"
+	defaultRoundcubeRoutePath   = "/webmail"
 	defaultLetsEncryptWebroot   = "/var/www/letsencrypt"
 	defaultTemplateDir          = "/etc/aipanel/templates"
 	defaultSiteVhostTemplate    = "/etc/aipanel/templates/nginx_vhost.conf.tmpl"
 	defaultPHPFPMPoolTemplate   = "/etc/aipanel/templates/phpfpm_pool.conf.tmpl"
 	defaultPanelVhostTemplate   = "/etc/aipanel/templates/nginx_panel_vhost.conf.tmpl"
 	defaultCatchallTemplate     = "/etc/aipanel/templates/nginx_catchall.conf.tmpl"
+	defaultCatchallLanding      = "/etc/aipanel/templates/catchall_landing.html.tmpl"
+	defaultCatchallRoot         = "/var/www/aipanel-default"
 	defaultRuntimeNginxBinary   = "/opt/aipanel/runtime/nginx/current/sbin/nginx"
 	defaultRuntimeNginxConf     = "/opt/aipanel/runtime/nginx/current/conf/nginx.conf"
 	defaultRuntimeNginxService  = "aipanel-runtime-nginx.service"
@@ -67,69 +85,137 @@ const (
 	defaultRuntimeLockURL       = "https://raw.githubusercontent.com/robsonek/aiPanel/main/configs/sources/lock.json"
 )
 
+// Point-in-time recovery archives live next to the runtime data dirs
+// under <data_dir>/runtime.
+const (
+	runtimeMariaDBBinlogDir   = "mariadb-binlog"
+	runtimeMariaDBConfigDir   = "mariadb-conf"
+	runtimePostgreSQLWALDir   = "postgresql-wal"
+	runtimePostgreSQLPITRConf = "aipanel-pitr.conf"
+)
+
 // Options controls installer behavior.
 type Options struct {
-	Addr                  string
-	Env                   string
-	ConfigPath            string
-	DataDir               string
-	PanelBinaryPath       string
-	SourceBinaryPath      string
-	UnitFilePath          string
-	StateFilePath         string
-	ReportFilePath        string
-	LogFilePath           string
-	AdminEmail            string
-	AdminPassword         string
-	InstallMode           string
-	RuntimeChannel        string
-	RuntimeLockPath       string
-	RuntimeLockURL        string
-	RuntimeInstallDir     string
-	VerifyUpstreamSources bool
-	ForceAllSteps         bool
-	UpdateChangedOnly     bool
-	ReverseProxy          bool
-	PanelDomain           string
-	PHPMyAdminURL         string
-	PHPMyAdminSHA256URL   string
-	PHPMyAdminInstallDir  string
-	SkipPHPMyAdmin        bool
-	PGAdminURL            string
-	PGAdminSHA256         string
-	PGAdminSignatureURL   string
-	PGAdminFingerprint    string
-	PGAdminInstallDir     string
-	PGAdminVenvDir        string
-	PGAdminDataDir        string
-	PGAdminListenAddr     string
-	PGAdminRoutePath      string
-	SkipPGAdmin           bool
-	EnableLetsEncrypt     bool
-	LetsEncryptEmail      string
-	LetsEncryptWebroot    string
-	OnlyStep              string
+	Addr                   string
+	ListenAddrs            []string
+	Env                    string
+	ConfigPath             string
+	DataDir                string
+	PanelBinaryPath        string
+	SourceBinaryPath       string
+	UnitFilePath           string
+	StateFilePath          string
+	ReportFilePath         string
+	LogFilePath            string
+	AdminEmail             string
+	AdminPassword          string
+	InstallMode            string
+	RuntimeChannel         string
+	RuntimeLockPath        string
+	RuntimeLockURL         string
+	RuntimePins            map[string]string
+	CABundlePath           string
+	RuntimeInstallDir      string
+	VerifyUpstreamSources  bool
+	ForceAllSteps          bool
+	UpdateChangedOnly      bool
+	ReverseProxy           bool
+	PanelDomain            string
+	PHPMyAdminVersion      string
+	PHPMyAdminURL          string
+	PHPMyAdminSHA256       string
+	PHPMyAdminSHA256URL    string
+	PHPMyAdminSignatureURL string
+	PHPMyAdminFingerprint  string
+	PHPMyAdminInstallDir   string
+	PHPMyAdminRoutePath    string
+	SkipPHPMyAdmin         bool
+	PGAdminVersion         string
+	PGAdminURL             string
+	PGAdminSHA256          string
+	PGAdminSignatureURL    string
+	PGAdminFingerprint     string
+	PGAdminInstallDir      string
+	PGAdminVenvDir         string
+	PGAdminDataDir         string
+	PGAdminListenAddr      string
+	PGAdminRoutePath       string
+	SkipPGAdmin            bool
+	AdminerVersion         string
+	AdminerURL             string
+	AdminerSHA256          string
+	AdminerInstallDir      string
+	AdminerRoutePath       string
+	SkipAdminer            bool
+	RoundcubeVersion       string
+	RoundcubeURL           string
+	RoundcubeSHA256        string
+	RoundcubeInstallDir    string
+	RoundcubeDataDir       string
+	RoundcubeRoutePath     string
+	SkipRoundcube          bool
+	AdminToolsAllow        []string
+	RandomizeAdminRoutes   bool
+	UpgradeAdminTools      bool
+	EnableLetsEncrypt      bool
+	LetsEncryptEmail       string
+	LetsEncryptStaging     bool
+	LetsEncryptWebroot     string
+	OnlyStep               string
+	// StageRuntime installs runtime components next to the active version
+	// without repointing "current", so sites can be moved to a new PHP
+	// version one at a time.
+	StageRuntime bool
 
 	OSReleasePath string
 	MemInfoPath   string
 	Proc1ExePath  string
 	RootFSPath    string
+	SwapFilePath  string
+	FstabPath     string
+	// CreateSwapMB creates a swapfile of this size before runtime builds;
+	// 0 leaves swap to the automatic sizing in preflight.
+	CreateSwapMB int
 
 	NginxSitesAvailableDir string
 	NginxSitesEnabledDir   string
 	PanelVhostTemplatePath string
 	CatchAllTemplatePath   string
+	// CatchAllMode is how nginx answers requests for unknown hosts:
+	// config.CatchAllDrop, CatchAllRedirect or CatchAllLanding.
+	CatchAllMode string
 
 	MinCPU      int
 	MinMemoryMB int
 	MinDiskGB   int
 
 	SkipHealthcheck bool
+
+	// SSHPort stays open in the baseline firewall next to 80, 443 and the
+	// panel port. SkipFirewall leaves nftables untouched.
+	SSHPort      int
+	SkipFirewall bool
+
+	// SummaryEmail receives the install summary, without the password,
+	// through the SMTP relay. CredentialsFilePath gets a one-time
+	// credentials file. Both are optional.
+	SummaryEmail        string
+	CredentialsFilePath string
+
+	// SetupWizard leaves the first admin to the panel's /setup wizard:
+	// create_admin stores the hash of SetupToken instead of creating an
+	// account from AdminEmail/AdminPassword.
+	SetupWizard bool
+	SetupToken  string
 }
 
 const (
 	// InstallModeSourceBuild compiles runtime directly from upstream sources.
 	InstallModeSourceBuild = "source-build"
+	// InstallModeBinary unpacks prebuilt, signed runtime artifacts listed in
+	// the same lock; source-build stays the way to reproduce them. The
+	// shipped lock lists none, so it needs a lock that does.
+	InstallModeBinary = "binary"
 )
 
 const (
@@ -161,9 +247,13 @@ func DefaultOptions() Options {
 		VerifyUpstreamSources:  true,
 		ReverseProxy:           false,
 		PanelDomain:            "_",
+		CatchAllMode:           config.CatchAllDrop,
+		PHPMyAdminVersion:      defaultPHPMyAdminVersion,
 		PHPMyAdminURL:          defaultPHPMyAdminURL,
 		PHPMyAdminSHA256URL:    defaultPHPMyAdminSHA256URL,
 		PHPMyAdminInstallDir:   defaultPHPMyAdminInstallDir,
+		PHPMyAdminRoutePath:    defaultPHPMyAdminRoutePath,
+		PGAdminVersion:         defaultPGAdminVersion,
 		PGAdminURL:             defaultPGAdminURL,
 		PGAdminSHA256:          defaultPGAdminSHA256,
 		PGAdminSignatureURL:    defaultPGAdminSignatureURL,
@@ -174,6 +264,18 @@ func DefaultOptions() Options {
 		PGAdminListenAddr:      defaultPGAdminListenAddr,
 		PGAdminRoutePath:       defaultPGAdminRoutePath,
 		SkipPGAdmin:            true,
+		AdminerVersion:         defaultAdminerVersion,
+		AdminerURL:             defaultAdminerURL,
+		AdminerInstallDir:      defaultAdminerInstallDir,
+		AdminerRoutePath:       defaultAdminerRoutePath,
+		SkipAdminer:            true,
+		RoundcubeVersion:       defaultRoundcubeVersion,
+		RoundcubeURL:           defaultRoundcubeURL,
+		RoundcubeInstallDir:    defaultRoundcubeInstallDir,
+		RoundcubeDataDir:       defaultRoundcubeDataDir,
+		RoundcubeRoutePath:     defaultRoundcubeRoutePath,
+		SkipRoundcube:          true,
+		RandomizeAdminRoutes:   true,
 		EnableLetsEncrypt:      false,
 		LetsEncryptEmail:       "",
 		LetsEncryptWebroot:     defaultLetsEncryptWebroot,
@@ -181,6 +283,8 @@ func DefaultOptions() Options {
 		MemInfoPath:            "/proc/meminfo",
 		Proc1ExePath:           "/proc/1/exe",
 		RootFSPath:             "/",
+		SwapFilePath:           "/swapfile",
+		FstabPath:              "/etc/fstab",
 		NginxSitesAvailableDir: "/etc/nginx/sites-available",
 		NginxSitesEnabledDir:   "/etc/nginx/sites-enabled",
 		PanelVhostTemplatePath: defaultPanelVhostTemplate,
@@ -189,6 +293,7 @@ func DefaultOptions() Options {
 		MinMemoryMB:            1024,
 		MinDiskGB:              10,
 		SkipHealthcheck:        false,
+		SSHPort:                22,
 		SourceBinaryPath:       "",
 	}
 }
@@ -205,6 +310,23 @@ func (o Options) withDefaults() Options {
 		strings.TrimSpace(o.OnlyStep) == "" {
 		o.SkipPGAdmin = d.SkipPGAdmin
 	}
+	if !o.SkipAdminer &&
+		strings.TrimSpace(o.AdminerURL) == "" &&
+		strings.TrimSpace(o.AdminerSHA256) == "" &&
+		strings.TrimSpace(o.AdminerInstallDir) == "" &&
+		strings.TrimSpace(o.AdminerRoutePath) == "" &&
+		strings.TrimSpace(o.OnlyStep) == "" {
+		o.SkipAdminer = d.SkipAdminer
+	}
+	if !o.SkipRoundcube &&
+		strings.TrimSpace(o.RoundcubeURL) == "" &&
+		strings.TrimSpace(o.RoundcubeSHA256) == "" &&
+		strings.TrimSpace(o.RoundcubeInstallDir) == "" &&
+		strings.TrimSpace(o.RoundcubeDataDir) == "" &&
+		strings.TrimSpace(o.RoundcubeRoutePath) == "" &&
+		strings.TrimSpace(o.OnlyStep) == "" {
+		o.SkipRoundcube = d.SkipRoundcube
+	}
 	if strings.TrimSpace(o.Addr) == "" {
 		o.Addr = d.Addr
 	}
@@ -223,6 +345,9 @@ func (o Options) withDefaults() Options {
 	if strings.TrimSpace(o.UnitFilePath) == "" {
 		o.UnitFilePath = d.UnitFilePath
 	}
+	if o.SSHPort == 0 {
+		o.SSHPort = d.SSHPort
+	}
 	if strings.TrimSpace(o.StateFilePath) == "" {
 		o.StateFilePath = d.StateFilePath
 	}
@@ -254,6 +379,12 @@ func (o Options) withDefaults() Options {
 	if strings.TrimSpace(o.PanelDomain) == "" {
 		o.PanelDomain = d.PanelDomain
 	}
+	if strings.TrimSpace(o.CatchAllMode) == "" {
+		o.CatchAllMode = d.CatchAllMode
+	}
+	if strings.TrimSpace(o.PHPMyAdminVersion) == "" {
+		o.PHPMyAdminVersion = d.PHPMyAdminVersion
+	}
 	if strings.TrimSpace(o.PHPMyAdminURL) == "" {
 		o.PHPMyAdminURL = d.PHPMyAdminURL
 	}
@@ -263,6 +394,39 @@ func (o Options) withDefaults() Options {
 	if strings.TrimSpace(o.PHPMyAdminInstallDir) == "" {
 		o.PHPMyAdminInstallDir = d.PHPMyAdminInstallDir
 	}
+	if strings.TrimSpace(o.PHPMyAdminRoutePath) == "" {
+		o.PHPMyAdminRoutePath = d.PHPMyAdminRoutePath
+	}
+	if strings.TrimSpace(o.PGAdminVersion) == "" {
+		o.PGAdminVersion = d.PGAdminVersion
+	}
+	if strings.TrimSpace(o.AdminerVersion) == "" {
+		o.AdminerVersion = d.AdminerVersion
+	}
+	if strings.TrimSpace(o.AdminerURL) == "" {
+		o.AdminerURL = d.AdminerURL
+	}
+	if strings.TrimSpace(o.AdminerInstallDir) == "" {
+		o.AdminerInstallDir = d.AdminerInstallDir
+	}
+	if strings.TrimSpace(o.AdminerRoutePath) == "" {
+		o.AdminerRoutePath = d.AdminerRoutePath
+	}
+	if strings.TrimSpace(o.RoundcubeVersion) == "" {
+		o.RoundcubeVersion = d.RoundcubeVersion
+	}
+	if strings.TrimSpace(o.RoundcubeURL) == "" {
+		o.RoundcubeURL = d.RoundcubeURL
+	}
+	if strings.TrimSpace(o.RoundcubeInstallDir) == "" {
+		o.RoundcubeInstallDir = d.RoundcubeInstallDir
+	}
+	if strings.TrimSpace(o.RoundcubeDataDir) == "" {
+		o.RoundcubeDataDir = d.RoundcubeDataDir
+	}
+	if strings.TrimSpace(o.RoundcubeRoutePath) == "" {
+		o.RoundcubeRoutePath = d.RoundcubeRoutePath
+	}
 	if strings.TrimSpace(o.PGAdminURL) == "" {
 		o.PGAdminURL = d.PGAdminURL
 	}
@@ -305,6 +469,12 @@ func (o Options) withDefaults() Options {
 	if strings.TrimSpace(o.RootFSPath) == "" {
 		o.RootFSPath = d.RootFSPath
 	}
+	if strings.TrimSpace(o.SwapFilePath) == "" {
+		o.SwapFilePath = d.SwapFilePath
+	}
+	if strings.TrimSpace(o.FstabPath) == "" {
+		o.FstabPath = d.FstabPath
+	}
 	if strings.TrimSpace(o.NginxSitesAvailableDir) == "" {
 		o.NginxSitesAvailableDir = d.NginxSitesAvailableDir
 	}
@@ -326,7 +496,7 @@ func (o Options) withDefaults() Options {
 	if o.MinDiskGB <= 0 {
 		o.MinDiskGB = d.MinDiskGB
 	}
-	if o.ReverseProxy {
+	if _, unixAddr := config.UnixSocketPath(o.Addr); o.ReverseProxy && !unixAddr {
 		o.Addr = net.JoinHostPort("127.0.0.1", parsePort(o.Addr, "8080"))
 	}
 	o.OnlyStep = strings.ToLower(strings.TrimSpace(o.OnlyStep))
@@ -336,7 +506,7 @@ func (o Options) withDefaults() Options {
 func (o Options) validate() error {
 	mode := strings.ToLower(strings.TrimSpace(o.InstallMode))
 	switch mode {
-	case InstallModeSourceBuild:
+	case InstallModeSourceBuild, InstallModeBinary:
 	default:
 		return fmt.Errorf("invalid install mode: %s", o.InstallMode)
 	}
@@ -347,21 +517,38 @@ func (o Options) validate() error {
 	default:
 		return fmt.Errorf("invalid runtime channel: %s", o.RuntimeChannel)
 	}
+	for name, version := range o.RuntimePins {
+		if !runtimeComponentNamePattern.MatchString(name) || strings.TrimSpace(version) == "" {
+			return fmt.Errorf("invalid runtime pin %s=%s", name, version)
+		}
+	}
 
-	if isRuntimeSourceMode(mode) &&
-		requiresRuntimeLockForStep(o.OnlyStep) &&
+	custom := o.customRuntimeComponents()
+	if usesRuntimeLock(mode) &&
+		requiresRuntimeLockForStep(o.OnlyStep, custom) &&
 		strings.TrimSpace(o.RuntimeLockPath) == "" &&
 		strings.TrimSpace(o.RuntimeLockURL) == "" {
 		return fmt.Errorf("%s mode requires runtime lock path or runtime lock URL", mode)
 	}
-	if isRuntimeSourceMode(mode) &&
-		requiresRuntimeLockForStep(o.OnlyStep) &&
+	if usesRuntimeLock(mode) &&
+		requiresRuntimeLockForStep(o.OnlyStep, custom) &&
 		strings.TrimSpace(o.RuntimeInstallDir) == "" {
 		return fmt.Errorf("%s mode requires runtime install dir", mode)
 	}
+	if o.CreateSwapMB != 0 && (o.CreateSwapMB < MinSwapMB || o.CreateSwapMB > MaxSwapMB) {
+		return fmt.Errorf("swapfile size must be between %d MB and %d MB", MinSwapMB, MaxSwapMB)
+	}
 	if len(strings.TrimSpace(o.AdminPassword)) < MinAdminPasswordLength {
 		return fmt.Errorf("admin password must be at least %d characters", MinAdminPasswordLength)
 	}
+	if o.SetupWizard && len(strings.TrimSpace(o.SetupToken)) < 32 {
+		return fmt.Errorf("setup wizard requires a setup token of at least 32 characters")
+	}
+	if email := strings.TrimSpace(o.SummaryEmail); email != "" {
+		if _, err := mail.ParseAddress(email); err != nil {
+			return fmt.Errorf("invalid summary email %q", email)
+		}
+	}
 	if !o.SkipPHPMyAdmin {
 		if strings.TrimSpace(o.PHPMyAdminURL) == "" {
 			return fmt.Errorf("phpMyAdmin source URL is required")
@@ -372,6 +559,49 @@ func (o Options) validate() error {
 		if strings.TrimSpace(o.PHPMyAdminInstallDir) == "" {
 			return fmt.Errorf("phpMyAdmin install dir is required")
 		}
+		if !adminRoutePattern.MatchString(strings.TrimSpace(o.PHPMyAdminRoutePath)) {
+			return fmt.Errorf("invalid phpMyAdmin route path %q", o.PHPMyAdminRoutePath)
+		}
+	}
+	installAdminer := !o.SkipAdminer || strings.EqualFold(strings.TrimSpace(o.OnlyStep), steps.InstallAdminer)
+	if installAdminer {
+		if strings.TrimSpace(o.AdminerURL) == "" {
+			return fmt.Errorf("adminer source URL is required")
+		}
+		// Adminer publishes no checksum file, so the digest must be pinned.
+		if !isValidSHA256(strings.TrimSpace(o.AdminerSHA256)) {
+			return fmt.Errorf("adminer SHA-256 checksum is required")
+		}
+		if strings.TrimSpace(o.AdminerInstallDir) == "" {
+			return fmt.Errorf("adminer install dir is required")
+		}
+		if !adminRoutePattern.MatchString(strings.TrimSpace(o.AdminerRoutePath)) {
+			return fmt.Errorf("invalid adminer route path %q", o.AdminerRoutePath)
+		}
+	}
+	installRoundcube := !o.SkipRoundcube || strings.EqualFold(strings.TrimSpace(o.OnlyStep), steps.InstallRoundcube)
+	if installRoundcube {
+		if strings.TrimSpace(o.RoundcubeURL) == "" {
+			return fmt.Errorf("roundcube source URL is required")
+		}
+		// Roundcube publishes digests only on its download page.
+		if !isValidSHA256(strings.TrimSpace(o.RoundcubeSHA256)) {
+			return fmt.Errorf("roundcube SHA-256 checksum is required")
+		}
+		if strings.TrimSpace(o.RoundcubeInstallDir) == "" {
+			return fmt.Errorf("roundcube install dir is required")
+		}
+		if strings.TrimSpace(o.RoundcubeDataDir) == "" {
+			return fmt.Errorf("roundcube data dir is required")
+		}
+		if !adminRoutePattern.MatchString(strings.TrimSpace(o.RoundcubeRoutePath)) {
+			return fmt.Errorf("invalid roundcube route path %q", o.RoundcubeRoutePath)
+		}
+	}
+	for _, entry := range o.AdminToolsAllow {
+		if !isIPOrCIDR(entry) {
+			return fmt.Errorf("invalid admin tools allowlist entry %q", entry)
+		}
 	}
 	installPGAdmin := !o.SkipPGAdmin || strings.EqualFold(strings.TrimSpace(o.OnlyStep), steps.InstallPGAdmin)
 	if installPGAdmin {
@@ -390,16 +620,30 @@ func (o Options) validate() error {
 		if strings.TrimSpace(o.PGAdminRoutePath) == "" {
 			return fmt.Errorf("pgAdmin route path is required")
 		}
-		if !strings.HasPrefix(strings.TrimSpace(o.PGAdminRoutePath), "/") {
-			return fmt.Errorf("pgAdmin route path must start with /")
+		if !adminRoutePattern.MatchString(strings.TrimSpace(o.PGAdminRoutePath)) {
+			return fmt.Errorf("invalid pgAdmin route path %q", o.PGAdminRoutePath)
 		}
 		if _, _, err := net.SplitHostPort(strings.TrimSpace(o.PGAdminListenAddr)); err != nil {
 			return fmt.Errorf("invalid pgAdmin listen address %q: %w", o.PGAdminListenAddr, err)
 		}
 	}
+	for _, addr := range o.ListenAddrs {
+		if err := config.ValidateListenAddr(addr); err != nil {
+			return fmt.Errorf("invalid panel listen address: %w", err)
+		}
+	}
 	if o.ReverseProxy && strings.TrimSpace(o.PanelDomain) == "" {
 		return fmt.Errorf("panel domain is required when reverse proxy is enabled")
 	}
+	switch strings.TrimSpace(o.CatchAllMode) {
+	case config.CatchAllDrop, config.CatchAllLanding:
+	case config.CatchAllRedirect:
+		if domain := strings.TrimSpace(o.PanelDomain); !o.ReverseProxy || domain == "" || domain == "_" {
+			return fmt.Errorf("catch-all redirect requires reverse proxy mode with a panel domain")
+		}
+	default:
+		return fmt.Errorf("invalid catch-all mode %q (use drop, redirect or landing)", o.CatchAllMode)
+	}
 	if o.EnableLetsEncrypt {
 		if !o.ReverseProxy {
 			return fmt.Errorf("letsencrypt requires reverse proxy mode")
@@ -414,7 +658,7 @@ func (o Options) validate() error {
 	}
 	if only := strings.TrimSpace(o.OnlyStep); only != "" {
 		if !isInstallerStepSupported(only) {
-			if _, runtimeAlias, err := parseRuntimeOnlyComponents(only); err != nil || !runtimeAlias {
+			if _, runtimeAlias, err := parseRuntimeOnlyComponents(only, custom); err != nil || !runtimeAlias {
 				return fmt.Errorf("invalid installer step for --only: %s", o.OnlyStep)
 			}
 		}
@@ -463,8 +707,18 @@ func isRuntimeSourceMode(mode string) bool {
 	return strings.EqualFold(strings.TrimSpace(mode), InstallModeSourceBuild)
 }
 
-func requiresRuntimeLockForStep(step string) bool {
-	if _, runtimeAlias, _ := parseRuntimeOnlyComponents(step); runtimeAlias {
+func isRuntimeBinaryMode(mode string) bool {
+	return strings.EqualFold(strings.TrimSpace(mode), InstallModeBinary)
+}
+
+// usesRuntimeLock reports whether mode installs runtime components from the
+// runtime lock.
+func usesRuntimeLock(mode string) bool {
+	return isRuntimeSourceMode(mode) || isRuntimeBinaryMode(mode)
+}
+
+func requiresRuntimeLockForStep(step string, custom map[string]struct{}) bool {
+	if _, runtimeAlias, _ := parseRuntimeOnlyComponents(step, custom); runtimeAlias {
 		return true
 	}
 	switch strings.ToLower(strings.TrimSpace(step)) {
@@ -475,7 +729,9 @@ func requiresRuntimeLockForStep(step string) bool {
 	}
 }
 
-func parseRuntimeOnlyComponents(raw string) ([]string, bool, error) {
+// parseRuntimeOnlyComponents parses a comma-separated --only list of
+// runtime components: built-ins, or custom components named in custom.
+func parseRuntimeOnlyComponents(raw string, custom map[string]struct{}) ([]string, bool, error) {
 	only := strings.ToLower(strings.TrimSpace(raw))
 	if only == "" || isInstallerStepSupported(only) {
 		return nil, false, nil
@@ -487,7 +743,7 @@ func parseRuntimeOnlyComponents(raw string) ([]string, bool, error) {
 		if token == "" {
 			return nil, false, fmt.Errorf("empty runtime component name")
 		}
-		if !isSupportedRuntimeComponentName(token) {
+		if _, ok := custom[token]; !ok && !isSupportedRuntimeComponentName(token) {
 			return nil, false, fmt.Errorf("unsupported runtime component name: %s", token)
 		}
 		componentSet[token] = struct{}{}
@@ -501,12 +757,7 @@ func parseRuntimeOnlyComponents(raw string) ([]string, bool, error) {
 }
 
 func isSupportedRuntimeComponentName(name string) bool {
-	switch strings.ToLower(strings.TrimSpace(name)) {
-	case "nginx", "php-fpm", "mysql", "mariadb", "postgresql":
-		return true
-	default:
-		return false
-	}
+	return IsBuiltinRuntimeComponent(name)
 }
 
 func requiresRootPrivileges(rootFSPath string) bool {
@@ -530,6 +781,9 @@ type Report struct {
 	ConfigPath  string       `json:"config_path"`
 	DataDir     string       `json:"data_dir"`
 	Steps       []StepResult `json:"steps"`
+	// AptPackages records the version of each apt prerequisite as
+	// installed by this run.
+	AptPackages map[string]string `json:"apt_packages,omitempty"`
 }
 
 type checkpointState struct {
@@ -538,16 +792,25 @@ type checkpointState struct {
 
 const runtimeComponentStateFile = ".aipanel-component-state.json"
 
+// caBundleFile is the system bundle plus the extra CA bundle, written to
+// the data dir for commands that replace their roots with one file.
+const caBundleFile = "ca-bundle.pem"
+
 type runtimeComponentInstallState struct {
 	Component    string `json:"component"`
 	Version      string `json:"version"`
 	SourceURL    string `json:"source_url"`
 	SourceSHA256 string `json:"source_sha256"`
+	// ArtifactSHA256 is set when the tree was unpacked from a prebuilt
+	// artifact instead of built here.
+	ArtifactSHA256 string `json:"artifact_sha256,omitempty"`
 }
 
 type commandLoggingRunner struct {
 	delegate systemd.Runner
 	logf     func(string, ...any)
+	// redact masks secrets in returned errors; logf redacts on its own.
+	redact func(string) string
 }
 
 func (r commandLoggingRunner) Run(ctx context.Context, name string, args ...string) (string, error) {
@@ -586,7 +849,11 @@ func (r commandLoggingRunner) Run(ctx context.Context, name string, args ...stri
 			}
 			r.logf("[command] error: %v", err)
 		}
-		return out, fmt.Errorf("command %q failed after %s: %w", command, duration, err)
+		err = fmt.Errorf("command %q failed after %s: %w", command, duration, err)
+		if r.redact != nil {
+			err = &redactedError{err: err, msg: r.redact(err.Error())}
+		}
+		return out, err
 	}
 	if r.logf != nil {
 		r.logf("[command] ok after %s: %s", duration, command)
@@ -604,6 +871,15 @@ type Installer struct {
 	now         func() time.Time
 	geteuid     func() int
 	runtimeLock *RuntimeSourceLock
+	// customComponents are the custom components of the local lock file,
+	// accepted by --only next to the built-ins.
+	customComponents map[string]struct{}
+	progress         Progress
+	// aptVersions are the apt prerequisite versions found after
+	// install_packages, copied into the report.
+	aptVersions map[string]string
+	// secrets masks passwords in everything written to logs and reports.
+	secrets redactor
 }
 
 // New returns a configured installer.
@@ -613,15 +889,19 @@ func New(opts Options, runner systemd.Runner) *Installer {
 		runner = systemd.ExecRunner{}
 	}
 	ins := &Installer{
-		opts: opts,
-		now:  time.Now,
+		opts:             opts,
+		customComponents: opts.customRuntimeComponents(),
+		now:              time.Now,
 		geteuid: func() int {
 			return os.Geteuid()
 		},
 	}
+	ins.secrets.add(opts.AdminPassword)
+	ins.secrets.add(opts.SetupToken)
 	ins.runner = commandLoggingRunner{
 		delegate: runner,
 		logf:     ins.logf,
+		redact:   ins.secrets.apply,
 	}
 	return ins
 }
@@ -648,10 +928,24 @@ func (i *Installer) Run(ctx context.Context) (*Report, error) {
 	if err := i.ensureRootPrivileges(); err != nil {
 		return nil, err
 	}
-	if isRuntimeSourceMode(i.opts.InstallMode) && requiresRuntimeLockForStep(i.opts.OnlyStep) {
-		if _, err := i.resolveRuntimeSourceLock(ctx); err != nil {
+	if bundle := strings.TrimSpace(i.opts.CABundlePath); bundle != "" {
+		// Downloads, certbot and the panel itself must see through a
+		// TLS-inspecting proxy before the first request goes out.
+		if err := cabundle.Apply(bundle, filepath.Join(i.opts.DataDir, caBundleFile)); err != nil {
+			return nil, err
+		}
+		i.logf("[ca_bundle] trusting extra CA certificates from %s", bundle)
+	}
+	if usesRuntimeLock(i.opts.InstallMode) && requiresRuntimeLockForStep(i.opts.OnlyStep, i.customComponents) {
+		lock, err := i.resolveRuntimeSourceLock(ctx)
+		if err != nil {
 			return nil, fmt.Errorf("load runtime source lock: %w", err)
 		}
+		if isRuntimeBinaryMode(i.opts.InstallMode) {
+			if err := i.checkRuntimeBinaries(lock); err != nil {
+				return nil, err
+			}
+		}
 	}
 	report := &Report{
 		InstalledAt: i.now().UTC().Format(time.RFC3339),
@@ -681,17 +975,26 @@ func (i *Installer) Run(ctx context.Context) (*Report, error) {
 			step.FinishedAt = i.now().UTC().Format(time.RFC3339)
 			report.Steps = append(report.Steps, step)
 			i.logf("[%s] skipped (checkpoint exists)", name)
+			if i.progress != nil {
+				i.progress.StepFinished(name, step.Status)
+			}
 			return nil
 		}
 
 		i.logf("[%s] started", name)
+		if i.progress != nil {
+			i.progress.StepStarted(name)
+		}
 		err := fn(ctx)
 		step.FinishedAt = i.now().UTC().Format(time.RFC3339)
 		if err != nil {
 			step.Status = "failed"
-			step.Error = err.Error()
+			step.Error = i.secrets.apply(err.Error())
 			report.Steps = append(report.Steps, step)
 			i.logf("[%s] failed: %v", name, err)
+			if i.progress != nil {
+				i.progress.StepFinished(name, step.Status)
+			}
 			return err
 		}
 
@@ -702,6 +1005,9 @@ func (i *Installer) Run(ctx context.Context) (*Report, error) {
 			return fmt.Errorf("save installer checkpoint: %w", err)
 		}
 		i.logf("[%s] completed", name)
+		if i.progress != nil {
+			i.progress.StepFinished(name, step.Status)
+		}
 		return nil
 	}
 
@@ -728,12 +1034,23 @@ func (i *Installer) Run(ctx context.Context) (*Report, error) {
 		{name: steps.ConfigurePHP, fn: i.configurePHPFPM},
 		{name: steps.InstallPHPMyAdmin, fn: i.installPHPMyAdmin},
 		{name: steps.InstallPGAdmin, fn: i.installPGAdmin},
+		{name: steps.InstallAdminer, fn: i.installAdminer},
+		{name: steps.InstallRoundcube, fn: i.installRoundcube},
+		{name: steps.ConfigureFirewall, fn: i.configureFirewall},
 		{name: steps.WriteUnit, fn: i.writeUnitFile},
 		{name: steps.StartPanel, fn: i.startPanelService},
 		{name: steps.CreateAdmin, fn: i.createAdminUser},
 		{name: steps.Healthcheck, fn: i.runHealthcheck},
 	}
 
+	if i.progress != nil {
+		names := make([]string, 0, len(executionPlan))
+		for _, step := range executionPlan {
+			names = append(names, step.name)
+		}
+		i.progress.Plan(planStepNames(i.opts.OnlyStep, names, i.customComponents))
+	}
+
 	onlyStep := strings.ToLower(strings.TrimSpace(i.opts.OnlyStep))
 	updateRuntimeComponents := make([]string, 0)
 	if onlyStep == "" && i.opts.UpdateChangedOnly && !i.opts.ForceAllSteps {
@@ -753,7 +1070,7 @@ func (i *Installer) Run(ctx context.Context) (*Report, error) {
 
 	runErr := error(nil)
 	if onlyStep != "" {
-		if runtimeComponents, runtimeAlias, parseErr := parseRuntimeOnlyComponents(onlyStep); parseErr != nil {
+		if runtimeComponents, runtimeAlias, parseErr := parseRuntimeOnlyComponents(onlyStep, i.customComponents); parseErr != nil {
 			runErr = parseErr
 		} else if runtimeAlias {
 			scope := strings.Join(runtimeComponents, ",")
@@ -815,6 +1132,7 @@ func (i *Installer) Run(ctx context.Context) (*Report, error) {
 		}
 	}
 
+	report.AptPackages = i.aptVersions
 	if runErr != nil {
 		report.Status = "failed"
 		_ = i.writeReport(report)
@@ -829,7 +1147,7 @@ func (i *Installer) Run(ctx context.Context) (*Report, error) {
 	return report, nil
 }
 
-func (i *Installer) runPreflight(_ context.Context) error {
+func (i *Installer) runPreflight(ctx context.Context) error {
 	release, err := parseOSRelease(i.opts.OSReleasePath)
 	if err != nil {
 		return fmt.Errorf("read os-release: %w", err)
@@ -850,22 +1168,30 @@ func (i *Installer) runPreflight(_ context.Context) error {
 		return fmt.Errorf("insufficient CPU: need at least %d cores", i.opts.MinCPU)
 	}
 
-	memMB, err := totalMemoryMB(i.opts.MemInfoPath)
+	req, err := i.installRequirements(ctx)
 	if err != nil {
-		return fmt.Errorf("read memory info: %w", err)
+		return fmt.Errorf("resolve install requirements: %w", err)
 	}
-	if memMB < i.opts.MinMemoryMB {
-		return fmt.Errorf("insufficient memory: need at least %d MB", i.opts.MinMemoryMB)
+	if len(req.Components) > 0 {
+		i.logf("[preflight] components: %s; need %d MB memory, %d GB disk", strings.Join(req.Components, ", "), req.MemoryMB, req.DiskGB)
 	}
 
 	freeGB, err := freeDiskGB(i.opts.RootFSPath)
 	if err != nil {
 		return fmt.Errorf("read disk stats: %w", err)
 	}
-	if freeGB < i.opts.MinDiskGB {
-		return fmt.Errorf("insufficient disk: need at least %d GB free", i.opts.MinDiskGB)
+	needGB := req.DiskGB + ceilDiv(i.opts.CreateSwapMB, 1024)
+	if freeGB < needGB {
+		if len(req.Components) > 0 {
+			return fmt.Errorf("insufficient disk: need at least %d GB free for %s", needGB, strings.Join(req.Components, ", "))
+		}
+		return fmt.Errorf("insufficient disk: need at least %d GB free", needGB)
 	}
-	return nil
+	addedSwapMB, err := i.createRequestedSwap(ctx)
+	if err != nil {
+		return err
+	}
+	return i.checkMemory(ctx, req, addedSwapMB)
 }
 
 func (i *Installer) runSystemUpdate(ctx context.Context) error {
@@ -888,6 +1214,7 @@ func (i *Installer) installPackages(ctx context.Context) error {
 		"cmake",
 		"flex",
 		"gnupg",
+		"libevent-dev",
 		"libicu-dev",
 		"libonig-dev",
 		"libncurses-dev",
@@ -896,27 +1223,109 @@ func (i *Installer) installPackages(ctx context.Context) error {
 		"libsqlite3-dev",
 		"libssl-dev",
 		"libxml2-dev",
+		"nftables",
 		"pkg-config",
 		"sqlite3",
 		"zlib1g-dev",
 	}
 	if i.opts.EnableLetsEncrypt {
-		packages = append(packages, "certbot")
+		// The Cloudflare plugin lets sites on Cloudflare use DNS-01.
+		packages = append(packages, "certbot", "python3-certbot-dns-cloudflare")
 	}
 	i.logf("[install_packages] apt prerequisites: %s", strings.Join(packages, ", "))
-	installArgs := append([]string{"install", "-y", "--no-install-recommends"}, packages...)
+	pins := i.aptPins()
+	installArgs := []string{"install", "-y", "--no-install-recommends"}
+	for _, pkg := range packages {
+		if version, ok := pins[pkg]; ok {
+			pkg += "=" + version
+		}
+		installArgs = append(installArgs, pkg)
+	}
 	if _, err := i.runner.Run(ctx, "apt-get", installArgs...); err != nil {
 		return fmt.Errorf("apt install installer prerequisites: %w", err)
 	}
+	i.recordAptVersions(ctx, packages, pins)
 	return nil
 }
 
+// aptPins returns the apt_packages section of the runtime lock, when the
+// run has loaded one.
+func (i *Installer) aptPins() map[string]string {
+	if i.runtimeLock == nil {
+		return nil
+	}
+	return i.runtimeLock.AptPackages
+}
+
+// recordAptVersions reads the installed version of each package for the
+// report and warns when one differs from its pin or from the version the
+// previous report recorded. It never fails the step: the packages are in.
+func (i *Installer) recordAptVersions(ctx context.Context, packages []string, pins map[string]string) {
+	args := append([]string{"-W", "-f=${Package} ${Version}\\n"}, packages...)
+	out, err := i.runner.Run(ctx, "dpkg-query", args...)
+	if err != nil {
+		i.logf("[install_packages] warning: read installed package versions: %v", err)
+		return
+	}
+	installed := parseDpkgVersions(out)
+	if len(installed) == 0 {
+		return
+	}
+	previous := i.previousAptVersions()
+	for _, pkg := range packages {
+		version, ok := installed[pkg]
+		if !ok {
+			continue
+		}
+		if pinned, ok := pins[pkg]; ok && pinned != version {
+			i.logf("[install_packages] warning: %s is at %s, lock pins %s", pkg, version, pinned)
+		}
+		if before, ok := previous[pkg]; ok && before != version {
+			i.logf("[install_packages] warning: %s drifted from %s to %s since the last install", pkg, before, version)
+		}
+	}
+	i.aptVersions = installed
+}
+
+// previousAptVersions returns the apt versions of the last report, if any.
+func (i *Installer) previousAptVersions() map[string]string {
+	path := strings.TrimSpace(i.opts.ReportFilePath)
+	if path == "" {
+		return nil
+	}
+	//nolint:gosec // G304: report path is controlled by installer options.
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var previous Report
+	if err := json.Unmarshal(b, &previous); err != nil {
+		return nil
+	}
+	return previous.AptPackages
+}
+
+// parseDpkgVersions parses "package version" lines from dpkg-query.
+func parseDpkgVersions(out string) map[string]string {
+	versions := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		// Multi-arch packages are listed as name:arch.
+		name, _, _ := strings.Cut(fields[0], ":")
+		versions[name] = fields[1]
+	}
+	return versions
+}
+
 func (i *Installer) installRuntimeArtifacts(ctx context.Context) error {
 	return i.installRuntimeArtifactsSelected(ctx, nil)
 }
 
 func (i *Installer) installRuntimeArtifactsSelected(ctx context.Context, selected []string) error {
-	if !isRuntimeSourceMode(i.opts.InstallMode) {
+	if !usesRuntimeLock(i.opts.InstallMode) {
 		return nil
 	}
 	return i.installRuntimeFromSourcesSelected(ctx, selected)
@@ -937,9 +1346,14 @@ func (i *Installer) installRuntimeFromSourcesSelected(ctx context.Context, selec
 		return err
 	}
 
-	for _, componentName := range componentNames {
+	for idx, componentName := range componentNames {
 		component := selectedChannel[componentName]
-		if err := i.installRuntimeComponentFromSource(ctx, componentName, component); err != nil {
+		i.stepDetail("%s %s (%d/%d)", componentName, component.Version, idx+1, len(componentNames))
+		install := i.installRuntimeComponentFromSource
+		if isRuntimeBinaryMode(i.opts.InstallMode) {
+			install = i.installRuntimeComponentFromBinary
+		}
+		if err := install(ctx, componentName, component); err != nil {
 			return err
 		}
 	}
@@ -975,9 +1389,54 @@ func (i *Installer) installRuntimeComponentFromSource(
 		return fmt.Errorf("create runtime component dir %s: %w", componentName, err)
 	}
 
+	buildRoot, sourceDir, err := i.fetchRuntimeSource(ctx, componentName, component)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(buildRoot)
+	}()
+
+	for idx, command := range component.Build.Commands {
+		rendered := renderRuntimeBuildCommand(i.opts, componentName, component.Version, command)
+		i.logf(
+			"[install_runtime] %s build command %d/%d: %s",
+			componentName,
+			idx+1,
+			len(component.Build.Commands),
+			rendered,
+		)
+		i.stepDetail("%s %s: build command %d/%d", componentName, component.Version, idx+1, len(component.Build.Commands))
+		shellCommand := "cd " + shellQuote(sourceDir) + " && " + rendered
+		if _, err := i.runner.Run(ctx, "bash", "-lc", shellCommand); err != nil {
+			return fmt.Errorf("build %s command %d failed: %w", componentName, idx+1, err)
+		}
+	}
+
+	hasFiles, err := directoryHasEntries(versionDir)
+	if err != nil {
+		return fmt.Errorf("inspect runtime install dir for %s: %w", componentName, err)
+	}
+	if !hasFiles {
+		return fmt.Errorf("runtime build output is empty for %s: %s", componentName, versionDir)
+	}
+	if err := writeRuntimeComponentInstallState(versionDir, componentName, component, ""); err != nil {
+		return fmt.Errorf("write runtime install state for %s: %w", componentName, err)
+	}
+	return i.activateRuntimeVersion(componentName, versionDir, currentLink)
+}
+
+// fetchRuntimeSource downloads the component's pinned source, verifies its
+// checksum and (with VerifyUpstreamSources) its upstream signature, and
+// unpacks it into a new temp dir. The caller removes buildRoot.
+func (i *Installer) fetchRuntimeSource(
+	ctx context.Context,
+	componentName string,
+	component RuntimeComponentLock,
+) (buildRoot string, sourceDir string, err error) {
 	sourceArchivePath, err := i.downloadRuntimeArtifact(ctx, component.SourceURL)
 	if err != nil {
-		return fmt.Errorf("download runtime source %s: %w", componentName, err)
+		return "", "", fmt.Errorf("download runtime source %s: %w", componentName, err)
 	}
 	defer func() {
 		_ = os.Remove(sourceArchivePath)
@@ -985,10 +1444,10 @@ func (i *Installer) installRuntimeComponentFromSource(
 
 	sourceHash, err := fileSHA256(sourceArchivePath)
 	if err != nil {
-		return fmt.Errorf("checksum runtime source %s: %w", componentName, err)
+		return "", "", fmt.Errorf("checksum runtime source %s: %w", componentName, err)
 	}
 	if !strings.EqualFold(sourceHash, component.SourceSHA256) {
-		return fmt.Errorf(
+		return "", "", fmt.Errorf(
 			"runtime source checksum mismatch for %s: expected %s got %s",
 			componentName,
 			component.SourceSHA256,
@@ -1002,54 +1461,162 @@ func (i *Installer) installRuntimeComponentFromSource(
 			i.logf("[install_runtime] signature metadata missing for %s, skipping GPG verification", componentName)
 		} else {
 			if err := i.verifyRuntimeSourceSignature(ctx, componentName, component, sourceArchivePath); err != nil {
-				return err
+				return "", "", err
 			}
 		}
 	}
 
-	buildRoot, err := os.MkdirTemp("", "aipanel-source-build-"+componentName+"-*")
+	buildRoot, err = os.MkdirTemp("", "aipanel-source-build-"+componentName+"-*")
 	if err != nil {
-		return fmt.Errorf("create build dir for %s: %w", componentName, err)
+		return "", "", fmt.Errorf("create build dir for %s: %w", componentName, err)
 	}
-	defer func() {
+	if err := extractArchive(sourceArchivePath, buildRoot); err != nil {
 		_ = os.RemoveAll(buildRoot)
-	}()
+		return "", "", fmt.Errorf("extract runtime source %s: %w", componentName, err)
+	}
+	sourceDir, err = detectSourceDir(buildRoot)
+	if err != nil {
+		_ = os.RemoveAll(buildRoot)
+		return "", "", fmt.Errorf("resolve source dir for %s: %w", componentName, err)
+	}
+	return buildRoot, sourceDir, nil
+}
 
-	if err := extractArchive(sourceArchivePath, buildRoot); err != nil {
-		return fmt.Errorf("extract runtime source %s: %w", componentName, err)
+// installRuntimeComponentFromBinary unpacks the component's prebuilt
+// artifact into its version dir. The artifact holds the install dir as the
+// build commands would have left it; its checksum and CI signature are
+// verified before anything is unpacked.
+func (i *Installer) installRuntimeComponentFromBinary(
+	ctx context.Context,
+	componentName string,
+	component RuntimeComponentLock,
+) error {
+	componentName = strings.TrimSpace(componentName)
+	if componentName == "" {
+		return fmt.Errorf("runtime component name is empty")
+	}
+	binary := component.Binary
+	if binary.IsZero() {
+		return fmt.Errorf("runtime lock has no prebuilt binary for %s; use --install-mode=%s", componentName, InstallModeSourceBuild)
 	}
+	i.logf(
+		"[install_runtime] component=%s version=%s binary=%s",
+		componentName,
+		component.Version,
+		binary.URL,
+	)
 
-	sourceDir, err := detectSourceDir(buildRoot)
+	archivePath, err := i.downloadRuntimeArtifact(ctx, binary.URL)
 	if err != nil {
-		return fmt.Errorf("resolve source dir for %s: %w", componentName, err)
+		return fmt.Errorf("download runtime binary %s: %w", componentName, err)
 	}
+	defer func() {
+		_ = os.Remove(archivePath)
+	}()
 
-	for idx, command := range component.Build.Commands {
-		rendered := renderRuntimeBuildCommand(i.opts, componentName, component.Version, command)
-		i.logf(
-			"[install_runtime] %s build command %d/%d: %s",
+	archiveHash, err := fileSHA256(archivePath)
+	if err != nil {
+		return fmt.Errorf("checksum runtime binary %s: %w", componentName, err)
+	}
+	if !strings.EqualFold(archiveHash, binary.SHA256) {
+		return fmt.Errorf(
+			"runtime binary checksum mismatch for %s: expected %s got %s",
 			componentName,
-			idx+1,
-			len(component.Build.Commands),
-			rendered,
+			binary.SHA256,
+			archiveHash,
 		)
-		shellCommand := "cd " + shellQuote(sourceDir) + " && " + rendered
-		if _, err := i.runner.Run(ctx, "bash", "-lc", shellCommand); err != nil {
-			return fmt.Errorf("build %s command %d failed: %w", componentName, idx+1, err)
-		}
 	}
+	i.logf("[install_runtime] checksum verified for %s: %s", componentName, archiveHash)
 
-	hasFiles, err := directoryHasEntries(versionDir)
+	// Unlike upstream sources, an artifact is always checked against its
+	// signature: nothing else ties it to the pinned source.
+	if err := i.verifyRuntimeSignature(ctx, componentName, binary.SignatureURL, binary.PublicKeyFingerprint, archivePath); err != nil {
+		return err
+	}
+
+	// Unpack next to the version dir so the tree can be renamed into place.
+	componentDir := filepath.Join(i.opts.RuntimeInstallDir, componentName)
+	versionDir := filepath.Join(componentDir, component.Version)
+	currentLink := filepath.Join(componentDir, "current")
+	//nolint:gosec // Runtime binaries must be traversable by non-root service users (e.g. postgres).
+	if err := os.MkdirAll(componentDir, 0o755); err != nil {
+		return fmt.Errorf("create runtime component dir %s: %w", componentName, err)
+	}
+	unpackRoot, err := os.MkdirTemp(componentDir, ".unpack-*")
 	if err != nil {
-		return fmt.Errorf("inspect runtime install dir for %s: %w", componentName, err)
+		return fmt.Errorf("create unpack dir for %s: %w", componentName, err)
+	}
+	defer func() {
+		_ = os.RemoveAll(unpackRoot)
+	}()
+	if err := extractInstallTree(archivePath, unpackRoot); err != nil {
+		return fmt.Errorf("extract runtime binary %s: %w", componentName, err)
+	}
+	treeDir, err := detectSourceDir(unpackRoot)
+	if err != nil {
+		return fmt.Errorf("resolve binary dir for %s: %w", componentName, err)
+	}
+	hasFiles, err := directoryHasEntries(treeDir)
+	if err != nil {
+		return fmt.Errorf("inspect runtime binary for %s: %w", componentName, err)
 	}
 	if !hasFiles {
-		return fmt.Errorf("runtime build output is empty for %s: %s", componentName, versionDir)
+		return fmt.Errorf("runtime binary archive is empty for %s", componentName)
+	}
+
+	if err := os.RemoveAll(versionDir); err != nil {
+		return fmt.Errorf("reset runtime component dir %s: %w", componentName, err)
 	}
-	if err := writeRuntimeComponentInstallState(versionDir, componentName, component); err != nil {
+	if err := os.Rename(treeDir, versionDir); err != nil {
+		return fmt.Errorf("install runtime binary %s: %w", componentName, err)
+	}
+	//nolint:gosec // Runtime binaries must be traversable by non-root service users (e.g. postgres).
+	if err := os.Chmod(versionDir, 0o755); err != nil {
+		return fmt.Errorf("chmod runtime component dir %s: %w", componentName, err)
+	}
+	if err := writeRuntimeComponentInstallState(versionDir, componentName, component, archiveHash); err != nil {
 		return fmt.Errorf("write runtime install state for %s: %w", componentName, err)
 	}
+	return i.activateRuntimeVersion(componentName, versionDir, currentLink)
+}
+
+// checkRuntimeBinaries fails before any step runs when a selected component
+// has no prebuilt artifact, rather than midway through install_runtime.
+func (i *Installer) checkRuntimeBinaries(lock *RuntimeSourceLock) error {
+	channel, err := i.runtimeChannel(lock)
+	if err != nil {
+		return err
+	}
+	only, _, err := parseRuntimeOnlyComponents(i.opts.OnlyStep, i.customComponents)
+	if err != nil {
+		return err
+	}
+	selected, names, err := selectRuntimeComponents(channel, only)
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for _, name := range names {
+		if selected[name].Binary.IsZero() {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("runtime lock has no prebuilt binary for %s; use --install-mode=%s",
+			strings.Join(missing, ", "), InstallModeSourceBuild)
+	}
+	return nil
+}
 
+// activateRuntimeVersion points the component's current symlink at
+// versionDir.
+func (i *Installer) activateRuntimeVersion(componentName, versionDir, currentLink string) error {
+	if i.opts.StageRuntime {
+		if _, err := os.Lstat(currentLink); err == nil {
+			i.logf("[install_runtime] staged %s at %s; current left unchanged", componentName, versionDir)
+			return nil
+		}
+	}
 	if err := os.Remove(currentLink); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("remove current runtime symlink for %s: %w", componentName, err)
 	}
@@ -1066,11 +1633,21 @@ func (i *Installer) verifyRuntimeSourceSignature(
 	component RuntimeComponentLock,
 	archivePath string,
 ) error {
-	signatureURL := strings.TrimSpace(component.SignatureURL)
+	return i.verifyRuntimeSignature(ctx, componentName, component.SignatureURL, component.PublicKeyFingerprint, archivePath)
+}
+
+func (i *Installer) verifyRuntimeSignature(
+	ctx context.Context,
+	componentName string,
+	signatureURL string,
+	fingerprint string,
+	archivePath string,
+) error {
+	signatureURL = strings.TrimSpace(signatureURL)
 	if signatureURL == "" {
 		return fmt.Errorf("runtime signature_url is missing for %s", componentName)
 	}
-	fingerprint := strings.TrimSpace(component.PublicKeyFingerprint)
+	fingerprint = strings.TrimSpace(fingerprint)
 	if fingerprint == "" {
 		return fmt.Errorf("runtime public_key_fingerprint is missing for %s", componentName)
 	}
@@ -1096,7 +1673,7 @@ func (i *Installer) verifyRuntimeSourceSignature(
 	}()
 
 	commands := []string{
-		"export GNUPGHOME=" + shellQuote(gnupgHome),
+		i.gnupgHomeCommand(gnupgHome),
 		"gpg --batch --keyserver hkps://keys.openpgp.org --recv-keys " + shellQuote(fingerprint) + " || true",
 		"if ! gpg --batch --list-keys --with-colons 2>/dev/null | grep -iq " + shellQuote(fingerprint) + "; then " +
 			"gpg --batch --keyserver hkps://keyserver.ubuntu.com --recv-keys " + shellQuote(fingerprint) + " || true; fi",
@@ -1117,7 +1694,7 @@ func (i *Installer) activateRuntimeServices(ctx context.Context) error {
 }
 
 func (i *Installer) activateRuntimeServicesSelected(ctx context.Context, selected []string) error {
-	if !isRuntimeSourceMode(i.opts.InstallMode) {
+	if !usesRuntimeLock(i.opts.InstallMode) {
 		return nil
 	}
 	lock, err := i.resolveRuntimeSourceLock(ctx)
@@ -1161,7 +1738,7 @@ func (i *Installer) activateRuntimeServicesSelected(ctx context.Context, selecte
 
 	if len(unitNames) == 0 {
 		i.logf("[activate_runtime_services] no runtime units declared in lockfile")
-		return nil
+		return i.validateRuntimeComponents(ctx, selectedChannel, componentNames)
 	}
 
 	if err := systemd.DaemonReload(ctx, i.runner); err != nil {
@@ -1177,6 +1754,29 @@ func (i *Installer) activateRuntimeServicesSelected(ctx context.Context, selecte
 			return fmt.Errorf("restart runtime unit %s: %w", unitName, err)
 		}
 	}
+	return i.validateRuntimeComponents(ctx, selectedChannel, componentNames)
+}
+
+// validateRuntimeComponents runs the validation command of each component
+// that declares one, after its unit was (re)started.
+func (i *Installer) validateRuntimeComponents(
+	ctx context.Context,
+	channel RuntimeChannelLock,
+	componentNames []string,
+) error {
+	for _, componentName := range componentNames {
+		component := channel[componentName]
+		command := strings.TrimSpace(component.Validation.Command)
+		if command == "" {
+			continue
+		}
+		rendered := renderRuntimePlaceholder(command, i.opts, componentName, component.Version)
+		i.logf("[activate_runtime_services] validate %s: %s", componentName, rendered)
+		i.stepDetail("%s %s: validate", componentName, component.Version)
+		if _, err := i.runner.Run(ctx, "bash", "-lc", rendered); err != nil {
+			return fmt.Errorf("validate runtime component %s: %w", componentName, err)
+		}
+	}
 	return nil
 }
 
@@ -1219,7 +1819,7 @@ func selectRuntimeComponents(
 }
 
 func (i *Installer) runtimeComponentsNeedingUpdate(ctx context.Context) ([]string, error) {
-	if !isRuntimeSourceMode(i.opts.InstallMode) {
+	if !usesRuntimeLock(i.opts.InstallMode) {
 		return nil, nil
 	}
 	lock, err := i.resolveRuntimeSourceLock(ctx)
@@ -1298,6 +1898,16 @@ func (i *Installer) runtimeComponentNeedsUpdate(
 	) {
 		return true, "source checksum changed", nil
 	}
+	expectedArtifact := ""
+	if isRuntimeBinaryMode(i.opts.InstallMode) {
+		expectedArtifact = component.Binary.SHA256
+	}
+	if !strings.EqualFold(
+		strings.TrimSpace(currentState.ArtifactSHA256),
+		strings.TrimSpace(expectedArtifact),
+	) {
+		return true, "prebuilt artifact changed", nil
+	}
 	return false, "", nil
 }
 
@@ -1305,12 +1915,14 @@ func writeRuntimeComponentInstallState(
 	versionDir string,
 	componentName string,
 	component RuntimeComponentLock,
+	artifactSHA256 string,
 ) error {
 	state := runtimeComponentInstallState{
-		Component:    strings.TrimSpace(componentName),
-		Version:      strings.TrimSpace(component.Version),
-		SourceURL:    strings.TrimSpace(component.SourceURL),
-		SourceSHA256: strings.ToLower(strings.TrimSpace(component.SourceSHA256)),
+		Component:      strings.TrimSpace(componentName),
+		Version:        strings.TrimSpace(component.Version),
+		SourceURL:      strings.TrimSpace(component.SourceURL),
+		SourceSHA256:   strings.ToLower(strings.TrimSpace(component.SourceSHA256)),
+		ArtifactSHA256: strings.ToLower(strings.TrimSpace(artifactSHA256)),
 	}
 	body, err := json.MarshalIndent(state, "", "  ")
 	if err != nil {
@@ -1336,6 +1948,10 @@ func readRuntimeComponentInstallState(versionDir string) (*runtimeComponentInsta
 
 var majorMinorVersionPattern = regexp.MustCompile(`^\d+\.\d+`)
 
+// adminRoutePattern limits admin tool routes to characters that are safe
+// to splice into nginx location blocks.
+var adminRoutePattern = regexp.MustCompile(`^/[A-Za-z0-9._~-]+(/[A-Za-z0-9._~-]+)*/?$`)
+
 func (i *Installer) prepareRuntimeCompatibility(
 	ctx context.Context,
 	channel RuntimeChannelLock,
@@ -1353,22 +1969,44 @@ func (i *Installer) prepareRuntimeCompatibility(
 			if version == "" {
 				return fmt.Errorf("invalid php-fpm version in runtime lock: %q", component.Version)
 			}
-			if err := i.ensureRuntimePHPFPMConfig(version); err != nil {
+			if err := i.ensureRuntimePHPFPMConfig(component.Version); err != nil {
 				return err
 			}
 		case "mariadb":
 			if err := i.ensureRuntimeMariaDBBootstrap(ctx); err != nil {
 				return err
 			}
+		case "mysql":
+			if err := i.ensureRuntimeMySQLBootstrap(ctx); err != nil {
+				return err
+			}
 		case "postgresql":
 			if err := i.ensureRuntimePostgreSQLBootstrap(ctx); err != nil {
 				return err
 			}
+		case "mongodb":
+			if err := i.ensureRuntimeMongoDBBootstrap(ctx); err != nil {
+				return err
+			}
+		case "memcached":
+			if err := i.restartSiteMemcached(ctx); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
+// restartSiteMemcached moves the per-site memcached instances, which run
+// from memcached/current, onto the build just installed. memcached has
+// no shared unit; sites without an instance match nothing.
+func (i *Installer) restartSiteMemcached(ctx context.Context) error {
+	if _, err := i.runner.Run(ctx, "systemctl", "try-restart", "aipanel-memcached-*.service"); err != nil {
+		return fmt.Errorf("restart site memcached instances: %w", err)
+	}
+	return nil
+}
+
 func majorMinorVersion(version string) string {
 	return majorMinorVersionPattern.FindString(strings.TrimSpace(version))
 }
@@ -1426,8 +2064,12 @@ func (i *Installer) ensureRuntimeNginxConfig(ctx context.Context) error {
 		}
 		resolvedTempDirs = append(resolvedTempDirs, resolved)
 	}
+	statusServer, err := i.runtimeNginxStatusServer(ctx)
+	if err != nil {
+		return err
+	}
 	confPath := filepath.Join(confDir, "nginx.conf")
-	if err := writeTextFile(confPath, sourceRuntimeNginxConf, 0o644); err != nil {
+	if err := writeTextFile(confPath, fmt.Sprintf(sourceRuntimeNginxConf, statusServer), 0o644); err != nil {
 		return fmt.Errorf("write runtime nginx config: %w", err)
 	}
 	if err := i.ensureRuntimeNginxTempDirPermissions(ctx, resolvedTempDirs); err != nil {
@@ -1436,6 +2078,22 @@ func (i *Installer) ensureRuntimeNginxConfig(ctx context.Context) error {
 	return nil
 }
 
+// runtimeNginxStatusServer returns the loopback stub_status server the
+// panel polls for connection and request rates, reserving its port. It is
+// empty for an nginx built without the module, which would reject the
+// directive.
+func (i *Installer) runtimeNginxStatusServer(ctx context.Context) (string, error) {
+	binary := filepath.Join(i.opts.RuntimeInstallDir, "nginx", "current", "sbin", "nginx")
+	out, err := i.runner.Run(ctx, binary, "-V")
+	if err != nil || !strings.Contains(out, "--with-http_stub_status_module") {
+		return "", nil
+	}
+	if err := i.reservePort(ctx, portOwnerNginxStatus, defaultRuntimeNginxService, runtimeNginxStatusAddr); err != nil {
+		return "", err
+	}
+	return runtimeNginxStatusServer, nil
+}
+
 func (i *Installer) ensureRuntimeNginxTempDirPermissions(ctx context.Context, dirs []string) error {
 	if len(dirs) == 0 {
 		return nil
@@ -1456,8 +2114,13 @@ func (i *Installer) ensureRuntimeNginxTempDirPermissions(ctx context.Context, di
 	return nil
 }
 
-func (i *Installer) ensureRuntimePHPFPMConfig(_ string) error {
+// ensureRuntimePHPFPMConfig seeds php-fpm.conf and the default pool of the
+// active runtime, or of the staged version with StageRuntime.
+func (i *Installer) ensureRuntimePHPFPMConfig(version string) error {
 	runtimeEtcDir := filepath.Join(i.opts.RuntimeInstallDir, "php-fpm", "current", "etc")
+	if i.opts.StageRuntime {
+		runtimeEtcDir = filepath.Join(i.opts.RuntimeInstallDir, "php-fpm", version, "etc")
+	}
 	if err := os.MkdirAll(runtimeEtcDir, 0o750); err != nil {
 		return fmt.Errorf("create runtime php-fpm etc dir: %w", err)
 	}
@@ -1511,6 +2174,9 @@ func (i *Installer) ensureRuntimeMariaDBBootstrap(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("prepare runtime mariadb data symlink: %w", err)
 	}
+	if err := i.ensureRuntimeMariaDBBinlog(); err != nil {
+		return err
+	}
 	mysqlDir := filepath.Join(dataDir, "mysql")
 	if _, err := os.Stat(mysqlDir); err == nil {
 		return nil
@@ -1546,6 +2212,79 @@ mkdir -p "$data_dir"
 	return nil
 }
 
+// ensureRuntimeMySQLBootstrap initializes the MySQL data dir on first install.
+// --initialize-insecure leaves root@localhost without a password; it is only
+// reachable over the local socket, which the database adapter relies on.
+func (i *Installer) ensureRuntimeMySQLBootstrap(ctx context.Context) error {
+	runtimeDir := filepath.Join(i.opts.RuntimeInstallDir, "mysql", "current")
+	dataDir, err := i.ensureRuntimeDataSymlink("mysql", runtimeDir, 0o750)
+	if err != nil {
+		return fmt.Errorf("prepare runtime mysql data symlink: %w", err)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "mysql")); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("inspect runtime mysql data dir: %w", err)
+	}
+	if _, err := i.runner.Run(
+		ctx,
+		filepath.Join(runtimeDir, "bin", "mysqld"),
+		"--initialize-insecure",
+		"--basedir="+runtimeDir,
+		"--datadir="+filepath.Join(runtimeDir, "data"),
+		"--user=root",
+	); err != nil {
+		return fmt.Errorf("initialize runtime mysql data dir: %w", err)
+	}
+	return nil
+}
+
+// ensureRuntimeMongoDBBootstrap creates the panel admin user on first install.
+// The runtime unit enables authorization, so the user is created against a
+// temporary mongod bound to localhost and its credentials are written next
+// to the persistent data dir for the database adapter.
+func (i *Installer) ensureRuntimeMongoDBBootstrap(ctx context.Context) error {
+	runtimeDir := filepath.Join(i.opts.RuntimeInstallDir, "mongodb", "current")
+	dataDir, err := i.ensureRuntimeDataSymlink("mongodb", runtimeDir, 0o700)
+	if err != nil {
+		return fmt.Errorf("prepare runtime mongodb data symlink: %w", err)
+	}
+	credentialsPath := filepath.Join(filepath.Dir(dataDir), "mongodb-admin.json")
+	if _, err := os.Stat(credentialsPath); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("inspect runtime mongodb credentials: %w", err)
+	}
+	password, err := randomPassword()
+	if err != nil {
+		return fmt.Errorf("generate mongodb admin password: %w", err)
+	}
+	i.secrets.add(password)
+	createUser := fmt.Sprintf(
+		`db.getSiblingDB("admin").createUser({user: "aipanel", pwd: "%s", roles: ["root"], mechanisms: ["SCRAM-SHA-256"]});`,
+		password,
+	)
+	bootstrapScript := fmt.Sprintf(`
+set -e
+runtime_root=%s
+data_dir="$runtime_root/data"
+"$runtime_root/bin/mongod" --dbpath "$data_dir" --bind_ip 127.0.0.1 --port 27017 --fork --logpath "$data_dir/bootstrap.log"
+trap '"$runtime_root/bin/mongod" --dbpath "$data_dir" --shutdown >/dev/null' EXIT
+"$runtime_root/bin/mongosh" --quiet --host 127.0.0.1 --port 27017 --eval %s
+`, shellQuote(runtimeDir), shellQuote(createUser))
+	if _, err := i.runner.Run(ctx, "bash", "-lc", bootstrapScript); err != nil {
+		return fmt.Errorf("bootstrap runtime mongodb admin user: %w", err)
+	}
+	creds, err := json.Marshal(map[string]string{"username": "aipanel", "password": password})
+	if err != nil {
+		return fmt.Errorf("encode mongodb admin credentials: %w", err)
+	}
+	if err := writeBinaryFile(credentialsPath, creds, 0o600); err != nil {
+		return fmt.Errorf("write mongodb admin credentials: %w", err)
+	}
+	return nil
+}
+
 func (i *Installer) ensureRuntimePostgreSQLBootstrap(ctx context.Context) error {
 	runtimeDir := filepath.Join(i.opts.RuntimeInstallDir, "postgresql", "current")
 	dataDir, err := i.ensureRuntimeDataSymlink("postgresql", runtimeDir, 0o700)
@@ -1618,22 +2357,95 @@ func (i *Installer) ensureRuntimePostgreSQLBootstrap(ctx context.Context) error
 	if _, err := i.runner.Run(ctx, "chown", "-R", "postgres:postgres", runtimeDir, dataDir); err != nil {
 		return fmt.Errorf("set runtime postgresql ownership: %w", err)
 	}
-	if versionExists {
-		return nil
+	if !versionExists {
+		initdbBin := filepath.Join(runtimeDir, "bin", "initdb")
+		if _, err := i.runner.Run(
+			ctx,
+			"runuser",
+			"-u", "postgres", "--",
+			initdbBin,
+			"-D", dataDir,
+			"-U", "postgres",
+			"--auth-local=trust",
+			"--auth-host=scram-sha-256",
+		); err != nil {
+			return fmt.Errorf("bootstrap runtime postgresql data dir: %w", err)
+		}
 	}
+	return i.ensureRuntimePostgreSQLArchiving(ctx, dataDir)
+}
 
-	initdbBin := filepath.Join(runtimeDir, "bin", "initdb")
-	if _, err := i.runner.Run(
-		ctx,
-		"runuser",
-		"-u", "postgres", "--",
-		initdbBin,
-		"-D", dataDir,
-		"-U", "postgres",
-		"--auth-local=trust",
-		"--auth-host=scram-sha-256",
-	); err != nil {
-		return fmt.Errorf("bootstrap runtime postgresql data dir: %w", err)
+// ensureRuntimeMariaDBBinlog enables the binary log for point-in-time
+// recovery. The runtime unit points MARIADB_HOME at the config dir, so
+// mariadbd reads my.cnf from there; the panel purges old binlogs itself.
+func (i *Installer) ensureRuntimeMariaDBBinlog() error {
+	binlogDir := i.runtimePersistentDataDir(runtimeMariaDBBinlogDir)
+	if err := os.MkdirAll(binlogDir, 0o750); err != nil {
+		return fmt.Errorf("create runtime mariadb binlog dir: %w", err)
+	}
+	confDir := i.runtimePersistentDataDir(runtimeMariaDBConfigDir)
+	if err := os.MkdirAll(confDir, 0o750); err != nil {
+		return fmt.Errorf("create runtime mariadb config dir: %w", err)
+	}
+	conf := strings.Join([]string{
+		"# Managed by aiPanel installer.",
+		"[mariadbd]",
+		"log_bin = " + filepath.Join(i.opts.DataDir, "runtime", runtimeMariaDBBinlogDir, "mariadb-bin"),
+		"binlog_format = ROW",
+		"server_id = 1",
+		"",
+	}, "\n")
+	if err := writeTextFile(filepath.Join(confDir, "my.cnf"), conf, 0o640); err != nil {
+		return fmt.Errorf("write runtime mariadb my.cnf: %w", err)
+	}
+	return nil
+}
+
+// ensureRuntimePostgreSQLArchiving enables WAL archiving for point-in-time
+// recovery. Settings live in an included file so postgresql.conf stays
+// operator-owned; the panel prunes the archive against its base backups.
+func (i *Installer) ensureRuntimePostgreSQLArchiving(ctx context.Context, dataDir string) error {
+	archiveDir := i.runtimePersistentDataDir(runtimePostgreSQLWALDir)
+	if err := os.MkdirAll(archiveDir, 0o700); err != nil {
+		return fmt.Errorf("create runtime postgresql wal archive dir: %w", err)
+	}
+	target := filepath.Join(i.opts.DataDir, "runtime", runtimePostgreSQLWALDir)
+	conf := strings.Join([]string{
+		"# Managed by aiPanel installer.",
+		"wal_level = replica",
+		"archive_mode = on",
+		fmt.Sprintf("archive_command = 'test ! -f %s/%%f && cp %%p %s/%%f'", target, target),
+		"archive_timeout = 300",
+		"",
+	}, "\n")
+	confPath := filepath.Join(dataDir, runtimePostgreSQLPITRConf)
+	if err := writeTextFile(confPath, conf, 0o600); err != nil {
+		return fmt.Errorf("write runtime postgresql archiving config: %w", err)
+	}
+	mainConf := filepath.Join(dataDir, "postgresql.conf")
+	include := fmt.Sprintf("include_if_exists = '%s'", runtimePostgreSQLPITRConf)
+	//nolint:gosec // G304: path is derived from installer options.
+	current, err := os.ReadFile(mainConf)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read runtime postgresql.conf: %w", err)
+	}
+	if !strings.Contains(string(current), include) {
+		//nolint:gosec // G304: path is derived from installer options.
+		f, err := os.OpenFile(mainConf, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return fmt.Errorf("open runtime postgresql.conf: %w", err)
+		}
+		_, writeErr := f.WriteString("\n" + include + "\n")
+		closeErr := f.Close()
+		if writeErr != nil {
+			return fmt.Errorf("append runtime postgresql.conf include: %w", writeErr)
+		}
+		if closeErr != nil {
+			return fmt.Errorf("close runtime postgresql.conf: %w", closeErr)
+		}
+	}
+	if _, err := i.runner.Run(ctx, "chown", "-R", "postgres:postgres", archiveDir, confPath, mainConf); err != nil {
+		return fmt.Errorf("set runtime postgresql archiving ownership: %w", err)
 	}
 	return nil
 }
@@ -1710,7 +2522,13 @@ func (i *Installer) resolveRuntimeSourceLock(ctx context.Context) (*RuntimeSourc
 			return nil, fmt.Errorf("validate runtime lock URL: %w", err)
 		}
 		if p := strings.TrimSpace(i.opts.RuntimeLockPath); p != "" {
-			if err := writeBinaryFile(p, payload, 0o644); err != nil {
+			// Custom components and pins only live in the local file;
+			// carry them over so a refreshed lock keeps honouring them.
+			persist := func() error { return writeBinaryFile(p, payload, 0o644) }
+			if local, err := LoadRuntimeSourceLock(p); err == nil && mergeCustomRuntimeComponents(&lock, local) > 0 {
+				persist = func() error { return WriteRuntimeSourceLock(p, &lock) }
+			}
+			if err := persist(); err != nil {
 				return nil, fmt.Errorf("persist runtime lock file: %w", err)
 			}
 		}
@@ -1728,13 +2546,11 @@ func (i *Installer) resolveRuntimeSourceLock(ctx context.Context) (*RuntimeSourc
 	return nil, fmt.Errorf("missing runtime lock path and runtime lock URL")
 }
 
+// runtimeChannel returns the selected channel with the lock pins and
+// RuntimePins applied.
 func (i *Installer) runtimeChannel(lock *RuntimeSourceLock) (RuntimeChannelLock, error) {
 	channelName := strings.ToLower(strings.TrimSpace(i.opts.RuntimeChannel))
-	channel, ok := lock.Channels[channelName]
-	if !ok {
-		return nil, fmt.Errorf("runtime lock does not contain channel %s", channelName)
-	}
-	return channel, nil
+	return lock.PinnedChannel(channelName, i.opts.RuntimePins)
 }
 
 func (i *Installer) downloadRuntimeArtifact(ctx context.Context, artifactURL string) (string, error) {
@@ -1759,6 +2575,16 @@ func (i *Installer) downloadRuntimeArtifact(ctx context.Context, artifactURL str
 	return tmp.Name(), nil
 }
 
+// gnupgHomeCommand exports GNUPGHOME for a signature check and, with a CA
+// bundle configured, has dirmngr trust it for hkps keyserver lookups.
+func (i *Installer) gnupgHomeCommand(gnupgHome string) string {
+	command := "export GNUPGHOME=" + shellQuote(gnupgHome)
+	if bundle := strings.TrimSpace(i.opts.CABundlePath); bundle != "" {
+		command += " && printf '%s' " + shellQuote(cabundle.DirmngrConf(bundle)) + ` > "$GNUPGHOME/dirmngr.conf"`
+	}
+	return command
+}
+
 func writeTempBytes(pattern string, b []byte) (string, error) {
 	tmp, err := os.CreateTemp("", pattern)
 	if err != nil {
@@ -1812,7 +2638,11 @@ func (i *Installer) downloadBytes(ctx context.Context, ref string) ([]byte, erro
 		if resp.StatusCode != http.StatusOK {
 			return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
 		}
-		body, err := io.ReadAll(io.LimitReader(resp.Body, 2<<30))
+		var reader io.Reader = resp.Body
+		if i.progress != nil {
+			reader = &progressReader{r: resp.Body, ref: ref, total: resp.ContentLength, progress: i.progress}
+		}
+		body, err := io.ReadAll(io.LimitReader(reader, 2<<30))
 		if err != nil {
 			return nil, err
 		}
@@ -1839,7 +2669,19 @@ func (i *Installer) downloadBytes(ctx context.Context, ref string) ([]byte, erro
 	return body, nil
 }
 
-func extractArchive(archivePath, destination string) error {
+func extractArchive(archivePath, destination string) error {
+	return extractArchiveAs(archivePath, destination, false)
+}
+
+// extractInstallTree unpacks a prebuilt install tree. Unlike source
+// archives, it keeps world-readable modes and relative symlinks that stay
+// inside destination, since services run as non-root users and shared
+// libraries are versioned through symlinks.
+func extractInstallTree(archivePath, destination string) error {
+	return extractArchiveAs(archivePath, destination, true)
+}
+
+func extractArchiveAs(archivePath, destination string, installTree bool) error {
 	f, err := os.Open(archivePath) //nolint:gosec // Installer reads generated temporary archive path.
 	if err != nil {
 		return err
@@ -1857,9 +2699,9 @@ func extractArchive(archivePath, destination string) error {
 		defer func() {
 			_ = gzr.Close()
 		}()
-		return extractTar(gzr, destination)
+		return extractTar(gzr, destination, installTree)
 	case strings.HasSuffix(archivePath, ".tar"):
-		return extractTar(f, destination)
+		return extractTar(f, destination, installTree)
 	default:
 		return fmt.Errorf("unsupported artifact format for %s", archivePath)
 	}
@@ -1962,7 +2804,7 @@ func shellQuote(value string) string {
 	return "'" + strings.ReplaceAll(value, "'", `'"'"'`) + "'"
 }
 
-func extractTar(r io.Reader, destination string) error {
+func extractTar(r io.Reader, destination string, installTree bool) error {
 	const (
 		maxExtractedBytes     int64 = 4 << 30
 		maxExtractedFileBytes int64 = 1 << 30
@@ -1995,11 +2837,24 @@ func extractTar(r io.Reader, destination string) error {
 		if cleanTarget != filepath.Clean(destination) && !strings.HasPrefix(cleanTarget, cleanDestination) {
 			return fmt.Errorf("archive path traversal detected: %s", header.Name)
 		}
+		if installTree {
+			// Symlinks are extracted too, so an entry must not land behind
+			// one that leads out of destination.
+			if err := checkExtractTarget(destination, cleanTarget); err != nil {
+				return fmt.Errorf("archive entry %s: %w", header.Name, err)
+			}
+		}
 		switch header.Typeflag {
 		case tar.TypeDir:
 			if err := os.MkdirAll(cleanTarget, 0o750); err != nil {
 				return err
 			}
+			if installTree {
+				//nolint:gosec // G302: mode sanitized to max 0755.
+				if err := os.Chmod(cleanTarget, installTreeFileMode(header.FileInfo().Mode(), true)); err != nil {
+					return err
+				}
+			}
 		case tar.TypeReg:
 			if err := os.MkdirAll(filepath.Dir(cleanTarget), 0o750); err != nil {
 				return err
@@ -2022,7 +2877,35 @@ func extractTar(r io.Reader, destination string) error {
 			extractedBytes += written
 
 			mode := secureArchiveFileMode(header.FileInfo().Mode(), false)
-			if err := os.Chmod(cleanTarget, mode); err != nil { //nolint:gosec // G302: mode sanitized to max 0750.
+			if installTree {
+				mode = installTreeFileMode(header.FileInfo().Mode(), false)
+			}
+			if err := os.Chmod(cleanTarget, mode); err != nil { //nolint:gosec // G302: mode sanitized to max 0755.
+				return err
+			}
+		case tar.TypeSymlink:
+			if !installTree {
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(cleanTarget), 0o750); err != nil {
+				return err
+			}
+			// Resolve from the real parent: an earlier symlink may have
+			// moved it relative to where the entry name suggests.
+			root, err := filepath.EvalSymlinks(destination)
+			if err != nil {
+				return err
+			}
+			parent, err := filepath.EvalSymlinks(filepath.Dir(cleanTarget))
+			if err != nil {
+				return err
+			}
+			linkTarget := filepath.Clean(filepath.Join(parent, header.Linkname))
+			if filepath.IsAbs(header.Linkname) ||
+				(linkTarget != root && !strings.HasPrefix(linkTarget, root+string(os.PathSeparator))) {
+				return fmt.Errorf("archive symlink escapes destination: %s -> %s", header.Name, header.Linkname)
+			}
+			if err := os.Symlink(header.Linkname, cleanTarget); err != nil {
 				return err
 			}
 		default:
@@ -2046,6 +2929,42 @@ func secureArchiveFileMode(raw os.FileMode, isDir bool) os.FileMode {
 	return perm
 }
 
+// checkExtractTarget fails when target is an existing symlink or its parent
+// resolves outside destination.
+func checkExtractTarget(destination, target string) error {
+	if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("would overwrite symlink")
+	}
+	root, err := filepath.EvalSymlinks(destination)
+	if err != nil {
+		return err
+	}
+	parent := filepath.Dir(target)
+	for {
+		resolved, err := filepath.EvalSymlinks(parent)
+		if err == nil {
+			if resolved != root && !strings.HasPrefix(resolved, root+string(os.PathSeparator)) {
+				return fmt.Errorf("path escapes destination through a symlink")
+			}
+			return nil
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		parent = filepath.Dir(parent)
+	}
+}
+
+// installTreeFileMode keeps read and execute bits for everyone but drops
+// group/other write and the setuid, setgid and sticky bits.
+func installTreeFileMode(raw os.FileMode, isDir bool) os.FileMode {
+	perm := raw & 0o755
+	if isDir {
+		return perm | 0o700
+	}
+	return perm | 0o600
+}
+
 func renderRuntimeSystemdUnit(opts Options, componentName string, component RuntimeComponentLock) string {
 	unit := component.Systemd
 	desc := strings.TrimSpace(unit.Description)
@@ -2099,6 +3018,9 @@ func renderRuntimeSystemdUnit(opts Options, componentName string, component Runt
 	if componentName == "php-fpm" {
 		lines = append(lines, "RuntimeDirectory=php")
 	}
+	if componentName == "mariadb" {
+		lines = append(lines, "Environment=MARIADB_HOME="+filepath.Join(opts.DataDir, "runtime", runtimeMariaDBConfigDir))
+	}
 	if strings.TrimSpace(execReload) != "" {
 		lines = append(lines, "ExecReload="+execReload)
 	}
@@ -2139,7 +3061,7 @@ func (i *Installer) prepareDirectories(_ context.Context) error {
 		filepath.Dir(i.opts.ReportFilePath):  {},
 		filepath.Dir(i.opts.LogFilePath):     {},
 	}
-	if isRuntimeSourceMode(i.opts.InstallMode) {
+	if usesRuntimeLock(i.opts.InstallMode) {
 		dirs[filepath.Dir(i.opts.RuntimeInstallDir)] = struct{}{}
 		dirs[i.opts.RuntimeInstallDir] = struct{}{}
 	}
@@ -2151,7 +3073,7 @@ func (i *Installer) prepareDirectories(_ context.Context) error {
 		}
 		mode := os.FileMode(0o750)
 		cleanDir := filepath.Clean(dir)
-		if isRuntimeSourceMode(i.opts.InstallMode) &&
+		if usesRuntimeLock(i.opts.InstallMode) &&
 			(cleanDir == runtimeRootDir || cleanDir == runtimeParentDir) {
 			mode = 0o755
 		}
@@ -2234,6 +3156,19 @@ func (i *Installer) writeConfig(_ context.Context) error {
 	if err := i.writeInstallerTemplates(); err != nil {
 		return err
 	}
+	return i.writeReleaseKey()
+}
+
+// writeReleaseKey installs the release signing key this build carries,
+// which aipanel update self verifies new releases against.
+func (i *Installer) writeReleaseKey() error {
+	written, err := selfupdate.WriteReleaseKey(pathInRootFS(i.opts.RootFSPath, selfupdate.DefaultReleaseKeyPath))
+	if err != nil {
+		return err
+	}
+	if !written {
+		i.logf("[write_config] this build carries no release key; aipanel update self stays unavailable")
+	}
 	return nil
 }
 
@@ -2251,11 +3186,19 @@ func (i *Installer) writeInstallerTemplates() error {
 		defaultPHPFPMPoolTemplate: sitePHPFPMPoolTemplateBody,
 		panelTemplatePath:         panelVhostTemplateBody,
 		catchallTemplatePath:      catchallTemplateBody,
+		defaultCatchallLanding:    catchallLandingTemplateBody,
 	}
+	// Templates edited by the operator are kept; the store records the
+	// shipped version so the edits can be merged with it later.
+	store := templates.New(pathInRootFS(i.opts.RootFSPath, defaultTemplateDir))
 	for path, body := range templateFiles {
 		target := pathInRootFS(i.opts.RootFSPath, path)
-		if err := writeTextFile(target, body, 0o644); err != nil {
-			return fmt.Errorf("write template %s: %w", path, err)
+		outcome, err := store.Install(filepath.Base(path), target, body)
+		if err != nil {
+			return err
+		}
+		if outcome == templates.Drifted {
+			i.logf("[templates] WARNING: %s has local edits made against an older release; they were kept, review and merge them via /api/settings/templates", path)
 		}
 	}
 	return nil
@@ -2305,17 +3248,38 @@ func (i *Installer) initDatabases(ctx context.Context) error {
 	return nil
 }
 
+// catchallTemplateData renders the catch-all vhost and landing page; the
+// hosting module renders them with the same fields.
+type catchallTemplateData struct {
+	Mode        string
+	PanelURL    string
+	LandingRoot string
+}
+
 type panelVhostTemplateData struct {
-	PanelPort     string
-	PanelHost     string
-	PHPVersion    string
-	ACMEWebroot   string
-	EnableTLS     bool
-	TLSCertPath   string
-	TLSKeyPath    string
-	EnablePGAdmin bool
-	PGAdminPath   string
-	PGAdminPort   string
+	PanelPort      string
+	PanelUpstream  string
+	PanelHost      string
+	PHPVersion     string
+	ACMEWebroot    string
+	EnableTLS      bool
+	TLSCertPath    string
+	TLSKeyPath     string
+	PHPMyAdminPath string
+	PHPMyAdminDir  string
+	EnablePGAdmin  bool
+	PGAdminPath    string
+	PGAdminPort    string
+	EnableAdminer  bool
+	AdminerPath    string
+	AdminerDir     string
+	// Roundcube is served under RoundcubePath from RoundcubeDir/public_html.
+	EnableRoundcube bool
+	RoundcubePath   string
+	RoundcubeDir    string
+	// AdminToolsAllow restricts admin tool routes to these addresses on top
+	// of the panel session check; empty allows any address.
+	AdminToolsAllow []string
 }
 
 func (i *Installer) configureNginx(ctx context.Context) error {
@@ -2342,9 +3306,18 @@ func (i *Installer) configureNginx(ctx context.Context) error {
 	if acmeWebroot == "" {
 		acmeWebroot = defaultLetsEncryptWebroot
 	}
-	pgAdminPath := normalizeWebSubpath(i.opts.PGAdminRoutePath, defaultPGAdminRoutePath)
+	routes, err := i.adminRoutes()
+	if err != nil {
+		return fmt.Errorf("resolve admin tool routes: %w", err)
+	}
+	pgAdminPath := routes[AdminToolPGAdmin]
 	pgAdminPort := parsePort(i.opts.PGAdminListenAddr, "5050")
 	enablePGAdmin := i.isPGAdminInstalled()
+	if enablePGAdmin {
+		if err := i.syncPGAdminUnitRoute(ctx, pgAdminPath); err != nil {
+			return err
+		}
+	}
 	enableTLS := false
 	tlsCertPath := ""
 	tlsKeyPath := ""
@@ -2361,24 +3334,55 @@ func (i *Installer) configureNginx(ctx context.Context) error {
 	panelTemplatePath := pathInRootFS(i.opts.RootFSPath, i.opts.PanelVhostTemplatePath)
 	catchallTemplatePath := pathInRootFS(i.opts.RootFSPath, i.opts.CatchAllTemplatePath)
 	panelContent, err := renderTemplateFile(panelTemplatePath, panelVhostTemplateData{
-		PanelPort:     panelPort,
-		PanelHost:     panelHost,
-		PHPVersion:    phpVersion,
-		ACMEWebroot:   acmeWebroot,
-		EnableTLS:     enableTLS,
-		TLSCertPath:   tlsCertPath,
-		TLSKeyPath:    tlsKeyPath,
-		EnablePGAdmin: enablePGAdmin,
-		PGAdminPath:   pgAdminPath,
-		PGAdminPort:   pgAdminPort,
+		PanelPort:       panelPort,
+		PanelUpstream:   panelUpstream(i.opts.Addr),
+		PanelHost:       panelHost,
+		PHPVersion:      phpVersion,
+		ACMEWebroot:     acmeWebroot,
+		EnableTLS:       enableTLS,
+		TLSCertPath:     tlsCertPath,
+		TLSKeyPath:      tlsKeyPath,
+		PHPMyAdminPath:  routes[AdminToolPHPMyAdmin],
+		PHPMyAdminDir:   strings.TrimRight(strings.TrimSpace(i.opts.PHPMyAdminInstallDir), "/"),
+		EnablePGAdmin:   enablePGAdmin,
+		PGAdminPath:     pgAdminPath,
+		PGAdminPort:     pgAdminPort,
+		EnableAdminer:   i.isAdminerInstalled(),
+		AdminerPath:     routes[AdminToolAdminer],
+		AdminerDir:      strings.TrimRight(strings.TrimSpace(i.opts.AdminerInstallDir), "/"),
+		EnableRoundcube: i.isRoundcubeInstalled(),
+		RoundcubePath:   normalizeWebSubpath(i.opts.RoundcubeRoutePath, defaultRoundcubeRoutePath),
+		RoundcubeDir:    strings.TrimRight(strings.TrimSpace(i.opts.RoundcubeInstallDir), "/"),
+		AdminToolsAllow: i.opts.AdminToolsAllow,
 	})
 	if err != nil {
 		return fmt.Errorf("render panel vhost template: %w", err)
 	}
-	catchallContent, err := renderTemplateFile(catchallTemplatePath, nil)
+	catchallData := catchallTemplateData{Mode: i.opts.CatchAllMode, LandingRoot: defaultCatchallRoot}
+	if panelHost != "_" {
+		catchallData.PanelURL = "http://" + panelHost
+		if enableTLS {
+			catchallData.PanelURL = "https://" + panelHost
+		}
+	}
+	catchallContent, err := renderTemplateFile(catchallTemplatePath, catchallData)
 	if err != nil {
 		return fmt.Errorf("render catchall template: %w", err)
 	}
+	if catchallData.Mode == config.CatchAllLanding {
+		landing, err := renderTemplateFile(pathInRootFS(i.opts.RootFSPath, defaultCatchallLanding), catchallData)
+		if err != nil {
+			return fmt.Errorf("render catchall landing page: %w", err)
+		}
+		landingRoot := pathInRootFS(i.opts.RootFSPath, defaultCatchallRoot)
+		// nginx workers read the page, so the directory stays world-readable.
+		if err := os.MkdirAll(landingRoot, 0o755); err != nil { //nolint:gosec // G301
+			return fmt.Errorf("create catchall landing root: %w", err)
+		}
+		if err := writeTextFile(filepath.Join(landingRoot, "index.html"), landing, 0o644); err != nil {
+			return fmt.Errorf("write catchall landing page: %w", err)
+		}
+	}
 
 	availDir := i.opts.NginxSitesAvailableDir
 	enableDir := i.opts.NginxSitesEnabledDir
@@ -2432,6 +3436,36 @@ func (i *Installer) configureNginx(ctx context.Context) error {
 	return nil
 }
 
+// ConfigurePanelDomain serves the panel on PanelDomain through nginx and,
+// with EnableLetsEncrypt, issues its certificate, then records the domain
+// in the panel config. It backs the setup wizard and
+// `aipanel panel set-domain`.
+func (i *Installer) ConfigurePanelDomain(ctx context.Context) error {
+	if err := i.ensureRootPrivileges(); err != nil {
+		return err
+	}
+	domain := strings.TrimSpace(i.opts.PanelDomain)
+	if !i.opts.ReverseProxy || domain == "" || domain == "_" {
+		return fmt.Errorf("panel domain requires reverse proxy mode and a domain name")
+	}
+	if i.opts.EnableLetsEncrypt {
+		if err := i.configureTLS(ctx); err != nil {
+			return err
+		}
+	} else if err := i.configureNginx(ctx); err != nil {
+		return err
+	}
+	keys := map[string]string{"panel_domain": domain}
+	if i.opts.EnableLetsEncrypt {
+		keys["acme_email"] = strings.TrimSpace(i.opts.LetsEncryptEmail)
+	}
+	if err := config.SetFileKeys(i.opts.ConfigPath, keys); err != nil {
+		return fmt.Errorf("record panel domain: %w", err)
+	}
+	i.logf("[panel_domain] panel served on %s (tls=%t)", domain, i.opts.EnableLetsEncrypt)
+	return nil
+}
+
 func (i *Installer) configureTLS(ctx context.Context) error {
 	if !i.opts.EnableLetsEncrypt {
 		i.logf("[configure_tls] skipped (letsencrypt disabled)")
@@ -2486,6 +3520,9 @@ func (i *Installer) configureTLS(ctx context.Context) error {
 		"--non-interactive",
 		"--keep-until-expiring",
 	}
+	if i.opts.LetsEncryptStaging {
+		certbotArgs = append(certbotArgs, "--staging")
+	}
 	if _, err := i.runner.Run(ctx, "certbot", certbotArgs...); err != nil {
 		return fmt.Errorf("issue letsencrypt certificate: %w", err)
 	}
@@ -2554,6 +3591,8 @@ func (i *Installer) installPHPMyAdmin(ctx context.Context) error {
 	}
 
 	installDir := pathInRootFS(i.opts.RootFSPath, i.opts.PHPMyAdminInstallDir)
+	version := strings.TrimSpace(i.opts.PHPMyAdminVersion)
+	replace := false
 	if info, err := os.Stat(installDir); err == nil {
 		if !info.IsDir() {
 			return fmt.Errorf("phpMyAdmin install path is not a directory: %s", installDir)
@@ -2563,8 +3602,13 @@ func (i *Installer) installPHPMyAdmin(ctx context.Context) error {
 			return fmt.Errorf("inspect phpMyAdmin install dir: %w", readErr)
 		}
 		if hasEntries {
-			i.logf("[install_phpmyadmin] existing installation detected at %s, keeping as-is", installDir)
-			return i.ensurePHPMyAdminPermissions(ctx, installDir)
+			installed, _ := ReadAdminToolVersion(installDir)
+			if !i.opts.UpgradeAdminTools || installed.Version == version {
+				i.logf("[install_phpmyadmin] existing installation detected at %s, keeping as-is", installDir)
+				return i.ensurePHPMyAdminPermissions(ctx, installDir)
+			}
+			i.logf("[install_phpmyadmin] upgrading %s from %q to %s", installDir, installed.Version, version)
+			replace = true
 		}
 	} else if !os.IsNotExist(err) {
 		return fmt.Errorf("inspect phpMyAdmin install dir: %w", err)
@@ -2574,13 +3618,18 @@ func (i *Installer) installPHPMyAdmin(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("download phpMyAdmin archive: %w", err)
 	}
-	checksumData, err := i.downloadBytes(ctx, i.opts.PHPMyAdminSHA256URL)
-	if err != nil {
-		return fmt.Errorf("download phpMyAdmin checksum: %w", err)
-	}
-	expectedChecksum, err := parseSHA256Checksum(checksumData)
-	if err != nil {
-		return fmt.Errorf("parse phpMyAdmin checksum: %w", err)
+	// A pinned digest is checked first; the upstream .sha256 file guards
+	// against a tampered mirror when no digest is pinned.
+	expectedChecksum := strings.TrimSpace(i.opts.PHPMyAdminSHA256)
+	if expectedChecksum == "" {
+		checksumData, err := i.downloadBytes(ctx, i.opts.PHPMyAdminSHA256URL)
+		if err != nil {
+			return fmt.Errorf("download phpMyAdmin checksum: %w", err)
+		}
+		expectedChecksum, err = parseSHA256Checksum(checksumData)
+		if err != nil {
+			return fmt.Errorf("parse phpMyAdmin checksum: %w", err)
+		}
 	}
 	actualChecksum := fmt.Sprintf("%x", sha256.Sum256(archiveData))
 	if !strings.EqualFold(expectedChecksum, actualChecksum) {
@@ -2599,6 +3648,13 @@ func (i *Installer) installPHPMyAdmin(ctx context.Context) error {
 	defer func() {
 		_ = os.Remove(archivePath)
 	}()
+	if i.opts.VerifyUpstreamSources &&
+		strings.TrimSpace(i.opts.PHPMyAdminSignatureURL) != "" &&
+		strings.TrimSpace(i.opts.PHPMyAdminFingerprint) != "" {
+		if err := i.verifyUpstreamSignature(ctx, "phpMyAdmin", archivePath, i.opts.PHPMyAdminSignatureURL, i.opts.PHPMyAdminFingerprint); err != nil {
+			return err
+		}
+	}
 
 	extractDir, err := os.MkdirTemp("", "aipanel-phpmyadmin-*")
 	if err != nil {
@@ -2624,9 +3680,20 @@ func (i *Installer) installPHPMyAdmin(ctx context.Context) error {
 	if err := os.MkdirAll(filepath.Dir(installDir), 0o750); err != nil {
 		return fmt.Errorf("create phpMyAdmin parent dir: %w", err)
 	}
-	if err := copyDirectory(sourceDir, installDir); err != nil {
+	if replace {
+		if err := replacePHPMyAdmin(sourceDir, installDir); err != nil {
+			return err
+		}
+	} else if err := copyDirectory(sourceDir, installDir); err != nil {
 		return fmt.Errorf("copy phpMyAdmin files: %w", err)
 	}
+	if err := writeAdminToolVersion(installDir, AdminToolVersion{
+		Name:    AdminToolPHPMyAdmin,
+		Version: version,
+		SHA256:  actualChecksum,
+	}); err != nil {
+		return err
+	}
 	if err := i.ensurePHPMyAdminPermissions(ctx, installDir); err != nil {
 		return err
 	}
@@ -2652,6 +3719,50 @@ func (i *Installer) ensurePHPMyAdminPermissions(ctx context.Context, installDir
 	return nil
 }
 
+// installAdminer installs the single-file Adminer release as index.php of
+// AdminerInstallDir. nginx only serves it to requests that carry a valid
+// panel session, checked with an auth_request subrequest to the panel.
+func (i *Installer) installAdminer(ctx context.Context) error {
+	if i.opts.SkipAdminer && !strings.EqualFold(i.opts.OnlyStep, steps.InstallAdminer) {
+		i.logf("[install_adminer] skipped by configuration")
+		return nil
+	}
+
+	data, err := i.downloadBytes(ctx, i.opts.AdminerURL)
+	if err != nil {
+		return fmt.Errorf("download adminer: %w", err)
+	}
+	expectedChecksum := strings.TrimSpace(i.opts.AdminerSHA256)
+	actualChecksum := fmt.Sprintf("%x", sha256.Sum256(data))
+	if !strings.EqualFold(expectedChecksum, actualChecksum) {
+		return fmt.Errorf("adminer checksum mismatch: expected %s got %s", expectedChecksum, actualChecksum)
+	}
+	i.logf("[install_adminer] checksum verified: %s", actualChecksum)
+
+	installDir := pathInRootFS(i.opts.RootFSPath, i.opts.AdminerInstallDir)
+	if err := os.MkdirAll(installDir, 0o750); err != nil {
+		return fmt.Errorf("create adminer install dir: %w", err)
+	}
+	if err := writeBinaryFile(filepath.Join(installDir, "index.php"), data, 0o640); err != nil {
+		return fmt.Errorf("write adminer: %w", err)
+	}
+	if err := writeAdminToolVersion(installDir, AdminToolVersion{
+		Name:    AdminToolAdminer,
+		Version: strings.TrimSpace(i.opts.AdminerVersion),
+		SHA256:  actualChecksum,
+	}); err != nil {
+		return err
+	}
+	if _, err := i.runner.Run(ctx, "chown", "-R", "root:www-data", installDir); err != nil {
+		return fmt.Errorf("set adminer ownership: %w", err)
+	}
+	if err := i.configureNginx(ctx); err != nil {
+		return fmt.Errorf("configure nginx for adminer: %w", err)
+	}
+	i.logf("[install_adminer] installed at %s", installDir)
+	return nil
+}
+
 func (i *Installer) installPGAdmin(ctx context.Context) error {
 	if i.opts.SkipPGAdmin && !strings.EqualFold(i.opts.OnlyStep, steps.InstallPGAdmin) {
 		i.logf("[install_pgadmin] skipped by configuration")
@@ -2719,7 +3830,7 @@ func (i *Installer) installPGAdmin(ctx context.Context) error {
 	}
 	wheelFileName := filepath.Base(strings.TrimSpace(wheelURL.Path))
 	if !strings.HasSuffix(strings.ToLower(wheelFileName), ".whl") {
-		wheelFileName = "pgadmin4-9.12-py3-none-any.whl"
+		wheelFileName = "pgadmin4-" + strings.TrimSpace(i.opts.PGAdminVersion) + "-py3-none-any.whl"
 	}
 	wheelTempDir, mkErr := os.MkdirTemp("", "aipanel-pgadmin-wheel-*")
 	if mkErr != nil {
@@ -2736,7 +3847,7 @@ func (i *Installer) installPGAdmin(ctx context.Context) error {
 	if i.opts.VerifyUpstreamSources &&
 		strings.TrimSpace(i.opts.PGAdminSignatureURL) != "" &&
 		strings.TrimSpace(i.opts.PGAdminFingerprint) != "" {
-		if err := i.verifyPGAdminSignature(ctx, wheelPath); err != nil {
+		if err := i.verifyUpstreamSignature(ctx, "pgAdmin", wheelPath, i.opts.PGAdminSignatureURL, i.opts.PGAdminFingerprint); err != nil {
 			return err
 		}
 	}
@@ -2811,16 +3922,27 @@ func (i *Installer) installPGAdmin(ctx context.Context) error {
 		i.logf("[install_pgadmin] admin user %s already exists", adminEmail)
 	}
 
+	if err := writeAdminToolVersion(installDir, AdminToolVersion{
+		Name:    AdminToolPGAdmin,
+		Version: strings.TrimSpace(i.opts.PGAdminVersion),
+		SHA256:  actualChecksum,
+	}); err != nil {
+		return err
+	}
 	if _, err := i.runner.Run(ctx, "chown", "-R", "aipanel:aipanel", installDir, venvDir, dataDir); err != nil {
 		return fmt.Errorf("set pgAdmin ownership: %w", err)
 	}
 
+	routes, err := i.adminRoutes()
+	if err != nil {
+		return fmt.Errorf("resolve pgAdmin route: %w", err)
+	}
 	unitPath := pathInRootFS(i.opts.RootFSPath, filepath.Join("/etc/systemd/system", defaultPGAdminUnitName))
 	unitContent := renderPGAdminUnit(
 		installDir,
 		venvDir,
 		strings.TrimSpace(i.opts.PGAdminListenAddr),
-		normalizeWebSubpath(i.opts.PGAdminRoutePath, defaultPGAdminRoutePath),
+		routes[AdminToolPGAdmin],
 	)
 	if err := writeTextFile(unitPath, unitContent, 0o644); err != nil {
 		return fmt.Errorf("write pgAdmin systemd unit: %w", err)
@@ -2828,6 +3950,9 @@ func (i *Installer) installPGAdmin(ctx context.Context) error {
 	if err := systemd.DaemonReload(ctx, i.runner); err != nil {
 		return fmt.Errorf("systemd daemon-reload for pgAdmin: %w", err)
 	}
+	if err := i.reservePort(ctx, portOwnerPGAdmin, defaultPGAdminUnitName, i.opts.PGAdminListenAddr); err != nil {
+		return err
+	}
 	if err := systemd.EnableNow(ctx, i.runner, defaultPGAdminUnitName); err != nil {
 		return fmt.Errorf("start pgAdmin service: %w", err)
 	}
@@ -2839,6 +3964,42 @@ func (i *Installer) installPGAdmin(ctx context.Context) error {
 	return nil
 }
 
+// syncPGAdminUnitRoute rewrites the pgAdmin unit when its SCRIPT_NAME no
+// longer matches routePath, so a route changed after install keeps the
+// generated links in step with nginx.
+func (i *Installer) syncPGAdminUnitRoute(ctx context.Context, routePath string) error {
+	unitPath := pathInRootFS(i.opts.RootFSPath, filepath.Join("/etc/systemd/system", defaultPGAdminUnitName))
+	// Unit path is fixed by the installer.
+	//nolint:gosec // G304
+	current, err := os.ReadFile(unitPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read pgAdmin systemd unit: %w", err)
+	}
+	if strings.Contains(string(current), "Environment=SCRIPT_NAME="+routePath+"\n") {
+		return nil
+	}
+	unitContent := renderPGAdminUnit(
+		pathInRootFS(i.opts.RootFSPath, i.opts.PGAdminInstallDir),
+		pathInRootFS(i.opts.RootFSPath, i.opts.PGAdminVenvDir),
+		strings.TrimSpace(i.opts.PGAdminListenAddr),
+		routePath,
+	)
+	if err := writeTextFile(unitPath, unitContent, 0o644); err != nil {
+		return fmt.Errorf("write pgAdmin systemd unit: %w", err)
+	}
+	if err := systemd.DaemonReload(ctx, i.runner); err != nil {
+		return fmt.Errorf("systemd daemon-reload for pgAdmin: %w", err)
+	}
+	if err := systemd.Restart(ctx, i.runner, defaultPGAdminUnitName); err != nil {
+		return fmt.Errorf("restart pgAdmin service: %w", err)
+	}
+	i.logf("[configure_nginx] pgAdmin route changed to %s", routePath)
+	return nil
+}
+
 func (i *Installer) ensurePGAdminPrerequisites(ctx context.Context) error {
 	// pgAdmin dependencies (notably gssapi/psycopg[c]) may need native headers/tools on Debian 13.
 	packages := []string{
@@ -2858,37 +4019,39 @@ func (i *Installer) ensurePGAdminPrerequisites(ctx context.Context) error {
 	return nil
 }
 
-func (i *Installer) verifyPGAdminSignature(ctx context.Context, archivePath string) error {
-	signatureData, err := i.downloadBytes(ctx, strings.TrimSpace(i.opts.PGAdminSignatureURL))
+// verifyUpstreamSignature checks a detached OpenPGP signature of an admin
+// tool artifact against the pinned release key fingerprint.
+func (i *Installer) verifyUpstreamSignature(ctx context.Context, label, archivePath, signatureURL, fingerprint string) error {
+	signatureData, err := i.downloadBytes(ctx, strings.TrimSpace(signatureURL))
 	if err != nil {
-		return fmt.Errorf("download pgAdmin signature: %w", err)
+		return fmt.Errorf("download %s signature: %w", label, err)
 	}
-	signaturePath, err := writeTempBytes("aipanel-pgadmin-signature-*", signatureData)
+	signaturePath, err := writeTempBytes("aipanel-admintool-signature-*", signatureData)
 	if err != nil {
-		return fmt.Errorf("write pgAdmin signature: %w", err)
+		return fmt.Errorf("write %s signature: %w", label, err)
 	}
 	defer func() {
 		_ = os.Remove(signaturePath)
 	}()
 
-	gnupgHome, err := os.MkdirTemp("", "aipanel-pgadmin-gpg-*")
+	gnupgHome, err := os.MkdirTemp("", "aipanel-admintool-gpg-*")
 	if err != nil {
-		return fmt.Errorf("create pgAdmin gpg home: %w", err)
+		return fmt.Errorf("create %s gpg home: %w", label, err)
 	}
 	defer func() {
 		_ = os.RemoveAll(gnupgHome)
 	}()
 
-	fingerprint := strings.TrimSpace(i.opts.PGAdminFingerprint)
+	fingerprint = strings.TrimSpace(fingerprint)
 	commands := []string{
-		"export GNUPGHOME=" + shellQuote(gnupgHome),
+		i.gnupgHomeCommand(gnupgHome),
 		"gpg --batch --keyserver hkps://keys.openpgp.org --recv-keys " + shellQuote(fingerprint) + " || true",
 		"if ! gpg --batch --list-keys --with-colons 2>/dev/null | grep -iq " + shellQuote(fingerprint) + "; then " +
 			"gpg --batch --keyserver hkps://keyserver.ubuntu.com --recv-keys " + shellQuote(fingerprint) + "; fi",
 		"gpg --batch --verify " + shellQuote(signaturePath) + " " + shellQuote(archivePath),
 	}
 	if _, err := i.runner.Run(ctx, "bash", "-lc", strings.Join(commands, " && ")); err != nil {
-		return fmt.Errorf("verify upstream signature for pgAdmin: %w", err)
+		return fmt.Errorf("verify upstream signature for %s: %w", label, err)
 	}
 	return nil
 }
@@ -2959,6 +4122,21 @@ func (i *Installer) createAdminUser(ctx context.Context) error {
 		return fmt.Errorf("init sqlite before create admin: %w", err)
 	}
 	iamSvc := iam.NewService(store, cfg, logger.New(cfg.Env))
+	if i.opts.SetupWizard {
+		required, err := iamSvc.SetupRequired(ctx)
+		if err != nil {
+			return err
+		}
+		if !required {
+			i.logf("[create_admin] an admin already exists; setup wizard not armed")
+			return nil
+		}
+		if err := iam.WriteSetupToken(i.opts.DataDir, i.opts.SetupToken); err != nil {
+			return err
+		}
+		i.logf("[create_admin] setup wizard armed; the first admin is created at /setup")
+		return nil
+	}
 	email := strings.TrimSpace(i.opts.AdminEmail)
 	password := strings.TrimSpace(i.opts.AdminPassword)
 	if email == "" {
@@ -2970,6 +4148,7 @@ func (i *Installer) createAdminUser(ctx context.Context) error {
 			return fmt.Errorf("generate admin password: %w", err)
 		}
 		password = generated
+		i.secrets.add(password)
 	}
 	if err := iamSvc.CreateAdmin(ctx, email, password); err != nil {
 		// Idempotent reruns can fail with unique email conflict.
@@ -2980,7 +4159,9 @@ func (i *Installer) createAdminUser(ctx context.Context) error {
 		return fmt.Errorf("create admin user: %w", err)
 	}
 	if strings.TrimSpace(i.opts.AdminPassword) == "" {
-		i.logf("[create_admin] generated admin credentials email=%s password=%s", email, password)
+		// Only the terminal gets the password; install logs get shared in bug reports.
+		i.logf("[create_admin] generated admin password for %s (shown on the terminal, not logged)", email)
+		_, _ = fmt.Fprintf(os.Stderr, "admin login: %s / %s (generated; change it after the first login)\n", email, password)
 	}
 	return nil
 }
@@ -2990,6 +4171,14 @@ func (i *Installer) writeUnitFile(_ context.Context) error {
 	if err := writeTextFile(i.opts.UnitFilePath, content, 0o600); err != nil {
 		return fmt.Errorf("write unit file: %w", err)
 	}
+	socketUnitPath := panelSocketUnitPath(i.opts.UnitFilePath)
+	if socketContent, ok := renderSystemdSocketUnit(i.opts); ok {
+		if err := writeTextFile(socketUnitPath, socketContent, 0o600); err != nil {
+			return fmt.Errorf("write socket unit file: %w", err)
+		}
+	} else if err := os.Remove(socketUnitPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove stale socket unit file: %w", err)
+	}
 	return nil
 }
 
@@ -2997,6 +4186,16 @@ func (i *Installer) startPanelService(ctx context.Context) error {
 	if err := systemd.DaemonReload(ctx, i.runner); err != nil {
 		return fmt.Errorf("systemd daemon-reload: %w", err)
 	}
+	for _, addr := range append([]string{i.opts.Addr}, i.opts.ListenAddrs...) {
+		if err := i.reservePort(ctx, portOwnerPanel, "aipanel", addr); err != nil {
+			return err
+		}
+	}
+	if _, unixAddr := config.UnixSocketPath(i.opts.Addr); unixAddr {
+		if err := systemd.EnableNow(ctx, i.runner, "aipanel.socket"); err != nil {
+			return fmt.Errorf("start aipanel socket: %w", err)
+		}
+	}
 	if err := systemd.EnableNow(ctx, i.runner, "aipanel"); err != nil {
 		return fmt.Errorf("start aipanel service: %w", err)
 	}
@@ -3015,7 +4214,7 @@ func (i *Installer) runHealthcheck(ctx context.Context) error {
 	defer cancel()
 
 	url := healthURL(i.opts.Addr)
-	client := &http.Client{Timeout: 2 * time.Second}
+	client := healthClient(i.opts.Addr)
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
@@ -3120,7 +4319,7 @@ func (i *Installer) writeReport(report *Report) error {
 
 func (i *Installer) logf(format string, args ...any) {
 	ts := i.now().UTC().Format(time.RFC3339)
-	message := fmt.Sprintf(format, args...)
+	message := i.secrets.apply(fmt.Sprintf(format, args...))
 	lines := strings.Split(strings.TrimSuffix(message, "\n"), "\n")
 
 	var file io.Writer
@@ -3137,7 +4336,11 @@ func (i *Installer) logf(format string, args ...any) {
 
 	for _, line := range lines {
 		entry := fmt.Sprintf("%s %s\n", ts, line)
-		_, _ = os.Stderr.WriteString(entry)
+		if i.progress != nil {
+			i.progress.Log(line)
+		} else {
+			_, _ = os.Stderr.WriteString(entry)
+		}
 		if file != nil {
 			_, _ = io.WriteString(file, entry)
 		}
@@ -3182,7 +4385,13 @@ func isDebian13(release map[string]string) bool {
 	return id == "debian" && (codename == "trixie" || versionID == "13")
 }
 
-func totalMemoryMB(memInfoPath string) (int, error) {
+// TotalMemoryMB returns MemTotal from a meminfo file in MB.
+func TotalMemoryMB(memInfoPath string) (int, error) {
+	return meminfoMB(memInfoPath, "MemTotal")
+}
+
+// meminfoMB returns one kB field of /proc/meminfo in MB.
+func meminfoMB(memInfoPath, key string) (int, error) {
 	// Installer controls meminfo path in runtime options.
 	//nolint:gosec // G304
 	f, err := os.Open(memInfoPath)
@@ -3195,12 +4404,12 @@ func totalMemoryMB(memInfoPath string) (int, error) {
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
 		line := scanner.Text()
-		if !strings.HasPrefix(line, "MemTotal:") {
+		if !strings.HasPrefix(line, key+":") {
 			continue
 		}
 		fields := strings.Fields(line)
 		if len(fields) < 2 {
-			return 0, fmt.Errorf("invalid MemTotal line")
+			return 0, fmt.Errorf("invalid %s line", key)
 		}
 		kb, err := strconv.Atoi(fields[1])
 		if err != nil {
@@ -3211,7 +4420,7 @@ func totalMemoryMB(memInfoPath string) (int, error) {
 	if err := scanner.Err(); err != nil {
 		return 0, err
 	}
-	return 0, fmt.Errorf("MemTotal not found")
+	return 0, fmt.Errorf("%s not found", key)
 }
 
 func freeDiskGB(rootPath string) (int, error) {
@@ -3241,6 +4450,9 @@ func freeDiskGB(rootPath string) (int, error) {
 }
 
 func healthURL(addr string) string {
+	if _, ok := config.UnixSocketPath(addr); ok {
+		return "http://unix/health"
+	}
 	host := "127.0.0.1"
 	port := "8080"
 
@@ -3262,6 +4474,33 @@ func healthURL(addr string) string {
 	return fmt.Sprintf("http://%s/health", net.JoinHostPort(host, port))
 }
 
+// healthClient returns an HTTP client that dials the panel unix socket when addr uses "unix:".
+func healthClient(addr string) *http.Client {
+	client := &http.Client{Timeout: 2 * time.Second}
+	socketPath, ok := config.UnixSocketPath(addr)
+	if !ok {
+		return client
+	}
+	// Clone the default transport so it keeps the roots cabundle.Apply
+	// added; only the dial goes to the socket.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", socketPath)
+	}
+	client.Transport = transport
+	return client
+}
+
+// panelUpstream returns the nginx proxy_pass target for the panel listen address.
+func panelUpstream(addr string) string {
+	if socketPath, ok := config.UnixSocketPath(addr); ok {
+		return fmt.Sprintf("http://unix:%s:", socketPath)
+	}
+	return fmt.Sprintf("http://127.0.0.1:%s", parsePort(addr, "8080"))
+}
+
 func parsePort(addr, fallback string) string {
 	if strings.TrimSpace(addr) == "" {
 		return fallback
@@ -3286,6 +4525,15 @@ func stringSliceContains(values []string, needle string) bool {
 	return false
 }
 
+func isIPOrCIDR(value string) bool {
+	value = strings.TrimSpace(value)
+	if net.ParseIP(value) != nil {
+		return true
+	}
+	_, _, err := net.ParseCIDR(value)
+	return err == nil
+}
+
 func normalizeWebSubpath(path, fallback string) string {
 	cleaned := strings.TrimSpace(path)
 	if cleaned == "" {
@@ -3298,6 +4546,10 @@ func normalizeWebSubpath(path, fallback string) string {
 	return cleaned
 }
 
+func (i *Installer) isAdminerInstalled() bool {
+	return fileExists(filepath.Join(pathInRootFS(i.opts.RootFSPath, i.opts.AdminerInstallDir), "index.php"))
+}
+
 func (i *Installer) isPGAdminInstalled() bool {
 	installDir := pathInRootFS(i.opts.RootFSPath, i.opts.PGAdminInstallDir)
 	entrypoint := filepath.Join(installDir, "pgadmin4", "pgAdmin4.py")
@@ -3339,23 +4591,101 @@ func randomPassword() (string, error) {
 	return hex.EncodeToString(buf), nil
 }
 
-const siteVhostTemplateBody = `server {
+const siteVhostTemplateBody = `{{- if .PageCacheZone -}}
+fastcgi_cache_path {{ .PageCacheDir }} levels=1:2 keys_zone={{ .PageCacheZone }}:10m inactive=1h use_temp_path=off;
+
+{{ end -}}
+{{- range .RedirectHosts -}}
+server {
+    listen 80;
+    server_name {{ . }};
+    return 301 $scheme://{{ $.ServerName }}$request_uri;
+}
+
+{{ end -}}
+server {
     listen 80;
-    server_name {{ .Domain }};
+    server_name {{ .ServerName }};
+{{- if .VarnishAddr }}
+
+    access_log /var/log/nginx/{{ .Domain }}.access.log;
+    error_log /var/log/nginx/{{ .Domain }}.error.log;
+
+    location / {
+        proxy_pass http://{{ .VarnishAddr }};
+        proxy_set_header Host $host;
+        proxy_set_header X-Real-IP $remote_addr;
+        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+        proxy_set_header X-Forwarded-Proto $scheme;
+    }
+}
+
+server {
+    listen 127.0.0.1:{{ .VarnishBackendPort }};
+    server_name {{ .ServerName }};
+{{- end }}
 
     root {{ .RootDir }};
     index index.php index.html index.htm;
 
+{{- if .VarnishAddr }}
+
+    access_log off;
+    error_log /var/log/nginx/{{ .Domain }}.error.log;
+    add_header X-Aipanel-TTL {{ .PageCacheTTL }};
+{{- else }}
+
     access_log /var/log/nginx/{{ .Domain }}.access.log;
     error_log /var/log/nginx/{{ .Domain }}.error.log;
+{{- end }}
+{{- if .PageCacheZone }}
+
+    set $skip_cache 0;
+    if ($request_method !~ ^(GET|HEAD)$) {
+        set $skip_cache 1;
+    }
+    if ($query_string != "") {
+        set $skip_cache 1;
+    }
+    if ($request_uri ~* "^/(wp-admin/|wp-login\.php|wp-cron\.php|xmlrpc\.php|wp-json/)") {
+        set $skip_cache 1;
+    }
+    if ($http_cookie ~* "wordpress_logged_in_|wp-postpass_|comment_author_|woocommerce_items_in_cart|wordpress_no_cache") {
+        set $skip_cache 1;
+    }
+{{- end }}
 
     location / {
         try_files $uri $uri/ /index.php?$query_string;
     }
+{{- if .MediaUpstream }}
+
+    location ^~ /media/ {
+        proxy_pass {{ .MediaUpstream }};
+        proxy_set_header Host {{ .MediaHost }};
+        proxy_ssl_server_name on;
+        proxy_hide_header x-amz-request-id;
+        proxy_hide_header x-amz-id-2;
+        proxy_intercept_errors on;
+        limit_except GET HEAD {
+            deny all;
+        }
+    }
+{{- end }}
 
     location ~ \.php$ {
         include snippets/fastcgi-php.conf;
         fastcgi_pass unix:{{ .SocketPath }};
+{{- if .PageCacheZone }}
+        fastcgi_cache {{ .PageCacheZone }};
+        fastcgi_cache_key $scheme$request_method$host$request_uri;
+        fastcgi_cache_valid 200 301 302 {{ .PageCacheTTL }}s;
+        fastcgi_cache_use_stale error timeout updating http_500 http_503;
+        fastcgi_cache_lock on;
+        fastcgi_cache_bypass $skip_cache;
+        fastcgi_no_cache $skip_cache;
+        add_header X-Cache-Status $upstream_cache_status always;
+{{- end }}
     }
 }
 `
@@ -3373,9 +4703,22 @@ pm = ondemand
 pm.max_children = 20
 pm.process_idle_timeout = 10s
 pm.max_requests = 500
+pm.status_path = /aipanel-status
+ping.path = /aipanel-ping
+
+slowlog = {{ .SlowLogPath }}
+request_slowlog_timeout = 5s
 
 chdir = /
-php_admin_value[open_basedir] = {{ .RootDir }}:/tmp
+php_admin_value[open_basedir] = {{ .OpenBasedir }}
+{{- if .TmpDir }}
+php_admin_value[sys_temp_dir] = {{ .TmpDir }}
+php_admin_value[upload_tmp_dir] = {{ .TmpDir }}
+php_admin_value[session.save_path] = {{ .TmpDir }}
+env[TMPDIR] = {{ .TmpDir }}
+{{- end }}
+{{ range $name, $value := .Env }}env[{{ $name }}] = "{{ $value }}"
+{{ end -}}
 `
 
 const panelVhostTemplateBody = `{{ if .EnableTLS -}}
@@ -3418,28 +4761,114 @@ server {
     }
 {{ end -}}
 
-    location = /phpmyadmin {
-        return 301 /phpmyadmin/;
+    # Database admin tools are only served to requests carrying a valid
+    # panel session, checked by a subrequest to the panel.
+    location = /_aipanel_auth {
+        internal;
+        proxy_pass {{ .PanelUpstream }}/api/auth/verify;
+        proxy_pass_request_body off;
+        proxy_set_header Content-Length "";
+        proxy_set_header X-Original-URI $request_uri;
+        proxy_set_header X-Real-IP $remote_addr;
+    }
+
+    location @aipanel_login {
+        return 302 /;
+    }
+
+    location = {{ .PHPMyAdminPath }} {
+        return 301 {{ .PHPMyAdminPath }}/;
     }
 
-    location /phpmyadmin/ {
-        root /usr/share;
+    location ^~ {{ .PHPMyAdminPath }}/ {
+{{- range .AdminToolsAllow }}
+        allow {{ . }};
+{{- end }}
+{{- if .AdminToolsAllow }}
+        deny all;
+{{- end }}
+        auth_request /_aipanel_auth;
+        error_page 401 = @aipanel_login;
+        alias {{ .PHPMyAdminDir }}/;
         index index.php;
-        try_files $uri $uri/ /phpmyadmin/index.php?$args;
+
+        location ~ \.php$ {
+            include fastcgi_params;
+            fastcgi_param SCRIPT_FILENAME $request_filename;
+            fastcgi_pass unix:/run/php/aipanel-default-{{ .PHPVersion }}.sock;
+        }
     }
 
-    location ~ ^/phpmyadmin/.*\.php$ {
-        root /usr/share;
-        include snippets/fastcgi-php.conf;
+{{ if .EnableAdminer -}}
+    location = {{ .AdminerPath }} {
+        return 301 {{ .AdminerPath }}/;
+    }
+
+    location {{ .AdminerPath }}/ {
+{{- range .AdminToolsAllow }}
+        allow {{ . }};
+{{- end }}
+{{- if .AdminToolsAllow }}
+        deny all;
+{{- end }}
+        auth_request /_aipanel_auth;
+        error_page 401 = @aipanel_login;
+        include fastcgi_params;
+        fastcgi_param SCRIPT_FILENAME {{ .AdminerDir }}/index.php;
+        fastcgi_param SCRIPT_NAME {{ .AdminerPath }}/index.php;
         fastcgi_pass unix:/run/php/aipanel-default-{{ .PHPVersion }}.sock;
     }
 
+{{ end -}}
+{{ if .EnableRoundcube -}}
+    location = {{ .RoundcubePath }} {
+        return 301 {{ .RoundcubePath }}/;
+    }
+
+    location ^~ {{ .RoundcubePath }}/ {
+{{- range .AdminToolsAllow }}
+        allow {{ . }};
+{{- end }}
+{{- if .AdminToolsAllow }}
+        deny all;
+{{- end }}
+        auth_request /_aipanel_auth;
+        error_page 401 = @aipanel_login;
+        alias {{ .RoundcubeDir }}/public_html/;
+        client_max_body_size 25m;
+
+        location = {{ .RoundcubePath }}/ {
+            include fastcgi_params;
+            fastcgi_param SCRIPT_FILENAME {{ .RoundcubeDir }}/public_html/index.php;
+            fastcgi_param SCRIPT_NAME {{ .RoundcubePath }}/index.php;
+            fastcgi_pass unix:/run/php/aipanel-default-{{ .PHPVersion }}.sock;
+        }
+
+        # Skins and plugin assets are served by static.php through PATH_INFO.
+        location ~ ^{{ .RoundcubePath }}/(index|static)\.php(/.*)?$ {
+            include fastcgi_params;
+            fastcgi_param SCRIPT_FILENAME {{ .RoundcubeDir }}/public_html/$1.php;
+            fastcgi_param SCRIPT_NAME {{ .RoundcubePath }}/$1.php;
+            fastcgi_param PATH_INFO $2;
+            fastcgi_pass unix:/run/php/aipanel-default-{{ .PHPVersion }}.sock;
+        }
+    }
+
+{{ end -}}
 {{ if .EnablePGAdmin -}}
     location = {{ .PGAdminPath }} {
         return 301 {{ .PGAdminPath }}/;
     }
 
     location {{ .PGAdminPath }}/ {
+{{- range .AdminToolsAllow }}
+        allow {{ . }};
+{{- end }}
+{{- if .AdminToolsAllow }}
+        deny all;
+{{- end }}
+        auth_request /_aipanel_auth;
+        error_page 401 = @aipanel_login;
         proxy_set_header Host $host;
         proxy_set_header X-Real-IP $remote_addr;
         proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
@@ -3454,7 +4883,7 @@ server {
         proxy_set_header X-Real-IP $remote_addr;
         proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
         proxy_set_header X-Forwarded-Proto $scheme;
-        proxy_pass http://127.0.0.1:{{ .PanelPort }};
+        proxy_pass {{ .PanelUpstream }};
     }
 }
 `
@@ -3462,10 +4891,41 @@ server {
 const catchallTemplateBody = `server {
     listen 80 default_server;
     server_name _;
+{{- if eq .Mode "redirect" }}
+    return 302 {{ .PanelURL }}/;
+{{- else if eq .Mode "landing" }}
+    root {{ .LandingRoot }};
+    location / {
+        try_files /index.html =404;
+    }
+{{- else }}
     return 444;
+{{- end }}
 }
 `
 
+const catchallLandingTemplateBody = `<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Site not configured</title>
+<style>
+body { margin: 0; font-family: system-ui, sans-serif; color: #1f2933; background: #f5f7fa; }
+main { max-width: 32rem; margin: 20vh auto; padding: 0 1.5rem; text-align: center; }
+h1 { font-size: 1.5rem; }
+</style>
+</head>
+<body>
+<main>
+<h1>This site is not configured yet</h1>
+<p>No website is set up for this address on this server.</p>
+</main>
+</body>
+</html>
+`
+
+// sourceRuntimeNginxConf uses one %s verb slot: the stub_status server, if any.
 const sourceRuntimeNginxConf = `worker_processes auto;
 user www-data;
 pid /run/nginx.pid;
@@ -3485,11 +4945,26 @@ http {
     fastcgi_temp_path /var/lib/nginx/fastcgi;
     uwsgi_temp_path /var/lib/nginx/uwsgi;
     scgi_temp_path /var/lib/nginx/scgi;
-    include /etc/nginx/conf.d/*.conf;
+%s    include /etc/nginx/conf.d/*.conf;
     include /etc/nginx/sites-enabled/*.conf;
 }
 `
 
+// runtimeNginxStatusAddr serves stub_status for the panel poller; it must
+// match the default nginx_status_url of the panel config.
+const runtimeNginxStatusAddr = "127.0.0.1:8089"
+
+const runtimeNginxStatusServer = `    server {
+        listen ` + runtimeNginxStatusAddr + `;
+        access_log off;
+        location = /nginx_status {
+            stub_status;
+            allow 127.0.0.1;
+            deny all;
+        }
+    }
+`
+
 const sourceRuntimeFastCGIPHPConf = `fastcgi_split_path_info ^(.+\.php)(/.+)$;
 try_files $fastcgi_script_name =404;
 set $path_info $fastcgi_path_info;
@@ -3512,12 +4987,37 @@ pm.process_idle_timeout = 10s
 `
 
 func renderPanelConfig(opts Options) string {
-	return fmt.Sprintf(
+	content := fmt.Sprintf(
 		"addr: %q\nenv: %q\ndata_dir: %q\nsession_cookie_name: \"aipanel_session\"\nsession_ttl_hours: 24\n",
 		opts.Addr,
 		opts.Env,
 		opts.DataDir,
 	)
+	if len(opts.ListenAddrs) > 0 {
+		content += "listen_addrs:\n"
+		for _, addr := range opts.ListenAddrs {
+			content += fmt.Sprintf("  - %q\n", strings.TrimSpace(addr))
+		}
+	}
+	if domain := strings.TrimSpace(opts.PanelDomain); opts.ReverseProxy && domain != "" && domain != "_" {
+		content += fmt.Sprintf("panel_domain: %q\n", domain)
+	}
+	if mode := strings.TrimSpace(opts.CatchAllMode); mode != "" && mode != config.CatchAllDrop {
+		content += fmt.Sprintf("catchall_mode: %q\n", mode)
+	}
+	if opts.SSHPort > 0 && opts.SSHPort != 22 {
+		content += fmt.Sprintf("ssh_port: %d\n", opts.SSHPort)
+	}
+	if bundle := strings.TrimSpace(opts.CABundlePath); bundle != "" {
+		content += fmt.Sprintf("ca_bundle_path: %q\n", bundle)
+	}
+	if opts.EnableLetsEncrypt {
+		content += fmt.Sprintf("acme_email: %q\nacme_staging: %t\n", strings.TrimSpace(opts.LetsEncryptEmail), opts.LetsEncryptStaging)
+		if webroot := strings.TrimSpace(opts.LetsEncryptWebroot); webroot != "" {
+			content += fmt.Sprintf("acme_webroot: %q\n", webroot)
+		}
+	}
+	return content
 }
 
 func renderSystemdUnit(opts Options) string {
@@ -3525,11 +5025,17 @@ func renderSystemdUnit(opts Options) string {
 	if strings.TrimSpace(configPath) == "" {
 		configPath = "/etc/aipanel/panel.yaml"
 	}
+	after := "After=network-online.target"
+	requires := ""
+	if _, unixAddr := config.UnixSocketPath(opts.Addr); unixAddr {
+		after += " aipanel.socket"
+		requires = "Requires=aipanel.socket\n"
+	}
 	return strings.Join([]string{
 		"[Unit]",
 		"Description=aiPanel service",
-		"After=network-online.target",
-		"Wants=network-online.target",
+		after,
+		requires + "Wants=network-online.target",
 		"",
 		"[Service]",
 		"Type=simple",
@@ -3540,6 +5046,7 @@ func renderSystemdUnit(opts Options) string {
 		"WorkingDirectory=/",
 		fmt.Sprintf("Environment=AIPANEL_CONFIG=%s", configPath),
 		fmt.Sprintf("ExecStart=%s serve", opts.PanelBinaryPath),
+		"ExecReload=/bin/kill -HUP $MAINPID",
 		"Restart=on-failure",
 		"RestartSec=2",
 		"",
@@ -3549,11 +5056,42 @@ func renderSystemdUnit(opts Options) string {
 	}, "\n")
 }
 
+// renderSystemdSocketUnit renders aipanel.socket for unix socket listen addresses.
+// The socket is owned by root:www-data so runtime nginx workers can proxy to it.
+func renderSystemdSocketUnit(opts Options) (string, bool) {
+	socketPath, ok := config.UnixSocketPath(opts.Addr)
+	if !ok {
+		return "", false
+	}
+	return strings.Join([]string{
+		"[Unit]",
+		"Description=aiPanel socket",
+		"",
+		"[Socket]",
+		fmt.Sprintf("ListenStream=%s", socketPath),
+		"SocketUser=root",
+		"SocketGroup=www-data",
+		"SocketMode=0660",
+		"RemoveOnStop=true",
+		"",
+		"[Install]",
+		"WantedBy=sockets.target",
+		"",
+	}, "\n"), true
+}
+
+func panelSocketUnitPath(unitFilePath string) string {
+	return filepath.Join(filepath.Dir(unitFilePath), "aipanel.socket")
+}
+
 func writeTextFile(path, content string, mode os.FileMode) error {
 	return writeBinaryFile(path, []byte(content), mode)
 }
 
 func writeBinaryFile(path string, content []byte, mode os.FileMode) error {
+	if err := faultinject.Write(path); err != nil {
+		return err
+	}
 	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
 		return err
 	}
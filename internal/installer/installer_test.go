@@ -5,16 +5,24 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/robsonek/aiPanel/internal/installer/steps"
+	"github.com/robsonek/aiPanel/internal/modules/iam"
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/faultinject"
+	"github.com/robsonek/aiPanel/internal/platform/ports"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
 )
 
 type fakeRunner struct {
@@ -109,7 +117,10 @@ func TestIsDebian13(t *testing.T) {
 	}
 }
 
-func TestInstallerRun_Phase1DrySystem(t *testing.T) {
+// newDrySystemOptions prepares a fake Debian 13 root with runtime install
+// already checkpointed, so Run only exercises the panel-level steps.
+func newDrySystemOptions(t *testing.T) Options {
+	t.Helper()
 	root := t.TempDir()
 	srcBinary := filepath.Join(root, "src", "aipanel")
 	if err := os.MkdirAll(filepath.Dir(srcBinary), 0o750); err != nil {
@@ -198,7 +209,11 @@ func TestInstallerRun_Phase1DrySystem(t *testing.T) {
 	if err := os.WriteFile(opts.StateFilePath, []byte(stateBody), 0o600); err != nil {
 		t.Fatalf("write installer state: %v", err)
 	}
+	return opts
+}
 
+func TestInstallerRun_Phase1DrySystem(t *testing.T) {
+	opts := newDrySystemOptions(t)
 	runner := &fakeRunner{}
 	ins := New(opts, runner)
 	report, err := ins.Run(context.Background())
@@ -237,6 +252,43 @@ func TestInstallerRun_Phase1DrySystem(t *testing.T) {
 	}
 }
 
+func TestInstallerRun_InjectedUnitWriteFailureResumesFromCheckpoint(t *testing.T) {
+	opts := newDrySystemOptions(t)
+	restore := faultinject.Install(faultinject.Rule{Kind: faultinject.KindWrite, Match: opts.UnitFilePath})
+
+	report, err := New(opts, &fakeRunner{}).Run(context.Background())
+	restore()
+	if !errors.Is(err, faultinject.ErrInjected) {
+		t.Fatalf("expected injected failure, got %v", err)
+	}
+	if report == nil || report.Status != "failed" {
+		t.Fatalf("expected failed report, got %+v", report)
+	}
+	last := report.Steps[len(report.Steps)-1]
+	if last.Name != steps.WriteUnit || last.Status != "failed" {
+		t.Fatalf("expected %s to fail last, got %+v", steps.WriteUnit, last)
+	}
+
+	runner := &fakeRunner{}
+	report, err = New(opts, runner).Run(context.Background())
+	if err != nil {
+		t.Fatalf("resume run failed: %v", err)
+	}
+	statuses := map[string]string{}
+	for _, step := range report.Steps {
+		statuses[step.Name] = step.Status
+	}
+	if statuses[steps.WriteConfig] != "skipped" {
+		t.Fatalf("expected completed steps to be skipped on resume, got %+v", statuses)
+	}
+	if statuses[steps.WriteUnit] != "ok" {
+		t.Fatalf("expected %s to succeed on resume, got %+v", steps.WriteUnit, statuses)
+	}
+	if _, err := os.Stat(opts.UnitFilePath); err != nil {
+		t.Fatalf("missing unit file after resume: %v", err)
+	}
+}
+
 func TestHealthURL(t *testing.T) {
 	tests := []struct {
 		addr string
@@ -248,6 +300,7 @@ func TestHealthURL(t *testing.T) {
 		{"[::]:8080", "http://127.0.0.1:8080/health"},
 		{"[::1]:8080", "http://[::1]:8080/health"},
 		{"", "http://127.0.0.1:8080/health"},
+		{"unix:/run/aipanel.sock", "http://unix/health"},
 	}
 	for _, tt := range tests {
 		got := healthURL(tt.addr)
@@ -257,6 +310,39 @@ func TestHealthURL(t *testing.T) {
 	}
 }
 
+func TestRenderSystemdUnits_UnixSocketAddr(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Addr = "unix:/run/aipanel.sock"
+
+	unit := renderSystemdUnit(opts)
+	if !strings.Contains(unit, "Requires=aipanel.socket") {
+		t.Fatalf("expected service unit to require socket, got:\n%s", unit)
+	}
+	socketUnit, ok := renderSystemdSocketUnit(opts)
+	if !ok {
+		t.Fatal("expected socket unit for unix addr")
+	}
+	for _, want := range []string{"ListenStream=/run/aipanel.sock", "SocketGroup=www-data", "SocketMode=0660"} {
+		if !strings.Contains(socketUnit, want) {
+			t.Fatalf("expected socket unit to contain %q, got:\n%s", want, socketUnit)
+		}
+	}
+	if got := panelUpstream(opts.Addr); got != "http://unix:/run/aipanel.sock:" {
+		t.Fatalf("unexpected panel upstream %q", got)
+	}
+
+	opts.Addr = "127.0.0.1:8080"
+	if _, ok := renderSystemdSocketUnit(opts); ok {
+		t.Fatal("expected no socket unit for tcp addr")
+	}
+	if strings.Contains(renderSystemdUnit(opts), "aipanel.socket") {
+		t.Fatal("expected tcp service unit without socket dependency")
+	}
+	if got := panelUpstream(opts.Addr); got != "http://127.0.0.1:8080" {
+		t.Fatalf("unexpected panel upstream %q", got)
+	}
+}
+
 func TestCreateServiceUser_NewUser(t *testing.T) {
 	root := t.TempDir()
 	runner := &fakeRunnerWithErrors{
@@ -351,6 +437,368 @@ func TestEnsureRuntimeNginxConfig_SetsTempDirPermissions(t *testing.T) {
 	}
 }
 
+// nginxVersionRunner answers nginx -V with configure arguments.
+type nginxVersionRunner struct {
+	fakeRunner
+	configure string
+}
+
+func (r *nginxVersionRunner) Run(ctx context.Context, name string, args ...string) (string, error) {
+	out, err := r.fakeRunner.Run(ctx, name, args...)
+	if len(args) == 1 && args[0] == "-V" {
+		return "nginx version: nginx/1.28.0\nconfigure arguments: " + r.configure + "\n", nil
+	}
+	return out, err
+}
+
+func TestEnsureRuntimeNginxConfig_AddsStubStatusWhenBuiltIn(t *testing.T) {
+	for _, tc := range []struct {
+		configure string
+		want      bool
+	}{
+		{configure: "--with-http_ssl_module --with-http_stub_status_module", want: true},
+		{configure: "--with-http_ssl_module", want: false},
+	} {
+		root := t.TempDir()
+		opts := DefaultOptions()
+		opts.RootFSPath = root
+		opts.RuntimeInstallDir = filepath.Join(root, "opt", "aipanel", "runtime")
+		ins := &Installer{
+			opts:   opts,
+			runner: &nginxVersionRunner{configure: tc.configure},
+			now:    time.Now,
+		}
+		if err := ins.ensureRuntimeNginxConfig(context.Background()); err != nil {
+			t.Fatalf("ensureRuntimeNginxConfig failed: %v", err)
+		}
+		raw, err := os.ReadFile(filepath.Join(opts.RuntimeInstallDir, "nginx", "current", "conf", "nginx.conf")) //nolint:gosec // test reads a file created within temp dir.
+		if err != nil {
+			t.Fatalf("read nginx.conf: %v", err)
+		}
+		conf := string(raw)
+		if got := strings.Contains(conf, "listen 127.0.0.1:8089;") && strings.Contains(conf, "stub_status;"); got != tc.want {
+			t.Fatalf("%s: expected status server %t, got:\n%s", tc.configure, tc.want, conf)
+		}
+		if strings.Contains(conf, "%!") {
+			t.Fatalf("unexpected format artifact in nginx.conf:\n%s", conf)
+		}
+		if !tc.want {
+			continue
+		}
+		owners, err := ports.New(sqlite.New(pathInRootFS(root, opts.DataDir))).List(context.Background())
+		if err != nil || len(owners) != 1 || owners[0].Owner != portOwnerNginxStatus || owners[0].Port != 8089 {
+			t.Fatalf("expected the status port reserved, got %+v (%v)", owners, err)
+		}
+	}
+}
+
+func TestConfigureNginx_GuardsAdminToolsWithPanelSession(t *testing.T) {
+	root := t.TempDir()
+	opts := DefaultOptions()
+	opts.RootFSPath = root
+	opts.RuntimeInstallDir = filepath.Join(root, "opt", "aipanel", "runtime")
+	opts.NginxSitesAvailableDir = filepath.Join(root, "etc", "nginx", "sites-available")
+	opts.NginxSitesEnabledDir = filepath.Join(root, "etc", "nginx", "sites-enabled")
+	opts.AdminToolsAllow = []string{"203.0.113.0/24", "2001:db8::1"}
+	pgAdminEntry := filepath.Join(root, "var", "lib", "aipanel", "pgadmin4", "pgadmin4", "pgAdmin4.py")
+	if err := os.MkdirAll(filepath.Dir(pgAdminEntry), 0o750); err != nil {
+		t.Fatalf("mkdir pgadmin: %v", err)
+	}
+	if err := os.WriteFile(pgAdminEntry, nil, 0o600); err != nil {
+		t.Fatalf("write pgadmin entrypoint: %v", err)
+	}
+
+	ins := &Installer{opts: opts, runner: &fakeRunner{}, now: time.Now}
+	if err := ins.configureNginx(context.Background()); err != nil {
+		t.Fatalf("configureNginx failed: %v", err)
+	}
+	raw, err := os.ReadFile(filepath.Join(opts.NginxSitesAvailableDir, "aipanel.conf")) //nolint:gosec // test reads file generated in temp dir.
+	if err != nil {
+		t.Fatalf("read panel vhost: %v", err)
+	}
+	vhost := string(raw)
+	if !strings.Contains(vhost, "proxy_pass http://127.0.0.1:8080/api/auth/verify;") {
+		t.Fatalf("expected auth subrequest location, got:\n%s", vhost)
+	}
+	routes, err := ReadAdminRoutes(filepath.Join(root, "var", "lib", "aipanel"))
+	if err != nil {
+		t.Fatalf("read admin routes: %v", err)
+	}
+	for _, location := range []string{
+		"location ^~ " + routes[AdminToolPHPMyAdmin] + "/ {",
+		"location " + routes[AdminToolPGAdmin] + "/ {",
+	} {
+		idx := strings.Index(vhost, location)
+		if idx < 0 {
+			t.Fatalf("missing %q in panel vhost:\n%s", location, vhost)
+		}
+		block := vhost[idx : idx+strings.Index(vhost[idx:], "}")]
+		for _, want := range []string{
+			"allow 203.0.113.0/24;",
+			"allow 2001:db8::1;",
+			"deny all;",
+			"auth_request /_aipanel_auth;",
+		} {
+			if !strings.Contains(block, want) {
+				t.Fatalf("expected %q in %q, got:\n%s", want, location, block)
+			}
+		}
+	}
+
+	opts.AdminToolsAllow = []string{"not-an-ip"}
+	if err := opts.validate(); err == nil || !strings.Contains(err.Error(), "allowlist") {
+		t.Fatalf("expected invalid allowlist entry to be rejected, got %v", err)
+	}
+}
+
+func TestConfigureNginx_RendersCatchAllMode(t *testing.T) {
+	root := t.TempDir()
+	opts := DefaultOptions()
+	opts.RootFSPath = root
+	opts.RuntimeInstallDir = filepath.Join(root, "opt", "aipanel", "runtime")
+	opts.NginxSitesAvailableDir = filepath.Join(root, "etc", "nginx", "sites-available")
+	opts.NginxSitesEnabledDir = filepath.Join(root, "etc", "nginx", "sites-enabled")
+	opts.ReverseProxy = true
+	opts.PanelDomain = "panel.example.com"
+	opts.CatchAllMode = config.CatchAllLanding
+	catchall := filepath.Join(opts.NginxSitesAvailableDir, "aipanel-catchall.conf")
+
+	ins := &Installer{opts: opts, runner: &fakeRunner{}, now: time.Now}
+	if err := ins.configureNginx(context.Background()); err != nil {
+		t.Fatalf("configureNginx failed: %v", err)
+	}
+	raw, err := os.ReadFile(catchall) //nolint:gosec // test reads file generated in temp dir.
+	if err != nil || !strings.Contains(string(raw), "root "+defaultCatchallRoot+";") {
+		t.Fatalf("expected landing catch-all, got %q (%v)", raw, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, defaultCatchallRoot, "index.html")); err != nil {
+		t.Fatalf("expected landing page: %v", err)
+	}
+	if content := renderPanelConfig(opts); !strings.Contains(content, `catchall_mode: "landing"`) {
+		t.Fatalf("expected catchall_mode in panel config, got:\n%s", content)
+	}
+
+	ins.opts.CatchAllMode = config.CatchAllRedirect
+	if err := ins.configureNginx(context.Background()); err != nil {
+		t.Fatalf("configureNginx failed: %v", err)
+	}
+	raw, _ = os.ReadFile(catchall) //nolint:gosec // test reads file generated in temp dir.
+	if !strings.Contains(string(raw), "return 302 http://panel.example.com/;") {
+		t.Fatalf("expected redirect to the panel, got:\n%s", raw)
+	}
+
+	opts.ReverseProxy = false
+	opts.PanelDomain = "_"
+	opts.CatchAllMode = config.CatchAllRedirect
+	if err := opts.validate(); err == nil || !strings.Contains(err.Error(), "catch-all") {
+		t.Fatalf("expected redirect without a panel domain to be rejected, got %v", err)
+	}
+}
+
+func TestStartPanelService_RefusesBusyPort(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	defer ln.Close()
+
+	opts := DefaultOptions()
+	opts.DataDir = t.TempDir()
+	opts.Addr = ln.Addr().String()
+	runner := &fakeRunner{}
+	ins := &Installer{opts: opts, runner: runner, now: time.Now}
+
+	err = ins.startPanelService(context.Background())
+	if !errors.Is(err, ports.ErrInUse) {
+		t.Fatalf("expected port conflict, got %v", err)
+	}
+	if slices.Contains(runner.commands, "systemctl enable --now aipanel") {
+		t.Fatalf("panel must not be started on a busy port: %v", runner.commands)
+	}
+
+	ins.opts.Addr = "127.0.0.1:5050"
+	if err := ports.New(sqlite.New(opts.DataDir)).Claim(context.Background(), portOwnerPGAdmin, 5050); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if err := ins.startPanelService(context.Background()); !errors.Is(err, ports.ErrReserved) {
+		t.Fatalf("expected reservation conflict, got %v", err)
+	}
+}
+
+func TestConfigureNginx_ResolvesAdminRoutes(t *testing.T) {
+	root := t.TempDir()
+	opts := DefaultOptions()
+	opts.RootFSPath = root
+	opts.RuntimeInstallDir = filepath.Join(root, "opt", "aipanel", "runtime")
+	opts.NginxSitesAvailableDir = filepath.Join(root, "etc", "nginx", "sites-available")
+	opts.NginxSitesEnabledDir = filepath.Join(root, "etc", "nginx", "sites-enabled")
+	dataDir := filepath.Join(root, "var", "lib", "aipanel")
+	vhostPath := filepath.Join(opts.NginxSitesAvailableDir, "aipanel.conf")
+	unitPath := filepath.Join(root, "etc", "systemd", "system", defaultPGAdminUnitName)
+	pgAdminEntry := filepath.Join(root, "var", "lib", "aipanel", "pgadmin4", "pgadmin4", "pgAdmin4.py")
+	if err := os.MkdirAll(filepath.Dir(pgAdminEntry), 0o750); err != nil {
+		t.Fatalf("mkdir pgadmin: %v", err)
+	}
+	if err := os.WriteFile(pgAdminEntry, nil, 0o600); err != nil {
+		t.Fatalf("write pgadmin entrypoint: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(unitPath), 0o750); err != nil {
+		t.Fatalf("mkdir systemd: %v", err)
+	}
+	if err := os.WriteFile(unitPath, []byte(renderPGAdminUnit("/var/lib/aipanel/pgadmin4", "/var/lib/aipanel/pgadmin4-venv", "127.0.0.1:5050", "/pgadmin")), 0o600); err != nil {
+		t.Fatalf("write pgadmin unit: %v", err)
+	}
+	run := func(opts Options) (map[string]string, string, *fakeRunner) {
+		t.Helper()
+		runner := &fakeRunner{}
+		ins := &Installer{opts: opts, runner: runner, now: time.Now}
+		if err := ins.configureNginx(context.Background()); err != nil {
+			t.Fatalf("configureNginx failed: %v", err)
+		}
+		routes, err := ReadAdminRoutes(dataDir)
+		if err != nil {
+			t.Fatalf("read admin routes: %v", err)
+		}
+		raw, err := os.ReadFile(vhostPath) //nolint:gosec // test reads file generated in temp dir.
+		if err != nil {
+			t.Fatalf("read panel vhost: %v", err)
+		}
+		return routes, string(raw), runner
+	}
+
+	routes, vhost, runner := run(opts)
+	pmaRoute := routes[AdminToolPHPMyAdmin]
+	pgaRoute := routes[AdminToolPGAdmin]
+	if !strings.HasPrefix(pmaRoute, "/phpmyadmin-") || !strings.HasPrefix(pgaRoute, "/pgadmin-") {
+		t.Fatalf("expected randomized routes on a fresh install, got %v", routes)
+	}
+	if !strings.Contains(vhost, "location ^~ "+pmaRoute+"/ {") || strings.Contains(vhost, "location = /phpmyadmin {") {
+		t.Fatalf("expected phpMyAdmin served under %s only, got:\n%s", pmaRoute, vhost)
+	}
+	unit, err := os.ReadFile(unitPath) //nolint:gosec // test reads file generated in temp dir.
+	if err != nil {
+		t.Fatalf("read pgadmin unit: %v", err)
+	}
+	if !strings.Contains(string(unit), "Environment=SCRIPT_NAME="+pgaRoute+"\n") {
+		t.Fatalf("expected pgAdmin unit to follow the new route, got:\n%s", unit)
+	}
+	if !strings.Contains(strings.Join(runner.commands, "\n"), "systemctl restart "+defaultPGAdminUnitName) {
+		t.Fatalf("expected pgAdmin restart after route change, got:\n%s", strings.Join(runner.commands, "\n"))
+	}
+
+	routes, _, runner = run(opts)
+	if routes[AdminToolPHPMyAdmin] != pmaRoute || routes[AdminToolPGAdmin] != pgaRoute {
+		t.Fatalf("expected routes to survive a rerun, got %v", routes)
+	}
+	if strings.Contains(strings.Join(runner.commands, "\n"), "systemctl restart") {
+		t.Fatalf("expected no pgAdmin restart without a route change, got:\n%s", strings.Join(runner.commands, "\n"))
+	}
+
+	explicit := opts
+	explicit.PHPMyAdminRoutePath = "/db/mysql/"
+	routes, vhost, _ = run(explicit)
+	if routes[AdminToolPHPMyAdmin] != "/db/mysql" || routes[AdminToolPGAdmin] != pgaRoute {
+		t.Fatalf("expected explicit phpMyAdmin route to win, got %v", routes)
+	}
+	if !strings.Contains(vhost, "location ^~ /db/mysql/ {") {
+		t.Fatalf("expected explicit phpMyAdmin route in vhost, got:\n%s", vhost)
+	}
+
+	explicit.PHPMyAdminRoutePath = "/db/{mysql}"
+	if err := explicit.validate(); err == nil || !strings.Contains(err.Error(), "phpMyAdmin route") {
+		t.Fatalf("expected unsafe route to be rejected, got %v", err)
+	}
+
+	if err := os.Remove(filepath.Join(dataDir, AdminRoutesFile)); err != nil {
+		t.Fatalf("remove admin routes: %v", err)
+	}
+	routes, _, _ = run(opts)
+	if routes[AdminToolPHPMyAdmin] != "/phpmyadmin" || routes[AdminToolPGAdmin] != "/pgadmin" {
+		t.Fatalf("expected panels without recorded routes to keep the defaults, got %v", routes)
+	}
+}
+
+func TestEnsureRuntimeMySQLBootstrap_InitializesOnce(t *testing.T) {
+	root := t.TempDir()
+	runner := &fakeRunner{}
+	opts := DefaultOptions()
+	opts.RootFSPath = root
+	opts.RuntimeInstallDir = filepath.Join(root, "opt", "aipanel", "runtime")
+	opts.DataDir = "/var/lib/aipanel"
+
+	ins := &Installer{
+		opts:   opts,
+		runner: runner,
+		now:    time.Now,
+	}
+	if err := ins.ensureRuntimeMySQLBootstrap(context.Background()); err != nil {
+		t.Fatalf("ensureRuntimeMySQLBootstrap failed: %v", err)
+	}
+	runtimeDir := filepath.Join(opts.RuntimeInstallDir, "mysql", "current")
+	want := filepath.Join(runtimeDir, "bin", "mysqld") + " --initialize-insecure --basedir=" + runtimeDir
+	if len(runner.commands) != 1 || !strings.HasPrefix(runner.commands[0], want) {
+		t.Fatalf("expected mysqld --initialize-insecure, got %v", runner.commands)
+	}
+
+	dataDir := pathInRootFS(root, filepath.Join(opts.DataDir, "runtime", "mysql"))
+	if err := os.MkdirAll(filepath.Join(dataDir, "mysql"), 0o750); err != nil {
+		t.Fatalf("mkdir system schema dir: %v", err)
+	}
+	runner.commands = nil
+	if err := ins.ensureRuntimeMySQLBootstrap(context.Background()); err != nil {
+		t.Fatalf("second ensureRuntimeMySQLBootstrap failed: %v", err)
+	}
+	if len(runner.commands) != 0 {
+		t.Fatalf("expected initialized data dir to be kept, got %v", runner.commands)
+	}
+}
+
+func TestEnsureRuntimeMongoDBBootstrap_WritesAdminCredentialsOnce(t *testing.T) {
+	root := t.TempDir()
+	runner := &fakeRunner{}
+	opts := DefaultOptions()
+	opts.RootFSPath = root
+	opts.RuntimeInstallDir = filepath.Join(root, "opt", "aipanel", "runtime")
+	opts.DataDir = "/var/lib/aipanel"
+
+	ins := &Installer{
+		opts:   opts,
+		runner: runner,
+		now:    time.Now,
+	}
+	if err := ins.ensureRuntimeMongoDBBootstrap(context.Background()); err != nil {
+		t.Fatalf("ensureRuntimeMongoDBBootstrap failed: %v", err)
+	}
+	if len(runner.commands) != 1 || !strings.Contains(runner.commands[0], "--fork") || !strings.Contains(runner.commands[0], "createUser") {
+		t.Fatalf("expected temporary mongod bootstrap, got %v", runner.commands)
+	}
+
+	credentialsPath := pathInRootFS(root, filepath.Join(opts.DataDir, "runtime", "mongodb-admin.json"))
+	raw, err := os.ReadFile(credentialsPath)
+	if err != nil {
+		t.Fatalf("read credentials: %v", err)
+	}
+	var creds map[string]string
+	if err := json.Unmarshal(raw, &creds); err != nil {
+		t.Fatalf("parse credentials: %v", err)
+	}
+	if creds["username"] != "aipanel" || creds["password"] == "" || !strings.Contains(runner.commands[0], creds["password"]) {
+		t.Fatalf("unexpected credentials: %v", creds)
+	}
+	info, err := os.Stat(credentialsPath)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected 0600 credentials file, got %v err=%v", info, err)
+	}
+
+	runner.commands = nil
+	if err := ins.ensureRuntimeMongoDBBootstrap(context.Background()); err != nil {
+		t.Fatalf("second ensureRuntimeMongoDBBootstrap failed: %v", err)
+	}
+	if len(runner.commands) != 0 {
+		t.Fatalf("expected existing admin user to be kept, got %v", runner.commands)
+	}
+}
+
 func TestEnsureRuntimePostgreSQLBootstrap_FixesDataParentPermissions(t *testing.T) {
 	root := t.TempDir()
 	dataRoot := filepath.Join(root, "var", "lib", "aipanel")
@@ -431,6 +879,62 @@ func TestEnsureRuntimePostgreSQLBootstrap_ReownsExistingData(t *testing.T) {
 	}
 }
 
+func TestEnsureRuntimePostgreSQLBootstrap_EnablesWALArchiving(t *testing.T) {
+	root := t.TempDir()
+	runner := &fakeRunner{}
+	opts := DefaultOptions()
+	opts.RootFSPath = root
+	opts.RuntimeInstallDir = filepath.Join(root, "opt", "aipanel", "runtime")
+	opts.DataDir = "/var/lib/aipanel"
+
+	dataDir := pathInRootFS(root, filepath.Join(opts.DataDir, "runtime", "postgresql"))
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		t.Fatalf("mkdir data dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "PG_VERSION"), []byte("18"), 0o600); err != nil {
+		t.Fatalf("write PG_VERSION: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "postgresql.conf"), []byte("max_connections = 100\n"), 0o600); err != nil {
+		t.Fatalf("write postgresql.conf: %v", err)
+	}
+
+	ins := &Installer{opts: opts, runner: runner, now: time.Now}
+	for range 2 {
+		if err := ins.ensureRuntimePostgreSQLBootstrap(context.Background()); err != nil {
+			t.Fatalf("ensureRuntimePostgreSQLBootstrap failed: %v", err)
+		}
+	}
+
+	pitrConf, err := os.ReadFile(filepath.Join(dataDir, "aipanel-pitr.conf"))
+	if err != nil {
+		t.Fatalf("read archiving config: %v", err)
+	}
+	if !strings.Contains(string(pitrConf), "archive_mode = on") ||
+		!strings.Contains(string(pitrConf), "cp %p /var/lib/aipanel/runtime/postgresql-wal/%f") {
+		t.Fatalf("unexpected archiving config:\n%s", pitrConf)
+	}
+	mainConf, err := os.ReadFile(filepath.Join(dataDir, "postgresql.conf"))
+	if err != nil {
+		t.Fatalf("read postgresql.conf: %v", err)
+	}
+	if !strings.HasPrefix(string(mainConf), "max_connections = 100") ||
+		strings.Count(string(mainConf), "include_if_exists = 'aipanel-pitr.conf'") != 1 {
+		t.Fatalf("expected one include appended to postgresql.conf, got:\n%s", mainConf)
+	}
+	if _, err := os.Stat(pathInRootFS(root, "/var/lib/aipanel/runtime/postgresql-wal")); err != nil {
+		t.Fatalf("expected wal archive dir: %v", err)
+	}
+}
+
+func TestRenderRuntimeSystemdUnit_MariaDBReadsPanelConfig(t *testing.T) {
+	opts := DefaultOptions()
+	opts.DataDir = "/var/lib/aipanel"
+	unit := renderRuntimeSystemdUnit(opts, "mariadb", RuntimeComponentLock{Version: "11.8.2"})
+	if !strings.Contains(unit, "Environment=MARIADB_HOME=/var/lib/aipanel/runtime/mariadb-conf\n") {
+		t.Fatalf("expected MARIADB_HOME in unit, got:\n%s", unit)
+	}
+}
+
 func TestRuntimeComponentNeedsUpdate_MetadataMissingRequiresRefresh(t *testing.T) {
 	root := t.TempDir()
 	opts := DefaultOptions()
@@ -477,7 +981,7 @@ func TestRuntimeComponentNeedsUpdate_MetadataMatchSkipsRefresh(t *testing.T) {
 	if err := os.MkdirAll(versionDir, 0o750); err != nil {
 		t.Fatalf("mkdir version dir: %v", err)
 	}
-	if err := writeRuntimeComponentInstallState(versionDir, "nginx", component); err != nil {
+	if err := writeRuntimeComponentInstallState(versionDir, "nginx", component, ""); err != nil {
 		t.Fatalf("write runtime component state: %v", err)
 	}
 	currentLink := filepath.Join(opts.RuntimeInstallDir, "nginx", "current")
@@ -536,7 +1040,7 @@ func TestInstallerRun_UpdateChangedOnly_SkipsWhenRuntimeIsCurrent(t *testing.T)
 	if err := os.MkdirAll(versionDir, 0o750); err != nil {
 		t.Fatalf("mkdir version dir: %v", err)
 	}
-	if err := writeRuntimeComponentInstallState(versionDir, "nginx", component); err != nil {
+	if err := writeRuntimeComponentInstallState(versionDir, "nginx", component, ""); err != nil {
 		t.Fatalf("write runtime component state: %v", err)
 	}
 	if err := os.Symlink(versionDir, filepath.Join(opts.RuntimeInstallDir, "nginx", "current")); err != nil {
@@ -595,6 +1099,63 @@ func TestInstallPackages_IncludesCertbotWhenLetsEncryptEnabled(t *testing.T) {
 	}
 }
 
+type dpkgQueryRunner struct {
+	fakeRunner
+	versions string
+}
+
+func (r *dpkgQueryRunner) Run(ctx context.Context, name string, args ...string) (string, error) {
+	out, err := r.fakeRunner.Run(ctx, name, args...)
+	if name == "dpkg-query" {
+		return r.versions, nil
+	}
+	return out, err
+}
+
+func TestInstallPackages_PinsAndRecordsAptVersions(t *testing.T) {
+	root := t.TempDir()
+	opts := DefaultOptions()
+	opts.ReportFilePath = filepath.Join(root, "install-report.json")
+	opts.LogFilePath = filepath.Join(root, "install.log")
+	previous, _ := json.Marshal(Report{AptPackages: map[string]string{"cmake": "3.31.5-1", "libssl-dev": "3.5.1-1"}})
+	if err := os.WriteFile(opts.ReportFilePath, previous, 0o600); err != nil {
+		t.Fatalf("write previous report: %v", err)
+	}
+	runner := &dpkgQueryRunner{versions: "cmake 3.31.6-2\nlibssl-dev:amd64 3.5.1-1\nsqlite3 3.46.1-7\n"}
+	ins := &Installer{
+		opts:        opts,
+		runner:      runner,
+		now:         time.Now,
+		runtimeLock: &RuntimeSourceLock{AptPackages: map[string]string{"libssl-dev": "3.5.1-1", "sqlite3": "3.46.1-6", "certbot": "4.0.0-2"}},
+	}
+	if err := ins.installPackages(context.Background()); err != nil {
+		t.Fatalf("installPackages failed: %v", err)
+	}
+
+	joined := strings.Join(runner.commands, "\n")
+	if !strings.Contains(joined, " libssl-dev=3.5.1-1 ") || !strings.Contains(joined, " sqlite3=3.46.1-6 ") || strings.Contains(joined, "certbot") {
+		t.Fatalf("expected locked packages pinned and unused pins ignored, got:\n%s", joined)
+	}
+	if ins.aptVersions["libssl-dev"] != "3.5.1-1" || ins.aptVersions["cmake"] != "3.31.6-2" {
+		t.Fatalf("unexpected recorded versions: %v", ins.aptVersions)
+	}
+	logged, err := os.ReadFile(opts.LogFilePath)
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+	for _, want := range []string{
+		"warning: sqlite3 is at 3.46.1-7, lock pins 3.46.1-6",
+		"warning: cmake drifted from 3.31.5-1 to 3.31.6-2 since the last install",
+	} {
+		if !strings.Contains(string(logged), want) {
+			t.Fatalf("expected %q in log, got:\n%s", want, logged)
+		}
+	}
+	if strings.Contains(string(logged), "libssl-dev drifted") {
+		t.Fatalf("expected no drift warning for an unchanged package, got:\n%s", logged)
+	}
+}
+
 func TestConfigureTLS_IssuesCertificateAndWritesRenewHook(t *testing.T) {
 	root := t.TempDir()
 	runner := &fakeRunner{}
@@ -632,6 +1193,70 @@ func TestConfigureTLS_IssuesCertificateAndWritesRenewHook(t *testing.T) {
 	}
 }
 
+func TestRenderPanelConfig_WritesACMESettings(t *testing.T) {
+	opts := DefaultOptions()
+	if strings.Contains(renderPanelConfig(opts), "acme_") {
+		t.Fatal("expected no acme settings when letsencrypt is disabled")
+	}
+	opts.EnableLetsEncrypt = true
+	opts.LetsEncryptEmail = "ops@aipanel.dev"
+	opts.LetsEncryptStaging = true
+	content := renderPanelConfig(opts)
+	for _, want := range []string{`acme_email: "ops@aipanel.dev"`, "acme_staging: true", `acme_webroot: "/var/www/letsencrypt"`} {
+		if !strings.Contains(content, want) {
+			t.Fatalf("expected %q in panel config:\n%s", want, content)
+		}
+	}
+}
+
+func TestRenderPanelConfig_WritesCABundle(t *testing.T) {
+	opts := DefaultOptions()
+	if strings.Contains(renderPanelConfig(opts), "ca_bundle_path") {
+		t.Fatal("expected no ca_bundle_path without a bundle")
+	}
+	opts.CABundlePath = "/etc/aipanel/proxy-ca.pem"
+	path := filepath.Join(t.TempDir(), "panel.yaml")
+	if err := os.WriteFile(path, []byte(renderPanelConfig(opts)), 0o600); err != nil {
+		t.Fatalf("write panel config: %v", err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil || cfg.CABundlePath != opts.CABundlePath {
+		t.Fatalf("expected the bundle kept for the panel, got %q (%v)", cfg.CABundlePath, err)
+	}
+	ins := &Installer{opts: opts}
+	if got := ins.gnupgHomeCommand("/tmp/gpg"); !strings.Contains(got, `hkp-cacert /etc/aipanel/proxy-ca.pem`) {
+		t.Fatalf("expected dirmngr to trust the bundle, got %q", got)
+	}
+}
+
+func TestRenderPanelConfig_WritesListenAddrs(t *testing.T) {
+	opts := DefaultOptions()
+	if strings.Contains(renderPanelConfig(opts), "listen_addrs") {
+		t.Fatal("expected no listen_addrs without extra addresses")
+	}
+	opts.ListenAddrs = []string{"10.8.0.1:8080", "iface:wg0:8443"}
+	content := renderPanelConfig(opts)
+	if !strings.Contains(content, "listen_addrs:\n  - \"10.8.0.1:8080\"\n  - \"iface:wg0:8443\"\n") {
+		t.Fatalf("expected listen_addrs list in panel config:\n%s", content)
+	}
+	path := filepath.Join(t.TempDir(), "panel.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write panel config: %v", err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("load rendered panel config: %v", err)
+	}
+	if len(cfg.ListenAddrs) != 2 || cfg.ListenAddrs[1] != "iface:wg0:8443" {
+		t.Fatalf("unexpected listen addrs after reload: %#v", cfg.ListenAddrs)
+	}
+
+	opts.ListenAddrs = []string{"wg0"}
+	if err := opts.validate(); err == nil || !strings.Contains(err.Error(), "listen address") {
+		t.Fatalf("expected invalid listen address to be rejected, got %v", err)
+	}
+}
+
 func TestConfigureTLS_RejectsPlaceholderEmail(t *testing.T) {
 	runner := &fakeRunner{}
 	opts := DefaultOptions()
@@ -1024,6 +1649,97 @@ func TestInstallerRun_OnlyRuntimeComponentsInstallsSelectedComponent(t *testing.
 	}
 }
 
+func TestInstallerRun_OnlyCustomRuntimeComponentBuildsAndValidates(t *testing.T) {
+	root := t.TempDir()
+	varnishTar := filepath.Join(root, "runtime", "varnish-source.tar.gz")
+	if err := os.MkdirAll(filepath.Dir(varnishTar), 0o750); err != nil {
+		t.Fatalf("mkdir runtime dir: %v", err)
+	}
+	if err := writeTarGzArtifact(varnishTar, "varnish-src/sbin/varnishd", []byte("compiled-varnishd")); err != nil {
+		t.Fatalf("write varnish source artifact: %v", err)
+	}
+	varnishSum, err := fileSHA256(varnishTar)
+	if err != nil {
+		t.Fatalf("varnish source sha: %v", err)
+	}
+	lock := &RuntimeSourceLock{SchemaVersion: 1, Channels: map[string]RuntimeChannelLock{
+		"stable": {"nginx": RuntimeComponentLock{
+			Version:      "1.29.5",
+			SourceURL:    "https://nginx.org/download/nginx-1.29.5.tar.gz",
+			SourceSHA256: strings.Repeat("a", 64),
+			Build:        RuntimeBuildSpec{Commands: []string{"make install"}},
+		}},
+	}}
+	varnish := RuntimeComponentLock{
+		Version:      "7.7.1",
+		SourceURL:    "file://" + varnishTar,
+		SourceSHA256: varnishSum,
+		Build:        RuntimeBuildSpec{Commands: []string{"mkdir -p {{install_dir}}/sbin", "cp ./sbin/varnishd {{install_dir}}/sbin/varnishd"}},
+		Systemd: RuntimeSystemdUnitSpec{
+			Name:      "aipanel-runtime-varnish.service",
+			ExecStart: "{{runtime_dir}}/varnish/current/sbin/varnishd -F -a :6081",
+		},
+		Validation: RuntimeValidationSpec{Command: "grep -q compiled {{install_dir}}/sbin/varnishd"},
+	}
+	if err := AddRuntimeComponent(lock, "varnish", varnish, nil, false); err != nil {
+		t.Fatalf("add component: %v", err)
+	}
+	lockPath := filepath.Join(root, "sources.lock.json")
+	if err := WriteRuntimeSourceLock(lockPath, lock); err != nil {
+		t.Fatalf("write lock: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.OnlyStep = "varnish"
+	opts.RootFSPath = root
+	opts.InstallMode = InstallModeSourceBuild
+	opts.RuntimeChannel = RuntimeChannelStable
+	opts.RuntimeLockPath = lockPath
+	opts.RuntimeLockURL = ""
+	opts.RuntimeInstallDir = filepath.Join(root, "opt", "aipanel", "runtime")
+	opts.PanelBinaryPath = filepath.Join(root, "usr", "local", "bin", "aipanel")
+	opts.UnitFilePath = filepath.Join(root, "etc", "systemd", "system", "aipanel.service")
+	opts.StateFilePath = filepath.Join(root, "var", "lib", "aipanel", ".installer-state.json")
+	opts.ReportFilePath = filepath.Join(root, "var", "lib", "aipanel", "install-report.json")
+	opts.LogFilePath = filepath.Join(root, "var", "log", "aipanel", "install.log")
+	opts.VerifyUpstreamSources = false
+
+	runner := &fakeRunnerShellBuild{}
+	report, err := New(opts, runner).Run(context.Background())
+	if err != nil || report.Status != "ok" {
+		t.Fatalf("installer run failed: %v", err)
+	}
+	if report.Steps[0].Name != steps.InstallPkgs+"[varnish]" {
+		t.Fatalf("expected the custom component scoped like a built-in, got %s", report.Steps[0].Name)
+	}
+	if _, err := os.Stat(filepath.Join(opts.RuntimeInstallDir, "varnish", "current", "sbin", "varnishd")); err != nil {
+		t.Fatalf("expected varnishd activated: %v", err)
+	}
+	unit, err := os.ReadFile(filepath.Join(root, "etc", "systemd", "system", "aipanel-runtime-varnish.service"))
+	if err != nil || !strings.Contains(string(unit), "ExecStart="+opts.RuntimeInstallDir+"/varnish/current/sbin/varnishd -F -a :6081") {
+		t.Fatalf("expected a rendered varnish unit, got %q (%v)", unit, err)
+	}
+	joined := strings.Join(runner.commands, "\n")
+	restart := strings.Index(joined, "systemctl restart aipanel-runtime-varnish.service")
+	validate := strings.Index(joined, "grep -q compiled "+opts.RuntimeInstallDir+"/varnish/7.7.1/sbin/varnishd")
+	if restart < 0 || validate < restart {
+		t.Fatalf("expected the validation after the restart, got:\n%s", joined)
+	}
+
+	varnish.Validation.Command = "grep -q interpreted {{install_dir}}/sbin/varnishd"
+	if err := AddRuntimeComponent(lock, "varnish", varnish, []string{"stable"}, true); err != nil {
+		t.Fatalf("replace component: %v", err)
+	}
+	if err := WriteRuntimeSourceLock(lockPath, lock); err != nil {
+		t.Fatalf("write lock: %v", err)
+	}
+	opts.ForceAllSteps = true
+	if _, err := New(opts, &fakeRunnerShellBuild{}).Run(context.Background()); err == nil ||
+		!strings.Contains(err.Error(), "validate runtime component varnish") {
+		t.Fatalf("expected a failing validation to fail the activation, got %v", err)
+	}
+}
+
 func TestInstallerRun_OnlyInstallPHPMyAdmin(t *testing.T) {
 	root := t.TempDir()
 	archivePath := filepath.Join(root, "phpmyadmin.tar.gz")
@@ -1094,6 +1810,267 @@ func TestInstallerRun_OnlyInstallPHPMyAdmin(t *testing.T) {
 	}
 }
 
+func TestInstallerRun_UpgradePHPMyAdminReplacesOlderRelease(t *testing.T) {
+	root := t.TempDir()
+	archivePath := filepath.Join(root, "phpmyadmin.tar.gz")
+	if err := writeTarGzArtifact(
+		archivePath,
+		"phpMyAdmin-5.2.4-all-languages/index.php",
+		[]byte("<?php echo 'new';"),
+	); err != nil {
+		t.Fatalf("write phpmyadmin archive: %v", err)
+	}
+	sum, err := fileSHA256(archivePath)
+	if err != nil {
+		t.Fatalf("checksum phpmyadmin archive: %v", err)
+	}
+
+	installDir := filepath.Join(root, "usr", "share", "phpmyadmin")
+	if err := os.MkdirAll(installDir, 0o750); err != nil {
+		t.Fatalf("mkdir install dir: %v", err)
+	}
+	for name, body := range map[string]string{
+		"index.php":          "<?php echo 'old';",
+		"config.inc.php":     "<?php $cfg['blowfish_secret'] = 'keep';",
+		AdminToolVersionFile: `{"name":"phpmyadmin","version":"5.2.3"}`,
+	} {
+		if err := os.WriteFile(filepath.Join(installDir, name), []byte(body), 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	opts := DefaultOptions()
+	opts.OnlyStep = steps.InstallPHPMyAdmin
+	opts.UpgradeAdminTools = true
+	opts.RootFSPath = root
+	opts.StateFilePath = filepath.Join(root, "var", "lib", "aipanel", ".installer-state.json")
+	opts.ReportFilePath = filepath.Join(root, "var", "lib", "aipanel", "install-report.json")
+	opts.LogFilePath = filepath.Join(root, "var", "log", "aipanel", "install.log")
+	opts.PHPMyAdminVersion = "5.2.4"
+	opts.PHPMyAdminURL = "file://" + archivePath
+	opts.PHPMyAdminSHA256 = sum
+	opts.PHPMyAdminInstallDir = "/usr/share/phpmyadmin"
+	opts.RuntimeInstallDir = filepath.Join(root, "opt", "aipanel", "runtime")
+	opts.NginxSitesAvailableDir = filepath.Join(root, "etc", "nginx", "sites-available")
+	opts.NginxSitesEnabledDir = filepath.Join(root, "etc", "nginx", "sites-enabled")
+
+	if _, err := New(opts, &fakeRunner{}).Run(context.Background()); err != nil {
+		t.Fatalf("installer run failed: %v", err)
+	}
+
+	body, err := os.ReadFile(filepath.Join(installDir, "index.php")) //nolint:gosec // test reads fixture under temp dir.
+	if err != nil || !strings.Contains(string(body), "new") {
+		t.Fatalf("expected upgraded index.php, got %q (%v)", string(body), err)
+	}
+	body, err = os.ReadFile(filepath.Join(installDir, "config.inc.php")) //nolint:gosec // test reads fixture under temp dir.
+	if err != nil || !strings.Contains(string(body), "keep") {
+		t.Fatalf("expected config.inc.php to be carried over, got %q (%v)", string(body), err)
+	}
+	marker, err := ReadAdminToolVersion(installDir)
+	if err != nil {
+		t.Fatalf("read version marker: %v", err)
+	}
+	if marker.Name != AdminToolPHPMyAdmin || marker.Version != "5.2.4" || marker.SHA256 != sum {
+		t.Fatalf("unexpected version marker: %+v", marker)
+	}
+	if _, err := os.Stat(installDir + ".aipanel-old"); !os.IsNotExist(err) {
+		t.Fatalf("expected previous release to be removed, stat err=%v", err)
+	}
+}
+
+func TestInstallerRun_OnlyInstallAdminerGuardsRouteWithPanelSession(t *testing.T) {
+	root := t.TempDir()
+	adminerPath := filepath.Join(root, "adminer-5.4.1.php")
+	if err := os.WriteFile(adminerPath, []byte("<?php echo 'adminer';"), 0o600); err != nil {
+		t.Fatalf("write adminer fixture: %v", err)
+	}
+	sum, err := fileSHA256(adminerPath)
+	if err != nil {
+		t.Fatalf("checksum adminer fixture: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.OnlyStep = steps.InstallAdminer
+	opts.RootFSPath = root
+	opts.StateFilePath = filepath.Join(root, "var", "lib", "aipanel", ".installer-state.json")
+	opts.ReportFilePath = filepath.Join(root, "var", "lib", "aipanel", "install-report.json")
+	opts.LogFilePath = filepath.Join(root, "var", "log", "aipanel", "install.log")
+	opts.AdminerURL = "file://" + adminerPath
+	opts.AdminerRoutePath = "/dbtool"
+	opts.RuntimeInstallDir = filepath.Join(root, "opt", "aipanel", "runtime")
+	opts.NginxSitesAvailableDir = filepath.Join(root, "etc", "nginx", "sites-available")
+	opts.NginxSitesEnabledDir = filepath.Join(root, "etc", "nginx", "sites-enabled")
+
+	if err := opts.withDefaults().validate(); err == nil || !strings.Contains(err.Error(), "adminer SHA-256") {
+		t.Fatalf("expected missing checksum to be rejected, got %v", err)
+	}
+	opts.AdminerSHA256 = strings.Repeat("0", 64)
+	if _, err := New(opts, &fakeRunner{}).Run(context.Background()); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+
+	opts.AdminerSHA256 = sum
+	if _, err := New(opts, &fakeRunner{}).Run(context.Background()); err != nil {
+		t.Fatalf("installer run failed: %v", err)
+	}
+	body, err := os.ReadFile(filepath.Join(root, "usr", "share", "adminer", "index.php")) //nolint:gosec // test reads fixture under temp dir.
+	if err != nil || !strings.Contains(string(body), "adminer") {
+		t.Fatalf("expected adminer index.php, got %q (%v)", string(body), err)
+	}
+	vhost, err := os.ReadFile(filepath.Join(opts.NginxSitesAvailableDir, "aipanel.conf")) //nolint:gosec // test reads file generated in temp dir.
+	if err != nil {
+		t.Fatalf("read panel vhost: %v", err)
+	}
+	for _, want := range []string{
+		"location /dbtool/ {",
+		"auth_request /_aipanel_auth;",
+		"proxy_pass http://127.0.0.1:8080/api/auth/verify;",
+		"fastcgi_param SCRIPT_FILENAME /usr/share/adminer/index.php;",
+	} {
+		if !strings.Contains(string(vhost), want) {
+			t.Fatalf("expected %q in panel vhost, got:\n%s", want, vhost)
+		}
+	}
+}
+
+func TestInstallerRun_OnlyConfigureFirewallKeepsExistingRuleset(t *testing.T) {
+	root := t.TempDir()
+	opts := DefaultOptions()
+	opts.OnlyStep = steps.ConfigureFirewall
+	opts.SkipFirewall = true
+	opts.SSHPort = 2222
+	opts.RootFSPath = root
+	opts.UnitFilePath = filepath.Join(root, "etc", "systemd", "system", "aipanel.service")
+	opts.StateFilePath = filepath.Join(root, "var", "lib", "aipanel", ".installer-state.json")
+	opts.ReportFilePath = filepath.Join(root, "var", "lib", "aipanel", "install-report.json")
+	opts.LogFilePath = filepath.Join(root, "var", "log", "aipanel", "install.log")
+	opts.RuntimeInstallDir = filepath.Join(root, "opt", "aipanel", "runtime")
+
+	runner := &fakeRunner{}
+	if _, err := New(opts, runner).Run(context.Background()); err != nil {
+		t.Fatalf("installer run failed: %v", err)
+	}
+	rulesPath := filepath.Join(root, "etc", "aipanel", "firewall.nft")
+	rules, err := os.ReadFile(rulesPath) //nolint:gosec // test reads file generated in temp dir.
+	if err != nil {
+		t.Fatalf("read firewall ruleset: %v", err)
+	}
+	for _, want := range []string{"policy drop;", `tcp dport { 80, 443, 2222, 8080 } accept comment "baseline"`} {
+		if !strings.Contains(string(rules), want) {
+			t.Fatalf("expected %q in ruleset, got:\n%s", want, rules)
+		}
+	}
+	unit, err := os.ReadFile(filepath.Join(root, "etc", "systemd", "system", "aipanel-firewall.service")) //nolint:gosec // test reads file generated in temp dir.
+	if err != nil || !strings.Contains(string(unit), "ExecStart=/usr/sbin/nft -f /etc/aipanel/firewall.nft") {
+		t.Fatalf("expected firewall unit, got %q (%v)", unit, err)
+	}
+	joined := strings.Join(runner.commands, "\n")
+	for _, want := range []string{
+		"/usr/sbin/nft -c -f " + rulesPath,
+		"systemctl enable --now aipanel-firewall.service",
+		"systemctl restart aipanel-firewall.service",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Fatalf("expected %q, got:\n%s", want, joined)
+		}
+	}
+
+	// The panel owns the ruleset after the first install.
+	if err := os.WriteFile(rulesPath, []byte("# managed\n"), 0o600); err != nil {
+		t.Fatalf("write ruleset: %v", err)
+	}
+	if _, err := New(opts, &fakeRunner{}).Run(context.Background()); err != nil {
+		t.Fatalf("installer rerun failed: %v", err)
+	}
+	if rules, _ := os.ReadFile(rulesPath); string(rules) != "# managed\n" { //nolint:gosec // test reads file generated in temp dir.
+		t.Fatalf("expected existing ruleset to be kept, got:\n%s", rules)
+	}
+}
+
+func TestInstallerRun_OnlyInstallRoundcubeConfiguresWebmail(t *testing.T) {
+	root := t.TempDir()
+	archivePath := filepath.Join(root, "roundcube.tar.gz")
+	if err := writeTarGzArtifactEntries(archivePath, map[string][]byte{
+		"roundcubemail-1.6.11/public_html/index.php":   []byte("<?php require '../index.php';"),
+		"roundcubemail-1.6.11/installer/index.php":     []byte("<?php echo 'installer';"),
+		"roundcubemail-1.6.11/bin/initdb.sh":           []byte("#!/usr/bin/env php"),
+		"roundcubemail-1.6.11/config/defaults.inc.php": []byte("<?php"),
+	}); err != nil {
+		t.Fatalf("write roundcube archive: %v", err)
+	}
+	sum, err := fileSHA256(archivePath)
+	if err != nil {
+		t.Fatalf("checksum roundcube archive: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.OnlyStep = steps.InstallRoundcube
+	opts.RootFSPath = root
+	opts.StateFilePath = filepath.Join(root, "var", "lib", "aipanel", ".installer-state.json")
+	opts.ReportFilePath = filepath.Join(root, "var", "lib", "aipanel", "install-report.json")
+	opts.LogFilePath = filepath.Join(root, "var", "log", "aipanel", "install.log")
+	opts.RoundcubeURL = "file://" + archivePath
+	opts.RuntimeInstallDir = filepath.Join(root, "opt", "aipanel", "runtime")
+	opts.NginxSitesAvailableDir = filepath.Join(root, "etc", "nginx", "sites-available")
+	opts.NginxSitesEnabledDir = filepath.Join(root, "etc", "nginx", "sites-enabled")
+
+	if err := opts.withDefaults().validate(); err == nil || !strings.Contains(err.Error(), "roundcube SHA-256") {
+		t.Fatalf("expected missing checksum to be rejected, got %v", err)
+	}
+	opts.RoundcubeSHA256 = strings.Repeat("0", 64)
+	if _, err := New(opts, &fakeRunner{}).Run(context.Background()); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+
+	opts.RoundcubeSHA256 = sum
+	runner := &fakeRunner{}
+	if _, err := New(opts, runner).Run(context.Background()); err != nil {
+		t.Fatalf("installer run failed: %v", err)
+	}
+	installDir := filepath.Join(root, "usr", "share", "roundcube")
+	if _, err := os.Stat(filepath.Join(installDir, "installer")); !os.IsNotExist(err) {
+		t.Fatalf("expected web installer to be removed, stat err=%v", err)
+	}
+	config, err := os.ReadFile(filepath.Join(installDir, "config", "config.inc.php")) //nolint:gosec // test reads file generated in temp dir.
+	if err != nil {
+		t.Fatalf("read roundcube config: %v", err)
+	}
+	for _, want := range []string{
+		"$config['db_dsnw'] = 'sqlite:////var/lib/roundcube/roundcube.db?mode=0640';",
+		"$config['imap_host'] = 'localhost:143';",
+		"$config['enable_installer'] = false;",
+	} {
+		if !strings.Contains(string(config), want) {
+			t.Fatalf("expected %q in roundcube config, got:\n%s", want, config)
+		}
+	}
+	desKey, err := os.ReadFile(filepath.Join(root, "var", "lib", "roundcube", "des_key")) //nolint:gosec // test reads file generated in temp dir.
+	if err != nil || !strings.Contains(string(config), "$config['des_key'] = '"+strings.TrimSpace(string(desKey))+"';") {
+		t.Fatalf("expected stored des_key in config, got %q (%v)", desKey, err)
+	}
+	initdb := "runuser -u www-data -- " + filepath.Join(opts.RuntimeInstallDir, "php-fpm", "current", "bin", "php") +
+		" /usr/share/roundcube/bin/initdb.sh --dir=/usr/share/roundcube/SQL"
+	if !slices.Contains(runner.commands, initdb) {
+		t.Fatalf("expected %q, got %v", initdb, runner.commands)
+	}
+	vhost, err := os.ReadFile(filepath.Join(opts.NginxSitesAvailableDir, "aipanel.conf")) //nolint:gosec // test reads file generated in temp dir.
+	if err != nil {
+		t.Fatalf("read panel vhost: %v", err)
+	}
+	for _, want := range []string{
+		"location ^~ /webmail/ {",
+		"alias /usr/share/roundcube/public_html/;",
+		"fastcgi_param SCRIPT_FILENAME /usr/share/roundcube/public_html/$1.php;",
+	} {
+		if !strings.Contains(string(vhost), want) {
+			t.Fatalf("expected %q in panel vhost, got:\n%s", want, vhost)
+		}
+	}
+	if !strings.Contains(string(vhost)[strings.Index(string(vhost), "location ^~ /webmail/"):], "auth_request /_aipanel_auth;") {
+		t.Fatalf("expected webmail route behind panel session check")
+	}
+}
+
 func TestInstallerRun_OnlyInstallPHPMyAdminRequiresRoot(t *testing.T) {
 	opts := DefaultOptions()
 	opts.OnlyStep = steps.InstallPHPMyAdmin
@@ -1237,6 +2214,57 @@ func TestResolveRuntimeSourceLock_DownloadsFromURLAndPersists(t *testing.T) {
 	}
 }
 
+func TestResolveRuntimeSourceLock_URLRefreshKeepsCustomComponents(t *testing.T) {
+	root := t.TempDir()
+	upstream := &RuntimeSourceLock{SchemaVersion: 1, Channels: map[string]RuntimeChannelLock{
+		"stable": {"nginx": RuntimeComponentLock{
+			Version:      "1.29.6",
+			SourceURL:    "https://nginx.org/download/nginx-1.29.6.tar.gz",
+			SourceSHA256: strings.Repeat("1", 64),
+		}},
+	}}
+	sourceLockPath := filepath.Join(root, "source.lock.json")
+	if err := WriteRuntimeSourceLock(sourceLockPath, upstream); err != nil {
+		t.Fatalf("write upstream lock: %v", err)
+	}
+	local := &RuntimeSourceLock{SchemaVersion: 1, Channels: map[string]RuntimeChannelLock{
+		"stable": {"nginx": RuntimeComponentLock{
+			Version:      "1.29.5",
+			SourceURL:    "https://nginx.org/download/nginx-1.29.5.tar.gz",
+			SourceSHA256: strings.Repeat("2", 64),
+		}},
+	}}
+	if err := AddRuntimeComponent(local, "varnish", RuntimeComponentLock{
+		Version:      "7.7.1",
+		SourceURL:    "https://varnish-cache.org/downloads/varnish-7.7.1.tgz",
+		SourceSHA256: strings.Repeat("3", 64),
+		Build:        RuntimeBuildSpec{Commands: []string{"make install"}},
+		Systemd:      RuntimeSystemdUnitSpec{Name: "aipanel-runtime-varnish.service", ExecStart: "{{install_dir}}/sbin/varnishd -F"},
+		Validation:   RuntimeValidationSpec{Command: "{{install_dir}}/sbin/varnishd -V"},
+	}, nil, false); err != nil {
+		t.Fatalf("add varnish: %v", err)
+	}
+	lockPath := filepath.Join(root, "sources.lock.json")
+	if err := WriteRuntimeSourceLock(lockPath, local); err != nil {
+		t.Fatalf("write local lock: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.RuntimeLockPath = lockPath
+	opts.RuntimeLockURL = "file://" + sourceLockPath
+	lock, err := New(opts, &fakeRunner{}).resolveRuntimeSourceLock(context.Background())
+	if err != nil {
+		t.Fatalf("resolve runtime lock from URL: %v", err)
+	}
+	if lock.Channels["stable"]["nginx"].Version != "1.29.6" || lock.Channels["stable"]["varnish"].Version != "7.7.1" {
+		t.Fatalf("expected upstream nginx and the local varnish, got %+v", lock.Channels["stable"])
+	}
+	persisted, err := LoadRuntimeSourceLock(lockPath)
+	if err != nil || persisted.Channels["stable"]["varnish"].Validation.Command != "{{install_dir}}/sbin/varnishd -V" {
+		t.Fatalf("expected varnish persisted, got %+v (%v)", persisted, err)
+	}
+}
+
 func writeTarGzArtifact(path string, name string, content []byte) error {
 	return writeTarGzArtifactEntries(path, map[string][]byte{
 		name: content,
@@ -1278,3 +2306,159 @@ func writeTarGzArtifactEntries(path string, entries map[string][]byte) error {
 	}
 	return f.Close()
 }
+
+func TestInstallRuntimeComponentFromBinary_UnpacksVerifiedArtifact(t *testing.T) {
+	root := t.TempDir()
+	artifact := filepath.Join(root, "nginx-1.27.4-debian13-amd64.tar.gz")
+	if err := writeTarGzArtifactEntries(artifact, map[string][]byte{
+		"nginx-1.27.4/sbin/nginx":      []byte("prebuilt-nginx"),
+		"nginx-1.27.4/conf/nginx.conf": []byte("events {}"),
+	}); err != nil {
+		t.Fatalf("write artifact: %v", err)
+	}
+	sum, err := fileSHA256(artifact)
+	if err != nil {
+		t.Fatalf("artifact sha: %v", err)
+	}
+	signature := filepath.Join(root, "nginx.tar.gz.asc")
+	if err := os.WriteFile(signature, []byte("signature"), 0o600); err != nil {
+		t.Fatalf("write signature: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.InstallMode = InstallModeBinary
+	// Artifacts are verified even when upstream source checks are off.
+	opts.VerifyUpstreamSources = false
+	opts.RuntimeInstallDir = filepath.Join(root, "runtime")
+	opts.LogFilePath = filepath.Join(root, "install.log")
+	runner := &fakeRunner{}
+	ins := &Installer{opts: opts, runner: runner, now: time.Now}
+	component := RuntimeComponentLock{
+		Version:      "1.27.4",
+		SourceURL:    "https://nginx.org/download/nginx-1.27.4.tar.gz",
+		SourceSHA256: strings.Repeat("a", 64),
+		Binary: RuntimeBinarySpec{
+			URL:                  "file://" + artifact,
+			SHA256:               sum,
+			SignatureURL:         "file://" + signature,
+			PublicKeyFingerprint: "177F4010FE56CA3336300305F1656F24C74CD1D8",
+		},
+	}
+	if err := ins.installRuntimeComponentFromBinary(context.Background(), "nginx", component); err != nil {
+		t.Fatalf("install from binary failed: %v", err)
+	}
+
+	versionDir := filepath.Join(opts.RuntimeInstallDir, "nginx", "1.27.4")
+	body, err := os.ReadFile(filepath.Join(versionDir, "sbin", "nginx")) //nolint:gosec // test reads file generated in temp dir.
+	if err != nil {
+		t.Fatalf("read installed binary: %v", err)
+	}
+	if string(body) != "prebuilt-nginx" {
+		t.Fatalf("unexpected installed payload: %q", string(body))
+	}
+	info, err := os.Stat(filepath.Join(versionDir, "sbin", "nginx"))
+	if err != nil {
+		t.Fatalf("stat installed binary: %v", err)
+	}
+	if info.Mode().Perm() != 0o755 {
+		t.Fatalf("expected world-executable binary, got %v", info.Mode().Perm())
+	}
+	if !strings.Contains(strings.Join(runner.commands, "\n"), "gpg --batch --verify") {
+		t.Fatalf("expected signature verification, got:\n%s", strings.Join(runner.commands, "\n"))
+	}
+	target, err := os.Readlink(filepath.Join(opts.RuntimeInstallDir, "nginx", "current"))
+	if err != nil || target != versionDir {
+		t.Fatalf("unexpected current symlink %q: %v", target, err)
+	}
+	entries, err := os.ReadDir(filepath.Join(opts.RuntimeInstallDir, "nginx"))
+	if err != nil {
+		t.Fatalf("read component dir: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected only the version dir and current symlink, got %d entries", len(entries))
+	}
+
+	needsUpdate, reason, err := ins.runtimeComponentNeedsUpdate("nginx", component)
+	if err != nil || needsUpdate {
+		t.Fatalf("expected installed binary to be current, got %t (%s): %v", needsUpdate, reason, err)
+	}
+	component.Binary.SHA256 = strings.Repeat("b", 64)
+	if needsUpdate, _, _ := ins.runtimeComponentNeedsUpdate("nginx", component); !needsUpdate {
+		t.Fatal("expected a changed artifact checksum to require an update")
+	}
+
+	component.Binary.SHA256 = strings.Repeat("c", 64)
+	if err := ins.installRuntimeComponentFromBinary(context.Background(), "nginx", component); err == nil ||
+		!strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+}
+
+func TestExtractInstallTree_RejectsEscapingSymlinks(t *testing.T) {
+	for name, links := range map[string][][2]string{
+		"absolute": {{"tree/lib/libx.so", "/etc/passwd"}},
+		"relative": {{"tree/lib/libx.so", "../../../etc/passwd"}},
+		"chained":  {{"up", "."}, {"up/out", ".."}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			archive := filepath.Join(root, "tree.tar")
+			f, err := os.Create(archive) //nolint:gosec // Test writes fixture under t.TempDir.
+			if err != nil {
+				t.Fatalf("create archive: %v", err)
+			}
+			tw := tar.NewWriter(f)
+			for _, link := range links {
+				if err := tw.WriteHeader(&tar.Header{Name: link[0], Typeflag: tar.TypeSymlink, Linkname: link[1], Mode: 0o777}); err != nil {
+					t.Fatalf("write header: %v", err)
+				}
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatalf("close tar: %v", err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatalf("close archive: %v", err)
+			}
+			dest := filepath.Join(root, "out")
+			if err := os.MkdirAll(dest, 0o750); err != nil {
+				t.Fatalf("mkdir dest: %v", err)
+			}
+			if err := extractInstallTree(archive, dest); err == nil {
+				t.Fatal("expected escaping symlink to be rejected")
+			}
+		})
+	}
+}
+
+func TestCreateAdminUser_SetupWizardStoresTokenHash(t *testing.T) {
+	root := t.TempDir()
+	opts := DefaultOptions()
+	opts.DataDir = filepath.Join(root, "data")
+	opts.LogFilePath = filepath.Join(root, "install.log")
+	opts.SetupWizard = true
+	opts.SetupToken = strings.Repeat("ef", 16)
+	ins := New(opts, &fakeRunner{})
+
+	if err := ins.createAdminUser(context.Background()); err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+	raw, err := os.ReadFile(iam.SetupTokenPath(opts.DataDir))
+	if err != nil {
+		t.Fatalf("read setup token: %v", err)
+	}
+	if strings.Contains(string(raw), opts.SetupToken) {
+		t.Fatalf("expected only the token hash on disk, got %q", raw)
+	}
+	log, err := os.ReadFile(opts.LogFilePath)
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+	if strings.Contains(string(log), opts.SetupToken) {
+		t.Fatalf("expected setup token to stay out of the log, got:\n%s", log)
+	}
+
+	opts.SetupToken = "short"
+	if err := opts.validate(); err == nil {
+		t.Fatal("expected a short setup token to be rejected")
+	}
+}
@@ -177,7 +177,7 @@ func TestOptionsValidate(t *testing.T) {
 	})
 
 	t.Run("mysql and mariadb component names are distinct", func(t *testing.T) {
-		components, runtimeOnly, err := parseRuntimeOnlyComponents("mysql,mariadb")
+		components, runtimeOnly, err := parseRuntimeOnlyComponents("mysql,mariadb", nil)
 		if err != nil {
 			t.Fatalf("expected mysql and mariadb names to parse, got %v", err)
 		}
@@ -0,0 +1,45 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/robsonek/aiPanel/internal/platform/ports"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+	"github.com/robsonek/aiPanel/internal/platform/systemd"
+)
+
+// Port owners recorded in panel.db by the installer.
+const (
+	portOwnerPanel       = "panel"
+	portOwnerPGAdmin     = "pgadmin"
+	portOwnerNginxStatus = "nginx-status"
+)
+
+// reservePort records the TCP port of addr for owner before unit is
+// started, so a clash surfaces as a clear error rather than a failed
+// systemd start. A unit that is already running keeps its port.
+func (i *Installer) reservePort(ctx context.Context, owner, unit, addr string) error {
+	port := ports.PortOf(addr)
+	if port == 0 {
+		return nil
+	}
+	// Init is idempotent and lets --only-step runs preflight on a host
+	// whose databases were never initialised.
+	store := sqlite.New(pathInRootFS(i.opts.RootFSPath, i.opts.DataDir))
+	if err := store.Init(ctx); err != nil {
+		return fmt.Errorf("port preflight for %s: %w", unit, err)
+	}
+	alloc := ports.New(store)
+	if active, _ := systemd.IsActive(ctx, i.runner, unit); active {
+		if err := alloc.Claim(ctx, owner, port); err != nil {
+			return fmt.Errorf("port preflight for %s: %w", unit, err)
+		}
+		return nil
+	}
+	if err := alloc.Reserve(ctx, owner, port); err != nil {
+		return fmt.Errorf("port preflight for %s: %w", unit, err)
+	}
+	i.logf("[ports] reserved %d for %s", port, owner)
+	return nil
+}
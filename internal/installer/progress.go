@@ -0,0 +1,78 @@
+package installer
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/installer/steps"
+)
+
+// Progress receives installer events to drive a live display such as the
+// terminal UI. Methods are called from the installer goroutine and must
+// return quickly.
+type Progress interface {
+	// Plan lists the steps of the run in order, before the first starts.
+	// Started steps may carry a "[scope]" suffix, e.g. install_runtime[nginx].
+	Plan(steps []string)
+	StepStarted(name string)
+	// StepFinished reports status "ok", "skipped" or "failed".
+	StepFinished(name, status string)
+	// StepDetail describes what the current step is doing, e.g. which
+	// component it builds.
+	StepDetail(detail string)
+	// Download reports bytes received for ref; total is -1 when unknown.
+	Download(ref string, done, total int64)
+	// Log receives every log line in place of stderr.
+	Log(line string)
+}
+
+// SetProgress sends step events and log lines to p. While set, log lines
+// go to p instead of stderr; the log file still gets all of them.
+func (i *Installer) SetProgress(p Progress) {
+	i.progress = p
+}
+
+func (i *Installer) stepDetail(format string, args ...any) {
+	if i.progress != nil {
+		i.progress.StepDetail(fmt.Sprintf(format, args...))
+	}
+}
+
+// progressReader reports download progress while body is read.
+type progressReader struct {
+	r        io.Reader
+	ref      string
+	done     int64
+	total    int64
+	progress Progress
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.done += int64(n)
+	p.progress.Download(p.ref, p.done, p.total)
+	return n, err
+}
+
+// planStepNames returns the steps a run will execute, for Progress.Plan.
+func planStepNames(onlyStep string, plan []string, custom map[string]struct{}) []string {
+	onlyStep = strings.ToLower(strings.TrimSpace(onlyStep))
+	if onlyStep == "" {
+		return plan
+	}
+	if components, runtimeAlias, err := parseRuntimeOnlyComponents(onlyStep, custom); err == nil && runtimeAlias {
+		scope := "[" + strings.Join(components, ",") + "]"
+		return []string{
+			steps.InstallPkgs + scope,
+			steps.InstallRuntime + scope,
+			steps.ActivateRuntime + scope,
+		}
+	}
+	for _, name := range plan {
+		if strings.EqualFold(name, onlyStep) {
+			return []string{name}
+		}
+	}
+	return nil
+}
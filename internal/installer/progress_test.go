@@ -0,0 +1,20 @@
+package installer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPlanStepNames(t *testing.T) {
+	plan := []string{"preflight", "install_packages", "install_runtime", "activate_runtime_services", "write_config"}
+	if got := planStepNames("", plan, nil); strings.Join(got, ",") != strings.Join(plan, ",") {
+		t.Fatalf("expected full plan, got %v", got)
+	}
+	if got := planStepNames("Write_Config", plan, nil); strings.Join(got, ",") != "write_config" {
+		t.Fatalf("expected single step, got %v", got)
+	}
+	got := planStepNames("nginx", plan, nil)
+	if strings.Join(got, ",") != "install_packages[nginx],install_runtime[nginx],activate_runtime_services[nginx]" {
+		t.Fatalf("expected scoped runtime steps, got %v", got)
+	}
+}
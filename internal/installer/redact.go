@@ -0,0 +1,73 @@
+package installer
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const redactedMark = "[REDACTED]"
+
+// minSecretLength keeps short values such as "root" from masking every
+// occurrence of a common word.
+const minSecretLength = 6
+
+// secretPatterns mask values that look like credentials even when the
+// installer did not register them: key=value and key: "value" pairs, CLI
+// password flags and SQL IDENTIFIED BY clauses. Group 1 is kept. Values
+// starting with $ are shell expansions that read the secret from a file,
+// so they are left alone.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(\b[a-z0-9_]*(?:password|passwd|pwd|secret|token|api[_-]?key)["']?\s*[:=]\s*["']?)[^$\s"',;&)}][^\s"',;&)}]*`),
+	regexp.MustCompile(`(?i)(--?(?:password|passwd)[= ]+["']?)[^$\s"']\S*`),
+	regexp.MustCompile(`(?i)(identified\s+by\s+')[^']*`),
+}
+
+// redactor masks secrets in log lines and report entries.
+type redactor struct {
+	mu     sync.Mutex
+	values []string
+}
+
+// add registers a secret value to mask wherever it appears.
+func (r *redactor) add(value string) {
+	value = strings.TrimSpace(value)
+	if len(value) < minSecretLength {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, known := range r.values {
+		if known == value {
+			return
+		}
+	}
+	r.values = append(r.values, value)
+	// Longest first, so a secret containing another is masked whole.
+	sort.Slice(r.values, func(a, b int) bool { return len(r.values[a]) > len(r.values[b]) })
+}
+
+// apply returns s with registered values and secret patterns masked.
+func (r *redactor) apply(s string) string {
+	r.mu.Lock()
+	for _, value := range r.values {
+		s = strings.ReplaceAll(s, value, redactedMark)
+	}
+	r.mu.Unlock()
+	for _, pattern := range secretPatterns {
+		s = pattern.ReplaceAllString(s, "${1}"+redactedMark)
+	}
+	return s
+}
+
+// redactedError masks secrets in the message of err while keeping it
+// available to errors.Is and errors.As.
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e *redactedError) Error() string { return e.msg }
+
+func (e *redactedError) Unwrap() error { return e.err }
@@ -0,0 +1,71 @@
+package installer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedactor_MasksSecretPatterns(t *testing.T) {
+	var r redactor
+	cases := map[string]string{
+		`export PGADMIN_SETUP_PASSWORD=hunter2hunter2 && run`:       `export PGADMIN_SETUP_PASSWORD=[REDACTED] && run`,
+		`createUser({user: "aipanel", pwd: "0123abcd", roles: []})`: `createUser({user: "aipanel", pwd: "[REDACTED]", roles: []})`,
+		`aipanel admin create --password Secret123! --email a@b.c`:  `aipanel admin create --password [REDACTED] --email a@b.c`,
+		`mysql -e "CREATE USER x IDENTIFIED BY 'p4ss w0rd'"`:        `mysql -e "CREATE USER x IDENTIFIED BY '[REDACTED]'"`,
+		`{"api_key": "abc123"}`:                                     `{"api_key": "[REDACTED]"}`,
+		// Shell expansions read the secret from a file and stay readable.
+		`export PGADMIN_SETUP_PASSWORD=$(cat '/tmp/creds/password')`: `export PGADMIN_SETUP_PASSWORD=$(cat '/tmp/creds/password')`,
+		`cd "$(pwd)" && make install`:                                `cd "$(pwd)" && make install`,
+	}
+	for in, want := range cases {
+		if got := r.apply(in); got != want {
+			t.Errorf("apply(%q)\n got %q\nwant %q", in, got, want)
+		}
+	}
+}
+
+func TestRedactor_MasksRegisteredValues(t *testing.T) {
+	var r redactor
+	r.add("root")
+	r.add("s3cr3t-value")
+	r.add("s3cr3t-value-longer")
+	got := r.apply("user root s3cr3t-value-longer and s3cr3t-value")
+	if got != "user root [REDACTED] and [REDACTED]" {
+		t.Fatalf("unexpected redaction: %q", got)
+	}
+}
+
+func TestInstallerLogs_RedactSecrets(t *testing.T) {
+	root := t.TempDir()
+	opts := DefaultOptions()
+	opts.LogFilePath = filepath.Join(root, "install.log")
+	opts.AdminPassword = "VeryStrongPass123!"
+	runner := &fakeRunnerWithErrors{failCommands: map[string]bool{"bash -lc echo VeryStrongPass123!": true}}
+	ins := New(opts, runner)
+
+	_, err := ins.runner.Run(context.Background(), "bash", "-lc", "echo VeryStrongPass123!")
+	if err == nil {
+		t.Fatal("expected command to fail")
+	}
+	var redacted *redactedError
+	if !errors.As(err, &redacted) || strings.Contains(err.Error(), "VeryStrongPass123!") {
+		t.Fatalf("expected password to be masked in the error, got %v", err)
+	}
+	ins.logf("[create_admin] password=%s", "generated-secret")
+
+	raw, readErr := os.ReadFile(opts.LogFilePath)
+	if readErr != nil {
+		t.Fatalf("read log: %v", readErr)
+	}
+	log := string(raw)
+	if strings.Contains(log, "VeryStrongPass123!") || strings.Contains(log, "generated-secret") {
+		t.Fatalf("expected secrets to be masked in the log, got:\n%s", log)
+	}
+	if !strings.Contains(log, "echo [REDACTED]") {
+		t.Fatalf("expected masked command in the log, got:\n%s", log)
+	}
+}
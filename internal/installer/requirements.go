@@ -0,0 +1,281 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// componentFootprint is the estimated cost of one runtime component: what
+// it needs once running and, separately, the peak of compiling it here.
+type componentFootprint struct {
+	RunMemoryMB   int
+	RunDiskMB     int
+	BuildMemoryMB int
+	BuildDiskMB   int
+}
+
+// componentFootprints are conservative estimates for Debian 13 amd64 with
+// make -j$(nproc). MariaDB and MySQL link a handful of huge translation
+// units that each need well over 1 GB; MongoDB is worse still.
+var componentFootprints = map[string]componentFootprint{
+	"nginx":      {RunMemoryMB: 64, RunDiskMB: 100, BuildMemoryMB: 512, BuildDiskMB: 500},
+	"php-fpm":    {RunMemoryMB: 256, RunDiskMB: 500, BuildMemoryMB: 2048, BuildDiskMB: 2000},
+	"mariadb":    {RunMemoryMB: 512, RunDiskMB: 2000, BuildMemoryMB: 4096, BuildDiskMB: 6000},
+	"mysql":      {RunMemoryMB: 512, RunDiskMB: 2000, BuildMemoryMB: 4096, BuildDiskMB: 8000},
+	"postgresql": {RunMemoryMB: 256, RunDiskMB: 500, BuildMemoryMB: 1024, BuildDiskMB: 1500},
+	"mongodb":    {RunMemoryMB: 1024, RunDiskMB: 2000, BuildMemoryMB: 8192, BuildDiskMB: 20000},
+	"memcached":  {RunMemoryMB: 64, RunDiskMB: 20, BuildMemoryMB: 256, BuildDiskMB: 100},
+}
+
+// compileCommandPattern matches build commands that compile rather than
+// unpack a prebuilt tree.
+var compileCommandPattern = regexp.MustCompile(`(^|[\s;&|(])(\./configure|configure|make|cmake|ninja|meson|scons)(\s|$)`)
+
+// swapRoundMB rounds automatic swapfiles up to whole gigabytes.
+const swapRoundMB = 1024
+
+// Bounds of a requested swapfile (--create-swap).
+const (
+	MinSwapMB = 256
+	MaxSwapMB = 64 * 1024
+)
+
+// swapSizePattern matches "2048", "2048M", "2G", "2GB" and the like.
+var swapSizePattern = regexp.MustCompile(`^(\d+)\s*([MG]?)B?$`)
+
+// resourceRequirements is the memory and disk an install needs, derived
+// from the selected runtime components.
+type resourceRequirements struct {
+	MemoryMB int
+	DiskGB   int
+	// RunMemoryMB is the baseline plus every component running.
+	RunMemoryMB int
+	// BuildMemoryMB is the peak of the largest local compile; 0 when
+	// nothing is compiled on this host.
+	BuildMemoryMB int
+	// Components are the selected runtime components; Compiled the subset
+	// built from source on this host.
+	Components []string
+	Compiled   []string
+}
+
+// compilesLocally reports whether a component's build commands compile
+// sources on the target instead of copying a prebuilt tree.
+func compilesLocally(component RuntimeComponentLock) bool {
+	for _, command := range component.Build.Commands {
+		if compileCommandPattern.MatchString(command) {
+			return true
+		}
+	}
+	return false
+}
+
+// computeRequirements adds the footprint of each selected component to the
+// panel baseline (MinMemoryMB, MinDiskGB). Builds run one at a time, so
+// memory is the larger of the steady-state total and the biggest compile;
+// disk keeps every installed tree plus scratch for the biggest compile.
+// With buildLocally false (binary mode) nothing is compiled here.
+func computeRequirements(baseMemoryMB, baseDiskGB int, channel RuntimeChannelLock, buildLocally bool) resourceRequirements {
+	req := resourceRequirements{Components: make([]string, 0, len(channel))}
+	for name := range channel {
+		req.Components = append(req.Components, name)
+	}
+	sort.Strings(req.Components)
+
+	req.RunMemoryMB = baseMemoryMB
+	diskMB := baseDiskGB * 1024
+	buildDiskMB := 0
+	for _, name := range req.Components {
+		footprint := componentFootprints[name]
+		req.RunMemoryMB += footprint.RunMemoryMB
+		diskMB += footprint.RunDiskMB
+		if !buildLocally || !compilesLocally(channel[name]) {
+			continue
+		}
+		req.Compiled = append(req.Compiled, name)
+		req.BuildMemoryMB = max(req.BuildMemoryMB, footprint.BuildMemoryMB)
+		buildDiskMB = max(buildDiskMB, footprint.BuildDiskMB)
+	}
+	req.MemoryMB = max(req.RunMemoryMB, req.BuildMemoryMB)
+	req.DiskGB = ceilDiv(diskMB+buildDiskMB, 1024)
+	return req
+}
+
+// installRequirements resolves the runtime components this run installs
+// and computes their requirements. Runs that install no runtime components
+// only need the panel baseline.
+func (i *Installer) installRequirements(ctx context.Context) (resourceRequirements, error) {
+	base := resourceRequirements{MemoryMB: i.opts.MinMemoryMB, RunMemoryMB: i.opts.MinMemoryMB, DiskGB: i.opts.MinDiskGB}
+	if !usesRuntimeLock(i.opts.InstallMode) || !requiresRuntimeLockForStep(i.opts.OnlyStep, i.customComponents) {
+		return base, nil
+	}
+	lock, err := i.resolveRuntimeSourceLock(ctx)
+	if err != nil {
+		return base, err
+	}
+	channel, err := i.runtimeChannel(lock)
+	if err != nil {
+		return base, err
+	}
+	only, _, err := parseRuntimeOnlyComponents(i.opts.OnlyStep, i.customComponents)
+	if err != nil {
+		return base, err
+	}
+	selected, _, err := selectRuntimeComponents(channel, only)
+	if err != nil {
+		return base, err
+	}
+	return computeRequirements(i.opts.MinMemoryMB, i.opts.MinDiskGB, selected, isRuntimeSourceMode(i.opts.InstallMode)), nil
+}
+
+// ParseSwapSize parses a swapfile size such as "2G" or "1536M"; a bare
+// number is in MB.
+func ParseSwapSize(raw string) (int, error) {
+	m := swapSizePattern.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(raw)))
+	if m == nil {
+		return 0, fmt.Errorf("invalid swap size %q (e.g. 2G or 1536M)", raw)
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid swap size %q: %w", raw, err)
+	}
+	if m[2] == "G" {
+		n *= 1024
+	}
+	if n < MinSwapMB || n > MaxSwapMB {
+		return 0, fmt.Errorf("swap size must be between %d MB and %d MB", MinSwapMB, MaxSwapMB)
+	}
+	return n, nil
+}
+
+// createRequestedSwap creates the swapfile asked for with CreateSwapMB and
+// returns its size. A swapfile already at SwapFilePath is kept, so reruns
+// do not fail.
+func (i *Installer) createRequestedSwap(ctx context.Context) (int, error) {
+	if i.opts.CreateSwapMB <= 0 {
+		return 0, nil
+	}
+	if _, err := os.Stat(i.opts.SwapFilePath); err == nil {
+		i.logf("[preflight] swapfile %s already exists; not creating another", i.opts.SwapFilePath)
+		return 0, nil
+	}
+	i.logf("[preflight] creating a %d MB swapfile at %s", i.opts.CreateSwapMB, i.opts.SwapFilePath)
+	if err := i.createSwapfile(ctx, i.opts.CreateSwapMB); err != nil {
+		return 0, err
+	}
+	return i.opts.CreateSwapMB, nil
+}
+
+// checkMemory compares RAM and swap against req; addedSwapMB is swap
+// created earlier in this run, which meminfo may not show yet. Below
+// MinMemoryMB the install is refused. A local compile that does not fit in
+// RAM plus swap gets a swapfile sized to the deficit; any other shortfall
+// is a warning, since the steady-state figures are estimates.
+func (i *Installer) checkMemory(ctx context.Context, req resourceRequirements, addedSwapMB int) error {
+	memMB, err := TotalMemoryMB(i.opts.MemInfoPath)
+	if err != nil {
+		return fmt.Errorf("read memory info: %w", err)
+	}
+	if memMB < i.opts.MinMemoryMB {
+		return fmt.Errorf("insufficient memory: need at least %d MB", i.opts.MinMemoryMB)
+	}
+	if memMB >= req.MemoryMB {
+		return nil
+	}
+	swapMB, err := meminfoMB(i.opts.MemInfoPath, "SwapTotal")
+	if err != nil {
+		swapMB = 0
+	}
+	swapMB += addedSwapMB
+	if req.RunMemoryMB > memMB {
+		i.logf("[preflight] warning: %d MB RAM is below the %d MB recommended to run %s",
+			memMB, req.RunMemoryMB, strings.Join(req.Components, ", "))
+	}
+	if req.BuildMemoryMB <= memMB {
+		return nil
+	}
+	compiled := strings.Join(req.Compiled, ", ")
+	if req.BuildMemoryMB <= memMB+swapMB {
+		i.logf("[preflight] warning: compiling %s needs about %d MB; relying on %d MB swap", compiled, req.BuildMemoryMB, swapMB)
+		return nil
+	}
+	sizeMB := ceilDiv(req.BuildMemoryMB-memMB-swapMB, swapRoundMB) * swapRoundMB
+	freeGB, err := freeDiskGB(i.opts.RootFSPath)
+	if err != nil {
+		return fmt.Errorf("read disk stats: %w", err)
+	}
+	if freeGB < req.DiskGB+sizeMB/1024 {
+		return fmt.Errorf("insufficient memory: compiling %s needs about %d MB and there is no disk left for a %d MB swapfile",
+			compiled, req.BuildMemoryMB, sizeMB)
+	}
+	i.logf("[preflight] compiling %s needs about %d MB but only %d MB RAM and %d MB swap are available; adding a %d MB swapfile at %s",
+		compiled, req.BuildMemoryMB, memMB, swapMB, sizeMB, i.opts.SwapFilePath)
+	return i.createSwapfile(ctx, sizeMB)
+}
+
+// createSwapfile creates, enables and persists a swapfile of sizeMB at
+// SwapFilePath. An existing file at that path is never overwritten.
+func (i *Installer) createSwapfile(ctx context.Context, sizeMB int) error {
+	path := i.opts.SwapFilePath
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("swapfile %s already exists but memory is still short; remove it and rerun with a larger --create-swap", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create swapfile dir: %w", err)
+	}
+	commands := [][]string{
+		{"fallocate", "-l", fmt.Sprintf("%dM", sizeMB), path},
+		{"chmod", "600", path},
+		{"mkswap", path},
+		{"swapon", path},
+	}
+	for _, command := range commands {
+		if _, err := i.runner.Run(ctx, command[0], command[1:]...); err != nil {
+			_ = os.Remove(path)
+			return fmt.Errorf("create swapfile: %w", err)
+		}
+	}
+	return appendFstabEntry(i.opts.FstabPath, path+" none swap sw 0 0")
+}
+
+// appendFstabEntry adds line to fstab unless an entry for the same device
+// is already present.
+func appendFstabEntry(fstabPath, line string) error {
+	device := strings.Fields(line)[0]
+	// Installer controls fstab path in runtime options.
+	//nolint:gosec // G304
+	existing, err := os.ReadFile(fstabPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read fstab: %w", err)
+	}
+	for _, entry := range strings.Split(string(existing), "\n") {
+		if fields := strings.Fields(entry); len(fields) > 0 && fields[0] == device {
+			return nil
+		}
+	}
+	if len(existing) > 0 && !strings.HasSuffix(string(existing), "\n") {
+		line = "\n" + line
+	}
+	//nolint:gosec // fstab must stay world-readable.
+	f, err := os.OpenFile(fstabPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open fstab: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		return fmt.Errorf("write fstab: %w", err)
+	}
+	return nil
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
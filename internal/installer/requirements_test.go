@@ -0,0 +1,140 @@
+package installer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestComputeRequirements_ScalesWithComponentsAndLocalBuilds(t *testing.T) {
+	channel := RuntimeChannelLock{
+		"nginx":   {Build: RuntimeBuildSpec{Commands: []string{"./configure --prefix={{install_dir}}", "make -j$(nproc)"}}},
+		"mariadb": {Build: RuntimeBuildSpec{Commands: []string{"cp -a . {{install_dir}}/"}}},
+	}
+	req := computeRequirements(1024, 10, channel, true)
+	if strings.Join(req.Compiled, ",") != "nginx" {
+		t.Fatalf("expected only nginx to compile locally, got %v", req.Compiled)
+	}
+	if req.RunMemoryMB != 1024+64+512 || req.BuildMemoryMB != 512 || req.MemoryMB != req.RunMemoryMB {
+		t.Fatalf("unexpected memory requirements %+v", req)
+	}
+	if req.DiskGB != ceilDiv(10*1024+100+2000+500, 1024) {
+		t.Fatalf("unexpected disk requirement %d", req.DiskGB)
+	}
+
+	channel["mariadb"] = RuntimeComponentLock{Build: RuntimeBuildSpec{Commands: []string{"cmake -S . -B build", "cmake --build build"}}}
+	req = computeRequirements(1024, 10, channel, true)
+	if req.BuildMemoryMB != 4096 || req.MemoryMB != 4096 {
+		t.Fatalf("expected mariadb source build to dominate memory, got %+v", req)
+	}
+
+	req = computeRequirements(1024, 10, channel, false)
+	if len(req.Compiled) != 0 || req.BuildMemoryMB != 0 || req.MemoryMB != req.RunMemoryMB {
+		t.Fatalf("expected no local builds in binary mode, got %+v", req)
+	}
+}
+
+func newRequirementsInstaller(t *testing.T, meminfo string, runner *fakeRunner) *Installer {
+	t.Helper()
+	root := t.TempDir()
+	memInfo := filepath.Join(root, "meminfo")
+	if err := os.WriteFile(memInfo, []byte(meminfo), 0o600); err != nil {
+		t.Fatalf("write meminfo: %v", err)
+	}
+	opts := DefaultOptions()
+	opts.MemInfoPath = memInfo
+	opts.RootFSPath = root
+	opts.SwapFilePath = filepath.Join(root, "swapfile")
+	opts.FstabPath = filepath.Join(root, "etc", "fstab")
+	opts.LogFilePath = filepath.Join(root, "install.log")
+	opts.MinDiskGB = 1
+	if err := os.MkdirAll(filepath.Dir(opts.FstabPath), 0o750); err != nil {
+		t.Fatalf("mkdir etc: %v", err)
+	}
+	return New(opts, runner)
+}
+
+func TestCheckMemory_AddsSwapfileForLocalCompile(t *testing.T) {
+	runner := &fakeRunner{}
+	ins := newRequirementsInstaller(t, "MemTotal:       2097152 kB\nSwapTotal:             0 kB\n", runner)
+	if err := os.WriteFile(ins.opts.FstabPath, []byte("UUID=abc / ext4 defaults 0 1"), 0o600); err != nil {
+		t.Fatalf("write fstab: %v", err)
+	}
+	req := resourceRequirements{MemoryMB: 4096, RunMemoryMB: 1600, BuildMemoryMB: 4096, DiskGB: 1, Components: []string{"mariadb"}, Compiled: []string{"mariadb"}}
+
+	if err := ins.checkMemory(context.Background(), req, 0); err != nil {
+		t.Fatalf("check memory: %v", err)
+	}
+	joined := strings.Join(runner.commands, "\n")
+	for _, want := range []string{"fallocate -l 2048M " + ins.opts.SwapFilePath, "chmod 600 " + ins.opts.SwapFilePath, "mkswap " + ins.opts.SwapFilePath, "swapon " + ins.opts.SwapFilePath} {
+		if !strings.Contains(joined, want) {
+			t.Fatalf("expected %q, got:\n%s", want, joined)
+		}
+	}
+	fstab, err := os.ReadFile(ins.opts.FstabPath)
+	if err != nil {
+		t.Fatalf("read fstab: %v", err)
+	}
+	if want := "UUID=abc / ext4 defaults 0 1\n" + ins.opts.SwapFilePath + " none swap sw 0 0\n"; string(fstab) != want {
+		t.Fatalf("unexpected fstab:\n%s", fstab)
+	}
+}
+
+func TestCheckMemory_ExistingSwapOnlyWarns(t *testing.T) {
+	runner := &fakeRunner{}
+	ins := newRequirementsInstaller(t, "MemTotal:       2097152 kB\nSwapTotal:       2097152 kB\n", runner)
+	req := resourceRequirements{MemoryMB: 4096, RunMemoryMB: 1600, BuildMemoryMB: 4096, DiskGB: 1, Components: []string{"mariadb"}, Compiled: []string{"mariadb"}}
+	if err := ins.checkMemory(context.Background(), req, 0); err != nil {
+		t.Fatalf("check memory: %v", err)
+	}
+	if len(runner.commands) != 0 {
+		t.Fatalf("expected no swap commands, got %v", runner.commands)
+	}
+
+	ins = newRequirementsInstaller(t, "MemTotal:        524288 kB\n", runner)
+	if err := ins.checkMemory(context.Background(), req, 0); err == nil || !strings.Contains(err.Error(), "need at least 1024 MB") {
+		t.Fatalf("expected hard memory floor, got %v", err)
+	}
+}
+
+func TestParseSwapSize(t *testing.T) {
+	for raw, want := range map[string]int{"2G": 2048, "2gb": 2048, "1536M": 1536, "1024": 1024} {
+		got, err := ParseSwapSize(raw)
+		if err != nil || got != want {
+			t.Fatalf("ParseSwapSize(%q) = %d, %v; want %d", raw, got, err, want)
+		}
+	}
+	for _, raw := range []string{"", "2T", "-1G", "128M", "100G"} {
+		if _, err := ParseSwapSize(raw); err == nil {
+			t.Fatalf("ParseSwapSize(%q) should fail", raw)
+		}
+	}
+}
+
+func TestCreateRequestedSwap_CountsTowardBuildMemory(t *testing.T) {
+	runner := &fakeRunner{}
+	ins := newRequirementsInstaller(t, "MemTotal:       2097152 kB\n", runner)
+	ins.opts.CreateSwapMB = 2048
+	added, err := ins.createRequestedSwap(context.Background())
+	if err != nil || added != 2048 {
+		t.Fatalf("create requested swap: added=%d err=%v", added, err)
+	}
+	if err := os.WriteFile(ins.opts.SwapFilePath, nil, 0o600); err != nil {
+		t.Fatalf("write swapfile: %v", err)
+	}
+	req := resourceRequirements{MemoryMB: 4096, RunMemoryMB: 1600, BuildMemoryMB: 4096, DiskGB: 1, Components: []string{"mariadb"}, Compiled: []string{"mariadb"}}
+	commands := len(runner.commands)
+	if err := ins.checkMemory(context.Background(), req, added); err != nil {
+		t.Fatalf("check memory: %v", err)
+	}
+	if len(runner.commands) != commands {
+		t.Fatalf("expected no automatic swapfile on top of the requested one, got %v", runner.commands[commands:])
+	}
+
+	added, err = ins.createRequestedSwap(context.Background())
+	if err != nil || added != 0 {
+		t.Fatalf("expected rerun to keep existing swapfile, added=%d err=%v", added, err)
+	}
+}
@@ -0,0 +1,240 @@
+package installer
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/installer/steps"
+)
+
+const (
+	// roundcubeIMAPHost and roundcubeSMTPHost point Roundcube at the local
+	// mail stack; SMTP authenticates with the IMAP login.
+	roundcubeIMAPHost = "localhost:143"
+	roundcubeSMTPHost = "localhost:587"
+	// roundcubeDESKeyFile keeps the session encryption key in the data dir
+	// so upgrades, which rewrite the config, do not log everyone out.
+	roundcubeDESKeyFile = "des_key"
+	roundcubeDBFile     = "roundcube.db"
+)
+
+// installRoundcube installs a pinned Roundcube release, writes its config
+// for the local IMAP/SMTP services and a SQLite database, and serves it on
+// the panel vhost behind the panel session check like the admin tools.
+func (i *Installer) installRoundcube(ctx context.Context) error {
+	if i.opts.SkipRoundcube && !strings.EqualFold(i.opts.OnlyStep, steps.InstallRoundcube) {
+		i.logf("[install_roundcube] skipped by configuration")
+		return nil
+	}
+
+	installDir := pathInRootFS(i.opts.RootFSPath, i.opts.RoundcubeInstallDir)
+	dataDir := pathInRootFS(i.opts.RootFSPath, i.opts.RoundcubeDataDir)
+	version := strings.TrimSpace(i.opts.RoundcubeVersion)
+	installed, err := ReadAdminToolVersion(installDir)
+	switch {
+	case err == nil && (installed.Version == version || !i.opts.UpgradeAdminTools):
+		i.logf("[install_roundcube] existing installation detected at %s, keeping as-is", installDir)
+		return i.configureRoundcube(ctx, installDir, dataDir, false)
+	case err == nil:
+		i.logf("[install_roundcube] upgrading %s from %q to %s", installDir, installed.Version, version)
+	case !os.IsNotExist(err):
+		return fmt.Errorf("inspect roundcube install dir: %w", err)
+	}
+
+	archiveData, err := i.downloadBytes(ctx, i.opts.RoundcubeURL)
+	if err != nil {
+		return fmt.Errorf("download roundcube archive: %w", err)
+	}
+	expectedChecksum := strings.TrimSpace(i.opts.RoundcubeSHA256)
+	actualChecksum := fmt.Sprintf("%x", sha256.Sum256(archiveData))
+	if !strings.EqualFold(expectedChecksum, actualChecksum) {
+		return fmt.Errorf("roundcube checksum mismatch: expected %s got %s", expectedChecksum, actualChecksum)
+	}
+	i.logf("[install_roundcube] checksum verified: %s", actualChecksum)
+
+	archivePath, err := writeTempBytes("aipanel-roundcube-*.tar.gz", archiveData)
+	if err != nil {
+		return fmt.Errorf("write roundcube archive temp file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(archivePath)
+	}()
+	extractDir, err := os.MkdirTemp("", "aipanel-roundcube-*")
+	if err != nil {
+		return fmt.Errorf("create roundcube extract dir: %w", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(extractDir)
+	}()
+	if err := extractArchive(archivePath, extractDir); err != nil {
+		return fmt.Errorf("extract roundcube archive: %w", err)
+	}
+	sourceDir, err := detectSourceDir(extractDir)
+	if err != nil {
+		return fmt.Errorf("detect roundcube source dir: %w", err)
+	}
+	if _, err := os.Stat(filepath.Join(sourceDir, "public_html", "index.php")); err != nil {
+		return fmt.Errorf("roundcube archive missing public_html/index.php: %w", err)
+	}
+	// The web installer is not needed and must not be reachable.
+	if err := os.RemoveAll(filepath.Join(sourceDir, "installer")); err != nil {
+		return fmt.Errorf("remove roundcube web installer: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(installDir), 0o750); err != nil {
+		return fmt.Errorf("create roundcube parent dir: %w", err)
+	}
+	if err := replaceRoundcube(sourceDir, installDir); err != nil {
+		return err
+	}
+	if err := writeAdminToolVersion(installDir, AdminToolVersion{
+		Name:    AdminToolRoundcube,
+		Version: version,
+		SHA256:  actualChecksum,
+	}); err != nil {
+		return err
+	}
+	if err := i.configureRoundcube(ctx, installDir, dataDir, true); err != nil {
+		return err
+	}
+	if err := i.configureNginx(ctx); err != nil {
+		return fmt.Errorf("configure nginx for roundcube: %w", err)
+	}
+	i.logf("[install_roundcube] installed at %s", installDir)
+	return nil
+}
+
+// replaceRoundcube copies sourceDir to installDir through a staging
+// directory, so a failed copy leaves a previous install serving.
+func replaceRoundcube(sourceDir, installDir string) error {
+	staging := installDir + ".aipanel-new"
+	previous := installDir + ".aipanel-old"
+	_ = os.RemoveAll(staging)
+	_ = os.RemoveAll(previous)
+	if err := copyDirectory(sourceDir, staging); err != nil {
+		_ = os.RemoveAll(staging)
+		return fmt.Errorf("stage roundcube files: %w", err)
+	}
+	hadPrevious := fileExists(installDir)
+	if hadPrevious {
+		if err := os.Rename(installDir, previous); err != nil {
+			_ = os.RemoveAll(staging)
+			return fmt.Errorf("move previous roundcube aside: %w", err)
+		}
+	}
+	if err := os.Rename(staging, installDir); err != nil {
+		if hadPrevious {
+			_ = os.Rename(previous, installDir)
+		}
+		_ = os.RemoveAll(staging)
+		return fmt.Errorf("activate new roundcube: %w", err)
+	}
+	if err := os.RemoveAll(previous); err != nil {
+		return fmt.Errorf("remove previous roundcube: %w", err)
+	}
+	return nil
+}
+
+// configureRoundcube writes config.inc.php, prepares the writable data
+// dir and creates or migrates the SQLite schema. migrate is set after new
+// release files were put in place.
+func (i *Installer) configureRoundcube(ctx context.Context, installDir, dataDir string, migrate bool) error {
+	for _, dir := range []string{dataDir, filepath.Join(dataDir, "temp"), filepath.Join(dataDir, "logs")} {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return fmt.Errorf("create roundcube data dir: %w", err)
+		}
+	}
+	desKey, err := roundcubeDESKey(dataDir)
+	if err != nil {
+		return err
+	}
+	// Paths in the config are the ones seen on the target system.
+	liveDataDir := strings.TrimRight(strings.TrimSpace(i.opts.RoundcubeDataDir), "/")
+	config := renderRoundcubeConfig(liveDataDir, desKey)
+	if err := writeTextFile(filepath.Join(installDir, "config", "config.inc.php"), config, 0o640); err != nil {
+		return fmt.Errorf("write roundcube config: %w", err)
+	}
+	if _, err := i.runner.Run(ctx, "chown", "-R", "root:www-data", installDir); err != nil {
+		return fmt.Errorf("set roundcube ownership: %w", err)
+	}
+	if _, err := i.runner.Run(ctx, "chmod", "-R", "g+rX,o-rwx", installDir); err != nil {
+		return fmt.Errorf("set roundcube permissions: %w", err)
+	}
+	if _, err := i.runner.Run(ctx, "chown", "-R", "www-data:www-data", dataDir); err != nil {
+		return fmt.Errorf("set roundcube data ownership: %w", err)
+	}
+
+	liveInstallDir := strings.TrimRight(strings.TrimSpace(i.opts.RoundcubeInstallDir), "/")
+	phpBin := filepath.Join(i.opts.RuntimeInstallDir, "php-fpm", "current", "bin", "php")
+	script := ""
+	switch {
+	case !fileExists(filepath.Join(dataDir, roundcubeDBFile)):
+		script = "initdb.sh"
+	case migrate:
+		script = "updatedb.sh"
+	default:
+		return nil
+	}
+	args := []string{"-u", "www-data", "--", phpBin, liveInstallDir + "/bin/" + script, "--dir=" + liveInstallDir + "/SQL"}
+	if script == "updatedb.sh" {
+		args = append(args, "--package=roundcube")
+	}
+	if _, err := i.runner.Run(ctx, "runuser", args...); err != nil {
+		return fmt.Errorf("roundcube %s: %w", script, err)
+	}
+	return nil
+}
+
+// roundcubeDESKey returns the key stored in dataDir, generating it on the
+// first install.
+func roundcubeDESKey(dataDir string) (string, error) {
+	path := filepath.Join(dataDir, roundcubeDESKeyFile)
+	// Path is derived from the configured data directory.
+	//nolint:gosec // G304
+	if raw, err := os.ReadFile(path); err == nil {
+		if key := strings.TrimSpace(string(raw)); len(key) == 24 {
+			return key, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("read roundcube des_key: %w", err)
+	}
+	buf := make([]byte, 18)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate roundcube des_key: %w", err)
+	}
+	key := base64.RawURLEncoding.EncodeToString(buf)
+	if err := writeTextFile(path, key+"\n", 0o600); err != nil {
+		return "", fmt.Errorf("write roundcube des_key: %w", err)
+	}
+	return key, nil
+}
+
+func renderRoundcubeConfig(dataDir, desKey string) string {
+	var b strings.Builder
+	b.WriteString("<?php\n// Managed by aiPanel (install_roundcube); local changes are overwritten.\n$config = [];\n")
+	for _, kv := range [][2]string{
+		{"db_dsnw", "sqlite:///" + dataDir + "/" + roundcubeDBFile + "?mode=0640"},
+		{"imap_host", roundcubeIMAPHost},
+		{"smtp_host", roundcubeSMTPHost},
+		{"smtp_user", "%u"},
+		{"smtp_pass", "%p"},
+		{"des_key", desKey},
+		{"product_name", "Webmail"},
+		{"temp_dir", dataDir + "/temp/"},
+		{"log_dir", dataDir + "/logs/"},
+	} {
+		fmt.Fprintf(&b, "$config['%s'] = '%s';\n", kv[0], strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(kv[1]))
+	}
+	b.WriteString("$config['enable_installer'] = false;\n$config['plugins'] = ['archive', 'zipdownload'];\n")
+	return b.String()
+}
+
+func (i *Installer) isRoundcubeInstalled() bool {
+	return fileExists(filepath.Join(pathInRootFS(i.opts.RootFSPath, i.opts.RoundcubeInstallDir), "public_html", "index.php"))
+}
@@ -4,14 +4,50 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 )
 
+// builtinRuntimeComponents get component-specific configuration from the
+// installer. Any other lock entry is a custom component: it is built,
+// activated and validated from its lock entry alone.
+var builtinRuntimeComponents = map[string]struct{}{
+	"nginx":      {},
+	"php-fpm":    {},
+	"mysql":      {},
+	"mariadb":    {},
+	"postgresql": {},
+	"mongodb":    {},
+	"memcached":  {},
+}
+
+// runtimeComponentNamePattern keeps component names safe in paths and
+// systemd unit names.
+var runtimeComponentNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// aptPackageNamePattern and aptVersionPattern follow Debian policy for
+// package names and versions, so a pin cannot smuggle apt-get options.
+var (
+	aptPackageNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]+$`)
+	aptVersionPattern     = regexp.MustCompile(`^[0-9][A-Za-z0-9.+~:-]*$`)
+)
+
 // RuntimeSourceLock represents pinned upstream source metadata for runtime components.
 type RuntimeSourceLock struct {
 	SchemaVersion int                           `json:"schema_version"`
 	Channels      map[string]RuntimeChannelLock `json:"channels"`
+	// Releases keeps earlier releases of a component that stay available
+	// for pinning once the channels have moved past them.
+	Releases map[string][]RuntimeComponentLock `json:"releases,omitempty"`
+	// Pins holds a component on one version, e.g. "php-fpm": "8.3.15",
+	// whichever channel is installed. The version must be one the lock
+	// lists for that component, in a channel or under releases.
+	Pins map[string]string `json:"pins,omitempty"`
+	// AptPackages optionally pins apt prerequisites to exact Debian
+	// versions, installed as "apt-get install pkg=version".
+	AptPackages map[string]string `json:"apt_packages,omitempty"`
 }
 
 // RuntimeChannelLock groups component metadata under a release channel.
@@ -24,8 +60,10 @@ type RuntimeComponentLock struct {
 	SourceSHA256         string                 `json:"source_sha256"`
 	SignatureURL         string                 `json:"signature_url"`
 	PublicKeyFingerprint string                 `json:"public_key_fingerprint"`
-	Build                RuntimeBuildSpec       `json:"build,omitempty"`
-	Systemd              RuntimeSystemdUnitSpec `json:"systemd,omitempty"`
+	Build                RuntimeBuildSpec       `json:"build,omitzero"`
+	Binary               RuntimeBinarySpec      `json:"binary,omitzero"`
+	Systemd              RuntimeSystemdUnitSpec `json:"systemd,omitzero"`
+	Validation           RuntimeValidationSpec  `json:"validation,omitzero"`
 }
 
 // RuntimeBuildSpec declares source build commands for a runtime component.
@@ -35,6 +73,35 @@ type RuntimeBuildSpec struct {
 	Commands []string `json:"commands,omitempty"`
 }
 
+// RuntimeBinarySpec points at a prebuilt, signed archive of the installed
+// tree, built from the same pinned source by whoever publishes the lock.
+// The binary install mode unpacks it instead of running the build commands.
+type RuntimeBinarySpec struct {
+	URL                  string `json:"url"`
+	SHA256               string `json:"sha256"`
+	SignatureURL         string `json:"signature_url"`
+	PublicKeyFingerprint string `json:"public_key_fingerprint"`
+	// ManifestURL optionally points at the CI attestation for the
+	// artifact: a sha256sum listing of every file in the installed tree.
+	ManifestURL string `json:"manifest_url,omitempty"`
+}
+
+// IsZero reports whether no prebuilt artifact is declared.
+func (b RuntimeBinarySpec) IsZero() bool {
+	return strings.TrimSpace(b.URL) == "" &&
+		strings.TrimSpace(b.SHA256) == "" &&
+		strings.TrimSpace(b.SignatureURL) == "" &&
+		strings.TrimSpace(b.PublicKeyFingerprint) == "" &&
+		strings.TrimSpace(b.ManifestURL) == ""
+}
+
+// RuntimeValidationSpec declares a check run once the component is
+// activated, e.g. "{{install_dir}}/sbin/varnishd -V". It supports the
+// build command placeholders; a non-zero exit fails the activation.
+type RuntimeValidationSpec struct {
+	Command string `json:"command,omitempty"`
+}
+
 // RuntimeSystemdUnitSpec declares how to run a runtime component through systemd.
 type RuntimeSystemdUnitSpec struct {
 	Name             string   `json:"name"`
@@ -94,13 +161,152 @@ func (l RuntimeSourceLock) Validate() error {
 			}
 		}
 	}
+	for componentName, releases := range l.Releases {
+		for _, component := range releases {
+			if err := validateRuntimeComponentLock("releases", componentName, component); err != nil {
+				return err
+			}
+		}
+	}
+	aptNames := make([]string, 0, len(l.AptPackages))
+	for name := range l.AptPackages {
+		aptNames = append(aptNames, name)
+	}
+	sort.Strings(aptNames)
+	for _, name := range aptNames {
+		if !aptPackageNamePattern.MatchString(name) {
+			return fmt.Errorf("runtime lock apt_packages has invalid package name %q", name)
+		}
+		if !aptVersionPattern.MatchString(l.AptPackages[name]) {
+			return fmt.Errorf("runtime lock apt_packages has invalid version %q for %s", l.AptPackages[name], name)
+		}
+	}
+	return l.validatePins(l.Pins)
+}
+
+// validatePins checks that every pin names a version the lock lists for
+// its component.
+func (l RuntimeSourceLock) validatePins(pins map[string]string) error {
+	names := make([]string, 0, len(pins))
+	for name := range pins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := l.pinnedComponent(name, pins[name]); !ok {
+			return fmt.Errorf("runtime lock has no %s %s to pin (available: %s)", name, pins[name], strings.Join(l.componentVersions(name), ", "))
+		}
+	}
 	return nil
 }
 
+// pinnedComponent finds the lock entry of version of component, looking
+// through the channels in name order and then the kept releases.
+func (l RuntimeSourceLock) pinnedComponent(name, version string) (RuntimeComponentLock, bool) {
+	version = strings.TrimSpace(version)
+	channelNames := make([]string, 0, len(l.Channels))
+	for channelName := range l.Channels {
+		channelNames = append(channelNames, channelName)
+	}
+	sort.Strings(channelNames)
+	for _, channelName := range channelNames {
+		if component, ok := l.Channels[channelName][name]; ok && component.Version == version {
+			return component, true
+		}
+	}
+	for _, component := range l.Releases[name] {
+		if component.Version == version {
+			return component, true
+		}
+	}
+	return RuntimeComponentLock{}, false
+}
+
+// componentVersions lists the versions of component the lock could pin.
+func (l RuntimeSourceLock) componentVersions(name string) []string {
+	seen := map[string]struct{}{}
+	for _, channel := range l.Channels {
+		if component, ok := channel[name]; ok {
+			seen[component.Version] = struct{}{}
+		}
+	}
+	for _, component := range l.Releases[name] {
+		seen[component.Version] = struct{}{}
+	}
+	versions := make([]string, 0, len(seen))
+	for version := range seen {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	if len(versions) == 0 {
+		return []string{"none"}
+	}
+	return versions
+}
+
+// PinnedChannel returns a copy of channel with each pinned component
+// swapped for its pinned release. pins override the lock's own pins for
+// the same component; a pin the lock cannot satisfy, or for a component
+// the channel does not install, is an error.
+func (l RuntimeSourceLock) PinnedChannel(channelName string, pins map[string]string) (RuntimeChannelLock, error) {
+	channel, ok := l.Channels[channelName]
+	if !ok {
+		return nil, fmt.Errorf("runtime lock does not contain channel %s", channelName)
+	}
+	merged := make(map[string]string, len(l.Pins)+len(pins))
+	for name, version := range l.Pins {
+		merged[name] = version
+	}
+	for name, version := range pins {
+		merged[name] = version
+	}
+	if len(merged) == 0 {
+		return channel, nil
+	}
+	if err := l.validatePins(merged); err != nil {
+		return nil, err
+	}
+	out := make(RuntimeChannelLock, len(channel))
+	for name, component := range channel {
+		out[name] = component
+	}
+	for name, version := range merged {
+		if _, ok := channel[name]; !ok {
+			return nil, fmt.Errorf("runtime lock channel %s has no component %s to pin", channelName, name)
+		}
+		out[name], _ = l.pinnedComponent(name, version)
+	}
+	return out, nil
+}
+
+// ParseRuntimePins parses repeated component=version flags, e.g.
+// php-fpm=8.3.15. A later pin for the same component wins.
+func ParseRuntimePins(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	pins := make(map[string]string, len(values))
+	for _, value := range values {
+		name, version, ok := strings.Cut(value, "=")
+		name, version = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(version)
+		if !ok || name == "" || version == "" {
+			return nil, fmt.Errorf("invalid pin %q: use component=version", value)
+		}
+		if !runtimeComponentNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid pin %q: component must be lowercase letters, digits, '.', '_' or '-'", value)
+		}
+		pins[name] = version
+	}
+	return pins, nil
+}
+
 func validateRuntimeComponentLock(channel, name string, component RuntimeComponentLock) error {
 	if strings.TrimSpace(name) == "" {
 		return fmt.Errorf("runtime lock channel %s contains empty component name", channel)
 	}
+	if !runtimeComponentNamePattern.MatchString(name) {
+		return fmt.Errorf("runtime lock component %s/%s: name must be lowercase letters, digits, '.', '_' or '-'", channel, name)
+	}
 	if strings.TrimSpace(component.Version) == "" {
 		return fmt.Errorf("runtime lock component %s/%s is missing version", channel, name)
 	}
@@ -121,6 +327,9 @@ func validateRuntimeComponentLock(channel, name string, component RuntimeCompone
 	if err := validateRuntimeBuildSpec(channel, name, component.Build); err != nil {
 		return err
 	}
+	if err := validateRuntimeBinarySpec(channel, name, component.Binary); err != nil {
+		return err
+	}
 	if err := validateRuntimeSystemdUnit(channel, name, component.Systemd); err != nil {
 		return err
 	}
@@ -144,6 +353,25 @@ func validateRuntimeBuildSpec(channel, component string, build RuntimeBuildSpec)
 	return nil
 }
 
+func validateRuntimeBinarySpec(channel, component string, binary RuntimeBinarySpec) error {
+	if binary.IsZero() {
+		return nil
+	}
+	if strings.TrimSpace(binary.URL) == "" {
+		return fmt.Errorf("runtime lock component %s/%s is missing binary.url", channel, component)
+	}
+	if !isValidSHA256(binary.SHA256) {
+		return fmt.Errorf("runtime lock component %s/%s has invalid binary.sha256", channel, component)
+	}
+	if strings.TrimSpace(binary.SignatureURL) == "" {
+		return fmt.Errorf("runtime lock component %s/%s is missing binary.signature_url", channel, component)
+	}
+	if strings.TrimSpace(binary.PublicKeyFingerprint) == "" {
+		return fmt.Errorf("runtime lock component %s/%s is missing binary.public_key_fingerprint", channel, component)
+	}
+	return nil
+}
+
 func validateRuntimeSystemdUnit(channel, component string, unit RuntimeSystemdUnitSpec) error {
 	if strings.TrimSpace(unit.Name) == "" &&
 		strings.TrimSpace(unit.ExecStart) == "" &&
@@ -180,3 +408,155 @@ func isValidSHA256(value string) bool {
 	}
 	return true
 }
+
+// IsBuiltinRuntimeComponent reports whether the installer ships dedicated
+// configuration for the component.
+func IsBuiltinRuntimeComponent(name string) bool {
+	_, ok := builtinRuntimeComponents[strings.ToLower(strings.TrimSpace(name))]
+	return ok
+}
+
+// AddRuntimeComponent adds a custom component to the given channels of
+// lock, or to every channel when channels is empty. Built-in names are
+// refused, and so is an existing entry unless replace is set. Custom
+// components must declare build commands, a systemd unit and a validation
+// command, so they install and activate like built-ins.
+func AddRuntimeComponent(lock *RuntimeSourceLock, name string, component RuntimeComponentLock, channels []string, replace bool) error {
+	name = strings.TrimSpace(name)
+	if IsBuiltinRuntimeComponent(name) {
+		return fmt.Errorf("%s is a built-in runtime component", name)
+	}
+	if !runtimeComponentNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid component name %q: use lowercase letters, digits, '.', '_' or '-'", name)
+	}
+	if len(component.Build.Commands) == 0 {
+		return fmt.Errorf("component %s needs at least one build command", name)
+	}
+	if strings.TrimSpace(component.Systemd.ExecStart) == "" {
+		return fmt.Errorf("component %s needs a systemd exec_start", name)
+	}
+	if strings.TrimSpace(component.Validation.Command) == "" {
+		return fmt.Errorf("component %s needs a validation command", name)
+	}
+	if len(channels) == 0 {
+		for channel := range lock.Channels {
+			channels = append(channels, channel)
+		}
+		sort.Strings(channels)
+	}
+	for _, channel := range channels {
+		components, ok := lock.Channels[channel]
+		if !ok {
+			return fmt.Errorf("runtime lock has no channel %s", channel)
+		}
+		if _, exists := components[name]; exists && !replace {
+			return fmt.Errorf("runtime lock channel %s already has component %s", channel, name)
+		}
+		if err := validateRuntimeComponentLock(channel, name, component); err != nil {
+			return err
+		}
+	}
+	for _, channel := range channels {
+		lock.Channels[channel][name] = component
+	}
+	return nil
+}
+
+// WriteRuntimeSourceLock validates lock and replaces the file at path.
+func WriteRuntimeSourceLock(path string, lock *RuntimeSourceLock) error {
+	if err := lock.Validate(); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode runtime lock file: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".lock-*.json")
+	if err != nil {
+		return fmt.Errorf("write runtime lock file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write runtime lock file: %w", err)
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write runtime lock file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write runtime lock file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write runtime lock file: %w", err)
+	}
+	return nil
+}
+
+// mergeCustomRuntimeComponents copies the custom components of local into
+// the matching channels of lock, unless lock already defines them, and
+// returns how many were copied. The pins of local are carried over too,
+// along with the pinned release when lock no longer lists it, so a
+// refreshed lock keeps an operator on the version they chose.
+func mergeCustomRuntimeComponents(lock, local *RuntimeSourceLock) int {
+	merged := 0
+	for channelName, channel := range local.Channels {
+		target, ok := lock.Channels[channelName]
+		if !ok {
+			continue
+		}
+		for name, component := range channel {
+			if _, exists := target[name]; exists || IsBuiltinRuntimeComponent(name) {
+				continue
+			}
+			target[name] = component
+			merged++
+		}
+	}
+	for name, version := range local.Pins {
+		if _, exists := lock.Pins[name]; exists {
+			continue
+		}
+		if _, ok := lock.pinnedComponent(name, version); !ok {
+			component, ok := local.pinnedComponent(name, version)
+			if !ok {
+				continue
+			}
+			if lock.Releases == nil {
+				lock.Releases = map[string][]RuntimeComponentLock{}
+			}
+			lock.Releases[name] = append(lock.Releases[name], component)
+		}
+		if lock.Pins == nil {
+			lock.Pins = map[string]string{}
+		}
+		lock.Pins[name] = version
+		merged++
+	}
+	return merged
+}
+
+// customRuntimeComponents lists the custom components declared in the
+// local lock file. It is best effort: --only is checked before a lock
+// URL is fetched, so a missing or invalid file yields no names.
+func (o Options) customRuntimeComponents() map[string]struct{} {
+	path := strings.TrimSpace(o.RuntimeLockPath)
+	if path == "" {
+		return nil
+	}
+	lock, err := LoadRuntimeSourceLock(path)
+	if err != nil {
+		return nil
+	}
+	custom := map[string]struct{}{}
+	for _, channel := range lock.Channels {
+		for name := range channel {
+			if !IsBuiltinRuntimeComponent(name) {
+				custom[name] = struct{}{}
+			}
+		}
+	}
+	return custom
+}
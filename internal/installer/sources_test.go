@@ -183,3 +183,185 @@ func TestLoadRuntimeSourceLock_RejectsPartialSignatureMetadata(t *testing.T) {
 		t.Fatalf("expected missing signature_url validation error, got: %v", err)
 	}
 }
+
+func TestLoadRuntimeSourceLock_RejectsUnsignedBinary(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "lock-unsigned-binary.json")
+	if err := os.WriteFile(path, []byte(`{
+  "schema_version": 1,
+  "channels": {
+    "stable": {
+      "postgresql": {
+        "version": "18.1",
+        "source_url": "https://ftp.postgresql.org/pub/source/v18.1/postgresql-18.1.tar.gz",
+        "source_sha256": "b0f18c2d6973d2aa023cfc77feda787d7bbe9c31a3977d0f04ac29885fb98ec4",
+        "binary": {
+          "url": "https://releases.example.com/postgresql-18.1-debian13-amd64.tar.gz",
+          "sha256": "b0f18c2d6973d2aa023cfc77feda787d7bbe9c31a3977d0f04ac29885fb98ec4"
+        }
+      }
+    }
+  }
+}`), 0o600); err != nil {
+		t.Fatalf("write lock file: %v", err)
+	}
+
+	_, err := LoadRuntimeSourceLock(path)
+	if err == nil {
+		t.Fatal("expected unsigned binary validation error")
+	}
+	if !strings.Contains(err.Error(), "missing binary.signature_url") {
+		t.Fatalf("expected missing binary.signature_url validation error, got: %v", err)
+	}
+}
+
+func TestAddRuntimeComponent_Rejections(t *testing.T) {
+	lock, err := LoadRuntimeSourceLock(filepath.Join("..", "..", "configs", "sources", "lock.json"))
+	if err != nil {
+		t.Fatalf("load repo lock: %v", err)
+	}
+	varnish := RuntimeComponentLock{
+		Version:      "7.7.1",
+		SourceURL:    "https://varnish-cache.org/downloads/varnish-7.7.1.tgz",
+		SourceSHA256: strings.Repeat("b", 64),
+		Build:        RuntimeBuildSpec{Commands: []string{"./configure --prefix={{install_dir}}", "make install"}},
+		Systemd:      RuntimeSystemdUnitSpec{Name: "aipanel-runtime-varnish.service", ExecStart: "{{install_dir}}/sbin/varnishd -F -a 127.0.0.1:6081"},
+	}
+	cases := []struct {
+		name      string
+		component string
+		channels  []string
+		want      string
+	}{
+		{"built-in", "nginx", nil, "built-in runtime component"},
+		{"built-in memcached", "memcached", nil, "built-in runtime component"},
+		{"unsafe name", "Varnish/1", nil, "invalid component name"},
+		{"no validation", "varnish", nil, "needs a validation command"},
+		{"unknown channel", "varnish", []string{"nightly"}, "no channel nightly"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			component := varnish
+			if tc.want != "needs a validation command" {
+				component.Validation.Command = "{{install_dir}}/sbin/varnishd -V"
+			}
+			if err := AddRuntimeComponent(lock, tc.component, component, tc.channels, false); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected %q, got %v", tc.want, err)
+			}
+		})
+	}
+
+	varnish.Validation.Command = "{{install_dir}}/sbin/varnishd -V"
+	if err := AddRuntimeComponent(lock, "varnish", varnish, nil, false); err != nil {
+		t.Fatalf("add varnish: %v", err)
+	}
+	for channel, components := range lock.Channels {
+		if components["varnish"].Version != "7.7.1" {
+			t.Fatalf("expected varnish in channel %s", channel)
+		}
+	}
+	if err := AddRuntimeComponent(lock, "varnish", varnish, nil, false); err == nil || !strings.Contains(err.Error(), "already has component varnish") {
+		t.Fatalf("expected an existing entry kept without replace, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "sources.lock.json")
+	if err := WriteRuntimeSourceLock(path, lock); err != nil {
+		t.Fatalf("write lock: %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil || strings.Contains(string(b), `"binary"`) || !strings.Contains(string(b), `"command": "{{install_dir}}/sbin/varnishd -V"`) {
+		t.Fatalf("expected empty blocks omitted and the validation kept, got %s (%v)", b, err)
+	}
+	if opts := (Options{RuntimeLockPath: path}); len(opts.customRuntimeComponents()) != 1 {
+		t.Fatalf("expected varnish accepted by --only, got %v", opts.customRuntimeComponents())
+	}
+}
+
+func TestRuntimeSourceLock_PinnedChannel(t *testing.T) {
+	lock, err := LoadRuntimeSourceLock(filepath.Join("..", "..", "configs", "sources", "lock.json"))
+	if err != nil {
+		t.Fatalf("load repo lock: %v", err)
+	}
+	nginx := lock.Channels[RuntimeChannelStable]["nginx"]
+	php := lock.Channels[RuntimeChannelStable]["php-fpm"]
+	older := php
+	older.Version = "8.3.15"
+	older.SourceURL = "https://www.php.net/distributions/php-8.3.15.tar.gz"
+	older.SourceSHA256 = strings.Repeat("c", 64)
+	lock.Releases = map[string][]RuntimeComponentLock{"php-fpm": {older}}
+
+	channel, err := lock.PinnedChannel(RuntimeChannelStable, map[string]string{"php-fpm": "8.3.15"})
+	if err != nil {
+		t.Fatalf("pinned channel: %v", err)
+	}
+	if channel["php-fpm"].SourceURL != older.SourceURL || channel["nginx"].Version != nginx.Version {
+		t.Fatalf("expected php pinned and nginx from the channel, got %+v", channel)
+	}
+	if lock.Channels[RuntimeChannelStable]["php-fpm"].Version != php.Version {
+		t.Fatal("expected the lock channel left untouched")
+	}
+
+	for _, tc := range []struct {
+		pins map[string]string
+		want string
+	}{
+		{map[string]string{"php-fpm": "8.1.0"}, "no php-fpm 8.1.0 to pin"},
+		{map[string]string{"mongodb": "8.0.4"}, "no mongodb 8.0.4 to pin"},
+	} {
+		if _, err := lock.PinnedChannel(RuntimeChannelStable, tc.pins); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("expected %q, got %v", tc.want, err)
+		}
+	}
+	lock.Pins = map[string]string{"php-fpm": "7.4.0"}
+	if err := lock.Validate(); err == nil || !strings.Contains(err.Error(), "available: 8.3.15, "+php.Version) {
+		t.Fatalf("expected a lock pin outside the lock rejected, got %v", err)
+	}
+
+	// A refreshed lock keeps the local pin and the release it points at.
+	local := *lock
+	local.Pins = map[string]string{"php-fpm": "8.3.15"}
+	refreshed, err := LoadRuntimeSourceLock(filepath.Join("..", "..", "configs", "sources", "lock.json"))
+	if err != nil {
+		t.Fatalf("load repo lock: %v", err)
+	}
+	if merged := mergeCustomRuntimeComponents(refreshed, &local); merged != 1 {
+		t.Fatalf("expected the pin carried over, got %d", merged)
+	}
+	if err := refreshed.Validate(); err != nil {
+		t.Fatalf("validate refreshed lock: %v", err)
+	}
+	channel, err = refreshed.PinnedChannel(RuntimeChannelEdge, nil)
+	if err != nil || channel["php-fpm"].Version != "8.3.15" {
+		t.Fatalf("expected the lock pin applied, got %v (%v)", channel["php-fpm"].Version, err)
+	}
+}
+
+func TestParseRuntimePins(t *testing.T) {
+	pins, err := ParseRuntimePins([]string{"PHP-FPM=8.3.15", "nginx = 1.29.5", "php-fpm=8.4.1"})
+	if err != nil {
+		t.Fatalf("parse pins: %v", err)
+	}
+	if len(pins) != 2 || pins["php-fpm"] != "8.4.1" || pins["nginx"] != "1.29.5" {
+		t.Fatalf("unexpected pins: %v", pins)
+	}
+	for _, bad := range []string{"php-fpm", "=8.3", "php-fpm=", "php/fpm=8.3"} {
+		if _, err := ParseRuntimePins([]string{bad}); err == nil {
+			t.Fatalf("expected %q rejected", bad)
+		}
+	}
+}
+
+func TestRuntimeSourceLock_ValidatesAptPackages(t *testing.T) {
+	lock, err := LoadRuntimeSourceLock(filepath.Join("..", "..", "configs", "sources", "lock.json"))
+	if err != nil {
+		t.Fatalf("load repo lock: %v", err)
+	}
+	lock.AptPackages = map[string]string{"libssl-dev": "3.5.1-1", "g++": "4:14.2.0-1"}
+	if err := lock.Validate(); err != nil {
+		t.Fatalf("expected apt pins accepted, got %v", err)
+	}
+	lock.AptPackages = map[string]string{"libssl-dev": "--allow-unauthenticated"}
+	if err := lock.Validate(); err == nil || !strings.Contains(err.Error(), "invalid version") {
+		t.Fatalf("expected an option-like version rejected, got %v", err)
+	}
+}
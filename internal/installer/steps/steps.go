@@ -19,6 +19,9 @@ const (
 	ConfigurePHP      = "configure_phpfpm"
 	InstallPHPMyAdmin = "install_phpmyadmin"
 	InstallPGAdmin    = "install_pgadmin"
+	InstallAdminer    = "install_adminer"
+	InstallRoundcube  = "install_roundcube"
+	ConfigureFirewall = "configure_firewall"
 	WriteUnit         = "write_systemd_unit"
 	StartPanel        = "start_panel_service"
 	CreateAdmin       = "create_admin"
@@ -44,6 +47,9 @@ var Ordered = []string{
 	ConfigurePHP,
 	InstallPHPMyAdmin,
 	InstallPGAdmin,
+	InstallAdminer,
+	InstallRoundcube,
+	ConfigureFirewall,
 	WriteUnit,
 	StartPanel,
 	CreateAdmin,
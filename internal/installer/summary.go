@@ -0,0 +1,189 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/mailer"
+)
+
+// Summary is what an operator needs after a successful install.
+type Summary struct {
+	PanelURL   string
+	AdminEmail string
+	ReportPath string
+	LogPath    string
+	NextSteps  []string
+}
+
+// Summary describes the finished install.
+func (i *Installer) Summary() Summary {
+	s := Summary{
+		PanelURL:   panelURL(i.opts),
+		AdminEmail: strings.TrimSpace(i.opts.AdminEmail),
+		ReportPath: i.opts.ReportFilePath,
+		LogPath:    i.opts.LogFilePath,
+	}
+	if i.opts.SetupWizard {
+		s.AdminEmail = ""
+		s.NextSteps = append(s.NextSteps, "Open "+s.PanelURL+"setup with the setup token printed by the installer to create the admin account.")
+	} else {
+		s.NextSteps = append(s.NextSteps, "Sign in at "+s.PanelURL+" and change the admin password.")
+	}
+	if !i.opts.ReverseProxy {
+		s.NextSteps = append(s.NextSteps, "Put the panel behind nginx with a domain (--reverse-proxy --panel-domain) before exposing it.")
+	} else if !i.opts.EnableLetsEncrypt {
+		s.NextSteps = append(s.NextSteps, "Enable Let's Encrypt for "+i.opts.PanelDomain+" so the panel is served over HTTPS.")
+	}
+	s.NextSteps = append(s.NextSteps, "Configure the SMTP relay in the panel settings so alerts and password resets are delivered.")
+	return s
+}
+
+// Text renders the summary. password is included only when it is not
+// empty; mailed summaries never carry it.
+func (s Summary) Text(password string) string {
+	var b strings.Builder
+	b.WriteString("aiPanel installation finished\n\n")
+	fmt.Fprintf(&b, "Panel URL:   %s\n", s.PanelURL)
+	if s.AdminEmail != "" {
+		fmt.Fprintf(&b, "Admin login: %s\n", s.AdminEmail)
+	}
+	if password != "" {
+		fmt.Fprintf(&b, "Password:    %s\n", password)
+	}
+	fmt.Fprintf(&b, "Report:      %s\n", s.ReportPath)
+	fmt.Fprintf(&b, "Log:         %s\n", s.LogPath)
+	b.WriteString("\nNext steps:\n")
+	for n, step := range s.NextSteps {
+		fmt.Fprintf(&b, "%d. %s\n", n+1, step)
+	}
+	return b.String()
+}
+
+// panelURL is where the panel is reached: the panel domain behind nginx,
+// otherwise the listen address (a wildcard host becomes a placeholder).
+func panelURL(opts Options) string {
+	domain := strings.TrimSpace(opts.PanelDomain)
+	if opts.ReverseProxy && domain != "" && domain != "_" {
+		if opts.EnableLetsEncrypt {
+			return "https://" + domain + "/"
+		}
+		return "http://" + domain + "/"
+	}
+	host, port, err := net.SplitHostPort(strings.TrimSpace(opts.Addr))
+	if err != nil {
+		return "http://" + strings.TrimSpace(opts.Addr) + "/"
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "<server-ip>"
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/"
+}
+
+// MailSummary sends the install summary to SummaryEmail through the SMTP
+// relay from the panel config (or AIPANEL_SMTP_* variables). The mail
+// never includes the password. It is a no-op without SummaryEmail.
+func (i *Installer) MailSummary(ctx context.Context) error {
+	to := strings.TrimSpace(i.opts.SummaryEmail)
+	if to == "" {
+		return nil
+	}
+	cfg, err := config.Load(i.opts.ConfigPath)
+	if err != nil {
+		return fmt.Errorf("mail install summary: load panel config: %w", err)
+	}
+	m := mailer.New(cfg, nil, nil)
+	if !m.Configured() {
+		return fmt.Errorf("mail install summary: %w; set smtp_host and smtp_from in %s or AIPANEL_SMTP_HOST and AIPANEL_SMTP_FROM",
+			mailer.ErrNotConfigured, i.opts.ConfigPath)
+	}
+	summary := i.Summary()
+	if err := m.Send(ctx, mailer.Message{
+		To:      []string{to},
+		Subject: "aiPanel installed: " + summary.PanelURL,
+		Body:    summary.Text(""),
+	}); err != nil {
+		return fmt.Errorf("mail install summary to %s: %w", to, err)
+	}
+	i.logf("[summary] install summary sent to %s", to)
+	return nil
+}
+
+// WriteCredentials writes the install summary to CredentialsFilePath,
+// including generatedPassword when one was generated. It is a no-op
+// without CredentialsFilePath.
+func (i *Installer) WriteCredentials(generatedPassword string) error {
+	path := strings.TrimSpace(i.opts.CredentialsFilePath)
+	if path == "" {
+		return nil
+	}
+	if err := writeCredentialsFile(path, i.Summary().Text(generatedPassword)); err != nil {
+		return fmt.Errorf("write credentials file: %w", err)
+	}
+	i.logf("[summary] credentials written to %s (deleted after the first read)", path)
+	return nil
+}
+
+// credentialsFileHeader tells whoever opens the file how to read it.
+const credentialsFileHeader = "# Read once with: aipanel credentials --file %s\n" +
+	"# The command prints this file and deletes it.\n\n"
+
+// writeCredentialsFile creates path with 0600 permissions; an existing file
+// is never overwritten, so older credentials are not silently lost.
+func writeCredentialsFile(path, body string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, credentialsFileHeader+"%s", path, body); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// ReadCredentialsFile returns the credentials file at path and destroys
+// it: the contents are overwritten with zeros before the file is removed,
+// so only the first read sees them.
+func ReadCredentialsFile(path string) (string, error) {
+	// Operators pass the path they gave the installer.
+	//nolint:gosec // G304
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", path)
+	}
+	raw, err := io.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteAt(make([]byte, len(raw)), 0); err != nil {
+		return "", fmt.Errorf("wipe credentials file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return "", fmt.Errorf("wipe credentials file: %w", err)
+	}
+	if err := os.Remove(path); err != nil {
+		return "", fmt.Errorf("remove credentials file: %w", err)
+	}
+	return string(raw), nil
+}
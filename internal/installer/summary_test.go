@@ -0,0 +1,100 @@
+package installer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/mailer"
+)
+
+func TestPanelURL(t *testing.T) {
+	cases := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"wildcard listen", Options{Addr: ":8080", PanelDomain: "_"}, "http://<server-ip>:8080/"},
+		{"explicit listen", Options{Addr: "10.0.0.5:8080"}, "http://10.0.0.5:8080/"},
+		{"proxy without tls", Options{Addr: "127.0.0.1:8080", ReverseProxy: true, PanelDomain: "panel.example.com"}, "http://panel.example.com/"},
+		{"proxy with tls", Options{Addr: "127.0.0.1:8080", ReverseProxy: true, PanelDomain: "panel.example.com", EnableLetsEncrypt: true}, "https://panel.example.com/"},
+	}
+	for _, tc := range cases {
+		if got := panelURL(tc.opts); got != tc.want {
+			t.Errorf("%s: got %q want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestWriteCredentials_IsReadOnce(t *testing.T) {
+	root := t.TempDir()
+	opts := DefaultOptions()
+	opts.LogFilePath = filepath.Join(root, "install.log")
+	opts.CredentialsFilePath = filepath.Join(root, "creds", "aipanel-credentials.txt")
+	ins := New(opts, &fakeRunner{})
+
+	if err := ins.WriteCredentials("generated-password-1"); err != nil {
+		t.Fatalf("write credentials: %v", err)
+	}
+	info, err := os.Stat(opts.CredentialsFilePath)
+	if err != nil {
+		t.Fatalf("stat credentials: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected 0600 credentials file, got %o", info.Mode().Perm())
+	}
+	if err := ins.WriteCredentials("generated-password-2"); err == nil {
+		t.Fatal("expected an existing credentials file not to be overwritten")
+	}
+
+	content, err := ReadCredentialsFile(opts.CredentialsFilePath)
+	if err != nil {
+		t.Fatalf("read credentials: %v", err)
+	}
+	for _, want := range []string{"Password:    generated-password-1", "Admin login: admin@example.com", "Next steps:"} {
+		if !strings.Contains(content, want) {
+			t.Fatalf("expected %q in credentials, got:\n%s", want, content)
+		}
+	}
+	if _, err := ReadCredentialsFile(opts.CredentialsFilePath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the file to be gone after the first read, got %v", err)
+	}
+
+	log, err := os.ReadFile(opts.LogFilePath)
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+	if strings.Contains(string(log), "generated-password-1") {
+		t.Fatalf("expected password to stay out of the log, got:\n%s", log)
+	}
+}
+
+func TestMailSummary_RequiresRelay(t *testing.T) {
+	root := t.TempDir()
+	opts := DefaultOptions()
+	opts.LogFilePath = filepath.Join(root, "install.log")
+	opts.ConfigPath = filepath.Join(root, "panel.yaml")
+	if err := os.WriteFile(opts.ConfigPath, []byte("env: prod\n"), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	ins := New(opts, &fakeRunner{})
+	if err := ins.MailSummary(context.Background()); err != nil {
+		t.Fatalf("expected no-op without summary email, got %v", err)
+	}
+
+	opts.SummaryEmail = "ops@example.com"
+	ins = New(opts, &fakeRunner{})
+	if err := ins.MailSummary(context.Background()); !errors.Is(err, mailer.ErrNotConfigured) {
+		t.Fatalf("expected ErrNotConfigured, got %v", err)
+	}
+}
+
+func TestSummaryText_OmitsEmptyPassword(t *testing.T) {
+	summary := New(DefaultOptions(), &fakeRunner{}).Summary()
+	if text := summary.Text(""); strings.Contains(text, "Password:") {
+		t.Fatalf("expected no password line, got:\n%s", text)
+	}
+}
@@ -0,0 +1,377 @@
+// Package tui renders installer progress as a live terminal display: the
+// step list, the current step with its progress, the active download and
+// the tail of the build log. It implements installer.Progress.
+package tui
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	refreshInterval = 100 * time.Millisecond
+	// idleRedraw keeps the elapsed timers moving when nothing else changes.
+	idleRedraw   = time.Second
+	logLinesKept = 200
+	minLogLines  = 5
+	barWidth     = 24
+)
+
+type stepState struct {
+	name     string
+	status   string // "", "running", "ok", "skipped", "failed"
+	started  time.Time
+	finished time.Time
+}
+
+type downloadState struct {
+	ref     string
+	done    int64
+	total   int64
+	started time.Time
+	updated time.Time
+}
+
+// Display draws installer progress in place on a terminal. Create it with
+// New, call Start before the installer runs and Stop after it returns.
+type Display struct {
+	out   io.Writer
+	title string
+	size  func() (rows, cols int)
+	now   func() time.Time
+
+	mu       sync.Mutex
+	steps    []stepState
+	current  int
+	detail   string
+	download *downloadState
+	logs     []string
+	started  time.Time
+	drawn    int
+	drawnAt  time.Time
+	dirty    bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New returns a display writing to out. size reports the terminal size and
+// is called for every frame, so resizes are picked up.
+func New(out io.Writer, title string, size func() (rows, cols int)) *Display {
+	return &Display{
+		out:     out,
+		title:   title,
+		size:    size,
+		now:     time.Now,
+		current: -1,
+	}
+}
+
+// Start begins redrawing in the background.
+func (d *Display) Start() {
+	d.mu.Lock()
+	d.started = d.now()
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+	d.mu.Unlock()
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stop:
+				d.redraw(true)
+				return
+			case <-ticker.C:
+				d.redraw(false)
+			}
+		}
+	}()
+}
+
+// Stop draws the final frame and leaves it on screen.
+func (d *Display) Stop() {
+	if d.stop == nil {
+		return
+	}
+	close(d.stop)
+	<-d.done
+	d.stop = nil
+}
+
+// Plan implements installer.Progress.
+func (d *Display) Plan(names []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.steps = make([]stepState, 0, len(names))
+	for _, name := range names {
+		d.steps = append(d.steps, stepState{name: name})
+	}
+	d.dirty = true
+}
+
+// StepStarted implements installer.Progress.
+func (d *Display) StepStarted(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	idx := d.stepIndex(name)
+	d.steps[idx].status = "running"
+	d.steps[idx].started = d.now()
+	d.current = idx
+	d.detail = ""
+	d.download = nil
+	d.dirty = true
+}
+
+// StepFinished implements installer.Progress.
+func (d *Display) StepFinished(name, status string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	idx := d.stepIndex(name)
+	d.steps[idx].status = status
+	d.steps[idx].finished = d.now()
+	if d.steps[idx].started.IsZero() {
+		d.steps[idx].started = d.steps[idx].finished
+	}
+	if idx == d.current {
+		d.detail = ""
+		d.download = nil
+	}
+	d.dirty = true
+}
+
+// StepDetail implements installer.Progress.
+func (d *Display) StepDetail(detail string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.detail = detail
+	d.dirty = true
+}
+
+// Download implements installer.Progress.
+func (d *Display) Download(ref string, done, total int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	if d.download == nil || d.download.ref != ref {
+		d.download = &downloadState{ref: ref, started: now}
+	}
+	d.download.done = done
+	d.download.total = total
+	d.download.updated = now
+	d.dirty = true
+}
+
+// Log implements installer.Progress.
+func (d *Display) Log(line string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.logs = append(d.logs, line)
+	if len(d.logs) > logLinesKept {
+		d.logs = append(d.logs[:0], d.logs[len(d.logs)-logLinesKept:]...)
+	}
+	d.dirty = true
+}
+
+// stepIndex finds a planned step by name. Scoped names such as
+// install_runtime[nginx] match their planned base step and replace its
+// label; unknown steps are appended.
+func (d *Display) stepIndex(name string) int {
+	base, _, _ := strings.Cut(name, "[")
+	for idx, step := range d.steps {
+		if step.name == name {
+			return idx
+		}
+	}
+	for idx, step := range d.steps {
+		if step.name == base {
+			d.steps[idx].name = name
+			return idx
+		}
+	}
+	d.steps = append(d.steps, stepState{name: name})
+	return len(d.steps) - 1
+}
+
+func (d *Display) redraw(final bool) {
+	d.mu.Lock()
+	now := d.now()
+	if !d.dirty && !final && now.Sub(d.drawnAt) < idleRedraw {
+		d.mu.Unlock()
+		return
+	}
+	rows, cols := d.size()
+	lines := d.frame(rows, cols)
+	previous := d.drawn
+	d.drawn = len(lines)
+	d.drawnAt = now
+	d.dirty = false
+	d.mu.Unlock()
+
+	var b strings.Builder
+	if previous > 0 {
+		fmt.Fprintf(&b, "\r\x1b[%dA", previous)
+	}
+	b.WriteString("\x1b[J")
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteString("\x1b[K\n")
+	}
+	_, _ = io.WriteString(d.out, b.String())
+}
+
+// frame lays out one screen of at most rows-1 lines of cols columns. The
+// step list is windowed around the current step when it does not fit; the
+// log pane gets whatever height is left.
+func (d *Display) frame(rows, cols int) []string {
+	if rows < minLogLines+6 {
+		rows = minLogLines + 6
+	}
+	if cols < 40 {
+		cols = 40
+	}
+	now := d.now()
+	height := rows - 1
+
+	finished := 0
+	for _, step := range d.steps {
+		if step.status == "ok" || step.status == "skipped" {
+			finished++
+		}
+	}
+	header := []string{
+		fmt.Sprintf("%s  %s %d/%d  %s", d.title, bar(float64(finished), float64(len(d.steps))),
+			finished, len(d.steps), formatDuration(now.Sub(d.started))),
+	}
+
+	var status []string
+	if d.current >= 0 && d.steps[d.current].status == "running" {
+		if d.detail != "" {
+			status = append(status, "  "+d.detail)
+		}
+		if dl := d.download; dl != nil {
+			status = append(status, "  "+formatDownload(dl))
+		}
+	}
+
+	stepRows := height - len(header) - len(status) - 1 - minLogLines
+	stepRows = max(1, min(stepRows, len(d.steps)))
+	first := 0
+	if d.current >= 0 && len(d.steps) > stepRows {
+		first = min(max(0, d.current-stepRows/2), len(d.steps)-stepRows)
+	}
+	var stepLines []string
+	for idx := first; idx < first+stepRows && idx < len(d.steps); idx++ {
+		stepLines = append(stepLines, formatStep(d.steps[idx], now))
+	}
+
+	logRows := height - len(header) - len(stepLines) - len(status) - 1
+	logs := d.logs
+	if len(logs) > logRows {
+		logs = logs[len(logs)-logRows:]
+	}
+
+	lines := make([]string, 0, height)
+	lines = append(lines, header...)
+	lines = append(lines, stepLines...)
+	lines = append(lines, status...)
+	lines = append(lines, "── log "+strings.Repeat("─", max(0, cols-7)))
+	for _, line := range logs {
+		lines = append(lines, "  "+line)
+	}
+	for idx := range lines {
+		lines[idx] = truncate(lines[idx], cols)
+	}
+	return lines
+}
+
+func formatStep(step stepState, now time.Time) string {
+	var icon, elapsed string
+	switch step.status {
+	case "running":
+		icon = "▸"
+		elapsed = formatDuration(now.Sub(step.started))
+	case "ok":
+		icon = "✓"
+		elapsed = formatDuration(step.finished.Sub(step.started))
+	case "skipped":
+		icon = "-"
+		elapsed = "skipped"
+	case "failed":
+		icon = "✗"
+		elapsed = "failed after " + formatDuration(step.finished.Sub(step.started))
+	default:
+		icon = " "
+	}
+	return fmt.Sprintf(" %s %-32s %s", icon, step.name, elapsed)
+}
+
+func formatDownload(dl *downloadState) string {
+	name := path.Base(dl.ref)
+	rate := ""
+	if elapsed := dl.updated.Sub(dl.started).Seconds(); elapsed > 0 {
+		rate = formatBytes(int64(float64(dl.done)/elapsed)) + "/s"
+	}
+	if dl.total > 0 {
+		return fmt.Sprintf("download %s %s %s / %s  %s", name, bar(float64(dl.done), float64(dl.total)),
+			formatBytes(dl.done), formatBytes(dl.total), rate)
+	}
+	return fmt.Sprintf("download %s %s  %s", name, formatBytes(dl.done), rate)
+}
+
+func bar(done, total float64) string {
+	filled := 0
+	if total > 0 {
+		filled = int(done / total * barWidth)
+	}
+	filled = min(max(filled, 0), barWidth)
+	return "[" + strings.Repeat("█", filled) + strings.Repeat("░", barWidth-filled) + "]"
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	}
+	if d < time.Hour {
+		return fmt.Sprintf("%dm%02ds", int(d.Minutes()), int(d.Seconds())%60)
+	}
+	return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// truncate cuts s to width runes; log lines may hold anything a build
+// prints, so control characters are dropped too.
+func truncate(s string, width int) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		if r == utf8.RuneError || r < 0x20 || r == 0x7f {
+			continue
+		}
+		if n == width {
+			break
+		}
+		b.WriteRune(r)
+		n++
+	}
+	return b.String()
+}
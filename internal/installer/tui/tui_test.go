@@ -0,0 +1,89 @@
+package tui
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestDisplay(now *time.Time) *Display {
+	d := New(&bytes.Buffer{}, "aiPanel installer", func() (int, int) { return 24, 80 })
+	d.now = func() time.Time { return *now }
+	d.started = *now
+	return d
+}
+
+func TestFrame_WindowsStepsAroundCurrentStep(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	d := newTestDisplay(&now)
+	names := make([]string, 0, 30)
+	for n := range 30 {
+		names = append(names, fmt.Sprintf("step_%02d", n))
+	}
+	d.Plan(names)
+	for n := range 20 {
+		d.StepStarted(names[n])
+		now = now.Add(2 * time.Second)
+		d.StepFinished(names[n], "ok")
+	}
+	d.StepStarted("step_20")
+	d.StepDetail("nginx 1.27.4 (1/4)")
+	d.Download("https://nginx.org/download/nginx-1.27.4.tar.gz", 512*1024, 1024*1024)
+	now = now.Add(time.Second)
+	d.Download("https://nginx.org/download/nginx-1.27.4.tar.gz", 1024*1024, 1024*1024)
+	for n := range 50 {
+		d.Log(fmt.Sprintf("build line %d", n))
+	}
+
+	lines := d.frame(24, 80)
+	if len(lines) > 23 {
+		t.Fatalf("frame has %d lines, terminal has 24 rows", len(lines))
+	}
+	frame := strings.Join(lines, "\n")
+	for _, want := range []string{"20/30", "▸ step_20", "nginx 1.27.4 (1/4)", "nginx-1.27.4.tar.gz", "1.0 MiB / 1.0 MiB", "build line 49"} {
+		if !strings.Contains(frame, want) {
+			t.Fatalf("expected %q in frame:\n%s", want, frame)
+		}
+	}
+	if strings.Contains(frame, "step_00") {
+		t.Fatalf("expected early steps to scroll out of view:\n%s", frame)
+	}
+	for _, line := range lines {
+		if len([]rune(line)) > 80 {
+			t.Fatalf("line wider than terminal: %q", line)
+		}
+	}
+}
+
+func TestStepIndex_ScopedStepReplacesPlannedStep(t *testing.T) {
+	now := time.Now()
+	d := newTestDisplay(&now)
+	d.Plan([]string{"install_packages", "install_runtime", "activate_runtime"})
+	d.StepStarted("install_runtime[nginx]")
+	d.StepFinished("install_runtime[nginx]", "failed")
+
+	if len(d.steps) != 3 || d.steps[1].name != "install_runtime[nginx]" || d.steps[1].status != "failed" {
+		t.Fatalf("unexpected steps %+v", d.steps)
+	}
+}
+
+func TestRedraw_MovesCursorBackOverPreviousFrame(t *testing.T) {
+	now := time.Now()
+	out := &bytes.Buffer{}
+	d := New(out, "aiPanel installer", func() (int, int) { return 24, 80 })
+	d.now = func() time.Time { return now }
+	d.Plan([]string{"preflight"})
+	d.redraw(false)
+	first := d.drawn
+	out.Reset()
+	d.Log("hello \x1b[31mworld")
+	d.redraw(false)
+	if !strings.HasPrefix(out.String(), fmt.Sprintf("\r\x1b[%dA\x1b[J", first)) {
+		t.Fatalf("expected redraw to move up %d lines, got %q", first, out.String())
+	}
+	if strings.Contains(out.String(), "\x1b[31m") {
+		t.Fatal("expected control sequences from log lines to be stripped")
+	}
+}
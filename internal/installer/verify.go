@@ -0,0 +1,304 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Reference trees a rebuild can be compared against.
+const (
+	VerifyAgainstInstalled   = "installed"
+	VerifyAgainstAttestation = "attestation"
+)
+
+// runtimeMutableDirs are top-level dirs of an installed component that the
+// installer or the running service rewrite (configuration, data, logs).
+// They are skipped when comparing a rebuild with the installed tree.
+var runtimeMutableDirs = map[string]struct{}{
+	"conf": {},
+	"data": {},
+	"etc":  {},
+	"logs": {},
+	"run":  {},
+	"tmp":  {},
+	"var":  {},
+}
+
+// RuntimeVerification is the outcome of rebuilding one component from its
+// pinned source and comparing the result with a reference tree.
+type RuntimeVerification struct {
+	Component    string   `json:"component"`
+	Version      string   `json:"version"`
+	Against      string   `json:"against"`
+	Files        int      `json:"files"`
+	Reproducible bool     `json:"reproducible"`
+	Differences  []string `json:"differences,omitempty"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// VerifyRuntime rebuilds the selected components (all in the channel when
+// components is empty) and compares the output file by file with the
+// installed tree or with the published attestation of the prebuilt
+// artifact. A failure to verify one component is reported in its result;
+// the error is only for problems that stop every verification.
+//
+// The build runs in a private mount namespace with a scratch dir bind
+// mounted over the version dir, so it sees the same prefix as the real
+// install while the installed tree stays untouched.
+func (i *Installer) VerifyRuntime(ctx context.Context, components []string, against string) ([]RuntimeVerification, error) {
+	against = strings.ToLower(strings.TrimSpace(against))
+	switch against {
+	case "":
+		against = VerifyAgainstInstalled
+	case VerifyAgainstInstalled, VerifyAgainstAttestation:
+	default:
+		return nil, fmt.Errorf("invalid verification target: %s", against)
+	}
+	if err := i.ensureRootPrivileges(); err != nil {
+		return nil, err
+	}
+	lock, err := i.resolveRuntimeSourceLock(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load runtime source lock: %w", err)
+	}
+	channel, err := i.runtimeChannel(lock)
+	if err != nil {
+		return nil, err
+	}
+	selected, names, err := selectRuntimeComponents(channel, components)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]RuntimeVerification, 0, len(names))
+	for _, name := range names {
+		result := RuntimeVerification{Component: name, Version: selected[name].Version, Against: against}
+		if err := i.verifyRuntimeComponent(ctx, name, selected[name], &result); err != nil {
+			result.Error = err.Error()
+		}
+		i.logf("[verify_runtime] %s: reproducible=%t differences=%d", name, result.Reproducible, len(result.Differences))
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (i *Installer) verifyRuntimeComponent(
+	ctx context.Context,
+	componentName string,
+	component RuntimeComponentLock,
+	result *RuntimeVerification,
+) error {
+	if len(component.Build.Commands) == 0 {
+		return fmt.Errorf("runtime build commands are missing for %s", componentName)
+	}
+	versionDir := filepath.Join(i.opts.RuntimeInstallDir, componentName, component.Version)
+
+	var expected map[string]string
+	switch result.Against {
+	case VerifyAgainstAttestation:
+		manifestURL := strings.TrimSpace(component.Binary.ManifestURL)
+		if manifestURL == "" {
+			return fmt.Errorf("runtime lock has no binary.manifest_url for %s", componentName)
+		}
+		body, err := i.downloadBytes(ctx, manifestURL)
+		if err != nil {
+			return fmt.Errorf("download attestation for %s: %w", componentName, err)
+		}
+		if expected, err = parseRuntimeManifest(body); err != nil {
+			return fmt.Errorf("parse attestation for %s: %w", componentName, err)
+		}
+	default:
+		if _, err := os.Stat(versionDir); err != nil {
+			return fmt.Errorf("%s %s is not installed: %w", componentName, component.Version, err)
+		}
+		var err error
+		if expected, err = runtimeTreeManifest(versionDir); err != nil {
+			return fmt.Errorf("hash installed %s: %w", componentName, err)
+		}
+	}
+
+	actual, err := i.rebuildRuntimeComponent(ctx, componentName, component, versionDir)
+	if err != nil {
+		return err
+	}
+	if result.Against == VerifyAgainstAttestation {
+		// sha256sum listings only cover regular files.
+		for path, sum := range actual {
+			if strings.HasPrefix(sum, "-> ") {
+				delete(actual, path)
+			}
+		}
+	}
+	result.Files = len(actual)
+	result.Differences = compareRuntimeManifests(expected, actual, result.Against == VerifyAgainstInstalled)
+	result.Reproducible = len(result.Differences) == 0
+	return nil
+}
+
+// rebuildRuntimeComponent builds the component into a scratch dir mounted
+// over versionDir and returns the manifest of the output.
+func (i *Installer) rebuildRuntimeComponent(
+	ctx context.Context,
+	componentName string,
+	component RuntimeComponentLock,
+	versionDir string,
+) (map[string]string, error) {
+	buildRoot, sourceDir, err := i.fetchRuntimeSource(ctx, componentName, component)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.RemoveAll(buildRoot)
+	}()
+
+	stageDir, err := os.MkdirTemp("", "aipanel-verify-"+componentName+"-*")
+	if err != nil {
+		return nil, fmt.Errorf("create verify dir for %s: %w", componentName, err)
+	}
+	defer func() {
+		_ = os.RemoveAll(stageDir)
+	}()
+	//nolint:gosec // Same mode as a real version dir.
+	if err := os.Chmod(stageDir, 0o755); err != nil {
+		return nil, fmt.Errorf("chmod verify dir for %s: %w", componentName, err)
+	}
+	// The bind mount needs a mount point; one created here is removed again.
+	if _, err := os.Stat(versionDir); os.IsNotExist(err) {
+		//nolint:gosec // Runtime binaries must be traversable by non-root service users (e.g. postgres).
+		if err := os.MkdirAll(versionDir, 0o755); err != nil {
+			return nil, fmt.Errorf("create mount point for %s: %w", componentName, err)
+		}
+		defer func() {
+			_ = os.Remove(versionDir)
+		}()
+	}
+
+	script := []string{
+		"mount --bind " + shellQuote(stageDir) + " " + shellQuote(versionDir),
+		"cd " + shellQuote(sourceDir),
+	}
+	for _, command := range component.Build.Commands {
+		script = append(script, renderRuntimeBuildCommand(i.opts, componentName, component.Version, command))
+	}
+	i.logf("[verify_runtime] rebuilding %s %s from %s", componentName, component.Version, component.SourceURL)
+	if _, err := i.runner.Run(ctx, "unshare", "--mount", "--propagation", "private",
+		"bash", "-lc", strings.Join(script, " && ")); err != nil {
+		return nil, fmt.Errorf("rebuild %s: %w", componentName, err)
+	}
+	manifest, err := runtimeTreeManifest(stageDir)
+	if err != nil {
+		return nil, fmt.Errorf("hash rebuilt %s: %w", componentName, err)
+	}
+	if len(manifest) == 0 {
+		return nil, fmt.Errorf("runtime build output is empty for %s", componentName)
+	}
+	return manifest, nil
+}
+
+// runtimeTreeManifest maps every file under root (slash-separated relative
+// path) to its sha256, or to "-> target" for a symlink. The installer's own
+// state file is left out.
+func runtimeTreeManifest(root string) (map[string]string, error) {
+	manifest := map[string]string{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == runtimeComponentStateFile {
+			return nil
+		}
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			manifest[rel] = "-> " + target
+		case d.Type().IsRegular():
+			sum, err := fileSHA256(path)
+			if err != nil {
+				return err
+			}
+			manifest[rel] = sum
+		}
+		return nil
+	})
+	return manifest, err
+}
+
+// parseRuntimeManifest reads a sha256sum listing ("<sha256>  ./path").
+func parseRuntimeManifest(raw []byte) (map[string]string, error) {
+	manifest := map[string]string{}
+	for n, line := range strings.Split(string(raw), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sum, path, ok := strings.Cut(line, " ")
+		path = strings.TrimPrefix(strings.TrimSpace(path), "*")
+		path = strings.TrimPrefix(path, "./")
+		if !ok || !isValidSHA256(sum) || path == "" {
+			return nil, fmt.Errorf("line %d: expected \"<sha256>  <path>\"", n+1)
+		}
+		manifest[path] = strings.ToLower(sum)
+	}
+	if len(manifest) == 0 {
+		return nil, fmt.Errorf("manifest is empty")
+	}
+	return manifest, nil
+}
+
+// compareRuntimeManifests lists the differences between a reference tree
+// and a rebuild, sorted by path. With installedTree the reference may hold
+// extra files (runtime state), and files in runtimeMutableDirs are skipped.
+func compareRuntimeManifests(expected, actual map[string]string, installedTree bool) []string {
+	var differences []string
+	for path, sum := range actual {
+		if installedTree && inRuntimeMutableDir(path) {
+			continue
+		}
+		want, found := expected[path]
+		switch {
+		case !found && installedTree:
+			differences = append(differences, "missing from installed tree: "+path)
+		case !found:
+			differences = append(differences, "not in attestation: "+path)
+		case want != sum:
+			differences = append(differences, "differs: "+path)
+		}
+	}
+	if !installedTree {
+		for path := range expected {
+			if _, found := actual[path]; !found {
+				differences = append(differences, "not rebuilt: "+path)
+			}
+		}
+	}
+	sort.Slice(differences, func(a, b int) bool {
+		return differencePath(differences[a]) < differencePath(differences[b])
+	})
+	return differences
+}
+
+func differencePath(difference string) string {
+	_, path, _ := strings.Cut(difference, ": ")
+	return path
+}
+
+func inRuntimeMutableDir(path string) bool {
+	top, _, _ := strings.Cut(path, "/")
+	_, mutable := runtimeMutableDirs[top]
+	return mutable
+}
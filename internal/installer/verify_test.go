@@ -0,0 +1,166 @@
+package installer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// fakeNamespaceRunner runs the verify build script without a mount
+// namespace: the bind mount is emulated by pointing the build at the
+// scratch dir instead of the version dir.
+type fakeNamespaceRunner struct {
+	commands []string
+}
+
+var bindMountPattern = regexp.MustCompile(`^mount --bind '([^']+)' '([^']+)' && `)
+
+func (r *fakeNamespaceRunner) Run(ctx context.Context, name string, args ...string) (string, error) {
+	r.commands = append(r.commands, strings.TrimSpace(name+" "+strings.Join(args, " ")))
+	if name != "unshare" {
+		return "", nil
+	}
+	script := args[len(args)-1]
+	m := bindMountPattern.FindStringSubmatch(script)
+	if m == nil {
+		return "", fmt.Errorf("unexpected verify script: %s", script)
+	}
+	script = strings.ReplaceAll(strings.TrimPrefix(script, m[0]), m[2], m[1])
+	out, err := exec.CommandContext(ctx, "bash", "-c", script).CombinedOutput() //nolint:gosec // Test helper executes controlled build commands.
+	if err != nil {
+		return string(out), fmt.Errorf("build shell failed: %w (%s)", err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+func newVerifyInstaller(t *testing.T, manifest string) (*Installer, *fakeNamespaceRunner, string) {
+	t.Helper()
+	root := t.TempDir()
+	sourceTar := filepath.Join(root, "nginx-source.tar.gz")
+	if err := writeTarGzArtifactEntries(sourceTar, map[string][]byte{
+		"nginx-src/nginx":      []byte("nginx-binary"),
+		"nginx-src/nginx.conf": []byte("events {}"),
+	}); err != nil {
+		t.Fatalf("write source artifact: %v", err)
+	}
+	sum, err := fileSHA256(sourceTar)
+	if err != nil {
+		t.Fatalf("source sha: %v", err)
+	}
+	manifestPath := filepath.Join(root, "nginx.sha256")
+	if err := os.WriteFile(manifestPath, []byte(manifest), 0o600); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+	lockPath := filepath.Join(root, "lock.json")
+	if err := os.WriteFile(lockPath, []byte(fmt.Sprintf(`{
+  "schema_version": 1,
+  "channels": {
+    "stable": {
+      "nginx": {
+        "version": "1.27.4",
+        "source_url": "file://%s",
+        "source_sha256": "%s",
+        "build": {
+          "commands": [
+            "mkdir -p {{install_dir}}/sbin {{install_dir}}/conf",
+            "cp nginx {{install_dir}}/sbin/nginx",
+            "cp nginx.conf {{install_dir}}/conf/nginx.conf"
+          ]
+        },
+        "binary": {
+          "url": "https://runtime.example.com/stable/nginx-1.27.4.tar.gz",
+          "sha256": "%s",
+          "signature_url": "https://runtime.example.com/stable/nginx-1.27.4.tar.gz.asc",
+          "public_key_fingerprint": "177F4010FE56CA3336300305F1656F24C74CD1D8",
+          "manifest_url": "file://%s"
+        }
+      }
+    }
+  }
+}`, sourceTar, sum, sum, manifestPath)), 0o600); err != nil {
+		t.Fatalf("write lock: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.RootFSPath = root
+	opts.RuntimeLockPath = lockPath
+	opts.RuntimeLockURL = ""
+	opts.RuntimeInstallDir = filepath.Join(root, "runtime")
+	opts.LogFilePath = filepath.Join(root, "verify.log")
+	opts.VerifyUpstreamSources = false
+	runner := &fakeNamespaceRunner{}
+	return New(opts, runner), runner, filepath.Join(opts.RuntimeInstallDir, "nginx", "1.27.4")
+}
+
+func writeInstalledFile(t *testing.T, versionDir, rel, content string) {
+	t.Helper()
+	path := filepath.Join(versionDir, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write %s: %v", rel, err)
+	}
+}
+
+func TestVerifyRuntime_ComparesRebuildWithInstalledTree(t *testing.T) {
+	ins, runner, versionDir := newVerifyInstaller(t, "")
+	writeInstalledFile(t, versionDir, "sbin/nginx", "nginx-binary")
+	// Rewritten by the installer and written at runtime; neither counts.
+	writeInstalledFile(t, versionDir, "conf/nginx.conf", "panel-managed config")
+	writeInstalledFile(t, versionDir, "logs/error.log", "runtime log")
+
+	results, err := ins.VerifyRuntime(context.Background(), nil, "")
+	if err != nil {
+		t.Fatalf("verify runtime: %v", err)
+	}
+	if len(results) != 1 || !results[0].Reproducible || results[0].Error != "" {
+		t.Fatalf("expected nginx to verify, got %+v", results)
+	}
+	if !strings.Contains(strings.Join(runner.commands, "\n"), "unshare --mount --propagation private") {
+		t.Fatalf("expected build in a private mount namespace, got:\n%s", strings.Join(runner.commands, "\n"))
+	}
+	body, err := os.ReadFile(filepath.Join(versionDir, "conf", "nginx.conf")) //nolint:gosec // test reads file generated in temp dir.
+	if err != nil || string(body) != "panel-managed config" {
+		t.Fatalf("expected installed tree to stay untouched, got %q: %v", body, err)
+	}
+
+	writeInstalledFile(t, versionDir, "sbin/nginx", "tampered")
+	results, err = ins.VerifyRuntime(context.Background(), []string{"nginx"}, VerifyAgainstInstalled)
+	if err != nil {
+		t.Fatalf("verify runtime: %v", err)
+	}
+	if results[0].Reproducible || strings.Join(results[0].Differences, ",") != "differs: sbin/nginx" {
+		t.Fatalf("expected tampered binary to be reported, got %+v", results[0])
+	}
+}
+
+func TestVerifyRuntime_ComparesRebuildWithAttestation(t *testing.T) {
+	binarySum := sha256Hex("nginx-binary")
+	confSum := sha256Hex("events {}")
+	ins, _, versionDir := newVerifyInstaller(t, fmt.Sprintf("%s  ./sbin/nginx\n%s  ./conf/nginx.conf\n%s  ./sbin/extra\n",
+		binarySum, confSum, binarySum))
+
+	results, err := ins.VerifyRuntime(context.Background(), nil, VerifyAgainstAttestation)
+	if err != nil {
+		t.Fatalf("verify runtime: %v", err)
+	}
+	if got := strings.Join(results[0].Differences, ","); got != "not rebuilt: sbin/extra" {
+		t.Fatalf("expected attested file missing from rebuild, got %q (%s)", got, results[0].Error)
+	}
+	if _, err := os.Stat(versionDir); !os.IsNotExist(err) {
+		t.Fatalf("expected temporary mount point to be removed, got %v", err)
+	}
+}
+
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,2 +1,121 @@
 // Package audit implements the append-only audit event log.
 package audit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+// Event is one audit log entry.
+type Event struct {
+	ID           int64     `json:"id"`
+	Actor        string    `json:"actor"`
+	Impersonator string    `json:"impersonator,omitempty"`
+	Action       string    `json:"action"`
+	Details      string    `json:"details"`
+	RemoteIP     string    `json:"remote_ip,omitempty"`
+	SiteID       int64     `json:"site_id,omitempty"`
+	DatabaseID   int64     `json:"database_id,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Query selects events touching one resource. BeforeID pages backwards:
+// pass the smallest id of the previous page.
+type Query struct {
+	SiteID     int64
+	DatabaseID int64
+	BeforeID   int64
+	Limit      int
+}
+
+// List returns matching events, newest first. A site's feed includes the
+// events of its databases.
+func List(ctx context.Context, store *sqlite.Store, q Query) ([]Event, error) {
+	var where []string
+	if q.SiteID > 0 {
+		where = append(where, fmt.Sprintf("site_id = %d", q.SiteID))
+	}
+	if q.DatabaseID > 0 {
+		where = append(where, fmt.Sprintf("database_id = %d", q.DatabaseID))
+	}
+	if len(where) == 0 {
+		return nil, fmt.Errorf("invalid activity query: site or database is required")
+	}
+	if q.BeforeID > 0 {
+		where = append(where, fmt.Sprintf("id < %d", q.BeforeID))
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	limit = min(limit, maxLimit)
+	rows, err := store.QueryAuditJSON(ctx, fmt.Sprintf(`
+SELECT id, actor, action, details, remote_ip, impersonator, site_id, database_id, created_at
+FROM audit_events
+WHERE %s
+ORDER BY id DESC
+LIMIT %d;`, strings.Join(where, " AND "), limit))
+	if err != nil {
+		return nil, fmt.Errorf("list audit events: %w", err)
+	}
+	events := make([]Event, 0, len(rows))
+	for _, row := range rows {
+		e := Event{
+			ID:         toInt64(row["id"]),
+			SiteID:     toInt64(row["site_id"]),
+			DatabaseID: toInt64(row["database_id"]),
+			CreatedAt:  time.Unix(toInt64(row["created_at"]), 0).UTC(),
+		}
+		e.Actor, _ = row["actor"].(string)
+		e.Impersonator, _ = row["impersonator"].(string)
+		e.Action, _ = row["action"].(string)
+		e.Details, _ = row["details"].(string)
+		e.RemoteIP, _ = row["remote_ip"].(string)
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// Field returns the value of key in event details written as key=value
+// pairs separated by spaces or commas, or "" when key is absent.
+func Field(details, key string) string {
+	for _, part := range strings.FieldsFunc(details, func(r rune) bool { return r == ' ' || r == ',' }) {
+		if k, v, ok := strings.Cut(part, "="); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+// FieldID returns the numeric value of key in details, or 0.
+func FieldID(details, key string) int64 {
+	id, err := strconv.ParseInt(Field(details, key), 10, 64)
+	if err != nil || id < 0 {
+		return 0
+	}
+	return id
+}
+
+func toInt64(v any) int64 {
+	switch t := v.(type) {
+	case float64:
+		return int64(t)
+	case int64:
+		return t
+	case string:
+		i, _ := strconv.ParseInt(t, 10, 64)
+		return i
+	default:
+		return 0
+	}
+}
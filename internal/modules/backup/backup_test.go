@@ -0,0 +1,718 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+func newTestRepository(t *testing.T) *Repository {
+	t.Helper()
+	repo := NewRepository(filepath.Join(t.TempDir(), "repo"))
+	// Small chunks so a few hundred KiB exercise boundaries.
+	repo.minChunk, repo.avgBits, repo.maxChunk = 2<<10, 13, 64<<10
+	return repo
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0o640); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func TestRepository_IncrementalBackupDeduplicatesAndRestores(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	src := t.TempDir()
+	big := make([]byte, 512<<10)
+	rand.New(rand.NewSource(1)).Read(big)
+	writeFile(t, filepath.Join(src, "uploads", "video.bin"), big)
+	writeFile(t, filepath.Join(src, "index.php"), []byte("<?php echo 'v1';"))
+	writeFile(t, filepath.Join(src, "wp-content", "cache", "page.html"), []byte("cached"))
+	writeFile(t, filepath.Join(src, "debug.log"), []byte("noise"))
+	if err := os.Symlink("index.php", filepath.Join(src, "home.php")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	first, err := repo.Backup(ctx, src, BackupOptions{Tag: "site:1", Trigger: TriggerScheduled})
+	if err != nil {
+		t.Fatalf("first backup: %v", err)
+	}
+	if first.Files != 2 || first.Added < int64(len(big)) {
+		t.Fatalf("unexpected first snapshot: %+v", first)
+	}
+
+	// Insert a few bytes near the start of the large file: content-defined
+	// chunking keeps most of the following chunks.
+	edited := append(append(append([]byte{}, big[:1000]...), []byte("inserted")...), big[1000:]...)
+	writeFile(t, filepath.Join(src, "uploads", "video.bin"), edited)
+	writeFile(t, filepath.Join(src, "index.php"), []byte("<?php echo 'v2';"))
+	second, err := repo.Backup(ctx, src, BackupOptions{Tag: "site:1", Trigger: TriggerScheduled})
+	if err != nil {
+		t.Fatalf("second backup: %v", err)
+	}
+	if second.Parent != first.ID {
+		t.Fatalf("expected parent %s, got %+v", first.ID, second)
+	}
+	if second.Added == 0 || second.Added > int64(len(big))/4 {
+		t.Fatalf("expected a small incremental snapshot, added %d of %d bytes", second.Added, len(big))
+	}
+	third, err := repo.Backup(ctx, src, BackupOptions{Tag: "site:1", Trigger: TriggerOnDemand})
+	if err != nil || third.Added != 0 {
+		t.Fatalf("expected an unchanged tree to add nothing, got %+v err=%v", third, err)
+	}
+
+	top, err := repo.List(first.ID, "")
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	var names []string
+	for _, e := range top {
+		names = append(names, e.Path+":"+e.Type)
+	}
+	if got := strings.Join(names, " "); got != "home.php:symlink index.php:file uploads:dir wp-content:dir" {
+		t.Fatalf("unexpected top level (cache and logs must be excluded): %s", got)
+	}
+	if _, err := repo.List(first.ID, "wp-content/cache"); !errors.Is(err, ErrEntryNotFound) {
+		t.Fatalf("expected excluded cache dir to be missing, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := repo.WriteFile(ctx, first.ID, "/index.php", &buf); err != nil || buf.String() != "<?php echo 'v1';" {
+		t.Fatalf("download v1: %q err=%v", buf.String(), err)
+	}
+
+	target := t.TempDir()
+	res, err := repo.Restore(ctx, first.ID, []string{"index.php", "uploads"}, target)
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if res.Files != 2 || res.Dirs != 1 {
+		t.Fatalf("unexpected restore result: %+v", res)
+	}
+	got, _ := os.ReadFile(filepath.Join(target, "uploads", "video.bin"))
+	if !bytes.Equal(got, big) {
+		t.Fatal("restored video.bin differs from the first snapshot")
+	}
+	if info, err := os.Stat(filepath.Join(target, "index.php")); err != nil || info.Mode().Perm() != 0o640 {
+		t.Fatalf("expected restored mode 0640, got %v err=%v", info, err)
+	}
+	if _, err := repo.Restore(ctx, first.ID, []string{"missing.txt"}, target); !errors.Is(err, ErrEntryNotFound) {
+		t.Fatalf("expected ErrEntryNotFound, got %v", err)
+	}
+
+	// Forgetting the first snapshot frees only the chunks nothing else uses.
+	if err := repo.Forget(first.ID); err != nil {
+		t.Fatalf("forget: %v", err)
+	}
+	pruned, err := repo.Prune()
+	if err != nil || pruned.Chunks == 0 {
+		t.Fatalf("expected prune to remove the old chunks, got %+v err=%v", pruned, err)
+	}
+	buf.Reset()
+	if _, err := repo.WriteFile(ctx, second.ID, "uploads/video.bin", &buf); err != nil || !bytes.Equal(buf.Bytes(), edited) {
+		t.Fatalf("second snapshot damaged by prune: err=%v", err)
+	}
+}
+
+func TestRepository_RestoreDoesNotFollowSymlinks(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	src := t.TempDir()
+	writeFile(t, filepath.Join(src, "conf", "app.ini"), []byte("safe"))
+	snap, err := repo.Backup(ctx, src, BackupOptions{Tag: "site:1"})
+	if err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+
+	outside := t.TempDir()
+	target := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(target, "conf")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	if _, err := repo.Restore(ctx, snap.ID, []string{"conf/app.ini"}, target); err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Fatalf("expected restore through a symlinked directory to fail, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "app.ini")); !os.IsNotExist(err) {
+		t.Fatalf("restore wrote outside the target: %v", err)
+	}
+
+	// A symlink at the file itself is replaced, not written through.
+	if err := os.Remove(filepath.Join(target, "conf")); err != nil {
+		t.Fatalf("remove symlink: %v", err)
+	}
+	writeFile(t, filepath.Join(outside, "victim"), []byte("untouched"))
+	if err := os.Mkdir(filepath.Join(target, "conf"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "victim"), filepath.Join(target, "conf", "app.ini")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	if _, err := repo.Restore(ctx, snap.ID, []string{"conf/app.ini"}, target); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if raw, _ := os.ReadFile(filepath.Join(outside, "victim")); string(raw) != "untouched" {
+		t.Fatalf("restore followed a symlink: victim now %q", raw)
+	}
+	if info, err := os.Lstat(filepath.Join(target, "conf", "app.ini")); err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("expected a regular file, got %v err=%v", info, err)
+	}
+}
+
+func TestService_SiteBackupsRetentionAndRestore(t *testing.T) {
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	docroot := filepath.Join(t.TempDir(), "public_html")
+	writeFile(t, filepath.Join(docroot, "wp-config.php"), []byte("v1"))
+	for _, sql := range []string{
+		"INSERT INTO sites(domain, root_dir, php_version, system_user, status, created_at, updated_at) VALUES('example.com','" + docroot + "','8.3','site_example_com','active',1,1);",
+		"INSERT INTO sites(domain, root_dir, php_version, system_user, status, created_at, updated_at) VALUES('other.com','/var/www/other.com/public_html','8.3','site_other_com','active',1,1);",
+	} {
+		if err := store.ExecPanel(ctx, sql); err != nil {
+			t.Fatalf("seed site: %v", err)
+		}
+	}
+	svc := NewService(store, nil, Options{Retention: 2})
+	queue := jobqueue.New(store, nil)
+	svc.RegisterJobs(queue)
+
+	if _, err := svc.BackupSite(ctx, 1, "admin@example.com"); err != nil {
+		t.Fatalf("queue backup: %v", err)
+	}
+	if _, err := queue.RunPending(ctx); err != nil {
+		t.Fatalf("run jobs: %v", err)
+	}
+	snaps, err := svc.Snapshots(ctx, 1)
+	if err != nil || len(snaps) != 1 || snaps[0].Trigger != TriggerOnDemand {
+		t.Fatalf("expected one on-demand snapshot, got %+v err=%v", snaps, err)
+	}
+	first := snaps[0].ID
+
+	writeFile(t, filepath.Join(docroot, "wp-config.php"), []byte("v2 broken"))
+	if _, err := svc.RestoreFiles(ctx, 2, first, []string{"wp-config.php"}, "", "admin@example.com"); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Fatalf("expected another site's snapshot to be hidden, got %v", err)
+	}
+	res, err := svc.RestoreFiles(ctx, 1, first, []string{"wp-config.php"}, "", "admin@example.com")
+	if err != nil || res.Files != 1 {
+		t.Fatalf("restore: %+v err=%v", res, err)
+	}
+	if raw, _ := os.ReadFile(filepath.Join(docroot, "wp-config.php")); string(raw) != "v1" {
+		t.Fatalf("expected v1 restored, got %q", raw)
+	}
+	snaps, _ = svc.Snapshots(ctx, 1)
+	if len(snaps) != 2 || snaps[0].Trigger != TriggerPreRestore {
+		t.Fatalf("expected a pre-restore snapshot, got %+v", snaps)
+	}
+	var buf bytes.Buffer
+	if err := svc.Download(ctx, 1, snaps[0].ID, "wp-config.php", &buf); err != nil || buf.String() != "v2 broken" {
+		t.Fatalf("pre-restore snapshot should hold the replaced file, got %q err=%v", buf.String(), err)
+	}
+
+	if queued, err := svc.RunNightly(ctx); err != nil || queued != 2 {
+		t.Fatalf("expected two nightly jobs, got %d err=%v", queued, err)
+	}
+	// other.com has no docroot on disk, so its job fails; example.com's
+	// snapshot pushes the oldest one out of retention.
+	_, _ = queue.RunPending(ctx)
+	snaps, _ = svc.Snapshots(ctx, 1)
+	if len(snaps) != 2 || snaps[1].ID == first || snaps[0].Trigger != TriggerScheduled {
+		t.Fatalf("expected retention to keep the newest two snapshots, got %+v", snaps)
+	}
+
+	rows, err := store.QueryAuditJSON(ctx, "SELECT action, site_id FROM audit_events ORDER BY id;")
+	if err != nil {
+		t.Fatalf("query audit: %v", err)
+	}
+	var actions []string
+	for _, row := range rows {
+		actions = append(actions, row["action"].(string))
+	}
+	if got := strings.Join(actions, ","); got != "backup.site.queue,backup.site.restore" {
+		t.Fatalf("unexpected audit events: %s", got)
+	}
+}
+
+func TestKeyring_EncryptDecrypt(t *testing.T) {
+	install, err := GenerateIdentity()
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	client, _ := GenerateIdentity()
+	stranger, _ := GenerateIdentity()
+	keys := &Keyring{Recipients: []*Recipient{install.Recipient(), client.Recipient()}, Identities: []*Identity{install}}
+
+	for _, size := range []int{0, 1, segmentSize, segmentSize + 1, 3*segmentSize - 7} {
+		plain := make([]byte, size)
+		rand.New(rand.NewSource(int64(size))).Read(plain)
+		var sealed bytes.Buffer
+		enc, err := keys.Encrypt(&sealed)
+		if err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+		if _, err := enc.Write(plain); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+		for _, kr := range []*Keyring{keys, (&Keyring{}).With(client)} {
+			r, err := kr.Decrypt(bytes.NewReader(sealed.Bytes()))
+			if err != nil {
+				t.Fatalf("decrypt %d bytes: %v", size, err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil || !bytes.Equal(got, plain) {
+				t.Fatalf("round trip of %d bytes failed: err=%v", size, err)
+			}
+		}
+		if _, err := (&Keyring{}).With(stranger).Decrypt(bytes.NewReader(sealed.Bytes())); !errors.Is(err, ErrNoKey) {
+			t.Fatalf("expected ErrNoKey for a foreign key, got %v", err)
+		}
+		if size > 0 {
+			r, err := keys.Decrypt(bytes.NewReader(sealed.Bytes()[:sealed.Len()-1]))
+			if err == nil {
+				_, err = io.ReadAll(r)
+			}
+			if err == nil {
+				t.Fatalf("expected truncated %d-byte archive to fail", size)
+			}
+		}
+	}
+
+	if _, err := keys.Decrypt(strings.NewReader("legacy plaintext")); !errors.Is(err, ErrPlaintext) {
+		t.Fatalf("expected plaintext refused by an encrypting keyring, got %v", err)
+	}
+	legacy := keys.With()
+	legacy.AllowPlaintext = true
+	for _, kr := range []*Keyring{legacy, nil, {}} {
+		r, err := kr.Decrypt(strings.NewReader("legacy plaintext"))
+		if err != nil {
+			t.Fatalf("decrypt plaintext: %v", err)
+		}
+		if got, _ := io.ReadAll(r); string(got) != "legacy plaintext" {
+			t.Fatalf("expected plaintext to pass through, got %q", got)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "planted.sql")
+	if err := os.WriteFile(path, []byte("DROP TABLE users;"), 0o600); err != nil {
+		t.Fatalf("write archive: %v", err)
+	}
+	if _, err := OpenFile(path, keys); !errors.Is(err, ErrPlaintext) {
+		t.Fatalf("expected a planted plaintext archive refused, got %v", err)
+	}
+
+	if _, err := ParseRecipient("age1notours"); err == nil || !strings.Contains(err.Error(), "invalid") {
+		t.Fatalf("expected an invalid public key error, got %v", err)
+	}
+	parsed, err := ParseIdentity(client.String())
+	if err != nil || parsed.Recipient().String() != client.Recipient().String() {
+		t.Fatalf("identity round trip: %v", err)
+	}
+}
+
+func TestRepository_EncryptedSnapshotsNeedTheKey(t *testing.T) {
+	ctx := context.Background()
+	install, _ := GenerateIdentity()
+	client, _ := GenerateIdentity()
+	base := newTestRepository(t)
+	repo := base.withKeys(&Keyring{Recipients: []*Recipient{install.Recipient(), client.Recipient()}, Identities: []*Identity{install}})
+	src := t.TempDir()
+	writeFile(t, filepath.Join(src, "secret.txt"), []byte("card numbers"))
+	snap, err := repo.Backup(ctx, src, BackupOptions{Tag: "site:1"})
+	if err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+	if snap.KeySet == "" {
+		t.Fatal("expected an encrypted snapshot to record its key set")
+	}
+	err = filepath.WalkDir(base.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		raw, _ := os.ReadFile(p)
+		if bytes.Contains(raw, []byte("secret.txt")) {
+			t.Errorf("%s holds plaintext", p)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk repository: %v", err)
+	}
+
+	// Another install: nothing opens, restores are refused and prune
+	// keeps the chunks it cannot account for.
+	other, _ := GenerateIdentity()
+	foreign := base.withKeys((&Keyring{}).With(other))
+	if snaps, err := foreign.Snapshots("site:1"); err != nil || len(snaps) != 0 {
+		t.Fatalf("expected unreadable snapshots to be hidden, got %+v err=%v", snaps, err)
+	}
+	target := t.TempDir()
+	if _, err := foreign.Restore(ctx, snap.ID, []string{""}, target); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("expected ErrNoKey, got %v", err)
+	}
+	if entries, _ := os.ReadDir(target); len(entries) != 0 {
+		t.Fatalf("refused restore wrote %d entries", len(entries))
+	}
+	if _, err := foreign.Prune(); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("expected prune to refuse, got %v", err)
+	}
+
+	// The client-held key alone restores everything.
+	escrow := base.withKeys((&Keyring{}).With(client))
+	if _, err := escrow.Restore(ctx, snap.ID, []string{""}, target); err != nil {
+		t.Fatalf("restore with client key: %v", err)
+	}
+	if raw, _ := os.ReadFile(filepath.Join(target, "secret.txt")); string(raw) != "card numbers" {
+		t.Fatalf("unexpected restored content %q", raw)
+	}
+
+	// A new recipient set does not reuse chunks the new key cannot open.
+	third, _ := GenerateIdentity()
+	rekeyed := base.withKeys(&Keyring{Recipients: []*Recipient{install.Recipient(), third.Recipient()}, Identities: []*Identity{install}})
+	next, err := rekeyed.Backup(ctx, src, BackupOptions{Tag: "site:1"})
+	if err != nil || next.KeySet == snap.KeySet || next.Added != int64(len("card numbers")) {
+		t.Fatalf("expected a full copy under the new key set, got %+v err=%v", next, err)
+	}
+	var buf bytes.Buffer
+	if _, err := base.withKeys((&Keyring{}).With(third)).WriteFile(ctx, next.ID, "secret.txt", &buf); err != nil || buf.String() != "card numbers" {
+		t.Fatalf("new recipient cannot read the new snapshot: %q err=%v", buf.String(), err)
+	}
+	if pruned, err := rekeyed.Prune(); err != nil || pruned.Chunks != 0 {
+		t.Fatalf("expected nothing to prune, got %+v err=%v", pruned, err)
+	}
+}
+
+func TestService_BackupRecipientsAndKeyedRestore(t *testing.T) {
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	docroot := filepath.Join(t.TempDir(), "public_html")
+	writeFile(t, filepath.Join(docroot, "index.php"), []byte("v1"))
+	if err := store.ExecPanel(ctx, "INSERT INTO sites(domain, root_dir, php_version, system_user, status, created_at, updated_at) VALUES('example.com','"+docroot+"','8.3','site_example_com','active',1,1);"); err != nil {
+		t.Fatalf("seed site: %v", err)
+	}
+	svc := NewService(store, nil, Options{})
+	queue := jobqueue.New(store, nil)
+	svc.RegisterJobs(queue)
+
+	client, _ := GenerateIdentity()
+	if _, err := svc.AddRecipient(ctx, "client", "not-a-key", "admin"); err == nil || !strings.Contains(err.Error(), "invalid") {
+		t.Fatalf("expected invalid key to be rejected, got %v", err)
+	}
+	rec, err := svc.AddRecipient(ctx, "client escrow", client.Recipient().String(), "admin")
+	if err != nil || rec.ID == 0 {
+		t.Fatalf("add recipient: %+v err=%v", rec, err)
+	}
+	if _, err := svc.AddRecipient(ctx, "again", client.Recipient().String(), "admin"); err == nil {
+		t.Fatal("expected a duplicate public key to be rejected")
+	}
+
+	if _, err := svc.BackupSite(ctx, 1, "admin"); err != nil {
+		t.Fatalf("queue backup: %v", err)
+	}
+	if _, err := queue.RunPending(ctx); err != nil {
+		t.Fatalf("run jobs: %v", err)
+	}
+	snaps, err := svc.Snapshots(ctx, 1)
+	if err != nil || len(snaps) != 1 || snaps[0].KeySet == "" {
+		t.Fatalf("expected one encrypted snapshot, got %+v err=%v", snaps, err)
+	}
+	if info, err := os.Stat(KeyFile(store.DataDir)); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected a 0600 install key, got %v err=%v", info, err)
+	}
+
+	// The server is rebuilt with a new install key: the old snapshot only
+	// restores with the client-held key.
+	if err := os.Remove(KeyFile(store.DataDir)); err != nil {
+		t.Fatalf("remove install key: %v", err)
+	}
+	writeFile(t, filepath.Join(docroot, "index.php"), []byte("lost"))
+	if _, err := svc.RestoreFiles(ctx, 1, snaps[0].ID, []string{"index.php"}, "", "admin"); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("expected restore without the key to be refused, got %v", err)
+	}
+	if _, err := svc.RestoreFiles(ctx, 1, snaps[0].ID, []string{"index.php"}, client.String(), "admin"); err != nil {
+		t.Fatalf("restore with client key: %v", err)
+	}
+	if raw, _ := os.ReadFile(filepath.Join(docroot, "index.php")); string(raw) != "v1" {
+		t.Fatalf("expected v1 restored, got %q", raw)
+	}
+
+	exported, err := svc.ExportInstallKey(ctx, "admin")
+	if err != nil || !strings.HasPrefix(exported, IdentityPrefix) {
+		t.Fatalf("export install key: %q err=%v", exported, err)
+	}
+	if err := svc.DeleteRecipient(ctx, rec.ID, "admin"); err != nil {
+		t.Fatalf("delete recipient: %v", err)
+	}
+	if err := svc.DeleteRecipient(ctx, rec.ID, "admin"); !errors.Is(err, ErrRecipientNotFound) {
+		t.Fatalf("expected ErrRecipientNotFound, got %v", err)
+	}
+
+	rows, err := store.QueryAuditJSON(ctx, "SELECT action FROM audit_events ORDER BY id;")
+	if err != nil {
+		t.Fatalf("query audit: %v", err)
+	}
+	var actions []string
+	for _, row := range rows {
+		actions = append(actions, row["action"].(string))
+	}
+	want := "backup.recipient.add,backup.site.queue,backup.site.restore_refused,backup.site.restore,backup.key.export,backup.recipient.delete"
+	if got := strings.Join(actions, ","); got != want {
+		t.Fatalf("unexpected audit events: %s", got)
+	}
+}
+
+func TestService_RunHistorySettingsAndRestoreRun(t *testing.T) {
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	docroot := filepath.Join(t.TempDir(), "public_html")
+	writeFile(t, filepath.Join(docroot, "index.php"), []byte("v1"))
+	for _, sql := range []string{
+		"INSERT INTO sites(domain, root_dir, php_version, system_user, status, created_at, updated_at) VALUES('example.com','" + docroot + "','8.3','site_example_com','active',1,1);",
+		"INSERT INTO sites(domain, root_dir, php_version, system_user, status, created_at, updated_at) VALUES('other.com','/nonexistent/other.com','8.3','site_other_com','active',1,1);",
+	} {
+		if err := store.ExecPanel(ctx, sql); err != nil {
+			t.Fatalf("seed site: %v", err)
+		}
+	}
+	svc := NewService(store, nil, Options{})
+
+	if _, err := svc.UpdateSettings(ctx, SettingsRequest{SiteRetention: 400}, "admin@example.com"); err == nil || !strings.Contains(err.Error(), "invalid") {
+		t.Fatalf("expected out-of-range retention to be invalid, got %v", err)
+	}
+	req := SettingsRequest{SiteRetention: 1}
+	req.Remote.Endpoint = "https://s3.example.com/"
+	req.Remote.Bucket = "panel-backups"
+	req.Remote.AccessKey = "AKIDEXAMPLE"
+	req.Remote.SecretKey = "wJalrXUtnFEMI/K7MDENG"
+	if _, err := svc.UpdateSettings(ctx, req, "admin@example.com"); err != nil {
+		t.Fatalf("update settings: %v", err)
+	}
+	req.Remote.SecretKey = ""
+	settings, err := svc.UpdateSettings(ctx, req, "admin@example.com")
+	if err != nil {
+		t.Fatalf("update settings keeping the secret: %v", err)
+	}
+	if settings.Remote.SecretKey != "wJalrXUtnFEMI/K7MDENG" || settings.Remote.Endpoint != "https://s3.example.com" || settings.Remote.Region != defaultRemoteRegion {
+		t.Fatalf("unexpected settings: %+v", settings.Remote)
+	}
+	if raw, _ := json.Marshal(settings); strings.Contains(string(raw), "wJalrXUtnFEMI") {
+		t.Fatalf("secret key leaked in JSON: %s", raw)
+	}
+
+	if _, err := svc.BackupSiteNow(ctx, 1, "admin@example.com"); err != nil {
+		t.Fatalf("first backup: %v", err)
+	}
+	writeFile(t, filepath.Join(docroot, "index.php"), []byte("v2"))
+	if _, err := svc.BackupSiteNow(ctx, 1, "admin@example.com"); err != nil {
+		t.Fatalf("second backup: %v", err)
+	}
+	if _, err := svc.BackupSiteNow(ctx, 2, "admin@example.com"); err == nil {
+		t.Fatalf("expected a backup of a missing docroot to fail")
+	}
+	if snaps, _ := svc.Snapshots(ctx, 1); len(snaps) != 1 {
+		t.Fatalf("expected site_retention=1 to keep one snapshot, got %+v", snaps)
+	}
+
+	runs, err := svc.Runs(ctx, RunFilter{Kind: KindSiteFiles})
+	if err != nil || len(runs) != 3 {
+		t.Fatalf("expected three site runs, got %+v err=%v", runs, err)
+	}
+	if runs[0].Status != RunFailed || runs[0].Target != "other.com" || runs[0].Error == "" {
+		t.Fatalf("expected the newest run failed for other.com, got %+v", runs[0])
+	}
+	if runs[1].Status != RunOK || runs[1].Location == "" || runs[1].Trigger != TriggerOnDemand {
+		t.Fatalf("unexpected successful run: %+v", runs[1])
+	}
+	if runs, _ := svc.Runs(ctx, RunFilter{SiteID: 1, Limit: 1}); len(runs) != 1 || runs[0].SiteID != 1 {
+		t.Fatalf("expected the site filter and limit to apply, got %+v", runs)
+	}
+	if _, err := svc.Runs(ctx, RunFilter{Kind: "tape"}); err == nil || !strings.Contains(err.Error(), "invalid") {
+		t.Fatalf("expected an unknown kind to be invalid, got %v", err)
+	}
+
+	writeFile(t, filepath.Join(docroot, "index.php"), []byte("broken"))
+	if _, err := svc.RestoreRun(ctx, runs[0].ID, nil, "", "admin@example.com"); err == nil || !strings.Contains(err.Error(), "invalid restore") {
+		t.Fatalf("expected a failed run to be unrestorable, got %v", err)
+	}
+	if _, err := svc.RestoreRun(ctx, 999, nil, "", "admin@example.com"); !errors.Is(err, ErrRunNotFound) {
+		t.Fatalf("expected ErrRunNotFound, got %v", err)
+	}
+	if _, err := svc.RestoreRun(ctx, runs[1].ID, nil, "", "admin@example.com"); err != nil {
+		t.Fatalf("restore run: %v", err)
+	}
+	if raw, _ := os.ReadFile(filepath.Join(docroot, "index.php")); string(raw) != "v2" {
+		t.Fatalf("expected v2 restored, got %q", raw)
+	}
+
+	file, err := svc.BackupPanel(ctx, TriggerOnDemand)
+	if err != nil {
+		t.Fatalf("backup panel: %v", err)
+	}
+	runs, _ = svc.Runs(ctx, RunFilter{Kind: KindPanel})
+	if len(runs) != 1 || runs[0].Location != filepath.Base(file) || runs[0].Bytes == 0 {
+		t.Fatalf("expected the panel.db copy recorded, got %+v", runs)
+	}
+	if _, err := svc.RestoreRun(ctx, runs[0].ID, nil, "", "admin@example.com"); err == nil || !strings.Contains(err.Error(), "invalid restore") {
+		t.Fatalf("expected panel runs to be restored with aipanel restore, got %v", err)
+	}
+}
+
+// fakeS3 is a path-style bucket that checks requests are signed.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	mtimes  map[string]string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+		http.Error(w, "unsigned", http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/panel-backups/")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		var b strings.Builder
+		b.WriteString("<ListBucketResult>")
+		for k, v := range f.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+				fmt.Fprintf(&b, "<Contents><Key>%s</Key><Size>%d</Size></Contents>", k, len(v))
+			}
+		}
+		b.WriteString("<IsTruncated>false</IsTruncated></ListBucketResult>")
+		_, _ = io.WriteString(w, b.String())
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.objects[key] = body
+		f.mtimes[key] = r.Header.Get("X-Amz-Meta-Mtime")
+	case r.Method == http.MethodGet:
+		body, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, "<Error><Code>NoSuchKey</Code><Message>missing</Message></Error>")
+			return
+		}
+		w.Header().Set("X-Amz-Meta-Mtime", f.mtimes[key])
+		_, _ = w.Write(body)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestService_SyncRemoteMirrorsAndPulls(t *testing.T) {
+	ctx := context.Background()
+	bucket := &fakeS3{
+		objects: map[string][]byte{"panel/other-install.db": []byte("not ours")},
+		mtimes:  map[string]string{},
+	}
+	srv := httptest.NewServer(bucket)
+	defer srv.Close()
+
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	svc := NewService(store, nil, Options{})
+	if _, err := svc.SyncRemote(ctx); !errors.Is(err, ErrRemoteNotConfigured) {
+		t.Fatalf("expected ErrRemoteNotConfigured, got %v", err)
+	}
+	req := SettingsRequest{}
+	req.Remote.Endpoint = srv.URL
+	req.Remote.Bucket = "panel-backups"
+	req.Remote.Prefix = "srv1"
+	req.Remote.AccessKey = "AKIDEXAMPLE"
+	req.Remote.SecretKey = "secret"
+	req.Remote.PathStyle = true
+	settings, err := svc.UpdateSettings(ctx, req, "admin@example.com")
+	if err != nil {
+		t.Fatalf("update settings: %v", err)
+	}
+
+	dir := Dir(store.DataDir)
+	old := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile(t, filepath.Join(dir, "panel", "panel-20260102-030405.db"), []byte("panel copy"))
+	writeFile(t, filepath.Join(dir, "files", "chunks", "ab", "abcdef"), []byte("chunk"))
+	writeFile(t, filepath.Join(dir, ".tmp", "partial"), []byte("in progress"))
+	if err := os.Chtimes(filepath.Join(dir, "panel", "panel-20260102-030405.db"), old, old); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	bucket.objects["srv1/leftover.db"] = []byte("uploaded by an earlier install")
+
+	res, err := svc.SyncRemote(ctx)
+	if err != nil || res.Uploaded != 2 || res.Deleted != 0 || res.Bytes != int64(len("panel copy")+len("chunk")) {
+		t.Fatalf("first sync: %+v err=%v", res, err)
+	}
+	if _, ok := bucket.objects["srv1/.tmp/partial"]; ok {
+		t.Fatalf("in-progress files must not be uploaded")
+	}
+	if res, err := svc.SyncRemote(ctx); err != nil || res.Uploaded != 0 {
+		t.Fatalf("expected an unchanged dir to upload nothing, got %+v err=%v", res, err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "files", "chunks", "ab", "abcdef")); err != nil {
+		t.Fatalf("remove chunk: %v", err)
+	}
+	if res, err := svc.SyncRemote(ctx); err != nil || res.Deleted != 1 {
+		t.Fatalf("expected the pruned chunk deleted remotely, got %+v err=%v", res, err)
+	}
+	for _, key := range []string{"panel/other-install.db", "srv1/leftover.db", "srv1/panel/panel-20260102-030405.db"} {
+		if _, ok := bucket.objects[key]; !ok {
+			t.Fatalf("expected %s kept in the bucket, got %v", key, bucket.objects)
+		}
+	}
+	runs, _ := svc.Runs(ctx, RunFilter{Kind: KindRemoteSync})
+	if len(runs) != 3 || runs[0].Status != RunOK || runs[0].Target != "panel-backups" {
+		t.Fatalf("expected three recorded syncs, got %+v", runs)
+	}
+
+	dest := t.TempDir()
+	pulled, err := PullRemote(ctx, settings.Remote, dest)
+	if err != nil || pulled != 2 {
+		t.Fatalf("pull: %d err=%v", pulled, err)
+	}
+	target := filepath.Join(dest, "panel", "panel-20260102-030405.db")
+	info, err := os.Stat(target)
+	if err != nil || !info.ModTime().Equal(old) {
+		t.Fatalf("expected the modification time kept, got %+v err=%v", info, err)
+	}
+	if raw, _ := os.ReadFile(target); string(raw) != "panel copy" {
+		t.Fatalf("unexpected pulled content %q", raw)
+	}
+	if pulled, err := PullRemote(ctx, settings.Remote, dest); err != nil || pulled != 0 {
+		t.Fatalf("expected a second pull to skip existing files, got %d err=%v", pulled, err)
+	}
+}
@@ -0,0 +1,75 @@
+package backup
+
+import (
+	"bufio"
+	"io"
+)
+
+// Chunk size bounds. Boundaries fall where the rolling hash has
+// avgChunkBits low zero bits, so chunks average about 1 MiB.
+const (
+	minChunkSize = 256 << 10
+	avgChunkBits = 20
+	maxChunkSize = 4 << 20
+)
+
+// gear maps each byte to a pseudo-random 64-bit value for the rolling
+// hash. The table is derived from a fixed seed: changing it moves every
+// chunk boundary and defeats deduplication against existing snapshots.
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x61695061_6e656c21) // "aiPanel!"
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// chunker splits a stream into content-defined chunks with a gear hash,
+// so an insert early in a file only changes the chunks around it instead
+// of shifting every fixed-size block after it.
+type chunker struct {
+	r        *bufio.Reader
+	min, max int
+	mask     uint64
+	buf      []byte
+}
+
+func newChunker(r io.Reader, minSize, avgBits, maxSize int) *chunker {
+	return &chunker{
+		r:    bufio.NewReaderSize(r, 64<<10),
+		min:  minSize,
+		max:  maxSize,
+		mask: 1<<avgBits - 1,
+		buf:  make([]byte, 0, maxSize),
+	}
+}
+
+// next returns the next chunk, or io.EOF after the last one. The slice is
+// reused by the following call.
+func (c *chunker) next() ([]byte, error) {
+	c.buf = c.buf[:0]
+	var h uint64
+	for {
+		b, err := c.r.ReadByte()
+		if err == io.EOF {
+			if len(c.buf) == 0 {
+				return nil, io.EOF
+			}
+			return c.buf, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.buf = append(c.buf, b)
+		h = h<<1 + gear[b]
+		if len(c.buf) >= c.max || (len(c.buf) >= c.min && h&c.mask == 0) {
+			return c.buf, nil
+		}
+	}
+}
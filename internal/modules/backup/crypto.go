@@ -0,0 +1,555 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Key encodings. Both are X25519 keys in unpadded base64url.
+const (
+	RecipientPrefix = "aipanel-backup-pk:"
+	IdentityPrefix  = "AIPANEL-BACKUP-SK:"
+)
+
+// Envelope layout, modelled on age:
+//
+//	aipanel-backup/v1
+//	-> X25519 <ephemeral public key> <wrapped file key>   (one per recipient)
+//	--- <HMAC of the lines above>
+//	<16-byte nonce><payload segments>
+//
+// A random file key is wrapped for every recipient with ECDH + HKDF +
+// AES-GCM. The payload is AES-256-GCM in 64 KiB segments whose nonce is a
+// counter with a last-segment flag, so truncated or reordered archives
+// fail to open instead of restoring partially.
+const (
+	envelopeMagic = "aipanel-backup/v1\n"
+	segmentSize   = 64 << 10
+	fileKeySize   = 32
+	payloadNonce  = 16
+	maxStanzas    = 64
+)
+
+// ErrNoKey indicates an encrypted backup none of the available identities
+// opens: a restore is refused rather than attempted.
+var ErrNoKey = errors.New("backup is encrypted to a key this panel does not hold")
+
+// ErrPlaintext indicates an unencrypted backup read with a keyring that
+// encrypts and does not allow plaintext.
+var ErrPlaintext = errors.New("backup is not encrypted; enable allow_plaintext to restore archives made before encryption")
+
+var errCorrupt = errors.New("encrypted backup is truncated or corrupt")
+
+// Recipient is a public key backups are encrypted to.
+type Recipient struct {
+	key *ecdh.PublicKey
+}
+
+// Identity is a private key that opens backups encrypted to its recipient.
+type Identity struct {
+	key *ecdh.PrivateKey
+}
+
+// GenerateIdentity returns a new random identity.
+func GenerateIdentity() (*Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate backup key: %w", err)
+	}
+	return &Identity{key: key}, nil
+}
+
+// ParseIdentity decodes an identity in IdentityPrefix form.
+func ParseIdentity(s string) (*Identity, error) {
+	raw, ok := strings.CutPrefix(strings.TrimSpace(s), IdentityPrefix)
+	if !ok {
+		return nil, fmt.Errorf("invalid backup identity: expected %s prefix", IdentityPrefix)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup identity: %w", err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup identity: %w", err)
+	}
+	return &Identity{key: key}, nil
+}
+
+// String encodes the identity. It is a secret.
+func (i *Identity) String() string {
+	return IdentityPrefix + base64.RawURLEncoding.EncodeToString(i.key.Bytes())
+}
+
+// Recipient returns the public half of the identity.
+func (i *Identity) Recipient() *Recipient {
+	return &Recipient{key: i.key.PublicKey()}
+}
+
+// ParseRecipient decodes a public key in RecipientPrefix form.
+func ParseRecipient(s string) (*Recipient, error) {
+	raw, ok := strings.CutPrefix(strings.TrimSpace(s), RecipientPrefix)
+	if !ok {
+		return nil, fmt.Errorf("invalid backup public key: expected %s prefix", RecipientPrefix)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup public key: %w", err)
+	}
+	key, err := ecdh.X25519().NewPublicKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup public key: %w", err)
+	}
+	return &Recipient{key: key}, nil
+}
+
+// String encodes the public key.
+func (r *Recipient) String() string {
+	return RecipientPrefix + base64.RawURLEncoding.EncodeToString(r.key.Bytes())
+}
+
+// Keyring holds the keys backups are written to and read with. A nil
+// Keyring writes plaintext and only reads plaintext.
+type Keyring struct {
+	// Recipients can each open everything written with the keyring.
+	Recipients []*Recipient
+	// Identities are tried in order when opening a backup.
+	Identities []*Identity
+	// AllowPlaintext reads unencrypted data even though the keyring has
+	// recipients, for archives written before encryption was enabled.
+	AllowPlaintext bool
+}
+
+// With returns a copy of k that also tries ids when opening backups, such
+// as a client-held key supplied for one restore.
+func (k *Keyring) With(ids ...*Identity) *Keyring {
+	out := &Keyring{}
+	if k != nil {
+		out.Recipients = append(out.Recipients, k.Recipients...)
+		out.Identities = append(out.Identities, k.Identities...)
+		out.AllowPlaintext = k.AllowPlaintext
+	}
+	out.Identities = append(out.Identities, ids...)
+	return out
+}
+
+// Fingerprint identifies the recipient set: data written under one
+// fingerprint opens with exactly the same keys. It is empty for a keyring
+// that writes plaintext.
+func (k *Keyring) Fingerprint() string {
+	if k == nil || len(k.Recipients) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(k.Recipients))
+	for _, r := range k.Recipients {
+		keys = append(keys, r.String())
+	}
+	sort.Strings(keys)
+	sum := sha256.Sum256([]byte(strings.Join(keys, "\n")))
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+// Encrypt returns a writer that encrypts to w for every recipient. Close
+// must be called to write the final segment; it does not close w. With no
+// recipients the data passes through unchanged.
+func (k *Keyring) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	if k == nil || len(k.Recipients) == 0 {
+		return nopWriteCloser{w}, nil
+	}
+	fileKey := make([]byte, fileKeySize)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, fmt.Errorf("encrypt backup: %w", err)
+	}
+	var header bytes.Buffer
+	header.WriteString(envelopeMagic)
+	for _, r := range k.Recipients {
+		eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("encrypt backup: %w", err)
+		}
+		shared, err := eph.ECDH(r.key)
+		if err != nil {
+			return nil, fmt.Errorf("encrypt backup: %w", err)
+		}
+		aead, err := wrapAEAD(shared, eph.PublicKey().Bytes(), r.key.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		wrapped := aead.Seal(nil, make([]byte, aead.NonceSize()), fileKey, nil)
+		fmt.Fprintf(&header, "-> X25519 %s %s\n",
+			base64.RawStdEncoding.EncodeToString(eph.PublicKey().Bytes()),
+			base64.RawStdEncoding.EncodeToString(wrapped))
+	}
+	header.WriteString("---")
+	mac, err := headerMAC(fileKey, header.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(&header, " %s\n", base64.RawStdEncoding.EncodeToString(mac))
+
+	nonce := make([]byte, payloadNonce)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("encrypt backup: %w", err)
+	}
+	aead, err := payloadAEAD(fileKey, nonce)
+	if err != nil {
+		return nil, err
+	}
+	header.Write(nonce)
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, buf: make([]byte, 0, segmentSize)}, nil
+}
+
+// Decrypt returns the plaintext of r. Data written without encryption is
+// returned as is only by a keyring without recipients or with
+// AllowPlaintext set; otherwise it fails with ErrPlaintext, so a planted
+// unencrypted archive is not restored in place of an encrypted one.
+func (k *Keyring) Decrypt(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(envelopeMagic))
+	if err != nil || string(magic) != envelopeMagic {
+		if k != nil && len(k.Recipients) > 0 && !k.AllowPlaintext {
+			return nil, ErrPlaintext
+		}
+		return br, nil
+	}
+	if _, err := br.Discard(len(envelopeMagic)); err != nil {
+		return nil, err
+	}
+	header := bytes.NewBufferString(envelopeMagic)
+	type stanza struct{ eph, wrapped []byte }
+	var stanzas []stanza
+	var mac []byte
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, errCorrupt
+		}
+		if rest, ok := strings.CutPrefix(line, "--- "); ok {
+			header.WriteString("---")
+			if mac, err = base64.RawStdEncoding.DecodeString(strings.TrimSuffix(rest, "\n")); err != nil {
+				return nil, errCorrupt
+			}
+			break
+		}
+		header.WriteString(line)
+		fields := strings.Fields(line)
+		if len(fields) != 4 || fields[0] != "->" || len(stanzas) == maxStanzas {
+			return nil, errCorrupt
+		}
+		if fields[1] != "X25519" {
+			// Unknown key types are skipped, like age does.
+			continue
+		}
+		eph, err1 := base64.RawStdEncoding.DecodeString(fields[2])
+		wrapped, err2 := base64.RawStdEncoding.DecodeString(fields[3])
+		if err1 != nil || err2 != nil {
+			return nil, errCorrupt
+		}
+		stanzas = append(stanzas, stanza{eph: eph, wrapped: wrapped})
+	}
+
+	var fileKey []byte
+	if k != nil {
+	search:
+		for _, id := range k.Identities {
+			for _, s := range stanzas {
+				if key := id.unwrap(s.eph, s.wrapped); key != nil {
+					fileKey = key
+					break search
+				}
+			}
+		}
+	}
+	if fileKey == nil {
+		return nil, ErrNoKey
+	}
+	want, err := headerMAC(fileKey, header.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, want) {
+		return nil, errCorrupt
+	}
+	nonce := make([]byte, payloadNonce)
+	if _, err := io.ReadFull(br, nonce); err != nil {
+		return nil, errCorrupt
+	}
+	aead, err := payloadAEAD(fileKey, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: br, aead: aead, buf: make([]byte, segmentSize+aead.Overhead()+1)}, nil
+}
+
+// unwrap returns the file key of a stanza, or nil when the stanza is for
+// another recipient.
+func (i *Identity) unwrap(ephRaw, wrapped []byte) []byte {
+	eph, err := ecdh.X25519().NewPublicKey(ephRaw)
+	if err != nil {
+		return nil
+	}
+	shared, err := i.key.ECDH(eph)
+	if err != nil {
+		return nil
+	}
+	aead, err := wrapAEAD(shared, ephRaw, i.key.PublicKey().Bytes())
+	if err != nil {
+		return nil
+	}
+	key, err := aead.Open(nil, make([]byte, aead.NonceSize()), wrapped, nil)
+	if err != nil || len(key) != fileKeySize {
+		return nil
+	}
+	return key
+}
+
+func wrapAEAD(shared, eph, recipient []byte) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, eph...), recipient...)
+	key, err := hkdf.Key(sha256.New, shared, salt, "aipanel-backup/v1 X25519", 32)
+	if err != nil {
+		return nil, fmt.Errorf("derive wrap key: %w", err)
+	}
+	return newGCM(key)
+}
+
+func payloadAEAD(fileKey, nonce []byte) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, fileKey, nonce, "payload", 32)
+	if err != nil {
+		return nil, fmt.Errorf("derive payload key: %w", err)
+	}
+	return newGCM(key)
+}
+
+func headerMAC(fileKey, header []byte) ([]byte, error) {
+	key, err := hkdf.Key(sha256.New, fileKey, nil, "header", 32)
+	if err != nil {
+		return nil, fmt.Errorf("derive header key: %w", err)
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write(header)
+	return h.Sum(nil), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// segmentNonce is an 11-byte big-endian counter followed by a flag byte
+// set on the last segment.
+func segmentNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed backup encrypter")
+	}
+	n := 0
+	for len(p) > 0 {
+		// A full segment is only flushed once more data follows, so the
+		// last one can always be flagged on Close.
+		if len(e.buf) == segmentSize {
+			if err := e.flush(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(e.buf[len(e.buf):segmentSize], p)
+		e.buf = e.buf[:len(e.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.flush(true)
+}
+
+func (e *encryptWriter) flush(last bool) error {
+	out := e.aead.Seal(nil, segmentNonce(e.counter, last), e.buf, nil)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(out)
+	return err
+}
+
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	buf     []byte
+	plain   []byte
+	counter uint64
+	done    bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) next() error {
+	full := segmentSize + d.aead.Overhead()
+	n, err := io.ReadFull(d.r, d.buf[:full])
+	last := false
+	switch {
+	case err == io.ErrUnexpectedEOF || err == io.EOF:
+		last = true
+	case err != nil:
+		return err
+	default:
+		if _, peekErr := d.r.Peek(1); peekErr == io.EOF {
+			last = true
+		}
+	}
+	plain, openErr := d.aead.Open(d.buf[:0], segmentNonce(d.counter, last), d.buf[:n], nil)
+	if openErr != nil {
+		return errCorrupt
+	}
+	d.counter++
+	d.plain = plain
+	d.done = last
+	return nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// EncryptFile encrypts a backup archive in place with keys. Archives that
+// are already encrypted, and every archive when keys has no recipients,
+// are left alone.
+func EncryptFile(path string, keys *Keyring) error {
+	if keys == nil || len(keys.Recipients) == 0 {
+		return nil
+	}
+	//nolint:gosec // G304: backup paths come from the panel backup dir.
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("encrypt backup: %w", err)
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+	head := make([]byte, len(envelopeMagic))
+	if n, _ := io.ReadFull(src, head); string(head[:n]) == envelopeMagic {
+		return nil
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("encrypt backup: %w", err)
+	}
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("encrypt backup: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".encrypt-*")
+	if err != nil {
+		return fmt.Errorf("encrypt backup: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	enc, err := keys.Encrypt(tmp)
+	if err == nil {
+		_, err = io.Copy(enc, src)
+	}
+	if err == nil {
+		err = enc.Close()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), info.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("encrypt backup: %w", err)
+	}
+	// Archives are dated by mtime; keep the time the dump finished.
+	_ = os.Chtimes(path, info.ModTime(), info.ModTime())
+	return nil
+}
+
+// OpenFile opens a backup archive for reading, decrypting it when needed.
+func OpenFile(path string, keys *Keyring) (io.ReadCloser, error) {
+	//nolint:gosec // G304: backup paths come from the panel backup dir.
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := keys.Decrypt(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("open %s: %w", filepath.Base(path), err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, f}, nil
+}
+
+// IsEncryptedFile reports whether a backup archive is encrypted.
+func IsEncryptedFile(path string) (bool, error) {
+	//nolint:gosec // G304: backup paths come from the panel backup dir.
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	head := make([]byte, len(envelopeMagic))
+	n, _ := io.ReadFull(f, head)
+	return string(head[:n]) == envelopeMagic, nil
+}
@@ -0,0 +1,324 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Handler exposes HTTP handlers for site file backups.
+type Handler struct {
+	svc *Service
+}
+
+// NewHandler creates backup HTTP handler.
+func NewHandler(svc *Service) *Handler {
+	return &Handler{svc: svc}
+}
+
+// HandleSiteBackups serves the file backups of a site; sub is the path
+// below /api/sites/{id}/:
+//
+//	GET  backups                        snapshots, newest first
+//	POST backups                        queue a snapshot
+//	GET  backups/{snapshot}/files       entries of ?path= (top level when empty)
+//	GET  backups/{snapshot}/download    one file at ?path=
+//	POST backups/{snapshot}/restore     {"paths": ["wp-config.php"], "identity": ""}
+//
+// identity is an optional client-held backup key for snapshots the panel
+// cannot open itself; it is used for the one request and never stored.
+func (h *Handler) HandleSiteBackups(w http.ResponseWriter, r *http.Request, siteID int64, sub, actor string) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(sub, "backups"), "/"), "/")
+	switch {
+	case parts[0] == "" && r.Method == http.MethodGet:
+		snaps, err := h.svc.Snapshots(r.Context(), siteID)
+		if err != nil {
+			writeBackupError(w, "failed to list backups", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"snapshots": snaps})
+	case parts[0] == "" && r.Method == http.MethodPost:
+		jobID, err := h.svc.BackupSite(r.Context(), siteID, actor)
+		if err != nil {
+			writeBackupError(w, "failed to queue backup", err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]any{"job_id": jobID})
+	case parts[0] == "":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	case len(parts) != 2:
+		http.NotFound(w, r)
+	case parts[1] == "files" && r.Method == http.MethodGet:
+		entries, err := h.svc.Browse(r.Context(), siteID, parts[0], r.URL.Query().Get("path"))
+		if err != nil {
+			writeBackupError(w, "failed to browse backup", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"entries": entries})
+	case parts[1] == "download" && r.Method == http.MethodGet:
+		name := r.URL.Query().Get("path")
+		if name == "" {
+			http.Error(w, "path is required", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(path.Base(cleanEntryPath(name))))
+		if err := h.svc.Download(r.Context(), siteID, parts[0], name, w); err != nil {
+			// Nothing has been written when the snapshot or path is wrong;
+			// later errors can only cut the download short.
+			w.Header().Del("Content-Disposition")
+			writeBackupError(w, "failed to download file", err)
+		}
+	case parts[1] == "restore" && r.Method == http.MethodPost:
+		var req struct {
+			Paths    []string `json:"paths"`
+			Identity string   `json:"identity"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		result, err := h.svc.RestoreFiles(r.Context(), siteID, parts[0], req.Paths, req.Identity, actor)
+		if err != nil {
+			writeBackupError(w, "failed to restore files", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	case parts[1] == "files" || parts[1] == "download" || parts[1] == "restore":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// HandleBackups serves the panel-wide backup API (admin only):
+//
+//	GET  /api/backups                    settings and the latest runs
+//	GET  /api/backups/runs               run history (?kind=&site_id=&limit=)
+//	POST /api/backups/runs               {"kind": "sites"|"panel"|"remote_sync"} run now
+//	GET  /api/backups/runs/{id}          one run
+//	POST /api/backups/runs/{id}/restore  {"paths": [], "identity": ""} restore a site files run
+//	GET  /api/backups/settings           retention and remote storage
+//	PUT  /api/backups/settings           update them
+//
+// /api/backups/keys is served by HandleKeys.
+func (h *Handler) HandleBackups(w http.ResponseWriter, r *http.Request, actor string) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/backups"), "/")
+	parts := strings.Split(rest, "/")
+	switch {
+	case parts[0] == "keys":
+		h.HandleKeys(w, r, actor)
+	case rest == "" && r.Method == http.MethodGet:
+		settings, err := h.svc.Settings(r.Context())
+		if err != nil {
+			writeBackupError(w, "failed to read backup settings", err)
+			return
+		}
+		runs, err := h.svc.Runs(r.Context(), RunFilter{Limit: 20})
+		if err != nil {
+			writeBackupError(w, "failed to list backup runs", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"settings": settings, "runs": runs})
+	case rest == "settings" && r.Method == http.MethodGet:
+		settings, err := h.svc.Settings(r.Context())
+		if err != nil {
+			writeBackupError(w, "failed to read backup settings", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, settings)
+	case rest == "settings" && r.Method == http.MethodPut:
+		var req SettingsRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		settings, err := h.svc.UpdateSettings(r.Context(), req, actor)
+		if err != nil {
+			writeBackupError(w, "failed to update backup settings", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, settings)
+	case rest == "runs" && r.Method == http.MethodGet:
+		q := r.URL.Query()
+		filter := RunFilter{Kind: q.Get("kind")}
+		filter.SiteID, _ = strconv.ParseInt(q.Get("site_id"), 10, 64)
+		filter.Limit, _ = strconv.Atoi(q.Get("limit"))
+		runs, err := h.svc.Runs(r.Context(), filter)
+		if err != nil {
+			writeBackupError(w, "failed to list backup runs", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"runs": runs})
+	case rest == "runs" && r.Method == http.MethodPost:
+		var req struct {
+			Kind string `json:"kind"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		h.startRun(w, r, req.Kind, actor)
+	case rest == "" || rest == "settings" || rest == "runs":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	case parts[0] == "runs" && len(parts) <= 3:
+		id, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || id <= 0 || (len(parts) == 3 && parts[2] != "restore") {
+			http.NotFound(w, r)
+			return
+		}
+		if len(parts) == 2 {
+			if r.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			run, err := h.svc.Run(r.Context(), id)
+			if err != nil {
+				writeBackupError(w, "failed to get backup run", err)
+				return
+			}
+			writeJSON(w, http.StatusOK, run)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Paths    []string `json:"paths"`
+			Identity string   `json:"identity"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		result, err := h.svc.RestoreRun(r.Context(), id, req.Paths, req.Identity, actor)
+		if err != nil {
+			writeBackupError(w, "failed to restore backup", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) startRun(w http.ResponseWriter, r *http.Request, kind, actor string) {
+	switch kind {
+	case "sites":
+		queued, err := h.svc.BackupAllSites(r.Context(), actor)
+		if err != nil {
+			writeBackupError(w, "failed to queue site backups", err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]any{"queued": queued})
+	case KindPanel:
+		dest, err := h.svc.BackupPanel(r.Context(), TriggerOnDemand)
+		if err != nil {
+			writeBackupError(w, "failed to back up panel database", err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]any{"file": path.Base(dest)})
+	case KindRemoteSync:
+		jobID, err := h.svc.QueueRemoteSync(r.Context(), actor)
+		if err != nil {
+			writeBackupError(w, "failed to queue remote sync", err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]any{"job_id": jobID})
+	default:
+		http.Error(w, "invalid kind: expected sites, panel or remote_sync", http.StatusBadRequest)
+	}
+}
+
+// HandleKeys serves backup encryption keys (admin only):
+//
+//	GET    /api/backups/keys          install public key and recipients
+//	POST   /api/backups/keys          {"name": "client escrow", "public_key": "aipanel-backup-pk:..."}
+//	DELETE /api/backups/keys/{id}     stop encrypting new backups to a recipient
+//	POST   /api/backups/keys/export   the install private key, for disaster recovery
+func (h *Handler) HandleKeys(w http.ResponseWriter, r *http.Request, actor string) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/backups/keys"), "/")
+	switch {
+	case rest == "" && r.Method == http.MethodGet:
+		install, err := h.svc.InstallRecipient()
+		if err != nil {
+			writeBackupError(w, "failed to read backup key", err)
+			return
+		}
+		recipients, err := h.svc.Recipients(r.Context())
+		if err != nil {
+			writeBackupError(w, "failed to list backup recipients", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"install_public_key": install, "recipients": recipients})
+	case rest == "" && r.Method == http.MethodPost:
+		var req struct {
+			Name      string `json:"name"`
+			PublicKey string `json:"public_key"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		rec, err := h.svc.AddRecipient(r.Context(), req.Name, req.PublicKey, actor)
+		if err != nil {
+			writeBackupError(w, "failed to add backup recipient", err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, rec)
+	case rest == "export" && r.Method == http.MethodPost:
+		key, err := h.svc.ExportInstallKey(r.Context(), actor)
+		if err != nil {
+			writeBackupError(w, "failed to export backup key", err)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, map[string]any{"identity": key})
+	case rest == "" || rest == "export":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		id, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil || id <= 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := h.svc.DeleteRecipient(r.Context(), id, actor); err != nil {
+			writeBackupError(w, "failed to delete backup recipient", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeBackupError(w http.ResponseWriter, prefix string, err error) {
+	switch {
+	case errors.Is(err, ErrSiteNotFound):
+		http.Error(w, "site not found", http.StatusNotFound)
+	case errors.Is(err, ErrSnapshotNotFound), errors.Is(err, ErrEntryNotFound), errors.Is(err, ErrRecipientNotFound),
+		errors.Is(err, ErrRunNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrNoKey), errors.Is(err, ErrPlaintext):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrRemoteNotConfigured):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case strings.Contains(err.Error(), "invalid"):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, prefix+": "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,219 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+// ErrRecipientNotFound indicates a missing backup recipient row.
+var ErrRecipientNotFound = errors.New("backup recipient not found")
+
+// BackupRecipient is a user-supplied public key every new backup is also
+// encrypted to, so a client holding the private key can open the backups
+// without this panel (client escrow).
+type BackupRecipient struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	PublicKey string    `json:"public_key"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// KeyFile returns the install key of a panel data dir. It lives outside
+// the backup dir so copying backups off the server never carries the key
+// that opens them.
+func KeyFile(dataDir string) string {
+	return filepath.Join(dataDir, "backup.key")
+}
+
+// LoadInstallIdentity reads the install key at path, generating it on
+// first use.
+func LoadInstallIdentity(path string) (*Identity, error) {
+	//nolint:gosec // G304: the key path is derived from the panel data dir.
+	raw, err := os.ReadFile(path)
+	if err == nil {
+		id, err := ParseIdentity(string(raw))
+		if err != nil {
+			return nil, fmt.Errorf("read backup key %s: %w", path, err)
+		}
+		return id, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read backup key: %w", err)
+	}
+	id, err := GenerateIdentity()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create backup key dir: %w", err)
+	}
+	// O_EXCL: a concurrent first use must not replace a key that may
+	// already have encrypted something.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return LoadInstallIdentity(path)
+		}
+		return nil, fmt.Errorf("write backup key: %w", err)
+	}
+	_, err = f.WriteString(id.String() + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, fmt.Errorf("write backup key: %w", err)
+	}
+	return id, nil
+}
+
+// LoadKeyring returns the keyring new backups are written with: the
+// install key plus every backup recipient. Only the install key is held
+// for reading. Unencrypted archives are read only when the allow_plaintext
+// backup setting is on.
+func LoadKeyring(ctx context.Context, store *sqlite.Store) (*Keyring, error) {
+	id, err := LoadInstallIdentity(KeyFile(store.DataDir))
+	if err != nil {
+		return nil, err
+	}
+	keys := &Keyring{Recipients: []*Recipient{id.Recipient()}, Identities: []*Identity{id}}
+	rows, err := store.QueryPanelJSON(ctx, "SELECT allow_plaintext FROM backup_settings WHERE id = 1;")
+	if err != nil {
+		return nil, fmt.Errorf("get backup settings: %w", err)
+	}
+	if len(rows) > 0 {
+		n, _ := toInt64(rows[0]["allow_plaintext"])
+		keys.AllowPlaintext = n == 1
+	}
+	recipients, err := listRecipients(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range recipients {
+		r, err := ParseRecipient(rec.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("backup recipient %q: %w", rec.Name, err)
+		}
+		keys.Recipients = append(keys.Recipients, r)
+	}
+	return keys, nil
+}
+
+// InstallRecipient returns the public half of the install key.
+func (s *Service) InstallRecipient() (string, error) {
+	id, err := LoadInstallIdentity(KeyFile(s.store.DataDir))
+	if err != nil {
+		return "", err
+	}
+	return id.Recipient().String(), nil
+}
+
+// ExportInstallKey returns the install key, needed to open this panel's
+// backups on a rebuilt server. Every export is audited.
+func (s *Service) ExportInstallKey(ctx context.Context, actor string) (string, error) {
+	id, err := LoadInstallIdentity(KeyFile(s.store.DataDir))
+	if err != nil {
+		return "", err
+	}
+	_ = s.writeAudit(ctx, actor, "backup.key.export", 0, "recipient="+id.Recipient().String())
+	return id.String(), nil
+}
+
+// Recipients lists the backup recipients.
+func (s *Service) Recipients(ctx context.Context) ([]BackupRecipient, error) {
+	return listRecipients(ctx, s.store)
+}
+
+// AddRecipient registers a public key new backups are also encrypted to.
+// Snapshots taken before stay readable only with the keys they were
+// written for.
+func (s *Service) AddRecipient(ctx context.Context, name, publicKey, actor string) (BackupRecipient, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 128 {
+		return BackupRecipient{}, fmt.Errorf("invalid backup recipient: name must be 1-128 characters")
+	}
+	r, err := ParseRecipient(publicKey)
+	if err != nil {
+		return BackupRecipient{}, err
+	}
+	key := r.String()
+	existing, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT id FROM backup_recipients WHERE public_key = '%s' LIMIT 1;", sqlEscape(key)))
+	if err != nil {
+		return BackupRecipient{}, fmt.Errorf("check backup recipient: %w", err)
+	}
+	if len(existing) > 0 {
+		return BackupRecipient{}, fmt.Errorf("invalid backup recipient: public key is already registered")
+	}
+	if strings.TrimSpace(actor) == "" {
+		actor = "system"
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(
+		"INSERT INTO backup_recipients(name, public_key, created_by, created_at) VALUES('%s','%s','%s',%d);",
+		sqlEscape(name), sqlEscape(key), sqlEscape(actor), time.Now().Unix())); err != nil {
+		return BackupRecipient{}, fmt.Errorf("add backup recipient: %w", err)
+	}
+	_ = s.writeAudit(ctx, actor, "backup.recipient.add", 0, fmt.Sprintf("name=%s key=%s", name, key))
+	recipients, err := s.Recipients(ctx)
+	if err != nil {
+		return BackupRecipient{}, err
+	}
+	for _, rec := range recipients {
+		if rec.PublicKey == key {
+			return rec, nil
+		}
+	}
+	return BackupRecipient{}, ErrRecipientNotFound
+}
+
+// DeleteRecipient stops encrypting new backups to a recipient. Backups
+// already written for it still open with its key.
+func (s *Service) DeleteRecipient(ctx context.Context, id int64, actor string) error {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT name FROM backup_recipients WHERE id = %d;", id))
+	if err != nil {
+		return fmt.Errorf("get backup recipient: %w", err)
+	}
+	if len(rows) == 0 {
+		return ErrRecipientNotFound
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf("DELETE FROM backup_recipients WHERE id = %d;", id)); err != nil {
+		return fmt.Errorf("delete backup recipient: %w", err)
+	}
+	name, _ := rows[0]["name"].(string)
+	_ = s.writeAudit(ctx, actor, "backup.recipient.delete", 0, "name="+name)
+	return nil
+}
+
+func listRecipients(ctx context.Context, store *sqlite.Store) ([]BackupRecipient, error) {
+	rows, err := store.QueryPanelJSON(ctx,
+		"SELECT id, name, public_key, created_by, created_at FROM backup_recipients ORDER BY id;")
+	if err != nil {
+		return nil, fmt.Errorf("list backup recipients: %w", err)
+	}
+	out := make([]BackupRecipient, 0, len(rows))
+	for _, row := range rows {
+		id, err := toInt64(row["id"])
+		if err != nil {
+			return nil, fmt.Errorf("parse backup recipient id: %w", err)
+		}
+		created, _ := toInt64(row["created_at"])
+		name, _ := row["name"].(string)
+		key, _ := row["public_key"].(string)
+		by, _ := row["created_by"].(string)
+		out = append(out, BackupRecipient{
+			ID: id, Name: name, PublicKey: key, CreatedBy: by,
+			CreatedAt: time.Unix(created, 0).UTC(),
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,136 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultPanelRetention is the number of panel.db copies kept unless the
+// backup settings say otherwise.
+const defaultPanelRetention = 14
+
+// ErrNoPanelBackup indicates a backup dir without a panel.db copy.
+var ErrNoPanelBackup = errors.New("no panel.db backup found")
+
+// PanelDir returns the directory holding panel.db copies of a panel data
+// dir. Together with the file repository and database dumps it is what a
+// disaster recovery restore needs from the backup dir.
+func PanelDir(dataDir string) string {
+	return filepath.Join(Dir(dataDir), "panel")
+}
+
+// BackupPanel writes an encrypted copy of panel.db with a checksum sidecar,
+// records the run and prunes copies beyond retention. It returns the path
+// written.
+func (s *Service) BackupPanel(ctx context.Context, trigger string) (string, error) {
+	started := time.Now()
+	dest, err := s.backupPanel(ctx)
+	run := BackupRun{Kind: KindPanel, Target: "panel.db", Trigger: trigger, StartedAt: started}
+	if err == nil {
+		run.Location = filepath.Base(dest)
+		if info, statErr := os.Stat(dest); statErr == nil {
+			run.Bytes = info.Size()
+		}
+	}
+	_ = RecordRun(ctx, s.store, run, err)
+	if err != nil {
+		return "", err
+	}
+	s.log.Info("panel database backed up", "path", dest)
+	return dest, nil
+}
+
+func (s *Service) backupPanel(ctx context.Context) (string, error) {
+	settings, err := s.Settings(ctx)
+	if err != nil {
+		return "", err
+	}
+	dir := PanelDir(s.store.DataDir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("create panel backup dir: %w", err)
+	}
+	dest := filepath.Join(dir, "panel-"+time.Now().UTC().Format("20060102-150405")+".db")
+	if err := s.store.BackupPanel(ctx, dest); err != nil {
+		_ = os.Remove(dest)
+		return "", err
+	}
+	keys, err := LoadKeyring(ctx, s.store)
+	if err == nil {
+		err = EncryptFile(dest, keys)
+	}
+	if err == nil {
+		err = WriteChecksum(dest)
+	}
+	if err != nil {
+		_ = os.Remove(dest)
+		return "", err
+	}
+	backups, err := PanelBackups(dir)
+	if err != nil {
+		return dest, err
+	}
+	for len(backups) > settings.PanelRetention {
+		_ = os.Remove(backups[0])
+		_ = os.Remove(backups[0] + ChecksumSuffix)
+		backups = backups[1:]
+	}
+	return dest, nil
+}
+
+// PanelBackups lists the panel.db copies in dir, oldest first. Names
+// carry the UTC time they were taken, so they sort chronologically.
+func PanelBackups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("list panel backups: %w", err)
+	}
+	var out []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && strings.HasPrefix(name, "panel-") && strings.HasSuffix(name, ".db") {
+			out = append(out, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// RestoreLatest restores the newest snapshot of a site in full, for
+// rebuilding a docroot that is gone rather than repairing one: no
+// pre-restore snapshot is taken. identity works as in RestoreFiles.
+func (s *Service) RestoreLatest(ctx context.Context, siteID int64, identity, actor string) (Snapshot, RestoreResult, error) {
+	site, err := s.site(ctx, siteID)
+	if err != nil {
+		return Snapshot{}, RestoreResult{}, err
+	}
+	repo, err := s.open(ctx, identity)
+	if err != nil {
+		return Snapshot{}, RestoreResult{}, err
+	}
+	snaps, err := repo.Snapshots(siteTag(siteID))
+	if err != nil {
+		return Snapshot{}, RestoreResult{}, err
+	}
+	if len(snaps) == 0 {
+		return Snapshot{}, RestoreResult{}, ErrSnapshotNotFound
+	}
+	snap := snaps[0]
+	result, err := repo.Restore(ctx, snap.ID, []string{""}, site.RootDir)
+	details := fmt.Sprintf("domain=%s snapshot=%s paths=* files=%d", site.Domain, snap.ID, result.Files)
+	if err != nil {
+		_ = s.writeAudit(ctx, actor, "backup.site.restore_failed", siteID, details)
+		return snap, result, err
+	}
+	_ = s.writeAudit(ctx, actor, "backup.site.restore", siteID, details)
+	s.restored(ctx, siteID, actor)
+	return snap, result, nil
+}
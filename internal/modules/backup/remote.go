@@ -0,0 +1,286 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+)
+
+// RemoteSyncJob is the job type that mirrors the backup dir to remote
+// storage.
+const RemoteSyncJob = "backup.remote.sync"
+
+// SyncResult summarizes a remote sync.
+type SyncResult struct {
+	Uploaded int   `json:"uploaded"`
+	Deleted  int   `json:"deleted"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// QueueRemoteSync queues a mirror of the backup dir to remote storage.
+func (s *Service) QueueRemoteSync(ctx context.Context, actor string) (int64, error) {
+	if s.jobs == nil {
+		return 0, fmt.Errorf("job queue is not configured")
+	}
+	settings, err := s.Settings(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if !settings.Remote.Configured() {
+		return 0, ErrRemoteNotConfigured
+	}
+	id, err := s.jobs.Enqueue(ctx, RemoteSyncJob, struct{}{})
+	if err != nil {
+		return 0, err
+	}
+	_ = s.writeAudit(ctx, actor, "backup.remote.queue", 0, fmt.Sprintf("bucket=%s job_id=%d", settings.Remote.Bucket, id))
+	return id, nil
+}
+
+// SyncRemote mirrors the backup dir to remote storage: new and changed
+// files are uploaded, and objects this panel uploaded whose local file
+// retention has since removed are deleted. Objects it did not upload are
+// never touched, so a bucket shared with another install, or a fresh
+// install pointed at an old bucket, loses nothing.
+func (s *Service) SyncRemote(ctx context.Context) (SyncResult, error) {
+	settings, err := s.Settings(ctx)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	if !settings.Remote.Configured() {
+		return SyncResult{}, ErrRemoteNotConfigured
+	}
+	started := time.Now()
+	result, err := s.syncRemote(ctx, newS3Client(settings.Remote))
+	_ = RecordRun(ctx, s.store, BackupRun{
+		Kind:      KindRemoteSync,
+		Target:    settings.Remote.Bucket,
+		Location:  remoteLocation(settings.Remote),
+		Bytes:     result.Bytes,
+		StartedAt: started,
+	}, err)
+	if err != nil {
+		return result, err
+	}
+	s.log.Info("backups synced to remote storage", "bucket", settings.Remote.Bucket,
+		"uploaded", result.Uploaded, "deleted", result.Deleted, "bytes", result.Bytes)
+	return result, nil
+}
+
+func (s *Service) syncRemote(ctx context.Context, client *s3Client) (SyncResult, error) {
+	var result SyncResult
+	local, err := localBackupFiles(Dir(s.store.DataDir))
+	if err != nil {
+		return result, err
+	}
+	objects, err := client.List(ctx)
+	if err != nil {
+		return result, err
+	}
+	remote := make(map[string]int64, len(objects))
+	for _, obj := range objects {
+		remote[obj.Key] = obj.Size
+	}
+	names := make([]string, 0, len(local))
+	for name := range local {
+		names = append(names, name)
+	}
+	// Snapshot manifests and panel.db copies refer to the chunks and dumps
+	// around them, so they go last: an interrupted sync never leaves one
+	// in the bucket without what it needs.
+	sort.Slice(names, func(i, j int) bool {
+		if li, lj := uploadsLast(names[i]), uploadsLast(names[j]); li != lj {
+			return lj
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		info := local[name]
+		if size, ok := remote[name]; ok && size == info.Size() {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if err := s.upload(ctx, client, name, info); err != nil {
+			return result, err
+		}
+		result.Uploaded++
+		result.Bytes += info.Size()
+	}
+
+	rows, err := s.store.QueryPanelJSON(ctx, "SELECT object_key FROM backup_remote_objects ORDER BY object_key;")
+	if err != nil {
+		return result, fmt.Errorf("list uploaded backups: %w", err)
+	}
+	for _, row := range rows {
+		name, _ := row["object_key"].(string)
+		if _, ok := local[name]; ok || name == "" {
+			continue
+		}
+		if _, ok := remote[name]; ok {
+			if err := client.Delete(ctx, name); err != nil {
+				return result, err
+			}
+			result.Deleted++
+		}
+		if err := s.store.ExecPanel(ctx, fmt.Sprintf(
+			"DELETE FROM backup_remote_objects WHERE object_key = '%s';", sqlEscape(name))); err != nil {
+			return result, fmt.Errorf("forget uploaded backup: %w", err)
+		}
+	}
+	return result, nil
+}
+
+func (s *Service) upload(ctx context.Context, client *s3Client, name string, info fs.FileInfo) error {
+	//nolint:gosec // G304: name comes from walking the panel backup dir.
+	f, err := os.Open(filepath.Join(Dir(s.store.DataDir), filepath.FromSlash(name)))
+	if err != nil {
+		return fmt.Errorf("upload %s: %w", name, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if err := client.Put(ctx, name, f, info.Size(), info.ModTime()); err != nil {
+		return err
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO backup_remote_objects(object_key, size, uploaded_at) VALUES('%s', %d, %d)
+ON CONFLICT(object_key) DO UPDATE SET size = excluded.size, uploaded_at = excluded.uploaded_at;`,
+		sqlEscape(name), info.Size(), time.Now().Unix())); err != nil {
+		return fmt.Errorf("record uploaded backup: %w", err)
+	}
+	return nil
+}
+
+// PullRemote downloads every object of remote into dest, keeping the
+// modification times recorded on upload. Files already in dest with the
+// same size are skipped, so an interrupted pull resumes. It returns the
+// number of files downloaded.
+func PullRemote(ctx context.Context, remote RemoteStorage, dest string) (int, error) {
+	if !remote.Configured() {
+		return 0, ErrRemoteNotConfigured
+	}
+	if remote.Region == "" {
+		remote.Region = defaultRemoteRegion
+	}
+	client := newS3Client(remote)
+	objects, err := client.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	pulled := 0
+	for _, obj := range objects {
+		name := filepath.FromSlash(obj.Key)
+		if obj.Key == "" || strings.HasSuffix(obj.Key, "/") {
+			continue
+		}
+		if !filepath.IsLocal(name) {
+			return pulled, fmt.Errorf("invalid object key %q", obj.Key)
+		}
+		target := filepath.Join(dest, name)
+		if info, err := os.Stat(target); err == nil && info.Size() == obj.Size {
+			continue
+		}
+		if err := pullObject(ctx, client, obj.Key, target); err != nil {
+			return pulled, err
+		}
+		pulled++
+	}
+	return pulled, nil
+}
+
+func pullObject(ctx context.Context, client *s3Client, key, target string) error {
+	body, modTime, err := client.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = body.Close()
+	}()
+	if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+		return fmt.Errorf("download %s: %w", key, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".pull-*")
+	if err != nil {
+		return fmt.Errorf("download %s: %w", key, err)
+	}
+	_, err = tmp.ReadFrom(body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && !modTime.IsZero() {
+		err = os.Chtimes(tmp.Name(), modTime, modTime)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), target)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("download %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *Service) runRemoteSyncJob(ctx context.Context, _ jobqueue.Job) error {
+	_, err := s.SyncRemote(ctx)
+	if errors.Is(err, ErrRemoteNotConfigured) {
+		// Removed after the job was queued; nothing to do.
+		return nil
+	}
+	return err
+}
+
+// localBackupFiles lists the regular files of the backup dir by slash
+// path. Dot-named entries are in-progress writes and scratch dirs.
+func localBackupFiles(dir string) (map[string]fs.FileInfo, error) {
+	files := map[string]fs.FileInfo{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && p == dir {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if p != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = info
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list backups: %w", err)
+	}
+	return files, nil
+}
+
+func uploadsLast(name string) bool {
+	return strings.HasPrefix(name, "files/snapshots/") || strings.HasPrefix(name, "panel/")
+}
+
+func remoteLocation(remote RemoteStorage) string {
+	return strings.TrimSuffix(remote.Endpoint+"/"+path.Join(remote.Bucket, remote.Prefix), "/")
+}
@@ -0,0 +1,882 @@
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Snapshot triggers.
+const (
+	TriggerScheduled  = "scheduled"
+	TriggerOnDemand   = "on-demand"
+	TriggerPreRestore = "pre-restore"
+)
+
+// Entry types.
+const (
+	EntryDir     = "dir"
+	EntryFile    = "file"
+	EntrySymlink = "symlink"
+)
+
+var (
+	// ErrSnapshotNotFound indicates an unknown snapshot id.
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	// ErrEntryNotFound indicates a path missing from a snapshot.
+	ErrEntryNotFound = errors.New("path not found in snapshot")
+)
+
+// DefaultExcludes are skipped by file backups unless BackupOptions.Exclude
+// says otherwise: caches, temp files, logs and node_modules.
+var DefaultExcludes = []string{"cache", "tmp", "logs", "node_modules", "*.log"}
+
+var snapshotIDPattern = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}Z-[0-9a-f]{8}$`)
+
+// Snapshot is one backup of a directory tree. Entries is omitted from
+// listings.
+type Snapshot struct {
+	ID        string    `json:"id"`
+	Tag       string    `json:"tag"`
+	Trigger   string    `json:"trigger"`
+	Source    string    `json:"source"`
+	Parent    string    `json:"parent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Files     int       `json:"files"`
+	Size      int64     `json:"size"`
+	// Added is the number of bytes of new chunks this snapshot stored;
+	// everything else was already in the repository.
+	Added int64 `json:"added"`
+	// KeySet is the fingerprint of the keys the chunks are encrypted to;
+	// empty for plaintext snapshots.
+	KeySet  string  `json:"key_set,omitempty"`
+	Entries []Entry `json:"entries,omitempty"`
+}
+
+// Entry is one directory, regular file or symlink of a snapshot. Path is
+// slash-separated and relative to the snapshot source.
+type Entry struct {
+	Path    string      `json:"path"`
+	Type    string      `json:"type"`
+	Mode    fs.FileMode `json:"mode"`
+	UID     int         `json:"uid"`
+	GID     int         `json:"gid"`
+	Size    int64       `json:"size,omitempty"`
+	ModTime time.Time   `json:"mod_time"`
+	Target  string      `json:"target,omitempty"`
+	Chunks  []string    `json:"chunks,omitempty"`
+}
+
+// BackupOptions describe one snapshot. Tag groups the snapshots of one
+// source (e.g. "site:12"); the newest snapshot with the same tag is the
+// parent whose unchanged files are not read again. Exclude holds globs
+// matched against base names; nil uses DefaultExcludes.
+type BackupOptions struct {
+	Tag     string
+	Trigger string
+	Exclude []string
+}
+
+// RestoreResult counts what a restore wrote.
+type RestoreResult struct {
+	Files    int   `json:"files"`
+	Dirs     int   `json:"dirs"`
+	Symlinks int   `json:"symlinks"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// PruneResult counts the chunks a prune removed.
+type PruneResult struct {
+	Chunks int   `json:"chunks"`
+	Bytes  int64 `json:"bytes"`
+}
+
+// Repository is a deduplicating file backup store. Files are split into
+// content-defined chunks kept once under chunks/ by SHA-256, gzipped, and
+// each snapshot is a JSON manifest under snapshots/ listing the chunks of
+// every file. A nightly backup of a large docroot therefore only stores
+// what changed.
+//
+// With a keyring, chunks and manifests are encrypted to its recipients.
+// Chunks are grouped by key set, so adding a recipient starts a fresh
+// chain whose every chunk the new key opens; chunk ids stay the SHA-256
+// of the plaintext.
+type Repository struct {
+	dir  string
+	now  func() time.Time
+	keys *Keyring
+
+	minChunk, avgBits, maxChunk int
+
+	// mu keeps Prune from removing chunks a running backup has written but
+	// not yet referenced from a manifest. It is shared by withKeys copies.
+	mu *sync.RWMutex
+}
+
+// NewRepository returns the repository in dir. Directories are created on
+// the first write.
+func NewRepository(dir string) *Repository {
+	return &Repository{
+		dir:      dir,
+		now:      time.Now,
+		minChunk: minChunkSize,
+		avgBits:  avgChunkBits,
+		maxChunk: maxChunkSize,
+		mu:       &sync.RWMutex{},
+	}
+}
+
+// withKeys returns a view of the repository that writes and reads with
+// keys.
+func (r *Repository) withKeys(keys *Keyring) *Repository {
+	view := *r
+	view.keys = keys
+	return &view
+}
+
+// Backup snapshots the tree under source. Files whose size and
+// modification time match the parent snapshot reuse its chunks without
+// being read. Special files (sockets, devices) are skipped.
+func (r *Repository) Backup(ctx context.Context, source string, opts BackupOptions) (Snapshot, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	source = filepath.Clean(source)
+	if info, err := os.Stat(source); err != nil {
+		return Snapshot{}, fmt.Errorf("backup source: %w", err)
+	} else if !info.IsDir() {
+		return Snapshot{}, fmt.Errorf("backup source %s is not a directory", source)
+	}
+	exclude := opts.Exclude
+	if exclude == nil {
+		exclude = DefaultExcludes
+	}
+	created := r.now().UTC()
+	snap := Snapshot{
+		ID:        newSnapshotID(created),
+		Tag:       opts.Tag,
+		Trigger:   opts.Trigger,
+		Source:    source,
+		CreatedAt: created,
+		KeySet:    r.keys.Fingerprint(),
+		Entries:   []Entry{},
+	}
+	parentFiles := map[string]Entry{}
+	if parent, err := r.latest(opts.Tag); err == nil {
+		snap.Parent = parent.ID
+		for _, e := range parent.Entries {
+			if parent.KeySet != snap.KeySet {
+				// The parent's chunks do not open with the current keys.
+				break
+			}
+			if e.Type == EntryFile {
+				parentFiles[e.Path] = e
+			}
+		}
+	} else if !errors.Is(err, ErrSnapshotNotFound) {
+		return Snapshot{}, err
+	}
+
+	stored := map[string]bool{}
+	err := filepath.WalkDir(source, func(p string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if errors.Is(walkErr, fs.ErrNotExist) {
+				return nil
+			}
+			return walkErr
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if p == source {
+			return nil
+		}
+		if excluded(d.Name(), exclude) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(source, p)
+		if err != nil {
+			return err
+		}
+		uid, gid := fileOwner(info)
+		entry := Entry{
+			Path:    filepath.ToSlash(rel),
+			Mode:    info.Mode().Perm() | info.Mode()&(fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky),
+			UID:     uid,
+			GID:     gid,
+			ModTime: info.ModTime().UTC(),
+		}
+		switch {
+		case info.IsDir():
+			entry.Type = EntryDir
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			entry.Type = EntrySymlink
+			entry.Target = target
+		case info.Mode().IsRegular():
+			entry.Type = EntryFile
+			entry.Size = info.Size()
+			if prev, ok := parentFiles[entry.Path]; ok && prev.Size == info.Size() && prev.ModTime.Equal(entry.ModTime) {
+				entry.Chunks = prev.Chunks
+			} else {
+				size, chunks, added, err := r.storeFile(p, snap.KeySet, stored)
+				if err != nil {
+					return err
+				}
+				entry.Size, entry.Chunks = size, chunks
+				snap.Added += added
+			}
+			snap.Files++
+			snap.Size += entry.Size
+		default:
+			return nil
+		}
+		snap.Entries = append(snap.Entries, entry)
+		return nil
+	})
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("backup %s: %w", source, err)
+	}
+	if err := r.writeManifest(snap); err != nil {
+		return Snapshot{}, err
+	}
+	snap.Entries = nil
+	return snap, nil
+}
+
+// Snapshots lists the snapshots with tag, or all of them when tag is
+// empty, newest first and without entries. Snapshots encrypted to keys
+// the repository view does not hold are left out.
+func (r *Repository) Snapshots(tag string) ([]Snapshot, error) {
+	return r.snapshots(tag, false)
+}
+
+// snapshots lists snapshots like Snapshots; strict fails on a snapshot
+// that does not open instead of skipping it.
+func (r *Repository) snapshots(tag string, strict bool) ([]Snapshot, error) {
+	entries, err := os.ReadDir(filepath.Join(r.dir, "snapshots"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return []Snapshot{}, nil
+		}
+		return nil, fmt.Errorf("list snapshots: %w", err)
+	}
+	snaps := make([]Snapshot, 0, len(entries))
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || !snapshotIDPattern.MatchString(id) {
+			continue
+		}
+		snap, err := r.Load(id)
+		if (errors.Is(err, ErrNoKey) || errors.Is(err, ErrPlaintext)) && !strict {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if tag != "" && snap.Tag != tag {
+			continue
+		}
+		snap.Entries = nil
+		snaps = append(snaps, snap)
+	}
+	sort.Slice(snaps, func(i, j int) bool {
+		if !snaps[i].CreatedAt.Equal(snaps[j].CreatedAt) {
+			return snaps[i].CreatedAt.After(snaps[j].CreatedAt)
+		}
+		return snaps[i].ID > snaps[j].ID
+	})
+	return snaps, nil
+}
+
+// Load reads a snapshot with its entries.
+func (r *Repository) Load(id string) (Snapshot, error) {
+	if !snapshotIDPattern.MatchString(id) {
+		return Snapshot{}, ErrSnapshotNotFound
+	}
+	//nolint:gosec // G304: the id is validated against snapshotIDPattern.
+	f, err := os.Open(filepath.Join(r.dir, "snapshots", id+".json"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return Snapshot{}, ErrSnapshotNotFound
+		}
+		return Snapshot{}, fmt.Errorf("read snapshot %s: %w", id, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	plain, err := r.keys.Decrypt(f)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("read snapshot %s: %w", id, err)
+	}
+	var snap Snapshot
+	if err := json.NewDecoder(plain).Decode(&snap); err != nil {
+		return Snapshot{}, fmt.Errorf("decode snapshot %s: %w", id, err)
+	}
+	return snap, nil
+}
+
+// List returns the entries directly inside dir of a snapshot; an empty
+// dir lists the top level.
+func (r *Repository) List(id, dir string) ([]Entry, error) {
+	snap, err := r.Load(id)
+	if err != nil {
+		return nil, err
+	}
+	dir = cleanEntryPath(dir)
+	found := dir == ""
+	entries := []Entry{}
+	for _, e := range snap.Entries {
+		if e.Path == dir {
+			if e.Type != EntryDir {
+				return nil, fmt.Errorf("invalid path: %s is not a directory", dir)
+			}
+			found = true
+			continue
+		}
+		parent := path.Dir(e.Path)
+		if parent == "." {
+			parent = ""
+		}
+		if parent == dir {
+			e.Chunks = nil
+			entries = append(entries, e)
+		}
+	}
+	if !found {
+		return nil, ErrEntryNotFound
+	}
+	return entries, nil
+}
+
+// WriteFile copies one file of a snapshot to w.
+func (r *Repository) WriteFile(ctx context.Context, id, name string, w io.Writer) (Entry, error) {
+	snap, err := r.Load(id)
+	if err != nil {
+		return Entry{}, err
+	}
+	name = cleanEntryPath(name)
+	for _, e := range snap.Entries {
+		if e.Path != name {
+			continue
+		}
+		if e.Type != EntryFile {
+			return Entry{}, fmt.Errorf("invalid path: %s is not a file", name)
+		}
+		_, err := r.copyChunks(ctx, snap.KeySet, e.Chunks, w)
+		return e, err
+	}
+	return Entry{}, ErrEntryNotFound
+}
+
+// Restore writes the selected paths of a snapshot, with everything below
+// them, into target. An empty path or "." selects the whole snapshot.
+// Files are written next to their destination and renamed over it, and a
+// restore never follows a symlink it finds in target: a parent that is
+// not a real directory fails the restore. Files created after the
+// snapshot are left alone. Ownership is restored when running as root.
+// A snapshot encrypted to keys the view does not hold fails with ErrNoKey
+// before anything is written: its manifest and chunks share one key set.
+func (r *Repository) Restore(ctx context.Context, id string, paths []string, target string) (RestoreResult, error) {
+	snap, err := r.Load(id)
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	if len(paths) == 0 {
+		return RestoreResult{}, fmt.Errorf("invalid restore: no paths selected")
+	}
+	dirs := map[string]Entry{}
+	for _, e := range snap.Entries {
+		if e.Type == EntryDir {
+			dirs[e.Path] = e
+		}
+	}
+	selected := make([]bool, len(snap.Entries))
+	for _, p := range paths {
+		p = cleanEntryPath(p)
+		matched := false
+		for i, e := range snap.Entries {
+			if p == "" || e.Path == p || strings.HasPrefix(e.Path, p+"/") {
+				selected[i] = true
+				matched = true
+			}
+		}
+		if !matched {
+			return RestoreResult{}, fmt.Errorf("%w: %s", ErrEntryNotFound, p)
+		}
+	}
+
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		return RestoreResult{}, fmt.Errorf("create restore target: %w", err)
+	}
+	var (
+		result   RestoreResult
+		restored []Entry
+	)
+	for i, e := range snap.Entries {
+		if !selected[i] {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if err := ensureParents(target, e.Path, dirs); err != nil {
+			return result, err
+		}
+		dest := filepath.Join(target, filepath.FromSlash(e.Path))
+		switch e.Type {
+		case EntryDir:
+			if err := restoreDir(dest, e); err != nil {
+				return result, err
+			}
+			result.Dirs++
+		case EntrySymlink:
+			if err := replaceWith(dest, func(tmp string) error { return os.Symlink(e.Target, tmp) }); err != nil {
+				return result, fmt.Errorf("restore %s: %w", e.Path, err)
+			}
+			chownEntry(dest, e)
+			result.Symlinks++
+		case EntryFile:
+			err := replaceWith(dest, func(tmp string) error {
+				//nolint:gosec // G304: tmp is created next to a path inside target.
+				f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+				if err != nil {
+					return err
+				}
+				n, copyErr := r.copyChunks(ctx, snap.KeySet, e.Chunks, f)
+				if err := f.Close(); copyErr == nil {
+					copyErr = err
+				}
+				if copyErr != nil {
+					return copyErr
+				}
+				result.Bytes += n
+				chownEntry(tmp, e)
+				if err := os.Chmod(tmp, e.Mode); err != nil {
+					return err
+				}
+				return os.Chtimes(tmp, e.ModTime, e.ModTime)
+			})
+			if err != nil {
+				return result, fmt.Errorf("restore %s: %w", e.Path, err)
+			}
+			result.Files++
+		}
+		restored = append(restored, e)
+	}
+	// Writing into a directory moves its mtime, so directory times are
+	// set last, deepest first.
+	for i := len(restored) - 1; i >= 0; i-- {
+		if e := restored[i]; e.Type == EntryDir {
+			_ = os.Chtimes(filepath.Join(target, filepath.FromSlash(e.Path)), e.ModTime, e.ModTime)
+		}
+	}
+	return result, nil
+}
+
+// Forget removes snapshot manifests. Their chunks stay until Prune.
+func (r *Repository) Forget(ids ...string) error {
+	for _, id := range ids {
+		if !snapshotIDPattern.MatchString(id) {
+			return ErrSnapshotNotFound
+		}
+		if err := os.Remove(filepath.Join(r.dir, "snapshots", id+".json")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("forget snapshot %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// Prune removes chunks no snapshot references.
+func (r *Repository) Prune() (PruneResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Strict: chunks of a snapshot that does not open are still in use.
+	snaps, err := r.snapshots("", true)
+	if err != nil {
+		return PruneResult{}, err
+	}
+	used := map[string]bool{}
+	for _, s := range snaps {
+		full, err := r.Load(s.ID)
+		if err != nil {
+			return PruneResult{}, err
+		}
+		for _, e := range full.Entries {
+			for _, c := range e.Chunks {
+				used[path.Join(full.KeySet, c)] = true
+			}
+		}
+	}
+	var result PruneResult
+	root := filepath.Join(r.dir, "chunks")
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		// Chunks sit at [<key set>/]<aa>/<hash>.
+		if used[path.Join(path.Dir(path.Dir(filepath.ToSlash(rel))), d.Name())] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+		result.Chunks++
+		result.Bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("prune chunks: %w", err)
+	}
+	return result, nil
+}
+
+// latest returns the newest snapshot with tag, with its entries.
+func (r *Repository) latest(tag string) (Snapshot, error) {
+	if tag == "" {
+		return Snapshot{}, ErrSnapshotNotFound
+	}
+	snaps, err := r.Snapshots(tag)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if len(snaps) == 0 {
+		return Snapshot{}, ErrSnapshotNotFound
+	}
+	return r.Load(snaps[0].ID)
+}
+
+// storeFile chunks one file and stores the chunks the repository does not
+// have yet. stored remembers chunks seen during this backup.
+func (r *Repository) storeFile(p, keySet string, stored map[string]bool) (int64, []string, int64, error) {
+	//nolint:gosec // G304: p comes from walking the backup source.
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var (
+		size, added int64
+		chunks      = []string{}
+	)
+	c := newChunker(f, r.minChunk, r.avgBits, r.maxChunk)
+	for {
+		data, err := c.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, nil, 0, err
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		if !stored[hash] {
+			written, err := r.putChunk(keySet, hash, data)
+			if err != nil {
+				return 0, nil, 0, err
+			}
+			if written {
+				added += int64(len(data))
+			}
+			stored[hash] = true
+		}
+		chunks = append(chunks, hash)
+		size += int64(len(data))
+	}
+	return size, chunks, added, nil
+}
+
+func (r *Repository) chunkPath(keySet, hash string) (string, error) {
+	if len(hash) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid chunk id %q", hash)
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", fmt.Errorf("invalid chunk id %q", hash)
+	}
+	if keySet == "" {
+		return filepath.Join(r.dir, "chunks", hash[:2], hash), nil
+	}
+	if _, err := hex.DecodeString(keySet); err != nil || len(keySet) != 16 {
+		return "", fmt.Errorf("invalid key set %q", keySet)
+	}
+	return filepath.Join(r.dir, "chunks", keySet, hash[:2], hash), nil
+}
+
+// putChunk stores data under hash unless it is already present and
+// reports whether it wrote anything. Chunks are compressed, then
+// encrypted.
+func (r *Repository) putChunk(keySet, hash string, data []byte) (bool, error) {
+	dest, err := r.chunkPath(keySet, hash)
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(dest); err == nil {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o700); err != nil {
+		return false, fmt.Errorf("create chunk dir: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".chunk-*")
+	if err != nil {
+		return false, fmt.Errorf("store chunk: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	enc, err := r.keys.Encrypt(tmp)
+	if err == nil {
+		zw, _ := gzip.NewWriterLevel(enc, gzip.BestSpeed)
+		_, err = zw.Write(data)
+		if closeErr := zw.Close(); err == nil {
+			err = closeErr
+		}
+		if closeErr := enc.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dest)
+	}
+	if err != nil {
+		return false, fmt.Errorf("store chunk: %w", err)
+	}
+	return true, nil
+}
+
+// copyChunks writes the chunks of a file to w, verifying each against its
+// hash.
+func (r *Repository) copyChunks(ctx context.Context, keySet string, chunks []string, w io.Writer) (int64, error) {
+	var total int64
+	for _, hash := range chunks {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		src, err := r.chunkPath(keySet, hash)
+		if err != nil {
+			return total, err
+		}
+		//nolint:gosec // G304: the path is built from a validated chunk hash.
+		f, err := os.Open(src)
+		if err != nil {
+			return total, fmt.Errorf("read chunk %s: %w", hash, err)
+		}
+		plain, err := r.keys.Decrypt(f)
+		if err != nil {
+			_ = f.Close()
+			return total, fmt.Errorf("read chunk %s: %w", hash, err)
+		}
+		zr, err := gzip.NewReader(plain)
+		if err != nil {
+			_ = f.Close()
+			return total, fmt.Errorf("read chunk %s: %w", hash, err)
+		}
+		h := sha256.New()
+		n, err := io.Copy(io.MultiWriter(w, h), zr)
+		_ = f.Close()
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("read chunk %s: %w", hash, err)
+		}
+		if hex.EncodeToString(h.Sum(nil)) != hash {
+			return total, fmt.Errorf("chunk %s is corrupt", hash)
+		}
+	}
+	return total, nil
+}
+
+func (r *Repository) writeManifest(snap Snapshot) error {
+	dir := filepath.Join(r.dir, "snapshots")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("create snapshot dir: %w", err)
+	}
+	raw, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+	tmp := filepath.Join(dir, "."+snap.ID+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	enc, err := r.keys.Encrypt(f)
+	if err == nil {
+		_, err = enc.Write(raw)
+		if closeErr := enc.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, snap.ID+".json")); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	return nil
+}
+
+// ensureParents creates the missing parent directories of rel below
+// target, with the mode and owner the snapshot recorded for them.
+func ensureParents(target, rel string, dirs map[string]Entry) error {
+	parts := strings.Split(rel, "/")
+	current := target
+	for i := 0; i < len(parts)-1; i++ {
+		current = filepath.Join(current, parts[i])
+		info, err := os.Lstat(current)
+		switch {
+		case err == nil && info.IsDir():
+			continue
+		case err == nil:
+			return fmt.Errorf("refusing to restore %s: %s is not a directory", rel, strings.Join(parts[:i+1], "/"))
+		case !errors.Is(err, fs.ErrNotExist):
+			return err
+		}
+		mode := fs.FileMode(0o755)
+		e, ok := dirs[strings.Join(parts[:i+1], "/")]
+		if ok {
+			mode = e.Mode
+		}
+		if err := os.Mkdir(current, mode.Perm()); err != nil {
+			return fmt.Errorf("restore %s: %w", rel, err)
+		}
+		if ok {
+			chownEntry(current, e)
+		}
+	}
+	return nil
+}
+
+func restoreDir(dest string, e Entry) error {
+	info, err := os.Lstat(dest)
+	switch {
+	case err == nil && info.IsDir():
+	case err == nil:
+		// A file or symlink took the place of the directory.
+		if err := os.Remove(dest); err != nil {
+			return fmt.Errorf("restore %s: %w", e.Path, err)
+		}
+		fallthrough
+	case errors.Is(err, fs.ErrNotExist):
+		if err := os.Mkdir(dest, e.Mode.Perm()); err != nil {
+			return fmt.Errorf("restore %s: %w", e.Path, err)
+		}
+	default:
+		return err
+	}
+	chownEntry(dest, e)
+	if err := os.Chmod(dest, e.Mode); err != nil {
+		return fmt.Errorf("restore %s: %w", e.Path, err)
+	}
+	return nil
+}
+
+// replaceWith creates a temporary sibling of dest with create and renames
+// it over dest, which replaces a symlink at dest instead of following it.
+func replaceWith(dest string, create func(tmp string) error) error {
+	tmp := filepath.Join(filepath.Dir(dest), fmt.Sprintf(".%s.aipanel-restore-%s", filepath.Base(dest), randomHex(4)))
+	if err := create(tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func chownEntry(p string, e Entry) {
+	if os.Geteuid() != 0 {
+		return
+	}
+	_ = os.Lchown(p, e.UID, e.GID)
+}
+
+func fileOwner(info fs.FileInfo) (int, int) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(st.Uid), int(st.Gid)
+	}
+	return -1, -1
+}
+
+func excluded(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanEntryPath turns a user-supplied path into the form stored in
+// entries: slash-separated, relative and without "..".
+func cleanEntryPath(p string) string {
+	p = strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(p, `\`, "/")), "/")
+	if p == "." {
+		return ""
+	}
+	return p
+}
+
+func newSnapshotID(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z") + "-" + randomHex(4)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
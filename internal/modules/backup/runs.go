@@ -0,0 +1,230 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+// Backup run kinds.
+const (
+	KindSiteFiles  = "site_files"
+	KindDatabase   = "database"
+	KindPanel      = "panel"
+	KindRemoteSync = "remote_sync"
+)
+
+// Backup run statuses.
+const (
+	RunOK     = "ok"
+	RunFailed = "failed"
+)
+
+const (
+	defaultRunLimit = 50
+	maxRunLimit     = 500
+	// runHistoryDays bounds the backup_runs table.
+	runHistoryDays = 90
+	// freshnessWindow is how old the last good site files backup may be
+	// before it counts as stale: the nightly run plus a missed night.
+	freshnessWindow = 48 * time.Hour
+)
+
+// ErrRunNotFound indicates a missing backup run.
+var ErrRunNotFound = errors.New("backup run not found")
+
+// BackupRun is one recorded backup, database dump, panel.db copy or remote
+// sync. Location is the snapshot ID, dump or copy file name, or bucket
+// the run wrote to.
+type BackupRun struct {
+	ID         int64     `json:"id"`
+	Kind       string    `json:"kind"`
+	Target     string    `json:"target"`
+	SiteID     int64     `json:"site_id,omitempty"`
+	DatabaseID int64     `json:"database_id,omitempty"`
+	Trigger    string    `json:"trigger,omitempty"`
+	Status     string    `json:"status"`
+	Location   string    `json:"location,omitempty"`
+	Bytes      int64     `json:"bytes"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// RunFilter narrows Runs. Zero values match everything.
+type RunFilter struct {
+	Kind   string
+	SiteID int64
+	Limit  int
+}
+
+// RecordRun stores a finished run; runErr marks it failed. Other modules
+// record their own backups with it, so the history covers every kind.
+// Runs older than runHistoryDays are dropped on the way.
+func RecordRun(ctx context.Context, store *sqlite.Store, run BackupRun, runErr error) error {
+	run.Status = RunOK
+	if runErr != nil {
+		run.Status = RunFailed
+		run.Error = runErr.Error()
+	}
+	if run.FinishedAt.IsZero() {
+		run.FinishedAt = time.Now()
+	}
+	if run.StartedAt.IsZero() {
+		run.StartedAt = run.FinishedAt
+	}
+	ctx = context.WithoutCancel(ctx)
+	if err := store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO backup_runs(kind, target, site_id, database_id, trigger_name, status, location, bytes, error, started_at, finished_at)
+VALUES('%s','%s',%d,%d,'%s','%s','%s',%d,'%s',%d,%d);
+DELETE FROM backup_runs WHERE finished_at < %d;`,
+		sqlEscape(run.Kind), sqlEscape(run.Target), run.SiteID, run.DatabaseID, sqlEscape(run.Trigger),
+		run.Status, sqlEscape(run.Location), run.Bytes, sqlEscape(run.Error),
+		run.StartedAt.Unix(), run.FinishedAt.Unix(),
+		run.FinishedAt.AddDate(0, 0, -runHistoryDays).Unix(),
+	)); err != nil {
+		return fmt.Errorf("record backup run: %w", err)
+	}
+	return nil
+}
+
+// Runs lists recorded backup runs, newest first.
+func (s *Service) Runs(ctx context.Context, filter RunFilter) ([]BackupRun, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultRunLimit
+	}
+	if limit > maxRunLimit {
+		limit = maxRunLimit
+	}
+	var where []string
+	if filter.Kind != "" {
+		if !validKind(filter.Kind) {
+			return nil, fmt.Errorf("invalid kind: %s", filter.Kind)
+		}
+		where = append(where, fmt.Sprintf("kind = '%s'", sqlEscape(filter.Kind)))
+	}
+	if filter.SiteID > 0 {
+		where = append(where, fmt.Sprintf("site_id = %d", filter.SiteID))
+	}
+	query := "SELECT id, kind, target, site_id, database_id, trigger_name, status, location, bytes, error, started_at, finished_at FROM backup_runs"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf("%s ORDER BY id DESC LIMIT %d;", query, limit))
+	if err != nil {
+		return nil, fmt.Errorf("list backup runs: %w", err)
+	}
+	runs := make([]BackupRun, 0, len(rows))
+	for _, row := range rows {
+		runs = append(runs, runFromRow(row))
+	}
+	return runs, nil
+}
+
+// Run returns one recorded backup run.
+func (s *Service) Run(ctx context.Context, id int64) (BackupRun, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT id, kind, target, site_id, database_id, trigger_name, status, location, bytes, error, started_at, finished_at FROM backup_runs WHERE id = %d;", id))
+	if err != nil {
+		return BackupRun{}, fmt.Errorf("get backup run: %w", err)
+	}
+	if len(rows) == 0 {
+		return BackupRun{}, ErrRunNotFound
+	}
+	return runFromRow(rows[0]), nil
+}
+
+// RestoreRun restores paths (everything when empty) of the snapshot a
+// successful site files run took. Database dumps and panel.db copies are
+// restored with their own tools, so other kinds are rejected.
+func (s *Service) RestoreRun(ctx context.Context, id int64, paths []string, identity, actor string) (RestoreResult, error) {
+	run, err := s.Run(ctx, id)
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	if run.Kind != KindSiteFiles {
+		return RestoreResult{}, fmt.Errorf("invalid restore: %s runs are not restored here", run.Kind)
+	}
+	if run.Status != RunOK || run.Location == "" {
+		return RestoreResult{}, fmt.Errorf("invalid restore: run %d did not produce a snapshot", id)
+	}
+	if len(paths) == 0 {
+		paths = []string{""}
+	}
+	return s.RestoreFiles(ctx, run.SiteID, run.Location, paths, identity, actor)
+}
+
+// Freshness is how recent the file backups of a site are. LastStatus is
+// the status of the latest run, which may be newer than LastOKAt.
+type Freshness struct {
+	LastOKAt   time.Time `json:"last_ok_at,omitzero"`
+	LastStatus string    `json:"last_status,omitempty"`
+	Stale      bool      `json:"stale"`
+}
+
+// SiteFreshness returns the file backup freshness of every site that has
+// backup runs; sites without any are missing from the map.
+func (s *Service) SiteFreshness(ctx context.Context) (map[int64]Freshness, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT site_id,
+       MAX(CASE WHEN status = '%[1]s' THEN finished_at ELSE 0 END) AS last_ok_at,
+       (SELECT status FROM backup_runs l WHERE l.kind = '%[2]s' AND l.site_id = r.site_id ORDER BY id DESC LIMIT 1) AS last_status
+FROM backup_runs r
+WHERE kind = '%[2]s' AND site_id > 0
+GROUP BY site_id;`, RunOK, KindSiteFiles))
+	if err != nil {
+		return nil, fmt.Errorf("backup freshness: %w", err)
+	}
+	cutoff := time.Now().Add(-freshnessWindow)
+	out := make(map[int64]Freshness, len(rows))
+	for _, row := range rows {
+		siteID, _ := toInt64(row["site_id"])
+		lastOK, _ := toInt64(row["last_ok_at"])
+		f := Freshness{Stale: true}
+		f.LastStatus, _ = row["last_status"].(string)
+		if lastOK > 0 {
+			f.LastOKAt = time.Unix(lastOK, 0).UTC()
+			f.Stale = f.LastOKAt.Before(cutoff)
+		}
+		out[siteID] = f
+	}
+	return out, nil
+}
+
+func runFromRow(row map[string]any) BackupRun {
+	str := func(k string) string {
+		v, _ := row[k].(string)
+		return v
+	}
+	num := func(k string) int64 {
+		n, _ := toInt64(row[k])
+		return n
+	}
+	return BackupRun{
+		ID:         num("id"),
+		Kind:       str("kind"),
+		Target:     str("target"),
+		SiteID:     num("site_id"),
+		DatabaseID: num("database_id"),
+		Trigger:    str("trigger_name"),
+		Status:     str("status"),
+		Location:   str("location"),
+		Bytes:      num("bytes"),
+		Error:      str("error"),
+		StartedAt:  time.Unix(num("started_at"), 0).UTC(),
+		FinishedAt: time.Unix(num("finished_at"), 0).UTC(),
+	}
+}
+
+func validKind(kind string) bool {
+	switch kind {
+	case KindSiteFiles, KindDatabase, KindPanel, KindRemoteSync:
+		return true
+	}
+	return false
+}
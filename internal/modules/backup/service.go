@@ -0,0 +1,403 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/internal/platform/middleware"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+// SiteFilesJob is the job type that snapshots one site docroot.
+const SiteFilesJob = "backup.site.files"
+
+// defaultSiteRetention is the number of snapshots kept per site, matching
+// the daily tier of the backup contract.
+const defaultSiteRetention = 7
+
+// ErrSiteNotFound indicates a missing site row.
+var ErrSiteNotFound = errors.New("site not found")
+
+// Options overrides the repository location and retention, mainly for
+// tests.
+type Options struct {
+	// RepoDir holds the file backup repository; it defaults to
+	// <data_dir>/backups/files.
+	RepoDir string
+	// Retention is the number of snapshots kept per site.
+	Retention int
+}
+
+// Service runs incremental file backups of site docroots and restores
+// individual files from them. Snapshots are encrypted to the install key
+// and every backup recipient (see LoadKeyring).
+type Service struct {
+	store     *sqlite.Store
+	log       *slog.Logger
+	repo      *Repository
+	jobs      *jobqueue.Queue
+	retention int
+	// onRestore runs after files were restored into a site docroot.
+	onRestore func(ctx context.Context, siteID int64, actor string)
+}
+
+type siteFilesPayload struct {
+	SiteID  int64  `json:"site_id"`
+	Trigger string `json:"trigger"`
+}
+
+type siteRef struct {
+	ID      int64
+	Domain  string
+	RootDir string
+}
+
+// NewService creates a backup service.
+func NewService(store *sqlite.Store, log *slog.Logger, opts Options) *Service {
+	if log == nil {
+		log = slog.Default()
+	}
+	if opts.RepoDir == "" {
+		opts.RepoDir = FilesDir(store.DataDir)
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = defaultSiteRetention
+	}
+	return &Service{
+		store:     store,
+		log:       log,
+		repo:      NewRepository(opts.RepoDir),
+		retention: opts.Retention,
+	}
+}
+
+// RegisterJobs registers backup job handlers and keeps q for enqueueing.
+func (s *Service) RegisterJobs(q *jobqueue.Queue) {
+	s.jobs = q
+	q.Register(SiteFilesJob, s.runSiteFilesJob)
+	q.Register(RemoteSyncJob, s.runRemoteSyncJob)
+}
+
+// OnRestore sets the callback run after a successful restore into a site
+// docroot, e.g. to purge the site page cache.
+func (s *Service) OnRestore(fn func(ctx context.Context, siteID int64, actor string)) {
+	s.onRestore = fn
+}
+
+func (s *Service) restored(ctx context.Context, siteID int64, actor string) {
+	if s.onRestore != nil {
+		s.onRestore(ctx, siteID, actor)
+	}
+}
+
+// BackupSite queues a snapshot of a site docroot.
+func (s *Service) BackupSite(ctx context.Context, siteID int64, actor string) (int64, error) {
+	if s.jobs == nil {
+		return 0, fmt.Errorf("job queue is not configured")
+	}
+	site, err := s.site(ctx, siteID)
+	if err != nil {
+		return 0, err
+	}
+	id, err := s.jobs.Enqueue(ctx, SiteFilesJob, siteFilesPayload{SiteID: siteID, Trigger: TriggerOnDemand})
+	if err != nil {
+		return 0, err
+	}
+	_ = s.writeAudit(ctx, actor, "backup.site.queue", siteID, fmt.Sprintf("domain=%s job_id=%d", site.Domain, id))
+	return id, nil
+}
+
+// BackupSiteNow snapshots a site docroot in the calling goroutine, for
+// the CLI where no job queue runs.
+func (s *Service) BackupSiteNow(ctx context.Context, siteID int64, actor string) (Snapshot, error) {
+	site, err := s.site(ctx, siteID)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	snap, err := s.backupSite(ctx, site, TriggerOnDemand)
+	if err != nil {
+		return snap, err
+	}
+	_ = s.writeAudit(ctx, actor, "backup.site.run", siteID, fmt.Sprintf("domain=%s snapshot=%s", site.Domain, snap.ID))
+	return snap, nil
+}
+
+// RunNightly queues a snapshot of every site docroot.
+func (s *Service) RunNightly(ctx context.Context) (int, error) {
+	return s.queueSites(ctx, TriggerScheduled)
+}
+
+// BackupAllSites queues an on-demand snapshot of every site docroot.
+func (s *Service) BackupAllSites(ctx context.Context, actor string) (int, error) {
+	queued, err := s.queueSites(ctx, TriggerOnDemand)
+	if queued > 0 {
+		_ = s.writeAudit(ctx, actor, "backup.sites.queue", 0, fmt.Sprintf("sites=%d", queued))
+	}
+	return queued, err
+}
+
+func (s *Service) queueSites(ctx context.Context, trigger string) (int, error) {
+	if s.jobs == nil {
+		return 0, fmt.Errorf("job queue is not configured")
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, "SELECT id FROM sites ORDER BY id;")
+	if err != nil {
+		return 0, fmt.Errorf("list sites: %w", err)
+	}
+	queued := 0
+	for _, row := range rows {
+		id, err := toInt64(row["id"])
+		if err != nil {
+			return queued, fmt.Errorf("parse site id: %w", err)
+		}
+		if _, err := s.jobs.Enqueue(ctx, SiteFilesJob, siteFilesPayload{SiteID: id, Trigger: trigger}); err != nil {
+			return queued, err
+		}
+		queued++
+	}
+	return queued, nil
+}
+
+// Snapshots lists the file snapshots of a site, newest first.
+func (s *Service) Snapshots(ctx context.Context, siteID int64) ([]Snapshot, error) {
+	if _, err := s.site(ctx, siteID); err != nil {
+		return nil, err
+	}
+	repo, err := s.open(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return repo.Snapshots(siteTag(siteID))
+}
+
+// Browse lists one directory of a site snapshot.
+func (s *Service) Browse(ctx context.Context, siteID int64, snapshotID, dir string) ([]Entry, error) {
+	repo, err := s.open(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ownSnapshot(ctx, repo, siteID, snapshotID); err != nil {
+		return nil, err
+	}
+	return repo.List(snapshotID, dir)
+}
+
+// Download writes one file of a site snapshot to w.
+func (s *Service) Download(ctx context.Context, siteID int64, snapshotID, name string, w io.Writer) error {
+	repo, err := s.open(ctx, "")
+	if err != nil {
+		return err
+	}
+	if err := s.ownSnapshot(ctx, repo, siteID, snapshotID); err != nil {
+		return err
+	}
+	_, err = repo.WriteFile(ctx, snapshotID, name, w)
+	return err
+}
+
+// RestoreFiles puts the selected paths of a snapshot back into the site
+// docroot. The current docroot is snapshotted first, so the restore can
+// itself be undone. identity optionally adds a client-held key (see
+// ParseIdentity) for snapshots the install key does not open; without a
+// matching key the restore is refused with ErrNoKey.
+func (s *Service) RestoreFiles(ctx context.Context, siteID int64, snapshotID string, paths []string, identity, actor string) (RestoreResult, error) {
+	site, err := s.site(ctx, siteID)
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	repo, err := s.open(ctx, identity)
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	if err := s.ownSnapshot(ctx, repo, siteID, snapshotID); err != nil {
+		if errors.Is(err, ErrNoKey) || errors.Is(err, ErrPlaintext) {
+			_ = s.writeAudit(ctx, actor, "backup.site.restore_refused", siteID, fmt.Sprintf("domain=%s snapshot=%s", site.Domain, snapshotID))
+		}
+		return RestoreResult{}, err
+	}
+	if len(paths) == 0 {
+		return RestoreResult{}, fmt.Errorf("invalid restore: no paths selected")
+	}
+	pre, err := repo.Backup(ctx, site.RootDir, BackupOptions{Tag: siteTag(siteID), Trigger: TriggerPreRestore})
+	if err != nil {
+		return RestoreResult{}, fmt.Errorf("pre-restore snapshot: %w", err)
+	}
+	result, err := repo.Restore(ctx, snapshotID, paths, site.RootDir)
+	details := fmt.Sprintf("domain=%s snapshot=%s pre_restore=%s paths=%s files=%d", site.Domain, snapshotID, pre.ID, strings.Join(paths, ","), result.Files)
+	if err != nil {
+		_ = s.writeAudit(ctx, actor, "backup.site.restore_failed", siteID, details)
+		return result, err
+	}
+	_ = s.writeAudit(ctx, actor, "backup.site.restore", siteID, details)
+	s.restored(ctx, siteID, actor)
+	return result, nil
+}
+
+func (s *Service) runSiteFilesJob(ctx context.Context, job jobqueue.Job) error {
+	var payload siteFilesPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("decode site backup payload: %w", err)
+	}
+	site, err := s.site(ctx, payload.SiteID)
+	if err != nil {
+		if errors.Is(err, ErrSiteNotFound) {
+			// Removed after the job was queued; nothing to do.
+			return nil
+		}
+		return err
+	}
+	_, err = s.backupSite(ctx, site, payload.Trigger)
+	return err
+}
+
+// backupSite snapshots a site docroot, records the run and applies
+// retention.
+func (s *Service) backupSite(ctx context.Context, site siteRef, trigger string) (Snapshot, error) {
+	started := time.Now()
+	run := BackupRun{Kind: KindSiteFiles, Target: site.Domain, SiteID: site.ID, Trigger: trigger, StartedAt: started}
+	repo, err := s.open(ctx, "")
+	if err != nil {
+		_ = RecordRun(ctx, s.store, run, err)
+		return Snapshot{}, err
+	}
+	snap, err := repo.Backup(ctx, site.RootDir, BackupOptions{Tag: siteTag(site.ID), Trigger: trigger})
+	run.Location, run.Bytes = snap.ID, snap.Added
+	_ = RecordRun(ctx, s.store, run, err)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	s.log.Info("site files backed up",
+		"domain", site.Domain, "snapshot", snap.ID, "files", snap.Files,
+		"size", snap.Size, "added", snap.Added, "duration", time.Since(started).String())
+	return snap, s.applyRetention(ctx, repo, site.ID)
+}
+
+// applyRetention forgets the oldest snapshots of a site beyond the
+// retention count and prunes the chunks only they used.
+func (s *Service) applyRetention(ctx context.Context, repo *Repository, siteID int64) error {
+	settings, err := s.Settings(ctx)
+	if err != nil {
+		return err
+	}
+	retention := settings.SiteRetention
+	snaps, err := repo.Snapshots(siteTag(siteID))
+	if err != nil {
+		return err
+	}
+	if len(snaps) <= retention {
+		return nil
+	}
+	ids := make([]string, 0, len(snaps)-retention)
+	for _, snap := range snaps[retention:] {
+		ids = append(ids, snap.ID)
+	}
+	if err := repo.Forget(ids...); err != nil {
+		return err
+	}
+	pruned, err := repo.Prune()
+	if err != nil {
+		return err
+	}
+	s.log.Info("site backup retention applied", "site_id", siteID, "forgotten", len(ids), "chunks", pruned.Chunks, "bytes", pruned.Bytes)
+	return nil
+}
+
+// open returns the repository with the current keyring, plus identity
+// when one is supplied.
+func (s *Service) open(ctx context.Context, identity string) (*Repository, error) {
+	keys, err := LoadKeyring(ctx, s.store)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(identity) != "" {
+		id, err := ParseIdentity(identity)
+		if err != nil {
+			return nil, err
+		}
+		keys = keys.With(id)
+	}
+	return s.repo.withKeys(keys), nil
+}
+
+// ownSnapshot makes sure a snapshot belongs to the site, so a site grant
+// never reaches another site's files.
+func (s *Service) ownSnapshot(ctx context.Context, repo *Repository, siteID int64, snapshotID string) error {
+	if _, err := s.site(ctx, siteID); err != nil {
+		return err
+	}
+	snap, err := repo.Load(snapshotID)
+	if err != nil {
+		return err
+	}
+	if snap.Tag != siteTag(siteID) {
+		return ErrSnapshotNotFound
+	}
+	return nil
+}
+
+func (s *Service) site(ctx context.Context, siteID int64) (siteRef, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT id, domain, root_dir FROM sites WHERE id = %d LIMIT 1;", siteID))
+	if err != nil {
+		return siteRef{}, fmt.Errorf("get site: %w", err)
+	}
+	if len(rows) == 0 {
+		return siteRef{}, ErrSiteNotFound
+	}
+	domain, _ := rows[0]["domain"].(string)
+	rootDir, _ := rows[0]["root_dir"].(string)
+	if rootDir == "" {
+		return siteRef{}, fmt.Errorf("site %d has no root_dir", siteID)
+	}
+	return siteRef{ID: siteID, Domain: domain, RootDir: rootDir}, nil
+}
+
+// writeAudit records an event in the activity of siteID.
+func (s *Service) writeAudit(ctx context.Context, actor, action string, siteID int64, details string) error {
+	if strings.TrimSpace(actor) == "" {
+		actor = "system"
+	}
+	return s.store.ExecAudit(ctx, fmt.Sprintf(
+		"INSERT INTO audit_events(actor, action, details, remote_ip, impersonator, site_id, created_at) VALUES('%s','%s','%s','%s','%s',%d,%d);",
+		sqlEscape(actor),
+		sqlEscape(action),
+		sqlEscape(details),
+		sqlEscape(middleware.ClientIP(ctx)),
+		sqlEscape(middleware.Impersonator(ctx)),
+		siteID,
+		time.Now().Unix(),
+	))
+}
+
+func siteTag(siteID int64) string {
+	return "site:" + strconv.FormatInt(siteID, 10)
+}
+
+func sqlEscape(in string) string {
+	return strings.ReplaceAll(in, "'", "''")
+}
+
+func toInt64(v any) (int64, error) {
+	switch n := v.(type) {
+	case float64:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case int:
+		return int64(n), nil
+	case string:
+		return strconv.ParseInt(n, 10, 64)
+	case nil:
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("unexpected type %T", v)
+	}
+}
@@ -0,0 +1,223 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRemoteRegion = "us-east-1"
+	maxRetention        = 365
+)
+
+// ErrRemoteNotConfigured indicates that no remote backup storage is set.
+var ErrRemoteNotConfigured = errors.New("remote backup storage is not configured")
+
+var (
+	remoteBucketPattern     = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	remoteRegionPattern     = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+	remoteCredentialPattern = regexp.MustCompile(`^[A-Za-z0-9._~+/=-]{3,256}$`)
+	remotePrefixPattern     = regexp.MustCompile(`^[A-Za-z0-9._/-]{0,200}$`)
+)
+
+// RemoteStorage is the S3-compatible bucket the backup dir is mirrored to.
+// Everything in it is encrypted (see LoadKeyring), so the bucket never
+// sees plaintext. The secret key is never returned.
+type RemoteStorage struct {
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"-"`
+	PathStyle bool   `json:"path_style"`
+}
+
+// Configured reports whether a bucket is set.
+func (r RemoteStorage) Configured() bool {
+	return r.Bucket != ""
+}
+
+// Settings holds backup retention and remote storage. A zero retention
+// keeps the built-in default. AllowPlaintext lets restores read archives
+// written before backups were encrypted; without it an unencrypted
+// archive is refused, since it may have been swapped in by whoever can
+// write to the backup dir or bucket.
+type Settings struct {
+	SiteRetention  int           `json:"site_retention"`
+	PanelRetention int           `json:"panel_retention"`
+	Remote         RemoteStorage `json:"remote"`
+	AllowPlaintext bool          `json:"allow_plaintext"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+// SettingsRequest updates Settings. An empty Remote.SecretKey keeps the
+// stored one; an empty Remote.Bucket removes remote storage.
+type SettingsRequest struct {
+	SiteRetention  int `json:"site_retention"`
+	PanelRetention int `json:"panel_retention"`
+	Remote         struct {
+		Endpoint  string `json:"endpoint"`
+		Region    string `json:"region"`
+		Bucket    string `json:"bucket"`
+		Prefix    string `json:"prefix"`
+		AccessKey string `json:"access_key"`
+		SecretKey string `json:"secret_key"`
+		PathStyle bool   `json:"path_style"`
+	} `json:"remote"`
+	AllowPlaintext bool `json:"allow_plaintext"`
+}
+
+// Settings returns the backup settings with defaults applied.
+func (s *Service) Settings(ctx context.Context) (Settings, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, `
+SELECT site_retention, panel_retention, remote_endpoint, remote_region, remote_bucket, remote_prefix,
+  remote_access_key, remote_secret_key, remote_path_style, allow_plaintext, updated_at
+FROM backup_settings
+WHERE id = 1;`)
+	if err != nil {
+		return Settings{}, fmt.Errorf("get backup settings: %w", err)
+	}
+	settings := Settings{SiteRetention: s.retention, PanelRetention: defaultPanelRetention}
+	if len(rows) == 0 {
+		return settings, nil
+	}
+	row := rows[0]
+	str := func(k string) string {
+		v, _ := row[k].(string)
+		return v
+	}
+	num := func(k string) int64 {
+		n, _ := toInt64(row[k])
+		return n
+	}
+	if n := int(num("site_retention")); n > 0 {
+		settings.SiteRetention = n
+	}
+	if n := int(num("panel_retention")); n > 0 {
+		settings.PanelRetention = n
+	}
+	settings.Remote = RemoteStorage{
+		Endpoint:  str("remote_endpoint"),
+		Region:    str("remote_region"),
+		Bucket:    str("remote_bucket"),
+		Prefix:    str("remote_prefix"),
+		AccessKey: str("remote_access_key"),
+		SecretKey: str("remote_secret_key"),
+		PathStyle: num("remote_path_style") == 1,
+	}
+	settings.AllowPlaintext = num("allow_plaintext") == 1
+	settings.UpdatedAt = time.Unix(num("updated_at"), 0).UTC()
+	return settings, nil
+}
+
+// UpdateSettings validates and stores the backup settings.
+func (s *Service) UpdateSettings(ctx context.Context, req SettingsRequest, actor string) (Settings, error) {
+	if req.SiteRetention < 0 || req.SiteRetention > maxRetention {
+		return Settings{}, fmt.Errorf("invalid site_retention: must be between 0 and %d", maxRetention)
+	}
+	if req.PanelRetention < 0 || req.PanelRetention > maxRetention {
+		return Settings{}, fmt.Errorf("invalid panel_retention: must be between 0 and %d", maxRetention)
+	}
+	remote := RemoteStorage{
+		Endpoint:  strings.TrimRight(strings.TrimSpace(req.Remote.Endpoint), "/"),
+		Region:    strings.TrimSpace(req.Remote.Region),
+		Bucket:    strings.TrimSpace(req.Remote.Bucket),
+		Prefix:    strings.Trim(strings.TrimSpace(req.Remote.Prefix), "/"),
+		AccessKey: strings.TrimSpace(req.Remote.AccessKey),
+		SecretKey: strings.TrimSpace(req.Remote.SecretKey),
+		PathStyle: req.Remote.PathStyle,
+	}
+	current, err := s.Settings(ctx)
+	if err != nil {
+		return Settings{}, err
+	}
+	if remote.Configured() {
+		if remote.Region == "" {
+			remote.Region = defaultRemoteRegion
+		}
+		if remote.SecretKey == "" {
+			remote.SecretKey = current.Remote.SecretKey
+		}
+		if err := validateRemote(remote); err != nil {
+			return Settings{}, err
+		}
+	} else {
+		remote = RemoteStorage{}
+	}
+	pathStyle, allowPlaintext := 0, 0
+	if remote.PathStyle {
+		pathStyle = 1
+	}
+	if req.AllowPlaintext {
+		allowPlaintext = 1
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO backup_settings(id, site_retention, panel_retention, remote_endpoint, remote_region, remote_bucket,
+  remote_prefix, remote_access_key, remote_secret_key, remote_path_style, allow_plaintext, updated_at)
+VALUES(1, %d, %d, '%s', '%s', '%s', '%s', '%s', '%s', %d, %d, %d)
+ON CONFLICT(id) DO UPDATE SET
+  site_retention = excluded.site_retention,
+  panel_retention = excluded.panel_retention,
+  remote_endpoint = excluded.remote_endpoint,
+  remote_region = excluded.remote_region,
+  remote_bucket = excluded.remote_bucket,
+  remote_prefix = excluded.remote_prefix,
+  remote_access_key = excluded.remote_access_key,
+  remote_secret_key = excluded.remote_secret_key,
+  remote_path_style = excluded.remote_path_style,
+  allow_plaintext = excluded.allow_plaintext,
+  updated_at = excluded.updated_at;`,
+		req.SiteRetention, req.PanelRetention,
+		sqlEscape(remote.Endpoint), sqlEscape(remote.Region), sqlEscape(remote.Bucket), sqlEscape(remote.Prefix),
+		sqlEscape(remote.AccessKey), sqlEscape(remote.SecretKey), pathStyle, allowPlaintext, time.Now().Unix(),
+	)); err != nil {
+		return Settings{}, fmt.Errorf("save backup settings: %w", err)
+	}
+	// Objects uploaded to another bucket are no longer this panel's to
+	// expire; they are left there.
+	if current.Remote.Endpoint != remote.Endpoint || current.Remote.Bucket != remote.Bucket || current.Remote.Prefix != remote.Prefix {
+		if err := s.store.ExecPanel(ctx, "DELETE FROM backup_remote_objects;"); err != nil {
+			return Settings{}, fmt.Errorf("reset remote backup state: %w", err)
+		}
+	}
+	_ = s.writeAudit(ctx, actor, "backup.settings.update", 0, fmt.Sprintf(
+		"site_retention=%d panel_retention=%d bucket=%s endpoint=%s prefix=%s allow_plaintext=%t",
+		req.SiteRetention, req.PanelRetention, remote.Bucket, remote.Endpoint, remote.Prefix, req.AllowPlaintext))
+	return s.Settings(ctx)
+}
+
+func validateRemote(remote RemoteStorage) error {
+	endpoint, err := url.Parse(remote.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" ||
+		endpoint.Path != "" || endpoint.RawQuery != "" || endpoint.User != nil {
+		return fmt.Errorf("invalid endpoint: expected http(s)://host[:port]")
+	}
+	if !remoteBucketPattern.MatchString(remote.Bucket) {
+		return fmt.Errorf("invalid bucket name")
+	}
+	if !remote.PathStyle && strings.Contains(remote.Bucket, ".") && endpoint.Scheme == "https" {
+		return fmt.Errorf("invalid bucket name: dotted buckets require path_style")
+	}
+	if !remotePrefixPattern.MatchString(remote.Prefix) || strings.Contains(remote.Prefix, "..") {
+		return fmt.Errorf("invalid prefix")
+	}
+	if !remoteRegionPattern.MatchString(remote.Region) {
+		return fmt.Errorf("invalid region")
+	}
+	if !remoteCredentialPattern.MatchString(remote.AccessKey) {
+		return fmt.Errorf("invalid access key")
+	}
+	if remote.SecretKey == "" {
+		return fmt.Errorf("secret key is required")
+	}
+	if !remoteCredentialPattern.MatchString(remote.SecretKey) {
+		return fmt.Errorf("invalid secret key")
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/modules/audit"
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+func TestService_DatabaseActivityTagsEvents(t *testing.T) {
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	if err := store.ExecPanel(ctx, "INSERT INTO sites(domain, root_dir, php_version, system_user, status, created_at, updated_at) VALUES('test.example.com','/var/www/test.example.com/public_html','8.3','site_test','active',1,1);"); err != nil {
+		t.Fatalf("seed site: %v", err)
+	}
+	svc := NewService(store, config.Config{}, slog.Default(), &fakeMariaDB{}, &fakePostgreSQL{})
+
+	res, err := svc.CreateDatabase(ctx, CreateDatabaseRequest{SiteID: 1, DBName: "shop", DBEngine: DBEngineMariaDB, Actor: "admin@example.com"})
+	if err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	id := res.Database.ID
+	if _, err := svc.SetBackupSchedule(ctx, id, BackupScheduleRequest{IntervalMinutes: 60, Retention: 3, Actor: "admin@example.com"}); err != nil {
+		t.Fatalf("set backup schedule: %v", err)
+	}
+
+	events, err := svc.DatabaseActivity(ctx, id, 0, 0)
+	if err != nil {
+		t.Fatalf("database activity: %v", err)
+	}
+	if len(events) != 2 || events[0].Action != "database.backup_schedule.set" || events[1].Action != "database.create" {
+		t.Fatalf("expected schedule and create events, got %+v", events)
+	}
+	if events[1].SiteID != 1 || events[1].DatabaseID != id {
+		t.Fatalf("expected event tagged with site and database, got %+v", events[1])
+	}
+
+	if err := svc.DeleteDatabase(ctx, id, "admin@example.com"); err != nil {
+		t.Fatalf("delete db: %v", err)
+	}
+	if _, err := svc.DatabaseActivity(ctx, id, 0, 0); !errors.Is(err, ErrDatabaseNotFound) {
+		t.Fatalf("expected ErrDatabaseNotFound after delete, got %v", err)
+	}
+	siteEvents, err := audit.List(ctx, store, audit.Query{SiteID: 1})
+	if err != nil {
+		t.Fatalf("site events: %v", err)
+	}
+	if len(siteEvents) != 3 || siteEvents[0].Action != "database.delete" || siteEvents[0].DatabaseID != id {
+		t.Fatalf("expected the deletion in the site feed, got %+v", siteEvents)
+	}
+}
@@ -1,25 +1,40 @@
 package database
 
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/robsonek/aiPanel/internal/platform/systemd"
 )
 
-var mariadbNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+var (
+	mariadbNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+	// binlogPositionPattern matches the commented coordinates written by
+	// mariadb-dump --master-data=2.
+	binlogPositionPattern = regexp.MustCompile(`CHANGE MASTER TO MASTER_LOG_FILE='([^']+)', MASTER_LOG_POS=(\d+)`)
+	binlogFilePattern     = regexp.MustCompile(`^mariadb-bin\.\d+$`)
+)
 
 const (
 	defaultMariaDBBinaryPath = "/opt/aipanel/runtime/mariadb/current/bin/mariadb"
 	defaultMariaDBService    = "aipanel-runtime-mariadb.service"
+	defaultMariaDBBinlogDir  = "/var/lib/aipanel/runtime/mariadb-binlog"
 )
 
 // MariaDBAdapterOptions controls runtime command paths used by the adapter.
 type MariaDBAdapterOptions struct {
 	BinaryPath  string
 	ServiceName string
+	BinlogDir   string
 }
 
 // MariaDBAdapter executes MariaDB commands through system runner.
@@ -27,6 +42,7 @@ type MariaDBAdapter struct {
 	runner      systemd.Runner
 	binaryPath  string
 	serviceName string
+	binlogDir   string
 }
 
 // NewMariaDBAdapter creates a MariaDB adapter.
@@ -44,10 +60,14 @@ func NewMariaDBAdapter(runner systemd.Runner, opts ...MariaDBAdapterOptions) *Ma
 	if strings.TrimSpace(cfg.ServiceName) == "" {
 		cfg.ServiceName = defaultMariaDBService
 	}
+	if strings.TrimSpace(cfg.BinlogDir) == "" {
+		cfg.BinlogDir = defaultMariaDBBinlogDir
+	}
 	return &MariaDBAdapter{
 		runner:      runner,
 		binaryPath:  cfg.BinaryPath,
 		serviceName: cfg.ServiceName,
+		binlogDir:   cfg.BinlogDir,
 	}
 }
 
@@ -117,6 +137,107 @@ func (a *MariaDBAdapter) DropUser(ctx context.Context, username string) error {
 	return nil
 }
 
+// SetPassword replaces the password of an existing database user.
+func (a *MariaDBAdapter) SetPassword(ctx context.Context, username, password string) error {
+	username = strings.TrimSpace(username)
+	if !mariadbNamePattern.MatchString(username) {
+		return fmt.Errorf("invalid username")
+	}
+	if strings.TrimSpace(password) == "" {
+		return fmt.Errorf("password is required")
+	}
+	password = strings.ReplaceAll(password, "\\", "\\\\")
+	password = strings.ReplaceAll(password, "'", "''")
+	sql := fmt.Sprintf("ALTER USER '%s'@'localhost' IDENTIFIED BY '%s'; FLUSH PRIVILEGES;", username, password)
+	if _, err := a.runner.Run(ctx, a.binaryPath, "-e", sql); err != nil {
+		return fmt.Errorf("set password for %s: %w", username, err)
+	}
+	return nil
+}
+
+// Dump writes a logical SQL dump of dbName to dest. With the binary log
+// enabled the dump records its binlog coordinates, which makes it a base
+// for RestoreToTime.
+func (a *MariaDBAdapter) Dump(ctx context.Context, dbName, dest string) error {
+	dbName = strings.TrimSpace(dbName)
+	if !mariadbNamePattern.MatchString(dbName) {
+		return fmt.Errorf("invalid database name")
+	}
+	args := []string{"--single-transaction", "--routines", "--events"}
+	if a.binlogEnabled(ctx) {
+		args = append(args, "--master-data=2")
+	}
+	args = append(args, "--result-file="+dest, dbName)
+	if _, err := a.runner.Run(ctx, filepath.Join(filepath.Dir(a.binaryPath), "mariadb-dump"), args...); err != nil {
+		return fmt.Errorf("dump database %s: %w", dbName, err)
+	}
+	return nil
+}
+
+// Restore loads a dump written by Dump into dbName. MariaDB grants are per
+// database, so owner is not needed.
+func (a *MariaDBAdapter) Restore(ctx context.Context, dbName, _ string, src string) error {
+	dbName = strings.TrimSpace(dbName)
+	if !mariadbNamePattern.MatchString(dbName) {
+		return fmt.Errorf("invalid database name")
+	}
+	script := fmt.Sprintf("%s %s < %s", shellQuote(a.binaryPath), shellQuote(dbName), shellQuote(src))
+	if _, err := a.runner.Run(ctx, "bash", "-c", script); err != nil {
+		return fmt.Errorf("restore database %s: %w", dbName, err)
+	}
+	return nil
+}
+
+// RestoreToTime recreates dbName from a dump taken with binlog coordinates
+// and replays binlog events of that database up to target.
+func (a *MariaDBAdapter) RestoreToTime(ctx context.Context, dbName, base string, target time.Time) error {
+	dbName = strings.TrimSpace(dbName)
+	if !mariadbNamePattern.MatchString(dbName) {
+		return fmt.Errorf("invalid database name")
+	}
+	file, pos, err := binlogPosition(base)
+	if err != nil {
+		return err
+	}
+	logs, err := a.binlogsFrom(file)
+	if err != nil {
+		return err
+	}
+	mariadbBin := filepath.Join(filepath.Dir(a.binaryPath), "mariadb-binlog")
+	recreate := fmt.Sprintf("DROP DATABASE IF EXISTS `%s`; CREATE DATABASE `%s` CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci;", dbName, dbName)
+	quotedLogs := make([]string, 0, len(logs))
+	for _, l := range logs {
+		quotedLogs = append(quotedLogs, shellQuote(l))
+	}
+	// mariadb-binlog reads --stop-datetime in the server's local time.
+	script := strings.Join([]string{
+		"set -euo pipefail",
+		fmt.Sprintf("%s -D %s -e %s", shellQuote(a.binaryPath), shellQuote(dbName), shellQuote(recreate)),
+		fmt.Sprintf("%s %s < %s", shellQuote(a.binaryPath), shellQuote(dbName), shellQuote(base)),
+		fmt.Sprintf("%s --database=%s --start-position=%d --stop-datetime=%s %s | %s %s",
+			shellQuote(mariadbBin), shellQuote(dbName), pos,
+			shellQuote(target.Local().Format("2006-01-02 15:04:05")),
+			strings.Join(quotedLogs, " "),
+			shellQuote(a.binaryPath), shellQuote(dbName)),
+	}, "\n")
+	if _, err := a.runner.Run(ctx, "bash", "-c", script); err != nil {
+		return fmt.Errorf("restore database %s to %s: %w", dbName, target.UTC().Format(time.RFC3339), err)
+	}
+	return nil
+}
+
+// PruneArchive purges binary logs older than before.
+func (a *MariaDBAdapter) PruneArchive(ctx context.Context, before time.Time) error {
+	if !a.binlogEnabled(ctx) {
+		return nil
+	}
+	sql := fmt.Sprintf("PURGE BINARY LOGS BEFORE '%s';", before.Local().Format("2006-01-02 15:04:05"))
+	if _, err := a.runner.Run(ctx, a.binaryPath, "-e", sql); err != nil {
+		return fmt.Errorf("purge binary logs: %w", err)
+	}
+	return nil
+}
+
 // IsRunning reports whether mariadb unit is active.
 func (a *MariaDBAdapter) IsRunning(ctx context.Context) (bool, error) {
 	out, err := a.runner.Run(ctx, "systemctl", "is-active", a.serviceName)
@@ -129,3 +250,61 @@ func (a *MariaDBAdapter) IsRunning(ctx context.Context) (bool, error) {
 	}
 	return strings.TrimSpace(out) == "active", nil
 }
+
+func (a *MariaDBAdapter) binlogEnabled(ctx context.Context) bool {
+	out, err := a.runner.Run(ctx, a.binaryPath, "-N", "-e", "SELECT @@log_bin;")
+	return err == nil && strings.TrimSpace(out) == "1"
+}
+
+// binlogsFrom lists binlog files from first onwards in sequence order.
+func (a *MariaDBAdapter) binlogsFrom(first string) ([]string, error) {
+	entries, err := os.ReadDir(a.binlogDir)
+	if err != nil {
+		return nil, fmt.Errorf("read binlog dir: %w", err)
+	}
+	var logs []string
+	for _, e := range entries {
+		if e.IsDir() || !binlogFilePattern.MatchString(e.Name()) || e.Name() < first {
+			continue
+		}
+		logs = append(logs, filepath.Join(a.binlogDir, e.Name()))
+	}
+	sort.Strings(logs)
+	if len(logs) == 0 || filepath.Base(logs[0]) != first {
+		return nil, fmt.Errorf("binary log %s is no longer available", first)
+	}
+	return logs, nil
+}
+
+// binlogPosition reads the binlog coordinates from the header of a dump.
+func binlogPosition(dumpPath string) (string, int64, error) {
+	// Dumps are written by the panel under its backup dir.
+	//nolint:gosec // G304
+	f, err := os.Open(dumpPath)
+	if err != nil {
+		return "", 0, fmt.Errorf("open dump: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return readBinlogPosition(f, filepath.Base(dumpPath))
+}
+
+// readBinlogPosition scans the head of a dump for its binlog coordinates.
+func readBinlogPosition(r io.Reader, name string) (string, int64, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for line := 0; line < 100 && scanner.Scan(); line++ {
+		if m := binlogPositionPattern.FindStringSubmatch(scanner.Text()); m != nil {
+			pos, err := strconv.ParseInt(m[2], 10, 64)
+			if err != nil {
+				return "", 0, fmt.Errorf("parse binlog position: %w", err)
+			}
+			return m[1], pos, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", 0, fmt.Errorf("read dump %s: %w", name, err)
+	}
+	return "", 0, fmt.Errorf("dump %s has no binlog position", name)
+}
@@ -3,8 +3,11 @@ package database
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 type fakeRunner struct {
@@ -96,3 +99,75 @@ func TestMariaDBAdapter_IsRunningInactive(t *testing.T) {
 		t.Fatal("expected running status false")
 	}
 }
+
+func TestMariaDBAdapter_SetPassword(t *testing.T) {
+	r := &fakeRunner{}
+	if err := NewMariaDBAdapter(r).SetPassword(context.Background(), "site_user", "n'ew"); err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+	want := "/opt/aipanel/runtime/mariadb/current/bin/mariadb -e ALTER USER 'site_user'@'localhost' IDENTIFIED BY 'n''ew'; FLUSH PRIVILEGES;"
+	if len(r.commands) != 1 || r.commands[0] != want {
+		t.Fatalf("expected %q, got %v", want, r.commands)
+	}
+	if err := NewMariaDBAdapter(r).SetPassword(context.Background(), "bad user", "x"); err == nil {
+		t.Fatal("expected invalid username to be rejected")
+	}
+}
+
+func TestMariaDBAdapter_RestoreToTimeReplaysBinlogFromDumpPosition(t *testing.T) {
+	dir := t.TempDir()
+	binlogDir := filepath.Join(dir, "binlog")
+	if err := os.MkdirAll(binlogDir, 0o750); err != nil {
+		t.Fatalf("mkdir binlog dir: %v", err)
+	}
+	for _, name := range []string{"mariadb-bin.000001", "mariadb-bin.000002", "mariadb-bin.000003", "mariadb-bin.index"} {
+		if err := os.WriteFile(filepath.Join(binlogDir, name), nil, 0o600); err != nil {
+			t.Fatalf("write binlog: %v", err)
+		}
+	}
+	dump := filepath.Join(dir, "shop.sql")
+	header := "-- MariaDB dump\n--\n-- CHANGE MASTER TO MASTER_LOG_FILE='mariadb-bin.000002', MASTER_LOG_POS=328;\n"
+	if err := os.WriteFile(dump, []byte(header), 0o600); err != nil {
+		t.Fatalf("write dump: %v", err)
+	}
+
+	r := &fakeRunner{}
+	ad := NewMariaDBAdapter(r, MariaDBAdapterOptions{BinlogDir: binlogDir})
+	target := time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)
+	if err := ad.RestoreToTime(context.Background(), "shop", dump, target); err != nil {
+		t.Fatalf("restore to time: %v", err)
+	}
+	joined := strings.Join(r.commands, "\n")
+	for _, want := range []string{
+		"DROP DATABASE IF EXISTS `shop`; CREATE DATABASE `shop`",
+		"< '" + dump + "'",
+		"--start-position=328 --stop-datetime='" + target.Local().Format("2006-01-02 15:04:05") + "'",
+		"'" + filepath.Join(binlogDir, "mariadb-bin.000002") + "' '" + filepath.Join(binlogDir, "mariadb-bin.000003") + "' |",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Fatalf("expected restore script to contain %q, got:\n%s", want, joined)
+		}
+	}
+	if strings.Contains(joined, "mariadb-bin.000001") {
+		t.Fatalf("did not expect binlogs before the dump position, got:\n%s", joined)
+	}
+
+	if err := os.Remove(filepath.Join(binlogDir, "mariadb-bin.000002")); err != nil {
+		t.Fatalf("remove binlog: %v", err)
+	}
+	if err := ad.RestoreToTime(context.Background(), "shop", dump, target); err == nil {
+		t.Fatal("expected restore to fail once the starting binlog is purged")
+	}
+}
+
+func TestMariaDBAdapter_DumpRecordsBinlogPositionWhenEnabled(t *testing.T) {
+	r := &fakeRunner{outputs: map[string]string{
+		"/opt/aipanel/runtime/mariadb/current/bin/mariadb -N -e SELECT @@log_bin;": "1\n",
+	}}
+	if err := NewMariaDBAdapter(r).Dump(context.Background(), "shop", "/tmp/shop.sql"); err != nil {
+		t.Fatalf("dump: %v", err)
+	}
+	if !strings.Contains(r.commands[len(r.commands)-1], "--master-data=2 --result-file=/tmp/shop.sql shop") {
+		t.Fatalf("expected dump with binlog coordinates, got %v", r.commands)
+	}
+}
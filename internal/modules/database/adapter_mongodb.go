@@ -0,0 +1,305 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/platform/systemd"
+	"github.com/robsonek/aiPanel/pkg/adapter"
+)
+
+const (
+	defaultMongoDBShellPath       = "/opt/aipanel/runtime/mongodb/current/bin/mongosh"
+	defaultMongoDBService         = "aipanel-runtime-mongodb.service"
+	defaultMongoDBCredentialsFile = "/var/lib/aipanel/runtime/mongodb-admin.json"
+	// mongoDBAuthSource is where site users live; their roles are scoped to
+	// the site database, so connection strings use authSource=admin.
+	mongoDBAuthSource = "admin"
+)
+
+// MongoDBAdapterOptions controls runtime command paths used by the adapter.
+type MongoDBAdapterOptions struct {
+	ShellPath       string
+	ServiceName     string
+	CredentialsFile string
+}
+
+// MongoDBAdapter executes MongoDB commands through mongosh. The runtime
+// runs with authorization enabled; the installer bootstraps an admin user
+// and stores its credentials in CredentialsFile.
+type MongoDBAdapter struct {
+	runner          systemd.Runner
+	shellPath       string
+	serviceName     string
+	credentialsFile string
+}
+
+// NewMongoDBAdapter creates a MongoDB adapter.
+func NewMongoDBAdapter(runner systemd.Runner, opts ...MongoDBAdapterOptions) *MongoDBAdapter {
+	if runner == nil {
+		runner = systemd.ExecRunner{}
+	}
+	cfg := MongoDBAdapterOptions{}
+	if len(opts) > 0 {
+		cfg = opts[0]
+	}
+	if strings.TrimSpace(cfg.ShellPath) == "" {
+		cfg.ShellPath = defaultMongoDBShellPath
+	}
+	if strings.TrimSpace(cfg.ServiceName) == "" {
+		cfg.ServiceName = defaultMongoDBService
+	}
+	if strings.TrimSpace(cfg.CredentialsFile) == "" {
+		cfg.CredentialsFile = defaultMongoDBCredentialsFile
+	}
+	return &MongoDBAdapter{
+		runner:          runner,
+		shellPath:       cfg.ShellPath,
+		serviceName:     cfg.ServiceName,
+		credentialsFile: cfg.CredentialsFile,
+	}
+}
+
+// CreateDatabase creates a MongoDB database. MongoDB creates databases
+// lazily, so a marker collection is added to make it visible right away.
+func (a *MongoDBAdapter) CreateDatabase(ctx context.Context, dbName string) error {
+	dbName = strings.TrimSpace(dbName)
+	if !mariadbNamePattern.MatchString(dbName) {
+		return fmt.Errorf("invalid database name")
+	}
+	script := fmt.Sprintf(
+		`const d = db.getSiblingDB(%s); if (!d.getCollectionNames().includes("_aipanel")) { d.createCollection("_aipanel"); }`,
+		jsString(dbName),
+	)
+	if _, err := a.eval(ctx, script); err != nil {
+		return fmt.Errorf("create database %s: %w", dbName, err)
+	}
+	return nil
+}
+
+// DropDatabase drops a MongoDB database.
+func (a *MongoDBAdapter) DropDatabase(ctx context.Context, dbName string) error {
+	dbName = strings.TrimSpace(dbName)
+	if !mariadbNamePattern.MatchString(dbName) {
+		return fmt.Errorf("invalid database name")
+	}
+	script := fmt.Sprintf("db.getSiblingDB(%s).dropDatabase();", jsString(dbName))
+	if _, err := a.eval(ctx, script); err != nil {
+		return fmt.Errorf("drop database %s: %w", dbName, err)
+	}
+	return nil
+}
+
+// CreateUser creates a SCRAM-SHA-256 user with read/write access to dbName.
+func (a *MongoDBAdapter) CreateUser(ctx context.Context, username, password, dbName string) error {
+	username = strings.TrimSpace(username)
+	dbName = strings.TrimSpace(dbName)
+	if !mariadbNamePattern.MatchString(username) {
+		return fmt.Errorf("invalid username")
+	}
+	if !mariadbNamePattern.MatchString(dbName) {
+		return fmt.Errorf("invalid database name")
+	}
+	if strings.TrimSpace(password) == "" {
+		return fmt.Errorf("password is required")
+	}
+	script := fmt.Sprintf(
+		`db.getSiblingDB(%s).createUser({user: %s, pwd: %s, mechanisms: ["SCRAM-SHA-256"], roles: [{role: "readWrite", db: %s}, {role: "dbAdmin", db: %s}]});`,
+		jsString(mongoDBAuthSource),
+		jsString(username),
+		jsString(password),
+		jsString(dbName),
+		jsString(dbName),
+	)
+	if _, err := a.eval(ctx, script); err != nil {
+		return fmt.Errorf("create user %s: %w", username, err)
+	}
+	return nil
+}
+
+// DropUser drops database user.
+func (a *MongoDBAdapter) DropUser(ctx context.Context, username string) error {
+	username = strings.TrimSpace(username)
+	if !mariadbNamePattern.MatchString(username) {
+		return fmt.Errorf("invalid username")
+	}
+	script := fmt.Sprintf(
+		`const d = db.getSiblingDB(%s); if (d.getUser(%s)) { d.dropUser(%s); }`,
+		jsString(mongoDBAuthSource),
+		jsString(username),
+		jsString(username),
+	)
+	if _, err := a.eval(ctx, script); err != nil {
+		return fmt.Errorf("drop user %s: %w", username, err)
+	}
+	return nil
+}
+
+// SetPassword replaces the password of an existing database user.
+func (a *MongoDBAdapter) SetPassword(ctx context.Context, username, password string) error {
+	username = strings.TrimSpace(username)
+	if !mariadbNamePattern.MatchString(username) {
+		return fmt.Errorf("invalid username")
+	}
+	if strings.TrimSpace(password) == "" {
+		return fmt.Errorf("password is required")
+	}
+	script := fmt.Sprintf(
+		`db.getSiblingDB(%s).updateUser(%s, {pwd: %s, mechanisms: ["SCRAM-SHA-256"]});`,
+		jsString(mongoDBAuthSource),
+		jsString(username),
+		jsString(password),
+	)
+	if _, err := a.eval(ctx, script); err != nil {
+		return fmt.Errorf("set password for %s: %w", username, err)
+	}
+	return nil
+}
+
+// Dump writes a gzipped mongodump archive of dbName to dest.
+func (a *MongoDBAdapter) Dump(ctx context.Context, dbName, dest string) error {
+	dbName = strings.TrimSpace(dbName)
+	if !mariadbNamePattern.MatchString(dbName) {
+		return fmt.Errorf("invalid database name")
+	}
+	creds, err := a.readCredentials()
+	if err != nil {
+		return err
+	}
+	if _, err := a.runner.Run(ctx,
+		filepath.Join(filepath.Dir(a.shellPath), "mongodump"),
+		"--host", "127.0.0.1",
+		"--username", creds.Username,
+		"--password", creds.Password,
+		"--authenticationDatabase", mongoDBAuthSource,
+		"--db", dbName,
+		"--gzip",
+		"--archive="+dest,
+	); err != nil {
+		return fmt.Errorf("dump database %s: %w", dbName, err)
+	}
+	return nil
+}
+
+// Restore loads a mongodump archive into dbName, renaming whatever database
+// the archive was taken from. Users live in the admin database, so owner
+// is not needed.
+func (a *MongoDBAdapter) Restore(ctx context.Context, dbName, _ string, src string) error {
+	dbName = strings.TrimSpace(dbName)
+	if !mariadbNamePattern.MatchString(dbName) {
+		return fmt.Errorf("invalid database name")
+	}
+	creds, err := a.readCredentials()
+	if err != nil {
+		return err
+	}
+	if _, err := a.runner.Run(ctx,
+		filepath.Join(filepath.Dir(a.shellPath), "mongorestore"),
+		"--host", "127.0.0.1",
+		"--username", creds.Username,
+		"--password", creds.Password,
+		"--authenticationDatabase", mongoDBAuthSource,
+		"--gzip",
+		"--archive="+src,
+		"--nsFrom=$db$.$col$",
+		"--nsTo="+dbName+".$col$",
+	); err != nil {
+		return fmt.Errorf("restore database %s: %w", dbName, err)
+	}
+	return nil
+}
+
+// Stats returns dbStats of a MongoDB database.
+func (a *MongoDBAdapter) Stats(ctx context.Context, dbName string) (adapter.DocumentDBStats, error) {
+	dbName = strings.TrimSpace(dbName)
+	if !mariadbNamePattern.MatchString(dbName) {
+		return adapter.DocumentDBStats{}, fmt.Errorf("invalid database name")
+	}
+	script := fmt.Sprintf("print(JSON.stringify(db.getSiblingDB(%s).stats()));", jsString(dbName))
+	out, err := a.eval(ctx, script)
+	if err != nil {
+		return adapter.DocumentDBStats{}, fmt.Errorf("stats %s: %w", dbName, err)
+	}
+	var raw struct {
+		Collections float64 `json:"collections"`
+		Objects     float64 `json:"objects"`
+		DataSize    float64 `json:"dataSize"`
+		StorageSize float64 `json:"storageSize"`
+		IndexSize   float64 `json:"indexSize"`
+	}
+	if err := json.Unmarshal([]byte(lastLine(out)), &raw); err != nil {
+		return adapter.DocumentDBStats{}, fmt.Errorf("parse stats %s: %w", dbName, err)
+	}
+	return adapter.DocumentDBStats{
+		Collections: int64(raw.Collections),
+		Objects:     int64(raw.Objects),
+		DataSize:    int64(raw.DataSize),
+		StorageSize: int64(raw.StorageSize),
+		IndexSize:   int64(raw.IndexSize),
+	}, nil
+}
+
+// IsRunning reports whether mongodb unit is active.
+func (a *MongoDBAdapter) IsRunning(ctx context.Context) (bool, error) {
+	out, err := a.runner.Run(ctx, "systemctl", "is-active", a.serviceName)
+	if err != nil {
+		trimmed := strings.TrimSpace(strings.ToLower(out + " " + err.Error()))
+		if strings.Contains(trimmed, "inactive") || strings.Contains(trimmed, "failed") || strings.Contains(trimmed, "unknown") {
+			return false, nil
+		}
+		return false, err
+	}
+	return strings.TrimSpace(out) == "active", nil
+}
+
+func (a *MongoDBAdapter) eval(ctx context.Context, script string) (string, error) {
+	creds, err := a.readCredentials()
+	if err != nil {
+		return "", err
+	}
+	return a.runner.Run(ctx, a.shellPath,
+		"--quiet",
+		"--host", "127.0.0.1",
+		"--username", creds.Username,
+		"--password", creds.Password,
+		"--authenticationDatabase", mongoDBAuthSource,
+		"--eval", script,
+	)
+}
+
+type mongoDBCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (a *MongoDBAdapter) readCredentials() (mongoDBCredentials, error) {
+	// Path comes from adapter options, not from request input.
+	//nolint:gosec // G304
+	raw, err := os.ReadFile(a.credentialsFile)
+	if err != nil {
+		return mongoDBCredentials{}, fmt.Errorf("read mongodb admin credentials: %w", err)
+	}
+	var creds mongoDBCredentials
+	if err := json.Unmarshal(raw, &creds); err != nil {
+		return mongoDBCredentials{}, fmt.Errorf("parse mongodb admin credentials: %w", err)
+	}
+	if strings.TrimSpace(creds.Username) == "" || creds.Password == "" {
+		return mongoDBCredentials{}, fmt.Errorf("mongodb admin credentials are incomplete")
+	}
+	return creds, nil
+}
+
+// jsString renders s as a JavaScript string literal.
+func jsString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func lastLine(out string) string {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
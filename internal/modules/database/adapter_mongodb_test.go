@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestMongoDBAdapter(t *testing.T, r *fakeRunner) *MongoDBAdapter {
+	t.Helper()
+	credentials := filepath.Join(t.TempDir(), "mongodb-admin.json")
+	if err := os.WriteFile(credentials, []byte(`{"username":"aipanel","password":"adminpw"}`), 0o600); err != nil {
+		t.Fatalf("write credentials: %v", err)
+	}
+	return NewMongoDBAdapter(r, MongoDBAdapterOptions{CredentialsFile: credentials})
+}
+
+func TestMongoDBAdapter_CommandSequence(t *testing.T) {
+	r := &fakeRunner{}
+	ad := newTestMongoDBAdapter(t, r)
+
+	if err := ad.CreateDatabase(context.Background(), "site_db"); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	if err := ad.CreateUser(context.Background(), "site_user", `sec"ret`, "site_db"); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if err := ad.DropUser(context.Background(), "site_user"); err != nil {
+		t.Fatalf("drop user: %v", err)
+	}
+	if err := ad.DropDatabase(context.Background(), "site_db"); err != nil {
+		t.Fatalf("drop db: %v", err)
+	}
+
+	joined := strings.Join(r.commands, "\n")
+	prefix := "/opt/aipanel/runtime/mongodb/current/bin/mongosh --quiet --host 127.0.0.1 --username aipanel --password adminpw --authenticationDatabase admin --eval "
+	if !strings.Contains(joined, prefix+`const d = db.getSiblingDB("site_db");`) {
+		t.Fatalf("missing create database command:\n%s", joined)
+	}
+	if !strings.Contains(joined, `createUser({user: "site_user", pwd: "sec\"ret", mechanisms: ["SCRAM-SHA-256"], roles: [{role: "readWrite", db: "site_db"}`) {
+		t.Fatalf("missing SCRAM create user command:\n%s", joined)
+	}
+	if !strings.Contains(joined, `db.getSiblingDB("site_db").dropDatabase();`) {
+		t.Fatalf("missing drop database command:\n%s", joined)
+	}
+	if err := ad.CreateDatabase(context.Background(), `x"); db.dropDatabase(); ("`); err == nil {
+		t.Fatal("expected invalid database name to be rejected")
+	}
+}
+
+func TestMongoDBAdapter_Stats(t *testing.T) {
+	r := &fakeRunner{outputs: map[string]string{}}
+	ad := newTestMongoDBAdapter(t, r)
+	cmd := "/opt/aipanel/runtime/mongodb/current/bin/mongosh --quiet --host 127.0.0.1 --username aipanel --password adminpw --authenticationDatabase admin --eval " +
+		`print(JSON.stringify(db.getSiblingDB("site_db").stats()));`
+	r.outputs[cmd] = `{"db":"site_db","collections":3,"objects":42,"dataSize":1024,"storageSize":4096,"indexSize":2048,"ok":1}` + "\n"
+
+	stats, err := ad.Stats(context.Background(), "site_db")
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if stats.Collections != 3 || stats.Objects != 42 || stats.DataSize != 1024 || stats.StorageSize != 4096 || stats.IndexSize != 2048 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestMongoDBAdapter_RequiresCredentials(t *testing.T) {
+	r := &fakeRunner{}
+	ad := NewMongoDBAdapter(r, MongoDBAdapterOptions{CredentialsFile: filepath.Join(t.TempDir(), "missing.json")})
+	if err := ad.CreateDatabase(context.Background(), "site_db"); err == nil || !strings.Contains(err.Error(), "credentials") {
+		t.Fatalf("expected missing credentials error, got %v", err)
+	}
+	if len(r.commands) != 0 {
+		t.Fatalf("expected no mongosh call without credentials, got %v", r.commands)
+	}
+}
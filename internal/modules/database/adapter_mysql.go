@@ -0,0 +1,188 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/platform/systemd"
+)
+
+const (
+	defaultMySQLBinaryPath = "/opt/aipanel/runtime/mysql/current/bin/mysql"
+	defaultMySQLService    = "aipanel-runtime-mysql.service"
+)
+
+// MySQLAdapterOptions controls runtime command paths used by the adapter.
+type MySQLAdapterOptions struct {
+	BinaryPath  string
+	ServiceName string
+}
+
+// MySQLAdapter executes MySQL (Oracle) commands through system runner.
+// Runtime data dirs are initialized with --initialize-insecure, so root
+// connects over the local socket without a password.
+type MySQLAdapter struct {
+	runner      systemd.Runner
+	binaryPath  string
+	serviceName string
+}
+
+// NewMySQLAdapter creates a MySQL adapter.
+func NewMySQLAdapter(runner systemd.Runner, opts ...MySQLAdapterOptions) *MySQLAdapter {
+	if runner == nil {
+		runner = systemd.ExecRunner{}
+	}
+	cfg := MySQLAdapterOptions{}
+	if len(opts) > 0 {
+		cfg = opts[0]
+	}
+	if strings.TrimSpace(cfg.BinaryPath) == "" {
+		cfg.BinaryPath = defaultMySQLBinaryPath
+	}
+	if strings.TrimSpace(cfg.ServiceName) == "" {
+		cfg.ServiceName = defaultMySQLService
+	}
+	return &MySQLAdapter{
+		runner:      runner,
+		binaryPath:  cfg.BinaryPath,
+		serviceName: cfg.ServiceName,
+	}
+}
+
+// CreateDatabase creates a MySQL database.
+func (a *MySQLAdapter) CreateDatabase(ctx context.Context, dbName string) error {
+	dbName = strings.TrimSpace(dbName)
+	if !mariadbNamePattern.MatchString(dbName) {
+		return fmt.Errorf("invalid database name")
+	}
+	sql := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s` CHARACTER SET utf8mb4 COLLATE utf8mb4_0900_ai_ci;", dbName)
+	if err := a.exec(ctx, sql); err != nil {
+		return fmt.Errorf("create database %s: %w", dbName, err)
+	}
+	return nil
+}
+
+// DropDatabase drops a MySQL database.
+func (a *MySQLAdapter) DropDatabase(ctx context.Context, dbName string) error {
+	dbName = strings.TrimSpace(dbName)
+	if !mariadbNamePattern.MatchString(dbName) {
+		return fmt.Errorf("invalid database name")
+	}
+	sql := fmt.Sprintf("DROP DATABASE IF EXISTS `%s`;", dbName)
+	if err := a.exec(ctx, sql); err != nil {
+		return fmt.Errorf("drop database %s: %w", dbName, err)
+	}
+	return nil
+}
+
+// CreateUser creates user and grants privileges for database.
+func (a *MySQLAdapter) CreateUser(ctx context.Context, username, password, dbName string) error {
+	username = strings.TrimSpace(username)
+	dbName = strings.TrimSpace(dbName)
+	if !mariadbNamePattern.MatchString(username) {
+		return fmt.Errorf("invalid username")
+	}
+	if !mariadbNamePattern.MatchString(dbName) {
+		return fmt.Errorf("invalid database name")
+	}
+	if strings.TrimSpace(password) == "" {
+		return fmt.Errorf("password is required")
+	}
+	password = strings.ReplaceAll(password, "\\", "\\\\")
+	password = strings.ReplaceAll(password, "'", "''")
+
+	// MySQL 8 applies account changes immediately; FLUSH PRIVILEGES is not needed.
+	sql := strings.Join([]string{
+		fmt.Sprintf("CREATE USER IF NOT EXISTS '%s'@'localhost' IDENTIFIED BY '%s';", username, password),
+		fmt.Sprintf("GRANT ALL PRIVILEGES ON `%s`.* TO '%s'@'localhost';", dbName, username),
+	}, " ")
+	if err := a.exec(ctx, sql); err != nil {
+		return fmt.Errorf("create user %s: %w", username, err)
+	}
+	return nil
+}
+
+// DropUser drops database user.
+func (a *MySQLAdapter) DropUser(ctx context.Context, username string) error {
+	username = strings.TrimSpace(username)
+	if !mariadbNamePattern.MatchString(username) {
+		return fmt.Errorf("invalid username")
+	}
+	sql := fmt.Sprintf("DROP USER IF EXISTS '%s'@'localhost';", username)
+	if err := a.exec(ctx, sql); err != nil {
+		return fmt.Errorf("drop user %s: %w", username, err)
+	}
+	return nil
+}
+
+// SetPassword replaces the password of an existing database user.
+func (a *MySQLAdapter) SetPassword(ctx context.Context, username, password string) error {
+	username = strings.TrimSpace(username)
+	if !mariadbNamePattern.MatchString(username) {
+		return fmt.Errorf("invalid username")
+	}
+	if strings.TrimSpace(password) == "" {
+		return fmt.Errorf("password is required")
+	}
+	password = strings.ReplaceAll(password, "\\", "\\\\")
+	password = strings.ReplaceAll(password, "'", "''")
+	sql := fmt.Sprintf("ALTER USER '%s'@'localhost' IDENTIFIED BY '%s';", username, password)
+	if err := a.exec(ctx, sql); err != nil {
+		return fmt.Errorf("set password for %s: %w", username, err)
+	}
+	return nil
+}
+
+// Dump writes a logical SQL dump of dbName to dest.
+func (a *MySQLAdapter) Dump(ctx context.Context, dbName, dest string) error {
+	dbName = strings.TrimSpace(dbName)
+	if !mariadbNamePattern.MatchString(dbName) {
+		return fmt.Errorf("invalid database name")
+	}
+	if _, err := a.runner.Run(ctx,
+		filepath.Join(filepath.Dir(a.binaryPath), "mysqldump"),
+		"--user=root",
+		"--single-transaction",
+		"--routines",
+		"--events",
+		"--result-file="+dest,
+		dbName,
+	); err != nil {
+		return fmt.Errorf("dump database %s: %w", dbName, err)
+	}
+	return nil
+}
+
+// Restore loads a dump written by Dump into dbName. MySQL grants are per
+// database, so owner is not needed.
+func (a *MySQLAdapter) Restore(ctx context.Context, dbName, _ string, src string) error {
+	dbName = strings.TrimSpace(dbName)
+	if !mariadbNamePattern.MatchString(dbName) {
+		return fmt.Errorf("invalid database name")
+	}
+	script := fmt.Sprintf("%s --user=root %s < %s", shellQuote(a.binaryPath), shellQuote(dbName), shellQuote(src))
+	if _, err := a.runner.Run(ctx, "bash", "-c", script); err != nil {
+		return fmt.Errorf("restore database %s: %w", dbName, err)
+	}
+	return nil
+}
+
+// IsRunning reports whether mysql unit is active.
+func (a *MySQLAdapter) IsRunning(ctx context.Context) (bool, error) {
+	out, err := a.runner.Run(ctx, "systemctl", "is-active", a.serviceName)
+	if err != nil {
+		trimmed := strings.TrimSpace(strings.ToLower(out + " " + err.Error()))
+		if strings.Contains(trimmed, "inactive") || strings.Contains(trimmed, "failed") || strings.Contains(trimmed, "unknown") {
+			return false, nil
+		}
+		return false, err
+	}
+	return strings.TrimSpace(out) == "active", nil
+}
+
+func (a *MySQLAdapter) exec(ctx context.Context, sql string) error {
+	_, err := a.runner.Run(ctx, a.binaryPath, "--user=root", "-e", sql)
+	return err
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMySQLAdapter_CommandSequence(t *testing.T) {
+	r := &fakeRunner{}
+	ad := NewMySQLAdapter(r)
+
+	if err := ad.CreateDatabase(context.Background(), "site_db"); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	if err := ad.CreateUser(context.Background(), "site_user", "sec'ret", "site_db"); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if err := ad.DropUser(context.Background(), "site_user"); err != nil {
+		t.Fatalf("drop user: %v", err)
+	}
+	if err := ad.DropDatabase(context.Background(), "site_db"); err != nil {
+		t.Fatalf("drop db: %v", err)
+	}
+
+	joined := strings.Join(r.commands, "\n")
+	if !strings.Contains(joined, "/opt/aipanel/runtime/mysql/current/bin/mysql --user=root -e CREATE DATABASE IF NOT EXISTS `site_db` CHARACTER SET utf8mb4 COLLATE utf8mb4_0900_ai_ci;") {
+		t.Fatalf("missing create database command:\n%s", joined)
+	}
+	if !strings.Contains(joined, "IDENTIFIED BY 'sec''ret'; GRANT ALL PRIVILEGES ON `site_db`.* TO 'site_user'@'localhost';") {
+		t.Fatalf("missing create user/grant command:\n%s", joined)
+	}
+	if strings.Contains(joined, "FLUSH PRIVILEGES") {
+		t.Fatalf("unexpected FLUSH PRIVILEGES for mysql:\n%s", joined)
+	}
+	if !strings.Contains(joined, "DROP DATABASE IF EXISTS `site_db`;") {
+		t.Fatalf("missing drop database command:\n%s", joined)
+	}
+}
+
+func TestMySQLAdapter_IsRunning(t *testing.T) {
+	r := &fakeRunner{
+		outputs: map[string]string{"systemctl is-active aipanel-runtime-mysql.service": "inactive"},
+		errs:    map[string]error{"systemctl is-active aipanel-runtime-mysql.service": fmt.Errorf("exit status 3")},
+	}
+	running, err := NewMySQLAdapter(r).IsRunning(context.Background())
+	if err != nil || running {
+		t.Fatalf("expected inactive mysql, got running=%t err=%v", running, err)
+	}
+}
@@ -3,8 +3,11 @@ package database
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/robsonek/aiPanel/internal/platform/systemd"
 )
@@ -15,21 +18,30 @@ const (
 	defaultPostgreSQLCommandPath = "/opt/aipanel/runtime/postgresql/current/bin/psql"
 	defaultPostgreSQLService     = "aipanel-runtime-postgresql.service"
 	defaultPostgreSQLUser        = "postgres"
+	defaultPostgreSQLWALArchive  = "/var/lib/aipanel/runtime/postgresql-wal"
+	defaultPostgreSQLScratchDir  = "/var/lib/aipanel/runtime/postgresql-pitr"
+	// pitrRecoveryPort is where the temporary recovery cluster listens;
+	// it only binds a unix socket inside the scratch dir.
+	pitrRecoveryPort = 54329
 )
 
 // PostgreSQLAdapterOptions controls runtime command paths used by the adapter.
 type PostgreSQLAdapterOptions struct {
-	CommandPath string
-	ServiceName string
-	RunAsUser   string
+	CommandPath   string
+	ServiceName   string
+	RunAsUser     string
+	WALArchiveDir string
+	ScratchDir    string
 }
 
 // PostgreSQLAdapter executes PostgreSQL commands through system runner.
 type PostgreSQLAdapter struct {
-	runner      systemd.Runner
-	commandPath string
-	serviceName string
-	runAsUser   string
+	runner        systemd.Runner
+	commandPath   string
+	serviceName   string
+	runAsUser     string
+	walArchiveDir string
+	scratchDir    string
 }
 
 // NewPostgreSQLAdapter creates a PostgreSQL adapter.
@@ -50,11 +62,19 @@ func NewPostgreSQLAdapter(runner systemd.Runner, opts ...PostgreSQLAdapterOption
 	if strings.TrimSpace(cfg.RunAsUser) == "" {
 		cfg.RunAsUser = defaultPostgreSQLUser
 	}
+	if strings.TrimSpace(cfg.WALArchiveDir) == "" {
+		cfg.WALArchiveDir = defaultPostgreSQLWALArchive
+	}
+	if strings.TrimSpace(cfg.ScratchDir) == "" {
+		cfg.ScratchDir = defaultPostgreSQLScratchDir
+	}
 	return &PostgreSQLAdapter{
-		runner:      runner,
-		commandPath: cfg.CommandPath,
-		serviceName: cfg.ServiceName,
-		runAsUser:   cfg.RunAsUser,
+		runner:        runner,
+		commandPath:   cfg.CommandPath,
+		serviceName:   cfg.ServiceName,
+		runAsUser:     cfg.RunAsUser,
+		walArchiveDir: cfg.WALArchiveDir,
+		scratchDir:    cfg.ScratchDir,
 	}
 }
 
@@ -138,6 +158,146 @@ func (a *PostgreSQLAdapter) DropUser(ctx context.Context, username string) error
 	return nil
 }
 
+// SetPassword replaces the password of an existing PostgreSQL role.
+func (a *PostgreSQLAdapter) SetPassword(ctx context.Context, username, password string) error {
+	username = strings.TrimSpace(username)
+	if !postgresNamePattern.MatchString(username) {
+		return fmt.Errorf("invalid username")
+	}
+	if strings.TrimSpace(password) == "" {
+		return fmt.Errorf("password is required")
+	}
+	password = strings.ReplaceAll(password, "\\", "\\\\")
+	password = strings.ReplaceAll(password, "'", "''")
+	sql := fmt.Sprintf("ALTER ROLE \"%s\" PASSWORD '%s';", username, password)
+	if err := a.runPSQL(ctx, sql); err != nil {
+		return fmt.Errorf("set password for %s: %w", username, err)
+	}
+	return nil
+}
+
+// Dump writes a custom-format pg_dump archive of dbName to dest. pg_dump
+// runs as the postgres user, so the root shell writes the output file.
+func (a *PostgreSQLAdapter) Dump(ctx context.Context, dbName, dest string) error {
+	dbName = strings.TrimSpace(dbName)
+	if !postgresNamePattern.MatchString(dbName) {
+		return fmt.Errorf("invalid database name")
+	}
+	pgDump := a.binPath("pg_dump")
+	script := fmt.Sprintf("runuser -u %s -- %s --format=custom %s > %s",
+		shellQuote(a.runAsUser), shellQuote(pgDump), shellQuote(dbName), shellQuote(dest))
+	if _, err := a.runner.Run(ctx, "bash", "-c", script); err != nil {
+		return fmt.Errorf("dump database %s: %w", dbName, err)
+	}
+	return nil
+}
+
+// Restore loads a pg_dump archive into dbName. Objects are recreated as
+// owner instead of their original owners, so the clone belongs to its own
+// database user.
+func (a *PostgreSQLAdapter) Restore(ctx context.Context, dbName, owner, src string) error {
+	dbName = strings.TrimSpace(dbName)
+	owner = strings.TrimSpace(owner)
+	if !postgresNamePattern.MatchString(dbName) {
+		return fmt.Errorf("invalid database name")
+	}
+	if !postgresNamePattern.MatchString(owner) {
+		return fmt.Errorf("invalid username")
+	}
+	script := fmt.Sprintf("runuser -u %s -- %s --no-owner --no-privileges --role=%s --exit-on-error -d %s < %s",
+		shellQuote(a.runAsUser), shellQuote(a.binPath("pg_restore")), shellQuote(owner), shellQuote(dbName), shellQuote(src))
+	if _, err := a.runner.Run(ctx, "bash", "-c", script); err != nil {
+		return fmt.Errorf("restore database %s: %w", dbName, err)
+	}
+	return nil
+}
+
+// BaseBackup writes a gzipped tar base backup of the whole cluster to dest,
+// including the WAL needed to make it consistent.
+func (a *PostgreSQLAdapter) BaseBackup(ctx context.Context, dest string) error {
+	script := fmt.Sprintf("set -o pipefail; runuser -u %s -- %s --pgdata=- --format=tar --wal-method=fetch --gzip > %s",
+		shellQuote(a.runAsUser), shellQuote(a.binPath("pg_basebackup")), shellQuote(dest))
+	if _, err := a.runner.Run(ctx, "bash", "-c", script); err != nil {
+		return fmt.Errorf("base backup: %w", err)
+	}
+	return nil
+}
+
+// RestoreToTime recovers the base backup into a temporary cluster, replays
+// archived WAL up to target and restores dbName from it into the live
+// cluster. Other databases are not touched.
+func (a *PostgreSQLAdapter) RestoreToTime(ctx context.Context, dbName, base string, target time.Time) error {
+	dbName = strings.TrimSpace(dbName)
+	if !postgresNamePattern.MatchString(dbName) {
+		return fmt.Errorf("invalid database name")
+	}
+	runAs := "runuser -u " + shellQuote(a.runAsUser) + " --"
+	pgCtl := shellQuote(a.binPath("pg_ctl"))
+	recoveryConf := strings.Join([]string{
+		fmt.Sprintf("port = %d", pitrRecoveryPort),
+		"listen_addresses = ''",
+		`unix_socket_directories = '$scratch'`,
+		"archive_mode = off",
+		fmt.Sprintf("restore_command = 'cp %s/%%f %%p'", a.walArchiveDir),
+		fmt.Sprintf("recovery_target_time = '%s'", target.UTC().Format("2006-01-02 15:04:05-07")),
+		"recovery_target_action = 'promote'",
+	}, "\n")
+	script := strings.Join([]string{
+		"set -euo pipefail",
+		fmt.Sprintf("install -d -m 0755 %s", shellQuote(a.scratchDir)),
+		fmt.Sprintf("scratch=$(mktemp -d %s)", shellQuote(filepath.Join(a.scratchDir, "restore-XXXXXX"))),
+		fmt.Sprintf(`trap '%s %s -D "$scratch/data" -m fast stop >/dev/null 2>&1 || true; rm -rf "$scratch"' EXIT`, runAs, pgCtl),
+		`install -d -m 0700 "$scratch/data"`,
+		fmt.Sprintf(`tar -xzf %s -C "$scratch/data"`, shellQuote(base)),
+		`cat >> "$scratch/data/postgresql.auto.conf" <<CONF`,
+		recoveryConf,
+		"CONF",
+		`touch "$scratch/data/recovery.signal"`,
+		fmt.Sprintf(`chown -R %s "$scratch"`, shellQuote(a.runAsUser)),
+		fmt.Sprintf(`%s %s -D "$scratch/data" -l "$scratch/recovery.log" -w -t 3600 start`, runAs, pgCtl),
+		"state=",
+		"for _ in $(seq 1 1800); do",
+		fmt.Sprintf(`  state=$(%s %s -h "$scratch" -p %d -d postgres -Atc 'SELECT pg_is_in_recovery()' 2>/dev/null || echo down)`,
+			runAs, shellQuote(a.commandPath), pitrRecoveryPort),
+		`  if [ "$state" != t ]; then break; fi`,
+		"  sleep 2",
+		"done",
+		`if [ "$state" != f ]; then cat "$scratch/recovery.log" >&2; exit 1; fi`,
+		fmt.Sprintf(`%s %s -h "$scratch" -p %d --format=custom %s > "$scratch/db.dump"`,
+			runAs, shellQuote(a.binPath("pg_dump")), pitrRecoveryPort, shellQuote(dbName)),
+		fmt.Sprintf(`%s %s --clean --if-exists --single-transaction -d %s < "$scratch/db.dump"`,
+			runAs, shellQuote(a.binPath("pg_restore")), shellQuote(dbName)),
+	}, "\n")
+	if _, err := a.runner.Run(ctx, "bash", "-c", script); err != nil {
+		return fmt.Errorf("restore database %s to %s: %w", dbName, target.UTC().Format(time.RFC3339), err)
+	}
+	return nil
+}
+
+// PruneArchive removes archived WAL segments written before before.
+func (a *PostgreSQLAdapter) PruneArchive(_ context.Context, before time.Time) error {
+	entries, err := os.ReadDir(a.walArchiveDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read wal archive: %w", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || !info.ModTime().Before(before) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(a.walArchiveDir, e.Name())); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("prune wal archive: %w", err)
+		}
+	}
+	return nil
+}
+
 // IsRunning reports whether postgresql unit is active.
 func (a *PostgreSQLAdapter) IsRunning(ctx context.Context) (bool, error) {
 	out, err := a.runner.Run(ctx, "systemctl", "is-active", a.serviceName)
@@ -163,3 +323,11 @@ func (a *PostgreSQLAdapter) runPSQL(ctx context.Context, sql string) error {
 	}
 	return nil
 }
+
+func (a *PostgreSQLAdapter) binPath(name string) string {
+	return filepath.Join(filepath.Dir(a.commandPath), name)
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'"'"'`) + "'"
+}
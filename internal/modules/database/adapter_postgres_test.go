@@ -70,3 +70,28 @@ func TestPostgreSQLAdapter_IsRunningInactive(t *testing.T) {
 		t.Fatal("expected running status false")
 	}
 }
+
+func TestPostgreSQLAdapter_DumpRunsAsPostgres(t *testing.T) {
+	r := &fakeRunner{}
+	if err := NewPostgreSQLAdapter(r).Dump(context.Background(), "site_db", "/var/lib/aipanel/backups/databases/1/site_db.dump"); err != nil {
+		t.Fatalf("dump: %v", err)
+	}
+	want := "bash -c runuser -u 'postgres' -- '/opt/aipanel/runtime/postgresql/current/bin/pg_dump' --format=custom 'site_db' > '/var/lib/aipanel/backups/databases/1/site_db.dump'"
+	if len(r.commands) != 1 || r.commands[0] != want {
+		t.Fatalf("expected %q, got %v", want, r.commands)
+	}
+}
+
+func TestPostgreSQLAdapter_RestoreRecreatesObjectsAsOwner(t *testing.T) {
+	r := &fakeRunner{}
+	if err := NewPostgreSQLAdapter(r).Restore(context.Background(), "shop_staging", "u_shop_staging", "/tmp/shop.dump"); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	want := "bash -c runuser -u 'postgres' -- '/opt/aipanel/runtime/postgresql/current/bin/pg_restore' --no-owner --no-privileges --role='u_shop_staging' --exit-on-error -d 'shop_staging' < '/tmp/shop.dump'"
+	if len(r.commands) != 1 || r.commands[0] != want {
+		t.Fatalf("expected %q, got %v", want, r.commands)
+	}
+	if err := NewPostgreSQLAdapter(r).Restore(context.Background(), "shop_staging", "bad owner", "/tmp/shop.dump"); err == nil {
+		t.Fatal("expected invalid owner to be rejected")
+	}
+}
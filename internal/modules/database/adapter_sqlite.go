@@ -0,0 +1,119 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/platform/systemd"
+)
+
+const defaultSQLiteBinaryPath = "sqlite3"
+
+// SQLiteFileAdapterOptions controls command paths used by the adapter.
+type SQLiteFileAdapterOptions struct {
+	BinaryPath string
+}
+
+// SQLiteFileAdapter manages SQLite database files owned by site users.
+// There is no server: PHP-FPM opens the file directly as the site user, so
+// the file and its directory must belong to that user.
+type SQLiteFileAdapter struct {
+	runner     systemd.Runner
+	binaryPath string
+}
+
+// NewSQLiteFileAdapter creates an SQLite file adapter.
+func NewSQLiteFileAdapter(runner systemd.Runner, opts ...SQLiteFileAdapterOptions) *SQLiteFileAdapter {
+	if runner == nil {
+		runner = systemd.ExecRunner{}
+	}
+	cfg := SQLiteFileAdapterOptions{}
+	if len(opts) > 0 {
+		cfg = opts[0]
+	}
+	if strings.TrimSpace(cfg.BinaryPath) == "" {
+		cfg.BinaryPath = defaultSQLiteBinaryPath
+	}
+	return &SQLiteFileAdapter{
+		runner:     runner,
+		binaryPath: cfg.BinaryPath,
+	}
+}
+
+// CreateFile creates an empty database file owned by owner. A zero-length
+// file is a valid empty SQLite database.
+func (a *SQLiteFileAdapter) CreateFile(ctx context.Context, path, owner string) error {
+	if !mariadbNamePattern.MatchString(owner) {
+		return fmt.Errorf("invalid owner")
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("create database dir: %w", err)
+	}
+	// Path is derived from the site root and a validated database name.
+	//nolint:gosec // G304
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("database file %s already exists", filepath.Base(path))
+		}
+		return fmt.Errorf("create database file: %w", err)
+	}
+	_ = f.Close()
+	// SQLite writes journal files next to the database, so the site user
+	// needs to own the whole directory, not just the file.
+	if _, err := a.runner.Run(ctx, "chown", "-R", owner+":"+owner, filepath.Dir(dir)); err != nil {
+		_ = os.Remove(path)
+		return fmt.Errorf("set database file owner: %w", err)
+	}
+	if _, err := a.runner.Run(ctx, "chmod", "0700", filepath.Dir(dir), dir); err != nil {
+		_ = os.Remove(path)
+		return fmt.Errorf("set database dir permissions: %w", err)
+	}
+	return nil
+}
+
+// RemoveFile removes a database file together with its journal files.
+func (a *SQLiteFileAdapter) RemoveFile(_ context.Context, path string) error {
+	for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+		if err := os.Remove(path + suffix); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove database file: %w", err)
+		}
+	}
+	return nil
+}
+
+// Backup writes a consistent copy of path to dest using the online backup
+// API, which is safe while the site keeps writing. An empty owner leaves
+// dest owned by root.
+func (a *SQLiteFileAdapter) Backup(ctx context.Context, path, dest, owner string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o700); err != nil {
+		return fmt.Errorf("create backup dir: %w", err)
+	}
+	cmd := fmt.Sprintf(".backup '%s'", strings.ReplaceAll(dest, "'", "''"))
+	if out, err := a.runner.Run(ctx, a.binaryPath, path, cmd); err != nil {
+		return fmt.Errorf("backup %s: %s", filepath.Base(path), commandDetail(err, out))
+	}
+	if owner == "" {
+		return nil
+	}
+	if !mariadbNamePattern.MatchString(owner) {
+		return fmt.Errorf("invalid owner")
+	}
+	if _, err := a.runner.Run(ctx, "chown", owner+":"+owner, filepath.Dir(dest), dest); err != nil {
+		return fmt.Errorf("set backup owner: %w", err)
+	}
+	return nil
+}
+
+func commandDetail(err error, out string) string {
+	out = strings.TrimSpace(out)
+	if out == "" {
+		return err.Error()
+	}
+	return err.Error() + ": " + out
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/systemd"
+)
+
+// sqliteTestRunner runs sqlite3 for real and records ownership changes.
+type sqliteTestRunner struct {
+	fakeRunner
+}
+
+func (r *sqliteTestRunner) Run(ctx context.Context, name string, args ...string) (string, error) {
+	if name == "sqlite3" {
+		return systemd.ExecRunner{}.Run(ctx, name, args...)
+	}
+	return r.fakeRunner.Run(ctx, name, args...)
+}
+
+func TestSQLiteFileAdapter_CreateBackupRemove(t *testing.T) {
+	r := &sqliteTestRunner{}
+	ad := NewSQLiteFileAdapter(r)
+	siteDir := t.TempDir()
+	path := filepath.Join(siteDir, "private", "databases", "app.sqlite")
+
+	if err := ad.CreateFile(context.Background(), path, "site_example_com"); err != nil {
+		t.Fatalf("create file: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected 0600 database file, got %v err=%v", info, err)
+	}
+	wantChown := "chown -R site_example_com:site_example_com " + filepath.Join(siteDir, "private")
+	if !strings.Contains(strings.Join(r.commands, "\n"), wantChown) {
+		t.Fatalf("expected %q, got %v", wantChown, r.commands)
+	}
+	if err := ad.CreateFile(context.Background(), path, "site_example_com"); err == nil {
+		t.Fatal("expected existing database file to be kept")
+	}
+	if err := ad.CreateFile(context.Background(), filepath.Join(siteDir, "x.sqlite"), "bad owner"); err == nil {
+		t.Fatal("expected invalid owner to be rejected")
+	}
+
+	if _, err := (systemd.ExecRunner{}).Run(context.Background(), "sqlite3", path, "CREATE TABLE t(v TEXT); INSERT INTO t VALUES('kept');"); err != nil {
+		t.Fatalf("seed database: %v", err)
+	}
+	dest := filepath.Join(siteDir, "private", "databases", "backups", "app-1.sqlite")
+	if err := ad.Backup(context.Background(), path, dest, "site_example_com"); err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+	out, err := (systemd.ExecRunner{}).Run(context.Background(), "sqlite3", dest, "SELECT v FROM t;")
+	if err != nil || strings.TrimSpace(out) != "kept" {
+		t.Fatalf("expected backup to contain seeded row, got %q err=%v", out, err)
+	}
+
+	if err := ad.RemoveFile(context.Background(), path); err != nil {
+		t.Fatalf("remove file: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected database file to be removed, got %v", err)
+	}
+	if _, err := os.Stat(dest); err != nil {
+		t.Fatalf("expected backup to be kept: %v", err)
+	}
+}
@@ -0,0 +1,391 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/modules/backup"
+	"github.com/robsonek/aiPanel/internal/platform/heartbeat"
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/internal/platform/settings"
+)
+
+// BackupDatabaseJob is the job type that dumps one database.
+const BackupDatabaseJob = "database.backup"
+
+const (
+	minBackupInterval = 15
+	maxBackupInterval = 7 * 24 * 60
+	defaultRetention  = 7
+	maxRetention      = 365
+)
+
+// ErrBackupScheduleNotFound indicates a database without a backup schedule.
+var ErrBackupScheduleNotFound = errors.New("backup schedule not found")
+
+// BackupSchedule is a logical dump cadence attached to one database,
+// independent of site file backups.
+type BackupSchedule struct {
+	DatabaseID      int64     `json:"database_id"`
+	IntervalMinutes int       `json:"interval_minutes"`
+	Retention       int       `json:"retention"`
+	Enabled         bool      `json:"enabled"`
+	NextRunAt       time.Time `json:"next_run_at"`
+	LastRunAt       time.Time `json:"last_run_at,omitzero"`
+	LastError       string    `json:"last_error,omitempty"`
+	HeartbeatURL    string    `json:"heartbeat_url,omitempty"`
+}
+
+// BackupScheduleRequest sets a database backup schedule. Retention is the
+// number of dumps kept and defaults to 7. HeartbeatURL, when set, is
+// pinged after every scheduled dump (see package heartbeat).
+type BackupScheduleRequest struct {
+	IntervalMinutes int    `json:"interval_minutes"`
+	Retention       int    `json:"retention"`
+	Enabled         *bool  `json:"enabled"`
+	HeartbeatURL    string `json:"heartbeat_url"`
+	Actor           string `json:"-"`
+}
+
+// DatabaseDump is one stored dump file.
+type DatabaseDump struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type backupPayload struct {
+	DatabaseID int64 `json:"database_id"`
+}
+
+// RegisterJobs registers database job handlers and keeps q for enqueueing.
+func (s *Service) RegisterJobs(q *jobqueue.Queue) {
+	s.jobs = q
+	q.Register(BackupDatabaseJob, s.runBackupJob)
+	q.Register(RestoreDatabaseJob, s.runRestoreJob)
+}
+
+// GetBackupSchedule returns the backup schedule of a database.
+func (s *Service) GetBackupSchedule(ctx context.Context, id int64) (BackupSchedule, error) {
+	if s.store == nil {
+		return BackupSchedule{}, fmt.Errorf("database service is not configured")
+	}
+	if _, err := s.getByID(ctx, id); err != nil {
+		return BackupSchedule{}, err
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT database_id, interval_minutes, retention, enabled, next_run_at, last_run_at, last_error, heartbeat_url
+FROM database_backup_schedules
+WHERE database_id = %d;`, id))
+	if err != nil {
+		return BackupSchedule{}, fmt.Errorf("get backup schedule: %w", err)
+	}
+	if len(rows) == 0 {
+		return BackupSchedule{}, ErrBackupScheduleNotFound
+	}
+	return mapRowToBackupSchedule(rows[0])
+}
+
+// SetBackupSchedule creates or replaces the backup schedule of a database.
+// The first dump runs one interval from now.
+func (s *Service) SetBackupSchedule(ctx context.Context, id int64, req BackupScheduleRequest) (BackupSchedule, error) {
+	if s.store == nil {
+		return BackupSchedule{}, fmt.Errorf("database service is not configured")
+	}
+	db, err := s.getByID(ctx, id)
+	if err != nil {
+		return BackupSchedule{}, err
+	}
+	if req.IntervalMinutes < minBackupInterval || req.IntervalMinutes > maxBackupInterval {
+		return BackupSchedule{}, fmt.Errorf("interval_minutes must be between %d and %d", minBackupInterval, maxBackupInterval)
+	}
+	if req.Retention == 0 {
+		req.Retention = defaultRetention
+		if s.settings != nil {
+			req.Retention = s.settings.Int(ctx, settings.Backup, "retention")
+		}
+	}
+	if req.Retention < 1 || req.Retention > maxRetention {
+		return BackupSchedule{}, fmt.Errorf("retention must be between 1 and %d", maxRetention)
+	}
+	req.HeartbeatURL = strings.TrimSpace(req.HeartbeatURL)
+	if err := heartbeat.Validate(req.HeartbeatURL); err != nil {
+		return BackupSchedule{}, err
+	}
+	enabled := 1
+	if req.Enabled != nil && !*req.Enabled {
+		enabled = 0
+	}
+	now := time.Now()
+	next := now.Add(time.Duration(req.IntervalMinutes) * time.Minute).Unix()
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO database_backup_schedules(database_id, interval_minutes, retention, enabled, heartbeat_url, next_run_at, updated_at)
+VALUES(%d,%d,%d,%d,'%s',%d,%d)
+ON CONFLICT(database_id) DO UPDATE SET
+  interval_minutes=excluded.interval_minutes,
+  retention=excluded.retention,
+  enabled=excluded.enabled,
+  heartbeat_url=excluded.heartbeat_url,
+  next_run_at=excluded.next_run_at,
+  updated_at=excluded.updated_at;`,
+		id, req.IntervalMinutes, req.Retention, enabled, sqlEscape(req.HeartbeatURL), next, now.Unix())); err != nil {
+		return BackupSchedule{}, fmt.Errorf("save backup schedule: %w", err)
+	}
+	_ = s.writeAudit(ctx, req.Actor, "database.backup_schedule.set", fmt.Sprintf(
+		"db=%s,engine=%s,interval=%d,retention=%d,enabled=%t", db.DBName, db.DBEngine, req.IntervalMinutes, req.Retention, enabled == 1))
+	return s.GetBackupSchedule(ctx, id)
+}
+
+// DeleteBackupSchedule removes the backup schedule of a database. Existing
+// dumps are kept.
+func (s *Service) DeleteBackupSchedule(ctx context.Context, id int64, actor string) error {
+	if _, err := s.GetBackupSchedule(ctx, id); err != nil {
+		return err
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(
+		"DELETE FROM database_backup_schedules WHERE database_id = %d;", id)); err != nil {
+		return fmt.Errorf("delete backup schedule: %w", err)
+	}
+	_ = s.writeAudit(ctx, actor, "database.backup_schedule.delete", fmt.Sprintf("database_id=%d", id))
+	return nil
+}
+
+// ListDumps returns stored dumps of a database, newest first.
+func (s *Service) ListDumps(ctx context.Context, id int64) ([]DatabaseDump, error) {
+	if s.store == nil {
+		return nil, fmt.Errorf("database service is not configured")
+	}
+	if _, err := s.getByID(ctx, id); err != nil {
+		return nil, err
+	}
+	return listDumps(s.dumpDir(id))
+}
+
+// RunDueBackups enqueues one backup job per database whose schedule is due
+// and moves its next run forward, so a slow dump is never queued twice.
+func (s *Service) RunDueBackups(ctx context.Context) (int, error) {
+	if s.jobs == nil {
+		return 0, fmt.Errorf("job queue is not configured")
+	}
+	now := time.Now().Unix()
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT database_id, interval_minutes
+FROM database_backup_schedules
+WHERE enabled = 1 AND next_run_at <= %d
+ORDER BY next_run_at;`, now))
+	if err != nil {
+		return 0, fmt.Errorf("list due backups: %w", err)
+	}
+	queued := 0
+	for _, row := range rows {
+		id, err := toInt64(row["database_id"])
+		if err != nil {
+			return queued, fmt.Errorf("parse schedule database_id: %w", err)
+		}
+		interval, err := toInt64(row["interval_minutes"])
+		if err != nil {
+			return queued, fmt.Errorf("parse schedule interval: %w", err)
+		}
+		if err := s.store.ExecPanel(ctx, fmt.Sprintf(
+			"UPDATE database_backup_schedules SET next_run_at = %d WHERE database_id = %d;",
+			now+interval*60, id)); err != nil {
+			return queued, fmt.Errorf("advance backup schedule: %w", err)
+		}
+		if _, err := s.jobs.Enqueue(ctx, BackupDatabaseJob, backupPayload{DatabaseID: id}); err != nil {
+			return queued, err
+		}
+		queued++
+	}
+	return queued, nil
+}
+
+func (s *Service) runBackupJob(ctx context.Context, job jobqueue.Job) error {
+	var payload backupPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("decode backup payload: %w", err)
+	}
+	schedule, err := s.GetBackupSchedule(ctx, payload.DatabaseID)
+	if err != nil {
+		if errors.Is(err, ErrDatabaseNotFound) || errors.Is(err, ErrBackupScheduleNotFound) {
+			// Removed after the job was queued; nothing to do.
+			return nil
+		}
+		return err
+	}
+	started := time.Now()
+	dest, runErr := s.dumpDatabase(ctx, payload.DatabaseID, schedule.Retention)
+	s.recordBackupRun(ctx, payload.DatabaseID, started, dest, runErr)
+	lastError := ""
+	if runErr != nil {
+		lastError = runErr.Error()
+	}
+	if err := s.store.ExecPanel(context.WithoutCancel(ctx), fmt.Sprintf(
+		"UPDATE database_backup_schedules SET last_run_at = %d, last_error = '%s' WHERE database_id = %d;",
+		time.Now().Unix(), sqlEscape(lastError), payload.DatabaseID)); err != nil {
+		s.log.Error("record database backup", "database_id", payload.DatabaseID, "error", err.Error())
+	}
+	if schedule.HeartbeatURL != "" && s.ping != nil {
+		if err := s.ping(ctx, schedule.HeartbeatURL, runErr); err != nil {
+			s.log.Warn("heartbeat ping failed", "database_id", payload.DatabaseID, "error", err.Error())
+		}
+	}
+	return runErr
+}
+
+// dumpDatabase writes one dump, encrypted to the backup keyring, with a
+// checksum sidecar and prunes dumps beyond retention. It returns the dump
+// path.
+func (s *Service) dumpDatabase(ctx context.Context, id int64, retention int) (string, error) {
+	db, err := s.getByID(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	dir := s.dumpDir(id)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("create dump dir: %w", err)
+	}
+	dest := filepath.Join(dir, fmt.Sprintf("%s-%s.%s",
+		db.DBName, time.Now().UTC().Format("20060102-150405"), dumpExtension(db.DBEngine)))
+
+	if db.DBEngine == DBEngineSQLite {
+		if s.sqlite == nil {
+			return "", fmt.Errorf("database engine sqlite is not configured")
+		}
+		site, err := s.sqliteSiteByID(ctx, db.SiteID)
+		if err != nil {
+			return "", err
+		}
+		err = s.sqlite.Backup(ctx, sqliteDatabasePath(site.rootDir, db.DBName), dest, "")
+		if err != nil {
+			return "", err
+		}
+	} else {
+		provisioner, err := s.provisionerForEngine(db.DBEngine)
+		if err != nil {
+			return "", err
+		}
+		if err := provisioner.Dump(ctx, db.DBName, dest); err != nil {
+			_ = os.Remove(dest)
+			return "", err
+		}
+	}
+	if err := s.encryptBackup(ctx, dest); err != nil {
+		return "", err
+	}
+	if err := backup.WriteChecksum(dest); err != nil {
+		return "", err
+	}
+	_ = s.writeAudit(ctx, "system", "database.backup", "db="+db.DBName+",engine="+db.DBEngine)
+	return dest, pruneDumps(dir, retention)
+}
+
+// recordBackupRun adds a scheduled dump to the backup run history.
+func (s *Service) recordBackupRun(ctx context.Context, id int64, started time.Time, dest string, runErr error) {
+	run := backup.BackupRun{
+		Kind:       backup.KindDatabase,
+		Target:     fmt.Sprintf("database %d", id),
+		DatabaseID: id,
+		Trigger:    backup.TriggerScheduled,
+		StartedAt:  started,
+	}
+	if db, err := s.getByID(ctx, id); err == nil {
+		run.Target, run.SiteID = db.DBName, db.SiteID
+	}
+	if dest != "" {
+		run.Location = filepath.Base(dest)
+		if info, err := os.Stat(dest); err == nil {
+			run.Bytes = info.Size()
+		}
+	}
+	if err := backup.RecordRun(ctx, s.store, run, runErr); err != nil {
+		s.log.Error("record database backup run", "database_id", id, "error", err.Error())
+	}
+}
+
+func (s *Service) dumpDir(id int64) string {
+	return filepath.Join(backup.Dir(s.store.DataDir), "databases", strconv.FormatInt(id, 10))
+}
+
+func dumpExtension(engine string) string {
+	switch engine {
+	case DBEnginePostgreSQL:
+		return "dump"
+	case DBEngineMongoDB:
+		return "archive.gz"
+	case DBEngineSQLite:
+		return "sqlite"
+	default:
+		return "sql"
+	}
+}
+
+func listDumps(dir string) ([]DatabaseDump, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []DatabaseDump{}, nil
+		}
+		return nil, fmt.Errorf("read dump dir: %w", err)
+	}
+	dumps := make([]DatabaseDump, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || strings.HasSuffix(e.Name(), backup.ChecksumSuffix) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		dumps = append(dumps, DatabaseDump{Name: e.Name(), Size: info.Size(), CreatedAt: info.ModTime().UTC()})
+	}
+	// Names embed a UTC timestamp, so they sort chronologically.
+	sort.Slice(dumps, func(i, j int) bool { return dumps[i].Name > dumps[j].Name })
+	return dumps, nil
+}
+
+func pruneDumps(dir string, retention int) error {
+	dumps, err := listDumps(dir)
+	if err != nil {
+		return err
+	}
+	for i := retention; i < len(dumps); i++ {
+		path := filepath.Join(dir, dumps[i].Name)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("prune dump: %w", err)
+		}
+		_ = os.Remove(path + backup.ChecksumSuffix)
+	}
+	return nil
+}
+
+func mapRowToBackupSchedule(row map[string]any) (BackupSchedule, error) {
+	fields := map[string]int64{}
+	for _, key := range []string{"database_id", "interval_minutes", "retention", "enabled", "next_run_at", "last_run_at"} {
+		v, err := toInt64(row[key])
+		if err != nil {
+			return BackupSchedule{}, fmt.Errorf("parse backup schedule %s: %w", key, err)
+		}
+		fields[key] = v
+	}
+	schedule := BackupSchedule{
+		DatabaseID:      fields["database_id"],
+		IntervalMinutes: int(fields["interval_minutes"]),
+		Retention:       int(fields["retention"]),
+		Enabled:         fields["enabled"] == 1,
+		NextRunAt:       time.Unix(fields["next_run_at"], 0).UTC(),
+		LastError:       fmt.Sprint(row["last_error"]),
+	}
+	schedule.HeartbeatURL, _ = row["heartbeat_url"].(string)
+	if fields["last_run_at"] > 0 {
+		schedule.LastRunAt = time.Unix(fields["last_run_at"], 0).UTC()
+	}
+	return schedule, nil
+}
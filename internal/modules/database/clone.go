@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrCloneIncompatible indicates a clone into an engine that cannot load
+// the source dump.
+var ErrCloneIncompatible = errors.New("databases can only be cloned within the same engine family")
+
+// engineFamilies groups engines that load each other's dumps.
+var engineFamilies = map[string]string{
+	DBEngineMariaDB:    "mysql",
+	DBEngineMySQL:      "mysql",
+	DBEnginePostgreSQL: "postgres",
+	DBEngineMongoDB:    "mongodb",
+	DBEngineSQLite:     "sqlite",
+}
+
+// CloneDatabase dumps a database and restores it into a new database with
+// its own user and fresh password, typically for a staging copy of a site.
+// The new database is removed again when the restore fails.
+func (s *Service) CloneDatabase(ctx context.Context, id int64, req CloneDatabaseRequest) (CreateDatabaseResult, error) {
+	if s.store == nil {
+		return CreateDatabaseResult{}, fmt.Errorf("database service is not fully configured")
+	}
+	src, err := s.getByID(ctx, id)
+	if err != nil {
+		return CreateDatabaseResult{}, err
+	}
+	if req.SiteID == 0 {
+		req.SiteID = src.SiteID
+	}
+	if req.DBEngine == "" {
+		req.DBEngine = src.DBEngine
+	}
+	engine, err := normalizeDatabaseEngine(req.DBEngine)
+	if err != nil {
+		return CreateDatabaseResult{}, err
+	}
+	if engineFamilies[engine] != engineFamilies[src.DBEngine] {
+		return CreateDatabaseResult{}, ErrCloneIncompatible
+	}
+
+	tmpDir, err := os.MkdirTemp("", "aipanel-clone-*")
+	if err != nil {
+		return CreateDatabaseResult{}, fmt.Errorf("create clone dir: %w", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	dump := filepath.Join(tmpDir, src.DBName+"."+dumpExtension(src.DBEngine))
+	if err := s.dumpForClone(ctx, src, dump); err != nil {
+		return CreateDatabaseResult{}, err
+	}
+
+	res, err := s.CreateDatabase(ctx, CreateDatabaseRequest{
+		SiteID:   req.SiteID,
+		DBName:   req.DBName,
+		DBEngine: engine,
+		Actor:    req.Actor,
+	})
+	if err != nil {
+		return CreateDatabaseResult{}, err
+	}
+	if err := s.restoreClone(ctx, res, dump); err != nil {
+		if cleanupErr := s.DeleteDatabase(context.WithoutCancel(ctx), res.Database.ID, req.Actor); cleanupErr != nil {
+			s.log.Error("remove failed database clone", "db", res.Database.DBName, "error", cleanupErr.Error())
+		}
+		return CreateDatabaseResult{}, err
+	}
+	_ = s.writeAudit(ctx, req.Actor, "database.clone", fmt.Sprintf(
+		"db=%s,clone=%s,engine=%s,site_id=%d", src.DBName, res.Database.DBName, engine, req.SiteID))
+	return res, nil
+}
+
+func (s *Service) dumpForClone(ctx context.Context, src SiteDatabase, dest string) error {
+	if src.DBEngine == DBEngineSQLite {
+		if s.sqlite == nil {
+			return fmt.Errorf("database engine sqlite is not configured")
+		}
+		site, err := s.sqliteSiteByID(ctx, src.SiteID)
+		if err != nil {
+			return err
+		}
+		return s.sqlite.Backup(ctx, sqliteDatabasePath(site.rootDir, src.DBName), dest, "")
+	}
+	provisioner, err := s.provisionerForEngine(src.DBEngine)
+	if err != nil {
+		return err
+	}
+	return provisioner.Dump(ctx, src.DBName, dest)
+}
+
+func (s *Service) restoreClone(ctx context.Context, res CreateDatabaseResult, dump string) error {
+	db := res.Database
+	if db.DBEngine == DBEngineSQLite {
+		// db_user of an SQLite database is the site user owning the file.
+		return s.sqlite.Backup(ctx, dump, res.Path, db.DBUser)
+	}
+	provisioner, err := s.provisionerForEngine(db.DBEngine)
+	if err != nil {
+		return err
+	}
+	return provisioner.Restore(ctx, db.DBName, db.DBUser, dump)
+}
@@ -0,0 +1,196 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// passwordPlaceholder stands in for the password, which the panel does not
+// keep after creation, unless it is regenerated.
+const passwordPlaceholder = "YOUR_PASSWORD"
+
+// ErrNoPassword indicates a database engine without user passwords.
+var ErrNoPassword = errors.New("sqlite databases have no password")
+
+// ConnectionInfo describes how a site connects to one database, with
+// ready-to-paste configuration snippets keyed by format.
+type ConnectionInfo struct {
+	Engine   string            `json:"engine"`
+	Host     string            `json:"host,omitempty"`
+	Port     int               `json:"port,omitempty"`
+	Socket   string            `json:"socket,omitempty"`
+	Path     string            `json:"path,omitempty"`
+	Database string            `json:"database"`
+	User     string            `json:"user,omitempty"`
+	Password string            `json:"password,omitempty"`
+	Snippets map[string]string `json:"snippets"`
+}
+
+// engineEndpoint holds the local listener of a runtime engine. MariaDB and
+// MySQL users are created for 'localhost', so clients must use the socket.
+type engineEndpoint struct {
+	host    string
+	port    int
+	socket  string
+	laravel string
+}
+
+var engineEndpoints = map[string]engineEndpoint{
+	DBEngineMariaDB:    {host: "localhost", port: 3306, socket: "/tmp/mysql.sock", laravel: "mariadb"},
+	DBEngineMySQL:      {host: "localhost", port: 3306, socket: "/tmp/mysql.sock", laravel: "mysql"},
+	DBEnginePostgreSQL: {host: "127.0.0.1", port: 5432, socket: "/tmp", laravel: "pgsql"},
+	DBEngineMongoDB:    {host: "127.0.0.1", port: 27017, laravel: "mongodb"},
+}
+
+// Connection returns connection details for a database. With regenerate
+// set, the user gets a new password which is returned once and filled into
+// the snippets; otherwise snippets carry a placeholder.
+func (s *Service) Connection(ctx context.Context, id int64, regenerate bool, actor string) (ConnectionInfo, error) {
+	if s.store == nil {
+		return ConnectionInfo{}, fmt.Errorf("database service is not configured")
+	}
+	db, err := s.getByID(ctx, id)
+	if err != nil {
+		return ConnectionInfo{}, err
+	}
+	engine, err := normalizeDatabaseEngine(db.DBEngine)
+	if err != nil {
+		return ConnectionInfo{}, err
+	}
+	if engine == DBEngineSQLite {
+		if regenerate {
+			return ConnectionInfo{}, ErrNoPassword
+		}
+		site, err := s.sqliteSiteByID(ctx, db.SiteID)
+		if err != nil {
+			return ConnectionInfo{}, err
+		}
+		return sqliteConnection(db, sqliteDatabasePath(site.rootDir, db.DBName)), nil
+	}
+
+	password := ""
+	if regenerate {
+		provisioner, err := s.provisionerForEngine(engine)
+		if err != nil {
+			return ConnectionInfo{}, err
+		}
+		password, err = randomHex(12)
+		if err != nil {
+			return ConnectionInfo{}, fmt.Errorf("generate password: %w", err)
+		}
+		if err := provisioner.SetPassword(ctx, db.DBUser, password); err != nil {
+			return ConnectionInfo{}, err
+		}
+		_ = s.writeAudit(ctx, actor, "database.password_rotate", "db="+db.DBName+",engine="+engine)
+	}
+	return serverConnection(engine, db, password), nil
+}
+
+func serverConnection(engine string, db SiteDatabase, password string) ConnectionInfo {
+	ep := engineEndpoints[engine]
+	info := ConnectionInfo{
+		Engine:   engine,
+		Host:     ep.host,
+		Port:     ep.port,
+		Socket:   ep.socket,
+		Database: db.DBName,
+		User:     db.DBUser,
+		Password: password,
+		Snippets: map[string]string{},
+	}
+	if password == "" {
+		password = passwordPlaceholder
+	}
+	port := strconv.Itoa(ep.port)
+
+	switch engine {
+	case DBEngineMariaDB, DBEngineMySQL:
+		info.Snippets["laravel_env"] = envLines(
+			"DB_CONNECTION", ep.laravel,
+			"DB_HOST", ep.host,
+			"DB_PORT", port,
+			"DB_SOCKET", ep.socket,
+			"DB_DATABASE", db.DBName,
+			"DB_USERNAME", db.DBUser,
+			"DB_PASSWORD", password,
+		)
+		info.Snippets["wp_config"] = strings.Join([]string{
+			phpDefine("DB_NAME", db.DBName),
+			phpDefine("DB_USER", db.DBUser),
+			phpDefine("DB_PASSWORD", password),
+			phpDefine("DB_HOST", ep.host+":"+ep.socket),
+			phpDefine("DB_CHARSET", "utf8mb4"),
+			phpDefine("DB_COLLATE", ""),
+		}, "\n")
+		info.Snippets["pdo"] = fmt.Sprintf("mysql:unix_socket=%s;dbname=%s;charset=utf8mb4", ep.socket, db.DBName)
+		info.Snippets["url"] = connectionURL("mysql", db.DBUser, password, ep.host+":"+port, db.DBName, url.Values{"socket": {ep.socket}})
+	case DBEnginePostgreSQL:
+		info.Snippets["laravel_env"] = envLines(
+			"DB_CONNECTION", ep.laravel,
+			"DB_HOST", ep.host,
+			"DB_PORT", port,
+			"DB_DATABASE", db.DBName,
+			"DB_USERNAME", db.DBUser,
+			"DB_PASSWORD", password,
+		)
+		info.Snippets["pdo"] = fmt.Sprintf("pgsql:host=%s;port=%d;dbname=%s", ep.host, ep.port, db.DBName)
+		info.Snippets["url"] = connectionURL("postgresql", db.DBUser, password, ep.host+":"+port, db.DBName, nil)
+	case DBEngineMongoDB:
+		uri := connectionURL("mongodb", db.DBUser, password, ep.host+":"+port, db.DBName, url.Values{"authSource": {mongoDBAuthSource}})
+		info.Snippets["laravel_env"] = envLines(
+			"DB_CONNECTION", ep.laravel,
+			"DB_URI", uri,
+			"DB_DATABASE", db.DBName,
+		)
+		info.Snippets["url"] = uri
+	}
+	return info
+}
+
+func sqliteConnection(db SiteDatabase, path string) ConnectionInfo {
+	return ConnectionInfo{
+		Engine:   DBEngineSQLite,
+		Path:     path,
+		Database: db.DBName,
+		Snippets: map[string]string{
+			"laravel_env": envLines("DB_CONNECTION", "sqlite", "DB_DATABASE", path),
+			"pdo":         "sqlite:" + path,
+		},
+	}
+}
+
+// envLines renders KEY=value pairs, quoting values with spaces or '#'.
+func envLines(kv ...string) string {
+	lines := make([]string, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		value := kv[i+1]
+		if strings.ContainsAny(value, " #\"") {
+			value = strconv.Quote(value)
+		}
+		lines = append(lines, kv[i]+"="+value)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func phpDefine(name, value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "'", `\'`)
+	return fmt.Sprintf("define( '%s', '%s' );", name, value)
+}
+
+func connectionURL(scheme, user, password, host, dbName string, query url.Values) string {
+	u := url.URL{
+		Scheme: scheme,
+		User:   url.UserPassword(user, password),
+		Host:   host,
+		Path:   "/" + dbName,
+	}
+	if len(query) > 0 {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
@@ -2,23 +2,38 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/robsonek/aiPanel/internal/modules/backup"
 	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/faultinject"
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
 	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+	"github.com/robsonek/aiPanel/pkg/adapter"
 )
 
 type fakeMariaDB struct {
-	createDBCalls   []string
-	dropDBCalls     []string
-	createUserCalls []string
-	dropUserCalls   []string
-	failCreateDB    error
-	failCreateUser  error
-	running         *bool
-	failIsRunning   error
+	createDBCalls      []string
+	dropDBCalls        []string
+	createUserCalls    []string
+	dropUserCalls      []string
+	setPasswordCalls   []string
+	dumpCalls          []string
+	restoreCalls       []string
+	restoreToTimeCalls []string
+	pruneCalls         []time.Time
+	failCreateDB       error
+	failCreateUser     error
+	running            *bool
+	failIsRunning      error
 }
 
 func (f *fakeMariaDB) CreateDatabase(_ context.Context, dbName string) error {
@@ -41,6 +56,31 @@ func (f *fakeMariaDB) DropUser(_ context.Context, username string) error {
 	return nil
 }
 
+func (f *fakeMariaDB) SetPassword(_ context.Context, username, password string) error {
+	f.setPasswordCalls = append(f.setPasswordCalls, username+":"+password)
+	return nil
+}
+
+func (f *fakeMariaDB) Dump(_ context.Context, dbName, dest string) error {
+	f.dumpCalls = append(f.dumpCalls, dbName)
+	return os.WriteFile(dest, []byte("-- dump of "+dbName+"\n"), 0o600)
+}
+
+func (f *fakeMariaDB) RestoreToTime(_ context.Context, dbName, base string, target time.Time) error {
+	f.restoreToTimeCalls = append(f.restoreToTimeCalls, dbName+"@"+filepath.Base(base)+"@"+target.UTC().Format(time.RFC3339))
+	return nil
+}
+
+func (f *fakeMariaDB) PruneArchive(_ context.Context, before time.Time) error {
+	f.pruneCalls = append(f.pruneCalls, before)
+	return nil
+}
+
+func (f *fakeMariaDB) Restore(_ context.Context, dbName, owner, src string) error {
+	f.restoreCalls = append(f.restoreCalls, dbName+"@"+owner+"<"+filepath.Base(src))
+	return nil
+}
+
 func (f *fakeMariaDB) IsRunning(_ context.Context) (bool, error) {
 	if f.failIsRunning != nil {
 		return false, f.failIsRunning
@@ -52,14 +92,17 @@ func (f *fakeMariaDB) IsRunning(_ context.Context) (bool, error) {
 }
 
 type fakePostgreSQL struct {
-	createDBCalls   []string
-	dropDBCalls     []string
-	createUserCalls []string
-	dropUserCalls   []string
-	failCreateDB    error
-	failCreateUser  error
-	running         *bool
-	failIsRunning   error
+	createDBCalls    []string
+	dropDBCalls      []string
+	createUserCalls  []string
+	dropUserCalls    []string
+	setPasswordCalls []string
+	dumpCalls        []string
+	restoreCalls     []string
+	failCreateDB     error
+	failCreateUser   error
+	running          *bool
+	failIsRunning    error
 }
 
 func (f *fakePostgreSQL) CreateDatabase(_ context.Context, dbName string) error {
@@ -82,6 +125,21 @@ func (f *fakePostgreSQL) DropUser(_ context.Context, username string) error {
 	return nil
 }
 
+func (f *fakePostgreSQL) SetPassword(_ context.Context, username, password string) error {
+	f.setPasswordCalls = append(f.setPasswordCalls, username+":"+password)
+	return nil
+}
+
+func (f *fakePostgreSQL) Dump(_ context.Context, dbName, dest string) error {
+	f.dumpCalls = append(f.dumpCalls, dbName)
+	return os.WriteFile(dest, []byte("-- dump of "+dbName+"\n"), 0o600)
+}
+
+func (f *fakePostgreSQL) Restore(_ context.Context, dbName, owner, src string) error {
+	f.restoreCalls = append(f.restoreCalls, dbName+"@"+owner+"<"+filepath.Base(src))
+	return nil
+}
+
 func (f *fakePostgreSQL) IsRunning(_ context.Context) (bool, error) {
 	if f.failIsRunning != nil {
 		return false, f.failIsRunning
@@ -170,6 +228,46 @@ func TestService_CreateDatabaseRollbackOnCreateUserFailure(t *testing.T) {
 	}
 }
 
+func TestService_CreateDatabaseRollbackOnInjectedGrantFailure(t *testing.T) {
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	if err := store.ExecPanel(ctx, "INSERT INTO sites(domain, root_dir, php_version, system_user, status, created_at, updated_at) VALUES('test.example.com','/var/www/test.example.com/public_html','8.3','site_test','active',1,1);"); err != nil {
+		t.Fatalf("seed site: %v", err)
+	}
+	restore := faultinject.Install(faultinject.Rule{Kind: faultinject.KindCommand, Match: "CREATE USER"})
+	defer restore()
+
+	runner := &fakeRunner{
+		outputs: map[string]string{
+			"systemctl is-active aipanel-runtime-mariadb.service": "active\n",
+		},
+	}
+	svc := NewService(store, config.Config{}, slog.Default(), NewMariaDBAdapter(faultinject.WrapRunner(runner)), &fakePostgreSQL{})
+
+	_, err := svc.CreateDatabase(ctx, CreateDatabaseRequest{
+		SiteID:   1,
+		DBName:   "test_db",
+		DBEngine: DBEngineMariaDB,
+	})
+	if !errors.Is(err, faultinject.ErrInjected) {
+		t.Fatalf("expected injected failure, got %v", err)
+	}
+	joined := strings.Join(runner.commands, "\n")
+	if !strings.Contains(joined, "DROP DATABASE IF EXISTS `test_db`;") {
+		t.Fatalf("expected database rollback, got:\n%s", joined)
+	}
+	items, err := svc.ListDatabases(ctx, 1)
+	if err != nil {
+		t.Fatalf("list databases: %v", err)
+	}
+	if len(items) != 0 {
+		t.Fatalf("expected no database rows after rollback, got %+v", items)
+	}
+}
+
 func TestService_CreateDatabaseNormalizesName(t *testing.T) {
 	ctx := context.Background()
 	store := sqlite.New(t.TempDir())
@@ -299,6 +397,463 @@ func TestService_CreateDatabase_AllowsSameNameAcrossEngines(t *testing.T) {
 	}
 }
 
+func TestService_CreateDatabaseUsesMySQLAdapter(t *testing.T) {
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	if err := store.ExecPanel(ctx, "INSERT INTO sites(domain, root_dir, php_version, system_user, status, created_at, updated_at) VALUES('test.example.com','/var/www/test.example.com/public_html','8.3','site_test','active',1,1);"); err != nil {
+		t.Fatalf("seed site: %v", err)
+	}
+	mariadb := &fakeMariaDB{}
+	mysql := &fakeMariaDB{running: boolPtr(true)}
+	svc := NewService(store, config.Config{}, slog.Default(), mariadb, nil, ServiceOptions{MySQL: mysql})
+
+	result, err := svc.CreateDatabase(ctx, CreateDatabaseRequest{SiteID: 1, DBName: "app", DBEngine: "MySQL"})
+	if err != nil {
+		t.Fatalf("create mysql db: %v", err)
+	}
+	if result.Database.DBEngine != DBEngineMySQL {
+		t.Fatalf("expected mysql engine, got %q", result.Database.DBEngine)
+	}
+	if len(mysql.createDBCalls) != 1 || len(mariadb.createDBCalls) != 0 {
+		t.Fatalf("expected only the mysql adapter to be used, mysql=%v mariadb=%v", mysql.createDBCalls, mariadb.createDBCalls)
+	}
+	if err := svc.DeleteDatabase(ctx, result.Database.ID, "admin"); err != nil {
+		t.Fatalf("delete mysql db: %v", err)
+	}
+	if len(mysql.dropUserCalls) != 1 || len(mysql.dropDBCalls) != 1 {
+		t.Fatalf("expected mysql user and database to be dropped, got users=%v dbs=%v", mysql.dropUserCalls, mysql.dropDBCalls)
+	}
+
+	engines, err := svc.AvailableEngines(ctx)
+	if err != nil {
+		t.Fatalf("available engines: %v", err)
+	}
+	if len(engines) != 2 || engines[1] != DBEngineMySQL {
+		t.Fatalf("expected mariadb and mysql engines, got %v", engines)
+	}
+}
+
+type fakeMongoDB struct {
+	fakeMariaDB
+	statsCalls []string
+}
+
+func (f *fakeMongoDB) Stats(_ context.Context, dbName string) (adapter.DocumentDBStats, error) {
+	f.statsCalls = append(f.statsCalls, dbName)
+	return adapter.DocumentDBStats{Collections: 2, Objects: 10}, nil
+}
+
+func TestService_MongoDBDatabaseStats(t *testing.T) {
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	if err := store.ExecPanel(ctx, "INSERT INTO sites(domain, root_dir, php_version, system_user, status, created_at, updated_at) VALUES('test.example.com','/var/www/test.example.com/public_html','8.3','site_test','active',1,1);"); err != nil {
+		t.Fatalf("seed site: %v", err)
+	}
+	mariadb := &fakeMariaDB{}
+	mongodb := &fakeMongoDB{}
+	svc := NewService(store, config.Config{}, slog.Default(), mariadb, nil, ServiceOptions{MongoDB: mongodb})
+
+	doc, err := svc.CreateDatabase(ctx, CreateDatabaseRequest{SiteID: 1, DBName: "app", DBEngine: "mongodb"})
+	if err != nil {
+		t.Fatalf("create mongodb db: %v", err)
+	}
+	if doc.Database.DBEngine != DBEngineMongoDB || len(mongodb.createUserCalls) != 1 {
+		t.Fatalf("expected mongodb database with one user, got %+v users=%v", doc.Database, mongodb.createUserCalls)
+	}
+	stats, err := svc.DatabaseStats(ctx, doc.Database.ID)
+	if err != nil {
+		t.Fatalf("mongodb stats: %v", err)
+	}
+	if stats.Collections != 2 || len(mongodb.statsCalls) != 1 || mongodb.statsCalls[0] != "app" {
+		t.Fatalf("unexpected stats %+v calls=%v", stats, mongodb.statsCalls)
+	}
+
+	rel, err := svc.CreateDatabase(ctx, CreateDatabaseRequest{SiteID: 1, DBName: "rel", DBEngine: "mariadb"})
+	if err != nil {
+		t.Fatalf("create mariadb db: %v", err)
+	}
+	if _, err := svc.DatabaseStats(ctx, rel.Database.ID); !errors.Is(err, ErrStatsUnsupported) {
+		t.Fatalf("expected ErrStatsUnsupported for mariadb, got %v", err)
+	}
+}
+
+func TestService_SQLiteDatabaseLifecycle(t *testing.T) {
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	rootDir := filepath.Join(t.TempDir(), "example.com", "public_html")
+	if err := store.ExecPanel(ctx, fmt.Sprintf(
+		"INSERT INTO sites(domain, root_dir, php_version, system_user, status, created_at, updated_at) VALUES('example.com','%s','8.3','site_example_com','active',1,1);",
+		rootDir,
+	)); err != nil {
+		t.Fatalf("seed site: %v", err)
+	}
+	svc := NewService(store, config.Config{}, slog.Default(), &fakeMariaDB{}, nil, ServiceOptions{
+		SQLite: NewSQLiteFileAdapter(&sqliteTestRunner{}),
+	})
+
+	engines, err := svc.AvailableEngines(ctx)
+	if err != nil {
+		t.Fatalf("available engines: %v", err)
+	}
+	if engines[len(engines)-1] != DBEngineSQLite {
+		t.Fatalf("expected sqlite to be available, got %v", engines)
+	}
+
+	res, err := svc.CreateDatabase(ctx, CreateDatabaseRequest{SiteID: 1, DBName: "app", DBEngine: "sqlite"})
+	if err != nil {
+		t.Fatalf("create sqlite db: %v", err)
+	}
+	wantPath := filepath.Join(filepath.Dir(rootDir), "private", "databases", "app.sqlite")
+	if res.Path != wantPath || res.Password != "" || res.Database.DBUser != "site_example_com" {
+		t.Fatalf("unexpected create result: %+v", res)
+	}
+	if _, err := svc.CreateDatabase(ctx, CreateDatabaseRequest{SiteID: 1, DBName: "app", DBEngine: "sqlite"}); err == nil || !isCreateDatabaseBadRequest(err) {
+		t.Fatalf("expected duplicate sqlite database to be rejected, got %v", err)
+	}
+
+	backup, err := svc.BackupDatabase(ctx, res.Database.ID, "admin")
+	if err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+	if filepath.Dir(backup.Path) != filepath.Join(filepath.Dir(wantPath), "backups") {
+		t.Fatalf("unexpected backup path %q", backup.Path)
+	}
+	snap, err := svc.SnapshotDatabase(ctx, res.Database.ID, "admin")
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	if _, err := os.Stat(snap.Path); err != nil {
+		t.Fatalf("expected snapshot file: %v", err)
+	}
+	if err := snap.Close(); err != nil {
+		t.Fatalf("close snapshot: %v", err)
+	}
+	if _, err := os.Stat(snap.Path); !os.IsNotExist(err) {
+		t.Fatalf("expected snapshot to be removed, got %v", err)
+	}
+
+	if err := svc.DeleteDatabase(ctx, res.Database.ID, "admin"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := os.Stat(wantPath); !os.IsNotExist(err) {
+		t.Fatalf("expected database file to be removed, got %v", err)
+	}
+}
+
+func TestService_ConnectionSnippets(t *testing.T) {
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	if err := store.ExecPanel(ctx, "INSERT INTO sites(domain, root_dir, php_version, system_user, status, created_at, updated_at) VALUES('test.example.com','/var/www/test.example.com/public_html','8.3','site_test','active',1,1);"); err != nil {
+		t.Fatalf("seed site: %v", err)
+	}
+	mariadb := &fakeMariaDB{}
+	postgres := &fakePostgreSQL{}
+	svc := NewService(store, config.Config{}, slog.Default(), mariadb, postgres)
+
+	wp, err := svc.CreateDatabase(ctx, CreateDatabaseRequest{SiteID: 1, DBName: "wp", DBEngine: DBEngineMariaDB})
+	if err != nil {
+		t.Fatalf("create mariadb db: %v", err)
+	}
+	info, err := svc.Connection(ctx, wp.Database.ID, false, "admin")
+	if err != nil {
+		t.Fatalf("connection: %v", err)
+	}
+	if info.Password != "" || info.Socket != "/tmp/mysql.sock" || info.User != wp.Database.DBUser {
+		t.Fatalf("unexpected connection info: %+v", info)
+	}
+	if !strings.Contains(info.Snippets["wp_config"], "define( 'DB_PASSWORD', 'YOUR_PASSWORD' );") ||
+		!strings.Contains(info.Snippets["wp_config"], "define( 'DB_HOST', 'localhost:/tmp/mysql.sock' );") {
+		t.Fatalf("unexpected wp-config snippet:\n%s", info.Snippets["wp_config"])
+	}
+	if !strings.Contains(info.Snippets["laravel_env"], "DB_CONNECTION=mariadb\n") {
+		t.Fatalf("unexpected laravel snippet:\n%s", info.Snippets["laravel_env"])
+	}
+	if len(mariadb.setPasswordCalls) != 0 {
+		t.Fatalf("expected GET connection not to touch the password, got %v", mariadb.setPasswordCalls)
+	}
+
+	app, err := svc.CreateDatabase(ctx, CreateDatabaseRequest{SiteID: 1, DBName: "app", DBEngine: DBEnginePostgreSQL})
+	if err != nil {
+		t.Fatalf("create postgres db: %v", err)
+	}
+	rotated, err := svc.Connection(ctx, app.Database.ID, true, "admin")
+	if err != nil {
+		t.Fatalf("rotate connection: %v", err)
+	}
+	if rotated.Password == "" || len(postgres.setPasswordCalls) != 1 || postgres.setPasswordCalls[0] != app.Database.DBUser+":"+rotated.Password {
+		t.Fatalf("expected password rotation, got info=%+v calls=%v", rotated, postgres.setPasswordCalls)
+	}
+	wantURL := "postgresql://" + app.Database.DBUser + ":" + rotated.Password + "@127.0.0.1:5432/app"
+	if rotated.Snippets["url"] != wantURL {
+		t.Fatalf("expected url %q, got %q", wantURL, rotated.Snippets["url"])
+	}
+	if _, err := svc.Connection(ctx, 999, false, "admin"); !errors.Is(err, ErrDatabaseNotFound) {
+		t.Fatalf("expected ErrDatabaseNotFound, got %v", err)
+	}
+}
+
+func TestService_BackupScheduleDumpsAndPrunes(t *testing.T) {
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	if err := store.ExecPanel(ctx, "INSERT INTO sites(domain, root_dir, php_version, system_user, status, created_at, updated_at) VALUES('test.example.com','/var/www/test.example.com/public_html','8.3','site_test','active',1,1);"); err != nil {
+		t.Fatalf("seed site: %v", err)
+	}
+	mariadb := &fakeMariaDB{}
+	svc := NewService(store, config.Config{}, slog.Default(), mariadb, nil)
+	var pings []string
+	svc.ping = func(_ context.Context, url string, runErr error) error {
+		pings = append(pings, fmt.Sprintf("%s ok=%t", url, runErr == nil))
+		return nil
+	}
+	queue := jobqueue.New(store, slog.Default())
+	svc.RegisterJobs(queue)
+
+	res, err := svc.CreateDatabase(ctx, CreateDatabaseRequest{SiteID: 1, DBName: "shop", DBEngine: DBEngineMariaDB})
+	if err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	id := res.Database.ID
+	if _, err := svc.GetBackupSchedule(ctx, id); !errors.Is(err, ErrBackupScheduleNotFound) {
+		t.Fatalf("expected ErrBackupScheduleNotFound, got %v", err)
+	}
+	if _, err := svc.SetBackupSchedule(ctx, id, BackupScheduleRequest{IntervalMinutes: 5}); err == nil {
+		t.Fatal("expected too short interval to be rejected")
+	}
+	if _, err := svc.SetBackupSchedule(ctx, id, BackupScheduleRequest{IntervalMinutes: 60, HeartbeatURL: "ftp://example.com/ping"}); err == nil {
+		t.Fatal("expected non-http heartbeat URL to be rejected")
+	}
+	const pingURL = "https://status.example.com/api/push/Ab12?status=up&msg=OK"
+	schedule, err := svc.SetBackupSchedule(ctx, id, BackupScheduleRequest{IntervalMinutes: 60, Retention: 2, HeartbeatURL: pingURL})
+	if err != nil {
+		t.Fatalf("set schedule: %v", err)
+	}
+	if !schedule.Enabled || schedule.Retention != 2 || !schedule.NextRunAt.After(time.Now()) || schedule.HeartbeatURL != pingURL {
+		t.Fatalf("unexpected schedule: %+v", schedule)
+	}
+	if queued, err := svc.RunDueBackups(ctx); err != nil || queued != 0 {
+		t.Fatalf("expected nothing due yet, got queued=%d err=%v", queued, err)
+	}
+
+	dumpDir := filepath.Join(store.DataDir, "backups", "databases", strconv.FormatInt(id, 10))
+	for i, name := range []string{"shop-20200101-000000.sql", "shop-20200102-000000.sql"} {
+		if err := os.MkdirAll(dumpDir, 0o700); err != nil {
+			t.Fatalf("mkdir dump dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dumpDir, name), []byte(strconv.Itoa(i)), 0o600); err != nil {
+			t.Fatalf("write old dump: %v", err)
+		}
+	}
+	if err := store.ExecPanel(ctx, fmt.Sprintf("UPDATE database_backup_schedules SET next_run_at = 1 WHERE database_id = %d;", id)); err != nil {
+		t.Fatalf("make schedule due: %v", err)
+	}
+	if queued, err := svc.RunDueBackups(ctx); err != nil || queued != 1 {
+		t.Fatalf("expected one queued backup, got queued=%d err=%v", queued, err)
+	}
+	if queued, err := svc.RunDueBackups(ctx); err != nil || queued != 0 {
+		t.Fatalf("expected schedule to advance after queueing, got queued=%d err=%v", queued, err)
+	}
+	if _, err := queue.RunPending(ctx); err != nil {
+		t.Fatalf("run jobs: %v", err)
+	}
+
+	if len(mariadb.dumpCalls) != 1 || mariadb.dumpCalls[0] != "shop" {
+		t.Fatalf("expected one dump of shop, got %v", mariadb.dumpCalls)
+	}
+	dumps, err := svc.ListDumps(ctx, id)
+	if err != nil {
+		t.Fatalf("list dumps: %v", err)
+	}
+	if len(dumps) != 2 || dumps[1].Name != "shop-20200102-000000.sql" {
+		t.Fatalf("expected retention to keep the newest two dumps, got %+v", dumps)
+	}
+	if err := backup.VerifyChecksum(filepath.Join(dumpDir, dumps[0].Name)); err != nil {
+		t.Fatalf("verify new dump checksum: %v", err)
+	}
+	if encrypted, err := backup.IsEncryptedFile(filepath.Join(dumpDir, dumps[0].Name)); err != nil || !encrypted {
+		t.Fatalf("expected the new dump to be encrypted, got %v err=%v", encrypted, err)
+	}
+	schedule, err = svc.GetBackupSchedule(ctx, id)
+	if err != nil || schedule.LastRunAt.IsZero() || schedule.LastError != "" {
+		t.Fatalf("expected successful run to be recorded, got %+v err=%v", schedule, err)
+	}
+	if len(pings) != 1 || pings[0] != pingURL+" ok=true" {
+		t.Fatalf("expected one successful heartbeat, got %v", pings)
+	}
+
+	if err := svc.DeleteDatabase(ctx, id, "admin"); err != nil {
+		t.Fatalf("delete db: %v", err)
+	}
+	rows, err := store.QueryPanelJSON(ctx, "SELECT database_id FROM database_backup_schedules;")
+	if err != nil || len(rows) != 0 {
+		t.Fatalf("expected schedule to be removed with the database, got %v err=%v", rows, err)
+	}
+}
+
+func TestService_RestoreToTimeUsesNewestDumpWithBinlogPosition(t *testing.T) {
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	if err := store.ExecPanel(ctx, "INSERT INTO sites(domain, root_dir, php_version, system_user, status, created_at, updated_at) VALUES('test.example.com','/var/www/test.example.com/public_html','8.3','site_test','active',1,1);"); err != nil {
+		t.Fatalf("seed site: %v", err)
+	}
+	mariadb := &fakeMariaDB{}
+	svc := NewService(store, config.Config{PITRRetentionDays: 3}, slog.Default(), mariadb, nil, ServiceOptions{MongoDB: &fakeMongoDB{}})
+	queue := jobqueue.New(store, slog.Default())
+	svc.RegisterJobs(queue)
+
+	res, err := svc.CreateDatabase(ctx, CreateDatabaseRequest{SiteID: 1, DBName: "shop", DBEngine: DBEngineMariaDB})
+	if err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	id := res.Database.ID
+	window, err := svc.RecoveryWindow(ctx, id)
+	if err != nil || window.Bases != 0 || !window.Earliest.IsZero() || window.RetentionDays != 3 {
+		t.Fatalf("expected empty window, got %+v err=%v", window, err)
+	}
+
+	now := time.Now()
+	dumpDir := filepath.Join(store.DataDir, "backups", "databases", strconv.FormatInt(id, 10))
+	if err := os.MkdirAll(dumpDir, 0o700); err != nil {
+		t.Fatalf("mkdir dump dir: %v", err)
+	}
+	position := "-- CHANGE MASTER TO MASTER_LOG_FILE='mariadb-bin.000002', MASTER_LOG_POS=328;\n"
+	dumps := []struct {
+		name    string
+		content string
+		age     time.Duration
+	}{
+		{"shop-expired.sql", position, 4 * 24 * time.Hour},
+		{"shop-older.sql", position, 2 * time.Hour},
+		{"shop-newer.sql", position, time.Hour},
+		{"shop-nopos.sql", "-- dump without binlog\n", 30 * time.Minute},
+	}
+	keys, err := backup.LoadKeyring(ctx, store)
+	if err != nil {
+		t.Fatalf("load keyring: %v", err)
+	}
+	for _, d := range dumps {
+		path := filepath.Join(dumpDir, d.name)
+		if err := os.WriteFile(path, []byte(d.content), 0o600); err != nil {
+			t.Fatalf("write dump: %v", err)
+		}
+		// Dumps written before encryption are only usable once plaintext
+		// is allowed.
+		if d.name == "shop-older.sql" {
+			if err := backup.EncryptFile(path, keys); err != nil {
+				t.Fatalf("encrypt dump: %v", err)
+			}
+		}
+		if err := os.Chtimes(path, now.Add(-d.age), now.Add(-d.age)); err != nil {
+			t.Fatalf("date dump: %v", err)
+		}
+	}
+	window, err = svc.RecoveryWindow(ctx, id)
+	if err != nil || window.Bases != 1 {
+		t.Fatalf("expected only the encrypted dump usable, got %+v err=%v", window, err)
+	}
+	if err := store.ExecPanel(ctx, "INSERT INTO backup_settings(id, allow_plaintext, updated_at) VALUES(1, 1, 0);"); err != nil {
+		t.Fatalf("allow plaintext: %v", err)
+	}
+	window, err = svc.RecoveryWindow(ctx, id)
+	if err != nil || window.Bases != 2 || window.Earliest.After(now.Add(-2*time.Hour+time.Second)) {
+		t.Fatalf("expected two usable dumps, got %+v err=%v", window, err)
+	}
+
+	if _, err := svc.RestoreToTime(ctx, id, RestoreRequest{TargetTime: now.Add(time.Hour)}); !errors.Is(err, ErrRestoreOutOfRange) {
+		t.Fatalf("expected future target to be rejected, got %v", err)
+	}
+	if _, err := svc.RestoreToTime(ctx, id, RestoreRequest{TargetTime: now.Add(-3 * time.Hour)}); !errors.Is(err, ErrRestoreOutOfRange) {
+		t.Fatalf("expected target before the first dump to be rejected, got %v", err)
+	}
+	target := now.Add(-90 * time.Minute).UTC().Truncate(time.Second)
+	queued, err := svc.RestoreToTime(ctx, id, RestoreRequest{TargetTime: target, Actor: "admin"})
+	if err != nil {
+		t.Fatalf("restore to time: %v", err)
+	}
+	if queued.JobID == 0 || queued.Base != "shop-older.sql" {
+		t.Fatalf("expected restore from the newest dump before target, got %+v", queued)
+	}
+	if _, err := queue.RunPending(ctx); err != nil {
+		t.Fatalf("run jobs: %v", err)
+	}
+	want := "shop@shop-older.sql@" + target.Format(time.RFC3339)
+	if len(mariadb.restoreToTimeCalls) != 1 || mariadb.restoreToTimeCalls[0] != want {
+		t.Fatalf("expected restore %q, got %v", want, mariadb.restoreToTimeCalls)
+	}
+
+	if err := svc.MaintainPointInTime(ctx); err != nil {
+		t.Fatalf("maintain point in time: %v", err)
+	}
+	if len(mariadb.pruneCalls) != 1 || mariadb.pruneCalls[0].After(now.Add(-72*time.Hour+time.Minute)) {
+		t.Fatalf("expected binlogs pruned to retention, got %v", mariadb.pruneCalls)
+	}
+
+	docs, err := svc.CreateDatabase(ctx, CreateDatabaseRequest{SiteID: 1, DBName: "docs", DBEngine: DBEngineMongoDB})
+	if err != nil {
+		t.Fatalf("create mongodb db: %v", err)
+	}
+	if _, err := svc.RecoveryWindow(ctx, docs.Database.ID); !errors.Is(err, ErrPITRUnsupported) {
+		t.Fatalf("expected ErrPITRUnsupported, got %v", err)
+	}
+}
+
+func TestService_CloneDatabaseToAnotherSite(t *testing.T) {
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	if err := store.ExecPanel(ctx, "INSERT INTO sites(domain, root_dir, php_version, system_user, status, created_at, updated_at) VALUES('test.example.com','/var/www/test.example.com/public_html','8.3','site_test','active',1,1),('staging.example.com','/var/www/staging.example.com/public_html','8.3','site_staging','active',1,1);"); err != nil {
+		t.Fatalf("seed sites: %v", err)
+	}
+	mariadb := &fakeMariaDB{}
+	postgres := &fakePostgreSQL{}
+	svc := NewService(store, config.Config{}, slog.Default(), mariadb, postgres)
+
+	src, err := svc.CreateDatabase(ctx, CreateDatabaseRequest{SiteID: 1, DBName: "shop", DBEngine: DBEnginePostgreSQL})
+	if err != nil {
+		t.Fatalf("create source db: %v", err)
+	}
+	if _, err := svc.CloneDatabase(ctx, src.Database.ID, CloneDatabaseRequest{DBName: "shop_copy", DBEngine: DBEngineMariaDB}); !errors.Is(err, ErrCloneIncompatible) {
+		t.Fatalf("expected ErrCloneIncompatible, got %v", err)
+	}
+	if _, err := svc.CloneDatabase(ctx, src.Database.ID, CloneDatabaseRequest{DBName: "shop"}); err == nil || !strings.HasSuffix(err.Error(), "already exists") {
+		t.Fatalf("expected duplicate name to be rejected, got %v", err)
+	}
+
+	clone, err := svc.CloneDatabase(ctx, src.Database.ID, CloneDatabaseRequest{SiteID: 2, DBName: "shop_staging", Actor: "admin"})
+	if err != nil {
+		t.Fatalf("clone db: %v", err)
+	}
+	if clone.Database.SiteID != 2 || clone.Database.DBEngine != DBEnginePostgreSQL || clone.Password == "" || clone.Database.DBUser == src.Database.DBUser {
+		t.Fatalf("expected a new database with its own credentials, got %+v", clone)
+	}
+	if len(postgres.dumpCalls) < 1 || postgres.dumpCalls[len(postgres.dumpCalls)-1] != "shop" {
+		t.Fatalf("expected source dump, got %v", postgres.dumpCalls)
+	}
+	want := "shop_staging@" + clone.Database.DBUser + "<shop.dump"
+	if len(postgres.restoreCalls) != 1 || postgres.restoreCalls[0] != want {
+		t.Fatalf("expected restore %q, got %v", want, postgres.restoreCalls)
+	}
+}
+
 func TestService_CreateDatabaseRejectsInvalidEngine(t *testing.T) {
 	ctx := context.Background()
 	store := sqlite.New(t.TempDir())
@@ -369,3 +924,36 @@ func TestService_AvailableEngines(t *testing.T) {
 		t.Fatalf("expected only mariadb available, got %+v", engines)
 	}
 }
+
+func TestService_DisabledEngineIsHidden(t *testing.T) {
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	if err := store.ExecPanel(ctx, `
+INSERT INTO sites(domain, root_dir, php_version, system_user, status, created_at, updated_at)
+VALUES('example.com', '/var/www/example.com/public_html', '8.3', 'site_example_com', 'active', 1, 1);
+INSERT INTO runtime_components(name, enabled, updated_at) VALUES('postgresql', 0, 1);`); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	svc := NewService(
+		store,
+		config.Config{},
+		slog.Default(),
+		&fakeMariaDB{running: boolPtr(true)},
+		&fakePostgreSQL{running: boolPtr(true)},
+	)
+
+	engines, err := svc.AvailableEngines(ctx)
+	if err != nil {
+		t.Fatalf("available engines: %v", err)
+	}
+	if len(engines) != 1 || engines[0] != DBEngineMariaDB {
+		t.Fatalf("expected disabled postgresql to be hidden, got %+v", engines)
+	}
+	_, err = svc.CreateDatabase(ctx, CreateDatabaseRequest{SiteID: 1, DBName: "app", DBEngine: DBEnginePostgreSQL})
+	if !isCreateDatabaseServiceUnavailable(err) {
+		t.Fatalf("expected disabled engine to be unavailable, got %v", err)
+	}
+}
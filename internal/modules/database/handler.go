@@ -3,8 +3,10 @@ package database
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 )
@@ -93,6 +95,267 @@ func (h *Handler) HandleDatabaseByID(w http.ResponseWriter, r *http.Request, id
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// HandleDatabaseStats serves GET /api/databases/{id}/stats.
+func (h *Handler) HandleDatabaseStats(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	stats, err := h.svc.DatabaseStats(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrDatabaseNotFound):
+			http.Error(w, "database not found", http.StatusNotFound)
+		case errors.Is(err, ErrStatsUnsupported):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "failed to read database stats", http.StatusInternalServerError)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// HandleDatabaseActivity serves GET /api/databases/{id}/activity.
+func (h *Handler) HandleDatabaseActivity(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	before, _ := strconv.ParseInt(r.URL.Query().Get("before"), 10, 64)
+	events, err := h.svc.DatabaseActivity(r.Context(), id, before, limit)
+	if err != nil {
+		if errors.Is(err, ErrDatabaseNotFound) {
+			http.Error(w, "database not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to list database activity", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"events": events})
+}
+
+// HandleDatabaseConnection serves GET/POST /api/databases/{id}/connection.
+// POST regenerates the database user password and returns it once.
+func (h *Handler) HandleDatabaseConnection(w http.ResponseWriter, r *http.Request, id int64, actor string) {
+	var regenerate bool
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		regenerate = true
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	info, err := h.svc.Connection(r.Context(), id, regenerate, actor)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrDatabaseNotFound):
+			http.Error(w, "database not found", http.StatusNotFound)
+		case errors.Is(err, ErrNoPassword):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "failed to read database connection", http.StatusInternalServerError)
+		}
+		return
+	}
+	if regenerate {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// HandleBackupSchedule serves GET/PUT/DELETE /api/databases/{id}/backup-schedule.
+func (h *Handler) HandleBackupSchedule(w http.ResponseWriter, r *http.Request, id int64, actor string) {
+	var (
+		schedule BackupSchedule
+		err      error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		schedule, err = h.svc.GetBackupSchedule(r.Context(), id)
+	case http.MethodPut:
+		var req BackupScheduleRequest
+		if decodeErr := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); decodeErr != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Actor = actor
+		schedule, err = h.svc.SetBackupSchedule(r.Context(), id, req)
+	case http.MethodDelete:
+		if err = h.svc.DeleteBackupSchedule(r.Context(), id, actor); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrDatabaseNotFound):
+			http.Error(w, "database not found", http.StatusNotFound)
+		case errors.Is(err, ErrBackupScheduleNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case strings.Contains(err.Error(), " must be between "), strings.HasPrefix(err.Error(), "invalid "):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "failed to manage backup schedule", http.StatusInternalServerError)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, schedule)
+}
+
+// HandleDumps serves GET /api/databases/{id}/dumps.
+func (h *Handler) HandleDumps(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	dumps, err := h.svc.ListDumps(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrDatabaseNotFound) {
+			http.Error(w, "database not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to list dumps", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"dumps": dumps})
+}
+
+// HandleDatabaseBackup serves POST /api/databases/{id}/backup.
+func (h *Handler) HandleDatabaseBackup(w http.ResponseWriter, r *http.Request, id int64, actor string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	backup, err := h.svc.BackupDatabase(r.Context(), id, actor)
+	if err != nil {
+		writeSQLiteError(w, err, "failed to back up database")
+		return
+	}
+	writeJSON(w, http.StatusCreated, backup)
+}
+
+// HandleDatabaseDownload serves GET /api/databases/{id}/download.
+func (h *Handler) HandleDatabaseDownload(w http.ResponseWriter, r *http.Request, id int64, actor string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	snap, err := h.svc.SnapshotDatabase(r.Context(), id, actor)
+	if err != nil {
+		writeSQLiteError(w, err, "failed to export database")
+		return
+	}
+	defer func() {
+		_ = snap.Close()
+	}()
+	f, err := os.Open(snap.Path)
+	if err != nil {
+		http.Error(w, "failed to export database", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", snap.Database.DBName+".sqlite"))
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, f)
+}
+
+// HandleDatabaseClone serves POST /api/databases/{id}/clone.
+func (h *Handler) HandleDatabaseClone(w http.ResponseWriter, r *http.Request, id int64, actor string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req CloneDatabaseRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Actor = actor
+	res, err := h.svc.CloneDatabase(r.Context(), id, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrDatabaseNotFound):
+			http.Error(w, "database not found", http.StatusNotFound)
+		case errors.Is(err, ErrCloneIncompatible), isCreateDatabaseBadRequest(err):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case isCreateDatabaseServiceUnavailable(err):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		default:
+			http.Error(w, "failed to clone database", http.StatusInternalServerError)
+		}
+		return
+	}
+	writeJSON(w, http.StatusCreated, res)
+}
+
+// HandleRecoveryWindow serves GET /api/databases/{id}/pitr.
+func (h *Handler) HandleRecoveryWindow(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	window, err := h.svc.RecoveryWindow(r.Context(), id)
+	if err != nil {
+		writeRestoreError(w, err, "failed to read recovery window")
+		return
+	}
+	writeJSON(w, http.StatusOK, window)
+}
+
+// HandleRestore serves POST /api/databases/{id}/restore. The restore runs
+// as a background job.
+func (h *Handler) HandleRestore(w http.ResponseWriter, r *http.Request, id int64, actor string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req RestoreRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Actor = actor
+	res, err := h.svc.RestoreToTime(r.Context(), id, req)
+	if err != nil {
+		writeRestoreError(w, err, "failed to queue restore")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, res)
+}
+
+func writeRestoreError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, ErrDatabaseNotFound):
+		http.Error(w, "database not found", http.StatusNotFound)
+	case errors.Is(err, ErrPITRUnsupported), errors.Is(err, ErrRestoreOutOfRange):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err.Error() == "target_time is required":
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+}
+
+func writeSQLiteError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, ErrDatabaseNotFound):
+		http.Error(w, "database not found", http.StatusNotFound)
+	case errors.Is(err, ErrSQLiteOnly):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+}
+
 // ParseSiteIDFromDatabasesPath extracts site ID from "/api/sites/{siteID}/databases".
 func ParseSiteIDFromDatabasesPath(path string) (int64, error) {
 	trimmed := strings.TrimPrefix(path, "/api/sites/")
@@ -111,6 +374,21 @@ func ParseDatabaseID(path string) (int64, error) {
 	return strconv.ParseInt(trimmed, 10, 64)
 }
 
+// ParseDatabaseSubresource extracts id and name from "/api/databases/{id}/{name}".
+func ParseDatabaseSubresource(path string) (int64, string, error) {
+	trimmed := strings.TrimPrefix(path, "/api/databases/")
+	trimmed = strings.TrimSpace(strings.Trim(trimmed, "/"))
+	idRaw, name, ok := strings.Cut(trimmed, "/")
+	if !ok || name == "" {
+		return 0, "", strconv.ErrSyntax
+	}
+	id, err := strconv.ParseInt(idRaw, 10, 64)
+	if err != nil {
+		return 0, "", err
+	}
+	return id, name, nil
+}
+
 func writeJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -125,7 +403,7 @@ func isCreateDatabaseBadRequest(err error) bool {
 	case "site_id is required", "invalid database name", "invalid database engine", "site not found":
 		return true
 	default:
-		return false
+		return strings.HasSuffix(err.Error(), "already exists")
 	}
 }
 
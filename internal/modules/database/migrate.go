@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ExportDatabase writes a plain, unencrypted dump of a database into dir
+// for transfer to another panel and returns its path. The file is named
+// <db_name>.<ext>, since engines pick the restore method by extension.
+func (s *Service) ExportDatabase(ctx context.Context, id int64, dir string) (string, error) {
+	if s.store == nil {
+		return "", fmt.Errorf("database service is not configured")
+	}
+	db, err := s.getByID(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("create export dir: %w", err)
+	}
+	dest := filepath.Join(dir, db.DBName+"."+dumpExtension(db.DBEngine))
+	if db.DBEngine == DBEngineSQLite {
+		if s.sqlite == nil {
+			return "", fmt.Errorf("database engine sqlite is not configured")
+		}
+		site, err := s.sqliteSiteByID(ctx, db.SiteID)
+		if err != nil {
+			return "", err
+		}
+		if err := s.sqlite.Backup(ctx, sqliteDatabasePath(site.rootDir, db.DBName), dest, ""); err != nil {
+			return "", err
+		}
+		return dest, nil
+	}
+	provisioner, err := s.provisionerForEngine(db.DBEngine)
+	if err != nil {
+		return "", err
+	}
+	if err := provisioner.Dump(ctx, db.DBName, dest); err != nil {
+		_ = os.Remove(dest)
+		return "", err
+	}
+	return dest, nil
+}
+
+// ImportDatabase loads a dump written by ExportDatabase on another panel
+// into an existing database, replacing what the dump covers.
+func (s *Service) ImportDatabase(ctx context.Context, id int64, src, actor string) error {
+	if s.store == nil {
+		return fmt.Errorf("database service is not configured")
+	}
+	db, err := s.getByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if db.DBEngine == DBEngineSQLite {
+		if s.sqlite == nil {
+			return fmt.Errorf("database engine sqlite is not configured")
+		}
+		site, err := s.sqliteSiteByID(ctx, db.SiteID)
+		if err != nil {
+			return err
+		}
+		// The online backup API copies the dump over the live file.
+		if err := s.sqlite.Backup(ctx, src, sqliteDatabasePath(site.rootDir, db.DBName), site.systemUser); err != nil {
+			return err
+		}
+	} else {
+		provisioner, err := s.provisionerForEngine(db.DBEngine)
+		if err != nil {
+			return err
+		}
+		if err := provisioner.Restore(ctx, db.DBName, db.DBUser, src); err != nil {
+			return err
+		}
+	}
+	_ = s.writeAudit(ctx, actor, "database.import", fmt.Sprintf(
+		"db=%s,engine=%s,dump=%s", db.DBName, db.DBEngine, filepath.Base(src)))
+	return nil
+}
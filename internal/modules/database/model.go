@@ -21,7 +21,18 @@ type CreateDatabaseRequest struct {
 }
 
 // CreateDatabaseResult includes one-time password for the new DB user.
+// SQLite databases have no password; Path points at the database file.
 type CreateDatabaseResult struct {
 	Database SiteDatabase `json:"database"`
 	Password string       `json:"password"`
+	Path     string       `json:"path,omitempty"`
+}
+
+// CloneDatabaseRequest copies an existing database into a new one. SiteID
+// and DBEngine default to those of the source database.
+type CloneDatabaseRequest struct {
+	SiteID   int64  `json:"site_id"`
+	DBName   string `json:"db_name"`
+	DBEngine string `json:"db_engine"`
+	Actor    string `json:"-"`
 }
@@ -0,0 +1,415 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/modules/backup"
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/internal/platform/settings"
+	"github.com/robsonek/aiPanel/pkg/adapter"
+)
+
+// RestoreDatabaseJob is the job type that restores one database to a point
+// in time.
+const RestoreDatabaseJob = "database.restore"
+
+const defaultPITRRetentionDays = 7
+
+var (
+	// ErrPITRUnsupported indicates an engine without point-in-time recovery.
+	ErrPITRUnsupported = errors.New("point-in-time recovery is only supported for mariadb and postgres databases")
+	// ErrRestoreOutOfRange indicates a target time outside the recovery window.
+	ErrRestoreOutOfRange = errors.New("target time is outside the recovery window")
+)
+
+// RecoveryWindow is the range a database can be restored to. Earliest is
+// zero when no base is available yet: a dump with binlog coordinates for
+// MariaDB, a cluster base backup for PostgreSQL.
+type RecoveryWindow struct {
+	Engine        string    `json:"engine"`
+	Earliest      time.Time `json:"earliest,omitzero"`
+	Latest        time.Time `json:"latest"`
+	RetentionDays int       `json:"retention_days"`
+	Bases         int       `json:"bases"`
+}
+
+// RestoreRequest selects the point in time to restore a database to.
+type RestoreRequest struct {
+	TargetTime time.Time `json:"target_time"`
+	Actor      string    `json:"-"`
+}
+
+// RestoreResult describes a queued point-in-time restore.
+type RestoreResult struct {
+	JobID      int64     `json:"job_id"`
+	TargetTime time.Time `json:"target_time"`
+	Base       string    `json:"base"`
+}
+
+type restorePayload struct {
+	DatabaseID int64  `json:"database_id"`
+	Target     int64  `json:"target"`
+	Base       string `json:"base"`
+	Actor      string `json:"actor"`
+}
+
+// recoveryBase is a restore starting point, oldest first in listings.
+type recoveryBase struct {
+	path      string
+	createdAt time.Time
+}
+
+// RecoveryWindow returns the point-in-time recovery range of a database.
+func (s *Service) RecoveryWindow(ctx context.Context, id int64) (RecoveryWindow, error) {
+	db, _, err := s.pitrDatabase(ctx, id)
+	if err != nil {
+		return RecoveryWindow{}, err
+	}
+	bases, err := s.recoveryBases(ctx, db)
+	if err != nil {
+		return RecoveryWindow{}, err
+	}
+	window := RecoveryWindow{
+		Engine:        db.DBEngine,
+		Latest:        time.Now().UTC(),
+		RetentionDays: s.pitrRetentionDays(ctx),
+		Bases:         len(bases),
+	}
+	if len(bases) > 0 {
+		window.Earliest = bases[0].createdAt
+	}
+	return window, nil
+}
+
+// RestoreToTime queues a restore of a database to req.TargetTime from the
+// newest base taken before it.
+func (s *Service) RestoreToTime(ctx context.Context, id int64, req RestoreRequest) (RestoreResult, error) {
+	if s.jobs == nil {
+		return RestoreResult{}, fmt.Errorf("job queue is not configured")
+	}
+	db, _, err := s.pitrDatabase(ctx, id)
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	if req.TargetTime.IsZero() {
+		return RestoreResult{}, fmt.Errorf("target_time is required")
+	}
+	target := req.TargetTime.UTC().Truncate(time.Second)
+	if target.After(time.Now()) {
+		return RestoreResult{}, ErrRestoreOutOfRange
+	}
+	bases, err := s.recoveryBases(ctx, db)
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	var base *recoveryBase
+	for i := range bases {
+		if bases[i].createdAt.After(target) {
+			break
+		}
+		base = &bases[i]
+	}
+	if base == nil {
+		return RestoreResult{}, ErrRestoreOutOfRange
+	}
+	jobID, err := s.jobs.Enqueue(ctx, RestoreDatabaseJob, restorePayload{
+		DatabaseID: id,
+		Target:     target.Unix(),
+		Base:       base.path,
+		Actor:      req.Actor,
+	})
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	_ = s.writeAudit(ctx, req.Actor, "database.restore.queue", fmt.Sprintf(
+		"db=%s,engine=%s,target=%s", db.DBName, db.DBEngine, target.Format(time.RFC3339)))
+	return RestoreResult{JobID: jobID, TargetTime: target, Base: filepath.Base(base.path)}, nil
+}
+
+// MaintainPointInTime takes a PostgreSQL base backup when site databases
+// use it and prunes bases and archived changes beyond the retention window.
+func (s *Service) MaintainPointInTime(ctx context.Context) error {
+	cutoff := time.Now().Add(-time.Duration(s.pitrRetentionDays(ctx)) * 24 * time.Hour)
+	var errs []error
+	if pitr, ok := s.mariadb.(adapter.PointInTimeRecovery); ok && s.engineRunning(ctx, s.mariadb) {
+		if err := pitr.PruneArchive(ctx, cutoff); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := s.maintainPostgreSQLBases(ctx, cutoff); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Service) maintainPostgreSQLBases(ctx context.Context, cutoff time.Time) error {
+	pitr, ok := s.postgresql.(adapter.PointInTimeRecovery)
+	if !ok || !s.engineRunning(ctx, s.postgresql) {
+		return nil
+	}
+	snapshotter, ok := s.postgresql.(adapter.BaseBackup)
+	if !ok {
+		return nil
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT COUNT(*) AS n FROM site_databases WHERE db_engine = '%s';", DBEnginePostgreSQL))
+	if err != nil {
+		return fmt.Errorf("count postgres databases: %w", err)
+	}
+	count, err := toInt64(rows[0]["n"])
+	if err != nil {
+		return fmt.Errorf("parse postgres database count: %w", err)
+	}
+	if count == 0 {
+		return nil
+	}
+
+	dir := s.baseBackupDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("create base backup dir: %w", err)
+	}
+	dest := filepath.Join(dir, "base-"+time.Now().UTC().Format("20060102-150405")+".tar.gz")
+	if err := snapshotter.BaseBackup(ctx, dest); err != nil {
+		_ = os.Remove(dest)
+		return err
+	}
+	if err := s.encryptBackup(ctx, dest); err != nil {
+		return err
+	}
+	if err := backup.WriteChecksum(dest); err != nil {
+		return err
+	}
+	_ = s.writeAudit(ctx, "system", "database.base_backup", "engine="+DBEnginePostgreSQL)
+
+	bases, err := listRecoveryBases(dir)
+	if err != nil {
+		return err
+	}
+	// The newest base is always kept so the window never becomes empty.
+	kept := bases[len(bases)-1]
+	for _, b := range bases[:len(bases)-1] {
+		if !b.createdAt.Before(cutoff) {
+			kept = b
+			break
+		}
+		if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("prune base backup: %w", err)
+		}
+		_ = os.Remove(b.path + backup.ChecksumSuffix)
+	}
+	return pitr.PruneArchive(ctx, kept.createdAt)
+}
+
+func (s *Service) runRestoreJob(ctx context.Context, job jobqueue.Job) error {
+	var payload restorePayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("decode restore payload: %w", err)
+	}
+	db, pitr, err := s.pitrDatabase(ctx, payload.DatabaseID)
+	if err != nil {
+		if errors.Is(err, ErrDatabaseNotFound) {
+			return nil
+		}
+		return err
+	}
+	target := time.Unix(payload.Target, 0).UTC()
+	base, cleanup, err := s.plainBackup(ctx, payload.Base)
+	if err != nil {
+		if errors.Is(err, backup.ErrNoKey) || errors.Is(err, backup.ErrPlaintext) {
+			_ = s.writeAudit(ctx, payload.Actor, "database.restore_refused", fmt.Sprintf(
+				"db=%s,engine=%s,base=%s", db.DBName, db.DBEngine, filepath.Base(payload.Base)))
+		}
+		return err
+	}
+	defer cleanup()
+	if err := pitr.RestoreToTime(ctx, db.DBName, base, target); err != nil {
+		return err
+	}
+	_ = s.writeAudit(ctx, payload.Actor, "database.restore", fmt.Sprintf(
+		"db=%s,engine=%s,target=%s", db.DBName, db.DBEngine, target.Format(time.RFC3339)))
+	return nil
+}
+
+func (s *Service) pitrDatabase(ctx context.Context, id int64) (SiteDatabase, adapter.PointInTimeRecovery, error) {
+	if s.store == nil {
+		return SiteDatabase{}, nil, fmt.Errorf("database service is not configured")
+	}
+	db, err := s.getByID(ctx, id)
+	if err != nil {
+		return SiteDatabase{}, nil, err
+	}
+	var engine any
+	switch db.DBEngine {
+	case DBEngineMariaDB:
+		engine = s.mariadb
+	case DBEnginePostgreSQL:
+		engine = s.postgresql
+	}
+	pitr, ok := engine.(adapter.PointInTimeRecovery)
+	if !ok {
+		return SiteDatabase{}, nil, ErrPITRUnsupported
+	}
+	return db, pitr, nil
+}
+
+// recoveryBases lists restore starting points of a database, oldest first.
+// MariaDB dumps need binlog coordinates and must fall inside retention,
+// since older binlogs are purged.
+func (s *Service) recoveryBases(ctx context.Context, db SiteDatabase) ([]recoveryBase, error) {
+	if db.DBEngine == DBEnginePostgreSQL {
+		return listRecoveryBases(s.baseBackupDir())
+	}
+	dumps, err := listRecoveryBases(s.dumpDir(db.ID))
+	if err != nil {
+		return nil, err
+	}
+	keys, err := backup.LoadKeyring(ctx, s.store)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-time.Duration(s.pitrRetentionDays(ctx)) * 24 * time.Hour)
+	bases := make([]recoveryBase, 0, len(dumps))
+	for _, d := range dumps {
+		if d.createdAt.Before(cutoff) {
+			continue
+		}
+		if _, _, err := dumpBinlogPosition(d.path, keys); err != nil {
+			continue
+		}
+		bases = append(bases, d)
+	}
+	return bases, nil
+}
+
+func (s *Service) baseBackupDir() string {
+	return filepath.Join(backup.Dir(s.store.DataDir), "postgresql-base")
+}
+
+func (s *Service) pitrRetentionDays(ctx context.Context) int {
+	if s.settings != nil {
+		return s.settings.Int(ctx, settings.Backup, "pitr_retention_days")
+	}
+	if s.cfg.PITRRetentionDays > 0 {
+		return s.cfg.PITRRetentionDays
+	}
+	return defaultPITRRetentionDays
+}
+
+func (s *Service) engineRunning(ctx context.Context, engine interface {
+	IsRunning(ctx context.Context) (bool, error)
+}) bool {
+	if engine == nil {
+		return false
+	}
+	running, err := engine.IsRunning(ctx)
+	return err == nil && running
+}
+
+// dumpBinlogPosition reads the binlog coordinates of a possibly encrypted
+// dump. A dump the panel keys do not open has none it can use.
+func dumpBinlogPosition(path string, keys *backup.Keyring) (string, int64, error) {
+	f, err := backup.OpenFile(path, keys)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return readBinlogPosition(f, filepath.Base(path))
+}
+
+// encryptBackup encrypts a freshly written dump or base backup to the
+// backup keyring before its checksum is taken.
+func (s *Service) encryptBackup(ctx context.Context, path string) error {
+	keys, err := backup.LoadKeyring(ctx, s.store)
+	if err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+	if err := backup.EncryptFile(path, keys); err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// plainBackup returns a path engines can restore from: the archive itself
+// when it is not encrypted, otherwise a decrypted copy in a private
+// scratch dir next to it, removed by cleanup. Restores are refused with
+// backup.ErrNoKey when the panel keys do not open the archive.
+func (s *Service) plainBackup(ctx context.Context, path string) (string, func(), error) {
+	encrypted, err := backup.IsEncryptedFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("open backup: %w", err)
+	}
+	if !encrypted {
+		return path, func() {}, nil
+	}
+	keys, err := backup.LoadKeyring(ctx, s.store)
+	if err != nil {
+		return "", nil, err
+	}
+	src, err := backup.OpenFile(path, keys)
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+	scratch, err := os.MkdirTemp(filepath.Dir(path), ".restore-")
+	if err != nil {
+		return "", nil, fmt.Errorf("create restore scratch dir: %w", err)
+	}
+	cleanup := func() {
+		_ = os.RemoveAll(scratch)
+	}
+	// Same base name: engines pick the restore method by extension.
+	dest := filepath.Join(scratch, filepath.Base(path))
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err == nil {
+		_, err = io.Copy(f, src)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("decrypt backup: %w", err)
+	}
+	return dest, cleanup, nil
+}
+
+// listRecoveryBases returns files of dir, oldest first. Bases are dated by
+// mtime, when they finished, so a base picked for a target never holds
+// changes made after it.
+func listRecoveryBases(dir string) ([]recoveryBase, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read %s: %w", filepath.Base(dir), err)
+	}
+	bases := make([]recoveryBase, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || strings.HasSuffix(e.Name(), backup.ChecksumSuffix) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		bases = append(bases, recoveryBase{path: filepath.Join(dir, e.Name()), createdAt: info.ModTime().UTC()})
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i].createdAt.Before(bases[j].createdAt) })
+	return bases, nil
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+)
+
+// ReprovisionResult describes a database recreated from its newest dump.
+// The panel never keeps database passwords, so the user gets a new one,
+// returned once in Connection; site configs must be updated with it.
+type ReprovisionResult struct {
+	Database   SiteDatabase   `json:"database"`
+	Dump       string         `json:"dump,omitempty"`
+	Connection ConnectionInfo `json:"connection"`
+}
+
+// ReprovisionDatabase recreates an existing site_databases row on its
+// engine after panel.db was restored onto a fresh server, then loads the
+// newest dump. SQLite databases live in the docroot and come back with the
+// site files, so only their connection details are returned.
+func (s *Service) ReprovisionDatabase(ctx context.Context, id int64, actor string) (ReprovisionResult, error) {
+	if s.store == nil {
+		return ReprovisionResult{}, fmt.Errorf("database service is not configured")
+	}
+	db, err := s.getByID(ctx, id)
+	if err != nil {
+		return ReprovisionResult{}, err
+	}
+	engine, err := normalizeDatabaseEngine(db.DBEngine)
+	if err != nil {
+		return ReprovisionResult{}, err
+	}
+	if engine == DBEngineSQLite {
+		conn, err := s.Connection(ctx, id, false, actor)
+		if err != nil {
+			return ReprovisionResult{}, err
+		}
+		return ReprovisionResult{Database: db, Connection: conn}, nil
+	}
+	provisioner, err := s.provisionerForEngine(engine)
+	if err != nil {
+		return ReprovisionResult{}, err
+	}
+	if running, err := provisioner.IsRunning(ctx); err != nil {
+		return ReprovisionResult{}, fmt.Errorf("check %s status: %w", engine, err)
+	} else if !running {
+		return ReprovisionResult{}, fmt.Errorf("database engine %s is unavailable", engine)
+	}
+	password, err := randomHex(12)
+	if err != nil {
+		return ReprovisionResult{}, fmt.Errorf("generate password: %w", err)
+	}
+	if err := provisioner.CreateDatabase(ctx, db.DBName); err != nil {
+		return ReprovisionResult{}, err
+	}
+	if err := provisioner.CreateUser(ctx, db.DBUser, password, db.DBName); err != nil {
+		return ReprovisionResult{}, err
+	}
+	result := ReprovisionResult{Database: db, Connection: serverConnection(engine, db, password)}
+
+	dumps, err := listRecoveryBases(s.dumpDir(id))
+	if err != nil {
+		return result, err
+	}
+	if len(dumps) > 0 {
+		newest := dumps[len(dumps)-1].path
+		src, cleanup, err := s.plainBackup(ctx, newest)
+		if err != nil {
+			return result, err
+		}
+		defer cleanup()
+		if err := provisioner.Restore(ctx, db.DBName, db.DBUser, src); err != nil {
+			return result, fmt.Errorf("restore %s: %w", filepath.Base(newest), err)
+		}
+		result.Dump = filepath.Base(newest)
+	}
+	_ = s.writeAudit(ctx, actor, "database.reprovision", fmt.Sprintf(
+		"db=%s,engine=%s,dump=%s", db.DBName, engine, result.Dump))
+	return result, nil
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/modules/backup"
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+func TestService_ReprovisionDatabaseRestoresNewestDump(t *testing.T) {
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	seed := `
+INSERT INTO sites(id, domain, root_dir, php_version, system_user, status, created_at, updated_at)
+VALUES(1, 'test.example.com', '/var/www/test.example.com/public_html', '8.3', 'site_test', 'active', 1, 1);
+INSERT INTO site_databases(id, site_id, db_name, db_user, db_engine, created_at) VALUES(7, 1, 'shop', 'shop_user', 'mariadb', 1);
+`
+	if err := store.ExecPanel(ctx, seed); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	mariadb := &fakeMariaDB{}
+	svc := NewService(store, config.Config{}, slog.Default(), mariadb, nil)
+
+	dumpDir := svc.dumpDir(7)
+	if err := os.MkdirAll(dumpDir, 0o700); err != nil {
+		t.Fatalf("mkdir dump dir: %v", err)
+	}
+	keys, err := backup.LoadKeyring(ctx, store)
+	if err != nil {
+		t.Fatalf("load keyring: %v", err)
+	}
+	for i, name := range []string{"shop-20260101-000000.sql", "shop-20260102-000000.sql"} {
+		path := filepath.Join(dumpDir, name)
+		if err := os.WriteFile(path, []byte("-- dump\n"), 0o600); err != nil {
+			t.Fatalf("write dump: %v", err)
+		}
+		when := time.Date(2026, 1, 1+i, 0, 0, 0, 0, time.UTC)
+		if err := os.Chtimes(path, when, when); err != nil {
+			t.Fatalf("chtimes: %v", err)
+		}
+		if err := backup.EncryptFile(path, keys); err != nil {
+			t.Fatalf("encrypt dump: %v", err)
+		}
+	}
+
+	res, err := svc.ReprovisionDatabase(ctx, 7, "cli")
+	if err != nil {
+		t.Fatalf("reprovision: %v", err)
+	}
+	if res.Dump != "shop-20260102-000000.sql" || res.Connection.Password == "" || res.Connection.User != "shop_user" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if len(mariadb.createDBCalls) != 1 || len(mariadb.createUserCalls) != 1 ||
+		mariadb.createUserCalls[0] != "shop_user@shop:"+res.Connection.Password {
+		t.Fatalf("expected database and user recreated with the new password, got %+v", mariadb)
+	}
+	if len(mariadb.restoreCalls) != 1 || mariadb.restoreCalls[0] != "shop@shop_user<shop-20260102-000000.sql" {
+		t.Fatalf("expected the newest dump restored decrypted, got %v", mariadb.restoreCalls)
+	}
+	rows, err := store.QueryAuditJSON(ctx, "SELECT details FROM audit_events WHERE action = 'database.reprovision';")
+	if err != nil || len(rows) != 1 || !strings.Contains(rows[0]["details"].(string), "dump=shop-20260102-000000.sql") {
+		t.Fatalf("expected a database.reprovision audit event, got %+v err=%v", rows, err)
+	}
+}
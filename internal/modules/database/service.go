@@ -12,7 +12,12 @@ import (
 	"strings"
 	"time"
 
+	"github.com/robsonek/aiPanel/internal/modules/audit"
 	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/heartbeat"
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/internal/platform/middleware"
+	"github.com/robsonek/aiPanel/internal/platform/settings"
 	"github.com/robsonek/aiPanel/internal/platform/sqlite"
 	"github.com/robsonek/aiPanel/pkg/adapter"
 )
@@ -20,6 +25,8 @@ import (
 var (
 	// ErrDatabaseNotFound indicates missing database row.
 	ErrDatabaseNotFound = errors.New("database not found")
+	// ErrStatsUnsupported indicates the database engine does not report stats.
+	ErrStatsUnsupported = errors.New("database engine does not support stats")
 	databaseNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
 )
 
@@ -28,6 +35,12 @@ const (
 	DBEngineMariaDB = "mariadb"
 	// DBEnginePostgreSQL marks PostgreSQL-backed site database metadata.
 	DBEnginePostgreSQL = "postgres"
+	// DBEngineMySQL marks MySQL (Oracle)-backed site database metadata.
+	DBEngineMySQL = "mysql"
+	// DBEngineMongoDB marks MongoDB-backed site database metadata.
+	DBEngineMongoDB = "mongodb"
+	// DBEngineSQLite marks file-backed SQLite site database metadata.
+	DBEngineSQLite = "sqlite"
 )
 
 type databaseProvisioner interface {
@@ -35,6 +48,9 @@ type databaseProvisioner interface {
 	DropDatabase(ctx context.Context, dbName string) error
 	CreateUser(ctx context.Context, username, password, dbName string) error
 	DropUser(ctx context.Context, username string) error
+	SetPassword(ctx context.Context, username, password string) error
+	Dump(ctx context.Context, dbName, dest string) error
+	Restore(ctx context.Context, dbName, owner, src string) error
 	IsRunning(ctx context.Context) (bool, error)
 }
 
@@ -45,6 +61,22 @@ type Service struct {
 	log        *slog.Logger
 	mariadb    adapter.MariaDB
 	postgresql adapter.PostgreSQL
+	mysql      adapter.MySQL
+	mongodb    adapter.MongoDB
+	sqlite     adapter.SQLiteFile
+	jobs       *jobqueue.Queue
+	// ping reports scheduled backup outcomes to heartbeat monitors.
+	ping func(ctx context.Context, url string, runErr error) error
+	// settings overrides the backup defaults; nil uses cfg and the
+	// built-in defaults.
+	settings *settings.Store
+}
+
+// ServiceOptions wires optional engine adapters into NewService.
+type ServiceOptions struct {
+	MySQL   adapter.MySQL
+	MongoDB adapter.MongoDB
+	SQLite  adapter.SQLiteFile
 }
 
 // NewService creates a database service.
@@ -54,19 +86,34 @@ func NewService(
 	log *slog.Logger,
 	mariadb adapter.MariaDB,
 	postgresql adapter.PostgreSQL,
+	opts ...ServiceOptions,
 ) *Service {
 	if log == nil {
 		log = slog.Default()
 	}
+	var opt ServiceOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
 	return &Service{
 		store:      store,
 		cfg:        cfg,
 		log:        log,
 		mariadb:    mariadb,
 		postgresql: postgresql,
+		mysql:      opt.MySQL,
+		mongodb:    opt.MongoDB,
+		sqlite:     opt.SQLite,
+		ping:       heartbeat.Ping,
 	}
 }
 
+// SetSettings sets the store of settings changed in the panel: the default
+// backup retention and the point-in-time recovery window.
+func (s *Service) SetSettings(store *settings.Store) {
+	s.settings = store
+}
+
 // CreateDatabase provisions DB + user in selected engine and stores metadata.
 func (s *Service) CreateDatabase(ctx context.Context, req CreateDatabaseRequest) (CreateDatabaseResult, error) {
 	if s.store == nil {
@@ -89,10 +136,20 @@ func (s *Service) CreateDatabase(ctx context.Context, req CreateDatabaseRequest)
 	if err != nil {
 		return CreateDatabaseResult{}, err
 	}
+	if engine == DBEngineSQLite {
+		return s.createSQLiteDatabase(ctx, req, dbName)
+	}
 	provisioner, err := s.provisionerForEngine(engine)
 	if err != nil {
 		return CreateDatabaseResult{}, err
 	}
+	disabled, err := s.disabledEngines(ctx)
+	if err != nil {
+		return CreateDatabaseResult{}, err
+	}
+	if disabled[engine] {
+		return CreateDatabaseResult{}, fmt.Errorf("database engine %s is disabled and unavailable", engine)
+	}
 	isRunning, err := provisioner.IsRunning(ctx)
 	if err != nil {
 		return CreateDatabaseResult{}, fmt.Errorf("check %s status: %w", engine, err)
@@ -100,6 +157,13 @@ func (s *Service) CreateDatabase(ctx context.Context, req CreateDatabaseRequest)
 	if !isRunning {
 		return CreateDatabaseResult{}, fmt.Errorf("database engine %s is unavailable", engine)
 	}
+	// Checked up front: provisioning is idempotent and the rollback below
+	// would otherwise drop the existing database.
+	if _, err := s.getByNameAndEngine(ctx, dbName, engine); err == nil {
+		return CreateDatabaseResult{}, fmt.Errorf("database %s already exists", dbName)
+	} else if !errors.Is(err, ErrDatabaseNotFound) {
+		return CreateDatabaseResult{}, err
+	}
 
 	dbUser := dbUserForName(engine, dbName)
 	password, err := randomHex(12)
@@ -153,12 +217,18 @@ VALUES(%d,'%s','%s','%s',%d);`,
 }
 
 // AvailableEngines returns currently running engines configured in the service.
+// Engines whose runtime component was disabled are never listed. SQLite has
+// no server and is listed whenever it is configured.
 func (s *Service) AvailableEngines(ctx context.Context) ([]string, error) {
 	if s.store == nil {
 		return nil, fmt.Errorf("database service is not configured")
 	}
-	engines := make([]string, 0, 2)
-	if s.mariadb != nil {
+	disabled, err := s.disabledEngines(ctx)
+	if err != nil {
+		return nil, err
+	}
+	engines := make([]string, 0, 5)
+	if s.mariadb != nil && !disabled[DBEngineMariaDB] {
 		ok, err := s.mariadb.IsRunning(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("check %s status: %w", DBEngineMariaDB, err)
@@ -167,7 +237,7 @@ func (s *Service) AvailableEngines(ctx context.Context) ([]string, error) {
 			engines = append(engines, DBEngineMariaDB)
 		}
 	}
-	if s.postgresql != nil {
+	if s.postgresql != nil && !disabled[DBEnginePostgreSQL] {
 		ok, err := s.postgresql.IsRunning(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("check %s status: %w", DBEnginePostgreSQL, err)
@@ -176,9 +246,53 @@ func (s *Service) AvailableEngines(ctx context.Context) ([]string, error) {
 			engines = append(engines, DBEnginePostgreSQL)
 		}
 	}
+	if s.mysql != nil && !disabled[DBEngineMySQL] {
+		ok, err := s.mysql.IsRunning(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("check %s status: %w", DBEngineMySQL, err)
+		}
+		if ok {
+			engines = append(engines, DBEngineMySQL)
+		}
+	}
+	if s.mongodb != nil && !disabled[DBEngineMongoDB] {
+		ok, err := s.mongodb.IsRunning(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("check %s status: %w", DBEngineMongoDB, err)
+		}
+		if ok {
+			engines = append(engines, DBEngineMongoDB)
+		}
+	}
+	if s.sqlite != nil {
+		engines = append(engines, DBEngineSQLite)
+	}
 	return engines, nil
 }
 
+// disabledEngines reads runtime components disabled through the version
+// manager and maps them to engine names.
+func (s *Service) disabledEngines(ctx context.Context) (map[string]bool, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, "SELECT name FROM runtime_components WHERE enabled = 0;")
+	if err != nil {
+		return nil, fmt.Errorf("list disabled components: %w", err)
+	}
+	out := map[string]bool{}
+	for _, row := range rows {
+		switch fmt.Sprint(row["name"]) {
+		case "mariadb":
+			out[DBEngineMariaDB] = true
+		case "postgresql":
+			out[DBEnginePostgreSQL] = true
+		case "mysql":
+			out[DBEngineMySQL] = true
+		case "mongodb":
+			out[DBEngineMongoDB] = true
+		}
+	}
+	return out, nil
+}
+
 func normalizeDatabaseName(raw string) (string, error) {
 	value := strings.TrimSpace(raw)
 	if value == "" {
@@ -246,6 +360,12 @@ func (s *Service) DeleteDatabase(ctx context.Context, id int64, actor string) er
 	if err != nil {
 		return err
 	}
+	if engine == DBEngineSQLite {
+		if err = s.deleteSQLiteDatabase(ctx, db); err != nil {
+			return err
+		}
+		return s.deleteDatabaseRow(ctx, db, actor)
+	}
 	provisioner, err := s.provisionerForEngine(engine)
 	if err != nil {
 		return err
@@ -266,14 +386,41 @@ func (s *Service) DeleteDatabase(ctx context.Context, id int64, actor string) er
 			return err
 		}
 	}
-	del := fmt.Sprintf("DELETE FROM site_databases WHERE id = %d;", id)
-	if err = s.store.ExecPanel(ctx, del); err != nil {
+	return s.deleteDatabaseRow(ctx, db, actor)
+}
+
+func (s *Service) deleteDatabaseRow(ctx context.Context, db SiteDatabase, actor string) error {
+	del := fmt.Sprintf(`
+DELETE FROM database_backup_schedules WHERE database_id = %d;
+DELETE FROM site_databases WHERE id = %d;`, db.ID, db.ID)
+	if err := s.store.ExecPanel(ctx, del); err != nil {
 		return fmt.Errorf("delete database row: %w", err)
 	}
-	_ = s.writeAudit(ctx, actor, "database.delete", "db="+db.DBName+",engine="+engine)
+	// The row is gone, so the ids are recorded for the activity feeds.
+	_ = s.writeAudit(ctx, actor, "database.delete", fmt.Sprintf(
+		"db=%s,engine=%s,database_id=%d,site_id=%d", db.DBName, db.DBEngine, db.ID, db.SiteID))
 	return nil
 }
 
+// DatabaseStats returns storage statistics for a database. Only document
+// engines (MongoDB) report stats.
+func (s *Service) DatabaseStats(ctx context.Context, id int64) (adapter.DocumentDBStats, error) {
+	if s.store == nil {
+		return adapter.DocumentDBStats{}, fmt.Errorf("database service is not configured")
+	}
+	db, err := s.getByID(ctx, id)
+	if err != nil {
+		return adapter.DocumentDBStats{}, err
+	}
+	if db.DBEngine != DBEngineMongoDB {
+		return adapter.DocumentDBStats{}, ErrStatsUnsupported
+	}
+	if s.mongodb == nil {
+		return adapter.DocumentDBStats{}, fmt.Errorf("database engine mongodb is not configured")
+	}
+	return s.mongodb.Stats(ctx, db.DBName)
+}
+
 func (s *Service) siteExists(ctx context.Context, siteID int64) (bool, error) {
 	query := fmt.Sprintf("SELECT id FROM sites WHERE id = %d LIMIT 1;", siteID)
 	rows, err := s.store.QueryPanelJSON(ctx, query)
@@ -283,6 +430,24 @@ func (s *Service) siteExists(ctx context.Context, siteID int64) (bool, error) {
 	return len(rows) > 0, nil
 }
 
+// DatabaseSiteID returns the id of the site owning a database.
+func (s *Service) DatabaseSiteID(ctx context.Context, id int64) (int64, error) {
+	db, err := s.getByID(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	return db.SiteID, nil
+}
+
+// DatabaseActivity returns the audit events touching a database, newest
+// first. beforeID pages backwards from an earlier page.
+func (s *Service) DatabaseActivity(ctx context.Context, id, beforeID int64, limit int) ([]audit.Event, error) {
+	if _, err := s.getByID(ctx, id); err != nil {
+		return nil, err
+	}
+	return audit.List(ctx, s.store, audit.Query{DatabaseID: id, BeforeID: beforeID, Limit: limit})
+}
+
 func (s *Service) getByID(ctx context.Context, id int64) (SiteDatabase, error) {
 	query := fmt.Sprintf(`
 SELECT id, site_id, db_name, db_user, db_engine, created_at
@@ -371,7 +536,7 @@ func normalizeDatabaseEngine(raw string) (string, error) {
 		return "", fmt.Errorf("invalid database engine")
 	}
 	switch engine {
-	case DBEngineMariaDB, DBEnginePostgreSQL:
+	case DBEngineMariaDB, DBEnginePostgreSQL, DBEngineMySQL, DBEngineMongoDB, DBEngineSQLite:
 		return engine, nil
 	default:
 		return "", fmt.Errorf("invalid database engine")
@@ -390,6 +555,16 @@ func (s *Service) provisionerForEngine(engine string) (databaseProvisioner, erro
 			return nil, fmt.Errorf("database engine postgres is not configured")
 		}
 		return s.postgresql, nil
+	case DBEngineMySQL:
+		if s.mysql == nil {
+			return nil, fmt.Errorf("database engine mysql is not configured")
+		}
+		return s.mysql, nil
+	case DBEngineMongoDB:
+		if s.mongodb == nil {
+			return nil, fmt.Errorf("database engine mongodb is not configured")
+		}
+		return s.mongodb, nil
 	default:
 		return nil, fmt.Errorf("invalid database engine")
 	}
@@ -431,12 +606,45 @@ func (s *Service) writeAudit(ctx context.Context, actor, action, details string)
 	if strings.TrimSpace(actor) == "" {
 		actor = "system"
 	}
+	siteID, databaseID := s.auditRefs(ctx, details)
 	sql := fmt.Sprintf(
-		"INSERT INTO audit_events(actor, action, details, created_at) VALUES('%s','%s','%s',%d);",
+		"INSERT INTO audit_events(actor, action, details, remote_ip, impersonator, site_id, database_id, created_at) VALUES('%s','%s','%s','%s','%s',%d,%d,%d);",
 		sqlEscape(actor),
 		sqlEscape(action),
 		sqlEscape(details),
+		sqlEscape(middleware.ClientIP(ctx)),
+		sqlEscape(middleware.Impersonator(ctx)),
+		siteID,
+		databaseID,
 		time.Now().Unix(),
 	)
 	return s.store.ExecAudit(ctx, sql)
 }
+
+// auditRefs resolves the database and site an event touches from its
+// details: explicit database_id and site_id win, otherwise the database is
+// looked up by db and engine.
+func (s *Service) auditRefs(ctx context.Context, details string) (siteID, databaseID int64) {
+	siteID = audit.FieldID(details, "site_id")
+	databaseID = audit.FieldID(details, "database_id")
+	var db SiteDatabase
+	var err error
+	switch name := audit.Field(details, "db"); {
+	case databaseID > 0:
+		db, err = s.getByID(ctx, databaseID)
+	case name != "":
+		db, err = s.getByNameAndEngine(ctx, name, audit.Field(details, "engine"))
+	default:
+		return siteID, databaseID
+	}
+	if err != nil {
+		return siteID, databaseID
+	}
+	if databaseID == 0 {
+		databaseID = db.ID
+	}
+	if siteID == 0 {
+		siteID = db.SiteID
+	}
+	return siteID, databaseID
+}
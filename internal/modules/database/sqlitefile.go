@@ -0,0 +1,185 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrSQLiteOnly indicates an operation that only applies to SQLite databases.
+var ErrSQLiteOnly = errors.New("operation is only supported for sqlite databases")
+
+// SQLiteBackup describes one backup copy written into the site private dir.
+type SQLiteBackup struct {
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SQLiteSnapshot is a consistent temporary copy of an SQLite database.
+// Close removes it.
+type SQLiteSnapshot struct {
+	Database SiteDatabase
+	Path     string
+	dir      string
+}
+
+// Close removes the snapshot from disk.
+func (s *SQLiteSnapshot) Close() error {
+	return os.RemoveAll(s.dir)
+}
+
+// sqliteSite holds the site fields needed to place a database file.
+type sqliteSite struct {
+	rootDir    string
+	systemUser string
+}
+
+// createSQLiteDatabase creates <site>/private/databases/<name>.sqlite owned
+// by the site user. SQLite has no users, so the site user is recorded as
+// db_user and no password is returned.
+func (s *Service) createSQLiteDatabase(ctx context.Context, req CreateDatabaseRequest, dbName string) (CreateDatabaseResult, error) {
+	if s.sqlite == nil {
+		return CreateDatabaseResult{}, fmt.Errorf("database engine sqlite is not configured")
+	}
+	site, err := s.sqliteSiteByID(ctx, req.SiteID)
+	if err != nil {
+		return CreateDatabaseResult{}, err
+	}
+	if _, err := s.getByNameAndEngine(ctx, dbName, DBEngineSQLite); err == nil {
+		return CreateDatabaseResult{}, fmt.Errorf("database %s already exists", dbName)
+	} else if !errors.Is(err, ErrDatabaseNotFound) {
+		return CreateDatabaseResult{}, err
+	}
+	path := sqliteDatabasePath(site.rootDir, dbName)
+	if err := s.sqlite.CreateFile(ctx, path, site.systemUser); err != nil {
+		return CreateDatabaseResult{}, err
+	}
+
+	insert := fmt.Sprintf(`
+INSERT INTO site_databases(site_id, db_name, db_user, db_engine, created_at)
+VALUES(%d,'%s','%s','%s',%d);`,
+		req.SiteID,
+		sqlEscape(dbName),
+		sqlEscape(site.systemUser),
+		DBEngineSQLite,
+		time.Now().Unix(),
+	)
+	if err := s.store.ExecPanel(ctx, insert); err != nil {
+		_ = s.sqlite.RemoveFile(ctx, path)
+		return CreateDatabaseResult{}, fmt.Errorf("insert database row: %w", err)
+	}
+	_ = s.writeAudit(ctx, req.Actor, "database.create", "db="+dbName+",engine="+DBEngineSQLite)
+
+	db, err := s.getByNameAndEngine(ctx, dbName, DBEngineSQLite)
+	if err != nil {
+		return CreateDatabaseResult{}, err
+	}
+	return CreateDatabaseResult{Database: db, Path: path}, nil
+}
+
+// BackupDatabase writes a timestamped copy of an SQLite database to
+// <site>/private/databases/backups. Backups are kept when the database is
+// deleted.
+func (s *Service) BackupDatabase(ctx context.Context, id int64, actor string) (SQLiteBackup, error) {
+	db, site, err := s.sqliteDatabase(ctx, id)
+	if err != nil {
+		return SQLiteBackup{}, err
+	}
+	now := time.Now().UTC()
+	dest := filepath.Join(
+		filepath.Dir(sqliteDatabasePath(site.rootDir, db.DBName)),
+		"backups",
+		fmt.Sprintf("%s-%s.sqlite", db.DBName, now.Format("20060102-150405")),
+	)
+	if err := s.sqlite.Backup(ctx, sqliteDatabasePath(site.rootDir, db.DBName), dest, site.systemUser); err != nil {
+		return SQLiteBackup{}, err
+	}
+	info, err := os.Stat(dest)
+	if err != nil {
+		return SQLiteBackup{}, fmt.Errorf("stat backup: %w", err)
+	}
+	_ = s.writeAudit(ctx, actor, "database.backup", "db="+db.DBName+",engine="+DBEngineSQLite)
+	return SQLiteBackup{Path: dest, Size: info.Size(), CreatedAt: now}, nil
+}
+
+// SnapshotDatabase writes a consistent copy of an SQLite database to a
+// temporary dir for download. The caller must Close the snapshot.
+func (s *Service) SnapshotDatabase(ctx context.Context, id int64, actor string) (*SQLiteSnapshot, error) {
+	db, site, err := s.sqliteDatabase(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "aipanel-sqlite-*")
+	if err != nil {
+		return nil, fmt.Errorf("create snapshot dir: %w", err)
+	}
+	snap := &SQLiteSnapshot{Database: db, Path: filepath.Join(dir, db.DBName+".sqlite"), dir: dir}
+	if err := s.sqlite.Backup(ctx, sqliteDatabasePath(site.rootDir, db.DBName), snap.Path, ""); err != nil {
+		_ = snap.Close()
+		return nil, err
+	}
+	_ = s.writeAudit(ctx, actor, "database.download", "db="+db.DBName+",engine="+DBEngineSQLite)
+	return snap, nil
+}
+
+func (s *Service) deleteSQLiteDatabase(ctx context.Context, db SiteDatabase) error {
+	if s.sqlite == nil {
+		return fmt.Errorf("database engine sqlite is not configured")
+	}
+	site, err := s.sqliteSiteByID(ctx, db.SiteID)
+	if err != nil {
+		return err
+	}
+	return s.sqlite.RemoveFile(ctx, sqliteDatabasePath(site.rootDir, db.DBName))
+}
+
+func (s *Service) sqliteDatabase(ctx context.Context, id int64) (SiteDatabase, sqliteSite, error) {
+	if s.store == nil {
+		return SiteDatabase{}, sqliteSite{}, fmt.Errorf("database service is not configured")
+	}
+	db, err := s.getByID(ctx, id)
+	if err != nil {
+		return SiteDatabase{}, sqliteSite{}, err
+	}
+	if db.DBEngine != DBEngineSQLite {
+		return SiteDatabase{}, sqliteSite{}, ErrSQLiteOnly
+	}
+	if s.sqlite == nil {
+		return SiteDatabase{}, sqliteSite{}, fmt.Errorf("database engine sqlite is not configured")
+	}
+	site, err := s.sqliteSiteByID(ctx, db.SiteID)
+	if err != nil {
+		return SiteDatabase{}, sqliteSite{}, err
+	}
+	return db, site, nil
+}
+
+func (s *Service) sqliteSiteByID(ctx context.Context, siteID int64) (sqliteSite, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT root_dir, system_user FROM sites WHERE id = %d LIMIT 1;", siteID))
+	if err != nil {
+		return sqliteSite{}, fmt.Errorf("get site: %w", err)
+	}
+	if len(rows) == 0 {
+		return sqliteSite{}, fmt.Errorf("site not found")
+	}
+	site := sqliteSite{
+		rootDir:    strings.TrimSpace(fmt.Sprint(rows[0]["root_dir"])),
+		systemUser: strings.TrimSpace(fmt.Sprint(rows[0]["system_user"])),
+	}
+	if site.rootDir == "" || site.systemUser == "" {
+		return sqliteSite{}, fmt.Errorf("site is missing root dir or system user")
+	}
+	return site, nil
+}
+
+// sqliteDatabasePath keeps database files next to, never inside, the
+// docroot so nginx cannot serve them.
+func sqliteDatabasePath(rootDir, dbName string) string {
+	return filepath.Join(filepath.Dir(rootDir), "private", "databases", dbName+".sqlite")
+}
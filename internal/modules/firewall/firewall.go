@@ -0,0 +1,4 @@
+// Package firewall manages the nftables ruleset of the host: a default
+// deny inbound baseline plus admin rules, applied behind a confirmation
+// timer so a bad rule cannot lock the admin out for good.
+package firewall
@@ -0,0 +1,177 @@
+package firewall
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+type fakeRunner struct {
+	commands []string
+	fail     map[string]error
+}
+
+func (r *fakeRunner) Run(_ context.Context, name string, args ...string) (string, error) {
+	cmd := name + " " + strings.Join(args, " ")
+	r.commands = append(r.commands, cmd)
+	for prefix, err := range r.fail {
+		if strings.HasPrefix(cmd, prefix) {
+			return "line 7: syntax error", err
+		}
+	}
+	return "", nil
+}
+
+func newTestService(t *testing.T) (*Service, *fakeRunner, string) {
+	t.Helper()
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	rulesPath := filepath.Join(t.TempDir(), "firewall.nft")
+	runner := &fakeRunner{}
+	cfg := config.Config{Addr: ":9443", SSHPort: 2222, FirewallConfirmWindow: 5 * time.Minute}
+	svc := NewService(store, cfg, slog.Default(), runner, Options{RulesPath: rulesPath, NftBin: "nft"})
+	return svc, runner, rulesPath
+}
+
+func TestRender_BaselineAndRules(t *testing.T) {
+	got := Render(Baseline{SSHPort: 22, PanelPort: 443}, []Rule{
+		{ID: 1, Protocol: ProtocolUDP, Port: 51820, Action: ActionAllow, Comment: "wireguard"},
+		{ID: 2, Protocol: ProtocolTCP, Port: 443, Source: "2001:db8::/32", Action: ActionDeny},
+	})
+	for _, want := range []string{
+		"table inet aipanel\ndelete table inet aipanel\n",
+		"policy drop;",
+		"ct state established,related accept",
+		`udp dport 51820 accept comment "rule 1: wireguard"`,
+		`ip6 saddr 2001:db8::/32 tcp dport 443 drop comment "rule 2"`,
+		`tcp dport { 22, 80, 443 } accept comment "baseline"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected %q in ruleset, got:\n%s", want, got)
+		}
+	}
+	if strings.Index(got, "rule 2") > strings.Index(got, "baseline") {
+		t.Fatalf("expected admin rules before the baseline, got:\n%s", got)
+	}
+}
+
+func TestService_ChangeConfirm(t *testing.T) {
+	ctx := context.Background()
+	svc, runner, rulesPath := newTestService(t)
+
+	if _, err := svc.Create(ctx, RuleRequest{Port: 70000}); err == nil || !strings.Contains(err.Error(), "invalid port") {
+		t.Fatalf("expected invalid port, got %v", err)
+	}
+	if _, err := svc.Create(ctx, RuleRequest{Port: 25, Source: "example.com"}); err == nil || !strings.Contains(err.Error(), "invalid source") {
+		t.Fatalf("expected invalid source, got %v", err)
+	}
+
+	rule, err := svc.Create(ctx, RuleRequest{Port: 3306, Source: "10.0.0.7/8", Action: "allow", Comment: "replica", Actor: "admin@example.com"})
+	if err != nil {
+		t.Fatalf("create rule: %v", err)
+	}
+	if rule.Protocol != ProtocolTCP || rule.Action != ActionAllow || rule.Source != "10.0.0.0/8" {
+		t.Fatalf("unexpected rule: %+v", rule)
+	}
+	pending, err := os.ReadFile(rulesPath + ".pending") //nolint:gosec // test reads file generated in temp dir.
+	if err != nil || !strings.Contains(string(pending), "ip saddr 10.0.0.0/8 tcp dport 3306 accept") {
+		t.Fatalf("expected pending ruleset, got %q (%v)", pending, err)
+	}
+	joined := strings.Join(runner.commands, "\n")
+	for _, want := range []string{
+		"nft -c -f " + rulesPath + ".pending",
+		"systemd-run --unit=aipanel-firewall-revert --collect --on-active=300s nft delete table inet aipanel",
+		"nft -f " + rulesPath + ".pending",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Fatalf("expected %q, got:\n%s", want, joined)
+		}
+	}
+	status, err := svc.Status(ctx)
+	if err != nil || !status.Pending || status.RevertAt == nil || status.Rules != 1 {
+		t.Fatalf("expected pending status, got %+v (%v)", status, err)
+	}
+	if got := status.BaselinePorts; len(got) != 4 || got[2] != 2222 || got[3] != 9443 {
+		t.Fatalf("unexpected baseline ports: %v", got)
+	}
+
+	if err := svc.Confirm(ctx, "admin@example.com"); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	if _, err := os.Stat(rulesPath); err != nil {
+		t.Fatalf("expected confirmed ruleset: %v", err)
+	}
+	if err := svc.Confirm(ctx, "admin@example.com"); !errors.Is(err, ErrNothingPending) {
+		t.Fatalf("expected nothing pending, got %v", err)
+	}
+
+	// Once a ruleset is confirmed, the timer reloads it instead of
+	// dropping the table.
+	runner.commands = nil
+	if err := svc.Delete(ctx, rule.ID, "admin@example.com"); err != nil {
+		t.Fatalf("delete rule: %v", err)
+	}
+	if joined := strings.Join(runner.commands, "\n"); !strings.Contains(joined, "--on-active=300s nft -f "+rulesPath) {
+		t.Fatalf("expected revert to confirmed ruleset, got:\n%s", joined)
+	}
+	if err := svc.Revert(ctx, "admin@example.com"); err != nil {
+		t.Fatalf("revert: %v", err)
+	}
+	rules, err := svc.List(ctx)
+	if err != nil || len(rules) != 1 || rules[0].ID != rule.ID || rules[0].Comment != "replica" {
+		t.Fatalf("expected confirmed rule back, got %+v (%v)", rules, err)
+	}
+	if _, err := os.Stat(rulesPath + ".pending"); !os.IsNotExist(err) {
+		t.Fatalf("expected pending ruleset removed, got %v", err)
+	}
+}
+
+func TestService_UnconfirmedChangeExpires(t *testing.T) {
+	ctx := context.Background()
+	svc, runner, _ := newTestService(t)
+	now := time.Now()
+	svc.now = func() time.Time { return now }
+
+	if _, err := svc.Create(ctx, RuleRequest{Port: 22, Action: "deny"}); err != nil {
+		t.Fatalf("create rule: %v", err)
+	}
+	now = now.Add(6 * time.Minute)
+	runner.commands = nil
+	status, err := svc.Status(ctx)
+	if err != nil || status.Pending || status.Rules != 0 {
+		t.Fatalf("expected expired change to be reverted, got %+v (%v)", status, err)
+	}
+	if joined := strings.Join(runner.commands, "\n"); !strings.Contains(joined, "systemctl stop aipanel-firewall-revert.timer") {
+		t.Fatalf("expected revert timer stop, got:\n%s", joined)
+	}
+}
+
+func TestService_InvalidRulesetIsRolledBack(t *testing.T) {
+	ctx := context.Background()
+	svc, runner, _ := newTestService(t)
+	runner.fail = map[string]error{"nft -c": errors.New("exit status 1")}
+
+	if _, err := svc.Create(ctx, RuleRequest{Port: 8443}); err == nil || !strings.Contains(err.Error(), "invalid ruleset: line 7") {
+		t.Fatalf("expected invalid ruleset, got %v", err)
+	}
+	rules, err := svc.List(ctx)
+	if err != nil || len(rules) != 0 {
+		t.Fatalf("expected rule to be rolled back, got %+v (%v)", rules, err)
+	}
+	for _, cmd := range runner.commands {
+		if strings.HasPrefix(cmd, "systemd-run") {
+			t.Fatalf("expected no revert timer for a rejected ruleset, got %q", cmd)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package firewall
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/platform/etag"
+	"github.com/robsonek/aiPanel/internal/platform/jsonstream"
+)
+
+// Handler exposes HTTP handlers for firewall rules.
+type Handler struct {
+	svc *Service
+}
+
+// NewHandler creates firewall HTTP handler.
+func NewHandler(svc *Service) *Handler {
+	return &Handler{svc: svc}
+}
+
+// HandleStatus serves GET /api/firewall.
+func (h *Handler) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status, err := h.svc.Status(r.Context())
+	if err != nil {
+		writeFirewallError(w, "failed to get firewall status", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"firewall": status})
+}
+
+// HandleConfirm serves POST /api/firewall/confirm.
+func (h *Handler) HandleConfirm(w http.ResponseWriter, r *http.Request, actor string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := h.svc.Confirm(r.Context(), actor); err != nil {
+		writeFirewallError(w, "failed to confirm firewall change", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleRevert serves POST /api/firewall/revert.
+func (h *Handler) HandleRevert(w http.ResponseWriter, r *http.Request, actor string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := h.svc.Revert(r.Context(), actor); err != nil {
+		writeFirewallError(w, "failed to revert firewall change", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleRules serves GET/POST /api/firewall/rules.
+func (h *Handler) HandleRules(w http.ResponseWriter, r *http.Request, actor string) {
+	switch r.Method {
+	case http.MethodGet:
+		rules, err := h.svc.List(r.Context())
+		if err != nil {
+			http.Error(w, "failed to list firewall rules", http.StatusInternalServerError)
+			return
+		}
+		jsonstream.List(w, r, "rules", rules)
+	case http.MethodPost:
+		var req RuleRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Actor = actor
+		rule, err := h.svc.Create(r.Context(), req)
+		if err != nil {
+			writeFirewallError(w, "failed to create firewall rule", err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]any{"rule": rule})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleRuleByID serves GET/PUT/DELETE /api/firewall/rules/{id}.
+func (h *Handler) HandleRuleByID(w http.ResponseWriter, r *http.Request, actor string) {
+	id, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/firewall/rules/"), "/"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid rule id", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		rule, err := h.svc.Get(r.Context(), id)
+		if err != nil {
+			writeFirewallError(w, "failed to get firewall rule", err)
+			return
+		}
+		etag.Set(w, ruleETag(rule))
+		writeJSON(w, http.StatusOK, map[string]any{"rule": rule})
+	case http.MethodPut:
+		var req RuleRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !h.checkVersion(w, r, id) {
+			return
+		}
+		req.Actor = actor
+		rule, err := h.svc.Update(r.Context(), id, req)
+		if err != nil {
+			writeFirewallError(w, "failed to update firewall rule", err)
+			return
+		}
+		etag.Set(w, ruleETag(rule))
+		writeJSON(w, http.StatusOK, map[string]any{"rule": rule})
+	case http.MethodDelete:
+		if !h.checkVersion(w, r, id) {
+			return
+		}
+		if err := h.svc.Delete(r.Context(), id, actor); err != nil {
+			writeFirewallError(w, "failed to delete firewall rule", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func ruleETag(rule Rule) string {
+	return etag.For("firewall-rule", rule.ID, rule.UpdatedAt)
+}
+
+// checkVersion answers 412 when If-Match names an outdated version of the
+// rule, so concurrent edits do not overwrite each other.
+func (h *Handler) checkVersion(w http.ResponseWriter, r *http.Request, id int64) bool {
+	if r.Header.Get("If-Match") == "" {
+		return true
+	}
+	rule, err := h.svc.Get(r.Context(), id)
+	if err != nil {
+		writeFirewallError(w, "failed to get firewall rule", err)
+		return false
+	}
+	return etag.Check(w, r, ruleETag(rule))
+}
+
+func writeFirewallError(w http.ResponseWriter, prefix string, err error) {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case errors.Is(err, ErrRuleNotFound):
+		http.Error(w, "firewall rule not found", http.StatusNotFound)
+	case errors.Is(err, ErrNothingPending):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case strings.Contains(msg, "invalid"), strings.Contains(msg, "required"):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, prefix+": "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
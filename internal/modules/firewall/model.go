@@ -0,0 +1,100 @@
+package firewall
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Protocols and actions of a rule; actions are nft verdicts.
+const (
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+	ActionAllow = "accept"
+	ActionDeny  = "drop"
+)
+
+// Rule accepts or drops inbound traffic to Port, from Source when set
+// (an IP or CIDR) or from anywhere.
+type Rule struct {
+	ID        int64     `json:"id"`
+	Protocol  string    `json:"protocol"`
+	Port      int       `json:"port"`
+	Source    string    `json:"source"`
+	Action    string    `json:"action"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RuleRequest creates or replaces a rule. Protocol defaults to tcp and
+// Action to accept.
+type RuleRequest struct {
+	Protocol string `json:"protocol"`
+	Port     int    `json:"port"`
+	Source   string `json:"source"`
+	Action   string `json:"action"`
+	Comment  string `json:"comment"`
+	Actor    string `json:"-"`
+}
+
+// Status describes the live ruleset. While Pending, the last change is
+// live but unconfirmed and the confirmed rules come back at RevertAt.
+type Status struct {
+	BaselinePorts []int      `json:"baseline_ports"`
+	Pending       bool       `json:"pending"`
+	RevertAt      *time.Time `json:"revert_at,omitempty"`
+	Rules         int        `json:"rules"`
+}
+
+// commentPattern keeps comments printable and free of nft syntax.
+var commentPattern = regexp.MustCompile(`^[A-Za-z0-9 ._:/@()-]{0,64}$`)
+
+func normalizeRequest(req RuleRequest) (RuleRequest, error) {
+	req.Protocol = strings.ToLower(strings.TrimSpace(req.Protocol))
+	if req.Protocol == "" {
+		req.Protocol = ProtocolTCP
+	}
+	if req.Protocol != ProtocolTCP && req.Protocol != ProtocolUDP {
+		return req, fmt.Errorf("invalid protocol: expected tcp or udp")
+	}
+	if req.Port < 1 || req.Port > 65535 {
+		return req, fmt.Errorf("invalid port: must be in 1-65535")
+	}
+	req.Action = strings.ToLower(strings.TrimSpace(req.Action))
+	switch req.Action {
+	case "", "allow", ActionAllow:
+		req.Action = ActionAllow
+	case "deny", ActionDeny:
+		req.Action = ActionDeny
+	default:
+		return req, fmt.Errorf("invalid action: expected accept or drop")
+	}
+	req.Source = strings.TrimSpace(req.Source)
+	if req.Source != "" {
+		source, err := canonicalAddr(req.Source)
+		if err != nil {
+			return req, fmt.Errorf("invalid source: %w", err)
+		}
+		req.Source = source
+	}
+	req.Comment = strings.TrimSpace(req.Comment)
+	if !commentPattern.MatchString(req.Comment) {
+		return req, fmt.Errorf("invalid comment: use up to 64 letters, digits, spaces and ._:/@()-")
+	}
+	return req, nil
+}
+
+// canonicalAddr canonicalizes an IP or CIDR.
+func canonicalAddr(entry string) (string, error) {
+	if ip := net.ParseIP(entry); ip != nil {
+		return ip.String(), nil
+	}
+	_, network, err := net.ParseCIDR(entry)
+	if err != nil {
+		return "", err
+	}
+	return network.String(), nil
+}
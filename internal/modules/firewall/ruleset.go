@@ -0,0 +1,119 @@
+package firewall
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// UnitName loads the confirmed ruleset at boot.
+	UnitName = "aipanel-firewall.service"
+	// DefaultRulesPath holds the confirmed ruleset.
+	DefaultRulesPath = "/etc/aipanel/firewall.nft"
+	// DefaultNftBin is the nft binary of Debian's nftables package.
+	DefaultNftBin = "/usr/sbin/nft"
+	// tableName is the only table aiPanel touches; other tables (Docker,
+	// fail2ban) are left alone.
+	tableName = "inet aipanel"
+)
+
+// Baseline is what the firewall always allows inbound, whatever the
+// admin rules say: SSH, HTTP, HTTPS and the panel.
+type Baseline struct {
+	SSHPort   int
+	PanelPort int
+}
+
+// Ports returns the baseline ports, sorted and without duplicates.
+func (b Baseline) Ports() []int {
+	ports := []int{80, 443}
+	for _, port := range []int{b.SSHPort, b.PanelPort} {
+		if port > 0 && !slices.Contains(ports, port) {
+			ports = append(ports, port)
+		}
+	}
+	slices.Sort(ports)
+	return ports
+}
+
+// PanelPort returns the port the panel is reached on for addr, as the
+// installer derives it for the panel vhost.
+func PanelPort(addr string) int {
+	_, port, err := net.SplitHostPort(strings.TrimSpace(addr))
+	if n, convErr := strconv.Atoi(port); err == nil && convErr == nil && n > 0 {
+		return n
+	}
+	return 8080
+}
+
+// Render returns the nftables script for the baseline and rules. It
+// replaces the aiPanel table atomically, so loading it twice is a no-op.
+// Admin rules come before the baseline accepts, so a drop rule can shut
+// out an address from 443 too; established connections always pass.
+func Render(base Baseline, rules []Rule) string {
+	var b strings.Builder
+	b.WriteString("#!/usr/sbin/nft -f\n")
+	b.WriteString("# Managed by aiPanel; change rules through /api/firewall/rules.\n")
+	fmt.Fprintf(&b, "table %s\ndelete table %s\n\n", tableName, tableName)
+	fmt.Fprintf(&b, "table %s {\n", tableName)
+	b.WriteString("\tchain input {\n")
+	b.WriteString("\t\ttype filter hook input priority filter; policy drop;\n")
+	b.WriteString("\t\tct state established,related accept\n")
+	b.WriteString("\t\tct state invalid drop\n")
+	b.WriteString("\t\tiifname \"lo\" accept\n")
+	b.WriteString("\t\tmeta l4proto { icmp, ipv6-icmp } accept\n")
+	for _, rule := range rules {
+		b.WriteString("\t\t" + ruleStatement(rule) + "\n")
+	}
+	ports := make([]string, 0, 4)
+	for _, port := range base.Ports() {
+		ports = append(ports, strconv.Itoa(port))
+	}
+	fmt.Fprintf(&b, "\t\ttcp dport { %s } accept comment \"baseline\"\n", strings.Join(ports, ", "))
+	b.WriteString("\t}\n}\n")
+	return b.String()
+}
+
+func ruleStatement(rule Rule) string {
+	parts := make([]string, 0, 5)
+	if rule.Source != "" {
+		family := "ip"
+		if strings.Contains(rule.Source, ":") {
+			family = "ip6"
+		}
+		parts = append(parts, family+" saddr "+rule.Source)
+	}
+	parts = append(parts, fmt.Sprintf("%s dport %d", rule.Protocol, rule.Port), rule.Action)
+	comment := fmt.Sprintf("rule %d", rule.ID)
+	if rule.Comment != "" {
+		comment += ": " + rule.Comment
+	}
+	parts = append(parts, "comment "+strconv.Quote(comment))
+	return strings.Join(parts, " ")
+}
+
+// RenderUnit returns aipanel-firewall.service, which loads the confirmed
+// ruleset at boot and drops the aiPanel table when stopped.
+func RenderUnit(nftBin, rulesPath string) string {
+	return strings.Join([]string{
+		"[Unit]",
+		"Description=aiPanel firewall",
+		"Wants=network-pre.target",
+		"Before=network-pre.target",
+		"After=nftables.service",
+		"",
+		"[Service]",
+		"Type=oneshot",
+		"RemainAfterExit=yes",
+		"ExecStart=" + nftBin + " -f " + rulesPath,
+		"ExecReload=" + nftBin + " -f " + rulesPath,
+		"ExecStop=-" + nftBin + " delete table " + tableName,
+		"",
+		"[Install]",
+		"WantedBy=multi-user.target",
+		"",
+	}, "\n")
+}
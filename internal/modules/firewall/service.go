@@ -0,0 +1,540 @@
+package firewall
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/middleware"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+	"github.com/robsonek/aiPanel/internal/platform/systemd"
+)
+
+// revertUnit is the transient timer (and service) systemd-run arms for
+// an unconfirmed change. It lives outside the panel process, so the
+// revert happens even when the change cut the panel off.
+const revertUnit = "aipanel-firewall-revert"
+
+var (
+	// ErrRuleNotFound indicates a missing firewall rule.
+	ErrRuleNotFound = errors.New("firewall rule not found")
+	// ErrNothingPending indicates there is no unconfirmed change.
+	ErrNothingPending = errors.New("no firewall change is waiting for confirmation")
+)
+
+// Options overrides file and binary locations, mainly for tests.
+type Options struct {
+	RulesPath string
+	NftBin    string
+}
+
+// Service stores firewall rules in panel.db and loads them into nftables.
+// Every change goes live at once but only becomes the boot ruleset once
+// confirmed; unconfirmed changes are reverted after the confirm window.
+type Service struct {
+	store  *sqlite.Store
+	log    *slog.Logger
+	runner systemd.Runner
+
+	base          Baseline
+	confirmWindow time.Duration
+	rulesPath     string
+	nftBin        string
+	// now is replaced in tests to expire the confirm window.
+	now func() time.Time
+
+	// mu serializes changes: the pending ruleset and the revert timer are
+	// shared by all of them.
+	mu sync.Mutex
+}
+
+// NewService creates a firewall service. The baseline opens cfg.SSHPort
+// and the port of cfg.Addr besides 80 and 443.
+func NewService(store *sqlite.Store, cfg config.Config, log *slog.Logger, runner systemd.Runner, opts Options) *Service {
+	if log == nil {
+		log = slog.Default()
+	}
+	if runner == nil {
+		runner = systemd.ExecRunner{}
+	}
+	if opts.RulesPath == "" {
+		opts.RulesPath = DefaultRulesPath
+	}
+	if opts.NftBin == "" {
+		opts.NftBin = DefaultNftBin
+	}
+	return &Service{
+		store:         store,
+		log:           log,
+		runner:        runner,
+		base:          Baseline{SSHPort: cfg.SSHPort, PanelPort: PanelPort(cfg.Addr)},
+		confirmWindow: cfg.FirewallConfirmWindow,
+		rulesPath:     opts.RulesPath,
+		nftBin:        opts.NftBin,
+		now:           time.Now,
+	}
+}
+
+// Status reports the baseline and whether a change awaits confirmation.
+func (s *Service) Status(ctx context.Context) (Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.expirePending(ctx); err != nil {
+		return Status{}, err
+	}
+	rules, err := s.listRules(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+	state, err := s.state(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+	status := Status{BaselinePorts: s.base.Ports(), Rules: len(rules)}
+	if state.pendingUntil > 0 {
+		revertAt := time.Unix(state.pendingUntil, 0).UTC()
+		status.Pending = true
+		status.RevertAt = &revertAt
+	}
+	return status, nil
+}
+
+// List returns the admin rules in the order nftables evaluates them.
+func (s *Service) List(ctx context.Context) ([]Rule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.expirePending(ctx); err != nil {
+		return nil, err
+	}
+	return s.listRules(ctx)
+}
+
+// Get returns one rule.
+func (s *Service) Get(ctx context.Context, id int64) (Rule, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT id, protocol, port, source, action, comment, created_at, updated_at
+FROM firewall_rules
+WHERE id = %d;`, id))
+	if err != nil {
+		return Rule{}, fmt.Errorf("get firewall rule: %w", err)
+	}
+	if len(rows) == 0 {
+		return Rule{}, ErrRuleNotFound
+	}
+	return mapRowToRule(rows[0])
+}
+
+// Create adds a rule and loads the new ruleset, pending confirmation.
+func (s *Service) Create(ctx context.Context, req RuleRequest) (Rule, error) {
+	req, err := normalizeRequest(req)
+	if err != nil {
+		return Rule{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.expirePending(ctx); err != nil {
+		return Rule{}, err
+	}
+	now := s.now().Unix()
+	rows, err := s.store.QueryPanelWriteJSON(ctx, fmt.Sprintf(`
+INSERT INTO firewall_rules(protocol, port, source, action, comment, created_at, updated_at)
+VALUES('%s',%d,'%s','%s','%s',%d,%d)
+RETURNING id;`,
+		req.Protocol, req.Port, sqlEscape(req.Source), req.Action, sqlEscape(req.Comment), now, now))
+	if err == nil && len(rows) == 0 {
+		err = fmt.Errorf("no id returned")
+	}
+	if err != nil {
+		return Rule{}, fmt.Errorf("insert firewall rule: %w", err)
+	}
+	id, err := toInt64(rows[0]["id"])
+	if err != nil {
+		return Rule{}, fmt.Errorf("parse firewall rule id: %w", err)
+	}
+	if err := s.stage(ctx); err != nil {
+		_ = s.store.ExecPanel(ctx, fmt.Sprintf("DELETE FROM firewall_rules WHERE id = %d;", id))
+		return Rule{}, err
+	}
+	_ = s.writeAudit(ctx, req.Actor, "firewall.rule.create", fmt.Sprintf("rule_id=%d %s", id, describe(req)))
+	return s.Get(ctx, id)
+}
+
+// Update replaces a rule and loads the new ruleset, pending confirmation.
+func (s *Service) Update(ctx context.Context, id int64, req RuleRequest) (Rule, error) {
+	req, err := normalizeRequest(req)
+	if err != nil {
+		return Rule{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.expirePending(ctx); err != nil {
+		return Rule{}, err
+	}
+	existing, err := s.Get(ctx, id)
+	if err != nil {
+		return Rule{}, err
+	}
+	if err := s.updateRule(ctx, id, req); err != nil {
+		return Rule{}, err
+	}
+	if err := s.stage(ctx); err != nil {
+		_ = s.updateRule(ctx, id, RuleRequest{
+			Protocol: existing.Protocol, Port: existing.Port, Source: existing.Source,
+			Action: existing.Action, Comment: existing.Comment,
+		})
+		return Rule{}, err
+	}
+	_ = s.writeAudit(ctx, req.Actor, "firewall.rule.update", fmt.Sprintf("rule_id=%d %s", id, describe(req)))
+	return s.Get(ctx, id)
+}
+
+// Delete removes a rule and loads the new ruleset, pending confirmation.
+func (s *Service) Delete(ctx context.Context, id int64, actor string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.expirePending(ctx); err != nil {
+		return err
+	}
+	existing, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf("DELETE FROM firewall_rules WHERE id = %d;", id)); err != nil {
+		return fmt.Errorf("delete firewall rule: %w", err)
+	}
+	if err := s.stage(ctx); err != nil {
+		_ = s.store.ExecPanel(ctx, insertRuleSQL(existing))
+		return err
+	}
+	_ = s.writeAudit(ctx, actor, "firewall.rule.delete", fmt.Sprintf("rule_id=%d port=%d/%s", id, existing.Port, existing.Protocol))
+	return nil
+}
+
+// Confirm keeps the live ruleset: it becomes the one loaded at boot and
+// the revert timer is cancelled.
+func (s *Service) Confirm(ctx context.Context, actor string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.expirePending(ctx); err != nil {
+		return err
+	}
+	state, err := s.state(ctx)
+	if err != nil {
+		return err
+	}
+	if state.pendingUntil == 0 {
+		return ErrNothingPending
+	}
+	if err := os.Rename(s.pendingPath(), s.rulesPath); err != nil {
+		return fmt.Errorf("save confirmed ruleset: %w", err)
+	}
+	s.disarm(ctx)
+	rules, err := s.listRules(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.saveState(ctx, 0, rules); err != nil {
+		return err
+	}
+	_ = s.writeAudit(ctx, actor, "firewall.confirm", fmt.Sprintf("rules=%d", len(rules)))
+	return nil
+}
+
+// Revert drops an unconfirmed change right away instead of waiting for
+// the timer.
+func (s *Service) Revert(ctx context.Context, actor string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.expirePending(ctx); err != nil {
+		return err
+	}
+	state, err := s.state(ctx)
+	if err != nil {
+		return err
+	}
+	if state.pendingUntil == 0 {
+		return ErrNothingPending
+	}
+	if err := s.rollback(ctx, state); err != nil {
+		return err
+	}
+	_ = s.writeAudit(ctx, actor, "firewall.revert", fmt.Sprintf("rules=%d", len(state.confirmed)))
+	return nil
+}
+
+// stage renders the rules into the pending ruleset, checks it with nft,
+// arms the revert timer and loads it. The timer is armed first: loading a
+// rule that cuts off the admin must still be undone.
+func (s *Service) stage(ctx context.Context) error {
+	rules, err := s.listRules(ctx)
+	if err != nil {
+		return err
+	}
+	state, err := s.state(ctx)
+	if err != nil {
+		return err
+	}
+	pending := s.pendingPath()
+	if err := os.MkdirAll(filepath.Dir(pending), 0o750); err != nil {
+		return fmt.Errorf("create firewall dir: %w", err)
+	}
+	if err := os.WriteFile(pending, []byte(Render(s.base, rules)), 0o600); err != nil {
+		return fmt.Errorf("write pending ruleset: %w", err)
+	}
+	if out, err := s.runner.Run(ctx, s.nftBin, "-c", "-f", pending); err != nil {
+		detail := strings.TrimSpace(out)
+		if detail == "" {
+			detail = err.Error()
+		}
+		return fmt.Errorf("invalid ruleset: %s", detail)
+	}
+	if err := s.arm(ctx); err != nil {
+		return err
+	}
+	if _, err := s.runner.Run(ctx, s.nftBin, "-f", pending); err != nil {
+		s.disarm(ctx)
+		return fmt.Errorf("load ruleset: %w", err)
+	}
+	return s.saveState(ctx, s.now().Add(s.confirmWindow).Unix(), state.confirmed)
+}
+
+// arm (re)starts the revert timer for a full confirm window.
+func (s *Service) arm(ctx context.Context) error {
+	s.disarm(ctx)
+	revert := []string{s.nftBin, "delete", "table", tableName}
+	if _, err := os.Stat(s.rulesPath); err == nil {
+		revert = []string{s.nftBin, "-f", s.rulesPath}
+	}
+	args := append([]string{
+		"--unit=" + revertUnit,
+		"--collect",
+		"--on-active=" + strconv.Itoa(int(s.confirmWindow.Seconds())) + "s",
+	}, revert...)
+	if _, err := s.runner.Run(ctx, "systemd-run", args...); err != nil {
+		return fmt.Errorf("arm firewall revert timer: %w", err)
+	}
+	return nil
+}
+
+// disarm cancels the revert timer; a timer that already fired or never
+// existed is fine.
+func (s *Service) disarm(ctx context.Context) {
+	_, _ = s.runner.Run(ctx, "systemctl", "stop", revertUnit+".timer")
+}
+
+// rollback loads the confirmed ruleset and puts the confirmed rules back
+// in panel.db.
+func (s *Service) rollback(ctx context.Context, state firewallState) error {
+	s.disarm(ctx)
+	if _, err := os.Stat(s.rulesPath); err == nil {
+		if _, err := s.runner.Run(ctx, s.nftBin, "-f", s.rulesPath); err != nil {
+			return fmt.Errorf("load confirmed ruleset: %w", err)
+		}
+	} else {
+		_, _ = s.runner.Run(ctx, s.nftBin, "delete", "table", tableName)
+	}
+	var b strings.Builder
+	b.WriteString("DELETE FROM firewall_rules;\n")
+	for _, rule := range state.confirmed {
+		b.WriteString(insertRuleSQL(rule) + "\n")
+	}
+	if err := s.store.ExecPanel(ctx, b.String()); err != nil {
+		return fmt.Errorf("restore confirmed firewall rules: %w", err)
+	}
+	if err := s.saveState(ctx, 0, state.confirmed); err != nil {
+		return err
+	}
+	_ = os.Remove(s.pendingPath())
+	return nil
+}
+
+// expirePending catches up with a revert timer that fired: the host is
+// back on the confirmed ruleset, so the rules in panel.db follow.
+func (s *Service) expirePending(ctx context.Context) error {
+	state, err := s.state(ctx)
+	if err != nil {
+		return err
+	}
+	if state.pendingUntil == 0 || s.now().Unix() < state.pendingUntil {
+		return nil
+	}
+	s.log.Warn("firewall change was not confirmed in time, reverted", "pending_until", state.pendingUntil)
+	if err := s.rollback(ctx, state); err != nil {
+		return err
+	}
+	_ = s.writeAudit(ctx, "system", "firewall.revert", "reason=not confirmed in time")
+	return nil
+}
+
+func (s *Service) pendingPath() string {
+	return s.rulesPath + ".pending"
+}
+
+type firewallState struct {
+	pendingUntil int64
+	confirmed    []Rule
+}
+
+func (s *Service) state(ctx context.Context) (firewallState, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, "SELECT pending_until, confirmed_rules FROM firewall_state WHERE id = 1;")
+	if err != nil {
+		return firewallState{}, fmt.Errorf("get firewall state: %w", err)
+	}
+	if len(rows) == 0 {
+		return firewallState{}, nil
+	}
+	pendingUntil, err := toInt64(rows[0]["pending_until"])
+	if err != nil {
+		return firewallState{}, fmt.Errorf("parse firewall pending_until: %w", err)
+	}
+	raw, _ := rows[0]["confirmed_rules"].(string)
+	var confirmed []Rule
+	if raw != "" {
+		if err := json.Unmarshal([]byte(raw), &confirmed); err != nil {
+			return firewallState{}, fmt.Errorf("parse confirmed firewall rules: %w", err)
+		}
+	}
+	return firewallState{pendingUntil: pendingUntil, confirmed: confirmed}, nil
+}
+
+func (s *Service) saveState(ctx context.Context, pendingUntil int64, confirmed []Rule) error {
+	if confirmed == nil {
+		confirmed = []Rule{}
+	}
+	raw, err := json.Marshal(confirmed)
+	if err != nil {
+		return fmt.Errorf("encode confirmed firewall rules: %w", err)
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO firewall_state(id, pending_until, confirmed_rules, updated_at)
+VALUES(1,%d,'%s',%d)
+ON CONFLICT(id) DO UPDATE SET pending_until=excluded.pending_until, confirmed_rules=excluded.confirmed_rules,
+  updated_at=excluded.updated_at;`,
+		pendingUntil, sqlEscape(string(raw)), s.now().Unix())); err != nil {
+		return fmt.Errorf("save firewall state: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) listRules(ctx context.Context) ([]Rule, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, `
+SELECT id, protocol, port, source, action, comment, created_at, updated_at
+FROM firewall_rules
+ORDER BY id;`)
+	if err != nil {
+		return nil, fmt.Errorf("list firewall rules: %w", err)
+	}
+	rules := make([]Rule, 0, len(rows))
+	for _, row := range rows {
+		rule, err := mapRowToRule(row)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (s *Service) updateRule(ctx context.Context, id int64, req RuleRequest) error {
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+UPDATE firewall_rules
+SET protocol='%s', port=%d, source='%s', action='%s', comment='%s', updated_at=MAX(%d, updated_at + 1)
+WHERE id = %d;`,
+		req.Protocol, req.Port, sqlEscape(req.Source), req.Action, sqlEscape(req.Comment), s.now().Unix(), id)); err != nil {
+		return fmt.Errorf("update firewall rule: %w", err)
+	}
+	return nil
+}
+
+func insertRuleSQL(rule Rule) string {
+	return fmt.Sprintf(`INSERT INTO firewall_rules(id, protocol, port, source, action, comment, created_at, updated_at)
+VALUES(%d,'%s',%d,'%s','%s','%s',%d,%d);`,
+		rule.ID, sqlEscape(rule.Protocol), rule.Port, sqlEscape(rule.Source), sqlEscape(rule.Action),
+		sqlEscape(rule.Comment), rule.CreatedAt.Unix(), rule.UpdatedAt.Unix())
+}
+
+func describe(req RuleRequest) string {
+	source := req.Source
+	if source == "" {
+		source = "any"
+	}
+	return fmt.Sprintf("port=%d/%s source=%s action=%s", req.Port, req.Protocol, source, req.Action)
+}
+
+func (s *Service) writeAudit(ctx context.Context, actor, action, details string) error {
+	if strings.TrimSpace(actor) == "" {
+		actor = "system"
+	}
+	return s.store.ExecAudit(ctx, fmt.Sprintf(
+		"INSERT INTO audit_events(actor, action, details, remote_ip, impersonator, created_at) VALUES('%s','%s','%s','%s','%s',%d);",
+		sqlEscape(actor),
+		sqlEscape(action),
+		sqlEscape(details),
+		sqlEscape(middleware.ClientIP(ctx)),
+		sqlEscape(middleware.Impersonator(ctx)),
+		time.Now().Unix(),
+	))
+}
+
+func mapRowToRule(row map[string]any) (Rule, error) {
+	id, err := toInt64(row["id"])
+	if err != nil {
+		return Rule{}, err
+	}
+	port, err := toInt64(row["port"])
+	if err != nil {
+		return Rule{}, err
+	}
+	createdAt, err := toInt64(row["created_at"])
+	if err != nil {
+		return Rule{}, err
+	}
+	updatedAt, err := toInt64(row["updated_at"])
+	if err != nil {
+		return Rule{}, err
+	}
+	protocol, _ := row["protocol"].(string)
+	source, _ := row["source"].(string)
+	action, _ := row["action"].(string)
+	comment, _ := row["comment"].(string)
+	return Rule{
+		ID:        id,
+		Protocol:  protocol,
+		Port:      int(port),
+		Source:    source,
+		Action:    action,
+		Comment:   comment,
+		CreatedAt: time.Unix(createdAt, 0).UTC(),
+		UpdatedAt: time.Unix(updatedAt, 0).UTC(),
+	}, nil
+}
+
+func sqlEscape(in string) string {
+	return strings.ReplaceAll(in, "'", "''")
+}
+
+func toInt64(v any) (int64, error) {
+	switch t := v.(type) {
+	case float64:
+		return int64(t), nil
+	case int64:
+		return t, nil
+	case string:
+		i, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return i, nil
+	default:
+		return 0, fmt.Errorf("unexpected numeric type %T", v)
+	}
+}
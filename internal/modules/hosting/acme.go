@@ -0,0 +1,379 @@
+package hosting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/settings"
+)
+
+const (
+	defaultLetsEncryptDir = "/etc/letsencrypt"
+	defaultACMEWebroot    = "/var/www/letsencrypt"
+	acmeProductionServer  = "https://acme-v02.api.letsencrypt.org/directory"
+	acmeStagingServer     = "https://acme-staging-v02.api.letsencrypt.org/directory"
+	acmeChallengeHTTP01   = "http-01"
+	acmeChallengeDNS01    = "dns-01"
+	// maxFailureOutput bounds certbot output kept per recorded failure.
+	maxFailureOutput = 2048
+)
+
+// ACMEAccount describes the certbot account registered for one ACME server.
+type ACMEAccount struct {
+	Server       string    `json:"server"`
+	Staging      bool      `json:"staging"`
+	Registered   bool      `json:"registered"`
+	AccountID    string    `json:"account_id,omitempty"`
+	URI          string    `json:"uri,omitempty"`
+	Contact      []string  `json:"contact"`
+	Status       string    `json:"status,omitempty"`
+	KeyPresent   bool      `json:"key_present"`
+	CreatedAt    time.Time `json:"created_at,omitzero"`
+	CreationHost string    `json:"creation_host,omitempty"`
+}
+
+// RegisterACMEAccountRequest registers or updates the account contact.
+// Staging defaults to acme_staging from panel.yaml.
+type RegisterACMEAccountRequest struct {
+	Email   string `json:"email"`
+	Staging *bool  `json:"staging"`
+	Actor   string `json:"-"`
+}
+
+// IssueCertificateRequest issues a certificate for a hosted domain.
+// Challenge is "http-01" or "dns-01"; empty picks dns-01 for sites on
+// Cloudflare and http-01 otherwise.
+type IssueCertificateRequest struct {
+	Domain    string `json:"domain"`
+	Staging   *bool  `json:"staging"`
+	Challenge string `json:"challenge"`
+	Actor     string `json:"-"`
+}
+
+// CertificateFailure is one recorded issuance failure.
+type CertificateFailure struct {
+	ID        int64     `json:"id"`
+	Domain    string    `json:"domain"`
+	Staging   bool      `json:"staging"`
+	Error     string    `json:"error"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ACMEAccount reads certbot's account state for the production or staging server.
+func (s *Service) ACMEAccount(_ context.Context, staging bool) (ACMEAccount, error) {
+	server := acmeServer(staging)
+	account := ACMEAccount{Server: server, Staging: staging, Contact: []string{}}
+
+	accountsDir := filepath.Join(s.letsEncryptDir, "accounts", acmeServerPath(server))
+	entries, err := os.ReadDir(accountsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return account, nil
+		}
+		return ACMEAccount{}, fmt.Errorf("read acme accounts: %w", err)
+	}
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() {
+			ids = append(ids, e.Name())
+		}
+	}
+	if len(ids) == 0 {
+		return account, nil
+	}
+	// certbot keeps one account per server unless created manually; report
+	// the first one deterministically.
+	sort.Strings(ids)
+	accountDir := filepath.Join(accountsDir, ids[0])
+	account.Registered = true
+	account.AccountID = ids[0]
+
+	var regr struct {
+		Body struct {
+			Contact []string `json:"contact"`
+			Status  string   `json:"status"`
+		} `json:"body"`
+		URI string `json:"uri"`
+	}
+	if err := readJSONFile(filepath.Join(accountDir, "regr.json"), &regr); err != nil {
+		return ACMEAccount{}, err
+	}
+	account.URI = regr.URI
+	account.Status = regr.Body.Status
+	for _, c := range regr.Body.Contact {
+		account.Contact = append(account.Contact, strings.TrimPrefix(c, "mailto:"))
+	}
+
+	var meta struct {
+		CreationDT   string `json:"creation_dt"`
+		CreationHost string `json:"creation_host"`
+	}
+	if err := readJSONFile(filepath.Join(accountDir, "meta.json"), &meta); err != nil {
+		return ACMEAccount{}, err
+	}
+	if t, err := time.Parse(time.RFC3339, meta.CreationDT); err == nil {
+		account.CreatedAt = t.UTC()
+	}
+	account.CreationHost = meta.CreationHost
+
+	// The private key never leaves disk; only report that it exists.
+	if _, err := os.Stat(filepath.Join(accountDir, "private_key.json")); err == nil {
+		account.KeyPresent = true
+	}
+	return account, nil
+}
+
+// RegisterACMEAccount registers a certbot account or updates its contact email.
+func (s *Service) RegisterACMEAccount(ctx context.Context, req RegisterACMEAccountRequest) (ACMEAccount, error) {
+	staging := s.acmeStaging(ctx, req.Staging)
+	email, err := normalizeACMEEmail(req.Email, s.acmeEmail(ctx))
+	if err != nil {
+		return ACMEAccount{}, err
+	}
+	existing, err := s.ACMEAccount(ctx, staging)
+	if err != nil {
+		return ACMEAccount{}, err
+	}
+
+	args := []string{"register", "--agree-tos", "--no-eff-email"}
+	action := "hosting.acme.register"
+	if existing.Registered {
+		args = []string{"update_account"}
+		action = "hosting.acme.update"
+	}
+	args = append(args, "--email", email, "--non-interactive", "--config-dir", s.letsEncryptDir)
+	if staging {
+		args = append(args, "--staging")
+	}
+	if out, err := s.runner.Run(ctx, "certbot", args...); err != nil {
+		return ACMEAccount{}, fmt.Errorf("certbot %s: %s", args[0], failureDetail(err, out))
+	}
+	_ = s.writeAudit(ctx, req.Actor, action, fmt.Sprintf("email=%s staging=%t", email, staging))
+	return s.ACMEAccount(ctx, staging)
+}
+
+// IssueCertificate runs certbot for a hosted domain and records failures.
+func (s *Service) IssueCertificate(ctx context.Context, req IssueCertificateRequest) error {
+	domain, err := normalizeDomain(req.Domain)
+	if err != nil {
+		return err
+	}
+	site, err := s.getSiteByDomain(ctx, domain)
+	if err != nil {
+		return err
+	}
+	email, err := normalizeACMEEmail("", s.acmeEmail(ctx))
+	if err != nil {
+		return err
+	}
+	staging := s.acmeStaging(ctx, req.Staging)
+
+	challenge := strings.ToLower(strings.TrimSpace(req.Challenge))
+	if challenge == "" {
+		challenge = acmeChallengeHTTP01
+		if s.useCloudflareDNS01(ctx, site.ID) {
+			challenge = acmeChallengeDNS01
+		}
+	}
+	var args []string
+	switch challenge {
+	case acmeChallengeHTTP01:
+		webroot := strings.TrimSpace(s.cfg.ACMEWebroot)
+		if webroot == "" {
+			webroot = defaultACMEWebroot
+		}
+		args = []string{"certonly", "--webroot", "--webroot-path", webroot}
+	case acmeChallengeDNS01:
+		credentials, err := s.writeCloudflareCredentials()
+		if err != nil {
+			return err
+		}
+		args = []string{"certonly", "--dns-cloudflare", "--dns-cloudflare-credentials", credentials}
+	default:
+		return fmt.Errorf("invalid challenge %q", req.Challenge)
+	}
+	args = append(args, "--domain", domain)
+	// The redirecting name needs the certificate too, or browsers reject
+	// it before the redirect is served.
+	serverName, aliases, err := canonicalHosts(site.Domain, site.CanonicalHost)
+	if err != nil {
+		return err
+	}
+	for _, name := range append([]string{serverName}, aliases...) {
+		if name != domain {
+			args = append(args, "--domain", name)
+		}
+	}
+	if len(aliases) > 0 {
+		args = append(args, "--expand")
+	}
+	args = append(args,
+		"--email", email,
+		"--agree-tos",
+		"--non-interactive",
+		"--keep-until-expiring",
+		"--config-dir", s.letsEncryptDir,
+	)
+	if staging {
+		args = append(args, "--staging")
+	}
+	out, runErr := s.runner.Run(ctx, "certbot", args...)
+	if runErr != nil {
+		detail := failureDetail(runErr, out)
+		s.recordCertificateFailure(ctx, domain, staging, detail)
+		_ = s.writeAudit(ctx, req.Actor, "hosting.certificate.issue_failed", "domain="+domain)
+		return fmt.Errorf("issue certificate for %s: %s", domain, detail)
+	}
+	_ = s.writeAudit(ctx, req.Actor, "hosting.certificate.issue", fmt.Sprintf("domain=%s staging=%t challenge=%s", domain, staging, challenge))
+	return nil
+}
+
+// ListCertificateFailures returns recorded issuance failures, newest first.
+// An empty domain lists failures for all domains.
+func (s *Service) ListCertificateFailures(ctx context.Context, domain string, limit int) ([]CertificateFailure, error) {
+	if s.store == nil {
+		return nil, fmt.Errorf("hosting service is not configured")
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	where := ""
+	if strings.TrimSpace(domain) != "" {
+		d, err := normalizeDomain(domain)
+		if err != nil {
+			return nil, err
+		}
+		where = fmt.Sprintf("WHERE domain = '%s'", sqlEscape(d))
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT id, domain, staging, error, created_at
+FROM certificate_failures
+%s
+ORDER BY id DESC
+LIMIT %d;`, where, limit))
+	if err != nil {
+		return nil, fmt.Errorf("list certificate failures: %w", err)
+	}
+	out := make([]CertificateFailure, 0, len(rows))
+	for _, row := range rows {
+		id, err := toInt64(row["id"])
+		if err != nil {
+			return nil, fmt.Errorf("parse failure id: %w", err)
+		}
+		staging, err := toInt64(row["staging"])
+		if err != nil {
+			return nil, fmt.Errorf("parse failure staging: %w", err)
+		}
+		createdAt, err := toInt64(row["created_at"])
+		if err != nil {
+			return nil, fmt.Errorf("parse failure created_at: %w", err)
+		}
+		out = append(out, CertificateFailure{
+			ID:        id,
+			Domain:    fmt.Sprint(row["domain"]),
+			Staging:   staging == 1,
+			Error:     fmt.Sprint(row["error"]),
+			CreatedAt: time.Unix(createdAt, 0).UTC(),
+		})
+	}
+	return out, nil
+}
+
+func (s *Service) recordCertificateFailure(ctx context.Context, domain string, staging bool, detail string) {
+	if s.store == nil {
+		return
+	}
+	stagingInt := 0
+	if staging {
+		stagingInt = 1
+	}
+	sql := fmt.Sprintf(
+		"INSERT INTO certificate_failures(domain, staging, error, created_at) VALUES('%s',%d,'%s',%d);",
+		sqlEscape(domain),
+		stagingInt,
+		sqlEscape(detail),
+		time.Now().Unix(),
+	)
+	if err := s.store.ExecPanel(ctx, sql); err != nil {
+		s.log.Error("record certificate failure", "domain", domain, "error", err.Error())
+	}
+}
+
+func (s *Service) acmeStaging(ctx context.Context, override *bool) bool {
+	if override != nil {
+		return *override
+	}
+	if s.settings != nil {
+		return s.settings.Bool(ctx, settings.ACME, "staging")
+	}
+	return s.cfg.ACMEStaging
+}
+
+func (s *Service) acmeEmail(ctx context.Context) string {
+	if s.settings != nil {
+		return s.settings.String(ctx, settings.ACME, "email")
+	}
+	return s.cfg.ACMEEmail
+}
+
+func acmeServer(staging bool) string {
+	if staging {
+		return acmeStagingServer
+	}
+	return acmeProductionServer
+}
+
+// acmeServerPath mirrors certbot's accounts layout: "<host>/<path>".
+func acmeServerPath(server string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+}
+
+func normalizeACMEEmail(email, fallback string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		email = strings.TrimSpace(fallback)
+	}
+	if email == "" {
+		return "", fmt.Errorf("acme email is required (set it in the acme settings or acme_email in panel.yaml)")
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", fmt.Errorf("invalid acme email %q", email)
+	}
+	return email, nil
+}
+
+func failureDetail(err error, out string) string {
+	detail := err.Error()
+	out = strings.TrimSpace(out)
+	if out != "" && !strings.Contains(detail, out) {
+		detail += ": " + out
+	}
+	if len(detail) > maxFailureOutput {
+		detail = detail[len(detail)-maxFailureOutput:]
+	}
+	return detail
+}
+
+func readJSONFile(path string, v any) error {
+	// Path is under the panel-controlled letsencrypt config dir.
+	//nolint:gosec // G304
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read %s: %w", filepath.Base(path), err)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("parse %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+)
+
+func newACMEService(t *testing.T, cfg config.Config, runner *fakeRunner) *Service {
+	t.Helper()
+	svc := newTestService(t, withConfig(cfg), withRunner(runner))
+	seedSite(t, svc, "example.com")
+	svc.letsEncryptDir = t.TempDir()
+	return svc
+}
+
+func TestACMEAccount_ReadsCertbotAccountState(t *testing.T) {
+	svc := newACMEService(t, config.Config{}, &fakeRunner{})
+
+	account, err := svc.ACMEAccount(context.Background(), true)
+	if err != nil {
+		t.Fatalf("read empty account: %v", err)
+	}
+	if account.Registered || account.Server != acmeStagingServer {
+		t.Fatalf("expected unregistered staging account, got %+v", account)
+	}
+
+	accountDir := filepath.Join(svc.letsEncryptDir, "accounts", "acme-staging-v02.api.letsencrypt.org", "directory", "abc123")
+	if err := os.MkdirAll(accountDir, 0o700); err != nil {
+		t.Fatalf("mkdir account dir: %v", err)
+	}
+	files := map[string]string{
+		"regr.json":        `{"body":{"contact":["mailto:ops@example.com"],"status":"valid"},"uri":"https://acme-staging-v02.api.letsencrypt.org/acme/acct/42"}`,
+		"meta.json":        `{"creation_dt":"2026-01-02T03:04:05Z","creation_host":"panel.example.com"}`,
+		"private_key.json": `{"n":"secret"}`,
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(accountDir, name), []byte(body), 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	account, err = svc.ACMEAccount(context.Background(), true)
+	if err != nil {
+		t.Fatalf("read account: %v", err)
+	}
+	if !account.Registered || account.AccountID != "abc123" || !account.KeyPresent {
+		t.Fatalf("unexpected account: %+v", account)
+	}
+	if len(account.Contact) != 1 || account.Contact[0] != "ops@example.com" || account.Status != "valid" {
+		t.Fatalf("unexpected account registration: %+v", account)
+	}
+	if account.CreatedAt.Year() != 2026 || account.CreationHost != "panel.example.com" {
+		t.Fatalf("unexpected account metadata: %+v", account)
+	}
+
+	production, err := svc.ACMEAccount(context.Background(), false)
+	if err != nil {
+		t.Fatalf("read production account: %v", err)
+	}
+	if production.Registered {
+		t.Fatalf("expected staging account to stay separate from production, got %+v", production)
+	}
+}
+
+func TestRegisterACMEAccount_UsesStagingToggle(t *testing.T) {
+	runner := &fakeRunner{}
+	svc := newACMEService(t, config.Config{ACMEEmail: "ops@example.com", ACMEStaging: true}, runner)
+
+	if _, err := svc.RegisterACMEAccount(context.Background(), RegisterACMEAccountRequest{}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	want := "certbot register --agree-tos --no-eff-email --email ops@example.com --non-interactive --config-dir " + svc.letsEncryptDir + " --staging"
+	if !containsCommand(runner.commands, want) {
+		t.Fatalf("expected %q, got %v", want, runner.commands)
+	}
+
+	production := false
+	if _, err := svc.RegisterACMEAccount(context.Background(), RegisterACMEAccountRequest{Email: "new@example.com", Staging: &production}); err != nil {
+		t.Fatalf("register production: %v", err)
+	}
+	last := runner.commands[len(runner.commands)-1]
+	if strings.Contains(last, "--staging") || !strings.Contains(last, "--email new@example.com") {
+		t.Fatalf("expected production registration, got %q", last)
+	}
+
+	if _, err := svc.RegisterACMEAccount(context.Background(), RegisterACMEAccountRequest{Email: "not-an-email"}); err == nil {
+		t.Fatal("expected invalid email to be rejected")
+	}
+}
+
+func TestIssueCertificate_RecordsFailures(t *testing.T) {
+	runner := &fakeRunner{}
+	svc := newACMEService(t, config.Config{ACMEEmail: "ops@example.com", ACMEWebroot: "/var/www/letsencrypt"}, runner)
+	issueCmd := "certbot certonly --webroot --webroot-path /var/www/letsencrypt --domain example.com --email ops@example.com --agree-tos --non-interactive --keep-until-expiring --config-dir " + svc.letsEncryptDir
+	runner.errs = map[string]error{issueCmd: fmt.Errorf("exit status 1")}
+	runner.outputs = map[string]string{issueCmd: "Challenge failed for domain example.com"}
+
+	err := svc.IssueCertificate(context.Background(), IssueCertificateRequest{Domain: "Example.com", Actor: "admin@example.com"})
+	if err == nil || !strings.Contains(err.Error(), "Challenge failed") {
+		t.Fatalf("expected issuance failure with certbot output, got %v", err)
+	}
+	if err := svc.IssueCertificate(context.Background(), IssueCertificateRequest{Domain: "missing.example.com"}); !errors.Is(err, ErrSiteNotFound) {
+		t.Fatalf("expected ErrSiteNotFound for unknown domain, got %v", err)
+	}
+
+	failures, err := svc.ListCertificateFailures(context.Background(), "example.com", 0)
+	if err != nil {
+		t.Fatalf("list failures: %v", err)
+	}
+	if len(failures) != 1 || failures[0].Domain != "example.com" || failures[0].Staging {
+		t.Fatalf("unexpected failures: %+v", failures)
+	}
+	if !strings.Contains(failures[0].Error, "Challenge failed for domain example.com") {
+		t.Fatalf("expected certbot output in recorded failure, got %q", failures[0].Error)
+	}
+
+	delete(runner.errs, issueCmd)
+	staging := true
+	if err := svc.IssueCertificate(context.Background(), IssueCertificateRequest{Domain: "example.com", Staging: &staging}); err != nil {
+		t.Fatalf("issue staging certificate: %v", err)
+	}
+	if !containsCommand(runner.commands, issueCmd+" --staging") {
+		t.Fatalf("expected staging issuance, got %v", runner.commands)
+	}
+}
@@ -0,0 +1,16 @@
+package hosting
+
+import (
+	"context"
+
+	"github.com/robsonek/aiPanel/internal/modules/audit"
+)
+
+// SiteActivity returns the audit events touching a site and its
+// databases, newest first. beforeID pages backwards from an earlier page.
+func (s *Service) SiteActivity(ctx context.Context, id, beforeID int64, limit int) ([]audit.Event, error) {
+	if _, err := s.GetSite(ctx, id); err != nil {
+		return nil, err
+	}
+	return audit.List(ctx, s.store, audit.Query{SiteID: id, BeforeID: beforeID, Limit: limit})
+}
@@ -0,0 +1,43 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+)
+
+func TestSiteActivity_ListsEventsTouchingTheSite(t *testing.T) {
+	ctx := context.Background()
+	svc := newACMEService(t, config.Config{}, &fakeRunner{})
+
+	_ = svc.writeAudit(ctx, "admin@example.com", "hosting.site.create", "domain=example.com")
+	_ = svc.writeAudit(ctx, "system", "hosting.certificate.renew", "domain=example.com")
+	_ = svc.writeAudit(ctx, "admin@example.com", "hosting.cron.delete", "site_id=1 cron_id=4")
+	_ = svc.writeAudit(ctx, "admin@example.com", "hosting.site.create", "domain=other.example")
+	_ = svc.writeAudit(ctx, "admin@example.com", "hosting.acme.register", "email=ops@example.com staging=true")
+
+	events, err := svc.SiteActivity(ctx, 1, 0, 0)
+	if err != nil {
+		t.Fatalf("site activity: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %+v", events)
+	}
+	if events[0].Action != "hosting.cron.delete" || events[2].Action != "hosting.site.create" || events[0].SiteID != 1 {
+		t.Fatalf("expected newest first, got %+v", events)
+	}
+
+	page, err := svc.SiteActivity(ctx, 1, events[0].ID, 1)
+	if err != nil {
+		t.Fatalf("site activity page: %v", err)
+	}
+	if len(page) != 1 || page[0].Action != "hosting.certificate.renew" {
+		t.Fatalf("expected the renewal on the second page, got %+v", page)
+	}
+
+	if _, err := svc.SiteActivity(ctx, 99, 0, 0); !errors.Is(err, ErrSiteNotFound) {
+		t.Fatalf("expected ErrSiteNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package hosting
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const defaultNamecheapEndpoint = "https://api.namecheap.com/xml.response"
+
+// NamecheapOptions holds the Namecheap API credentials. Calls must come
+// from ClientIP, which has to be whitelisted in the Namecheap account.
+type NamecheapOptions struct {
+	Endpoint string
+	APIUser  string
+	APIKey   string
+	Username string
+	ClientIP string
+}
+
+// NamecheapRegistrar implements adapter.Registrar on the Namecheap XML API.
+type NamecheapRegistrar struct {
+	opts   NamecheapOptions
+	client *http.Client
+}
+
+// NewNamecheapRegistrar constructs a Namecheap registrar client.
+func NewNamecheapRegistrar(opts NamecheapOptions) *NamecheapRegistrar {
+	if opts.Endpoint == "" {
+		opts.Endpoint = defaultNamecheapEndpoint
+	}
+	if opts.Username == "" {
+		opts.Username = opts.APIUser
+	}
+	return &NamecheapRegistrar{opts: opts, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Name implements adapter.Registrar.
+func (n *NamecheapRegistrar) Name() string { return "namecheap" }
+
+// CheckAvailability implements adapter.Registrar.
+func (n *NamecheapRegistrar) CheckAvailability(ctx context.Context, domain string) (bool, error) {
+	var resp struct {
+		Results []struct {
+			Domain    string `xml:"Domain,attr"`
+			Available bool   `xml:"Available,attr"`
+		} `xml:"CommandResponse>DomainCheckResult"`
+	}
+	if err := n.call(ctx, "namecheap.domains.check", url.Values{"DomainList": {domain}}, &resp); err != nil {
+		return false, err
+	}
+	for _, r := range resp.Results {
+		if strings.EqualFold(r.Domain, domain) {
+			return r.Available, nil
+		}
+	}
+	return false, fmt.Errorf("namecheap: no availability result for %s", domain)
+}
+
+// SetNameservers implements adapter.Registrar.
+func (n *NamecheapRegistrar) SetNameservers(ctx context.Context, domain string, nameservers []string) error {
+	params := registrarSLDTLD(domain)
+	params.Set("Nameservers", strings.Join(nameservers, ","))
+	return n.call(ctx, "namecheap.domains.dns.setCustom", params, nil)
+}
+
+// SetGlueRecord implements adapter.Registrar. Namecheap keeps a single
+// address per nameserver host.
+func (n *NamecheapRegistrar) SetGlueRecord(ctx context.Context, domain, host string, ips []string) error {
+	if len(ips) != 1 {
+		return fmt.Errorf("invalid glue record: namecheap accepts exactly one address per host")
+	}
+	params := registrarSLDTLD(domain)
+	params.Set("Nameserver", host)
+	var info struct {
+		Result struct {
+			IP string `xml:"IP,attr"`
+		} `xml:"CommandResponse>DomainNSInfoResult"`
+	}
+	if err := n.call(ctx, "namecheap.domains.ns.getInfo", params, &info); err != nil {
+		// Hosts that are not registered yet are reported as errors.
+		params.Set("IP", ips[0])
+		return n.call(ctx, "namecheap.domains.ns.create", params, nil)
+	}
+	if info.Result.IP == ips[0] {
+		return nil
+	}
+	params.Set("OldIP", info.Result.IP)
+	params.Set("IP", ips[0])
+	return n.call(ctx, "namecheap.domains.ns.update", params, nil)
+}
+
+// call runs one API command and decodes its ApiResponse into out.
+func (n *NamecheapRegistrar) call(ctx context.Context, command string, params url.Values, out any) error {
+	q := url.Values{
+		"ApiUser":  {n.opts.APIUser},
+		"ApiKey":   {n.opts.APIKey},
+		"UserName": {n.opts.Username},
+		"ClientIp": {n.opts.ClientIP},
+		"Command":  {command},
+	}
+	for k, v := range params {
+		q[k] = v
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.opts.Endpoint, strings.NewReader(q.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("namecheap %s: %w", command, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("namecheap %s: %w", command, err)
+	}
+	var envelope struct {
+		Status string `xml:"Status,attr"`
+		Errors []struct {
+			Number  string `xml:"Number,attr"`
+			Message string `xml:",chardata"`
+		} `xml:"Errors>Error"`
+	}
+	if err := xml.Unmarshal(raw, &envelope); err != nil {
+		return fmt.Errorf("namecheap %s: unexpected response (status %d)", command, resp.StatusCode)
+	}
+	if !strings.EqualFold(envelope.Status, "OK") {
+		msgs := make([]string, 0, len(envelope.Errors))
+		for _, e := range envelope.Errors {
+			msgs = append(msgs, e.Number+" "+strings.TrimSpace(e.Message))
+		}
+		return fmt.Errorf("namecheap %s: %s", command, strings.Join(msgs, "; "))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := xml.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("namecheap %s: decode response: %w", command, err)
+	}
+	return nil
+}
+
+// registrarSLDTLD splits a registered domain into the SLD and TLD
+// parameters of the Namecheap API, e.g. example + co.uk.
+func registrarSLDTLD(domain string) url.Values {
+	sld, tld, _ := strings.Cut(domain, ".")
+	return url.Values{"SLD": {sld}, "TLD": {tld}}
+}
@@ -4,10 +4,14 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"text/template"
 
+	"github.com/robsonek/aiPanel/internal/platform/faultinject"
 	"github.com/robsonek/aiPanel/internal/platform/systemd"
 	"github.com/robsonek/aiPanel/pkg/adapter"
 )
@@ -19,6 +23,8 @@ const (
 	defaultNginxBinaryPath     = "/opt/aipanel/runtime/nginx/current/sbin/nginx"
 	defaultNginxConfigPath     = "/opt/aipanel/runtime/nginx/current/conf/nginx.conf"
 	defaultNginxServiceName    = "aipanel-runtime-nginx.service"
+	// catchAllVhostName is the installer's default server vhost.
+	catchAllVhostName = "aipanel-catchall.conf"
 )
 
 // NginxAdapterOptions controls filesystem locations used by the adapter.
@@ -77,27 +83,21 @@ func NewNginxAdapter(runner systemd.Runner, opts NginxAdapterOptions) *NginxAdap
 }
 
 // WriteVhost renders and writes a site vhost config and ensures sites-enabled symlink exists.
-func (a *NginxAdapter) WriteVhost(_ context.Context, site adapter.SiteConfig) error {
-	domain, err := normalizeDomain(site.Domain)
+func (a *NginxAdapter) WriteVhost(ctx context.Context, site adapter.SiteConfig) error {
+	content, err := a.RenderVhost(site)
 	if err != nil {
 		return err
 	}
-	if site.RootDir == "" {
-		return fmt.Errorf("root_dir is required")
-	}
-	model := map[string]string{
-		"Domain":     domain,
-		"RootDir":    site.RootDir,
-		"PHPVersion": site.PHPVersion,
-		"SystemUser": site.SystemUser,
-		"SocketPath": socketPath(domain, site.PHPVersion),
-	}
+	return a.WriteRenderedVhost(ctx, site.Domain, content)
+}
 
-	content, err := renderTemplateFile(a.templatePath, model)
+// WriteRenderedVhost writes an already rendered vhost config, e.g. one
+// rendered from a template that is not installed yet.
+func (a *NginxAdapter) WriteRenderedVhost(_ context.Context, domain, content string) error {
+	domain, err := normalizeDomain(domain)
 	if err != nil {
-		return fmt.Errorf("render nginx vhost template: %w", err)
+		return err
 	}
-
 	availablePath := filepath.Join(a.sitesAvailableDir, domain+".conf")
 	enabledPath := filepath.Join(a.sitesEnabledDir, domain+".conf")
 
@@ -107,6 +107,9 @@ func (a *NginxAdapter) WriteVhost(_ context.Context, site adapter.SiteConfig) er
 	if err := os.MkdirAll(a.sitesEnabledDir, 0o750); err != nil {
 		return fmt.Errorf("create sites-enabled dir: %w", err)
 	}
+	if err := faultinject.Write(availablePath); err != nil {
+		return fmt.Errorf("write vhost config: %w", err)
+	}
 	if err := os.WriteFile(availablePath, []byte(content), 0o600); err != nil {
 		return fmt.Errorf("write vhost config: %w", err)
 	}
@@ -119,6 +122,118 @@ func (a *NginxAdapter) WriteVhost(_ context.Context, site adapter.SiteConfig) er
 	return nil
 }
 
+// TemplatePath returns the vhost template sites are rendered from.
+func (a *NginxAdapter) TemplatePath() string {
+	return a.templatePath
+}
+
+// RenderVhost renders the vhost config of a site without writing it.
+func (a *NginxAdapter) RenderVhost(site adapter.SiteConfig) (string, error) {
+	return a.renderVhost(site, "")
+}
+
+// PreviewVhost renders the vhost config of a site from source instead of
+// the installed template.
+func (a *NginxAdapter) PreviewVhost(site adapter.SiteConfig, source string) (string, error) {
+	return a.renderVhost(site, source)
+}
+
+func (a *NginxAdapter) renderVhost(site adapter.SiteConfig, source string) (string, error) {
+	domain, err := normalizeDomain(site.Domain)
+	if err != nil {
+		return "", err
+	}
+	if site.RootDir == "" {
+		return "", fmt.Errorf("root_dir is required")
+	}
+	serverName := domain
+	if site.ServerName != "" {
+		if serverName, err = normalizeDomain(site.ServerName); err != nil {
+			return "", fmt.Errorf("invalid server name: %w", err)
+		}
+	}
+	redirectHosts := make([]string, 0, len(site.RedirectHosts))
+	for _, raw := range site.RedirectHosts {
+		host, err := normalizeDomain(raw)
+		if err != nil || host == serverName {
+			return "", fmt.Errorf("invalid redirect host %q", raw)
+		}
+		redirectHosts = append(redirectHosts, host)
+	}
+	model := map[string]any{
+		"Domain":        domain,
+		"ServerName":    serverName,
+		"RedirectHosts": redirectHosts,
+		"RootDir":       site.RootDir,
+		"PHPVersion":    site.PHPVersion,
+		"SystemUser":    site.SystemUser,
+		"SocketPath":    socketPath(domain, site.PHPVersion),
+		"MediaUpstream": "",
+		"MediaHost":     "",
+	}
+	if site.MediaUpstream != "" {
+		upstream, err := url.Parse(site.MediaUpstream)
+		if err != nil || (upstream.Scheme != "http" && upstream.Scheme != "https") || upstream.Host == "" {
+			return "", fmt.Errorf("invalid media upstream")
+		}
+		model["MediaUpstream"] = upstream.String()
+		model["MediaHost"] = upstream.Host
+	}
+	if err := pageCacheModel(model, domain, site.PageCache); err != nil {
+		return "", err
+	}
+
+	var content string
+	if source == "" {
+		content, err = renderTemplateFile(a.templatePath, model)
+	} else {
+		content, err = renderTemplate(filepath.Base(a.templatePath), source, model)
+	}
+	if err != nil {
+		return "", fmt.Errorf("render nginx vhost template: %w", err)
+	}
+	return content, nil
+}
+
+// pageCacheModel adds the page cache fields of the vhost template; they
+// are empty when the site has no cache.
+func pageCacheModel(model map[string]any, domain string, cache *adapter.PageCache) error {
+	model["PageCache"] = ""
+	model["PageCacheZone"] = ""
+	model["PageCacheDir"] = ""
+	model["PageCacheTTL"] = 0
+	model["VarnishAddr"] = ""
+	model["VarnishBackendPort"] = 0
+	if cache == nil {
+		return nil
+	}
+	if cache.TTLSeconds <= 0 {
+		return fmt.Errorf("invalid page cache ttl")
+	}
+	switch cache.Backend {
+	case adapter.PageCacheFastCGI:
+		if !filepath.IsAbs(cache.Dir) {
+			return fmt.Errorf("invalid page cache dir %q", cache.Dir)
+		}
+		model["PageCacheZone"] = "aipanel_" + strings.ReplaceAll(sanitizeToken(domain), "-", "_")
+		model["PageCacheDir"] = cache.Dir
+	case adapter.PageCacheVarnish:
+		if _, _, err := net.SplitHostPort(cache.VarnishAddr); err != nil {
+			return fmt.Errorf("invalid varnish address %q", cache.VarnishAddr)
+		}
+		if cache.BackendPort <= 0 || cache.BackendPort > 65535 {
+			return fmt.Errorf("invalid varnish backend port %d", cache.BackendPort)
+		}
+		model["VarnishAddr"] = cache.VarnishAddr
+		model["VarnishBackendPort"] = cache.BackendPort
+	default:
+		return fmt.Errorf("invalid page cache backend %q", cache.Backend)
+	}
+	model["PageCache"] = cache.Backend
+	model["PageCacheTTL"] = cache.TTLSeconds
+	return nil
+}
+
 // RemoveVhost removes sites-enabled symlink and sites-available config.
 func (a *NginxAdapter) RemoveVhost(_ context.Context, domain string) error {
 	domain, err := normalizeDomain(domain)
@@ -136,6 +251,30 @@ func (a *NginxAdapter) RemoveVhost(_ context.Context, domain string) error {
 	return nil
 }
 
+// CatchAllEnabled reports whether the catch-all vhost is enabled. The
+// installer leaves it disabled while the panel vhost is the default server.
+func (a *NginxAdapter) CatchAllEnabled() bool {
+	_, err := os.Lstat(filepath.Join(a.sitesEnabledDir, catchAllVhostName))
+	return err == nil
+}
+
+// WriteCatchAll replaces the catch-all vhost and returns its previous
+// content, to be written back if the new one fails the config test.
+func (a *NginxAdapter) WriteCatchAll(content string) (string, error) {
+	path := filepath.Join(a.sitesAvailableDir, catchAllVhostName)
+	previous, err := os.ReadFile(path) //nolint:gosec // G304: fixed vhost path.
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("read catch-all vhost: %w", err)
+	}
+	if err := os.MkdirAll(a.sitesAvailableDir, 0o750); err != nil {
+		return "", fmt.Errorf("create sites-available dir: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil { //nolint:gosec // G306: matches the installer's vhost mode.
+		return "", fmt.Errorf("write catch-all vhost: %w", err)
+	}
+	return string(previous), nil
+}
+
 // TestConfig runs "nginx -t".
 func (a *NginxAdapter) TestConfig(ctx context.Context) error {
 	if _, err := a.runner.Run(ctx, a.nginxBinaryPath, "-t", "-c", a.nginxConfigPath); err != nil {
@@ -144,6 +283,58 @@ func (a *NginxAdapter) TestConfig(ctx context.Context) error {
 	return nil
 }
 
+// TestStaged runs "nginx -t" on a throwaway main config that includes only
+// the given server blocks, so a vhost can be validated before it replaces
+// a live one. Files next to the runtime nginx.conf (mime.types,
+// fastcgi_params, ...) are copied so relative includes resolve. It returns
+// the nginx output.
+func (a *NginxAdapter) TestStaged(ctx context.Context, vhosts ...string) (string, error) {
+	dir, err := os.MkdirTemp("", "aipanel-nginx-stage-*")
+	if err != nil {
+		return "", fmt.Errorf("create nginx stage dir: %w", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	confDir := filepath.Dir(a.nginxConfigPath)
+	if entries, err := os.ReadDir(confDir); err == nil {
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() || entry.Name() == filepath.Base(a.nginxConfigPath) {
+				continue
+			}
+			raw, err := os.ReadFile(filepath.Join(confDir, entry.Name())) //nolint:gosec // G304: runtime nginx conf dir.
+			if err != nil {
+				return "", fmt.Errorf("stage %s: %w", entry.Name(), err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, entry.Name()), raw, 0o600); err != nil {
+				return "", fmt.Errorf("stage %s: %w", entry.Name(), err)
+			}
+		}
+	}
+	var main bytes.Buffer
+	fmt.Fprintf(&main, "pid %s;\nerror_log %s;\nevents {}\nhttp {\n", filepath.Join(dir, "nginx.pid"), filepath.Join(dir, "error.log"))
+	if _, err := os.Stat(filepath.Join(dir, "mime.types")); err == nil {
+		main.WriteString("    include mime.types;\n")
+	}
+	for n, vhost := range vhosts {
+		name := fmt.Sprintf("staged-%d.conf", n)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(vhost), 0o600); err != nil {
+			return "", fmt.Errorf("stage vhost: %w", err)
+		}
+		fmt.Fprintf(&main, "    include %s;\n", name)
+	}
+	main.WriteString("}\n")
+	mainPath := filepath.Join(dir, "nginx.conf")
+	if err := os.WriteFile(mainPath, main.Bytes(), 0o600); err != nil {
+		return "", fmt.Errorf("stage nginx.conf: %w", err)
+	}
+	out, err := a.runner.Run(ctx, a.nginxBinaryPath, "-t", "-p", dir+"/", "-c", mainPath)
+	if err != nil {
+		return out, fmt.Errorf("nginx config test failed: %w", err)
+	}
+	return out, nil
+}
+
 // Reload reloads the configured Nginx systemd service.
 func (a *NginxAdapter) Reload(ctx context.Context) error {
 	if _, err := a.runner.Run(ctx, "systemctl", "reload", a.serviceName); err != nil {
@@ -157,7 +348,11 @@ func renderTemplateFile(path string, data any) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	tpl, err := template.New(filepath.Base(path)).Parse(string(source))
+	return renderTemplate(filepath.Base(path), string(source), data)
+}
+
+func renderTemplate(name, source string, data any) (string, error) {
+	tpl, err := template.New(name).Parse(source)
 	if err != nil {
 		return "", err
 	}
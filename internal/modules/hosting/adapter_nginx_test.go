@@ -59,6 +59,94 @@ func TestNginxAdapter_WriteVhostAndSymlink(t *testing.T) {
 	}
 }
 
+func TestNginxAdapter_WriteVhostProxiesMedia(t *testing.T) {
+	root := t.TempDir()
+	templatePath := filepath.Join(root, "nginx_vhost.conf.tmpl")
+	body := "{{ if .MediaUpstream }}location ^~ /media/ { proxy_pass {{ .MediaUpstream }}; proxy_set_header Host {{ .MediaHost }}; }{{ end }}"
+	if err := os.WriteFile(templatePath, []byte(body), 0o600); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+	availDir := filepath.Join(root, "sites-available")
+	ad := NewNginxAdapter(&fakeRunner{}, NginxAdapterOptions{
+		TemplatePath:      templatePath,
+		SitesAvailableDir: availDir,
+		SitesEnabledDir:   filepath.Join(root, "sites-enabled"),
+	})
+	site := adapter.SiteConfig{
+		Domain:        "test.example.com",
+		RootDir:       "/var/www/test.example.com/public_html",
+		PHPVersion:    "8.3",
+		SystemUser:    "site_test_example_com",
+		MediaUpstream: "https://assets.s3.eu-central-1.amazonaws.com/",
+	}
+	if err := ad.WriteVhost(context.Background(), site); err != nil {
+		t.Fatalf("write vhost: %v", err)
+	}
+	//nolint:gosec // test reads a file created within temp dir.
+	b, err := os.ReadFile(filepath.Join(availDir, "test.example.com.conf"))
+	if err != nil {
+		t.Fatalf("read vhost: %v", err)
+	}
+	want := "location ^~ /media/ { proxy_pass https://assets.s3.eu-central-1.amazonaws.com/; proxy_set_header Host assets.s3.eu-central-1.amazonaws.com; }"
+	if string(b) != want {
+		t.Fatalf("unexpected vhost content: %q", b)
+	}
+
+	site.MediaUpstream = "ftp://assets.example.com/"
+	if err := ad.WriteVhost(context.Background(), site); err == nil {
+		t.Fatal("expected non-http media upstream to be rejected")
+	}
+}
+
+func TestNginxAdapter_WriteVhostRedirectsAlternateHost(t *testing.T) {
+	root := t.TempDir()
+	templatePath := filepath.Join(root, "nginx_vhost.conf.tmpl")
+	body := "{{- range .RedirectHosts -}}\nserver {\n    server_name {{ . }};\n    return 301 $scheme://{{ $.ServerName }}$request_uri;\n}\n\n{{ end -}}\nserver {\n    server_name {{ .ServerName }};\n}\n"
+	if err := os.WriteFile(templatePath, []byte(body), 0o600); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+	availDir := filepath.Join(root, "sites-available")
+	ad := NewNginxAdapter(&fakeRunner{}, NginxAdapterOptions{
+		TemplatePath:      templatePath,
+		SitesAvailableDir: availDir,
+		SitesEnabledDir:   filepath.Join(root, "sites-enabled"),
+	})
+	site := adapter.SiteConfig{
+		Domain:     "example.com",
+		RootDir:    "/var/www/example.com/public_html",
+		PHPVersion: "8.3",
+		SystemUser: "site_example_com",
+	}
+	read := func() string {
+		t.Helper()
+		if err := ad.WriteVhost(context.Background(), site); err != nil {
+			t.Fatalf("write vhost: %v", err)
+		}
+		//nolint:gosec // test reads a file created within temp dir.
+		b, err := os.ReadFile(filepath.Join(availDir, "example.com.conf"))
+		if err != nil {
+			t.Fatalf("read vhost: %v", err)
+		}
+		return string(b)
+	}
+	if got := read(); got != "server {\n    server_name example.com;\n}\n" {
+		t.Fatalf("unexpected vhost without redirects: %q", got)
+	}
+
+	site.ServerName = "www.example.com"
+	site.RedirectHosts = []string{"example.com"}
+	want := "server {\n    server_name example.com;\n    return 301 $scheme://www.example.com$request_uri;\n}\n\n" +
+		"server {\n    server_name www.example.com;\n}\n"
+	if got := read(); got != want {
+		t.Fatalf("unexpected vhost with redirect:\n%s", got)
+	}
+
+	site.RedirectHosts = []string{"www.example.com"}
+	if err := ad.WriteVhost(context.Background(), site); err == nil {
+		t.Fatal("expected redirect to the server name itself to be rejected")
+	}
+}
+
 func TestNginxAdapter_WriteVhostFailsWithoutTemplate(t *testing.T) {
 	root := t.TempDir()
 	availDir := filepath.Join(root, "sites-available")
@@ -132,3 +220,56 @@ func TestNginxAdapter_TestConfigAndReload(t *testing.T) {
 		t.Fatalf("expected reload command, got %v", r.commands)
 	}
 }
+
+func TestNginxAdapter_RenderVhostPageCache(t *testing.T) {
+	ad := NewNginxAdapter(&fakeRunner{}, NginxAdapterOptions{TemplatePath: filepath.Join("..", "..", "..", "configs", "templates", "nginx_vhost.conf.tmpl")})
+	site := adapter.SiteConfig{
+		Domain:     "example.com",
+		RootDir:    "/var/www/example.com/public_html",
+		PHPVersion: "8.3",
+		SystemUser: "site_example_com",
+	}
+	plain, err := ad.RenderVhost(site)
+	if err != nil {
+		t.Fatalf("render vhost: %v", err)
+	}
+	if strings.Contains(plain, "cache") || strings.Count(plain, "server {") != 1 {
+		t.Fatalf("expected no cache in the plain vhost, got\n%s", plain)
+	}
+
+	site.PageCache = &adapter.PageCache{Backend: adapter.PageCacheFastCGI, TTLSeconds: 600, Dir: "/var/cache/nginx/aipanel/example.com"}
+	fastcgi, err := ad.RenderVhost(site)
+	if err != nil {
+		t.Fatalf("render fastcgi vhost: %v", err)
+	}
+	for _, line := range []string{
+		"fastcgi_cache_path /var/cache/nginx/aipanel/example.com levels=1:2 keys_zone=aipanel_example_com:10m",
+		"fastcgi_cache aipanel_example_com;",
+		"fastcgi_cache_valid 200 301 302 600s;",
+		"fastcgi_no_cache $skip_cache;",
+		"add_header X-Cache-Status $upstream_cache_status always;",
+	} {
+		if !strings.Contains(fastcgi, line) {
+			t.Fatalf("expected %q in\n%s", line, fastcgi)
+		}
+	}
+
+	site.PageCache = &adapter.PageCache{Backend: adapter.PageCacheVarnish, TTLSeconds: 300, VarnishAddr: "127.0.0.1:6081", BackendPort: 8088}
+	varnish, err := ad.RenderVhost(site)
+	if err != nil {
+		t.Fatalf("render varnish vhost: %v", err)
+	}
+	for _, line := range []string{"proxy_pass http://127.0.0.1:6081;", "listen 127.0.0.1:8088;", "add_header X-Aipanel-TTL 300;"} {
+		if !strings.Contains(varnish, line) {
+			t.Fatalf("expected %q in\n%s", line, varnish)
+		}
+	}
+	if strings.Count(varnish, "access_log /var/log/nginx/example.com.access.log;") != 1 || strings.Contains(varnish, "fastcgi_cache") {
+		t.Fatalf("expected the access log on the front server only, got\n%s", varnish)
+	}
+
+	site.PageCache.BackendPort = 0
+	if _, err := ad.RenderVhost(site); err == nil {
+		t.Fatal("expected a varnish cache without a backend port rejected")
+	}
+}
@@ -0,0 +1,156 @@
+package hosting
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1" //nolint:gosec // G505: OVH request signatures are defined as SHA-1.
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OVHOptions holds the OVH API credentials. The consumer key needs GET,
+// PUT and POST on /domain/* and POST/GET/DELETE on /order/cart/*.
+type OVHOptions struct {
+	Endpoint          string
+	ApplicationKey    string
+	ApplicationSecret string
+	ConsumerKey       string
+	Subsidiary        string
+}
+
+// OVHRegistrar implements adapter.Registrar on the OVH API.
+type OVHRegistrar struct {
+	opts   OVHOptions
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewOVHRegistrar constructs an OVH registrar client.
+func NewOVHRegistrar(opts OVHOptions) *OVHRegistrar {
+	if opts.Endpoint == "" {
+		opts.Endpoint = "https://eu.api.ovh.com/1.0"
+	}
+	if opts.Subsidiary == "" {
+		opts.Subsidiary = "FR"
+	}
+	return &OVHRegistrar{opts: opts, client: &http.Client{Timeout: 30 * time.Second}, now: time.Now}
+}
+
+// Name implements adapter.Registrar.
+func (o *OVHRegistrar) Name() string { return "ovh" }
+
+// CheckAvailability implements adapter.Registrar. OVH only answers
+// availability through an order cart, which is deleted afterwards.
+func (o *OVHRegistrar) CheckAvailability(ctx context.Context, domain string) (bool, error) {
+	var cart struct {
+		CartID string `json:"cartId"`
+	}
+	if err := o.call(ctx, http.MethodPost, "/order/cart", map[string]any{"ovhSubsidiary": o.opts.Subsidiary}, &cart); err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = o.call(context.WithoutCancel(ctx), http.MethodDelete, "/order/cart/"+url.PathEscape(cart.CartID), nil, nil)
+	}()
+	var offers []struct {
+		Action    string `json:"action"`
+		Orderable bool   `json:"orderable"`
+	}
+	path := "/order/cart/" + url.PathEscape(cart.CartID) + "/domain?domain=" + url.QueryEscape(domain)
+	if err := o.call(ctx, http.MethodGet, path, nil, &offers); err != nil {
+		return false, err
+	}
+	for _, offer := range offers {
+		if offer.Action == "create" && offer.Orderable {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SetNameservers implements adapter.Registrar.
+func (o *OVHRegistrar) SetNameservers(ctx context.Context, domain string, nameservers []string) error {
+	base := "/domain/" + url.PathEscape(domain)
+	if err := o.call(ctx, http.MethodPut, base, map[string]any{"nameServerType": "external"}, nil); err != nil {
+		return err
+	}
+	hosts := make([]map[string]string, 0, len(nameservers))
+	for _, ns := range nameservers {
+		hosts = append(hosts, map[string]string{"host": ns})
+	}
+	return o.call(ctx, http.MethodPost, base+"/nameServers/update", map[string]any{"nameServers": hosts}, nil)
+}
+
+// SetGlueRecord implements adapter.Registrar.
+func (o *OVHRegistrar) SetGlueRecord(ctx context.Context, domain, host string, ips []string) error {
+	base := "/domain/" + url.PathEscape(domain) + "/glueRecord"
+	var existing []string
+	if err := o.call(ctx, http.MethodGet, base, nil, &existing); err != nil {
+		return err
+	}
+	if slices.Contains(existing, host) {
+		return o.call(ctx, http.MethodPost, base+"/"+url.PathEscape(host)+"/update", map[string]any{"ips": ips}, nil)
+	}
+	return o.call(ctx, http.MethodPost, base, map[string]any{"host": host, "ips": ips}, nil)
+}
+
+// call sends a signed request: the signature is "$1$" and the SHA-1 of
+// secret+consumer key+method+URL+body+timestamp joined with "+".
+func (o *OVHRegistrar) call(ctx context.Context, method, path string, body, out any) error {
+	var raw []byte
+	if body != nil {
+		var err error
+		if raw, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	target := strings.TrimRight(o.opts.Endpoint, "/") + path
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(o.now().Unix(), 10)
+	//nolint:gosec // G401: mandated by the OVH API.
+	sum := sha1.Sum([]byte(strings.Join([]string{o.opts.ApplicationSecret, o.opts.ConsumerKey, method, target, string(raw), ts}, "+")))
+	req.Header.Set("X-Ovh-Application", o.opts.ApplicationKey)
+	req.Header.Set("X-Ovh-Consumer", o.opts.ConsumerKey)
+	req.Header.Set("X-Ovh-Timestamp", ts)
+	req.Header.Set("X-Ovh-Signature", "$1$"+hex.EncodeToString(sum[:]))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("ovh %s %s: %w", method, path, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("ovh %s %s: %w", method, path, err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		return fmt.Errorf("ovh %s %s: status %d: %s", method, path, resp.StatusCode, apiErr.Message)
+	}
+	if out == nil || len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("ovh %s %s: decode response: %w", method, path, err)
+	}
+	return nil
+}
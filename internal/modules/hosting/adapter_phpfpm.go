@@ -7,8 +7,10 @@ import (
 	"path/filepath"
 	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 
+	"github.com/robsonek/aiPanel/internal/platform/faultinject"
 	"github.com/robsonek/aiPanel/internal/platform/systemd"
 	"github.com/robsonek/aiPanel/pkg/adapter"
 )
@@ -18,6 +20,8 @@ const (
 	defaultPHPFPMPoolDir       = "/opt/aipanel/runtime/php-fpm/current/etc/php-fpm.d"
 	defaultPHPFPMRuntimeDir    = "/opt/aipanel/runtime/php-fpm"
 	defaultPHPFPMServiceName   = "aipanel-runtime-php-fpm.service"
+	defaultSystemdUnitDir      = "/etc/systemd/system"
+	defaultPHPSlowLogDir       = "/var/log/aipanel/php-fpm"
 	phpRuntimeVersionPatternRE = `^\d+\.\d+(?:\.\d+)?$`
 )
 
@@ -25,21 +29,39 @@ var phpVersionPattern = regexp.MustCompile(`^\d+\.\d+$`)
 var phpRuntimeVersionPattern = regexp.MustCompile(phpRuntimeVersionPatternRE)
 var phpMajorMinorPattern = regexp.MustCompile(`^\d+\.\d+`)
 
+// poolEnvNamePattern limits pool env[] names; values are written quoted
+// and may not contain quotes, "$" (ini interpolation) or newlines.
+var poolEnvNamePattern = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+
 // PHPFPMAdapterOptions controls filesystem locations used by the adapter.
+// SlicePoolDir holds pools of sites with resource limits; it must not be
+// included by the shared master and defaults to php-fpm.slice.d next to
+// PoolDir. SlowLogDir holds the per-site slow logs.
 type PHPFPMAdapterOptions struct {
 	TemplatePath        string
 	PoolDir             string
+	SlicePoolDir        string
 	RuntimeComponentDir string
 	ServiceName         string
+	SystemdUnitDir      string
+	SlowLogDir          string
 }
 
-// PHPFPMAdapter manages per-site PHP-FPM pools.
+// PHPFPMAdapter manages per-site PHP-FPM pools. Pools of the active
+// runtime version (the "current" symlink) run under the shared runtime
+// master; pools of another installed version run under a master unit of
+// that version, so sites can be moved between versions one at a time. A
+// site with resource limits gets its own master unit inside the site's
+// systemd slice.
 type PHPFPMAdapter struct {
 	runner              systemd.Runner
 	templatePath        string
 	poolDir             string
+	slicePoolDir        string
 	runtimeComponentDir string
 	serviceName         string
+	systemdUnitDir      string
+	slowLogDir          string
 }
 
 // NewPHPFPMAdapter constructs a PHP-FPM adapter with sane defaults.
@@ -56,57 +78,167 @@ func NewPHPFPMAdapter(runner systemd.Runner, opts PHPFPMAdapterOptions) *PHPFPMA
 	if opts.RuntimeComponentDir == "" {
 		opts.RuntimeComponentDir = defaultPHPFPMRuntimeDir
 	}
+	if opts.SlicePoolDir == "" {
+		opts.SlicePoolDir = filepath.Join(filepath.Dir(opts.PoolDir), "php-fpm.slice.d")
+	}
 	if opts.ServiceName == "" {
 		opts.ServiceName = defaultPHPFPMServiceName
 	}
+	if opts.SystemdUnitDir == "" {
+		opts.SystemdUnitDir = defaultSystemdUnitDir
+	}
+	if opts.SlowLogDir == "" {
+		opts.SlowLogDir = defaultPHPSlowLogDir
+	}
 	return &PHPFPMAdapter{
 		runner:              runner,
 		templatePath:        opts.TemplatePath,
 		poolDir:             opts.PoolDir,
+		slicePoolDir:        opts.SlicePoolDir,
 		runtimeComponentDir: opts.RuntimeComponentDir,
 		serviceName:         opts.ServiceName,
+		systemdUnitDir:      opts.SystemdUnitDir,
+		slowLogDir:          opts.SlowLogDir,
 	}
 }
 
 // WritePool renders and writes a PHP-FPM pool config for the site.
-func (a *PHPFPMAdapter) WritePool(_ context.Context, site adapter.SiteConfig) error {
-	domain, err := normalizeDomain(site.Domain)
+func (a *PHPFPMAdapter) WritePool(ctx context.Context, site adapter.SiteConfig) error {
+	content, err := a.RenderPool(site)
 	if err != nil {
 		return err
 	}
-	if !phpVersionPattern.MatchString(site.PHPVersion) {
-		return fmt.Errorf("invalid php version")
-	}
-	if site.SystemUser == "" {
-		return fmt.Errorf("system user is required")
+	domain, err := normalizeDomain(site.Domain)
+	if err != nil {
+		return err
 	}
 	pool := poolName(domain, site.PHPVersion)
-	targetDir := a.poolDir
-	targetPath := filepath.Join(targetDir, pool+".conf")
-
-	model := map[string]string{
-		"Domain":     domain,
-		"RootDir":    site.RootDir,
-		"PHPVersion": site.PHPVersion,
-		"SystemUser": site.SystemUser,
-		"PoolName":   pool,
-		"SocketPath": socketPath(domain, site.PHPVersion),
-	}
-	content, err := renderTemplateFile(a.templatePath, model)
+	sharedDir, err := a.poolDirFor(site.PHPVersion)
 	if err != nil {
-		return fmt.Errorf("render php-fpm pool template: %w", err)
+		return err
+	}
+	targetDir := sharedDir
+	if site.Limits != nil {
+		targetDir = a.slicePoolDir
 	}
+	targetPath := filepath.Join(targetDir, pool+".conf")
 	if err := os.MkdirAll(targetDir, 0o750); err != nil {
 		return fmt.Errorf("create php-fpm pool dir: %w", err)
 	}
+	// php-fpm refuses a pool whose slowlog cannot be opened; templates
+	// customized before slowlog was added do not reference it.
+	if strings.Contains(content, a.slowLogPath(domain)) {
+		if err := os.MkdirAll(a.slowLogDir, 0o750); err != nil {
+			return fmt.Errorf("create php-fpm slowlog dir: %w", err)
+		}
+	}
+	if err := faultinject.Write(targetPath); err != nil {
+		return fmt.Errorf("write php-fpm pool file: %w", err)
+	}
 	if err := os.WriteFile(targetPath, []byte(content), 0o600); err != nil {
 		return fmt.Errorf("write php-fpm pool file: %w", err)
 	}
-	return nil
+	if site.Limits != nil {
+		if err := os.Remove(filepath.Join(sharedDir, pool+".conf")); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove shared php-fpm pool file: %w", err)
+		}
+		return a.writeSliceUnits(ctx, domain, pool, targetPath, site.PHPVersion, *site.Limits)
+	}
+	return a.removeSliceUnits(ctx, pool)
 }
 
-// RemovePool removes a per-site PHP-FPM pool config.
-func (a *PHPFPMAdapter) RemovePool(_ context.Context, domain, phpVersion string) error {
+// slowLogPath is where the pools of a site log requests running longer
+// than request_slowlog_timeout, shared by all PHP versions of the site.
+func (a *PHPFPMAdapter) slowLogPath(domain string) string {
+	return filepath.Join(a.slowLogDir, domain+".slow.log")
+}
+
+// RenderPool renders the PHP-FPM pool config of a site without writing it.
+func (a *PHPFPMAdapter) RenderPool(site adapter.SiteConfig) (string, error) {
+	return a.renderPool(site, "")
+}
+
+// PreviewPool renders the PHP-FPM pool config of a site from source
+// instead of the installed template.
+func (a *PHPFPMAdapter) PreviewPool(site adapter.SiteConfig, source string) (string, error) {
+	return a.renderPool(site, source)
+}
+
+func (a *PHPFPMAdapter) renderPool(site adapter.SiteConfig, source string) (string, error) {
+	domain, err := normalizeDomain(site.Domain)
+	if err != nil {
+		return "", err
+	}
+	if !phpVersionPattern.MatchString(site.PHPVersion) {
+		return "", fmt.Errorf("invalid php version")
+	}
+	if site.SystemUser == "" {
+		return "", fmt.Errorf("system user is required")
+	}
+	for name, value := range site.Env {
+		if !poolEnvNamePattern.MatchString(name) || strings.ContainsAny(value, "\"$\r\n") {
+			return "", fmt.Errorf("invalid pool environment variable %s", name)
+		}
+	}
+	model := map[string]any{
+		"Domain":      domain,
+		"RootDir":     site.RootDir,
+		"PHPVersion":  site.PHPVersion,
+		"SystemUser":  site.SystemUser,
+		"PoolName":    poolName(domain, site.PHPVersion),
+		"SocketPath":  socketPath(domain, site.PHPVersion),
+		"Env":         site.Env,
+		"TmpDir":      site.TmpDir,
+		"OpenBasedir": openBasedir(site),
+		"SlowLogPath": a.slowLogPath(domain),
+	}
+	var content string
+	if source == "" {
+		content, err = renderTemplateFile(a.templatePath, model)
+	} else {
+		content, err = renderTemplate(filepath.Base(a.templatePath), source, model)
+	}
+	if err != nil {
+		return "", fmt.Errorf("render php-fpm pool template: %w", err)
+	}
+	return content, nil
+}
+
+// TestPool runs "php-fpm -t" on a throwaway master config that includes
+// only pool, and returns the php-fpm output.
+func (a *PHPFPMAdapter) TestPool(ctx context.Context, pool string) (string, error) {
+	dir, err := os.MkdirTemp("", "aipanel-phpfpm-stage-*")
+	if err != nil {
+		return "", fmt.Errorf("create php-fpm stage dir: %w", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	poolPath := filepath.Join(dir, "pool.conf")
+	if err := os.WriteFile(poolPath, []byte(pool), 0o600); err != nil {
+		return "", fmt.Errorf("stage php-fpm pool: %w", err)
+	}
+	masterPath := filepath.Join(dir, "php-fpm.conf")
+	master := strings.Join([]string{
+		"[global]",
+		"pid = " + filepath.Join(dir, "php-fpm.pid"),
+		"error_log = " + filepath.Join(dir, "php-fpm.log"),
+		"include = " + poolPath,
+		"",
+	}, "\n")
+	if err := os.WriteFile(masterPath, []byte(master), 0o600); err != nil {
+		return "", fmt.Errorf("stage php-fpm master: %w", err)
+	}
+	out, err := a.runner.Run(ctx, filepath.Join(a.runtimeComponentDir, "current", "sbin", "php-fpm"), "-t", "--fpm-config", masterPath)
+	if err != nil {
+		return out, fmt.Errorf("php-fpm config test failed: %w", err)
+	}
+	return out, nil
+}
+
+// RemovePool removes a per-site PHP-FPM pool config, stopping its own
+// master unit if the site had resource limits.
+func (a *PHPFPMAdapter) RemovePool(ctx context.Context, domain, phpVersion string) error {
 	domain, err := normalizeDomain(domain)
 	if err != nil {
 		return err
@@ -114,24 +246,347 @@ func (a *PHPFPMAdapter) RemovePool(_ context.Context, domain, phpVersion string)
 	if !phpVersionPattern.MatchString(phpVersion) {
 		return fmt.Errorf("invalid php version")
 	}
-	path := filepath.Join(a.poolDir, poolName(domain, phpVersion)+".conf")
-	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+	pool := poolName(domain, phpVersion)
+	dir, err := a.poolDirFor(phpVersion)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, pool+".conf")); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("remove php-fpm pool file: %w", err)
 	}
-	return nil
+	return a.removeSliceUnits(ctx, pool)
 }
 
-// Restart restarts the given PHP-FPM systemd unit.
+// Restart restarts the master serving phpVersion — the shared PHP-FPM
+// unit, or the version master of a version that is not current — then the
+// masters of sliced pools. The order matters: a master unlinks the sockets
+// of pools it used to serve when it stops.
 func (a *PHPFPMAdapter) Restart(ctx context.Context, phpVersion string) error {
 	if !phpVersionPattern.MatchString(phpVersion) {
 		return fmt.Errorf("invalid php version")
 	}
-	if _, err := a.runner.Run(ctx, "systemctl", "restart", a.serviceName); err != nil {
+	if a.shared(phpVersion) {
+		// A version master left from before the version became current
+		// would fight the shared master over the same sockets.
+		if err := a.removeVersionMaster(ctx, phpVersion); err != nil {
+			return err
+		}
+		if _, err := a.runner.Run(ctx, "systemctl", "restart", a.serviceName); err != nil {
+			return fmt.Errorf("restart php-fpm %s: %w", phpVersion, err)
+		}
+	} else if err := a.restartVersionMaster(ctx, phpVersion); err != nil {
+		return err
+	}
+	pools, err := filepath.Glob(filepath.Join(a.slicePoolDir, "*.conf"))
+	if err != nil {
+		return fmt.Errorf("list sliced php-fpm pools: %w", err)
+	}
+	for _, path := range pools {
+		unit := slicePoolUnit(strings.TrimSuffix(filepath.Base(path), ".conf"))
+		if _, err := a.runner.Run(ctx, "systemctl", "restart", unit); err != nil {
+			return fmt.Errorf("restart %s: %w", unit, err)
+		}
+	}
+	return nil
+}
+
+// writeSliceUnits writes the site slice and a dedicated php-fpm master unit
+// for pool, and enables the unit. It is started by Restart.
+func (a *PHPFPMAdapter) writeSliceUnits(ctx context.Context, domain, pool, poolPath, phpVersion string, limits adapter.ResourceLimits) error {
+	binary, err := a.binary(phpVersion)
+	if err != nil {
+		return err
+	}
+	masterPath := filepath.Join(a.slicePoolDir, pool+".master")
+	master := strings.Join([]string{
+		"[global]",
+		"pid = /run/php/" + pool + ".pid",
+		"error_log = syslog",
+		"syslog.ident = php-fpm-" + pool,
+		"daemonize = no",
+		"include = " + poolPath,
+		"",
+	}, "\n")
+	if err := os.WriteFile(masterPath, []byte(master), 0o600); err != nil {
+		return fmt.Errorf("write php-fpm master config: %w", err)
+	}
+	slice := SiteSlice(domain)
+	files := map[string]string{
+		slice: renderSliceUnit(domain, limits),
+		slicePoolUnit(pool): strings.Join([]string{
+			"[Unit]",
+			"Description=aiPanel PHP-FPM for " + domain,
+			"After=" + a.serviceName,
+			"",
+			"[Service]",
+			"Type=simple",
+			"Slice=" + slice,
+			"PrivateTmp=yes",
+			"ExecStart=" + binary + " --nodaemonize --fpm-config " + masterPath,
+			"ExecReload=/bin/kill -USR2 $MAINPID",
+			"Restart=on-failure",
+			"RestartSec=2",
+			"",
+			"[Install]",
+			"WantedBy=multi-user.target",
+			"",
+		}, "\n"),
+	}
+	if err := os.MkdirAll(a.systemdUnitDir, 0o755); err != nil {
+		return fmt.Errorf("create systemd unit dir: %w", err)
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(a.systemdUnitDir, name), []byte(body), 0o644); err != nil {
+			return fmt.Errorf("write %s: %w", name, err)
+		}
+	}
+	if err := systemd.DaemonReload(ctx, a.runner); err != nil {
+		return fmt.Errorf("systemd daemon-reload: %w", err)
+	}
+	if _, err := a.runner.Run(ctx, "systemctl", "enable", slicePoolUnit(pool)); err != nil {
+		return fmt.Errorf("enable %s: %w", slicePoolUnit(pool), err)
+	}
+	return nil
+}
+
+// removeSliceUnits stops and removes the dedicated master of pool, if any.
+// The site slice is left in place; it is empty and costs nothing.
+func (a *PHPFPMAdapter) removeSliceUnits(ctx context.Context, pool string) error {
+	unit := slicePoolUnit(pool)
+	unitPath := filepath.Join(a.systemdUnitDir, unit)
+	if _, err := os.Stat(unitPath); os.IsNotExist(err) {
+		return nil
+	}
+	_, _ = a.runner.Run(ctx, "systemctl", "disable", "--now", unit)
+	for _, path := range []string{
+		unitPath,
+		filepath.Join(a.slicePoolDir, pool+".conf"),
+		filepath.Join(a.slicePoolDir, pool+".master"),
+	} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove %s: %w", filepath.Base(path), err)
+		}
+	}
+	if err := systemd.DaemonReload(ctx, a.runner); err != nil {
+		return fmt.Errorf("systemd daemon-reload: %w", err)
+	}
+	return nil
+}
+
+// CurrentVersion returns the major.minor version the "current" runtime
+// symlink points at, or "" when it cannot be resolved.
+func (a *PHPFPMAdapter) CurrentVersion() string {
+	target, err := os.Readlink(filepath.Join(a.runtimeComponentDir, "current"))
+	if err != nil {
+		return ""
+	}
+	return phpMajorMinorPattern.FindString(filepath.Base(target))
+}
+
+// shared reports whether pools of phpVersion run under the shared master.
+// Without a resolvable current version every pool does, as before
+// versions could run side by side.
+func (a *PHPFPMAdapter) shared(phpVersion string) bool {
+	current := a.CurrentVersion()
+	return current == "" || current == phpVersion
+}
+
+// versionDir returns the newest installed runtime dir of a major.minor
+// version.
+func (a *PHPFPMAdapter) versionDir(phpVersion string) (string, error) {
+	entries, err := os.ReadDir(a.runtimeComponentDir)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("read php runtime dir: %w", err)
+	}
+	best := ""
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || !phpRuntimeVersionPattern.MatchString(name) || phpMajorMinorPattern.FindString(name) != phpVersion {
+			continue
+		}
+		if best == "" || compareRuntimeVersions(name, best) > 0 {
+			best = name
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("php version %s is not installed", phpVersion)
+	}
+	return filepath.Join(a.runtimeComponentDir, best), nil
+}
+
+// poolDirFor returns the directory holding shared-master pools of
+// phpVersion.
+func (a *PHPFPMAdapter) poolDirFor(phpVersion string) (string, error) {
+	if a.shared(phpVersion) {
+		return a.poolDir, nil
+	}
+	dir, err := a.versionDir(phpVersion)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "etc", "php-fpm.d"), nil
+}
+
+// binary returns the php-fpm executable of phpVersion.
+func (a *PHPFPMAdapter) binary(phpVersion string) (string, error) {
+	if a.shared(phpVersion) {
+		return filepath.Join(a.runtimeComponentDir, "current", "sbin", "php-fpm"), nil
+	}
+	dir, err := a.versionDir(phpVersion)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sbin", "php-fpm"), nil
+}
+
+// restartVersionMaster writes and restarts the master unit serving the
+// pools of a version that is not current, or stops it once it has none.
+func (a *PHPFPMAdapter) restartVersionMaster(ctx context.Context, phpVersion string) error {
+	dir, err := a.versionDir(phpVersion)
+	if err != nil {
+		return err
+	}
+	pools, err := filepath.Glob(filepath.Join(dir, "etc", "php-fpm.d", "*.conf"))
+	if err != nil {
+		return fmt.Errorf("list php-fpm %s pools: %w", phpVersion, err)
+	}
+	if len(pools) == 0 {
+		// php-fpm refuses to start without a pool.
+		return a.removeVersionMaster(ctx, phpVersion)
+	}
+	unit := versionMasterUnit(phpVersion)
+	masterPath := filepath.Join(dir, "etc", "aipanel-master.conf")
+	master := strings.Join([]string{
+		"[global]",
+		"pid = /run/php/" + strings.TrimSuffix(unit, ".service") + ".pid",
+		"error_log = syslog",
+		"syslog.ident = " + strings.TrimSuffix(unit, ".service"),
+		"daemonize = no",
+		"include = " + filepath.Join(dir, "etc", "php-fpm.d", "*.conf"),
+		"",
+	}, "\n")
+	if err := os.WriteFile(masterPath, []byte(master), 0o600); err != nil {
+		return fmt.Errorf("write php-fpm %s master config: %w", phpVersion, err)
+	}
+	body := strings.Join([]string{
+		"[Unit]",
+		"Description=aiPanel PHP-FPM " + phpVersion,
+		"After=network.target",
+		"",
+		"[Service]",
+		"Type=simple",
+		"PrivateTmp=yes",
+		"ExecStart=" + filepath.Join(dir, "sbin", "php-fpm") + " --nodaemonize --fpm-config " + masterPath,
+		"ExecReload=/bin/kill -USR2 $MAINPID",
+		"Restart=on-failure",
+		"RestartSec=2",
+		"",
+		"[Install]",
+		"WantedBy=multi-user.target",
+		"",
+	}, "\n")
+	if err := os.MkdirAll(a.systemdUnitDir, 0o755); err != nil {
+		return fmt.Errorf("create systemd unit dir: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(a.systemdUnitDir, unit), []byte(body), 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", unit, err)
+	}
+	if err := systemd.DaemonReload(ctx, a.runner); err != nil {
+		return fmt.Errorf("systemd daemon-reload: %w", err)
+	}
+	if _, err := a.runner.Run(ctx, "systemctl", "enable", unit); err != nil {
+		return fmt.Errorf("enable %s: %w", unit, err)
+	}
+	if _, err := a.runner.Run(ctx, "systemctl", "restart", unit); err != nil {
 		return fmt.Errorf("restart php-fpm %s: %w", phpVersion, err)
 	}
 	return nil
 }
 
+// removeVersionMaster stops and removes the master unit of phpVersion, if
+// any.
+func (a *PHPFPMAdapter) removeVersionMaster(ctx context.Context, phpVersion string) error {
+	unit := versionMasterUnit(phpVersion)
+	unitPath := filepath.Join(a.systemdUnitDir, unit)
+	if _, err := os.Stat(unitPath); os.IsNotExist(err) {
+		return nil
+	}
+	_, _ = a.runner.Run(ctx, "systemctl", "disable", "--now", unit)
+	if err := os.Remove(unitPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove %s: %w", unit, err)
+	}
+	if err := systemd.DaemonReload(ctx, a.runner); err != nil {
+		return fmt.Errorf("systemd daemon-reload: %w", err)
+	}
+	return nil
+}
+
+func versionMasterUnit(phpVersion string) string {
+	return "aipanel-runtime-php" + strings.ReplaceAll(phpVersion, ".", "") + "-fpm.service"
+}
+
+// compareRuntimeVersions orders dotted numeric versions ("8.4.10" after
+// "8.4.9").
+func compareRuntimeVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
+
+// openBasedir lists the paths PHP may open: the docroot and the site tmp
+// dir by default, the whole site home and the shared /tmp when relaxed.
+func openBasedir(site adapter.SiteConfig) string {
+	switch {
+	case site.RelaxOpenBasedir:
+		return filepath.Dir(site.RootDir) + ":/tmp"
+	case site.TmpDir != "":
+		return site.RootDir + ":" + site.TmpDir
+	default:
+		return site.RootDir + ":/tmp"
+	}
+}
+
+// SiteSlice is the systemd slice holding the processes of a site with
+// resource limits.
+func SiteSlice(domain string) string {
+	return "aipanel-site-" + sanitizeToken(domain) + ".slice"
+}
+
+func slicePoolUnit(pool string) string {
+	return "aipanel-php-fpm-" + pool + ".service"
+}
+
+func renderSliceUnit(domain string, limits adapter.ResourceLimits) string {
+	lines := []string{
+		"[Unit]",
+		"Description=aiPanel site " + domain,
+		"Before=slices.target",
+		"",
+		"[Slice]",
+	}
+	if limits.CPUQuotaPercent > 0 {
+		lines = append(lines, fmt.Sprintf("CPUQuota=%d%%", limits.CPUQuotaPercent))
+	}
+	if limits.MemoryMaxMB > 0 {
+		lines = append(lines, fmt.Sprintf("MemoryMax=%dM", limits.MemoryMaxMB))
+	}
+	if limits.TasksMax > 0 {
+		lines = append(lines, fmt.Sprintf("TasksMax=%d", limits.TasksMax))
+	}
+	return strings.Join(append(lines, ""), "\n")
+}
+
 // ListVersions returns installed PHP major.minor versions detected in runtime component dirs.
 func (a *PHPFPMAdapter) ListVersions(_ context.Context) ([]string, error) {
 	entries, err := os.ReadDir(a.runtimeComponentDir)
@@ -51,6 +51,193 @@ func TestPHPFPMAdapter_WritePoolAndRemovePool(t *testing.T) {
 	}
 }
 
+func TestPHPFPMAdapter_WritePoolRendersEnv(t *testing.T) {
+	root := t.TempDir()
+	templatePath := filepath.Join(root, "pool.tmpl")
+	body := "[{{ .PoolName }}]\n{{ range $name, $value := .Env }}env[{{ $name }}] = \"{{ $value }}\"\n{{ end -}}\n"
+	if err := os.WriteFile(templatePath, []byte(body), 0o600); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+	poolDir := filepath.Join(root, "pool.d")
+	ad := NewPHPFPMAdapter(&fakeRunner{}, PHPFPMAdapterOptions{TemplatePath: templatePath, PoolDir: poolDir})
+	site := adapter.SiteConfig{
+		Domain:     "test.example.com",
+		RootDir:    "/var/www/test.example.com/public_html",
+		PHPVersion: "8.3",
+		SystemUser: "site_test_example_com",
+		Env:        map[string]string{"AWS_BUCKET": "assets", "AWS_ACCESS_KEY_ID": "AKIA"},
+	}
+	if err := ad.WritePool(context.Background(), site); err != nil {
+		t.Fatalf("write pool: %v", err)
+	}
+	//nolint:gosec // test reads a file created within temp dir.
+	b, err := os.ReadFile(filepath.Join(poolDir, "test-example-com-php83.conf"))
+	if err != nil {
+		t.Fatalf("read pool: %v", err)
+	}
+	want := "[test-example-com-php83]\nenv[AWS_ACCESS_KEY_ID] = \"AKIA\"\nenv[AWS_BUCKET] = \"assets\"\n"
+	if string(b) != want {
+		t.Fatalf("unexpected pool content:\n%s", b)
+	}
+
+	site.Env = map[string]string{"AWS_SECRET_ACCESS_KEY": "x\"\nphp_admin_value[open_basedir] = /"}
+	if err := ad.WritePool(context.Background(), site); err == nil {
+		t.Fatal("expected env value with quotes and newlines to be rejected")
+	}
+}
+
+func TestPHPFPMAdapter_WritePoolConfinesToSiteTmp(t *testing.T) {
+	root := t.TempDir()
+	templatePath := filepath.Join(root, "pool.tmpl")
+	body := "open_basedir = {{ .OpenBasedir }}\n{{- if .TmpDir }}\nsys_temp_dir = {{ .TmpDir }}\n{{- end }}\n"
+	if err := os.WriteFile(templatePath, []byte(body), 0o600); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+	poolDir := filepath.Join(root, "pool.d")
+	ad := NewPHPFPMAdapter(&fakeRunner{}, PHPFPMAdapterOptions{TemplatePath: templatePath, PoolDir: poolDir})
+	site := adapter.SiteConfig{
+		Domain:     "test.example.com",
+		RootDir:    "/var/www/test.example.com/public_html",
+		PHPVersion: "8.3",
+		SystemUser: "site_test_example_com",
+		TmpDir:     "/var/www/test.example.com/tmp",
+	}
+	for _, tc := range []struct {
+		relaxed bool
+		want    string
+	}{
+		{false, "open_basedir = /var/www/test.example.com/public_html:/var/www/test.example.com/tmp\nsys_temp_dir = /var/www/test.example.com/tmp\n"},
+		{true, "open_basedir = /var/www/test.example.com:/tmp\nsys_temp_dir = /var/www/test.example.com/tmp\n"},
+	} {
+		site.RelaxOpenBasedir = tc.relaxed
+		if err := ad.WritePool(context.Background(), site); err != nil {
+			t.Fatalf("write pool: %v", err)
+		}
+		//nolint:gosec // test reads a file created within temp dir.
+		b, err := os.ReadFile(filepath.Join(poolDir, "test-example-com-php83.conf"))
+		if err != nil {
+			t.Fatalf("read pool: %v", err)
+		}
+		if string(b) != tc.want {
+			t.Fatalf("relaxed=%t: unexpected pool content:\n%s", tc.relaxed, b)
+		}
+	}
+}
+
+func TestPHPFPMAdapter_WritePoolCreatesSlowLogDir(t *testing.T) {
+	root := t.TempDir()
+	templatePath := filepath.Join(root, "pool.tmpl")
+	if err := os.WriteFile(templatePath, []byte("slowlog = {{ .SlowLogPath }}\n"), 0o600); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+	poolDir := filepath.Join(root, "pool.d")
+	slowLogDir := filepath.Join(root, "log", "php-fpm")
+	ad := NewPHPFPMAdapter(&fakeRunner{}, PHPFPMAdapterOptions{TemplatePath: templatePath, PoolDir: poolDir, SlowLogDir: slowLogDir})
+	site := adapter.SiteConfig{
+		Domain:     "test.example.com",
+		RootDir:    "/var/www/test.example.com/public_html",
+		PHPVersion: "8.3",
+		SystemUser: "site_test_example_com",
+	}
+	if err := ad.WritePool(context.Background(), site); err != nil {
+		t.Fatalf("write pool: %v", err)
+	}
+	//nolint:gosec // test reads a file created within temp dir.
+	b, err := os.ReadFile(filepath.Join(poolDir, "test-example-com-php83.conf"))
+	if err != nil {
+		t.Fatalf("read pool: %v", err)
+	}
+	if want := "slowlog = " + filepath.Join(slowLogDir, "test.example.com.slow.log") + "\n"; string(b) != want {
+		t.Fatalf("unexpected pool content:\n%s", b)
+	}
+	if info, err := os.Stat(slowLogDir); err != nil || !info.IsDir() {
+		t.Fatalf("expected the slowlog dir created, got %v", err)
+	}
+}
+
+func TestPHPFPMAdapter_WritePoolWithLimitsUsesSlice(t *testing.T) {
+	root := t.TempDir()
+	templatePath := filepath.Join(root, "pool.tmpl")
+	if err := os.WriteFile(templatePath, []byte("[{{ .PoolName }}]\nlisten = {{ .SocketPath }}\n"), 0o600); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+	poolDir := filepath.Join(root, "pool.d")
+	sliceDir := filepath.Join(root, "slice.d")
+	unitDir := filepath.Join(root, "systemd")
+	r := &fakeRunner{}
+	ad := NewPHPFPMAdapter(r, PHPFPMAdapterOptions{
+		TemplatePath:        templatePath,
+		PoolDir:             poolDir,
+		SlicePoolDir:        sliceDir,
+		SystemdUnitDir:      unitDir,
+		RuntimeComponentDir: "/opt/aipanel/runtime/php",
+	})
+	site := adapter.SiteConfig{
+		Domain:     "test.example.com",
+		RootDir:    "/var/www/test.example.com/public_html",
+		PHPVersion: "8.3",
+		SystemUser: "site_test_example_com",
+	}
+	ctx := context.Background()
+	if err := ad.WritePool(ctx, site); err != nil {
+		t.Fatalf("write shared pool: %v", err)
+	}
+	site.Limits = &adapter.ResourceLimits{CPUQuotaPercent: 50, MemoryMaxMB: 512}
+	if err := ad.WritePool(ctx, site); err != nil {
+		t.Fatalf("write sliced pool: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(poolDir, "test-example-com-php83.conf")); !os.IsNotExist(err) {
+		t.Fatalf("expected shared pool removed, got err=%v", err)
+	}
+	//nolint:gosec // test reads a file created within temp dir.
+	slice, err := os.ReadFile(filepath.Join(unitDir, "aipanel-site-test-example-com.slice"))
+	if err != nil {
+		t.Fatalf("read slice: %v", err)
+	}
+	if !strings.Contains(string(slice), "CPUQuota=50%\nMemoryMax=512M\n") || strings.Contains(string(slice), "TasksMax") {
+		t.Fatalf("unexpected slice unit:\n%s", slice)
+	}
+	unit := "aipanel-php-fpm-test-example-com-php83.service"
+	//nolint:gosec // test reads a file created within temp dir.
+	service, err := os.ReadFile(filepath.Join(unitDir, unit))
+	if err != nil {
+		t.Fatalf("read service: %v", err)
+	}
+	master := filepath.Join(sliceDir, "test-example-com-php83.master")
+	if !strings.Contains(string(service), "Slice=aipanel-site-test-example-com.slice\n") ||
+		!strings.Contains(string(service), "ExecStart=/opt/aipanel/runtime/php/current/sbin/php-fpm --nodaemonize --fpm-config "+master) {
+		t.Fatalf("unexpected service unit:\n%s", service)
+	}
+	if !containsCommand(r.commands, "systemctl enable "+unit) {
+		t.Fatalf("expected unit to be enabled, got %v", r.commands)
+	}
+
+	r.commands = nil
+	if err := ad.Restart(ctx, "8.3"); err != nil {
+		t.Fatalf("restart: %v", err)
+	}
+	if !slices.Equal(r.commands, []string{"systemctl restart aipanel-runtime-php-fpm.service", "systemctl restart " + unit}) {
+		t.Fatalf("expected shared master restarted before the sliced one, got %v", r.commands)
+	}
+
+	site.Limits = nil
+	if err := ad.WritePool(ctx, site); err != nil {
+		t.Fatalf("write pool without limits: %v", err)
+	}
+	if !containsCommand(r.commands, "systemctl disable --now "+unit) {
+		t.Fatalf("expected sliced unit to be stopped, got %v", r.commands)
+	}
+	for _, path := range []string{filepath.Join(unitDir, unit), master, filepath.Join(sliceDir, "test-example-com-php83.conf")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected %s removed, got err=%v", path, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(poolDir, "test-example-com-php83.conf")); err != nil {
+		t.Fatalf("expected shared pool restored: %v", err)
+	}
+}
+
 func TestPHPFPMAdapter_WritePoolFailsWithoutTemplate(t *testing.T) {
 	root := t.TempDir()
 	poolDir := filepath.Join(root, "pool.d")
@@ -97,3 +284,75 @@ func TestPHPFPMAdapter_ListVersions(t *testing.T) {
 		t.Fatalf("unexpected versions: %v", versions)
 	}
 }
+
+func TestPHPFPMAdapter_PoolsOfAnotherVersionRunUnderTheirOwnMaster(t *testing.T) {
+	root := t.TempDir()
+	runtimeDir := filepath.Join(root, "runtime")
+	for _, dir := range []string{"8.3.10", "8.4.1", "8.4.2"} {
+		if err := os.MkdirAll(filepath.Join(runtimeDir, dir, "etc", "php-fpm.d"), 0o750); err != nil {
+			t.Fatalf("mkdir %s: %v", dir, err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(runtimeDir, "8.3.10"), filepath.Join(runtimeDir, "current")); err != nil {
+		t.Fatalf("symlink current: %v", err)
+	}
+	templatePath := filepath.Join(root, "pool.tmpl")
+	if err := os.WriteFile(templatePath, []byte("[{{ .PoolName }}]\nlisten = {{ .SocketPath }}"), 0o600); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+	unitDir := filepath.Join(root, "systemd")
+	r := &fakeRunner{}
+	ad := NewPHPFPMAdapter(r, PHPFPMAdapterOptions{
+		TemplatePath:        templatePath,
+		PoolDir:             filepath.Join(runtimeDir, "current", "etc", "php-fpm.d"),
+		RuntimeComponentDir: runtimeDir,
+		SystemdUnitDir:      unitDir,
+	})
+	if got := ad.CurrentVersion(); got != "8.3" {
+		t.Fatalf("expected current 8.3, got %q", got)
+	}
+
+	ctx := context.Background()
+	site := adapter.SiteConfig{Domain: "example.com", RootDir: "/var/www/example.com/public_html", PHPVersion: "8.4", SystemUser: "site_example_com"}
+	if err := ad.WritePool(ctx, site); err != nil {
+		t.Fatalf("write pool: %v", err)
+	}
+	poolPath := filepath.Join(runtimeDir, "8.4.2", "etc", "php-fpm.d", "example-com-php84.conf")
+	if _, err := os.Stat(poolPath); err != nil {
+		t.Fatalf("expected the pool in the newest 8.4 dir: %v", err)
+	}
+	if err := ad.Restart(ctx, "8.4"); err != nil {
+		t.Fatalf("restart 8.4: %v", err)
+	}
+	unitPath := filepath.Join(unitDir, "aipanel-runtime-php84-fpm.service")
+	//nolint:gosec // test reads a file created within temp dir.
+	unit, err := os.ReadFile(unitPath)
+	if err != nil {
+		t.Fatalf("read version master unit: %v", err)
+	}
+	if !strings.Contains(string(unit), "ExecStart="+filepath.Join(runtimeDir, "8.4.2", "sbin", "php-fpm")) {
+		t.Fatalf("expected the 8.4.2 binary, got %s", unit)
+	}
+	if !containsCommand(r.commands, "systemctl enable aipanel-runtime-php84-fpm.service") ||
+		!containsCommand(r.commands, "systemctl restart aipanel-runtime-php84-fpm.service") ||
+		containsCommand(r.commands, "systemctl restart aipanel-runtime-php-fpm.service") {
+		t.Fatalf("expected only the 8.4 master restarted, got %v", r.commands)
+	}
+
+	if err := ad.RemovePool(ctx, "example.com", "8.4"); err != nil {
+		t.Fatalf("remove pool: %v", err)
+	}
+	if err := ad.Restart(ctx, "8.4"); err != nil {
+		t.Fatalf("restart 8.4 without pools: %v", err)
+	}
+	if _, err := os.Stat(unitPath); !os.IsNotExist(err) {
+		t.Fatalf("expected the idle version master removed, got err=%v", err)
+	}
+
+	if err := ad.Restart(ctx, "8.3"); err != nil {
+		t.Fatalf("restart 8.3: %v", err)
+	}
+	if !containsCommand(r.commands, "systemctl restart aipanel-runtime-php-fpm.service") {
+		t.Fatalf("expected the shared master restarted, got %v", r.commands)
+	}
+}
@@ -0,0 +1,169 @@
+package hosting
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/platform/faultinject"
+	"github.com/robsonek/aiPanel/internal/platform/systemd"
+	"github.com/robsonek/aiPanel/pkg/adapter"
+)
+
+const (
+	defaultSSHDConfigDir         = "/etc/ssh/sshd_config.d"
+	defaultSSHDAuthorizedKeysDir = "/etc/ssh/aipanel_authorized_keys"
+	defaultSSHDBinaryPath        = "/usr/sbin/sshd"
+	defaultSSHDServiceName       = "ssh.service"
+)
+
+// sftpUserPattern limits the users written into sshd config to the names
+// systemUserForDomain generates.
+var sftpUserPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,31}$`)
+
+// SSHDAdapterOptions controls filesystem locations used by the adapter.
+type SSHDAdapterOptions struct {
+	ConfigDir         string
+	AuthorizedKeysDir string
+	SSHDBinaryPath    string
+	ServiceName       string
+}
+
+// SSHDAdapter manages the sshd Match blocks of site SFTP logins. Each
+// login gets its own drop-in under sshd_config.d and an authorized_keys
+// file owned by root, so the site user cannot add keys of its own.
+type SSHDAdapter struct {
+	runner            systemd.Runner
+	configDir         string
+	authorizedKeysDir string
+	sshdBinaryPath    string
+	serviceName       string
+}
+
+// NewSSHDAdapter constructs a sshd adapter with sane defaults.
+func NewSSHDAdapter(runner systemd.Runner, opts SSHDAdapterOptions) *SSHDAdapter {
+	if runner == nil {
+		runner = systemd.ExecRunner{}
+	}
+	if opts.ConfigDir == "" {
+		opts.ConfigDir = defaultSSHDConfigDir
+	}
+	if opts.AuthorizedKeysDir == "" {
+		opts.AuthorizedKeysDir = defaultSSHDAuthorizedKeysDir
+	}
+	if opts.SSHDBinaryPath == "" {
+		opts.SSHDBinaryPath = defaultSSHDBinaryPath
+	}
+	if opts.ServiceName == "" {
+		opts.ServiceName = defaultSSHDServiceName
+	}
+	return &SSHDAdapter{
+		runner:            runner,
+		configDir:         opts.ConfigDir,
+		authorizedKeysDir: opts.AuthorizedKeysDir,
+		sshdBinaryPath:    opts.SSHDBinaryPath,
+		serviceName:       opts.ServiceName,
+	}
+}
+
+// WriteAccount writes the authorized keys and the Match block of account.
+func (a *SSHDAdapter) WriteAccount(_ context.Context, account adapter.SFTPAccount) error {
+	content, err := a.RenderAccount(account)
+	if err != nil {
+		return err
+	}
+	keys := strings.Join(account.AuthorizedKeys, "\n") + "\n"
+	for _, dir := range []string{a.configDir, a.authorizedKeysDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil { //nolint:gosec // G301: sshd reads these as the logging-in user.
+			return fmt.Errorf("create sshd dir: %w", err)
+		}
+	}
+	keysPath := filepath.Join(a.authorizedKeysDir, account.User)
+	if err := faultinject.Write(keysPath); err != nil {
+		return fmt.Errorf("write authorized keys: %w", err)
+	}
+	if err := os.WriteFile(keysPath, []byte(keys), 0o644); err != nil { //nolint:gosec // G306: sshd requires no more than 0644.
+		return fmt.Errorf("write authorized keys: %w", err)
+	}
+	if err := os.WriteFile(a.configPath(account.User), []byte(content), 0o644); err != nil { //nolint:gosec // G306: matches sshd_config.
+		return fmt.Errorf("write sshd config: %w", err)
+	}
+	return nil
+}
+
+// RenderAccount renders the Match block of account without writing it.
+func (a *SSHDAdapter) RenderAccount(account adapter.SFTPAccount) (string, error) {
+	if !sftpUserPattern.MatchString(account.User) {
+		return "", fmt.Errorf("invalid sftp user %q", account.User)
+	}
+	if !filepath.IsAbs(account.ChrootDir) || filepath.Clean(account.ChrootDir) == "/" || strings.ContainsAny(account.ChrootDir, " \t\r\n\"%") {
+		return "", fmt.Errorf("invalid chroot dir %q", account.ChrootDir)
+	}
+	startDir := path.Clean("/" + account.StartDir)
+	if strings.ContainsAny(startDir, " \t\r\n\"%") {
+		return "", fmt.Errorf("invalid start dir %q", account.StartDir)
+	}
+	if len(account.AuthorizedKeys) == 0 {
+		return "", fmt.Errorf("authorized key is required")
+	}
+	for _, key := range account.AuthorizedKeys {
+		if strings.ContainsAny(key, "\r\n") {
+			return "", fmt.Errorf("invalid authorized key")
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Managed by aiPanel: SFTP access of %s.\n", account.User)
+	fmt.Fprintf(&b, "Match User %s\n", account.User)
+	fmt.Fprintf(&b, "    ChrootDirectory %s\n", filepath.Clean(account.ChrootDir))
+	fmt.Fprintf(&b, "    ForceCommand internal-sftp -d %s\n", startDir)
+	fmt.Fprintf(&b, "    AuthorizedKeysFile %s\n", filepath.Join(a.authorizedKeysDir, account.User))
+	b.WriteString("    PasswordAuthentication no\n")
+	b.WriteString("    KbdInteractiveAuthentication no\n")
+	b.WriteString("    AllowTcpForwarding no\n")
+	b.WriteString("    AllowAgentForwarding no\n")
+	b.WriteString("    X11Forwarding no\n")
+	b.WriteString("    PermitTunnel no\n")
+	// Drop-ins are included at the top of sshd_config; end the block so
+	// the lines after the Include stay global.
+	b.WriteString("Match all\n")
+	return b.String(), nil
+}
+
+// RemoveAccount removes the Match block and authorized keys of user.
+func (a *SSHDAdapter) RemoveAccount(_ context.Context, user string) error {
+	if !sftpUserPattern.MatchString(user) {
+		return fmt.Errorf("invalid sftp user %q", user)
+	}
+	if err := os.Remove(a.configPath(user)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove sshd config: %w", err)
+	}
+	if err := os.Remove(filepath.Join(a.authorizedKeysDir, user)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove authorized keys: %w", err)
+	}
+	return nil
+}
+
+// TestConfig runs "sshd -t".
+func (a *SSHDAdapter) TestConfig(ctx context.Context) error {
+	if _, err := a.runner.Run(ctx, a.sshdBinaryPath, "-t"); err != nil {
+		return fmt.Errorf("sshd config test failed: %w", err)
+	}
+	return nil
+}
+
+// Reload reloads the configured sshd systemd service. Open sessions are
+// kept.
+func (a *SSHDAdapter) Reload(ctx context.Context) error {
+	if _, err := a.runner.Run(ctx, "systemctl", "reload", a.serviceName); err != nil {
+		return fmt.Errorf("sshd reload failed: %w", err)
+	}
+	return nil
+}
+
+func (a *SSHDAdapter) configPath(user string) string {
+	return filepath.Join(a.configDir, "aipanel-sftp-"+user+".conf")
+}
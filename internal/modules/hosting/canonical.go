@@ -0,0 +1,87 @@
+package hosting
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Canonical host modes of a site.
+const (
+	CanonicalHostWWW  = "www"
+	CanonicalHostApex = "apex"
+)
+
+// CanonicalHostRequest sets the canonical host of a site; an empty
+// CanonicalHost serves the site domain only.
+type CanonicalHostRequest struct {
+	CanonicalHost string `json:"canonical_host"`
+	Actor         string `json:"-"`
+}
+
+// SetCanonicalHost switches a site between www, apex and domain-only
+// serving and rewrites its vhost.
+func (s *Service) SetCanonicalHost(ctx context.Context, siteID int64, req CanonicalHostRequest) (Site, error) {
+	if s.store == nil || s.nginx == nil || s.phpfpm == nil {
+		return Site{}, fmt.Errorf("hosting service is not fully configured")
+	}
+	mode, err := normalizeCanonicalHost(req.CanonicalHost)
+	if err != nil {
+		return Site{}, err
+	}
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return Site{}, err
+	}
+	if _, _, err := canonicalHosts(site.Domain, mode); err != nil {
+		return Site{}, err
+	}
+	previous, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return Site{}, err
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(
+		"UPDATE sites SET canonical_host = '%s', updated_at = MAX(%d, updated_at + 1) WHERE id = %d;",
+		sqlEscape(mode), time.Now().Unix(), siteID)); err != nil {
+		return Site{}, fmt.Errorf("update canonical host: %w", err)
+	}
+	s.sitesCache.Purge()
+	site.CanonicalHost = mode
+	next, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return Site{}, err
+	}
+	if err := s.applySiteConfig(ctx, previous, next); err != nil {
+		return Site{}, err
+	}
+	_ = s.writeAudit(ctx, req.Actor, "hosting.canonical_host.set", fmt.Sprintf("domain=%s canonical_host=%s", site.Domain, mode))
+	return s.GetSite(ctx, siteID)
+}
+
+func normalizeCanonicalHost(mode string) (string, error) {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	switch mode {
+	case "", CanonicalHostWWW, CanonicalHostApex:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid canonical_host: expected www, apex or empty")
+	}
+}
+
+// canonicalHosts returns the server name of a site and the alternate name
+// redirected to it. The apex is the domain without a leading "www.".
+func canonicalHosts(domain, mode string) (string, []string, error) {
+	apex := strings.TrimPrefix(domain, "www.")
+	if mode != "" && !strings.Contains(apex, ".") {
+		return "", nil, fmt.Errorf("invalid canonical_host: %s has no apex domain", domain)
+	}
+	switch mode {
+	case CanonicalHostWWW:
+		return "www." + apex, []string{apex}, nil
+	case CanonicalHostApex:
+		return apex, []string{"www." + apex}, nil
+	default:
+		return domain, nil, nil
+	}
+}
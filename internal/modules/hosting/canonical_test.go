@@ -0,0 +1,115 @@
+package hosting
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+)
+
+func TestCanonicalHosts(t *testing.T) {
+	tests := []struct {
+		domain, mode, server string
+		redirects            []string
+	}{
+		{"example.com", "", "example.com", nil},
+		{"example.com", CanonicalHostWWW, "www.example.com", []string{"example.com"}},
+		{"www.example.com", CanonicalHostWWW, "www.example.com", []string{"example.com"}},
+		{"www.example.com", CanonicalHostApex, "example.com", []string{"www.example.com"}},
+	}
+	for _, tc := range tests {
+		server, redirects, err := canonicalHosts(tc.domain, tc.mode)
+		if err != nil || server != tc.server || !slices.Equal(redirects, tc.redirects) {
+			t.Fatalf("canonicalHosts(%q, %q) = %q, %v, %v", tc.domain, tc.mode, server, redirects, err)
+		}
+	}
+	if _, _, err := canonicalHosts("localhost", CanonicalHostWWW); err == nil {
+		t.Fatal("expected single-label domain to be rejected")
+	}
+	if _, err := normalizeCanonicalHost("both"); err == nil || !strings.Contains(err.Error(), "invalid canonical_host") {
+		t.Fatalf("expected invalid mode error, got %v", err)
+	}
+}
+
+func TestSetCanonicalHost_RewritesVhostAndCertificateNames(t *testing.T) {
+	ctx := context.Background()
+	runner := &fakeRunner{}
+	svc := newACMEService(t, config.Config{ACMEEmail: "ops@example.com", ACMEWebroot: "/var/www/letsencrypt"}, runner)
+	nginx := &fakeNginxAdapter{}
+	svc.nginx = nginx
+	svc.phpfpm = &fakePHPFPMAdapter{}
+	root := t.TempDir()
+	if err := svc.store.ExecPanel(ctx, "UPDATE sites SET root_dir = '"+root+"/public_html' WHERE id = 1;"); err != nil {
+		t.Fatalf("move site root: %v", err)
+	}
+
+	site, err := svc.SetCanonicalHost(ctx, 1, CanonicalHostRequest{CanonicalHost: "WWW"})
+	if err != nil {
+		t.Fatalf("set canonical host: %v", err)
+	}
+	if site.CanonicalHost != CanonicalHostWWW {
+		t.Fatalf("unexpected site: %+v", site)
+	}
+	vhost := nginx.writeCalls[len(nginx.writeCalls)-1]
+	if vhost.ServerName != "www.example.com" || !slices.Equal(vhost.RedirectHosts, []string{"example.com"}) {
+		t.Fatalf("unexpected vhost config: %+v", vhost)
+	}
+
+	if err := svc.IssueCertificate(ctx, IssueCertificateRequest{Domain: "example.com"}); err != nil {
+		t.Fatalf("issue certificate: %v", err)
+	}
+	want := "certbot certonly --webroot --webroot-path /var/www/letsencrypt --domain example.com --domain www.example.com --expand --email ops@example.com"
+	found := false
+	for _, cmd := range runner.commands {
+		found = found || strings.HasPrefix(cmd, want)
+	}
+	if !found {
+		t.Fatalf("expected certificate for both names, got %v", runner.commands)
+	}
+
+	if site, err = svc.SetCanonicalHost(ctx, 1, CanonicalHostRequest{}); err != nil || site.CanonicalHost != "" {
+		t.Fatalf("clear canonical host: %+v (%v)", site, err)
+	}
+	if vhost := nginx.writeCalls[len(nginx.writeCalls)-1]; vhost.ServerName != "example.com" || len(vhost.RedirectHosts) != 0 {
+		t.Fatalf("expected domain-only vhost, got %+v", vhost)
+	}
+}
+
+func TestHandleSiteCanonicalHost_RejectsStaleIfMatch(t *testing.T) {
+	svc := newACMEService(t, config.Config{}, &fakeRunner{})
+	svc.nginx = &fakeNginxAdapter{}
+	svc.phpfpm = &fakePHPFPMAdapter{}
+	h := NewHandler(svc)
+
+	rec := httptest.NewRecorder()
+	h.HandleSiteByID(rec, httptest.NewRequest(http.MethodGet, "/api/sites/1", nil), 1, "admin@example.com")
+	tag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || tag == "" {
+		t.Fatalf("expected site with ETag, got %d %q", rec.Code, tag)
+	}
+
+	put := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/sites/1/canonical-host", strings.NewReader(body))
+		req.Header.Set("If-Match", tag)
+		rec := httptest.NewRecorder()
+		h.HandleSiteCanonicalHost(rec, req, 1, "admin@example.com")
+		return rec
+	}
+	first := put(`{"canonical_host":"www"}`)
+	if first.Code != http.StatusOK || first.Header().Get("ETag") == tag {
+		t.Fatalf("expected update with a new ETag, got %d %q: %s", first.Code, first.Header().Get("ETag"), first.Body.String())
+	}
+	// A second admin still holding the old version must not overwrite it.
+	second := put(`{"canonical_host":"apex"}`)
+	if second.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412, got %d: %s", second.Code, second.Body.String())
+	}
+	site, err := svc.GetSite(context.Background(), 1)
+	if err != nil || site.CanonicalHost != CanonicalHostWWW {
+		t.Fatalf("expected first update to survive, got %+v (%v)", site, err)
+	}
+}
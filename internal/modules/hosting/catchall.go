@@ -0,0 +1,142 @@
+package hosting
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+)
+
+const (
+	defaultCatchAllTemplate        = "/etc/aipanel/templates/nginx_catchall.conf.tmpl"
+	defaultCatchAllLandingTemplate = "/etc/aipanel/templates/catchall_landing.html.tmpl"
+	defaultCatchAllRoot            = "/var/www/aipanel-default"
+)
+
+// catchAllWriter is implemented by the file-backed nginx adapter, which
+// owns the catch-all vhost next to the site vhosts.
+type catchAllWriter interface {
+	vhostPreviewer
+	CatchAllEnabled() bool
+	WriteCatchAll(content string) (string, error)
+}
+
+// catchAllData mirrors the fields the installer renders the catch-all
+// templates with.
+type catchAllData struct {
+	Mode        string
+	PanelURL    string
+	LandingRoot string
+}
+
+// CatchAll describes the nginx default server answering requests for
+// hosts no site serves. Enabled is false while the panel itself is the
+// default server, i.e. it has no domain of its own.
+type CatchAll struct {
+	Mode     string `json:"mode"`
+	PanelURL string `json:"panel_url,omitempty"`
+	Enabled  bool   `json:"enabled"`
+	Rendered string `json:"rendered"`
+}
+
+// IsCatchAllTemplate reports whether name is one of the templates the
+// catch-all server is rendered from.
+func IsCatchAllTemplate(name string) bool {
+	return slices.Contains([]string{
+		filepath.Base(defaultCatchAllTemplate),
+		filepath.Base(defaultCatchAllLandingTemplate),
+	}, name)
+}
+
+// CatchAll renders the catch-all vhost from the installed template and
+// the configured mode.
+func (s *Service) CatchAll(_ context.Context) (CatchAll, error) {
+	nginx, ok := s.nginx.(catchAllWriter)
+	if !ok {
+		return CatchAll{}, fmt.Errorf("nginx adapter does not manage the catch-all vhost")
+	}
+	data, err := s.catchAllData()
+	if err != nil {
+		return CatchAll{}, err
+	}
+	rendered, err := renderTemplateFile(s.catchAllTemplate, data)
+	if err != nil {
+		return CatchAll{}, fmt.Errorf("render catch-all template: %w", err)
+	}
+	return CatchAll{Mode: data.Mode, PanelURL: data.PanelURL, Enabled: nginx.CatchAllEnabled(), Rendered: rendered}, nil
+}
+
+// ApplyCatchAll renders the catch-all vhost and, in landing mode, the
+// landing page, then reloads nginx. A vhost that fails the config test is
+// rolled back.
+func (s *Service) ApplyCatchAll(ctx context.Context, actor string) (CatchAll, error) {
+	nginx, ok := s.nginx.(catchAllWriter)
+	if !ok {
+		return CatchAll{}, fmt.Errorf("nginx adapter does not manage the catch-all vhost")
+	}
+	state, err := s.CatchAll(ctx)
+	if err != nil {
+		return CatchAll{}, err
+	}
+	if out, err := nginx.TestStaged(ctx, state.Rendered); err != nil {
+		return CatchAll{}, fmt.Errorf("%w: %s", err, strings.TrimSpace(out))
+	}
+	if state.Mode == config.CatchAllLanding {
+		page, err := renderTemplateFile(s.catchAllLandingTemplate, catchAllData{Mode: state.Mode, LandingRoot: s.catchAllRoot})
+		if err != nil {
+			return CatchAll{}, fmt.Errorf("render catch-all landing page: %w", err)
+		}
+		if err := os.MkdirAll(s.catchAllRoot, 0o755); err != nil { //nolint:gosec // G301: nginx reads the landing page.
+			return CatchAll{}, fmt.Errorf("create catch-all root: %w", err)
+		}
+		if err := os.WriteFile(filepath.Join(s.catchAllRoot, "index.html"), []byte(page), 0o644); err != nil { //nolint:gosec // G306: nginx reads the landing page.
+			return CatchAll{}, fmt.Errorf("write catch-all landing page: %w", err)
+		}
+	}
+	previous, err := nginx.WriteCatchAll(state.Rendered)
+	if err != nil {
+		return CatchAll{}, err
+	}
+	if state.Enabled {
+		if err := s.nginx.TestConfig(ctx); err != nil {
+			_, _ = nginx.WriteCatchAll(previous)
+			return CatchAll{}, err
+		}
+		if err := s.nginx.Reload(ctx); err != nil {
+			return CatchAll{}, err
+		}
+	}
+	_ = s.writeAudit(ctx, actor, "hosting.catchall.apply", fmt.Sprintf("mode=%s enabled=%t", state.Mode, state.Enabled))
+	return state, nil
+}
+
+// panelURL is the base URL of the panel, over HTTPS once it has a
+// certificate, or empty when the panel has no domain.
+func (s *Service) panelURL() string {
+	host := strings.TrimSpace(s.cfg.PanelDomain)
+	if host == "" {
+		return ""
+	}
+	if _, err := os.Stat(filepath.Join(s.letsEncryptDir, "live", host, "fullchain.pem")); err == nil {
+		return "https://" + host
+	}
+	return "http://" + host
+}
+
+// catchAllData fills the template fields from the panel config. Redirects
+// go to the panel over HTTPS once it has a certificate.
+func (s *Service) catchAllData() (catchAllData, error) {
+	data := catchAllData{Mode: s.cfg.CatchAllMode, LandingRoot: s.catchAllRoot}
+	if data.Mode == "" {
+		data.Mode = config.CatchAllDrop
+	}
+	data.PanelURL = s.panelURL()
+	if data.Mode == config.CatchAllRedirect && data.PanelURL == "" {
+		return catchAllData{}, fmt.Errorf("invalid catch-all mode: redirect requires panel_domain")
+	}
+	return data, nil
+}
@@ -0,0 +1,107 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+)
+
+// newCatchAllService renders the shipped catch-all templates into a temp
+// nginx layout with the catch-all vhost enabled.
+func newCatchAllService(t *testing.T, cfg config.Config, runner *fakeRunner) (*Service, string) {
+	t.Helper()
+	root := t.TempDir()
+	nginx := NewNginxAdapter(runner, NginxAdapterOptions{
+		SitesAvailableDir: filepath.Join(root, "sites-available"),
+		SitesEnabledDir:   filepath.Join(root, "sites-enabled"),
+		NginxBinaryPath:   "nginx",
+		NginxConfigPath:   filepath.Join(root, "conf", "nginx.conf"),
+	})
+	svc := newACMEService(t, cfg, runner)
+	svc.nginx = nginx
+	svc.catchAllTemplate = filepath.Join("..", "..", "..", "configs", "templates", "nginx_catchall.conf.tmpl")
+	svc.catchAllLandingTemplate = filepath.Join("..", "..", "..", "configs", "templates", "catchall_landing.html.tmpl")
+	svc.catchAllRoot = filepath.Join(root, "aipanel-default")
+
+	vhost := filepath.Join(root, "sites-available", catchAllVhostName)
+	for _, dir := range []string{"sites-available", "sites-enabled"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o750); err != nil {
+			t.Fatalf("mkdir %s: %v", dir, err)
+		}
+	}
+	if err := os.Symlink(vhost, filepath.Join(root, "sites-enabled", catchAllVhostName)); err != nil {
+		t.Fatalf("enable catch-all: %v", err)
+	}
+	return svc, vhost
+}
+
+func TestApplyCatchAll_RendersModes(t *testing.T) {
+	ctx := context.Background()
+	runner := &fakeRunner{}
+	svc, vhost := newCatchAllService(t, config.Config{CatchAllMode: config.CatchAllLanding}, runner)
+
+	state, err := svc.ApplyCatchAll(ctx, "admin@example.com")
+	if err != nil {
+		t.Fatalf("apply landing: %v", err)
+	}
+	if !state.Enabled || !strings.Contains(state.Rendered, "root "+svc.catchAllRoot+";") {
+		t.Fatalf("unexpected landing catch-all: %+v", state)
+	}
+	page, err := os.ReadFile(filepath.Join(svc.catchAllRoot, "index.html"))
+	if err != nil || !strings.Contains(string(page), "not configured") {
+		t.Fatalf("expected landing page, got %q (%v)", page, err)
+	}
+	written, _ := os.ReadFile(vhost)
+	if string(written) != state.Rendered {
+		t.Fatalf("expected vhost to be written, got %q", written)
+	}
+	if !containsCommand(runner.commands, "systemctl reload aipanel-runtime-nginx.service") {
+		t.Fatalf("expected nginx reload, got %v", runner.commands)
+	}
+
+	svc.cfg.CatchAllMode = config.CatchAllRedirect
+	if _, err := svc.ApplyCatchAll(ctx, "admin@example.com"); err == nil || !strings.Contains(err.Error(), "panel_domain") {
+		t.Fatalf("expected redirect without panel domain to fail, got %v", err)
+	}
+	svc.cfg.PanelDomain = "panel.example.com"
+	state, err = svc.ApplyCatchAll(ctx, "admin@example.com")
+	if err != nil {
+		t.Fatalf("apply redirect: %v", err)
+	}
+	if !strings.Contains(state.Rendered, "return 302 http://panel.example.com/;") {
+		t.Fatalf("expected redirect to the panel, got %q", state.Rendered)
+	}
+
+	svc.cfg.CatchAllMode = config.CatchAllDrop
+	state, err = svc.CatchAll(ctx)
+	if err != nil || !strings.Contains(state.Rendered, "return 444;") {
+		t.Fatalf("expected 444 catch-all, got %+v (%v)", state, err)
+	}
+}
+
+func TestApplyCatchAll_RollsBackFailedConfigTest(t *testing.T) {
+	ctx := context.Background()
+	runner := &fakeRunner{}
+	svc, vhost := newCatchAllService(t, config.Config{CatchAllMode: config.CatchAllDrop}, runner)
+	if err := os.WriteFile(vhost, []byte("server { return 444; }\n"), 0o600); err != nil {
+		t.Fatalf("seed catch-all vhost: %v", err)
+	}
+	nginxTest := "nginx -t -c " + filepath.Join(filepath.Dir(filepath.Dir(vhost)), "conf", "nginx.conf")
+	runner.errs = map[string]error{nginxTest: errors.New("exit status 1")}
+
+	if _, err := svc.ApplyCatchAll(ctx, "admin@example.com"); err == nil {
+		t.Fatal("expected failed config test to be reported")
+	}
+	written, _ := os.ReadFile(vhost)
+	if string(written) != "server { return 444; }\n" {
+		t.Fatalf("expected previous vhost to be restored, got %q", written)
+	}
+	if containsCommand(runner.commands, "systemctl reload aipanel-runtime-nginx.service") {
+		t.Fatal("expected no reload after a failed config test")
+	}
+}
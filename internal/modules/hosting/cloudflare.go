@@ -0,0 +1,340 @@
+package hosting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCloudflareAPI = "https://api.cloudflare.com/client/v4"
+	// cloudflareAutoTTL lets Cloudflare pick the record TTL (required for
+	// proxied records).
+	cloudflareAutoTTL = 1
+	// cloudflareCredentialsFile is certbot's dns-cloudflare credentials file,
+	// kept in the letsencrypt config dir.
+	cloudflareCredentialsFile = "aipanel-cloudflare.ini"
+)
+
+var (
+	// ErrCloudflareNotConfigured indicates a missing cloudflare_api_token.
+	ErrCloudflareNotConfigured = errors.New("cloudflare api token is not configured")
+	// ErrCloudflareNotEnabled indicates a site without Cloudflare records.
+	ErrCloudflareNotEnabled = errors.New("cloudflare is not enabled for this site")
+	// ErrCloudflareZoneNotFound indicates no zone of the token covers the domain.
+	ErrCloudflareZoneNotFound = errors.New("no cloudflare zone found for domain")
+)
+
+// CloudflareSite is the Cloudflare state of one site.
+type CloudflareSite struct {
+	SiteID    int64     `json:"site_id"`
+	Domain    string    `json:"domain"`
+	ZoneID    string    `json:"zone_id"`
+	ZoneName  string    `json:"zone_name"`
+	Proxied   bool      `json:"proxied"`
+	RecordIDs []string  `json:"record_ids"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CloudflareRequest enables Cloudflare for a site or toggles its proxy.
+type CloudflareRequest struct {
+	Proxied bool   `json:"proxied"`
+	Actor   string `json:"-"`
+}
+
+// EnableCloudflare points the site domain at the origin addresses in its
+// Cloudflare zone, creating or updating A/AAAA records.
+func (s *Service) EnableCloudflare(ctx context.Context, siteID int64, req CloudflareRequest) (CloudflareSite, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return CloudflareSite{}, err
+	}
+	if strings.TrimSpace(s.cfg.CloudflareAPIToken) == "" {
+		return CloudflareSite{}, ErrCloudflareNotConfigured
+	}
+	origins := map[string]string{}
+	if ip := strings.TrimSpace(s.cfg.CloudflareOriginIPv4); ip != "" {
+		origins["A"] = ip
+	}
+	if ip := strings.TrimSpace(s.cfg.CloudflareOriginIPv6); ip != "" {
+		origins["AAAA"] = ip
+	}
+	if len(origins) == 0 {
+		return CloudflareSite{}, fmt.Errorf("cloudflare_origin_ipv4 or cloudflare_origin_ipv6 is required")
+	}
+	zoneID, zoneName, err := s.cloudflareZone(ctx, site.Domain)
+	if err != nil {
+		return CloudflareSite{}, err
+	}
+	recordIDs := make([]string, 0, len(origins))
+	for _, recordType := range []string{"A", "AAAA"} {
+		ip, ok := origins[recordType]
+		if !ok {
+			continue
+		}
+		id, err := s.upsertCloudflareRecord(ctx, zoneID, recordType, site.Domain, ip, req.Proxied)
+		if err != nil {
+			return CloudflareSite{}, err
+		}
+		recordIDs = append(recordIDs, id)
+	}
+	if err := s.saveCloudflareSite(ctx, siteID, zoneID, zoneName, req.Proxied, recordIDs); err != nil {
+		return CloudflareSite{}, err
+	}
+	_ = s.writeAudit(ctx, req.Actor, "hosting.cloudflare.enable", fmt.Sprintf("domain=%s proxied=%t", site.Domain, req.Proxied))
+	return s.CloudflareStatus(ctx, siteID)
+}
+
+// CloudflareStatus returns the Cloudflare state of a site.
+func (s *Service) CloudflareStatus(ctx context.Context, siteID int64) (CloudflareSite, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return CloudflareSite{}, err
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT zone_id, zone_name, proxied, record_ids, updated_at FROM site_cloudflare WHERE site_id = %d;", siteID))
+	if err != nil {
+		return CloudflareSite{}, fmt.Errorf("get site cloudflare: %w", err)
+	}
+	if len(rows) == 0 {
+		return CloudflareSite{}, ErrCloudflareNotEnabled
+	}
+	proxied, err := toInt64(rows[0]["proxied"])
+	if err != nil {
+		return CloudflareSite{}, fmt.Errorf("parse cloudflare proxied: %w", err)
+	}
+	updatedAt, err := toInt64(rows[0]["updated_at"])
+	if err != nil {
+		return CloudflareSite{}, fmt.Errorf("parse cloudflare updated_at: %w", err)
+	}
+	recordIDs := []string{}
+	for _, id := range strings.Split(fmt.Sprint(rows[0]["record_ids"]), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			recordIDs = append(recordIDs, id)
+		}
+	}
+	return CloudflareSite{
+		SiteID:    siteID,
+		Domain:    site.Domain,
+		ZoneID:    fmt.Sprint(rows[0]["zone_id"]),
+		ZoneName:  fmt.Sprint(rows[0]["zone_name"]),
+		Proxied:   proxied == 1,
+		RecordIDs: recordIDs,
+		UpdatedAt: time.Unix(updatedAt, 0).UTC(),
+	}, nil
+}
+
+// SetCloudflareProxy turns the Cloudflare proxy of the site records on or off.
+func (s *Service) SetCloudflareProxy(ctx context.Context, siteID int64, req CloudflareRequest) (CloudflareSite, error) {
+	state, err := s.CloudflareStatus(ctx, siteID)
+	if err != nil {
+		return CloudflareSite{}, err
+	}
+	for _, id := range state.RecordIDs {
+		path := "/zones/" + url.PathEscape(state.ZoneID) + "/dns_records/" + url.PathEscape(id)
+		if err := s.cloudflareDo(ctx, http.MethodPatch, path, map[string]any{"proxied": req.Proxied}, nil); err != nil {
+			return CloudflareSite{}, fmt.Errorf("update cloudflare record: %w", err)
+		}
+	}
+	if err := s.saveCloudflareSite(ctx, siteID, state.ZoneID, state.ZoneName, req.Proxied, state.RecordIDs); err != nil {
+		return CloudflareSite{}, err
+	}
+	_ = s.writeAudit(ctx, req.Actor, "hosting.cloudflare.proxy", fmt.Sprintf("domain=%s proxied=%t", state.Domain, req.Proxied))
+	return s.CloudflareStatus(ctx, siteID)
+}
+
+// PurgeCloudflareCache drops Cloudflare's cached copies of the site, e.g.
+// after a deploy.
+func (s *Service) PurgeCloudflareCache(ctx context.Context, siteID int64, actor string) error {
+	state, err := s.CloudflareStatus(ctx, siteID)
+	if err != nil {
+		return err
+	}
+	path := "/zones/" + url.PathEscape(state.ZoneID) + "/purge_cache"
+	if err := s.cloudflareDo(ctx, http.MethodPost, path, map[string]any{"hosts": []string{state.Domain}}, nil); err != nil {
+		return fmt.Errorf("purge cloudflare cache: %w", err)
+	}
+	_ = s.writeAudit(ctx, actor, "hosting.cloudflare.purge", "domain="+state.Domain)
+	return nil
+}
+
+// DisableCloudflare deletes the site DNS records from Cloudflare.
+func (s *Service) DisableCloudflare(ctx context.Context, siteID int64, actor string) error {
+	state, err := s.CloudflareStatus(ctx, siteID)
+	if err != nil {
+		return err
+	}
+	for _, id := range state.RecordIDs {
+		path := "/zones/" + url.PathEscape(state.ZoneID) + "/dns_records/" + url.PathEscape(id)
+		if err := s.cloudflareDo(ctx, http.MethodDelete, path, nil, nil); err != nil {
+			return fmt.Errorf("delete cloudflare record: %w", err)
+		}
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf("DELETE FROM site_cloudflare WHERE site_id = %d;", siteID)); err != nil {
+		return fmt.Errorf("delete site cloudflare: %w", err)
+	}
+	_ = s.writeAudit(ctx, actor, "hosting.cloudflare.disable", "domain="+state.Domain)
+	return nil
+}
+
+// useCloudflareDNS01 reports whether certificates for the site are issued
+// through Cloudflare DNS-01 by default: the site must be on Cloudflare and
+// a token must be configured.
+func (s *Service) useCloudflareDNS01(ctx context.Context, siteID int64) bool {
+	if strings.TrimSpace(s.cfg.CloudflareAPIToken) == "" {
+		return false
+	}
+	_, err := s.CloudflareStatus(ctx, siteID)
+	return err == nil
+}
+
+// writeCloudflareCredentials writes certbot's dns-cloudflare credentials
+// and returns their path. The file is rewritten on every issuance so a
+// rotated token takes effect.
+func (s *Service) writeCloudflareCredentials() (string, error) {
+	if strings.TrimSpace(s.cfg.CloudflareAPIToken) == "" {
+		return "", ErrCloudflareNotConfigured
+	}
+	if err := os.MkdirAll(s.letsEncryptDir, 0o700); err != nil {
+		return "", fmt.Errorf("create letsencrypt dir: %w", err)
+	}
+	path := filepath.Join(s.letsEncryptDir, cloudflareCredentialsFile)
+	body := "dns_cloudflare_api_token = " + strings.TrimSpace(s.cfg.CloudflareAPIToken) + "\n"
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		return "", fmt.Errorf("write cloudflare credentials: %w", err)
+	}
+	return path, nil
+}
+
+// cloudflareZone finds the zone covering domain by trying it and each of
+// its parent domains.
+func (s *Service) cloudflareZone(ctx context.Context, domain string) (string, string, error) {
+	labels := strings.Split(domain, ".")
+	for i := 0; i < len(labels)-1; i++ {
+		name := strings.Join(labels[i:], ".")
+		var zones []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		}
+		if err := s.cloudflareDo(ctx, http.MethodGet, "/zones?name="+url.QueryEscape(name), nil, &zones); err != nil {
+			return "", "", fmt.Errorf("look up cloudflare zone: %w", err)
+		}
+		if len(zones) > 0 {
+			return zones[0].ID, zones[0].Name, nil
+		}
+	}
+	return "", "", ErrCloudflareZoneNotFound
+}
+
+func (s *Service) upsertCloudflareRecord(ctx context.Context, zoneID, recordType, name, content string, proxied bool) (string, error) {
+	base := "/zones/" + url.PathEscape(zoneID) + "/dns_records"
+	var existing []struct {
+		ID string `json:"id"`
+	}
+	query := "?type=" + url.QueryEscape(recordType) + "&name=" + url.QueryEscape(name)
+	if err := s.cloudflareDo(ctx, http.MethodGet, base+query, nil, &existing); err != nil {
+		return "", fmt.Errorf("list cloudflare %s records: %w", recordType, err)
+	}
+	record := map[string]any{
+		"type":    recordType,
+		"name":    name,
+		"content": content,
+		"ttl":     cloudflareAutoTTL,
+		"proxied": proxied,
+	}
+	var result struct {
+		ID string `json:"id"`
+	}
+	if len(existing) > 0 {
+		if err := s.cloudflareDo(ctx, http.MethodPatch, base+"/"+url.PathEscape(existing[0].ID), record, &result); err != nil {
+			return "", fmt.Errorf("update cloudflare %s record: %w", recordType, err)
+		}
+		return existing[0].ID, nil
+	}
+	if err := s.cloudflareDo(ctx, http.MethodPost, base, record, &result); err != nil {
+		return "", fmt.Errorf("create cloudflare %s record: %w", recordType, err)
+	}
+	return result.ID, nil
+}
+
+func (s *Service) saveCloudflareSite(ctx context.Context, siteID int64, zoneID, zoneName string, proxied bool, recordIDs []string) error {
+	proxiedInt := 0
+	if proxied {
+		proxiedInt = 1
+	}
+	sql := fmt.Sprintf(`
+INSERT INTO site_cloudflare(site_id, zone_id, zone_name, proxied, record_ids, updated_at) VALUES(%d,'%s','%s',%d,'%s',%d)
+ON CONFLICT(site_id) DO UPDATE SET zone_id=excluded.zone_id, zone_name=excluded.zone_name,
+  proxied=excluded.proxied, record_ids=excluded.record_ids, updated_at=excluded.updated_at;`,
+		siteID, sqlEscape(zoneID), sqlEscape(zoneName), proxiedInt, sqlEscape(strings.Join(recordIDs, ",")), time.Now().Unix())
+	if err := s.store.ExecPanel(ctx, sql); err != nil {
+		return fmt.Errorf("save site cloudflare: %w", err)
+	}
+	return nil
+}
+
+// cloudflareDo calls the Cloudflare v4 API and decodes the "result" field
+// of its response envelope into out.
+func (s *Service) cloudflareDo(ctx context.Context, method, path string, body, out any) error {
+	token := strings.TrimSpace(s.cfg.CloudflareAPIToken)
+	if token == "" {
+		return ErrCloudflareNotConfigured
+	}
+	var reqBody io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(s.cloudflareAPI, "/")+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	var envelope struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&envelope); err != nil {
+		return fmt.Errorf("cloudflare api %s: unexpected response (status %d)", method, resp.StatusCode)
+	}
+	if !envelope.Success || resp.StatusCode >= http.StatusBadRequest {
+		msgs := make([]string, 0, len(envelope.Errors))
+		for _, e := range envelope.Errors {
+			msgs = append(msgs, fmt.Sprintf("%d %s", e.Code, e.Message))
+		}
+		return fmt.Errorf("cloudflare api %s: status %d: %s", method, resp.StatusCode, strings.Join(msgs, "; "))
+	}
+	if out != nil && len(envelope.Result) > 0 {
+		if err := json.Unmarshal(envelope.Result, out); err != nil {
+			return fmt.Errorf("decode cloudflare result: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package hosting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+)
+
+// fakeCloudflare serves the subset of the Cloudflare v4 API used by the
+// hosting service and records every request as "METHOD path".
+type fakeCloudflare struct {
+	mu       sync.Mutex
+	requests []string
+	records  map[string]map[string]any
+	purged   []string
+}
+
+func (f *fakeCloudflare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	if r.Header.Get("Authorization") != "Bearer cf-token" {
+		w.WriteHeader(http.StatusForbidden)
+		_ = json.NewEncoder(w).Encode(map[string]any{"success": false, "errors": []map[string]any{{"code": 9109, "message": "Invalid access token"}}})
+		return
+	}
+	var body map[string]any
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	result := any(nil)
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/zones":
+		zones := []map[string]any{}
+		if r.URL.Query().Get("name") == "example.com" {
+			zones = append(zones, map[string]any{"id": "zone1", "name": "example.com"})
+		}
+		result = zones
+	case r.Method == http.MethodGet && r.URL.Path == "/zones/zone1/dns_records":
+		out := []map[string]any{}
+		for id, rec := range f.records {
+			if rec["type"] == r.URL.Query().Get("type") && rec["name"] == r.URL.Query().Get("name") {
+				out = append(out, map[string]any{"id": id})
+			}
+		}
+		result = out
+	case r.Method == http.MethodPost && r.URL.Path == "/zones/zone1/dns_records":
+		id := "rec-" + body["type"].(string)
+		f.records[id] = body
+		result = map[string]any{"id": id}
+	case strings.HasPrefix(r.URL.Path, "/zones/zone1/dns_records/"):
+		id := strings.TrimPrefix(r.URL.Path, "/zones/zone1/dns_records/")
+		if r.Method == http.MethodDelete {
+			delete(f.records, id)
+		} else {
+			for k, v := range body {
+				f.records[id][k] = v
+			}
+		}
+		result = map[string]any{"id": id}
+	case r.Method == http.MethodPost && r.URL.Path == "/zones/zone1/purge_cache":
+		for _, host := range body["hosts"].([]any) {
+			f.purged = append(f.purged, host.(string))
+		}
+		result = map[string]any{"id": "zone1"}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(map[string]any{"success": false, "errors": []map[string]any{{"code": 7003, "message": "no route"}}})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"success": true, "errors": []any{}, "result": result})
+}
+
+func newCloudflareService(t *testing.T, cfg config.Config, runner *fakeRunner) (*Service, *fakeCloudflare) {
+	t.Helper()
+	fake := &fakeCloudflare{records: map[string]map[string]any{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	svc := newACMEService(t, cfg, runner)
+	svc.cloudflareAPI = server.URL
+	return svc, fake
+}
+
+func TestCloudflare_ManagesSiteRecords(t *testing.T) {
+	ctx := context.Background()
+	svc, fake := newCloudflareService(t, config.Config{
+		CloudflareAPIToken:   "cf-token",
+		CloudflareOriginIPv4: "203.0.113.10",
+		CloudflareOriginIPv6: "2001:db8::10",
+	}, &fakeRunner{})
+
+	if _, err := svc.CloudflareStatus(ctx, 1); !errors.Is(err, ErrCloudflareNotEnabled) {
+		t.Fatalf("expected ErrCloudflareNotEnabled before enabling, got %v", err)
+	}
+	state, err := svc.EnableCloudflare(ctx, 1, CloudflareRequest{Proxied: true, Actor: "admin@example.com"})
+	if err != nil {
+		t.Fatalf("enable cloudflare: %v", err)
+	}
+	if state.ZoneID != "zone1" || !state.Proxied || len(state.RecordIDs) != 2 {
+		t.Fatalf("unexpected cloudflare state: %+v", state)
+	}
+	if rec := fake.records["rec-A"]; rec["content"] != "203.0.113.10" || rec["proxied"] != true {
+		t.Fatalf("unexpected A record: %+v", rec)
+	}
+	if rec := fake.records["rec-AAAA"]; rec["content"] != "2001:db8::10" {
+		t.Fatalf("unexpected AAAA record: %+v", rec)
+	}
+
+	// Enabling again updates the existing records instead of duplicating them.
+	if _, err := svc.EnableCloudflare(ctx, 1, CloudflareRequest{Proxied: true}); err != nil {
+		t.Fatalf("re-enable cloudflare: %v", err)
+	}
+	if len(fake.records) != 2 {
+		t.Fatalf("expected records to be reused, got %+v", fake.records)
+	}
+
+	state, err = svc.SetCloudflareProxy(ctx, 1, CloudflareRequest{Proxied: false})
+	if err != nil {
+		t.Fatalf("disable proxy: %v", err)
+	}
+	if state.Proxied || fake.records["rec-A"]["proxied"] != false || fake.records["rec-AAAA"]["proxied"] != false {
+		t.Fatalf("expected proxy off, got state=%+v records=%+v", state, fake.records)
+	}
+
+	if err := svc.PurgeCloudflareCache(ctx, 1, "admin@example.com"); err != nil {
+		t.Fatalf("purge cache: %v", err)
+	}
+	if len(fake.purged) != 1 || fake.purged[0] != "example.com" {
+		t.Fatalf("unexpected purge hosts: %v", fake.purged)
+	}
+
+	if err := svc.DisableCloudflare(ctx, 1, "admin@example.com"); err != nil {
+		t.Fatalf("disable cloudflare: %v", err)
+	}
+	if len(fake.records) != 0 {
+		t.Fatalf("expected records to be deleted, got %+v", fake.records)
+	}
+	if _, err := svc.CloudflareStatus(ctx, 1); !errors.Is(err, ErrCloudflareNotEnabled) {
+		t.Fatalf("expected ErrCloudflareNotEnabled after disabling, got %v", err)
+	}
+}
+
+func TestCloudflare_RequiresTokenAndZone(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newCloudflareService(t, config.Config{CloudflareOriginIPv4: "203.0.113.10"}, &fakeRunner{})
+	if _, err := svc.EnableCloudflare(ctx, 1, CloudflareRequest{}); !errors.Is(err, ErrCloudflareNotConfigured) {
+		t.Fatalf("expected ErrCloudflareNotConfigured, got %v", err)
+	}
+
+	svc.cfg.CloudflareAPIToken = "cf-token"
+	other := seedSite(t, svc, "other.test")
+	if _, err := svc.EnableCloudflare(ctx, other, CloudflareRequest{}); !errors.Is(err, ErrCloudflareZoneNotFound) {
+		t.Fatalf("expected ErrCloudflareZoneNotFound, got %v", err)
+	}
+
+	svc.cfg.CloudflareAPIToken = "wrong-token"
+	_, err := svc.EnableCloudflare(ctx, 1, CloudflareRequest{})
+	if err == nil || !strings.Contains(err.Error(), "Invalid access token") {
+		t.Fatalf("expected cloudflare api error, got %v", err)
+	}
+}
+
+func TestIssueCertificate_UsesCloudflareDNS01(t *testing.T) {
+	ctx := context.Background()
+	runner := &fakeRunner{}
+	svc, _ := newCloudflareService(t, config.Config{
+		ACMEEmail:            "ops@example.com",
+		ACMEWebroot:          "/var/www/letsencrypt",
+		CloudflareAPIToken:   "cf-token",
+		CloudflareOriginIPv4: "203.0.113.10",
+	}, runner)
+	if _, err := svc.EnableCloudflare(ctx, 1, CloudflareRequest{Proxied: true}); err != nil {
+		t.Fatalf("enable cloudflare: %v", err)
+	}
+
+	if err := svc.IssueCertificate(ctx, IssueCertificateRequest{Domain: "example.com"}); err != nil {
+		t.Fatalf("issue certificate: %v", err)
+	}
+	credentials := filepath.Join(svc.letsEncryptDir, cloudflareCredentialsFile)
+	dnsCmd := "certbot certonly --dns-cloudflare --dns-cloudflare-credentials " + credentials +
+		" --domain example.com --email ops@example.com --agree-tos --non-interactive --keep-until-expiring --config-dir " + svc.letsEncryptDir
+	if !containsCommand(runner.commands, dnsCmd) {
+		t.Fatalf("expected dns-01 issuance, got %v", runner.commands)
+	}
+	info, err := os.Stat(credentials)
+	if err != nil {
+		t.Fatalf("stat credentials: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected credentials mode 0600, got %o", info.Mode().Perm())
+	}
+	raw, err := os.ReadFile(credentials)
+	if err != nil {
+		t.Fatalf("read credentials: %v", err)
+	}
+	if string(raw) != "dns_cloudflare_api_token = cf-token\n" {
+		t.Fatalf("unexpected credentials: %q", raw)
+	}
+
+	if err := svc.IssueCertificate(ctx, IssueCertificateRequest{Domain: "example.com", Challenge: "http-01"}); err != nil {
+		t.Fatalf("issue http-01 certificate: %v", err)
+	}
+	if !containsCommand(runner.commands, "certbot certonly --webroot --webroot-path /var/www/letsencrypt --domain example.com --email ops@example.com --agree-tos --non-interactive --keep-until-expiring --config-dir "+svc.letsEncryptDir) {
+		t.Fatalf("expected explicit http-01 issuance, got %v", runner.commands)
+	}
+	if err := svc.IssueCertificate(ctx, IssueCertificateRequest{Domain: "example.com", Challenge: "tls-alpn-01"}); err == nil || !strings.Contains(err.Error(), "invalid challenge") {
+		t.Fatalf("expected invalid challenge error, got %v", err)
+	}
+}
@@ -0,0 +1,483 @@
+package hosting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/heartbeat"
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+)
+
+// RunCronJob is the job type that runs one site cron job.
+const RunCronJob = "hosting.cron.run"
+
+const (
+	minCronInterval       = 1
+	maxCronInterval       = 7 * 24 * 60
+	defaultCronTimeout    = 10 * 60
+	maxCronTimeout        = 60 * 60
+	defaultCronAlertAfter = 3
+	maxCronCommandLength  = 4096
+	// cronOutputTail bounds the output kept per run.
+	cronOutputTail = 4096
+	// cronRunsKept is the number of runs kept per cron job.
+	cronRunsKept = 100
+)
+
+// ErrCronJobNotFound indicates a missing site cron job.
+var ErrCronJobNotFound = errors.New("cron job not found")
+
+// CronJob is a command run as the site user every IntervalMinutes.
+type CronJob struct {
+	ID                  int64     `json:"id"`
+	SiteID              int64     `json:"site_id"`
+	Command             string    `json:"command"`
+	IntervalMinutes     int       `json:"interval_minutes"`
+	TimeoutSeconds      int       `json:"timeout_seconds"`
+	AlertAfter          int       `json:"alert_after"`
+	Enabled             bool      `json:"enabled"`
+	NextRunAt           time.Time `json:"next_run_at"`
+	LastRunAt           time.Time `json:"last_run_at,omitzero"`
+	LastExitCode        *int      `json:"last_exit_code,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	HeartbeatURL        string    `json:"heartbeat_url,omitempty"`
+}
+
+// CronJobRequest creates or updates a site cron job. AlertAfter is the
+// number of consecutive failures that triggers an alert (default 3).
+// HeartbeatURL, when set, is pinged after every run (see package
+// heartbeat).
+type CronJobRequest struct {
+	Command         string `json:"command"`
+	IntervalMinutes int    `json:"interval_minutes"`
+	TimeoutSeconds  int    `json:"timeout_seconds"`
+	AlertAfter      int    `json:"alert_after"`
+	Enabled         *bool  `json:"enabled"`
+	HeartbeatURL    string `json:"heartbeat_url"`
+	Actor           string `json:"-"`
+}
+
+// CronRun is one recorded execution of a cron job. ExitCode is -1 when
+// the command could not be started or was killed by the timeout.
+type CronRun struct {
+	ID         int64     `json:"id"`
+	CronID     int64     `json:"cron_id"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS int64     `json:"duration_ms"`
+	ExitCode   int       `json:"exit_code"`
+	Output     string    `json:"output"`
+}
+
+type cronPayload struct {
+	CronID int64 `json:"cron_id"`
+}
+
+// ListCronJobs returns the cron jobs of a site.
+func (s *Service) ListCronJobs(ctx context.Context, siteID int64) ([]CronJob, error) {
+	if _, err := s.GetSite(ctx, siteID); err != nil {
+		return nil, err
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT id, site_id, command, interval_minutes, timeout_seconds, alert_after, enabled, next_run_at, last_run_at, last_exit_code, consecutive_failures, heartbeat_url
+FROM site_cron_jobs
+WHERE site_id = %d
+ORDER BY id;`, siteID))
+	if err != nil {
+		return nil, fmt.Errorf("list cron jobs: %w", err)
+	}
+	jobs := make([]CronJob, 0, len(rows))
+	for _, row := range rows {
+		job, err := mapRowToCronJob(row)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// GetCronJob returns one cron job of a site.
+func (s *Service) GetCronJob(ctx context.Context, siteID, cronID int64) (CronJob, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT id, site_id, command, interval_minutes, timeout_seconds, alert_after, enabled, next_run_at, last_run_at, last_exit_code, consecutive_failures, heartbeat_url
+FROM site_cron_jobs
+WHERE id = %d AND site_id = %d;`, cronID, siteID))
+	if err != nil {
+		return CronJob{}, fmt.Errorf("get cron job: %w", err)
+	}
+	if len(rows) == 0 {
+		return CronJob{}, ErrCronJobNotFound
+	}
+	return mapRowToCronJob(rows[0])
+}
+
+// CreateCronJob adds a cron job to a site. The first run is one interval
+// from now.
+func (s *Service) CreateCronJob(ctx context.Context, siteID int64, req CronJobRequest) (CronJob, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return CronJob{}, err
+	}
+	req, err = normalizeCronJobRequest(req)
+	if err != nil {
+		return CronJob{}, err
+	}
+	enabled := 1
+	if req.Enabled != nil && !*req.Enabled {
+		enabled = 0
+	}
+	now := time.Now().Unix()
+	rows, err := s.store.QueryPanelWriteJSON(ctx, fmt.Sprintf(`
+INSERT INTO site_cron_jobs(site_id, command, interval_minutes, timeout_seconds, alert_after, enabled, heartbeat_url, next_run_at, created_at, updated_at)
+VALUES(%d,'%s',%d,%d,%d,%d,'%s',%d,%d,%d)
+RETURNING id;`,
+		siteID, sqlEscape(req.Command), req.IntervalMinutes, req.TimeoutSeconds, req.AlertAfter, enabled,
+		sqlEscape(req.HeartbeatURL), now+int64(req.IntervalMinutes)*60, now, now))
+	if err != nil {
+		return CronJob{}, fmt.Errorf("insert cron job: %w", err)
+	}
+	if len(rows) == 0 {
+		return CronJob{}, fmt.Errorf("insert cron job: no id returned")
+	}
+	id, err := toInt64(rows[0]["id"])
+	if err != nil {
+		return CronJob{}, fmt.Errorf("parse cron job id: %w", err)
+	}
+	_ = s.writeAudit(ctx, req.Actor, "hosting.cron.create", fmt.Sprintf("domain=%s cron_id=%d interval=%d", site.Domain, id, req.IntervalMinutes))
+	return s.GetCronJob(ctx, siteID, id)
+}
+
+// UpdateCronJob replaces the settings of a cron job. Changing the interval
+// reschedules the next run one interval from now.
+func (s *Service) UpdateCronJob(ctx context.Context, siteID, cronID int64, req CronJobRequest) (CronJob, error) {
+	existing, err := s.GetCronJob(ctx, siteID, cronID)
+	if err != nil {
+		return CronJob{}, err
+	}
+	req, err = normalizeCronJobRequest(req)
+	if err != nil {
+		return CronJob{}, err
+	}
+	enabled := 0
+	if existing.Enabled {
+		enabled = 1
+	}
+	if req.Enabled != nil {
+		enabled = 0
+		if *req.Enabled {
+			enabled = 1
+		}
+	}
+	now := time.Now().Unix()
+	nextRunAt := existing.NextRunAt.Unix()
+	if req.IntervalMinutes != existing.IntervalMinutes {
+		nextRunAt = now + int64(req.IntervalMinutes)*60
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+UPDATE site_cron_jobs
+SET command='%s', interval_minutes=%d, timeout_seconds=%d, alert_after=%d, enabled=%d, heartbeat_url='%s', next_run_at=%d, updated_at=%d
+WHERE id = %d;`,
+		sqlEscape(req.Command), req.IntervalMinutes, req.TimeoutSeconds, req.AlertAfter, enabled,
+		sqlEscape(req.HeartbeatURL), nextRunAt, now, cronID)); err != nil {
+		return CronJob{}, fmt.Errorf("update cron job: %w", err)
+	}
+	_ = s.writeAudit(ctx, req.Actor, "hosting.cron.update", fmt.Sprintf("site_id=%d cron_id=%d", siteID, cronID))
+	return s.GetCronJob(ctx, siteID, cronID)
+}
+
+// DeleteCronJob removes a cron job and its run history.
+func (s *Service) DeleteCronJob(ctx context.Context, siteID, cronID int64, actor string) error {
+	if _, err := s.GetCronJob(ctx, siteID, cronID); err != nil {
+		return err
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+DELETE FROM site_cron_runs WHERE cron_id = %d;
+DELETE FROM site_cron_jobs WHERE id = %d;`, cronID, cronID)); err != nil {
+		return fmt.Errorf("delete cron job: %w", err)
+	}
+	_ = s.writeAudit(ctx, actor, "hosting.cron.delete", fmt.Sprintf("site_id=%d cron_id=%d", siteID, cronID))
+	return nil
+}
+
+// TriggerCronJob queues an immediate run of a cron job.
+func (s *Service) TriggerCronJob(ctx context.Context, siteID, cronID int64, actor string) (int64, error) {
+	if s.jobs == nil {
+		return 0, fmt.Errorf("job queue is not configured")
+	}
+	if _, err := s.GetCronJob(ctx, siteID, cronID); err != nil {
+		return 0, err
+	}
+	id, err := s.jobs.Enqueue(ctx, RunCronJob, cronPayload{CronID: cronID})
+	if err != nil {
+		return 0, err
+	}
+	_ = s.writeAudit(ctx, actor, "hosting.cron.trigger", fmt.Sprintf("site_id=%d cron_id=%d", siteID, cronID))
+	return id, nil
+}
+
+// ListCronRuns returns recorded runs of a cron job, newest first.
+func (s *Service) ListCronRuns(ctx context.Context, siteID, cronID int64, limit int) ([]CronRun, error) {
+	if _, err := s.GetCronJob(ctx, siteID, cronID); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > cronRunsKept {
+		limit = cronRunsKept
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT id, cron_id, started_at, duration_ms, exit_code, output
+FROM site_cron_runs
+WHERE cron_id = %d
+ORDER BY id DESC
+LIMIT %d;`, cronID, limit))
+	if err != nil {
+		return nil, fmt.Errorf("list cron runs: %w", err)
+	}
+	runs := make([]CronRun, 0, len(rows))
+	for _, row := range rows {
+		fields := map[string]int64{}
+		for _, key := range []string{"id", "cron_id", "started_at", "duration_ms", "exit_code"} {
+			v, err := toInt64(row[key])
+			if err != nil {
+				return nil, fmt.Errorf("parse cron run %s: %w", key, err)
+			}
+			fields[key] = v
+		}
+		output, _ := row["output"].(string)
+		runs = append(runs, CronRun{
+			ID:         fields["id"],
+			CronID:     fields["cron_id"],
+			StartedAt:  time.Unix(fields["started_at"], 0).UTC(),
+			DurationMS: fields["duration_ms"],
+			ExitCode:   int(fields["exit_code"]),
+			Output:     output,
+		})
+	}
+	return runs, nil
+}
+
+// RunDueCronJobs enqueues one run per enabled cron job that is due and
+// moves its next run forward, so a slow run is never queued twice.
+func (s *Service) RunDueCronJobs(ctx context.Context) (int, error) {
+	if s.jobs == nil {
+		return 0, fmt.Errorf("job queue is not configured")
+	}
+	now := time.Now().Unix()
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT id, interval_minutes
+FROM site_cron_jobs
+WHERE enabled = 1 AND next_run_at <= %d
+ORDER BY next_run_at;`, now))
+	if err != nil {
+		return 0, fmt.Errorf("list due cron jobs: %w", err)
+	}
+	queued := 0
+	for _, row := range rows {
+		id, err := toInt64(row["id"])
+		if err != nil {
+			return queued, fmt.Errorf("parse cron job id: %w", err)
+		}
+		interval, err := toInt64(row["interval_minutes"])
+		if err != nil {
+			return queued, fmt.Errorf("parse cron job interval: %w", err)
+		}
+		if err := s.store.ExecPanel(ctx, fmt.Sprintf(
+			"UPDATE site_cron_jobs SET next_run_at = %d WHERE id = %d;", now+interval*60, id)); err != nil {
+			return queued, fmt.Errorf("advance cron job: %w", err)
+		}
+		if _, err := s.jobs.Enqueue(ctx, RunCronJob, cronPayload{CronID: id}); err != nil {
+			return queued, err
+		}
+		queued++
+	}
+	return queued, nil
+}
+
+// runCronJob runs the command as the site user from the site docroot and
+// records exit code, duration and the tail of its output.
+func (s *Service) runCronJob(ctx context.Context, job jobqueue.Job) error {
+	var payload cronPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("decode cron payload: %w", err)
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT site_id FROM site_cron_jobs WHERE id = %d;", payload.CronID))
+	if err != nil {
+		return fmt.Errorf("get cron job: %w", err)
+	}
+	if len(rows) == 0 {
+		// Removed after the run was queued; nothing to do.
+		return nil
+	}
+	siteID, err := toInt64(rows[0]["site_id"])
+	if err != nil {
+		return fmt.Errorf("parse cron job site_id: %w", err)
+	}
+	cron, err := s.GetCronJob(ctx, siteID, payload.CronID)
+	if err != nil {
+		return err
+	}
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"-u", site.SystemUser, "--", "/bin/sh", "-c", "cd " + shellQuote(site.RootDir) + " && " + cron.Command}
+	name := "runuser"
+	// Sites with resource limits run their cron jobs inside the site slice.
+	if limits, err := s.siteLimits(ctx, site); err == nil {
+		args = append([]string{"--quiet", "--scope", "--slice=" + limits.Slice, "runuser"}, args...)
+		name = "systemd-run"
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, time.Duration(cron.TimeoutSeconds)*time.Second)
+	defer cancel()
+	started := time.Now()
+	out, runErr := s.runner.Run(runCtx, name, args...)
+	duration := time.Since(started)
+	exitCode := 0
+	if runErr != nil {
+		exitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(runErr, &exitErr) && runCtx.Err() == nil {
+			exitCode = exitErr.ExitCode()
+		}
+		if runCtx.Err() != nil {
+			out = strings.TrimRight(out, "\n") + fmt.Sprintf("\n[aipanel] killed after %ds timeout", cron.TimeoutSeconds)
+		}
+	}
+	if len(out) > cronOutputTail {
+		out = out[len(out)-cronOutputTail:]
+	}
+	s.recordCronRun(context.WithoutCancel(ctx), site, cron, started, duration, exitCode, out)
+	var result error
+	if runErr != nil {
+		result = fmt.Errorf("cron job %d exited with code %d", cron.ID, exitCode)
+	}
+	s.heartbeat(ctx, cron.HeartbeatURL, result)
+	return result
+}
+
+// recordCronRun stores a run, prunes old runs and alerts once a job has
+// failed AlertAfter times in a row.
+func (s *Service) recordCronRun(ctx context.Context, site Site, cron CronJob, started time.Time, duration time.Duration, exitCode int, output string) {
+	failuresSQL := "0"
+	if exitCode != 0 {
+		failuresSQL = "consecutive_failures + 1"
+	}
+	sql := fmt.Sprintf(`
+INSERT INTO site_cron_runs(cron_id, started_at, duration_ms, exit_code, output)
+VALUES(%d,%d,%d,%d,'%s');
+DELETE FROM site_cron_runs WHERE cron_id = %d AND id NOT IN (
+  SELECT id FROM site_cron_runs WHERE cron_id = %d ORDER BY id DESC LIMIT %d
+);
+UPDATE site_cron_jobs SET last_run_at = %d, last_exit_code = %d, consecutive_failures = %s WHERE id = %d;`,
+		cron.ID, started.Unix(), duration.Milliseconds(), exitCode, sqlEscape(output),
+		cron.ID, cron.ID, cronRunsKept,
+		started.Unix(), exitCode, failuresSQL, cron.ID)
+	if err := s.store.ExecPanel(ctx, sql); err != nil {
+		s.log.Error("record cron run", "cron_id", cron.ID, "error", err.Error())
+		return
+	}
+	if exitCode == 0 {
+		return
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT consecutive_failures FROM site_cron_jobs WHERE id = %d;", cron.ID))
+	if err != nil || len(rows) == 0 {
+		return
+	}
+	failures, err := toInt64(rows[0]["consecutive_failures"])
+	// Alert once per failure streak, not on every failed run after it.
+	if err != nil || failures != int64(cron.AlertAfter) {
+		return
+	}
+	s.log.Error("cron job keeps failing", "domain", site.Domain, "cron_id", cron.ID, "failures", failures, "exit_code", exitCode)
+	if s.notify == nil {
+		return
+	}
+	subject := fmt.Sprintf("Cron job failing for %s", site.Domain)
+	body := fmt.Sprintf(
+		"Cron job %d of %s has failed %d times in a row.\n\nCommand: %s\nLast exit code: %d\n\nOutput (tail):\n%s\n",
+		cron.ID, site.Domain, failures, cron.Command, exitCode, output,
+	)
+	if err := s.notify(ctx, subject, body); err != nil {
+		s.log.Error("send cron alert", "domain", site.Domain, "cron_id", cron.ID, "error", err.Error())
+	}
+}
+
+func normalizeCronJobRequest(req CronJobRequest) (CronJobRequest, error) {
+	req.Command = strings.TrimSpace(req.Command)
+	if req.Command == "" {
+		return req, fmt.Errorf("command is required")
+	}
+	if len(req.Command) > maxCronCommandLength || strings.ContainsAny(req.Command, "\x00\r\n") {
+		return req, fmt.Errorf("invalid command: must be a single line of at most %d bytes", maxCronCommandLength)
+	}
+	if req.IntervalMinutes < minCronInterval || req.IntervalMinutes > maxCronInterval {
+		return req, fmt.Errorf("interval_minutes must be between %d and %d", minCronInterval, maxCronInterval)
+	}
+	if req.TimeoutSeconds == 0 {
+		req.TimeoutSeconds = defaultCronTimeout
+	}
+	if req.TimeoutSeconds < 1 || req.TimeoutSeconds > maxCronTimeout {
+		return req, fmt.Errorf("timeout_seconds must be between 1 and %d", maxCronTimeout)
+	}
+	if req.AlertAfter == 0 {
+		req.AlertAfter = defaultCronAlertAfter
+	}
+	if req.AlertAfter < 1 {
+		return req, fmt.Errorf("alert_after must be at least 1")
+	}
+	req.HeartbeatURL = strings.TrimSpace(req.HeartbeatURL)
+	if err := heartbeat.Validate(req.HeartbeatURL); err != nil {
+		return req, err
+	}
+	return req, nil
+}
+
+func mapRowToCronJob(row map[string]any) (CronJob, error) {
+	fields := map[string]int64{}
+	for _, key := range []string{"id", "site_id", "interval_minutes", "timeout_seconds", "alert_after", "enabled", "next_run_at", "last_run_at", "consecutive_failures"} {
+		v, err := toInt64(row[key])
+		if err != nil {
+			return CronJob{}, fmt.Errorf("parse cron job %s: %w", key, err)
+		}
+		fields[key] = v
+	}
+	command, _ := row["command"].(string)
+	heartbeatURL, _ := row["heartbeat_url"].(string)
+	job := CronJob{
+		ID:                  fields["id"],
+		SiteID:              fields["site_id"],
+		Command:             command,
+		IntervalMinutes:     int(fields["interval_minutes"]),
+		TimeoutSeconds:      int(fields["timeout_seconds"]),
+		AlertAfter:          int(fields["alert_after"]),
+		Enabled:             fields["enabled"] == 1,
+		NextRunAt:           time.Unix(fields["next_run_at"], 0).UTC(),
+		ConsecutiveFailures: int(fields["consecutive_failures"]),
+		HeartbeatURL:        heartbeatURL,
+	}
+	if fields["last_run_at"] > 0 {
+		job.LastRunAt = time.Unix(fields["last_run_at"], 0).UTC()
+	}
+	if row["last_exit_code"] != nil {
+		code, err := toInt64(row["last_exit_code"])
+		if err != nil {
+			return CronJob{}, fmt.Errorf("parse cron job last_exit_code: %w", err)
+		}
+		exitCode := int(code)
+		job.LastExitCode = &exitCode
+	}
+	return job, nil
+}
+
+// shellQuote single-quotes s for /bin/sh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
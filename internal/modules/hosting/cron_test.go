@@ -0,0 +1,122 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+)
+
+func TestCronJobs_RecordRunsAndAlertOnConsecutiveFailures(t *testing.T) {
+	ctx := context.Background()
+	runner := &fakeRunner{}
+	svc := newACMEService(t, config.Config{}, runner)
+	var alerts []string
+	svc.SetNotifier(func(_ context.Context, subject, body string) error {
+		alerts = append(alerts, subject+"\n"+body)
+		return nil
+	})
+	var pings []string
+	svc.ping = func(_ context.Context, url string, runErr error) error {
+		pings = append(pings, fmt.Sprintf("%s ok=%t", url, runErr == nil))
+		return nil
+	}
+	queue := jobqueue.New(svc.store, nil)
+	svc.RegisterJobs(queue)
+
+	if _, err := svc.CreateCronJob(ctx, 1, CronJobRequest{Command: "php artisan schedule:run"}); err == nil || !strings.Contains(err.Error(), "interval_minutes") {
+		t.Fatalf("expected interval validation error, got %v", err)
+	}
+	if _, err := svc.CreateCronJob(ctx, 1, CronJobRequest{Command: "true\nrm -rf /", IntervalMinutes: 5}); err == nil {
+		t.Fatal("expected multi-line command to be rejected")
+	}
+	if _, err := svc.CreateCronJob(ctx, 1, CronJobRequest{Command: "true", IntervalMinutes: 5, HeartbeatURL: "http://127.0.0.1:3001/api/push/x"}); err == nil || !strings.Contains(err.Error(), "invalid heartbeat URL") {
+		t.Fatalf("expected local heartbeat URL to be rejected, got %v", err)
+	}
+	const pingURL = "https://hc-ping.com/5f2b6c1e"
+	cron, err := svc.CreateCronJob(ctx, 1, CronJobRequest{Command: "php artisan schedule:run", IntervalMinutes: 5, AlertAfter: 2, HeartbeatURL: pingURL})
+	if err != nil {
+		t.Fatalf("create cron job: %v", err)
+	}
+	if cron.TimeoutSeconds != defaultCronTimeout || !cron.Enabled || cron.LastExitCode != nil || cron.HeartbeatURL != pingURL {
+		t.Fatalf("unexpected cron job: %+v", cron)
+	}
+
+	cmd := "runuser -u site_example_com -- /bin/sh -c cd '" + filepath.Join(svc.webRoot, "example.com", "public_html") + "' && php artisan schedule:run"
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+	runner.errs = map[string]error{cmd: fmt.Errorf("exec runuser: %w", exitErr)}
+	runner.outputs = map[string]string{cmd: "Could not open input file: artisan"}
+
+	if _, err := svc.RunDueCronJobs(ctx); err != nil {
+		t.Fatalf("run due cron jobs: %v", err)
+	}
+	if n, _ := queue.RunPending(ctx); n != 0 {
+		t.Fatalf("expected nothing due before the first interval, ran %d", n)
+	}
+	for i := 0; i < 3; i++ {
+		if err := svc.store.ExecPanel(ctx, fmt.Sprintf("UPDATE site_cron_jobs SET next_run_at = 0 WHERE id = %d;", cron.ID)); err != nil {
+			t.Fatalf("make cron job due: %v", err)
+		}
+		queued, err := svc.RunDueCronJobs(ctx)
+		if err != nil || queued != 1 {
+			t.Fatalf("expected one queued run, got %d (%v)", queued, err)
+		}
+		if _, err := queue.RunPending(ctx); err != nil {
+			t.Fatalf("run jobs: %v", err)
+		}
+		if i == 0 && len(alerts) != 0 {
+			t.Fatalf("expected no alert after a single failure, got %v", alerts)
+		}
+	}
+	// Alerts fire once per failure streak.
+	if len(alerts) != 1 || !strings.Contains(alerts[0], "Could not open input file") || !strings.Contains(alerts[0], "exit code: 3") {
+		t.Fatalf("expected one alert with the command output, got %v", alerts)
+	}
+
+	runs, err := svc.ListCronRuns(ctx, 1, cron.ID, 0)
+	if err != nil {
+		t.Fatalf("list cron runs: %v", err)
+	}
+	if len(runs) != 3 || runs[0].ExitCode != 3 || runs[0].Output != "Could not open input file: artisan" {
+		t.Fatalf("unexpected cron runs: %+v", runs)
+	}
+	cron, err = svc.GetCronJob(ctx, 1, cron.ID)
+	if err != nil {
+		t.Fatalf("get cron job: %v", err)
+	}
+	if cron.ConsecutiveFailures != 3 || cron.LastExitCode == nil || *cron.LastExitCode != 3 {
+		t.Fatalf("unexpected cron job after failures: %+v", cron)
+	}
+
+	runner.errs = nil
+	if _, err := svc.TriggerCronJob(ctx, 1, cron.ID, "admin@example.com"); err != nil {
+		t.Fatalf("trigger cron job: %v", err)
+	}
+	if _, err := queue.RunPending(ctx); err != nil {
+		t.Fatalf("run jobs: %v", err)
+	}
+	cron, err = svc.GetCronJob(ctx, 1, cron.ID)
+	if err != nil {
+		t.Fatalf("get cron job: %v", err)
+	}
+	if cron.ConsecutiveFailures != 0 || *cron.LastExitCode != 0 {
+		t.Fatalf("expected success to reset failures, got %+v", cron)
+	}
+	wantPings := []string{pingURL + " ok=false", pingURL + " ok=false", pingURL + " ok=false", pingURL + " ok=true"}
+	if fmt.Sprint(pings) != fmt.Sprint(wantPings) {
+		t.Fatalf("expected one heartbeat per run, got %v", pings)
+	}
+
+	if err := svc.DeleteCronJob(ctx, 1, cron.ID, "admin@example.com"); err != nil {
+		t.Fatalf("delete cron job: %v", err)
+	}
+	if _, err := svc.ListCronRuns(ctx, 1, cron.ID, 0); !errors.Is(err, ErrCronJobNotFound) {
+		t.Fatalf("expected ErrCronJobNotFound after delete, got %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package hosting
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Deliverability check statuses.
+const (
+	CheckPass    = "pass"
+	CheckFail    = "fail"
+	CheckSkipped = "skipped"
+)
+
+const (
+	defaultDKIMKeyDir   = "/etc/aipanel/dkim"
+	defaultDKIMSelector = "default"
+)
+
+// DNSRecordCheck is one recommended DNS record and its verification result.
+type DNSRecordCheck struct {
+	Type        string   `json:"type"`
+	Name        string   `json:"name"`
+	Recommended string   `json:"recommended,omitempty"`
+	Found       []string `json:"found"`
+	Status      string   `json:"status"`
+	Detail      string   `json:"detail,omitempty"`
+}
+
+// Deliverability groups SPF, DKIM and DMARC checks for a site domain.
+type Deliverability struct {
+	Domain string         `json:"domain"`
+	SPF    DNSRecordCheck `json:"spf"`
+	DKIM   DNSRecordCheck `json:"dkim"`
+	DMARC  DNSRecordCheck `json:"dmarc"`
+}
+
+// CheckDeliverability builds recommended mail DNS records for the site domain
+// and verifies what is currently published.
+func (s *Service) CheckDeliverability(ctx context.Context, siteID int64) (Deliverability, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return Deliverability{}, err
+	}
+	domain := site.Domain
+	return Deliverability{
+		Domain: domain,
+		SPF:    s.checkSPF(ctx, domain),
+		DKIM:   s.checkDKIM(ctx, domain),
+		DMARC:  s.checkDMARC(ctx, domain),
+	}, nil
+}
+
+func (s *Service) checkSPF(ctx context.Context, domain string) DNSRecordCheck {
+	mechanisms := []string{"v=spf1", "a", "mx"}
+	if relay := strings.TrimSpace(s.cfg.SMTPHost); relay != "" && !isLocalHost(relay) {
+		mechanisms = append(mechanisms, "a:"+relay)
+	}
+	mechanisms = append(mechanisms, "~all")
+	check := DNSRecordCheck{
+		Type:        "TXT",
+		Name:        domain,
+		Recommended: strings.Join(mechanisms, " "),
+	}
+	found, err := s.lookupTXT(ctx, domain)
+	check.Found = filterTXT(found, "v=spf1")
+	switch {
+	case err != nil && !isNotFound(err):
+		check.Status, check.Detail = CheckFail, "dns lookup failed: "+err.Error()
+	case len(check.Found) == 0:
+		check.Status, check.Detail = CheckFail, "no SPF record published"
+	case len(check.Found) > 1:
+		check.Status, check.Detail = CheckFail, "multiple SPF records published; receivers treat this as an error"
+	default:
+		check.Status = CheckPass
+	}
+	return check
+}
+
+func (s *Service) checkDMARC(ctx context.Context, domain string) DNSRecordCheck {
+	check := DNSRecordCheck{
+		Type:        "TXT",
+		Name:        "_dmarc." + domain,
+		Recommended: "v=DMARC1; p=quarantine; rua=mailto:postmaster@" + domain,
+	}
+	found, err := s.lookupTXT(ctx, check.Name)
+	check.Found = filterTXT(found, "v=DMARC1")
+	switch {
+	case err != nil && !isNotFound(err):
+		check.Status, check.Detail = CheckFail, "dns lookup failed: "+err.Error()
+	case len(check.Found) == 0:
+		check.Status, check.Detail = CheckFail, "no DMARC record published"
+	default:
+		check.Status = CheckPass
+	}
+	return check
+}
+
+// checkDKIM is skipped unless a DKIM public key was provisioned for the domain
+// (by the mail module) under dkimKeyDir as "<domain>.pub".
+func (s *Service) checkDKIM(ctx context.Context, domain string) DNSRecordCheck {
+	check := DNSRecordCheck{
+		Type:  "TXT",
+		Name:  defaultDKIMSelector + "._domainkey." + domain,
+		Found: []string{},
+	}
+	pubKey, err := readDKIMPublicKey(filepath.Join(s.dkimKeyDir, domain+".pub"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			check.Status, check.Detail = CheckSkipped, "mail module is not active for this domain"
+			return check
+		}
+		check.Status, check.Detail = CheckFail, err.Error()
+		return check
+	}
+	check.Recommended = "v=DKIM1; k=rsa; p=" + pubKey
+
+	found, err := s.lookupTXT(ctx, check.Name)
+	check.Found = filterTXT(found, "v=DKIM1")
+	switch {
+	case err != nil && !isNotFound(err):
+		check.Status, check.Detail = CheckFail, "dns lookup failed: "+err.Error()
+	case len(check.Found) == 0:
+		check.Status, check.Detail = CheckFail, "no DKIM record published"
+	default:
+		check.Status, check.Detail = CheckFail, "published DKIM key does not match the provisioned key"
+		for _, rec := range check.Found {
+			if strings.Contains(strings.ReplaceAll(rec, " ", ""), "p="+pubKey) {
+				check.Status, check.Detail = CheckPass, ""
+				break
+			}
+		}
+	}
+	return check
+}
+
+func (s *Service) lookupTXT(ctx context.Context, name string) ([]string, error) {
+	if s.txtLookup != nil {
+		return s.txtLookup(ctx, name)
+	}
+	return net.DefaultResolver.LookupTXT(ctx, name)
+}
+
+// readDKIMPublicKey returns the base64 body of a PEM public key file.
+func readDKIMPublicKey(path string) (string, error) {
+	// Path is derived from the validated site domain under the panel key dir.
+	//nolint:gosec // G304
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return "", fmt.Errorf("invalid DKIM public key %s", path)
+	}
+	return base64.StdEncoding.EncodeToString(block.Bytes), nil
+}
+
+func filterTXT(records []string, prefix string) []string {
+	out := []string{}
+	for _, rec := range records {
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(rec)), strings.ToLower(prefix)) {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+func isLocalHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
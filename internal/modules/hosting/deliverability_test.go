@@ -0,0 +1,74 @@
+package hosting
+
+import (
+	"context"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+)
+
+func newDeliverabilityService(t *testing.T, records map[string][]string) *Service {
+	t.Helper()
+	svc := newTestService(t, withConfig(config.Config{SMTPHost: "relay.example.net"}))
+	seedSite(t, svc, "example.com")
+	svc.dkimKeyDir = t.TempDir()
+	svc.txtLookup = func(_ context.Context, name string) ([]string, error) {
+		if recs, ok := records[name]; ok {
+			return recs, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return svc
+}
+
+func TestCheckDeliverability_ReportsMissingRecords(t *testing.T) {
+	svc := newDeliverabilityService(t, map[string][]string{
+		"example.com": {"google-site-verification=abc", "v=spf1 -all", "v=spf1 mx ~all"},
+	})
+
+	report, err := svc.CheckDeliverability(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("check deliverability: %v", err)
+	}
+	if report.SPF.Recommended != "v=spf1 a mx a:relay.example.net ~all" {
+		t.Fatalf("unexpected SPF recommendation: %q", report.SPF.Recommended)
+	}
+	if report.SPF.Status != CheckFail || len(report.SPF.Found) != 2 {
+		t.Fatalf("expected duplicate SPF records to fail, got %+v", report.SPF)
+	}
+	if report.DMARC.Status != CheckFail || report.DMARC.Name != "_dmarc.example.com" {
+		t.Fatalf("expected missing DMARC to fail, got %+v", report.DMARC)
+	}
+	if report.DKIM.Status != CheckSkipped {
+		t.Fatalf("expected DKIM to be skipped without a provisioned key, got %+v", report.DKIM)
+	}
+}
+
+func TestCheckDeliverability_PassesWithPublishedRecords(t *testing.T) {
+	svc := newDeliverabilityService(t, map[string][]string{
+		"example.com":                    {"v=spf1 a mx ~all"},
+		"_dmarc.example.com":             {"v=DMARC1; p=reject"},
+		"default._domainkey.example.com": {"v=DKIM1; k=rsa; p=a2V5Ynl0ZXM="},
+	})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("keybytes")})
+	if err := os.WriteFile(filepath.Join(svc.dkimKeyDir, "example.com.pub"), keyPEM, 0o600); err != nil {
+		t.Fatalf("write dkim key: %v", err)
+	}
+
+	report, err := svc.CheckDeliverability(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("check deliverability: %v", err)
+	}
+	for name, check := range map[string]DNSRecordCheck{"spf": report.SPF, "dkim": report.DKIM, "dmarc": report.DMARC} {
+		if check.Status != CheckPass {
+			t.Fatalf("expected %s to pass, got %+v", name, check)
+		}
+	}
+	if report.DKIM.Recommended != "v=DKIM1; k=rsa; p=a2V5Ynl0ZXM=" {
+		t.Fatalf("unexpected DKIM recommendation: %q", report.DKIM.Recommended)
+	}
+}
@@ -0,0 +1,42 @@
+package hosting
+
+import (
+	"context"
+	"slices"
+)
+
+// secretEnv are variables whose values the env endpoint never returns.
+var secretEnv = map[string]bool{
+	"AWS_SECRET_ACCESS_KEY": true,
+}
+
+// SiteEnv lists the environment variables the panel passes to the PHP
+// pool of a site, e.g. the bucket and memcached socket to connect to.
+// Secret values are left out; their names are listed in Hidden.
+type SiteEnv struct {
+	SiteID int64             `json:"site_id"`
+	Env    map[string]string `json:"env"`
+	Hidden []string          `json:"hidden"`
+}
+
+// GetSiteEnv returns the environment of a site PHP pool.
+func (s *Service) GetSiteEnv(ctx context.Context, siteID int64) (SiteEnv, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return SiteEnv{}, err
+	}
+	cfg, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return SiteEnv{}, err
+	}
+	out := SiteEnv{SiteID: site.ID, Env: map[string]string{}, Hidden: []string{}}
+	for name, value := range cfg.Env {
+		if secretEnv[name] {
+			out.Hidden = append(out.Hidden, name)
+			continue
+		}
+		out.Env[name] = value
+	}
+	slices.Sort(out.Hidden)
+	return out, nil
+}
@@ -0,0 +1,428 @@
+package hosting
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/metrics"
+)
+
+const (
+	// errorBudgetWindow is the rolling window alerts are evaluated over.
+	errorBudgetWindow = 15 * time.Minute
+	// errorRateMaxWindow is the widest window reported; older per-minute
+	// counts are left to the metrics retention policy.
+	errorRateMaxWindow = 24 * time.Hour
+	// errorAlertMinRequests keeps a handful of failed requests on a quiet
+	// site from raising an alert.
+	errorAlertMinRequests = 50
+	// errorLogTail bounds how much of a site error log is read.
+	errorLogTail         = 256 << 10
+	defaultErrorLogLimit = 50
+	maxErrorLogLimit     = 500
+	// errorAlertLogLines is how many error log lines an alert quotes.
+	errorAlertLogLines = 5
+)
+
+// errorRateWindows are the rolling windows reported by GetSiteErrorBudget.
+var errorRateWindows = []time.Duration{5 * time.Minute, errorBudgetWindow, time.Hour, errorRateMaxWindow}
+
+// errorLogHeader matches nginx error log lines:
+// "2026/10/16 10:00:00 [error] 1234#1234: *5 message".
+var errorLogHeader = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}) \[(\w+)\] \d+#\d+: (?:\*\d+ )?(.*)$`)
+
+// SiteNotifier delivers an alert to the people responsible for a site.
+type SiteNotifier func(ctx context.Context, siteID int64, subject, body string) error
+
+// ErrorRate is the 5xx share of the requests a site answered over a
+// rolling window.
+type ErrorRate struct {
+	WindowMinutes int     `json:"window_minutes"`
+	Requests      int64   `json:"requests"`
+	Errors        int64   `json:"errors"`
+	RatePercent   float64 `json:"rate_percent"`
+}
+
+// SiteErrorBudget is the 5xx alert threshold of a site and its current
+// error rates. A threshold of zero turns the alerts off; Custom is set
+// when the site overrides the panel default.
+type SiteErrorBudget struct {
+	SiteID           int64       `json:"site_id"`
+	ThresholdPercent float64     `json:"threshold_percent"`
+	Custom           bool        `json:"custom"`
+	WindowMinutes    int         `json:"window_minutes"`
+	Rates            []ErrorRate `json:"rates"`
+	Alerting         bool        `json:"alerting"`
+	AlertedAt        *time.Time  `json:"alerted_at,omitempty"`
+	UpdatedAt        time.Time   `json:"updated_at"`
+}
+
+// SiteErrorBudgetRequest sets the alert threshold of a site. A null
+// threshold restores the panel default.
+type SiteErrorBudgetRequest struct {
+	ThresholdPercent *float64 `json:"threshold_percent"`
+	Actor            string   `json:"-"`
+}
+
+// ErrorLogEntry is one line of a site's nginx error log.
+type ErrorLogEntry struct {
+	Time    *time.Time `json:"time,omitempty"`
+	Level   string     `json:"level"`
+	Message string     `json:"message"`
+}
+
+// SetSiteNotifier sets the channel error rate alerts reach site owners
+// through; without one they go to the admin notifier.
+func (s *Service) SetSiteNotifier(n SiteNotifier) {
+	s.siteNotify = n
+}
+
+// GetSiteErrorBudget returns the alert threshold and error rates of a site.
+func (s *Service) GetSiteErrorBudget(ctx context.Context, siteID int64) (SiteErrorBudget, error) {
+	if _, err := s.GetSite(ctx, siteID); err != nil {
+		return SiteErrorBudget{}, err
+	}
+	return s.siteErrorBudget(ctx, siteID, time.Now())
+}
+
+// SetSiteErrorBudget overrides the alert threshold of a site.
+func (s *Service) SetSiteErrorBudget(ctx context.Context, siteID int64, req SiteErrorBudgetRequest) (SiteErrorBudget, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return SiteErrorBudget{}, err
+	}
+	threshold := "NULL"
+	detail := "default"
+	if req.ThresholdPercent != nil {
+		t := *req.ThresholdPercent
+		if math.IsNaN(t) || t < 0 || t > 100 {
+			return SiteErrorBudget{}, fmt.Errorf("invalid threshold_percent: must be between 0 and 100")
+		}
+		threshold = fmt.Sprintf("%g", t)
+		detail = threshold
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO site_error_budgets(site_id, threshold_percent, updated_at) VALUES(%d, %s, %d)
+ON CONFLICT(site_id) DO UPDATE SET threshold_percent = excluded.threshold_percent,
+  updated_at = MAX(excluded.updated_at, site_error_budgets.updated_at + 1);`,
+		siteID, threshold, time.Now().Unix())); err != nil {
+		return SiteErrorBudget{}, fmt.Errorf("save error budget: %w", err)
+	}
+	_ = s.writeAudit(ctx, req.Actor, "hosting.error_budget.update", fmt.Sprintf("domain=%s threshold=%s", site.Domain, detail))
+	return s.siteErrorBudget(ctx, siteID, time.Now())
+}
+
+// CollectErrorRates counts the requests each site answered since the last
+// pass into per-minute rows, publishes the rolling 5xx rate as a gauge
+// and alerts the site owners when it crosses the threshold. One alert is
+// sent per breach; the next one waits until the rate is back under the
+// threshold. A site seen for the first time is counted from the current
+// end of its access log.
+func (s *Service) CollectErrorRates(ctx context.Context) error {
+	sites, err := s.ListSites(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	minute := now.Truncate(time.Minute).Unix()
+	for _, site := range sites {
+		stats, err := s.scanNewAccessLines(site)
+		if err != nil {
+			s.log.Warn("scan access log", "domain", site.Domain, "error", err.Error())
+		}
+		if stats.requests > 0 {
+			labels := map[string]string{"site": site.Domain}
+			metrics.Default.AddCounter("site_requests_total", labels, uint64(stats.requests))
+			metrics.Default.AddCounter("site_5xx_responses_total", labels, uint64(stats.errors))
+			if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO site_error_rates(site_id, minute, requests, errors) VALUES(%d, %d, %d, %d)
+ON CONFLICT(site_id, minute) DO UPDATE SET requests = requests + excluded.requests,
+  errors = errors + excluded.errors;`,
+				site.ID, minute, stats.requests, stats.errors)); err != nil {
+				return fmt.Errorf("save error rate: %w", err)
+			}
+		}
+		budget, err := s.siteErrorBudget(ctx, site.ID, now)
+		if err != nil {
+			return err
+		}
+		window := budget.window()
+		metrics.Default.SetGauge("site_error_rate_percent", map[string]string{"site": site.Domain}, window.RatePercent)
+		s.checkErrorBudget(ctx, site, budget, window)
+	}
+	return nil
+}
+
+// SiteErrorLog returns the latest lines of a site's nginx error log,
+// newest first. Only the tail of the log is read.
+func (s *Service) SiteErrorLog(ctx context.Context, siteID int64, limit int) ([]ErrorLogEntry, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultErrorLogLimit
+	}
+	limit = min(limit, maxErrorLogLimit)
+	entries, err := readErrorLog(filepath.Join(s.accessLogDir, site.Domain+".error.log"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(entries)
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// window is the rate alerts are evaluated on.
+func (b SiteErrorBudget) window() ErrorRate {
+	for _, r := range b.Rates {
+		if r.WindowMinutes == b.WindowMinutes {
+			return r
+		}
+	}
+	return ErrorRate{WindowMinutes: b.WindowMinutes}
+}
+
+func (s *Service) siteErrorBudget(ctx context.Context, siteID int64, now time.Time) (SiteErrorBudget, error) {
+	budget := SiteErrorBudget{
+		SiteID:           siteID,
+		ThresholdPercent: s.cfg.ErrorAlertThresholdPercent,
+		WindowMinutes:    int(errorBudgetWindow / time.Minute),
+		Rates:            make([]ErrorRate, 0, len(errorRateWindows)),
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT threshold_percent, alerting, alerted_at, updated_at FROM site_error_budgets WHERE site_id = %d LIMIT 1;", siteID))
+	if err != nil {
+		return SiteErrorBudget{}, fmt.Errorf("get error budget: %w", err)
+	}
+	if len(rows) > 0 {
+		row := rows[0]
+		if t, ok := row["threshold_percent"].(float64); ok {
+			budget.ThresholdPercent = t
+			budget.Custom = true
+		}
+		alerting, _ := toInt64(row["alerting"])
+		budget.Alerting = alerting != 0
+		if at, _ := toInt64(row["alerted_at"]); at > 0 && budget.Alerting {
+			t := time.Unix(at, 0).UTC()
+			budget.AlertedAt = &t
+		}
+		updated, _ := toInt64(row["updated_at"])
+		budget.UpdatedAt = time.Unix(updated, 0).UTC()
+	}
+
+	// A window of N minutes covers the current minute and the N-1 before.
+	current := now.Truncate(time.Minute)
+	cols := make([]string, 0, 2*len(errorRateWindows))
+	for i, w := range errorRateWindows {
+		since := current.Add(-w + time.Minute).Unix()
+		cols = append(cols,
+			fmt.Sprintf("COALESCE(SUM(CASE WHEN minute >= %d THEN requests END), 0) AS r%d", since, i),
+			fmt.Sprintf("COALESCE(SUM(CASE WHEN minute >= %d THEN errors END), 0) AS e%d", since, i))
+	}
+	rows, err = s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT %s FROM site_error_rates WHERE site_id = %d;", strings.Join(cols, ", "), siteID))
+	if err != nil {
+		return SiteErrorBudget{}, fmt.Errorf("get error rates: %w", err)
+	}
+	for i, w := range errorRateWindows {
+		rate := ErrorRate{WindowMinutes: int(w / time.Minute)}
+		if len(rows) > 0 {
+			rate.Requests, _ = toInt64(rows[0][fmt.Sprintf("r%d", i)])
+			rate.Errors, _ = toInt64(rows[0][fmt.Sprintf("e%d", i)])
+		}
+		rate.RatePercent = accessStats{requests: int(rate.Requests), errors: int(rate.Errors)}.rate()
+		budget.Rates = append(budget.Rates, rate)
+	}
+	return budget, nil
+}
+
+// checkErrorBudget alerts on a fresh breach and re-arms the alert once
+// the rate recovers.
+func (s *Service) checkErrorBudget(ctx context.Context, site Site, budget SiteErrorBudget, window ErrorRate) {
+	breached := budget.ThresholdPercent > 0 && window.Requests >= errorAlertMinRequests && window.RatePercent > budget.ThresholdPercent
+	recovered := budget.ThresholdPercent == 0 || window.RatePercent <= budget.ThresholdPercent
+	switch {
+	case breached && !budget.Alerting:
+	case recovered && budget.Alerting:
+		s.log.Info("site error rate recovered", "domain", site.Domain, "rate", window.RatePercent)
+	default:
+		return
+	}
+	alerting := 0
+	if breached {
+		alerting = 1
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO site_error_budgets(site_id, alerting, alerted_at) VALUES(%d, %d, %d)
+ON CONFLICT(site_id) DO UPDATE SET alerting = excluded.alerting, alerted_at = excluded.alerted_at;`,
+		site.ID, alerting, time.Now().Unix())); err != nil {
+		s.log.Error("record error budget state", "domain", site.Domain, "error", err.Error())
+		return
+	}
+	if !breached {
+		return
+	}
+	s.log.Warn("site error rate over threshold", "domain", site.Domain, "rate", window.RatePercent, "threshold", budget.ThresholdPercent)
+	subject := fmt.Sprintf("%s is failing %.1f%% of requests", site.Domain, window.RatePercent)
+	var body strings.Builder
+	fmt.Fprintf(&body, "%d of the %d requests %s answered in the last %d minutes failed with a 5xx status (%.1f%%), above the alert threshold of %g%%.\n",
+		window.Errors, window.Requests, site.Domain, window.WindowMinutes, window.RatePercent, budget.ThresholdPercent)
+	fmt.Fprintf(&body, "\nRecent error log lines: %s\n", s.panelURL()+fmt.Sprintf("/api/sites/%d/error-budget/log", site.ID))
+	if entries, err := s.SiteErrorLog(ctx, site.ID, errorAlertLogLines); err == nil && len(entries) > 0 {
+		body.WriteString("\n")
+		for _, e := range entries {
+			fmt.Fprintf(&body, "[%s] %s\n", e.Level, e.Message)
+		}
+	}
+	body.WriteString("\nNo further alert is sent until the rate falls back under the threshold.\n")
+	var err error
+	switch {
+	case s.siteNotify != nil:
+		err = s.siteNotify(ctx, site.ID, subject, body.String())
+	case s.notify != nil:
+		err = s.notify(ctx, subject, body.String())
+	default:
+		return
+	}
+	if err != nil {
+		s.log.Error("send error rate alert", "domain", site.Domain, "error", err.Error())
+	}
+}
+
+// scanNewAccessLines counts the complete access log lines of site written
+// since the previous call and advances its offset past them.
+func (s *Service) scanNewAccessLines(site Site) (accessStats, error) {
+	path := filepath.Join(s.accessLogDir, site.Domain+".access.log")
+	s.errorScanMu.Lock()
+	defer s.errorScanMu.Unlock()
+	if s.errorScanOffsets == nil {
+		s.errorScanOffsets = map[int64]int64{}
+	}
+	offset, seen := s.errorScanOffsets[site.ID]
+	if !seen {
+		s.errorScanOffsets[site.ID] = fileSize(path)
+		return accessStats{}, nil
+	}
+	stats, next, err := scanAccessLogFrom(path, offset)
+	if errors.Is(err, os.ErrNotExist) {
+		s.errorScanOffsets[site.ID] = 0
+		return accessStats{}, nil
+	}
+	if err != nil {
+		return stats, err
+	}
+	s.errorScanOffsets[site.ID] = next
+	return stats, nil
+}
+
+// scanAccessLogFrom counts the complete lines after offset and returns
+// the offset past the last of them, so a line still being written is
+// counted on the next pass. A log rotated since offset is read from its
+// beginning.
+func scanAccessLogFrom(path string, offset int64) (accessStats, int64, error) {
+	var stats accessStats
+	f, err := os.Open(path) //nolint:gosec // G304: access log path is built from the site domain.
+	if err != nil {
+		return stats, offset, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return stats, offset, err
+	}
+	if info.Size() < offset {
+		offset = 0
+	}
+	reader := bufio.NewReaderSize(io.NewSectionReader(f, offset, info.Size()-offset), 64<<10)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// io.EOF leaves a partial line for the next pass.
+			if errors.Is(err, io.EOF) {
+				return stats, offset, nil
+			}
+			return stats, offset, err
+		}
+		offset += int64(len(line))
+		status, ok := accessLogStatus(line)
+		if !ok {
+			continue
+		}
+		stats.requests++
+		if status >= http.StatusInternalServerError {
+			stats.errors++
+		}
+	}
+}
+
+func readErrorLog(path string) ([]ErrorLogEntry, error) {
+	f, err := os.Open(path) //nolint:gosec // G304: error log path is built from the site domain.
+	if os.IsNotExist(err) {
+		return []ErrorLogEntry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open error log: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat error log: %w", err)
+	}
+	offset := max(0, info.Size()-errorLogTail)
+	raw, err := io.ReadAll(io.NewSectionReader(f, offset, info.Size()-offset))
+	if err != nil {
+		return nil, fmt.Errorf("read error log: %w", err)
+	}
+	if offset > 0 {
+		// Drop the cut first line.
+		if i := bytes.IndexByte(raw, '\n'); i >= 0 {
+			raw = raw[i+1:]
+		}
+	}
+	return parseErrorLog(raw), nil
+}
+
+// parseErrorLog splits an nginx error log into entries; lines not in the
+// error log format are kept whole as messages.
+func parseErrorLog(raw []byte) []ErrorLogEntry {
+	entries := []ErrorLogEntry{}
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		m := errorLogHeader.FindStringSubmatch(line)
+		if m == nil {
+			entries = append(entries, ErrorLogEntry{Message: line})
+			continue
+		}
+		entry := ErrorLogEntry{Level: m[2], Message: m[3]}
+		if at, err := time.ParseInLocation("2006/01/02 15:04:05", m[1], time.Local); err == nil {
+			at = at.UTC()
+			entry.Time = &at
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
@@ -0,0 +1,156 @@
+package hosting
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+)
+
+type sentAlert struct {
+	siteID  int64
+	subject string
+	body    string
+}
+
+func newErrorBudgetService(t *testing.T) (*Service, *[]sentAlert) {
+	t.Helper()
+	svc := newTestService(t, withConfig(config.Config{PanelDomain: "panel.example.com", ErrorAlertThresholdPercent: 5}))
+	seedSite(t, svc, "example.com")
+	svc.accessLogDir = t.TempDir()
+	svc.letsEncryptDir = t.TempDir()
+	alerts := &[]sentAlert{}
+	svc.SetSiteNotifier(func(_ context.Context, siteID int64, subject, body string) error {
+		*alerts = append(*alerts, sentAlert{siteID: siteID, subject: subject, body: body})
+		return nil
+	})
+	return svc, alerts
+}
+
+func appendAccessLog(t *testing.T, svc *Service, status, count int, tail string) {
+	t.Helper()
+	f, err := os.OpenFile(filepath.Join(svc.accessLogDir, "example.com.access.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatalf("open access log: %v", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	for range count {
+		fmt.Fprintf(f, "203.0.113.7 - - [16/Oct/2026:10:00:00 +0000] \"GET /shop HTTP/1.1\" %d 512 \"-\" \"curl/8.5\"\n", status)
+	}
+	_, _ = f.WriteString(tail)
+}
+
+func TestService_ErrorBudgetAlertsOncePerBreach(t *testing.T) {
+	ctx := context.Background()
+	svc, alerts := newErrorBudgetService(t)
+	errorLog := "2026/10/16 10:00:01 [error] 812#812: *40 upstream timed out (110: Connection timed out), client: 203.0.113.7, server: example.com\n"
+	if err := os.WriteFile(filepath.Join(svc.accessLogDir, "example.com.error.log"), []byte(errorLog), 0o600); err != nil {
+		t.Fatalf("write error log: %v", err)
+	}
+
+	if err := svc.CollectErrorRates(ctx); err != nil {
+		t.Fatalf("first pass: %v", err)
+	}
+	appendAccessLog(t, svc, 200, 90, "")
+	appendAccessLog(t, svc, 502, 10, `203.0.113.7 - - [16/Oct/2026:10:00:00 +0000] "GET / HTTP/1.1" 500`)
+	if err := svc.CollectErrorRates(ctx); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	budget, err := svc.GetSiteErrorBudget(ctx, 1)
+	if err != nil {
+		t.Fatalf("get budget: %v", err)
+	}
+	if w := budget.window(); w.Requests != 100 || w.Errors != 10 || w.RatePercent != 10 {
+		t.Fatalf("expected the partial line left for the next pass, got %+v", w)
+	}
+	if !budget.Alerting || budget.AlertedAt == nil || len(*alerts) != 1 {
+		t.Fatalf("expected one alert, got %+v and %+v", budget, *alerts)
+	}
+	alert := (*alerts)[0]
+	if alert.siteID != 1 || !strings.Contains(alert.subject, "example.com is failing 10.0%") ||
+		!strings.Contains(alert.body, "http://panel.example.com/api/sites/1/error-budget/log") ||
+		!strings.Contains(alert.body, "[error] upstream timed out") {
+		t.Fatalf("unexpected alert %+v", alert)
+	}
+
+	if err := svc.CollectErrorRates(ctx); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if len(*alerts) != 1 {
+		t.Fatalf("expected no repeat while the breach lasts, got %+v", *alerts)
+	}
+
+	appendAccessLog(t, svc, 200, 0, " 0 \"-\" \"curl/8.5\"\n")
+	appendAccessLog(t, svc, 200, 199, "")
+	if err := svc.CollectErrorRates(ctx); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if budget, _ = svc.GetSiteErrorBudget(ctx, 1); budget.Alerting || budget.window().Requests != 300 || budget.window().Errors != 11 {
+		t.Fatalf("expected the alert re-armed, got %+v", budget)
+	}
+	appendAccessLog(t, svc, 503, 100, "")
+	if err := svc.CollectErrorRates(ctx); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if len(*alerts) != 2 {
+		t.Fatalf("expected a second breach alerted, got %d alerts", len(*alerts))
+	}
+}
+
+func TestService_SiteErrorBudgetOverride(t *testing.T) {
+	ctx := context.Background()
+	svc, alerts := newErrorBudgetService(t)
+
+	off := 0.0
+	budget, err := svc.SetSiteErrorBudget(ctx, 1, SiteErrorBudgetRequest{ThresholdPercent: &off, Actor: "owner@example.com"})
+	if err != nil || !budget.Custom || budget.ThresholdPercent != 0 || len(budget.Rates) != 4 {
+		t.Fatalf("expected alerts turned off, got %+v (%v)", budget, err)
+	}
+	if err := svc.CollectErrorRates(ctx); err != nil {
+		t.Fatalf("first pass: %v", err)
+	}
+	appendAccessLog(t, svc, 500, 100, "")
+	if err := svc.CollectErrorRates(ctx); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if len(*alerts) != 0 {
+		t.Fatalf("expected no alert with a zero threshold, got %+v", *alerts)
+	}
+
+	bad := 101.0
+	if _, err := svc.SetSiteErrorBudget(ctx, 1, SiteErrorBudgetRequest{ThresholdPercent: &bad}); err == nil || !isBadRequest(err) {
+		t.Fatalf("expected an invalid threshold rejected, got %v", err)
+	}
+	if budget, err = svc.SetSiteErrorBudget(ctx, 1, SiteErrorBudgetRequest{}); err != nil || budget.Custom || budget.ThresholdPercent != 5 {
+		t.Fatalf("expected the panel default restored, got %+v (%v)", budget, err)
+	}
+	rows, err := svc.store.QueryAuditJSON(ctx, "SELECT details FROM audit_events WHERE action = 'hosting.error_budget.update' ORDER BY id;")
+	if err != nil || len(rows) != 2 || rows[0]["details"] != "domain=example.com threshold=0" {
+		t.Fatalf("expected audited updates, got %+v (%v)", rows, err)
+	}
+}
+
+func TestParseErrorLog(t *testing.T) {
+	entries := parseErrorLog([]byte("2026/10/16 10:00:01 [crit] 812#812: *40 connect() to unix:/run/php/example.sock failed\n" +
+		"\n" +
+		"PHP message: PHP Fatal error: Allowed memory size exhausted\n" +
+		"2026/10/16 10:00:02 [warn] 812#812: conflicting server name \"example.com\"\n"))
+	if len(entries) != 3 {
+		t.Fatalf("expected three entries, got %+v", entries)
+	}
+	if e := entries[0]; e.Level != "crit" || e.Time == nil || e.Message != "connect() to unix:/run/php/example.sock failed" {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if e := entries[1]; e.Level != "" || e.Time != nil || !strings.HasPrefix(e.Message, "PHP message:") {
+		t.Fatalf("expected a foreign line kept whole, got %+v", e)
+	}
+	if e := entries[2]; e.Level != "warn" || e.Message != `conflicting server name "example.com"` {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+}
@@ -0,0 +1,229 @@
+package hosting
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/fastcgi"
+	"github.com/robsonek/aiPanel/internal/platform/metrics"
+)
+
+const (
+	// siteStatusPath is the pm.status_path of site pools
+	// (phpfpm_pool.conf.tmpl).
+	siteStatusPath = "/aipanel-status"
+	// slowLogTail bounds how much of a slow log is read for excerpts.
+	slowLogTail         = 256 << 10
+	defaultSlowLogLimit = 20
+	maxSlowLogLimit     = 200
+)
+
+// slowLogHeader matches the first line of a slow log entry:
+// "[16-Oct-2026 10:00:00]  [pool example_com_83] pid 1234".
+var slowLogHeader = regexp.MustCompile(`^\[(\d{2}-\w{3}-\d{4} \d{2}:\d{2}:\d{2})\]\s+\[pool ([^\]]+)\] pid (\d+)$`)
+
+// PHPFPMStatus is the process manager state of a site pool, read from
+// its pm.status_path. Counters run since the pool master started.
+type PHPFPMStatus struct {
+	SiteID             int64     `json:"site_id"`
+	Pool               string    `json:"pool"`
+	ProcessManager     string    `json:"process_manager"`
+	StartedAt          time.Time `json:"started_at"`
+	AcceptedConn       int64     `json:"accepted_conn"`
+	ListenQueue        int64     `json:"listen_queue"`
+	MaxListenQueue     int64     `json:"max_listen_queue"`
+	ListenQueueLen     int64     `json:"listen_queue_len"`
+	IdleProcesses      int64     `json:"idle_processes"`
+	ActiveProcesses    int64     `json:"active_processes"`
+	TotalProcesses     int64     `json:"total_processes"`
+	MaxActiveProcesses int64     `json:"max_active_processes"`
+	MaxChildrenReached int64     `json:"max_children_reached"`
+	SlowRequests       int64     `json:"slow_requests"`
+	CollectedAt        time.Time `json:"collected_at"`
+}
+
+// fpmStatusJSON is the "?json" answer of pm.status_path.
+type fpmStatusJSON struct {
+	Pool               string `json:"pool"`
+	ProcessManager     string `json:"process manager"`
+	StartTime          int64  `json:"start time"`
+	AcceptedConn       int64  `json:"accepted conn"`
+	ListenQueue        int64  `json:"listen queue"`
+	MaxListenQueue     int64  `json:"max listen queue"`
+	ListenQueueLen     int64  `json:"listen queue len"`
+	IdleProcesses      int64  `json:"idle processes"`
+	ActiveProcesses    int64  `json:"active processes"`
+	TotalProcesses     int64  `json:"total processes"`
+	MaxActiveProcesses int64  `json:"max active processes"`
+	MaxChildrenReached int64  `json:"max children reached"`
+	SlowRequests       int64  `json:"slow requests"`
+}
+
+// SlowLogEntry is one request php-fpm logged for running longer than
+// request_slowlog_timeout, with the PHP backtrace at that moment.
+type SlowLogEntry struct {
+	Time   time.Time `json:"time"`
+	Pool   string    `json:"pool"`
+	PID    int       `json:"pid"`
+	Script string    `json:"script"`
+	Trace  []string  `json:"trace"`
+}
+
+// GetPHPFPMStatus reads the live pool status of a site.
+func (s *Service) GetPHPFPMStatus(ctx context.Context, siteID int64) (PHPFPMStatus, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return PHPFPMStatus{}, err
+	}
+	return s.phpFPMStatus(ctx, site)
+}
+
+// CollectPHPFPMStatus reads the pool status of every site into the panel
+// metrics. Pools rendered from a template without pm.status_path are
+// skipped.
+func (s *Service) CollectPHPFPMStatus(ctx context.Context) error {
+	sites, err := s.ListSites(ctx)
+	if err != nil {
+		return err
+	}
+	for _, site := range sites {
+		status, err := s.phpFPMStatus(ctx, site)
+		if err != nil {
+			s.log.Debug("php-fpm status", "domain", site.Domain, "error", err.Error())
+			continue
+		}
+		labels := map[string]string{"site": site.Domain}
+		metrics.Default.SetGauge("php_fpm_listen_queue", labels, float64(status.ListenQueue))
+		metrics.Default.SetGauge("php_fpm_active_processes", labels, float64(status.ActiveProcesses))
+		metrics.Default.SetGauge("php_fpm_idle_processes", labels, float64(status.IdleProcesses))
+		metrics.Default.SetGauge("php_fpm_max_children_reached", labels, float64(status.MaxChildrenReached))
+		metrics.Default.SetGauge("php_fpm_slow_requests", labels, float64(status.SlowRequests))
+	}
+	return nil
+}
+
+func (s *Service) phpFPMStatus(ctx context.Context, site Site) (PHPFPMStatus, error) {
+	socket := s.siteSocket(site)
+	ctx, cancel := context.WithTimeout(ctx, siteProbeTimeout)
+	defer cancel()
+	resp, err := fastcgi.Do(ctx, "unix", socket, map[string]string{
+		"REQUEST_METHOD":  http.MethodGet,
+		"REQUEST_URI":     siteStatusPath + "?json",
+		"QUERY_STRING":    "json",
+		"SCRIPT_NAME":     siteStatusPath,
+		"SCRIPT_FILENAME": siteStatusPath,
+		"SERVER_PROTOCOL": "HTTP/1.1",
+	})
+	if err != nil {
+		return PHPFPMStatus{}, fmt.Errorf("read php-fpm status: %w", err)
+	}
+	if resp.Status != http.StatusOK {
+		return PHPFPMStatus{}, fmt.Errorf("read php-fpm status: %s answered status %d (pm.status_path not configured?)", socket, resp.Status)
+	}
+	var raw fpmStatusJSON
+	if err := json.Unmarshal(resp.Body, &raw); err != nil {
+		return PHPFPMStatus{}, fmt.Errorf("read php-fpm status: %w", err)
+	}
+	return PHPFPMStatus{
+		SiteID:             site.ID,
+		Pool:               raw.Pool,
+		ProcessManager:     raw.ProcessManager,
+		StartedAt:          time.Unix(raw.StartTime, 0).UTC(),
+		AcceptedConn:       raw.AcceptedConn,
+		ListenQueue:        raw.ListenQueue,
+		MaxListenQueue:     raw.MaxListenQueue,
+		ListenQueueLen:     raw.ListenQueueLen,
+		IdleProcesses:      raw.IdleProcesses,
+		ActiveProcesses:    raw.ActiveProcesses,
+		TotalProcesses:     raw.TotalProcesses,
+		MaxActiveProcesses: raw.MaxActiveProcesses,
+		MaxChildrenReached: raw.MaxChildrenReached,
+		SlowRequests:       raw.SlowRequests,
+		CollectedAt:        time.Now().UTC(),
+	}, nil
+}
+
+// PHPSlowLog returns the latest slow log entries of a site, newest first.
+// Only the tail of the log is read.
+func (s *Service) PHPSlowLog(ctx context.Context, siteID int64, limit int) ([]SlowLogEntry, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultSlowLogLimit
+	}
+	limit = min(limit, maxSlowLogLimit)
+	entries, err := readSlowLog(filepath.Join(s.slowLogDir, site.Domain+".slow.log"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(entries)
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+func readSlowLog(path string) ([]SlowLogEntry, error) {
+	f, err := os.Open(path) //nolint:gosec // G304: slow log path is built from the site domain.
+	if os.IsNotExist(err) {
+		return []SlowLogEntry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open slow log: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat slow log: %w", err)
+	}
+	offset := max(0, info.Size()-slowLogTail)
+	raw, err := io.ReadAll(io.NewSectionReader(f, offset, info.Size()-offset))
+	if err != nil {
+		return nil, fmt.Errorf("read slow log: %w", err)
+	}
+	return parseSlowLog(raw), nil
+}
+
+// parseSlowLog splits a slow log into entries. Lines before the first
+// header (the cut end of an older entry) are dropped.
+func parseSlowLog(raw []byte) []SlowLogEntry {
+	entries := []SlowLogEntry{}
+	var current *SlowLogEntry
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if m := slowLogHeader.FindStringSubmatch(line); m != nil {
+			at, _ := time.ParseInLocation("02-Jan-2006 15:04:05", m[1], time.Local)
+			pid, _ := strconv.Atoi(m[3])
+			entries = append(entries, SlowLogEntry{Time: at.UTC(), Pool: m[2], PID: pid, Trace: []string{}})
+			current = &entries[len(entries)-1]
+			continue
+		}
+		if current == nil || line == "" {
+			continue
+		}
+		if script, ok := strings.CutPrefix(line, "script_filename = "); ok {
+			current.Script = script
+			continue
+		}
+		current.Trace = append(current.Trace, line)
+	}
+	return entries
+}
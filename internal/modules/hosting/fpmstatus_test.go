@@ -0,0 +1,117 @@
+package hosting
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/metrics"
+)
+
+const fpmStatusBody = `{"pool":"example-com-php83","process manager":"ondemand","start time":1792144800,"start since":60,` +
+	`"accepted conn":120,"listen queue":3,"max listen queue":7,"listen queue len":511,"idle processes":0,` +
+	`"active processes":20,"total processes":20,"max active processes":20,"max children reached":4,"slow requests":2}`
+
+func newFPMStatusService(t *testing.T) *Service {
+	t.Helper()
+	svc := newTestService(t)
+	seedSite(t, svc, "example.com")
+	svc.slowLogDir = t.TempDir()
+
+	sock := filepath.Join(t.TempDir(), "fpm.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	go func() {
+		_ = fcgi.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != siteStatusPath || r.URL.RawQuery != "json" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(fpmStatusBody))
+		}))
+	}()
+	svc.fpmSocket = func(Site) string { return sock }
+	return svc
+}
+
+func TestService_PHPFPMStatusIntoMetrics(t *testing.T) {
+	ctx := context.Background()
+	svc := newFPMStatusService(t)
+
+	status, err := svc.GetPHPFPMStatus(ctx, 1)
+	if err != nil {
+		t.Fatalf("get status: %v", err)
+	}
+	if status.Pool != "example-com-php83" || status.ListenQueue != 3 || status.ActiveProcesses != 20 ||
+		status.MaxChildrenReached != 4 || status.SlowRequests != 2 || status.StartedAt.Unix() != 1792144800 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	if err := svc.CollectPHPFPMStatus(ctx); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	gauges := map[string]float64{}
+	for _, g := range metrics.Default.Snapshot().Gauges {
+		if g.Labels["site"] == "example.com" {
+			gauges[g.Name] = g.Value
+		}
+	}
+	if gauges["php_fpm_listen_queue"] != 3 || gauges["php_fpm_active_processes"] != 20 || gauges["php_fpm_max_children_reached"] != 4 {
+		t.Fatalf("expected the pool published as gauges, got %v", gauges)
+	}
+
+	svc.fpmSocket = func(Site) string { return filepath.Join(t.TempDir(), "missing.sock") }
+	if _, err := svc.GetPHPFPMStatus(ctx, 1); err == nil || !strings.Contains(err.Error(), "read php-fpm status") {
+		t.Fatalf("expected an unreachable pool reported, got %v", err)
+	}
+	if err := svc.CollectPHPFPMStatus(ctx); err != nil {
+		t.Fatalf("expected unreachable pools skipped, got %v", err)
+	}
+}
+
+func TestService_PHPSlowLogNewestFirst(t *testing.T) {
+	ctx := context.Background()
+	svc := newFPMStatusService(t)
+
+	if entries, err := svc.PHPSlowLog(ctx, 1, 0); err != nil || len(entries) != 0 {
+		t.Fatalf("expected no entries without a log, got %+v (%v)", entries, err)
+	}
+	log := `0x00007f main() /var/www/example.com/public_html/cut.php:1
+
+[16-Oct-2026 10:00:00]  [pool example-com-php83] pid 1201
+script_filename = /var/www/example.com/public_html/index.php
+[0x00007f1a] curl_exec() /var/www/example.com/public_html/api.php:42
+[0x00007f1b] fetch() /var/www/example.com/public_html/index.php:7
+
+[16-Oct-2026 10:05:00]  [pool example-com-php83] pid 1202
+script_filename = /var/www/example.com/public_html/report.php
+[0x00007f2a] sleep() /var/www/example.com/public_html/report.php:3
+`
+	if err := os.WriteFile(filepath.Join(svc.slowLogDir, "example.com.slow.log"), []byte(log), 0o600); err != nil {
+		t.Fatalf("write slow log: %v", err)
+	}
+	entries, err := svc.PHPSlowLog(ctx, 1, 0)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("expected two entries, got %+v (%v)", entries, err)
+	}
+	if e := entries[0]; e.PID != 1202 || e.Script != "/var/www/example.com/public_html/report.php" || len(e.Trace) != 1 {
+		t.Fatalf("expected the newest entry first, got %+v", e)
+	}
+	if e := entries[1]; e.Pool != "example-com-php83" || len(e.Trace) != 2 || !strings.HasPrefix(e.Trace[0], "[0x00007f1a] curl_exec()") {
+		t.Fatalf("unexpected older entry %+v", e)
+	}
+	if entries, _ := svc.PHPSlowLog(ctx, 1, 1); len(entries) != 1 || entries[0].PID != 1202 {
+		t.Fatalf("expected the limit applied, got %+v", entries)
+	}
+}
@@ -7,6 +7,10 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+
+	"github.com/robsonek/aiPanel/internal/platform/etag"
+	"github.com/robsonek/aiPanel/internal/platform/jsonstream"
+	"github.com/robsonek/aiPanel/internal/platform/websocket"
 )
 
 // Handler exposes HTTP handlers for site CRUD.
@@ -28,7 +32,7 @@ func (h *Handler) HandleSites(w http.ResponseWriter, r *http.Request, actor stri
 			http.Error(w, "failed to list sites", http.StatusInternalServerError)
 			return
 		}
-		writeJSON(w, http.StatusOK, map[string]any{"sites": sites})
+		jsonstream.List(w, r, "sites", sites)
 	case http.MethodPost:
 		var req CreateSiteRequest
 		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
@@ -55,6 +59,148 @@ func (h *Handler) HandleSites(w http.ResponseWriter, r *http.Request, actor stri
 	}
 }
 
+// HandlePHPVersions serves GET /api/system/php-versions.
+func (h *Handler) HandlePHPVersions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	versions, err := h.svc.PHPVersions(r.Context())
+	if err != nil {
+		http.Error(w, "failed to list php versions", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, versions)
+}
+
+// HandlePHPUpgrades serves blue/green PHP upgrades:
+//
+//	GET  /api/system/php-upgrades               newest upgrades
+//	POST /api/system/php-upgrades               start {"to", "from", "site_ids", "build", "max_error_rate"}
+//	GET  /api/system/php-upgrades/{id}?offset=  one upgrade and its log from offset
+func (h *Handler) HandlePHPUpgrades(w http.ResponseWriter, r *http.Request, actor string) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/system/php-upgrades"), "/")
+	if rest != "" {
+		id, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil || id <= 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		offset, _ := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+		progress, err := h.svc.PHPUpgradeProgress(r.Context(), id, offset)
+		if err != nil {
+			if errors.Is(err, ErrPHPUpgradeNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to get php upgrade", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, progress)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		items, err := h.svc.ListPHPUpgrades(r.Context(), limit)
+		if err != nil {
+			http.Error(w, "failed to list php upgrades", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"items": items})
+	case http.MethodPost:
+		var req PHPUpgradeRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Actor = actor
+		upgrade, err := h.svc.StartPHPUpgrade(r.Context(), req)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrPHPUpgradeInProgress):
+				http.Error(w, err.Error(), http.StatusConflict)
+			case strings.Contains(err.Error(), "invalid"), strings.Contains(err.Error(), "not installed"):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				http.Error(w, "failed to start php upgrade: "+err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		writeJSON(w, http.StatusAccepted, upgrade)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleTemplateRollouts serves canary rollouts of the vhost template:
+//
+//	GET  /api/templates/rollouts               newest rollouts
+//	POST /api/templates/rollouts               start {"content", "canary_site_ids", "canary_percent", "soak_seconds", "max_error_rate"}
+//	GET  /api/templates/rollouts/{id}?offset=  one rollout and its log from offset
+func (h *Handler) HandleTemplateRollouts(w http.ResponseWriter, r *http.Request, actor string) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/templates/rollouts"), "/")
+	if rest != "" {
+		id, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil || id <= 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		offset, _ := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+		progress, err := h.svc.TemplateRolloutProgress(r.Context(), id, offset)
+		if err != nil {
+			if errors.Is(err, ErrRolloutNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to get template rollout", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, progress)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		items, err := h.svc.ListTemplateRollouts(r.Context(), limit)
+		if err != nil {
+			http.Error(w, "failed to list template rollouts", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"items": items})
+	case http.MethodPost:
+		var req TemplateRolloutRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Actor = actor
+		rollout, err := h.svc.StartTemplateRollout(r.Context(), req)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrRolloutInProgress):
+				http.Error(w, err.Error(), http.StatusConflict)
+			case strings.Contains(err.Error(), "invalid"):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				http.Error(w, "failed to start template rollout: "+err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		writeJSON(w, http.StatusAccepted, rollout)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 // HandleSiteByID serves GET/DELETE /api/sites/{id}.
 func (h *Handler) HandleSiteByID(w http.ResponseWriter, r *http.Request, id int64, actor string) {
 	switch r.Method {
@@ -68,8 +214,12 @@ func (h *Handler) HandleSiteByID(w http.ResponseWriter, r *http.Request, id int6
 			http.Error(w, "failed to get site", http.StatusInternalServerError)
 			return
 		}
+		etag.Set(w, siteETag(site))
 		writeJSON(w, http.StatusOK, map[string]any{"site": site})
 	case http.MethodDelete:
+		if !h.checkSiteVersion(w, r, id) {
+			return
+		}
 		if err := h.svc.DeleteSite(r.Context(), id, actor); err != nil {
 			if errors.Is(err, ErrSiteNotFound) {
 				http.Error(w, "site not found", http.StatusNotFound)
@@ -84,11 +234,1301 @@ func (h *Handler) HandleSiteByID(w http.ResponseWriter, r *http.Request, id int6
 	}
 }
 
-// ParseSiteID extracts id from "/api/sites/{id}".
-func ParseSiteID(path string) (int64, error) {
-	idRaw := strings.TrimPrefix(path, "/api/sites/")
-	idRaw = strings.TrimSpace(strings.Trim(idRaw, "/"))
-	return strconv.ParseInt(idRaw, 10, 64)
+// HandleSiteDeliverability serves GET /api/sites/{id}/deliverability.
+func (h *Handler) HandleSiteDeliverability(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	report, err := h.svc.CheckDeliverability(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrSiteNotFound) {
+			http.Error(w, "site not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to check deliverability", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"deliverability": report})
+}
+
+// HandleSiteCloudflare serves GET/POST/PATCH/DELETE /api/sites/{id}/cloudflare.
+// POST creates the DNS records, PATCH toggles the proxy, DELETE removes them.
+func (h *Handler) HandleSiteCloudflare(w http.ResponseWriter, r *http.Request, id int64, actor string) {
+	var (
+		state CloudflareSite
+		err   error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		state, err = h.svc.CloudflareStatus(r.Context(), id)
+	case http.MethodPost, http.MethodPatch:
+		var req CloudflareRequest
+		if decErr := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); decErr != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Actor = actor
+		if r.Method == http.MethodPost {
+			state, err = h.svc.EnableCloudflare(r.Context(), id, req)
+		} else {
+			state, err = h.svc.SetCloudflareProxy(r.Context(), id, req)
+		}
+	case http.MethodDelete:
+		if err = h.svc.DisableCloudflare(r.Context(), id, actor); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		writeCloudflareError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"cloudflare": state})
+}
+
+// HandleSiteCloudflarePurge serves POST /api/sites/{id}/cloudflare/purge.
+func (h *Handler) HandleSiteCloudflarePurge(w http.ResponseWriter, r *http.Request, id int64, actor string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := h.svc.PurgeCloudflareCache(r.Context(), id, actor); err != nil {
+		writeCloudflareError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "purged"})
+}
+
+func writeCloudflareError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrSiteNotFound):
+		http.Error(w, "site not found", http.StatusNotFound)
+	case errors.Is(err, ErrCloudflareNotEnabled):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrCloudflareNotConfigured):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrCloudflareZoneNotFound), isBadRequest(err):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
+// HandleSiteRegistrar serves GET/PUT /api/sites/{id}/registrar: GET
+// returns the delegation last set, PUT sets nameservers and glue records
+// at the registrar.
+func (h *Handler) HandleSiteRegistrar(w http.ResponseWriter, r *http.Request, id int64, actor string) {
+	var (
+		state SiteRegistrar
+		err   error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		state, err = h.svc.GetSiteRegistrar(r.Context(), id)
+	case http.MethodPut:
+		var req RegistrarNameserversRequest
+		if decErr := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); decErr != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Actor = actor
+		state, err = h.svc.SetSiteNameservers(r.Context(), id, req)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		writeRegistrarError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"registrar": state})
+}
+
+// HandleDomainAvailability serves GET /api/domains/availability?domain=.
+func (h *Handler) HandleDomainAvailability(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	availability, err := h.svc.CheckDomainAvailability(r.Context(), r.URL.Query().Get("domain"))
+	if err != nil {
+		writeRegistrarError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, availability)
+}
+
+func writeRegistrarError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrSiteNotFound):
+		http.Error(w, "site not found", http.StatusNotFound)
+	case errors.Is(err, ErrRegistrarNotConfigured):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case isBadRequest(err):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
+// HandleSiteHealth serves GET/POST /api/sites/{id}/health: GET returns the
+// last result, POST checks the site again.
+func (h *Handler) HandleSiteHealth(w http.ResponseWriter, r *http.Request, id int64) {
+	var (
+		health SiteHealth
+		err    error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		health, err = h.svc.GetSiteHealth(r.Context(), id)
+	case http.MethodPost:
+		health, err = h.svc.CheckSiteHealth(r.Context(), id)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		if errors.Is(err, ErrSiteNotFound) {
+			http.Error(w, "site not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to check site health: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"health": health})
+}
+
+// HandleSiteActivity serves GET /api/sites/{id}/activity.
+func (h *Handler) HandleSiteActivity(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	before, _ := strconv.ParseInt(r.URL.Query().Get("before"), 10, 64)
+	events, err := h.svc.SiteActivity(r.Context(), id, before, limit)
+	if err != nil {
+		if errors.Is(err, ErrSiteNotFound) {
+			http.Error(w, "site not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to list site activity", http.StatusInternalServerError)
+		return
+	}
+	jsonstream.List(w, r, "events", events)
+}
+
+// HandleSiteStorage serves GET/PUT/DELETE /api/sites/{id}/storage.
+func (h *Handler) HandleSiteStorage(w http.ResponseWriter, r *http.Request, id int64, actor string) {
+	var (
+		storage SiteStorage
+		err     error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		storage, err = h.svc.GetSiteStorage(r.Context(), id)
+	case http.MethodPut:
+		var req SetSiteStorageRequest
+		if decErr := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); decErr != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !checkVersion(w, r, func() (string, error) { return h.storageETag(r, id) }) {
+			return
+		}
+		req.Actor = actor
+		storage, err = h.svc.SetSiteStorage(r.Context(), id, req)
+	case http.MethodDelete:
+		if !checkVersion(w, r, func() (string, error) { return h.storageETag(r, id) }) {
+			return
+		}
+		if err = h.svc.DeleteSiteStorage(r.Context(), id, actor); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrSiteNotFound):
+			http.Error(w, "site not found", http.StatusNotFound)
+		case errors.Is(err, ErrSiteStorageNotConfigured):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case isBadRequest(err):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "failed to update site storage: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	etag.Set(w, etag.For("site-storage", id, storage.UpdatedAt))
+	writeJSON(w, http.StatusOK, map[string]any{"storage": storage})
+}
+
+// HandleSiteLimits serves GET/PUT/DELETE /api/sites/{id}/limits.
+func (h *Handler) HandleSiteLimits(w http.ResponseWriter, r *http.Request, id int64, actor string) {
+	var (
+		limits SiteLimits
+		err    error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		limits, err = h.svc.GetSiteLimits(r.Context(), id)
+	case http.MethodPut:
+		var req SiteLimitsRequest
+		if decErr := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); decErr != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !checkVersion(w, r, func() (string, error) { return h.limitsETag(r, id) }) {
+			return
+		}
+		req.Actor = actor
+		limits, err = h.svc.SetSiteLimits(r.Context(), id, req)
+	case http.MethodDelete:
+		if !checkVersion(w, r, func() (string, error) { return h.limitsETag(r, id) }) {
+			return
+		}
+		if err = h.svc.DeleteSiteLimits(r.Context(), id, actor); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrSiteNotFound):
+			http.Error(w, "site not found", http.StatusNotFound)
+		case errors.Is(err, ErrSiteLimitsNotSet):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case isBadRequest(err):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "failed to update site limits: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	etag.Set(w, etag.For("site-limits", id, limits.UpdatedAt))
+	writeJSON(w, http.StatusOK, map[string]any{"limits": limits})
+}
+
+// HandleSiteSFTP serves GET/PUT/DELETE /api/sites/{id}/sftp. PUT enables
+// access or rotates the key; without a public_key the panel generates one
+// and returns its private key once.
+func (h *Handler) HandleSiteSFTP(w http.ResponseWriter, r *http.Request, id int64, actor string) {
+	var (
+		sftp SiteSFTP
+		err  error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		sftp, err = h.svc.GetSiteSFTP(r.Context(), id)
+	case http.MethodPut:
+		var req SiteSFTPRequest
+		if decErr := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); decErr != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !checkVersion(w, r, func() (string, error) { return h.sftpETag(r, id) }) {
+			return
+		}
+		req.Actor = actor
+		sftp, err = h.svc.SetSiteSFTP(r.Context(), id, req)
+	case http.MethodDelete:
+		if !checkVersion(w, r, func() (string, error) { return h.sftpETag(r, id) }) {
+			return
+		}
+		if err = h.svc.DisableSiteSFTP(r.Context(), id, actor); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrSiteNotFound):
+			http.Error(w, "site not found", http.StatusNotFound)
+		case errors.Is(err, ErrSFTPNotEnabled):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case isBadRequest(err):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "failed to update site sftp: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if sftp.Enabled {
+		etag.Set(w, etag.For("site-sftp", id, sftp.UpdatedAt))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"sftp": sftp})
+}
+
+// HandleSitePHPFPM serves the PHP-FPM diagnostics of a site; sub is the
+// path after the site id:
+//
+//	GET /api/sites/{id}/php-fpm                 live pool status
+//	GET /api/sites/{id}/php-fpm/slowlog?limit=  latest slow requests
+func (h *Handler) HandleSitePHPFPM(w http.ResponseWriter, r *http.Request, siteID int64, sub string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch strings.Trim(sub, "/") {
+	case "php-fpm":
+		status, err := h.svc.GetPHPFPMStatus(r.Context(), siteID)
+		if err != nil {
+			if errors.Is(err, ErrSiteNotFound) {
+				http.Error(w, "site not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"status": status})
+	case "php-fpm/slowlog":
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		entries, err := h.svc.PHPSlowLog(r.Context(), siteID, limit)
+		if err != nil {
+			if errors.Is(err, ErrSiteNotFound) {
+				http.Error(w, "site not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to read slow log: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jsonstream.List(w, r, "entries", entries)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// HandleSiteErrorBudget serves the 5xx alerting of a site; sub is the
+// path after the site id:
+//
+//	GET /api/sites/{id}/error-budget            threshold and error rates
+//	PUT /api/sites/{id}/error-budget            set or reset the threshold
+//	GET /api/sites/{id}/error-budget/log?limit= latest error log lines
+func (h *Handler) HandleSiteErrorBudget(w http.ResponseWriter, r *http.Request, siteID int64, sub, actor string) {
+	if strings.Trim(sub, "/") == "error-budget/log" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		entries, err := h.svc.SiteErrorLog(r.Context(), siteID, limit)
+		if err != nil {
+			if errors.Is(err, ErrSiteNotFound) {
+				http.Error(w, "site not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to read error log: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jsonstream.List(w, r, "entries", entries)
+		return
+	}
+	if strings.Trim(sub, "/") != "error-budget" {
+		http.NotFound(w, r)
+		return
+	}
+	var (
+		budget SiteErrorBudget
+		err    error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		budget, err = h.svc.GetSiteErrorBudget(r.Context(), siteID)
+	case http.MethodPut:
+		var req SiteErrorBudgetRequest
+		if decErr := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); decErr != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !checkVersion(w, r, func() (string, error) { return h.errorBudgetETag(r, siteID) }) {
+			return
+		}
+		req.Actor = actor
+		budget, err = h.svc.SetSiteErrorBudget(r.Context(), siteID, req)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrSiteNotFound):
+			http.Error(w, "site not found", http.StatusNotFound)
+		case isBadRequest(err):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "failed to update error budget: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	etag.Set(w, etag.For("site-error-budget", siteID, budget.UpdatedAt))
+	writeJSON(w, http.StatusOK, map[string]any{"error_budget": budget})
+}
+
+// HandleSitePageCache serves GET/PUT/DELETE /api/sites/{id}/page-cache.
+func (h *Handler) HandleSitePageCache(w http.ResponseWriter, r *http.Request, id int64, actor string) {
+	var (
+		cache SitePageCache
+		err   error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		cache, err = h.svc.GetSitePageCache(r.Context(), id)
+	case http.MethodPut:
+		var req SitePageCacheRequest
+		if decErr := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); decErr != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !checkVersion(w, r, func() (string, error) { return h.pageCacheETag(r, id) }) {
+			return
+		}
+		req.Actor = actor
+		cache, err = h.svc.SetSitePageCache(r.Context(), id, req)
+	case http.MethodDelete:
+		if !checkVersion(w, r, func() (string, error) { return h.pageCacheETag(r, id) }) {
+			return
+		}
+		if err = h.svc.DeleteSitePageCache(r.Context(), id, actor); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		writePageCacheError(w, err)
+		return
+	}
+	etag.Set(w, etag.For("site-page-cache", id, cache.UpdatedAt))
+	writeJSON(w, http.StatusOK, map[string]any{"page_cache": cache})
+}
+
+// HandleSitePageCachePurge serves POST /api/sites/{id}/page-cache/purge.
+func (h *Handler) HandleSitePageCachePurge(w http.ResponseWriter, r *http.Request, id int64, actor string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := h.svc.PurgePageCache(r.Context(), id, actor); err != nil {
+		writePageCacheError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "purged"})
+}
+
+func writePageCacheError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrSiteNotFound):
+		http.Error(w, "site not found", http.StatusNotFound)
+	case errors.Is(err, ErrPageCacheNotEnabled):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case isBadRequest(err):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "failed to update page cache: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// HandleSiteMemcached serves GET/PUT/DELETE /api/sites/{id}/memcached.
+func (h *Handler) HandleSiteMemcached(w http.ResponseWriter, r *http.Request, id int64, actor string) {
+	var (
+		instance SiteMemcached
+		err      error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		instance, err = h.svc.GetSiteMemcached(r.Context(), id)
+	case http.MethodPut:
+		var req SiteMemcachedRequest
+		if decErr := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); decErr != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !checkVersion(w, r, func() (string, error) { return h.memcachedETag(r, id) }) {
+			return
+		}
+		req.Actor = actor
+		instance, err = h.svc.SetSiteMemcached(r.Context(), id, req)
+	case http.MethodDelete:
+		if !checkVersion(w, r, func() (string, error) { return h.memcachedETag(r, id) }) {
+			return
+		}
+		if err = h.svc.DeleteSiteMemcached(r.Context(), id, actor); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrSiteNotFound):
+			http.Error(w, "site not found", http.StatusNotFound)
+		case errors.Is(err, ErrMemcachedNotEnabled):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, ErrMemcachedNotInstalled):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case isBadRequest(err):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "failed to update memcached: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	etag.Set(w, etag.For("site-memcached", id, instance.UpdatedAt))
+	writeJSON(w, http.StatusOK, map[string]any{"memcached": instance})
+}
+
+// HandleSiteEnv serves GET /api/sites/{id}/env.
+func (h *Handler) HandleSiteEnv(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	env, err := h.svc.GetSiteEnv(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrSiteNotFound) {
+			http.Error(w, "site not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to get site env", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"env": env})
+}
+
+// HandleSiteIsolation serves GET/PUT /api/sites/{id}/isolation.
+func (h *Handler) HandleSiteIsolation(w http.ResponseWriter, r *http.Request, id int64, actor string) {
+	var (
+		isolation SiteIsolation
+		err       error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		isolation, err = h.svc.GetSiteIsolation(r.Context(), id)
+	case http.MethodPut:
+		var req SiteIsolationRequest
+		if decErr := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); decErr != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !h.checkSiteVersion(w, r, id) {
+			return
+		}
+		req.Actor = actor
+		isolation, err = h.svc.SetSiteIsolation(r.Context(), id, req)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		if errors.Is(err, ErrSiteNotFound) {
+			http.Error(w, "site not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to update site isolation: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Isolation is stored on the site row, so it shares the site's version.
+	if site, err := h.svc.GetSite(r.Context(), id); err == nil {
+		etag.Set(w, siteETag(site))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"isolation": isolation})
+}
+
+// HandleSiteCanonicalHost serves PUT /api/sites/{id}/canonical-host.
+func (h *Handler) HandleSiteCanonicalHost(w http.ResponseWriter, r *http.Request, id int64, actor string) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req CanonicalHostRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !h.checkSiteVersion(w, r, id) {
+		return
+	}
+	req.Actor = actor
+	site, err := h.svc.SetCanonicalHost(r.Context(), id, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrSiteNotFound):
+			http.Error(w, "site not found", http.StatusNotFound)
+		case isBadRequest(err):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "failed to set canonical host: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	etag.Set(w, siteETag(site))
+	writeJSON(w, http.StatusOK, map[string]any{"site": site})
+}
+
+// HandleSitePHPVersion serves PUT /api/sites/{id}/php-version.
+func (h *Handler) HandleSitePHPVersion(w http.ResponseWriter, r *http.Request, id int64, actor string) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req SitePHPVersionRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !h.checkSiteVersion(w, r, id) {
+		return
+	}
+	req.Actor = actor
+	site, err := h.svc.SetSitePHPVersion(r.Context(), id, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrSiteNotFound):
+			http.Error(w, "site not found", http.StatusNotFound)
+		case errors.Is(err, ErrPHPUpgradeInProgress):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, ErrInvalidPHPVersion), errors.Is(err, ErrPHPVersionNotInstalled):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "failed to switch php version: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	etag.Set(w, siteETag(site))
+	writeJSON(w, http.StatusOK, map[string]any{"site": site})
+}
+
+// HandleSiteTerminal serves GET /api/sites/{id}/terminal as a WebSocket
+// running a shell as the site user.
+func (h *Handler) HandleSiteTerminal(w http.ResponseWriter, r *http.Request, id int64, actor string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	term, err := h.svc.StartTerminal(r.Context(), id, actor)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrTerminalDisabled):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, ErrSiteNotFound):
+			http.Error(w, "site not found", http.StatusNotFound)
+		default:
+			http.Error(w, "failed to start terminal: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		_ = term.Close()
+		return
+	}
+	term.Serve(conn)
+}
+
+// HandleSiteCron serves the site cron API; sub is the path after the site
+// id:
+//
+//	GET/POST          /api/sites/{id}/cron
+//	GET/PUT/DELETE    /api/sites/{id}/cron/{cronID}
+//	GET               /api/sites/{id}/cron/{cronID}/runs?limit=
+//	POST              /api/sites/{id}/cron/{cronID}/run
+func (h *Handler) HandleSiteCron(w http.ResponseWriter, r *http.Request, siteID int64, sub, actor string) {
+	parts := strings.Split(strings.Trim(sub, "/"), "/")
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			jobs, err := h.svc.ListCronJobs(r.Context(), siteID)
+			if err != nil {
+				writeCronError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"cron_jobs": jobs})
+		case http.MethodPost:
+			var req CronJobRequest
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+			req.Actor = actor
+			job, err := h.svc.CreateCronJob(r.Context(), siteID, req)
+			if err != nil {
+				writeCronError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusCreated, map[string]any{"cron_job": job})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	cronID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || len(parts) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+	if len(parts) == 3 {
+		switch {
+		case parts[2] == "runs" && r.Method == http.MethodGet:
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			runs, err := h.svc.ListCronRuns(r.Context(), siteID, cronID, limit)
+			if err != nil {
+				writeCronError(w, err)
+				return
+			}
+			jsonstream.List(w, r, "runs", runs)
+		case parts[2] == "run" && r.Method == http.MethodPost:
+			jobID, err := h.svc.TriggerCronJob(r.Context(), siteID, cronID, actor)
+			if err != nil {
+				writeCronError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusAccepted, map[string]any{"job_id": jobID})
+		case parts[2] == "runs" || parts[2] == "run":
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		default:
+			http.NotFound(w, r)
+		}
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		job, err := h.svc.GetCronJob(r.Context(), siteID, cronID)
+		if err != nil {
+			writeCronError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"cron_job": job})
+	case http.MethodPut:
+		var req CronJobRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Actor = actor
+		job, err := h.svc.UpdateCronJob(r.Context(), siteID, cronID, req)
+		if err != nil {
+			writeCronError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"cron_job": job})
+	case http.MethodDelete:
+		if err := h.svc.DeleteCronJob(r.Context(), siteID, cronID, actor); err != nil {
+			writeCronError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleSiteWorkers serves the site worker API; sub is the path after the
+// site id:
+//
+//	GET/POST          /api/sites/{id}/workers
+//	GET/PUT/DELETE    /api/sites/{id}/workers/{workerID}
+//	POST              /api/sites/{id}/workers/{workerID}/start|stop|restart
+//	PUT               /api/sites/{id}/workers/{workerID}/scale
+//	GET               /api/sites/{id}/workers/{workerID}/logs?lines=
+func (h *Handler) HandleSiteWorkers(w http.ResponseWriter, r *http.Request, siteID int64, sub, actor string) {
+	parts := strings.Split(strings.Trim(sub, "/"), "/")
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			workers, err := h.svc.ListWorkers(r.Context(), siteID)
+			if err != nil {
+				writeWorkerError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"workers": workers})
+		case http.MethodPost:
+			var req WorkerRequest
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+			req.Actor = actor
+			worker, err := h.svc.CreateWorker(r.Context(), siteID, req)
+			if err != nil {
+				writeWorkerError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusCreated, map[string]any{"worker": worker})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	workerID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || len(parts) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+	if len(parts) == 3 {
+		var worker Worker
+		switch {
+		case parts[2] == "logs" && r.Method == http.MethodGet:
+			lines, _ := strconv.Atoi(r.URL.Query().Get("lines"))
+			logs, err := h.svc.WorkerLogs(r.Context(), siteID, workerID, lines)
+			if err != nil {
+				writeWorkerError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"lines": logs})
+			return
+		case parts[2] == "scale" && r.Method == http.MethodPut:
+			var req struct {
+				Processes int `json:"processes"`
+			}
+			if decErr := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); decErr != nil {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+			worker, err = h.svc.ScaleWorker(r.Context(), siteID, workerID, req.Processes, actor)
+		case parts[2] == "start" && r.Method == http.MethodPost:
+			worker, err = h.svc.StartWorker(r.Context(), siteID, workerID, actor)
+		case parts[2] == "stop" && r.Method == http.MethodPost:
+			worker, err = h.svc.StopWorker(r.Context(), siteID, workerID, actor)
+		case parts[2] == "restart" && r.Method == http.MethodPost:
+			worker, err = h.svc.RestartWorker(r.Context(), siteID, workerID, actor)
+		case parts[2] == "logs" || parts[2] == "scale" || parts[2] == "start" || parts[2] == "stop" || parts[2] == "restart":
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			writeWorkerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"worker": worker})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		worker, err := h.svc.GetWorker(r.Context(), siteID, workerID)
+		if err != nil {
+			writeWorkerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"worker": worker})
+	case http.MethodPut:
+		var req WorkerRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Actor = actor
+		worker, err := h.svc.UpdateWorker(r.Context(), siteID, workerID, req)
+		if err != nil {
+			writeWorkerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"worker": worker})
+	case http.MethodDelete:
+		if err := h.svc.DeleteWorker(r.Context(), siteID, workerID, actor); err != nil {
+			writeWorkerError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleSiteWordPress serves the WordPress toolkit of a site:
+//
+//	GET  /api/sites/{id}/wordpress
+//	POST /api/sites/{id}/wordpress/update
+//	PUT  /api/sites/{id}/wordpress/auto-updates
+//	PUT  /api/sites/{id}/wordpress/hardening
+//	POST /api/sites/{id}/wordpress/scan
+func (h *Handler) HandleSiteWordPress(w http.ResponseWriter, r *http.Request, siteID int64, sub, actor string) {
+	var (
+		wp  WordPressSite
+		err error
+	)
+	switch {
+	case sub == "wordpress" && r.Method == http.MethodGet:
+		wp, err = h.svc.GetWordPress(r.Context(), siteID)
+	case sub == "wordpress/update" && r.Method == http.MethodPost:
+		var req WPUpdateRequest
+		if decErr := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); decErr != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Actor = actor
+		jobID, err := h.svc.UpdateWordPress(r.Context(), siteID, req)
+		if err != nil {
+			writeWordPressError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]any{"job_id": jobID})
+		return
+	case sub == "wordpress/auto-updates" && r.Method == http.MethodPut:
+		var req WPAutoUpdates
+		if decErr := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); decErr != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Actor = actor
+		wp, err = h.svc.SetWordPressAutoUpdates(r.Context(), siteID, req)
+	case sub == "wordpress/hardening" && r.Method == http.MethodPut:
+		var req WPHardeningRequest
+		if decErr := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); decErr != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Actor = actor
+		wp, err = h.svc.SetWordPressHardening(r.Context(), siteID, req)
+	case sub == "wordpress/scan" && r.Method == http.MethodPost:
+		wp, err = h.svc.ScanWordPress(r.Context(), siteID)
+	case sub == "wordpress" || sub == "wordpress/update" || sub == "wordpress/auto-updates" ||
+		sub == "wordpress/hardening" || sub == "wordpress/scan":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		writeWordPressError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"wordpress": wp})
+}
+
+func writeWordPressError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrSiteNotFound):
+		http.Error(w, "site not found", http.StatusNotFound)
+	case errors.Is(err, ErrNotWordPress):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrWPScanNotConfigured):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case isBadRequest(err) && !strings.HasPrefix(err.Error(), "wp "):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "wordpress request failed: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeCronError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrSiteNotFound):
+		http.Error(w, "site not found", http.StatusNotFound)
+	case errors.Is(err, ErrCronJobNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case isBadRequest(err) || strings.Contains(err.Error(), "must be"):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "cron request failed: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeWorkerError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrSiteNotFound):
+		http.Error(w, "site not found", http.StatusNotFound)
+	case errors.Is(err, ErrWorkerNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrWorkerExists), errors.Is(err, ErrWorkerStopped):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case isBadRequest(err):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "worker request failed: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// HandleACMEAccount serves GET/POST /api/tls/account.
+// GET accepts ?staging=true to inspect the staging account.
+func (h *Handler) HandleACMEAccount(w http.ResponseWriter, r *http.Request, actor string) {
+	switch r.Method {
+	case http.MethodGet:
+		staging := h.svc.acmeStaging(r.Context(), nil)
+		if raw := r.URL.Query().Get("staging"); raw != "" {
+			v, err := strconv.ParseBool(raw)
+			if err != nil {
+				http.Error(w, "invalid staging value", http.StatusBadRequest)
+				return
+			}
+			staging = v
+		}
+		account, err := h.svc.ACMEAccount(r.Context(), staging)
+		if err != nil {
+			http.Error(w, "failed to read acme account", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"account": account})
+	case http.MethodPost:
+		var req RegisterACMEAccountRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Actor = actor
+		account, err := h.svc.RegisterACMEAccount(r.Context(), req)
+		if err != nil {
+			if isBadRequest(err) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "failed to register acme account: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"account": account})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleCertificates serves POST /api/tls/certificates (issue for a domain).
+func (h *Handler) HandleCertificates(w http.ResponseWriter, r *http.Request, actor string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req IssueCertificateRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Actor = actor
+	if err := h.svc.IssueCertificate(r.Context(), req); err != nil {
+		switch {
+		case errors.Is(err, ErrSiteNotFound):
+			http.Error(w, "site not found", http.StatusNotFound)
+		case errors.Is(err, ErrCloudflareNotConfigured):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case isBadRequest(err):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "issued", "domain": strings.ToLower(strings.TrimSpace(req.Domain))})
+}
+
+// HandleCertificateFailures serves GET /api/tls/failures?domain=&limit=.
+func (h *Handler) HandleCertificateFailures(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	failures, err := h.svc.ListCertificateFailures(r.Context(), r.URL.Query().Get("domain"), limit)
+	if err != nil {
+		if isBadRequest(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "failed to list certificate failures", http.StatusInternalServerError)
+		return
+	}
+	jsonstream.List(w, r, "failures", failures)
+}
+
+// HandleTemplatePreview serves POST /api/templates/preview. A template
+// that fails to render or test is still a 200 with valid=false.
+func (h *Handler) HandleTemplatePreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req TemplatePreviewRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	preview, err := h.svc.PreviewTemplate(r.Context(), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrSiteNotFound):
+			http.Error(w, "site not found", http.StatusNotFound)
+		case isBadRequest(err):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "failed to preview template: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"preview": preview})
+}
+
+// HandleCatchAll serves GET/POST /api/settings/catchall. GET renders the
+// catch-all vhost; POST applies it to nginx.
+func (h *Handler) HandleCatchAll(w http.ResponseWriter, r *http.Request, actor string) {
+	var (
+		state CatchAll
+		err   error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		state, err = h.svc.CatchAll(r.Context())
+	case http.MethodPost:
+		state, err = h.svc.ApplyCatchAll(r.Context(), actor)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		if isBadRequest(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "failed to apply catch-all vhost: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"catchall": state})
+}
+
+func isBadRequest(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "invalid") || strings.Contains(msg, "required")
+}
+
+// ParseSiteSubresource splits "/api/sites/{id}/{name}" into id and name.
+func ParseSiteSubresource(path string) (int64, string, error) {
+	trimmed := strings.TrimPrefix(path, "/api/sites/")
+	trimmed = strings.TrimSpace(strings.Trim(trimmed, "/"))
+	idRaw, name, ok := strings.Cut(trimmed, "/")
+	if !ok || name == "" {
+		return 0, "", strconv.ErrSyntax
+	}
+	id, err := strconv.ParseInt(idRaw, 10, 64)
+	if err != nil {
+		return 0, "", err
+	}
+	return id, name, nil
+}
+
+// ParseSiteID extracts id from "/api/sites/{id}".
+func ParseSiteID(path string) (int64, error) {
+	idRaw := strings.TrimPrefix(path, "/api/sites/")
+	idRaw = strings.TrimSpace(strings.Trim(idRaw, "/"))
+	return strconv.ParseInt(idRaw, 10, 64)
+}
+
+// siteETag is the version of a site row. Settings stored on the row
+// (canonical host, isolation) bump updated_at and so change the tag.
+func siteETag(site Site) string {
+	return etag.For("site", site.ID, site.UpdatedAt)
+}
+
+func (h *Handler) checkSiteVersion(w http.ResponseWriter, r *http.Request, id int64) bool {
+	return checkVersion(w, r, func() (string, error) {
+		site, err := h.svc.GetSite(r.Context(), id)
+		if err != nil {
+			return "", err
+		}
+		return siteETag(site), nil
+	})
+}
+
+// limitsETag returns "" while no limits are set, so only If-Match: * passes.
+func (h *Handler) limitsETag(r *http.Request, id int64) (string, error) {
+	limits, err := h.svc.GetSiteLimits(r.Context(), id)
+	if errors.Is(err, ErrSiteLimitsNotSet) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return etag.For("site-limits", id, limits.UpdatedAt), nil
+}
+
+func (h *Handler) pageCacheETag(r *http.Request, id int64) (string, error) {
+	cache, err := h.svc.GetSitePageCache(r.Context(), id)
+	if errors.Is(err, ErrPageCacheNotEnabled) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return etag.For("site-page-cache", id, cache.UpdatedAt), nil
+}
+
+// memcachedETag returns "" while memcached is off, so only If-Match: *
+// passes.
+func (h *Handler) memcachedETag(r *http.Request, id int64) (string, error) {
+	instance, err := h.svc.GetSiteMemcached(r.Context(), id)
+	if errors.Is(err, ErrMemcachedNotEnabled) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return etag.For("site-memcached", id, instance.UpdatedAt), nil
+}
+
+// sftpETag returns "" while SFTP is off, so only If-Match: * passes.
+func (h *Handler) sftpETag(r *http.Request, id int64) (string, error) {
+	sftp, err := h.svc.GetSiteSFTP(r.Context(), id)
+	if err != nil {
+		return "", err
+	}
+	if !sftp.Enabled {
+		return "", nil
+	}
+	return etag.For("site-sftp", id, sftp.UpdatedAt), nil
+}
+
+func (h *Handler) errorBudgetETag(r *http.Request, id int64) (string, error) {
+	budget, err := h.svc.GetSiteErrorBudget(r.Context(), id)
+	if err != nil {
+		return "", err
+	}
+	return etag.For("site-error-budget", id, budget.UpdatedAt), nil
+}
+
+func (h *Handler) storageETag(r *http.Request, id int64) (string, error) {
+	storage, err := h.svc.GetSiteStorage(r.Context(), id)
+	if errors.Is(err, ErrSiteStorageNotConfigured) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return etag.For("site-storage", id, storage.UpdatedAt), nil
+}
+
+// checkVersion enforces If-Match before an update. The current version is
+// only loaded for conditional requests.
+func checkVersion(w http.ResponseWriter, r *http.Request, current func() (string, error)) bool {
+	if r.Header.Get("If-Match") == "" {
+		return true
+	}
+	tag, err := current()
+	if err != nil {
+		if errors.Is(err, ErrSiteNotFound) {
+			http.Error(w, "site not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "failed to load current version", http.StatusInternalServerError)
+		}
+		return false
+	}
+	return etag.Check(w, r, tag)
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
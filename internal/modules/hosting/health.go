@@ -0,0 +1,197 @@
+package hosting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/fastcgi"
+)
+
+const (
+	defaultHealthHTTPBase = "http://127.0.0.1"
+	// sitePingPath is the ping.path of site pools (phpfpm_pool.conf.tmpl).
+	sitePingPath = "/aipanel-ping"
+	// siteHealthAttempts bounds retries while a fresh vhost or pool comes up.
+	siteHealthAttempts = 5
+	siteProbeTimeout   = 5 * time.Second
+)
+
+// HealthUnknown is the status of a site that was never checked.
+const HealthUnknown = "unknown"
+
+// HealthProbe is the outcome of one health check.
+type HealthProbe struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// SiteHealth is whether a site is actually serving: nginx answers for its
+// name and its PHP-FPM pool answers a ping through the socket.
+type SiteHealth struct {
+	SiteID    int64       `json:"site_id"`
+	Status    string      `json:"status"`
+	HTTP      HealthProbe `json:"http"`
+	PHPFPM    HealthProbe `json:"php_fpm"`
+	CheckedAt time.Time   `json:"checked_at,omitzero"`
+}
+
+// diagnostics summarizes the failed probes for an error message.
+func (h SiteHealth) diagnostics() string {
+	var parts []string
+	if h.HTTP.Status != CheckPass {
+		parts = append(parts, "http: "+h.HTTP.Detail)
+	}
+	if h.PHPFPM.Status != CheckPass {
+		parts = append(parts, "php-fpm: "+h.PHPFPM.Detail)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// GetSiteHealth returns the result of the last health check of a site.
+func (s *Service) GetSiteHealth(ctx context.Context, siteID int64) (SiteHealth, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT health_report FROM sites WHERE id = %d;", siteID))
+	if err != nil {
+		return SiteHealth{}, fmt.Errorf("get site health: %w", err)
+	}
+	if len(rows) == 0 {
+		return SiteHealth{}, ErrSiteNotFound
+	}
+	report, _ := rows[0]["health_report"].(string)
+	if report == "" {
+		return SiteHealth{SiteID: siteID, Status: HealthUnknown}, nil
+	}
+	var health SiteHealth
+	if err := json.Unmarshal([]byte(report), &health); err != nil {
+		return SiteHealth{}, fmt.Errorf("decode site health: %w", err)
+	}
+	return health, nil
+}
+
+// CheckSiteHealth probes a site now and records the result on its row.
+func (s *Service) CheckSiteHealth(ctx context.Context, siteID int64) (SiteHealth, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return SiteHealth{}, err
+	}
+	health := s.probeSite(ctx, site)
+	if err := s.recordSiteHealth(ctx, health); err != nil {
+		return SiteHealth{}, err
+	}
+	return health, nil
+}
+
+// probeSite runs both probes, retrying failed ones a few times: nginx
+// workers pick up a reload asynchronously and an ondemand pool starts its
+// first worker on the first request.
+func (s *Service) probeSite(ctx context.Context, site Site) SiteHealth {
+	health := SiteHealth{SiteID: site.ID}
+retry:
+	for attempt := 1; ; attempt++ {
+		if health.HTTP.Status != CheckPass {
+			health.HTTP = s.probeHTTP(ctx, site)
+		}
+		if health.PHPFPM.Status != CheckPass {
+			health.PHPFPM = s.probePHPFPM(ctx, site)
+		}
+		if (health.HTTP.Status == CheckPass && health.PHPFPM.Status == CheckPass) || attempt == siteHealthAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			break retry
+		case <-time.After(s.healthRetryDelay):
+		}
+	}
+	health.Status = CheckPass
+	if health.HTTP.Status != CheckPass || health.PHPFPM.Status != CheckPass {
+		health.Status = CheckFail
+	}
+	health.CheckedAt = time.Now().UTC().Truncate(time.Second)
+	return health
+}
+
+// probeHTTP requests the site from the local nginx. Anything below 500,
+// redirects included, shows the vhost is loaded; the catch-all server
+// closes the connection instead of answering.
+func (s *Service) probeHTTP(ctx context.Context, site Site) HealthProbe {
+	serverName, _, err := canonicalHosts(site.Domain, site.CanonicalHost)
+	if err != nil {
+		return HealthProbe{Status: CheckFail, Detail: err.Error()}
+	}
+	ctx, cancel := context.WithTimeout(ctx, siteProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.healthHTTPBase+"/", nil)
+	if err != nil {
+		return HealthProbe{Status: CheckFail, Detail: err.Error()}
+	}
+	req.Host = serverName
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return HealthProbe{Status: CheckFail, Detail: "request failed: " + err.Error()}
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	detail := fmt.Sprintf("GET http://%s/ answered %s", serverName, resp.Status)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return HealthProbe{Status: CheckFail, Detail: detail}
+	}
+	return HealthProbe{Status: CheckPass, Detail: detail}
+}
+
+// probePHPFPM pings the site pool over its socket. Pools written before
+// ping.path was added answer 404; that still proves a worker is serving.
+func (s *Service) probePHPFPM(ctx context.Context, site Site) HealthProbe {
+	socket := s.siteSocket(site)
+	ctx, cancel := context.WithTimeout(ctx, siteProbeTimeout)
+	defer cancel()
+	resp, err := fastcgi.Do(ctx, "unix", socket, map[string]string{
+		"REQUEST_METHOD":  http.MethodGet,
+		"REQUEST_URI":     sitePingPath,
+		"SCRIPT_NAME":     sitePingPath,
+		"SCRIPT_FILENAME": sitePingPath,
+		"SERVER_PROTOCOL": "HTTP/1.1",
+	})
+	if err != nil {
+		return HealthProbe{Status: CheckFail, Detail: err.Error()}
+	}
+	if resp.Status == http.StatusOK && strings.TrimSpace(string(resp.Body)) == "pong" {
+		return HealthProbe{Status: CheckPass, Detail: "pong from " + socket}
+	}
+	if resp.Status >= http.StatusInternalServerError {
+		return HealthProbe{Status: CheckFail, Detail: fmt.Sprintf("%s answered status %d", socket, resp.Status)}
+	}
+	return HealthProbe{Status: CheckPass, Detail: fmt.Sprintf("%s answered status %d (ping.path not configured)", socket, resp.Status)}
+}
+
+// siteSocket is the PHP-FPM socket of the site's pool on its PHP version.
+func (s *Service) siteSocket(site Site) string {
+	if s.fpmSocket != nil {
+		return s.fpmSocket(site)
+	}
+	return socketPath(site.Domain, site.PHPVersion)
+}
+
+func (s *Service) recordSiteHealth(ctx context.Context, health SiteHealth) error {
+	report, err := json.Marshal(health)
+	if err != nil {
+		return fmt.Errorf("encode site health: %w", err)
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(
+		"UPDATE sites SET health_status = '%s', health_report = '%s' WHERE id = %d;",
+		sqlEscape(health.Status), sqlEscape(string(report)), health.SiteID)); err != nil {
+		return fmt.Errorf("record site health: %w", err)
+	}
+	s.sitesCache.Purge()
+	return nil
+}
@@ -0,0 +1,111 @@
+package hosting
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+)
+
+// fakeSite serves HTTP for the given host and a PHP-FPM ping on a unix
+// socket, and points svc at both.
+func fakeSite(t *testing.T, svc *Service, host string, httpStatus int) {
+	t.Helper()
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != host {
+			http.Error(w, "unknown host", http.StatusMisdirectedRequest)
+			return
+		}
+		w.WriteHeader(httpStatus)
+	}))
+	t.Cleanup(web.Close)
+
+	sock := filepath.Join(t.TempDir(), "fpm.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	go func() {
+		_ = fcgi.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != sitePingPath {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write([]byte("pong"))
+		}))
+	}()
+
+	svc.healthHTTPBase = web.URL
+	svc.fpmSocket = func(Site) string { return sock }
+	svc.healthRetryDelay = time.Millisecond
+}
+
+func TestCheckSiteHealth_RecordsResult(t *testing.T) {
+	ctx := context.Background()
+	svc := newACMEService(t, config.Config{}, &fakeRunner{})
+	fakeSite(t, svc, "example.com", http.StatusOK)
+
+	health, err := svc.GetSiteHealth(ctx, 1)
+	if err != nil || health.Status != HealthUnknown {
+		t.Fatalf("expected unknown health before a check, got %+v (%v)", health, err)
+	}
+	health, err = svc.CheckSiteHealth(ctx, 1)
+	if err != nil {
+		t.Fatalf("check site health: %v", err)
+	}
+	if health.Status != CheckPass || health.HTTP.Status != CheckPass || !strings.HasPrefix(health.PHPFPM.Detail, "pong from ") {
+		t.Fatalf("unexpected health: %+v", health)
+	}
+	stored, err := svc.GetSiteHealth(ctx, 1)
+	if err != nil || stored.Status != CheckPass || stored.CheckedAt.IsZero() {
+		t.Fatalf("expected stored result, got %+v (%v)", stored, err)
+	}
+	site, err := svc.GetSite(ctx, 1)
+	if err != nil || site.HealthStatus != CheckPass {
+		t.Fatalf("expected health status on site, got %+v (%v)", site, err)
+	}
+
+	svc.fpmSocket = func(Site) string { return filepath.Join(t.TempDir(), "missing.sock") }
+	health, err = svc.CheckSiteHealth(ctx, 1)
+	if err != nil || health.Status != CheckFail || health.PHPFPM.Status != CheckFail || health.HTTP.Status != CheckPass {
+		t.Fatalf("expected php-fpm failure, got %+v (%v)", health, err)
+	}
+}
+
+func TestService_CreateSiteRollsBackSiteThatIsNotServing(t *testing.T) {
+	ctx := context.Background()
+	runner := newSiteUserRunner()
+	nginx := &fakeNginxAdapter{}
+	svc := newTestService(t, withConfig(config.Config{SiteHealthChecks: true}), withRunner(runner), withNginx(nginx))
+	fakeSite(t, svc, "test.example.com", http.StatusBadGateway)
+
+	_, err := svc.CreateSite(ctx, CreateSiteRequest{Domain: "test.example.com", PHPVersion: "8.3"})
+	if err == nil || !strings.Contains(err.Error(), "site is not serving: http: GET http://test.example.com/ answered 502") {
+		t.Fatalf("expected health check failure, got %v", err)
+	}
+	if len(nginx.removeCalls) != 1 {
+		t.Fatalf("expected vhost rollback, got %v", nginx.removeCalls)
+	}
+	if sites, _ := svc.ListSites(ctx); len(sites) != 0 {
+		t.Fatalf("expected no site row, got %+v", sites)
+	}
+
+	fakeSite(t, svc, "test.example.com", http.StatusOK)
+	site, err := svc.CreateSite(ctx, CreateSiteRequest{Domain: "test.example.com", PHPVersion: "8.3"})
+	if err != nil {
+		t.Fatalf("create site: %v", err)
+	}
+	if site.HealthStatus != CheckPass {
+		t.Fatalf("expected recorded health, got %+v", site)
+	}
+}
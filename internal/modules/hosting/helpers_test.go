@@ -0,0 +1,92 @@
+package hosting
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+	"github.com/robsonek/aiPanel/internal/platform/systemd"
+	"github.com/robsonek/aiPanel/pkg/adapter"
+)
+
+type testServiceOptions struct {
+	cfg    config.Config
+	runner systemd.Runner
+	nginx  adapter.Nginx
+	phpfpm adapter.PHPFPM
+}
+
+type testServiceOption func(*testServiceOptions)
+
+func withConfig(cfg config.Config) testServiceOption {
+	return func(o *testServiceOptions) { o.cfg = cfg }
+}
+
+func withRunner(runner systemd.Runner) testServiceOption {
+	return func(o *testServiceOptions) { o.runner = runner }
+}
+
+func withNginx(nginx adapter.Nginx) testServiceOption {
+	return func(o *testServiceOptions) { o.nginx = nginx }
+}
+
+func withPHPFPM(phpfpm adapter.PHPFPM) testServiceOption {
+	return func(o *testServiceOptions) { o.phpfpm = phpfpm }
+}
+
+// newTestService returns a service on a fresh store with a temporary web
+// root. The runner and adapters are fakes unless opts replace them; tests
+// that inspect a fake pass their own.
+func newTestService(t *testing.T, opts ...testServiceOption) *Service {
+	t.Helper()
+	o := testServiceOptions{runner: &fakeRunner{}, nginx: &fakeNginxAdapter{}, phpfpm: &fakePHPFPMAdapter{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(context.Background()); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	svc := NewService(store, o.cfg, slog.Default(), o.runner, o.nginx, o.phpfpm)
+	svc.webRoot = t.TempDir()
+	return svc
+}
+
+// newSiteUserRunner returns a fake runner on which the system user of
+// test.example.com does not exist yet, so CreateSite provisions it.
+func newSiteUserRunner() *fakeRunner {
+	return &fakeRunner{errs: map[string]error{"id site_test_example_com": fmt.Errorf("no such user")}}
+}
+
+// createTestSite creates test.example.com on PHP 8.3 through CreateSite.
+func createTestSite(t *testing.T, svc *Service) Site {
+	t.Helper()
+	site, err := svc.CreateSite(context.Background(), CreateSiteRequest{Domain: "test.example.com", PHPVersion: "8.3"})
+	if err != nil {
+		t.Fatalf("create site: %v", err)
+	}
+	return site
+}
+
+// seedSite inserts an active PHP 8.3 site row for domain, with its docroot
+// under the service web root, and returns its id. Unlike CreateSite it
+// provisions nothing.
+func seedSite(t *testing.T, svc *Service, domain string) int64 {
+	t.Helper()
+	rows, err := svc.store.QueryPanelWriteJSON(context.Background(), fmt.Sprintf(`
+INSERT INTO sites(domain, root_dir, php_version, system_user, status, created_at, updated_at)
+VALUES('%s', '%s', '8.3', '%s', 'active', 1, 1)
+RETURNING id;`, sqlEscape(domain), sqlEscape(filepath.Join(svc.webRoot, domain, "public_html")), systemUserForDomain(domain)))
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("seed site %s: %v", domain, err)
+	}
+	id, err := toInt64(rows[0]["id"])
+	if err != nil {
+		t.Fatalf("seed site %s: %v", domain, err)
+	}
+	return id
+}
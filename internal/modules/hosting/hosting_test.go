@@ -2,14 +2,17 @@ package hosting
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
 	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/faultinject"
 	"github.com/robsonek/aiPanel/internal/platform/sqlite"
 	"github.com/robsonek/aiPanel/pkg/adapter"
 )
@@ -21,6 +24,7 @@ type fakeNginxAdapter struct {
 	reloadCalls int
 	failWrite   error
 	failTest    error
+	failReload  error
 }
 
 func (f *fakeNginxAdapter) WriteVhost(_ context.Context, site adapter.SiteConfig) error {
@@ -43,7 +47,7 @@ func (f *fakeNginxAdapter) TestConfig(_ context.Context) error {
 
 func (f *fakeNginxAdapter) Reload(_ context.Context) error {
 	f.reloadCalls++
-	return nil
+	return f.failReload
 }
 
 type fakePHPFPMAdapter struct {
@@ -164,6 +168,29 @@ func TestService_CreateSiteUsesLatestInstalledPHPVersionByDefault(t *testing.T)
 	}
 }
 
+func TestService_PHPVersionsCachedUntilInvalidated(t *testing.T) {
+	ctx := context.Background()
+	phpfpm := &fakePHPFPMAdapter{versions: []string{"8.4", "8.3"}}
+	svc := NewService(nil, config.Config{}, slog.Default(), &fakeRunner{}, &fakeNginxAdapter{}, phpfpm)
+
+	got, err := svc.PHPVersions(ctx)
+	if err != nil {
+		t.Fatalf("php versions: %v", err)
+	}
+	if !slices.Equal(got.Versions, []string{"8.3", "8.4"}) || got.Default != "8.4" {
+		t.Fatalf("unexpected versions: %+v", got)
+	}
+
+	phpfpm.versions = []string{"8.3", "8.4", "8.5"}
+	if got, _ = svc.PHPVersions(ctx); got.Default != "8.4" {
+		t.Fatalf("expected cached list before invalidation, got %+v", got)
+	}
+	svc.InvalidatePHPVersions()
+	if got, _ = svc.PHPVersions(ctx); len(got.Versions) != 3 || got.Default != "8.5" {
+		t.Fatalf("expected fresh list after invalidation, got %+v", got)
+	}
+}
+
 func TestService_CreateSiteRollbackOnNginxFailure(t *testing.T) {
 	ctx := context.Background()
 	store := sqlite.New(t.TempDir())
@@ -195,6 +222,72 @@ func TestService_CreateSiteRollbackOnNginxFailure(t *testing.T) {
 	}
 }
 
+func TestService_CreateSiteRollbackOnInjectedChownFailure(t *testing.T) {
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	restore := faultinject.Install(faultinject.Rule{Kind: faultinject.KindCommand, Match: "chown -R"})
+	defer restore()
+
+	runner := &fakeRunner{
+		errs: map[string]error{
+			"id site_test_example_com": fmt.Errorf("no such user"),
+		},
+	}
+	nginx := &fakeNginxAdapter{}
+	phpfpm := &fakePHPFPMAdapter{}
+	svc := NewService(store, config.Config{}, slog.Default(), faultinject.WrapRunner(runner), nginx, phpfpm)
+	svc.webRoot = t.TempDir()
+
+	_, err := svc.CreateSite(ctx, CreateSiteRequest{Domain: "test.example.com", PHPVersion: "8.3"})
+	if !errors.Is(err, faultinject.ErrInjected) {
+		t.Fatalf("expected injected failure, got %v", err)
+	}
+	if !containsCommand(runner.commands, "userdel --remove site_test_example_com") {
+		t.Fatalf("expected user cleanup, got %v", runner.commands)
+	}
+	if _, statErr := os.Stat(filepath.Join(svc.webRoot, "test.example.com")); !os.IsNotExist(statErr) {
+		t.Fatalf("expected site directory removal, stat err=%v", statErr)
+	}
+	if len(phpfpm.writeCalls) != 0 || len(nginx.writeCalls) != 0 {
+		t.Fatalf("expected provisioning to stop before pool/vhost writes")
+	}
+	sites, err := svc.ListSites(ctx)
+	if err != nil {
+		t.Fatalf("list sites: %v", err)
+	}
+	if len(sites) != 0 {
+		t.Fatalf("expected no site rows, got %+v", sites)
+	}
+}
+
+func TestService_CreateSiteRollbackOnInjectedBootstrapWriteFailure(t *testing.T) {
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	restore := faultinject.Install(faultinject.Rule{Kind: faultinject.KindWrite, Match: "public_html/index.html"})
+	defer restore()
+
+	runner := &fakeRunner{}
+	svc := NewService(store, config.Config{}, slog.Default(), runner, &fakeNginxAdapter{}, &fakePHPFPMAdapter{})
+	svc.webRoot = t.TempDir()
+
+	_, err := svc.CreateSite(ctx, CreateSiteRequest{Domain: "test.example.com", PHPVersion: "8.3"})
+	if !errors.Is(err, faultinject.ErrInjected) {
+		t.Fatalf("expected injected failure, got %v", err)
+	}
+	if _, statErr := os.Stat(filepath.Join(svc.webRoot, "test.example.com")); !os.IsNotExist(statErr) {
+		t.Fatalf("expected site directory removal, stat err=%v", statErr)
+	}
+	if containsCommand(runner.commands, "id site_test_example_com") {
+		t.Fatalf("expected provisioning to stop before user creation, got %v", runner.commands)
+	}
+}
+
 func TestService_DeleteSite(t *testing.T) {
 	ctx := context.Background()
 	store := sqlite.New(t.TempDir())
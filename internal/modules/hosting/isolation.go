@@ -0,0 +1,103 @@
+package hosting
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/robsonek/aiPanel/pkg/adapter"
+)
+
+// SiteIsolation describes the filesystem confinement of a site PHP pool.
+type SiteIsolation struct {
+	SiteID             int64  `json:"site_id"`
+	TmpDir             string `json:"tmp_dir"`
+	OpenBasedir        string `json:"open_basedir"`
+	OpenBasedirRelaxed bool   `json:"open_basedir_relaxed"`
+}
+
+// SiteIsolationRequest toggles the relaxed open_basedir of a site.
+type SiteIsolationRequest struct {
+	OpenBasedirRelaxed bool   `json:"open_basedir_relaxed"`
+	Actor              string `json:"-"`
+}
+
+// GetSiteIsolation returns the tmp dir and open_basedir of a site.
+func (s *Service) GetSiteIsolation(ctx context.Context, siteID int64) (SiteIsolation, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return SiteIsolation{}, err
+	}
+	cfg, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return SiteIsolation{}, err
+	}
+	return SiteIsolation{
+		SiteID:             site.ID,
+		TmpDir:             cfg.TmpDir,
+		OpenBasedir:        openBasedir(cfg),
+		OpenBasedirRelaxed: site.OpenBasedirRelaxed,
+	}, nil
+}
+
+// SetSiteIsolation relaxes or restores the open_basedir of a site and
+// rewrites its PHP pool.
+func (s *Service) SetSiteIsolation(ctx context.Context, siteID int64, req SiteIsolationRequest) (SiteIsolation, error) {
+	if s.store == nil || s.nginx == nil || s.phpfpm == nil {
+		return SiteIsolation{}, fmt.Errorf("hosting service is not fully configured")
+	}
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return SiteIsolation{}, err
+	}
+	previous, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return SiteIsolation{}, err
+	}
+	relaxed := 0
+	if req.OpenBasedirRelaxed {
+		relaxed = 1
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(
+		"UPDATE sites SET open_basedir_relaxed = %d, updated_at = MAX(%d, updated_at + 1) WHERE id = %d;",
+		relaxed, time.Now().Unix(), siteID)); err != nil {
+		return SiteIsolation{}, fmt.Errorf("update site isolation: %w", err)
+	}
+	s.sitesCache.Purge()
+	site.OpenBasedirRelaxed = req.OpenBasedirRelaxed
+	next, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return SiteIsolation{}, err
+	}
+	if err := s.applySiteConfig(ctx, previous, next); err != nil {
+		return SiteIsolation{}, err
+	}
+	_ = s.writeAudit(ctx, req.Actor, "hosting.isolation.set", fmt.Sprintf("domain=%s open_basedir_relaxed=%t", site.Domain, req.OpenBasedirRelaxed))
+	return s.GetSiteIsolation(ctx, siteID)
+}
+
+// siteTmpDir is the private tmp dir of a site, next to its docroot and
+// outside the web-served tree.
+func siteTmpDir(rootDir string) string {
+	return filepath.Join(filepath.Dir(rootDir), "tmp")
+}
+
+// ensureSiteTmpDir creates the tmp dir of sites provisioned before it
+// existed, owned by the site user and closed to everyone else.
+func (s *Service) ensureSiteTmpDir(ctx context.Context, cfg adapter.SiteConfig) error {
+	if cfg.TmpDir == "" {
+		return nil
+	}
+	if _, err := os.Stat(cfg.TmpDir); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(cfg.TmpDir, 0o700); err != nil {
+		return fmt.Errorf("create site tmp dir: %w", err)
+	}
+	if _, err := s.runner.Run(ctx, "chown", cfg.SystemUser+":"+cfg.SystemUser, cfg.TmpDir); err != nil {
+		return fmt.Errorf("chown site tmp dir: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package hosting
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestService_SiteIsolation(t *testing.T) {
+	ctx := context.Background()
+	runner := newSiteUserRunner()
+	phpfpm := &fakePHPFPMAdapter{}
+	svc := newTestService(t, withRunner(runner), withPHPFPM(phpfpm))
+	site := createTestSite(t, svc)
+	tmpDir := filepath.Join(svc.webRoot, "test.example.com", "tmp")
+	if info, err := os.Stat(tmpDir); err != nil || info.Mode().Perm() != 0o700 {
+		t.Fatalf("expected private site tmp dir, got info=%v err=%v", info, err)
+	}
+	if pool := phpfpm.writeCalls[0]; pool.TmpDir != tmpDir || pool.RelaxOpenBasedir {
+		t.Fatalf("unexpected pool config: %+v", pool)
+	}
+
+	isolation, err := svc.SetSiteIsolation(ctx, site.ID, SiteIsolationRequest{OpenBasedirRelaxed: true})
+	if err != nil {
+		t.Fatalf("relax open_basedir: %v", err)
+	}
+	if !isolation.OpenBasedirRelaxed || isolation.OpenBasedir != filepath.Join(svc.webRoot, "test.example.com")+":/tmp" {
+		t.Fatalf("unexpected isolation: %+v", isolation)
+	}
+	if pool := phpfpm.writeCalls[len(phpfpm.writeCalls)-1]; !pool.RelaxOpenBasedir {
+		t.Fatalf("expected relaxed pool, got %+v", pool)
+	}
+	if site, err = svc.GetSite(ctx, site.ID); err != nil || !site.OpenBasedirRelaxed {
+		t.Fatalf("expected relaxed flag stored, got %+v (%v)", site, err)
+	}
+
+	// Sites provisioned before tmp dirs existed get one on the next rewrite.
+	if err := os.Remove(tmpDir); err != nil {
+		t.Fatalf("remove tmp dir: %v", err)
+	}
+	if _, err := svc.SetSiteIsolation(ctx, site.ID, SiteIsolationRequest{}); err != nil {
+		t.Fatalf("restore open_basedir: %v", err)
+	}
+	if _, err := os.Stat(tmpDir); err != nil {
+		t.Fatalf("expected tmp dir recreated: %v", err)
+	}
+	if !containsCommand(runner.commands, "chown site_test_example_com:site_test_example_com "+tmpDir) {
+		t.Fatalf("expected tmp dir chown, got %v", runner.commands)
+	}
+}
@@ -0,0 +1,171 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/robsonek/aiPanel/pkg/adapter"
+)
+
+const (
+	maxCPUQuotaPercent = 100 * 256
+	minMemoryMaxMB     = 64
+	maxMemoryMaxMB     = 1024 * 1024
+	minTasksMax        = 8
+	maxTasksMax        = 1 << 20
+)
+
+// ErrSiteLimitsNotSet indicates a site running without resource limits.
+var ErrSiteLimitsNotSet = errors.New("resource limits are not set for this site")
+
+// SiteLimits caps the CPU, memory and process count of a site. The PHP
+// pool and cron jobs of the site run in Slice. Zero fields are unlimited;
+// CPUQuotaPercent is relative to one core, so 200 allows two full cores.
+type SiteLimits struct {
+	SiteID          int64     `json:"site_id"`
+	CPUQuotaPercent int       `json:"cpu_quota_percent"`
+	MemoryMaxMB     int       `json:"memory_max_mb"`
+	TasksMax        int       `json:"tasks_max"`
+	Slice           string    `json:"slice"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// SiteLimitsRequest sets the resource limits of a site.
+type SiteLimitsRequest struct {
+	CPUQuotaPercent int    `json:"cpu_quota_percent"`
+	MemoryMaxMB     int    `json:"memory_max_mb"`
+	TasksMax        int    `json:"tasks_max"`
+	Actor           string `json:"-"`
+}
+
+// GetSiteLimits returns the resource limits of a site.
+func (s *Service) GetSiteLimits(ctx context.Context, siteID int64) (SiteLimits, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return SiteLimits{}, err
+	}
+	return s.siteLimits(ctx, site)
+}
+
+// SetSiteLimits stores the limits and moves the site PHP pool into its own
+// master inside the site slice.
+func (s *Service) SetSiteLimits(ctx context.Context, siteID int64, req SiteLimitsRequest) (SiteLimits, error) {
+	if s.store == nil || s.nginx == nil || s.phpfpm == nil {
+		return SiteLimits{}, fmt.Errorf("hosting service is not fully configured")
+	}
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return SiteLimits{}, err
+	}
+	if err := validateSiteLimits(req); err != nil {
+		return SiteLimits{}, err
+	}
+	previous, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return SiteLimits{}, err
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO site_limits(site_id, cpu_quota_percent, memory_max_mb, tasks_max, updated_at)
+VALUES(%d,%d,%d,%d,%d)
+ON CONFLICT(site_id) DO UPDATE SET cpu_quota_percent=excluded.cpu_quota_percent, memory_max_mb=excluded.memory_max_mb,
+  tasks_max=excluded.tasks_max, updated_at=MAX(excluded.updated_at, site_limits.updated_at + 1);`,
+		siteID, req.CPUQuotaPercent, req.MemoryMaxMB, req.TasksMax, time.Now().Unix())); err != nil {
+		return SiteLimits{}, fmt.Errorf("save site limits: %w", err)
+	}
+	next, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return SiteLimits{}, err
+	}
+	if err := s.applySiteConfig(ctx, previous, next); err != nil {
+		return SiteLimits{}, err
+	}
+	_ = s.writeAudit(ctx, req.Actor, "hosting.limits.set", fmt.Sprintf("domain=%s cpu=%d%% memory=%dM tasks=%d",
+		site.Domain, req.CPUQuotaPercent, req.MemoryMaxMB, req.TasksMax))
+	return s.siteLimits(ctx, site)
+}
+
+// DeleteSiteLimits lifts the limits of a site; its pool moves back to the
+// shared PHP-FPM master.
+func (s *Service) DeleteSiteLimits(ctx context.Context, siteID int64, actor string) error {
+	if s.store == nil || s.nginx == nil || s.phpfpm == nil {
+		return fmt.Errorf("hosting service is not fully configured")
+	}
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.siteLimits(ctx, site); err != nil {
+		return err
+	}
+	previous, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return err
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf("DELETE FROM site_limits WHERE site_id = %d;", siteID)); err != nil {
+		return fmt.Errorf("delete site limits: %w", err)
+	}
+	next, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return err
+	}
+	if err := s.applySiteConfig(ctx, previous, next); err != nil {
+		return err
+	}
+	_ = s.writeAudit(ctx, actor, "hosting.limits.delete", "domain="+site.Domain)
+	return nil
+}
+
+func (s *Service) siteLimits(ctx context.Context, site Site) (SiteLimits, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT cpu_quota_percent, memory_max_mb, tasks_max, updated_at
+FROM site_limits
+WHERE site_id = %d;`, site.ID))
+	if err != nil {
+		return SiteLimits{}, fmt.Errorf("get site limits: %w", err)
+	}
+	if len(rows) == 0 {
+		return SiteLimits{}, ErrSiteLimitsNotSet
+	}
+	fields := map[string]int64{}
+	for _, key := range []string{"cpu_quota_percent", "memory_max_mb", "tasks_max", "updated_at"} {
+		v, err := toInt64(rows[0][key])
+		if err != nil {
+			return SiteLimits{}, fmt.Errorf("parse site limits %s: %w", key, err)
+		}
+		fields[key] = v
+	}
+	return SiteLimits{
+		SiteID:          site.ID,
+		CPUQuotaPercent: int(fields["cpu_quota_percent"]),
+		MemoryMaxMB:     int(fields["memory_max_mb"]),
+		TasksMax:        int(fields["tasks_max"]),
+		Slice:           SiteSlice(site.Domain),
+		UpdatedAt:       time.Unix(fields["updated_at"], 0).UTC(),
+	}, nil
+}
+
+func (limits SiteLimits) resourceLimits() *adapter.ResourceLimits {
+	return &adapter.ResourceLimits{
+		CPUQuotaPercent: limits.CPUQuotaPercent,
+		MemoryMaxMB:     limits.MemoryMaxMB,
+		TasksMax:        limits.TasksMax,
+	}
+}
+
+func validateSiteLimits(req SiteLimitsRequest) error {
+	if req.CPUQuotaPercent == 0 && req.MemoryMaxMB == 0 && req.TasksMax == 0 {
+		return fmt.Errorf("at least one limit is required")
+	}
+	if req.CPUQuotaPercent < 0 || req.CPUQuotaPercent > maxCPUQuotaPercent {
+		return fmt.Errorf("invalid cpu_quota_percent: must be between 1 and %d", maxCPUQuotaPercent)
+	}
+	if req.MemoryMaxMB != 0 && (req.MemoryMaxMB < minMemoryMaxMB || req.MemoryMaxMB > maxMemoryMaxMB) {
+		return fmt.Errorf("invalid memory_max_mb: must be between %d and %d", minMemoryMaxMB, maxMemoryMaxMB)
+	}
+	if req.TasksMax != 0 && (req.TasksMax < minTasksMax || req.TasksMax > maxTasksMax) {
+		return fmt.Errorf("invalid tasks_max: must be between %d and %d", minTasksMax, maxTasksMax)
+	}
+	return nil
+}
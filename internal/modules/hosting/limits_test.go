@@ -0,0 +1,69 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/pkg/adapter"
+)
+
+func TestService_SiteLimits(t *testing.T) {
+	ctx := context.Background()
+	runner := newSiteUserRunner()
+	nginx := &fakeNginxAdapter{}
+	phpfpm := &fakePHPFPMAdapter{}
+	svc := newTestService(t, withRunner(runner), withNginx(nginx), withPHPFPM(phpfpm))
+	site := createTestSite(t, svc)
+
+	if _, err := svc.GetSiteLimits(ctx, site.ID); !errors.Is(err, ErrSiteLimitsNotSet) {
+		t.Fatalf("expected ErrSiteLimitsNotSet, got %v", err)
+	}
+	if _, err := svc.SetSiteLimits(ctx, site.ID, SiteLimitsRequest{}); err == nil || !strings.Contains(err.Error(), "required") {
+		t.Fatalf("expected empty limits to be rejected, got %v", err)
+	}
+	if _, err := svc.SetSiteLimits(ctx, site.ID, SiteLimitsRequest{MemoryMaxMB: 16}); err == nil || !strings.Contains(err.Error(), "invalid memory_max_mb") {
+		t.Fatalf("expected tiny memory limit to be rejected, got %v", err)
+	}
+
+	limits, err := svc.SetSiteLimits(ctx, site.ID, SiteLimitsRequest{CPUQuotaPercent: 150, MemoryMaxMB: 1024, TasksMax: 256})
+	if err != nil {
+		t.Fatalf("set limits: %v", err)
+	}
+	if limits.Slice != "aipanel-site-test-example-com.slice" || limits.CPUQuotaPercent != 150 {
+		t.Fatalf("unexpected limits: %+v", limits)
+	}
+	pool := phpfpm.writeCalls[len(phpfpm.writeCalls)-1]
+	if pool.Limits == nil || *pool.Limits != (adapter.ResourceLimits{CPUQuotaPercent: 150, MemoryMaxMB: 1024, TasksMax: 256}) {
+		t.Fatalf("unexpected pool limits: %+v", pool.Limits)
+	}
+
+	queue := jobqueue.New(svc.store, nil)
+	svc.RegisterJobs(queue)
+	cron, err := svc.CreateCronJob(ctx, site.ID, CronJobRequest{Command: "php cron.php", IntervalMinutes: 5})
+	if err != nil {
+		t.Fatalf("create cron job: %v", err)
+	}
+	if _, err := svc.TriggerCronJob(ctx, site.ID, cron.ID, "admin@example.com"); err != nil {
+		t.Fatalf("trigger cron job: %v", err)
+	}
+	if _, err := queue.RunPending(ctx); err != nil {
+		t.Fatalf("run cron job: %v", err)
+	}
+	want := "systemd-run --quiet --scope --slice=aipanel-site-test-example-com.slice runuser -u " + site.SystemUser + " -- /bin/sh -c cd '" + site.RootDir + "' && php cron.php"
+	if !containsCommand(runner.commands, want) {
+		t.Fatalf("expected cron job to run in the site slice, got %v", runner.commands)
+	}
+
+	if err := svc.DeleteSiteLimits(ctx, site.ID, "admin@example.com"); err != nil {
+		t.Fatalf("delete limits: %v", err)
+	}
+	if pool := phpfpm.writeCalls[len(phpfpm.writeCalls)-1]; pool.Limits != nil {
+		t.Fatalf("expected limits removed from pool, got %+v", pool.Limits)
+	}
+	if err := svc.DeleteSiteLimits(ctx, site.ID, "admin@example.com"); !errors.Is(err, ErrSiteLimitsNotSet) {
+		t.Fatalf("expected ErrSiteLimitsNotSet on second delete, got %v", err)
+	}
+}
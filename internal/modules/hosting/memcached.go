@@ -0,0 +1,315 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/systemd"
+)
+
+const (
+	defaultMemcachedBinary         = "/opt/aipanel/runtime/memcached/current/bin/memcached"
+	defaultMemcachedMemoryMB       = 64
+	minMemcachedMemoryMB           = 8
+	maxMemcachedMemoryMB           = 64 * 1024
+	defaultMemcachedMaxConnections = 256
+	maxMemcachedMaxConnections     = 65536
+	// memcachedOverheadMB is allowed on top of the item memory for
+	// connection buffers and the hash table before systemd kills the
+	// instance.
+	memcachedOverheadMB = 32
+)
+
+var (
+	// ErrMemcachedNotEnabled indicates a site without its own memcached.
+	ErrMemcachedNotEnabled = errors.New("memcached is not enabled for this site")
+	// ErrMemcachedNotInstalled indicates the memcached runtime component
+	// has not been built on this server.
+	ErrMemcachedNotInstalled = errors.New("memcached runtime is not installed")
+)
+
+// SiteMemcached is the memcached instance of a site. It listens only on
+// Socket, inside the site home, and runs as the site user in the site
+// slice, so its memory counts against the site limits.
+type SiteMemcached struct {
+	SiteID         int64     `json:"site_id"`
+	MemoryMB       int       `json:"memory_mb"`
+	MaxConnections int       `json:"max_connections"`
+	Socket         string    `json:"socket"`
+	Unit           string    `json:"unit"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// SiteMemcachedRequest enables or resizes the memcached of a site. Zero
+// fields use the defaults of 64 MB and 256 connections.
+type SiteMemcachedRequest struct {
+	MemoryMB       int    `json:"memory_mb"`
+	MaxConnections int    `json:"max_connections"`
+	Actor          string `json:"-"`
+}
+
+// GetSiteMemcached returns the memcached instance of a site.
+func (s *Service) GetSiteMemcached(ctx context.Context, siteID int64) (SiteMemcached, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return SiteMemcached{}, err
+	}
+	return s.siteMemcached(ctx, site)
+}
+
+// SetSiteMemcached stores the instance settings and (re)starts the
+// memcached of a site. Enabling it also rewrites the PHP pool so the
+// MEMCACHED_* variables reach the site; a resize restarts the instance
+// and drops what it held.
+func (s *Service) SetSiteMemcached(ctx context.Context, siteID int64, req SiteMemcachedRequest) (SiteMemcached, error) {
+	if s.store == nil || s.nginx == nil || s.phpfpm == nil {
+		return SiteMemcached{}, fmt.Errorf("hosting service is not fully configured")
+	}
+	if req.MemoryMB == 0 {
+		req.MemoryMB = defaultMemcachedMemoryMB
+	}
+	if req.MaxConnections == 0 {
+		req.MaxConnections = defaultMemcachedMaxConnections
+	}
+	if req.MemoryMB < minMemcachedMemoryMB || req.MemoryMB > maxMemcachedMemoryMB {
+		return SiteMemcached{}, fmt.Errorf("invalid memory_mb: must be between %d and %d", minMemcachedMemoryMB, maxMemcachedMemoryMB)
+	}
+	if req.MaxConnections < 1 || req.MaxConnections > maxMemcachedMaxConnections {
+		return SiteMemcached{}, fmt.Errorf("invalid max_connections: must be between 1 and %d", maxMemcachedMaxConnections)
+	}
+	if _, err := os.Stat(s.memcachedBinary); err != nil {
+		return SiteMemcached{}, fmt.Errorf("%w: %s", ErrMemcachedNotInstalled, s.memcachedBinary)
+	}
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return SiteMemcached{}, err
+	}
+	current, err := s.siteMemcached(ctx, site)
+	enabled := err == nil
+	if err != nil && !errors.Is(err, ErrMemcachedNotEnabled) {
+		return SiteMemcached{}, err
+	}
+	previous, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return SiteMemcached{}, err
+	}
+	if err := s.saveSiteMemcached(ctx, siteID, req.MemoryMB, req.MaxConnections); err != nil {
+		return SiteMemcached{}, err
+	}
+	// restore puts the previous instance back, or removes the new one.
+	restore := func() {
+		if enabled {
+			_ = s.saveSiteMemcached(ctx, siteID, current.MemoryMB, current.MaxConnections)
+			if err := s.startMemcached(ctx, site, current); err != nil {
+				s.log.Warn("restore memcached", "domain", site.Domain, "error", err.Error())
+			}
+			return
+		}
+		_ = s.store.ExecPanel(ctx, fmt.Sprintf("DELETE FROM site_memcached WHERE site_id = %d;", siteID))
+		_ = s.removeMemcachedUnit(ctx, site)
+	}
+	instance, err := s.siteMemcached(ctx, site)
+	if err != nil {
+		restore()
+		return SiteMemcached{}, err
+	}
+	if err := s.startMemcached(ctx, site, instance); err != nil {
+		restore()
+		return SiteMemcached{}, err
+	}
+	if !enabled {
+		next, err := s.siteConfig(ctx, site)
+		if err != nil {
+			restore()
+			return SiteMemcached{}, err
+		}
+		if err := s.applySiteConfig(ctx, previous, next); err != nil {
+			restore()
+			return SiteMemcached{}, err
+		}
+	}
+	_ = s.writeAudit(ctx, req.Actor, "hosting.memcached.set", fmt.Sprintf("domain=%s memory_mb=%d max_connections=%d", site.Domain, req.MemoryMB, req.MaxConnections))
+	return instance, nil
+}
+
+// DeleteSiteMemcached stops the memcached of a site, removes its unit and
+// socket and drops the MEMCACHED_* variables from the PHP pool.
+func (s *Service) DeleteSiteMemcached(ctx context.Context, siteID int64, actor string) error {
+	if s.store == nil || s.nginx == nil || s.phpfpm == nil {
+		return fmt.Errorf("hosting service is not fully configured")
+	}
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.siteMemcached(ctx, site); err != nil {
+		return err
+	}
+	previous, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return err
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf("DELETE FROM site_memcached WHERE site_id = %d;", siteID)); err != nil {
+		return fmt.Errorf("delete site memcached: %w", err)
+	}
+	next, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return err
+	}
+	if err := s.applySiteConfig(ctx, previous, next); err != nil {
+		return err
+	}
+	if err := s.removeMemcachedUnit(ctx, site); err != nil {
+		return err
+	}
+	_ = os.RemoveAll(memcachedDir(site))
+	_ = s.writeAudit(ctx, actor, "hosting.memcached.delete", "domain="+site.Domain)
+	return nil
+}
+
+// startMemcached writes the unit of the site instance and restarts it.
+func (s *Service) startMemcached(ctx context.Context, site Site, instance SiteMemcached) error {
+	dir := memcachedDir(site)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("create memcached dir: %w", err)
+	}
+	if _, err := s.runner.Run(ctx, "chown", site.SystemUser+":"+site.SystemUser, dir); err != nil {
+		return fmt.Errorf("chown memcached dir: %w", err)
+	}
+	if err := os.MkdirAll(s.systemdUnitDir, 0o755); err != nil {
+		return fmt.Errorf("create systemd unit dir: %w", err)
+	}
+	unit := memcachedUnit(site.Domain)
+	if err := os.WriteFile(filepath.Join(s.systemdUnitDir, unit), []byte(s.renderMemcachedUnit(site, instance)), 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", unit, err)
+	}
+	if err := systemd.DaemonReload(ctx, s.runner); err != nil {
+		return fmt.Errorf("systemd daemon-reload: %w", err)
+	}
+	if _, err := s.runner.Run(ctx, "systemctl", "enable", unit); err != nil {
+		return fmt.Errorf("enable %s: %w", unit, err)
+	}
+	if _, err := s.runner.Run(ctx, "systemctl", "restart", unit); err != nil {
+		return fmt.Errorf("restart %s: %w", unit, err)
+	}
+	return nil
+}
+
+// removeMemcachedUnit stops and removes the memcached unit of a site, if
+// any.
+func (s *Service) removeMemcachedUnit(ctx context.Context, site Site) error {
+	unit := memcachedUnit(site.Domain)
+	unitPath := filepath.Join(s.systemdUnitDir, unit)
+	if _, err := os.Stat(unitPath); os.IsNotExist(err) {
+		return nil
+	}
+	_, _ = s.runner.Run(ctx, "systemctl", "disable", "--now", unit)
+	if err := os.Remove(unitPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove %s: %w", unit, err)
+	}
+	if err := systemd.DaemonReload(ctx, s.runner); err != nil {
+		return fmt.Errorf("systemd daemon-reload: %w", err)
+	}
+	return nil
+}
+
+// renderMemcachedUnit runs memcached on a unix socket only (-s disables
+// TCP and UDP), readable by the site user alone. MemoryMax leaves room
+// for connection buffers on top of the item memory.
+func (s *Service) renderMemcachedUnit(site Site, instance SiteMemcached) string {
+	return strings.Join([]string{
+		"[Unit]",
+		"Description=aiPanel memcached for " + site.Domain,
+		"After=network.target",
+		"",
+		"[Service]",
+		"Type=simple",
+		"User=" + site.SystemUser,
+		"Group=" + site.SystemUser,
+		"Slice=" + SiteSlice(site.Domain),
+		fmt.Sprintf("ExecStart=%s -s %s -a 0700 -m %d -c %d", s.memcachedBinary, instance.Socket, instance.MemoryMB, instance.MaxConnections),
+		fmt.Sprintf("MemoryMax=%dM", instance.MemoryMB+memcachedOverheadMB),
+		"PrivateTmp=yes",
+		"NoNewPrivileges=yes",
+		"Restart=on-failure",
+		"RestartSec=2",
+		"",
+		"[Install]",
+		"WantedBy=multi-user.target",
+		"",
+	}, "\n")
+}
+
+func (s *Service) saveSiteMemcached(ctx context.Context, siteID int64, memoryMB, maxConnections int) error {
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO site_memcached(site_id, memory_mb, max_connections, updated_at)
+VALUES(%d,%d,%d,%d)
+ON CONFLICT(site_id) DO UPDATE SET memory_mb=excluded.memory_mb, max_connections=excluded.max_connections,
+  updated_at=MAX(excluded.updated_at, site_memcached.updated_at + 1);`,
+		siteID, memoryMB, maxConnections, time.Now().Unix())); err != nil {
+		return fmt.Errorf("save site memcached: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) siteMemcached(ctx context.Context, site Site) (SiteMemcached, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT memory_mb, max_connections, updated_at
+FROM site_memcached
+WHERE site_id = %d;`, site.ID))
+	if err != nil {
+		return SiteMemcached{}, fmt.Errorf("get site memcached: %w", err)
+	}
+	if len(rows) == 0 {
+		return SiteMemcached{}, ErrMemcachedNotEnabled
+	}
+	memoryMB, err := toInt64(rows[0]["memory_mb"])
+	if err != nil {
+		return SiteMemcached{}, fmt.Errorf("parse site memcached memory_mb: %w", err)
+	}
+	maxConnections, err := toInt64(rows[0]["max_connections"])
+	if err != nil {
+		return SiteMemcached{}, fmt.Errorf("parse site memcached max_connections: %w", err)
+	}
+	updatedAt, err := toInt64(rows[0]["updated_at"])
+	if err != nil {
+		return SiteMemcached{}, fmt.Errorf("parse site memcached updated_at: %w", err)
+	}
+	return SiteMemcached{
+		SiteID:         site.ID,
+		MemoryMB:       int(memoryMB),
+		MaxConnections: int(maxConnections),
+		Socket:         memcachedSocket(site),
+		Unit:           memcachedUnit(site.Domain),
+		UpdatedAt:      time.Unix(updatedAt, 0).UTC(),
+	}, nil
+}
+
+// env returns the variables exposed to the site PHP pool. Laravel reads
+// MEMCACHED_HOST with a zero port as a socket path.
+func (instance SiteMemcached) env() map[string]string {
+	return map[string]string{
+		"MEMCACHED_SOCKET": instance.Socket,
+		"MEMCACHED_HOST":   instance.Socket,
+		"MEMCACHED_PORT":   "0",
+	}
+}
+
+// memcachedDir is where the memcached socket of a site lives, next to the
+// docroot in the site home.
+func memcachedDir(site Site) string {
+	return filepath.Join(filepath.Dir(site.RootDir), "memcached")
+}
+
+func memcachedSocket(site Site) string {
+	return filepath.Join(memcachedDir(site), "memcached.sock")
+}
+
+func memcachedUnit(domain string) string {
+	return "aipanel-memcached-" + sanitizeToken(domain) + ".service"
+}
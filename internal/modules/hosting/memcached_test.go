@@ -0,0 +1,100 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newMemcachedService(t *testing.T) (*Service, *fakeRunner, *fakePHPFPMAdapter, Site) {
+	t.Helper()
+	runner := newSiteUserRunner()
+	phpfpm := &fakePHPFPMAdapter{}
+	svc := newTestService(t, withRunner(runner), withPHPFPM(phpfpm))
+	svc.systemdUnitDir = t.TempDir()
+	svc.memcachedBinary = filepath.Join(t.TempDir(), "memcached")
+	return svc, runner, phpfpm, createTestSite(t, svc)
+}
+
+func TestService_SiteMemcached(t *testing.T) {
+	ctx := context.Background()
+	svc, runner, phpfpm, site := newMemcachedService(t)
+
+	if _, err := svc.SetSiteMemcached(ctx, site.ID, SiteMemcachedRequest{}); !errors.Is(err, ErrMemcachedNotInstalled) {
+		t.Fatalf("expected ErrMemcachedNotInstalled, got %v", err)
+	}
+	if err := os.WriteFile(svc.memcachedBinary, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatalf("write memcached binary: %v", err)
+	}
+	if _, err := svc.SetSiteMemcached(ctx, site.ID, SiteMemcachedRequest{MemoryMB: 4}); err == nil || !strings.Contains(err.Error(), "invalid memory_mb") {
+		t.Fatalf("expected a tiny instance rejected, got %v", err)
+	}
+	instance, err := svc.SetSiteMemcached(ctx, site.ID, SiteMemcachedRequest{MemoryMB: 128, Actor: "admin@example.com"})
+	if err != nil {
+		t.Fatalf("set memcached: %v", err)
+	}
+	socket := filepath.Join(filepath.Dir(site.RootDir), "memcached", "memcached.sock")
+	if instance.Socket != socket || instance.MaxConnections != defaultMemcachedMaxConnections || instance.Unit != "aipanel-memcached-test-example-com.service" {
+		t.Fatalf("unexpected instance: %+v", instance)
+	}
+	unit, err := os.ReadFile(filepath.Join(svc.systemdUnitDir, instance.Unit))
+	if err != nil {
+		t.Fatalf("read unit: %v", err)
+	}
+	for _, want := range []string{
+		"User=" + site.SystemUser,
+		"Slice=aipanel-site-test-example-com.slice",
+		"ExecStart=" + svc.memcachedBinary + " -s " + socket + " -a 0700 -m 128 -c 256",
+		"MemoryMax=160M",
+	} {
+		if !strings.Contains(string(unit), want+"\n") {
+			t.Fatalf("expected %q in unit:\n%s", want, unit)
+		}
+	}
+	if !containsCommand(runner.commands, "systemctl restart "+instance.Unit) {
+		t.Fatalf("expected the instance restarted, got %v", runner.commands)
+	}
+	if pool := phpfpm.writeCalls[len(phpfpm.writeCalls)-1]; pool.Env["MEMCACHED_SOCKET"] != socket || pool.Env["MEMCACHED_PORT"] != "0" {
+		t.Fatalf("expected the socket in the pool env, got %v", pool.Env)
+	}
+	env, err := svc.GetSiteEnv(ctx, site.ID)
+	if err != nil || env.Env["MEMCACHED_HOST"] != socket {
+		t.Fatalf("expected the socket in the site env, got %+v (%v)", env, err)
+	}
+
+	if err := svc.DeleteSiteMemcached(ctx, site.ID, "admin@example.com"); err != nil {
+		t.Fatalf("delete memcached: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(svc.systemdUnitDir, instance.Unit)); !os.IsNotExist(err) {
+		t.Fatalf("expected the unit removed, got %v", err)
+	}
+	if pool := phpfpm.writeCalls[len(phpfpm.writeCalls)-1]; pool.Env["MEMCACHED_SOCKET"] != "" {
+		t.Fatalf("expected the socket dropped from the pool env, got %v", pool.Env)
+	}
+	if _, err := svc.GetSiteMemcached(ctx, site.ID); !errors.Is(err, ErrMemcachedNotEnabled) {
+		t.Fatalf("expected ErrMemcachedNotEnabled, got %v", err)
+	}
+}
+
+func TestService_SiteEnvHidesSecrets(t *testing.T) {
+	ctx := context.Background()
+	svc, _, _, site := newMemcachedService(t)
+	if _, err := svc.SetSiteStorage(ctx, site.ID, SetSiteStorageRequest{
+		Endpoint: "https://s3.example.com", Bucket: "media-bucket", AccessKey: "AKIDEXAMPLE", SecretKey: "wJalrXUtnFEMI",
+	}); err != nil {
+		t.Fatalf("set storage: %v", err)
+	}
+	env, err := svc.GetSiteEnv(ctx, site.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	if env.Env["AWS_BUCKET"] != "media-bucket" || strings.Join(env.Hidden, ",") != "AWS_SECRET_ACCESS_KEY" {
+		t.Fatalf("unexpected env: %+v", env)
+	}
+	if _, ok := env.Env["AWS_SECRET_ACCESS_KEY"]; ok {
+		t.Fatalf("expected the secret key left out, got %+v", env.Env)
+	}
+}
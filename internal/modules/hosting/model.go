@@ -12,11 +12,32 @@ type Site struct {
 	Status     string    `json:"status"`
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
+	// OpenBasedirRelaxed lets PHP open files anywhere in the site home and
+	// the shared /tmp instead of only the docroot and the site tmp dir.
+	OpenBasedirRelaxed bool `json:"open_basedir_relaxed"`
+	// CanonicalHost is "www", "apex" or empty; the other name redirects to it.
+	CanonicalHost string `json:"canonical_host"`
+	// HealthStatus is the result of the last health check ("pass",
+	// "fail"), empty when the site was never checked.
+	HealthStatus string `json:"health_status"`
 }
 
 // CreateSiteRequest contains data needed to create a site.
 type CreateSiteRequest struct {
 	Domain     string `json:"domain"`
 	PHPVersion string `json:"php_version"`
-	Actor      string `json:"-"`
+	// Cloudflare creates DNS records for the domain in its Cloudflare zone.
+	Cloudflare        bool `json:"cloudflare"`
+	CloudflareProxied bool `json:"cloudflare_proxied"`
+	// CanonicalHost serves the site on www or the apex and redirects the
+	// other name to it; empty serves Domain only.
+	CanonicalHost string `json:"canonical_host"`
+	Actor         string `json:"-"`
+}
+
+// PHPVersions lists installed PHP versions for the site form.
+type PHPVersions struct {
+	Versions []string `json:"versions"`
+	// Default is used when a site is created without a PHP version.
+	Default string `json:"default"`
 }
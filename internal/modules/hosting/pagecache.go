@@ -0,0 +1,296 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/pkg/adapter"
+)
+
+const (
+	defaultPageCacheDir = "/var/cache/nginx/aipanel"
+	defaultPageCacheTTL = 600
+	maxPageCacheTTL     = 7 * 24 * 3600
+	// varnishPurgeTimeout bounds the PURGE request sent to varnishd.
+	varnishPurgeTimeout = 10 * time.Second
+)
+
+// ErrPageCacheNotEnabled indicates a site served without a page cache.
+var ErrPageCacheNotEnabled = errors.New("page cache is not enabled for this site")
+
+// SitePageCache is the full-page cache of a site. Backend is "fastcgi"
+// (nginx caches PHP responses itself) or "varnish" (nginx proxies the
+// site through varnishd). Responses carry X-Cache-Status.
+type SitePageCache struct {
+	SiteID     int64     `json:"site_id"`
+	Backend    string    `json:"backend"`
+	TTLSeconds int       `json:"ttl_seconds"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// SitePageCacheRequest enables or changes the page cache of a site; a zero
+// TTLSeconds uses the default of ten minutes.
+type SitePageCacheRequest struct {
+	Backend    string `json:"backend"`
+	TTLSeconds int    `json:"ttl_seconds"`
+	Actor      string `json:"-"`
+}
+
+// GetSitePageCache returns the page cache of a site.
+func (s *Service) GetSitePageCache(ctx context.Context, siteID int64) (SitePageCache, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return SitePageCache{}, err
+	}
+	return s.sitePageCache(ctx, site)
+}
+
+// SetSitePageCache stores the page cache settings and rewrites the vhost
+// of the site; nginx rejecting it restores the previous one. The cache
+// starts empty.
+func (s *Service) SetSitePageCache(ctx context.Context, siteID int64, req SitePageCacheRequest) (SitePageCache, error) {
+	if s.store == nil || s.nginx == nil || s.phpfpm == nil {
+		return SitePageCache{}, fmt.Errorf("hosting service is not fully configured")
+	}
+	req.Backend = strings.ToLower(strings.TrimSpace(req.Backend))
+	if req.TTLSeconds == 0 {
+		req.TTLSeconds = defaultPageCacheTTL
+	}
+	if req.Backend != adapter.PageCacheFastCGI && req.Backend != adapter.PageCacheVarnish {
+		return SitePageCache{}, fmt.Errorf("invalid backend: expected %s or %s", adapter.PageCacheFastCGI, adapter.PageCacheVarnish)
+	}
+	if req.TTLSeconds < 1 || req.TTLSeconds > maxPageCacheTTL {
+		return SitePageCache{}, fmt.Errorf("invalid ttl_seconds: must be between 1 and %d", maxPageCacheTTL)
+	}
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return SitePageCache{}, err
+	}
+	previous, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return SitePageCache{}, err
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO site_page_cache(site_id, backend, ttl_seconds, updated_at)
+VALUES(%d,'%s',%d,%d)
+ON CONFLICT(site_id) DO UPDATE SET backend=excluded.backend, ttl_seconds=excluded.ttl_seconds,
+  updated_at=MAX(excluded.updated_at, site_page_cache.updated_at + 1);`,
+		siteID, sqlEscape(req.Backend), req.TTLSeconds, time.Now().Unix())); err != nil {
+		return SitePageCache{}, fmt.Errorf("save site page cache: %w", err)
+	}
+	next, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return SitePageCache{}, err
+	}
+	if err := s.applySiteConfig(ctx, previous, next); err != nil {
+		s.restorePageCache(ctx, siteID, previous.PageCache)
+		return SitePageCache{}, err
+	}
+	if previous.PageCache != nil {
+		s.clearPageCache(ctx, site, previous.PageCache)
+	}
+	_ = s.writeAudit(ctx, req.Actor, "hosting.page_cache.set", fmt.Sprintf("domain=%s backend=%s ttl=%d", site.Domain, req.Backend, req.TTLSeconds))
+	return s.sitePageCache(ctx, site)
+}
+
+// DeleteSitePageCache turns the page cache of a site off and drops what
+// it holds.
+func (s *Service) DeleteSitePageCache(ctx context.Context, siteID int64, actor string) error {
+	if s.store == nil || s.nginx == nil || s.phpfpm == nil {
+		return fmt.Errorf("hosting service is not fully configured")
+	}
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.sitePageCache(ctx, site); err != nil {
+		return err
+	}
+	previous, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return err
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf("DELETE FROM site_page_cache WHERE site_id = %d;", siteID)); err != nil {
+		return fmt.Errorf("delete site page cache: %w", err)
+	}
+	next, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return err
+	}
+	if err := s.applySiteConfig(ctx, previous, next); err != nil {
+		s.restorePageCache(ctx, siteID, previous.PageCache)
+		return err
+	}
+	if previous.PageCache.Backend == adapter.PageCacheFastCGI {
+		_ = os.RemoveAll(previous.PageCache.Dir)
+	} else {
+		s.clearPageCache(ctx, site, previous.PageCache)
+	}
+	_ = s.writeAudit(ctx, actor, "hosting.page_cache.delete", "domain="+site.Domain)
+	return nil
+}
+
+// PurgePageCache drops every cached page of a site, e.g. at the end of a
+// deploy. Sites without a page cache return ErrPageCacheNotEnabled.
+func (s *Service) PurgePageCache(ctx context.Context, siteID int64, actor string) error {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return err
+	}
+	cfg, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return err
+	}
+	if cfg.PageCache == nil {
+		return ErrPageCacheNotEnabled
+	}
+	if err := s.purgePageCache(ctx, cfg); err != nil {
+		return err
+	}
+	_ = s.writeAudit(ctx, actor, "hosting.page_cache.purge", fmt.Sprintf("domain=%s backend=%s", site.Domain, cfg.PageCache.Backend))
+	return nil
+}
+
+// purgeAfterDeploy purges the page cache once the code of a site changed.
+// Failures only log: the deploy itself went through.
+func (s *Service) purgeAfterDeploy(ctx context.Context, site Site, actor string) {
+	err := s.PurgePageCache(ctx, site.ID, actor)
+	if err != nil && !errors.Is(err, ErrPageCacheNotEnabled) {
+		s.log.Warn("purge page cache after deploy", "domain", site.Domain, "error", err.Error())
+	}
+}
+
+func (s *Service) purgePageCache(ctx context.Context, cfg adapter.SiteConfig) error {
+	switch cfg.PageCache.Backend {
+	case adapter.PageCacheVarnish:
+		return s.purgeVarnish(ctx, cfg)
+	default:
+		return emptyDir(cfg.PageCache.Dir)
+	}
+}
+
+// purgeVarnish asks varnishd to ban every object of the site; the VCL
+// answers PURGE from loopback (see docs/installer-contract.md).
+func (s *Service) purgeVarnish(ctx context.Context, cfg adapter.SiteConfig) error {
+	host := cfg.ServerName
+	if host == "" {
+		host = cfg.Domain
+	}
+	req, err := http.NewRequestWithContext(ctx, "PURGE", "http://"+cfg.PageCache.VarnishAddr+"/", nil)
+	if err != nil {
+		return fmt.Errorf("purge varnish: %w", err)
+	}
+	req.Host = host
+	client := &http.Client{Timeout: varnishPurgeTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("purge varnish: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("purge varnish: %s answered %d: %s", cfg.PageCache.VarnishAddr, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// clearPageCache purges a cache the site no longer uses in that form.
+// Failures only log: stale entries expire by their TTL.
+func (s *Service) clearPageCache(ctx context.Context, site Site, cache *adapter.PageCache) {
+	cfg, err := s.siteConfig(ctx, site)
+	if err == nil {
+		cfg.PageCache = cache
+		err = s.purgePageCache(ctx, cfg)
+	}
+	if err != nil {
+		s.log.Warn("clear page cache", "domain", site.Domain, "backend", cache.Backend, "error", err.Error())
+	}
+}
+
+// restorePageCache puts the previous settings back after nginx rejected
+// the vhost rendered from the new ones.
+func (s *Service) restorePageCache(ctx context.Context, siteID int64, cache *adapter.PageCache) {
+	query := fmt.Sprintf("DELETE FROM site_page_cache WHERE site_id = %d;", siteID)
+	if cache != nil {
+		query = fmt.Sprintf(`
+INSERT INTO site_page_cache(site_id, backend, ttl_seconds, updated_at)
+VALUES(%d,'%s',%d,%d)
+ON CONFLICT(site_id) DO UPDATE SET backend=excluded.backend, ttl_seconds=excluded.ttl_seconds,
+  updated_at=MAX(excluded.updated_at, site_page_cache.updated_at + 1);`,
+			siteID, sqlEscape(cache.Backend), cache.TTLSeconds, time.Now().Unix())
+	}
+	if err := s.store.ExecPanel(ctx, query); err != nil {
+		s.log.Warn("restore site page cache", "site_id", siteID, "error", err.Error())
+	}
+}
+
+func (s *Service) sitePageCache(ctx context.Context, site Site) (SitePageCache, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT backend, ttl_seconds, updated_at
+FROM site_page_cache
+WHERE site_id = %d;`, site.ID))
+	if err != nil {
+		return SitePageCache{}, fmt.Errorf("get site page cache: %w", err)
+	}
+	if len(rows) == 0 {
+		return SitePageCache{}, ErrPageCacheNotEnabled
+	}
+	backend, _ := rows[0]["backend"].(string)
+	ttl, err := toInt64(rows[0]["ttl_seconds"])
+	if err != nil {
+		return SitePageCache{}, fmt.Errorf("parse site page cache ttl_seconds: %w", err)
+	}
+	updatedAt, err := toInt64(rows[0]["updated_at"])
+	if err != nil {
+		return SitePageCache{}, fmt.Errorf("parse site page cache updated_at: %w", err)
+	}
+	return SitePageCache{
+		SiteID:     site.ID,
+		Backend:    backend,
+		TTLSeconds: int(ttl),
+		UpdatedAt:  time.Unix(updatedAt, 0).UTC(),
+	}, nil
+}
+
+func (s *Service) pageCacheConfig(domain string, cache SitePageCache) *adapter.PageCache {
+	out := &adapter.PageCache{Backend: cache.Backend, TTLSeconds: cache.TTLSeconds}
+	switch cache.Backend {
+	case adapter.PageCacheVarnish:
+		out.VarnishAddr = s.cfg.VarnishAddr
+		out.BackendPort = s.cfg.VarnishBackendPort
+	default:
+		out.Dir = s.pageCacheSiteDir(domain)
+	}
+	return out
+}
+
+func (s *Service) pageCacheSiteDir(domain string) string {
+	return filepath.Join(s.pageCacheDir, domain)
+}
+
+// emptyDir removes the contents of dir but keeps dir itself, which nginx
+// created and owns.
+func emptyDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("purge page cache: %w", err)
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return fmt.Errorf("purge page cache: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/pkg/adapter"
+)
+
+func newPageCacheService(t *testing.T, cfg config.Config) (*Service, *fakeNginxAdapter, Site) {
+	t.Helper()
+	nginx := &fakeNginxAdapter{}
+	svc := newTestService(t, withConfig(cfg), withRunner(newSiteUserRunner()), withNginx(nginx))
+	svc.pageCacheDir = t.TempDir()
+	return svc, nginx, createTestSite(t, svc)
+}
+
+func TestService_SitePageCacheFastCGI(t *testing.T) {
+	ctx := context.Background()
+	svc, nginx, site := newPageCacheService(t, config.Config{})
+
+	if _, err := svc.GetSitePageCache(ctx, site.ID); !errors.Is(err, ErrPageCacheNotEnabled) {
+		t.Fatalf("expected ErrPageCacheNotEnabled, got %v", err)
+	}
+	if _, err := svc.SetSitePageCache(ctx, site.ID, SitePageCacheRequest{Backend: "redis"}); err == nil || !strings.Contains(err.Error(), "invalid backend") {
+		t.Fatalf("expected an unknown backend rejected, got %v", err)
+	}
+	cache, err := svc.SetSitePageCache(ctx, site.ID, SitePageCacheRequest{Backend: "fastcgi", Actor: "admin@example.com"})
+	if err != nil {
+		t.Fatalf("set page cache: %v", err)
+	}
+	if cache.Backend != adapter.PageCacheFastCGI || cache.TTLSeconds != defaultPageCacheTTL {
+		t.Fatalf("unexpected page cache: %+v", cache)
+	}
+	dir := filepath.Join(svc.pageCacheDir, "test.example.com")
+	vhost := nginx.writeCalls[len(nginx.writeCalls)-1]
+	if vhost.PageCache == nil || vhost.PageCache.Dir != dir || vhost.PageCache.TTLSeconds != defaultPageCacheTTL {
+		t.Fatalf("unexpected vhost page cache: %+v", vhost.PageCache)
+	}
+
+	entry := filepath.Join(dir, "a", "1b", "0c5e1b")
+	if err := os.MkdirAll(filepath.Dir(entry), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(entry, []byte("cached"), 0o600); err != nil {
+		t.Fatalf("write cache entry: %v", err)
+	}
+	if err := svc.PurgePageCache(ctx, site.ID, "deploy-token"); err != nil {
+		t.Fatalf("purge page cache: %v", err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Fatalf("expected an empty cache dir, got %v (%v)", entries, err)
+	}
+
+	if err := svc.DeleteSitePageCache(ctx, site.ID, "admin@example.com"); err != nil {
+		t.Fatalf("delete page cache: %v", err)
+	}
+	if vhost := nginx.writeCalls[len(nginx.writeCalls)-1]; vhost.PageCache != nil {
+		t.Fatalf("expected the cache removed from the vhost, got %+v", vhost.PageCache)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("expected the cache dir removed, got %v", err)
+	}
+	if err := svc.PurgePageCache(ctx, site.ID, "deploy-token"); !errors.Is(err, ErrPageCacheNotEnabled) {
+		t.Fatalf("expected ErrPageCacheNotEnabled on purge, got %v", err)
+	}
+}
+
+func TestService_SitePageCacheVarnishPurge(t *testing.T) {
+	ctx := context.Background()
+	var purges []string
+	varnish := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		purges = append(purges, r.Method+" "+r.Host)
+	}))
+	t.Cleanup(varnish.Close)
+	addr := strings.TrimPrefix(varnish.URL, "http://")
+	svc, nginx, site := newPageCacheService(t, config.Config{VarnishAddr: addr, VarnishBackendPort: 8088})
+
+	if _, err := svc.SetSitePageCache(ctx, site.ID, SitePageCacheRequest{Backend: "varnish", TTLSeconds: 300}); err != nil {
+		t.Fatalf("set page cache: %v", err)
+	}
+	vhost := nginx.writeCalls[len(nginx.writeCalls)-1]
+	if vhost.PageCache == nil || vhost.PageCache.VarnishAddr != addr || vhost.PageCache.BackendPort != 8088 {
+		t.Fatalf("unexpected vhost page cache: %+v", vhost.PageCache)
+	}
+	if err := svc.PurgePageCache(ctx, site.ID, "deploy-token"); err != nil {
+		t.Fatalf("purge page cache: %v", err)
+	}
+	if strings.Join(purges, ",") != "PURGE test.example.com" {
+		t.Fatalf("expected one PURGE for the site, got %v", purges)
+	}
+	rows, err := svc.store.QueryAuditJSON(ctx, "SELECT actor FROM audit_events WHERE action = 'hosting.page_cache.purge';")
+	if err != nil || len(rows) != 1 || rows[0]["actor"] != "deploy-token" {
+		t.Fatalf("expected a purge audit event, got %+v (%v)", rows, err)
+	}
+}
+
+func TestService_SitePageCacheRestoredWhenNginxRejectsVhost(t *testing.T) {
+	ctx := context.Background()
+	svc, nginx, site := newPageCacheService(t, config.Config{})
+	nginx.failTest = fmt.Errorf("nginx: [emerg] unknown directive \"fastcgi_cache_path\"")
+
+	if _, err := svc.SetSitePageCache(ctx, site.ID, SitePageCacheRequest{Backend: "fastcgi"}); err == nil {
+		t.Fatal("expected the rejected vhost reported")
+	}
+	if _, err := svc.GetSitePageCache(ctx, site.ID); !errors.Is(err, ErrPageCacheNotEnabled) {
+		t.Fatalf("expected the page cache left off, got %v", err)
+	}
+	if vhost := nginx.writeCalls[len(nginx.writeCalls)-1]; vhost.PageCache != nil {
+		t.Fatalf("expected the previous vhost restored, got %+v", vhost.PageCache)
+	}
+}
@@ -0,0 +1,721 @@
+package hosting
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/fastcgi"
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/pkg/adapter"
+)
+
+// UpgradePHPJob is the job type that moves sites to another PHP version.
+const UpgradePHPJob = "hosting.php.upgrade"
+
+// PHP upgrade states.
+const (
+	PHPUpgradeQueued    = "queued"
+	PHPUpgradeRunning   = "running"
+	PHPUpgradeCompleted = "completed"
+	PHPUpgradeFailed    = "failed"
+)
+
+// Outcomes of one site in a PHP upgrade.
+const (
+	// PHPSiteUpgraded sites serve from the new version; their old pool is gone.
+	PHPSiteUpgraded = "upgraded"
+	// PHPSiteRolledBack sites were cut over and moved back after a rise in
+	// 5xx answers or a failed HTTP check.
+	PHPSiteRolledBack = "rolled_back"
+	// PHPSiteUnhealthy sites failed the check of the new pool and were
+	// never cut over.
+	PHPSiteUnhealthy = "unhealthy"
+	// PHPSiteFailed sites hit an error; they stay on the old version.
+	PHPSiteFailed = "failed"
+)
+
+const (
+	defaultAccessLogDir = "/var/log/nginx"
+	// defaultCutoverWatch is how long a site is watched after its cutover
+	// before the old pool is removed.
+	defaultCutoverWatch = 2 * time.Minute
+	// defaultMaxErrorRate is the rise of the 5xx share, in percentage
+	// points over the share before the cutover, that rolls a site back.
+	defaultMaxErrorRate = 5.0
+	// cutoverMinErrors keeps a single failed request on a quiet site from
+	// rolling it back.
+	cutoverMinErrors = 3
+	// accessLogBaseline bounds how much of the log before the cutover sets
+	// the baseline 5xx share.
+	accessLogBaseline = 256 << 10
+)
+
+var (
+	// ErrPHPUpgradeNotFound indicates a missing php_upgrades row.
+	ErrPHPUpgradeNotFound = errors.New("php upgrade not found")
+	// ErrPHPUpgradeInProgress indicates another upgrade is queued or running.
+	ErrPHPUpgradeInProgress = errors.New("php upgrade already in progress")
+
+	// errCutoverStranded marks a cutover that failed and could not be
+	// undone: the vhost or the site row may still name the new version,
+	// so its pool has to stay.
+	errCutoverStranded = errors.New("cutover could not be rolled back")
+)
+
+// PHPUpgradeRequest starts a fleet-wide PHP upgrade.
+type PHPUpgradeRequest struct {
+	// To is the target major.minor version; empty picks the newest
+	// installed one (after the build, with Build).
+	To string `json:"to,omitempty"`
+	// From limits the upgrade to sites on this version.
+	From string `json:"from,omitempty"`
+	// SiteIDs limits the upgrade to these sites; empty means all.
+	SiteIDs []int64 `json:"site_ids,omitempty"`
+	// Build stages the PHP runtime pinned in the lock first.
+	Build bool `json:"build"`
+	// MaxErrorRate overrides defaultMaxErrorRate.
+	MaxErrorRate float64 `json:"max_error_rate,omitempty"`
+	Actor        string  `json:"-"`
+}
+
+// PHPUpgradeSite is the outcome of one site.
+type PHPUpgradeSite struct {
+	SiteID int64  `json:"site_id"`
+	Domain string `json:"domain"`
+	From   string `json:"from"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Requests and ErrorRate cover the watch after the cutover;
+	// BaselineRate is the 5xx share before it, in percent.
+	Requests     int     `json:"requests"`
+	ErrorRate    float64 `json:"error_rate"`
+	BaselineRate float64 `json:"baseline_rate"`
+}
+
+// PHPUpgrade is one fleet-wide upgrade and the sites it has handled so far.
+type PHPUpgrade struct {
+	ID        int64            `json:"id"`
+	JobID     int64            `json:"job_id"`
+	From      string           `json:"from,omitempty"`
+	To        string           `json:"to,omitempty"`
+	Status    string           `json:"status"`
+	Sites     []PHPUpgradeSite `json:"sites"`
+	Error     string           `json:"error,omitempty"`
+	Actor     string           `json:"actor"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+// PHPUpgradeProgress is an upgrade together with a chunk of its job log.
+type PHPUpgradeProgress struct {
+	Upgrade    PHPUpgrade `json:"upgrade"`
+	Log        string     `json:"log"`
+	NextOffset int64      `json:"next_offset"`
+}
+
+type phpUpgradePayload struct {
+	UpgradeID int64             `json:"upgrade_id"`
+	Request   PHPUpgradeRequest `json:"request"`
+	Actor     string            `json:"actor"`
+}
+
+// SetPHPStager sets how Build upgrades install the new PHP runtime next
+// to the active one (the version manager's StagePHP).
+func (s *Service) SetPHPStager(fn func(ctx context.Context, logw io.Writer) error) {
+	s.stagePHP = fn
+}
+
+// StartPHPUpgrade validates req and enqueues the upgrade. Only one upgrade
+// runs at a time.
+func (s *Service) StartPHPUpgrade(ctx context.Context, req PHPUpgradeRequest) (PHPUpgrade, error) {
+	if s.store == nil || s.nginx == nil || s.phpfpm == nil {
+		return PHPUpgrade{}, fmt.Errorf("hosting service is not fully configured")
+	}
+	if s.jobs == nil {
+		return PHPUpgrade{}, fmt.Errorf("job queue is not configured")
+	}
+	req.To = strings.TrimSpace(req.To)
+	req.From = strings.TrimSpace(req.From)
+	for _, v := range []string{req.To, req.From} {
+		if v != "" && !phpVersionPattern.MatchString(v) {
+			return PHPUpgrade{}, fmt.Errorf("invalid php version %q", v)
+		}
+	}
+	if req.MaxErrorRate < 0 || req.MaxErrorRate > 100 {
+		return PHPUpgrade{}, fmt.Errorf("invalid max_error_rate: must be between 0 and 100")
+	}
+	if req.Build && s.stagePHP == nil {
+		return PHPUpgrade{}, fmt.Errorf("invalid upgrade: php builds are not configured")
+	}
+	if !req.Build && req.To != "" {
+		versions, err := s.listPHPVersions(ctx)
+		if err != nil {
+			return PHPUpgrade{}, fmt.Errorf("list php versions: %w", err)
+		}
+		if !slices.Contains(versions, req.To) {
+			return PHPUpgrade{}, fmt.Errorf("php version %s is not installed", req.To)
+		}
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT id FROM php_upgrades WHERE status IN ('%s','%s') LIMIT 1;", PHPUpgradeQueued, PHPUpgradeRunning))
+	if err != nil {
+		return PHPUpgrade{}, fmt.Errorf("check php upgrades: %w", err)
+	}
+	if len(rows) > 0 {
+		return PHPUpgrade{}, fmt.Errorf("%w (upgrade %v)", ErrPHPUpgradeInProgress, rows[0]["id"])
+	}
+	now := time.Now().Unix()
+	rows, err = s.store.QueryPanelWriteJSON(ctx, fmt.Sprintf(`
+INSERT INTO php_upgrades(from_version, to_version, status, actor, created_at, updated_at)
+VALUES('%s','%s','%s','%s',%d,%d)
+RETURNING id;`, sqlEscape(req.From), sqlEscape(req.To), PHPUpgradeQueued, sqlEscape(req.Actor), now, now))
+	if err != nil {
+		return PHPUpgrade{}, fmt.Errorf("insert php upgrade: %w", err)
+	}
+	if len(rows) == 0 {
+		return PHPUpgrade{}, fmt.Errorf("insert php upgrade: no id returned")
+	}
+	id, err := toInt64(rows[0]["id"])
+	if err != nil {
+		return PHPUpgrade{}, fmt.Errorf("parse php upgrade id: %w", err)
+	}
+	jobID, err := s.jobs.Enqueue(ctx, UpgradePHPJob, phpUpgradePayload{UpgradeID: id, Request: req, Actor: req.Actor})
+	if err != nil {
+		_ = s.store.ExecPanel(ctx, fmt.Sprintf("DELETE FROM php_upgrades WHERE id = %d;", id))
+		return PHPUpgrade{}, err
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf("UPDATE php_upgrades SET job_id = %d WHERE id = %d;", jobID, id)); err != nil {
+		return PHPUpgrade{}, fmt.Errorf("record php upgrade job: %w", err)
+	}
+	_ = s.writeAudit(ctx, req.Actor, "hosting.php.upgrade_requested", fmt.Sprintf("upgrade_id=%d to=%s build=%t job_id=%d", id, req.To, req.Build, jobID))
+	return s.GetPHPUpgrade(ctx, id)
+}
+
+// ListPHPUpgrades returns the newest upgrades first.
+func (s *Service) ListPHPUpgrades(ctx context.Context, limit int) ([]PHPUpgrade, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT id, job_id, from_version, to_version, status, sites, error, actor, created_at, updated_at
+FROM php_upgrades
+ORDER BY id DESC
+LIMIT %d;`, limit))
+	if err != nil {
+		return nil, fmt.Errorf("list php upgrades: %w", err)
+	}
+	out := make([]PHPUpgrade, 0, len(rows))
+	for _, row := range rows {
+		u, err := phpUpgradeFromRow(row)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, nil
+}
+
+// GetPHPUpgrade returns one upgrade.
+func (s *Service) GetPHPUpgrade(ctx context.Context, id int64) (PHPUpgrade, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT id, job_id, from_version, to_version, status, sites, error, actor, created_at, updated_at
+FROM php_upgrades
+WHERE id = %d;`, id))
+	if err != nil {
+		return PHPUpgrade{}, fmt.Errorf("get php upgrade: %w", err)
+	}
+	if len(rows) == 0 {
+		return PHPUpgrade{}, ErrPHPUpgradeNotFound
+	}
+	return phpUpgradeFromRow(rows[0])
+}
+
+// PHPUpgradeProgress returns an upgrade and its job log from offset.
+func (s *Service) PHPUpgradeProgress(ctx context.Context, id, offset int64) (PHPUpgradeProgress, error) {
+	u, err := s.GetPHPUpgrade(ctx, id)
+	if err != nil {
+		return PHPUpgradeProgress{}, err
+	}
+	progress := PHPUpgradeProgress{Upgrade: u, NextOffset: offset}
+	if s.jobs != nil && u.JobID > 0 {
+		out, next, err := s.jobs.ReadLog(u.JobID, offset)
+		if err != nil {
+			return PHPUpgradeProgress{}, err
+		}
+		progress.Log, progress.NextOffset = out, next
+	}
+	return progress, nil
+}
+
+func (s *Service) runPHPUpgrade(ctx context.Context, job jobqueue.Job) error {
+	var payload phpUpgradePayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("decode php upgrade payload: %w", err)
+	}
+	logw, err := s.jobs.LogWriter(job.ID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = logw.Close()
+	}()
+	u, err := s.GetPHPUpgrade(ctx, payload.UpgradeID)
+	if err != nil {
+		return err
+	}
+	u.Status = PHPUpgradeRunning
+	if err := s.savePHPUpgrade(ctx, u); err != nil {
+		return err
+	}
+	err = s.upgradePHP(ctx, &u, payload.Request, logw)
+	// The outcome is recorded even when the job was cancelled.
+	ctx = context.WithoutCancel(ctx)
+	if err != nil {
+		u.Status, u.Error = PHPUpgradeFailed, err.Error()
+		_, _ = fmt.Fprintf(logw, "upgrade failed: %v\n", err)
+		_ = s.savePHPUpgrade(ctx, u)
+		_ = s.writeAudit(ctx, payload.Actor, "hosting.php.upgrade_failed", fmt.Sprintf("upgrade_id=%d to=%s", u.ID, u.To))
+		return err
+	}
+	u.Status = PHPUpgradeCompleted
+	if err := s.savePHPUpgrade(ctx, u); err != nil {
+		return err
+	}
+	counts := map[string]int{}
+	var rolledBack []string
+	for _, site := range u.Sites {
+		counts[site.Status]++
+		if site.Status == PHPSiteRolledBack {
+			rolledBack = append(rolledBack, site.Domain+": "+site.Detail)
+		}
+	}
+	_, _ = fmt.Fprintf(logw, "upgrade to php %s finished: %d upgraded, %d rolled back, %d unhealthy, %d failed\n",
+		u.To, counts[PHPSiteUpgraded], counts[PHPSiteRolledBack], counts[PHPSiteUnhealthy], counts[PHPSiteFailed])
+	_ = s.writeAudit(ctx, payload.Actor, "hosting.php.upgrade", fmt.Sprintf("upgrade_id=%d to=%s upgraded=%d rolled_back=%d unhealthy=%d failed=%d",
+		u.ID, u.To, counts[PHPSiteUpgraded], counts[PHPSiteRolledBack], counts[PHPSiteUnhealthy], counts[PHPSiteFailed]))
+	if len(rolledBack) > 0 && s.notify != nil {
+		body := fmt.Sprintf("The upgrade to PHP %s rolled back %d site(s), which stay on their previous version:\n\n%s\n",
+			u.To, len(rolledBack), strings.Join(rolledBack, "\n"))
+		if err := s.notify(ctx, fmt.Sprintf("PHP %s upgrade rolled back %d site(s)", u.To, len(rolledBack)), body); err != nil {
+			s.log.Warn("send php upgrade alert", "error", err.Error())
+		}
+	}
+	return nil
+}
+
+// upgradePHP stages the runtime if asked, then moves the selected sites one
+// at a time, recording each outcome on u as it goes.
+func (s *Service) upgradePHP(ctx context.Context, u *PHPUpgrade, req PHPUpgradeRequest, logw io.Writer) error {
+	if req.Build {
+		_, _ = fmt.Fprintln(logw, "staging the php runtime pinned in the lock")
+		if err := s.stagePHP(ctx, logw); err != nil {
+			return err
+		}
+		s.InvalidatePHPVersions()
+	}
+	versions, err := s.listPHPVersions(ctx)
+	if err != nil {
+		return fmt.Errorf("list php versions: %w", err)
+	}
+	if u.To == "" {
+		u.To = defaultPHPVersionOf(versions)
+	}
+	if !slices.Contains(versions, u.To) {
+		return fmt.Errorf("php version %s is not installed", u.To)
+	}
+	sites, err := s.ListSites(ctx)
+	if err != nil {
+		return err
+	}
+	// Oldest sites first, the order they were created in.
+	slices.Reverse(sites)
+	var todo []Site
+	for _, site := range sites {
+		if site.PHPVersion == u.To || (u.From != "" && site.PHPVersion != u.From) ||
+			(len(req.SiteIDs) > 0 && !slices.Contains(req.SiteIDs, site.ID)) {
+			continue
+		}
+		todo = append(todo, site)
+	}
+	_, _ = fmt.Fprintf(logw, "upgrading %d site(s) to php %s\n", len(todo), u.To)
+	if err := s.savePHPUpgrade(ctx, *u); err != nil {
+		return err
+	}
+	maxRate := req.MaxErrorRate
+	if maxRate == 0 {
+		maxRate = defaultMaxErrorRate
+	}
+	for _, site := range todo {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		res := s.upgradeSite(ctx, site, u.To, maxRate, logw)
+		_, _ = fmt.Fprintf(logw, "%s: %s %s\n", site.Domain, res.Status, res.Detail)
+		u.Sites = append(u.Sites, res)
+		if err := s.savePHPUpgrade(ctx, *u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upgradeSite moves one site blue/green: it starts a pool on the new
+// version next to the old one, checks it through its own socket, points
+// the vhost at it, and watches the access log. A rise in 5xx answers or a
+// failed HTTP check points the vhost back; otherwise the old pool goes.
+func (s *Service) upgradeSite(ctx context.Context, site Site, to string, maxRate float64, logw io.Writer) PHPUpgradeSite {
+	res := PHPUpgradeSite{SiteID: site.ID, Domain: site.Domain, From: site.PHPVersion}
+	fail := func(status string, err error) PHPUpgradeSite {
+		res.Status, res.Detail = status, err.Error()
+		return res
+	}
+	blue, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return fail(PHPSiteFailed, err)
+	}
+	green := blue
+	green.PHPVersion = to
+	greenSite := site
+	greenSite.PHPVersion = to
+
+	_, _ = fmt.Fprintf(logw, "%s: starting a php %s pool next to php %s\n", site.Domain, to, site.PHPVersion)
+	if err := s.phpfpm.WritePool(ctx, green); err != nil {
+		s.dropPool(ctx, site.Domain, to)
+		return fail(PHPSiteFailed, fmt.Errorf("write php-fpm pool: %w", err))
+	}
+	if err := s.phpfpm.Restart(ctx, to); err != nil {
+		s.dropPool(ctx, site.Domain, to)
+		return fail(PHPSiteFailed, fmt.Errorf("restart php-fpm %s: %w", to, err))
+	}
+	if probe := s.probeNewPool(ctx, greenSite); probe.Status != CheckPass {
+		s.dropPool(ctx, site.Domain, to)
+		return fail(PHPSiteUnhealthy, errors.New(probe.Detail))
+	}
+
+	logPath := filepath.Join(s.accessLogDir, site.Domain+".access.log")
+	offset := fileSize(logPath)
+	baseline, _ := scanAccessLog(logPath, max(0, offset-accessLogBaseline), offset)
+	res.BaselineRate = baseline.rate()
+	_, _ = fmt.Fprintf(logw, "%s: cutting over (5xx before: %.1f%%)\n", site.Domain, res.BaselineRate)
+	if err := s.cutover(ctx, site.ID, blue, green); err != nil {
+		if !errors.Is(err, errCutoverStranded) {
+			s.dropPool(ctx, site.Domain, to)
+		}
+		return fail(PHPSiteFailed, err)
+	}
+
+	// Everything past the cutover must finish even if the job is
+	// cancelled: the site is half-way and has to land on one side.
+	select {
+	case <-ctx.Done():
+	case <-time.After(s.cutoverWatch):
+	}
+	interrupted := ctx.Err() != nil
+	ctx = context.WithoutCancel(ctx)
+	after, _ := scanAccessLog(logPath, offset, -1)
+	res.Requests, res.ErrorRate = after.requests, after.rate()
+	reason := ""
+	switch {
+	case interrupted:
+		reason = "upgrade was cancelled during the watch"
+	case after.elevatedOver(res.BaselineRate, maxRate):
+		reason = fmt.Sprintf("5xx rate rose to %.1f%% of %d request(s) from %.1f%%", res.ErrorRate, after.requests, res.BaselineRate)
+	default:
+		if probe := s.probeHTTP(ctx, greenSite); probe.Status != CheckPass {
+			reason = probe.Detail
+		}
+	}
+	if reason != "" {
+		_, _ = fmt.Fprintf(logw, "%s: rolling back: %s\n", site.Domain, reason)
+		if err := s.cutover(ctx, site.ID, green, blue); err != nil {
+			return fail(PHPSiteFailed, fmt.Errorf("roll back after %s: %w", reason, err))
+		}
+		s.dropPool(ctx, site.Domain, to)
+		_ = s.writeAudit(ctx, "system", "hosting.php.rollback", fmt.Sprintf("domain=%s from=%s to=%s", site.Domain, to, site.PHPVersion))
+		res.Status, res.Detail = PHPSiteRolledBack, reason
+		return res
+	}
+	s.dropPool(ctx, site.Domain, site.PHPVersion)
+	_ = s.writeAudit(ctx, "system", "hosting.php.cutover", fmt.Sprintf("domain=%s from=%s to=%s", site.Domain, site.PHPVersion, to))
+	res.Status = PHPSiteUpgraded
+	res.Detail = fmt.Sprintf("%d request(s) watched, %.1f%% 5xx", after.requests, res.ErrorRate)
+	return res
+}
+
+// cutover points the vhost and the site row from one version to the
+// other. A step that fails restores the vhost and the row; when that
+// fails as well the error wraps errCutoverStranded.
+func (s *Service) cutover(ctx context.Context, siteID int64, from, to adapter.SiteConfig) error {
+	if err := s.nginx.WriteVhost(ctx, to); err != nil {
+		return s.restoreVhost(ctx, from, fmt.Errorf("write nginx vhost: %w", err))
+	}
+	if err := s.nginx.TestConfig(ctx); err != nil {
+		return s.restoreVhost(ctx, from, fmt.Errorf("test nginx config: %w", err))
+	}
+	if err := s.setSitePHPVersion(ctx, siteID, to.PHPVersion); err != nil {
+		return s.restoreVhost(ctx, from, err)
+	}
+	if err := s.nginx.Reload(ctx); err != nil {
+		cause := fmt.Errorf("reload nginx: %w", err)
+		if err := s.restoreVhost(ctx, from, cause); errors.Is(err, errCutoverStranded) {
+			return err
+		}
+		if err := s.setSitePHPVersion(ctx, siteID, from.PHPVersion); err != nil {
+			return fmt.Errorf("%w: %w (restore site: %v)", errCutoverStranded, cause, err)
+		}
+		return cause
+	}
+	return nil
+}
+
+// restoreVhost writes the from vhost back after a failed cutover step and
+// returns cause, wrapped in errCutoverStranded when the write fails too.
+func (s *Service) restoreVhost(ctx context.Context, from adapter.SiteConfig, cause error) error {
+	if err := s.nginx.WriteVhost(ctx, from); err != nil {
+		return fmt.Errorf("%w: %w (restore vhost: %v)", errCutoverStranded, cause, err)
+	}
+	return cause
+}
+
+func (s *Service) setSitePHPVersion(ctx context.Context, siteID int64, phpVersion string) error {
+	defer s.sitesCache.Purge()
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(
+		"UPDATE sites SET php_version = '%s', updated_at = MAX(%d, updated_at + 1) WHERE id = %d;",
+		sqlEscape(phpVersion), time.Now().Unix(), siteID)); err != nil {
+		return fmt.Errorf("update site php version: %w", err)
+	}
+	return nil
+}
+
+// dropPool removes the pool of a site on one version and restarts that
+// version's master so it lets go of the socket. Failures only log: the
+// vhost no longer points there.
+func (s *Service) dropPool(ctx context.Context, domain, phpVersion string) {
+	if err := s.phpfpm.RemovePool(ctx, domain, phpVersion); err != nil {
+		s.log.Warn("remove php-fpm pool", "domain", domain, "php_version", phpVersion, "error", err.Error())
+		return
+	}
+	if err := s.phpfpm.Restart(ctx, phpVersion); err != nil {
+		s.log.Warn("restart php-fpm", "php_version", phpVersion, "error", err.Error())
+	}
+}
+
+// probeNewPool pings the pool through its socket and, when the docroot
+// has an index.php, renders it there, retrying while the master starts.
+func (s *Service) probeNewPool(ctx context.Context, site Site) HealthProbe {
+	for attempt := 1; ; attempt++ {
+		probe := s.probePHPFPM(ctx, site)
+		if probe.Status == CheckPass {
+			probe = s.probePHPIndex(ctx, site)
+		}
+		if probe.Status == CheckPass || attempt == siteHealthAttempts {
+			return probe
+		}
+		select {
+		case <-ctx.Done():
+			return probe
+		case <-time.After(s.healthRetryDelay):
+		}
+	}
+}
+
+// probePHPIndex requests the site's index.php straight from its pool, so
+// a fatal error under the new version shows before any visitor sees it.
+func (s *Service) probePHPIndex(ctx context.Context, site Site) HealthProbe {
+	script := filepath.Join(site.RootDir, "index.php")
+	if _, err := os.Stat(script); err != nil {
+		return HealthProbe{Status: CheckPass, Detail: "no index.php to render"}
+	}
+	serverName, _, err := canonicalHosts(site.Domain, site.CanonicalHost)
+	if err != nil {
+		return HealthProbe{Status: CheckFail, Detail: err.Error()}
+	}
+	socket := s.siteSocket(site)
+	ctx, cancel := context.WithTimeout(ctx, siteProbeTimeout)
+	defer cancel()
+	resp, err := fastcgi.Do(ctx, "unix", socket, map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"REQUEST_METHOD":    http.MethodGet,
+		"REQUEST_URI":       "/",
+		"QUERY_STRING":      "",
+		"SCRIPT_NAME":       "/index.php",
+		"SCRIPT_FILENAME":   script,
+		"DOCUMENT_ROOT":     site.RootDir,
+		"DOCUMENT_URI":      "/index.php",
+		"SERVER_PROTOCOL":   "HTTP/1.1",
+		"SERVER_NAME":       serverName,
+		"SERVER_PORT":       "443",
+		"HTTPS":             "on",
+		"HTTP_HOST":         serverName,
+		"REMOTE_ADDR":       "127.0.0.1",
+	})
+	if err != nil {
+		return HealthProbe{Status: CheckFail, Detail: err.Error()}
+	}
+	detail := fmt.Sprintf("index.php answered status %d through %s", resp.Status, socket)
+	if resp.Status >= http.StatusInternalServerError {
+		if stderr := strings.TrimSpace(resp.Stderr); stderr != "" {
+			detail = failureDetail(errors.New(detail), stderr)
+		}
+		return HealthProbe{Status: CheckFail, Detail: detail}
+	}
+	return HealthProbe{Status: CheckPass, Detail: detail}
+}
+
+func (s *Service) savePHPUpgrade(ctx context.Context, u PHPUpgrade) error {
+	if u.Sites == nil {
+		u.Sites = []PHPUpgradeSite{}
+	}
+	sites, err := json.Marshal(u.Sites)
+	if err != nil {
+		return fmt.Errorf("encode php upgrade sites: %w", err)
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+UPDATE php_upgrades
+SET to_version = '%s', status = '%s', sites = '%s', error = '%s', updated_at = MAX(%d, updated_at + 1)
+WHERE id = %d;`, sqlEscape(u.To), sqlEscape(u.Status), sqlEscape(string(sites)), sqlEscape(u.Error), time.Now().Unix(), u.ID)); err != nil {
+		return fmt.Errorf("save php upgrade: %w", err)
+	}
+	return nil
+}
+
+func phpUpgradeFromRow(row map[string]any) (PHPUpgrade, error) {
+	var u PHPUpgrade
+	var err error
+	if u.ID, err = toInt64(row["id"]); err != nil {
+		return PHPUpgrade{}, err
+	}
+	if u.JobID, err = toInt64(row["job_id"]); err != nil {
+		return PHPUpgrade{}, err
+	}
+	created, err := toInt64(row["created_at"])
+	if err != nil {
+		return PHPUpgrade{}, err
+	}
+	updated, err := toInt64(row["updated_at"])
+	if err != nil {
+		return PHPUpgrade{}, err
+	}
+	u.From, _ = row["from_version"].(string)
+	u.To, _ = row["to_version"].(string)
+	u.Status, _ = row["status"].(string)
+	u.Error, _ = row["error"].(string)
+	u.Actor, _ = row["actor"].(string)
+	u.CreatedAt = time.Unix(created, 0).UTC()
+	u.UpdatedAt = time.Unix(updated, 0).UTC()
+	u.Sites = []PHPUpgradeSite{}
+	if raw, _ := row["sites"].(string); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &u.Sites); err != nil {
+			return PHPUpgrade{}, fmt.Errorf("decode php upgrade sites: %w", err)
+		}
+	}
+	return u, nil
+}
+
+// accessStats counts answers in an nginx access log.
+type accessStats struct {
+	requests int
+	errors   int
+}
+
+// rate is the 5xx share in percent.
+func (a accessStats) rate() float64 {
+	if a.requests == 0 {
+		return 0
+	}
+	return float64(a.errors) * 100 / float64(a.requests)
+}
+
+// elevatedOver reports whether the 5xx share rose more than maxRate
+// percentage points over baseline. Fewer than cutoverMinErrors failures
+// never count as a rise.
+func (a accessStats) elevatedOver(baseline, maxRate float64) bool {
+	return a.errors >= cutoverMinErrors && a.rate() > baseline+maxRate
+}
+
+// scanAccessLog counts the answers logged between byte offsets start and
+// end (-1 for the end of the file). A log rotated since start is read from
+// its beginning. Lines are in nginx's combined format.
+func scanAccessLog(path string, start, end int64) (accessStats, error) {
+	var stats accessStats
+	f, err := os.Open(path)
+	if err != nil {
+		return stats, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return stats, err
+	}
+	if info.Size() < start {
+		start = 0
+	}
+	if end < 0 || end > info.Size() {
+		end = info.Size()
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return stats, err
+	}
+	scanner := bufio.NewScanner(io.LimitReader(f, end-start))
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	// Starting mid-file, the first line is a fragment.
+	skip := start > 0
+	for scanner.Scan() {
+		if skip {
+			skip = false
+			continue
+		}
+		status, ok := accessLogStatus(scanner.Text())
+		if !ok {
+			continue
+		}
+		stats.requests++
+		if status >= http.StatusInternalServerError {
+			stats.errors++
+		}
+	}
+	return stats, scanner.Err()
+}
+
+// accessLogStatus returns the status of a combined-format line: the first
+// field after the quoted request line. nginx escapes quotes inside it.
+func accessLogStatus(line string) (int, bool) {
+	_, rest, ok := strings.Cut(line, `"`)
+	if !ok {
+		return 0, false
+	}
+	_, rest, ok = strings.Cut(rest, `"`)
+	if !ok {
+		return 0, false
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return 0, false
+	}
+	status, err := strconv.Atoi(fields[0])
+	if err != nil || status < 100 || status > 599 {
+		return 0, false
+	}
+	return status, true
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
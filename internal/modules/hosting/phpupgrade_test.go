@@ -0,0 +1,341 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+)
+
+// reloadHookNginx runs onReload after each reload, to fake traffic that
+// reaches the site once the vhost points at the new pool.
+type reloadHookNginx struct {
+	fakeNginxAdapter
+	onReload func(version string)
+}
+
+func (n *reloadHookNginx) Reload(ctx context.Context) error {
+	if n.onReload != nil && len(n.writeCalls) > 0 {
+		n.onReload(n.writeCalls[len(n.writeCalls)-1].PHPVersion)
+	}
+	return n.fakeNginxAdapter.Reload(ctx)
+}
+
+// newUpgradeService seeds one site on PHP 8.3 with an index.php and serves
+// its pool on a socket; indexStatus sets what index.php answers.
+func newUpgradeService(t *testing.T, nginx *reloadHookNginx, indexStatus int) (*Service, *fakePHPFPMAdapter, *jobqueue.Queue) {
+	t.Helper()
+	phpfpm := &fakePHPFPMAdapter{}
+	svc := newTestService(t, withNginx(nginx), withPHPFPM(phpfpm))
+	seedSite(t, svc, "example.com")
+	root := filepath.Join(svc.webRoot, "example.com", "public_html")
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "index.php"), []byte("<?php echo 'hi';"), 0o644); err != nil {
+		t.Fatalf("write index: %v", err)
+	}
+	svc.accessLogDir = t.TempDir()
+	svc.cutoverWatch = 10 * time.Millisecond
+
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(web.Close)
+	sock := filepath.Join(t.TempDir(), "fpm.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	go func() {
+		_ = fcgi.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == sitePingPath {
+				_, _ = w.Write([]byte("pong"))
+				return
+			}
+			env := fcgi.ProcessEnv(r)
+			if env["SCRIPT_FILENAME"] != filepath.Join(root, "index.php") {
+				http.NotFound(w, r)
+				return
+			}
+			w.WriteHeader(indexStatus)
+		}))
+	}()
+	svc.healthHTTPBase = web.URL
+	svc.fpmSocket = func(Site) string { return sock }
+	svc.healthRetryDelay = time.Millisecond
+
+	queue := jobqueue.New(svc.store, nil)
+	svc.RegisterJobs(queue)
+	return svc, phpfpm, queue
+}
+
+func runUpgrade(t *testing.T, svc *Service, queue *jobqueue.Queue, req PHPUpgradeRequest) PHPUpgrade {
+	t.Helper()
+	ctx := context.Background()
+	started, err := svc.StartPHPUpgrade(ctx, req)
+	if err != nil {
+		t.Fatalf("start upgrade: %v", err)
+	}
+	if started.Status != PHPUpgradeQueued || started.JobID == 0 {
+		t.Fatalf("unexpected queued upgrade: %+v", started)
+	}
+	if _, err := queue.RunPending(ctx); err != nil {
+		t.Fatalf("run jobs: %v", err)
+	}
+	done, err := svc.GetPHPUpgrade(ctx, started.ID)
+	if err != nil {
+		t.Fatalf("get upgrade: %v", err)
+	}
+	return done
+}
+
+func TestService_PHPUpgradeCutsOverHealthySite(t *testing.T) {
+	ctx := context.Background()
+	nginx := &reloadHookNginx{}
+	svc, phpfpm, queue := newUpgradeService(t, nginx, http.StatusOK)
+	logPath := filepath.Join(svc.accessLogDir, "example.com.access.log")
+	if err := os.WriteFile(logPath, []byte(strings.Repeat(
+		`127.0.0.1 - - [16/Oct/2026:10:00:00 +0000] "GET / HTTP/1.1" 200 12 "-" "curl/8"`+"\n", 20)), 0o644); err != nil {
+		t.Fatalf("seed access log: %v", err)
+	}
+
+	u := runUpgrade(t, svc, queue, PHPUpgradeRequest{To: "8.4", Actor: "admin@example.com"})
+	if u.Status != PHPUpgradeCompleted || len(u.Sites) != 1 || u.Sites[0].Status != PHPSiteUpgraded {
+		t.Fatalf("expected the site upgraded, got %+v", u)
+	}
+	site, err := svc.GetSite(ctx, 1)
+	if err != nil || site.PHPVersion != "8.4" {
+		t.Fatalf("expected the site on 8.4, got %+v (%v)", site, err)
+	}
+	if len(phpfpm.writeCalls) != 1 || phpfpm.writeCalls[0].PHPVersion != "8.4" {
+		t.Fatalf("expected one green pool, got %+v", phpfpm.writeCalls)
+	}
+	if strings.Join(phpfpm.removeCalls, ",") != "example.com@8.3" {
+		t.Fatalf("expected only the old pool removed, got %v", phpfpm.removeCalls)
+	}
+	if len(nginx.writeCalls) != 1 || nginx.writeCalls[0].PHPVersion != "8.4" || nginx.reloadCalls != 1 {
+		t.Fatalf("expected one cutover, got %+v reloads=%d", nginx.writeCalls, nginx.reloadCalls)
+	}
+
+	// Nothing is left to move.
+	u = runUpgrade(t, svc, queue, PHPUpgradeRequest{To: "8.4"})
+	if u.Status != PHPUpgradeCompleted || len(u.Sites) != 0 {
+		t.Fatalf("expected an empty upgrade, got %+v", u)
+	}
+	progress, err := svc.PHPUpgradeProgress(ctx, u.ID, 0)
+	if err != nil || !strings.Contains(progress.Log, "upgrading 0 site(s) to php 8.4") {
+		t.Fatalf("expected the job log, got %q (%v)", progress.Log, err)
+	}
+}
+
+func TestService_PHPUpgradeRollsBackOnElevated5xx(t *testing.T) {
+	ctx := context.Background()
+	nginx := &reloadHookNginx{}
+	svc, phpfpm, queue := newUpgradeService(t, nginx, http.StatusOK)
+	logPath := filepath.Join(svc.accessLogDir, "example.com.access.log")
+	nginx.onReload = func(version string) {
+		if version != "8.4" {
+			return
+		}
+		f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			t.Errorf("open access log: %v", err)
+			return
+		}
+		defer f.Close()
+		for i := range 10 {
+			status := 200
+			if i%2 == 0 {
+				status = 502
+			}
+			_, _ = fmt.Fprintf(f, "127.0.0.1 - - [16/Oct/2026:10:00:00 +0000] \"GET /shop HTTP/1.1\" %d 0 \"-\" \"curl/8\"\n", status)
+		}
+	}
+
+	u := runUpgrade(t, svc, queue, PHPUpgradeRequest{To: "8.4"})
+	if u.Status != PHPUpgradeCompleted || len(u.Sites) != 1 {
+		t.Fatalf("unexpected upgrade: %+v", u)
+	}
+	res := u.Sites[0]
+	if res.Status != PHPSiteRolledBack || res.Requests != 10 || res.ErrorRate != 50 || !strings.Contains(res.Detail, "5xx rate rose to 50.0%") {
+		t.Fatalf("expected a rollback on the 5xx rise, got %+v", res)
+	}
+	site, err := svc.GetSite(ctx, 1)
+	if err != nil || site.PHPVersion != "8.3" {
+		t.Fatalf("expected the site back on 8.3, got %+v (%v)", site, err)
+	}
+	if len(nginx.writeCalls) != 2 || nginx.writeCalls[1].PHPVersion != "8.3" {
+		t.Fatalf("expected the vhost pointed back, got %+v", nginx.writeCalls)
+	}
+	if strings.Join(phpfpm.removeCalls, ",") != "example.com@8.4" {
+		t.Fatalf("expected only the new pool removed, got %v", phpfpm.removeCalls)
+	}
+	rows, err := svc.store.QueryAuditJSON(ctx, "SELECT action FROM audit_events WHERE action = 'hosting.php.rollback';")
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("expected a rollback audit event, got %+v (%v)", rows, err)
+	}
+}
+
+func TestService_PHPUpgradeSkipsSiteFailingOnNewPool(t *testing.T) {
+	ctx := context.Background()
+	nginx := &reloadHookNginx{}
+	svc, phpfpm, queue := newUpgradeService(t, nginx, http.StatusInternalServerError)
+
+	u := runUpgrade(t, svc, queue, PHPUpgradeRequest{})
+	if len(u.Sites) != 1 || u.Sites[0].Status != PHPSiteUnhealthy || !strings.Contains(u.Sites[0].Detail, "index.php answered status 500") {
+		t.Fatalf("expected the site left on its version, got %+v", u)
+	}
+	if u.To != "8.4" {
+		t.Fatalf("expected the newest installed version picked, got %q", u.To)
+	}
+	if len(nginx.writeCalls) != 0 {
+		t.Fatalf("expected no cutover, got %+v", nginx.writeCalls)
+	}
+	if site, _ := svc.GetSite(ctx, 1); site.PHPVersion != "8.3" {
+		t.Fatalf("expected the site on 8.3, got %+v", site)
+	}
+	if strings.Join(phpfpm.removeCalls, ",") != "example.com@8.4" {
+		t.Fatalf("expected the new pool removed, got %v", phpfpm.removeCalls)
+	}
+
+	if _, err := svc.StartPHPUpgrade(ctx, PHPUpgradeRequest{To: "9.1"}); err == nil || !strings.Contains(err.Error(), "not installed") {
+		t.Fatalf("expected an unknown version refused, got %v", err)
+	}
+	if _, err := svc.StartPHPUpgrade(ctx, PHPUpgradeRequest{Build: true}); err == nil || !strings.Contains(err.Error(), "invalid") {
+		t.Fatalf("expected a build without a stager refused, got %v", err)
+	}
+	if _, err := svc.StartPHPUpgrade(ctx, PHPUpgradeRequest{To: "8.4"}); err != nil {
+		t.Fatalf("start upgrade: %v", err)
+	}
+	if _, err := svc.StartPHPUpgrade(ctx, PHPUpgradeRequest{To: "8.4"}); err == nil || !strings.Contains(err.Error(), "already in progress") {
+		t.Fatalf("expected a second upgrade refused, got %v", err)
+	}
+}
+
+func TestService_SetSitePHPVersion(t *testing.T) {
+	ctx := context.Background()
+	nginx := &reloadHookNginx{}
+	svc, phpfpm, _ := newUpgradeService(t, nginx, http.StatusOK)
+	// A write stamped ahead of the clock, as two writes in one second leave
+	// it; the switch must still move the ETag forward.
+	if err := svc.store.ExecPanel(ctx, fmt.Sprintf("UPDATE sites SET updated_at = %d WHERE id = 1;", time.Now().Add(time.Hour).Unix())); err != nil {
+		t.Fatalf("stamp site: %v", err)
+	}
+	before, err := svc.GetSite(ctx, 1)
+	if err != nil {
+		t.Fatalf("get site: %v", err)
+	}
+
+	site, err := svc.SetSitePHPVersion(ctx, 1, SitePHPVersionRequest{PHPVersion: "8.4", Actor: "admin@example.com"})
+	if err != nil || site.PHPVersion != "8.4" {
+		t.Fatalf("expected the site on 8.4, got %+v (%v)", site, err)
+	}
+	if !site.UpdatedAt.After(before.UpdatedAt) {
+		t.Fatalf("expected updated_at to advance past %v, got %v", before.UpdatedAt, site.UpdatedAt)
+	}
+	if len(phpfpm.writeCalls) != 1 || phpfpm.writeCalls[0].PHPVersion != "8.4" || !slices.Contains(phpfpm.restarts, "8.4") {
+		t.Fatalf("expected a pool started on 8.4, got %+v restarts=%v", phpfpm.writeCalls, phpfpm.restarts)
+	}
+	if strings.Join(phpfpm.removeCalls, ",") != "example.com@8.3" {
+		t.Fatalf("expected only the old pool removed, got %v", phpfpm.removeCalls)
+	}
+	if len(nginx.writeCalls) != 1 || nginx.writeCalls[0].PHPVersion != "8.4" || nginx.reloadCalls != 1 {
+		t.Fatalf("expected the vhost pointed at 8.4, got %+v reloads=%d", nginx.writeCalls, nginx.reloadCalls)
+	}
+	rows, err := svc.store.QueryAuditJSON(ctx, "SELECT actor FROM audit_events WHERE action = 'hosting.php.switch';")
+	if err != nil || len(rows) != 1 || rows[0]["actor"] != "admin@example.com" {
+		t.Fatalf("expected a switch audit event, got %+v (%v)", rows, err)
+	}
+
+	if _, err := svc.SetSitePHPVersion(ctx, 1, SitePHPVersionRequest{PHPVersion: "9.1"}); !errors.Is(err, ErrPHPVersionNotInstalled) {
+		t.Fatalf("expected an unknown version refused, got %v", err)
+	}
+	if _, err := svc.SetSitePHPVersion(ctx, 1, SitePHPVersionRequest{PHPVersion: "8"}); !errors.Is(err, ErrInvalidPHPVersion) {
+		t.Fatalf("expected a malformed version refused, got %v", err)
+	}
+}
+
+func TestService_SetSitePHPVersionRollsBackOnNginxTestFailure(t *testing.T) {
+	ctx := context.Background()
+	nginx := &reloadHookNginx{}
+	nginx.failTest = fmt.Errorf("nginx: [emerg] unknown directive")
+	svc, phpfpm, _ := newUpgradeService(t, nginx, http.StatusOK)
+
+	if _, err := svc.SetSitePHPVersion(ctx, 1, SitePHPVersionRequest{PHPVersion: "8.4"}); err == nil || !strings.Contains(err.Error(), "test nginx config") {
+		t.Fatalf("expected the config test failure, got %v", err)
+	}
+	if len(nginx.writeCalls) != 2 || nginx.writeCalls[1].PHPVersion != "8.3" || nginx.reloadCalls != 0 {
+		t.Fatalf("expected the vhost restored without a reload, got %+v reloads=%d", nginx.writeCalls, nginx.reloadCalls)
+	}
+	if site, _ := svc.GetSite(ctx, 1); site.PHPVersion != "8.3" {
+		t.Fatalf("expected the site on 8.3, got %+v", site)
+	}
+	if strings.Join(phpfpm.removeCalls, ",") != "example.com@8.4" {
+		t.Fatalf("expected the new pool removed, got %v", phpfpm.removeCalls)
+	}
+}
+
+func TestService_SetSitePHPVersionRollsBackOnReloadFailure(t *testing.T) {
+	ctx := context.Background()
+	nginx := &reloadHookNginx{}
+	nginx.failReload = fmt.Errorf("nginx: reload failed")
+	svc, phpfpm, _ := newUpgradeService(t, nginx, http.StatusOK)
+
+	if _, err := svc.SetSitePHPVersion(ctx, 1, SitePHPVersionRequest{PHPVersion: "8.4"}); err == nil || !strings.Contains(err.Error(), "reload nginx") || errors.Is(err, errCutoverStranded) {
+		t.Fatalf("expected the reload failure rolled back, got %v", err)
+	}
+	if len(nginx.writeCalls) != 2 || nginx.writeCalls[1].PHPVersion != "8.3" {
+		t.Fatalf("expected the vhost restored, got %+v", nginx.writeCalls)
+	}
+	if site, _ := svc.GetSite(ctx, 1); site.PHPVersion != "8.3" {
+		t.Fatalf("expected the site back on 8.3, got %+v", site)
+	}
+	if strings.Join(phpfpm.removeCalls, ",") != "example.com@8.4" {
+		t.Fatalf("expected the new pool removed after the rollback, got %v", phpfpm.removeCalls)
+	}
+}
+
+func TestService_SetSitePHPVersionKeepsNewPoolWhenRollbackFails(t *testing.T) {
+	ctx := context.Background()
+	nginx := &reloadHookNginx{}
+	nginx.failReload = fmt.Errorf("nginx: reload failed")
+	// The vhost cannot be written back once the reload failed.
+	nginx.onReload = func(string) { nginx.failWrite = fmt.Errorf("disk full") }
+	svc, phpfpm, _ := newUpgradeService(t, nginx, http.StatusOK)
+
+	if _, err := svc.SetSitePHPVersion(ctx, 1, SitePHPVersionRequest{PHPVersion: "8.4"}); !errors.Is(err, errCutoverStranded) {
+		t.Fatalf("expected a stranded cutover, got %v", err)
+	}
+	if len(phpfpm.removeCalls) != 0 {
+		t.Fatalf("expected both pools kept while the vhost may name 8.4, got %v", phpfpm.removeCalls)
+	}
+}
+
+func TestAccessLogStatus(t *testing.T) {
+	for line, want := range map[string]int{
+		`1.2.3.4 - - [16/Oct/2026:10:00:00 +0000] "GET / HTTP/1.1" 502 157 "-" "curl/8"`:      502,
+		`1.2.3.4 - - [16/Oct/2026:10:00:00 +0000] "GET /?q=\x22x\x22 HTTP/1.1" 200 5 "-" "-"`: 200,
+		`1.2.3.4 - - [16/Oct/2026:10:00:00 +0000] "\x16\x03\x01" 400 157 "-" "-"`:             400,
+		`garbage`: 0,
+	} {
+		got, ok := accessLogStatus(line)
+		if got != want || ok != (want != 0) {
+			t.Fatalf("accessLogStatus(%q) = %d, %t; want %d", line, got, ok, want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrInvalidPHPVersion indicates a version that is not major.minor.
+	ErrInvalidPHPVersion = errors.New("invalid php version")
+	// ErrPHPVersionNotInstalled indicates a version with no runtime on the host.
+	ErrPHPVersionNotInstalled = errors.New("php version is not installed")
+)
+
+// SitePHPVersionRequest moves one site to another installed PHP version.
+type SitePHPVersionRequest struct {
+	PHPVersion string `json:"php_version"`
+	Actor      string `json:"-"`
+}
+
+// SetSitePHPVersion moves a site to another PHP version right away: it
+// starts a pool on the new version, checks it, points the vhost at it and
+// removes the old pool. A vhost nginx rejects or fails to reload is
+// restored and the site stays where it was. Fleet-wide moves with a watch period go through
+// StartPHPUpgrade instead.
+func (s *Service) SetSitePHPVersion(ctx context.Context, siteID int64, req SitePHPVersionRequest) (Site, error) {
+	if s.store == nil || s.nginx == nil || s.phpfpm == nil {
+		return Site{}, fmt.Errorf("hosting service is not fully configured")
+	}
+	to := strings.TrimSpace(req.PHPVersion)
+	if !phpVersionPattern.MatchString(to) {
+		return Site{}, fmt.Errorf("%w %q", ErrInvalidPHPVersion, to)
+	}
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return Site{}, err
+	}
+	if site.PHPVersion == to {
+		return site, nil
+	}
+	versions, err := s.listPHPVersions(ctx)
+	if err != nil {
+		return Site{}, fmt.Errorf("list php versions: %w", err)
+	}
+	if !slices.Contains(versions, to) {
+		return Site{}, fmt.Errorf("%w: %s", ErrPHPVersionNotInstalled, to)
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT id FROM php_upgrades WHERE status IN ('%s','%s') LIMIT 1;", PHPUpgradeQueued, PHPUpgradeRunning))
+	if err != nil {
+		return Site{}, fmt.Errorf("check php upgrades: %w", err)
+	}
+	if len(rows) > 0 {
+		return Site{}, fmt.Errorf("%w (upgrade %v)", ErrPHPUpgradeInProgress, rows[0]["id"])
+	}
+
+	blue, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return Site{}, err
+	}
+	green := blue
+	green.PHPVersion = to
+	greenSite := site
+	greenSite.PHPVersion = to
+	if err := s.phpfpm.WritePool(ctx, green); err != nil {
+		s.dropPool(ctx, site.Domain, to)
+		return Site{}, fmt.Errorf("write php-fpm pool: %w", err)
+	}
+	if err := s.phpfpm.Restart(ctx, to); err != nil {
+		s.dropPool(ctx, site.Domain, to)
+		return Site{}, fmt.Errorf("restart php-fpm %s: %w", to, err)
+	}
+	if probe := s.probeNewPool(ctx, greenSite); probe.Status != CheckPass {
+		s.dropPool(ctx, site.Domain, to)
+		return Site{}, fmt.Errorf("php %s pool failed its check: %s", to, probe.Detail)
+	}
+	if err := s.cutover(ctx, site.ID, blue, green); err != nil {
+		if !errors.Is(err, errCutoverStranded) {
+			s.dropPool(ctx, site.Domain, to)
+		}
+		return Site{}, err
+	}
+	s.dropPool(ctx, site.Domain, site.PHPVersion)
+	_ = s.writeAudit(ctx, req.Actor, "hosting.php.switch", fmt.Sprintf("domain=%s from=%s to=%s", site.Domain, site.PHPVersion, to))
+	return s.GetSite(ctx, siteID)
+}
@@ -0,0 +1,238 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/pkg/adapter"
+)
+
+// Template kinds accepted by PreviewTemplate.
+const (
+	TemplateVhost = "vhost"
+	TemplatePool  = "pool"
+	TemplatePanel = "panel"
+	// TemplateCatchAll is the nginx default server for unknown hosts.
+	TemplateCatchAll = "catchall"
+)
+
+const defaultPanelVhostTemplate = "/etc/aipanel/templates/nginx_panel_vhost.conf.tmpl"
+
+// vhostPreviewer and poolPreviewer are implemented by the file-backed
+// adapters; previews render through them and test a staged copy.
+type vhostPreviewer interface {
+	PreviewVhost(site adapter.SiteConfig, source string) (string, error)
+	TestStaged(ctx context.Context, vhosts ...string) (string, error)
+}
+
+type poolPreviewer interface {
+	PreviewPool(site adapter.SiteConfig, source string) (string, error)
+	TestPool(ctx context.Context, pool string) (string, error)
+}
+
+// TemplatePreviewRequest selects what to render. Content replaces the
+// installed template when set; SiteID renders with that site's data
+// instead of a sample site. SiteID is ignored for the panel vhost.
+type TemplatePreviewRequest struct {
+	Kind    string `json:"kind"`
+	Content string `json:"content"`
+	SiteID  int64  `json:"site_id"`
+}
+
+// TemplatePreview is the rendered config and the result of testing it.
+// Valid is false when rendering or the config test failed; Error says why
+// and Output carries the nginx/php-fpm output.
+type TemplatePreview struct {
+	Kind     string `json:"kind"`
+	Site     string `json:"site"`
+	Rendered string `json:"rendered"`
+	Valid    bool   `json:"valid"`
+	Output   string `json:"output,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// panelVhostPreviewData mirrors the fields the installer renders the
+// panel vhost with.
+type panelVhostPreviewData struct {
+	PanelPort       string
+	PanelUpstream   string
+	PanelHost       string
+	PHPVersion      string
+	ACMEWebroot     string
+	EnableTLS       bool
+	TLSCertPath     string
+	TLSKeyPath      string
+	PHPMyAdminPath  string
+	PHPMyAdminDir   string
+	EnablePGAdmin   bool
+	PGAdminPath     string
+	PGAdminPort     string
+	EnableAdminer   bool
+	AdminerPath     string
+	AdminerDir      string
+	EnableRoundcube bool
+	RoundcubePath   string
+	RoundcubeDir    string
+	AdminToolsAllow []string
+}
+
+// PreviewTemplate renders a vhost, pool or panel vhost template and runs
+// the matching config test against a staged copy, without touching the
+// live configuration.
+func (s *Service) PreviewTemplate(ctx context.Context, req TemplatePreviewRequest) (*TemplatePreview, error) {
+	kind := strings.ToLower(strings.TrimSpace(req.Kind))
+	preview := &TemplatePreview{Kind: kind}
+	var (
+		rendered string
+		test     func() (string, error)
+		err      error
+	)
+	switch kind {
+	case TemplateVhost, TemplatePool:
+		site, err := s.previewSite(ctx, req.SiteID)
+		if err != nil {
+			return nil, err
+		}
+		preview.Site = site.Domain
+		if kind == TemplateVhost {
+			vhosts, ok := s.nginx.(vhostPreviewer)
+			if !ok {
+				return nil, fmt.Errorf("nginx adapter does not support previews")
+			}
+			rendered, err = vhosts.PreviewVhost(site, req.Content)
+			test = func() (string, error) { return vhosts.TestStaged(ctx, rendered) }
+		} else {
+			pools, ok := s.phpfpm.(poolPreviewer)
+			if !ok {
+				return nil, fmt.Errorf("php-fpm adapter does not support previews")
+			}
+			rendered, err = pools.PreviewPool(site, req.Content)
+			test = func() (string, error) { return pools.TestPool(ctx, rendered) }
+		}
+		if err != nil {
+			preview.Error = err.Error()
+			return preview, nil
+		}
+	case TemplatePanel:
+		vhosts, ok := s.nginx.(vhostPreviewer)
+		if !ok {
+			return nil, fmt.Errorf("nginx adapter does not support previews")
+		}
+		source := req.Content
+		if source == "" {
+			raw, err := os.ReadFile(defaultPanelVhostTemplate)
+			if err != nil {
+				return nil, fmt.Errorf("read panel vhost template: %w", err)
+			}
+			source = string(raw)
+		}
+		data := s.panelPreviewData()
+		preview.Site = data.PanelHost
+		if rendered, err = renderTemplate(filepath.Base(defaultPanelVhostTemplate), source, data); err != nil {
+			preview.Error = fmt.Sprintf("render panel vhost template: %v", err)
+			return preview, nil
+		}
+		test = func() (string, error) { return vhosts.TestStaged(ctx, rendered) }
+	case TemplateCatchAll:
+		vhosts, ok := s.nginx.(vhostPreviewer)
+		if !ok {
+			return nil, fmt.Errorf("nginx adapter does not support previews")
+		}
+		source := req.Content
+		if source == "" {
+			raw, err := os.ReadFile(s.catchAllTemplate)
+			if err != nil {
+				return nil, fmt.Errorf("read catch-all template: %w", err)
+			}
+			source = string(raw)
+		}
+		data, err := s.catchAllData()
+		if err != nil {
+			preview.Error = err.Error()
+			return preview, nil
+		}
+		preview.Site = "_"
+		if rendered, err = renderTemplate(filepath.Base(s.catchAllTemplate), source, data); err != nil {
+			preview.Error = fmt.Sprintf("render catch-all template: %v", err)
+			return preview, nil
+		}
+		test = func() (string, error) { return vhosts.TestStaged(ctx, rendered) }
+	default:
+		return nil, fmt.Errorf("invalid template kind %q (use vhost, pool, panel or catchall)", req.Kind)
+	}
+
+	preview.Rendered = rendered
+	output, err := test()
+	preview.Output = strings.TrimSpace(output)
+	if err != nil {
+		preview.Error = err.Error()
+		return preview, nil
+	}
+	preview.Valid = true
+	return preview, nil
+}
+
+// previewSite returns the data of site id, or of a sample site for 0.
+func (s *Service) previewSite(ctx context.Context, id int64) (adapter.SiteConfig, error) {
+	if id == 0 {
+		return adapter.SiteConfig{
+			Domain:     "preview.example.com",
+			RootDir:    filepath.Join(s.webRoot, "preview.example.com", "public_html"),
+			PHPVersion: defaultPHPVersion,
+			// The pool test resolves the user, so the sample uses one that
+			// exists on every host.
+			SystemUser: "www-data",
+			TmpDir:     filepath.Join(s.webRoot, "preview.example.com", "tmp"),
+		}, nil
+	}
+	site, err := s.GetSite(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrSiteNotFound) {
+			return adapter.SiteConfig{}, err
+		}
+		return adapter.SiteConfig{}, fmt.Errorf("load site: %w", err)
+	}
+	return s.siteConfig(ctx, site)
+}
+
+// panelPreviewData fills the panel vhost fields from the panel config and
+// the installer's default admin tool routes.
+func (s *Service) panelPreviewData() panelVhostPreviewData {
+	port := "8080"
+	if _, p, err := net.SplitHostPort(s.cfg.Addr); err == nil && p != "" {
+		port = p
+	}
+	upstream := "http://127.0.0.1:" + port
+	if socketPath, ok := config.UnixSocketPath(s.cfg.Addr); ok {
+		upstream = "http://unix:" + socketPath + ":"
+	}
+	host := strings.TrimSpace(s.cfg.PanelDomain)
+	if host == "" {
+		host = "_"
+	}
+	webroot := s.cfg.ACMEWebroot
+	if webroot == "" {
+		webroot = "/var/www/letsencrypt"
+	}
+	return panelVhostPreviewData{
+		PanelPort:      port,
+		PanelUpstream:  upstream,
+		PanelHost:      host,
+		PHPVersion:     defaultPHPVersion,
+		ACMEWebroot:    webroot,
+		PHPMyAdminPath: "/phpmyadmin",
+		PHPMyAdminDir:  "/usr/share/phpmyadmin",
+		PGAdminPath:    "/pgadmin",
+		PGAdminPort:    "5050",
+		AdminerPath:    "/adminer",
+		AdminerDir:     "/usr/share/adminer",
+		RoundcubePath:  "/webmail",
+		RoundcubeDir:   "/usr/share/roundcube",
+	}
+}
@@ -0,0 +1,91 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+)
+
+// stageRunner records the staged nginx config at the time nginx -t runs,
+// since the stage dir is removed afterwards.
+type stageRunner struct {
+	args   []string
+	staged string
+	err    error
+}
+
+func (r *stageRunner) Run(_ context.Context, _ string, args ...string) (string, error) {
+	r.args = args
+	if len(args) > 2 && args[1] == "-p" {
+		raw, _ := os.ReadFile(filepath.Join(args[2], "staged-0.conf")) //nolint:gosec // test reads the stage dir.
+		r.staged = string(raw)
+	}
+	if r.err != nil {
+		return "nginx: [emerg] unknown directive", r.err
+	}
+	return "nginx: configuration file test is successful", nil
+}
+
+func TestPreviewTemplate_VhostRendersAndTestsStagedConfig(t *testing.T) {
+	root := t.TempDir()
+	confDir := filepath.Join(root, "conf")
+	if err := os.MkdirAll(confDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	runner := &stageRunner{}
+	nginx := NewNginxAdapter(runner, NginxAdapterOptions{
+		TemplatePath:    filepath.Join(root, "missing.tmpl"),
+		NginxConfigPath: filepath.Join(confDir, "nginx.conf"),
+	})
+	svc := NewService(nil, config.Config{}, slog.Default(), &fakeRunner{}, nginx, &fakePHPFPMAdapter{})
+
+	preview, err := svc.PreviewTemplate(context.Background(), TemplatePreviewRequest{
+		Kind:    "vhost",
+		Content: "server { server_name {{ .Domain }}; }\n",
+	})
+	if err != nil {
+		t.Fatalf("preview: %v", err)
+	}
+	if !preview.Valid || preview.Site != "preview.example.com" {
+		t.Fatalf("unexpected preview: %+v", preview)
+	}
+	if runner.staged != "server { server_name preview.example.com; }\n" || preview.Rendered != runner.staged {
+		t.Fatalf("expected the rendered vhost to be staged, got %q", runner.staged)
+	}
+	if runner.args[0] != "-t" {
+		t.Fatalf("expected a config test, got %v", runner.args)
+	}
+
+	runner.err = errors.New("exit status 1")
+	preview, err = svc.PreviewTemplate(context.Background(), TemplatePreviewRequest{Kind: "vhost", Content: "server { bogus; }\n"})
+	if err != nil {
+		t.Fatalf("preview: %v", err)
+	}
+	if preview.Valid || !strings.Contains(preview.Output, "unknown directive") {
+		t.Fatalf("expected a failed config test, got %+v", preview)
+	}
+}
+
+func TestPreviewTemplate_RejectsBrokenTemplateAndUnknownKind(t *testing.T) {
+	runner := &stageRunner{}
+	nginx := NewNginxAdapter(runner, NginxAdapterOptions{NginxConfigPath: filepath.Join(t.TempDir(), "nginx.conf")})
+	svc := NewService(nil, config.Config{}, slog.Default(), &fakeRunner{}, nginx, &fakePHPFPMAdapter{})
+
+	preview, err := svc.PreviewTemplate(context.Background(), TemplatePreviewRequest{Kind: "vhost", Content: "{{ .Domain "})
+	if err != nil {
+		t.Fatalf("preview: %v", err)
+	}
+	if preview.Valid || preview.Error == "" || runner.args != nil {
+		t.Fatalf("expected a render error without a config test, got %+v", preview)
+	}
+
+	if _, err := svc.PreviewTemplate(context.Background(), TemplatePreviewRequest{Kind: "mail"}); err == nil || !isBadRequest(err) {
+		t.Fatalf("expected an invalid kind error, got %v", err)
+	}
+}
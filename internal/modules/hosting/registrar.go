@@ -0,0 +1,211 @@
+package hosting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/pkg/adapter"
+)
+
+// ErrRegistrarNotConfigured indicates that no registrar integration is set.
+var ErrRegistrarNotConfigured = errors.New("registrar is not configured")
+
+// GlueRecord is the address set of a nameserver host inside the domain
+// it serves, e.g. ns1.example.com for example.com.
+type GlueRecord struct {
+	Host string   `json:"host"`
+	IPs  []string `json:"ips"`
+}
+
+// DomainAvailability is the registrar's answer for an unregistered name.
+type DomainAvailability struct {
+	Domain    string `json:"domain"`
+	Available bool   `json:"available"`
+	Registrar string `json:"registrar"`
+}
+
+// SiteRegistrar is the delegation the panel last set at the registrar for
+// a site domain.
+type SiteRegistrar struct {
+	SiteID      int64        `json:"site_id"`
+	Registrar   string       `json:"registrar"`
+	Nameservers []string     `json:"nameservers"`
+	Glue        []GlueRecord `json:"glue"`
+	UpdatedAt   time.Time    `json:"updated_at,omitzero"`
+}
+
+// RegistrarNameserversRequest delegates a site domain to nameservers.
+// Glue is needed for nameservers inside the domain itself.
+type RegistrarNameserversRequest struct {
+	Nameservers []string     `json:"nameservers"`
+	Glue        []GlueRecord `json:"glue"`
+	Actor       string       `json:"-"`
+}
+
+// registrarFromConfig builds the configured registrar integration.
+func registrarFromConfig(cfg config.Config) adapter.Registrar {
+	switch cfg.Registrar {
+	case "namecheap":
+		return NewNamecheapRegistrar(NamecheapOptions{
+			APIUser:  cfg.NamecheapAPIUser,
+			APIKey:   cfg.NamecheapAPIKey,
+			Username: cfg.NamecheapUsername,
+			ClientIP: cfg.NamecheapClientIP,
+		})
+	case "ovh":
+		return NewOVHRegistrar(OVHOptions{
+			Endpoint:          cfg.OVHEndpoint,
+			ApplicationKey:    cfg.OVHApplicationKey,
+			ApplicationSecret: cfg.OVHApplicationSecret,
+			ConsumerKey:       cfg.OVHConsumerKey,
+			Subsidiary:        cfg.OVHSubsidiary,
+		})
+	default:
+		return nil
+	}
+}
+
+// CheckDomainAvailability asks the registrar whether domain can be
+// registered, e.g. before creating a site for it.
+func (s *Service) CheckDomainAvailability(ctx context.Context, domain string) (DomainAvailability, error) {
+	if s.registrar == nil {
+		return DomainAvailability{}, ErrRegistrarNotConfigured
+	}
+	domain, err := normalizeDomain(domain)
+	if err != nil {
+		return DomainAvailability{}, err
+	}
+	available, err := s.registrar.CheckAvailability(ctx, domain)
+	if err != nil {
+		return DomainAvailability{}, err
+	}
+	return DomainAvailability{Domain: domain, Available: available, Registrar: s.registrar.Name()}, nil
+}
+
+// GetSiteRegistrar returns the delegation last set for the site domain.
+func (s *Service) GetSiteRegistrar(ctx context.Context, siteID int64) (SiteRegistrar, error) {
+	if _, err := s.GetSite(ctx, siteID); err != nil {
+		return SiteRegistrar{}, err
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT registrar, nameservers, glue, updated_at FROM site_registrar WHERE site_id = %d;", siteID))
+	if err != nil {
+		return SiteRegistrar{}, fmt.Errorf("get site registrar: %w", err)
+	}
+	state := SiteRegistrar{SiteID: siteID, Nameservers: []string{}, Glue: []GlueRecord{}}
+	if s.registrar != nil {
+		state.Registrar = s.registrar.Name()
+	}
+	if len(rows) == 0 {
+		return state, nil
+	}
+	row := rows[0]
+	state.Registrar, _ = row["registrar"].(string)
+	if ns, _ := row["nameservers"].(string); ns != "" {
+		state.Nameservers = strings.Split(ns, ",")
+	}
+	if glue, _ := row["glue"].(string); glue != "" {
+		if err := json.Unmarshal([]byte(glue), &state.Glue); err != nil {
+			return SiteRegistrar{}, fmt.Errorf("decode glue records: %w", err)
+		}
+	}
+	updatedAt, err := toInt64(row["updated_at"])
+	if err != nil {
+		return SiteRegistrar{}, fmt.Errorf("parse updated_at: %w", err)
+	}
+	state.UpdatedAt = time.Unix(updatedAt, 0).UTC()
+	return state, nil
+}
+
+// SetSiteNameservers sets glue records and then delegates the site domain
+// to the given nameservers at the registrar. The site domain must be the
+// registered domain, not a subdomain.
+func (s *Service) SetSiteNameservers(ctx context.Context, siteID int64, req RegistrarNameserversRequest) (SiteRegistrar, error) {
+	if s.registrar == nil {
+		return SiteRegistrar{}, ErrRegistrarNotConfigured
+	}
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return SiteRegistrar{}, err
+	}
+	nameservers, glue, err := validateDelegation(site.Domain, req)
+	if err != nil {
+		return SiteRegistrar{}, err
+	}
+	// Registrars reject in-domain nameservers that have no glue yet.
+	for _, g := range glue {
+		if err := s.registrar.SetGlueRecord(ctx, site.Domain, g.Host, g.IPs); err != nil {
+			return SiteRegistrar{}, fmt.Errorf("set glue record %s: %w", g.Host, err)
+		}
+	}
+	if err := s.registrar.SetNameservers(ctx, site.Domain, nameservers); err != nil {
+		return SiteRegistrar{}, fmt.Errorf("set nameservers: %w", err)
+	}
+
+	rawGlue, err := json.Marshal(glue)
+	if err != nil {
+		return SiteRegistrar{}, fmt.Errorf("encode glue records: %w", err)
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO site_registrar(site_id, registrar, nameservers, glue, updated_at) VALUES(%d,'%s','%s','%s',%d)
+ON CONFLICT(site_id) DO UPDATE SET registrar=excluded.registrar, nameservers=excluded.nameservers,
+  glue=excluded.glue, updated_at=MAX(excluded.updated_at, site_registrar.updated_at + 1);`,
+		siteID, sqlEscape(s.registrar.Name()), sqlEscape(strings.Join(nameservers, ",")), sqlEscape(string(rawGlue)),
+		time.Now().Unix())); err != nil {
+		return SiteRegistrar{}, fmt.Errorf("save site registrar: %w", err)
+	}
+	_ = s.writeAudit(ctx, req.Actor, "hosting.registrar.nameservers", fmt.Sprintf("domain=%s registrar=%s nameservers=%s",
+		site.Domain, s.registrar.Name(), strings.Join(nameservers, ",")))
+	return s.GetSiteRegistrar(ctx, siteID)
+}
+
+// validateDelegation normalizes nameservers and glue records. Glue is
+// only valid for listed nameservers inside domain, and those need it.
+func validateDelegation(domain string, req RegistrarNameserversRequest) ([]string, []GlueRecord, error) {
+	if len(req.Nameservers) < 2 || len(req.Nameservers) > 13 {
+		return nil, nil, fmt.Errorf("invalid nameservers: between 2 and 13 are required")
+	}
+	nameservers := make([]string, 0, len(req.Nameservers))
+	for _, raw := range req.Nameservers {
+		ns, err := normalizeDomain(strings.TrimSuffix(strings.TrimSpace(raw), "."))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid nameserver %q", raw)
+		}
+		if slices.Contains(nameservers, ns) {
+			return nil, nil, fmt.Errorf("invalid nameservers: %s is listed twice", ns)
+		}
+		nameservers = append(nameservers, ns)
+	}
+	glue := make([]GlueRecord, 0, len(req.Glue))
+	for _, g := range req.Glue {
+		host, err := normalizeDomain(strings.TrimSuffix(strings.TrimSpace(g.Host), "."))
+		if err != nil || !slices.Contains(nameservers, host) || !strings.HasSuffix(host, "."+domain) {
+			return nil, nil, fmt.Errorf("invalid glue host %q: must be one of the nameservers under %s", g.Host, domain)
+		}
+		if len(g.IPs) == 0 {
+			return nil, nil, fmt.Errorf("invalid glue record %s: at least one address is required", host)
+		}
+		ips := make([]string, 0, len(g.IPs))
+		for _, raw := range g.IPs {
+			addr, err := netip.ParseAddr(strings.TrimSpace(raw))
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid glue address %q", raw)
+			}
+			ips = append(ips, addr.String())
+		}
+		glue = append(glue, GlueRecord{Host: host, IPs: ips})
+	}
+	for _, ns := range nameservers {
+		if strings.HasSuffix(ns, "."+domain) && !slices.ContainsFunc(glue, func(g GlueRecord) bool { return g.Host == ns }) {
+			return nil, nil, fmt.Errorf("invalid nameservers: %s is inside %s and requires a glue record", ns, domain)
+		}
+	}
+	return nameservers, glue, nil
+}
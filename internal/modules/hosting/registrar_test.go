@@ -0,0 +1,192 @@
+package hosting
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec // G505: verifies the OVH signature scheme.
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+)
+
+type fakeRegistrar struct {
+	calls []string
+	err   error
+}
+
+func (f *fakeRegistrar) Name() string { return "fake" }
+
+func (f *fakeRegistrar) CheckAvailability(_ context.Context, domain string) (bool, error) {
+	f.calls = append(f.calls, "check "+domain)
+	return domain == "free.example", f.err
+}
+
+func (f *fakeRegistrar) SetNameservers(_ context.Context, domain string, nameservers []string) error {
+	f.calls = append(f.calls, "ns "+domain+" "+strings.Join(nameservers, ","))
+	return f.err
+}
+
+func (f *fakeRegistrar) SetGlueRecord(_ context.Context, domain, host string, ips []string) error {
+	f.calls = append(f.calls, "glue "+domain+" "+host+" "+strings.Join(ips, ","))
+	return f.err
+}
+
+func TestService_SetSiteNameserversSetsGlueFirst(t *testing.T) {
+	ctx := context.Background()
+	svc := newACMEService(t, config.Config{}, &fakeRunner{})
+	if _, err := svc.SetSiteNameservers(ctx, 1, RegistrarNameserversRequest{}); !errors.Is(err, ErrRegistrarNotConfigured) {
+		t.Fatalf("expected ErrRegistrarNotConfigured, got %v", err)
+	}
+	reg := &fakeRegistrar{}
+	svc.registrar = reg
+
+	for _, req := range []RegistrarNameserversRequest{
+		{Nameservers: []string{"ns1.example.net"}},
+		{Nameservers: []string{"ns1.example.com", "ns2.example.net"}},
+		{Nameservers: []string{"ns1.example.net", "ns2.example.net"}, Glue: []GlueRecord{{Host: "ns1.example.net", IPs: []string{"192.0.2.1"}}}},
+		{Nameservers: []string{"ns1.example.com", "ns2.example.net"}, Glue: []GlueRecord{{Host: "ns1.example.com", IPs: []string{"not-an-ip"}}}},
+	} {
+		if _, err := svc.SetSiteNameservers(ctx, 1, req); err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Fatalf("expected validation error for %+v, got %v", req, err)
+		}
+	}
+	if len(reg.calls) != 0 {
+		t.Fatalf("expected no registrar calls for invalid requests, got %v", reg.calls)
+	}
+
+	state, err := svc.SetSiteNameservers(ctx, 1, RegistrarNameserversRequest{
+		Nameservers: []string{"NS1.example.com.", "ns2.example.net"},
+		Glue:        []GlueRecord{{Host: "ns1.example.com", IPs: []string{"192.0.2.53", "2001:db8::53"}}},
+	})
+	if err != nil {
+		t.Fatalf("set nameservers: %v", err)
+	}
+	want := []string{
+		"glue example.com ns1.example.com 192.0.2.53,2001:db8::53",
+		"ns example.com ns1.example.com,ns2.example.net",
+	}
+	if strings.Join(reg.calls, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected registrar calls: %v", reg.calls)
+	}
+	if state.Registrar != "fake" || len(state.Nameservers) != 2 || len(state.Glue) != 1 || state.UpdatedAt.IsZero() {
+		t.Fatalf("unexpected stored state: %+v", state)
+	}
+
+	availability, err := svc.CheckDomainAvailability(ctx, "Free.Example")
+	if err != nil || !availability.Available || availability.Domain != "free.example" {
+		t.Fatalf("unexpected availability: %+v (%v)", availability, err)
+	}
+}
+
+func TestNamecheapRegistrar(t *testing.T) {
+	var commands []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("ApiKey") != "key" || r.Form.Get("ClientIp") != "198.51.100.7" {
+			_, _ = io.WriteString(w, `<ApiResponse Status="ERROR"><Errors><Error Number="1011102">API Key is invalid</Error></Errors></ApiResponse>`)
+			return
+		}
+		cmd := r.Form.Get("Command")
+		commands = append(commands, fmt.Sprintf("%s %s.%s %s %s %s %s", cmd, r.Form.Get("SLD"), r.Form.Get("TLD"),
+			r.Form.Get("Nameservers"), r.Form.Get("Nameserver"), r.Form.Get("OldIP"), r.Form.Get("IP")))
+		switch cmd {
+		case "namecheap.domains.check":
+			_, _ = io.WriteString(w, `<ApiResponse Status="OK"><CommandResponse><DomainCheckResult Domain="`+r.Form.Get("DomainList")+`" Available="true"/></CommandResponse></ApiResponse>`)
+		case "namecheap.domains.ns.getInfo":
+			_, _ = io.WriteString(w, `<ApiResponse Status="OK"><CommandResponse><DomainNSInfoResult Domain="example.co.uk" Nameserver="ns1.example.co.uk" IP="192.0.2.1"/></CommandResponse></ApiResponse>`)
+		default:
+			_, _ = io.WriteString(w, `<ApiResponse Status="OK"><CommandResponse/></ApiResponse>`)
+		}
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	bad := NewNamecheapRegistrar(NamecheapOptions{Endpoint: server.URL, APIUser: "user", APIKey: "wrong", ClientIP: "198.51.100.7"})
+	if _, err := bad.CheckAvailability(ctx, "example.com"); err == nil || !strings.Contains(err.Error(), "API Key is invalid") {
+		t.Fatalf("expected API error, got %v", err)
+	}
+
+	nc := NewNamecheapRegistrar(NamecheapOptions{Endpoint: server.URL, APIUser: "user", APIKey: "key", ClientIP: "198.51.100.7"})
+	if available, err := nc.CheckAvailability(ctx, "example.com"); err != nil || !available {
+		t.Fatalf("expected available domain, got %t (%v)", available, err)
+	}
+	if err := nc.SetNameservers(ctx, "example.co.uk", []string{"ns1.example.co.uk", "ns2.example.net"}); err != nil {
+		t.Fatalf("set nameservers: %v", err)
+	}
+	if err := nc.SetGlueRecord(ctx, "example.co.uk", "ns1.example.co.uk", []string{"192.0.2.2"}); err != nil {
+		t.Fatalf("set glue: %v", err)
+	}
+	want := []string{
+		"namecheap.domains.check . " + "   ",
+		"namecheap.domains.dns.setCustom example.co.uk ns1.example.co.uk,ns2.example.net   ",
+		"namecheap.domains.ns.getInfo example.co.uk  ns1.example.co.uk  ",
+		"namecheap.domains.ns.update example.co.uk  ns1.example.co.uk 192.0.2.1 192.0.2.2",
+	}
+	if strings.Join(commands, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected commands:\n%s", strings.Join(commands, "\n"))
+	}
+}
+
+func TestOVHRegistrar_SignsRequests(t *testing.T) {
+	now := time.Unix(1760600000, 0)
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		target := "http://" + r.Host + r.URL.RequestURI()
+		sum := sha1.Sum([]byte(strings.Join([]string{"secret", "consumer", r.Method, target, string(body), "1760600000"}, "+"))) //nolint:gosec // G401: test mirrors the OVH scheme.
+		if r.Header.Get("X-Ovh-Signature") != "$1$"+hex.EncodeToString(sum[:]) || r.Header.Get("X-Ovh-Application") != "app" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = io.WriteString(w, `{"message":"Invalid signature"}`)
+			return
+		}
+		calls = append(calls, r.Method+" "+r.URL.RequestURI()+" "+string(body))
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/order/cart":
+			_ = json.NewEncoder(w).Encode(map[string]string{"cartId": "c1"})
+		case r.URL.Path == "/order/cart/c1/domain":
+			_, _ = io.WriteString(w, `[{"action":"create","orderable":false},{"action":"transfer","orderable":true}]`)
+		case r.URL.Path == "/domain/example.com/glueRecord" && r.Method == http.MethodGet:
+			_, _ = io.WriteString(w, `["ns1.example.com"]`)
+		default:
+			_, _ = io.WriteString(w, `null`)
+		}
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	ovh := NewOVHRegistrar(OVHOptions{Endpoint: server.URL, ApplicationKey: "app", ApplicationSecret: "secret", ConsumerKey: "consumer"})
+	ovh.now = func() time.Time { return now }
+	if available, err := ovh.CheckAvailability(ctx, "example.com"); err != nil || available {
+		t.Fatalf("expected registered domain, got %t (%v)", available, err)
+	}
+	if err := ovh.SetGlueRecord(ctx, "example.com", "ns1.example.com", []string{"192.0.2.53"}); err != nil {
+		t.Fatalf("set glue: %v", err)
+	}
+	if err := ovh.SetNameservers(ctx, "example.com", []string{"ns1.example.com", "ns2.example.net"}); err != nil {
+		t.Fatalf("set nameservers: %v", err)
+	}
+	want := []string{
+		`POST /order/cart {"ovhSubsidiary":"FR"}`,
+		`GET /order/cart/c1/domain?domain=example.com `,
+		`DELETE /order/cart/c1 `,
+		`GET /domain/example.com/glueRecord `,
+		`POST /domain/example.com/glueRecord/ns1.example.com/update {"ips":["192.0.2.53"]}`,
+		`PUT /domain/example.com {"nameServerType":"external"}`,
+		`POST /domain/example.com/nameServers/update {"nameServers":[{"host":"ns1.example.com"},{"host":"ns2.example.net"}]}`,
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected calls:\n%s", strings.Join(calls, "\n"))
+	}
+
+	ovh.opts.ApplicationSecret = "wrong"
+	if err := ovh.SetNameservers(ctx, "example.com", []string{"a.example.net", "b.example.net"}); err == nil || !strings.Contains(err.Error(), "Invalid signature") {
+		t.Fatalf("expected signature error, got %v", err)
+	}
+}
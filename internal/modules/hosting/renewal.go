@@ -0,0 +1,213 @@
+package hosting
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/internal/platform/notify"
+)
+
+// RenewCertificatesJob is the job type that renews one batch of certificates.
+const RenewCertificatesJob = "hosting.certificate.renew"
+
+const (
+	// renewalWindow matches certbot's own renew_before_expiry default.
+	renewalWindow = 30 * 24 * time.Hour
+	// renewalAlertThreshold is the number of consecutive failed renewals
+	// of one domain before an alert is sent.
+	renewalAlertThreshold = 2
+)
+
+// RenewalBatch is the payload of a RenewCertificatesJob.
+type RenewalBatch struct {
+	Domains []string `json:"domains"`
+}
+
+// RenewalCheck summarizes one pass over managed certificates.
+type RenewalCheck struct {
+	Checked int      `json:"checked"`
+	Due     []string `json:"due"`
+	JobID   int64    `json:"job_id,omitempty"`
+}
+
+// SetNotifier sets the alert channel for repeated renewal and cron failures.
+func (s *Service) SetNotifier(n notify.Func) {
+	s.notify = n
+}
+
+// RegisterJobs registers hosting job handlers and keeps q for enqueueing.
+func (s *Service) RegisterJobs(q *jobqueue.Queue) {
+	s.jobs = q
+	q.Register(RenewCertificatesJob, s.runRenewalJob)
+	q.Register(RunCronJob, s.runCronJob)
+	q.Register(UpdateWordPressJob, s.runWordPressUpdate)
+	q.Register(UpgradePHPJob, s.runPHPUpgrade)
+	q.Register(RolloutTemplateJob, s.runTemplateRollout)
+}
+
+// CheckRenewals inspects the certificate of every site and enqueues one
+// renewal batch for those expiring within renewalWindow. The renewal
+// heartbeat is pinged here when nothing is due, otherwise by the batch.
+func (s *Service) CheckRenewals(ctx context.Context) (RenewalCheck, error) {
+	if s.jobs == nil {
+		return RenewalCheck{}, fmt.Errorf("job queue is not configured")
+	}
+	sites, err := s.ListSites(ctx)
+	if err != nil {
+		s.heartbeat(ctx, s.cfg.CertRenewalHeartbeatURL, err)
+		return RenewalCheck{}, err
+	}
+	check := RenewalCheck{Due: []string{}}
+	deadline := time.Now().Add(renewalWindow)
+	for _, site := range sites {
+		notAfter, err := readCertificateExpiry(filepath.Join(s.letsEncryptDir, "live", site.Domain, "cert.pem"))
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				s.log.Warn("read certificate", "domain", site.Domain, "error", err.Error())
+			}
+			continue
+		}
+		check.Checked++
+		if notAfter.Before(deadline) {
+			check.Due = append(check.Due, site.Domain)
+		}
+	}
+	if len(check.Due) == 0 {
+		s.heartbeat(ctx, s.cfg.CertRenewalHeartbeatURL, nil)
+		return check, nil
+	}
+	id, err := s.jobs.Enqueue(ctx, RenewCertificatesJob, RenewalBatch{Domains: check.Due})
+	if err != nil {
+		s.heartbeat(ctx, s.cfg.CertRenewalHeartbeatURL, err)
+		return RenewalCheck{}, err
+	}
+	check.JobID = id
+	return check, nil
+}
+
+// runRenewalJob renews each domain in the batch and reloads nginx once if
+// any certificate changed, then pings the renewal heartbeat.
+func (s *Service) runRenewalJob(ctx context.Context, job jobqueue.Job) error {
+	err := s.renewBatch(ctx, job)
+	s.heartbeat(ctx, s.cfg.CertRenewalHeartbeatURL, err)
+	return err
+}
+
+func (s *Service) renewBatch(ctx context.Context, job jobqueue.Job) error {
+	var batch RenewalBatch
+	if err := json.Unmarshal(job.Payload, &batch); err != nil {
+		return fmt.Errorf("decode renewal batch: %w", err)
+	}
+	renewed := 0
+	var failed []string
+	for _, domain := range batch.Domains {
+		out, err := s.runner.Run(ctx, "certbot",
+			"renew",
+			"--cert-name", domain,
+			"--non-interactive",
+			"--config-dir", s.letsEncryptDir,
+		)
+		if err != nil {
+			detail := failureDetail(err, out)
+			s.recordCertificateFailure(ctx, domain, s.acmeStaging(ctx, nil), detail)
+			s.recordRenewalAttempt(ctx, domain, detail)
+			_ = s.writeAudit(ctx, "system", "hosting.certificate.renew_failed", "domain="+domain)
+			failed = append(failed, domain)
+			continue
+		}
+		s.recordRenewalAttempt(ctx, domain, "")
+		_ = s.writeAudit(ctx, "system", "hosting.certificate.renew", "domain="+domain)
+		renewed++
+	}
+	if renewed > 0 && s.nginx != nil {
+		if err := s.nginx.TestConfig(ctx); err != nil {
+			return fmt.Errorf("nginx config test after renewal: %w", err)
+		}
+		if err := s.nginx.Reload(ctx); err != nil {
+			return fmt.Errorf("reload nginx after renewal: %w", err)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("renewal failed for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// recordRenewalAttempt tracks consecutive failures per domain and alerts once
+// the threshold is reached. An empty detail records a success.
+func (s *Service) recordRenewalAttempt(ctx context.Context, domain, detail string) {
+	if s.store == nil {
+		return
+	}
+	now := time.Now().Unix()
+	var sql string
+	if detail == "" {
+		sql = fmt.Sprintf(`
+INSERT INTO certificate_renewals(domain, consecutive_failures, last_error, last_attempt_at, last_success_at)
+VALUES('%s',0,'',%d,%d)
+ON CONFLICT(domain) DO UPDATE SET consecutive_failures=0, last_error='', last_attempt_at=%d, last_success_at=%d;`,
+			sqlEscape(domain), now, now, now, now)
+	} else {
+		sql = fmt.Sprintf(`
+INSERT INTO certificate_renewals(domain, consecutive_failures, last_error, last_attempt_at)
+VALUES('%s',1,'%s',%d)
+ON CONFLICT(domain) DO UPDATE SET consecutive_failures=consecutive_failures+1, last_error=excluded.last_error, last_attempt_at=excluded.last_attempt_at;`,
+			sqlEscape(domain), sqlEscape(detail), now)
+	}
+	if err := s.store.ExecPanel(ctx, sql); err != nil {
+		s.log.Error("record renewal attempt", "domain", domain, "error", err.Error())
+		return
+	}
+	if detail == "" {
+		return
+	}
+
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT consecutive_failures FROM certificate_renewals WHERE domain = '%s';", sqlEscape(domain)))
+	if err != nil || len(rows) == 0 {
+		return
+	}
+	failures, err := toInt64(rows[0]["consecutive_failures"])
+	if err != nil || failures < renewalAlertThreshold {
+		return
+	}
+	s.log.Error("certificate renewal keeps failing", "domain", domain, "failures", failures, "error", detail)
+	if s.notify == nil {
+		return
+	}
+	subject := fmt.Sprintf("Certificate renewal failing for %s", domain)
+	body := fmt.Sprintf(
+		"Renewing the certificate for %s has failed %d times in a row.\n\nLast ACME error:\n%s\n",
+		domain, failures, detail,
+	)
+	if err := s.notify(ctx, subject, body); err != nil {
+		s.log.Error("send renewal alert", "domain", domain, "error", err.Error())
+	}
+}
+
+func readCertificateExpiry(path string) (time.Time, error) {
+	// Path is derived from a site domain under the letsencrypt config dir.
+	//nolint:gosec // G304
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return time.Time{}, fmt.Errorf("invalid certificate %s", path)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse certificate %s: %w", path, err)
+	}
+	return cert.NotAfter, nil
+}
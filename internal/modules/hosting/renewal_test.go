@@ -0,0 +1,130 @@
+package hosting
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+)
+
+func writeTestCertificate(t *testing.T, dir, domain string, notAfter time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	liveDir := filepath.Join(dir, "live", domain)
+	if err := os.MkdirAll(liveDir, 0o755); err != nil {
+		t.Fatalf("mkdir live dir: %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(filepath.Join(liveDir, "cert.pem"), certPEM, 0o644); err != nil {
+		t.Fatalf("write certificate: %v", err)
+	}
+}
+
+func TestCheckRenewals_RenewsExpiringCertificatesInOneBatch(t *testing.T) {
+	ctx := context.Background()
+	runner := &fakeRunner{}
+	svc := newACMEService(t, config.Config{}, runner)
+	seedSite(t, svc, "fresh.example.com")
+	seedSite(t, svc, "nocert.example.com")
+	nginx := &fakeNginxAdapter{}
+	svc.nginx = nginx
+	writeTestCertificate(t, svc.letsEncryptDir, "example.com", time.Now().Add(10*24*time.Hour))
+	writeTestCertificate(t, svc.letsEncryptDir, "fresh.example.com", time.Now().Add(60*24*time.Hour))
+
+	queue := jobqueue.New(svc.store, nil)
+	svc.RegisterJobs(queue)
+	check, err := svc.CheckRenewals(ctx)
+	if err != nil {
+		t.Fatalf("check renewals: %v", err)
+	}
+	if check.Checked != 2 || len(check.Due) != 1 || check.Due[0] != "example.com" || check.JobID == 0 {
+		t.Fatalf("unexpected renewal check: %+v", check)
+	}
+	if _, err := queue.RunPending(ctx); err != nil {
+		t.Fatalf("run jobs: %v", err)
+	}
+	job, err := queue.Get(ctx, check.JobID)
+	if err != nil || job.Status != jobqueue.StatusDone {
+		t.Fatalf("expected renewal job to succeed, got %+v (%v)", job, err)
+	}
+	want := "certbot renew --cert-name example.com --non-interactive --config-dir " + svc.letsEncryptDir
+	if !containsCommand(runner.commands, want) {
+		t.Fatalf("expected %q, got %v", want, runner.commands)
+	}
+	if nginx.testCalls != 1 || nginx.reloadCalls != 1 {
+		t.Fatalf("expected one nginx test+reload per batch, got test=%d reload=%d", nginx.testCalls, nginx.reloadCalls)
+	}
+}
+
+func TestRenewalJob_AlertsOnRepeatedFailures(t *testing.T) {
+	ctx := context.Background()
+	runner := &fakeRunner{}
+	svc := newACMEService(t, config.Config{}, runner)
+	nginx := &fakeNginxAdapter{}
+	svc.nginx = nginx
+	renewCmd := "certbot renew --cert-name example.com --non-interactive --config-dir " + svc.letsEncryptDir
+	runner.errs = map[string]error{renewCmd: fmt.Errorf("exit status 1")}
+	runner.outputs = map[string]string{renewCmd: "urn:ietf:params:acme:error:rateLimited"}
+	var alerts []string
+	svc.SetNotifier(func(_ context.Context, subject, body string) error {
+		alerts = append(alerts, subject+"\n"+body)
+		return nil
+	})
+	writeTestCertificate(t, svc.letsEncryptDir, "example.com", time.Now().Add(24*time.Hour))
+
+	queue := jobqueue.New(svc.store, nil)
+	svc.RegisterJobs(queue)
+	for i := 0; i < 2; i++ {
+		check, err := svc.CheckRenewals(ctx)
+		if err != nil {
+			t.Fatalf("check renewals: %v", err)
+		}
+		if _, err := queue.RunPending(ctx); err != nil {
+			t.Fatalf("run jobs: %v", err)
+		}
+		job, _ := queue.Get(ctx, check.JobID)
+		if job.Status != jobqueue.StatusFailed {
+			t.Fatalf("expected failed renewal job, got %+v", job)
+		}
+		if i == 0 && len(alerts) != 0 {
+			t.Fatalf("expected no alert after a single failure, got %v", alerts)
+		}
+	}
+	if len(alerts) != 1 || !strings.Contains(alerts[0], "rateLimited") {
+		t.Fatalf("expected one alert with the ACME error, got %v", alerts)
+	}
+	if nginx.reloadCalls != 0 {
+		t.Fatalf("expected no reload when nothing renewed, got %d", nginx.reloadCalls)
+	}
+	failures, err := svc.ListCertificateFailures(ctx, "example.com", 0)
+	if err != nil || len(failures) != 2 {
+		t.Fatalf("expected renewal failures to be recorded, got %+v (%v)", failures, err)
+	}
+}
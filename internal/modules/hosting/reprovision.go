@@ -0,0 +1,69 @@
+package hosting
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ReprovisionSite recreates the server side of an existing site row — the
+// system user, docroot, PHP-FPM pool and nginx vhost — after panel.db was
+// restored onto a fresh server. Docroot content is left to the file
+// restore; certificates are issued separately.
+func (s *Service) ReprovisionSite(ctx context.Context, id int64, actor string) (Site, error) {
+	if s.store == nil || s.nginx == nil || s.phpfpm == nil {
+		return Site{}, fmt.Errorf("hosting service is not fully configured")
+	}
+	site, err := s.GetSite(ctx, id)
+	if err != nil {
+		return Site{}, err
+	}
+	rootBaseDir := filepath.Dir(site.RootDir)
+	if err := os.MkdirAll(filepath.Dir(rootBaseDir), 0o750); err != nil {
+		return Site{}, fmt.Errorf("prepare web root: %w", err)
+	}
+	if filepath.Dir(rootBaseDir) == s.webRoot {
+		if _, err := s.runner.Run(ctx, "chown", rootWebOwner+":"+nginxContentReaderGroup, s.webRoot); err != nil {
+			return Site{}, fmt.Errorf("set web root owner/group: %w", err)
+		}
+		if _, err := s.runner.Run(ctx, "chmod", "0750", s.webRoot); err != nil {
+			return Site{}, fmt.Errorf("set web root permissions: %w", err)
+		}
+	}
+	if err := os.MkdirAll(site.RootDir, 0o750); err != nil {
+		return Site{}, fmt.Errorf("create docroot: %w", err)
+	}
+	if _, err := s.runner.Run(ctx, "id", site.SystemUser); err != nil {
+		if _, err := s.runner.Run(ctx,
+			"useradd",
+			"--system",
+			"--create-home",
+			"--home-dir", rootBaseDir,
+			"--shell", "/usr/sbin/nologin",
+			site.SystemUser,
+		); err != nil {
+			return Site{}, fmt.Errorf("create system user: %w", err)
+		}
+	}
+	if _, err := s.runner.Run(ctx, "chown", "-R", site.SystemUser+":"+nginxContentReaderGroup, rootBaseDir); err != nil {
+		return Site{}, fmt.Errorf("chown site directory: %w", err)
+	}
+	cfg, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return Site{}, err
+	}
+	if err := s.applySiteConfig(ctx, cfg, cfg); err != nil {
+		return Site{}, err
+	}
+	// The chown above took the site home back from root; SFTP access
+	// restored with panel.db needs it again.
+	if sftp, err := s.siteSFTP(ctx, site); err == nil && s.sshd != nil {
+		if err := s.writeSFTPAccount(ctx, site, sftp.PublicKey); err != nil {
+			return Site{}, fmt.Errorf("restore sftp access: %w", err)
+		}
+	}
+	s.sitesCache.Purge()
+	_ = s.writeAudit(ctx, actor, "hosting.site.reprovision", "domain="+site.Domain)
+	return site, nil
+}
@@ -0,0 +1,52 @@
+package hosting
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestService_ReprovisionSiteRecreatesUserPoolAndVhost(t *testing.T) {
+	ctx := context.Background()
+	runner := &fakeRunner{errs: map[string]error{"id site_example_com": fmt.Errorf("no such user")}}
+	nginx := &fakeNginxAdapter{}
+	phpfpm := &fakePHPFPMAdapter{}
+	svc := newTestService(t, withRunner(runner), withNginx(nginx), withPHPFPM(phpfpm))
+	seedSite(t, svc, "example.com")
+	rootDir := filepath.Join(svc.webRoot, "example.com", "public_html")
+
+	site, err := svc.ReprovisionSite(ctx, 1, "cli")
+	if err != nil {
+		t.Fatalf("reprovision: %v", err)
+	}
+	if site.Domain != "example.com" {
+		t.Fatalf("unexpected site: %+v", site)
+	}
+	if info, err := os.Stat(rootDir); err != nil || !info.IsDir() {
+		t.Fatalf("expected docroot recreated: %v", err)
+	}
+	if !containsCommand(runner.commands, "useradd --system --create-home --home-dir "+filepath.Dir(rootDir)+" --shell /usr/sbin/nologin site_example_com") {
+		t.Fatalf("expected the system user recreated, got %v", runner.commands)
+	}
+	if !containsCommand(runner.commands, "chown -R site_example_com:www-data "+filepath.Dir(rootDir)) {
+		t.Fatalf("expected restored files handed to the new user, got %v", runner.commands)
+	}
+	if len(nginx.writeCalls) != 1 || len(phpfpm.writeCalls) != 1 || phpfpm.writeCalls[0].PHPVersion != "8.3" {
+		t.Fatalf("expected vhost and 8.3 pool written, got nginx=%d phpfpm=%+v", len(nginx.writeCalls), phpfpm.writeCalls)
+	}
+
+	// A second run finds the user and only rewrites config.
+	runner.errs = nil
+	runner.commands = nil
+	if _, err := svc.ReprovisionSite(ctx, 1, "cli"); err != nil {
+		t.Fatalf("second reprovision: %v", err)
+	}
+	for _, cmd := range runner.commands {
+		if strings.HasPrefix(cmd, "useradd") {
+			t.Fatalf("expected the existing user kept, got %v", runner.commands)
+		}
+	}
+}
@@ -0,0 +1,610 @@
+package hosting
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/internal/platform/templates"
+)
+
+// RolloutTemplateJob applies a vhost template change to canary sites,
+// watches them, then saves it for every site or rolls it back.
+const RolloutTemplateJob = "hosting.template.rollout"
+
+// Template rollout statuses.
+const (
+	RolloutQueued = "queued"
+	// RolloutSoaking means the canary sites run the new template and are
+	// being watched.
+	RolloutSoaking = "soaking"
+	// RolloutPromoting means the template is saved and the remaining
+	// vhosts are being rewritten.
+	RolloutPromoting = "promoting"
+	RolloutCompleted = "completed"
+	// RolloutRolledBack means a canary site got worse; the canaries were
+	// put back and the template was not saved.
+	RolloutRolledBack = "rolled_back"
+	RolloutFailed     = "failed"
+)
+
+const (
+	// defaultRolloutSoak is how long canary sites are watched before the
+	// template reaches the other sites.
+	defaultRolloutSoak = 10 * time.Minute
+	maxRolloutSoak     = 24 * time.Hour
+	// defaultCanaryPercent is the share of sites, oldest first, that get
+	// a template change first.
+	defaultCanaryPercent = 10
+)
+
+var (
+	// ErrRolloutNotFound indicates a missing template_rollouts row.
+	ErrRolloutNotFound = errors.New("template rollout not found")
+	// ErrRolloutInProgress indicates another rollout is not finished.
+	ErrRolloutInProgress = errors.New("template rollout already in progress")
+)
+
+// vhostRoller is implemented by the file-backed nginx adapter; rollouts
+// write canary vhosts rendered from a template that is not installed yet.
+type vhostRoller interface {
+	vhostPreviewer
+	WriteRenderedVhost(ctx context.Context, domain, content string) error
+	TemplatePath() string
+}
+
+// TemplateRolloutRequest starts a canary rollout of a new vhost template.
+type TemplateRolloutRequest struct {
+	Content string `json:"content"`
+	// CanarySiteIDs picks the canary sites; empty picks CanaryPercent of
+	// the sites, oldest first.
+	CanarySiteIDs []int64 `json:"canary_site_ids,omitempty"`
+	// CanaryPercent overrides defaultCanaryPercent.
+	CanaryPercent int `json:"canary_percent,omitempty"`
+	// SoakSeconds overrides how long the canaries are watched.
+	SoakSeconds int `json:"soak_seconds,omitempty"`
+	// MaxErrorRate overrides defaultMaxErrorRate.
+	MaxErrorRate float64 `json:"max_error_rate,omitempty"`
+	Actor        string  `json:"-"`
+}
+
+// RolloutCanary is how one canary site fared during the soak. Requests
+// and ErrorRate cover the soak; BaselineRate is the 5xx share before it,
+// in percent.
+type RolloutCanary struct {
+	SiteID       int64   `json:"site_id"`
+	Domain       string  `json:"domain"`
+	Healthy      bool    `json:"healthy"`
+	Detail       string  `json:"detail,omitempty"`
+	Requests     int     `json:"requests"`
+	ErrorRate    float64 `json:"error_rate"`
+	BaselineRate float64 `json:"baseline_rate"`
+}
+
+// TemplateRollout is one canary rollout. Diff is the change to the live
+// template; Promoted counts the vhosts rewritten once it was saved.
+type TemplateRollout struct {
+	ID        int64           `json:"id"`
+	JobID     int64           `json:"job_id"`
+	Template  string          `json:"template"`
+	SHA256    string          `json:"sha256"`
+	Diff      string          `json:"diff"`
+	Status    string          `json:"status"`
+	Canaries  []RolloutCanary `json:"canaries"`
+	Promoted  int             `json:"promoted"`
+	Error     string          `json:"error,omitempty"`
+	Actor     string          `json:"actor"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// TemplateRolloutProgress is a rollout together with a chunk of its job
+// log.
+type TemplateRolloutProgress struct {
+	Rollout    TemplateRollout `json:"rollout"`
+	Log        string          `json:"log"`
+	NextOffset int64           `json:"next_offset"`
+}
+
+type templateRolloutPayload struct {
+	RolloutID int64                  `json:"rollout_id"`
+	Request   TemplateRolloutRequest `json:"request"`
+	Actor     string                 `json:"actor"`
+}
+
+// SetTemplateStore sets the store rollouts save promoted vhost templates
+// to, so the change is tracked like an edit made through the templates
+// API.
+func (s *Service) SetTemplateStore(store *templates.Store) {
+	s.templates = store
+}
+
+// StartTemplateRollout validates req and enqueues the rollout. Only one
+// rollout runs at a time.
+func (s *Service) StartTemplateRollout(ctx context.Context, req TemplateRolloutRequest) (TemplateRollout, error) {
+	if s.store == nil || s.nginx == nil {
+		return TemplateRollout{}, fmt.Errorf("hosting service is not fully configured")
+	}
+	if s.jobs == nil {
+		return TemplateRollout{}, fmt.Errorf("job queue is not configured")
+	}
+	if s.templates == nil {
+		return TemplateRollout{}, fmt.Errorf("template store is not configured")
+	}
+	roller, ok := s.nginx.(vhostRoller)
+	if !ok {
+		return TemplateRollout{}, fmt.Errorf("nginx adapter does not support rollouts")
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		return TemplateRollout{}, fmt.Errorf("invalid rollout: content is required")
+	}
+	if _, err := template.New("vhost").Parse(req.Content); err != nil {
+		return TemplateRollout{}, fmt.Errorf("invalid template: %v", err)
+	}
+	if req.CanaryPercent < 0 || req.CanaryPercent > 100 {
+		return TemplateRollout{}, fmt.Errorf("invalid canary_percent: must be between 0 and 100")
+	}
+	if req.SoakSeconds < 0 || time.Duration(req.SoakSeconds)*time.Second > maxRolloutSoak {
+		return TemplateRollout{}, fmt.Errorf("invalid soak_seconds: must be between 0 and %d", int(maxRolloutSoak.Seconds()))
+	}
+	if req.MaxErrorRate < 0 || req.MaxErrorRate > 100 {
+		return TemplateRollout{}, fmt.Errorf("invalid max_error_rate: must be between 0 and 100")
+	}
+	for _, id := range req.CanarySiteIDs {
+		if _, err := s.GetSite(ctx, id); err != nil {
+			if errors.Is(err, ErrSiteNotFound) {
+				return TemplateRollout{}, fmt.Errorf("invalid canary site %d: %w", id, err)
+			}
+			return TemplateRollout{}, err
+		}
+	}
+	name := filepath.Base(roller.TemplatePath())
+	live, _, err := s.templates.Get(name)
+	if err != nil {
+		return TemplateRollout{}, fmt.Errorf("read template %s: %w", name, err)
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT id FROM template_rollouts WHERE status IN ('%s','%s','%s') LIMIT 1;", RolloutQueued, RolloutSoaking, RolloutPromoting))
+	if err != nil {
+		return TemplateRollout{}, fmt.Errorf("check template rollouts: %w", err)
+	}
+	if len(rows) > 0 {
+		return TemplateRollout{}, fmt.Errorf("%w (rollout %v)", ErrRolloutInProgress, rows[0]["id"])
+	}
+	sum := sha256.Sum256([]byte(req.Content))
+	diff := templates.UnifiedDiff("live/"+name, "rollout/"+name, live, req.Content)
+	now := time.Now().Unix()
+	rows, err = s.store.QueryPanelWriteJSON(ctx, fmt.Sprintf(`
+INSERT INTO template_rollouts(template, sha256, diff, status, actor, created_at, updated_at)
+VALUES('%s','%s','%s','%s','%s',%d,%d)
+RETURNING id;`, sqlEscape(name), hex.EncodeToString(sum[:]), sqlEscape(diff), RolloutQueued, sqlEscape(req.Actor), now, now))
+	if err != nil {
+		return TemplateRollout{}, fmt.Errorf("insert template rollout: %w", err)
+	}
+	if len(rows) == 0 {
+		return TemplateRollout{}, fmt.Errorf("insert template rollout: no id returned")
+	}
+	id, err := toInt64(rows[0]["id"])
+	if err != nil {
+		return TemplateRollout{}, fmt.Errorf("parse template rollout id: %w", err)
+	}
+	jobID, err := s.jobs.Enqueue(ctx, RolloutTemplateJob, templateRolloutPayload{RolloutID: id, Request: req, Actor: req.Actor})
+	if err != nil {
+		_ = s.store.ExecPanel(ctx, fmt.Sprintf("DELETE FROM template_rollouts WHERE id = %d;", id))
+		return TemplateRollout{}, err
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf("UPDATE template_rollouts SET job_id = %d WHERE id = %d;", jobID, id)); err != nil {
+		return TemplateRollout{}, fmt.Errorf("record template rollout job: %w", err)
+	}
+	_ = s.writeAudit(ctx, req.Actor, "hosting.template.rollout_requested", fmt.Sprintf("rollout_id=%d template=%s job_id=%d", id, name, jobID))
+	return s.GetTemplateRollout(ctx, id)
+}
+
+// ListTemplateRollouts returns the newest rollouts first.
+func (s *Service) ListTemplateRollouts(ctx context.Context, limit int) ([]TemplateRollout, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT id, job_id, template, sha256, diff, status, canaries, promoted, error, actor, created_at, updated_at
+FROM template_rollouts
+ORDER BY id DESC
+LIMIT %d;`, limit))
+	if err != nil {
+		return nil, fmt.Errorf("list template rollouts: %w", err)
+	}
+	out := make([]TemplateRollout, 0, len(rows))
+	for _, row := range rows {
+		r, err := templateRolloutFromRow(row)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// GetTemplateRollout returns one rollout.
+func (s *Service) GetTemplateRollout(ctx context.Context, id int64) (TemplateRollout, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT id, job_id, template, sha256, diff, status, canaries, promoted, error, actor, created_at, updated_at
+FROM template_rollouts
+WHERE id = %d;`, id))
+	if err != nil {
+		return TemplateRollout{}, fmt.Errorf("get template rollout: %w", err)
+	}
+	if len(rows) == 0 {
+		return TemplateRollout{}, ErrRolloutNotFound
+	}
+	return templateRolloutFromRow(rows[0])
+}
+
+// TemplateRolloutProgress returns a rollout and its job log from offset.
+func (s *Service) TemplateRolloutProgress(ctx context.Context, id, offset int64) (TemplateRolloutProgress, error) {
+	r, err := s.GetTemplateRollout(ctx, id)
+	if err != nil {
+		return TemplateRolloutProgress{}, err
+	}
+	progress := TemplateRolloutProgress{Rollout: r, NextOffset: offset}
+	if s.jobs != nil && r.JobID > 0 {
+		out, next, err := s.jobs.ReadLog(r.JobID, offset)
+		if err != nil {
+			return TemplateRolloutProgress{}, err
+		}
+		progress.Log, progress.NextOffset = out, next
+	}
+	return progress, nil
+}
+
+func (s *Service) runTemplateRollout(ctx context.Context, job jobqueue.Job) error {
+	var payload templateRolloutPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("decode template rollout payload: %w", err)
+	}
+	logw, err := s.jobs.LogWriter(job.ID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = logw.Close()
+	}()
+	r, err := s.GetTemplateRollout(ctx, payload.RolloutID)
+	if err != nil {
+		return err
+	}
+	err = s.rolloutTemplate(ctx, &r, payload.Request, logw)
+	// The outcome is recorded even when the job was cancelled.
+	ctx = context.WithoutCancel(ctx)
+	if err != nil {
+		r.Status, r.Error = RolloutFailed, err.Error()
+		_, _ = fmt.Fprintf(logw, "rollout failed: %v\n", err)
+		_ = s.saveTemplateRollout(ctx, r)
+		_ = s.writeAudit(ctx, payload.Actor, "hosting.template.rollout_failed", fmt.Sprintf("rollout_id=%d template=%s", r.ID, r.Template))
+		s.notifyRollout(ctx, r, fmt.Sprintf("Template %s rollout failed", r.Template),
+			fmt.Sprintf("The rollout of %s failed: %v\n\nThe template was not changed.\n", r.Template, err))
+		return err
+	}
+	if err := s.saveTemplateRollout(ctx, r); err != nil {
+		return err
+	}
+	if r.Status == RolloutRolledBack {
+		var bad []string
+		for _, c := range r.Canaries {
+			if !c.Healthy {
+				bad = append(bad, c.Domain+": "+c.Detail)
+			}
+		}
+		_ = s.writeAudit(ctx, payload.Actor, "hosting.template.rollback", fmt.Sprintf("rollout_id=%d template=%s canaries=%d unhealthy=%d", r.ID, r.Template, len(r.Canaries), len(bad)))
+		s.notifyRollout(ctx, r, fmt.Sprintf("Template %s rollout rolled back", r.Template),
+			fmt.Sprintf("The new %s made %d canary site(s) worse; they were put back and the template was not changed:\n\n%s\n",
+				r.Template, len(bad), strings.Join(bad, "\n")))
+		return nil
+	}
+	_ = s.writeAudit(ctx, payload.Actor, "hosting.template.rollout", fmt.Sprintf("rollout_id=%d template=%s canaries=%d promoted=%d", r.ID, r.Template, len(r.Canaries), r.Promoted))
+	return nil
+}
+
+func (s *Service) notifyRollout(ctx context.Context, r TemplateRollout, subject, body string) {
+	if s.notify == nil {
+		return
+	}
+	if err := s.notify(ctx, subject, body); err != nil {
+		s.log.Warn("send template rollout alert", "rollout_id", r.ID, "error", err.Error())
+	}
+}
+
+// rolloutTemplate renders the new template for every site, puts it on
+// the canaries, watches their access logs for the soak, then either puts
+// the canaries back or saves the template and rewrites every vhost.
+func (s *Service) rolloutTemplate(ctx context.Context, r *TemplateRollout, req TemplateRolloutRequest, logw io.Writer) error {
+	roller, ok := s.nginx.(vhostRoller)
+	if !ok {
+		return fmt.Errorf("nginx adapter does not support rollouts")
+	}
+	sites, err := s.ListSites(ctx)
+	if err != nil {
+		return err
+	}
+	// Oldest sites first, the order they were created in.
+	slices.Reverse(sites)
+
+	// A template that breaks on any site's data reaches none of them.
+	rendered := make(map[int64]string, len(sites))
+	all := make([]string, 0, len(sites))
+	for _, site := range sites {
+		cfg, err := s.siteConfig(ctx, site)
+		if err != nil {
+			return fmt.Errorf("%s: %w", site.Domain, err)
+		}
+		content, err := roller.PreviewVhost(cfg, req.Content)
+		if err != nil {
+			return fmt.Errorf("render %s: %w", site.Domain, err)
+		}
+		rendered[site.ID] = content
+		all = append(all, content)
+	}
+	if len(all) > 0 {
+		if out, err := roller.TestStaged(ctx, all...); err != nil {
+			return errors.New(failureDetail(err, out))
+		}
+	}
+	_, _ = fmt.Fprintf(logw, "rendered %s for %d site(s); staged config test passed\n", r.Template, len(sites))
+
+	canaries := pickCanaries(sites, req)
+	if len(canaries) > 0 {
+		healthy, err := s.soakCanaries(ctx, r, req, canaries, rendered, logw)
+		if err != nil {
+			return err
+		}
+		if !healthy {
+			r.Status = RolloutRolledBack
+			return nil
+		}
+	}
+
+	r.Status = RolloutPromoting
+	if err := s.saveTemplateRollout(ctx, *r); err != nil {
+		return err
+	}
+	// Past this point the template is live and has to reach every site
+	// or none, even if the job is cancelled.
+	ctx = context.WithoutCancel(ctx)
+	previous, _, err := s.templates.Get(r.Template)
+	if err != nil {
+		_ = s.restoreVhosts(ctx, canaries)
+		return fmt.Errorf("read template %s: %w", r.Template, err)
+	}
+	if err := s.templates.Put(r.Template, req.Content); err != nil {
+		_ = s.restoreVhosts(ctx, canaries)
+		return fmt.Errorf("save template %s: %w", r.Template, err)
+	}
+	_, _ = fmt.Fprintf(logw, "saved %s; rewriting %d vhost(s)\n", r.Template, len(sites))
+	// Sites are listed again: certificates and settings may have changed
+	// during the soak.
+	if current, err := s.ListSites(ctx); err == nil {
+		sites = current
+	}
+	if err := s.rewriteVhosts(ctx, sites); err != nil {
+		_, _ = fmt.Fprintf(logw, "promotion failed, restoring the previous template: %v\n", err)
+		if putErr := s.templates.Put(r.Template, previous); putErr != nil {
+			return fmt.Errorf("%w; restore template: %v", err, putErr)
+		}
+		if restoreErr := s.restoreVhosts(ctx, sites); restoreErr != nil {
+			return fmt.Errorf("%w; restore vhosts: %v", err, restoreErr)
+		}
+		return err
+	}
+	r.Promoted = len(sites)
+	r.Status = RolloutCompleted
+	_, _ = fmt.Fprintf(logw, "rollout of %s completed: %d canary site(s), %d vhost(s) rewritten\n", r.Template, len(canaries), r.Promoted)
+	return nil
+}
+
+// soakCanaries writes the new vhosts of the canary sites, watches their
+// access logs for the soak and puts them back when any got worse. It
+// reports whether all canaries stayed healthy.
+func (s *Service) soakCanaries(ctx context.Context, r *TemplateRollout, req TemplateRolloutRequest, canaries []Site, rendered map[int64]string, logw io.Writer) (bool, error) {
+	roller, _ := s.nginx.(vhostRoller)
+	maxRate := req.MaxErrorRate
+	if maxRate == 0 {
+		maxRate = defaultMaxErrorRate
+	}
+	soak := s.rolloutSoak
+	if req.SoakSeconds > 0 {
+		soak = time.Duration(req.SoakSeconds) * time.Second
+	}
+	offsets := make([]int64, len(canaries))
+	r.Canaries = make([]RolloutCanary, len(canaries))
+	for i, site := range canaries {
+		logPath := filepath.Join(s.accessLogDir, site.Domain+".access.log")
+		offsets[i] = fileSize(logPath)
+		baseline, _ := scanAccessLog(logPath, max(0, offsets[i]-accessLogBaseline), offsets[i])
+		r.Canaries[i] = RolloutCanary{SiteID: site.ID, Domain: site.Domain, BaselineRate: baseline.rate()}
+	}
+	write := func() error {
+		for _, site := range canaries {
+			if err := roller.WriteRenderedVhost(ctx, site.Domain, rendered[site.ID]); err != nil {
+				return fmt.Errorf("write %s vhost: %w", site.Domain, err)
+			}
+		}
+		if err := s.nginx.TestConfig(ctx); err != nil {
+			return fmt.Errorf("test nginx config: %w", err)
+		}
+		return s.nginx.Reload(ctx)
+	}
+	if err := write(); err != nil {
+		_ = s.restoreVhosts(context.WithoutCancel(ctx), canaries)
+		return false, err
+	}
+	r.Status = RolloutSoaking
+	if err := s.saveTemplateRollout(ctx, *r); err != nil {
+		_ = s.restoreVhosts(context.WithoutCancel(ctx), canaries)
+		return false, err
+	}
+	domains := make([]string, len(canaries))
+	for i, site := range canaries {
+		domains[i] = site.Domain
+	}
+	_, _ = fmt.Fprintf(logw, "canary: %s; soaking for %s\n", strings.Join(domains, ", "), soak)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(soak):
+	}
+	interrupted := ctx.Err()
+	ctx = context.WithoutCancel(ctx)
+	healthy := true
+	for i, site := range canaries {
+		c := &r.Canaries[i]
+		logPath := filepath.Join(s.accessLogDir, site.Domain+".access.log")
+		after, _ := scanAccessLog(logPath, offsets[i], -1)
+		c.Requests, c.ErrorRate = after.requests, after.rate()
+		switch {
+		case after.elevatedOver(c.BaselineRate, maxRate):
+			c.Detail = fmt.Sprintf("5xx rate rose to %.1f%% of %d request(s) from %.1f%%", c.ErrorRate, after.requests, c.BaselineRate)
+		default:
+			if probe := s.probeHTTP(ctx, site); probe.Status != CheckPass {
+				c.Detail = probe.Detail
+			}
+		}
+		c.Healthy = c.Detail == ""
+		if c.Healthy {
+			c.Detail = fmt.Sprintf("%d request(s) watched, %.1f%% 5xx", after.requests, c.ErrorRate)
+		} else {
+			healthy = false
+		}
+		_, _ = fmt.Fprintf(logw, "%s: healthy=%t %s\n", site.Domain, c.Healthy, c.Detail)
+	}
+	if interrupted != nil || !healthy {
+		_, _ = fmt.Fprintln(logw, "rolling back the canary sites")
+		if err := s.restoreVhosts(ctx, canaries); err != nil {
+			return false, fmt.Errorf("roll back canaries: %w", err)
+		}
+		if interrupted != nil {
+			return false, fmt.Errorf("rollout was cancelled during the soak: %w", interrupted)
+		}
+	}
+	return healthy, nil
+}
+
+// pickCanaries returns the sites that get the template first: the ones
+// asked for, or CanaryPercent of sites (at least one), oldest first.
+func pickCanaries(sites []Site, req TemplateRolloutRequest) []Site {
+	if len(req.CanarySiteIDs) > 0 {
+		var out []Site
+		for _, site := range sites {
+			if slices.Contains(req.CanarySiteIDs, site.ID) {
+				out = append(out, site)
+			}
+		}
+		return out
+	}
+	if len(sites) == 0 {
+		return nil
+	}
+	percent := req.CanaryPercent
+	if percent == 0 {
+		percent = defaultCanaryPercent
+	}
+	n := max(1, (len(sites)*percent+99)/100)
+	return sites[:min(n, len(sites))]
+}
+
+// rewriteVhosts renders the vhosts of sites from the installed template,
+// tests the config and reloads nginx.
+func (s *Service) rewriteVhosts(ctx context.Context, sites []Site) error {
+	for _, site := range sites {
+		cfg, err := s.siteConfig(ctx, site)
+		if err != nil {
+			return fmt.Errorf("%s: %w", site.Domain, err)
+		}
+		if err := s.nginx.WriteVhost(ctx, cfg); err != nil {
+			return fmt.Errorf("write %s vhost: %w", site.Domain, err)
+		}
+	}
+	if err := s.nginx.TestConfig(ctx); err != nil {
+		return fmt.Errorf("test nginx config: %w", err)
+	}
+	if err := s.nginx.Reload(ctx); err != nil {
+		return fmt.Errorf("reload nginx: %w", err)
+	}
+	return nil
+}
+
+// restoreVhosts puts sites back on the installed template.
+func (s *Service) restoreVhosts(ctx context.Context, sites []Site) error {
+	if len(sites) == 0 {
+		return nil
+	}
+	if err := s.rewriteVhosts(ctx, sites); err != nil {
+		s.log.Error("restore vhosts", "error", err.Error())
+		return err
+	}
+	return nil
+}
+
+func (s *Service) saveTemplateRollout(ctx context.Context, r TemplateRollout) error {
+	if r.Canaries == nil {
+		r.Canaries = []RolloutCanary{}
+	}
+	canaries, err := json.Marshal(r.Canaries)
+	if err != nil {
+		return fmt.Errorf("encode template rollout canaries: %w", err)
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+UPDATE template_rollouts
+SET status = '%s', canaries = '%s', promoted = %d, error = '%s', updated_at = %d
+WHERE id = %d;`, sqlEscape(r.Status), sqlEscape(string(canaries)), r.Promoted, sqlEscape(r.Error), time.Now().Unix(), r.ID)); err != nil {
+		return fmt.Errorf("save template rollout: %w", err)
+	}
+	return nil
+}
+
+func templateRolloutFromRow(row map[string]any) (TemplateRollout, error) {
+	var r TemplateRollout
+	var err error
+	if r.ID, err = toInt64(row["id"]); err != nil {
+		return TemplateRollout{}, err
+	}
+	if r.JobID, err = toInt64(row["job_id"]); err != nil {
+		return TemplateRollout{}, err
+	}
+	promoted, err := toInt64(row["promoted"])
+	if err != nil {
+		return TemplateRollout{}, err
+	}
+	created, err := toInt64(row["created_at"])
+	if err != nil {
+		return TemplateRollout{}, err
+	}
+	updated, err := toInt64(row["updated_at"])
+	if err != nil {
+		return TemplateRollout{}, err
+	}
+	r.Promoted = int(promoted)
+	r.Template, _ = row["template"].(string)
+	r.SHA256, _ = row["sha256"].(string)
+	r.Diff, _ = row["diff"].(string)
+	r.Status, _ = row["status"].(string)
+	r.Error, _ = row["error"].(string)
+	r.Actor, _ = row["actor"].(string)
+	r.CreatedAt = time.Unix(created, 0).UTC()
+	r.UpdatedAt = time.Unix(updated, 0).UTC()
+	r.Canaries = []RolloutCanary{}
+	if raw, _ := row["canaries"].(string); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &r.Canaries); err != nil {
+			return TemplateRollout{}, fmt.Errorf("decode template rollout canaries: %w", err)
+		}
+	}
+	return r, nil
+}
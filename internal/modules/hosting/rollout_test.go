@@ -0,0 +1,214 @@
+package hosting
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/internal/platform/templates"
+)
+
+const (
+	rolloutTemplateV1 = "server { server_name {{ .Domain }}; } # v1\n"
+	rolloutTemplateV2 = "server { server_name {{ .Domain }}; gzip on; } # v2\n"
+)
+
+// reloadRunner calls onReload after each nginx reload, to fake traffic
+// that reaches the sites once they run the new config.
+type reloadRunner struct {
+	fakeRunner
+	onReload func()
+}
+
+func (r *reloadRunner) Run(ctx context.Context, name string, args ...string) (string, error) {
+	out, err := r.fakeRunner.Run(ctx, name, args...)
+	if name == "systemctl" && len(args) > 0 && args[0] == "reload" && r.onReload != nil {
+		r.onReload()
+	}
+	return out, err
+}
+
+// newRolloutService seeds three sites whose vhosts were rendered from
+// rolloutTemplateV1.
+func newRolloutService(t *testing.T) (*Service, *reloadRunner, *templates.Store, *jobqueue.Queue) {
+	t.Helper()
+	ctx := context.Background()
+	root := t.TempDir()
+	templateDir := filepath.Join(root, "templates")
+	templatePath := filepath.Join(templateDir, "nginx_vhost.conf.tmpl")
+	tplStore := templates.New(templateDir)
+	if _, err := tplStore.Install("nginx_vhost.conf.tmpl", templatePath, rolloutTemplateV1); err != nil {
+		t.Fatalf("install template: %v", err)
+	}
+	runner := &reloadRunner{}
+	nginx := NewNginxAdapter(runner, NginxAdapterOptions{
+		TemplatePath:      templatePath,
+		SitesAvailableDir: filepath.Join(root, "sites-available"),
+		SitesEnabledDir:   filepath.Join(root, "sites-enabled"),
+		NginxConfigPath:   filepath.Join(root, "conf", "nginx.conf"),
+	})
+	svc := newTestService(t, withNginx(nginx))
+	for _, domain := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		seedSite(t, svc, domain)
+	}
+	svc.SetTemplateStore(tplStore)
+	svc.accessLogDir = t.TempDir()
+	svc.rolloutSoak = 10 * time.Millisecond
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(web.Close)
+	svc.healthHTTPBase = web.URL
+
+	sites, err := svc.ListSites(ctx)
+	if err != nil {
+		t.Fatalf("list sites: %v", err)
+	}
+	if err := svc.rewriteVhosts(ctx, sites); err != nil {
+		t.Fatalf("write vhosts: %v", err)
+	}
+	queue := jobqueue.New(svc.store, nil)
+	svc.RegisterJobs(queue)
+	return svc, runner, tplStore, queue
+}
+
+func vhostOf(t *testing.T, svc *Service, domain string) string {
+	t.Helper()
+	path := filepath.Join(svc.nginx.(*NginxAdapter).sitesAvailableDir, domain+".conf")
+	raw, err := os.ReadFile(path) //nolint:gosec // test reads a file created within temp dir.
+	if err != nil {
+		t.Fatalf("read vhost: %v", err)
+	}
+	return string(raw)
+}
+
+func runRollout(t *testing.T, svc *Service, queue *jobqueue.Queue, req TemplateRolloutRequest) TemplateRollout {
+	t.Helper()
+	ctx := context.Background()
+	started, err := svc.StartTemplateRollout(ctx, req)
+	if err != nil {
+		t.Fatalf("start rollout: %v", err)
+	}
+	if started.Status != RolloutQueued || started.JobID == 0 || !strings.Contains(started.Diff, "+server { server_name {{ .Domain }}; gzip on; } # v2") {
+		t.Fatalf("unexpected queued rollout: %+v", started)
+	}
+	if _, err := queue.RunPending(ctx); err != nil {
+		t.Fatalf("run jobs: %v", err)
+	}
+	done, err := svc.GetTemplateRollout(ctx, started.ID)
+	if err != nil {
+		t.Fatalf("get rollout: %v", err)
+	}
+	return done
+}
+
+func TestService_TemplateRolloutPromotesAfterHealthySoak(t *testing.T) {
+	svc, runner, tplStore, queue := newRolloutService(t)
+	var duringSoak []string
+	runner.onReload = func() {
+		if duringSoak == nil {
+			for _, domain := range []string{"a.example.com", "b.example.com"} {
+				duringSoak = append(duringSoak, vhostOf(t, svc, domain))
+			}
+		}
+	}
+
+	r := runRollout(t, svc, queue, TemplateRolloutRequest{Content: rolloutTemplateV2, Actor: "admin@example.com"})
+	if r.Status != RolloutCompleted || r.Promoted != 3 {
+		t.Fatalf("expected the rollout promoted to every site, got %+v", r)
+	}
+	if len(r.Canaries) != 1 || r.Canaries[0].Domain != "a.example.com" || !r.Canaries[0].Healthy {
+		t.Fatalf("expected the oldest site as the healthy canary, got %+v", r.Canaries)
+	}
+	if len(duringSoak) != 2 || !strings.Contains(duringSoak[0], "# v2") || !strings.Contains(duringSoak[1], "# v1") {
+		t.Fatalf("expected only the canary on the new template during the soak, got %q", duringSoak)
+	}
+	for _, domain := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		if got := vhostOf(t, svc, domain); got != "server { server_name "+domain+"; gzip on; } # v2\n" {
+			t.Fatalf("expected %s on the new template, got %q", domain, got)
+		}
+	}
+	if content, status, err := tplStore.Get("nginx_vhost.conf.tmpl"); err != nil || content != rolloutTemplateV2 || status.State != templates.StateCustomized {
+		t.Fatalf("expected the template saved through the store, got %q %+v (%v)", content, status, err)
+	}
+	rows, err := svc.store.QueryAuditJSON(context.Background(), "SELECT action FROM audit_events WHERE action = 'hosting.template.rollout';")
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("expected a rollout audit event, got %+v (%v)", rows, err)
+	}
+}
+
+func TestService_TemplateRolloutRollsBackOnElevated5xx(t *testing.T) {
+	svc, runner, tplStore, queue := newRolloutService(t)
+	logPath := filepath.Join(svc.accessLogDir, "b.example.com.access.log")
+	runner.onReload = func() {
+		if !strings.Contains(vhostOf(t, svc, "b.example.com"), "# v2") {
+			return
+		}
+		f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			t.Errorf("open access log: %v", err)
+			return
+		}
+		defer f.Close()
+		for range 4 {
+			_, _ = fmt.Fprintln(f, `127.0.0.1 - - [16/Oct/2026:10:00:00 +0000] "GET / HTTP/1.1" 502 0 "-" "curl/8"`)
+		}
+	}
+
+	r := runRollout(t, svc, queue, TemplateRolloutRequest{Content: rolloutTemplateV2, CanarySiteIDs: []int64{2, 3}})
+	if r.Status != RolloutRolledBack || r.Promoted != 0 || len(r.Canaries) != 2 {
+		t.Fatalf("expected the rollout rolled back, got %+v", r)
+	}
+	if c := r.Canaries[0]; c.Domain != "b.example.com" || c.Healthy || c.Requests != 4 || !strings.Contains(c.Detail, "5xx rate rose to 100.0%") {
+		t.Fatalf("expected b.example.com unhealthy, got %+v", c)
+	}
+	if !r.Canaries[1].Healthy {
+		t.Fatalf("expected c.example.com healthy, got %+v", r.Canaries[1])
+	}
+	for _, domain := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		if got := vhostOf(t, svc, domain); !strings.Contains(got, "# v1") {
+			t.Fatalf("expected %s back on the installed template, got %q", domain, got)
+		}
+	}
+	if content, _, _ := tplStore.Get("nginx_vhost.conf.tmpl"); content != rolloutTemplateV1 {
+		t.Fatalf("expected the template unchanged, got %q", content)
+	}
+}
+
+func TestService_TemplateRolloutRefusesBrokenTemplates(t *testing.T) {
+	ctx := context.Background()
+	svc, _, _, queue := newRolloutService(t)
+
+	if _, err := svc.StartTemplateRollout(ctx, TemplateRolloutRequest{Content: "server { {{ .Domain "}); err == nil || !strings.Contains(err.Error(), "invalid template") {
+		t.Fatalf("expected an unparsable template refused, got %v", err)
+	}
+	if _, err := svc.StartTemplateRollout(ctx, TemplateRolloutRequest{Content: rolloutTemplateV2, CanarySiteIDs: []int64{42}}); err == nil || !strings.Contains(err.Error(), "invalid canary site 42") {
+		t.Fatalf("expected an unknown canary refused, got %v", err)
+	}
+
+	// Parses, but fails to render for the sites: nothing is written.
+	started, err := svc.StartTemplateRollout(ctx, TemplateRolloutRequest{Content: "server { server_name {{ index .RedirectHosts 0 }}; }\n"})
+	if err != nil {
+		t.Fatalf("start rollout: %v", err)
+	}
+	if _, err := svc.StartTemplateRollout(ctx, TemplateRolloutRequest{Content: rolloutTemplateV2}); err == nil || !strings.Contains(err.Error(), "already in progress") {
+		t.Fatalf("expected a second rollout refused, got %v", err)
+	}
+	if _, err := queue.RunPending(ctx); err != nil {
+		t.Fatalf("run jobs: %v", err)
+	}
+	r, err := svc.GetTemplateRollout(ctx, started.ID)
+	if err != nil || r.Status != RolloutFailed || !strings.Contains(r.Error, "render a.example.com") || len(r.Canaries) != 0 {
+		t.Fatalf("expected the rollout failed before the canaries, got %+v (%v)", r, err)
+	}
+	if got := vhostOf(t, svc, "a.example.com"); !strings.Contains(got, "# v1") {
+		t.Fatalf("expected the vhost untouched, got %q", got)
+	}
+}
@@ -0,0 +1,174 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Certificate states in a SiteRollup.
+const (
+	CertNone     = "none"
+	CertValid    = "valid"
+	CertExpiring = "expiring"
+	CertExpired  = "expired"
+)
+
+// SiteRollup is the dashboard view of one site: its last health check,
+// workers, cron jobs and certificate, read in bulk for every site.
+type SiteRollup struct {
+	SiteID      int64        `json:"site_id"`
+	Domain      string       `json:"domain"`
+	Health      string       `json:"health"`
+	Workers     WorkerRollup `json:"workers"`
+	Cron        CronRollup   `json:"cron"`
+	Certificate CertRollup   `json:"certificate"`
+}
+
+// WorkerRollup counts the workers of a site. Down are workers meant to
+// run with fewer active processes than configured.
+type WorkerRollup struct {
+	Total   int `json:"total"`
+	Running int `json:"running"`
+	Down    int `json:"down"`
+}
+
+// CronRollup counts the cron jobs of a site. Failing are enabled jobs
+// whose last run failed.
+type CronRollup struct {
+	Jobs      int       `json:"jobs"`
+	Failing   int       `json:"failing"`
+	LastRunAt time.Time `json:"last_run_at,omitzero"`
+}
+
+// CertRollup is the state of the site certificate; it is expiring within
+// the renewal window.
+type CertRollup struct {
+	Status   string    `json:"status"`
+	NotAfter time.Time `json:"not_after,omitzero"`
+}
+
+// SiteRollups returns the rollup of the sites in ids, or of every site
+// when ids is nil. Worker processes are checked with a single systemctl
+// call.
+func (s *Service) SiteRollups(ctx context.Context, ids map[int64]bool) ([]SiteRollup, error) {
+	sites, err := s.ListSites(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rollups := make([]SiteRollup, 0, len(sites))
+	index := map[int64]int{}
+	now := time.Now()
+	for _, site := range sites {
+		if ids != nil && !ids[site.ID] {
+			continue
+		}
+		health := site.HealthStatus
+		if health == "" {
+			health = HealthUnknown
+		}
+		index[site.ID] = len(rollups)
+		rollups = append(rollups, SiteRollup{
+			SiteID:      site.ID,
+			Domain:      site.Domain,
+			Health:      health,
+			Certificate: s.certRollup(site.Domain, now),
+		})
+	}
+	if len(rollups) == 0 {
+		return rollups, nil
+	}
+
+	rows, err := s.store.QueryPanelJSON(ctx, `
+SELECT site_id, COUNT(*) AS jobs,
+       SUM(CASE WHEN enabled = 1 AND consecutive_failures > 0 THEN 1 ELSE 0 END) AS failing,
+       MAX(last_run_at) AS last_run_at
+FROM site_cron_jobs
+GROUP BY site_id;`)
+	if err != nil {
+		return nil, fmt.Errorf("roll up cron jobs: %w", err)
+	}
+	for _, row := range rows {
+		siteID, _ := toInt64(row["site_id"])
+		i, ok := index[siteID]
+		if !ok {
+			continue
+		}
+		jobs, _ := toInt64(row["jobs"])
+		failing, _ := toInt64(row["failing"])
+		lastRun, _ := toInt64(row["last_run_at"])
+		rollups[i].Cron = CronRollup{Jobs: int(jobs), Failing: int(failing)}
+		if lastRun > 0 {
+			rollups[i].Cron.LastRunAt = time.Unix(lastRun, 0).UTC()
+		}
+	}
+
+	rows, err = s.store.QueryPanelJSON(ctx, `
+SELECT w.id AS id, w.site_id AS site_id, w.name AS name, w.processes AS processes, w.running AS running, s.domain AS domain
+FROM site_workers w
+JOIN sites s ON s.id = w.site_id
+ORDER BY w.id;`)
+	if err != nil {
+		return nil, fmt.Errorf("roll up workers: %w", err)
+	}
+	var running []Worker
+	for _, row := range rows {
+		siteID, _ := toInt64(row["site_id"])
+		i, ok := index[siteID]
+		if !ok {
+			continue
+		}
+		rollups[i].Workers.Total++
+		if flag, _ := toInt64(row["running"]); flag != 1 {
+			continue
+		}
+		rollups[i].Workers.Running++
+		name, _ := row["name"].(string)
+		domain, _ := row["domain"].(string)
+		processes, _ := toInt64(row["processes"])
+		running = append(running, Worker{SiteID: siteID, Name: name, Processes: int(processes), Unit: workerUnit(domain, name)})
+	}
+	if len(running) > 0 {
+		var units []string
+		for _, w := range running {
+			units = append(units, workerInstances(w, 0, w.Processes)...)
+		}
+		out, _ := s.runner.Run(ctx, "systemctl", append([]string{"is-active"}, units...)...)
+		states := strings.Fields(out)
+		offset := 0
+		for _, w := range running {
+			active := 0
+			for j := offset; j < offset+w.Processes && j < len(states); j++ {
+				if states[j] == "active" {
+					active++
+				}
+			}
+			offset += w.Processes
+			if active < w.Processes {
+				rollups[index[w.SiteID]].Workers.Down++
+			}
+		}
+	}
+	return rollups, nil
+}
+
+func (s *Service) certRollup(domain string, now time.Time) CertRollup {
+	notAfter, err := readCertificateExpiry(filepath.Join(s.letsEncryptDir, "live", domain, "cert.pem"))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return CertRollup{Status: CertNone}
+	case err != nil:
+		s.log.Warn("read certificate", "domain", domain, "error", err.Error())
+		return CertRollup{Status: CertNone}
+	case !notAfter.After(now):
+		return CertRollup{Status: CertExpired, NotAfter: notAfter.UTC()}
+	case notAfter.Before(now.Add(renewalWindow)):
+		return CertRollup{Status: CertExpiring, NotAfter: notAfter.UTC()}
+	default:
+		return CertRollup{Status: CertValid, NotAfter: notAfter.UTC()}
+	}
+}
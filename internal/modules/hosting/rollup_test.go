@@ -0,0 +1,59 @@
+package hosting
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestService_SiteRollups(t *testing.T) {
+	ctx := context.Background()
+	svc, runner, site := newWorkerService(t)
+	svc.letsEncryptDir = t.TempDir()
+	writeTestCertificate(t, svc.letsEncryptDir, site.Domain, time.Now().Add(10*24*time.Hour))
+
+	if _, err := svc.CreateWorker(ctx, site.ID, WorkerRequest{Name: "queue", Command: "php artisan queue:work", Processes: 2}); err != nil {
+		t.Fatalf("create worker: %v", err)
+	}
+	if _, err := svc.CreateWorker(ctx, site.ID, WorkerRequest{Name: "ws", Command: "node server.js"}); err != nil {
+		t.Fatalf("create worker: %v", err)
+	}
+	ws, err := svc.ListWorkers(ctx, site.ID)
+	if err != nil || len(ws) != 2 {
+		t.Fatalf("list workers: %+v (%v)", ws, err)
+	}
+	if _, err := svc.StopWorker(ctx, site.ID, ws[1].ID, "admin@example.com"); err != nil {
+		t.Fatalf("stop worker: %v", err)
+	}
+	for _, interval := range []int{5, 60} {
+		if _, err := svc.CreateCronJob(ctx, site.ID, CronJobRequest{Command: "php artisan schedule:run", IntervalMinutes: interval}); err != nil {
+			t.Fatalf("create cron job: %v", err)
+		}
+	}
+	if err := svc.store.ExecPanel(ctx, "UPDATE site_cron_jobs SET consecutive_failures = 2, last_run_at = 1700000000 WHERE interval_minutes = 5;"); err != nil {
+		t.Fatalf("fail cron job: %v", err)
+	}
+
+	runner.outputs = map[string]string{
+		"systemctl is-active aipanel-worker-test-example-com-queue@1.service aipanel-worker-test-example-com-queue@2.service": "active\nfailed\n",
+	}
+	rollups, err := svc.SiteRollups(ctx, nil)
+	if err != nil || len(rollups) != 1 {
+		t.Fatalf("unexpected rollups: %+v (%v)", rollups, err)
+	}
+	got := rollups[0]
+	if got.Workers != (WorkerRollup{Total: 2, Running: 1, Down: 1}) {
+		t.Fatalf("unexpected workers rollup: %+v", got.Workers)
+	}
+	if got.Cron.Jobs != 2 || got.Cron.Failing != 1 || got.Cron.LastRunAt.Unix() != 1700000000 {
+		t.Fatalf("unexpected cron rollup: %+v", got.Cron)
+	}
+	if got.Certificate.Status != CertExpiring || got.Health != HealthUnknown {
+		t.Fatalf("unexpected rollup: %+v", got)
+	}
+
+	rollups, err = svc.SiteRollups(ctx, map[int64]bool{site.ID + 1: true})
+	if err != nil || len(rollups) != 0 {
+		t.Fatalf("expected no visible sites, got %+v (%v)", rollups, err)
+	}
+}
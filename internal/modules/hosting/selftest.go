@@ -0,0 +1,120 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/pkg/adapter"
+)
+
+// certificateAlertWindow is how close to expiry a certificate must be for
+// the self-test to flag it. Renewals start at renewalWindow, so reaching
+// this window means renewals have been failing for weeks.
+const certificateAlertWindow = 7 * 24 * time.Hour
+
+// vhostRenderer and poolRenderer are implemented by the file-backed
+// adapters; the self-test renders through them without touching disk.
+type vhostRenderer interface {
+	RenderVhost(site adapter.SiteConfig) (string, error)
+}
+
+type poolRenderer interface {
+	RenderPool(site adapter.SiteConfig) (string, error)
+}
+
+// CheckTemplates renders the vhost and pool templates for every site, or
+// for a sample site when there are none, without writing the results.
+func (s *Service) CheckTemplates(ctx context.Context) (string, error) {
+	vhosts, _ := s.nginx.(vhostRenderer)
+	pools, _ := s.phpfpm.(poolRenderer)
+	if vhosts == nil && pools == nil {
+		return "adapters do not support rendering; skipped", nil
+	}
+	sites, err := s.ListSites(ctx)
+	if err != nil {
+		return "", err
+	}
+	configs := make([]adapter.SiteConfig, 0, len(sites))
+	for _, site := range sites {
+		cfg, err := s.siteConfig(ctx, site)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", site.Domain, err)
+		}
+		configs = append(configs, cfg)
+	}
+	if len(configs) == 0 {
+		configs = append(configs, adapter.SiteConfig{
+			Domain:     "self-test.invalid",
+			RootDir:    filepath.Join(s.webRoot, "self-test.invalid", "public_html"),
+			PHPVersion: defaultPHPVersion,
+			SystemUser: "site_self_test_invalid",
+			TmpDir:     filepath.Join(s.webRoot, "self-test.invalid", "tmp"),
+		})
+	}
+	var problems []string
+	for _, cfg := range configs {
+		if vhosts != nil {
+			if _, err := vhosts.RenderVhost(cfg); err != nil {
+				problems = append(problems, fmt.Sprintf("%s vhost: %v", cfg.Domain, err))
+			}
+		}
+		if pools != nil {
+			if _, err := pools.RenderPool(cfg); err != nil {
+				problems = append(problems, fmt.Sprintf("%s pool: %v", cfg.Domain, err))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return "", errors.New(strings.Join(problems, "; "))
+	}
+	return fmt.Sprintf("rendered templates for %d site(s)", len(configs)), nil
+}
+
+// CheckNginxConfig runs the nginx configuration test without reloading.
+func (s *Service) CheckNginxConfig(ctx context.Context) (string, error) {
+	if err := s.nginx.TestConfig(ctx); err != nil {
+		return "", err
+	}
+	return "nginx -t ok", nil
+}
+
+// CheckCertificates scans the certificate of every site and fails when one
+// is unreadable, expired or expires within certificateAlertWindow. Sites
+// without a certificate are skipped.
+func (s *Service) CheckCertificates(ctx context.Context) (string, error) {
+	sites, err := s.ListSites(ctx)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	var (
+		problems []string
+		scanned  int
+	)
+	for _, site := range sites {
+		notAfter, err := readCertificateExpiry(filepath.Join(s.letsEncryptDir, "live", site.Domain, "cert.pem"))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			continue
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %v", site.Domain, err))
+			continue
+		}
+		scanned++
+		switch {
+		case notAfter.Before(now):
+			problems = append(problems, fmt.Sprintf("%s: expired %s", site.Domain, notAfter.UTC().Format(time.RFC3339)))
+		case notAfter.Before(now.Add(certificateAlertWindow)):
+			problems = append(problems, fmt.Sprintf("%s: expires %s", site.Domain, notAfter.UTC().Format(time.RFC3339)))
+		}
+	}
+	if len(problems) > 0 {
+		return "", errors.New(strings.Join(problems, "; "))
+	}
+	return fmt.Sprintf("%d certificate(s) valid", scanned), nil
+}
@@ -0,0 +1,75 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+)
+
+func TestCheckCertificates_FlagsExpiringAndSkipsMissing(t *testing.T) {
+	ctx := context.Background()
+	svc := newACMEService(t, config.Config{}, &fakeRunner{})
+	seedSite(t, svc, "nocert.example.com")
+	writeTestCertificate(t, svc.letsEncryptDir, "example.com", time.Now().Add(60*24*time.Hour))
+
+	detail, err := svc.CheckCertificates(ctx)
+	if err != nil {
+		t.Fatalf("check valid certificates: %v", err)
+	}
+	if detail != "1 certificate(s) valid" {
+		t.Fatalf("unexpected detail %q", detail)
+	}
+
+	writeTestCertificate(t, svc.letsEncryptDir, "example.com", time.Now().Add(48*time.Hour))
+	if _, err := svc.CheckCertificates(ctx); err == nil || !strings.Contains(err.Error(), "example.com: expires") {
+		t.Fatalf("expected expiring certificate to fail, got %v", err)
+	}
+}
+
+func TestCheckTemplates_RendersEverySiteWithoutWriting(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	vhostTemplate := filepath.Join(root, "vhost.tmpl")
+	poolTemplate := filepath.Join(root, "pool.tmpl")
+	if err := os.WriteFile(vhostTemplate, []byte("server_name {{ .ServerName }};"), 0o600); err != nil {
+		t.Fatalf("write vhost template: %v", err)
+	}
+	if err := os.WriteFile(poolTemplate, []byte("[{{ .PoolName }}]"), 0o600); err != nil {
+		t.Fatalf("write pool template: %v", err)
+	}
+	runner := &fakeRunner{}
+	svc := newACMEService(t, config.Config{}, runner)
+	svc.nginx = NewNginxAdapter(runner, NginxAdapterOptions{
+		TemplatePath:      vhostTemplate,
+		SitesAvailableDir: filepath.Join(root, "sites-available"),
+		SitesEnabledDir:   filepath.Join(root, "sites-enabled"),
+	})
+	svc.phpfpm = NewPHPFPMAdapter(runner, PHPFPMAdapterOptions{
+		TemplatePath: poolTemplate,
+		PoolDir:      filepath.Join(root, "pool.d"),
+	})
+
+	detail, err := svc.CheckTemplates(ctx)
+	if err != nil {
+		t.Fatalf("check templates: %v", err)
+	}
+	if detail != "rendered templates for 1 site(s)" {
+		t.Fatalf("unexpected detail %q", detail)
+	}
+	if _, err := os.Stat(filepath.Join(root, "sites-available")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected no vhost files to be written, stat err=%v", err)
+	}
+
+	if err := os.WriteFile(poolTemplate, []byte("[{{ .PoolName }"), 0o600); err != nil {
+		t.Fatalf("break pool template: %v", err)
+	}
+	if _, err := svc.CheckTemplates(ctx); err == nil || !strings.Contains(err.Error(), "example.com pool") {
+		t.Fatalf("expected broken pool template to fail, got %v", err)
+	}
+}
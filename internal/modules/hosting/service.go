@@ -4,17 +4,28 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/robsonek/aiPanel/internal/modules/audit"
+	"github.com/robsonek/aiPanel/internal/platform/cache"
 	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/faultinject"
+	"github.com/robsonek/aiPanel/internal/platform/heartbeat"
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/internal/platform/middleware"
+	"github.com/robsonek/aiPanel/internal/platform/notify"
+	"github.com/robsonek/aiPanel/internal/platform/settings"
 	"github.com/robsonek/aiPanel/internal/platform/sqlite"
 	"github.com/robsonek/aiPanel/internal/platform/systemd"
+	"github.com/robsonek/aiPanel/internal/platform/templates"
 	"github.com/robsonek/aiPanel/pkg/adapter"
 )
 
@@ -27,6 +38,16 @@ const defaultPHPVersion = "8.5"
 const nginxContentReaderGroup = "www-data"
 const rootWebOwner = "root"
 
+// Read caches; site writes invalidate sitesCache explicitly and runtime
+// installs invalidate phpVersionsCache through InvalidatePHPVersions. The
+// TTL only covers installs made outside the panel (aipanel install).
+const (
+	sitesCacheTTL       = 30 * time.Second
+	phpVersionsCacheTTL = 10 * time.Minute
+	sitesCacheKey       = "all"
+	phpVersionsCacheKey = "all"
+)
+
 // Service orchestrates site CRUD against adapters and panel.db.
 type Service struct {
 	store   *sqlite.Store
@@ -36,6 +57,77 @@ type Service struct {
 	nginx   adapter.Nginx
 	phpfpm  adapter.PHPFPM
 	webRoot string
+
+	// dkimKeyDir holds "<domain>.pub" DKIM keys written by the mail module.
+	dkimKeyDir string
+	// letsEncryptDir is certbot's --config-dir (accounts, live certificates).
+	letsEncryptDir string
+	// catchAllTemplate and catchAllLandingTemplate render the nginx default
+	// server; the landing page is written to catchAllRoot.
+	catchAllTemplate        string
+	catchAllLandingTemplate string
+	catchAllRoot            string
+	// cloudflareAPI is the Cloudflare v4 API base URL.
+	cloudflareAPI string
+	// phpCLI and wpCLI run wp-cli for WordPress sites; wpscanAPI is the
+	// WPScan v3 API base URL.
+	phpCLI    string
+	wpCLI     string
+	wpscanAPI string
+	// registrar is the domain registrar integration, nil when none is
+	// configured.
+	registrar adapter.Registrar
+	// txtLookup overrides DNS TXT resolution in tests.
+	txtLookup func(ctx context.Context, name string) ([]string, error)
+	// healthHTTPBase is where nginx serves sites locally; health checks
+	// send the site name in the Host header.
+	healthHTTPBase string
+	// fpmSocket overrides the PHP-FPM socket of a site in tests.
+	fpmSocket func(site Site) string
+	// healthRetryDelay separates health check attempts while nginx
+	// workers pick up a reload.
+	healthRetryDelay time.Duration
+
+	// stagePHP installs a PHP runtime next to the active one for
+	// upgrades; accessLogDir holds the nginx access logs watched after a
+	// cutover for cutoverWatch.
+	stagePHP     func(ctx context.Context, logw io.Writer) error
+	accessLogDir string
+	cutoverWatch time.Duration
+	// slowLogDir holds the PHP-FPM slow logs of the sites.
+	slowLogDir string
+	// pageCacheDir holds the fastcgi_cache directories of the sites.
+	pageCacheDir string
+	// memcachedBinary is the memcached runtime build; per-site instance
+	// units are written to systemdUnitDir.
+	memcachedBinary string
+	systemdUnitDir  string
+	// workerEnvDir holds the root-only environment files of site workers.
+	workerEnvDir string
+	// templates saves vhost templates promoted by a canary rollout;
+	// rolloutSoak is how long canary sites are watched by default.
+	templates   *templates.Store
+	rolloutSoak time.Duration
+	// sshd manages site SFTP logins, nil when SFTP is unavailable.
+	sshd adapter.SSHD
+	// errorScanOffsets is how far CollectErrorRates has read the access
+	// log of each site.
+	errorScanMu      sync.Mutex
+	errorScanOffsets map[int64]int64
+
+	// settings overrides panel.yaml with values changed in the panel;
+	// nil uses cfg as is.
+	settings *settings.Store
+
+	jobs   *jobqueue.Queue
+	notify notify.Func
+	// siteNotify reaches the owners of a site rather than the admins.
+	siteNotify SiteNotifier
+	// ping reports cron and renewal outcomes to heartbeat monitors.
+	ping func(ctx context.Context, url string, runErr error) error
+
+	sitesCache       *cache.TTL[string, []Site]
+	phpVersionsCache *cache.TTL[string, []string]
 }
 
 // NewService creates a hosting service.
@@ -61,9 +153,100 @@ func NewService(
 		nginx:   nginx,
 		phpfpm:  phpfpm,
 		webRoot: "/var/www",
+
+		dkimKeyDir:     defaultDKIMKeyDir,
+		letsEncryptDir: defaultLetsEncryptDir,
+		cloudflareAPI:  defaultCloudflareAPI,
+		phpCLI:         defaultPHPCLI,
+		wpCLI:          defaultWPCLI,
+		wpscanAPI:      defaultWPScanAPI,
+		registrar:      registrarFromConfig(cfg),
+		healthHTTPBase: defaultHealthHTTPBase,
+
+		healthRetryDelay: time.Second,
+		ping:             heartbeat.Ping,
+		accessLogDir:     defaultAccessLogDir,
+		slowLogDir:       defaultPHPSlowLogDir,
+		pageCacheDir:     defaultPageCacheDir,
+		memcachedBinary:  defaultMemcachedBinary,
+		systemdUnitDir:   defaultSystemdUnitDir,
+		workerEnvDir:     defaultWorkerEnvDir,
+		cutoverWatch:     defaultCutoverWatch,
+		rolloutSoak:      defaultRolloutSoak,
+
+		catchAllTemplate:        defaultCatchAllTemplate,
+		catchAllLandingTemplate: defaultCatchAllLandingTemplate,
+		catchAllRoot:            defaultCatchAllRoot,
+
+		sitesCache:       cache.New[string, []Site](sitesCacheTTL),
+		phpVersionsCache: cache.New[string, []string](phpVersionsCacheTTL),
+	}
+}
+
+// SetSettings sets the store of settings changed in the panel: the ACME
+// contact and environment and the web terminal toggle.
+func (s *Service) SetSettings(store *settings.Store) {
+	s.settings = store
+}
+
+// heartbeat pings url with the outcome of a scheduled run. A monitor that
+// cannot be reached only logs; it must not fail the run it reports on.
+func (s *Service) heartbeat(ctx context.Context, url string, runErr error) {
+	if url == "" || s.ping == nil {
+		return
+	}
+	if err := s.ping(ctx, url, runErr); err != nil {
+		s.log.Warn("heartbeat ping failed", "error", err.Error())
 	}
 }
 
+// listPHPVersions returns installed PHP versions, cached for phpVersionsCacheTTL.
+func (s *Service) listPHPVersions(ctx context.Context) ([]string, error) {
+	if versions, ok := s.phpVersionsCache.Get(phpVersionsCacheKey); ok {
+		return slices.Clone(versions), nil
+	}
+	versions, err := s.phpfpm.ListVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.phpVersionsCache.Set(phpVersionsCacheKey, slices.Clone(versions))
+	return versions, nil
+}
+
+// PHPVersions lists the installed PHP versions and the one new sites get
+// when none is requested.
+func (s *Service) PHPVersions(ctx context.Context) (PHPVersions, error) {
+	if s.phpfpm == nil {
+		return PHPVersions{}, fmt.Errorf("hosting service is not fully configured")
+	}
+	versions, err := s.listPHPVersions(ctx)
+	if err != nil {
+		return PHPVersions{}, fmt.Errorf("list php versions: %w", err)
+	}
+	slices.Sort(versions)
+	if versions == nil {
+		versions = []string{}
+	}
+	return PHPVersions{Versions: versions, Default: defaultPHPVersionOf(versions)}, nil
+}
+
+// InvalidatePHPVersions drops the cached version list after a PHP runtime
+// was installed or upgraded.
+func (s *Service) InvalidatePHPVersions() {
+	s.phpVersionsCache.Purge()
+}
+
+// defaultPHPVersionOf returns the newest of versions, or defaultPHPVersion
+// when none is installed.
+func defaultPHPVersionOf(versions []string) string {
+	if len(versions) == 0 {
+		return defaultPHPVersion
+	}
+	sorted := slices.Clone(versions)
+	slices.Sort(sorted)
+	return sorted[len(sorted)-1]
+}
+
 // CreateSite creates system user, docroot, PHP pool, Nginx vhost and DB row.
 func (s *Service) CreateSite(ctx context.Context, req CreateSiteRequest) (Site, error) {
 	if s.store == nil || s.nginx == nil || s.phpfpm == nil {
@@ -74,19 +257,13 @@ func (s *Service) CreateSite(ctx context.Context, req CreateSiteRequest) (Site,
 	if err != nil {
 		return Site{}, err
 	}
-	versions, err := s.phpfpm.ListVersions(ctx)
+	versions, err := s.listPHPVersions(ctx)
 	if err != nil {
 		return Site{}, fmt.Errorf("list php versions: %w", err)
 	}
 	phpVersion := strings.TrimSpace(req.PHPVersion)
 	if phpVersion == "" {
-		if len(versions) > 0 {
-			availableVersions := slices.Clone(versions)
-			slices.Sort(availableVersions)
-			phpVersion = availableVersions[len(availableVersions)-1]
-		} else {
-			phpVersion = defaultPHPVersion
-		}
+		phpVersion = defaultPHPVersionOf(versions)
 	}
 	if !phpVersionPattern.MatchString(phpVersion) {
 		return Site{}, fmt.Errorf("invalid php version")
@@ -95,14 +272,26 @@ func (s *Service) CreateSite(ctx context.Context, req CreateSiteRequest) (Site,
 		return Site{}, fmt.Errorf("php version %s is not installed", phpVersion)
 	}
 
+	canonicalHost, err := normalizeCanonicalHost(req.CanonicalHost)
+	if err != nil {
+		return Site{}, err
+	}
+	serverName, redirectHosts, err := canonicalHosts(domain, canonicalHost)
+	if err != nil {
+		return Site{}, err
+	}
+
 	rootBaseDir := filepath.Join(s.webRoot, domain)
 	rootDir := filepath.Join(rootBaseDir, "public_html")
 	systemUser := systemUserForDomain(domain)
 	siteCfg := adapter.SiteConfig{
-		Domain:     domain,
-		RootDir:    rootDir,
-		PHPVersion: phpVersion,
-		SystemUser: systemUser,
+		Domain:        domain,
+		RootDir:       rootDir,
+		PHPVersion:    phpVersion,
+		SystemUser:    systemUser,
+		TmpDir:        siteTmpDir(rootDir),
+		ServerName:    serverName,
+		RedirectHosts: redirectHosts,
 	}
 
 	if err = os.MkdirAll(s.webRoot, 0o750); err != nil {
@@ -145,30 +334,34 @@ func (s *Service) CreateSite(ctx context.Context, req CreateSiteRequest) (Site,
 	if err = os.MkdirAll(rootDir, 0o750); err != nil {
 		return Site{}, fmt.Errorf("create docroot: %w", err)
 	}
+	if err = os.MkdirAll(siteCfg.TmpDir, 0o700); err != nil {
+		return Site{}, fmt.Errorf("create site tmp dir: %w", err)
+	}
 	bootstrapIndexPath, err := ensureSiteBootstrapFiles(rootDir, domain)
 	if err != nil {
 		return Site{}, fmt.Errorf("bootstrap docroot: %w", err)
 	}
 
 	if _, runErr := s.runner.Run(ctx, "id", systemUser); runErr != nil {
-		if _, runErr = s.runner.Run(ctx,
+		if _, err = s.runner.Run(ctx,
 			"useradd",
 			"--system",
 			"--create-home",
 			"--home-dir", rootBaseDir,
 			"--shell", "/usr/sbin/nologin",
 			systemUser,
-		); runErr != nil {
-			return Site{}, fmt.Errorf("create system user: %w", runErr)
+		); err != nil {
+			return Site{}, fmt.Errorf("create system user: %w", err)
 		}
 		createdUser = true
 	}
-	if _, runErr := s.runner.Run(ctx, "chown", "-R", systemUser+":"+nginxContentReaderGroup, rootBaseDir); runErr != nil {
-		return Site{}, fmt.Errorf("chown site directory: %w", runErr)
+	// Assign err (not a scoped runErr) so the deferred rollback sees failures.
+	if _, err = s.runner.Run(ctx, "chown", "-R", systemUser+":"+nginxContentReaderGroup, rootBaseDir); err != nil {
+		return Site{}, fmt.Errorf("chown site directory: %w", err)
 	}
 	if bootstrapIndexPath != "" {
-		if _, runErr := s.runner.Run(ctx, "chmod", "0644", bootstrapIndexPath); runErr != nil {
-			return Site{}, fmt.Errorf("set bootstrap index permissions: %w", runErr)
+		if _, err = s.runner.Run(ctx, "chmod", "0644", bootstrapIndexPath); err != nil {
+			return Site{}, fmt.Errorf("set bootstrap index permissions: %w", err)
 		}
 	}
 
@@ -190,27 +383,54 @@ func (s *Service) CreateSite(ctx context.Context, req CreateSiteRequest) (Site,
 	if err = s.nginx.Reload(ctx); err != nil {
 		return Site{}, fmt.Errorf("reload nginx: %w", err)
 	}
+	// A site that is not actually serving is rolled back with diagnostics
+	// rather than left half-working.
+	var health SiteHealth
+	if s.cfg.SiteHealthChecks {
+		health = s.probeSite(ctx, Site{Domain: domain, PHPVersion: phpVersion, CanonicalHost: canonicalHost})
+		if health.Status != CheckPass {
+			s.log.Warn("site health check failed", "domain", domain, "diagnostics", health.diagnostics())
+			err = fmt.Errorf("site is not serving: %s", health.diagnostics())
+			return Site{}, err
+		}
+	}
 
 	nowUnix := time.Now().Unix()
 	insert := fmt.Sprintf(`
-INSERT INTO sites(domain, root_dir, php_version, system_user, status, created_at, updated_at)
-VALUES('%s','%s','%s','%s','active',%d,%d);`,
+INSERT INTO sites(domain, root_dir, php_version, system_user, status, canonical_host, created_at, updated_at)
+VALUES('%s','%s','%s','%s','active','%s',%d,%d);`,
 		sqlEscape(domain),
 		sqlEscape(rootDir),
 		sqlEscape(phpVersion),
 		sqlEscape(systemUser),
+		sqlEscape(canonicalHost),
 		nowUnix,
 		nowUnix,
 	)
 	if err = s.store.ExecPanel(ctx, insert); err != nil {
 		return Site{}, fmt.Errorf("insert site: %w", err)
 	}
+	s.sitesCache.Purge()
 	_ = s.writeAudit(ctx, req.Actor, "hosting.site.create", "domain="+domain)
 
 	site, err := s.getSiteByDomain(ctx, domain)
 	if err != nil {
 		return Site{}, err
 	}
+	if s.cfg.SiteHealthChecks {
+		health.SiteID = site.ID
+		if recErr := s.recordSiteHealth(ctx, health); recErr != nil {
+			s.log.Warn("record site health", "domain", domain, "error", recErr.Error())
+		} else {
+			site.HealthStatus = health.Status
+		}
+	}
+	// The site is served already; DNS can be retried from the site page.
+	if req.Cloudflare {
+		if _, cfErr := s.EnableCloudflare(ctx, site.ID, CloudflareRequest{Proxied: req.CloudflareProxied, Actor: req.Actor}); cfErr != nil {
+			s.log.Warn("enable cloudflare", "domain", domain, "error", cfErr.Error())
+		}
+	}
 	return site, nil
 }
 
@@ -219,8 +439,11 @@ func (s *Service) ListSites(ctx context.Context) ([]Site, error) {
 	if s.store == nil {
 		return nil, fmt.Errorf("hosting service is not configured")
 	}
+	if sites, ok := s.sitesCache.Get(sitesCacheKey); ok {
+		return slices.Clone(sites), nil
+	}
 	rows, err := s.store.QueryPanelJSON(ctx, `
-SELECT id, domain, root_dir, php_version, system_user, status, created_at, updated_at
+SELECT id, domain, root_dir, php_version, system_user, status, open_basedir_relaxed, canonical_host, health_status, created_at, updated_at
 FROM sites
 ORDER BY id DESC;`)
 	if err != nil {
@@ -234,6 +457,7 @@ ORDER BY id DESC;`)
 		}
 		sites = append(sites, site)
 	}
+	s.sitesCache.Set(sitesCacheKey, slices.Clone(sites))
 	return sites, nil
 }
 
@@ -243,7 +467,7 @@ func (s *Service) GetSite(ctx context.Context, id int64) (Site, error) {
 		return Site{}, fmt.Errorf("hosting service is not configured")
 	}
 	query := fmt.Sprintf(`
-SELECT id, domain, root_dir, php_version, system_user, status, created_at, updated_at
+SELECT id, domain, root_dir, php_version, system_user, status, open_basedir_relaxed, canonical_host, health_status, created_at, updated_at
 FROM sites
 WHERE id = %d
 LIMIT 1;`, id)
@@ -266,14 +490,14 @@ func (s *Service) DeleteSite(ctx context.Context, id int64, actor string) error
 	if err != nil {
 		return err
 	}
-
-	siteCfg := adapter.SiteConfig{
-		Domain:     site.Domain,
-		RootDir:    site.RootDir,
-		PHPVersion: site.PHPVersion,
-		SystemUser: site.SystemUser,
+	siteCfg, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return err
 	}
 
+	if cfErr := s.DisableCloudflare(ctx, id, actor); cfErr != nil && !errors.Is(cfErr, ErrCloudflareNotEnabled) {
+		s.log.Warn("disable cloudflare", "domain", site.Domain, "error", cfErr.Error())
+	}
 	if err = s.nginx.RemoveVhost(ctx, site.Domain); err != nil {
 		return fmt.Errorf("remove nginx vhost: %w", err)
 	}
@@ -294,15 +518,51 @@ func (s *Service) DeleteSite(ctx context.Context, id int64, actor string) error
 		return fmt.Errorf("reload nginx: %w", err)
 	}
 
+	if s.sshd != nil {
+		if _, sftpErr := s.siteSFTP(ctx, site); sftpErr == nil {
+			if sftpErr = s.sshd.RemoveAccount(ctx, site.SystemUser); sftpErr == nil {
+				sftpErr = s.sshd.Reload(ctx)
+			}
+			if sftpErr != nil {
+				s.log.Warn("remove sftp access", "domain", site.Domain, "error", sftpErr.Error())
+			}
+		}
+	}
+	if err := s.removeMemcachedUnit(ctx, site); err != nil {
+		s.log.Warn("remove memcached", "domain", site.Domain, "error", err.Error())
+	}
+	if err := s.removeSiteWorkers(ctx, site); err != nil {
+		s.log.Warn("remove workers", "domain", site.Domain, "error", err.Error())
+	}
 	_, _ = s.runner.Run(ctx, "userdel", "--remove", site.SystemUser)
 
 	rootBaseDir := filepath.Dir(site.RootDir)
 	if withinBase(rootBaseDir, s.webRoot) {
 		_ = os.RemoveAll(rootBaseDir)
 	}
-
-	del := fmt.Sprintf("DELETE FROM sites WHERE id = %d;", id)
-	if err = s.store.ExecPanel(ctx, del); err != nil {
+	_ = os.RemoveAll(s.pageCacheSiteDir(site.Domain))
+
+	// panel.db does not enforce foreign keys, so site rows are removed
+	// explicitly.
+	del := fmt.Sprintf(`
+DELETE FROM site_cron_runs WHERE cron_id IN (SELECT id FROM site_cron_jobs WHERE site_id = %d);
+DELETE FROM site_cron_jobs WHERE site_id = %d;
+DELETE FROM site_storage WHERE site_id = %d;
+DELETE FROM site_limits WHERE site_id = %d;
+DELETE FROM site_page_cache WHERE site_id = %d;
+DELETE FROM site_memcached WHERE site_id = %d;
+DELETE FROM site_workers WHERE site_id = %d;
+DELETE FROM site_sftp WHERE site_id = %d;
+DELETE FROM site_error_budgets WHERE site_id = %d;
+DELETE FROM site_error_rates WHERE site_id = %d;
+DELETE FROM site_wordpress WHERE site_id = %d;
+DELETE FROM site_registrar WHERE site_id = %d;
+DELETE FROM organization_sites WHERE site_id = %d;
+DELETE FROM user_site_grants WHERE site_id = %d;
+DELETE FROM sites WHERE id = %d;`, id, id, id, id, id, id, id, id, id, id, id, id, id, id, id)
+	err = s.store.ExecPanel(ctx, del)
+	s.sitesCache.Purge()
+	if err != nil {
 		return fmt.Errorf("delete site row: %w", err)
 	}
 	_ = s.writeAudit(ctx, actor, "hosting.site.delete", "domain="+site.Domain)
@@ -311,7 +571,7 @@ func (s *Service) DeleteSite(ctx context.Context, id int64, actor string) error
 
 func (s *Service) getSiteByDomain(ctx context.Context, domain string) (Site, error) {
 	query := fmt.Sprintf(`
-SELECT id, domain, root_dir, php_version, system_user, status, created_at, updated_at
+SELECT id, domain, root_dir, php_version, system_user, status, open_basedir_relaxed, canonical_host, health_status, created_at, updated_at
 FROM sites
 WHERE domain = '%s'
 LIMIT 1;`, sqlEscape(domain))
@@ -335,6 +595,12 @@ func mapRowToSite(row map[string]any) (Site, error) {
 	phpVersion, _ := row["php_version"].(string)
 	systemUser, _ := row["system_user"].(string)
 	status, _ := row["status"].(string)
+	canonicalHost, _ := row["canonical_host"].(string)
+	healthStatus, _ := row["health_status"].(string)
+	relaxed, err := toInt64(row["open_basedir_relaxed"])
+	if err != nil {
+		return Site{}, err
+	}
 	createdAtUnix, err := toInt64(row["created_at"])
 	if err != nil {
 		return Site{}, err
@@ -352,6 +618,10 @@ func mapRowToSite(row map[string]any) (Site, error) {
 		Status:     status,
 		CreatedAt:  time.Unix(createdAtUnix, 0).UTC(),
 		UpdatedAt:  time.Unix(updatedAtUnix, 0).UTC(),
+
+		OpenBasedirRelaxed: relaxed == 1,
+		CanonicalHost:      canonicalHost,
+		HealthStatus:       healthStatus,
 	}, nil
 }
 
@@ -377,6 +647,9 @@ func ensureSiteBootstrapFiles(rootDir, domain string) (string, error) {
 		"<head><meta charset=\"utf-8\"><title>" + domain + "</title></head>\n" +
 		"<body><h1>" + domain + "</h1><p>Site created by aiPanel.</p></body>\n" +
 		"</html>\n"
+	if err := faultinject.Write(indexPath); err != nil {
+		return "", err
+	}
 	if err := os.WriteFile(indexPath, []byte(body), 0o600); err != nil {
 		return "", err
 	}
@@ -422,11 +695,31 @@ func (s *Service) writeAudit(ctx context.Context, actor, action, details string)
 		actor = "system"
 	}
 	sql := fmt.Sprintf(
-		"INSERT INTO audit_events(actor, action, details, created_at) VALUES('%s','%s','%s',%d);",
+		"INSERT INTO audit_events(actor, action, details, remote_ip, impersonator, site_id, created_at) VALUES('%s','%s','%s','%s','%s',%d,%d);",
 		sqlEscape(actor),
 		sqlEscape(action),
 		sqlEscape(details),
+		sqlEscape(middleware.ClientIP(ctx)),
+		sqlEscape(middleware.Impersonator(ctx)),
+		s.auditSiteID(ctx, details),
 		time.Now().Unix(),
 	)
 	return s.store.ExecAudit(ctx, sql)
 }
+
+// auditSiteID resolves the site an event touches from the site_id or
+// domain in its details, so the event shows up in the site's activity.
+func (s *Service) auditSiteID(ctx context.Context, details string) int64 {
+	if id := audit.FieldID(details, "site_id"); id > 0 {
+		return id
+	}
+	domain := audit.Field(details, "domain")
+	if domain == "" {
+		return 0
+	}
+	site, err := s.getSiteByDomain(ctx, domain)
+	if err != nil {
+		return 0
+	}
+	return site.ID
+}
@@ -0,0 +1,59 @@
+package hosting
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+// BenchmarkCreateSite_DryRun measures site provisioning with recording fakes
+// in place of system commands, nginx and php-fpm.
+func BenchmarkCreateSite_DryRun(b *testing.B) {
+	ctx := context.Background()
+	store := sqlite.New(b.TempDir())
+	if err := store.Init(ctx); err != nil {
+		b.Fatalf("init store: %v", err)
+	}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	svc := NewService(store, config.Config{}, log, &fakeRunner{}, &fakeNginxAdapter{}, &fakePHPFPMAdapter{})
+	svc.webRoot = b.TempDir()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := svc.CreateSite(ctx, CreateSiteRequest{
+			Domain:     fmt.Sprintf("bench-%d.example.com", i),
+			PHPVersion: "8.3",
+			Actor:      "bench@example.com",
+		}); err != nil {
+			b.Fatalf("create site: %v", err)
+		}
+	}
+}
+
+func BenchmarkListSites(b *testing.B) {
+	ctx := context.Background()
+	store := sqlite.New(b.TempDir())
+	if err := store.Init(ctx); err != nil {
+		b.Fatalf("init store: %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		if err := store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO sites(domain, root_dir, php_version, system_user, status, created_at, updated_at)
+VALUES('site-%d.example.com', '/var/www/site-%d.example.com/public_html', '8.3', 'site_%d', 'active', 1, 1);`, i, i, i)); err != nil {
+			b.Fatalf("seed site: %v", err)
+		}
+	}
+	svc := NewService(store, config.Config{}, slog.New(slog.NewJSONHandler(io.Discard, nil)), &fakeRunner{}, nil, nil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := svc.ListSites(ctx); err != nil {
+			b.Fatalf("list sites: %v", err)
+		}
+	}
+}
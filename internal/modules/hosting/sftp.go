@@ -0,0 +1,324 @@
+package hosting
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/pkg/adapter"
+)
+
+// ErrSFTPNotEnabled indicates a site without SFTP access.
+var ErrSFTPNotEnabled = errors.New("sftp access is not enabled for this site")
+
+// sftpKeyTypes are the public key algorithms accepted for SFTP logins.
+var sftpKeyTypes = map[string]bool{
+	"ssh-ed25519":                        true,
+	"ssh-rsa":                            true,
+	"ecdsa-sha2-nistp256":                true,
+	"ecdsa-sha2-nistp384":                true,
+	"ecdsa-sha2-nistp521":                true,
+	"sk-ssh-ed25519@openssh.com":         true,
+	"sk-ecdsa-sha2-nistp256@openssh.com": true,
+}
+
+// SiteSFTP describes the SFTP login of a site. Sessions are chrooted to
+// the site home (the parent of the docroot, which sshd requires to be
+// owned by root while access is on) and start in the docroot.
+type SiteSFTP struct {
+	SiteID      int64     `json:"site_id"`
+	Enabled     bool      `json:"enabled"`
+	Username    string    `json:"username"`
+	ChrootDir   string    `json:"chroot_dir"`
+	StartDir    string    `json:"start_dir"`
+	KeyType     string    `json:"key_type,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	PublicKey   string    `json:"public_key,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// PrivateKey is set only in the response that generated the key; the
+	// panel does not keep it.
+	PrivateKey string `json:"private_key,omitempty"`
+}
+
+// SiteSFTPRequest enables SFTP for a site or rotates its key. An empty
+// PublicKey makes the panel generate an ed25519 key pair.
+type SiteSFTPRequest struct {
+	PublicKey string `json:"public_key"`
+	Actor     string `json:"-"`
+}
+
+// SetSSHD sets the adapter managing SFTP logins; SFTP is unavailable
+// without one.
+func (s *Service) SetSSHD(sshd adapter.SSHD) {
+	s.sshd = sshd
+}
+
+// GetSiteSFTP returns the SFTP access of a site.
+func (s *Service) GetSiteSFTP(ctx context.Context, siteID int64) (SiteSFTP, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return SiteSFTP{}, err
+	}
+	out, err := s.siteSFTP(ctx, site)
+	if errors.Is(err, ErrSFTPNotEnabled) {
+		return out, nil
+	}
+	return out, err
+}
+
+// SetSiteSFTP enables SFTP for a site with the given or a generated key,
+// or replaces the key of an enabled site. A failed sshd config test
+// leaves the previous access in place.
+func (s *Service) SetSiteSFTP(ctx context.Context, siteID int64, req SiteSFTPRequest) (SiteSFTP, error) {
+	if s.store == nil || s.sshd == nil {
+		return SiteSFTP{}, fmt.Errorf("hosting service is not fully configured")
+	}
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return SiteSFTP{}, err
+	}
+	previous, err := s.siteSFTP(ctx, site)
+	if err != nil && !errors.Is(err, ErrSFTPNotEnabled) {
+		return SiteSFTP{}, err
+	}
+	publicKey, privateKey := strings.TrimSpace(req.PublicKey), ""
+	if publicKey == "" {
+		if publicKey, privateKey, err = generateSFTPKey("aipanel-" + site.Domain); err != nil {
+			return SiteSFTP{}, err
+		}
+	}
+	keyType, fingerprint, publicKey, err := parseAuthorizedKey(publicKey)
+	if err != nil {
+		return SiteSFTP{}, err
+	}
+	if err := s.writeSFTPAccount(ctx, site, publicKey); err != nil {
+		if previous.Enabled {
+			_ = s.writeSFTPAccount(ctx, site, previous.PublicKey)
+		} else {
+			_ = s.removeSFTPAccount(ctx, site)
+		}
+		return SiteSFTP{}, err
+	}
+	now := time.Now().Unix()
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO site_sftp(site_id, public_key, fingerprint, created_at, updated_at)
+VALUES(%d,'%s','%s',%d,%d)
+ON CONFLICT(site_id) DO UPDATE SET public_key=excluded.public_key, fingerprint=excluded.fingerprint,
+  updated_at=MAX(excluded.updated_at, site_sftp.updated_at + 1);`,
+		siteID, sqlEscape(publicKey), sqlEscape(fingerprint), now, now)); err != nil {
+		return SiteSFTP{}, fmt.Errorf("save site sftp: %w", err)
+	}
+	action := "hosting.sftp.enable"
+	if previous.Enabled {
+		action = "hosting.sftp.rotate"
+	}
+	_ = s.writeAudit(ctx, req.Actor, action, fmt.Sprintf("domain=%s key=%s %s generated=%t", site.Domain, keyType, fingerprint, privateKey != ""))
+	out, err := s.siteSFTP(ctx, site)
+	if err != nil {
+		return SiteSFTP{}, err
+	}
+	out.PrivateKey = privateKey
+	return out, nil
+}
+
+// DisableSiteSFTP removes the SFTP login of a site and hands its home back
+// to the site user.
+func (s *Service) DisableSiteSFTP(ctx context.Context, siteID int64, actor string) error {
+	if s.store == nil || s.sshd == nil {
+		return fmt.Errorf("hosting service is not fully configured")
+	}
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.siteSFTP(ctx, site); err != nil {
+		return err
+	}
+	if err := s.removeSFTPAccount(ctx, site); err != nil {
+		return err
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf("DELETE FROM site_sftp WHERE site_id = %d;", siteID)); err != nil {
+		return fmt.Errorf("delete site sftp: %w", err)
+	}
+	_ = s.writeAudit(ctx, actor, "hosting.sftp.disable", "domain="+site.Domain)
+	return nil
+}
+
+func (s *Service) siteSFTP(ctx context.Context, site Site) (SiteSFTP, error) {
+	out := SiteSFTP{
+		SiteID:    site.ID,
+		Username:  site.SystemUser,
+		ChrootDir: filepath.Dir(site.RootDir),
+		StartDir:  site.RootDir,
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT public_key, fingerprint, updated_at
+FROM site_sftp
+WHERE site_id = %d;`, site.ID))
+	if err != nil {
+		return SiteSFTP{}, fmt.Errorf("get site sftp: %w", err)
+	}
+	if len(rows) == 0 {
+		return out, ErrSFTPNotEnabled
+	}
+	updatedAt, err := toInt64(rows[0]["updated_at"])
+	if err != nil {
+		return SiteSFTP{}, fmt.Errorf("parse site sftp updated_at: %w", err)
+	}
+	out.Enabled = true
+	out.PublicKey, _ = rows[0]["public_key"].(string)
+	out.Fingerprint, _ = rows[0]["fingerprint"].(string)
+	out.KeyType, _, _ = strings.Cut(out.PublicKey, " ")
+	out.UpdatedAt = time.Unix(updatedAt, 0).UTC()
+	return out, nil
+}
+
+// writeSFTPAccount hands the site home to root, as sshd refuses a chroot
+// the user could write to, and installs the login. The docroot and tmp
+// dir inside stay owned by the site user.
+func (s *Service) writeSFTPAccount(ctx context.Context, site Site, publicKey string) error {
+	chroot := filepath.Dir(site.RootDir)
+	if !withinBase(chroot, s.webRoot) || filepath.Clean(chroot) == filepath.Clean(s.webRoot) {
+		return fmt.Errorf("site home %s is outside the web root", chroot)
+	}
+	if _, err := s.runner.Run(ctx, "chown", rootWebOwner+":"+nginxContentReaderGroup, chroot); err != nil {
+		return fmt.Errorf("chown sftp chroot: %w", err)
+	}
+	if _, err := s.runner.Run(ctx, "chmod", "0755", chroot); err != nil {
+		return fmt.Errorf("chmod sftp chroot: %w", err)
+	}
+	// Site users are created locked; sshd refuses locked accounts even
+	// for key logins. "*" unlocks without allowing any password.
+	if _, err := s.runner.Run(ctx, "usermod", "--password", "*", site.SystemUser); err != nil {
+		return fmt.Errorf("unlock site user: %w", err)
+	}
+	account := adapter.SFTPAccount{
+		User:           site.SystemUser,
+		ChrootDir:      chroot,
+		StartDir:       filepath.Base(site.RootDir),
+		AuthorizedKeys: []string{publicKey},
+	}
+	if err := s.sshd.WriteAccount(ctx, account); err != nil {
+		return err
+	}
+	if err := s.sshd.TestConfig(ctx); err != nil {
+		return err
+	}
+	return s.sshd.Reload(ctx)
+}
+
+// removeSFTPAccount removes the login and restores the ownership the site
+// home was created with.
+func (s *Service) removeSFTPAccount(ctx context.Context, site Site) error {
+	if err := s.sshd.RemoveAccount(ctx, site.SystemUser); err != nil {
+		return err
+	}
+	if err := s.sshd.Reload(ctx); err != nil {
+		return err
+	}
+	if _, err := s.runner.Run(ctx, "usermod", "--lock", site.SystemUser); err != nil {
+		return fmt.Errorf("lock site user: %w", err)
+	}
+	chroot := filepath.Dir(site.RootDir)
+	if _, err := s.runner.Run(ctx, "chown", site.SystemUser+":"+nginxContentReaderGroup, chroot); err != nil {
+		return fmt.Errorf("chown site home: %w", err)
+	}
+	if _, err := s.runner.Run(ctx, "chmod", "0750", chroot); err != nil {
+		return fmt.Errorf("chmod site home: %w", err)
+	}
+	return nil
+}
+
+// parseAuthorizedKey validates one OpenSSH public key line and returns its
+// type, SHA256 fingerprint and the line normalized to "type key comment".
+// Options are not accepted; the panel writes the ones it needs.
+func parseAuthorizedKey(line string) (string, string, string, error) {
+	if strings.ContainsAny(line, "\r\n") {
+		return "", "", "", fmt.Errorf("invalid public key: expected a single line")
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !sftpKeyTypes[fields[0]] {
+		return "", "", "", fmt.Errorf("invalid public key: expected an OpenSSH public key (ssh-ed25519, ecdsa or ssh-rsa)")
+	}
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid public key: %w", err)
+	}
+	if name, ok := readSSHString(blob); !ok || name != fields[0] {
+		return "", "", "", fmt.Errorf("invalid public key: key data does not match %s", fields[0])
+	}
+	sum := sha256.Sum256(blob)
+	fingerprint := "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+	normalized := fields[0] + " " + fields[1]
+	if len(fields) > 2 {
+		normalized += " " + strings.Join(fields[2:], " ")
+	}
+	return fields[0], fingerprint, normalized, nil
+}
+
+// generateSFTPKey returns a new ed25519 key pair as an authorized_keys
+// line and an unencrypted OpenSSH private key.
+func generateSFTPKey(comment string) (string, string, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("generate sftp key: %w", err)
+	}
+	var pubBlob bytes.Buffer
+	writeSSHString(&pubBlob, []byte("ssh-ed25519"))
+	writeSSHString(&pubBlob, pub)
+
+	var check [4]byte
+	if _, err := rand.Read(check[:]); err != nil {
+		return "", "", fmt.Errorf("generate sftp key: %w", err)
+	}
+	var inner bytes.Buffer
+	inner.Write(check[:])
+	inner.Write(check[:])
+	writeSSHString(&inner, []byte("ssh-ed25519"))
+	writeSSHString(&inner, pub)
+	writeSSHString(&inner, priv)
+	writeSSHString(&inner, []byte(comment))
+	for i := byte(1); inner.Len()%8 != 0; i++ {
+		inner.WriteByte(i)
+	}
+
+	var key bytes.Buffer
+	key.WriteString("openssh-key-v1\x00")
+	writeSSHString(&key, []byte("none"))
+	writeSSHString(&key, []byte("none"))
+	writeSSHString(&key, nil)
+	_ = binary.Write(&key, binary.BigEndian, uint32(1))
+	writeSSHString(&key, pubBlob.Bytes())
+	writeSSHString(&key, inner.Bytes())
+
+	publicKey := "ssh-ed25519 " + base64.StdEncoding.EncodeToString(pubBlob.Bytes()) + " " + comment
+	privateKey := string(pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: key.Bytes()}))
+	return publicKey, privateKey, nil
+}
+
+// writeSSHString appends b as an SSH wire-format string (RFC 4251).
+func writeSSHString(buf *bytes.Buffer, b []byte) {
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(b)))
+	buf.Write(b)
+}
+
+func readSSHString(b []byte) (string, bool) {
+	if len(b) < 4 {
+		return "", false
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint64(n) > uint64(len(b)-4) {
+		return "", false
+	}
+	return string(b[4 : 4+n]), true
+}
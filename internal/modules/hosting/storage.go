@@ -0,0 +1,322 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/pkg/adapter"
+)
+
+const defaultStorageRegion = "us-east-1"
+
+// ErrSiteStorageNotConfigured indicates a site without object storage.
+var ErrSiteStorageNotConfigured = errors.New("object storage is not configured for this site")
+
+var (
+	storageBucketPattern     = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	storageRegionPattern     = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+	storageCredentialPattern = regexp.MustCompile(`^[A-Za-z0-9._~+/=-]{3,256}$`)
+)
+
+// SiteStorage is the S3-compatible bucket a site keeps its media in. The
+// secret key is never returned.
+type SiteStorage struct {
+	SiteID    int64     `json:"site_id"`
+	Endpoint  string    `json:"endpoint"`
+	Region    string    `json:"region"`
+	Bucket    string    `json:"bucket"`
+	AccessKey string    `json:"access_key"`
+	SecretKey string    `json:"-"`
+	PathStyle bool      `json:"path_style"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SetSiteStorageRequest configures object storage for a site. An empty
+// SecretKey keeps the stored one. PathStyle addresses the bucket as
+// endpoint/bucket (MinIO) instead of bucket.endpoint (AWS).
+type SetSiteStorageRequest struct {
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	PathStyle bool   `json:"path_style"`
+	Actor     string `json:"-"`
+}
+
+// GetSiteStorage returns the object storage settings of a site.
+func (s *Service) GetSiteStorage(ctx context.Context, siteID int64) (SiteStorage, error) {
+	if _, err := s.GetSite(ctx, siteID); err != nil {
+		return SiteStorage{}, err
+	}
+	return s.siteStorage(ctx, siteID)
+}
+
+// SetSiteStorage stores the bucket settings and rewrites the site PHP pool
+// and vhost: credentials reach PHP as AWS_* environment variables and
+// nginx proxies /media/ to the bucket, so assets never touch local disk.
+func (s *Service) SetSiteStorage(ctx context.Context, siteID int64, req SetSiteStorageRequest) (SiteStorage, error) {
+	if s.store == nil || s.nginx == nil || s.phpfpm == nil {
+		return SiteStorage{}, fmt.Errorf("hosting service is not fully configured")
+	}
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return SiteStorage{}, err
+	}
+	previous, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return SiteStorage{}, err
+	}
+
+	storage := SiteStorage{
+		SiteID:    siteID,
+		Endpoint:  strings.TrimRight(strings.TrimSpace(req.Endpoint), "/"),
+		Region:    strings.TrimSpace(req.Region),
+		Bucket:    strings.TrimSpace(req.Bucket),
+		AccessKey: strings.TrimSpace(req.AccessKey),
+		SecretKey: strings.TrimSpace(req.SecretKey),
+		PathStyle: req.PathStyle,
+	}
+	if storage.Region == "" {
+		storage.Region = defaultStorageRegion
+	}
+	if storage.SecretKey == "" {
+		if existing, err := s.siteStorage(ctx, siteID); err == nil {
+			storage.SecretKey = existing.SecretKey
+		}
+	}
+	if err := validateSiteStorage(storage); err != nil {
+		return SiteStorage{}, err
+	}
+
+	pathStyle := 0
+	if storage.PathStyle {
+		pathStyle = 1
+	}
+	upsert := fmt.Sprintf(`
+INSERT INTO site_storage(site_id, endpoint, region, bucket, access_key, secret_key, path_style, updated_at)
+VALUES(%d,'%s','%s','%s','%s','%s',%d,%d)
+ON CONFLICT(site_id) DO UPDATE SET endpoint=excluded.endpoint, region=excluded.region, bucket=excluded.bucket,
+  access_key=excluded.access_key, secret_key=excluded.secret_key, path_style=excluded.path_style,
+  updated_at=MAX(excluded.updated_at, site_storage.updated_at + 1);`,
+		siteID, sqlEscape(storage.Endpoint), sqlEscape(storage.Region), sqlEscape(storage.Bucket),
+		sqlEscape(storage.AccessKey), sqlEscape(storage.SecretKey), pathStyle, time.Now().Unix())
+	if err := s.store.ExecPanel(ctx, upsert); err != nil {
+		return SiteStorage{}, fmt.Errorf("save site storage: %w", err)
+	}
+	next, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return SiteStorage{}, err
+	}
+	if err := s.applySiteConfig(ctx, previous, next); err != nil {
+		return SiteStorage{}, err
+	}
+	_ = s.writeAudit(ctx, req.Actor, "hosting.storage.set", fmt.Sprintf("domain=%s bucket=%s endpoint=%s", site.Domain, storage.Bucket, storage.Endpoint))
+	return s.siteStorage(ctx, siteID)
+}
+
+// DeleteSiteStorage detaches object storage from a site. Bucket contents
+// are left untouched.
+func (s *Service) DeleteSiteStorage(ctx context.Context, siteID int64, actor string) error {
+	if s.store == nil || s.nginx == nil || s.phpfpm == nil {
+		return fmt.Errorf("hosting service is not fully configured")
+	}
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.siteStorage(ctx, siteID); err != nil {
+		return err
+	}
+	previous, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return err
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf("DELETE FROM site_storage WHERE site_id = %d;", siteID)); err != nil {
+		return fmt.Errorf("delete site storage: %w", err)
+	}
+	next, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return err
+	}
+	if err := s.applySiteConfig(ctx, previous, next); err != nil {
+		return err
+	}
+	_ = s.writeAudit(ctx, actor, "hosting.storage.delete", "domain="+site.Domain)
+	return nil
+}
+
+// siteConfig builds the adapter config of a site, including canonical host,
+// storage, memcached, resource limit and page cache settings layered on
+// top of the sites row.
+func (s *Service) siteConfig(ctx context.Context, site Site) (adapter.SiteConfig, error) {
+	cfg := adapter.SiteConfig{
+		Domain:     site.Domain,
+		RootDir:    site.RootDir,
+		PHPVersion: site.PHPVersion,
+		SystemUser: site.SystemUser,
+		TmpDir:     siteTmpDir(site.RootDir),
+
+		RelaxOpenBasedir: site.OpenBasedirRelaxed,
+	}
+	serverName, redirectHosts, err := canonicalHosts(site.Domain, site.CanonicalHost)
+	if err != nil {
+		return adapter.SiteConfig{}, err
+	}
+	cfg.ServerName = serverName
+	cfg.RedirectHosts = redirectHosts
+	storage, err := s.siteStorage(ctx, site.ID)
+	switch {
+	case err == nil:
+		cfg.Env = storage.env()
+		cfg.MediaUpstream = storage.mediaUpstream()
+	case !errors.Is(err, ErrSiteStorageNotConfigured):
+		return adapter.SiteConfig{}, err
+	}
+	memcached, err := s.siteMemcached(ctx, site)
+	switch {
+	case err == nil:
+		if cfg.Env == nil {
+			cfg.Env = map[string]string{}
+		}
+		maps.Copy(cfg.Env, memcached.env())
+	case !errors.Is(err, ErrMemcachedNotEnabled):
+		return adapter.SiteConfig{}, err
+	}
+	limits, err := s.siteLimits(ctx, site)
+	switch {
+	case err == nil:
+		cfg.Limits = limits.resourceLimits()
+	case !errors.Is(err, ErrSiteLimitsNotSet):
+		return adapter.SiteConfig{}, err
+	}
+	pageCache, err := s.sitePageCache(ctx, site)
+	switch {
+	case err == nil:
+		cfg.PageCache = s.pageCacheConfig(site.Domain, pageCache)
+	case !errors.Is(err, ErrPageCacheNotEnabled):
+		return adapter.SiteConfig{}, err
+	}
+	return cfg, nil
+}
+
+// applySiteConfig rewrites the pool and vhost of a site, restoring the
+// previous files when nginx rejects the new config.
+func (s *Service) applySiteConfig(ctx context.Context, previous, next adapter.SiteConfig) error {
+	if err := s.ensureSiteTmpDir(ctx, next); err != nil {
+		return err
+	}
+	if err := s.phpfpm.WritePool(ctx, next); err != nil {
+		return fmt.Errorf("write php-fpm pool: %w", err)
+	}
+	if err := s.nginx.WriteVhost(ctx, next); err != nil {
+		_ = s.phpfpm.WritePool(ctx, previous)
+		return fmt.Errorf("write nginx vhost: %w", err)
+	}
+	if err := s.nginx.TestConfig(ctx); err != nil {
+		_ = s.phpfpm.WritePool(ctx, previous)
+		_ = s.nginx.WriteVhost(ctx, previous)
+		return fmt.Errorf("test nginx config: %w", err)
+	}
+	if err := s.phpfpm.Restart(ctx, next.PHPVersion); err != nil {
+		return fmt.Errorf("restart php-fpm: %w", err)
+	}
+	if err := s.nginx.Reload(ctx); err != nil {
+		return fmt.Errorf("reload nginx: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) siteStorage(ctx context.Context, siteID int64) (SiteStorage, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT endpoint, region, bucket, access_key, secret_key, path_style, updated_at
+FROM site_storage
+WHERE site_id = %d;`, siteID))
+	if err != nil {
+		return SiteStorage{}, fmt.Errorf("get site storage: %w", err)
+	}
+	if len(rows) == 0 {
+		return SiteStorage{}, ErrSiteStorageNotConfigured
+	}
+	row := rows[0]
+	pathStyle, err := toInt64(row["path_style"])
+	if err != nil {
+		return SiteStorage{}, fmt.Errorf("parse storage path_style: %w", err)
+	}
+	updatedAt, err := toInt64(row["updated_at"])
+	if err != nil {
+		return SiteStorage{}, fmt.Errorf("parse storage updated_at: %w", err)
+	}
+	return SiteStorage{
+		SiteID:    siteID,
+		Endpoint:  fmt.Sprint(row["endpoint"]),
+		Region:    fmt.Sprint(row["region"]),
+		Bucket:    fmt.Sprint(row["bucket"]),
+		AccessKey: fmt.Sprint(row["access_key"]),
+		SecretKey: fmt.Sprint(row["secret_key"]),
+		PathStyle: pathStyle == 1,
+		UpdatedAt: time.Unix(updatedAt, 0).UTC(),
+	}, nil
+}
+
+func validateSiteStorage(storage SiteStorage) error {
+	endpoint, err := url.Parse(storage.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" ||
+		endpoint.Path != "" || endpoint.RawQuery != "" || endpoint.User != nil {
+		return fmt.Errorf("invalid endpoint: expected http(s)://host[:port]")
+	}
+	if !storageBucketPattern.MatchString(storage.Bucket) {
+		return fmt.Errorf("invalid bucket name")
+	}
+	if !storage.PathStyle && strings.Contains(storage.Bucket, ".") && endpoint.Scheme == "https" {
+		// bucket.example.com.s3.amazonaws.com would not match the wildcard certificate.
+		return fmt.Errorf("invalid bucket name: dotted buckets require path_style")
+	}
+	if !storageRegionPattern.MatchString(storage.Region) {
+		return fmt.Errorf("invalid region")
+	}
+	if !storageCredentialPattern.MatchString(storage.AccessKey) {
+		return fmt.Errorf("invalid access key")
+	}
+	if storage.SecretKey == "" {
+		return fmt.Errorf("secret key is required")
+	}
+	if !storageCredentialPattern.MatchString(storage.SecretKey) {
+		return fmt.Errorf("invalid secret key")
+	}
+	return nil
+}
+
+// env returns the variables exposed to the site PHP pool. The names
+// follow the AWS SDK and Laravel's s3 disk conventions.
+func (storage SiteStorage) env() map[string]string {
+	return map[string]string{
+		"AWS_ACCESS_KEY_ID":           storage.AccessKey,
+		"AWS_SECRET_ACCESS_KEY":       storage.SecretKey,
+		"AWS_DEFAULT_REGION":          storage.Region,
+		"AWS_BUCKET":                  storage.Bucket,
+		"AWS_ENDPOINT":                storage.Endpoint,
+		"AWS_ENDPOINT_URL":            storage.Endpoint,
+		"AWS_USE_PATH_STYLE_ENDPOINT": fmt.Sprint(storage.PathStyle),
+		"AWS_URL":                     "/media",
+	}
+}
+
+// mediaUpstream is the bucket URL /media/ is proxied to. Only objects the
+// bucket serves anonymously are reachable through the proxy.
+func (storage SiteStorage) mediaUpstream() string {
+	endpoint, err := url.Parse(storage.Endpoint)
+	if err != nil {
+		return ""
+	}
+	if storage.PathStyle {
+		return endpoint.Scheme + "://" + endpoint.Host + "/" + storage.Bucket + "/"
+	}
+	return endpoint.Scheme + "://" + storage.Bucket + "." + endpoint.Host + "/"
+}
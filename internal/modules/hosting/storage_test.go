@@ -0,0 +1,96 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestService_SiteStorage(t *testing.T) {
+	ctx := context.Background()
+	runner := newSiteUserRunner()
+	nginx := &fakeNginxAdapter{}
+	phpfpm := &fakePHPFPMAdapter{}
+	svc := newTestService(t, withRunner(runner), withNginx(nginx), withPHPFPM(phpfpm))
+	site := createTestSite(t, svc)
+
+	if _, err := svc.GetSiteStorage(ctx, site.ID); !errors.Is(err, ErrSiteStorageNotConfigured) {
+		t.Fatalf("expected ErrSiteStorageNotConfigured, got %v", err)
+	}
+	req := SetSiteStorageRequest{
+		Endpoint:  "https://minio.example.net:9000/",
+		Bucket:    "site-assets",
+		AccessKey: "AKIAEXAMPLE",
+		PathStyle: true,
+	}
+	if _, err := svc.SetSiteStorage(ctx, site.ID, req); err == nil || !strings.Contains(err.Error(), "secret key is required") {
+		t.Fatalf("expected missing secret to be rejected, got %v", err)
+	}
+	req.SecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCY"
+	storage, err := svc.SetSiteStorage(ctx, site.ID, req)
+	if err != nil {
+		t.Fatalf("set storage: %v", err)
+	}
+	if storage.Endpoint != "https://minio.example.net:9000" || storage.Region != defaultStorageRegion || !storage.PathStyle {
+		t.Fatalf("unexpected storage: %+v", storage)
+	}
+
+	pool := phpfpm.writeCalls[len(phpfpm.writeCalls)-1]
+	if pool.Env["AWS_SECRET_ACCESS_KEY"] != req.SecretKey || pool.Env["AWS_BUCKET"] != "site-assets" || pool.Env["AWS_USE_PATH_STYLE_ENDPOINT"] != "true" {
+		t.Fatalf("unexpected pool env: %+v", pool.Env)
+	}
+	vhost := nginx.writeCalls[len(nginx.writeCalls)-1]
+	if vhost.MediaUpstream != "https://minio.example.net:9000/site-assets/" {
+		t.Fatalf("unexpected media upstream: %q", vhost.MediaUpstream)
+	}
+
+	// Updating without a secret keeps the stored one.
+	req.SecretKey = ""
+	req.PathStyle = false
+	if _, err := svc.SetSiteStorage(ctx, site.ID, req); err != nil {
+		t.Fatalf("update storage: %v", err)
+	}
+	pool = phpfpm.writeCalls[len(phpfpm.writeCalls)-1]
+	vhost = nginx.writeCalls[len(nginx.writeCalls)-1]
+	if pool.Env["AWS_SECRET_ACCESS_KEY"] != "wJalrXUtnFEMI/K7MDENG+bPxRfiCY" || vhost.MediaUpstream != "https://site-assets.minio.example.net:9000/" {
+		t.Fatalf("unexpected config after update: env=%+v upstream=%q", pool.Env, vhost.MediaUpstream)
+	}
+
+	if err := svc.DeleteSiteStorage(ctx, site.ID, "admin@example.com"); err != nil {
+		t.Fatalf("delete storage: %v", err)
+	}
+	pool = phpfpm.writeCalls[len(phpfpm.writeCalls)-1]
+	vhost = nginx.writeCalls[len(nginx.writeCalls)-1]
+	if len(pool.Env) != 0 || vhost.MediaUpstream != "" {
+		t.Fatalf("expected storage removed from site config, got env=%+v upstream=%q", pool.Env, vhost.MediaUpstream)
+	}
+}
+
+func TestService_SiteStorageRestoresConfigOnNginxFailure(t *testing.T) {
+	ctx := context.Background()
+	runner := newSiteUserRunner()
+	nginx := &fakeNginxAdapter{}
+	phpfpm := &fakePHPFPMAdapter{}
+	svc := newTestService(t, withRunner(runner), withNginx(nginx), withPHPFPM(phpfpm))
+	site := createTestSite(t, svc)
+
+	nginx.failTest = fmt.Errorf("nginx: [emerg] host not found in upstream")
+	_, err := svc.SetSiteStorage(ctx, site.ID, SetSiteStorageRequest{
+		Endpoint:  "https://s3.eu-central-1.amazonaws.com",
+		Region:    "eu-central-1",
+		Bucket:    "site-assets",
+		AccessKey: "AKIAEXAMPLE",
+		SecretKey: "secret",
+	})
+	if err == nil {
+		t.Fatal("expected nginx test failure")
+	}
+	if last := nginx.writeCalls[len(nginx.writeCalls)-1]; last.MediaUpstream != "" {
+		t.Fatalf("expected previous vhost restored, got %+v", last)
+	}
+	if last := phpfpm.writeCalls[len(phpfpm.writeCalls)-1]; len(last.Env) != 0 {
+		t.Fatalf("expected previous pool restored, got %+v", last)
+	}
+}
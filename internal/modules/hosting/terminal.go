@@ -0,0 +1,241 @@
+package hosting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/pty"
+	"github.com/robsonek/aiPanel/internal/platform/settings"
+	"github.com/robsonek/aiPanel/internal/platform/websocket"
+)
+
+const (
+	terminalIdleTimeout = 15 * time.Minute
+	terminalMaxDuration = 4 * time.Hour
+	// terminalCommandLimit bounds one audited command line.
+	terminalCommandLimit = 1024
+)
+
+// ErrTerminalDisabled indicates the web terminal was not opted into.
+var ErrTerminalDisabled = errors.New("web terminal is disabled (web_terminal_enabled)")
+
+// Terminal is a shell running as a site user on a pseudo-terminal.
+// Commands typed into it are written to the audit log.
+type Terminal struct {
+	svc     *Service
+	ctx     context.Context
+	site    Site
+	actor   string
+	cmd     *exec.Cmd
+	pty     *os.File
+	started time.Time
+
+	mu        sync.Mutex
+	line      []byte
+	escape    int
+	closeOnce sync.Once
+}
+
+// terminalControl is a JSON control message sent in a text frame.
+type terminalControl struct {
+	Type string `json:"type"`
+	Cols int    `json:"cols"`
+	Rows int    `json:"rows"`
+}
+
+// webTerminalEnabled reads the security toggle, web_terminal_enabled in
+// panel.yaml until it is changed in the panel.
+func (s *Service) webTerminalEnabled(ctx context.Context) bool {
+	if s.settings != nil {
+		return s.settings.Bool(ctx, settings.Security, "web_terminal")
+	}
+	return s.cfg.WebTerminalEnabled
+}
+
+// StartTerminal starts a login shell as the site user in the site home,
+// inside the site slice when the site has resource limits. The shell gets
+// a minimal environment so panel settings never leak into it.
+func (s *Service) StartTerminal(ctx context.Context, siteID int64, actor string) (*Terminal, error) {
+	if !s.webTerminalEnabled(ctx) {
+		return nil, ErrTerminalDisabled
+	}
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return nil, err
+	}
+	args := s.terminalCommand(ctx, site)
+	//nolint:gosec // argv is built from the stored site, not request input.
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = filepath.Dir(site.RootDir)
+	cmd.Env = terminalEnv(site)
+	master, err := pty.Start(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("start terminal: %w", err)
+	}
+	t := &Terminal{
+		svc:     s,
+		ctx:     context.WithoutCancel(ctx),
+		site:    site,
+		actor:   actor,
+		cmd:     cmd,
+		pty:     master,
+		started: time.Now(),
+	}
+	_ = s.writeAudit(t.ctx, actor, "hosting.terminal.open", "domain="+site.Domain)
+	return t, nil
+}
+
+// Serve pumps terminal output to conn as binary frames and conn input to
+// the terminal until either side ends. Binary frames carry keystrokes;
+// text frames carry control messages such as
+// {"type":"resize","cols":120,"rows":40}.
+func (t *Terminal) Serve(conn *websocket.Conn) {
+	defer t.Close()
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := t.pty.Read(buf)
+			if n > 0 {
+				if werr := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); werr != nil {
+					break
+				}
+			}
+			if err != nil {
+				_ = conn.WriteClose(1000, "session ended")
+				break
+			}
+		}
+		_ = conn.Close()
+	}()
+
+	deadline := t.started.Add(terminalMaxDuration)
+	for {
+		idle := time.Now().Add(terminalIdleTimeout)
+		if idle.After(deadline) {
+			idle = deadline
+		}
+		_ = conn.SetReadDeadline(idle)
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		switch messageType {
+		case websocket.BinaryMessage:
+			if _, err := t.Write(data); err != nil {
+				return
+			}
+		case websocket.TextMessage:
+			var ctl terminalControl
+			if json.Unmarshal(data, &ctl) == nil && ctl.Type == "resize" {
+				_ = pty.Setsize(t.pty, ctl.Rows, ctl.Cols)
+			}
+		}
+	}
+}
+
+// Write sends input to the shell, auditing each completed command line.
+// Input typed while echo is off (password prompts) is not recorded.
+func (t *Terminal) Write(p []byte) (int, error) {
+	if echo, err := pty.Echo(t.pty); err != nil || echo {
+		for _, line := range t.record(p) {
+			_ = t.svc.writeAudit(t.ctx, t.actor, "hosting.terminal.command", fmt.Sprintf("domain=%s command=%s", t.site.Domain, line))
+		}
+	}
+	return t.pty.Write(p)
+}
+
+// Close hangs up the shell and waits for it to exit.
+func (t *Terminal) Close() error {
+	t.closeOnce.Do(func() {
+		_ = t.pty.Close()
+		done := make(chan struct{})
+		go func() {
+			_ = t.cmd.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			_ = t.cmd.Process.Kill()
+			<-done
+		}
+		_ = t.svc.writeAudit(t.ctx, t.actor, "hosting.terminal.close",
+			fmt.Sprintf("domain=%s duration=%s", t.site.Domain, time.Since(t.started).Round(time.Second)))
+	})
+	return nil
+}
+
+// record feeds keystrokes into the current line and returns the lines
+// completed by Enter. Editing keys are applied; escape sequences (arrows,
+// function keys) are dropped, so history recalls are not reconstructed.
+func (t *Terminal) record(p []byte) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var lines []string
+	for _, b := range p {
+		switch {
+		case t.escape == 1:
+			t.escape = 0
+			if b == '[' || b == 'O' {
+				t.escape = 2
+			}
+		case t.escape == 2:
+			if b >= 0x40 && b <= 0x7e {
+				t.escape = 0
+			}
+		case b == 0x1b:
+			t.escape = 1
+		case b == '\r' || b == '\n':
+			if len(t.line) > 0 {
+				lines = append(lines, string(t.line))
+			}
+			t.line = t.line[:0]
+		case b == 0x7f || b == 0x08:
+			// Drop the last UTF-8 sequence, not just its last byte.
+			for len(t.line) > 0 {
+				last := t.line[len(t.line)-1]
+				t.line = t.line[:len(t.line)-1]
+				if last < 0x80 || last >= 0xc0 {
+					break
+				}
+			}
+		case b == 0x03 || b == 0x15:
+			t.line = t.line[:0]
+		case b < 0x20:
+		default:
+			if len(t.line) < terminalCommandLimit {
+				t.line = append(t.line, b)
+			}
+		}
+	}
+	return lines
+}
+
+func (s *Service) terminalCommand(ctx context.Context, site Site) []string {
+	args := []string{"runuser", "-u", site.SystemUser, "--", "/bin/bash", "--login"}
+	if limits, err := s.siteLimits(ctx, site); err == nil {
+		args = append([]string{"systemd-run", "--quiet", "--scope", "--slice=" + limits.Slice}, args...)
+	}
+	return args
+}
+
+func terminalEnv(site Site) []string {
+	home := filepath.Dir(site.RootDir)
+	return []string{
+		"HOME=" + home,
+		"USER=" + site.SystemUser,
+		"LOGNAME=" + site.SystemUser,
+		"SHELL=/bin/bash",
+		"TERM=xterm-256color",
+		"LANG=C.UTF-8",
+		"PATH=/usr/local/bin:/usr/bin:/bin",
+		"TMPDIR=" + siteTmpDir(site.RootDir),
+	}
+}
@@ -0,0 +1,76 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+)
+
+func TestTerminal_RecordsCommandLines(t *testing.T) {
+	term := &Terminal{}
+	input := "ls -la\r" +
+		"cd pub\x7f\x7f\x7fpublic_html\r" + // backspaces
+		"\x1b[A\r" + // history recall is not reconstructed
+		"rm -rf /\x03" + // Ctrl-C discards the line
+		"echo zażółć\x7f\r" +
+		"\r"
+	var got []string
+	for _, chunk := range strings.SplitAfter(input, "p") {
+		got = append(got, term.record([]byte(chunk))...)
+	}
+	want := []string{"ls -la", "cd public_html", "echo zażół"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("unexpected recorded lines: %q", got)
+	}
+}
+
+func TestTerminal_CommandRunsAsSiteUser(t *testing.T) {
+	ctx := context.Background()
+	svc := newACMEService(t, config.Config{}, &fakeRunner{})
+	site, err := svc.GetSite(ctx, 1)
+	if err != nil {
+		t.Fatalf("get site: %v", err)
+	}
+	want := []string{"runuser", "-u", "site_example_com", "--", "/bin/bash", "--login"}
+	if got := svc.terminalCommand(ctx, site); !slices.Equal(got, want) {
+		t.Fatalf("unexpected command: %v", got)
+	}
+	if err := svc.store.ExecPanel(ctx, "INSERT INTO site_limits(site_id, memory_max_mb, updated_at) VALUES(1, 512, 1);"); err != nil {
+		t.Fatalf("seed limits: %v", err)
+	}
+	got := svc.terminalCommand(ctx, site)
+	if !slices.Equal(got[:4], []string{"systemd-run", "--quiet", "--scope", "--slice=aipanel-site-example-com.slice"}) || !slices.Equal(got[4:], want) {
+		t.Fatalf("expected command in the site slice, got %v", got)
+	}
+	env := terminalEnv(site)
+	home := filepath.Join(svc.webRoot, "example.com")
+	if !slices.Contains(env, "HOME="+home) || !slices.Contains(env, "TMPDIR="+home+"/tmp") {
+		t.Fatalf("unexpected environment: %v", env)
+	}
+}
+
+func TestTerminal_RequiresOptIn(t *testing.T) {
+	svc := newACMEService(t, config.Config{}, &fakeRunner{})
+	if _, err := svc.StartTerminal(context.Background(), 1, "admin@example.com"); !errors.Is(err, ErrTerminalDisabled) {
+		t.Fatalf("expected ErrTerminalDisabled, got %v", err)
+	}
+	rec := httptest.NewRecorder()
+	NewHandler(svc).HandleSiteTerminal(rec, httptest.NewRequest(http.MethodGet, "/api/sites/1/terminal", nil), 1, "admin@example.com")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 when disabled, got %d", rec.Code)
+	}
+
+	svc.cfg.WebTerminalEnabled = true
+	rec = httptest.NewRecorder()
+	NewHandler(svc).HandleSiteTerminal(rec, httptest.NewRequest(http.MethodGet, "/api/sites/9/terminal", nil), 9, "admin@example.com")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a missing site, got %d", rec.Code)
+	}
+}
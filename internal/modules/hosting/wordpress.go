@@ -0,0 +1,494 @@
+package hosting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+)
+
+// UpdateWordPressJob is the job type that updates WordPress core, plugins
+// or themes of one site.
+const UpdateWordPressJob = "hosting.wordpress.update"
+
+const (
+	defaultPHPCLI = "/opt/aipanel/runtime/php-fpm/current/bin/php"
+	defaultWPCLI  = "/usr/local/bin/wp"
+	// wpOutputTail bounds the wp-cli output kept in error messages.
+	wpOutputTail = 2048
+	// wpXMLRPCPlugin is the must-use plugin written by the disable_xmlrpc preset.
+	wpXMLRPCPlugin = "aipanel-disable-xmlrpc.php"
+)
+
+// WordPress core auto-update modes.
+const (
+	WPAutoUpdateOff   = "off"
+	WPAutoUpdateMinor = "minor"
+	WPAutoUpdateAll   = "all"
+)
+
+// WordPress hardening presets.
+const (
+	WPHardenDisableXMLRPC   = "disable_xmlrpc"
+	WPHardenDisableFileEdit = "disable_file_edit"
+)
+
+// ErrNotWordPress indicates a site whose docroot holds no WordPress install.
+var ErrNotWordPress = errors.New("site is not a WordPress install")
+
+// wpSlugPattern matches plugin and theme slugs; it also keeps names from
+// being read as wp-cli flags.
+var wpSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,99}$`)
+
+// WPComponent is an installed plugin or theme.
+type WPComponent struct {
+	Name          string `json:"name"`
+	Status        string `json:"status"`
+	Version       string `json:"version"`
+	UpdateVersion string `json:"update_version,omitempty"`
+	AutoUpdate    bool   `json:"auto_update"`
+}
+
+// WPAutoUpdates are the auto-update settings applied to a site.
+type WPAutoUpdates struct {
+	// Core is "off", "minor" (WordPress default) or "all".
+	Core    string `json:"core"`
+	Plugins bool   `json:"plugins"`
+	Themes  bool   `json:"themes"`
+	Actor   string `json:"-"`
+}
+
+// WordPressSite is the WordPress state of a site.
+type WordPressSite struct {
+	SiteID          int64             `json:"site_id"`
+	CoreVersion     string            `json:"core_version"`
+	CoreUpdate      string            `json:"core_update,omitempty"`
+	Plugins         []WPComponent     `json:"plugins"`
+	Themes          []WPComponent     `json:"themes"`
+	AutoUpdates     WPAutoUpdates     `json:"auto_updates"`
+	Hardening       []string          `json:"hardening"`
+	Vulnerabilities []WPVulnerability `json:"vulnerabilities"`
+	ScannedAt       time.Time         `json:"scanned_at,omitzero"`
+}
+
+// WPUpdateRequest selects what an update job updates. Plugins and Themes
+// hold slugs; "*" updates all of them.
+type WPUpdateRequest struct {
+	Core    bool     `json:"core"`
+	Plugins []string `json:"plugins"`
+	Themes  []string `json:"themes"`
+	Actor   string   `json:"-"`
+}
+
+// WPHardeningRequest sets the hardening presets of a site; presets not
+// listed are reverted.
+type WPHardeningRequest struct {
+	Presets []string `json:"presets"`
+	Actor   string   `json:"-"`
+}
+
+type wpUpdatePayload struct {
+	SiteID  int64           `json:"site_id"`
+	Request WPUpdateRequest `json:"request"`
+	Actor   string          `json:"actor"`
+}
+
+// wpSettings is the site_wordpress row of a site.
+type wpSettings struct {
+	autoUpdates     WPAutoUpdates
+	hardening       []string
+	vulnerabilities []WPVulnerability
+	scannedAt       time.Time
+}
+
+// isWordPress reports whether the site docroot holds a WordPress install.
+func isWordPress(site Site) bool {
+	_, err := os.Stat(filepath.Join(site.RootDir, "wp-includes", "version.php"))
+	return err == nil
+}
+
+// wordPressSite returns the site if it is a WordPress install.
+func (s *Service) wordPressSite(ctx context.Context, siteID int64) (Site, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return Site{}, err
+	}
+	if !isWordPress(site) {
+		return Site{}, ErrNotWordPress
+	}
+	return site, nil
+}
+
+// GetWordPress lists core, plugin and theme versions with available
+// updates, and the panel-managed settings of a WordPress site.
+func (s *Service) GetWordPress(ctx context.Context, siteID int64) (WordPressSite, error) {
+	site, err := s.wordPressSite(ctx, siteID)
+	if err != nil {
+		return WordPressSite{}, err
+	}
+	wp, err := s.inspectWordPress(ctx, site)
+	if err != nil {
+		return WordPressSite{}, err
+	}
+	settings, err := s.wordPressSettings(ctx, siteID)
+	if err != nil {
+		return WordPressSite{}, err
+	}
+	wp.AutoUpdates = settings.autoUpdates
+	wp.Hardening = settings.hardening
+	wp.Vulnerabilities = settings.vulnerabilities
+	wp.ScannedAt = settings.scannedAt
+	return wp, nil
+}
+
+// inspectWordPress reads versions and available updates through wp-cli.
+func (s *Service) inspectWordPress(ctx context.Context, site Site) (WordPressSite, error) {
+	wp := WordPressSite{SiteID: site.ID}
+	out, err := s.wp(ctx, site, "core", "version")
+	if err != nil {
+		return WordPressSite{}, err
+	}
+	wp.CoreVersion = lastLine(out)
+
+	out, err = s.wp(ctx, site, "core", "check-update", "--format=json")
+	if err != nil {
+		return WordPressSite{}, err
+	}
+	var coreUpdates []struct {
+		Version string `json:"version"`
+	}
+	// Without updates wp-cli prints a success message instead of JSON.
+	if raw := jsonLine(out); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &coreUpdates); err != nil {
+			return WordPressSite{}, fmt.Errorf("decode core updates: %w", err)
+		}
+	}
+	for _, u := range coreUpdates {
+		if compareVersions(u.Version, wp.CoreUpdate) > 0 {
+			wp.CoreUpdate = u.Version
+		}
+	}
+
+	if wp.Plugins, err = s.wpComponents(ctx, site, "plugin"); err != nil {
+		return WordPressSite{}, err
+	}
+	if wp.Themes, err = s.wpComponents(ctx, site, "theme"); err != nil {
+		return WordPressSite{}, err
+	}
+	return wp, nil
+}
+
+func (s *Service) wpComponents(ctx context.Context, site Site, kind string) ([]WPComponent, error) {
+	out, err := s.wp(ctx, site, kind, "list", "--format=json", "--fields=name,status,version,update_version,auto_update")
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Name          string `json:"name"`
+		Status        string `json:"status"`
+		Version       string `json:"version"`
+		UpdateVersion string `json:"update_version"`
+		AutoUpdate    string `json:"auto_update"`
+	}
+	if err := json.Unmarshal([]byte(jsonLine(out)), &rows); err != nil {
+		return nil, fmt.Errorf("decode %s list: %w", kind, err)
+	}
+	components := make([]WPComponent, 0, len(rows))
+	for _, row := range rows {
+		components = append(components, WPComponent{
+			Name:          row.Name,
+			Status:        row.Status,
+			Version:       row.Version,
+			UpdateVersion: row.UpdateVersion,
+			AutoUpdate:    row.AutoUpdate == "on",
+		})
+	}
+	return components, nil
+}
+
+// UpdateWordPress queues an update of core, plugins or themes. Updates run
+// on the job queue since core updates download and migrate the database.
+func (s *Service) UpdateWordPress(ctx context.Context, siteID int64, req WPUpdateRequest) (int64, error) {
+	if s.jobs == nil {
+		return 0, fmt.Errorf("job queue is not configured")
+	}
+	site, err := s.wordPressSite(ctx, siteID)
+	if err != nil {
+		return 0, err
+	}
+	if !req.Core && len(req.Plugins) == 0 && len(req.Themes) == 0 {
+		return 0, fmt.Errorf("nothing to update: core, plugins or themes is required")
+	}
+	for _, slug := range append(slices.Clone(req.Plugins), req.Themes...) {
+		if slug != "*" && !wpSlugPattern.MatchString(slug) {
+			return 0, fmt.Errorf("invalid plugin or theme slug %q", slug)
+		}
+	}
+	id, err := s.jobs.Enqueue(ctx, UpdateWordPressJob, wpUpdatePayload{SiteID: siteID, Request: req, Actor: req.Actor})
+	if err != nil {
+		return 0, err
+	}
+	_ = s.writeAudit(ctx, req.Actor, "hosting.wordpress.update_requested", fmt.Sprintf("domain=%s job_id=%d", site.Domain, id))
+	return id, nil
+}
+
+func (s *Service) runWordPressUpdate(ctx context.Context, job jobqueue.Job) error {
+	var payload wpUpdatePayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("decode wordpress update payload: %w", err)
+	}
+	site, err := s.wordPressSite(ctx, payload.SiteID)
+	if errors.Is(err, ErrSiteNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	req := payload.Request
+	var steps [][]string
+	if req.Core {
+		steps = append(steps, []string{"core", "update"}, []string{"core", "update-db"})
+	}
+	for kind, slugs := range map[string][]string{"plugin": req.Plugins, "theme": req.Themes} {
+		switch {
+		case len(slugs) == 0:
+		case slices.Contains(slugs, "*"):
+			steps = append(steps, []string{kind, "update", "--all"})
+		default:
+			steps = append(steps, append([]string{kind, "update"}, slugs...))
+		}
+	}
+	for _, args := range steps {
+		if _, err := s.wp(ctx, site, args...); err != nil {
+			_ = s.writeAudit(ctx, payload.Actor, "hosting.wordpress.update_failed", "domain="+site.Domain)
+			return err
+		}
+	}
+	_ = s.writeAudit(ctx, payload.Actor, "hosting.wordpress.update", fmt.Sprintf("domain=%s core=%t plugins=%s themes=%s",
+		site.Domain, req.Core, strings.Join(req.Plugins, ","), strings.Join(req.Themes, ",")))
+	s.purgeAfterDeploy(ctx, site, payload.Actor)
+	return nil
+}
+
+// SetWordPressAutoUpdates sets WP_AUTO_UPDATE_CORE in wp-config.php and
+// toggles auto-updates of all installed plugins and themes.
+func (s *Service) SetWordPressAutoUpdates(ctx context.Context, siteID int64, req WPAutoUpdates) (WordPressSite, error) {
+	site, err := s.wordPressSite(ctx, siteID)
+	if err != nil {
+		return WordPressSite{}, err
+	}
+	var coreArgs []string
+	switch req.Core {
+	case WPAutoUpdateOff:
+		coreArgs = []string{"config", "set", "WP_AUTO_UPDATE_CORE", "false", "--raw"}
+	case "", WPAutoUpdateMinor:
+		req.Core = WPAutoUpdateMinor
+		coreArgs = []string{"config", "set", "WP_AUTO_UPDATE_CORE", "minor"}
+	case WPAutoUpdateAll:
+		coreArgs = []string{"config", "set", "WP_AUTO_UPDATE_CORE", "true", "--raw"}
+	default:
+		return WordPressSite{}, fmt.Errorf("invalid core auto-update mode: expected off, minor or all")
+	}
+	toggle := func(on bool) string {
+		if on {
+			return "enable"
+		}
+		return "disable"
+	}
+	for _, args := range [][]string{
+		coreArgs,
+		{"plugin", "auto-updates", toggle(req.Plugins), "--all"},
+		{"theme", "auto-updates", toggle(req.Themes), "--all"},
+	} {
+		if _, err := s.wp(ctx, site, args...); err != nil {
+			return WordPressSite{}, err
+		}
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO site_wordpress(site_id, auto_update_core, auto_update_plugins, auto_update_themes, updated_at)
+VALUES(%d,'%s',%d,%d,%d)
+ON CONFLICT(site_id) DO UPDATE SET auto_update_core=excluded.auto_update_core,
+  auto_update_plugins=excluded.auto_update_plugins, auto_update_themes=excluded.auto_update_themes,
+  updated_at=MAX(excluded.updated_at, site_wordpress.updated_at + 1);`,
+		siteID, sqlEscape(req.Core), boolToInt(req.Plugins), boolToInt(req.Themes), time.Now().Unix())); err != nil {
+		return WordPressSite{}, fmt.Errorf("save wordpress auto-updates: %w", err)
+	}
+	_ = s.writeAudit(ctx, req.Actor, "hosting.wordpress.auto_updates", fmt.Sprintf("domain=%s core=%s plugins=%t themes=%t",
+		site.Domain, req.Core, req.Plugins, req.Themes))
+	return s.GetWordPress(ctx, siteID)
+}
+
+// SetWordPressHardening applies the listed presets and reverts the ones
+// applied before but no longer listed.
+func (s *Service) SetWordPressHardening(ctx context.Context, siteID int64, req WPHardeningRequest) (WordPressSite, error) {
+	site, err := s.wordPressSite(ctx, siteID)
+	if err != nil {
+		return WordPressSite{}, err
+	}
+	presets := make([]string, 0, len(req.Presets))
+	for _, preset := range req.Presets {
+		preset = strings.ToLower(strings.TrimSpace(preset))
+		if preset != WPHardenDisableXMLRPC && preset != WPHardenDisableFileEdit {
+			return WordPressSite{}, fmt.Errorf("invalid hardening preset %q", preset)
+		}
+		if !slices.Contains(presets, preset) {
+			presets = append(presets, preset)
+		}
+	}
+	slices.Sort(presets)
+	settings, err := s.wordPressSettings(ctx, siteID)
+	if err != nil {
+		return WordPressSite{}, err
+	}
+
+	wantFileEdit := slices.Contains(presets, WPHardenDisableFileEdit)
+	switch {
+	case wantFileEdit:
+		_, err = s.wp(ctx, site, "config", "set", "DISALLOW_FILE_EDIT", "true", "--raw")
+	case slices.Contains(settings.hardening, WPHardenDisableFileEdit):
+		// Only remove the constant when the panel set it.
+		_, err = s.wp(ctx, site, "config", "delete", "DISALLOW_FILE_EDIT")
+	}
+	if err != nil {
+		return WordPressSite{}, err
+	}
+	if err := s.setXMLRPCPlugin(ctx, site, slices.Contains(presets, WPHardenDisableXMLRPC)); err != nil {
+		return WordPressSite{}, err
+	}
+
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO site_wordpress(site_id, hardening, updated_at) VALUES(%d,'%s',%d)
+ON CONFLICT(site_id) DO UPDATE SET hardening=excluded.hardening,
+  updated_at=MAX(excluded.updated_at, site_wordpress.updated_at + 1);`,
+		siteID, sqlEscape(strings.Join(presets, ",")), time.Now().Unix())); err != nil {
+		return WordPressSite{}, fmt.Errorf("save wordpress hardening: %w", err)
+	}
+	_ = s.writeAudit(ctx, req.Actor, "hosting.wordpress.hardening", fmt.Sprintf("domain=%s presets=%s", site.Domain, strings.Join(presets, ",")))
+	return s.GetWordPress(ctx, siteID)
+}
+
+// setXMLRPCPlugin writes or removes a must-use plugin that turns XML-RPC
+// off; must-use plugins cannot be deactivated from wp-admin.
+func (s *Service) setXMLRPCPlugin(ctx context.Context, site Site, enabled bool) error {
+	dir := filepath.Join(site.RootDir, "wp-content", "mu-plugins")
+	path := filepath.Join(dir, wpXMLRPCPlugin)
+	if !enabled {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove xml-rpc plugin: %w", err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("create mu-plugins dir: %w", err)
+	}
+	body := "<?php\n" +
+		"// Managed by aiPanel (hardening preset disable_xmlrpc).\n" +
+		"add_filter('xmlrpc_enabled', '__return_false');\n" +
+		"add_filter('xmlrpc_methods', '__return_empty_array');\n" +
+		"add_filter('wp_headers', function ($headers) { unset($headers['X-Pingback']); return $headers; });\n"
+	if err := os.WriteFile(path, []byte(body), 0o640); err != nil {
+		return fmt.Errorf("write xml-rpc plugin: %w", err)
+	}
+	if _, err := s.runner.Run(ctx, "chown", site.SystemUser+":"+nginxContentReaderGroup, dir, path); err != nil {
+		return fmt.Errorf("chown xml-rpc plugin: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) wordPressSettings(ctx context.Context, siteID int64) (wpSettings, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT auto_update_core, auto_update_plugins, auto_update_themes, hardening, vulnerabilities, scanned_at
+FROM site_wordpress WHERE site_id = %d;`, siteID))
+	if err != nil {
+		return wpSettings{}, fmt.Errorf("get wordpress settings: %w", err)
+	}
+	settings := wpSettings{
+		autoUpdates:     WPAutoUpdates{Core: WPAutoUpdateMinor},
+		hardening:       []string{},
+		vulnerabilities: []WPVulnerability{},
+	}
+	if len(rows) == 0 {
+		return settings, nil
+	}
+	row := rows[0]
+	if core, _ := row["auto_update_core"].(string); core != "" {
+		settings.autoUpdates.Core = core
+	}
+	plugins, err := toInt64(row["auto_update_plugins"])
+	if err != nil {
+		return wpSettings{}, fmt.Errorf("parse auto_update_plugins: %w", err)
+	}
+	themes, err := toInt64(row["auto_update_themes"])
+	if err != nil {
+		return wpSettings{}, fmt.Errorf("parse auto_update_themes: %w", err)
+	}
+	settings.autoUpdates.Plugins = plugins == 1
+	settings.autoUpdates.Themes = themes == 1
+	if hardening, _ := row["hardening"].(string); hardening != "" {
+		settings.hardening = strings.Split(hardening, ",")
+	}
+	if raw, _ := row["vulnerabilities"].(string); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &settings.vulnerabilities); err != nil {
+			return wpSettings{}, fmt.Errorf("decode vulnerabilities: %w", err)
+		}
+	}
+	scannedAt, err := toInt64(row["scanned_at"])
+	if err != nil {
+		return wpSettings{}, fmt.Errorf("parse scanned_at: %w", err)
+	}
+	if scannedAt > 0 {
+		settings.scannedAt = time.Unix(scannedAt, 0).UTC()
+	}
+	return settings, nil
+}
+
+// wp runs wp-cli as the site user. Plugins and themes are not loaded, so
+// a broken plugin cannot stop the panel from updating it.
+func (s *Service) wp(ctx context.Context, site Site, args ...string) (string, error) {
+	full := append([]string{
+		"-u", site.SystemUser, "--",
+		s.phpCLI, s.wpCLI, "--path=" + site.RootDir, "--skip-plugins", "--skip-themes", "--no-color",
+	}, args...)
+	out, err := s.runner.Run(ctx, "runuser", full...)
+	if err != nil {
+		tail := strings.TrimSpace(out)
+		if len(tail) > wpOutputTail {
+			tail = tail[len(tail)-wpOutputTail:]
+		}
+		return out, fmt.Errorf("wp %s: %w: %s", strings.Join(args, " "), err, tail)
+	}
+	return out, nil
+}
+
+// jsonLine returns the last line of wp-cli output that holds JSON; PHP
+// notices and warnings end up in the same output.
+func jsonLine(out string) string {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(line, "[") || strings.HasPrefix(line, "{") {
+			return line
+		}
+	}
+	return ""
+}
+
+func lastLine(out string) string {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+func boolToInt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
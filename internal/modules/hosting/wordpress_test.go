@@ -0,0 +1,177 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+)
+
+// newWordPressService returns a service whose seeded site is a WordPress
+// install answering wp-cli with canned output.
+func newWordPressService(t *testing.T, cfg config.Config) (*Service, *fakeRunner, string) {
+	t.Helper()
+	runner := &fakeRunner{}
+	svc := newACMEService(t, cfg, runner)
+	root := filepath.Join(svc.webRoot, "example.com", "public_html")
+	if err := os.MkdirAll(filepath.Join(root, "wp-includes"), 0o750); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "wp-includes", "version.php"), []byte("<?php\n"), 0o640); err != nil {
+		t.Fatalf("write version.php: %v", err)
+	}
+	prefix := fmt.Sprintf("runuser -u site_example_com -- %s %s --path=%s --skip-plugins --skip-themes --no-color ", defaultPHPCLI, defaultWPCLI, root)
+	runner.outputs = map[string]string{
+		prefix + "core version":                    "6.4.2\n",
+		prefix + "core check-update --format=json": "PHP Notice: something\n[{\"version\":\"6.5.3\",\"update_type\":\"major\"},{\"version\":\"6.4.4\",\"update_type\":\"minor\"}]\n",
+		prefix + "plugin list --format=json --fields=name,status,version,update_version,auto_update": `[{"name":"akismet","status":"active","version":"5.0","update_version":"5.3","auto_update":"off"},{"name":"hello","status":"inactive","version":"1.7.2","update_version":"","auto_update":"on"}]`,
+		prefix + "theme list --format=json --fields=name,status,version,update_version,auto_update":  `[{"name":"twentytwentyfour","status":"active","version":"1.1","update_version":"","auto_update":"off"}]`,
+	}
+	return svc, runner, prefix
+}
+
+func TestGetWordPress_ListsVersionsAndUpdates(t *testing.T) {
+	ctx := context.Background()
+	svc, _, _ := newWordPressService(t, config.Config{})
+
+	wp, err := svc.GetWordPress(ctx, 1)
+	if err != nil {
+		t.Fatalf("get wordpress: %v", err)
+	}
+	if wp.CoreVersion != "6.4.2" || wp.CoreUpdate != "6.5.3" {
+		t.Fatalf("unexpected core state: %+v", wp)
+	}
+	if len(wp.Plugins) != 2 || wp.Plugins[0].UpdateVersion != "5.3" || !wp.Plugins[1].AutoUpdate || len(wp.Themes) != 1 {
+		t.Fatalf("unexpected components: %+v %+v", wp.Plugins, wp.Themes)
+	}
+	if wp.AutoUpdates.Core != WPAutoUpdateMinor || len(wp.Hardening) != 0 {
+		t.Fatalf("expected default settings, got %+v", wp)
+	}
+
+	if err := svc.store.ExecPanel(ctx, "UPDATE sites SET root_dir = '/nonexistent' WHERE id = 1;"); err != nil {
+		t.Fatalf("update root dir: %v", err)
+	}
+	svc.sitesCache.Purge()
+	if _, err := svc.GetWordPress(ctx, 1); !errors.Is(err, ErrNotWordPress) {
+		t.Fatalf("expected ErrNotWordPress, got %v", err)
+	}
+}
+
+func TestSetWordPressHardening_AppliesAndRevertsPresets(t *testing.T) {
+	ctx := context.Background()
+	svc, runner, prefix := newWordPressService(t, config.Config{})
+	site, err := svc.GetSite(ctx, 1)
+	if err != nil {
+		t.Fatalf("get site: %v", err)
+	}
+	plugin := filepath.Join(site.RootDir, "wp-content", "mu-plugins", wpXMLRPCPlugin)
+
+	if _, err := svc.SetWordPressHardening(ctx, 1, WPHardeningRequest{Presets: []string{"bogus"}}); err == nil || !strings.Contains(err.Error(), "invalid") {
+		t.Fatalf("expected invalid preset error, got %v", err)
+	}
+	wp, err := svc.SetWordPressHardening(ctx, 1, WPHardeningRequest{Presets: []string{WPHardenDisableXMLRPC, WPHardenDisableFileEdit}})
+	if err != nil {
+		t.Fatalf("apply hardening: %v", err)
+	}
+	if strings.Join(wp.Hardening, ",") != "disable_file_edit,disable_xmlrpc" {
+		t.Fatalf("unexpected presets: %v", wp.Hardening)
+	}
+	if !containsCommand(runner.commands, prefix+"config set DISALLOW_FILE_EDIT true --raw") {
+		t.Fatalf("expected DISALLOW_FILE_EDIT set, got %v", runner.commands)
+	}
+	if body, err := os.ReadFile(plugin); err != nil || !strings.Contains(string(body), "xmlrpc_enabled") {
+		t.Fatalf("expected xml-rpc mu-plugin, got %q (%v)", body, err)
+	}
+
+	wp, err = svc.SetWordPressHardening(ctx, 1, WPHardeningRequest{})
+	if err != nil {
+		t.Fatalf("revert hardening: %v", err)
+	}
+	if len(wp.Hardening) != 0 || !containsCommand(runner.commands, prefix+"config delete DISALLOW_FILE_EDIT") {
+		t.Fatalf("expected presets reverted, got %v %v", wp.Hardening, runner.commands)
+	}
+	if _, err := os.Stat(plugin); !os.IsNotExist(err) {
+		t.Fatalf("expected mu-plugin removed, got %v", err)
+	}
+}
+
+func TestScanWordPressSites_AlertsOnlyNewVulnerabilities(t *testing.T) {
+	ctx := context.Background()
+	var requests []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token token=secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		requests = append(requests, r.URL.Path)
+		switch r.URL.Path {
+		case "/wordpresses/642":
+			_, _ = w.Write([]byte(`{"6.4.2":{"vulnerabilities":[{"title":"Core XSS","fixed_in":"6.4.3"}]}}`))
+		case "/plugins/akismet":
+			_, _ = w.Write([]byte(`{"akismet":{"vulnerabilities":[{"title":"Old bug","fixed_in":"4.0"},{"title":"Akismet CSRF","fixed_in":"5.1"}]}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer api.Close()
+
+	svc, _, _ := newWordPressService(t, config.Config{})
+	svc.wpscanAPI = api.URL
+	if n, err := svc.ScanWordPressSites(ctx); err != nil || n != 0 || len(requests) != 0 {
+		t.Fatalf("expected no scan without a token, got %d %v %v", n, err, requests)
+	}
+	if _, err := svc.ScanWordPress(ctx, 1); !errors.Is(err, ErrWPScanNotConfigured) {
+		t.Fatalf("expected ErrWPScanNotConfigured, got %v", err)
+	}
+
+	svc.cfg.WPScanAPIToken = "secret"
+	var alerts []string
+	svc.notify = func(_ context.Context, subject, body string) error {
+		alerts = append(alerts, subject+"\n"+body)
+		return nil
+	}
+	n, err := svc.ScanWordPressSites(ctx)
+	if err != nil || n != 1 {
+		t.Fatalf("scan: %d %v", n, err)
+	}
+	wp, err := svc.GetWordPress(ctx, 1)
+	if err != nil {
+		t.Fatalf("get wordpress: %v", err)
+	}
+	if len(wp.Vulnerabilities) != 2 || wp.Vulnerabilities[1].Title != "Akismet CSRF" || wp.ScannedAt.IsZero() {
+		t.Fatalf("unexpected vulnerabilities: %+v", wp.Vulnerabilities)
+	}
+	if len(alerts) != 1 || !strings.Contains(alerts[0], "Core XSS") || !strings.Contains(alerts[0], "fixed in 5.1") {
+		t.Fatalf("unexpected alerts: %v", alerts)
+	}
+
+	if _, err := svc.ScanWordPressSites(ctx); err != nil {
+		t.Fatalf("rescan: %v", err)
+	}
+	if len(alerts) != 1 {
+		t.Fatalf("expected no repeated alert, got %v", alerts)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"6.4.2", "6.4.3", -1},
+		{"6.5", "6.4.9", 1},
+		{"5.0", "5", 0},
+		{"10.1", "9.9", 1},
+	} {
+		if got := compareVersions(tc.a, tc.b); got != tc.want {
+			t.Fatalf("compareVersions(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
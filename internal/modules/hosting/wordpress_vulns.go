@@ -0,0 +1,227 @@
+package hosting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultWPScanAPI = "https://wpscan.com/api/v3"
+
+// ErrWPScanNotConfigured indicates a missing wpscan_api_token.
+var ErrWPScanNotConfigured = errors.New("wpscan api token is not configured")
+
+// WPVulnerability is a known vulnerability of the installed version of
+// WordPress core, a plugin or a theme.
+type WPVulnerability struct {
+	// Kind is "core", "plugin" or "theme".
+	Kind    string `json:"kind"`
+	Slug    string `json:"slug"`
+	Version string `json:"version"`
+	Title   string `json:"title"`
+	FixedIn string `json:"fixed_in,omitempty"`
+}
+
+type wpscanVuln struct {
+	Title   string `json:"title"`
+	FixedIn string `json:"fixed_in"`
+}
+
+// wpscanCache holds API answers for one scan run, so a plugin installed on
+// many sites costs one request of the daily API quota.
+type wpscanCache map[string][]wpscanVuln
+
+// ScanWordPress checks one WordPress site against the WPScan database now.
+func (s *Service) ScanWordPress(ctx context.Context, siteID int64) (WordPressSite, error) {
+	if s.cfg.WPScanAPIToken == "" {
+		return WordPressSite{}, ErrWPScanNotConfigured
+	}
+	site, err := s.wordPressSite(ctx, siteID)
+	if err != nil {
+		return WordPressSite{}, err
+	}
+	if err := s.scanWordPress(ctx, site, wpscanCache{}); err != nil {
+		return WordPressSite{}, err
+	}
+	return s.GetWordPress(ctx, siteID)
+}
+
+// ScanWordPressSites checks every WordPress site and alerts about newly
+// found vulnerabilities. It returns how many sites were scanned; without
+// an API token it does nothing.
+func (s *Service) ScanWordPressSites(ctx context.Context) (int, error) {
+	if s.cfg.WPScanAPIToken == "" {
+		return 0, nil
+	}
+	sites, err := s.ListSites(ctx)
+	if err != nil {
+		return 0, err
+	}
+	cache := wpscanCache{}
+	scanned := 0
+	for _, site := range sites {
+		if !isWordPress(site) {
+			continue
+		}
+		if err := s.scanWordPress(ctx, site, cache); err != nil {
+			s.log.Warn("wordpress vulnerability scan", "domain", site.Domain, "error", err.Error())
+			continue
+		}
+		scanned++
+	}
+	return scanned, nil
+}
+
+func (s *Service) scanWordPress(ctx context.Context, site Site, cache wpscanCache) error {
+	wp, err := s.inspectWordPress(ctx, site)
+	if err != nil {
+		return err
+	}
+	found := []WPVulnerability{}
+	// The core endpoint only lists vulnerabilities of the given release.
+	coreVulns, err := s.wpscanLookup(ctx, cache, "wordpresses", strings.ReplaceAll(wp.CoreVersion, ".", ""), wp.CoreVersion)
+	if err != nil {
+		return err
+	}
+	for _, v := range coreVulns {
+		found = append(found, WPVulnerability{Kind: "core", Slug: "wordpress", Version: wp.CoreVersion, Title: v.Title, FixedIn: v.FixedIn})
+	}
+	for _, group := range []struct {
+		kind, endpoint string
+		items          []WPComponent
+	}{
+		{"plugin", "plugins", wp.Plugins},
+		{"theme", "themes", wp.Themes},
+	} {
+		for _, item := range group.items {
+			vulns, err := s.wpscanLookup(ctx, cache, group.endpoint, item.Name, item.Name)
+			if err != nil {
+				return err
+			}
+			for _, v := range vulns {
+				if v.FixedIn != "" && compareVersions(item.Version, v.FixedIn) >= 0 {
+					continue
+				}
+				found = append(found, WPVulnerability{Kind: group.kind, Slug: item.Name, Version: item.Version, Title: v.Title, FixedIn: v.FixedIn})
+			}
+		}
+	}
+
+	previous, err := s.wordPressSettings(ctx, site.ID)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(found)
+	if err != nil {
+		return fmt.Errorf("encode vulnerabilities: %w", err)
+	}
+	now := time.Now().Unix()
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO site_wordpress(site_id, vulnerabilities, scanned_at, updated_at) VALUES(%d,'%s',%d,%d)
+ON CONFLICT(site_id) DO UPDATE SET vulnerabilities=excluded.vulnerabilities, scanned_at=excluded.scanned_at;`,
+		site.ID, sqlEscape(string(raw)), now, now)); err != nil {
+		return fmt.Errorf("save vulnerabilities: %w", err)
+	}
+	s.alertNewVulnerabilities(ctx, site, previous.vulnerabilities, found)
+	return nil
+}
+
+// alertNewVulnerabilities mails the vulnerabilities not reported by the
+// previous scan, so a site is not re-alerted daily for the same finding.
+func (s *Service) alertNewVulnerabilities(ctx context.Context, site Site, previous, found []WPVulnerability) {
+	seen := map[string]bool{}
+	for _, v := range previous {
+		seen[v.Kind+"/"+v.Slug+"/"+v.Title] = true
+	}
+	var lines []string
+	for _, v := range found {
+		if seen[v.Kind+"/"+v.Slug+"/"+v.Title] {
+			continue
+		}
+		fixed := "no fix released"
+		if v.FixedIn != "" {
+			fixed = "fixed in " + v.FixedIn
+		}
+		lines = append(lines, fmt.Sprintf("- %s %s %s: %s (%s)", v.Kind, v.Slug, v.Version, v.Title, fixed))
+	}
+	if len(lines) == 0 {
+		return
+	}
+	s.log.Warn("wordpress vulnerabilities found", "domain", site.Domain, "new", len(lines))
+	if s.notify == nil {
+		return
+	}
+	subject := fmt.Sprintf("WordPress vulnerabilities on %s", site.Domain)
+	body := fmt.Sprintf("The daily scan found %d new vulnerabilities on %s:\n\n%s\n", len(lines), site.Domain, strings.Join(lines, "\n"))
+	if err := s.notify(ctx, subject, body); err != nil {
+		s.log.Warn("send wordpress vulnerability alert", "domain", site.Domain, "error", err.Error())
+	}
+}
+
+// wpscanLookup returns the vulnerabilities WPScan lists under
+// endpoint/slug, keyed in the answer by key. Unknown slugs (custom
+// plugins) answer 404 and have none.
+func (s *Service) wpscanLookup(ctx context.Context, cache wpscanCache, endpoint, slug, key string) ([]wpscanVuln, error) {
+	cacheKey := endpoint + "/" + slug
+	if vulns, ok := cache[cacheKey]; ok {
+		return vulns, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.wpscanAPI+"/"+endpoint+"/"+url.PathEscape(slug), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Token token="+s.cfg.WPScanAPIToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("wpscan %s: %w", cacheKey, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode == http.StatusNotFound {
+		cache[cacheKey] = nil
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("wpscan %s: unexpected status %d", cacheKey, resp.StatusCode)
+	}
+	var doc map[string]struct {
+		Vulnerabilities []wpscanVuln `json:"vulnerabilities"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decode wpscan %s: %w", cacheKey, err)
+	}
+	vulns := doc[key].Vulnerabilities
+	cache[cacheKey] = vulns
+	return vulns, nil
+}
+
+// compareVersions compares dotted numeric versions such as "6.4.2" and
+// "6.5"; missing components count as zero.
+func compareVersions(a, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x > y {
+				return 1
+			}
+			return -1
+		}
+	}
+	return 0
+}
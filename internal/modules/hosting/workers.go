@@ -0,0 +1,658 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/systemd"
+)
+
+const (
+	defaultWorkerEnvDir = "/etc/aipanel/workers"
+	maxWorkerProcesses  = 32
+	maxWorkerCommand    = 4096
+	defaultWorkerDelay  = 5
+	maxWorkerDelay      = 3600
+	// defaultWorkerStopTimeout gives queue consumers time to finish the
+	// job in hand after SIGTERM, as Horizon and queue:work do.
+	defaultWorkerStopTimeout = 30
+	maxWorkerStopTimeout     = 3600
+	defaultWorkerLogLines    = 100
+	maxWorkerLogLines        = 1000
+)
+
+// Worker restart policies, as systemd Restart= values.
+const (
+	WorkerRestartAlways    = "always"
+	WorkerRestartOnFailure = "on-failure"
+	WorkerRestartNever     = "no"
+)
+
+var (
+	// ErrWorkerNotFound indicates a missing site worker.
+	ErrWorkerNotFound = errors.New("worker not found")
+	// ErrWorkerExists indicates a worker name already used on the site.
+	ErrWorkerExists = errors.New("worker already exists")
+	// ErrWorkerStopped indicates a restart of a stopped worker.
+	ErrWorkerStopped = errors.New("worker is stopped")
+)
+
+var workerNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// Worker is a long-running process of a site, such as a queue consumer or
+// a websocket server. Each of its Processes is an instance of the
+// systemd template Unit, running as the site user in the site slice;
+// output goes to the journal.
+type Worker struct {
+	ID             int64     `json:"id"`
+	SiteID         int64     `json:"site_id"`
+	Name           string    `json:"name"`
+	Command        string    `json:"command"`
+	Processes      int       `json:"processes"`
+	Restart        string    `json:"restart"`
+	RestartSec     int       `json:"restart_sec"`
+	StopTimeoutSec int       `json:"stop_timeout_sec"`
+	Running        bool      `json:"running"`
+	Active         int       `json:"active"`
+	Unit           string    `json:"unit"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// WorkerRequest creates or updates a worker. Zero fields use the
+// defaults: one process, restart always after 5 seconds, 30 seconds to
+// stop. A worker cannot be renamed.
+type WorkerRequest struct {
+	Name           string `json:"name"`
+	Command        string `json:"command"`
+	Processes      int    `json:"processes"`
+	Restart        string `json:"restart"`
+	RestartSec     int    `json:"restart_sec"`
+	StopTimeoutSec int    `json:"stop_timeout_sec"`
+	Actor          string `json:"-"`
+}
+
+// ListWorkers returns the workers of a site.
+func (s *Service) ListWorkers(ctx context.Context, siteID int64) ([]Worker, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT id, site_id, name, command, processes, restart, restart_sec, stop_timeout_sec, running, created_at, updated_at
+FROM site_workers
+WHERE site_id = %d
+ORDER BY id;`, siteID))
+	if err != nil {
+		return nil, fmt.Errorf("list workers: %w", err)
+	}
+	workers := make([]Worker, 0, len(rows))
+	for _, row := range rows {
+		worker, err := mapRowToWorker(row, site)
+		if err != nil {
+			return nil, err
+		}
+		worker.Active = s.activeWorkerProcesses(ctx, worker)
+		workers = append(workers, worker)
+	}
+	return workers, nil
+}
+
+// GetWorker returns one worker of a site.
+func (s *Service) GetWorker(ctx context.Context, siteID, workerID int64) (Worker, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return Worker{}, err
+	}
+	worker, err := s.worker(ctx, site, workerID)
+	if err != nil {
+		return Worker{}, err
+	}
+	worker.Active = s.activeWorkerProcesses(ctx, worker)
+	return worker, nil
+}
+
+// CreateWorker adds a worker to a site and starts it.
+func (s *Service) CreateWorker(ctx context.Context, siteID int64, req WorkerRequest) (Worker, error) {
+	req, err := normalizeWorkerRequest(req)
+	if err != nil {
+		return Worker{}, err
+	}
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return Worker{}, err
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT id FROM site_workers WHERE site_id = %d AND name = '%s';", siteID, sqlEscape(req.Name)))
+	if err != nil {
+		return Worker{}, fmt.Errorf("check worker name: %w", err)
+	}
+	if len(rows) > 0 {
+		return Worker{}, fmt.Errorf("%w: %s", ErrWorkerExists, req.Name)
+	}
+	now := time.Now().Unix()
+	rows, err = s.store.QueryPanelWriteJSON(ctx, fmt.Sprintf(`
+INSERT INTO site_workers(site_id, name, command, processes, restart, restart_sec, stop_timeout_sec, running, created_at, updated_at)
+VALUES(%d,'%s','%s',%d,'%s',%d,%d,1,%d,%d)
+RETURNING id;`,
+		siteID, sqlEscape(req.Name), sqlEscape(req.Command), req.Processes, req.Restart, req.RestartSec, req.StopTimeoutSec, now, now))
+	if err == nil && len(rows) == 0 {
+		err = fmt.Errorf("no id returned")
+	}
+	if err != nil {
+		return Worker{}, fmt.Errorf("insert worker: %w", err)
+	}
+	id, err := toInt64(rows[0]["id"])
+	if err != nil {
+		return Worker{}, fmt.Errorf("parse worker id: %w", err)
+	}
+	worker, err := s.worker(ctx, site, id)
+	if err == nil {
+		err = s.writeWorkerUnit(ctx, site, worker)
+	}
+	if err == nil {
+		err = s.scaleWorkerUnits(ctx, worker, 0, worker.Processes)
+	}
+	if err != nil {
+		_ = s.removeWorkerUnit(ctx, worker, worker.Processes)
+		_ = s.store.ExecPanel(ctx, fmt.Sprintf("DELETE FROM site_workers WHERE id = %d;", id))
+		return Worker{}, err
+	}
+	_ = s.writeAudit(ctx, req.Actor, "hosting.worker.create", fmt.Sprintf("domain=%s worker=%s processes=%d", site.Domain, req.Name, req.Processes))
+	return s.GetWorker(ctx, siteID, id)
+}
+
+// UpdateWorker replaces the settings of a worker. A running worker is
+// restarted with them, and scaled when Processes changed.
+func (s *Service) UpdateWorker(ctx context.Context, siteID, workerID int64, req WorkerRequest) (Worker, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return Worker{}, err
+	}
+	existing, err := s.worker(ctx, site, workerID)
+	if err != nil {
+		return Worker{}, err
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		req.Name = existing.Name
+	}
+	req, err = normalizeWorkerRequest(req)
+	if err != nil {
+		return Worker{}, err
+	}
+	if req.Name != existing.Name {
+		return Worker{}, fmt.Errorf("invalid name: a worker cannot be renamed")
+	}
+	if err := s.updateWorkerRow(ctx, workerID, req); err != nil {
+		return Worker{}, err
+	}
+	worker, err := s.worker(ctx, site, workerID)
+	if err != nil {
+		return Worker{}, err
+	}
+	if err := s.applyWorker(ctx, site, existing, worker); err != nil {
+		_ = s.updateWorkerRow(ctx, workerID, workerRequestOf(existing))
+		if restoreErr := s.applyWorker(ctx, site, worker, existing); restoreErr != nil {
+			s.log.Warn("restore worker", "domain", site.Domain, "worker", existing.Name, "error", restoreErr.Error())
+		}
+		return Worker{}, err
+	}
+	_ = s.writeAudit(ctx, req.Actor, "hosting.worker.update", fmt.Sprintf("domain=%s worker=%s processes=%d", site.Domain, worker.Name, worker.Processes))
+	return s.GetWorker(ctx, siteID, workerID)
+}
+
+// ScaleWorker changes the number of processes of a worker; a running
+// worker starts or stops the difference and keeps the others running.
+func (s *Service) ScaleWorker(ctx context.Context, siteID, workerID int64, processes int, actor string) (Worker, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return Worker{}, err
+	}
+	existing, err := s.worker(ctx, site, workerID)
+	if err != nil {
+		return Worker{}, err
+	}
+	if processes < 1 || processes > maxWorkerProcesses {
+		return Worker{}, fmt.Errorf("invalid processes: must be between 1 and %d", maxWorkerProcesses)
+	}
+	if existing.Running {
+		if err := s.scaleWorkerUnits(ctx, existing, existing.Processes, processes); err != nil {
+			return Worker{}, err
+		}
+	}
+	req := workerRequestOf(existing)
+	req.Processes = processes
+	if err := s.updateWorkerRow(ctx, workerID, req); err != nil {
+		return Worker{}, err
+	}
+	_ = s.writeAudit(ctx, actor, "hosting.worker.scale", fmt.Sprintf("domain=%s worker=%s processes=%d->%d", site.Domain, existing.Name, existing.Processes, processes))
+	return s.GetWorker(ctx, siteID, workerID)
+}
+
+// StartWorker starts every process of a worker and enables them at boot.
+// The site environment is re-read, so a started worker sees variables
+// added since it was created.
+func (s *Service) StartWorker(ctx context.Context, siteID, workerID int64, actor string) (Worker, error) {
+	return s.setWorkerRunning(ctx, siteID, workerID, true, actor)
+}
+
+// StopWorker stops every process of a worker and keeps it stopped across
+// reboots.
+func (s *Service) StopWorker(ctx context.Context, siteID, workerID int64, actor string) (Worker, error) {
+	return s.setWorkerRunning(ctx, siteID, workerID, false, actor)
+}
+
+// RestartWorker restarts the processes of a running worker, e.g. after a
+// deploy, with the current site environment.
+func (s *Service) RestartWorker(ctx context.Context, siteID, workerID int64, actor string) (Worker, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return Worker{}, err
+	}
+	worker, err := s.worker(ctx, site, workerID)
+	if err != nil {
+		return Worker{}, err
+	}
+	if !worker.Running {
+		return Worker{}, ErrWorkerStopped
+	}
+	if err := s.writeWorkerUnit(ctx, site, worker); err != nil {
+		return Worker{}, err
+	}
+	if _, err := s.runner.Run(ctx, "systemctl", append([]string{"restart"}, workerInstances(worker, 0, worker.Processes)...)...); err != nil {
+		return Worker{}, fmt.Errorf("restart worker %s: %w", worker.Name, err)
+	}
+	_ = s.writeAudit(ctx, actor, "hosting.worker.restart", fmt.Sprintf("domain=%s worker=%s", site.Domain, worker.Name))
+	return s.GetWorker(ctx, siteID, workerID)
+}
+
+// DeleteWorker stops a worker and removes its unit.
+func (s *Service) DeleteWorker(ctx context.Context, siteID, workerID int64, actor string) error {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return err
+	}
+	worker, err := s.worker(ctx, site, workerID)
+	if err != nil {
+		return err
+	}
+	if err := s.removeWorkerUnit(ctx, worker, worker.Processes); err != nil {
+		return err
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf("DELETE FROM site_workers WHERE id = %d;", workerID)); err != nil {
+		return fmt.Errorf("delete worker: %w", err)
+	}
+	_ = s.writeAudit(ctx, actor, "hosting.worker.delete", fmt.Sprintf("domain=%s worker=%s", site.Domain, worker.Name))
+	return nil
+}
+
+// WorkerLogs returns the last lines the processes of a worker wrote to
+// the journal, oldest first.
+func (s *Service) WorkerLogs(ctx context.Context, siteID, workerID int64, lines int) ([]string, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return nil, err
+	}
+	worker, err := s.worker(ctx, site, workerID)
+	if err != nil {
+		return nil, err
+	}
+	if lines <= 0 {
+		lines = defaultWorkerLogLines
+	}
+	lines = min(lines, maxWorkerLogLines)
+	out, err := s.runner.Run(ctx, "journalctl", "--unit="+workerInstance(worker.Unit, "*"), "--lines="+strconv.Itoa(lines),
+		"--no-pager", "--output=short-iso")
+	if err != nil {
+		return nil, fmt.Errorf("read worker logs: %w", err)
+	}
+	out = strings.TrimRight(out, "\n")
+	if out == "" || strings.HasPrefix(out, "-- No entries --") {
+		return []string{}, nil
+	}
+	return strings.Split(out, "\n"), nil
+}
+
+func (s *Service) setWorkerRunning(ctx context.Context, siteID, workerID int64, running bool, actor string) (Worker, error) {
+	site, err := s.GetSite(ctx, siteID)
+	if err != nil {
+		return Worker{}, err
+	}
+	worker, err := s.worker(ctx, site, workerID)
+	if err != nil {
+		return Worker{}, err
+	}
+	action := "hosting.worker.stop"
+	if running {
+		action = "hosting.worker.start"
+		if err := s.writeWorkerUnit(ctx, site, worker); err != nil {
+			return Worker{}, err
+		}
+		err = s.scaleWorkerUnits(ctx, worker, 0, worker.Processes)
+	} else {
+		err = s.scaleWorkerUnits(ctx, worker, worker.Processes, 0)
+	}
+	if err != nil {
+		return Worker{}, err
+	}
+	flag := 0
+	if running {
+		flag = 1
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(
+		"UPDATE site_workers SET running = %d, updated_at = MAX(%d, updated_at + 1) WHERE id = %d;", flag, time.Now().Unix(), workerID)); err != nil {
+		return Worker{}, fmt.Errorf("update worker: %w", err)
+	}
+	_ = s.writeAudit(ctx, actor, action, fmt.Sprintf("domain=%s worker=%s", site.Domain, worker.Name))
+	return s.GetWorker(ctx, siteID, workerID)
+}
+
+// applyWorker moves a worker from the settings of previous to those of
+// next: the unit is rewritten and, while running, the processes are
+// restarted and scaled.
+func (s *Service) applyWorker(ctx context.Context, site Site, previous, next Worker) error {
+	if err := s.writeWorkerUnit(ctx, site, next); err != nil {
+		return err
+	}
+	if !next.Running {
+		return nil
+	}
+	if err := s.scaleWorkerUnits(ctx, next, previous.Processes, next.Processes); err != nil {
+		return err
+	}
+	kept := min(previous.Processes, next.Processes)
+	if kept == 0 {
+		return nil
+	}
+	if _, err := s.runner.Run(ctx, "systemctl", append([]string{"restart"}, workerInstances(next, 0, kept)...)...); err != nil {
+		return fmt.Errorf("restart worker %s: %w", next.Name, err)
+	}
+	return nil
+}
+
+// scaleWorkerUnits enables and starts instances from+1..to, or disables
+// and stops to+1..from when scaling down.
+func (s *Service) scaleWorkerUnits(ctx context.Context, worker Worker, from, to int) error {
+	switch {
+	case to > from:
+		if _, err := s.runner.Run(ctx, "systemctl", append([]string{"enable", "--now"}, workerInstances(worker, from, to)...)...); err != nil {
+			return fmt.Errorf("start worker %s: %w", worker.Name, err)
+		}
+	case to < from:
+		if _, err := s.runner.Run(ctx, "systemctl", append([]string{"disable", "--now"}, workerInstances(worker, to, from)...)...); err != nil {
+			return fmt.Errorf("stop worker %s: %w", worker.Name, err)
+		}
+	}
+	return nil
+}
+
+// writeWorkerUnit writes the template unit of a worker and the site
+// environment it reads. The environment file is root-only: it can hold
+// secrets such as the bucket key.
+func (s *Service) writeWorkerUnit(ctx context.Context, site Site, worker Worker) error {
+	cfg, err := s.siteConfig(ctx, site)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.workerEnvDir, 0o700); err != nil {
+		return fmt.Errorf("create worker env dir: %w", err)
+	}
+	if err := os.WriteFile(s.workerEnvFile(worker), []byte(renderWorkerEnv(cfg.Env)), 0o600); err != nil {
+		return fmt.Errorf("write worker env: %w", err)
+	}
+	if err := os.MkdirAll(s.systemdUnitDir, 0o755); err != nil {
+		return fmt.Errorf("create systemd unit dir: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(s.systemdUnitDir, worker.Unit), []byte(s.renderWorkerUnit(site, worker)), 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", worker.Unit, err)
+	}
+	if err := systemd.DaemonReload(ctx, s.runner); err != nil {
+		return fmt.Errorf("systemd daemon-reload: %w", err)
+	}
+	return nil
+}
+
+// removeWorkerUnit stops the processes of a worker and removes its unit
+// and environment file.
+func (s *Service) removeWorkerUnit(ctx context.Context, worker Worker, processes int) error {
+	unitPath := filepath.Join(s.systemdUnitDir, worker.Unit)
+	if _, err := os.Stat(unitPath); os.IsNotExist(err) {
+		return nil
+	}
+	if processes > 0 {
+		_, _ = s.runner.Run(ctx, "systemctl", append([]string{"disable", "--now"}, workerInstances(worker, 0, processes)...)...)
+	}
+	if err := os.Remove(unitPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove %s: %w", worker.Unit, err)
+	}
+	_ = os.Remove(s.workerEnvFile(worker))
+	if err := systemd.DaemonReload(ctx, s.runner); err != nil {
+		return fmt.Errorf("systemd daemon-reload: %w", err)
+	}
+	return nil
+}
+
+// removeSiteWorkers stops and removes every worker of a site being
+// deleted.
+func (s *Service) removeSiteWorkers(ctx context.Context, site Site) error {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT id, site_id, name, command, processes, restart, restart_sec, stop_timeout_sec, running, created_at, updated_at
+FROM site_workers
+WHERE site_id = %d;`, site.ID))
+	if err != nil {
+		return fmt.Errorf("list workers: %w", err)
+	}
+	for _, row := range rows {
+		worker, err := mapRowToWorker(row, site)
+		if err != nil {
+			return err
+		}
+		if err := s.removeWorkerUnit(ctx, worker, worker.Processes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// activeWorkerProcesses counts the running instances of a worker; any
+// error counts as none running.
+func (s *Service) activeWorkerProcesses(ctx context.Context, worker Worker) int {
+	out, _ := s.runner.Run(ctx, "systemctl", append([]string{"is-active"}, workerInstances(worker, 0, worker.Processes)...)...)
+	active := 0
+	for line := range strings.Lines(out) {
+		if strings.TrimSpace(line) == "active" {
+			active++
+		}
+	}
+	return active
+}
+
+// renderWorkerUnit renders the template unit; %i is the process number,
+// also passed to the command as WORKER_PROCESS. The command runs through
+// /bin/sh from the docroot, so it may use shell syntax. Start rate
+// limiting is off: a crashing worker keeps retrying every RestartSec
+// instead of giving up for good.
+func (s *Service) renderWorkerUnit(site Site, worker Worker) string {
+	return strings.Join([]string{
+		"[Unit]",
+		fmt.Sprintf("Description=aiPanel worker %s for %s (process %%i)", worker.Name, site.Domain),
+		"After=network.target",
+		"StartLimitIntervalSec=0",
+		"",
+		"[Service]",
+		"Type=simple",
+		"User=" + site.SystemUser,
+		"Group=" + site.SystemUser,
+		"Slice=" + SiteSlice(site.Domain),
+		"WorkingDirectory=" + site.RootDir,
+		"EnvironmentFile=" + s.workerEnvFile(worker),
+		"Environment=WORKER_PROCESS=%i",
+		"ExecStart=/bin/sh -c " + systemdQuote(worker.Command),
+		"Restart=" + worker.Restart,
+		fmt.Sprintf("RestartSec=%d", worker.RestartSec),
+		fmt.Sprintf("TimeoutStopSec=%d", worker.StopTimeoutSec),
+		"SyslogIdentifier=" + strings.TrimSuffix(worker.Unit, "@.service"),
+		"PrivateTmp=yes",
+		"NoNewPrivileges=yes",
+		"",
+		"[Install]",
+		"WantedBy=multi-user.target",
+		"",
+	}, "\n")
+}
+
+func (s *Service) workerEnvFile(worker Worker) string {
+	return filepath.Join(s.workerEnvDir, strings.TrimSuffix(worker.Unit, "@.service")+".env")
+}
+
+func (s *Service) worker(ctx context.Context, site Site, workerID int64) (Worker, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT id, site_id, name, command, processes, restart, restart_sec, stop_timeout_sec, running, created_at, updated_at
+FROM site_workers
+WHERE id = %d AND site_id = %d;`, workerID, site.ID))
+	if err != nil {
+		return Worker{}, fmt.Errorf("get worker: %w", err)
+	}
+	if len(rows) == 0 {
+		return Worker{}, ErrWorkerNotFound
+	}
+	return mapRowToWorker(rows[0], site)
+}
+
+func (s *Service) updateWorkerRow(ctx context.Context, workerID int64, req WorkerRequest) error {
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+UPDATE site_workers
+SET command='%s', processes=%d, restart='%s', restart_sec=%d, stop_timeout_sec=%d, updated_at=MAX(%d, updated_at + 1)
+WHERE id = %d;`,
+		sqlEscape(req.Command), req.Processes, req.Restart, req.RestartSec, req.StopTimeoutSec, time.Now().Unix(), workerID)); err != nil {
+		return fmt.Errorf("update worker: %w", err)
+	}
+	return nil
+}
+
+func workerRequestOf(worker Worker) WorkerRequest {
+	return WorkerRequest{
+		Name:           worker.Name,
+		Command:        worker.Command,
+		Processes:      worker.Processes,
+		Restart:        worker.Restart,
+		RestartSec:     worker.RestartSec,
+		StopTimeoutSec: worker.StopTimeoutSec,
+	}
+}
+
+func normalizeWorkerRequest(req WorkerRequest) (WorkerRequest, error) {
+	req.Name = strings.ToLower(strings.TrimSpace(req.Name))
+	if req.Name == "" {
+		return req, fmt.Errorf("name is required")
+	}
+	if !workerNamePattern.MatchString(req.Name) {
+		return req, fmt.Errorf("invalid name: use up to 32 lowercase letters, digits and dashes")
+	}
+	req.Command = strings.TrimSpace(req.Command)
+	if req.Command == "" {
+		return req, fmt.Errorf("command is required")
+	}
+	if len(req.Command) > maxWorkerCommand || strings.ContainsAny(req.Command, "\x00\r\n") {
+		return req, fmt.Errorf("invalid command: must be a single line of at most %d bytes", maxWorkerCommand)
+	}
+	if req.Processes == 0 {
+		req.Processes = 1
+	}
+	if req.Processes < 1 || req.Processes > maxWorkerProcesses {
+		return req, fmt.Errorf("invalid processes: must be between 1 and %d", maxWorkerProcesses)
+	}
+	req.Restart = strings.ToLower(strings.TrimSpace(req.Restart))
+	switch req.Restart {
+	case "":
+		req.Restart = WorkerRestartAlways
+	case WorkerRestartAlways, WorkerRestartOnFailure, WorkerRestartNever:
+	default:
+		return req, fmt.Errorf("invalid restart: expected always, on-failure or no")
+	}
+	if req.RestartSec == 0 {
+		req.RestartSec = defaultWorkerDelay
+	}
+	if req.RestartSec < 1 || req.RestartSec > maxWorkerDelay {
+		return req, fmt.Errorf("invalid restart_sec: must be between 1 and %d", maxWorkerDelay)
+	}
+	if req.StopTimeoutSec == 0 {
+		req.StopTimeoutSec = defaultWorkerStopTimeout
+	}
+	if req.StopTimeoutSec < 1 || req.StopTimeoutSec > maxWorkerStopTimeout {
+		return req, fmt.Errorf("invalid stop_timeout_sec: must be between 1 and %d", maxWorkerStopTimeout)
+	}
+	return req, nil
+}
+
+func mapRowToWorker(row map[string]any, site Site) (Worker, error) {
+	fields := map[string]int64{}
+	for _, key := range []string{"id", "site_id", "processes", "restart_sec", "stop_timeout_sec", "running", "created_at", "updated_at"} {
+		v, err := toInt64(row[key])
+		if err != nil {
+			return Worker{}, fmt.Errorf("parse worker %s: %w", key, err)
+		}
+		fields[key] = v
+	}
+	name, _ := row["name"].(string)
+	command, _ := row["command"].(string)
+	restart, _ := row["restart"].(string)
+	return Worker{
+		ID:             fields["id"],
+		SiteID:         fields["site_id"],
+		Name:           name,
+		Command:        command,
+		Processes:      int(fields["processes"]),
+		Restart:        restart,
+		RestartSec:     int(fields["restart_sec"]),
+		StopTimeoutSec: int(fields["stop_timeout_sec"]),
+		Running:        fields["running"] == 1,
+		Unit:           workerUnit(site.Domain, name),
+		CreatedAt:      time.Unix(fields["created_at"], 0).UTC(),
+		UpdatedAt:      time.Unix(fields["updated_at"], 0).UTC(),
+	}, nil
+}
+
+// renderWorkerEnv renders an EnvironmentFile, sorted for stable output.
+func renderWorkerEnv(env map[string]string) string {
+	var b strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(env)) {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(env[name])
+		fmt.Fprintf(&b, "%s=\"%s\"\n", name, value)
+	}
+	return b.String()
+}
+
+// systemdQuote quotes s as one ExecStart argument, escaping specifiers
+// and variables so the shell sees the command as written.
+func systemdQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$").Replace(s) + `"`
+}
+
+// workerUnit names the template unit of a worker; instances are
+// <unit>@1.service and up.
+func workerUnit(domain, name string) string {
+	return "aipanel-worker-" + sanitizeToken(domain) + "-" + name + "@.service"
+}
+
+func workerInstance(unit, instance string) string {
+	return strings.TrimSuffix(unit, "@.service") + "@" + instance + ".service"
+}
+
+// workerInstances lists the instance units from+1..to.
+func workerInstances(worker Worker, from, to int) []string {
+	units := make([]string, 0, max(to-from, 0))
+	for n := from + 1; n <= to; n++ {
+		units = append(units, workerInstance(worker.Unit, strconv.Itoa(n)))
+	}
+	return units
+}
@@ -0,0 +1,127 @@
+package hosting
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newWorkerService(t *testing.T) (*Service, *fakeRunner, Site) {
+	t.Helper()
+	runner := newSiteUserRunner()
+	svc := newTestService(t, withRunner(runner))
+	svc.systemdUnitDir = t.TempDir()
+	svc.workerEnvDir = t.TempDir()
+	return svc, runner, createTestSite(t, svc)
+}
+
+func TestService_SiteWorkers(t *testing.T) {
+	ctx := context.Background()
+	svc, runner, site := newWorkerService(t)
+
+	if _, err := svc.CreateWorker(ctx, site.ID, WorkerRequest{Name: "Horizon!", Command: "php artisan horizon"}); err == nil || !strings.Contains(err.Error(), "invalid name") {
+		t.Fatalf("expected invalid name, got %v", err)
+	}
+	if _, err := svc.CreateWorker(ctx, site.ID, WorkerRequest{Name: "horizon", Command: "php artisan horizon", Restart: "sometimes"}); err == nil || !strings.Contains(err.Error(), "invalid restart") {
+		t.Fatalf("expected invalid restart, got %v", err)
+	}
+
+	runner.commands = nil
+	worker, err := svc.CreateWorker(ctx, site.ID, WorkerRequest{Name: "horizon", Command: `php artisan horizon --env="$APP_ENV" 100%`, Processes: 2, Actor: "admin@example.com"})
+	if err != nil {
+		t.Fatalf("create worker: %v", err)
+	}
+	if worker.Unit != "aipanel-worker-test-example-com-horizon@.service" || worker.Restart != WorkerRestartAlways || !worker.Running {
+		t.Fatalf("unexpected worker: %+v", worker)
+	}
+	unit, err := os.ReadFile(filepath.Join(svc.systemdUnitDir, worker.Unit)) //nolint:gosec // test reads file generated in temp dir.
+	if err != nil {
+		t.Fatalf("read unit: %v", err)
+	}
+	for _, want := range []string{
+		"User=" + site.SystemUser,
+		"Slice=aipanel-site-test-example-com.slice",
+		"WorkingDirectory=" + site.RootDir,
+		"Environment=WORKER_PROCESS=%i",
+		`ExecStart=/bin/sh -c "php artisan horizon --env=\"$$APP_ENV\" 100%%"`,
+		"Restart=always",
+		"TimeoutStopSec=30",
+	} {
+		if !strings.Contains(string(unit), want) {
+			t.Fatalf("expected %q in unit, got:\n%s", want, unit)
+		}
+	}
+	joined := strings.Join(runner.commands, "\n")
+	if !strings.Contains(joined, "systemctl enable --now aipanel-worker-test-example-com-horizon@1.service aipanel-worker-test-example-com-horizon@2.service") {
+		t.Fatalf("expected both processes started, got:\n%s", joined)
+	}
+	if _, err := svc.CreateWorker(ctx, site.ID, WorkerRequest{Name: "horizon", Command: "true"}); !errors.Is(err, ErrWorkerExists) {
+		t.Fatalf("expected ErrWorkerExists, got %v", err)
+	}
+
+	runner.commands = nil
+	if _, err := svc.ScaleWorker(ctx, site.ID, worker.ID, 4, "admin@example.com"); err != nil {
+		t.Fatalf("scale up: %v", err)
+	}
+	if _, err := svc.ScaleWorker(ctx, site.ID, worker.ID, 1, "admin@example.com"); err != nil {
+		t.Fatalf("scale down: %v", err)
+	}
+	joined = strings.Join(runner.commands, "\n")
+	for _, want := range []string{
+		"systemctl enable --now aipanel-worker-test-example-com-horizon@3.service aipanel-worker-test-example-com-horizon@4.service",
+		"systemctl disable --now aipanel-worker-test-example-com-horizon@2.service aipanel-worker-test-example-com-horizon@3.service aipanel-worker-test-example-com-horizon@4.service",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Fatalf("expected %q, got:\n%s", want, joined)
+		}
+	}
+
+	stopped, err := svc.StopWorker(ctx, site.ID, worker.ID, "admin@example.com")
+	if err != nil || stopped.Running {
+		t.Fatalf("expected stopped worker, got %+v (%v)", stopped, err)
+	}
+	if _, err := svc.RestartWorker(ctx, site.ID, worker.ID, "admin@example.com"); !errors.Is(err, ErrWorkerStopped) {
+		t.Fatalf("expected ErrWorkerStopped, got %v", err)
+	}
+	if _, err := svc.UpdateWorker(ctx, site.ID, worker.ID, WorkerRequest{Name: "queue", Command: "true"}); err == nil || !strings.Contains(err.Error(), "cannot be renamed") {
+		t.Fatalf("expected rename rejected, got %v", err)
+	}
+	updated, err := svc.UpdateWorker(ctx, site.ID, worker.ID, WorkerRequest{Command: "php artisan queue:work", Restart: "on-failure", StopTimeoutSec: 120})
+	if err != nil || updated.Command != "php artisan queue:work" || updated.Processes != 1 || updated.Running {
+		t.Fatalf("unexpected updated worker: %+v (%v)", updated, err)
+	}
+
+	if err := svc.DeleteWorker(ctx, site.ID, worker.ID, "admin@example.com"); err != nil {
+		t.Fatalf("delete worker: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(svc.systemdUnitDir, worker.Unit)); !os.IsNotExist(err) {
+		t.Fatalf("expected unit removed, got %v", err)
+	}
+	if _, err := svc.GetWorker(ctx, site.ID, worker.ID); !errors.Is(err, ErrWorkerNotFound) {
+		t.Fatalf("expected ErrWorkerNotFound, got %v", err)
+	}
+}
+
+func TestService_WorkerEnvAndLogs(t *testing.T) {
+	ctx := context.Background()
+	svc, runner, site := newWorkerService(t)
+
+	worker, err := svc.CreateWorker(ctx, site.ID, WorkerRequest{Name: "ws", Command: "node server.js"})
+	if err != nil {
+		t.Fatalf("create worker: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(svc.workerEnvDir, "aipanel-worker-test-example-com-ws.env"))
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected root-only env file, got %v (%v)", info, err)
+	}
+
+	cmd := "journalctl --unit=aipanel-worker-test-example-com-ws@*.service --lines=100 --no-pager --output=short-iso"
+	runner.outputs = map[string]string{cmd: "2026-10-16T10:00:00+0000 host ws[1]: listening\n"}
+	lines, err := svc.WorkerLogs(ctx, site.ID, worker.ID, 0)
+	if err != nil || len(lines) != 1 || !strings.HasSuffix(lines[0], "listening") {
+		t.Fatalf("unexpected logs: %q (%v)", lines, err)
+	}
+}
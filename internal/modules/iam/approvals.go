@@ -0,0 +1,260 @@
+package iam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/settings"
+)
+
+// Approval states. A pending approval becomes approved or rejected by an
+// admin and expired once its window passes. An approved operation runs as
+// a job and ends done, or failed when it returned an error.
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+	ApprovalExpired  = "expired"
+	ApprovalDone     = "done"
+	ApprovalFailed   = "failed"
+)
+
+const defaultApprovalWindow = time.Hour
+
+var (
+	// ErrApprovalNotFound indicates a missing approval.
+	ErrApprovalNotFound = errors.New("approval not found")
+	// ErrApprovalNotPending indicates an approval already decided.
+	ErrApprovalNotPending = errors.New("approval is no longer pending")
+	// ErrApprovalExpired indicates an approval whose window has passed.
+	ErrApprovalExpired = errors.New("approval window has passed")
+	// ErrSelfApproval indicates the requester trying to approve their own
+	// operation.
+	ErrSelfApproval = errors.New("operation must be approved by a second admin")
+	// ErrNoApprover indicates a panel without another admin who could
+	// approve.
+	ErrNoApprover = errors.New("two-person approval needs a second admin")
+)
+
+// Approval is a destructive operation held until a second admin confirms
+// it. Payload is what the operation needs to run; client-held secrets are
+// never part of it.
+type Approval struct {
+	ID          int64           `json:"id"`
+	Operation   string          `json:"operation"`
+	Target      string          `json:"target"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	RequestedBy string          `json:"requested_by"`
+	Status      string          `json:"status"`
+	DecidedBy   string          `json:"decided_by,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	JobID       int64           `json:"job_id,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	ExpiresAt   time.Time       `json:"expires_at"`
+	DecidedAt   time.Time       `json:"decided_at,omitzero"`
+}
+
+// ApprovalRequired reports whether destructive operations wait for a
+// second admin (the security two_person_approval setting).
+func (s *Service) ApprovalRequired(ctx context.Context) bool {
+	return s.settings != nil && s.settings.Bool(ctx, settings.Security, "two_person_approval")
+}
+
+func (s *Service) approvalWindow(ctx context.Context) time.Duration {
+	if s.settings != nil {
+		if minutes := s.settings.Int(ctx, settings.Security, "approval_window_minutes"); minutes > 0 {
+			return time.Duration(minutes) * time.Minute
+		}
+	}
+	return defaultApprovalWindow
+}
+
+// RequestApproval holds operation on target until another admin approves
+// it within the approval window.
+func (s *Service) RequestApproval(ctx context.Context, operation, target string, payload json.RawMessage, actor string) (Approval, error) {
+	operation, target = strings.TrimSpace(operation), strings.TrimSpace(target)
+	if operation == "" || target == "" {
+		return Approval{}, fmt.Errorf("operation and target are required")
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT COUNT(*) AS n FROM users WHERE role='%s' AND email != '%s';", RoleAdmin, sqlEscape(actor)))
+	if err != nil {
+		return Approval{}, fmt.Errorf("count approvers: %w", err)
+	}
+	if len(rows) == 0 {
+		return Approval{}, ErrNoApprover
+	}
+	if n, _ := toInt64(rows[0]["n"]); n == 0 {
+		return Approval{}, ErrNoApprover
+	}
+	now := s.now()
+	rows, err = s.store.QueryPanelWriteJSON(ctx, fmt.Sprintf(`
+INSERT INTO approvals(operation, target, payload, requested_by, status, created_at, expires_at)
+VALUES('%s','%s','%s','%s','%s',%d,%d)
+RETURNING id;`,
+		sqlEscape(operation), sqlEscape(target), sqlEscape(string(payload)), sqlEscape(actor), ApprovalPending,
+		now.Unix(), now.Add(s.approvalWindow(ctx)).Unix()))
+	if err != nil {
+		return Approval{}, fmt.Errorf("request approval: %w", err)
+	}
+	if len(rows) == 0 {
+		return Approval{}, fmt.Errorf("request approval: no id returned")
+	}
+	id, err := toInt64(rows[0]["id"])
+	if err != nil {
+		return Approval{}, fmt.Errorf("request approval: %w", err)
+	}
+	s.audit(ctx, actor, "approval.request", fmt.Sprintf("id=%d operation=%s target=%s", id, operation, target))
+	return s.GetApproval(ctx, id)
+}
+
+// ListApprovals returns approvals, newest first, optionally only those in
+// status.
+func (s *Service) ListApprovals(ctx context.Context, status string) ([]Approval, error) {
+	s.expireApprovals(ctx)
+	where := ""
+	if status != "" {
+		where = fmt.Sprintf("WHERE status='%s'", sqlEscape(status))
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, "SELECT * FROM approvals "+where+" ORDER BY id DESC LIMIT 200;")
+	if err != nil {
+		return nil, fmt.Errorf("list approvals: %w", err)
+	}
+	out := make([]Approval, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, approvalFromRow(row))
+	}
+	return out, nil
+}
+
+// GetApproval returns one approval.
+func (s *Service) GetApproval(ctx context.Context, id int64) (Approval, error) {
+	s.expireApprovals(ctx)
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf("SELECT * FROM approvals WHERE id=%d;", id))
+	if err != nil {
+		return Approval{}, fmt.Errorf("get approval: %w", err)
+	}
+	if len(rows) == 0 {
+		return Approval{}, ErrApprovalNotFound
+	}
+	return approvalFromRow(rows[0]), nil
+}
+
+// ApproveOperation confirms a pending approval. The caller runs the
+// operation afterwards and reports its outcome with CompleteApproval or
+// FailApproval.
+func (s *Service) ApproveOperation(ctx context.Context, id int64, actor string) (Approval, error) {
+	a, err := s.GetApproval(ctx, id)
+	if err != nil {
+		return Approval{}, err
+	}
+	switch {
+	case a.Status == ApprovalExpired:
+		return a, ErrApprovalExpired
+	case a.Status != ApprovalPending:
+		return a, ErrApprovalNotPending
+	case strings.EqualFold(a.RequestedBy, actor):
+		return a, ErrSelfApproval
+	}
+	if err := s.decide(ctx, id, ApprovalApproved, actor); err != nil {
+		return a, err
+	}
+	s.audit(ctx, actor, "approval.approve", fmt.Sprintf("id=%d operation=%s target=%s requested_by=%s", id, a.Operation, a.Target, a.RequestedBy))
+	return s.GetApproval(ctx, id)
+}
+
+// RejectOperation turns down a pending approval. The requester may reject
+// their own to withdraw it.
+func (s *Service) RejectOperation(ctx context.Context, id int64, actor string) (Approval, error) {
+	a, err := s.GetApproval(ctx, id)
+	if err != nil {
+		return Approval{}, err
+	}
+	if a.Status != ApprovalPending {
+		return a, ErrApprovalNotPending
+	}
+	if err := s.decide(ctx, id, ApprovalRejected, actor); err != nil {
+		return a, err
+	}
+	s.audit(ctx, actor, "approval.reject", fmt.Sprintf("id=%d operation=%s target=%s requested_by=%s", id, a.Operation, a.Target, a.RequestedBy))
+	return s.GetApproval(ctx, id)
+}
+
+// SetApprovalJob records the job that runs an approved operation.
+func (s *Service) SetApprovalJob(ctx context.Context, id, jobID int64) error {
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(
+		"UPDATE approvals SET job_id=%d WHERE id=%d;", jobID, id)); err != nil {
+		return fmt.Errorf("set approval job: %w", err)
+	}
+	return nil
+}
+
+// CompleteApproval records that an approved operation finished.
+func (s *Service) CompleteApproval(ctx context.Context, id int64) {
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(
+		"UPDATE approvals SET status='%s' WHERE id=%d AND status='%s';",
+		ApprovalDone, id, ApprovalApproved)); err != nil {
+		s.log.Warn("record approval completion failed", "id", id, "error", err)
+	}
+	s.audit(ctx, "system", "approval.done", fmt.Sprintf("id=%d", id))
+}
+
+// FailApproval records that an approved operation returned runErr.
+func (s *Service) FailApproval(ctx context.Context, id int64, runErr error) {
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(
+		"UPDATE approvals SET status='%s', error='%s' WHERE id=%d AND status='%s';",
+		ApprovalFailed, sqlEscape(runErr.Error()), id, ApprovalApproved)); err != nil {
+		s.log.Warn("record approval failure failed", "id", id, "error", err)
+	}
+	s.audit(ctx, "system", "approval.failed", fmt.Sprintf("id=%d error=%s", id, runErr.Error()))
+}
+
+// decide moves a pending approval to status, unless it expired or was
+// decided in the meantime.
+func (s *Service) decide(ctx context.Context, id int64, status, actor string) error {
+	now := s.now().Unix()
+	rows, err := s.store.QueryPanelWriteJSON(ctx, fmt.Sprintf(
+		"UPDATE approvals SET status='%s', decided_by='%s', decided_at=%d WHERE id=%d AND status='%s' AND expires_at > %d RETURNING id;",
+		status, sqlEscape(actor), now, id, ApprovalPending, now))
+	if err != nil {
+		return fmt.Errorf("decide approval: %w", err)
+	}
+	if len(rows) == 0 {
+		return ErrApprovalNotPending
+	}
+	return nil
+}
+
+func (s *Service) expireApprovals(ctx context.Context) {
+	_ = s.store.ExecPanel(ctx, fmt.Sprintf(
+		"UPDATE approvals SET status='%s' WHERE status='%s' AND expires_at <= %d;",
+		ApprovalExpired, ApprovalPending, s.now().Unix()))
+}
+
+func approvalFromRow(row map[string]any) Approval {
+	var a Approval
+	a.ID, _ = toInt64(row["id"])
+	a.Operation, _ = row["operation"].(string)
+	a.Target, _ = row["target"].(string)
+	if payload, _ := row["payload"].(string); payload != "" {
+		a.Payload = json.RawMessage(payload)
+	}
+	a.RequestedBy, _ = row["requested_by"].(string)
+	a.Status, _ = row["status"].(string)
+	a.DecidedBy, _ = row["decided_by"].(string)
+	a.Error, _ = row["error"].(string)
+	a.JobID, _ = toInt64(row["job_id"])
+	created, _ := toInt64(row["created_at"])
+	expires, _ := toInt64(row["expires_at"])
+	decided, _ := toInt64(row["decided_at"])
+	a.CreatedAt = time.Unix(created, 0).UTC()
+	a.ExpiresAt = time.Unix(expires, 0).UTC()
+	if decided > 0 {
+		a.DecidedAt = time.Unix(decided, 0).UTC()
+	}
+	return a
+}
@@ -0,0 +1,92 @@
+package iam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/logger"
+	"github.com/robsonek/aiPanel/internal/platform/settings"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+func TestIAM_TwoPersonApproval(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Config{DataDir: t.TempDir(), SessionTTL: time.Hour}
+	store := sqlite.New(cfg.DataDir)
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init sqlite: %v", err)
+	}
+	svc := NewService(store, cfg, logger.New("test"))
+	now := time.Now()
+	svc.now = func() time.Time { return now }
+	policy := settings.New(store, nil, settings.Builtin(cfg)...)
+	svc.SetSettings(policy)
+	if err := svc.CreateAdmin(ctx, "admin@example.com", "supersecret123"); err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+
+	if svc.ApprovalRequired(ctx) {
+		t.Fatal("expected approval off by default")
+	}
+	if _, err := policy.Update(ctx, settings.Security, map[string]json.RawMessage{
+		"two_person_approval": json.RawMessage(`true`), "approval_window_minutes": json.RawMessage(`30`),
+	}, "admin@example.com"); err != nil {
+		t.Fatalf("enable approval: %v", err)
+	}
+	if !svc.ApprovalRequired(ctx) {
+		t.Fatal("expected approval required")
+	}
+	if _, err := svc.RequestApproval(ctx, "site.delete", "site 1", nil, "admin@example.com"); !errors.Is(err, ErrNoApprover) {
+		t.Fatalf("expected ErrNoApprover with a single admin, got %v", err)
+	}
+	if _, err := svc.CreateUser(ctx, "second@example.com", "supersecret123", RoleAdmin); err != nil {
+		t.Fatalf("create second admin: %v", err)
+	}
+
+	a, err := svc.RequestApproval(ctx, "site.delete", "site 1", json.RawMessage(`{"id":1}`), "admin@example.com")
+	if err != nil {
+		t.Fatalf("request approval: %v", err)
+	}
+	if a.Status != ApprovalPending || !a.ExpiresAt.Equal(time.Unix(now.Add(30*time.Minute).Unix(), 0).UTC()) {
+		t.Fatalf("unexpected approval: %+v", a)
+	}
+	if _, err := svc.ApproveOperation(ctx, a.ID, "Admin@example.com"); !errors.Is(err, ErrSelfApproval) {
+		t.Fatalf("expected ErrSelfApproval, got %v", err)
+	}
+	approved, err := svc.ApproveOperation(ctx, a.ID, "second@example.com")
+	if err != nil || approved.Status != ApprovalApproved || approved.DecidedBy != "second@example.com" {
+		t.Fatalf("unexpected approve result: %+v (%v)", approved, err)
+	}
+	if _, err := svc.RejectOperation(ctx, a.ID, "second@example.com"); !errors.Is(err, ErrApprovalNotPending) {
+		t.Fatalf("expected ErrApprovalNotPending, got %v", err)
+	}
+	if err := svc.SetApprovalJob(ctx, a.ID, 7); err != nil {
+		t.Fatalf("set approval job: %v", err)
+	}
+	svc.FailApproval(ctx, a.ID, errors.New("nginx test failed"))
+	if failed, _ := svc.GetApproval(ctx, a.ID); failed.Status != ApprovalFailed || failed.Error != "nginx test failed" || failed.JobID != 7 {
+		t.Fatalf("expected failed approval, got %+v", failed)
+	}
+
+	late, err := svc.RequestApproval(ctx, "database.delete", "database 2", nil, "second@example.com")
+	if err != nil {
+		t.Fatalf("request approval: %v", err)
+	}
+	now = now.Add(31 * time.Minute)
+	if _, err := svc.ApproveOperation(ctx, late.ID, "admin@example.com"); !errors.Is(err, ErrApprovalExpired) {
+		t.Fatalf("expected ErrApprovalExpired, got %v", err)
+	}
+	pending, err := svc.ListApprovals(ctx, ApprovalPending)
+	if err != nil || len(pending) != 0 {
+		t.Fatalf("expected no pending approvals, got %+v (%v)", pending, err)
+	}
+
+	rows, err := store.QueryAuditJSON(ctx, "SELECT action FROM audit_events WHERE action LIKE 'approval.%' ORDER BY id;")
+	if err != nil || len(rows) != 4 {
+		t.Fatalf("expected request, approve, failed and request audits, got %v (%v)", rows, err)
+	}
+}
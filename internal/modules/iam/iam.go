@@ -13,9 +13,13 @@ import (
 	"net/mail"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/robsonek/aiPanel/internal/platform/cache"
 	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/middleware"
+	"github.com/robsonek/aiPanel/internal/platform/settings"
 	"github.com/robsonek/aiPanel/internal/platform/sqlite"
 )
 
@@ -31,6 +35,11 @@ type User struct {
 	ID    int64  `json:"id"`
 	Email string `json:"email"`
 	Role  string `json:"role"`
+	// Scope is set when the request authenticated with an API token.
+	Scope *Scope `json:"scope,omitempty"`
+	// Impersonator is the email of the admin acting as this user in an
+	// impersonation session.
+	Impersonator string `json:"impersonator,omitempty"`
 }
 
 // Session is an authenticated session result.
@@ -40,53 +49,120 @@ type Session struct {
 	ExpiresAt time.Time
 }
 
+// sessionCacheTTL bounds how long a session lookup is served from memory.
+// Logout invalidates explicitly; the TTL covers rows removed out of band.
+const sessionCacheTTL = 30 * time.Second
+
 // Service provides IAM operations backed by panel.db.
 type Service struct {
-	store *sqlite.Store
-	cfg   config.Config
-	log   *slog.Logger
+	store    *sqlite.Store
+	cfg      config.Config
+	log      *slog.Logger
+	sessions *cache.TTL[string, User]
+	// setupMu serializes CompleteSetup so only one first admin is created.
+	setupMu sync.Mutex
+	// now is the clock for login lockouts; tests move it forward.
+	now func() time.Time
+	// settings overrides the lockout thresholds of panel.yaml; nil uses
+	// cfg as is.
+	settings *settings.Store
 }
 
 // NewService creates IAM service.
 func NewService(store *sqlite.Store, cfg config.Config, log *slog.Logger) *Service {
-	return &Service{store: store, cfg: cfg, log: log}
+	return &Service{
+		store:    store,
+		cfg:      cfg,
+		log:      log,
+		sessions: cache.New[string, User](sessionCacheTTL),
+		now:      time.Now,
+	}
+}
+
+// SetSettings sets the store of settings changed in the panel: the login
+// lockout thresholds.
+func (s *Service) SetSettings(store *settings.Store) {
+	s.settings = store
 }
 
+// User roles. Admins manage the whole panel; users only reach the sites of
+// their organizations.
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
 // CreateAdmin creates an admin user if email is valid.
 func (s *Service) CreateAdmin(ctx context.Context, email, password string) error {
+	_, err := s.CreateUser(ctx, email, password, RoleAdmin)
+	return err
+}
+
+// CreateUser creates a user with the given role.
+func (s *Service) CreateUser(ctx context.Context, email, password, role string) (User, error) {
 	if err := validateEmail(email); err != nil {
-		return err
+		return User{}, err
 	}
 	if len(password) < 10 {
-		return fmt.Errorf("password must be at least 10 characters")
+		return User{}, fmt.Errorf("password must be at least 10 characters")
+	}
+	if role != RoleAdmin && role != RoleUser {
+		return User{}, fmt.Errorf("invalid role %q", role)
 	}
 	hash, err := hashPassword(password)
 	if err != nil {
-		return err
+		return User{}, err
 	}
+	email = strings.ToLower(strings.TrimSpace(email))
 	now := time.Now().Unix()
 	sql := fmt.Sprintf(
-		"INSERT INTO users(email, password_hash, role, created_at) VALUES('%s','%s','admin',%d);",
-		sqlEscape(strings.ToLower(strings.TrimSpace(email))),
+		"INSERT INTO users(email, password_hash, role, created_at) VALUES('%s','%s','%s',%d);",
+		sqlEscape(email),
 		sqlEscape(hash),
+		role,
 		now,
 	)
 	if err := s.store.ExecPanel(ctx, sql); err != nil {
-		return fmt.Errorf("create admin: %w", err)
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return User{}, fmt.Errorf("user %s already exists", email)
+		}
+		return User{}, fmt.Errorf("create %s: %w", role, err)
 	}
-	return nil
+	user, _, err := s.getUserByEmail(ctx, email)
+	return user, err
 }
 
-// Login validates credentials and creates a session.
+// ListUsers returns all users ordered by email.
+func (s *Service) ListUsers(ctx context.Context) ([]User, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, "SELECT id, email, role FROM users ORDER BY email;")
+	if err != nil {
+		return nil, fmt.Errorf("list users: %w", err)
+	}
+	users := make([]User, 0, len(rows))
+	for _, row := range rows {
+		u, err := mapRowToUser(row)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// Login validates credentials and creates a session. Repeated failures
+// for one email or client address lock it out; see ErrLockedOut.
 func (s *Service) Login(ctx context.Context, email, password string) (*Session, error) {
 	email = strings.ToLower(strings.TrimSpace(email))
-	user, hash, err := s.getUserByEmail(ctx, email)
-	if err != nil {
-		return nil, ErrInvalidCredentials
+	keys := s.loginKeys(ctx, email)
+	if err := s.checkLockout(ctx, keys); err != nil {
+		return nil, err
 	}
-	if !verifyPassword(password, hash) {
+	user, hash, err := s.getUserByEmail(ctx, email)
+	if err != nil || !verifyPassword(password, hash) {
+		s.recordLoginFailure(ctx, keys)
 		return nil, ErrInvalidCredentials
 	}
+	s.clearLoginFailures(ctx, email)
 
 	token, err := randomHex(32)
 	if err != nil {
@@ -107,8 +183,9 @@ func (s *Service) Login(ctx context.Context, email, password string) (*Session,
 	}
 
 	_ = s.store.ExecAudit(ctx, fmt.Sprintf(
-		"INSERT INTO audit_events(actor, action, details, created_at) VALUES('%s','auth.login','success',%d);",
+		"INSERT INTO audit_events(actor, action, details, remote_ip, created_at) VALUES('%s','auth.login','success','%s',%d);",
 		sqlEscape(user.Email),
+		sqlEscape(middleware.ClientIP(ctx)),
 		time.Now().Unix(),
 	))
 
@@ -125,6 +202,7 @@ func (s *Service) Logout(ctx context.Context, token string) error {
 	if token == "" {
 		return nil
 	}
+	s.sessions.Delete(token)
 	sql := fmt.Sprintf("DELETE FROM sessions WHERE token='%s';", sqlEscape(token))
 	if err := s.store.ExecPanel(ctx, sql); err != nil {
 		return fmt.Errorf("delete session: %w", err)
@@ -138,13 +216,21 @@ func (s *Service) Authenticate(ctx context.Context, token string) (User, error)
 	if token == "" {
 		return User{}, ErrUnauthorized
 	}
+	if u, ok := s.sessions.Get(token); ok {
+		return u, nil
+	}
+	if strings.HasPrefix(token, APITokenPrefix) {
+		return s.authenticateAPIToken(ctx, token)
+	}
 	// Remove expired sessions opportunistically.
 	_ = s.store.ExecPanel(ctx, fmt.Sprintf("DELETE FROM sessions WHERE expires_at <= %d;", time.Now().Unix()))
 
 	query := fmt.Sprintf(`
-SELECT u.id as id, u.email as email, u.role as role
+SELECT u.id as id, u.email as email, u.role as role, s.expires_at as expires_at,
+       COALESCE(i.email, '') as impersonator
 FROM sessions s
 JOIN users u ON u.id = s.user_id
+LEFT JOIN users i ON i.id = s.impersonator_id AND s.impersonator_id > 0
 WHERE s.token = '%s' AND s.expires_at > %d
 LIMIT 1;`, sqlEscape(token), time.Now().Unix())
 	rows, err := s.store.QueryPanelJSON(ctx, query)
@@ -155,6 +241,10 @@ LIMIT 1;`, sqlEscape(token), time.Now().Unix())
 	if err != nil {
 		return User{}, ErrUnauthorized
 	}
+	u.Impersonator, _ = rows[0]["impersonator"].(string)
+	if expiresAt, convErr := toInt64(rows[0]["expires_at"]); convErr == nil {
+		s.sessions.SetUntil(token, u, time.Unix(expiresAt, 0))
+	}
 	return u, nil
 }
 
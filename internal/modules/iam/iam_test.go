@@ -2,6 +2,7 @@ package iam
 
 import (
 	"context"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -55,3 +56,42 @@ func TestIAM_CreateLoginAuthenticateLogout(t *testing.T) {
 		t.Fatal("expected auth to fail after logout")
 	}
 }
+
+func TestIAM_AuthenticateServesCachedSessionUntilLogout(t *testing.T) {
+	cfg := config.Config{
+		Addr:              ":8080",
+		Env:               "test",
+		DataDir:           t.TempDir(),
+		SessionCookieName: "aipanel_session",
+		SessionTTL:        time.Hour,
+	}
+	store := sqlite.New(cfg.DataDir)
+	if err := store.Init(context.Background()); err != nil {
+		t.Fatalf("init sqlite: %v", err)
+	}
+	svc := NewService(store, cfg, logger.New("test"))
+	if err := svc.CreateAdmin(context.Background(), "admin@example.com", "supersecret123"); err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+	session, err := svc.Login(context.Background(), "admin@example.com", "supersecret123")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if _, err := svc.Authenticate(context.Background(), session.Token); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+
+	// A cached session keeps working even when the database is unavailable.
+	svc.store = sqlite.New(filepath.Join(t.TempDir(), "missing", "dir"))
+	if _, err := svc.Authenticate(context.Background(), session.Token); err != nil {
+		t.Fatalf("expected cached authenticate to succeed: %v", err)
+	}
+
+	svc.store = store
+	if err := svc.Logout(context.Background(), session.Token); err != nil {
+		t.Fatalf("logout: %v", err)
+	}
+	if _, err := svc.Authenticate(context.Background(), session.Token); err == nil {
+		t.Fatal("expected logout to invalidate cached session")
+	}
+}
@@ -0,0 +1,58 @@
+package iam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxImpersonationTTL caps impersonation sessions below session_ttl; they
+// are meant for a debugging visit, not for working as the user.
+const maxImpersonationTTL = time.Hour
+
+// ErrCannotImpersonate indicates an impersonation the panel refuses:
+// admins cannot be impersonated and impersonation cannot be nested.
+var ErrCannotImpersonate = errors.New("cannot impersonate this user")
+
+// Impersonate opens a session as the user with userID on behalf of admin.
+// Requests in that session carry admin as User.Impersonator, and audit
+// events record both identities.
+func (s *Service) Impersonate(ctx context.Context, admin User, userID int64) (*Session, error) {
+	if !admin.AllowsAdmin() || admin.Scope != nil || admin.Impersonator != "" {
+		return nil, ErrCannotImpersonate
+	}
+	target, err := s.getUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if target.Role == RoleAdmin {
+		return nil, ErrCannotImpersonate
+	}
+	token, err := randomHex(32)
+	if err != nil {
+		return nil, fmt.Errorf("generate session token: %w", err)
+	}
+	now := time.Now()
+	expires := now.Add(min(s.cfg.SessionTTL, maxImpersonationTTL))
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(
+		"INSERT INTO sessions(token, user_id, expires_at, created_at, impersonator_id) VALUES('%s',%d,%d,%d,%d);",
+		sqlEscape(token), target.ID, expires.Unix(), now.Unix(), admin.ID)); err != nil {
+		return nil, fmt.Errorf("create impersonation session: %w", err)
+	}
+	s.audit(ctx, admin.Email, "auth.impersonate.start", "user="+target.Email)
+	target.Impersonator = admin.Email
+	return &Session{Token: token, User: target, ExpiresAt: expires}, nil
+}
+
+// EndImpersonation closes an impersonation session.
+func (s *Service) EndImpersonation(ctx context.Context, u User, token string) error {
+	if u.Impersonator == "" {
+		return ErrCannotImpersonate
+	}
+	if err := s.Logout(ctx, token); err != nil {
+		return err
+	}
+	s.audit(ctx, u.Email, "auth.impersonate.stop", "impersonator="+u.Impersonator)
+	return nil
+}
@@ -0,0 +1,186 @@
+package iam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/middleware"
+	"github.com/robsonek/aiPanel/internal/platform/settings"
+)
+
+// Lockout kinds: failed logins are counted per email and per client
+// address.
+const (
+	LockoutEmail = "email"
+	LockoutIP    = "ip"
+)
+
+var (
+	// ErrLockedOut indicates a login refused because of too many recent
+	// failures; the returned error is a *LockoutError.
+	ErrLockedOut = errors.New("too many failed logins")
+	// ErrLockoutNotFound indicates no active lockout for the kind and key.
+	ErrLockoutNotFound = errors.New("lockout not found")
+)
+
+// LockoutError carries how long a locked out login has to wait.
+type LockoutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string { return ErrLockedOut.Error() }
+
+// Unwrap makes errors.Is(err, ErrLockedOut) match.
+func (e *LockoutError) Unwrap() error { return ErrLockedOut }
+
+// Lockout is an email or client address refused until LockedUntil.
+type Lockout struct {
+	Kind        string    `json:"kind"`
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+type loginKey struct {
+	kind  string
+	key   string
+	limit int
+}
+
+// loginKeys lists the counters a login attempt is charged to. A zero
+// threshold disables its counter.
+func (s *Service) loginKeys(ctx context.Context, email string) []loginKey {
+	perEmail, perIP := s.cfg.LoginMaxFailures, s.cfg.LoginMaxFailuresPerIP
+	if s.settings != nil {
+		perEmail = s.settings.Int(ctx, settings.Security, "login_max_failures")
+		perIP = s.settings.Int(ctx, settings.Security, "login_max_failures_per_ip")
+	}
+	var keys []loginKey
+	if email != "" && perEmail > 0 {
+		keys = append(keys, loginKey{kind: LockoutEmail, key: email, limit: perEmail})
+	}
+	if ip := middleware.ClientIP(ctx); ip != "" && perIP > 0 {
+		keys = append(keys, loginKey{kind: LockoutIP, key: ip, limit: perIP})
+	}
+	return keys
+}
+
+func keysCondition(keys []loginKey) string {
+	conds := make([]string, 0, len(keys))
+	for _, k := range keys {
+		conds = append(conds, fmt.Sprintf("(kind='%s' AND key='%s')", k.kind, sqlEscape(k.key)))
+	}
+	return strings.Join(conds, " OR ")
+}
+
+// checkLockout refuses the attempt before the password is looked at, so a
+// locked account cannot be probed further.
+func (s *Service) checkLockout(ctx context.Context, keys []loginKey) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	now := s.now().Unix()
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT MAX(locked_until) AS locked_until FROM login_failures WHERE locked_until > %d AND (%s);",
+		now, keysCondition(keys)))
+	if err != nil {
+		return fmt.Errorf("check login lockout: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	until, err := toInt64(rows[0]["locked_until"])
+	if err != nil || until <= now {
+		return nil
+	}
+	return &LockoutError{RetryAfter: time.Duration(until-now) * time.Second}
+}
+
+// recordLoginFailure charges a failed attempt to every key and locks the
+// ones that reached their threshold. Counting restarts once the window has
+// passed or a previous lockout has run out.
+func (s *Service) recordLoginFailure(ctx context.Context, keys []loginKey) {
+	now := s.now().Unix()
+	windowStart := now - int64(s.cfg.LoginFailureWindow/time.Second)
+	_ = s.store.ExecPanel(ctx, fmt.Sprintf(
+		"DELETE FROM login_failures WHERE locked_until <= %d AND first_failed_at <= %d;", now, windowStart))
+	for _, k := range keys {
+		err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO login_failures(kind, key, failures, first_failed_at, locked_until, updated_at)
+VALUES('%[1]s','%[2]s',1,%[3]d,0,%[3]d)
+ON CONFLICT(kind, key) DO UPDATE SET
+  failures = CASE WHEN first_failed_at <= %[4]d OR (locked_until > 0 AND locked_until <= %[3]d) THEN 1 ELSE failures + 1 END,
+  first_failed_at = CASE WHEN first_failed_at <= %[4]d OR (locked_until > 0 AND locked_until <= %[3]d) THEN %[3]d ELSE first_failed_at END,
+  locked_until = CASE WHEN locked_until <= %[3]d THEN 0 ELSE locked_until END,
+  updated_at = %[3]d;`, k.kind, sqlEscape(k.key), now, windowStart))
+		if err != nil {
+			s.log.Warn("record login failure failed", "kind", k.kind, "error", err)
+			continue
+		}
+		until := now + int64(s.cfg.LoginLockout/time.Second)
+		rows, err := s.store.QueryPanelWriteJSON(ctx, fmt.Sprintf(
+			"UPDATE login_failures SET locked_until=%d WHERE kind='%s' AND key='%s' AND failures >= %d AND locked_until = 0 RETURNING failures;",
+			until, k.kind, sqlEscape(k.key), k.limit))
+		if err != nil || len(rows) == 0 {
+			continue
+		}
+		s.log.Warn("login locked out", "kind", k.kind, "key", k.key, "until", time.Unix(until, 0))
+		s.audit(ctx, "system", "auth.lockout", fmt.Sprintf("%s=%s until=%s", k.kind, k.key, time.Unix(until, 0).UTC().Format(time.RFC3339)))
+	}
+}
+
+// clearLoginFailures forgets the email's failures after a successful
+// login. The address counter is kept, so one valid account does not reset
+// the count for guesses at others from the same client.
+func (s *Service) clearLoginFailures(ctx context.Context, email string) {
+	_ = s.store.ExecPanel(ctx, fmt.Sprintf(
+		"DELETE FROM login_failures WHERE kind='%s' AND key='%s';", LockoutEmail, sqlEscape(email)))
+}
+
+// ListLockouts returns the active lockouts, the longest-running first.
+func (s *Service) ListLockouts(ctx context.Context) ([]Lockout, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT kind, key, failures, locked_until FROM login_failures WHERE locked_until > %d ORDER BY locked_until DESC, kind, key;",
+		s.now().Unix()))
+	if err != nil {
+		return nil, fmt.Errorf("list lockouts: %w", err)
+	}
+	out := make([]Lockout, 0, len(rows))
+	for _, row := range rows {
+		failures, _ := toInt64(row["failures"])
+		until, _ := toInt64(row["locked_until"])
+		l := Lockout{Failures: int(failures), LockedUntil: time.Unix(until, 0).UTC()}
+		l.Kind, _ = row["kind"].(string)
+		l.Key, _ = row["key"].(string)
+		out = append(out, l)
+	}
+	return out, nil
+}
+
+// ClearLockout lifts an active lockout and resets its failure count.
+func (s *Service) ClearLockout(ctx context.Context, kind, key, actor string) error {
+	if kind != LockoutEmail && kind != LockoutIP {
+		return fmt.Errorf("invalid lockout kind %q", kind)
+	}
+	key = strings.TrimSpace(key)
+	if kind == LockoutEmail {
+		key = strings.ToLower(key)
+	}
+	if key == "" {
+		return fmt.Errorf("lockout key is required")
+	}
+	rows, err := s.store.QueryPanelWriteJSON(ctx, fmt.Sprintf(
+		"DELETE FROM login_failures WHERE kind='%s' AND key='%s' AND locked_until > %d RETURNING kind;",
+		kind, sqlEscape(key), s.now().Unix()))
+	if err != nil {
+		return fmt.Errorf("clear lockout: %w", err)
+	}
+	if len(rows) == 0 {
+		return ErrLockoutNotFound
+	}
+	s.audit(ctx, actor, "auth.lockout.clear", kind+"="+key)
+	return nil
+}
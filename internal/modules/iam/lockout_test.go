@@ -0,0 +1,83 @@
+package iam
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/logger"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+func TestIAM_LoginLockout(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Config{
+		DataDir:               t.TempDir(),
+		SessionTTL:            time.Hour,
+		LoginMaxFailures:      3,
+		LoginMaxFailuresPerIP: 20,
+		LoginFailureWindow:    15 * time.Minute,
+		LoginLockout:          10 * time.Minute,
+	}
+	store := sqlite.New(cfg.DataDir)
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init sqlite: %v", err)
+	}
+	svc := NewService(store, cfg, logger.New("test"))
+	now := time.Now()
+	svc.now = func() time.Time { return now }
+	if err := svc.CreateAdmin(ctx, "admin@example.com", "supersecret123"); err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+
+	// Failures outside the window do not add up.
+	for range 2 {
+		if _, err := svc.Login(ctx, "admin@example.com", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("expected invalid credentials, got %v", err)
+		}
+	}
+	now = now.Add(16 * time.Minute)
+	for range 3 {
+		if _, err := svc.Login(ctx, "Admin@example.com", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("expected invalid credentials, got %v", err)
+		}
+	}
+
+	now = now.Add(time.Minute)
+	_, err := svc.Login(ctx, "admin@example.com", "supersecret123")
+	var locked *LockoutError
+	if !errors.As(err, &locked) || !errors.Is(err, ErrLockedOut) || locked.RetryAfter != 9*time.Minute {
+		t.Fatalf("expected lockout with 9m left, got %v", err)
+	}
+	lockouts, err := svc.ListLockouts(ctx)
+	if err != nil || len(lockouts) != 1 || lockouts[0].Kind != LockoutEmail || lockouts[0].Key != "admin@example.com" || lockouts[0].Failures != 3 {
+		t.Fatalf("unexpected lockouts: %+v (%v)", lockouts, err)
+	}
+
+	if err := svc.ClearLockout(ctx, "user", "admin@example.com", "root"); err == nil {
+		t.Fatal("expected invalid kind")
+	}
+	if err := svc.ClearLockout(ctx, LockoutIP, "192.0.2.1", "root"); !errors.Is(err, ErrLockoutNotFound) {
+		t.Fatalf("expected ErrLockoutNotFound, got %v", err)
+	}
+	if err := svc.ClearLockout(ctx, LockoutEmail, "ADMIN@example.com", "root"); err != nil {
+		t.Fatalf("clear lockout: %v", err)
+	}
+	if _, err := svc.Login(ctx, "admin@example.com", "supersecret123"); err != nil {
+		t.Fatalf("expected login after clearing lockout, got %v", err)
+	}
+
+	// A lockout that ran out starts a fresh count.
+	for range 3 {
+		_, _ = svc.Login(ctx, "admin@example.com", "wrong")
+	}
+	now = now.Add(11 * time.Minute)
+	if _, err := svc.Login(ctx, "admin@example.com", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected invalid credentials after lockout expiry, got %v", err)
+	}
+	if _, err := svc.Login(ctx, "admin@example.com", "supersecret123"); err != nil {
+		t.Fatalf("expected login after lockout expiry, got %v", err)
+	}
+}
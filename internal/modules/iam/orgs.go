@@ -0,0 +1,334 @@
+package iam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/middleware"
+)
+
+// Organization roles, from most to least privileged. Owners manage the
+// members of their organization; developers change its sites; viewers
+// only read them.
+const (
+	OrgRoleOwner     = "owner"
+	OrgRoleDeveloper = "developer"
+	OrgRoleViewer    = "viewer"
+)
+
+var (
+	// ErrOrgNotFound indicates an unknown organization id.
+	ErrOrgNotFound = errors.New("organization not found")
+	// ErrUserNotFound indicates an unknown user.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrForbidden indicates the user lacks the role for an operation.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Organization groups users that share access to a set of sites, and with
+// them the sites' databases.
+type Organization struct {
+	ID        int64       `json:"id"`
+	Name      string      `json:"name"`
+	Members   []OrgMember `json:"members"`
+	SiteIDs   []int64     `json:"site_ids"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// OrgMember is a user's role in an organization.
+type OrgMember struct {
+	UserID int64  `json:"user_id"`
+	Email  string `json:"email"`
+	Role   string `json:"role"`
+}
+
+// orgRoleRank orders roles so the strongest membership wins.
+var orgRoleRank = map[string]int{
+	OrgRoleViewer:    1,
+	OrgRoleDeveloper: 2,
+	OrgRoleOwner:     3,
+}
+
+// CreateOrg creates an empty organization.
+func (s *Service) CreateOrg(ctx context.Context, name, actor string) (Organization, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Organization{}, fmt.Errorf("organization name is required")
+	}
+	if len(name) > 128 {
+		return Organization{}, fmt.Errorf("invalid organization name: longer than 128 characters")
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(
+		"INSERT INTO organizations(name, created_at) VALUES('%s',%d);",
+		sqlEscape(name), time.Now().Unix())); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return Organization{}, fmt.Errorf("organization %q already exists", name)
+		}
+		return Organization{}, fmt.Errorf("create organization: %w", err)
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT id FROM organizations WHERE name = '%s' LIMIT 1;", sqlEscape(name)))
+	if err != nil || len(rows) == 0 {
+		return Organization{}, fmt.Errorf("read created organization: %w", err)
+	}
+	id, err := toInt64(rows[0]["id"])
+	if err != nil {
+		return Organization{}, fmt.Errorf("parse organization id: %w", err)
+	}
+	s.audit(ctx, actor, "iam.org.create", fmt.Sprintf("org_id=%d name=%s", id, name))
+	return s.GetOrg(ctx, id)
+}
+
+// ListOrgs returns every organization to admins and the organizations a
+// user belongs to otherwise.
+func (s *Service) ListOrgs(ctx context.Context, u User) ([]Organization, error) {
+	query := "SELECT id FROM organizations ORDER BY name;"
+	if !u.AllowsAdmin() {
+		query = fmt.Sprintf(`
+SELECT o.id AS id FROM organizations o
+JOIN organization_members m ON m.org_id = o.id
+WHERE m.user_id = %d ORDER BY o.name;`, u.ID)
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("list organizations: %w", err)
+	}
+	orgs := make([]Organization, 0, len(rows))
+	for _, row := range rows {
+		id, err := toInt64(row["id"])
+		if err != nil {
+			return nil, fmt.Errorf("parse organization id: %w", err)
+		}
+		org, err := s.GetOrg(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, nil
+}
+
+// GetOrg returns an organization with its members and sites.
+func (s *Service) GetOrg(ctx context.Context, id int64) (Organization, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT id, name, created_at FROM organizations WHERE id = %d LIMIT 1;", id))
+	if err != nil {
+		return Organization{}, fmt.Errorf("get organization: %w", err)
+	}
+	if len(rows) == 0 {
+		return Organization{}, ErrOrgNotFound
+	}
+	org := Organization{ID: id, Members: []OrgMember{}, SiteIDs: []int64{}}
+	org.Name, _ = rows[0]["name"].(string)
+	if created, err := toInt64(rows[0]["created_at"]); err == nil {
+		org.CreatedAt = time.Unix(created, 0).UTC()
+	}
+
+	rows, err = s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT m.user_id AS user_id, u.email AS email, m.role AS role
+FROM organization_members m JOIN users u ON u.id = m.user_id
+WHERE m.org_id = %d ORDER BY u.email;`, id))
+	if err != nil {
+		return Organization{}, fmt.Errorf("list organization members: %w", err)
+	}
+	for _, row := range rows {
+		userID, err := toInt64(row["user_id"])
+		if err != nil {
+			return Organization{}, fmt.Errorf("parse member id: %w", err)
+		}
+		email, _ := row["email"].(string)
+		role, _ := row["role"].(string)
+		org.Members = append(org.Members, OrgMember{UserID: userID, Email: email, Role: role})
+	}
+
+	rows, err = s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT site_id FROM organization_sites WHERE org_id = %d ORDER BY site_id;", id))
+	if err != nil {
+		return Organization{}, fmt.Errorf("list organization sites: %w", err)
+	}
+	for _, row := range rows {
+		siteID, err := toInt64(row["site_id"])
+		if err != nil {
+			return Organization{}, fmt.Errorf("parse site id: %w", err)
+		}
+		org.SiteIDs = append(org.SiteIDs, siteID)
+	}
+	return org, nil
+}
+
+// DeleteOrg removes an organization. Its sites stay and fall back to
+// admin-only access.
+func (s *Service) DeleteOrg(ctx context.Context, id int64, actor string) error {
+	org, err := s.GetOrg(ctx, id)
+	if err != nil {
+		return err
+	}
+	// panel.db does not enforce foreign keys, so rows are removed explicitly.
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+DELETE FROM organization_members WHERE org_id = %d;
+DELETE FROM organization_sites WHERE org_id = %d;
+DELETE FROM organizations WHERE id = %d;`, id, id, id)); err != nil {
+		return fmt.Errorf("delete organization: %w", err)
+	}
+	s.audit(ctx, actor, "iam.org.delete", fmt.Sprintf("org_id=%d name=%s", id, org.Name))
+	return nil
+}
+
+// SetOrgMember adds the user with email to an organization, or changes the
+// role of an existing member.
+func (s *Service) SetOrgMember(ctx context.Context, orgID int64, email, role, actor string) (Organization, error) {
+	if _, ok := orgRoleRank[role]; !ok {
+		return Organization{}, fmt.Errorf("invalid role %q: must be owner, developer or viewer", role)
+	}
+	if _, err := s.GetOrg(ctx, orgID); err != nil {
+		return Organization{}, err
+	}
+	user, _, err := s.getUserByEmail(ctx, strings.ToLower(strings.TrimSpace(email)))
+	if err != nil {
+		return Organization{}, ErrUserNotFound
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO organization_members(org_id, user_id, role, created_at) VALUES(%d,%d,'%s',%d)
+ON CONFLICT(org_id, user_id) DO UPDATE SET role=excluded.role;`,
+		orgID, user.ID, role, time.Now().Unix())); err != nil {
+		return Organization{}, fmt.Errorf("set organization member: %w", err)
+	}
+	s.audit(ctx, actor, "iam.org.member.set", fmt.Sprintf("org_id=%d user=%s role=%s", orgID, user.Email, role))
+	return s.GetOrg(ctx, orgID)
+}
+
+// RemoveOrgMember removes a user from an organization.
+func (s *Service) RemoveOrgMember(ctx context.Context, orgID, userID int64, actor string) (Organization, error) {
+	org, err := s.GetOrg(ctx, orgID)
+	if err != nil {
+		return Organization{}, err
+	}
+	found := false
+	for _, m := range org.Members {
+		found = found || m.UserID == userID
+	}
+	if !found {
+		return Organization{}, ErrUserNotFound
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(
+		"DELETE FROM organization_members WHERE org_id = %d AND user_id = %d;", orgID, userID)); err != nil {
+		return Organization{}, fmt.Errorf("remove organization member: %w", err)
+	}
+	s.audit(ctx, actor, "iam.org.member.remove", fmt.Sprintf("org_id=%d user_id=%d", orgID, userID))
+	return s.GetOrg(ctx, orgID)
+}
+
+// AssignSite makes an organization the owner of a site, moving it from
+// any other organization.
+func (s *Service) AssignSite(ctx context.Context, orgID, siteID int64, actor string) (Organization, error) {
+	if _, err := s.GetOrg(ctx, orgID); err != nil {
+		return Organization{}, err
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf("SELECT id FROM sites WHERE id = %d LIMIT 1;", siteID))
+	if err != nil {
+		return Organization{}, fmt.Errorf("check site exists: %w", err)
+	}
+	if len(rows) == 0 {
+		return Organization{}, fmt.Errorf("invalid site id %d: site not found", siteID)
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO organization_sites(site_id, org_id, created_at) VALUES(%d,%d,%d)
+ON CONFLICT(site_id) DO UPDATE SET org_id=excluded.org_id;`,
+		siteID, orgID, time.Now().Unix())); err != nil {
+		return Organization{}, fmt.Errorf("assign site: %w", err)
+	}
+	s.audit(ctx, actor, "iam.org.site.assign", fmt.Sprintf("org_id=%d site_id=%d", orgID, siteID))
+	return s.GetOrg(ctx, orgID)
+}
+
+// UnassignSite takes a site away from an organization.
+func (s *Service) UnassignSite(ctx context.Context, orgID, siteID int64, actor string) (Organization, error) {
+	if _, err := s.GetOrg(ctx, orgID); err != nil {
+		return Organization{}, err
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(
+		"DELETE FROM organization_sites WHERE org_id = %d AND site_id = %d;", orgID, siteID)); err != nil {
+		return Organization{}, fmt.Errorf("unassign site: %w", err)
+	}
+	s.audit(ctx, actor, "iam.org.site.unassign", fmt.Sprintf("org_id=%d site_id=%d", orgID, siteID))
+	return s.GetOrg(ctx, orgID)
+}
+
+// OrgRole returns the role of u in an organization: RoleAdmin for admins
+// outside a narrowed API token,
+// the member role otherwise, or "" for non-members.
+func (s *Service) OrgRole(ctx context.Context, u User, orgID int64) (string, error) {
+	if u.AllowsAdmin() {
+		return RoleAdmin, nil
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT role FROM organization_members WHERE org_id = %d AND user_id = %d LIMIT 1;", orgID, u.ID))
+	if err != nil {
+		return "", fmt.Errorf("get organization role: %w", err)
+	}
+	if len(rows) == 0 {
+		return "", nil
+	}
+	role, _ := rows[0]["role"].(string)
+	return role, nil
+}
+
+// SiteRole returns the role through which u reaches a site: RoleAdmin for
+// admins, the role in the organization owning the site otherwise, or ""
+// when u has no access.
+func (s *Service) SiteRole(ctx context.Context, u User, siteID int64) (string, error) {
+	if u.Role == RoleAdmin {
+		return RoleAdmin, nil
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT m.role AS role FROM organization_sites os
+JOIN organization_members m ON m.org_id = os.org_id
+WHERE os.site_id = %d AND m.user_id = %d;`, siteID, u.ID))
+	if err != nil {
+		return "", fmt.Errorf("get site role: %w", err)
+	}
+	best := ""
+	for _, row := range rows {
+		role, _ := row["role"].(string)
+		if orgRoleRank[role] > orgRoleRank[best] {
+			best = role
+		}
+	}
+	return best, nil
+}
+
+// SiteContacts returns the addresses alerts about a site go to: owners
+// and developers of the organizations owning it and users granted access
+// to it directly.
+func (s *Service) SiteContacts(ctx context.Context, siteID int64) ([]string, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT u.email AS email FROM organization_sites os
+JOIN organization_members m ON m.org_id = os.org_id
+JOIN users u ON u.id = m.user_id
+WHERE os.site_id = %d AND m.role IN ('%s','%s')
+UNION
+SELECT u.email AS email FROM user_site_grants g
+JOIN users u ON u.id = g.user_id
+WHERE g.site_id = %d
+ORDER BY email;`, siteID, OrgRoleOwner, OrgRoleDeveloper, siteID))
+	if err != nil {
+		return nil, fmt.Errorf("list site contacts: %w", err)
+	}
+	emails := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if email, _ := row["email"].(string); email != "" {
+			emails = append(emails, email)
+		}
+	}
+	return emails, nil
+}
+
+func (s *Service) audit(ctx context.Context, actor, action, details string) {
+	_ = s.store.ExecAudit(ctx, fmt.Sprintf(
+		"INSERT INTO audit_events(actor, action, details, remote_ip, impersonator, created_at) VALUES('%s','%s','%s','%s','%s',%d);",
+		sqlEscape(actor), sqlEscape(action), sqlEscape(details),
+		sqlEscape(middleware.ClientIP(ctx)), sqlEscape(middleware.Impersonator(ctx)), time.Now().Unix()))
+}
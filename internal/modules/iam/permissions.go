@@ -0,0 +1,275 @@
+package iam
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Site actions are the unit of permission below roles. Organization roles
+// imply a set of them; grants and API token scopes name them directly.
+const (
+	// ActionRead reads a site and its settings.
+	ActionRead = "read"
+	// ActionWrite changes site settings not covered by a narrower action.
+	ActionWrite = "write"
+	// ActionCron manages cron jobs and workers, which both run commands
+	// as the site user.
+	ActionCron = "cron"
+	// ActionWordPress runs WordPress updates, hardening and scans.
+	ActionWordPress = "wordpress"
+	// ActionDatabases manages site databases and reads their credentials.
+	ActionDatabases = "databases"
+	// ActionCachePurge purges the CDN and page caches, the usual last step
+	// of a deploy.
+	ActionCachePurge = "cache.purge"
+	// ActionTerminal opens a shell as the site user.
+	ActionTerminal = "terminal"
+	// ActionAdmin covers hosting settings and panel administration. Only
+	// admins hold it; on a token it keeps the admin routes reachable.
+	ActionAdmin = "admin"
+)
+
+// siteActions are the actions a grant may name.
+var siteActions = []string{
+	ActionRead, ActionWrite, ActionCron, ActionWordPress,
+	ActionDatabases, ActionCachePurge, ActionTerminal,
+}
+
+// orgRoleActions are the actions implied by organization roles.
+var orgRoleActions = map[string][]string{
+	OrgRoleViewer:    {ActionRead},
+	OrgRoleDeveloper: siteActions,
+	OrgRoleOwner:     siteActions,
+}
+
+// Scope narrows what an API token may do to a subset of its owner's
+// access. Empty lists leave that dimension unrestricted.
+type Scope struct {
+	TokenID int64    `json:"token_id"`
+	SiteIDs []int64  `json:"site_ids,omitempty"`
+	Actions []string `json:"actions,omitempty"`
+}
+
+// allowsSite reports whether the scope covers siteID.
+func (sc *Scope) allowsSite(siteID int64) bool {
+	return sc == nil || len(sc.SiteIDs) == 0 || slices.Contains(sc.SiteIDs, siteID)
+}
+
+// allowsAction reports whether the scope covers action.
+func (sc *Scope) allowsAction(action string) bool {
+	return sc == nil || len(sc.Actions) == 0 || slices.Contains(sc.Actions, action)
+}
+
+// Narrowed reports whether the scope restricts its token at all.
+func (sc *Scope) Narrowed() bool {
+	return sc != nil && (len(sc.SiteIDs) > 0 || len(sc.Actions) > 0)
+}
+
+// AllowsAdmin reports whether u may use the admin routes: u is an admin
+// and, on an API token, the token is neither limited to sites nor to
+// actions other than ActionAdmin.
+func (u User) AllowsAdmin() bool {
+	if u.Role != RoleAdmin {
+		return false
+	}
+	return u.Scope == nil || (len(u.Scope.SiteIDs) == 0 && u.Scope.allowsAction(ActionAdmin))
+}
+
+// SiteGrant gives a user actions on one site outside any organization.
+type SiteGrant struct {
+	UserID    int64     `json:"user_id"`
+	SiteID    int64     `json:"site_id"`
+	Actions   []string  `json:"actions"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// validateActions normalizes an action list. allowAdmin admits
+// ActionAdmin, which only tokens may carry.
+func validateActions(actions []string, allowAdmin bool) ([]string, error) {
+	out := []string{}
+	for _, a := range actions {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if !slices.Contains(siteActions, a) && (!allowAdmin || a != ActionAdmin) {
+			return nil, fmt.Errorf("invalid action %q", a)
+		}
+		if !slices.Contains(out, a) {
+			out = append(out, a)
+		}
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+// GrantSite gives a user the listed actions on a site, replacing an
+// earlier grant on the same site.
+func (s *Service) GrantSite(ctx context.Context, userID, siteID int64, actions []string, actor string) (SiteGrant, error) {
+	actions, err := validateActions(actions, false)
+	if err != nil {
+		return SiteGrant{}, err
+	}
+	if len(actions) == 0 {
+		return SiteGrant{}, fmt.Errorf("at least one action is required")
+	}
+	if _, err := s.getUserByID(ctx, userID); err != nil {
+		return SiteGrant{}, err
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf("SELECT id FROM sites WHERE id = %d LIMIT 1;", siteID))
+	if err != nil {
+		return SiteGrant{}, fmt.Errorf("check site exists: %w", err)
+	}
+	if len(rows) == 0 {
+		return SiteGrant{}, fmt.Errorf("invalid site id %d: site not found", siteID)
+	}
+	now := time.Now().Unix()
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO user_site_grants(user_id, site_id, actions, created_at) VALUES(%d,%d,'%s',%d)
+ON CONFLICT(user_id, site_id) DO UPDATE SET actions=excluded.actions;`,
+		userID, siteID, sqlEscape(strings.Join(actions, ",")), now)); err != nil {
+		return SiteGrant{}, fmt.Errorf("grant site: %w", err)
+	}
+	s.audit(ctx, actor, "iam.grant.set", fmt.Sprintf("user_id=%d site_id=%d actions=%s", userID, siteID, strings.Join(actions, ",")))
+	return SiteGrant{UserID: userID, SiteID: siteID, Actions: actions, CreatedAt: time.Unix(now, 0).UTC()}, nil
+}
+
+// RevokeSite removes a user's grant on a site.
+func (s *Service) RevokeSite(ctx context.Context, userID, siteID int64, actor string) error {
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(
+		"DELETE FROM user_site_grants WHERE user_id = %d AND site_id = %d;", userID, siteID)); err != nil {
+		return fmt.Errorf("revoke site: %w", err)
+	}
+	s.audit(ctx, actor, "iam.grant.revoke", fmt.Sprintf("user_id=%d site_id=%d", userID, siteID))
+	return nil
+}
+
+// ListGrants returns the site grants of a user.
+func (s *Service) ListGrants(ctx context.Context, userID int64) ([]SiteGrant, error) {
+	if _, err := s.getUserByID(ctx, userID); err != nil {
+		return nil, err
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT site_id, actions, created_at FROM user_site_grants WHERE user_id = %d ORDER BY site_id;", userID))
+	if err != nil {
+		return nil, fmt.Errorf("list grants: %w", err)
+	}
+	grants := make([]SiteGrant, 0, len(rows))
+	for _, row := range rows {
+		siteID, err := toInt64(row["site_id"])
+		if err != nil {
+			return nil, fmt.Errorf("parse site id: %w", err)
+		}
+		actions, _ := row["actions"].(string)
+		created, _ := toInt64(row["created_at"])
+		grants = append(grants, SiteGrant{
+			UserID:    userID,
+			SiteID:    siteID,
+			Actions:   splitList(actions),
+			CreatedAt: time.Unix(created, 0).UTC(),
+		})
+	}
+	return grants, nil
+}
+
+// SiteActions returns what u may do on a site: every action for admins,
+// otherwise the union of the organization role and direct grants, cut
+// down to the API token scope. An empty set means u cannot see the site.
+func (s *Service) SiteActions(ctx context.Context, u User, siteID int64) (map[string]bool, error) {
+	allowed := map[string]bool{}
+	if !u.Scope.allowsSite(siteID) {
+		return allowed, nil
+	}
+	var actions []string
+	if u.Role == RoleAdmin {
+		actions = append(slices.Clone(siteActions), ActionAdmin)
+	} else {
+		role, err := s.SiteRole(ctx, u, siteID)
+		if err != nil {
+			return nil, err
+		}
+		actions = slices.Clone(orgRoleActions[role])
+		rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+			"SELECT actions FROM user_site_grants WHERE user_id = %d AND site_id = %d LIMIT 1;", u.ID, siteID))
+		if err != nil {
+			return nil, fmt.Errorf("get site grant: %w", err)
+		}
+		if len(rows) > 0 {
+			granted, _ := rows[0]["actions"].(string)
+			actions = append(actions, splitList(granted)...)
+		}
+	}
+	for _, a := range actions {
+		if u.Scope.allowsAction(a) {
+			allowed[a] = true
+		}
+	}
+	return allowed, nil
+}
+
+// VisibleSites returns the ids of the sites u can see. all is true when
+// u sees every site, as an admin without a site-limited token does.
+func (s *Service) VisibleSites(ctx context.Context, u User) (ids map[int64]bool, all bool, err error) {
+	if u.Role == RoleAdmin {
+		if u.Scope == nil || len(u.Scope.SiteIDs) == 0 {
+			return nil, true, nil
+		}
+		ids = map[int64]bool{}
+		for _, id := range u.Scope.SiteIDs {
+			ids[id] = true
+		}
+		return ids, false, nil
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT os.site_id AS site_id FROM organization_sites os
+JOIN organization_members m ON m.org_id = os.org_id
+WHERE m.user_id = %d
+UNION
+SELECT site_id FROM user_site_grants WHERE user_id = %d;`, u.ID, u.ID))
+	if err != nil {
+		return nil, false, fmt.Errorf("list user sites: %w", err)
+	}
+	ids = make(map[int64]bool, len(rows))
+	for _, row := range rows {
+		id, err := toInt64(row["site_id"])
+		if err != nil {
+			return nil, false, fmt.Errorf("parse site id: %w", err)
+		}
+		if u.Scope.allowsSite(id) {
+			ids[id] = true
+		}
+	}
+	return ids, false, nil
+}
+
+func (s *Service) getUserByID(ctx context.Context, id int64) (User, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT id, email, role FROM users WHERE id = %d LIMIT 1;", id))
+	if err != nil {
+		return User{}, fmt.Errorf("get user: %w", err)
+	}
+	if len(rows) == 0 {
+		return User{}, ErrUserNotFound
+	}
+	return mapRowToUser(rows[0])
+}
+
+// splitList parses the comma-separated lists stored in panel.db.
+func splitList(raw string) []string {
+	out := []string{}
+	for _, part := range strings.Split(raw, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func joinIDs(ids []int64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(parts, ",")
+}
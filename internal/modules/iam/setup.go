@@ -0,0 +1,104 @@
+package iam
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/middleware"
+)
+
+// setupTokenFile, under the data dir, holds the sha256 of the one-time
+// token the installer prints when it leaves the admin account to the
+// /setup wizard.
+const setupTokenFile = "setup-token"
+
+var (
+	// ErrSetupComplete indicates an admin already exists.
+	ErrSetupComplete = errors.New("setup already completed")
+	// ErrInvalidSetupToken indicates a missing or wrong setup token.
+	ErrInvalidSetupToken = errors.New("invalid setup token")
+)
+
+// SetupTokenPath returns where the setup token hash is kept.
+func SetupTokenPath(dataDir string) string {
+	return filepath.Join(dataDir, setupTokenFile)
+}
+
+// WriteSetupToken stores the hash of token so the panel can accept it on
+// /setup. Only the hash is written; the token itself is shown once.
+func WriteSetupToken(dataDir, token string) error {
+	if len(strings.TrimSpace(token)) < 32 {
+		return fmt.Errorf("setup token is too short")
+	}
+	if err := os.MkdirAll(dataDir, 0o750); err != nil {
+		return fmt.Errorf("create data dir: %w", err)
+	}
+	sum := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	if err := os.WriteFile(SetupTokenPath(dataDir), []byte(hex.EncodeToString(sum[:])+"\n"), 0o600); err != nil {
+		return fmt.Errorf("write setup token: %w", err)
+	}
+	return nil
+}
+
+// SetupRequired reports whether no user exists yet.
+func (s *Service) SetupRequired(ctx context.Context) (bool, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, `SELECT COUNT(*) AS n FROM users;`)
+	if err != nil {
+		return false, fmt.Errorf("count users: %w", err)
+	}
+	if len(rows) == 0 {
+		return true, nil
+	}
+	n, err := toInt64(rows[0]["n"])
+	if err != nil {
+		return false, fmt.Errorf("parse user count: %w", err)
+	}
+	return n == 0, nil
+}
+
+// CompleteSetup creates the first admin when token matches the installer's
+// setup token, consumes the token and signs the new admin in.
+func (s *Service) CompleteSetup(ctx context.Context, token, email, password string) (*Session, error) {
+	s.setupMu.Lock()
+	defer s.setupMu.Unlock()
+
+	required, err := s.SetupRequired(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !required {
+		return nil, ErrSetupComplete
+	}
+	path := SetupTokenPath(s.cfg.DataDir)
+	//nolint:gosec // G304: path is derived from the configured data dir.
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, ErrInvalidSetupToken
+	}
+	sum := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.TrimSpace(string(raw)))) != 1 {
+		s.log.Warn("setup attempt with invalid token", "remote_ip", middleware.ClientIP(ctx))
+		return nil, ErrInvalidSetupToken
+	}
+	if err := s.CreateAdmin(ctx, email, password); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		s.log.Error("remove setup token", "error", err.Error())
+	}
+	_ = s.store.ExecAudit(ctx, fmt.Sprintf(
+		"INSERT INTO audit_events(actor, action, details, remote_ip, created_at) VALUES('%s','auth.setup','first admin created','%s',%d);",
+		sqlEscape(strings.ToLower(strings.TrimSpace(email))),
+		sqlEscape(middleware.ClientIP(ctx)),
+		time.Now().Unix(),
+	))
+	return s.Login(ctx, email, password)
+}
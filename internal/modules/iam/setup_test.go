@@ -0,0 +1,61 @@
+package iam
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/logger"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+func TestIAM_CompleteSetupConsumesToken(t *testing.T) {
+	cfg := config.Config{
+		Env:               "test",
+		DataDir:           t.TempDir(),
+		SessionCookieName: "aipanel_session",
+		SessionTTL:        time.Hour,
+	}
+	store := sqlite.New(cfg.DataDir)
+	if err := store.Init(context.Background()); err != nil {
+		t.Fatalf("init sqlite: %v", err)
+	}
+	svc := NewService(store, cfg, logger.New("test"))
+	ctx := context.Background()
+	token := strings.Repeat("ab", 16)
+
+	if required, err := svc.SetupRequired(ctx); err != nil || !required {
+		t.Fatalf("expected setup to be required on an empty panel, got %t (%v)", required, err)
+	}
+	if _, err := svc.CompleteSetup(ctx, token, "admin@example.com", "supersecret123"); !errors.Is(err, ErrInvalidSetupToken) {
+		t.Fatalf("expected ErrInvalidSetupToken without a token file, got %v", err)
+	}
+	if err := WriteSetupToken(cfg.DataDir, token); err != nil {
+		t.Fatalf("write setup token: %v", err)
+	}
+	raw, err := os.ReadFile(SetupTokenPath(cfg.DataDir))
+	if err != nil || strings.Contains(string(raw), token) {
+		t.Fatalf("expected only the token hash on disk, got %q (%v)", raw, err)
+	}
+	if _, err := svc.CompleteSetup(ctx, "wrong-token", "admin@example.com", "supersecret123"); !errors.Is(err, ErrInvalidSetupToken) {
+		t.Fatalf("expected ErrInvalidSetupToken for a wrong token, got %v", err)
+	}
+
+	session, err := svc.CompleteSetup(ctx, token, "admin@example.com", "supersecret123")
+	if err != nil {
+		t.Fatalf("complete setup: %v", err)
+	}
+	if session.User.Email != "admin@example.com" || session.User.Role != "admin" {
+		t.Fatalf("unexpected session user: %+v", session.User)
+	}
+	if _, err := os.Stat(SetupTokenPath(cfg.DataDir)); !os.IsNotExist(err) {
+		t.Fatalf("expected setup token to be removed, got %v", err)
+	}
+	if _, err := svc.CompleteSetup(ctx, token, "other@example.com", "supersecret123"); !errors.Is(err, ErrSetupComplete) {
+		t.Fatalf("expected ErrSetupComplete once an admin exists, got %v", err)
+	}
+}
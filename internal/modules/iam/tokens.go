@@ -0,0 +1,216 @@
+package iam
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APITokenPrefix starts every API token, telling them apart from session
+// tokens in the Authorization header.
+const APITokenPrefix = "aipt_"
+
+// ErrTokenNotFound indicates an unknown or foreign API token id.
+var ErrTokenNotFound = errors.New("api token not found")
+
+// APIToken describes an API token; the secret is only returned once, by
+// CreateAPIToken.
+type APIToken struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"user_id"`
+	Name       string    `json:"name"`
+	SiteIDs    []int64   `json:"site_ids"`
+	Actions    []string  `json:"actions"`
+	ExpiresAt  time.Time `json:"expires_at,omitzero"`
+	LastUsedAt time.Time `json:"last_used_at,omitzero"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CreateAPITokenRequest names a token and narrows it. Empty SiteIDs and
+// Actions keep the owner's full access.
+type CreateAPITokenRequest struct {
+	Name          string   `json:"name"`
+	SiteIDs       []int64  `json:"site_ids"`
+	Actions       []string `json:"actions"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// CreateAPIToken issues a token for u and returns it with its secret. A
+// token never reaches more than its owner does: the scope only narrows.
+func (s *Service) CreateAPIToken(ctx context.Context, u User, req CreateAPITokenRequest) (APIToken, string, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return APIToken{}, "", fmt.Errorf("token name is required")
+	}
+	if len(name) > 128 {
+		return APIToken{}, "", fmt.Errorf("invalid token name: longer than 128 characters")
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > 3650 {
+		return APIToken{}, "", fmt.Errorf("invalid expires_in_days: must be between 0 and 3650")
+	}
+	actions, err := validateActions(req.Actions, true)
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	siteIDs := []int64{}
+	for _, id := range req.SiteIDs {
+		if id <= 0 {
+			return APIToken{}, "", fmt.Errorf("invalid site id %d", id)
+		}
+		if !slices.Contains(siteIDs, id) {
+			siteIDs = append(siteIDs, id)
+		}
+	}
+	slices.Sort(siteIDs)
+
+	raw, err := randomHex(32)
+	if err != nil {
+		return APIToken{}, "", fmt.Errorf("generate api token: %w", err)
+	}
+	secret := APITokenPrefix + raw
+	now := time.Now()
+	var expires int64
+	if req.ExpiresInDays > 0 {
+		expires = now.AddDate(0, 0, req.ExpiresInDays).Unix()
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO api_tokens(user_id, name, token_hash, site_ids, actions, expires_at, created_at)
+VALUES(%d,'%s','%s','%s','%s',%d,%d);`,
+		u.ID, sqlEscape(name), hashToken(secret), joinIDs(siteIDs),
+		sqlEscape(strings.Join(actions, ",")), expires, now.Unix())); err != nil {
+		return APIToken{}, "", fmt.Errorf("create api token: %w", err)
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT * FROM api_tokens WHERE token_hash = '%s' LIMIT 1;", hashToken(secret)))
+	if err != nil || len(rows) == 0 {
+		return APIToken{}, "", fmt.Errorf("read created api token: %w", err)
+	}
+	token, err := mapRowToAPIToken(rows[0])
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	s.audit(ctx, u.Email, "iam.token.create", fmt.Sprintf("token_id=%d name=%s sites=%s actions=%s",
+		token.ID, name, joinIDs(siteIDs), strings.Join(actions, ",")))
+	return token, secret, nil
+}
+
+// ListAPITokens returns the tokens of u.
+func (s *Service) ListAPITokens(ctx context.Context, u User) ([]APIToken, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT * FROM api_tokens WHERE user_id = %d ORDER BY id;", u.ID))
+	if err != nil {
+		return nil, fmt.Errorf("list api tokens: %w", err)
+	}
+	tokens := make([]APIToken, 0, len(rows))
+	for _, row := range rows {
+		token, err := mapRowToAPIToken(row)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// RevokeAPIToken deletes one of u's tokens.
+func (s *Service) RevokeAPIToken(ctx context.Context, u User, id int64) error {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT id FROM api_tokens WHERE id = %d AND user_id = %d LIMIT 1;", id, u.ID))
+	if err != nil {
+		return fmt.Errorf("get api token: %w", err)
+	}
+	if len(rows) == 0 {
+		return ErrTokenNotFound
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf("DELETE FROM api_tokens WHERE id = %d;", id)); err != nil {
+		return fmt.Errorf("revoke api token: %w", err)
+	}
+	// The cache is keyed by the secret, which is not stored.
+	s.sessions.Purge()
+	s.audit(ctx, u.Email, "iam.token.revoke", fmt.Sprintf("token_id=%d", id))
+	return nil
+}
+
+// authenticateAPIToken resolves an API token to its owner carrying the
+// token scope.
+func (s *Service) authenticateAPIToken(ctx context.Context, secret string) (User, error) {
+	now := time.Now().Unix()
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT t.id AS token_id, t.site_ids AS site_ids, t.actions AS actions, t.expires_at AS expires_at,
+       u.id AS id, u.email AS email, u.role AS role
+FROM api_tokens t
+JOIN users u ON u.id = t.user_id
+WHERE t.token_hash = '%s' AND (t.expires_at = 0 OR t.expires_at > %d)
+LIMIT 1;`, hashToken(secret), now))
+	if err != nil || len(rows) == 0 {
+		return User{}, ErrUnauthorized
+	}
+	u, err := mapRowToUser(rows[0])
+	if err != nil {
+		return User{}, ErrUnauthorized
+	}
+	tokenID, err := toInt64(rows[0]["token_id"])
+	if err != nil {
+		return User{}, ErrUnauthorized
+	}
+	siteIDs, _ := rows[0]["site_ids"].(string)
+	actions, _ := rows[0]["actions"].(string)
+	u.Scope = &Scope{TokenID: tokenID, SiteIDs: parseIDs(siteIDs), Actions: splitList(actions)}
+	_ = s.store.ExecPanel(ctx, fmt.Sprintf("UPDATE api_tokens SET last_used_at = %d WHERE id = %d;", now, tokenID))
+
+	deadline := time.Now().Add(sessionCacheTTL)
+	if expires, _ := toInt64(rows[0]["expires_at"]); expires > 0 && time.Unix(expires, 0).Before(deadline) {
+		deadline = time.Unix(expires, 0)
+	}
+	s.sessions.SetUntil(secret, u, deadline)
+	return u, nil
+}
+
+func mapRowToAPIToken(row map[string]any) (APIToken, error) {
+	id, err := toInt64(row["id"])
+	if err != nil {
+		return APIToken{}, fmt.Errorf("parse api token id: %w", err)
+	}
+	userID, _ := toInt64(row["user_id"])
+	name, _ := row["name"].(string)
+	siteIDs, _ := row["site_ids"].(string)
+	actions, _ := row["actions"].(string)
+	token := APIToken{
+		ID:      id,
+		UserID:  userID,
+		Name:    name,
+		SiteIDs: parseIDs(siteIDs),
+		Actions: splitList(actions),
+	}
+	if v, _ := toInt64(row["expires_at"]); v > 0 {
+		token.ExpiresAt = time.Unix(v, 0).UTC()
+	}
+	if v, _ := toInt64(row["last_used_at"]); v > 0 {
+		token.LastUsedAt = time.Unix(v, 0).UTC()
+	}
+	if v, _ := toInt64(row["created_at"]); v > 0 {
+		token.CreatedAt = time.Unix(v, 0).UTC()
+	}
+	return token, nil
+}
+
+func parseIDs(raw string) []int64 {
+	ids := []int64{}
+	for _, part := range splitList(raw) {
+		if id, err := strconv.ParseInt(part, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
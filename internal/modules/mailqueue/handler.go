@@ -0,0 +1,114 @@
+package mailqueue
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/platform/jsonstream"
+)
+
+// Handler exposes HTTP handlers for the mail queue and delivery log.
+type Handler struct {
+	svc *Service
+}
+
+// NewHandler creates mail queue HTTP handler.
+func NewHandler(svc *Service) *Handler {
+	return &Handler{svc: svc}
+}
+
+// HandleQueue serves GET /api/mail/queue and POST /api/mail/queue/flush.
+// The flush body may name one message: {"queue_id": "..."}.
+func (h *Handler) HandleQueue(w http.ResponseWriter, r *http.Request, actor string) {
+	switch {
+	case r.URL.Path == "/api/mail/queue" && r.Method == http.MethodGet:
+		messages, err := h.svc.ListQueue(r.Context())
+		if err != nil {
+			writeMailError(w, "failed to list mail queue", err)
+			return
+		}
+		jsonstream.List(w, r, "messages", messages)
+	case r.URL.Path == "/api/mail/queue/flush" && r.Method == http.MethodPost:
+		var req struct {
+			QueueID string `json:"queue_id"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+		}
+		if err := h.svc.Flush(r.Context(), strings.TrimSpace(req.QueueID), actor); err != nil {
+			writeMailError(w, "failed to flush mail queue", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.URL.Path == "/api/mail/queue" || r.URL.Path == "/api/mail/queue/flush":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		h.handleMessage(w, r, actor)
+	}
+}
+
+// handleMessage serves DELETE /api/mail/queue/{queue_id}.
+func (h *Handler) handleMessage(w http.ResponseWriter, r *http.Request, actor string) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/mail/queue/"), "/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := h.svc.Delete(r.Context(), id, actor); err != nil {
+		writeMailError(w, "failed to delete queued message", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleLog serves GET /api/mail/log?q=&status=&queue_id=&limit=.
+func (h *Handler) HandleLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	q := LogQuery{
+		Query:   query.Get("q"),
+		Status:  query.Get("status"),
+		QueueID: query.Get("queue_id"),
+	}
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		q.Limit = limit
+	}
+	entries, err := h.svc.SearchLog(r.Context(), q)
+	if err != nil {
+		writeMailError(w, "failed to search mail log", err)
+		return
+	}
+	jsonstream.List(w, r, "entries", entries)
+}
+
+func writeMailError(w http.ResponseWriter, prefix string, err error) {
+	switch {
+	case errors.Is(err, ErrNotInstalled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrMessageNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case strings.Contains(err.Error(), "invalid"):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, prefix+": "+err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,164 @@
+package mailqueue
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLogLimit = 100
+	maxLogLimit     = 1000
+	// journalLines bounds how much of the journal is searched when Postfix
+	// logs there instead of mail.log.
+	journalLines = "50000"
+)
+
+// Delivery statuses logged by the Postfix delivery agents.
+var logStatuses = []string{"sent", "bounced", "deferred", "expired", "undeliverable"}
+
+var (
+	// postfixLine splits "<time> <host> postfix/<agent>[pid]: <message>";
+	// multi-instance setups log as postfix-<name>/<agent>.
+	postfixLine  = regexp.MustCompile(`^(.+?) \S+ postfix[^/\s]*/(?:[\w-]+/)?([\w-]+)\[\d+\]: (.*)$`)
+	deliveryLine = regexp.MustCompile(`^([0-9A-Za-z]+): to=<([^>]*)>,(.*)$`)
+	senderLine   = regexp.MustCompile(`^([0-9A-Za-z]+): from=<([^>]*)>`)
+	removedLine  = regexp.MustCompile(`^([0-9A-Za-z]+): removed$`)
+	relayField   = regexp.MustCompile(`\brelay=([^,\s]+)`)
+	dsnField     = regexp.MustCompile(`\bdsn=([^,\s]+)`)
+	statusField  = regexp.MustCompile(`\bstatus=(\w+)(?: \((.*)\))?$`)
+)
+
+// SearchLog returns the newest delivery attempts matching q, newest first.
+// It reads mail.log, or the journal on hosts without rsyslog.
+func (s *Service) SearchLog(ctx context.Context, q LogQuery) ([]LogEntry, error) {
+	if !s.Installed() {
+		return nil, ErrNotInstalled
+	}
+	if q.Status != "" && !slices.Contains(logStatuses, q.Status) {
+		return nil, fmt.Errorf("invalid status: expected one of %s", strings.Join(logStatuses, ", "))
+	}
+	if q.QueueID != "" && !queueIDPattern.MatchString(q.QueueID) {
+		return nil, fmt.Errorf("invalid queue id")
+	}
+	switch {
+	case q.Limit <= 0:
+		q.Limit = defaultLogLimit
+	case q.Limit > maxLogLimit:
+		q.Limit = maxLogLimit
+	}
+
+	f, err := os.Open(s.logPath)
+	if err == nil {
+		defer func() {
+			_ = f.Close()
+		}()
+		return scanLog(ctx, f, q, time.Now())
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("open mail log: %w", err)
+	}
+	out, err := s.runner.Run(ctx, "journalctl", "--no-pager", "--quiet", "--output=short-iso",
+		"SYSLOG_FACILITY=2", "--lines="+journalLines)
+	if err != nil {
+		return nil, fmt.Errorf("read mail journal: %w: %s", err, strings.TrimSpace(out))
+	}
+	return scanLog(ctx, strings.NewReader(out), q, time.Now())
+}
+
+// scanLog keeps the last q.Limit matching delivery lines of r. Senders are
+// logged by qmgr on a separate line and joined by queue id.
+func scanLog(ctx context.Context, r io.Reader, q LogQuery, now time.Time) ([]LogEntry, error) {
+	senders := map[string]string{}
+	needle := strings.ToLower(strings.TrimSpace(q.Query))
+	var entries []LogEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for n := 0; scanner.Scan(); n++ {
+		if n%10000 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		line := scanner.Text()
+		m := postfixLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		msg := m[3]
+		if sm := senderLine.FindStringSubmatch(msg); sm != nil {
+			senders[sm[1]] = sm[2]
+			continue
+		}
+		if rm := removedLine.FindStringSubmatch(msg); rm != nil {
+			delete(senders, rm[1])
+			continue
+		}
+		dm := deliveryLine.FindStringSubmatch(msg)
+		if dm == nil {
+			continue
+		}
+		entry := LogEntry{
+			Time:      parseLogTime(m[1], now),
+			QueueID:   dm[1],
+			Process:   m[2],
+			Sender:    senders[dm[1]],
+			Recipient: dm[2],
+		}
+		if f := relayField.FindStringSubmatch(dm[3]); f != nil {
+			entry.Relay = f[1]
+		}
+		if f := dsnField.FindStringSubmatch(dm[3]); f != nil {
+			entry.DSN = f[1]
+		}
+		if f := statusField.FindStringSubmatch(dm[3]); f != nil {
+			entry.Status = f[1]
+			entry.Detail = f[2]
+		}
+		if entry.Status == "" ||
+			(q.Status != "" && entry.Status != q.Status) ||
+			(q.QueueID != "" && entry.QueueID != q.QueueID) ||
+			(needle != "" && !strings.Contains(strings.ToLower(line), needle) && !strings.Contains(strings.ToLower(entry.Sender), needle)) {
+			continue
+		}
+		entries = append(entries, entry)
+		if len(entries) > 2*q.Limit {
+			entries = slices.Clone(entries[len(entries)-q.Limit:])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read mail log: %w", err)
+	}
+	if len(entries) > q.Limit {
+		entries = entries[len(entries)-q.Limit:]
+	}
+	slices.Reverse(entries)
+	if entries == nil {
+		entries = []LogEntry{}
+	}
+	return entries, nil
+}
+
+// parseLogTime reads RFC 3339 timestamps (rsyslog high precision format,
+// journalctl short-iso) and classic syslog ones, which lack the year.
+func parseLogTime(raw string, now time.Time) time.Time {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05-0700"} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t.UTC()
+		}
+	}
+	t, err := time.ParseInLocation(time.Stamp, raw, now.Location())
+	if err != nil {
+		return time.Time{}
+	}
+	t = t.AddDate(now.Year(), 0, 0)
+	// A December line read in January belongs to the previous year.
+	if t.After(now.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t.UTC()
+}
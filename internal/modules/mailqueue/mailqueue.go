@@ -0,0 +1,3 @@
+// Package mailqueue exposes the Postfix queue and delivery log of the host,
+// so bounced and deferred mail can be debugged from the panel.
+package mailqueue
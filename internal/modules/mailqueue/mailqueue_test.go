@@ -0,0 +1,179 @@
+package mailqueue
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+type fakeRunner struct {
+	commands []string
+	outputs  map[string]string
+}
+
+func (r *fakeRunner) Run(_ context.Context, name string, args ...string) (string, error) {
+	cmd := strings.TrimSpace(name + " " + strings.Join(args, " "))
+	r.commands = append(r.commands, cmd)
+	return r.outputs[cmd], nil
+}
+
+func newTestService(t *testing.T, runner *fakeRunner, logBody string) *Service {
+	t.Helper()
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	dir := t.TempDir()
+	postqueue := filepath.Join(dir, "postqueue")
+	if err := os.WriteFile(postqueue, nil, 0o755); err != nil {
+		t.Fatalf("write postqueue: %v", err)
+	}
+	logPath := filepath.Join(dir, "mail.log")
+	if logBody != "" {
+		if err := os.WriteFile(logPath, []byte(logBody), 0o644); err != nil {
+			t.Fatalf("write mail log: %v", err)
+		}
+	}
+	return NewService(store, nil, runner, Options{
+		PostqueueBin: postqueue,
+		PostsuperBin: "/usr/sbin/postsuper",
+		LogPath:      logPath,
+	})
+}
+
+func TestService_NotInstalled(t *testing.T) {
+	svc := NewService(nil, nil, &fakeRunner{}, Options{PostqueueBin: filepath.Join(t.TempDir(), "missing")})
+	if _, err := svc.ListQueue(context.Background()); !errors.Is(err, ErrNotInstalled) {
+		t.Fatalf("expected ErrNotInstalled, got %v", err)
+	}
+	if _, err := svc.SearchLog(context.Background(), LogQuery{}); !errors.Is(err, ErrNotInstalled) {
+		t.Fatalf("expected ErrNotInstalled, got %v", err)
+	}
+}
+
+func TestService_QueueListFlushDelete(t *testing.T) {
+	ctx := context.Background()
+	runner := &fakeRunner{outputs: map[string]string{}}
+	svc := newTestService(t, runner, "")
+	runner.outputs[svc.postqueueBin+" -j"] = `postqueue: warning: something
+{"queue_name":"deferred","queue_id":"4BC2D1A0F3","arrival_time":1760600000,"message_size":2077,"sender":"app@example.com","recipients":[{"address":"user@gmail.com","delay_reason":"connect to gmail-smtp-in.l.google.com: Connection timed out"}]}
+{"queue_name":"active","queue_id":"1A2B3C4D5E","arrival_time":1760500000,"message_size":512,"sender":"","recipients":[{"address":"bounce@example.org"}]}
+`
+	runner.outputs["/usr/sbin/postsuper -d 4BC2D1A0F3"] = "postsuper: 4BC2D1A0F3: removed\npostsuper: Deleted: 1 message\n"
+	runner.outputs["/usr/sbin/postsuper -d AAAAAAAAAA"] = "postsuper: Deleted: 0 messages\n"
+
+	messages, err := svc.ListQueue(ctx)
+	if err != nil {
+		t.Fatalf("list queue: %v", err)
+	}
+	if len(messages) != 2 || messages[0].ID != "1A2B3C4D5E" || messages[1].Queue != "deferred" ||
+		!strings.Contains(messages[1].Recipients[0].DelayReason, "timed out") {
+		t.Fatalf("unexpected queue: %+v", messages)
+	}
+
+	if err := svc.Flush(ctx, "", "admin@example.com"); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if err := svc.Flush(ctx, "4BC2D1A0F3", "admin@example.com"); err != nil {
+		t.Fatalf("flush message: %v", err)
+	}
+	if err := svc.Delete(ctx, "4BC2D1A0F3", "admin@example.com"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := svc.Delete(ctx, "AAAAAAAAAA", "admin@example.com"); !errors.Is(err, ErrMessageNotFound) {
+		t.Fatalf("expected ErrMessageNotFound, got %v", err)
+	}
+	if err := svc.Delete(ctx, "ALL", "admin@example.com"); err == nil || !strings.Contains(err.Error(), "invalid") {
+		t.Fatalf("expected ALL to be rejected, got %v", err)
+	}
+	want := []string{
+		svc.postqueueBin + " -j",
+		svc.postqueueBin + " -f",
+		svc.postqueueBin + " -i 4BC2D1A0F3",
+		"/usr/sbin/postsuper -d 4BC2D1A0F3",
+		"/usr/sbin/postsuper -d AAAAAAAAAA",
+	}
+	if strings.Join(runner.commands, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected commands:\n%s", strings.Join(runner.commands, "\n"))
+	}
+}
+
+const testMailLog = `2026-10-16T09:00:00.123456+02:00 mx postfix/pickup[100]: 4BC2D1A0F3: uid=33 from=<www-data>
+2026-10-16T09:00:00.200000+02:00 mx postfix/qmgr[101]: 4BC2D1A0F3: from=<app@example.com>, size=2077, nrcpt=1 (queue active)
+2026-10-16T09:00:01.000000+02:00 mx postfix/smtp[102]: 4BC2D1A0F3: to=<nobody@gmail.com>, relay=gmail-smtp-in.l.google.com[142.250.1.1]:25, delay=1.2, delays=0.1/0/0.5/0.6, dsn=5.1.1, status=bounced (host gmail-smtp-in.l.google.com[142.250.1.1] said: 550 5.1.1 The email account that you tried to reach does not exist. (in reply to RCPT TO command))
+2026-10-16T09:00:01.100000+02:00 mx postfix/qmgr[101]: 4BC2D1A0F3: removed
+2026-10-16T09:05:00.000000+02:00 mx postfix/qmgr[101]: 1A2B3C4D5E: from=<shop@example.com>, size=900, nrcpt=1 (queue active)
+2026-10-16T09:05:02.000000+02:00 mx postfix/smtp[103]: 1A2B3C4D5E: to=<buyer@example.org>, relay=mx.example.org[192.0.2.10]:25, delay=2, delays=0/0/1/1, dsn=2.0.0, status=sent (250 2.0.0 Ok: queued as 9F8E7D)
+2026-10-16T09:06:00.000000+02:00 mx dovecot: imap-login: Login: user=<x>
+`
+
+func TestService_SearchLog(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t, &fakeRunner{}, testMailLog)
+
+	entries, err := svc.SearchLog(ctx, LogQuery{})
+	if err != nil {
+		t.Fatalf("search log: %v", err)
+	}
+	if len(entries) != 2 || entries[0].QueueID != "1A2B3C4D5E" || entries[0].Status != "sent" {
+		t.Fatalf("expected newest first, got %+v", entries)
+	}
+	bounced := entries[1]
+	if bounced.Sender != "app@example.com" || bounced.Recipient != "nobody@gmail.com" || bounced.DSN != "5.1.1" ||
+		bounced.Process != "smtp" || !strings.Contains(bounced.Detail, "does not exist") ||
+		!bounced.Time.Equal(time.Date(2026, 10, 16, 7, 0, 1, 0, time.UTC)) {
+		t.Fatalf("unexpected bounce entry: %+v", bounced)
+	}
+
+	for _, tc := range []struct {
+		q    LogQuery
+		want int
+	}{
+		{LogQuery{Status: "bounced"}, 1},
+		{LogQuery{Query: "SHOP@example.com"}, 1},
+		{LogQuery{Query: "550 5.1.1"}, 1},
+		{LogQuery{QueueID: "1A2B3C4D5E"}, 1},
+		{LogQuery{Limit: 1}, 1},
+		{LogQuery{Query: "nothing-matches"}, 0},
+	} {
+		entries, err := svc.SearchLog(ctx, tc.q)
+		if err != nil || len(entries) != tc.want {
+			t.Fatalf("query %+v: expected %d entries, got %+v (%v)", tc.q, tc.want, entries, err)
+		}
+	}
+	if _, err := svc.SearchLog(ctx, LogQuery{Status: "lost"}); err == nil || !strings.Contains(err.Error(), "invalid status") {
+		t.Fatalf("expected invalid status, got %v", err)
+	}
+}
+
+func TestService_SearchLogFallsBackToJournal(t *testing.T) {
+	runner := &fakeRunner{outputs: map[string]string{
+		"journalctl --no-pager --quiet --output=short-iso SYSLOG_FACILITY=2 --lines=" + journalLines: "2026-10-16T09:05:02+0000 mx postfix/smtp[103]: 1A2B3C4D5E: to=<buyer@example.org>, relay=none, delay=30, dsn=4.4.1, status=deferred (connect to mx.example.org[192.0.2.10]:25: Connection refused)\n",
+	}}
+	svc := newTestService(t, runner, "")
+	entries, err := svc.SearchLog(context.Background(), LogQuery{})
+	if err != nil {
+		t.Fatalf("search journal: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Status != "deferred" || entries[0].Relay != "none" ||
+		!entries[0].Time.Equal(time.Date(2026, 10, 16, 9, 5, 2, 0, time.UTC)) {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+}
+
+func TestParseLogTime_ClassicSyslog(t *testing.T) {
+	now := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	if got := parseLogTime("Dec 31 23:59:00", now); got.Year() != 2025 {
+		t.Fatalf("expected previous year, got %v", got)
+	}
+	if got := parseLogTime("Jan  2 09:00:00", now); !got.Equal(time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected time: %v", got)
+	}
+}
@@ -0,0 +1,42 @@
+package mailqueue
+
+import "time"
+
+// QueuedMessage is one message in the Postfix queue.
+type QueuedMessage struct {
+	ID         string      `json:"id"`
+	Queue      string      `json:"queue"`
+	ArrivedAt  time.Time   `json:"arrived_at"`
+	Size       int64       `json:"size"`
+	Sender     string      `json:"sender"`
+	Recipients []Recipient `json:"recipients"`
+}
+
+// Recipient is a pending recipient of a queued message. DelayReason says
+// why the last delivery attempt failed.
+type Recipient struct {
+	Address     string `json:"address"`
+	DelayReason string `json:"delay_reason,omitempty"`
+}
+
+// LogEntry is one delivery attempt from the mail log.
+type LogEntry struct {
+	Time      time.Time `json:"time"`
+	QueueID   string    `json:"queue_id"`
+	Process   string    `json:"process"`
+	Sender    string    `json:"sender,omitempty"`
+	Recipient string    `json:"recipient"`
+	Relay     string    `json:"relay,omitempty"`
+	DSN       string    `json:"dsn,omitempty"`
+	Status    string    `json:"status"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+// LogQuery filters the delivery log. Query matches case-insensitively
+// anywhere in the log line, e.g. an address or a remote error.
+type LogQuery struct {
+	Query   string
+	Status  string
+	QueueID string
+	Limit   int
+}
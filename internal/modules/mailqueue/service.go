@@ -0,0 +1,191 @@
+package mailqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/middleware"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+	"github.com/robsonek/aiPanel/internal/platform/systemd"
+)
+
+const (
+	defaultPostqueueBin = "/usr/sbin/postqueue"
+	defaultPostsuperBin = "/usr/sbin/postsuper"
+	defaultLogPath      = "/var/log/mail.log"
+)
+
+var (
+	// ErrNotInstalled indicates a host without Postfix.
+	ErrNotInstalled = errors.New("mail module is not installed")
+	// ErrMessageNotFound indicates a queue id that is not in the queue.
+	ErrMessageNotFound = errors.New("queued message not found")
+)
+
+// queueIDPattern matches short (hex) and long Postfix queue ids. It also
+// rejects "ALL", which postsuper -d reads as the whole queue.
+var queueIDPattern = regexp.MustCompile(`^[0-9A-Za-z]{6,32}$`)
+
+// Options overrides binary and log locations, mainly for tests.
+type Options struct {
+	PostqueueBin string
+	PostsuperBin string
+	LogPath      string
+}
+
+// Service reads and manages the Postfix queue through postqueue/postsuper.
+type Service struct {
+	store  *sqlite.Store
+	log    *slog.Logger
+	runner systemd.Runner
+
+	postqueueBin string
+	postsuperBin string
+	logPath      string
+}
+
+// NewService creates a mail queue service.
+func NewService(store *sqlite.Store, log *slog.Logger, runner systemd.Runner, opts Options) *Service {
+	if log == nil {
+		log = slog.Default()
+	}
+	if runner == nil {
+		runner = systemd.ExecRunner{}
+	}
+	if opts.PostqueueBin == "" {
+		opts.PostqueueBin = defaultPostqueueBin
+	}
+	if opts.PostsuperBin == "" {
+		opts.PostsuperBin = defaultPostsuperBin
+	}
+	if opts.LogPath == "" {
+		opts.LogPath = defaultLogPath
+	}
+	return &Service{
+		store:        store,
+		log:          log,
+		runner:       runner,
+		postqueueBin: opts.PostqueueBin,
+		postsuperBin: opts.PostsuperBin,
+		logPath:      opts.LogPath,
+	}
+}
+
+// Installed reports whether Postfix is installed on the host.
+func (s *Service) Installed() bool {
+	_, err := os.Stat(s.postqueueBin)
+	return err == nil
+}
+
+// ListQueue returns the messages in the active, incoming, deferred and
+// hold queues, oldest first.
+func (s *Service) ListQueue(ctx context.Context) ([]QueuedMessage, error) {
+	if !s.Installed() {
+		return nil, ErrNotInstalled
+	}
+	out, err := s.runner.Run(ctx, s.postqueueBin, "-j")
+	if err != nil {
+		return nil, fmt.Errorf("postqueue -j: %w: %s", err, strings.TrimSpace(out))
+	}
+	messages := []QueuedMessage{}
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		// postqueue prints warnings next to the JSON lines.
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		var raw struct {
+			QueueName   string      `json:"queue_name"`
+			QueueID     string      `json:"queue_id"`
+			ArrivalTime int64       `json:"arrival_time"`
+			MessageSize int64       `json:"message_size"`
+			Sender      string      `json:"sender"`
+			Recipients  []Recipient `json:"recipients"`
+		}
+		if err := json.Unmarshal([]byte(line), &raw); err != nil {
+			return nil, fmt.Errorf("decode postqueue output: %w", err)
+		}
+		messages = append(messages, QueuedMessage{
+			ID:         raw.QueueID,
+			Queue:      raw.QueueName,
+			ArrivedAt:  time.Unix(raw.ArrivalTime, 0).UTC(),
+			Size:       raw.MessageSize,
+			Sender:     raw.Sender,
+			Recipients: raw.Recipients,
+		})
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].ArrivedAt.Before(messages[j].ArrivedAt)
+	})
+	return messages, nil
+}
+
+// Flush asks Postfix to retry delivery now: of one message when id is
+// set, otherwise of the whole deferred queue.
+func (s *Service) Flush(ctx context.Context, id, actor string) error {
+	if !s.Installed() {
+		return ErrNotInstalled
+	}
+	args := []string{"-f"}
+	if id != "" {
+		if !queueIDPattern.MatchString(id) {
+			return fmt.Errorf("invalid queue id")
+		}
+		args = []string{"-i", id}
+	}
+	if out, err := s.runner.Run(ctx, s.postqueueBin, args...); err != nil {
+		if id != "" && strings.Contains(out, "not found") {
+			return ErrMessageNotFound
+		}
+		return fmt.Errorf("postqueue %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(out))
+	}
+	_ = s.writeAudit(ctx, actor, "mail.queue.flush", "queue_id="+id)
+	return nil
+}
+
+// Delete removes one message from the queue without delivering it.
+func (s *Service) Delete(ctx context.Context, id, actor string) error {
+	if !s.Installed() {
+		return ErrNotInstalled
+	}
+	if !queueIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid queue id")
+	}
+	out, err := s.runner.Run(ctx, s.postsuperBin, "-d", id)
+	if err != nil {
+		return fmt.Errorf("postsuper -d: %w: %s", err, strings.TrimSpace(out))
+	}
+	// postsuper exits 0 for unknown ids and only reports the count.
+	if strings.Contains(out, "Deleted: 0 messages") {
+		return ErrMessageNotFound
+	}
+	_ = s.writeAudit(ctx, actor, "mail.queue.delete", "queue_id="+id)
+	return nil
+}
+
+func (s *Service) writeAudit(ctx context.Context, actor, action, details string) error {
+	if strings.TrimSpace(actor) == "" {
+		actor = "system"
+	}
+	return s.store.ExecAudit(ctx, fmt.Sprintf(
+		"INSERT INTO audit_events(actor, action, details, remote_ip, impersonator, created_at) VALUES('%s','%s','%s','%s','%s',%d);",
+		sqlEscape(actor),
+		sqlEscape(action),
+		sqlEscape(details),
+		sqlEscape(middleware.ClientIP(ctx)),
+		sqlEscape(middleware.Impersonator(ctx)),
+		time.Now().Unix(),
+	))
+}
+
+func sqlEscape(in string) string {
+	return strings.ReplaceAll(in, "'", "''")
+}
@@ -0,0 +1,176 @@
+package migration
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/modules/hosting"
+)
+
+// Handler exposes the receiving end of site migrations.
+type Handler struct {
+	svc *Service
+}
+
+// NewHandler creates migration HTTP handler.
+func NewHandler(svc *Service) *Handler {
+	return &Handler{svc: svc}
+}
+
+// HandleMigrations serves incoming site migrations (admin only; the
+// source authenticates with an API token):
+//
+//	GET  /api/migrations                   list
+//	POST /api/migrations                   start or resume {"domain", "php_version", "source"}
+//	GET  /api/migrations/{id}              one migration
+//	POST /api/migrations/{id}/plan         {"entries": [...], "final": bool} files to send
+//	PUT  /api/migrations/{id}/files        one piece (?path=&offset=&size=&mtime=&mode=&sha256=)
+//	PUT  /api/migrations/{id}/dumps        one piece of a database dump (same query)
+//	POST /api/migrations/{id}/databases    {"db_name", "db_engine", "dump"} import a dump
+//	POST /api/migrations/{id}/complete     finish and reprovision the site
+func (h *Handler) HandleMigrations(w http.ResponseWriter, r *http.Request, actor string) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/migrations"), "/")
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet:
+			items, err := h.svc.List(r.Context())
+			if err != nil {
+				writeMigrationError(w, "failed to list migrations", err)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"items": items})
+		case http.MethodPost:
+			var req StartRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+			m, err := h.svc.Start(r.Context(), req, actor)
+			if err != nil {
+				writeMigrationError(w, "failed to start migration", err)
+				return
+			}
+			writeJSON(w, http.StatusCreated, m)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	parts := strings.Split(rest, "/")
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || id <= 0 || len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+	sub := ""
+	if len(parts) == 2 {
+		sub = parts[1]
+	}
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		m, err := h.svc.Get(r.Context(), id)
+		if err != nil {
+			writeMigrationError(w, "failed to get migration", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, m)
+	case sub == "plan" && r.Method == http.MethodPost:
+		var req PlanRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		plan, err := h.svc.Plan(r.Context(), id, req)
+		if err != nil {
+			writeMigrationError(w, "failed to plan migration", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, plan)
+	case (sub == "files" || sub == "dumps") && r.Method == http.MethodPut:
+		p, err := pieceFromQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		receive := h.svc.ReceiveFile
+		if sub == "dumps" {
+			receive = h.svc.ReceiveDump
+		}
+		res, err := receive(r.Context(), id, p, r.Body)
+		if errors.Is(err, ErrOffsetMismatch) {
+			writeJSON(w, http.StatusConflict, res)
+			return
+		}
+		if err != nil {
+			writeMigrationError(w, "failed to receive upload", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	case sub == "databases" && r.Method == http.MethodPost:
+		var req DatabaseRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		res, err := h.svc.ImportDatabase(r.Context(), id, req, actor)
+		if err != nil {
+			writeMigrationError(w, "failed to import database", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	case sub == "complete" && r.Method == http.MethodPost:
+		m, err := h.svc.Complete(r.Context(), id, actor)
+		if err != nil {
+			writeMigrationError(w, "failed to complete migration", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, m)
+	case sub == "" || sub == "plan" || sub == "files" || sub == "dumps" || sub == "databases" || sub == "complete":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func pieceFromQuery(r *http.Request) (Piece, error) {
+	q := r.URL.Query()
+	p := Piece{Path: q.Get("path"), SHA256: q.Get("sha256")}
+	var err error
+	num := func(key string) int64 {
+		n, parseErr := strconv.ParseInt(q.Get(key), 10, 64)
+		if parseErr != nil && err == nil {
+			err = errors.New("invalid piece: " + key + " must be an integer")
+		}
+		return n
+	}
+	p.Offset, p.Size, p.ModTime = num("offset"), num("size"), num("mtime")
+	mode, parseErr := strconv.ParseUint(q.Get("mode"), 8, 32)
+	if parseErr != nil && err == nil {
+		err = errors.New("invalid piece: mode must be octal")
+	}
+	p.Mode = fs.FileMode(mode).Perm()
+	return p, err
+}
+
+func writeMigrationError(w http.ResponseWriter, msg string, err error) {
+	switch {
+	case errors.Is(err, ErrMigrationNotFound), errors.Is(err, hosting.ErrSiteNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrSiteExists), errors.Is(err, ErrAlreadyMigrated):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case strings.Contains(err.Error(), "invalid"):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, msg+": "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,721 @@
+// Package migration moves a site to another aiPanel instance over its API.
+// The source walks the docroot and sends what the target lacks in
+// resumable pieces, then runs a final delta pass together with database
+// dumps; the target recreates the site, databases and files.
+package migration
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/modules/backup"
+	"github.com/robsonek/aiPanel/internal/modules/database"
+	"github.com/robsonek/aiPanel/internal/modules/hosting"
+	"github.com/robsonek/aiPanel/internal/platform/middleware"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+// Migration statuses on the target.
+const (
+	StatusReceiving = "receiving"
+	StatusCompleted = "completed"
+)
+
+// stagingDir holds partial uploads next to the docroot, on the same file
+// system, so a finished file is renamed into place.
+const stagingDir = ".aipanel-migration"
+
+var (
+	// ErrMigrationNotFound indicates an unknown migration id.
+	ErrMigrationNotFound = errors.New("migration not found")
+	// ErrSiteExists indicates the target already serves the domain.
+	ErrSiteExists = errors.New("target already has a site for this domain")
+	// ErrAlreadyMigrated indicates a completed migration of the domain.
+	ErrAlreadyMigrated = errors.New("site was already migrated")
+	// ErrOffsetMismatch indicates a piece that does not continue the
+	// staged upload; the target reports the offset to resume from.
+	ErrOffsetMismatch = errors.New("upload offset does not match the staged file")
+)
+
+var (
+	sha256Pattern   = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	dumpNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+)
+
+// Sites is the part of the hosting service a migration uses on either end.
+type Sites interface {
+	ListSites(ctx context.Context) ([]hosting.Site, error)
+	GetSite(ctx context.Context, id int64) (hosting.Site, error)
+	CreateSite(ctx context.Context, req hosting.CreateSiteRequest) (hosting.Site, error)
+	ReprovisionSite(ctx context.Context, id int64, actor string) (hosting.Site, error)
+}
+
+// Databases is the part of the database service a migration uses on
+// either end.
+type Databases interface {
+	ListDatabases(ctx context.Context, siteID int64) ([]database.SiteDatabase, error)
+	CreateDatabase(ctx context.Context, req database.CreateDatabaseRequest) (database.CreateDatabaseResult, error)
+	ExportDatabase(ctx context.Context, id int64, dir string) (string, error)
+	ImportDatabase(ctx context.Context, id int64, src, actor string) error
+}
+
+// Migration is an incoming site on the target panel.
+type Migration struct {
+	ID        int64     `json:"id"`
+	Domain    string    `json:"domain"`
+	SiteID    int64     `json:"site_id"`
+	Source    string    `json:"source"`
+	Status    string    `json:"status"`
+	Files     int64     `json:"files"`
+	Bytes     int64     `json:"bytes"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// StartRequest opens a migration on the target.
+type StartRequest struct {
+	Domain     string `json:"domain"`
+	PHPVersion string `json:"php_version"`
+	Source     string `json:"source"`
+}
+
+// Entry is one directory, regular file or symlink of the source docroot.
+// Path is slash-separated and relative to the docroot; ModTime is in Unix
+// nanoseconds.
+type Entry struct {
+	Path    string      `json:"path"`
+	Type    string      `json:"type"`
+	Mode    fs.FileMode `json:"mode"`
+	Size    int64       `json:"size,omitempty"`
+	ModTime int64       `json:"mtime"`
+	Target  string      `json:"target,omitempty"`
+}
+
+// PlanRequest is the source docroot. Final also removes what the target
+// has and the source no longer does.
+type PlanRequest struct {
+	Entries []Entry `json:"entries"`
+	Final   bool    `json:"final"`
+}
+
+// Transfer is a file the target lacks; Offset is where a staged partial
+// upload of it resumes.
+type Transfer struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+}
+
+// Plan lists the files to send. Directories and symlinks are applied by
+// the plan itself.
+type Plan struct {
+	Transfers []Transfer `json:"transfers"`
+	Removed   int        `json:"removed"`
+}
+
+// Piece is one upload request of a file or dump: bytes from Offset of a
+// file of Size bytes whose content hashes to SHA256.
+type Piece struct {
+	Path    string
+	Offset  int64
+	Size    int64
+	ModTime int64
+	Mode    fs.FileMode
+	SHA256  string
+}
+
+// PieceResult reports the staged size after a piece; Done is set once the
+// whole file arrived and was verified.
+type PieceResult struct {
+	Offset int64 `json:"offset"`
+	Done   bool  `json:"done"`
+}
+
+// DatabaseRequest imports an uploaded dump into a database of the
+// migrated site, creating it when missing.
+type DatabaseRequest struct {
+	DBName   string `json:"db_name"`
+	DBEngine string `json:"db_engine"`
+	Dump     string `json:"dump"`
+}
+
+// DatabaseResult is an imported database. Password is the new password of
+// a database created by the import and is only returned once; site configs
+// must be updated with it.
+type DatabaseResult struct {
+	Database database.SiteDatabase `json:"database"`
+	Created  bool                  `json:"created"`
+	Password string                `json:"password,omitempty"`
+}
+
+// Service sends sites to another panel and receives them from one.
+type Service struct {
+	store *sqlite.Store
+	log   *slog.Logger
+	sites Sites
+	dbs   Databases
+	http  *http.Client
+}
+
+// NewService creates a migration service.
+func NewService(store *sqlite.Store, log *slog.Logger, sites Sites, dbs Databases) *Service {
+	if log == nil {
+		log = slog.Default()
+	}
+	return &Service{
+		store: store,
+		log:   log,
+		sites: sites,
+		dbs:   dbs,
+		http:  &http.Client{Timeout: 30 * time.Minute},
+	}
+}
+
+// Start opens a migration of req.Domain, creating the site. Starting a
+// migration of a domain that is still receiving returns it, so an
+// interrupted run resumes.
+func (s *Service) Start(ctx context.Context, req StartRequest, actor string) (Migration, error) {
+	req.Domain = strings.ToLower(strings.TrimSpace(req.Domain))
+	if req.Domain == "" {
+		return Migration{}, fmt.Errorf("invalid migration: domain is required")
+	}
+	m, err := s.byDomain(ctx, req.Domain)
+	switch {
+	case err == nil && m.Status == StatusReceiving:
+		return m, nil
+	case err == nil:
+		return Migration{}, ErrAlreadyMigrated
+	case !errors.Is(err, ErrMigrationNotFound):
+		return Migration{}, err
+	}
+	sites, err := s.sites.ListSites(ctx)
+	if err != nil {
+		return Migration{}, err
+	}
+	for _, site := range sites {
+		if site.Domain == req.Domain {
+			return Migration{}, ErrSiteExists
+		}
+	}
+	site, err := s.sites.CreateSite(ctx, hosting.CreateSiteRequest{Domain: req.Domain, PHPVersion: req.PHPVersion, Actor: actor})
+	if err != nil {
+		return Migration{}, err
+	}
+	now := time.Now().Unix()
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO site_migrations(domain, site_id, source, status, created_at, updated_at)
+VALUES('%s', %d, '%s', '%s', %d, %d);`,
+		sqlEscape(req.Domain), site.ID, sqlEscape(req.Source), StatusReceiving, now, now)); err != nil {
+		return Migration{}, fmt.Errorf("insert migration: %w", err)
+	}
+	_ = s.writeAudit(ctx, actor, "migration.start", site.ID, fmt.Sprintf("domain=%s source=%s", req.Domain, req.Source))
+	return s.byDomain(ctx, req.Domain)
+}
+
+// List returns the migrations received by this panel, newest first.
+func (s *Service) List(ctx context.Context) ([]Migration, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, "SELECT "+migrationColumns+" FROM site_migrations ORDER BY id DESC;")
+	if err != nil {
+		return nil, fmt.Errorf("list migrations: %w", err)
+	}
+	out := make([]Migration, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, migrationFromRow(row))
+	}
+	return out, nil
+}
+
+// Get returns one migration.
+func (s *Service) Get(ctx context.Context, id int64) (Migration, error) {
+	return s.one(ctx, fmt.Sprintf("id = %d", id))
+}
+
+// Plan applies the directories and symlinks of the source docroot and
+// returns the files whose size or modification time differ, the same
+// quick check rsync makes.
+func (s *Service) Plan(ctx context.Context, id int64, req PlanRequest) (Plan, error) {
+	m, root, docroot, err := s.receiving(ctx, id)
+	if err != nil {
+		return Plan{}, err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+	entries := append([]Entry(nil), req.Entries...)
+	for _, e := range entries {
+		if err := validEntry(e); err != nil {
+			return Plan{}, err
+		}
+	}
+	// Parents sort before their children.
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	plan := Plan{Transfers: []Transfer{}}
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return plan, err
+		}
+		dest := path.Join(docroot, e.Path)
+		info, statErr := root.Lstat(dest)
+		exists := statErr == nil
+		switch e.Type {
+		case backup.EntryDir:
+			if exists && !info.IsDir() {
+				if err := root.RemoveAll(dest); err != nil {
+					return plan, fmt.Errorf("replace %s: %w", e.Path, err)
+				}
+				exists = false
+			}
+			if !exists {
+				if err := root.Mkdir(dest, 0o755); err != nil {
+					return plan, fmt.Errorf("create %s: %w", e.Path, err)
+				}
+			}
+			if err := root.Chmod(dest, e.Mode.Perm()); err != nil {
+				return plan, fmt.Errorf("chmod %s: %w", e.Path, err)
+			}
+		case backup.EntrySymlink:
+			if exists && info.Mode()&fs.ModeSymlink != 0 {
+				if target, err := root.Readlink(dest); err == nil && target == e.Target {
+					continue
+				}
+			}
+			if exists {
+				if err := root.RemoveAll(dest); err != nil {
+					return plan, fmt.Errorf("replace %s: %w", e.Path, err)
+				}
+			}
+			if err := root.Symlink(e.Target, dest); err != nil {
+				return plan, fmt.Errorf("create %s: %w", e.Path, err)
+			}
+		case backup.EntryFile:
+			if exists && info.Mode().IsRegular() && info.Size() == e.Size && info.ModTime().UnixNano() == e.ModTime {
+				continue
+			}
+			offset := int64(0)
+			if staged, err := root.Stat(path.Join(stagingDir, stagedName(e.Path, e.Size, e.ModTime))); err == nil && staged.Size() <= e.Size {
+				offset = staged.Size()
+			}
+			plan.Transfers = append(plan.Transfers, Transfer{Path: e.Path, Offset: offset})
+		}
+	}
+	if req.Final {
+		removed, err := prune(root, docroot, entries)
+		plan.Removed = removed
+		if err != nil {
+			return plan, err
+		}
+		if removed > 0 {
+			_ = s.writeAudit(ctx, "", "migration.prune", m.SiteID, fmt.Sprintf("domain=%s removed=%d", m.Domain, removed))
+		}
+	}
+	return plan, nil
+}
+
+// ReceiveFile stages one piece of a docroot file. Once the file is
+// complete and its checksum matches, it is moved into the docroot with
+// the source mode and modification time.
+func (s *Service) ReceiveFile(ctx context.Context, id int64, p Piece, body io.Reader) (PieceResult, error) {
+	if err := validEntry(Entry{Path: p.Path, Type: backup.EntryFile, Size: p.Size}); err != nil {
+		return PieceResult{}, err
+	}
+	if err := validPiece(p); err != nil {
+		return PieceResult{}, err
+	}
+	m, root, docroot, err := s.receiving(ctx, id)
+	if err != nil {
+		return PieceResult{}, err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+	if err := root.MkdirAll(stagingDir, 0o700); err != nil {
+		return PieceResult{}, fmt.Errorf("create staging dir: %w", err)
+	}
+	staged := path.Join(stagingDir, stagedName(p.Path, p.Size, p.ModTime))
+	f, err := root.OpenFile(staged, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return PieceResult{}, fmt.Errorf("stage %s: %w", p.Path, err)
+	}
+	offset, err := receive(f, p, body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || offset < p.Size {
+		return PieceResult{Offset: offset}, err
+	}
+	if err := finish(root, staged, p); err != nil {
+		return PieceResult{}, err
+	}
+	dest := path.Join(docroot, p.Path)
+	if err := root.MkdirAll(path.Dir(dest), 0o755); err != nil {
+		return PieceResult{}, fmt.Errorf("create parent of %s: %w", p.Path, err)
+	}
+	if info, err := root.Lstat(dest); err == nil && info.IsDir() {
+		if err := root.RemoveAll(dest); err != nil {
+			return PieceResult{}, fmt.Errorf("replace %s: %w", p.Path, err)
+		}
+	}
+	if err := root.Rename(staged, dest); err != nil {
+		return PieceResult{}, fmt.Errorf("move %s into place: %w", p.Path, err)
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(
+		"UPDATE site_migrations SET files = files + 1, bytes = bytes + %d, updated_at = %d WHERE id = %d;",
+		p.Size, time.Now().Unix(), m.ID)); err != nil {
+		return PieceResult{}, fmt.Errorf("update migration: %w", err)
+	}
+	return PieceResult{Offset: p.Size, Done: true}, nil
+}
+
+// ReceiveDump stages one piece of a database dump; p.Path is the dump
+// file name.
+func (s *Service) ReceiveDump(ctx context.Context, id int64, p Piece, body io.Reader) (PieceResult, error) {
+	if !dumpNamePattern.MatchString(p.Path) {
+		return PieceResult{}, fmt.Errorf("invalid dump name")
+	}
+	if err := validPiece(p); err != nil {
+		return PieceResult{}, err
+	}
+	m, err := s.active(ctx, id)
+	if err != nil {
+		return PieceResult{}, err
+	}
+	dumps, err := os.OpenRoot(s.dumpDir(m.ID))
+	if errors.Is(err, fs.ErrNotExist) {
+		if err = os.MkdirAll(s.dumpDir(m.ID), 0o700); err == nil {
+			dumps, err = os.OpenRoot(s.dumpDir(m.ID))
+		}
+	}
+	if err != nil {
+		return PieceResult{}, fmt.Errorf("open dump dir: %w", err)
+	}
+	defer func() {
+		_ = dumps.Close()
+	}()
+	staged := ".partial-" + p.Path
+	f, err := dumps.OpenFile(staged, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return PieceResult{}, fmt.Errorf("stage %s: %w", p.Path, err)
+	}
+	offset, err := receive(f, p, body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || offset < p.Size {
+		return PieceResult{Offset: offset}, err
+	}
+	p.Mode = 0o600
+	if err := finish(dumps, staged, p); err != nil {
+		return PieceResult{}, err
+	}
+	if err := dumps.Rename(staged, p.Path); err != nil {
+		return PieceResult{}, fmt.Errorf("store dump %s: %w", p.Path, err)
+	}
+	return PieceResult{Offset: p.Size, Done: true}, nil
+}
+
+// ImportDatabase loads an uploaded dump into the database of the migrated
+// site named by req, creating the database first when it does not exist.
+// The dump is removed once loaded.
+func (s *Service) ImportDatabase(ctx context.Context, id int64, req DatabaseRequest, actor string) (DatabaseResult, error) {
+	if !dumpNamePattern.MatchString(req.Dump) {
+		return DatabaseResult{}, fmt.Errorf("invalid dump name")
+	}
+	m, err := s.active(ctx, id)
+	if err != nil {
+		return DatabaseResult{}, err
+	}
+	dump := filepath.Join(s.dumpDir(m.ID), req.Dump)
+	if _, err := os.Stat(dump); err != nil {
+		return DatabaseResult{}, fmt.Errorf("invalid dump: %s was not uploaded", req.Dump)
+	}
+	dbs, err := s.dbs.ListDatabases(ctx, m.SiteID)
+	if err != nil {
+		return DatabaseResult{}, err
+	}
+	var result DatabaseResult
+	found := false
+	for _, db := range dbs {
+		if db.DBName == req.DBName && db.DBEngine == req.DBEngine {
+			result.Database, found = db, true
+			break
+		}
+	}
+	if !found {
+		created, err := s.dbs.CreateDatabase(ctx, database.CreateDatabaseRequest{
+			SiteID: m.SiteID, DBName: req.DBName, DBEngine: req.DBEngine, Actor: actor,
+		})
+		if err != nil {
+			return DatabaseResult{}, err
+		}
+		result = DatabaseResult{Database: created.Database, Created: true, Password: created.Password}
+	}
+	if err := s.dbs.ImportDatabase(ctx, result.Database.ID, dump, actor); err != nil {
+		return result, err
+	}
+	_ = os.Remove(dump)
+	return result, nil
+}
+
+// Complete finishes a migration: staged leftovers are removed and the site
+// is reprovisioned, which hands the docroot to the site user.
+func (s *Service) Complete(ctx context.Context, id int64, actor string) (Migration, error) {
+	m, root, _, err := s.receiving(ctx, id)
+	if err != nil {
+		return Migration{}, err
+	}
+	err = root.RemoveAll(stagingDir)
+	_ = root.Close()
+	if err != nil {
+		return Migration{}, fmt.Errorf("remove staging dir: %w", err)
+	}
+	if err := os.RemoveAll(s.dumpDir(m.ID)); err != nil {
+		return Migration{}, fmt.Errorf("remove dumps: %w", err)
+	}
+	if _, err := s.sites.ReprovisionSite(ctx, m.SiteID, actor); err != nil {
+		return Migration{}, err
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(
+		"UPDATE site_migrations SET status = '%s', updated_at = %d WHERE id = %d;",
+		StatusCompleted, time.Now().Unix(), m.ID)); err != nil {
+		return Migration{}, fmt.Errorf("update migration: %w", err)
+	}
+	_ = s.writeAudit(ctx, actor, "migration.complete", m.SiteID, fmt.Sprintf(
+		"domain=%s source=%s files=%d bytes=%d", m.Domain, m.Source, m.Files, m.Bytes))
+	return s.Get(ctx, m.ID)
+}
+
+// receiving loads a migration that still accepts data and opens the parent
+// of its site docroot. Every write goes through the returned root, so
+// nothing the source sends can leave it.
+func (s *Service) receiving(ctx context.Context, id int64) (Migration, *os.Root, string, error) {
+	m, err := s.active(ctx, id)
+	if err != nil {
+		return Migration{}, nil, "", err
+	}
+	site, err := s.sites.GetSite(ctx, m.SiteID)
+	if err != nil {
+		return Migration{}, nil, "", err
+	}
+	base, docroot := filepath.Dir(site.RootDir), filepath.Base(site.RootDir)
+	if err := os.MkdirAll(site.RootDir, 0o750); err != nil {
+		return Migration{}, nil, "", fmt.Errorf("create docroot: %w", err)
+	}
+	root, err := os.OpenRoot(base)
+	if err != nil {
+		return Migration{}, nil, "", fmt.Errorf("open site dir: %w", err)
+	}
+	return m, root, docroot, nil
+}
+
+// active loads a migration that still accepts data.
+func (s *Service) active(ctx context.Context, id int64) (Migration, error) {
+	m, err := s.Get(ctx, id)
+	if err != nil {
+		return Migration{}, err
+	}
+	if m.Status != StatusReceiving {
+		return Migration{}, ErrAlreadyMigrated
+	}
+	return m, nil
+}
+
+func (s *Service) dumpDir(id int64) string {
+	return filepath.Join(s.store.DataDir, "migrations", fmt.Sprint(id))
+}
+
+func (s *Service) byDomain(ctx context.Context, domain string) (Migration, error) {
+	return s.one(ctx, fmt.Sprintf("domain = '%s'", sqlEscape(domain)))
+}
+
+const migrationColumns = "id, domain, site_id, source, status, files, bytes, created_at, updated_at"
+
+func (s *Service) one(ctx context.Context, where string) (Migration, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, "SELECT "+migrationColumns+" FROM site_migrations WHERE "+where+" LIMIT 1;")
+	if err != nil {
+		return Migration{}, fmt.Errorf("get migration: %w", err)
+	}
+	if len(rows) == 0 {
+		return Migration{}, ErrMigrationNotFound
+	}
+	return migrationFromRow(rows[0]), nil
+}
+
+// receive appends body to f, which must hold exactly p.Offset bytes, and
+// returns the new size. A mismatch returns ErrOffsetMismatch with the
+// staged size, from which the source resumes.
+func receive(f *os.File, p Piece, body io.Reader) (int64, error) {
+	staged, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("stage %s: %w", p.Path, err)
+	}
+	if staged != p.Offset {
+		return staged, ErrOffsetMismatch
+	}
+	n, err := io.Copy(f, io.LimitReader(body, p.Size-p.Offset))
+	if err != nil {
+		return staged + n, fmt.Errorf("stage %s: %w", p.Path, err)
+	}
+	return staged + n, nil
+}
+
+// finish verifies a complete staged file and applies the source mode and
+// modification time. A checksum mismatch discards it.
+func finish(root *os.Root, staged string, p Piece) error {
+	f, err := root.Open(staged)
+	if err != nil {
+		return fmt.Errorf("verify %s: %w", p.Path, err)
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	_ = f.Close()
+	if err != nil {
+		return fmt.Errorf("verify %s: %w", p.Path, err)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != p.SHA256 {
+		_ = root.Remove(staged)
+		return fmt.Errorf("invalid upload: checksum mismatch for %s", p.Path)
+	}
+	if err := root.Chmod(staged, p.Mode.Perm()); err != nil {
+		return fmt.Errorf("chmod %s: %w", p.Path, err)
+	}
+	if p.ModTime != 0 {
+		mtime := time.Unix(0, p.ModTime)
+		if err := root.Chtimes(staged, mtime, mtime); err != nil {
+			return fmt.Errorf("set times of %s: %w", p.Path, err)
+		}
+	}
+	return nil
+}
+
+// prune removes from docroot what entries does not list.
+func prune(root *os.Root, docroot string, entries []Entry) (int, error) {
+	keep := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		keep[e.Path] = true
+	}
+	var remove []string
+	err := fs.WalkDir(root.FS(), docroot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == docroot {
+			return nil
+		}
+		rel := strings.TrimPrefix(p, docroot+"/")
+		if keep[rel] {
+			return nil
+		}
+		remove = append(remove, p)
+		if d.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("list docroot: %w", err)
+	}
+	for _, p := range remove {
+		if err := root.RemoveAll(p); err != nil {
+			return 0, fmt.Errorf("remove %s: %w", p, err)
+		}
+	}
+	return len(remove), nil
+}
+
+// stagedName keys a partial upload by path and source version, so a file
+// that changed on the source starts over instead of resuming.
+func stagedName(p string, size, modTime int64) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d", p, size, modTime)))
+	return hex.EncodeToString(sum[:16])
+}
+
+func validEntry(e Entry) error {
+	if e.Path == "" || e.Path != path.Clean(e.Path) || !filepath.IsLocal(filepath.FromSlash(e.Path)) ||
+		strings.Contains(e.Path, "\\") {
+		return fmt.Errorf("invalid path: %q", e.Path)
+	}
+	switch e.Type {
+	case backup.EntryDir, backup.EntryFile:
+	case backup.EntrySymlink:
+		if e.Target == "" {
+			return fmt.Errorf("invalid path: symlink %q has no target", e.Path)
+		}
+	default:
+		return fmt.Errorf("invalid path: %q has unknown type %q", e.Path, e.Type)
+	}
+	if e.Size < 0 {
+		return fmt.Errorf("invalid path: %q has a negative size", e.Path)
+	}
+	return nil
+}
+
+func validPiece(p Piece) error {
+	if p.Size < 0 || p.Offset < 0 || p.Offset > p.Size {
+		return fmt.Errorf("invalid piece: offset %d outside size %d", p.Offset, p.Size)
+	}
+	if !sha256Pattern.MatchString(p.SHA256) {
+		return fmt.Errorf("invalid piece: sha256 must be 64 lowercase hex characters")
+	}
+	return nil
+}
+
+func migrationFromRow(row map[string]any) Migration {
+	str := func(k string) string {
+		v, _ := row[k].(string)
+		return v
+	}
+	num := func(k string) int64 {
+		switch n := row[k].(type) {
+		case float64:
+			return int64(n)
+		case int64:
+			return n
+		}
+		return 0
+	}
+	return Migration{
+		ID:        num("id"),
+		Domain:    str("domain"),
+		SiteID:    num("site_id"),
+		Source:    str("source"),
+		Status:    str("status"),
+		Files:     num("files"),
+		Bytes:     num("bytes"),
+		CreatedAt: time.Unix(num("created_at"), 0).UTC(),
+		UpdatedAt: time.Unix(num("updated_at"), 0).UTC(),
+	}
+}
+
+// writeAudit records an event in the activity of siteID.
+func (s *Service) writeAudit(ctx context.Context, actor, action string, siteID int64, details string) error {
+	if strings.TrimSpace(actor) == "" {
+		actor = "system"
+	}
+	return s.store.ExecAudit(ctx, fmt.Sprintf(
+		"INSERT INTO audit_events(actor, action, details, remote_ip, impersonator, site_id, created_at) VALUES('%s','%s','%s','%s','%s',%d,%d);",
+		sqlEscape(actor),
+		sqlEscape(action),
+		sqlEscape(details),
+		sqlEscape(middleware.ClientIP(ctx)),
+		sqlEscape(middleware.Impersonator(ctx)),
+		siteID,
+		time.Now().Unix(),
+	))
+}
+
+func sqlEscape(in string) string {
+	return strings.ReplaceAll(in, "'", "''")
+}
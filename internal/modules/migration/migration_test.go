@@ -0,0 +1,301 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/modules/database"
+	"github.com/robsonek/aiPanel/internal/modules/hosting"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+type fakeSites struct {
+	webRoot       string
+	sites         []hosting.Site
+	reprovisioned []int64
+}
+
+func (f *fakeSites) ListSites(context.Context) ([]hosting.Site, error) {
+	return f.sites, nil
+}
+
+func (f *fakeSites) GetSite(_ context.Context, id int64) (hosting.Site, error) {
+	for _, s := range f.sites {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return hosting.Site{}, hosting.ErrSiteNotFound
+}
+
+func (f *fakeSites) CreateSite(_ context.Context, req hosting.CreateSiteRequest) (hosting.Site, error) {
+	site := hosting.Site{
+		ID:         int64(len(f.sites) + 1),
+		Domain:     req.Domain,
+		PHPVersion: req.PHPVersion,
+		RootDir:    filepath.Join(f.webRoot, req.Domain, "public_html"),
+	}
+	if err := os.MkdirAll(site.RootDir, 0o755); err != nil {
+		return hosting.Site{}, err
+	}
+	if err := os.WriteFile(filepath.Join(site.RootDir, "index.html"), []byte("placeholder"), 0o644); err != nil {
+		return hosting.Site{}, err
+	}
+	f.sites = append(f.sites, site)
+	return site, nil
+}
+
+func (f *fakeSites) ReprovisionSite(_ context.Context, id int64, _ string) (hosting.Site, error) {
+	f.reprovisioned = append(f.reprovisioned, id)
+	return f.GetSite(context.Background(), id)
+}
+
+type fakeDatabases struct {
+	dbs      []database.SiteDatabase
+	dumps    map[string]string
+	imported map[string]string
+}
+
+func (f *fakeDatabases) ListDatabases(_ context.Context, siteID int64) ([]database.SiteDatabase, error) {
+	var out []database.SiteDatabase
+	for _, db := range f.dbs {
+		if db.SiteID == siteID {
+			out = append(out, db)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeDatabases) CreateDatabase(_ context.Context, req database.CreateDatabaseRequest) (database.CreateDatabaseResult, error) {
+	db := database.SiteDatabase{ID: int64(len(f.dbs) + 1), SiteID: req.SiteID, DBName: req.DBName, DBUser: req.DBName + "_user", DBEngine: req.DBEngine}
+	f.dbs = append(f.dbs, db)
+	return database.CreateDatabaseResult{Database: db, Password: "new-secret"}, nil
+}
+
+func (f *fakeDatabases) ExportDatabase(_ context.Context, id int64, dir string) (string, error) {
+	db := f.dbs[id-1]
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	dest := filepath.Join(dir, db.DBName+".sql")
+	return dest, os.WriteFile(dest, []byte(f.dumps[db.DBName]), 0o600)
+}
+
+func (f *fakeDatabases) ImportDatabase(_ context.Context, id int64, src, _ string) error {
+	raw, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	f.imported[f.dbs[id-1].DBName] = string(raw)
+	return nil
+}
+
+func newStore(t *testing.T) *sqlite.Store {
+	t.Helper()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(context.Background()); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	return store
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0o640); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func TestService_SendMigratesSiteWithFinalDeltaPass(t *testing.T) {
+	ctx := context.Background()
+	docroot := filepath.Join(t.TempDir(), "example.com", "public_html")
+	big := make([]byte, pieceSize+pieceSize/2)
+	rand.New(rand.NewSource(1)).Read(big)
+	writeFile(t, filepath.Join(docroot, "wp-content", "uploads", "video.bin"), big)
+	writeFile(t, filepath.Join(docroot, "index.php"), []byte("<?php echo 'v1';"))
+	writeFile(t, filepath.Join(docroot, "old.php"), []byte("removed before the cutover"))
+	writeFile(t, filepath.Join(docroot, "empty.txt"), nil)
+	if err := os.Symlink("index.php", filepath.Join(docroot, "home.php")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	sourceSites := &fakeSites{sites: []hosting.Site{{ID: 7, Domain: "example.com", PHPVersion: "8.3", RootDir: docroot}}}
+	sourceDBs := &fakeDatabases{
+		dbs:   []database.SiteDatabase{{ID: 1, SiteID: 7, DBName: "shop", DBUser: "shop_user", DBEngine: "mariadb"}},
+		dumps: map[string]string{"shop": "CREATE TABLE orders;"},
+	}
+	source := NewService(newStore(t), nil, sourceSites, sourceDBs)
+
+	targetSites := &fakeSites{webRoot: t.TempDir()}
+	targetDBs := &fakeDatabases{imported: map[string]string{}}
+	target := NewService(newStore(t), nil, targetSites, targetDBs)
+	var puts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer aipt_test" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodPut {
+			puts++
+		}
+		NewHandler(target).HandleMigrations(w, r, "admin@target")
+	}))
+	defer srv.Close()
+
+	if _, err := source.Send(ctx, 7, SendOptions{To: srv.URL, Token: "wrong"}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("expected a bad token to be rejected, got %v", err)
+	}
+	report, err := source.Send(ctx, 7, SendOptions{To: srv.URL, Token: "aipt_test"})
+	if err != nil {
+		t.Fatalf("bulk pass: %v", err)
+	}
+	if report.Migration.Status != StatusReceiving || len(report.Passes) != 1 || report.Passes[0].Files != 4 {
+		t.Fatalf("unexpected bulk report: %+v", report)
+	}
+	if puts != 5 {
+		t.Fatalf("expected the large file in two pieces and three single-piece files, got %d uploads", puts)
+	}
+	targetRoot := targetSites.sites[0].RootDir
+	if raw, _ := os.ReadFile(filepath.Join(targetRoot, "wp-content", "uploads", "video.bin")); !bytes.Equal(raw, big) {
+		t.Fatalf("large file differs after transfer")
+	}
+	if link, err := os.Readlink(filepath.Join(targetRoot, "home.php")); err != nil || link != "index.php" {
+		t.Fatalf("expected the symlink recreated, got %q err=%v", link, err)
+	}
+	if len(targetDBs.imported) != 0 || len(targetSites.reprovisioned) != 0 {
+		t.Fatalf("a bulk pass must not import databases or complete")
+	}
+
+	// A second bulk pass sends nothing.
+	puts = 0
+	if report, err = source.Send(ctx, 7, SendOptions{To: srv.URL, Token: "aipt_test"}); err != nil || report.Passes[0].Files != 0 || puts != 0 {
+		t.Fatalf("expected an unchanged docroot to send nothing, got %+v puts=%d err=%v", report.Passes, puts, err)
+	}
+
+	// Changes before the cutover, and an upload that was interrupted after
+	// three bytes.
+	later := time.Now().Add(time.Minute)
+	writeFile(t, filepath.Join(docroot, "index.php"), []byte("<?php echo 'v2';"))
+	if err := os.Chtimes(filepath.Join(docroot, "index.php"), later, later); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	if err := os.Remove(filepath.Join(docroot, "old.php")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	resume := []byte("0123456789")
+	writeFile(t, filepath.Join(docroot, "resume.txt"), resume)
+	info, _ := os.Stat(filepath.Join(docroot, "resume.txt"))
+	sum := sha256.Sum256(resume)
+	piece := Piece{Path: "resume.txt", Size: 10, ModTime: info.ModTime().UnixNano(), Mode: 0o640, SHA256: hex.EncodeToString(sum[:])}
+	if res, err := target.ReceiveFile(ctx, report.Migration.ID, piece, bytes.NewReader(resume[:3])); err != nil || res.Offset != 3 || res.Done {
+		t.Fatalf("stage partial upload: %+v err=%v", res, err)
+	}
+	piece.Offset = 1
+	if res, err := target.ReceiveFile(ctx, report.Migration.ID, piece, bytes.NewReader(resume[1:])); !errors.Is(err, ErrOffsetMismatch) || res.Offset != 3 {
+		t.Fatalf("expected a gap to report the staged offset, got %+v err=%v", res, err)
+	}
+
+	report, err = source.Send(ctx, 7, SendOptions{To: srv.URL, Token: "aipt_test", Final: true})
+	if err != nil {
+		t.Fatalf("final pass: %v", err)
+	}
+	// The bulk pass that precedes the cutover carries the delta, so the
+	// final pass only prunes.
+	if len(report.Passes) != 2 || report.Passes[0].Files != 2 || report.Passes[0].Bytes != int64(len("<?php echo 'v2';")+7) {
+		t.Fatalf("expected only the delta sent, got %+v", report.Passes)
+	}
+	if final := report.Passes[1]; !final.Final || final.Files != 0 || final.Removed != 2 {
+		t.Fatalf("unexpected final pass: %+v", final)
+	}
+	if raw, _ := os.ReadFile(filepath.Join(targetRoot, "resume.txt")); string(raw) != string(resume) {
+		t.Fatalf("expected the resumed file complete, got %q", raw)
+	}
+	if raw, _ := os.ReadFile(filepath.Join(targetRoot, "index.php")); string(raw) != "<?php echo 'v2';" {
+		t.Fatalf("expected the changed file sent, got %q", raw)
+	}
+	for _, gone := range []string{"old.php", "index.html"} {
+		if _, err := os.Lstat(filepath.Join(targetRoot, gone)); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected %s removed from the target, got %v", gone, err)
+		}
+	}
+	if got, _ := os.Stat(filepath.Join(targetRoot, "index.php")); !got.ModTime().Equal(later) {
+		t.Fatalf("expected the modification time kept, got %v", got.ModTime())
+	}
+	if len(report.Databases) != 1 || report.Databases[0].Password != "new-secret" || targetDBs.imported["shop"] != "CREATE TABLE orders;" {
+		t.Fatalf("unexpected database import: %+v %+v", report.Databases, targetDBs.imported)
+	}
+	if report.Migration.Status != StatusCompleted || len(targetSites.reprovisioned) != 1 {
+		t.Fatalf("expected the migration completed and the site reprovisioned, got %+v", report.Migration)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(targetRoot), stagingDir)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the staging dir removed, got %v", err)
+	}
+	if _, err := source.Send(ctx, 7, SendOptions{To: srv.URL, Token: "aipt_test"}); err == nil || !strings.Contains(err.Error(), "409") {
+		t.Fatalf("expected a completed migration to refuse a new one, got %v", err)
+	}
+	rows, err := source.store.QueryAuditJSON(ctx, "SELECT action FROM audit_events WHERE action = 'migration.send';")
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("expected one migration.send audit event, got %+v err=%v", rows, err)
+	}
+}
+
+func TestService_ReceiveRejectsPathsOutsideTheDocroot(t *testing.T) {
+	ctx := context.Background()
+	sites := &fakeSites{webRoot: t.TempDir()}
+	svc := NewService(newStore(t), nil, sites, &fakeDatabases{})
+	m, err := svc.Start(ctx, StartRequest{Domain: "Example.com", Source: "old"}, "admin")
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if again, err := svc.Start(ctx, StartRequest{Domain: "example.com"}, "admin"); err != nil || again.ID != m.ID {
+		t.Fatalf("expected a receiving migration to resume, got %+v err=%v", again, err)
+	}
+	if _, err := svc.Start(ctx, StartRequest{Domain: "other.com"}, "admin"); err != nil {
+		t.Fatalf("start other: %v", err)
+	}
+	sites.sites = append(sites.sites, hosting.Site{ID: 99, Domain: "taken.com"})
+	if _, err := svc.Start(ctx, StartRequest{Domain: "taken.com"}, "admin"); !errors.Is(err, ErrSiteExists) {
+		t.Fatalf("expected ErrSiteExists, got %v", err)
+	}
+
+	for _, p := range []string{"../escape.php", "/etc/passwd", "a/../../b", "", "./x"} {
+		if _, err := svc.Plan(ctx, m.ID, PlanRequest{Entries: []Entry{{Path: p, Type: "file"}}}); err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Fatalf("expected %q rejected, got %v", p, err)
+		}
+	}
+	// A symlink the source sent must not let a later file escape.
+	if _, err := svc.Plan(ctx, m.ID, PlanRequest{Entries: []Entry{{Path: "out", Type: "symlink", Target: "/tmp"}}}); err != nil {
+		t.Fatalf("plan symlink: %v", err)
+	}
+	body := []byte("pwned")
+	sum := sha256.Sum256(body)
+	p := Piece{Path: "out/pwned.php", Size: int64(len(body)), Mode: 0o644, SHA256: hex.EncodeToString(sum[:])}
+	if _, err := svc.ReceiveFile(ctx, m.ID, p, bytes.NewReader(body)); err == nil {
+		t.Fatalf("expected a write through a symlink to fail")
+	}
+	if _, err := os.Stat("/tmp/pwned.php"); err == nil {
+		_ = os.Remove("/tmp/pwned.php")
+		t.Fatalf("file was written outside the docroot")
+	}
+
+	p = Piece{Path: "a.txt", Size: 3, Mode: 0o644, SHA256: strings.Repeat("0", 64)}
+	if _, err := svc.ReceiveFile(ctx, m.ID, p, strings.NewReader("abc")); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+	if _, err := targetURL("http://panel.example.com"); err == nil {
+		t.Fatalf("expected plain http to a remote host to be refused")
+	}
+}
@@ -0,0 +1,425 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/modules/backup"
+)
+
+const (
+	// pieceSize bounds one upload request, well under the default
+	// max_request_body_mb, so an interrupted upload loses little.
+	pieceSize = 4 << 20
+	// passRounds bounds the plan/upload rounds of one pass; a round only
+	// repeats when uploads failed.
+	passRounds = 3
+	// pieceAttempts bounds the tries of one piece on network errors.
+	pieceAttempts = 3
+)
+
+// SendOptions controls a migration from the source side.
+type SendOptions struct {
+	// To is the base URL of the target panel.
+	To string
+	// Token is an admin API token on the target.
+	Token string
+	// Final runs the final pass: the last file delta, database dumps and
+	// completion. Without it only the files are copied, which can be
+	// repeated to pre-seed a large site ahead of the cutover.
+	Final bool
+	Actor string
+	// Progress receives one line per step when set.
+	Progress io.Writer
+}
+
+// PassResult summarizes one file pass.
+type PassResult struct {
+	Final      bool  `json:"final"`
+	Files      int   `json:"files"`
+	Bytes      int64 `json:"bytes"`
+	Removed    int   `json:"removed"`
+	DurationMS int64 `json:"duration_ms"`
+}
+
+// Report summarizes a migration from the source side. FinalPassMS is how
+// long the final pass took: writes the source takes after it started are
+// not carried over, so DNS should move right after it.
+type Report struct {
+	Migration   Migration        `json:"migration"`
+	Passes      []PassResult     `json:"passes"`
+	Databases   []DatabaseResult `json:"databases,omitempty"`
+	FinalPassMS int64            `json:"final_pass_ms,omitempty"`
+}
+
+// Send migrates a site of this panel to the panel at opts.To. A bulk pass
+// copies the docroot while the site keeps serving; the final pass then
+// sends only what changed since, together with fresh database dumps. Every
+// step is resumable: running Send again after an interruption continues
+// the same migration and skips what the target already has.
+func (s *Service) Send(ctx context.Context, siteID int64, opts SendOptions) (Report, error) {
+	target, err := targetURL(opts.To)
+	if err != nil {
+		return Report{}, err
+	}
+	if strings.TrimSpace(opts.Token) == "" {
+		return Report{}, fmt.Errorf("invalid migration: an API token of the target is required")
+	}
+	site, err := s.sites.GetSite(ctx, siteID)
+	if err != nil {
+		return Report{}, err
+	}
+	dbs, err := s.dbs.ListDatabases(ctx, siteID)
+	if err != nil {
+		return Report{}, err
+	}
+	progress := func(format string, args ...any) {
+		if opts.Progress != nil {
+			_, _ = fmt.Fprintf(opts.Progress, format+"\n", args...)
+		}
+	}
+	c := &client{base: target, token: opts.Token, http: s.http}
+	source, _ := os.Hostname()
+
+	var report Report
+	if err := c.call(ctx, http.MethodPost, "", nil, StartRequest{Domain: site.Domain, PHPVersion: site.PHPVersion, Source: source}, &report.Migration); err != nil {
+		return report, fmt.Errorf("start migration: %w", err)
+	}
+	progress("migration %d of %s to %s", report.Migration.ID, site.Domain, target.Host)
+
+	pass, err := s.sendFiles(ctx, c, report.Migration.ID, site.RootDir, false)
+	report.Passes = append(report.Passes, pass)
+	if err != nil {
+		return report, err
+	}
+	progress("bulk pass: %d file(s), %d bytes in %s", pass.Files, pass.Bytes, time.Duration(pass.DurationMS)*time.Millisecond)
+	if !opts.Final {
+		return report, nil
+	}
+
+	started := time.Now()
+	exportDir := filepath.Join(s.store.DataDir, "migrations", fmt.Sprintf("export-%d", siteID))
+	defer func() {
+		_ = os.RemoveAll(exportDir)
+	}()
+	// Dumps are taken first, so the data they hold is never older than
+	// the files sent after them.
+	dumps := make([]string, len(dbs))
+	for i, db := range dbs {
+		if dumps[i], err = s.dbs.ExportDatabase(ctx, db.ID, exportDir); err != nil {
+			return report, fmt.Errorf("export %s: %w", db.DBName, err)
+		}
+	}
+	pass, err = s.sendFiles(ctx, c, report.Migration.ID, site.RootDir, true)
+	report.Passes = append(report.Passes, pass)
+	if err != nil {
+		return report, err
+	}
+	progress("final pass: %d file(s), %d bytes, %d removed", pass.Files, pass.Bytes, pass.Removed)
+	for i, db := range dbs {
+		if err := s.sendFile(ctx, c, report.Migration.ID, "dumps", dumps[i], filepath.Base(dumps[i]), 0, nil); err != nil {
+			return report, err
+		}
+		var result DatabaseResult
+		req := DatabaseRequest{DBName: db.DBName, DBEngine: db.DBEngine, Dump: filepath.Base(dumps[i])}
+		if err := c.call(ctx, http.MethodPost, fmt.Sprintf("/%d/databases", report.Migration.ID), nil, req, &result); err != nil {
+			return report, fmt.Errorf("import %s: %w", db.DBName, err)
+		}
+		report.Databases = append(report.Databases, result)
+		progress("database %s imported", db.DBName)
+	}
+	if err := c.call(ctx, http.MethodPost, fmt.Sprintf("/%d/complete", report.Migration.ID), nil, struct{}{}, &report.Migration); err != nil {
+		return report, fmt.Errorf("complete migration: %w", err)
+	}
+	report.FinalPassMS = time.Since(started).Milliseconds()
+	_ = s.writeAudit(ctx, opts.Actor, "migration.send", siteID, fmt.Sprintf(
+		"domain=%s target=%s migration=%d databases=%d", site.Domain, target.Host, report.Migration.ID, len(dbs)))
+	return report, nil
+}
+
+// sendFiles runs one pass: the target plans against the current docroot
+// and every file it lacks is uploaded. Failed uploads are retried in a
+// new round; files that change while being read are left to the next
+// pass.
+func (s *Service) sendFiles(ctx context.Context, c *client, id int64, docroot string, final bool) (PassResult, error) {
+	started := time.Now()
+	result := PassResult{Final: final}
+	var lastErr error
+	for round := 0; round < passRounds; round++ {
+		entries, err := scanTree(docroot)
+		if err != nil {
+			return result, err
+		}
+		byPath := make(map[string]Entry, len(entries))
+		for _, e := range entries {
+			byPath[e.Path] = e
+		}
+		var plan Plan
+		if err := c.call(ctx, http.MethodPost, fmt.Sprintf("/%d/plan", id), nil, PlanRequest{Entries: entries, Final: final}, &plan); err != nil {
+			return result, fmt.Errorf("plan migration: %w", err)
+		}
+		result.Removed += plan.Removed
+		lastErr = nil
+		for _, t := range plan.Transfers {
+			e, ok := byPath[t.Path]
+			if !ok {
+				continue
+			}
+			err := s.sendFile(ctx, c, id, "files", filepath.Join(docroot, filepath.FromSlash(e.Path)), e.Path, t.Offset, &e)
+			switch {
+			case err == nil:
+				result.Files++
+				result.Bytes += e.Size - t.Offset
+			case errors.Is(err, errChanged):
+			case ctx.Err() != nil:
+				return result, ctx.Err()
+			default:
+				s.log.Warn("migration upload failed", "path", e.Path, "error", err)
+				lastErr = err
+			}
+		}
+		if lastErr == nil {
+			break
+		}
+	}
+	result.DurationMS = time.Since(started).Milliseconds()
+	return result, lastErr
+}
+
+// errChanged marks a file modified since the scan.
+var errChanged = errors.New("file changed during the pass")
+
+// sendFile uploads local as name from offset in pieces. With an entry, a
+// file that no longer matches it is skipped with errChanged.
+func (s *Service) sendFile(ctx context.Context, c *client, id int64, kind, local, name string, offset int64, entry *Entry) error {
+	//nolint:gosec // G304: local is inside the site docroot or the export dir.
+	f, err := os.Open(local)
+	if err != nil {
+		if entry != nil && errors.Is(err, fs.ErrNotExist) {
+			return errChanged
+		}
+		return fmt.Errorf("open %s: %w", name, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", name, err)
+	}
+	if entry != nil && (!info.Mode().IsRegular() || info.Size() != entry.Size || info.ModTime().UnixNano() != entry.ModTime) {
+		return errChanged
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("read %s: %w", name, err)
+	}
+	query := url.Values{
+		"path":   {name},
+		"size":   {strconv.FormatInt(info.Size(), 10)},
+		"mtime":  {strconv.FormatInt(info.ModTime().UnixNano(), 10)},
+		"mode":   {strconv.FormatUint(uint64(info.Mode().Perm()), 8)},
+		"sha256": {hex.EncodeToString(h.Sum(nil))},
+	}
+	for {
+		n := min(info.Size()-offset, pieceSize)
+		query.Set("offset", strconv.FormatInt(offset, 10))
+		var res PieceResult
+		var err error
+		for attempt := 1; attempt <= pieceAttempts; attempt++ {
+			res, err = c.put(ctx, fmt.Sprintf("/%d/%s", id, kind), query, io.NewSectionReader(f, offset, n), n)
+			if err == nil || errors.Is(err, ErrOffsetMismatch) || ctx.Err() != nil || !retryable(err) {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * time.Second):
+			}
+		}
+		switch {
+		case errors.Is(err, ErrOffsetMismatch):
+			// An earlier attempt arrived after all, or another run
+			// staged more; continue from what the target has.
+		case err != nil:
+			return fmt.Errorf("upload %s: %w", name, err)
+		}
+		if res.Done {
+			return nil
+		}
+		if res.Offset < 0 || res.Offset > info.Size() {
+			return fmt.Errorf("upload %s: target reported offset %d of %d", name, res.Offset, info.Size())
+		}
+		offset = res.Offset
+	}
+}
+
+// scanTree lists the directories, regular files and symlinks below root.
+func scanTree(root string) ([]Entry, error) {
+	var entries []Entry
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		e := Entry{Path: filepath.ToSlash(rel), Mode: info.Mode().Perm(), ModTime: info.ModTime().UnixNano()}
+		switch {
+		case info.IsDir():
+			e.Type = backup.EntryDir
+		case info.Mode().IsRegular():
+			e.Type, e.Size = backup.EntryFile, info.Size()
+		case info.Mode()&fs.ModeSymlink != 0:
+			if e.Target, err = os.Readlink(p); err != nil {
+				return err
+			}
+			e.Type = backup.EntrySymlink
+		default:
+			// Sockets, pipes and devices do not move.
+			return nil
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan docroot: %w", err)
+	}
+	return entries, nil
+}
+
+// targetURL validates the target panel URL. Plain HTTP is only accepted
+// for loopback addresses, since the token and the site travel over it.
+func targetURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimRight(strings.TrimSpace(raw), "/"))
+	if err != nil || u.Host == "" || u.User != nil || u.RawQuery != "" {
+		return nil, fmt.Errorf("invalid target: expected https://panel.example.com")
+	}
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if ip := net.ParseIP(u.Hostname()); u.Hostname() != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return nil, fmt.Errorf("invalid target: plain http is only allowed for loopback addresses")
+		}
+	default:
+		return nil, fmt.Errorf("invalid target: expected https://panel.example.com")
+	}
+	return u, nil
+}
+
+// client calls /api/migrations of the target panel.
+type client struct {
+	base  *url.URL
+	token string
+	http  *http.Client
+}
+
+// statusError is a non-2xx answer of the target.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("target answered %d: %s", e.code, e.msg)
+}
+
+// retryable reports whether err may pass on a new attempt: network errors
+// and 5xx answers, not rejected requests.
+func retryable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code >= 500
+	}
+	return true
+}
+
+func (c *client) call(ctx context.Context, method, sub string, query url.Values, in, out any) error {
+	raw, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, method, sub, query, bytes.NewReader(raw), int64(len(raw)), "application/json")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// put sends one piece. A 409 carries the staged offset and is returned
+// as ErrOffsetMismatch together with it.
+func (c *client) put(ctx context.Context, sub string, query url.Values, body io.Reader, size int64) (PieceResult, error) {
+	resp, err := c.do(ctx, http.MethodPut, sub, query, body, size, "application/octet-stream")
+	var res PieceResult
+	var se *statusError
+	if errors.As(err, &se) && se.code == http.StatusConflict {
+		if jsonErr := json.Unmarshal([]byte(se.msg), &res); jsonErr == nil {
+			return res, ErrOffsetMismatch
+		}
+	}
+	if err != nil {
+		return res, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	return res, json.NewDecoder(resp.Body).Decode(&res)
+}
+
+func (c *client) do(ctx context.Context, method, sub string, query url.Values, body io.Reader, size int64, contentType string) (*http.Response, error) {
+	u := *c.base
+	u.Path = strings.TrimRight(u.Path, "/") + "/api/migrations" + sub
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		_ = resp.Body.Close()
+		return nil, &statusError{code: resp.StatusCode, msg: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
@@ -0,0 +1,59 @@
+package monitor
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/robsonek/aiPanel/internal/platform/jsonstream"
+)
+
+// Handler exposes HTTP handlers for the resource dashboard.
+type Handler struct {
+	svc *Service
+}
+
+// NewHandler creates monitor HTTP handler.
+func NewHandler(svc *Service) *Handler {
+	return &Handler{svc: svc}
+}
+
+// HandleSummary serves GET /api/monitor/summary: the latest resource
+// sample and the 24-hour peaks.
+func (h *Handler) HandleSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	summary, err := h.svc.ResourceSummary(r.Context())
+	if err != nil {
+		http.Error(w, "failed to read resource summary", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// HandleHistory serves GET /api/monitor/history?range=24h: the resource
+// samples of the range ("6h", "24h", "7d", up to "30d"), oldest first.
+func (h *Handler) HandleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	span, err := ParseHistoryRange(r.URL.Query().Get("range"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	samples, err := h.svc.ResourceHistory(r.Context(), span)
+	if err != nil {
+		http.Error(w, "failed to read resource history", http.StatusInternalServerError)
+		return
+	}
+	jsonstream.List(w, r, "samples", samples)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
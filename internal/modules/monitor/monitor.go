@@ -0,0 +1,64 @@
+// Package monitor samples host resources for the dashboard: CPU, memory,
+// disk, the size of each site docroot and the memory of each runtime unit
+// cgroup. Samples are kept in panel.db and pruned with the metrics
+// retention.
+package monitor
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+// Service records resource samples and serves their summary and history.
+type Service struct {
+	store *sqlite.Store
+	log   *slog.Logger
+	now   func() time.Time
+
+	// mu guards the previous CPU reading of SampleResources.
+	mu      sync.Mutex
+	cpuPrev *cpuTimes
+	// procRoot, cgroupRoot and diskPath are where resources are read;
+	// tests point them at fixtures.
+	procRoot   string
+	cgroupRoot string
+	diskPath   string
+}
+
+// NewService creates a resource monitor reading the host's /proc, cgroup
+// hierarchy and root filesystem.
+func NewService(store *sqlite.Store, log *slog.Logger) *Service {
+	if log == nil {
+		log = slog.Default()
+	}
+	return &Service{store: store, log: log, now: time.Now, procRoot: "/proc", cgroupRoot: "/sys/fs/cgroup", diskPath: "/"}
+}
+
+func sqlEscape(in string) string {
+	return strings.ReplaceAll(in, "'", "''")
+}
+
+func toInt64(v any) (int64, error) {
+	switch t := v.(type) {
+	case float64:
+		return int64(t), nil
+	case int64:
+		return t, nil
+	case string:
+		i, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return i, nil
+	case nil:
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("unsupported int conversion type %T", v)
+	}
+}
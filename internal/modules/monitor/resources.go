@@ -0,0 +1,306 @@
+package monitor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// ResourceSampleInterval is how often SampleResources is scheduled;
+	// history is served at this resolution.
+	ResourceSampleInterval = 5 * time.Minute
+	// DefaultHistoryRange is the history served without a range.
+	DefaultHistoryRange = 24 * time.Hour
+	// maxHistoryRange bounds one history request to a month of samples.
+	maxHistoryRange = 30 * 24 * time.Hour
+	// runtimeUnitPattern matches the systemd units of runtime components.
+	runtimeUnitPattern = "aipanel-runtime-*.service"
+)
+
+// ResourceSample is one reading of host resources. CPUPercent covers the
+// time since the previous sample and is zero for the first one. Sites maps
+// each site domain to the bytes under its docroot, Units each runtime unit
+// to the memory its cgroup uses.
+type ResourceSample struct {
+	CollectedAt      time.Time        `json:"collected_at"`
+	CPUPercent       float64          `json:"cpu_percent"`
+	MemoryTotalBytes int64            `json:"memory_total_bytes"`
+	MemoryUsedBytes  int64            `json:"memory_used_bytes"`
+	DiskTotalBytes   int64            `json:"disk_total_bytes"`
+	DiskUsedBytes    int64            `json:"disk_used_bytes"`
+	Sites            map[string]int64 `json:"sites"`
+	Units            map[string]int64 `json:"units"`
+}
+
+// ResourceSummary is the dashboard headline: the latest sample and the
+// peaks of the last 24 hours.
+type ResourceSummary struct {
+	Sample          *ResourceSample `json:"sample"`
+	CPUPeakPercent  float64         `json:"cpu_peak_percent"`
+	MemoryPeakBytes int64           `json:"memory_peak_bytes"`
+}
+
+// cpuTimes is the aggregate line of /proc/stat in clock ticks.
+type cpuTimes struct {
+	busy, total uint64
+}
+
+// SampleResources reads CPU, memory, disk, docroot sizes and runtime unit
+// memory and stores them as one sample. Readings that fail are left at
+// zero so one missing source does not stop the others.
+func (s *Service) SampleResources(ctx context.Context) error {
+	sample := ResourceSample{CollectedAt: s.now().UTC(), Sites: map[string]int64{}, Units: map[string]int64{}}
+
+	if times, err := readCPUTimes(filepath.Join(s.procRoot, "stat")); err == nil {
+		s.mu.Lock()
+		if prev := s.cpuPrev; prev != nil && times.total > prev.total {
+			sample.CPUPercent = float64(times.busy-prev.busy) / float64(times.total-prev.total) * 100
+		}
+		s.cpuPrev = &times
+		s.mu.Unlock()
+	} else {
+		s.log.Debug("read cpu times", "error", err)
+	}
+	if total, available, err := readMemInfo(filepath.Join(s.procRoot, "meminfo")); err == nil {
+		sample.MemoryTotalBytes, sample.MemoryUsedBytes = total, total-available
+	} else {
+		s.log.Debug("read meminfo", "error", err)
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(s.diskPath, &st); err == nil {
+		bsize := int64(st.Bsize) //nolint:unconvert // Bsize is int32 on some platforms.
+		sample.DiskTotalBytes = int64(st.Blocks) * bsize
+		sample.DiskUsedBytes = (int64(st.Blocks) - int64(st.Bfree)) * bsize
+	}
+
+	rows, err := s.store.QueryPanelJSON(ctx, "SELECT domain, root_dir FROM sites ORDER BY id;")
+	if err != nil {
+		return fmt.Errorf("list sites: %w", err)
+	}
+	for _, row := range rows {
+		domain, _ := row["domain"].(string)
+		root, _ := row["root_dir"].(string)
+		if domain != "" && root != "" {
+			sample.Sites[domain] = dirSize(root)
+		}
+	}
+	units, _ := filepath.Glob(filepath.Join(s.cgroupRoot, "system.slice", runtimeUnitPattern))
+	for _, dir := range units {
+		//nolint:gosec // G304: path is under the cgroup root.
+		b, err := os.ReadFile(filepath.Join(dir, "memory.current"))
+		if err != nil {
+			continue
+		}
+		if n, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64); err == nil {
+			sample.Units[filepath.Base(dir)] = n
+		}
+	}
+
+	sites, _ := json.Marshal(sample.Sites)
+	unitsJSON, _ := json.Marshal(sample.Units)
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO resource_samples(collected_at, cpu_percent, memory_total, memory_used, disk_total, disk_used, sites, units)
+VALUES(%d, %.2f, %d, %d, %d, %d, '%s', '%s');`,
+		sample.CollectedAt.Unix(), sample.CPUPercent, sample.MemoryTotalBytes, sample.MemoryUsedBytes,
+		sample.DiskTotalBytes, sample.DiskUsedBytes, sqlEscape(string(sites)), sqlEscape(string(unitsJSON)),
+	)); err != nil {
+		return fmt.Errorf("record resource sample: %w", err)
+	}
+	return nil
+}
+
+// ResourceSummary returns the latest sample and the 24-hour peaks. Sample
+// is nil until the first sample was taken.
+func (s *Service) ResourceSummary(ctx context.Context) (ResourceSummary, error) {
+	var out ResourceSummary
+	rows, err := s.store.QueryPanelJSON(ctx, "SELECT * FROM resource_samples ORDER BY collected_at DESC, id DESC LIMIT 1;")
+	if err != nil {
+		return out, fmt.Errorf("latest resource sample: %w", err)
+	}
+	if len(rows) == 0 {
+		return out, nil
+	}
+	latest := resourceSampleFromRow(rows[0])
+	out.Sample = &latest
+	rows, err = s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT MAX(cpu_percent) AS cpu, MAX(memory_used) AS memory FROM resource_samples WHERE collected_at > %d;",
+		s.now().Add(-24*time.Hour).Unix()))
+	if err != nil {
+		return out, fmt.Errorf("resource peaks: %w", err)
+	}
+	if len(rows) > 0 {
+		out.CPUPeakPercent, _ = toFloat64(rows[0]["cpu"])
+		out.MemoryPeakBytes, _ = toInt64(rows[0]["memory"])
+	}
+	return out, nil
+}
+
+// ResourceHistory returns the samples of the last span, oldest first.
+func (s *Service) ResourceHistory(ctx context.Context, span time.Duration) ([]ResourceSample, error) {
+	if span <= 0 || span > maxHistoryRange {
+		return nil, fmt.Errorf("history range must be between %s and 30d", ResourceSampleInterval)
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT * FROM resource_samples WHERE collected_at > %d ORDER BY collected_at, id;", s.now().Add(-span).Unix()))
+	if err != nil {
+		return nil, fmt.Errorf("resource history: %w", err)
+	}
+	out := make([]ResourceSample, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, resourceSampleFromRow(row))
+	}
+	return out, nil
+}
+
+// ParseHistoryRange parses a history range such as "6h", "24h" or "7d".
+// An empty range is DefaultHistoryRange.
+func ParseHistoryRange(raw string) (time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return DefaultHistoryRange, nil
+	}
+	var span time.Duration
+	if days, ok := strings.CutSuffix(raw, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid range %q", raw)
+		}
+		span = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if span, err = time.ParseDuration(raw); err != nil {
+			return 0, fmt.Errorf("invalid range %q", raw)
+		}
+	}
+	if span < ResourceSampleInterval || span > maxHistoryRange {
+		return 0, fmt.Errorf("range must be between %s and 30d", ResourceSampleInterval)
+	}
+	return span, nil
+}
+
+func resourceSampleFromRow(row map[string]any) ResourceSample {
+	var sample ResourceSample
+	collected, _ := toInt64(row["collected_at"])
+	sample.CollectedAt = time.Unix(collected, 0).UTC()
+	sample.CPUPercent, _ = toFloat64(row["cpu_percent"])
+	sample.MemoryTotalBytes, _ = toInt64(row["memory_total"])
+	sample.MemoryUsedBytes, _ = toInt64(row["memory_used"])
+	sample.DiskTotalBytes, _ = toInt64(row["disk_total"])
+	sample.DiskUsedBytes, _ = toInt64(row["disk_used"])
+	sites, _ := row["sites"].(string)
+	units, _ := row["units"].(string)
+	_ = json.Unmarshal([]byte(sites), &sample.Sites)
+	_ = json.Unmarshal([]byte(units), &sample.Units)
+	if sample.Sites == nil {
+		sample.Sites = map[string]int64{}
+	}
+	if sample.Units == nil {
+		sample.Units = map[string]int64{}
+	}
+	return sample
+}
+
+// readCPUTimes parses the aggregate "cpu" line of /proc/stat. Idle and
+// iowait count as not busy.
+func readCPUTimes(path string) (cpuTimes, error) {
+	//nolint:gosec // G304: path is under the proc root.
+	f, err := os.Open(path)
+	if err != nil {
+		return cpuTimes{}, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		var times cpuTimes
+		for i, field := range fields[1:] {
+			n, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return cpuTimes{}, fmt.Errorf("parse %s: %w", path, err)
+			}
+			times.total += n
+			// Fields 3 and 4 (0-based) are idle and iowait.
+			if i != 3 && i != 4 {
+				times.busy += n
+			}
+		}
+		return times, nil
+	}
+	return cpuTimes{}, fmt.Errorf("no cpu line in %s", path)
+}
+
+// readMemInfo returns MemTotal and MemAvailable of /proc/meminfo in bytes.
+func readMemInfo(path string) (total, available int64, err error) {
+	//nolint:gosec // G304: path is under the proc root.
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		kb, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total = kb * 1024
+		case "MemAvailable:":
+			available = kb * 1024
+		}
+	}
+	if total == 0 {
+		return 0, 0, fmt.Errorf("no MemTotal in %s", path)
+	}
+	return total, available, nil
+}
+
+// dirSize sums the sizes of the regular files under root without
+// following symlinks; unreadable entries are skipped.
+func dirSize(root string) int64 {
+	var size int64
+	_ = filepath.WalkDir(root, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+func toFloat64(v any) (float64, error) {
+	switch t := v.(type) {
+	case float64:
+		return t, nil
+	case int64:
+		return float64(t), nil
+	case string:
+		return strconv.ParseFloat(t, 64)
+	case nil:
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("unsupported float conversion type %T", v)
+	}
+}
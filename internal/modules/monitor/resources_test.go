@@ -0,0 +1,113 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(context.Background()); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	return NewService(store, slog.New(slog.NewJSONHandler(io.Discard, nil)))
+}
+
+func writeFixture(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		t.Fatalf("create fixture dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write fixture: %v", err)
+	}
+}
+
+func TestSampleResources_RecordsSamplesAndHistory(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	svc := newTestService(t)
+	svc.procRoot = filepath.Join(root, "proc")
+	svc.cgroupRoot = filepath.Join(root, "cgroup")
+	svc.diskPath = root
+	now := time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+
+	writeFixture(t, filepath.Join(svc.procRoot, "meminfo"), "MemTotal:        2048000 kB\nMemFree:          100000 kB\nMemAvailable:     512000 kB\n")
+	writeFixture(t, filepath.Join(svc.procRoot, "stat"), "cpu  100 0 100 700 100 0 0 0 0 0\ncpu0 100 0 100 700 100 0 0 0 0 0\n")
+	writeFixture(t, filepath.Join(svc.cgroupRoot, "system.slice", "aipanel-runtime-nginx.service", "memory.current"), "10485760\n")
+	writeFixture(t, filepath.Join(svc.cgroupRoot, "system.slice", "ssh.service", "memory.current"), "1\n")
+	docroot := filepath.Join(root, "www", "client.example", "public_html")
+	writeFixture(t, filepath.Join(docroot, "index.php"), "<?php echo 'hi';")
+	writeFixture(t, filepath.Join(docroot, "assets", "app.js"), "console.log(1)")
+	if err := svc.store.ExecPanel(ctx, fmt.Sprintf(
+		"INSERT INTO sites(domain, root_dir, system_user, created_at, updated_at) VALUES('client.example','%s','site_client',1,1);", docroot)); err != nil {
+		t.Fatalf("insert site: %v", err)
+	}
+
+	summary, err := svc.ResourceSummary(ctx)
+	if err != nil || summary.Sample != nil {
+		t.Fatalf("expected no sample before the first run, got %+v (%v)", summary, err)
+	}
+	if err := svc.SampleResources(ctx); err != nil {
+		t.Fatalf("first sample: %v", err)
+	}
+	// 200 of the next 400 ticks are busy.
+	writeFixture(t, filepath.Join(svc.procRoot, "stat"), "cpu  250 0 150 900 100 0 0 0 0 0\n")
+	now = now.Add(ResourceSampleInterval)
+	if err := svc.SampleResources(ctx); err != nil {
+		t.Fatalf("second sample: %v", err)
+	}
+
+	summary, err = svc.ResourceSummary(ctx)
+	if err != nil || summary.Sample == nil {
+		t.Fatalf("summary: %+v (%v)", summary, err)
+	}
+	sample := summary.Sample
+	if sample.CPUPercent != 50 || summary.CPUPeakPercent != 50 {
+		t.Fatalf("expected 50%% cpu, got %v (peak %v)", sample.CPUPercent, summary.CPUPeakPercent)
+	}
+	if sample.MemoryTotalBytes != 2048000*1024 || sample.MemoryUsedBytes != (2048000-512000)*1024 {
+		t.Fatalf("unexpected memory: %+v", sample)
+	}
+	if sample.DiskTotalBytes <= 0 || sample.DiskUsedBytes <= 0 {
+		t.Fatalf("expected disk usage of the temp dir, got %+v", sample)
+	}
+	if got := sample.Sites["client.example"]; got != int64(len("<?php echo 'hi';")+len("console.log(1)")) {
+		t.Fatalf("unexpected docroot size %d", got)
+	}
+	if len(sample.Units) != 1 || sample.Units["aipanel-runtime-nginx.service"] != 10485760 {
+		t.Fatalf("expected only runtime units, got %v", sample.Units)
+	}
+
+	history, err := svc.ResourceHistory(ctx, time.Hour)
+	if err != nil || len(history) != 2 || history[0].CPUPercent != 0 || !history[1].CollectedAt.Equal(now) {
+		t.Fatalf("expected both samples oldest first, got %+v (%v)", history, err)
+	}
+	now = now.Add(2 * time.Hour)
+	if history, err := svc.ResourceHistory(ctx, time.Hour); err != nil || len(history) != 0 {
+		t.Fatalf("expected samples outside the range left out, got %d (%v)", len(history), err)
+	}
+}
+
+func TestParseHistoryRange(t *testing.T) {
+	for raw, want := range map[string]time.Duration{"": 24 * time.Hour, "6h": 6 * time.Hour, "7d": 7 * 24 * time.Hour, "30m": 30 * time.Minute} {
+		if got, err := ParseHistoryRange(raw); err != nil || got != want {
+			t.Errorf("ParseHistoryRange(%q) = %v, %v; want %v", raw, got, err, want)
+		}
+	}
+	for _, raw := range []string{"1m", "31d", "xd", "forever", "-6h"} {
+		if _, err := ParseHistoryRange(raw); err == nil {
+			t.Errorf("expected %q rejected", raw)
+		}
+	}
+}
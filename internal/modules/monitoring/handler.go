@@ -0,0 +1,59 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/robsonek/aiPanel/internal/platform/jsonstream"
+)
+
+// Handler exposes HTTP handlers for the panel self-test and system stats.
+type Handler struct {
+	svc *Service
+}
+
+// NewHandler creates monitoring HTTP handler.
+func NewHandler(svc *Service) *Handler {
+	return &Handler{svc: svc}
+}
+
+// HandleSelfTest serves GET /api/system/self-test?limit=N (recent runs) and
+// POST /api/system/self-test (run now).
+func (h *Handler) HandleSelfTest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		runs, err := h.svc.ListSelfTestRuns(r.Context(), limit)
+		if err != nil {
+			http.Error(w, "failed to list self-test runs", http.StatusInternalServerError)
+			return
+		}
+		jsonstream.List(w, r, "runs", runs)
+	case http.MethodPost:
+		run, err := h.svc.RunSelfTest(r.Context())
+		if err != nil {
+			http.Error(w, "failed to run self-test: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, run)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleStats serves GET /api/system/stats: the current nginx connection
+// and request rates.
+func (h *Handler) HandleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.svc.Stats(r.Context()))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
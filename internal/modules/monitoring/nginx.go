@@ -0,0 +1,171 @@
+package monitoring
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/metrics"
+)
+
+// nginxStatusTimeout bounds one stub_status request; it is served by nginx
+// itself, so anything slower means nginx is in trouble.
+const nginxStatusTimeout = 5 * time.Second
+
+// NginxStats is one reading of nginx stub_status. The per-second rates
+// cover the time since the previous reading and are zero for the first
+// one and after nginx restarted (the counters went back).
+type NginxStats struct {
+	ActiveConnections int64     `json:"active_connections"`
+	Reading           int64     `json:"reading"`
+	Writing           int64     `json:"writing"`
+	Waiting           int64     `json:"waiting"`
+	Accepts           int64     `json:"accepts"`
+	Handled           int64     `json:"handled"`
+	Requests          int64     `json:"requests"`
+	RequestsPerSecond float64   `json:"requests_per_second"`
+	AcceptsPerSecond  float64   `json:"accepts_per_second"`
+	CollectedAt       time.Time `json:"collected_at"`
+}
+
+// SystemStats are the current values behind the dashboard. Nginx is the
+// last successful reading; NginxError is set while polling fails.
+type SystemStats struct {
+	Nginx      *NginxStats `json:"nginx"`
+	NginxError string      `json:"nginx_error,omitempty"`
+}
+
+// SetNginxStatusURL sets the loopback stub_status location polled by
+// PollNginx; empty disables polling.
+func (s *Service) SetNginxStatusURL(url string) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	s.nginxStatusURL = strings.TrimSpace(url)
+}
+
+// Stats returns the current system stats, reading nginx once when it was
+// not polled yet.
+func (s *Service) Stats(ctx context.Context) SystemStats {
+	s.statsMu.Lock()
+	polled := s.nginx != nil || s.nginxErr != ""
+	s.statsMu.Unlock()
+	if !polled {
+		_ = s.PollNginx(ctx)
+	}
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	out := SystemStats{NginxError: s.nginxErr}
+	if s.nginx != nil {
+		current := *s.nginx
+		out.Nginx = &current
+	}
+	return out
+}
+
+// PollNginx reads stub_status, derives the request and connection rates
+// from the previous reading, and publishes them as panel metrics. A
+// failure is kept for Stats and returned only when polling was working
+// before, so the scheduler logs it once rather than on every poll.
+func (s *Service) PollNginx(ctx context.Context) error {
+	s.statsMu.Lock()
+	url := s.nginxStatusURL
+	s.statsMu.Unlock()
+	if url == "" {
+		return nil
+	}
+	reading, err := fetchNginxStatus(ctx, url)
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	if err != nil {
+		first := s.nginxErr == ""
+		s.nginxErr = err.Error()
+		if first {
+			return err
+		}
+		return nil
+	}
+	reading.CollectedAt = s.now().UTC()
+	if prev := s.nginx; prev != nil && reading.Requests >= prev.Requests && reading.Accepts >= prev.Accepts {
+		if elapsed := reading.CollectedAt.Sub(prev.CollectedAt).Seconds(); elapsed > 0 {
+			reading.RequestsPerSecond = float64(reading.Requests-prev.Requests) / elapsed
+			reading.AcceptsPerSecond = float64(reading.Accepts-prev.Accepts) / elapsed
+		}
+	}
+	s.nginx, s.nginxErr = &reading, ""
+	metrics.Default.SetGauge("nginx_connections_active", nil, float64(reading.ActiveConnections))
+	for state, n := range map[string]int64{"reading": reading.Reading, "writing": reading.Writing, "waiting": reading.Waiting} {
+		metrics.Default.SetGauge("nginx_connections", map[string]string{"state": state}, float64(n))
+	}
+	metrics.Default.SetGauge("nginx_requests_per_second", nil, reading.RequestsPerSecond)
+	metrics.Default.SetGauge("nginx_accepts_per_second", nil, reading.AcceptsPerSecond)
+	return nil
+}
+
+func fetchNginxStatus(ctx context.Context, url string) (NginxStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, nginxStatusTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return NginxStats{}, fmt.Errorf("nginx status: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return NginxStats{}, fmt.Errorf("nginx status: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	if err != nil {
+		return NginxStats{}, fmt.Errorf("nginx status: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return NginxStats{}, fmt.Errorf("nginx status: %s answered %s", url, resp.Status)
+	}
+	return parseStubStatus(string(body))
+}
+
+// parseStubStatus parses the stub_status page:
+//
+//	Active connections: 291
+//	server accepts handled requests
+//	 16630948 16630948 31070465
+//	Reading: 6 Writing: 179 Waiting: 106
+func parseStubStatus(body string) (NginxStats, error) {
+	var st NginxStats
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if len(lines) != 4 {
+		return NginxStats{}, fmt.Errorf("nginx status: unexpected stub_status page")
+	}
+	active, ok := strings.CutPrefix(strings.TrimSpace(lines[0]), "Active connections:")
+	if !ok {
+		return NginxStats{}, fmt.Errorf("nginx status: missing active connections")
+	}
+	counters := strings.Fields(lines[2])
+	states := strings.Fields(lines[3])
+	if len(counters) != 3 || len(states) != 6 || states[0] != "Reading:" || states[2] != "Writing:" || states[4] != "Waiting:" {
+		return NginxStats{}, fmt.Errorf("nginx status: unexpected stub_status page")
+	}
+	var err error
+	for _, f := range []struct {
+		dst *int64
+		raw string
+	}{
+		{&st.ActiveConnections, strings.TrimSpace(active)},
+		{&st.Accepts, counters[0]},
+		{&st.Handled, counters[1]},
+		{&st.Requests, counters[2]},
+		{&st.Reading, states[1]},
+		{&st.Writing, states[3]},
+		{&st.Waiting, states[5]},
+	} {
+		if *f.dst, err = strconv.ParseInt(f.raw, 10, 64); err != nil {
+			return NginxStats{}, fmt.Errorf("nginx status: invalid number %q", f.raw)
+		}
+	}
+	return st, nil
+}
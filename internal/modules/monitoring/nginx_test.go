@@ -0,0 +1,85 @@
+package monitoring
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/metrics"
+)
+
+func TestParseStubStatus(t *testing.T) {
+	st, err := parseStubStatus("Active connections: 291 \nserver accepts handled requests\n 16630948 16630948 31070465 \nReading: 6 Writing: 179 Waiting: 106 \n")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := NginxStats{ActiveConnections: 291, Accepts: 16630948, Handled: 16630948, Requests: 31070465, Reading: 6, Writing: 179, Waiting: 106}
+	if st != want {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	for _, body := range []string{"", "<html>404</html>", "Active connections: x\nserver accepts handled requests\n 1 1 1\nReading: 0 Writing: 1 Waiting: 0\n"} {
+		if _, err := parseStubStatus(body); err == nil {
+			t.Fatalf("expected %q rejected", body)
+		}
+	}
+}
+
+func TestPollNginx_DerivesRatesAndPublishesGauges(t *testing.T) {
+	ctx := context.Background()
+	requests, status := 1000, http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = fmt.Fprintf(w, "Active connections: 3 \nserver accepts handled requests\n 50 50 %d \nReading: 0 Writing: 1 Waiting: 2 \n", requests)
+	}))
+	defer srv.Close()
+
+	svc := newTestService(t)
+	now := time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+	svc.SetNginxStatusURL(srv.URL)
+
+	first := svc.Stats(ctx)
+	if first.Nginx == nil || first.Nginx.ActiveConnections != 3 || first.Nginx.RequestsPerSecond != 0 {
+		t.Fatalf("expected a first reading without a rate, got %+v", first)
+	}
+	now, requests = now.Add(10*time.Second), 1250
+	if err := svc.PollNginx(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if got := svc.Stats(ctx).Nginx.RequestsPerSecond; got != 25 {
+		t.Fatalf("expected 25 requests/s, got %v", got)
+	}
+	gauges := map[string]float64{}
+	for _, g := range metrics.Default.Snapshot().Gauges {
+		gauges[g.Name+g.Labels["state"]] = g.Value
+	}
+	if gauges["nginx_requests_per_second"] != 25 || gauges["nginx_connections_active"] != 3 || gauges["nginx_connectionswaiting"] != 2 {
+		t.Fatalf("expected the reading published as gauges, got %v", gauges)
+	}
+
+	// Only the first failure is returned; the last reading stays visible.
+	status = http.StatusForbidden
+	if err := svc.PollNginx(ctx); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("expected the failure reported, got %v", err)
+	}
+	if err := svc.PollNginx(ctx); err != nil {
+		t.Fatalf("expected a repeated failure kept quiet, got %v", err)
+	}
+	stats := svc.Stats(ctx)
+	if stats.Nginx == nil || stats.Nginx.Requests != 1250 || !strings.Contains(stats.NginxError, "403") {
+		t.Fatalf("expected the last reading with the error, got %+v", stats)
+	}
+
+	// A restarted nginx starts its counters over; no negative rate.
+	now, requests, status = now.Add(10*time.Second), 10, http.StatusOK
+	if err := svc.PollNginx(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if stats := svc.Stats(ctx); stats.Nginx.RequestsPerSecond != 0 || stats.NginxError != "" {
+		t.Fatalf("expected the rate reset after a restart, got %+v", stats)
+	}
+}
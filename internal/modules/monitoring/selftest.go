@@ -0,0 +1,358 @@
+package monitoring
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/notify"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+const (
+	// selfTestRunsKept bounds stored history to one week of hourly runs.
+	selfTestRunsKept = 168
+	// chronicThreshold is the number of consecutive failed runs of one
+	// check before an alert is sent.
+	chronicThreshold = 3
+	// checkTimeout bounds a single check so one hung probe cannot stall
+	// the whole run.
+	checkTimeout = 30 * time.Second
+	// detailLimit bounds the stored detail of one result.
+	detailLimit = 2048
+)
+
+// CheckFunc probes one part of the panel. It returns a short detail on
+// success and an error describing the problem on failure.
+type CheckFunc func(ctx context.Context) (string, error)
+
+// Result is the outcome of one check within a self-test run.
+type Result struct {
+	Name                string `json:"name"`
+	OK                  bool   `json:"ok"`
+	Detail              string `json:"detail"`
+	DurationMS          int64  `json:"duration_ms"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+}
+
+// SelfTestRun is one recorded pass over all registered checks.
+type SelfTestRun struct {
+	ID        int64     `json:"id"`
+	StartedAt time.Time `json:"started_at"`
+	OK        bool      `json:"ok"`
+	Results   []Result  `json:"results"`
+}
+
+type namedCheck struct {
+	name string
+	run  CheckFunc
+}
+
+// Service runs the panel self-test and keeps its history in panel.db.
+type Service struct {
+	store  *sqlite.Store
+	log    *slog.Logger
+	notify notify.Func
+
+	// mu serializes runs so scheduled and manual runs do not interleave.
+	mu     sync.Mutex
+	checks []namedCheck
+
+	// statsMu guards the last nginx stub_status reading.
+	statsMu        sync.Mutex
+	nginxStatusURL string
+	nginx          *NginxStats
+	nginxErr       string
+	now            func() time.Time
+}
+
+// NewService creates a self-test service with the built-in database check.
+// Checks owned by other modules are added with AddCheck.
+func NewService(store *sqlite.Store, log *slog.Logger) *Service {
+	if log == nil {
+		log = slog.Default()
+	}
+	s := &Service{store: store, log: log, now: time.Now}
+	s.AddCheck("database", s.checkDatabase)
+	return s
+}
+
+// AddCheck registers a check; checks run in registration order.
+func (s *Service) AddCheck(name string, run CheckFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks = append(s.checks, namedCheck{name: name, run: run})
+}
+
+// SetNotifier sets the alert channel for chronically failing checks.
+func (s *Service) SetNotifier(n notify.Func) {
+	s.notify = n
+}
+
+// RunSelfTest runs every check, records the results and alerts once when
+// a check reaches chronicThreshold consecutive failures.
+func (s *Service) RunSelfTest(ctx context.Context) (SelfTestRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run := SelfTestRun{StartedAt: time.Now().UTC(), OK: true, Results: make([]Result, 0, len(s.checks))}
+	for _, check := range s.checks {
+		result := runCheck(ctx, check)
+		if !result.OK {
+			run.OK = false
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	previous, err := s.lastFailures(ctx)
+	if err != nil {
+		return SelfTestRun{}, err
+	}
+	for i := range run.Results {
+		if !run.Results[i].OK {
+			run.Results[i].ConsecutiveFailures = previous[run.Results[i].Name] + 1
+		}
+	}
+	if run.ID, err = s.record(ctx, run); err != nil {
+		return SelfTestRun{}, err
+	}
+
+	for _, result := range run.Results {
+		switch {
+		case result.ConsecutiveFailures == chronicThreshold:
+			s.alert(ctx, result)
+		case result.OK && previous[result.Name] >= chronicThreshold:
+			s.log.Info("self-test check recovered", "check", result.Name, "failures", previous[result.Name])
+		}
+	}
+	return run, nil
+}
+
+// ListSelfTestRuns returns the most recent runs, newest first.
+func (s *Service) ListSelfTestRuns(ctx context.Context, limit int) ([]SelfTestRun, error) {
+	if limit <= 0 || limit > selfTestRunsKept {
+		limit = selfTestRunsKept
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT id, started_at, ok
+FROM self_test_runs
+ORDER BY id DESC
+LIMIT %d;`, limit))
+	if err != nil {
+		return nil, fmt.Errorf("list self-test runs: %w", err)
+	}
+	runs := make([]SelfTestRun, 0, len(rows))
+	index := map[int64]int{}
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		id, err := toInt64(row["id"])
+		if err != nil {
+			return nil, fmt.Errorf("parse self-test run id: %w", err)
+		}
+		startedAt, err := toInt64(row["started_at"])
+		if err != nil {
+			return nil, fmt.Errorf("parse self-test run started_at: %w", err)
+		}
+		ok, _ := toInt64(row["ok"])
+		index[id] = len(runs)
+		ids = append(ids, strconv.FormatInt(id, 10))
+		runs = append(runs, SelfTestRun{
+			ID:        id,
+			StartedAt: time.Unix(startedAt, 0).UTC(),
+			OK:        ok == 1,
+			Results:   []Result{},
+		})
+	}
+	if len(ids) == 0 {
+		return runs, nil
+	}
+
+	rows, err = s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT run_id, check_name, ok, detail, duration_ms, consecutive_failures
+FROM self_test_results
+WHERE run_id IN (%s)
+ORDER BY id;`, strings.Join(ids, ",")))
+	if err != nil {
+		return nil, fmt.Errorf("list self-test results: %w", err)
+	}
+	for _, row := range rows {
+		fields := map[string]int64{}
+		for _, key := range []string{"run_id", "ok", "duration_ms", "consecutive_failures"} {
+			v, err := toInt64(row[key])
+			if err != nil {
+				return nil, fmt.Errorf("parse self-test result %s: %w", key, err)
+			}
+			fields[key] = v
+		}
+		i, found := index[fields["run_id"]]
+		if !found {
+			continue
+		}
+		name, _ := row["check_name"].(string)
+		detail, _ := row["detail"].(string)
+		runs[i].Results = append(runs[i].Results, Result{
+			Name:                name,
+			OK:                  fields["ok"] == 1,
+			Detail:              detail,
+			DurationMS:          fields["duration_ms"],
+			ConsecutiveFailures: int(fields["consecutive_failures"]),
+		})
+	}
+	return runs, nil
+}
+
+func runCheck(ctx context.Context, check namedCheck) (result Result) {
+	result.Name = check.name
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	started := time.Now()
+	defer func() {
+		result.DurationMS = time.Since(started).Milliseconds()
+		if r := recover(); r != nil {
+			result.OK = false
+			result.Detail = fmt.Sprintf("check panicked: %v", r)
+		}
+		result.Detail = truncate(result.Detail, detailLimit)
+	}()
+	detail, err := check.run(ctx)
+	if err != nil {
+		result.Detail = err.Error()
+		return result
+	}
+	result.OK = true
+	result.Detail = detail
+	return result
+}
+
+// checkDatabase writes a random token to panel.db and reads it back.
+func (s *Service) checkDatabase(ctx context.Context) (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate probe token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO self_test_probe(id, token, written_at) VALUES(1,'%s',%d)
+ON CONFLICT(id) DO UPDATE SET token = excluded.token, written_at = excluded.written_at;`,
+		token, time.Now().Unix())); err != nil {
+		return "", fmt.Errorf("write probe row: %w", err)
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, `SELECT token FROM self_test_probe WHERE id = 1;`)
+	if err != nil {
+		return "", fmt.Errorf("read probe row: %w", err)
+	}
+	if len(rows) == 0 {
+		return "", fmt.Errorf("probe row missing after write")
+	}
+	if got, _ := rows[0]["token"].(string); got != token {
+		return "", fmt.Errorf("probe row read back %q, wrote %q", got, token)
+	}
+	return "panel.db read/write ok", nil
+}
+
+// lastFailures returns the consecutive failure count of each check as of
+// its latest recorded result.
+func (s *Service) lastFailures(ctx context.Context) (map[string]int, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, `
+SELECT r.check_name, r.consecutive_failures
+FROM self_test_results r
+JOIN (SELECT check_name, MAX(id) AS id FROM self_test_results GROUP BY check_name) latest ON latest.id = r.id;`)
+	if err != nil {
+		return nil, fmt.Errorf("load previous self-test results: %w", err)
+	}
+	failures := make(map[string]int, len(rows))
+	for _, row := range rows {
+		name, _ := row["check_name"].(string)
+		n, err := toInt64(row["consecutive_failures"])
+		if err != nil {
+			return nil, fmt.Errorf("parse consecutive_failures: %w", err)
+		}
+		failures[name] = int(n)
+	}
+	return failures, nil
+}
+
+func (s *Service) record(ctx context.Context, run SelfTestRun) (int64, error) {
+	rows, err := s.store.QueryPanelWriteJSON(ctx, fmt.Sprintf(`
+INSERT INTO self_test_runs(started_at, ok) VALUES(%d,%d)
+RETURNING id;`, run.StartedAt.Unix(), boolInt(run.OK)))
+	if err != nil {
+		return 0, fmt.Errorf("record self-test run: %w", err)
+	}
+	if len(rows) == 0 {
+		return 0, fmt.Errorf("record self-test run: no id returned")
+	}
+	id, err := toInt64(rows[0]["id"])
+	if err != nil {
+		return 0, fmt.Errorf("parse self-test run id: %w", err)
+	}
+
+	var sql strings.Builder
+	for _, result := range run.Results {
+		fmt.Fprintf(&sql, `
+INSERT INTO self_test_results(run_id, check_name, ok, detail, duration_ms, consecutive_failures)
+VALUES(%d,'%s',%d,'%s',%d,%d);`,
+			id, sqlEscape(result.Name), boolInt(result.OK), sqlEscape(result.Detail), result.DurationMS, result.ConsecutiveFailures)
+	}
+	fmt.Fprintf(&sql, `
+DELETE FROM self_test_runs WHERE id NOT IN (SELECT id FROM self_test_runs ORDER BY id DESC LIMIT %d);
+DELETE FROM self_test_results WHERE run_id NOT IN (SELECT id FROM self_test_runs);`, selfTestRunsKept)
+	if err := s.store.ExecPanel(ctx, sql.String()); err != nil {
+		return 0, fmt.Errorf("record self-test results: %w", err)
+	}
+	return id, nil
+}
+
+func (s *Service) alert(ctx context.Context, result Result) {
+	s.log.Error("self-test check failing", "check", result.Name, "failures", result.ConsecutiveFailures, "detail", result.Detail)
+	if s.notify == nil {
+		return
+	}
+	subject := fmt.Sprintf("aiPanel self-test: %s failing", result.Name)
+	body := fmt.Sprintf("The %s self-test check has failed %d runs in a row.\n\nLast error:\n%s\n",
+		result.Name, result.ConsecutiveFailures, result.Detail)
+	if err := s.notify(ctx, subject, body); err != nil {
+		s.log.Warn("send self-test alert", "check", result.Name, "error", err.Error())
+	}
+}
+
+func truncate(in string, limit int) string {
+	if len(in) <= limit {
+		return in
+	}
+	return in[:limit] + "…"
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func sqlEscape(in string) string {
+	return strings.ReplaceAll(in, "'", "''")
+}
+
+func toInt64(v any) (int64, error) {
+	switch t := v.(type) {
+	case float64:
+		return int64(t), nil
+	case int64:
+		return t, nil
+	case string:
+		i, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return i, nil
+	default:
+		return 0, fmt.Errorf("unsupported int conversion type %T", v)
+	}
+}
@@ -0,0 +1,91 @@
+package monitoring
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(context.Background()); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	return NewService(store, slog.New(slog.NewJSONHandler(io.Discard, nil)))
+}
+
+func TestRunSelfTest_RecordsResultsAndAlertsOnChronicFailure(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t)
+	failing := true
+	svc.AddCheck("nginx", func(context.Context) (string, error) {
+		if failing {
+			return "", errors.New("nginx config test failed")
+		}
+		return "nginx -t ok", nil
+	})
+	var alerts []string
+	svc.SetNotifier(func(_ context.Context, subject, _ string) error {
+		alerts = append(alerts, subject)
+		return nil
+	})
+
+	for i := 1; i <= chronicThreshold+1; i++ {
+		run, err := svc.RunSelfTest(ctx)
+		if err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+		if run.OK || len(run.Results) != 2 {
+			t.Fatalf("run %d: expected failing run with 2 results, got %+v", i, run)
+		}
+		if db := run.Results[0]; db.Name != "database" || !db.OK {
+			t.Fatalf("run %d: expected database check to pass, got %+v", i, db)
+		}
+		if got := run.Results[1].ConsecutiveFailures; got != i {
+			t.Fatalf("run %d: expected %d consecutive failures, got %d", i, i, got)
+		}
+	}
+	if len(alerts) != 1 || !strings.Contains(alerts[0], "nginx") {
+		t.Fatalf("expected exactly one nginx alert, got %v", alerts)
+	}
+
+	failing = false
+	run, err := svc.RunSelfTest(ctx)
+	if err != nil {
+		t.Fatalf("recovery run: %v", err)
+	}
+	if !run.OK || run.Results[1].ConsecutiveFailures != 0 {
+		t.Fatalf("expected recovered run, got %+v", run)
+	}
+
+	runs, err := svc.ListSelfTestRuns(ctx, 2)
+	if err != nil {
+		t.Fatalf("list runs: %v", err)
+	}
+	if len(runs) != 2 || runs[0].ID != run.ID || !runs[0].OK || runs[1].OK {
+		t.Fatalf("unexpected run history %+v", runs)
+	}
+	if len(runs[1].Results) != 2 || runs[1].Results[1].Detail != "nginx config test failed" {
+		t.Fatalf("expected stored failure detail, got %+v", runs[1].Results)
+	}
+}
+
+func TestRunSelfTest_RecoversPanickingCheck(t *testing.T) {
+	svc := newTestService(t)
+	svc.AddCheck("broken", func(context.Context) (string, error) {
+		panic("boom")
+	})
+	run, err := svc.RunSelfTest(context.Background())
+	if err != nil {
+		t.Fatalf("run self-test: %v", err)
+	}
+	if run.OK || run.Results[1].Detail != "check panicked: boom" {
+		t.Fatalf("expected panicking check to fail, got %+v", run.Results)
+	}
+}
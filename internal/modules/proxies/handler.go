@@ -0,0 +1,134 @@
+package proxies
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/platform/etag"
+	"github.com/robsonek/aiPanel/internal/platform/jsonstream"
+)
+
+// Handler exposes HTTP handlers for proxy host CRUD.
+type Handler struct {
+	svc *Service
+}
+
+// NewHandler creates proxies HTTP handler.
+func NewHandler(svc *Service) *Handler {
+	return &Handler{svc: svc}
+}
+
+// HandleProxies serves GET/POST /api/proxies.
+func (h *Handler) HandleProxies(w http.ResponseWriter, r *http.Request, actor string) {
+	switch r.Method {
+	case http.MethodGet:
+		proxies, err := h.svc.List(r.Context())
+		if err != nil {
+			http.Error(w, "failed to list proxy hosts", http.StatusInternalServerError)
+			return
+		}
+		jsonstream.List(w, r, "proxies", proxies)
+	case http.MethodPost:
+		var req ProxyRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Actor = actor
+		proxy, err := h.svc.Create(r.Context(), req)
+		if err != nil {
+			writeProxyError(w, "failed to create proxy host", err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]any{"proxy": proxy})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleProxyByID serves GET/PUT/DELETE /api/proxies/{id}.
+func (h *Handler) HandleProxyByID(w http.ResponseWriter, r *http.Request, actor string) {
+	id, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/proxies/"), "/"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid proxy id", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		proxy, err := h.svc.Get(r.Context(), id)
+		if err != nil {
+			writeProxyError(w, "failed to get proxy host", err)
+			return
+		}
+		etag.Set(w, proxyETag(proxy))
+		writeJSON(w, http.StatusOK, map[string]any{"proxy": proxy})
+	case http.MethodPut:
+		var req ProxyRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !h.checkVersion(w, r, id) {
+			return
+		}
+		req.Actor = actor
+		proxy, err := h.svc.Update(r.Context(), id, req)
+		if err != nil {
+			writeProxyError(w, "failed to update proxy host", err)
+			return
+		}
+		etag.Set(w, proxyETag(proxy))
+		writeJSON(w, http.StatusOK, map[string]any{"proxy": proxy})
+	case http.MethodDelete:
+		if !h.checkVersion(w, r, id) {
+			return
+		}
+		if err := h.svc.Delete(r.Context(), id, actor); err != nil {
+			writeProxyError(w, "failed to delete proxy host", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func proxyETag(proxy Proxy) string {
+	return etag.For("proxy", proxy.ID, proxy.UpdatedAt)
+}
+
+// checkVersion answers 412 when If-Match names an outdated version of the
+// proxy host, so concurrent edits do not overwrite each other.
+func (h *Handler) checkVersion(w http.ResponseWriter, r *http.Request, id int64) bool {
+	if r.Header.Get("If-Match") == "" {
+		return true
+	}
+	proxy, err := h.svc.Get(r.Context(), id)
+	if err != nil {
+		writeProxyError(w, "failed to get proxy host", err)
+		return false
+	}
+	return etag.Check(w, r, proxyETag(proxy))
+}
+
+func writeProxyError(w http.ResponseWriter, prefix string, err error) {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case errors.Is(err, ErrProxyNotFound):
+		http.Error(w, "proxy host not found", http.StatusNotFound)
+	case strings.Contains(msg, "invalid"), strings.Contains(msg, "required"), strings.Contains(msg, "already exists"):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, prefix+": "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,104 @@
+package proxies
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Proxy forwards requests for Host to Upstream. Allow restricts access to
+// the listed IPs/CIDRs; an empty list allows everyone.
+type Proxy struct {
+	ID        int64     `json:"id"`
+	Host      string    `json:"host"`
+	Upstream  string    `json:"upstream"`
+	TLS       bool      `json:"tls"`
+	Websocket bool      `json:"websocket"`
+	Allow     []string  `json:"allow"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ProxyRequest creates or replaces a proxy host. TLS issues a Let's
+// Encrypt certificate for Host over http-01.
+type ProxyRequest struct {
+	Host      string   `json:"host"`
+	Upstream  string   `json:"upstream"`
+	TLS       bool     `json:"tls"`
+	Websocket bool     `json:"websocket"`
+	Allow     []string `json:"allow"`
+	Actor     string   `json:"-"`
+}
+
+var hostPattern = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?(?:\.[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?)+$`)
+
+func normalizeRequest(req ProxyRequest) (ProxyRequest, error) {
+	req.Host = strings.ToLower(strings.TrimSpace(req.Host))
+	if req.Host == "" {
+		return req, fmt.Errorf("host is required")
+	}
+	if !hostPattern.MatchString(req.Host) {
+		return req, fmt.Errorf("invalid host")
+	}
+	upstream, err := normalizeUpstream(req.Upstream)
+	if err != nil {
+		return req, err
+	}
+	req.Upstream = upstream
+	allow := make([]string, 0, len(req.Allow))
+	seen := map[string]bool{}
+	for _, entry := range req.Allow {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		entry, err := canonicalAddr(entry)
+		if err != nil {
+			return req, fmt.Errorf("invalid allow entry: %w", err)
+		}
+		if !seen[entry] {
+			seen[entry] = true
+			allow = append(allow, entry)
+		}
+	}
+	req.Allow = allow
+	return req, nil
+}
+
+// normalizeUpstream accepts http(s)://host[:port][/path] and
+// unix:/path/to.sock. Anything nginx would read as syntax is rejected.
+func normalizeUpstream(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", fmt.Errorf("upstream is required")
+	}
+	if strings.ContainsAny(raw, " \t\r\n;{}'\"$\\") {
+		return "", fmt.Errorf("invalid upstream")
+	}
+	if path, ok := strings.CutPrefix(raw, "unix:"); ok {
+		if !strings.HasPrefix(path, "/") || strings.Contains(path, ":") {
+			return "", fmt.Errorf("invalid upstream: unix socket path must be absolute")
+		}
+		return raw, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid upstream: use http://host:port or unix:/path.sock")
+	}
+	return u.String(), nil
+}
+
+// canonicalAddr canonicalizes an IP or CIDR.
+func canonicalAddr(entry string) (string, error) {
+	if ip := net.ParseIP(entry); ip != nil {
+		return ip.String(), nil
+	}
+	_, network, err := net.ParseCIDR(entry)
+	if err != nil {
+		return "", err
+	}
+	return network.String(), nil
+}
@@ -0,0 +1,3 @@
+// Package proxies manages reverse proxy hosts that are not hosted sites,
+// such as internal tools and dashboards running on a local port.
+package proxies
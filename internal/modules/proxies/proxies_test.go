@@ -0,0 +1,185 @@
+package proxies
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+	"github.com/robsonek/aiPanel/pkg/adapter"
+)
+
+type fakeNginx struct {
+	tests    int
+	reloads  int
+	failTest error
+}
+
+func (f *fakeNginx) WriteVhost(context.Context, adapter.SiteConfig) error { return nil }
+func (f *fakeNginx) RemoveVhost(context.Context, string) error            { return nil }
+
+func (f *fakeNginx) TestConfig(context.Context) error {
+	f.tests++
+	return f.failTest
+}
+
+func (f *fakeNginx) Reload(context.Context) error {
+	f.reloads++
+	return nil
+}
+
+type fakeRunner struct {
+	commands []string
+	onRun    func(args []string)
+}
+
+func (r *fakeRunner) Run(_ context.Context, name string, args ...string) (string, error) {
+	r.commands = append(r.commands, name+" "+strings.Join(args, " "))
+	if r.onRun != nil {
+		r.onRun(args)
+	}
+	return "", nil
+}
+
+func newTestService(t *testing.T, cfg config.Config) (*Service, *fakeNginx, *fakeRunner, string) {
+	t.Helper()
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	root := t.TempDir()
+	nginx := &fakeNginx{}
+	runner := &fakeRunner{}
+	svc := NewService(store, cfg, slog.Default(), runner, nginx, Options{
+		SitesAvailableDir: filepath.Join(root, "sites-available"),
+		SitesEnabledDir:   filepath.Join(root, "sites-enabled"),
+		LetsEncryptDir:    filepath.Join(root, "letsencrypt"),
+	})
+	return svc, nginx, runner, root
+}
+
+func TestService_CreateUpdateDeleteProxy(t *testing.T) {
+	ctx := context.Background()
+	svc, nginx, _, root := newTestService(t, config.Config{})
+
+	proxy, err := svc.Create(ctx, ProxyRequest{
+		Host:      "Grafana.Example.com",
+		Upstream:  "http://127.0.0.1:3000",
+		Websocket: true,
+		Allow:     []string{"10.0.0.0/8", "192.168.1.7", "10.0.0.0/8"},
+		Actor:     "admin@example.com",
+	})
+	if err != nil {
+		t.Fatalf("create proxy: %v", err)
+	}
+	if proxy.Host != "grafana.example.com" || len(proxy.Allow) != 2 || !proxy.Websocket {
+		t.Fatalf("unexpected proxy: %+v", proxy)
+	}
+	confPath := filepath.Join(root, "sites-available", "proxy-grafana.example.com.conf")
+	raw, err := os.ReadFile(confPath) //nolint:gosec // test reads a file created within temp dir.
+	if err != nil {
+		t.Fatalf("read proxy config: %v", err)
+	}
+	for _, want := range []string{
+		"server_name grafana.example.com;",
+		"allow 10.0.0.0/8;",
+		"allow 192.168.1.7;",
+		"deny all;",
+		"proxy_set_header Upgrade $http_upgrade;",
+		"proxy_pass http://127.0.0.1:3000;",
+	} {
+		if !strings.Contains(string(raw), want) {
+			t.Fatalf("expected %q in proxy config:\n%s", want, raw)
+		}
+	}
+	if _, err := os.Readlink(filepath.Join(root, "sites-enabled", "proxy-grafana.example.com.conf")); err != nil {
+		t.Fatalf("expected enabled symlink: %v", err)
+	}
+	if nginx.reloads != 1 {
+		t.Fatalf("expected one reload, got %d", nginx.reloads)
+	}
+
+	if _, err := svc.Create(ctx, ProxyRequest{Host: "grafana.example.com", Upstream: "http://127.0.0.1:3001"}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected duplicate host error, got %v", err)
+	}
+
+	updated, err := svc.Update(ctx, proxy.ID, ProxyRequest{Host: "metrics.example.com", Upstream: "unix:/run/grafana.sock"})
+	if err != nil {
+		t.Fatalf("update proxy: %v", err)
+	}
+	if updated.Host != "metrics.example.com" || len(updated.Allow) != 0 {
+		t.Fatalf("unexpected updated proxy: %+v", updated)
+	}
+	if _, err := os.Stat(confPath); !os.IsNotExist(err) {
+		t.Fatalf("expected old config to be removed, got %v", err)
+	}
+	raw, _ = os.ReadFile(filepath.Join(root, "sites-available", "proxy-metrics.example.com.conf")) //nolint:gosec // test reads a file created within temp dir.
+	if !strings.Contains(string(raw), "proxy_pass http://unix:/run/grafana.sock:;") || strings.Contains(string(raw), "deny all;") {
+		t.Fatalf("unexpected updated config:\n%s", raw)
+	}
+
+	if err := svc.Delete(ctx, proxy.ID, "admin@example.com"); err != nil {
+		t.Fatalf("delete proxy: %v", err)
+	}
+	if _, err := svc.Get(ctx, proxy.ID); !errors.Is(err, ErrProxyNotFound) {
+		t.Fatalf("expected ErrProxyNotFound, got %v", err)
+	}
+}
+
+func TestService_CreateProxyRollsBackRejectedConfig(t *testing.T) {
+	ctx := context.Background()
+	svc, nginx, _, root := newTestService(t, config.Config{})
+	nginx.failTest = errors.New("emerg")
+
+	if _, err := svc.Create(ctx, ProxyRequest{Host: "tools.example.com", Upstream: "http://127.0.0.1:8081"}); err == nil {
+		t.Fatal("expected create to fail")
+	}
+	if _, err := os.Stat(filepath.Join(root, "sites-available", "proxy-tools.example.com.conf")); !os.IsNotExist(err) {
+		t.Fatalf("expected rejected config to be removed, got %v", err)
+	}
+	if list, _ := svc.List(ctx); len(list) != 0 {
+		t.Fatalf("expected no stored proxy, got %+v", list)
+	}
+}
+
+func TestService_CreateProxyIssuesCertificate(t *testing.T) {
+	ctx := context.Background()
+	svc, _, runner, root := newTestService(t, config.Config{ACMEEmail: "ops@example.com"})
+	runner.onRun = func([]string) {
+		live := filepath.Join(root, "letsencrypt", "live", "ci.example.com")
+		_ = os.MkdirAll(live, 0o750)
+		_ = os.WriteFile(filepath.Join(live, "fullchain.pem"), []byte("cert"), 0o600)
+	}
+
+	if _, err := svc.Create(ctx, ProxyRequest{Host: "ci.example.com", Upstream: "https://10.0.0.5:8443", TLS: true}); err != nil {
+		t.Fatalf("create proxy: %v", err)
+	}
+	if len(runner.commands) != 1 || !strings.HasPrefix(runner.commands[0], "certbot certonly --webroot") {
+		t.Fatalf("expected certbot to run once, got %v", runner.commands)
+	}
+	raw, _ := os.ReadFile(filepath.Join(root, "sites-available", "proxy-ci.example.com.conf")) //nolint:gosec // test reads a file created within temp dir.
+	if !strings.Contains(string(raw), "listen 443 ssl;") || !strings.Contains(string(raw), "return 301 https://$host$request_uri;") {
+		t.Fatalf("expected TLS config, got:\n%s", raw)
+	}
+}
+
+func TestNormalizeRequest_RejectsUnsafeInput(t *testing.T) {
+	for _, req := range []ProxyRequest{
+		{Host: "", Upstream: "http://127.0.0.1:1"},
+		{Host: "bad host", Upstream: "http://127.0.0.1:1"},
+		{Host: "a.example.com", Upstream: "http://127.0.0.1:1; include /etc/passwd"},
+		{Host: "a.example.com", Upstream: "ftp://127.0.0.1"},
+		{Host: "a.example.com", Upstream: "unix:relative.sock"},
+		{Host: "a.example.com", Upstream: "http://127.0.0.1:1", Allow: []string{"not-an-ip"}},
+	} {
+		if _, err := normalizeRequest(req); err == nil {
+			t.Fatalf("expected %+v to be rejected", req)
+		}
+	}
+}
@@ -0,0 +1,447 @@
+package proxies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/middleware"
+	"github.com/robsonek/aiPanel/internal/platform/settings"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+	"github.com/robsonek/aiPanel/internal/platform/systemd"
+	"github.com/robsonek/aiPanel/pkg/adapter"
+)
+
+const (
+	defaultSitesAvailableDir = "/etc/nginx/sites-available"
+	defaultSitesEnabledDir   = "/etc/nginx/sites-enabled"
+	defaultLetsEncryptDir    = "/etc/letsencrypt"
+	defaultACMEWebroot       = "/var/www/letsencrypt"
+)
+
+// ErrProxyNotFound indicates a missing proxy host.
+var ErrProxyNotFound = errors.New("proxy host not found")
+
+// Options overrides filesystem locations, mainly for tests.
+type Options struct {
+	SitesAvailableDir string
+	SitesEnabledDir   string
+	LetsEncryptDir    string
+}
+
+// Service stores proxy hosts in panel.db and renders them as nginx server
+// blocks next to the site vhosts.
+type Service struct {
+	store  *sqlite.Store
+	cfg    config.Config
+	log    *slog.Logger
+	runner systemd.Runner
+	nginx  adapter.Nginx
+	// settings overrides the ACME contact and environment of panel.yaml;
+	// nil uses cfg as is.
+	settings *settings.Store
+
+	sitesAvailableDir string
+	sitesEnabledDir   string
+	letsEncryptDir    string
+}
+
+// NewService creates a proxy host service. nginx is only used to test and
+// reload the configuration.
+func NewService(store *sqlite.Store, cfg config.Config, log *slog.Logger, runner systemd.Runner, nginx adapter.Nginx, opts Options) *Service {
+	if log == nil {
+		log = slog.Default()
+	}
+	if runner == nil {
+		runner = systemd.ExecRunner{}
+	}
+	if opts.SitesAvailableDir == "" {
+		opts.SitesAvailableDir = defaultSitesAvailableDir
+	}
+	if opts.SitesEnabledDir == "" {
+		opts.SitesEnabledDir = defaultSitesEnabledDir
+	}
+	if opts.LetsEncryptDir == "" {
+		opts.LetsEncryptDir = defaultLetsEncryptDir
+	}
+	return &Service{
+		store:             store,
+		cfg:               cfg,
+		log:               log,
+		runner:            runner,
+		nginx:             nginx,
+		sitesAvailableDir: opts.SitesAvailableDir,
+		sitesEnabledDir:   opts.SitesEnabledDir,
+		letsEncryptDir:    opts.LetsEncryptDir,
+	}
+}
+
+// SetSettings sets the store of settings changed in the panel.
+func (s *Service) SetSettings(store *settings.Store) {
+	s.settings = store
+}
+
+// List returns all proxy hosts ordered by host.
+func (s *Service) List(ctx context.Context) ([]Proxy, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, `
+SELECT id, host, upstream, tls, websocket, allow_list, created_at, updated_at
+FROM proxy_hosts
+ORDER BY host;`)
+	if err != nil {
+		return nil, fmt.Errorf("list proxy hosts: %w", err)
+	}
+	proxies := make([]Proxy, 0, len(rows))
+	for _, row := range rows {
+		p, err := mapRowToProxy(row)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, p)
+	}
+	return proxies, nil
+}
+
+// Get returns one proxy host.
+func (s *Service) Get(ctx context.Context, id int64) (Proxy, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT id, host, upstream, tls, websocket, allow_list, created_at, updated_at
+FROM proxy_hosts
+WHERE id = %d;`, id))
+	if err != nil {
+		return Proxy{}, fmt.Errorf("get proxy host: %w", err)
+	}
+	if len(rows) == 0 {
+		return Proxy{}, ErrProxyNotFound
+	}
+	return mapRowToProxy(rows[0])
+}
+
+// Create validates a proxy host, writes and activates its nginx config and
+// stores it. Nothing is stored when nginx rejects the config.
+func (s *Service) Create(ctx context.Context, req ProxyRequest) (Proxy, error) {
+	req, err := normalizeRequest(req)
+	if err != nil {
+		return Proxy{}, err
+	}
+	if err := s.checkHostFree(ctx, req.Host, 0); err != nil {
+		return Proxy{}, err
+	}
+	if err := s.apply(ctx, req); err != nil {
+		return Proxy{}, err
+	}
+	now := time.Now().Unix()
+	rows, err := s.store.QueryPanelWriteJSON(ctx, fmt.Sprintf(`
+INSERT INTO proxy_hosts(host, upstream, tls, websocket, allow_list, created_at, updated_at)
+VALUES('%s','%s',%d,%d,'%s',%d,%d)
+RETURNING id;`,
+		sqlEscape(req.Host), sqlEscape(req.Upstream), boolInt(req.TLS), boolInt(req.Websocket),
+		sqlEscape(strings.Join(req.Allow, ",")), now, now))
+	if err == nil && len(rows) == 0 {
+		err = fmt.Errorf("no id returned")
+	}
+	if err != nil {
+		s.remove(ctx, req.Host)
+		return Proxy{}, fmt.Errorf("insert proxy host: %w", err)
+	}
+	id, err := toInt64(rows[0]["id"])
+	if err != nil {
+		return Proxy{}, fmt.Errorf("parse proxy host id: %w", err)
+	}
+	_ = s.writeAudit(ctx, req.Actor, "proxies.create", fmt.Sprintf("host=%s upstream=%s tls=%t", req.Host, req.Upstream, req.TLS))
+	return s.Get(ctx, id)
+}
+
+// Update replaces the settings of a proxy host. Renaming the host removes
+// the config of the old one.
+func (s *Service) Update(ctx context.Context, id int64, req ProxyRequest) (Proxy, error) {
+	existing, err := s.Get(ctx, id)
+	if err != nil {
+		return Proxy{}, err
+	}
+	req, err = normalizeRequest(req)
+	if err != nil {
+		return Proxy{}, err
+	}
+	if req.Host != existing.Host {
+		if err := s.checkHostFree(ctx, req.Host, id); err != nil {
+			return Proxy{}, err
+		}
+	}
+	if err := s.apply(ctx, req); err != nil {
+		return Proxy{}, err
+	}
+	if req.Host != existing.Host {
+		s.remove(ctx, existing.Host)
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+UPDATE proxy_hosts
+SET host='%s', upstream='%s', tls=%d, websocket=%d, allow_list='%s', updated_at=MAX(%d, updated_at + 1)
+WHERE id = %d;`,
+		sqlEscape(req.Host), sqlEscape(req.Upstream), boolInt(req.TLS), boolInt(req.Websocket),
+		sqlEscape(strings.Join(req.Allow, ",")), time.Now().Unix(), id)); err != nil {
+		return Proxy{}, fmt.Errorf("update proxy host: %w", err)
+	}
+	_ = s.writeAudit(ctx, req.Actor, "proxies.update", fmt.Sprintf("proxy_id=%d host=%s upstream=%s tls=%t", id, req.Host, req.Upstream, req.TLS))
+	return s.Get(ctx, id)
+}
+
+// Delete removes a proxy host and its nginx config. The certificate is
+// left to certbot.
+func (s *Service) Delete(ctx context.Context, id int64, actor string) error {
+	existing, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	s.remove(ctx, existing.Host)
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf("DELETE FROM proxy_hosts WHERE id = %d;", id)); err != nil {
+		return fmt.Errorf("delete proxy host: %w", err)
+	}
+	_ = s.writeAudit(ctx, actor, "proxies.delete", fmt.Sprintf("proxy_id=%d host=%s", id, existing.Host))
+	return nil
+}
+
+// checkHostFree rejects a host served by a site or another proxy host.
+func (s *Service) checkHostFree(ctx context.Context, host string, selfID int64) error {
+	rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT 'site' AS kind FROM sites WHERE domain = '%s'
+UNION ALL
+SELECT 'proxy' AS kind FROM proxy_hosts WHERE host = '%s' AND id != %d;`,
+		sqlEscape(host), sqlEscape(host), selfID))
+	if err != nil {
+		return fmt.Errorf("check proxy host: %w", err)
+	}
+	if len(rows) > 0 {
+		kind, _ := rows[0]["kind"].(string)
+		return fmt.Errorf("%s already exists as a %s", host, kind)
+	}
+	return nil
+}
+
+// apply writes the nginx config of req and reloads nginx. With TLS and no
+// certificate yet, the plain HTTP config goes live first so certbot can
+// answer the http-01 challenge.
+func (s *Service) apply(ctx context.Context, req ProxyRequest) error {
+	data := vhostData{
+		Host:        req.Host,
+		ProxyPass:   proxyPass(req.Upstream),
+		Websocket:   req.Websocket,
+		Allow:       req.Allow,
+		ACMEWebroot: s.acmeWebroot(),
+		CertPath:    filepath.Join(s.letsEncryptDir, "live", req.Host, "fullchain.pem"),
+		KeyPath:     filepath.Join(s.letsEncryptDir, "live", req.Host, "privkey.pem"),
+	}
+	if req.TLS {
+		if _, err := os.Stat(data.CertPath); os.IsNotExist(err) {
+			if s.acmeEmail(ctx) == "" {
+				return fmt.Errorf("acme_email is required for tls")
+			}
+			if err := s.writeConfig(ctx, data); err != nil {
+				return err
+			}
+			if err := s.issueCertificate(ctx, req.Host); err != nil {
+				return err
+			}
+		}
+		data.TLS = true
+	}
+	return s.writeConfig(ctx, data)
+}
+
+// writeConfig installs the rendered config, restoring the previous one
+// when nginx rejects it.
+func (s *Service) writeConfig(ctx context.Context, data vhostData) error {
+	content, err := renderVhost(data)
+	if err != nil {
+		return err
+	}
+	availablePath, enabledPath := s.configPaths(data.Host)
+	if err := os.MkdirAll(s.sitesAvailableDir, 0o750); err != nil {
+		return fmt.Errorf("create sites-available dir: %w", err)
+	}
+	if err := os.MkdirAll(s.sitesEnabledDir, 0o750); err != nil {
+		return fmt.Errorf("create sites-enabled dir: %w", err)
+	}
+	previous, readErr := os.ReadFile(availablePath) //nolint:gosec // G304: path is built from a validated host.
+	if err := os.WriteFile(availablePath, []byte(content), 0o600); err != nil {
+		return fmt.Errorf("write proxy config: %w", err)
+	}
+	if err := os.Remove(enabledPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove old proxy symlink: %w", err)
+	}
+	if err := os.Symlink(availablePath, enabledPath); err != nil {
+		return fmt.Errorf("create proxy symlink: %w", err)
+	}
+	if err := s.nginx.TestConfig(ctx); err != nil {
+		if readErr == nil {
+			_ = os.WriteFile(availablePath, previous, 0o600)
+		} else {
+			_ = os.Remove(enabledPath)
+			_ = os.Remove(availablePath)
+		}
+		return fmt.Errorf("nginx rejected proxy config for %s: %w", data.Host, err)
+	}
+	if err := s.nginx.Reload(ctx); err != nil {
+		return fmt.Errorf("reload nginx: %w", err)
+	}
+	return nil
+}
+
+// remove drops the config of host and reloads nginx, logging failures:
+// the row is gone either way and a leftover file is harmless to retry.
+func (s *Service) remove(ctx context.Context, host string) {
+	availablePath, enabledPath := s.configPaths(host)
+	if err := os.Remove(enabledPath); err != nil && !os.IsNotExist(err) {
+		s.log.Warn("remove proxy symlink", "host", host, "error", err)
+	}
+	if err := os.Remove(availablePath); err != nil && !os.IsNotExist(err) {
+		s.log.Warn("remove proxy config", "host", host, "error", err)
+	}
+	if err := s.nginx.TestConfig(ctx); err != nil {
+		s.log.Warn("nginx config test after removing proxy host", "host", host, "error", err)
+		return
+	}
+	if err := s.nginx.Reload(ctx); err != nil {
+		s.log.Warn("reload nginx after removing proxy host", "host", host, "error", err)
+	}
+}
+
+// configPaths names proxy configs "proxy-<host>.conf" so they never
+// collide with a site vhost.
+func (s *Service) configPaths(host string) (string, string) {
+	name := "proxy-" + host + ".conf"
+	return filepath.Join(s.sitesAvailableDir, name), filepath.Join(s.sitesEnabledDir, name)
+}
+
+func (s *Service) issueCertificate(ctx context.Context, host string) error {
+	args := []string{
+		"certonly", "--webroot", "--webroot-path", s.acmeWebroot(),
+		"--domain", host,
+		"--email", s.acmeEmail(ctx),
+		"--agree-tos",
+		"--non-interactive",
+		"--keep-until-expiring",
+		"--config-dir", s.letsEncryptDir,
+	}
+	if s.acmeStaging(ctx) {
+		args = append(args, "--staging")
+	}
+	if out, err := s.runner.Run(ctx, "certbot", args...); err != nil {
+		detail := strings.TrimSpace(out)
+		if detail == "" {
+			detail = err.Error()
+		}
+		return fmt.Errorf("issue certificate for %s: %s", host, detail)
+	}
+	return nil
+}
+
+func (s *Service) acmeEmail(ctx context.Context) string {
+	if s.settings != nil {
+		return s.settings.String(ctx, settings.ACME, "email")
+	}
+	return strings.TrimSpace(s.cfg.ACMEEmail)
+}
+
+func (s *Service) acmeStaging(ctx context.Context) bool {
+	if s.settings != nil {
+		return s.settings.Bool(ctx, settings.ACME, "staging")
+	}
+	return s.cfg.ACMEStaging
+}
+
+func (s *Service) acmeWebroot() string {
+	if webroot := strings.TrimSpace(s.cfg.ACMEWebroot); webroot != "" {
+		return webroot
+	}
+	return defaultACMEWebroot
+}
+
+func (s *Service) writeAudit(ctx context.Context, actor, action, details string) error {
+	if strings.TrimSpace(actor) == "" {
+		actor = "system"
+	}
+	return s.store.ExecAudit(ctx, fmt.Sprintf(
+		"INSERT INTO audit_events(actor, action, details, remote_ip, impersonator, created_at) VALUES('%s','%s','%s','%s','%s',%d);",
+		sqlEscape(actor),
+		sqlEscape(action),
+		sqlEscape(details),
+		sqlEscape(middleware.ClientIP(ctx)),
+		sqlEscape(middleware.Impersonator(ctx)),
+		time.Now().Unix(),
+	))
+}
+
+func mapRowToProxy(row map[string]any) (Proxy, error) {
+	id, err := toInt64(row["id"])
+	if err != nil {
+		return Proxy{}, err
+	}
+	tls, err := toInt64(row["tls"])
+	if err != nil {
+		return Proxy{}, err
+	}
+	websocket, err := toInt64(row["websocket"])
+	if err != nil {
+		return Proxy{}, err
+	}
+	createdAt, err := toInt64(row["created_at"])
+	if err != nil {
+		return Proxy{}, err
+	}
+	updatedAt, err := toInt64(row["updated_at"])
+	if err != nil {
+		return Proxy{}, err
+	}
+	host, _ := row["host"].(string)
+	upstream, _ := row["upstream"].(string)
+	allowList, _ := row["allow_list"].(string)
+	allow := []string{}
+	if allowList != "" {
+		allow = strings.Split(allowList, ",")
+	}
+	return Proxy{
+		ID:        id,
+		Host:      host,
+		Upstream:  upstream,
+		TLS:       tls == 1,
+		Websocket: websocket == 1,
+		Allow:     allow,
+		CreatedAt: time.Unix(createdAt, 0).UTC(),
+		UpdatedAt: time.Unix(updatedAt, 0).UTC(),
+	}, nil
+}
+
+func boolInt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func sqlEscape(in string) string {
+	return strings.ReplaceAll(in, "'", "''")
+}
+
+func toInt64(v any) (int64, error) {
+	switch t := v.(type) {
+	case float64:
+		return int64(t), nil
+	case int64:
+		return t, nil
+	case string:
+		i, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return i, nil
+	default:
+		return 0, fmt.Errorf("unexpected numeric type %T", v)
+	}
+}
@@ -0,0 +1,84 @@
+package proxies
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// proxyPass turns an upstream into a proxy_pass target.
+func proxyPass(upstream string) string {
+	if strings.HasPrefix(upstream, "unix:") {
+		return "http://" + upstream + ":"
+	}
+	return upstream
+}
+
+type vhostData struct {
+	Host        string
+	ProxyPass   string
+	Websocket   bool
+	Allow       []string
+	ACMEWebroot string
+	TLS         bool
+	CertPath    string
+	KeyPath     string
+}
+
+var vhostTemplate = template.Must(template.New("proxy").Parse(`# Managed by aiPanel (proxy host). Changes are overwritten.
+server {
+    listen 80;
+    server_name {{ .Host }};
+
+    access_log /var/log/nginx/proxy-{{ .Host }}.access.log;
+    error_log /var/log/nginx/proxy-{{ .Host }}.error.log;
+
+    location /.well-known/acme-challenge/ {
+        root {{ .ACMEWebroot }};
+        try_files $uri =404;
+    }
+{{ if .TLS }}
+    location / {
+        return 301 https://$host$request_uri;
+    }
+}
+
+server {
+    listen 443 ssl;
+    server_name {{ .Host }};
+
+    access_log /var/log/nginx/proxy-{{ .Host }}.access.log;
+    error_log /var/log/nginx/proxy-{{ .Host }}.error.log;
+    ssl_certificate {{ .CertPath }};
+    ssl_certificate_key {{ .KeyPath }};
+{{ end }}
+    location / {
+{{- range .Allow }}
+        allow {{ . }};
+{{- end }}
+{{- if .Allow }}
+        deny all;
+{{- end }}
+        proxy_set_header Host $host;
+        proxy_set_header X-Real-IP $remote_addr;
+        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+        proxy_set_header X-Forwarded-Proto $scheme;
+{{- if .Websocket }}
+        proxy_http_version 1.1;
+        proxy_set_header Upgrade $http_upgrade;
+        proxy_set_header Connection "upgrade";
+        proxy_read_timeout 3600s;
+{{- end }}
+        proxy_pass {{ .ProxyPass }};
+    }
+}
+`))
+
+func renderVhost(data vhostData) (string, error) {
+	var buf bytes.Buffer
+	if err := vhostTemplate.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("render proxy vhost: %w", err)
+	}
+	return buf.String(), nil
+}
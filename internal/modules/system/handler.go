@@ -0,0 +1,244 @@
+package system
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+)
+
+// Handler exposes HTTP handlers for host settings.
+type Handler struct {
+	svc *Service
+}
+
+// NewHandler creates host settings HTTP handler.
+func NewHandler(svc *Service) *Handler {
+	return &Handler{svc: svc}
+}
+
+// HandleTime serves GET /api/system/time and PUT /api/system/time with
+// {"timezone": "Europe/Warsaw", "ntp": "timesyncd"|"chrony"|"off"}.
+func (h *Handler) HandleTime(w http.ResponseWriter, r *http.Request, actor string) {
+	switch r.Method {
+	case http.MethodGet:
+		status, err := h.svc.Time(r.Context())
+		if err != nil {
+			writeSystemError(w, "failed to read clock state", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	case http.MethodPut:
+		var req TimeSettings
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		status, err := h.svc.UpdateTime(r.Context(), req, actor)
+		if err != nil {
+			writeSystemError(w, "failed to update clock settings", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleUpdates serves the OS update routes:
+//
+//	GET  /api/system/updates               pending packages and reboot state
+//	POST /api/system/updates/refresh       apt-get update, then the same
+//	POST /api/system/updates/install       {"security_only": true}; queues a job
+//	GET  /api/system/updates/jobs/{id}     job state and log from ?offset=
+//	GET  /api/system/updates/schedule      automatic security updates
+//	PUT  /api/system/updates/schedule      {"auto_security", "window_start_hour", "window_hours"}
+func (h *Handler) HandleUpdates(w http.ResponseWriter, r *http.Request, actor string) {
+	sub := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/system/updates"), "/")
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		status, err := h.svc.Updates(r.Context())
+		if err != nil {
+			writeSystemError(w, "failed to list updates", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	case sub == "refresh" && r.Method == http.MethodPost:
+		status, err := h.svc.RefreshUpdates(r.Context())
+		if err != nil {
+			writeSystemError(w, "failed to refresh package lists", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	case sub == "install" && r.Method == http.MethodPost:
+		var req struct {
+			SecurityOnly bool `json:"security_only"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+		}
+		job, err := h.svc.InstallUpdates(r.Context(), req.SecurityOnly, actor)
+		if err != nil {
+			writeSystemError(w, "failed to queue updates", err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]any{"job": job})
+	case strings.HasPrefix(sub, "jobs/") && r.Method == http.MethodGet:
+		id, err := strconv.ParseInt(strings.TrimPrefix(sub, "jobs/"), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid job id", http.StatusBadRequest)
+			return
+		}
+		offset, _ := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+		progress, err := h.svc.UpdateJob(r.Context(), id, offset)
+		if err != nil {
+			writeSystemError(w, "failed to read job", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, progress)
+	case sub == "schedule" && r.Method == http.MethodGet:
+		schedule, err := h.svc.UpdateSchedule(r.Context())
+		if err != nil {
+			writeSystemError(w, "failed to read update schedule", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, schedule)
+	case sub == "schedule" && r.Method == http.MethodPut:
+		var req UpdateSchedule
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		schedule, err := h.svc.SetUpdateSchedule(r.Context(), req, actor)
+		if err != nil {
+			writeSystemError(w, "failed to save update schedule", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, schedule)
+	case sub == "" || sub == "refresh" || sub == "install" || sub == "schedule" || strings.HasPrefix(sub, "jobs/"):
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// HandlePower serves GET /api/system/power, POST /api/system/power with
+// {"action": "reboot"|"shutdown", "delay_minutes": 1, "force": false,
+// "message": ""} and POST /api/system/power/cancel. delay_minutes defaults
+// to 1 so logged-in users get a warning. Running jobs answer 409 with the
+// list of jobs.
+func (h *Handler) HandlePower(w http.ResponseWriter, r *http.Request, actor string) {
+	sub := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/system/power"), "/")
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		status, err := h.svc.PowerStatus(r.Context())
+		if err != nil {
+			writeSystemError(w, "failed to read power status", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	case sub == "" && r.Method == http.MethodPost:
+		var req struct {
+			PowerRequest
+			DelayMinutes *int `json:"delay_minutes"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.PowerRequest.DelayMinutes = 1
+		if req.DelayMinutes != nil {
+			req.PowerRequest.DelayMinutes = *req.DelayMinutes
+		}
+		status, err := h.svc.Power(r.Context(), req.PowerRequest, actor)
+		var busy *BusyError
+		if errors.As(err, &busy) {
+			writeJSON(w, http.StatusConflict, map[string]any{"error": err.Error(), "running": busy.Jobs})
+			return
+		}
+		if err != nil {
+			writeSystemError(w, "failed to schedule power action", err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, status)
+	case sub == "cancel" && r.Method == http.MethodPost:
+		status, err := h.svc.CancelPower(r.Context(), actor)
+		if err != nil {
+			writeSystemError(w, "failed to cancel power action", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	case sub == "" || sub == "cancel":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// HandleRetention serves the data retention routes:
+//
+//	GET  /api/system/retention          policy
+//	PUT  /api/system/retention          {"audit_days", "metrics_days", "jobs_days", "logs_days", "log_max_size_mb"}
+//	GET  /api/system/retention/preview  what a pass would remove now
+//	POST /api/system/retention/prune    run a pass now
+func (h *Handler) HandleRetention(w http.ResponseWriter, r *http.Request, actor string) {
+	sub := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/system/retention"), "/")
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		policy, err := h.svc.Retention(r.Context())
+		if err != nil {
+			writeSystemError(w, "failed to read retention policy", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, policy)
+	case sub == "" && r.Method == http.MethodPut:
+		var req RetentionPolicy
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		policy, err := h.svc.SetRetention(r.Context(), req, actor)
+		if err != nil {
+			writeSystemError(w, "failed to save retention policy", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, policy)
+	case (sub == "preview" && r.Method == http.MethodGet) || (sub == "prune" && r.Method == http.MethodPost):
+		run, err := h.svc.PruneRetention(r.Context(), sub == "preview", actor)
+		if err != nil {
+			writeSystemError(w, "failed to prune data", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, run)
+	case sub == "" || sub == "preview" || sub == "prune":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeSystemError(w http.ResponseWriter, prefix string, err error) {
+	switch {
+	case errors.Is(err, ErrChronyNotInstalled), errors.Is(err, ErrUpdateInProgress):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, jobqueue.ErrJobNotFound):
+		http.Error(w, "job not found", http.StatusNotFound)
+	case strings.Contains(err.Error(), "invalid"):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, prefix+": "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,231 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/modules/versionmgr"
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/internal/platform/notify"
+)
+
+// Power actions.
+const (
+	PowerReboot   = "reboot"
+	PowerShutdown = "shutdown"
+)
+
+// maxPowerDelayMinutes bounds how far ahead a power action is scheduled.
+const maxPowerDelayMinutes = 24 * 60
+
+// ErrHostBusy indicates jobs that a reboot would interrupt.
+var ErrHostBusy = errors.New("host is busy")
+
+// buildJobTypes compile or replace runtime components; interrupting them
+// leaves a half-installed component behind.
+var buildJobTypes = []string{versionmgr.InstallComponentJob, versionmgr.UpgradeAdminToolJob}
+
+// BusyError lists the running jobs that block a power action.
+type BusyError struct {
+	Jobs []RunningJob
+}
+
+func (e *BusyError) Error() string {
+	parts := make([]string, 0, len(e.Jobs))
+	for _, j := range e.Jobs {
+		part := fmt.Sprintf("#%d %s", j.ID, j.Type)
+		if j.Build {
+			part += " (build)"
+		}
+		parts = append(parts, part)
+	}
+	return fmt.Sprintf("%s: %d job(s) still running: %s", ErrHostBusy, len(e.Jobs), strings.Join(parts, ", "))
+}
+
+// Is makes errors.Is(err, ErrHostBusy) match.
+func (e *BusyError) Is(target error) bool {
+	return target == ErrHostBusy
+}
+
+// RunningJob is a job a power action would interrupt.
+type RunningJob struct {
+	ID    int64  `json:"id"`
+	Type  string `json:"type"`
+	Build bool   `json:"build"`
+}
+
+// PowerRequest reboots or powers off the host after DelayMinutes. Force
+// proceeds over running jobs, except a package upgrade.
+type PowerRequest struct {
+	Action       string `json:"action"`
+	DelayMinutes int    `json:"delay_minutes"`
+	Force        bool   `json:"force"`
+	Message      string `json:"message"`
+}
+
+// PowerStatus is what a power action has to wait for, and the action
+// systemd has scheduled, if any.
+type PowerStatus struct {
+	RebootRequired bool            `json:"reboot_required"`
+	RebootPackages []string        `json:"reboot_packages"`
+	Running        []RunningJob    `json:"running"`
+	Queued         int             `json:"queued"`
+	QueuePaused    bool            `json:"queue_paused"`
+	Scheduled      *ScheduledPower `json:"scheduled,omitempty"`
+}
+
+// ScheduledPower is a pending shutdown(8) action.
+type ScheduledPower struct {
+	Action string    `json:"action"`
+	At     time.Time `json:"at"`
+}
+
+// SetNotifier sets the channel that announces power actions.
+func (s *Service) SetNotifier(n notify.Func) {
+	s.notify = n
+}
+
+// PowerStatus reports running jobs, the reboot flag and any scheduled
+// power action.
+func (s *Service) PowerStatus(ctx context.Context) (PowerStatus, error) {
+	if s.jobs == nil {
+		return PowerStatus{}, ErrNoJobQueue
+	}
+	active, err := s.jobs.Active(ctx)
+	if err != nil {
+		return PowerStatus{}, err
+	}
+	status := PowerStatus{Running: []RunningJob{}, QueuePaused: s.jobs.Paused()}
+	for _, job := range active {
+		if job.Status != jobqueue.StatusRunning {
+			status.Queued++
+			continue
+		}
+		status.Running = append(status.Running, RunningJob{
+			ID:    job.ID,
+			Type:  job.Type,
+			Build: slices.Contains(buildJobTypes, job.Type),
+		})
+	}
+	status.RebootRequired, status.RebootPackages = s.rebootRequired()
+	status.Scheduled = s.scheduledPower()
+	return status, nil
+}
+
+// Power drains the job queue and schedules a reboot or power-off with
+// shutdown(8), which also warns logged-in users. Queued jobs stay queued
+// and run after the next start. Running jobs block the action unless
+// req.Force is set; a running package upgrade always does.
+func (s *Service) Power(ctx context.Context, req PowerRequest, actor string) (PowerStatus, error) {
+	req.Action = strings.ToLower(strings.TrimSpace(req.Action))
+	mode := ""
+	switch req.Action {
+	case PowerReboot:
+		mode = "-r"
+	case PowerShutdown:
+		mode = "-P"
+	default:
+		return PowerStatus{}, fmt.Errorf("invalid power action %q: use %s or %s", req.Action, PowerReboot, PowerShutdown)
+	}
+	if req.DelayMinutes < 0 || req.DelayMinutes > maxPowerDelayMinutes {
+		return PowerStatus{}, fmt.Errorf("invalid delay_minutes: must be between 0 and %d", maxPowerDelayMinutes)
+	}
+	if s.jobs == nil {
+		return PowerStatus{}, ErrNoJobQueue
+	}
+
+	// Pause first so no job starts between the check and shutdown. A pause
+	// left by an earlier scheduled action is kept when this one fails.
+	resume := func() {}
+	if !s.jobs.Paused() {
+		if err := s.jobs.Pause(); err != nil {
+			return PowerStatus{}, err
+		}
+		resume = func() { _ = s.jobs.Resume() }
+	}
+	status, err := s.PowerStatus(ctx)
+	if err != nil {
+		resume()
+		return PowerStatus{}, err
+	}
+	if len(status.Running) > 0 && (!req.Force || slices.ContainsFunc(status.Running, func(j RunningJob) bool {
+		return j.Type == InstallUpdatesJob
+	})) {
+		resume()
+		return status, &BusyError{Jobs: status.Running}
+	}
+
+	when := "now"
+	if req.DelayMinutes > 0 {
+		when = "+" + strconv.Itoa(req.DelayMinutes)
+	}
+	message := strings.TrimSpace(req.Message)
+	if message == "" {
+		message = fmt.Sprintf("aiPanel: host %s requested by %s", req.Action, actorOrSystem(actor))
+	}
+	if out, err := s.runner.Run(ctx, "shutdown", mode, when, message); err != nil {
+		resume()
+		return PowerStatus{}, fmt.Errorf("shutdown %s %s: %w: %s", mode, when, err, strings.TrimSpace(out))
+	}
+
+	details := fmt.Sprintf("delay_minutes=%d force=%t running_jobs=%d queued_jobs=%d", req.DelayMinutes, req.Force, len(status.Running), status.Queued)
+	_ = s.writeAudit(ctx, actor, "system.power."+req.Action, details)
+	s.log.Warn("host power action scheduled", "action", req.Action, "delay_minutes", req.DelayMinutes, "actor", actor, "force", req.Force)
+	if s.notify != nil {
+		subject := fmt.Sprintf("aiPanel: host %s in %d minute(s)", req.Action, req.DelayMinutes)
+		body := fmt.Sprintf("%s\n\nRequested by %s. %d job(s) were running and %d are queued until the panel starts again.\n",
+			message, actorOrSystem(actor), len(status.Running), status.Queued)
+		if err := s.notify(ctx, subject, body); err != nil {
+			s.log.Warn("power action notification failed", "error", err.Error())
+		}
+	}
+	return s.PowerStatus(ctx)
+}
+
+// CancelPower cancels a scheduled power action and resumes the job queue.
+func (s *Service) CancelPower(ctx context.Context, actor string) (PowerStatus, error) {
+	if s.jobs == nil {
+		return PowerStatus{}, ErrNoJobQueue
+	}
+	if out, err := s.runner.Run(ctx, "shutdown", "-c"); err != nil {
+		return PowerStatus{}, fmt.Errorf("shutdown -c: %w: %s", err, strings.TrimSpace(out))
+	}
+	if err := s.jobs.Resume(); err != nil {
+		return PowerStatus{}, err
+	}
+	_ = s.writeAudit(ctx, actor, "system.power.cancel", "")
+	s.log.Info("host power action cancelled", "actor", actor)
+	return s.PowerStatus(ctx)
+}
+
+// scheduledPower reads the state file systemd-logind keeps for a pending
+// shutdown: USEC=<microseconds since epoch> and MODE=reboot|poweroff|halt.
+func (s *Service) scheduledPower() *ScheduledPower {
+	raw, err := os.ReadFile(s.scheduledShutdownPath)
+	if err != nil {
+		return nil
+	}
+	props := parseProperties(string(raw))
+	usec, err := strconv.ParseInt(props["USEC"], 10, 64)
+	if err != nil {
+		return nil
+	}
+	action := PowerShutdown
+	if props["MODE"] == "reboot" {
+		action = PowerReboot
+	}
+	return &ScheduledPower{Action: action, At: time.UnixMicro(usec).UTC()}
+}
+
+func actorOrSystem(actor string) string {
+	if strings.TrimSpace(actor) == "" {
+		return "system"
+	}
+	return actor
+}
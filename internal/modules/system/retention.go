@@ -0,0 +1,337 @@
+package system
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Retention categories.
+const (
+	RetentionAudit   = "audit"
+	RetentionMetrics = "metrics"
+	RetentionJobs    = "jobs"
+	RetentionLogs    = "logs"
+)
+
+const (
+	defaultAuditDays    = 365
+	defaultMetricsDays  = 7
+	defaultJobsDays     = 30
+	defaultLogsDays     = 14
+	defaultLogMaxSizeMB = 256
+	maxRetentionDays    = 3650
+	// minAuditDays keeps a typo from wiping the audit trail.
+	minAuditDays    = 30
+	maxLogMaxSizeMB = 1 << 20
+)
+
+// defaultLogDirs hold the nginx site logs and the PHP-FPM slow logs. The
+// panel log rotates itself (log_max_size_mb, log_max_backups).
+var defaultLogDirs = []string{"/var/log/nginx", "/var/log/aipanel"}
+
+// RetentionPolicy bounds how long panel records and log files are kept.
+// A zero day count keeps data forever and a zero LogMaxSizeMB never
+// rotates active logs.
+type RetentionPolicy struct {
+	AuditDays    int       `json:"audit_days"`
+	MetricsDays  int       `json:"metrics_days"`
+	JobsDays     int       `json:"jobs_days"`
+	LogsDays     int       `json:"logs_days"`
+	LogMaxSizeMB int       `json:"log_max_size_mb"`
+	LastRunAt    time.Time `json:"last_run_at,omitzero"`
+}
+
+// RetentionTarget is what one pruning pass removes, or would remove,
+// from a table or a log directory. Action is "delete", or "rotate" for
+// active logs compressed and truncated for exceeding LogMaxSizeMB.
+type RetentionTarget struct {
+	Category string `json:"category"`
+	Target   string `json:"target"`
+	Action   string `json:"action"`
+	Rows     int64  `json:"rows"`
+	Files    int    `json:"files"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// RetentionRun is the outcome of a pruning pass; a dry run only counts.
+type RetentionRun struct {
+	DryRun  bool              `json:"dry_run"`
+	Policy  RetentionPolicy   `json:"policy"`
+	Targets []RetentionTarget `json:"targets"`
+	RanAt   time.Time         `json:"ran_at"`
+}
+
+// retentionTable is a table pruned by row age. age is an SQL expression
+// giving the row time in unix seconds; where narrows the prunable rows.
+type retentionTable struct {
+	category string
+	db       string
+	table    string
+	age      string
+	where    string
+}
+
+var retentionTables = []retentionTable{
+	{category: RetentionAudit, db: "audit", table: "audit_events", age: "created_at"},
+	{category: RetentionMetrics, db: "panel", table: "site_error_rates", age: "minute"},
+	{category: RetentionMetrics, db: "panel", table: "resource_samples", age: "collected_at"},
+	// Rows written before jobs.updated_at existed have it at zero.
+	{category: RetentionJobs, db: "queue", table: "jobs", age: "MAX(created_at, updated_at)", where: "status IN ('done','failed')"},
+	{category: RetentionJobs, db: "panel", table: "mail_failures", age: "created_at"},
+	{category: RetentionJobs, db: "panel", table: "certificate_failures", age: "created_at"},
+}
+
+// Retention returns the retention policy with defaults applied.
+func (s *Service) Retention(ctx context.Context) (RetentionPolicy, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, `
+SELECT audit_days, metrics_days, jobs_days, logs_days, log_max_size_mb, last_run_at
+FROM retention_settings
+WHERE id = 1;`)
+	if err != nil {
+		return RetentionPolicy{}, fmt.Errorf("get retention policy: %w", err)
+	}
+	policy := RetentionPolicy{
+		AuditDays:    defaultAuditDays,
+		MetricsDays:  defaultMetricsDays,
+		JobsDays:     defaultJobsDays,
+		LogsDays:     defaultLogsDays,
+		LogMaxSizeMB: defaultLogMaxSizeMB,
+	}
+	if len(rows) == 0 {
+		return policy, nil
+	}
+	policy.AuditDays = int(toInt64(rows[0]["audit_days"]))
+	policy.MetricsDays = int(toInt64(rows[0]["metrics_days"]))
+	policy.JobsDays = int(toInt64(rows[0]["jobs_days"]))
+	policy.LogsDays = int(toInt64(rows[0]["logs_days"]))
+	policy.LogMaxSizeMB = int(toInt64(rows[0]["log_max_size_mb"]))
+	if last := toInt64(rows[0]["last_run_at"]); last > 0 {
+		policy.LastRunAt = time.Unix(last, 0).UTC()
+	}
+	return policy, nil
+}
+
+// SetRetention stores the retention policy. It takes effect on the next
+// pruning pass.
+func (s *Service) SetRetention(ctx context.Context, policy RetentionPolicy, actor string) (RetentionPolicy, error) {
+	for _, f := range []struct {
+		name string
+		days int
+	}{
+		{"audit_days", policy.AuditDays},
+		{"metrics_days", policy.MetricsDays},
+		{"jobs_days", policy.JobsDays},
+		{"logs_days", policy.LogsDays},
+	} {
+		if f.days < 0 || f.days > maxRetentionDays {
+			return RetentionPolicy{}, fmt.Errorf("invalid %s: must be between 0 and %d", f.name, maxRetentionDays)
+		}
+	}
+	if policy.AuditDays > 0 && policy.AuditDays < minAuditDays {
+		return RetentionPolicy{}, fmt.Errorf("invalid audit_days: must be 0 or at least %d", minAuditDays)
+	}
+	if policy.LogMaxSizeMB < 0 || policy.LogMaxSizeMB > maxLogMaxSizeMB {
+		return RetentionPolicy{}, fmt.Errorf("invalid log_max_size_mb: must be between 0 and %d", maxLogMaxSizeMB)
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO retention_settings(id, audit_days, metrics_days, jobs_days, logs_days, log_max_size_mb, updated_at)
+VALUES(1, %d, %d, %d, %d, %d, %d)
+ON CONFLICT(id) DO UPDATE SET
+  audit_days = excluded.audit_days,
+  metrics_days = excluded.metrics_days,
+  jobs_days = excluded.jobs_days,
+  logs_days = excluded.logs_days,
+  log_max_size_mb = excluded.log_max_size_mb,
+  updated_at = excluded.updated_at;`,
+		policy.AuditDays, policy.MetricsDays, policy.JobsDays, policy.LogsDays, policy.LogMaxSizeMB, s.now().Unix(),
+	)); err != nil {
+		return RetentionPolicy{}, fmt.Errorf("set retention policy: %w", err)
+	}
+	_ = s.writeAudit(ctx, actor, "system.retention.update", fmt.Sprintf(
+		"audit_days=%d metrics_days=%d jobs_days=%d logs_days=%d log_max_size_mb=%d",
+		policy.AuditDays, policy.MetricsDays, policy.JobsDays, policy.LogsDays, policy.LogMaxSizeMB,
+	))
+	return s.Retention(ctx)
+}
+
+// PruneRetention applies the retention policy: it deletes records and
+// rotated log files older than their category allows and rotates active
+// logs over the size cap. With dryRun it only reports what would go.
+func (s *Service) PruneRetention(ctx context.Context, dryRun bool, actor string) (RetentionRun, error) {
+	policy, err := s.Retention(ctx)
+	if err != nil {
+		return RetentionRun{}, err
+	}
+	now := s.now()
+	run := RetentionRun{DryRun: dryRun, Policy: policy, Targets: []RetentionTarget{}, RanAt: now.UTC()}
+	days := map[string]int{
+		RetentionAudit:   policy.AuditDays,
+		RetentionMetrics: policy.MetricsDays,
+		RetentionJobs:    policy.JobsDays,
+	}
+	for _, t := range retentionTables {
+		target := RetentionTarget{Category: t.category, Target: t.table, Action: "delete"}
+		if days[t.category] > 0 {
+			cutoff := now.Add(-time.Duration(days[t.category]) * 24 * time.Hour).Unix()
+			if target.Rows, err = s.pruneTable(ctx, t, cutoff, dryRun); err != nil {
+				return RetentionRun{}, err
+			}
+		}
+		run.Targets = append(run.Targets, target)
+	}
+	for _, dir := range s.logDirs {
+		deleted, rotated, err := s.pruneLogDir(dir, policy, now, dryRun)
+		if err != nil {
+			return RetentionRun{}, err
+		}
+		run.Targets = append(run.Targets, deleted, rotated)
+	}
+	if dryRun {
+		return run, nil
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO retention_settings(id, audit_days, metrics_days, jobs_days, logs_days, log_max_size_mb, last_run_at, updated_at)
+VALUES(1, %d, %d, %d, %d, %d, %d, %d)
+ON CONFLICT(id) DO UPDATE SET last_run_at = excluded.last_run_at;`,
+		policy.AuditDays, policy.MetricsDays, policy.JobsDays, policy.LogsDays, policy.LogMaxSizeMB, now.Unix(), now.Unix(),
+	)); err != nil {
+		return RetentionRun{}, fmt.Errorf("record retention run: %w", err)
+	}
+	run.Policy.LastRunAt = now.UTC()
+	// Written after the audit trail was pruned, so the record of the
+	// pass itself is kept.
+	details := make([]string, 0, len(run.Targets))
+	for _, t := range run.Targets {
+		switch {
+		case t.Rows > 0:
+			details = append(details, fmt.Sprintf("%s=%d", t.Target, t.Rows))
+		case t.Files > 0:
+			details = append(details, fmt.Sprintf("%s:%s=%d", t.Target, t.Action, t.Files))
+		}
+	}
+	_ = s.writeAudit(ctx, actor, "system.retention.prune", strings.Join(details, " "))
+	return run, nil
+}
+
+func (s *Service) pruneTable(ctx context.Context, t retentionTable, cutoff int64, dryRun bool) (int64, error) {
+	query, exec := s.store.QueryPanelJSON, s.store.ExecPanel
+	switch t.db {
+	case "audit":
+		query, exec = s.store.QueryAuditJSON, s.store.ExecAudit
+	case "queue":
+		query, exec = s.store.QueryQueueJSON, s.store.ExecQueue
+	}
+	where := fmt.Sprintf("%s < %d", t.age, cutoff)
+	if t.where != "" {
+		where += " AND " + t.where
+	}
+	rows, err := query(ctx, fmt.Sprintf("SELECT COUNT(*) AS n FROM %s WHERE %s;", t.table, where))
+	if err != nil {
+		return 0, fmt.Errorf("count %s: %w", t.table, err)
+	}
+	var n int64
+	if len(rows) > 0 {
+		n = toInt64(rows[0]["n"])
+	}
+	if n == 0 || dryRun {
+		return n, nil
+	}
+	if err := exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s;", t.table, where)); err != nil {
+		return 0, fmt.Errorf("prune %s: %w", t.table, err)
+	}
+	return n, nil
+}
+
+// pruneLogDir deletes rotated logs (any file not named *.log) older than
+// LogsDays and rotates active *.log files larger than LogMaxSizeMB: they
+// are gzipped next to the original and truncated in place, so nginx and
+// php-fpm keep writing to the same file.
+func (s *Service) pruneLogDir(dir string, policy RetentionPolicy, now time.Time, dryRun bool) (deleted, rotated RetentionTarget, err error) {
+	deleted = RetentionTarget{Category: RetentionLogs, Target: dir, Action: "delete"}
+	rotated = RetentionTarget{Category: RetentionLogs, Target: dir, Action: "rotate"}
+	cutoff := now.Add(-time.Duration(policy.LogsDays) * 24 * time.Hour)
+	maxSize := int64(policy.LogMaxSizeMB) << 20
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if strings.HasSuffix(d.Name(), ".log") {
+			if maxSize == 0 || info.Size() <= maxSize {
+				return nil
+			}
+			rotated.Files++
+			rotated.Bytes += info.Size()
+			if dryRun {
+				return nil
+			}
+			return rotateLog(path, info, now)
+		}
+		if policy.LogsDays == 0 || !info.ModTime().Before(cutoff) {
+			return nil
+		}
+		deleted.Files++
+		deleted.Bytes += info.Size()
+		if dryRun {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove log: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return deleted, rotated, fmt.Errorf("prune logs in %s: %w", dir, err)
+	}
+	return deleted, rotated, nil
+}
+
+// rotateLog compresses path to "<path>-<time>.gz" and truncates it. Lines
+// written between the copy and the truncation are lost, as with
+// logrotate's copytruncate.
+func rotateLog(path string, info fs.FileInfo, now time.Time) error {
+	src, err := os.Open(path) //nolint:gosec // G304: path comes from walking the managed log dirs.
+	if err != nil {
+		return fmt.Errorf("rotate log: %w", err)
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+	dest := path + "-" + now.UTC().Format("20060102-150405") + ".gz"
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm()) //nolint:gosec // G304: see above.
+	if err != nil {
+		return fmt.Errorf("rotate log: %w", err)
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, src)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(dest)
+		return fmt.Errorf("rotate log: %w", err)
+	}
+	if err := os.Truncate(path, 0); err != nil {
+		return fmt.Errorf("truncate log: %w", err)
+	}
+	return nil
+}
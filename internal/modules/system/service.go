@@ -0,0 +1,116 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/internal/platform/middleware"
+	"github.com/robsonek/aiPanel/internal/platform/notify"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+	"github.com/robsonek/aiPanel/internal/platform/systemd"
+)
+
+const (
+	defaultChronydBin         = "/usr/sbin/chronyd"
+	defaultZoneinfoDir        = "/usr/share/zoneinfo"
+	defaultRebootRequiredPath = "/var/run/reboot-required"
+	// defaultScheduledShutdownPath is where systemd-logind records a
+	// pending shutdown(8).
+	defaultScheduledShutdownPath = "/run/systemd/shutdown/scheduled"
+)
+
+// Options overrides binary and data locations, mainly for tests.
+type Options struct {
+	ChronydBin            string
+	ZoneinfoDir           string
+	RebootRequiredPath    string
+	ScheduledShutdownPath string
+	// LogDirs are pruned by the retention policy.
+	LogDirs []string
+}
+
+// Service manages host settings through systemd tools.
+type Service struct {
+	store  *sqlite.Store
+	log    *slog.Logger
+	runner systemd.Runner
+	jobs   *jobqueue.Queue
+	notify notify.Func
+	now    func() time.Time
+
+	chronydBin            string
+	zoneinfoDir           string
+	rebootRequiredPath    string
+	scheduledShutdownPath string
+	logDirs               []string
+
+	// mu guards activeUpdate, the last queued update job.
+	mu           sync.Mutex
+	activeUpdate int64
+}
+
+// NewService creates a host settings service.
+func NewService(store *sqlite.Store, log *slog.Logger, runner systemd.Runner, opts Options) *Service {
+	if log == nil {
+		log = slog.Default()
+	}
+	if runner == nil {
+		runner = systemd.ExecRunner{}
+	}
+	if opts.ChronydBin == "" {
+		opts.ChronydBin = defaultChronydBin
+	}
+	if opts.ZoneinfoDir == "" {
+		opts.ZoneinfoDir = defaultZoneinfoDir
+	}
+	if opts.RebootRequiredPath == "" {
+		opts.RebootRequiredPath = defaultRebootRequiredPath
+	}
+	if opts.ScheduledShutdownPath == "" {
+		opts.ScheduledShutdownPath = defaultScheduledShutdownPath
+	}
+	if opts.LogDirs == nil {
+		opts.LogDirs = defaultLogDirs
+	}
+	return &Service{
+		store:                 store,
+		log:                   log,
+		runner:                runner,
+		now:                   time.Now,
+		chronydBin:            opts.ChronydBin,
+		zoneinfoDir:           opts.ZoneinfoDir,
+		rebootRequiredPath:    opts.RebootRequiredPath,
+		scheduledShutdownPath: opts.ScheduledShutdownPath,
+		logDirs:               opts.LogDirs,
+	}
+}
+
+func (s *Service) chronyInstalled() bool {
+	_, err := os.Stat(s.chronydBin)
+	return err == nil
+}
+
+func (s *Service) writeAudit(ctx context.Context, actor, action, details string) error {
+	if strings.TrimSpace(actor) == "" {
+		actor = "system"
+	}
+	return s.store.ExecAudit(ctx, fmt.Sprintf(
+		"INSERT INTO audit_events(actor, action, details, remote_ip, impersonator, created_at) VALUES('%s','%s','%s','%s','%s',%d);",
+		sqlEscape(actor),
+		sqlEscape(action),
+		sqlEscape(details),
+		sqlEscape(middleware.ClientIP(ctx)),
+		sqlEscape(middleware.Impersonator(ctx)),
+		s.now().Unix(),
+	))
+}
+
+func sqlEscape(in string) string {
+	return strings.ReplaceAll(in, "'", "''")
+}
@@ -0,0 +1,3 @@
+// Package system manages host-level settings the panel depends on: the
+// clock and its time synchronization, OS package updates, and reboots.
+package system
@@ -0,0 +1,392 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/modules/versionmgr"
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+type fakeRunner struct {
+	commands []string
+	outputs  map[string]string
+}
+
+func (r *fakeRunner) Run(_ context.Context, name string, args ...string) (string, error) {
+	cmd := strings.TrimSpace(name + " " + strings.Join(args, " "))
+	r.commands = append(r.commands, cmd)
+	return r.outputs[cmd], nil
+}
+
+func newTestService(t *testing.T, runner *fakeRunner, chrony bool) *Service {
+	t.Helper()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(context.Background()); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	dir := t.TempDir()
+	zoneinfo := filepath.Join(dir, "zoneinfo")
+	if err := os.MkdirAll(filepath.Join(zoneinfo, "Europe"), 0o755); err != nil {
+		t.Fatalf("create zoneinfo: %v", err)
+	}
+	for _, zone := range []string{"UTC", "Europe/Warsaw"} {
+		if err := os.WriteFile(filepath.Join(zoneinfo, zone), nil, 0o644); err != nil {
+			t.Fatalf("write zone: %v", err)
+		}
+	}
+	chronyd := filepath.Join(dir, "chronyd")
+	if chrony {
+		if err := os.WriteFile(chronyd, nil, 0o755); err != nil {
+			t.Fatalf("write chronyd: %v", err)
+		}
+	}
+	return NewService(store, nil, runner, Options{
+		ChronydBin:         chronyd,
+		ZoneinfoDir:        zoneinfo,
+		RebootRequiredPath: filepath.Join(dir, "reboot-required"),
+	})
+}
+
+const aptUpgradable = `Listing...
+openssl/stable-security 3.0.15-1~deb12u1 amd64 [upgradable from: 3.0.14-1~deb12u2]
+linux-image-amd64/stable-security,now 6.1.115-1 amd64 [upgradable from: 6.1.112-1]
+curl/stable 7.88.1-10+deb12u8 amd64 [upgradable from: 7.88.1-10+deb12u7]
+`
+
+func TestTime_ReportsChronyDrift(t *testing.T) {
+	runner := &fakeRunner{outputs: map[string]string{
+		"timedatectl show":           "Timezone=Europe/Warsaw\nNTP=no\nNTPSynchronized=yes\n",
+		"systemctl is-active chrony": "active\n",
+		"chronyc -c tracking":        "A9FEA97B,169.254.169.123,4,1760000000.1,-2.500000000,0.0001,0.0002,1.2,0.0,0.01,0.0005,0.0001,64.0,Normal\n",
+	}}
+	svc := newTestService(t, runner, true)
+	status, err := svc.Time(context.Background())
+	if err != nil {
+		t.Fatalf("time: %v", err)
+	}
+	if status.Timezone != "Europe/Warsaw" || status.NTPService != NTPChrony || !status.Synchronized {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	if status.DriftMS == nil || *status.DriftMS != -2500 {
+		t.Fatalf("expected -2500ms drift, got %v", status.DriftMS)
+	}
+	if _, err := svc.CheckClock(context.Background()); err == nil || !strings.Contains(err.Error(), "off by -2500ms") {
+		t.Fatalf("expected drift warning, got %v", err)
+	}
+}
+
+func TestTime_WarnsWithoutNTP(t *testing.T) {
+	runner := &fakeRunner{outputs: map[string]string{
+		"timedatectl show": "Timezone=UTC\nNTP=no\nNTPSynchronized=no\n",
+	}}
+	svc := newTestService(t, runner, false)
+	status, err := svc.Time(context.Background())
+	if err != nil {
+		t.Fatalf("time: %v", err)
+	}
+	if status.NTPService != NTPOff || status.Warning == "" {
+		t.Fatalf("expected NTP warning, got %+v", status)
+	}
+}
+
+func TestUpdateTime_SwitchesServiceAndTimezone(t *testing.T) {
+	ctx := context.Background()
+	runner := &fakeRunner{outputs: map[string]string{
+		"timedatectl show": "Timezone=Europe/Warsaw\nNTP=yes\nNTPSynchronized=yes\n",
+	}}
+	svc := newTestService(t, runner, true)
+	status, err := svc.UpdateTime(ctx, TimeSettings{Timezone: "Europe/Warsaw", NTP: "timesyncd"}, "admin@example.com")
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if status.NTPService != NTPTimesyncd || status.Warning != "" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	for _, want := range []string{
+		"timedatectl set-timezone Europe/Warsaw",
+		"systemctl disable --now chrony",
+		"timedatectl set-ntp true",
+	} {
+		if !slices.Contains(runner.commands, want) {
+			t.Fatalf("expected %q in %v", want, runner.commands)
+		}
+	}
+	rows, err := svc.store.QueryAuditJSON(ctx, "SELECT action FROM audit_events ORDER BY id;")
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("expected two audit events, got %v (%v)", rows, err)
+	}
+
+	for _, tz := range []string{"../etc/passwd", "Mars/Olympus", "Europe"} {
+		if _, err := svc.UpdateTime(ctx, TimeSettings{Timezone: tz}, "admin@example.com"); err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Fatalf("expected %q to be rejected, got %v", tz, err)
+		}
+	}
+	noChrony := newTestService(t, &fakeRunner{}, false)
+	if _, err := noChrony.UpdateTime(ctx, TimeSettings{NTP: "chrony"}, "admin@example.com"); !errors.Is(err, ErrChronyNotInstalled) {
+		t.Fatalf("expected ErrChronyNotInstalled, got %v", err)
+	}
+}
+
+func TestUpdates_ListsPackagesAndRebootState(t *testing.T) {
+	runner := &fakeRunner{outputs: map[string]string{"apt list --upgradable": aptUpgradable}}
+	svc := newTestService(t, runner, false)
+	if err := os.WriteFile(svc.rebootRequiredPath, nil, 0o644); err != nil {
+		t.Fatalf("write reboot flag: %v", err)
+	}
+	if err := os.WriteFile(svc.rebootRequiredPath+".pkgs", []byte("linux-image-6.1.0-27-amd64\nlibc6\nlibc6\n"), 0o644); err != nil {
+		t.Fatalf("write reboot pkgs: %v", err)
+	}
+	status, err := svc.Updates(context.Background())
+	if err != nil {
+		t.Fatalf("updates: %v", err)
+	}
+	if len(status.Packages) != 3 || status.SecurityCount != 2 {
+		t.Fatalf("unexpected packages: %+v", status.Packages)
+	}
+	if p := status.Packages[0]; p.Name != "openssl" || p.Version != "3.0.15-1~deb12u1" || p.CurrentVersion != "3.0.14-1~deb12u2" || !p.Security {
+		t.Fatalf("unexpected package: %+v", p)
+	}
+	if !status.RebootRequired || !slices.Equal(status.RebootPackages, []string{"linux-image-6.1.0-27-amd64", "libc6"}) {
+		t.Fatalf("unexpected reboot state: %v %v", status.RebootRequired, status.RebootPackages)
+	}
+}
+
+func TestInstallUpdates_RunsSecurityOnlyJob(t *testing.T) {
+	ctx := context.Background()
+	runner := &fakeRunner{outputs: map[string]string{"apt list --upgradable": aptUpgradable}}
+	svc := newTestService(t, runner, false)
+	queue := jobqueue.New(svc.store, nil)
+	svc.RegisterJobs(queue)
+
+	job, err := svc.InstallUpdates(ctx, true, "admin@example.com")
+	if err != nil {
+		t.Fatalf("install: %v", err)
+	}
+	if _, err := svc.InstallUpdates(ctx, false, "admin@example.com"); !errors.Is(err, ErrUpdateInProgress) {
+		t.Fatalf("expected ErrUpdateInProgress, got %v", err)
+	}
+	if _, err := queue.RunPending(ctx); err != nil {
+		t.Fatalf("run pending: %v", err)
+	}
+	progress, err := svc.UpdateJob(ctx, job.ID, 0)
+	if err != nil || progress.Job.Status != jobqueue.StatusDone {
+		t.Fatalf("expected done job, got %+v (%v)", progress.Job, err)
+	}
+	want := "env DEBIAN_FRONTEND=noninteractive apt-get install -y --only-upgrade -o Dpkg::Options::=--force-confdef -o Dpkg::Options::=--force-confold openssl linux-image-amd64"
+	if !slices.Contains(runner.commands, want) {
+		t.Fatalf("expected %q in %v", want, runner.commands)
+	}
+	if !strings.Contains(progress.Log, "apt-get update -qq") {
+		t.Fatalf("expected job log, got %q", progress.Log)
+	}
+}
+
+func TestRunAutoUpdates_OncePerWindow(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t, &fakeRunner{}, false)
+	svc.RegisterJobs(jobqueue.New(svc.store, nil))
+	if _, err := svc.SetUpdateSchedule(ctx, UpdateSchedule{AutoSecurity: true, WindowStartHour: 23, WindowHours: 3}, "admin@example.com"); err != nil {
+		t.Fatalf("set schedule: %v", err)
+	}
+	if _, err := svc.SetUpdateSchedule(ctx, UpdateSchedule{WindowStartHour: 24, WindowHours: 1}, ""); err == nil {
+		t.Fatal("expected invalid window to be rejected")
+	}
+
+	svc.now = func() time.Time { return time.Date(2026, 5, 10, 12, 0, 0, 0, time.Local) }
+	if queued, err := svc.RunAutoUpdates(ctx); err != nil || queued {
+		t.Fatalf("expected no run outside the window, got %v, %v", queued, err)
+	}
+	// 01:00 falls in the window that opened at 23:00 the day before.
+	svc.now = func() time.Time { return time.Date(2026, 5, 11, 1, 0, 0, 0, time.Local) }
+	if queued, err := svc.RunAutoUpdates(ctx); err != nil || !queued {
+		t.Fatalf("expected a run inside the window, got %v, %v", queued, err)
+	}
+	svc.activeUpdate = 0
+	if queued, err := svc.RunAutoUpdates(ctx); err != nil || queued {
+		t.Fatalf("expected one run per window, got %v, %v", queued, err)
+	}
+}
+
+func TestPower_RefusesRunningJobsAndDrainsQueue(t *testing.T) {
+	ctx := context.Background()
+	runner := &fakeRunner{}
+	svc := newTestService(t, runner, false)
+	queue := jobqueue.New(svc.store, nil)
+	svc.RegisterJobs(queue)
+	var notified []string
+	svc.SetNotifier(func(_ context.Context, subject, _ string) error {
+		notified = append(notified, subject)
+		return nil
+	})
+
+	// A build left running blocks the reboot.
+	id, err := queue.Enqueue(ctx, versionmgr.InstallComponentJob, map[string]string{"component": "nginx"})
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if err := svc.store.ExecQueue(ctx, fmt.Sprintf("UPDATE jobs SET status='running' WHERE id=%d;", id)); err != nil {
+		t.Fatalf("mark running: %v", err)
+	}
+	_, err = svc.Power(ctx, PowerRequest{Action: PowerReboot, DelayMinutes: 5}, "admin@example.com")
+	var busy *BusyError
+	if !errors.As(err, &busy) || !errors.Is(err, ErrHostBusy) || len(busy.Jobs) != 1 || !busy.Jobs[0].Build {
+		t.Fatalf("expected busy error naming the build, got %v", err)
+	}
+	if queue.Paused() {
+		t.Fatal("queue must be resumed after a refused power action")
+	}
+
+	status, err := svc.Power(ctx, PowerRequest{Action: PowerReboot, DelayMinutes: 5, Force: true}, "admin@example.com")
+	if err != nil {
+		t.Fatalf("forced reboot: %v", err)
+	}
+	if !status.QueuePaused {
+		t.Fatal("expected the queue to stay paused until the reboot")
+	}
+	want := "shutdown -r +5 aiPanel: host reboot requested by admin@example.com"
+	if !slices.Contains(runner.commands, want) {
+		t.Fatalf("expected %q in %v", want, runner.commands)
+	}
+	if len(notified) != 1 {
+		t.Fatalf("expected one notification, got %v", notified)
+	}
+	rows, err := svc.store.QueryAuditJSON(ctx, "SELECT action FROM audit_events WHERE action = 'system.power.reboot';")
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("expected power audit event, got %v (%v)", rows, err)
+	}
+
+	if _, err := svc.CancelPower(ctx, "admin@example.com"); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if queue.Paused() || !slices.Contains(runner.commands, "shutdown -c") {
+		t.Fatalf("expected cancel to resume the queue, commands %v", runner.commands)
+	}
+
+	// A package upgrade is never interrupted, even with force.
+	if err := svc.store.ExecQueue(ctx, fmt.Sprintf("UPDATE jobs SET type='%s' WHERE id=%d;", InstallUpdatesJob, id)); err != nil {
+		t.Fatalf("retype job: %v", err)
+	}
+	if _, err := svc.Power(ctx, PowerRequest{Action: PowerShutdown, Force: true}, ""); !errors.Is(err, ErrHostBusy) {
+		t.Fatalf("expected upgrade to block a forced shutdown, got %v", err)
+	}
+	if _, err := svc.Power(ctx, PowerRequest{Action: "halt"}, ""); err == nil || !strings.Contains(err.Error(), "invalid") {
+		t.Fatalf("expected invalid action, got %v", err)
+	}
+}
+
+func TestPruneRetention_PreviewThenPrune(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t, &fakeRunner{}, false)
+	now := time.Date(2026, 10, 16, 3, 45, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+	old, recent := now.AddDate(0, 0, -400).Unix(), now.AddDate(0, 0, -1).Unix()
+	if err := svc.store.ExecAudit(ctx, fmt.Sprintf(
+		"INSERT INTO audit_events(actor, action, details, created_at) VALUES('a','x','',%d),('a','y','',%d);", old, recent)); err != nil {
+		t.Fatalf("seed audit: %v", err)
+	}
+	if err := svc.store.ExecQueue(ctx, fmt.Sprintf(`
+INSERT INTO jobs(type, status, payload, created_at, updated_at) VALUES
+('t','done','{}',%d,0),('t','failed','{}',%d,%d),('t','queued','{}',%d,0);`, old, old, recent, old)); err != nil {
+		t.Fatalf("seed jobs: %v", err)
+	}
+	if err := svc.store.ExecPanel(ctx, fmt.Sprintf(
+		"INSERT INTO site_error_rates(site_id, minute, requests, errors) VALUES(1,%d,10,1),(1,%d,10,0);", old, recent)); err != nil {
+		t.Fatalf("seed rates: %v", err)
+	}
+	logs := t.TempDir()
+	svc.logDirs = []string{logs, filepath.Join(t.TempDir(), "missing")}
+	rotatedLog := filepath.Join(logs, "example.com.access.log.1.gz")
+	active := filepath.Join(logs, "php-fpm", "example.com.slow.log")
+	if err := os.MkdirAll(filepath.Dir(active), 0o755); err != nil {
+		t.Fatalf("create log dir: %v", err)
+	}
+	for path, size := range map[string]int{rotatedLog: 10, active: 2 << 20, filepath.Join(logs, "example.com.access.log"): 10} {
+		if err := os.WriteFile(path, []byte(strings.Repeat("x", size)), 0o640); err != nil {
+			t.Fatalf("write log: %v", err)
+		}
+	}
+	if err := os.Chtimes(rotatedLog, now.AddDate(0, 0, -20), now.AddDate(0, 0, -20)); err != nil {
+		t.Fatalf("age log: %v", err)
+	}
+	if _, err := svc.SetRetention(ctx, RetentionPolicy{AuditDays: 365, MetricsDays: 7, JobsDays: 30, LogsDays: 14, LogMaxSizeMB: 1}, "admin@example.com"); err != nil {
+		t.Fatalf("set retention: %v", err)
+	}
+	if _, err := svc.SetRetention(ctx, RetentionPolicy{AuditDays: 7}, ""); err == nil || !strings.Contains(err.Error(), "invalid audit_days") {
+		t.Fatalf("expected a short audit retention rejected, got %v", err)
+	}
+
+	counts := func(run RetentionRun) map[string]string {
+		out := map[string]string{}
+		for _, target := range run.Targets {
+			key := target.Target + ":" + target.Action
+			if target.Target == logs {
+				key = "logs:" + target.Action
+			}
+			out[key] = fmt.Sprintf("%d/%d", target.Rows, target.Files)
+		}
+		return out
+	}
+	want := map[string]string{
+		"audit_events:delete":         "1/0",
+		"site_error_rates:delete":     "1/0",
+		"jobs:delete":                 "1/0",
+		"mail_failures:delete":        "0/0",
+		"certificate_failures:delete": "0/0",
+		"logs:delete":                 "0/1",
+		"logs:rotate":                 "0/1",
+	}
+	preview, err := svc.PruneRetention(ctx, true, "admin@example.com")
+	if err != nil {
+		t.Fatalf("preview: %v", err)
+	}
+	for key, n := range want {
+		if got := counts(preview)[key]; got != n {
+			t.Fatalf("preview %s: expected %s, got %s (%+v)", key, n, got, preview.Targets)
+		}
+	}
+	if _, err := os.Stat(rotatedLog); err != nil {
+		t.Fatalf("expected the dry run to keep files: %v", err)
+	}
+
+	run, err := svc.PruneRetention(ctx, false, "admin@example.com")
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	for key, n := range want {
+		if got := counts(run)[key]; got != n {
+			t.Fatalf("prune %s: expected %s, got %s", key, n, got)
+		}
+	}
+	if again, _ := svc.PruneRetention(ctx, true, ""); counts(again)["jobs:delete"] != "0/0" || counts(again)["logs:rotate"] != "0/0" {
+		t.Fatalf("expected nothing left to prune, got %+v", again.Targets)
+	}
+	if _, err := os.Stat(rotatedLog); !os.IsNotExist(err) {
+		t.Fatalf("expected the old rotated log removed, got %v", err)
+	}
+	if info, err := os.Stat(active); err != nil || info.Size() != 0 {
+		t.Fatalf("expected the oversized log truncated, got %v", err)
+	}
+	if _, err := os.Stat(active + "-20261016-034500.gz"); err != nil {
+		t.Fatalf("expected a compressed copy: %v", err)
+	}
+	rows, err := svc.store.QueryQueueJSON(ctx, "SELECT status FROM jobs ORDER BY id;")
+	if err != nil || len(rows) != 2 || rows[0]["status"] != "failed" || rows[1]["status"] != "queued" {
+		t.Fatalf("expected the recent and the unfinished job kept, got %+v (%v)", rows, err)
+	}
+	rows, err = svc.store.QueryAuditJSON(ctx, "SELECT action, details FROM audit_events WHERE action = 'system.retention.prune';")
+	if err != nil || len(rows) != 1 || !strings.Contains(fmt.Sprint(rows[0]["details"]), "audit_events=1") {
+		t.Fatalf("expected the pass audited, got %+v (%v)", rows, err)
+	}
+	if policy, _ := svc.Retention(ctx); !policy.LastRunAt.Equal(now) {
+		t.Fatalf("expected the run recorded, got %+v", policy)
+	}
+}
@@ -0,0 +1,218 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/systemd"
+)
+
+// NTP services the panel can switch between.
+const (
+	NTPTimesyncd = "timesyncd"
+	NTPChrony    = "chrony"
+	NTPOff       = "off"
+)
+
+const (
+	chronyUnit = "chrony"
+	// driftWarnThreshold is the clock offset above which the clock check
+	// fails. TLS, TOTP and cron all tolerate far less than a minute, but
+	// anything above a second points at a broken time source.
+	driftWarnThreshold = time.Second
+)
+
+// ErrChronyNotInstalled indicates a host without the chrony package.
+var ErrChronyNotInstalled = errors.New("chrony is not installed")
+
+var timezonePattern = regexp.MustCompile(`^[A-Za-z0-9_+-]+(/[A-Za-z0-9_+-]+)*$`)
+
+// TimeStatus is the state of the host clock.
+type TimeStatus struct {
+	Now             time.Time `json:"now"`
+	Timezone        string    `json:"timezone"`
+	NTPService      string    `json:"ntp_service"`
+	ChronyInstalled bool      `json:"chrony_installed"`
+	Synchronized    bool      `json:"synchronized"`
+	// DriftMS is the offset from NTP time reported by chrony;
+	// systemd-timesyncd does not expose it.
+	DriftMS *float64 `json:"drift_ms,omitempty"`
+	Warning string   `json:"warning,omitempty"`
+}
+
+// TimeSettings changes the timezone and/or NTP service; empty fields are
+// left as they are.
+type TimeSettings struct {
+	Timezone string `json:"timezone"`
+	NTP      string `json:"ntp"`
+}
+
+// Time reads the clock state from timedatectl and, when chrony runs, its
+// tracking offset.
+func (s *Service) Time(ctx context.Context) (TimeStatus, error) {
+	out, err := s.runner.Run(ctx, "timedatectl", "show")
+	if err != nil {
+		return TimeStatus{}, fmt.Errorf("timedatectl show: %w: %s", err, strings.TrimSpace(out))
+	}
+	props := parseProperties(out)
+	status := TimeStatus{
+		Now:             s.now().UTC(),
+		Timezone:        props["Timezone"],
+		NTPService:      NTPOff,
+		ChronyInstalled: s.chronyInstalled(),
+		Synchronized:    props["NTPSynchronized"] == "yes",
+	}
+	chronyActive := false
+	if status.ChronyInstalled {
+		chronyActive, _ = systemd.IsActive(ctx, s.runner, chronyUnit)
+	}
+	switch {
+	case chronyActive:
+		status.NTPService = NTPChrony
+		if drift, ok := s.chronyDrift(ctx); ok {
+			status.DriftMS = &drift
+		}
+	case props["NTP"] == "yes":
+		status.NTPService = NTPTimesyncd
+	}
+	status.Warning = clockWarning(status)
+	return status, nil
+}
+
+// UpdateTime applies settings and returns the new clock state.
+func (s *Service) UpdateTime(ctx context.Context, settings TimeSettings, actor string) (TimeStatus, error) {
+	tz := strings.TrimSpace(settings.Timezone)
+	ntp := strings.ToLower(strings.TrimSpace(settings.NTP))
+	if tz == "" && ntp == "" {
+		return TimeStatus{}, fmt.Errorf("invalid time settings: timezone or ntp is required")
+	}
+	if tz != "" {
+		if err := s.validateTimezone(tz); err != nil {
+			return TimeStatus{}, err
+		}
+	}
+	switch ntp {
+	case "", NTPTimesyncd, NTPOff:
+	case NTPChrony:
+		if !s.chronyInstalled() {
+			return TimeStatus{}, ErrChronyNotInstalled
+		}
+	default:
+		return TimeStatus{}, fmt.Errorf("invalid ntp service %q: use %s, %s or %s", ntp, NTPTimesyncd, NTPChrony, NTPOff)
+	}
+
+	if tz != "" {
+		if out, err := s.runner.Run(ctx, "timedatectl", "set-timezone", tz); err != nil {
+			return TimeStatus{}, fmt.Errorf("timedatectl set-timezone: %w: %s", err, strings.TrimSpace(out))
+		}
+		_ = s.writeAudit(ctx, actor, "system.time.timezone", "timezone="+tz)
+		s.log.Info("timezone changed", "timezone", tz, "actor", actor)
+	}
+	if ntp != "" {
+		if err := s.setNTP(ctx, ntp); err != nil {
+			return TimeStatus{}, err
+		}
+		_ = s.writeAudit(ctx, actor, "system.time.ntp", "ntp="+ntp)
+		s.log.Info("ntp service changed", "ntp", ntp, "actor", actor)
+	}
+	return s.Time(ctx)
+}
+
+// setNTP leaves exactly one time service running: timesyncd and chrony
+// both discipline the clock and must not run together.
+func (s *Service) setNTP(ctx context.Context, ntp string) error {
+	if ntp != NTPTimesyncd {
+		if out, err := s.runner.Run(ctx, "timedatectl", "set-ntp", "false"); err != nil {
+			return fmt.Errorf("timedatectl set-ntp false: %w: %s", err, strings.TrimSpace(out))
+		}
+	}
+	if ntp != NTPChrony && s.chronyInstalled() {
+		if err := systemd.DisableNow(ctx, s.runner, chronyUnit); err != nil {
+			return fmt.Errorf("stop chrony: %w", err)
+		}
+	}
+	switch ntp {
+	case NTPTimesyncd:
+		if out, err := s.runner.Run(ctx, "timedatectl", "set-ntp", "true"); err != nil {
+			return fmt.Errorf("timedatectl set-ntp true: %w: %s", err, strings.TrimSpace(out))
+		}
+	case NTPChrony:
+		if err := systemd.EnableNow(ctx, s.runner, chronyUnit); err != nil {
+			return fmt.Errorf("start chrony: %w", err)
+		}
+	}
+	return nil
+}
+
+// CheckClock is a self-test check that fails when the clock is not being
+// synchronized or has drifted.
+func (s *Service) CheckClock(ctx context.Context) (string, error) {
+	status, err := s.Time(ctx)
+	if err != nil {
+		return "", err
+	}
+	if status.Warning != "" {
+		return "", errors.New(status.Warning)
+	}
+	return fmt.Sprintf("synchronized by %s", status.NTPService), nil
+}
+
+func (s *Service) validateTimezone(tz string) error {
+	if !timezonePattern.MatchString(tz) || strings.Contains(tz, "..") {
+		return fmt.Errorf("invalid timezone %q", tz)
+	}
+	info, err := os.Stat(filepath.Join(s.zoneinfoDir, filepath.FromSlash(tz)))
+	if err != nil || info.IsDir() {
+		return fmt.Errorf("invalid timezone %q: unknown zone", tz)
+	}
+	return nil
+}
+
+// chronyDrift reads the system time offset from `chronyc -c tracking`, in
+// milliseconds.
+func (s *Service) chronyDrift(ctx context.Context) (float64, bool) {
+	out, err := s.runner.Run(ctx, "chronyc", "-c", "tracking")
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Split(strings.TrimSpace(out), ",")
+	if len(fields) < 5 {
+		return 0, false
+	}
+	offset, err := strconv.ParseFloat(fields[4], 64)
+	if err != nil {
+		return 0, false
+	}
+	return offset * 1000, true
+}
+
+func clockWarning(status TimeStatus) string {
+	switch {
+	case status.NTPService == NTPOff:
+		return "no NTP service is running; the clock is not synchronized"
+	case !status.Synchronized:
+		return "the clock is not synchronized with NTP yet"
+	case status.DriftMS != nil && math.Abs(*status.DriftMS) > float64(driftWarnThreshold.Milliseconds()):
+		return fmt.Sprintf("the clock is off by %.0fms", *status.DriftMS)
+	}
+	return ""
+}
+
+// parseProperties reads the Key=Value lines of `timedatectl show`.
+func parseProperties(out string) map[string]string {
+	props := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			props[k] = v
+		}
+	}
+	return props
+}
@@ -0,0 +1,384 @@
+package system
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/internal/platform/systemd"
+)
+
+// InstallUpdatesJob is the job type that upgrades OS packages with apt.
+const InstallUpdatesJob = "system.updates.install"
+
+// maxWindowHours bounds the daily maintenance window.
+const maxWindowHours = 12
+
+var (
+	// ErrUpdateInProgress indicates an update job that is still queued or
+	// running.
+	ErrUpdateInProgress = errors.New("an update job is already in progress")
+	// ErrNoJobQueue indicates a service without RegisterJobs.
+	ErrNoJobQueue = errors.New("job queue is not configured")
+)
+
+// Package is one upgradable apt package.
+type Package struct {
+	Name           string `json:"name"`
+	Suite          string `json:"suite"`
+	Version        string `json:"version"`
+	CurrentVersion string `json:"current_version"`
+	Arch           string `json:"arch"`
+	Security       bool   `json:"security"`
+}
+
+// UpdateStatus lists pending updates and whether the host must reboot to
+// finish earlier ones.
+type UpdateStatus struct {
+	Packages       []Package      `json:"packages"`
+	SecurityCount  int            `json:"security_count"`
+	RebootRequired bool           `json:"reboot_required"`
+	RebootPackages []string       `json:"reboot_packages"`
+	Schedule       UpdateSchedule `json:"schedule"`
+}
+
+// UpdateSchedule installs security updates automatically inside a daily
+// maintenance window, in the host's timezone.
+type UpdateSchedule struct {
+	AutoSecurity    bool      `json:"auto_security"`
+	WindowStartHour int       `json:"window_start_hour"`
+	WindowHours     int       `json:"window_hours"`
+	LastAutoRunAt   time.Time `json:"last_auto_run_at,omitzero"`
+}
+
+// JobProgress is an update job and its log output.
+type JobProgress struct {
+	Job        jobqueue.Job `json:"job"`
+	Log        string       `json:"log"`
+	NextOffset int64        `json:"next_offset"`
+}
+
+type updatePayload struct {
+	SecurityOnly bool   `json:"security_only"`
+	Actor        string `json:"actor"`
+}
+
+// RegisterJobs registers the update job handler and keeps q for
+// enqueueing.
+func (s *Service) RegisterJobs(q *jobqueue.Queue) {
+	s.jobs = q
+	q.Register(InstallUpdatesJob, s.runUpdateJob)
+}
+
+// Updates lists upgradable packages from the apt cache. Call RefreshUpdates
+// first for fresh package lists.
+func (s *Service) Updates(ctx context.Context) (UpdateStatus, error) {
+	packages, err := s.upgradable(ctx)
+	if err != nil {
+		return UpdateStatus{}, err
+	}
+	schedule, err := s.UpdateSchedule(ctx)
+	if err != nil {
+		return UpdateStatus{}, err
+	}
+	status := UpdateStatus{Packages: packages, Schedule: schedule}
+	for _, p := range packages {
+		if p.Security {
+			status.SecurityCount++
+		}
+	}
+	status.RebootRequired, status.RebootPackages = s.rebootRequired()
+	return status, nil
+}
+
+// RefreshUpdates runs apt-get update and returns the fresh status.
+func (s *Service) RefreshUpdates(ctx context.Context) (UpdateStatus, error) {
+	if out, err := s.runner.Run(ctx, "apt-get", "update", "-qq"); err != nil {
+		return UpdateStatus{}, fmt.Errorf("apt-get update: %w: %s", err, strings.TrimSpace(out))
+	}
+	return s.Updates(ctx)
+}
+
+// InstallUpdates enqueues an upgrade of all pending packages, or only of
+// those from security suites.
+func (s *Service) InstallUpdates(ctx context.Context, securityOnly bool, actor string) (jobqueue.Job, error) {
+	if s.jobs == nil {
+		return jobqueue.Job{}, ErrNoJobQueue
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.activeUpdate != 0 {
+		job, err := s.jobs.Get(ctx, s.activeUpdate)
+		if err == nil && (job.Status == jobqueue.StatusQueued || job.Status == jobqueue.StatusRunning) {
+			return jobqueue.Job{}, fmt.Errorf("%w (job %d)", ErrUpdateInProgress, s.activeUpdate)
+		}
+	}
+	id, err := s.jobs.Enqueue(ctx, InstallUpdatesJob, updatePayload{SecurityOnly: securityOnly, Actor: actor})
+	if err != nil {
+		return jobqueue.Job{}, err
+	}
+	s.activeUpdate = id
+	_ = s.writeAudit(ctx, actor, "system.updates.install_requested", fmt.Sprintf("security_only=%t job_id=%d", securityOnly, id))
+	return s.jobs.Get(ctx, id)
+}
+
+// UpdateJob returns an update job and its log output from offset.
+func (s *Service) UpdateJob(ctx context.Context, id, offset int64) (JobProgress, error) {
+	if s.jobs == nil {
+		return JobProgress{}, ErrNoJobQueue
+	}
+	job, err := s.jobs.Get(ctx, id)
+	if err != nil {
+		return JobProgress{}, err
+	}
+	if job.Type != InstallUpdatesJob {
+		return JobProgress{}, jobqueue.ErrJobNotFound
+	}
+	out, next, err := s.jobs.ReadLog(id, offset)
+	if err != nil {
+		return JobProgress{}, err
+	}
+	return JobProgress{Job: job, Log: out, NextOffset: next}, nil
+}
+
+// UpdateSchedule returns the automatic security update settings.
+func (s *Service) UpdateSchedule(ctx context.Context) (UpdateSchedule, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, `
+SELECT auto_security, window_start_hour, window_hours, last_auto_run_at
+FROM os_update_settings
+WHERE id = 1;`)
+	if err != nil {
+		return UpdateSchedule{}, fmt.Errorf("get update schedule: %w", err)
+	}
+	schedule := UpdateSchedule{WindowStartHour: 3, WindowHours: 2}
+	if len(rows) == 0 {
+		return schedule, nil
+	}
+	schedule.AutoSecurity = toInt64(rows[0]["auto_security"]) == 1
+	schedule.WindowStartHour = int(toInt64(rows[0]["window_start_hour"]))
+	schedule.WindowHours = int(toInt64(rows[0]["window_hours"]))
+	if last := toInt64(rows[0]["last_auto_run_at"]); last > 0 {
+		schedule.LastAutoRunAt = time.Unix(last, 0).UTC()
+	}
+	return schedule, nil
+}
+
+// SetUpdateSchedule stores the automatic security update settings.
+func (s *Service) SetUpdateSchedule(ctx context.Context, schedule UpdateSchedule, actor string) (UpdateSchedule, error) {
+	if schedule.WindowStartHour < 0 || schedule.WindowStartHour > 23 {
+		return UpdateSchedule{}, fmt.Errorf("invalid window_start_hour: must be between 0 and 23")
+	}
+	if schedule.WindowHours < 1 || schedule.WindowHours > maxWindowHours {
+		return UpdateSchedule{}, fmt.Errorf("invalid window_hours: must be between 1 and %d", maxWindowHours)
+	}
+	auto := 0
+	if schedule.AutoSecurity {
+		auto = 1
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(`
+INSERT INTO os_update_settings(id, auto_security, window_start_hour, window_hours, updated_at)
+VALUES(1, %d, %d, %d, %d)
+ON CONFLICT(id) DO UPDATE SET
+  auto_security = excluded.auto_security,
+  window_start_hour = excluded.window_start_hour,
+  window_hours = excluded.window_hours,
+  updated_at = excluded.updated_at;`,
+		auto, schedule.WindowStartHour, schedule.WindowHours, s.now().Unix(),
+	)); err != nil {
+		return UpdateSchedule{}, fmt.Errorf("set update schedule: %w", err)
+	}
+	_ = s.writeAudit(ctx, actor, "system.updates.schedule", fmt.Sprintf(
+		"auto_security=%t window_start_hour=%d window_hours=%d",
+		schedule.AutoSecurity, schedule.WindowStartHour, schedule.WindowHours,
+	))
+	return s.UpdateSchedule(ctx)
+}
+
+// RunAutoUpdates enqueues a security update once per maintenance window.
+// It reports whether a job was queued.
+func (s *Service) RunAutoUpdates(ctx context.Context) (bool, error) {
+	schedule, err := s.UpdateSchedule(ctx)
+	if err != nil || !schedule.AutoSecurity {
+		return false, err
+	}
+	start, ok := windowStart(s.now(), schedule)
+	if !ok || !schedule.LastAutoRunAt.Before(start) {
+		return false, nil
+	}
+	if _, err := s.InstallUpdates(ctx, true, "system"); err != nil {
+		if errors.Is(err, ErrUpdateInProgress) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := s.store.ExecPanel(ctx, fmt.Sprintf(
+		"UPDATE os_update_settings SET last_auto_run_at = %d WHERE id = 1;", s.now().Unix(),
+	)); err != nil {
+		return true, fmt.Errorf("record automatic update run: %w", err)
+	}
+	return true, nil
+}
+
+// windowStart returns the start of the maintenance window now falls in. A
+// window may cross midnight, so yesterday's window is checked too.
+func windowStart(now time.Time, schedule UpdateSchedule) (time.Time, bool) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), schedule.WindowStartHour, 0, 0, 0, now.Location())
+	for _, start := range []time.Time{today, today.AddDate(0, 0, -1)} {
+		if !now.Before(start) && now.Before(start.Add(time.Duration(schedule.WindowHours)*time.Hour)) {
+			return start, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// runUpdateJob refreshes the package lists, upgrades the selected packages
+// and records whether the host now needs a reboot.
+func (s *Service) runUpdateJob(ctx context.Context, job jobqueue.Job) error {
+	var payload updatePayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("decode update payload: %w", err)
+	}
+	logw, err := s.jobs.LogWriter(job.ID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = logw.Close()
+	}()
+
+	if err := s.runLogged(ctx, logw, "apt-get", "update", "-qq"); err != nil {
+		return fmt.Errorf("apt-get update: %w", err)
+	}
+	packages, err := s.upgradable(ctx)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(packages))
+	for _, p := range packages {
+		if !payload.SecurityOnly || p.Security {
+			names = append(names, p.Name)
+		}
+	}
+	if len(names) == 0 {
+		writeLine(logw, "no packages to upgrade")
+		return nil
+	}
+	args := append([]string{
+		"DEBIAN_FRONTEND=noninteractive", "apt-get", "install", "-y", "--only-upgrade",
+		"-o", "Dpkg::Options::=--force-confdef", "-o", "Dpkg::Options::=--force-confold",
+	}, names...)
+	runErr := s.runLogged(ctx, logw, "env", args...)
+	reboot, _ := s.rebootRequired()
+	details := fmt.Sprintf("packages=%d security_only=%t reboot_required=%t job_id=%d", len(names), payload.SecurityOnly, reboot, job.ID)
+	if runErr != nil {
+		_ = s.writeAudit(ctx, payload.Actor, "system.updates.install_failed", details)
+		return fmt.Errorf("apt-get install: %w", runErr)
+	}
+	if reboot {
+		writeLine(logw, "a reboot is required to finish the upgrade")
+	}
+	_ = s.writeAudit(ctx, payload.Actor, "system.updates.install", details)
+	return nil
+}
+
+// runLogged runs a command and copies its output into logw.
+func (s *Service) runLogged(ctx context.Context, logw io.Writer, name string, args ...string) error {
+	writeLine(logw, fmt.Sprintf("$ %s %s", name, strings.Join(args, " ")))
+	if live, ok := s.runner.(systemd.LiveRunner); ok {
+		_, err := live.RunLive(ctx, name, args, func(line string, _ bool) {
+			writeLine(logw, line)
+		})
+		return err
+	}
+	out, err := s.runner.Run(ctx, name, args...)
+	if strings.TrimSpace(out) != "" {
+		writeLine(logw, strings.TrimRight(out, "\n"))
+	}
+	return err
+}
+
+// upgradable parses `apt list --upgradable`, whose lines look like
+// "openssl/stable-security 3.0.15-1~deb12u1 amd64 [upgradable from: 3.0.14-1~deb12u2]".
+func (s *Service) upgradable(ctx context.Context) ([]Package, error) {
+	out, err := s.runner.Run(ctx, "apt", "list", "--upgradable")
+	if err != nil {
+		return nil, fmt.Errorf("apt list --upgradable: %w: %s", err, strings.TrimSpace(out))
+	}
+	packages := []Package{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 || fields[3] != "[upgradable" {
+			continue
+		}
+		name, suite, ok := strings.Cut(fields[0], "/")
+		if !ok {
+			continue
+		}
+		packages = append(packages, Package{
+			Name:           name,
+			Suite:          suite,
+			Version:        fields[1],
+			Arch:           fields[2],
+			CurrentVersion: strings.TrimSuffix(fields[len(fields)-1], "]"),
+			Security:       isSecuritySuite(suite),
+		})
+	}
+	return packages, nil
+}
+
+// isSecuritySuite matches suites such as "stable-security" and
+// "trixie-security,now"; apt lists every suite a version is in.
+func isSecuritySuite(suite string) bool {
+	for _, part := range strings.Split(suite, ",") {
+		if strings.HasSuffix(part, "-security") {
+			return true
+		}
+	}
+	return false
+}
+
+// rebootRequired reads the flag file Debian packages (kernel, libc, ...)
+// touch when a reboot is needed, and the packages listed next to it.
+func (s *Service) rebootRequired() (bool, []string) {
+	if _, err := os.Stat(s.rebootRequiredPath); err != nil {
+		return false, []string{}
+	}
+	packages := []string{}
+	raw, err := os.ReadFile(s.rebootRequiredPath + ".pkgs")
+	if err != nil {
+		return true, packages
+	}
+	seen := map[string]bool{}
+	for _, line := range strings.Split(string(raw), "\n") {
+		if name := strings.TrimSpace(line); name != "" && !seen[name] {
+			seen[name] = true
+			packages = append(packages, name)
+		}
+	}
+	return true, packages
+}
+
+func writeLine(w io.Writer, line string) {
+	_, _ = io.WriteString(w, line+"\n")
+}
+
+func toInt64(v any) int64 {
+	switch t := v.(type) {
+	case float64:
+		return int64(t)
+	case int64:
+		return t
+	case string:
+		i, _ := strconv.ParseInt(t, 10, 64)
+		return i
+	default:
+		return 0
+	}
+}
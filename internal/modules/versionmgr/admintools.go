@@ -0,0 +1,426 @@
+package versionmgr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/installer"
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+)
+
+// UpgradeAdminToolJob is the job type that upgrades phpMyAdmin or pgAdmin.
+const UpgradeAdminToolJob = "versionmgr.admintool.upgrade"
+
+// DefaultAdminToolsManifestURL is the pinned admin tool release manifest.
+const DefaultAdminToolsManifestURL = "https://raw.githubusercontent.com/robsonek/aiPanel/main/configs/sources/admin-tools.json"
+
+// versionUnknown marks an install that predates version markers.
+const versionUnknown = "unknown"
+
+var (
+	// ErrUnknownAdminTool indicates an admin tool name the panel does not manage.
+	ErrUnknownAdminTool = errors.New("unknown admin tool")
+	// ErrAdminToolNotInstalled indicates an upgrade of a tool that is not installed.
+	ErrAdminToolNotInstalled = errors.New("admin tool is not installed")
+	// ErrNoPinnedRelease indicates that no upstream check has recorded a release yet.
+	ErrNoPinnedRelease = errors.New("no pinned release known, check for updates first")
+	// ErrAdminToolUpToDate indicates the installed release already matches the pinned one.
+	ErrAdminToolUpToDate = errors.New("admin tool is up to date")
+)
+
+// AdminTools lists the database web UIs the installer can set up.
+var AdminTools = []string{installer.AdminToolPHPMyAdmin, installer.AdminToolPGAdmin}
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// AdminToolsManifest pins the admin tool releases the panel upgrades to.
+type AdminToolsManifest struct {
+	SchemaVersion int                         `json:"schema_version"`
+	Tools         map[string]AdminToolRelease `json:"tools"`
+}
+
+// AdminToolRelease is one pinned admin tool artifact. The archive must match
+// SourceSHA256, or the digest published at SHA256URL, and carry a detached
+// signature made by the PublicKeyFingerprint key.
+type AdminToolRelease struct {
+	Version              string `json:"version"`
+	SourceURL            string `json:"source_url"`
+	SourceSHA256         string `json:"source_sha256,omitempty"`
+	SHA256URL            string `json:"sha256_url,omitempty"`
+	SignatureURL         string `json:"signature_url"`
+	PublicKeyFingerprint string `json:"public_key_fingerprint"`
+}
+
+// AdminToolStatus is the installed and latest pinned release of one tool.
+// InstalledVersion is empty when the tool is not installed.
+type AdminToolStatus struct {
+	Name             string `json:"name"`
+	InstalledVersion string `json:"installed_version"`
+	LatestVersion    string `json:"latest_version"`
+	UpdateAvailable  bool   `json:"update_available"`
+	CheckedAt        int64  `json:"checked_at"`
+	// Route is the URL path the tool is served under, as recorded by the
+	// installer; empty for panels installed before routes were recorded.
+	Route string `json:"route,omitempty"`
+}
+
+// UpgradePayload is the payload of an UpgradeAdminToolJob.
+type UpgradePayload struct {
+	Tool    string           `json:"tool"`
+	Release AdminToolRelease `json:"release"`
+	Actor   string           `json:"actor"`
+}
+
+type adminToolRow struct {
+	installed string
+	latest    string
+	release   string
+	checkedAt int64
+}
+
+// ListAdminTools reports installed and latest known releases of admin tools.
+// Installed versions are refreshed from the markers the installer writes.
+func (s *Service) ListAdminTools(ctx context.Context) ([]AdminToolStatus, error) {
+	rows, err := s.adminToolRows(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Routes are informational; a missing or unreadable file leaves them
+	// empty.
+	routes, _ := installer.ReadAdminRoutes(s.cfg.DataDir)
+	out := make([]AdminToolStatus, 0, len(AdminTools))
+	for _, name := range AdminTools {
+		row := rows[name]
+		installed := s.installedAdminToolVersion(name)
+		if installed != row.installed {
+			if err := s.setInstalledVersion(ctx, name, installed); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, AdminToolStatus{
+			Name:             name,
+			InstalledVersion: installed,
+			LatestVersion:    row.latest,
+			UpdateAvailable:  updateAvailable(installed, row.latest),
+			CheckedAt:        row.checkedAt,
+			Route:            routes[name],
+		})
+	}
+	return out, nil
+}
+
+// CheckAdminTools fetches the pinned release manifest and records the latest
+// release of every admin tool.
+func (s *Service) CheckAdminTools(ctx context.Context, actor string) ([]AdminToolStatus, error) {
+	manifest, err := s.fetchAdminToolsManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	for _, name := range AdminTools {
+		release, ok := manifest.Tools[name]
+		if !ok {
+			continue
+		}
+		raw, err := json.Marshal(release)
+		if err != nil {
+			return nil, err
+		}
+		sql := fmt.Sprintf(`
+INSERT INTO admin_tools(name, latest_version, release_json, checked_at, updated_at) VALUES('%s','%s','%s',%d,%d)
+ON CONFLICT(name) DO UPDATE SET latest_version=excluded.latest_version, release_json=excluded.release_json,
+  checked_at=excluded.checked_at, updated_at=excluded.updated_at;`,
+			sqlEscape(name), sqlEscape(release.Version), sqlEscape(string(raw)), now, now)
+		if err := s.store.ExecPanel(ctx, sql); err != nil {
+			return nil, fmt.Errorf("store %s release: %w", name, err)
+		}
+	}
+	_ = s.writeAudit(ctx, actor, "admin_tools.check", "manifest="+s.adminToolsManifestURL())
+	return s.ListAdminTools(ctx)
+}
+
+// UpgradeAdminTool enqueues an installer run that replaces an admin tool
+// with its latest pinned release.
+func (s *Service) UpgradeAdminTool(ctx context.Context, name, actor string) (jobqueue.Job, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !isAdminTool(name) {
+		return jobqueue.Job{}, fmt.Errorf("%w: %q", ErrUnknownAdminTool, name)
+	}
+	rows, err := s.adminToolRows(ctx)
+	if err != nil {
+		return jobqueue.Job{}, err
+	}
+	row := rows[name]
+	installed := s.installedAdminToolVersion(name)
+	if installed == "" {
+		return jobqueue.Job{}, fmt.Errorf("%w: %s", ErrAdminToolNotInstalled, name)
+	}
+	if row.release == "" {
+		return jobqueue.Job{}, ErrNoPinnedRelease
+	}
+	if !updateAvailable(installed, row.latest) {
+		return jobqueue.Job{}, fmt.Errorf("%w: %s %s", ErrAdminToolUpToDate, name, installed)
+	}
+	var release AdminToolRelease
+	if err := json.Unmarshal([]byte(row.release), &release); err != nil {
+		return jobqueue.Job{}, fmt.Errorf("decode %s release: %w", name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.active[name]; ok {
+		job, err := s.queue.Get(ctx, id)
+		if err == nil && (job.Status == jobqueue.StatusQueued || job.Status == jobqueue.StatusRunning) {
+			return jobqueue.Job{}, fmt.Errorf("%w: %s (job %d)", ErrInstallInProgress, name, id)
+		}
+	}
+	id, err := s.queue.Enqueue(ctx, UpgradeAdminToolJob, UpgradePayload{Tool: name, Release: release, Actor: actor})
+	if err != nil {
+		return jobqueue.Job{}, err
+	}
+	s.active[name] = id
+	_ = s.writeAudit(ctx, actor, "admin_tools.upgrade_requested", fmt.Sprintf("tool=%s version=%s job_id=%d", name, release.Version, id))
+	return s.queue.Get(ctx, id)
+}
+
+// runUpgradeJob re-runs the admin tool install step with the pinned artifact.
+// The installer verifies the checksum and signature before replacing files.
+func (s *Service) runUpgradeJob(ctx context.Context, job jobqueue.Job) error {
+	var payload UpgradePayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("decode upgrade payload: %w", err)
+	}
+	if !isAdminTool(payload.Tool) {
+		return fmt.Errorf("%w: %q", ErrUnknownAdminTool, payload.Tool)
+	}
+	if err := payload.Release.validate(payload.Tool); err != nil {
+		return fmt.Errorf("%s release: %w", payload.Tool, err)
+	}
+	logw, err := s.queue.LogWriter(job.ID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = logw.Close()
+	}()
+
+	runErr := s.runInstaller(ctx, logw, upgradeArgs(payload.Tool, payload.Release, s.cfg.DataDir, s.opts.ConfigPath))
+	if runErr != nil {
+		_ = s.writeAudit(ctx, payload.Actor, "admin_tools.upgrade_failed", "tool="+payload.Tool)
+		return fmt.Errorf("upgrade %s: %w", payload.Tool, runErr)
+	}
+	if err := s.setInstalledVersion(ctx, payload.Tool, payload.Release.Version); err != nil {
+		return err
+	}
+	_ = s.writeAudit(ctx, payload.Actor, "admin_tools.upgrade", fmt.Sprintf("tool=%s version=%s", payload.Tool, payload.Release.Version))
+	return nil
+}
+
+func upgradeArgs(tool string, r AdminToolRelease, dataDir, configPath string) []string {
+	var args []string
+	switch tool {
+	case installer.AdminToolPHPMyAdmin:
+		args = []string{
+			"install", "--only", "install_phpmyadmin", "--upgrade-admin-tools",
+			"--phpmyadmin-version", r.Version,
+			"--phpmyadmin-url", r.SourceURL,
+		}
+		if r.SourceSHA256 != "" {
+			args = append(args, "--phpmyadmin-sha256", r.SourceSHA256)
+		} else {
+			args = append(args, "--phpmyadmin-sha256-url", r.SHA256URL)
+		}
+		args = append(args,
+			"--phpmyadmin-signature-url", r.SignatureURL,
+			"--phpmyadmin-fingerprint", r.PublicKeyFingerprint,
+		)
+	case installer.AdminToolPGAdmin:
+		args = []string{
+			"install", "--only", "install_pgadmin", "--upgrade-admin-tools",
+			"--pgadmin-version", r.Version,
+			"--pgadmin-url", r.SourceURL,
+			"--pgadmin-sha256", r.SourceSHA256,
+			"--pgadmin-signature-url", r.SignatureURL,
+			"--pgadmin-fingerprint", r.PublicKeyFingerprint,
+		}
+	}
+	args = append(args, "--data-dir", dataDir)
+	if strings.TrimSpace(configPath) != "" {
+		args = append(args, "--config", configPath)
+	}
+	return args
+}
+
+// validate checks that a release pins everything the installer verifies.
+// pgAdmin wheels have no published digest file, so they need source_sha256.
+func (r AdminToolRelease) validate(tool string) error {
+	if strings.TrimSpace(r.Version) == "" {
+		return errors.New("version is required")
+	}
+	if strings.TrimSpace(r.SourceURL) == "" {
+		return errors.New("source_url is required")
+	}
+	if r.SourceSHA256 == "" && (r.SHA256URL == "" || tool == installer.AdminToolPGAdmin) {
+		return errors.New("source_sha256 is required")
+	}
+	if r.SourceSHA256 != "" && !sha256Pattern.MatchString(r.SourceSHA256) {
+		return errors.New("source_sha256 must be 64 hex characters")
+	}
+	if strings.TrimSpace(r.SignatureURL) == "" || strings.TrimSpace(r.PublicKeyFingerprint) == "" {
+		return errors.New("signature_url and public_key_fingerprint are required")
+	}
+	return nil
+}
+
+func (s *Service) fetchAdminToolsManifest(ctx context.Context) (AdminToolsManifest, error) {
+	ref := s.adminToolsManifestURL()
+	var raw []byte
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ref, nil)
+		if err != nil {
+			return AdminToolsManifest{}, err
+		}
+		client := &http.Client{Timeout: 30 * time.Second}
+		resp, err := client.Do(req)
+		if err != nil {
+			return AdminToolsManifest{}, fmt.Errorf("fetch admin tools manifest: %w", err)
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		if resp.StatusCode != http.StatusOK {
+			return AdminToolsManifest{}, fmt.Errorf("fetch admin tools manifest: unexpected status %d", resp.StatusCode)
+		}
+		if raw, err = io.ReadAll(io.LimitReader(resp.Body, 1<<20)); err != nil {
+			return AdminToolsManifest{}, fmt.Errorf("read admin tools manifest: %w", err)
+		}
+	} else {
+		var err error
+		// The manifest location comes from panel configuration.
+		//nolint:gosec // G304
+		if raw, err = os.ReadFile(strings.TrimPrefix(ref, "file://")); err != nil {
+			return AdminToolsManifest{}, fmt.Errorf("read admin tools manifest: %w", err)
+		}
+	}
+	var manifest AdminToolsManifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return AdminToolsManifest{}, fmt.Errorf("parse admin tools manifest: %w", err)
+	}
+	if manifest.SchemaVersion != 1 {
+		return AdminToolsManifest{}, fmt.Errorf("unsupported admin tools manifest schema_version %d", manifest.SchemaVersion)
+	}
+	for name, release := range manifest.Tools {
+		if err := release.validate(name); err != nil {
+			return AdminToolsManifest{}, fmt.Errorf("admin tools manifest %s: %w", name, err)
+		}
+	}
+	return manifest, nil
+}
+
+func (s *Service) adminToolsManifestURL() string {
+	if u := strings.TrimSpace(s.cfg.AdminToolsManifestURL); u != "" {
+		return u
+	}
+	return DefaultAdminToolsManifestURL
+}
+
+// installedAdminToolVersion returns the version recorded in the install
+// directory, versionUnknown for installs without a marker and "" when the
+// tool is not installed.
+func (s *Service) installedAdminToolVersion(name string) string {
+	dir := s.opts.PHPMyAdminDir
+	if name == installer.AdminToolPGAdmin {
+		dir = s.opts.PGAdminDir
+	}
+	marker, err := installer.ReadAdminToolVersion(dir)
+	if err == nil && marker.Version != "" {
+		return marker.Version
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return versionUnknown
+	}
+	return ""
+}
+
+func (s *Service) setInstalledVersion(ctx context.Context, name, version string) error {
+	sql := fmt.Sprintf(`
+INSERT INTO admin_tools(name, installed_version, updated_at) VALUES('%s','%s',%d)
+ON CONFLICT(name) DO UPDATE SET installed_version=excluded.installed_version, updated_at=excluded.updated_at;`,
+		sqlEscape(name), sqlEscape(version), time.Now().Unix())
+	if err := s.store.ExecPanel(ctx, sql); err != nil {
+		return fmt.Errorf("store %s version: %w", name, err)
+	}
+	return nil
+}
+
+func (s *Service) adminToolRows(ctx context.Context) (map[string]adminToolRow, error) {
+	rows, err := s.store.QueryPanelJSON(ctx,
+		"SELECT name, installed_version, latest_version, release_json, checked_at FROM admin_tools;")
+	if err != nil {
+		return nil, fmt.Errorf("list admin tools: %w", err)
+	}
+	out := make(map[string]adminToolRow, len(rows))
+	for _, row := range rows {
+		checkedAt, _ := strconv.ParseInt(fmt.Sprint(row["checked_at"]), 10, 64)
+		out[fmt.Sprint(row["name"])] = adminToolRow{
+			installed: fmt.Sprint(row["installed_version"]),
+			latest:    fmt.Sprint(row["latest_version"]),
+			release:   fmt.Sprint(row["release_json"]),
+			checkedAt: checkedAt,
+		}
+	}
+	return out, nil
+}
+
+func isAdminTool(name string) bool {
+	for _, t := range AdminTools {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// updateAvailable reports whether latest is newer than installed. Installs
+// without a version marker are always considered outdated.
+func updateAvailable(installed, latest string) bool {
+	if installed == "" || latest == "" {
+		return false
+	}
+	if installed == versionUnknown {
+		return true
+	}
+	return compareVersions(latest, installed) > 0
+}
+
+// compareVersions compares dotted numeric versions such as "5.2.3" and
+// "9.12"; missing components count as zero.
+func compareVersions(a, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x > y {
+				return 1
+			}
+			return -1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,151 @@
+package versionmgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrComponentRequired indicates a component the panel cannot run without.
+	ErrComponentRequired = errors.New("component is required")
+	// ErrComponentInUse indicates a database engine that still hosts site databases.
+	ErrComponentInUse = errors.New("component is in use")
+	// ErrComponentDisabled indicates a component that was disabled by an admin.
+	ErrComponentDisabled = errors.New("component is disabled")
+)
+
+// requiredComponents serve the panel itself and every hosted site.
+var requiredComponents = map[string]bool{"nginx": true, "php-fpm": true}
+
+// databaseEngines maps runtime components to site_databases.db_engine values.
+var databaseEngines = map[string]string{"mariadb": "mariadb", "mysql": "mysql", "postgresql": "postgres", "mongodb": "mongodb"}
+
+// ComponentStatus is the enabled flag and unit state of one runtime component.
+type ComponentStatus struct {
+	Name    string `json:"name"`
+	Unit    string `json:"unit"`
+	Enabled bool   `json:"enabled"`
+	State   string `json:"state"`
+}
+
+// ListComponents reports every runtime component with its unit state.
+func (s *Service) ListComponents(ctx context.Context) ([]ComponentStatus, error) {
+	disabled, err := s.disabledComponents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ComponentStatus, 0, len(Components))
+	for _, name := range Components {
+		unit := unitForComponent(name)
+		// is-active exits non-zero for anything but "active"; the state is
+		// still printed on stdout.
+		state, _ := s.runner.Run(ctx, "systemctl", "is-active", unit)
+		state = strings.TrimSpace(state)
+		if state == "" {
+			state = "unknown"
+		}
+		out = append(out, ComponentStatus{
+			Name:    name,
+			Unit:    unit,
+			Enabled: !disabled[name],
+			State:   state,
+		})
+	}
+	return out, nil
+}
+
+// DisableComponent stops, disables and masks a runtime unit so nothing
+// (including package hooks) can start it again until it is re-enabled.
+func (s *Service) DisableComponent(ctx context.Context, component, actor string) error {
+	component = strings.ToLower(strings.TrimSpace(component))
+	if !isComponent(component) {
+		return fmt.Errorf("%w: %q", ErrUnknownComponent, component)
+	}
+	if requiredComponents[component] {
+		return fmt.Errorf("%w: %s serves hosted sites and cannot be disabled", ErrComponentRequired, component)
+	}
+	if engine, ok := databaseEngines[component]; ok {
+		rows, err := s.store.QueryPanelJSON(ctx, fmt.Sprintf(
+			"SELECT COUNT(*) AS n FROM site_databases WHERE db_engine = '%s';", sqlEscape(engine)))
+		if err != nil {
+			return fmt.Errorf("count %s databases: %w", engine, err)
+		}
+		if len(rows) > 0 && fmt.Sprint(rows[0]["n"]) != "0" {
+			return fmt.Errorf("%w: %s still hosts %v site databases", ErrComponentInUse, component, rows[0]["n"])
+		}
+	}
+
+	unit := unitForComponent(component)
+	for _, args := range [][]string{
+		{"stop", unit},
+		{"disable", unit},
+		{"mask", unit},
+	} {
+		if _, err := s.runner.Run(ctx, "systemctl", args...); err != nil {
+			return fmt.Errorf("systemctl %s %s: %w", args[0], unit, err)
+		}
+	}
+	if err := s.setComponentEnabled(ctx, component, false); err != nil {
+		return err
+	}
+	s.componentChanged(component)
+	_ = s.writeAudit(ctx, actor, "runtime.component.disable", "component="+component)
+	return nil
+}
+
+// EnableComponent unmasks and starts a previously disabled runtime unit.
+func (s *Service) EnableComponent(ctx context.Context, component, actor string) error {
+	component = strings.ToLower(strings.TrimSpace(component))
+	if !isComponent(component) {
+		return fmt.Errorf("%w: %q", ErrUnknownComponent, component)
+	}
+	unit := unitForComponent(component)
+	for _, args := range [][]string{
+		{"unmask", unit},
+		{"enable", "--now", unit},
+	} {
+		if _, err := s.runner.Run(ctx, "systemctl", args...); err != nil {
+			return fmt.Errorf("systemctl %s %s: %w", args[0], unit, err)
+		}
+	}
+	if err := s.setComponentEnabled(ctx, component, true); err != nil {
+		return err
+	}
+	s.componentChanged(component)
+	_ = s.writeAudit(ctx, actor, "runtime.component.enable", "component="+component)
+	return nil
+}
+
+func (s *Service) setComponentEnabled(ctx context.Context, component string, enabled bool) error {
+	enabledInt := 0
+	if enabled {
+		enabledInt = 1
+	}
+	sql := fmt.Sprintf(`
+INSERT INTO runtime_components(name, enabled, updated_at) VALUES('%s',%d,%d)
+ON CONFLICT(name) DO UPDATE SET enabled=excluded.enabled, updated_at=excluded.updated_at;`,
+		sqlEscape(component), enabledInt, time.Now().Unix())
+	if err := s.store.ExecPanel(ctx, sql); err != nil {
+		return fmt.Errorf("store component state: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) disabledComponents(ctx context.Context) (map[string]bool, error) {
+	rows, err := s.store.QueryPanelJSON(ctx, "SELECT name FROM runtime_components WHERE enabled = 0;")
+	if err != nil {
+		return nil, fmt.Errorf("list component state: %w", err)
+	}
+	out := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		out[fmt.Sprint(row["name"])] = true
+	}
+	return out, nil
+}
+
+func unitForComponent(name string) string {
+	return "aipanel-runtime-" + name + ".service"
+}
@@ -0,0 +1,183 @@
+package versionmgr
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+)
+
+// Handler exposes HTTP handlers for runtime component management.
+type Handler struct {
+	svc *Service
+}
+
+// NewHandler creates version manager HTTP handler.
+func NewHandler(svc *Service) *Handler {
+	return &Handler{svc: svc}
+}
+
+// HandleComponents serves GET /api/system/runtime/components.
+func (h *Handler) HandleComponents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	components, err := h.svc.ListComponents(r.Context())
+	if err != nil {
+		http.Error(w, "failed to list runtime components", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"components": components})
+}
+
+// HandleComponentAction serves POST /api/system/runtime/components/{name}/{install|enable|disable}.
+func (h *Handler) HandleComponentAction(w http.ResponseWriter, r *http.Request, component, action, actor string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch action {
+	case "install":
+		job, err := h.svc.InstallComponent(r.Context(), component, actor)
+		if err != nil {
+			writeComponentError(w, "failed to queue install", err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]any{"job": job})
+	case "enable", "disable":
+		var err error
+		if action == "enable" {
+			err = h.svc.EnableComponent(r.Context(), component, actor)
+		} else {
+			err = h.svc.DisableComponent(r.Context(), component, actor)
+		}
+		if err != nil {
+			writeComponentError(w, "failed to "+action+" component", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"status": action + "d", "component": component})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeComponentError(w http.ResponseWriter, prefix string, err error) {
+	switch {
+	case errors.Is(err, ErrUnknownComponent):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInstallInProgress),
+		errors.Is(err, ErrComponentRequired),
+		errors.Is(err, ErrComponentInUse),
+		errors.Is(err, ErrComponentDisabled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, prefix+": "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// HandleAdminTools serves GET /api/system/admin-tools.
+func (h *Handler) HandleAdminTools(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tools, err := h.svc.ListAdminTools(r.Context())
+	if err != nil {
+		http.Error(w, "failed to list admin tools", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tools": tools})
+}
+
+// HandleAdminToolAction serves POST /api/system/admin-tools/check and
+// POST /api/system/admin-tools/{name}/upgrade.
+func (h *Handler) HandleAdminToolAction(w http.ResponseWriter, r *http.Request, name, action, actor string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case name == "check" && action == "":
+		tools, err := h.svc.CheckAdminTools(r.Context(), actor)
+		if err != nil {
+			http.Error(w, "failed to check admin tool releases: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"tools": tools})
+	case action == "upgrade":
+		job, err := h.svc.UpgradeAdminTool(r.Context(), name, actor)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrUnknownAdminTool):
+				http.Error(w, err.Error(), http.StatusNotFound)
+			case errors.Is(err, ErrInstallInProgress),
+				errors.Is(err, ErrAdminToolNotInstalled),
+				errors.Is(err, ErrNoPinnedRelease),
+				errors.Is(err, ErrAdminToolUpToDate):
+				http.Error(w, err.Error(), http.StatusConflict)
+			default:
+				http.Error(w, "failed to queue upgrade: "+err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]any{"job": job})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// HandleJob serves GET /api/system/runtime/jobs/{id}?offset=N. Clients follow
+// build logs by passing back next_offset until the job is done or failed.
+func (h *Handler) HandleJob(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	offset, _ := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	progress, err := h.svc.JobProgress(r.Context(), id, offset)
+	if err != nil {
+		if errors.Is(err, jobqueue.ErrJobNotFound) {
+			http.Error(w, "job not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to read job", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, progress)
+}
+
+// ParseComponentAction splits "/api/system/runtime/components/{name}/{action}".
+func ParseComponentAction(path string) (string, string, error) {
+	trimmed := strings.Trim(strings.TrimPrefix(path, "/api/system/runtime/components/"), "/")
+	name, action, ok := strings.Cut(trimmed, "/")
+	if !ok || name == "" || action == "" {
+		return "", "", strconv.ErrSyntax
+	}
+	return name, action, nil
+}
+
+// ParseAdminToolAction splits "/api/system/admin-tools/{name}[/{action}]".
+func ParseAdminToolAction(path string) (string, string, error) {
+	trimmed := strings.Trim(strings.TrimPrefix(path, "/api/system/admin-tools/"), "/")
+	name, action, _ := strings.Cut(trimmed, "/")
+	if name == "" || strings.Contains(action, "/") {
+		return "", "", strconv.ErrSyntax
+	}
+	return name, action, nil
+}
+
+// ParseJobID extracts id from "/api/system/runtime/jobs/{id}".
+func ParseJobID(path string) (int64, error) {
+	idRaw := strings.Trim(strings.TrimPrefix(path, "/api/system/runtime/jobs/"), "/")
+	return strconv.ParseInt(idRaw, 10, 64)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,284 @@
+package versionmgr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/internal/platform/middleware"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+	"github.com/robsonek/aiPanel/internal/platform/systemd"
+)
+
+// InstallComponentJob is the job type that installs one runtime component.
+const InstallComponentJob = "versionmgr.component.install"
+
+var (
+	// ErrUnknownComponent indicates a component name the installer does not support.
+	ErrUnknownComponent = errors.New("unknown runtime component")
+	// ErrInstallInProgress indicates an install job for the component is already queued or running.
+	ErrInstallInProgress = errors.New("install already in progress")
+)
+
+// Components lists runtime components installable with "aipanel install --only".
+var Components = []string{"nginx", "php-fpm", "mariadb", "mysql", "postgresql", "mongodb"}
+
+// Options locates the panel binary used to run installer steps.
+type Options struct {
+	// PanelBinary is the aipanel executable; defaults to /usr/local/bin/aipanel.
+	PanelBinary string
+	// ConfigPath is passed to the installer as --config.
+	ConfigPath string
+	// PHPMyAdminDir and PGAdminDir are where the installer puts the admin
+	// tools; their version markers are read from there.
+	PHPMyAdminDir string
+	PGAdminDir    string
+}
+
+// InstallPayload is the payload of an InstallComponentJob.
+type InstallPayload struct {
+	Component string `json:"component"`
+	Actor     string `json:"actor"`
+}
+
+// JobProgress is a job together with a chunk of its log output.
+type JobProgress struct {
+	Job        jobqueue.Job `json:"job"`
+	Log        string       `json:"log"`
+	NextOffset int64        `json:"next_offset"`
+}
+
+// Service installs runtime components on demand through the job queue.
+type Service struct {
+	store  *sqlite.Store
+	cfg    config.Config
+	log    *slog.Logger
+	runner systemd.Runner
+	queue  *jobqueue.Queue
+	opts   Options
+
+	mu sync.Mutex
+	// active maps component or admin tool name to its latest job id.
+	active map[string]int64
+
+	// onChange is called after a component was installed, enabled or
+	// disabled, so other modules can drop what they cached about it.
+	onChange func(component string)
+}
+
+// NewService creates a version manager and registers its job handlers.
+func NewService(
+	store *sqlite.Store,
+	cfg config.Config,
+	log *slog.Logger,
+	runner systemd.Runner,
+	queue *jobqueue.Queue,
+	opts Options,
+) *Service {
+	if log == nil {
+		log = slog.Default()
+	}
+	if runner == nil {
+		runner = systemd.ExecRunner{}
+	}
+	if strings.TrimSpace(opts.PanelBinary) == "" {
+		opts.PanelBinary = "/usr/local/bin/aipanel"
+	}
+	if strings.TrimSpace(opts.PHPMyAdminDir) == "" {
+		opts.PHPMyAdminDir = "/usr/share/phpmyadmin"
+	}
+	if strings.TrimSpace(opts.PGAdminDir) == "" {
+		opts.PGAdminDir = "/var/lib/aipanel/pgadmin4"
+	}
+	s := &Service{
+		store:  store,
+		cfg:    cfg,
+		log:    log,
+		runner: runner,
+		queue:  queue,
+		opts:   opts,
+		active: map[string]int64{},
+	}
+	queue.Register(InstallComponentJob, s.runInstallJob)
+	queue.Register(UpgradeAdminToolJob, s.runUpgradeJob)
+	return s
+}
+
+// OnComponentChange sets the callback run after a component install
+// (successful or not, since a failed upgrade may still have replaced
+// files), enable or disable.
+func (s *Service) OnComponentChange(fn func(component string)) {
+	s.onChange = fn
+}
+
+func (s *Service) componentChanged(component string) {
+	if s.onChange != nil {
+		s.onChange(component)
+	}
+}
+
+// InstallComponent enqueues an installer run limited to one runtime component.
+func (s *Service) InstallComponent(ctx context.Context, component, actor string) (jobqueue.Job, error) {
+	component = strings.ToLower(strings.TrimSpace(component))
+	if !isComponent(component) {
+		return jobqueue.Job{}, fmt.Errorf("%w: %q", ErrUnknownComponent, component)
+	}
+
+	disabled, err := s.disabledComponents(ctx)
+	if err != nil {
+		return jobqueue.Job{}, err
+	}
+	if disabled[component] {
+		return jobqueue.Job{}, fmt.Errorf("%w: enable %s before reinstalling it", ErrComponentDisabled, component)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.active[component]; ok {
+		job, err := s.queue.Get(ctx, id)
+		if err == nil && (job.Status == jobqueue.StatusQueued || job.Status == jobqueue.StatusRunning) {
+			return jobqueue.Job{}, fmt.Errorf("%w: %s (job %d)", ErrInstallInProgress, component, id)
+		}
+	}
+	id, err := s.queue.Enqueue(ctx, InstallComponentJob, InstallPayload{Component: component, Actor: actor})
+	if err != nil {
+		return jobqueue.Job{}, err
+	}
+	s.active[component] = id
+	_ = s.writeAudit(ctx, actor, "runtime.component.install_requested", fmt.Sprintf("component=%s job_id=%d", component, id))
+	return s.queue.Get(ctx, id)
+}
+
+// JobProgress returns an install or upgrade job and its log output from offset.
+func (s *Service) JobProgress(ctx context.Context, id, offset int64) (JobProgress, error) {
+	job, err := s.queue.Get(ctx, id)
+	if err != nil {
+		return JobProgress{}, err
+	}
+	if job.Type != InstallComponentJob && job.Type != UpgradeAdminToolJob {
+		return JobProgress{}, jobqueue.ErrJobNotFound
+	}
+	out, next, err := s.queue.ReadLog(id, offset)
+	if err != nil {
+		return JobProgress{}, err
+	}
+	return JobProgress{Job: job, Log: out, NextOffset: next}, nil
+}
+
+// runInstallJob runs "aipanel install --only <component>" and streams its
+// output into the job log.
+func (s *Service) runInstallJob(ctx context.Context, job jobqueue.Job) error {
+	var payload InstallPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("decode install payload: %w", err)
+	}
+	if !isComponent(payload.Component) {
+		return fmt.Errorf("%w: %q", ErrUnknownComponent, payload.Component)
+	}
+	logw, err := s.queue.LogWriter(job.ID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = logw.Close()
+	}()
+
+	args := []string{"install", "--only", payload.Component, "--data-dir", s.cfg.DataDir}
+	if strings.TrimSpace(s.opts.ConfigPath) != "" {
+		args = append(args, "--config", s.opts.ConfigPath)
+	}
+	err = s.runInstaller(ctx, logw, args)
+	s.componentChanged(payload.Component)
+	if err != nil {
+		_ = s.writeAudit(ctx, payload.Actor, "runtime.component.install_failed", "component="+payload.Component)
+		return fmt.Errorf("install %s: %w", payload.Component, err)
+	}
+	_ = s.writeAudit(ctx, payload.Actor, "runtime.component.install", "component="+payload.Component)
+	return nil
+}
+
+// StagePHP builds the PHP runtime pinned in the lock next to the active
+// one without switching to it, writing installer output to logw. Sites
+// are then moved to the new version one at a time by the hosting module.
+func (s *Service) StagePHP(ctx context.Context, logw io.Writer) error {
+	args := []string{"install", "--only", "php-fpm", "--stage-runtime", "--data-dir", s.cfg.DataDir}
+	if strings.TrimSpace(s.opts.ConfigPath) != "" {
+		args = append(args, "--config", s.opts.ConfigPath)
+	}
+	err := s.runInstaller(ctx, logw, args)
+	s.componentChanged("php-fpm")
+	if err != nil {
+		return fmt.Errorf("stage php-fpm: %w", err)
+	}
+	return nil
+}
+
+// runInstaller runs the panel binary with args and copies its output, plus
+// a closing status line, into logw.
+func (s *Service) runInstaller(ctx context.Context, logw io.Writer, args []string) error {
+	writeLine(logw, fmt.Sprintf("$ %s %s", s.opts.PanelBinary, strings.Join(args, " ")))
+	started := time.Now()
+	var runErr error
+	if live, ok := s.runner.(systemd.LiveRunner); ok {
+		_, runErr = live.RunLive(ctx, s.opts.PanelBinary, args, func(line string, _ bool) {
+			writeLine(logw, line)
+		})
+	} else {
+		var out string
+		out, runErr = s.runner.Run(ctx, s.opts.PanelBinary, args...)
+		if strings.TrimSpace(out) != "" {
+			writeLine(logw, strings.TrimRight(out, "\n"))
+		}
+	}
+	duration := time.Since(started).Round(time.Second)
+	if runErr != nil {
+		writeLine(logw, fmt.Sprintf("install failed after %s: %v", duration, runErr))
+		return runErr
+	}
+	writeLine(logw, fmt.Sprintf("install finished after %s", duration))
+	return nil
+}
+
+func writeLine(w io.Writer, line string) {
+	_, _ = io.WriteString(w, line+"\n")
+}
+
+func isComponent(name string) bool {
+	for _, c := range Components {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) writeAudit(ctx context.Context, actor, action, details string) error {
+	if s.store == nil {
+		return nil
+	}
+	if strings.TrimSpace(actor) == "" {
+		actor = "system"
+	}
+	sql := fmt.Sprintf(
+		"INSERT INTO audit_events(actor, action, details, remote_ip, impersonator, created_at) VALUES('%s','%s','%s','%s','%s',%d);",
+		sqlEscape(actor),
+		sqlEscape(action),
+		sqlEscape(details),
+		sqlEscape(middleware.ClientIP(ctx)),
+		sqlEscape(middleware.Impersonator(ctx)),
+		time.Now().Unix(),
+	)
+	return s.store.ExecAudit(ctx, sql)
+}
+
+func sqlEscape(in string) string {
+	return strings.ReplaceAll(in, "'", "''")
+}
@@ -0,0 +1,296 @@
+package versionmgr
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/installer"
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+type fakeLiveRunner struct {
+	commands []string
+	lines    []string
+	err      error
+	// outputs answers non-streamed commands, keyed by "name args...".
+	outputs map[string]string
+}
+
+func (r *fakeLiveRunner) Run(ctx context.Context, name string, args ...string) (string, error) {
+	cmd := strings.TrimSpace(name + " " + strings.Join(args, " "))
+	if name == "systemctl" {
+		r.commands = append(r.commands, cmd)
+		return r.outputs[cmd], nil
+	}
+	return r.RunLive(ctx, name, args, nil)
+}
+
+func (r *fakeLiveRunner) RunLive(_ context.Context, name string, args []string, onLine func(string, bool)) (string, error) {
+	r.commands = append(r.commands, strings.TrimSpace(name+" "+strings.Join(args, " ")))
+	for _, line := range r.lines {
+		if onLine != nil {
+			onLine(line, false)
+		}
+	}
+	return strings.Join(r.lines, "\n"), r.err
+}
+
+func newTestService(t *testing.T, runner *fakeLiveRunner) (*Service, *jobqueue.Queue) {
+	t.Helper()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(context.Background()); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	queue := jobqueue.New(store, nil)
+	svc := NewService(store, config.Config{DataDir: store.DataDir}, slog.Default(), runner, queue, Options{
+		PanelBinary: "/usr/local/bin/aipanel",
+		ConfigPath:  "/etc/aipanel/panel.yaml",
+	})
+	return svc, queue
+}
+
+func TestInstallComponent_RunsInstallerThroughQueue(t *testing.T) {
+	ctx := context.Background()
+	runner := &fakeLiveRunner{lines: []string{"[install_runtime] started", "[install_runtime] ok"}}
+	svc, queue := newTestService(t, runner)
+
+	job, err := svc.InstallComponent(ctx, "PostgreSQL", "admin@example.com")
+	if err != nil {
+		t.Fatalf("install component: %v", err)
+	}
+	if job.Status != jobqueue.StatusQueued {
+		t.Fatalf("expected queued job, got %+v", job)
+	}
+	if _, err := svc.InstallComponent(ctx, "postgresql", "admin@example.com"); !errors.Is(err, ErrInstallInProgress) {
+		t.Fatalf("expected ErrInstallInProgress for duplicate request, got %v", err)
+	}
+	if _, err := svc.InstallComponent(ctx, "redis", "admin@example.com"); !errors.Is(err, ErrUnknownComponent) {
+		t.Fatalf("expected ErrUnknownComponent, got %v", err)
+	}
+
+	if _, err := queue.RunPending(ctx); err != nil {
+		t.Fatalf("run jobs: %v", err)
+	}
+	want := "/usr/local/bin/aipanel install --only postgresql --data-dir " + svc.cfg.DataDir + " --config /etc/aipanel/panel.yaml"
+	if len(runner.commands) != 1 || runner.commands[0] != want {
+		t.Fatalf("expected %q, got %v", want, runner.commands)
+	}
+	progress, err := svc.JobProgress(ctx, job.ID, 0)
+	if err != nil {
+		t.Fatalf("job progress: %v", err)
+	}
+	if progress.Job.Status != jobqueue.StatusDone {
+		t.Fatalf("expected done job, got %+v", progress.Job)
+	}
+	if !strings.Contains(progress.Log, "[install_runtime] ok") || !strings.Contains(progress.Log, "install finished") {
+		t.Fatalf("expected streamed installer output in log, got %q", progress.Log)
+	}
+	tail, err := svc.JobProgress(ctx, job.ID, progress.NextOffset)
+	if err != nil || tail.Log != "" {
+		t.Fatalf("expected no new output past next_offset, got %q (%v)", tail.Log, err)
+	}
+
+	if _, err := svc.InstallComponent(ctx, "postgresql", "admin@example.com"); err != nil {
+		t.Fatalf("expected reinstall to be allowed after job finished, got %v", err)
+	}
+}
+
+func TestInstallComponent_RecordsFailure(t *testing.T) {
+	ctx := context.Background()
+	runner := &fakeLiveRunner{lines: []string{"configure: error: readline library not found"}, err: errors.New("exit status 1")}
+	svc, queue := newTestService(t, runner)
+	var changed []string
+	svc.OnComponentChange(func(component string) {
+		changed = append(changed, component)
+	})
+
+	job, err := svc.InstallComponent(ctx, "postgresql", "")
+	if err != nil {
+		t.Fatalf("install component: %v", err)
+	}
+	if _, err := queue.RunPending(ctx); err != nil {
+		t.Fatalf("run jobs: %v", err)
+	}
+	progress, err := svc.JobProgress(ctx, job.ID, 0)
+	if err != nil {
+		t.Fatalf("job progress: %v", err)
+	}
+	if progress.Job.Status != jobqueue.StatusFailed || !strings.Contains(progress.Job.LastError, "exit status 1") {
+		t.Fatalf("expected failed job, got %+v", progress.Job)
+	}
+	if !strings.Contains(progress.Log, "readline library not found") || !strings.Contains(progress.Log, "install failed") {
+		t.Fatalf("expected build output in failed job log, got %q", progress.Log)
+	}
+	// A failed upgrade may already have replaced files, so caches are dropped too.
+	if len(changed) != 1 || changed[0] != "postgresql" {
+		t.Fatalf("expected change callback for postgresql, got %v", changed)
+	}
+}
+
+func TestDisableComponent_MasksUnitAndBlocksInstall(t *testing.T) {
+	ctx := context.Background()
+	runner := &fakeLiveRunner{outputs: map[string]string{
+		"systemctl is-active aipanel-runtime-postgresql.service": "inactive\n",
+	}}
+	svc, _ := newTestService(t, runner)
+
+	if err := svc.DisableComponent(ctx, "nginx", "admin@example.com"); !errors.Is(err, ErrComponentRequired) {
+		t.Fatalf("expected nginx to be required, got %v", err)
+	}
+	if err := svc.DisableComponent(ctx, "postgresql", "admin@example.com"); err != nil {
+		t.Fatalf("disable: %v", err)
+	}
+	want := []string{
+		"systemctl stop aipanel-runtime-postgresql.service",
+		"systemctl disable aipanel-runtime-postgresql.service",
+		"systemctl mask aipanel-runtime-postgresql.service",
+	}
+	if strings.Join(runner.commands, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected commands: %v", runner.commands)
+	}
+	if _, err := svc.InstallComponent(ctx, "postgresql", "admin@example.com"); !errors.Is(err, ErrComponentDisabled) {
+		t.Fatalf("expected install of disabled component to be refused, got %v", err)
+	}
+
+	components, err := svc.ListComponents(ctx)
+	if err != nil {
+		t.Fatalf("list components: %v", err)
+	}
+	for _, c := range components {
+		if c.Name == "postgresql" && (c.Enabled || c.State != "inactive") {
+			t.Fatalf("expected disabled inactive postgresql, got %+v", c)
+		}
+		if c.Name == "mariadb" && !c.Enabled {
+			t.Fatalf("expected mariadb to stay enabled, got %+v", c)
+		}
+	}
+
+	runner.commands = nil
+	if err := svc.EnableComponent(ctx, "postgresql", "admin@example.com"); err != nil {
+		t.Fatalf("enable: %v", err)
+	}
+	if len(runner.commands) != 2 || runner.commands[1] != "systemctl enable --now aipanel-runtime-postgresql.service" {
+		t.Fatalf("unexpected enable commands: %v", runner.commands)
+	}
+}
+
+func TestDisableComponent_RefusesEngineWithDatabases(t *testing.T) {
+	ctx := context.Background()
+	runner := &fakeLiveRunner{}
+	svc, _ := newTestService(t, runner)
+	if err := svc.store.ExecPanel(ctx, `
+INSERT INTO sites(domain, root_dir, php_version, system_user, status, created_at, updated_at)
+VALUES('example.com', '/var/www/example.com/public_html', '8.3', 'site_example_com', 'active', 1, 1);
+INSERT INTO site_databases(site_id, db_name, db_user, db_engine, created_at)
+VALUES(1, 'app', 'pg_app', 'postgres', 1);`); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	if err := svc.DisableComponent(ctx, "postgresql", ""); !errors.Is(err, ErrComponentInUse) {
+		t.Fatalf("expected ErrComponentInUse, got %v", err)
+	}
+	if len(runner.commands) != 0 {
+		t.Fatalf("expected no systemctl calls, got %v", runner.commands)
+	}
+}
+
+func TestUpgradeAdminTool_RunsInstallerWithPinnedRelease(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	pmaDir := filepath.Join(root, "phpmyadmin")
+	if err := os.MkdirAll(pmaDir, 0o750); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(pmaDir, installer.AdminToolVersionFile), []byte(`{"name":"phpmyadmin","version":"5.2.3"}`), 0o600); err != nil {
+		t.Fatalf("write marker: %v", err)
+	}
+	manifest := filepath.Join(root, "admin-tools.json")
+	if err := os.WriteFile(manifest, []byte(`{
+  "schema_version": 1,
+  "tools": {
+    "phpmyadmin": {
+      "version": "5.2.10",
+      "source_url": "https://files.phpmyadmin.net/phpMyAdmin/5.2.10/phpMyAdmin-5.2.10-all-languages.tar.gz",
+      "sha256_url": "https://files.phpmyadmin.net/phpMyAdmin/5.2.10/phpMyAdmin-5.2.10-all-languages.tar.gz.sha256",
+      "signature_url": "https://files.phpmyadmin.net/phpMyAdmin/5.2.10/phpMyAdmin-5.2.10-all-languages.tar.gz.asc",
+      "public_key_fingerprint": "3D06A59ECE730EB71B511C17CE752F178259BD92"
+    }
+  }
+}`), 0o600); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+
+	runner := &fakeLiveRunner{lines: []string{"[install_phpmyadmin] checksum verified"}}
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	queue := jobqueue.New(store, nil)
+	svc := NewService(store, config.Config{DataDir: store.DataDir, AdminToolsManifestURL: manifest}, slog.Default(), runner, queue, Options{
+		PanelBinary:   "/usr/local/bin/aipanel",
+		PHPMyAdminDir: pmaDir,
+		PGAdminDir:    filepath.Join(root, "pgadmin4"),
+	})
+
+	if _, err := svc.UpgradeAdminTool(ctx, "phpmyadmin", "admin@example.com"); !errors.Is(err, ErrNoPinnedRelease) {
+		t.Fatalf("expected ErrNoPinnedRelease before a check, got %v", err)
+	}
+	tools, err := svc.CheckAdminTools(ctx, "admin@example.com")
+	if err != nil {
+		t.Fatalf("check admin tools: %v", err)
+	}
+	if len(tools) != 2 || !tools[0].UpdateAvailable || tools[0].InstalledVersion != "5.2.3" || tools[0].LatestVersion != "5.2.10" {
+		t.Fatalf("expected phpmyadmin 5.2.3 -> 5.2.10 update, got %+v", tools)
+	}
+	if tools[1].InstalledVersion != "" || tools[1].UpdateAvailable {
+		t.Fatalf("expected pgadmin to be reported as not installed, got %+v", tools[1])
+	}
+	if _, err := svc.UpgradeAdminTool(ctx, "pgadmin", ""); !errors.Is(err, ErrAdminToolNotInstalled) {
+		t.Fatalf("expected ErrAdminToolNotInstalled, got %v", err)
+	}
+
+	job, err := svc.UpgradeAdminTool(ctx, "phpmyadmin", "admin@example.com")
+	if err != nil {
+		t.Fatalf("upgrade: %v", err)
+	}
+	if _, err := queue.RunPending(ctx); err != nil {
+		t.Fatalf("run jobs: %v", err)
+	}
+	want := "/usr/local/bin/aipanel install --only install_phpmyadmin --upgrade-admin-tools" +
+		" --phpmyadmin-version 5.2.10" +
+		" --phpmyadmin-url https://files.phpmyadmin.net/phpMyAdmin/5.2.10/phpMyAdmin-5.2.10-all-languages.tar.gz" +
+		" --phpmyadmin-sha256-url https://files.phpmyadmin.net/phpMyAdmin/5.2.10/phpMyAdmin-5.2.10-all-languages.tar.gz.sha256" +
+		" --phpmyadmin-signature-url https://files.phpmyadmin.net/phpMyAdmin/5.2.10/phpMyAdmin-5.2.10-all-languages.tar.gz.asc" +
+		" --phpmyadmin-fingerprint 3D06A59ECE730EB71B511C17CE752F178259BD92" +
+		" --data-dir " + store.DataDir
+	if len(runner.commands) != 1 || runner.commands[0] != want {
+		t.Fatalf("expected %q, got %v", want, runner.commands)
+	}
+	progress, err := svc.JobProgress(ctx, job.ID, 0)
+	if err != nil || progress.Job.Status != jobqueue.StatusDone {
+		t.Fatalf("expected finished upgrade job, got %+v (%v)", progress.Job, err)
+	}
+	rows, err := svc.adminToolRows(ctx)
+	if err != nil || rows["phpmyadmin"].installed != "5.2.10" {
+		t.Fatalf("expected installed version to be recorded, got %+v (%v)", rows["phpmyadmin"], err)
+	}
+}
+
+func TestAdminToolsManifest_ShippedManifestIsValid(t *testing.T) {
+	svc := &Service{cfg: config.Config{AdminToolsManifestURL: filepath.Join("..", "..", "..", "configs", "sources", "admin-tools.json")}}
+	manifest, err := svc.fetchAdminToolsManifest(context.Background())
+	if err != nil {
+		t.Fatalf("load shipped manifest: %v", err)
+	}
+	for _, name := range AdminTools {
+		if manifest.Tools[name].Version == "" {
+			t.Fatalf("expected %s to be pinned, got %+v", name, manifest.Tools)
+		}
+	}
+}
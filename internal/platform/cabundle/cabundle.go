@@ -0,0 +1,115 @@
+// Package cabundle adds an operator-supplied CA bundle to the roots the
+// panel and the installer trust. It is meant for networks behind a
+// TLS-inspecting proxy, whose re-signed certificates would otherwise fail
+// verification of downloads, ACME requests, webhooks and keyserver lookups.
+package cabundle
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// systemBundles are the distribution CA bundles, first match wins. Debian
+// ships the first; the others cover hosts the CLI is run from.
+var systemBundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/cert.pem",
+}
+
+// Pool returns the system roots plus every certificate in the PEM file at
+// path. A file without a single certificate is an error, so a typo in the
+// path or a DER file does not silently leave the proxy untrusted.
+func Pool(path string) (*x509.CertPool, error) {
+	extra, err := read(path)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	pool.AppendCertsFromPEM(extra)
+	return pool, nil
+}
+
+// Apply makes the process and the commands it starts trust the bundle at
+// path. Go HTTP clients on http.DefaultTransport get the extended pool.
+// A combined bundle, the system bundle followed by the extra certificates,
+// is written to combinedPath and exported as SSL_CERT_FILE and
+// REQUESTS_CA_BUNDLE for curl, openssl and certbot, which replace their
+// roots with the file rather than adding to them.
+func Apply(path, combinedPath string) error {
+	pool, err := Pool(path)
+	if err != nil {
+		return err
+	}
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("ca bundle: default transport is %T", http.DefaultTransport)
+	}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	transport.TLSClientConfig.RootCAs = pool
+	if err := writeCombined(path, combinedPath); err != nil {
+		return err
+	}
+	for _, key := range []string{"SSL_CERT_FILE", "REQUESTS_CA_BUNDLE"} {
+		if err := os.Setenv(key, combinedPath); err != nil {
+			return fmt.Errorf("ca bundle: set %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// DirmngrConf is the dirmngr.conf line that makes gpg trust the bundle at
+// path for hkps keyserver lookups.
+func DirmngrConf(path string) string {
+	return "hkp-cacert " + strings.TrimSpace(path) + "\n"
+}
+
+func read(path string) ([]byte, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return nil, fmt.Errorf("ca bundle: empty path")
+	}
+	//nolint:gosec // G304: the bundle path is operator configuration.
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ca bundle: %w", err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("ca bundle: %s holds no PEM certificates", path)
+	}
+	return b, nil
+}
+
+func writeCombined(path, combinedPath string) error {
+	extra, err := read(path)
+	if err != nil {
+		return err
+	}
+	var combined []byte
+	for _, system := range systemBundles {
+		//nolint:gosec // G304: fixed distribution paths.
+		if b, err := os.ReadFile(system); err == nil {
+			combined = append(b, '\n')
+			break
+		}
+	}
+	combined = append(combined, extra...)
+	if err := os.MkdirAll(filepath.Dir(combinedPath), 0o755); err != nil {
+		return fmt.Errorf("ca bundle: %w", err)
+	}
+	//nolint:gosec // G306: CA certificates are public.
+	if err := os.WriteFile(combinedPath, combined, 0o644); err != nil {
+		return fmt.Errorf("ca bundle: %w", err)
+	}
+	return nil
+}
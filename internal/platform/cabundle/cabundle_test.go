@@ -0,0 +1,78 @@
+package cabundle
+
+import (
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApply_TrustsExtraCA(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	transport := http.DefaultTransport.(*http.Transport)
+	saved := transport.TLSClientConfig
+	t.Cleanup(func() { transport.TLSClientConfig = saved })
+	t.Setenv("SSL_CERT_FILE", "")
+	t.Setenv("REQUESTS_CA_BUNDLE", "")
+
+	client := &http.Client{}
+	if resp, err := client.Get(srv.URL); err == nil {
+		_ = resp.Body.Close()
+		t.Fatal("expected the test server untrusted before Apply")
+	}
+
+	dir := t.TempDir()
+	bundle := filepath.Join(dir, "proxy-ca.pem")
+	block := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(bundle, block, 0o600); err != nil {
+		t.Fatalf("write bundle: %v", err)
+	}
+	combined := filepath.Join(dir, "data", "ca-bundle.pem")
+	if err := Apply(bundle, combined); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	transport.CloseIdleConnections()
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("expected the test server trusted after Apply, got %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if os.Getenv("SSL_CERT_FILE") != combined || os.Getenv("REQUESTS_CA_BUNDLE") != combined {
+		t.Fatalf("expected combined bundle exported, got %q / %q", os.Getenv("SSL_CERT_FILE"), os.Getenv("REQUESTS_CA_BUNDLE"))
+	}
+	b, err := os.ReadFile(combined) //nolint:gosec // test reads file in temp dir.
+	if err != nil || !strings.HasSuffix(string(b), string(block)) {
+		t.Fatalf("expected the extra CA at the end of the combined bundle, got %v", err)
+	}
+}
+
+func TestPool_RejectsFileWithoutCertificates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bundle.pem")
+	if err := os.WriteFile(path, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("write bundle: %v", err)
+	}
+	if _, err := Pool(path); err == nil || !strings.Contains(err.Error(), "no PEM certificates") {
+		t.Fatalf("expected missing certificates rejected, got %v", err)
+	}
+	if _, err := Pool(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Fatal("expected a missing file rejected")
+	}
+}
+
+func TestDirmngrConf(t *testing.T) {
+	if got := DirmngrConf(" /etc/aipanel/proxy-ca.pem "); got != "hkp-cacert /etc/aipanel/proxy-ca.pem\n" {
+		t.Fatalf("unexpected dirmngr line %q", got)
+	}
+}
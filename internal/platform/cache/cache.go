@@ -0,0 +1,116 @@
+// Package cache provides a small in-memory TTL cache for hot read paths.
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultMaxEntries bounds memory use when callers key by unbounded input (e.g. tokens).
+const defaultMaxEntries = 10000
+
+// TTL is a concurrency-safe key/value cache whose entries expire after a fixed TTL.
+type TTL[K comparable, V any] struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	items      map[K]entry[V]
+	now        func() time.Time
+}
+
+type entry[V any] struct {
+	value     V
+	expiresAt time.Time
+}
+
+// New creates a cache with the given entry lifetime. A non-positive ttl disables caching.
+func New[K comparable, V any](ttl time.Duration) *TTL[K, V] {
+	return &TTL[K, V]{
+		ttl:        ttl,
+		maxEntries: defaultMaxEntries,
+		items:      map[K]entry[V]{},
+		now:        time.Now,
+	}
+}
+
+// Get returns a cached value if present and not expired.
+func (c *TTL[K, V]) Get(key K) (V, bool) {
+	var zero V
+	if c == nil {
+		return zero, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+	if !c.now().Before(e.expiresAt) {
+		delete(c.items, key)
+		return zero, false
+	}
+	return e.value, true
+}
+
+// Set stores value for the cache TTL.
+func (c *TTL[K, V]) Set(key K, value V) {
+	if c == nil {
+		return
+	}
+	c.SetUntil(key, value, time.Time{})
+}
+
+// SetUntil stores value until the earlier of the cache TTL and deadline
+// (zero deadline means TTL only).
+func (c *TTL[K, V]) SetUntil(key K, value V, deadline time.Time) {
+	if c == nil || c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	expiresAt := now.Add(c.ttl)
+	if !deadline.IsZero() && deadline.Before(expiresAt) {
+		expiresAt = deadline
+	}
+	if !now.Before(expiresAt) {
+		delete(c.items, key)
+		return
+	}
+	if _, exists := c.items[key]; !exists && len(c.items) >= c.maxEntries {
+		c.evictLocked(now)
+	}
+	c.items[key] = entry[V]{value: value, expiresAt: expiresAt}
+}
+
+// Delete invalidates a single key.
+func (c *TTL[K, V]) Delete(key K) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.items, key)
+}
+
+// Purge invalidates all keys.
+func (c *TTL[K, V]) Purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = map[K]entry[V]{}
+}
+
+// evictLocked drops expired entries, or everything when the cache is full of live ones.
+func (c *TTL[K, V]) evictLocked(now time.Time) {
+	for k, e := range c.items {
+		if !now.Before(e.expiresAt) {
+			delete(c.items, k)
+		}
+	}
+	if len(c.items) >= c.maxEntries {
+		c.items = map[K]entry[V]{}
+	}
+}
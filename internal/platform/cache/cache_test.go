@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTL_ExpiresAndInvalidates(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := New[string, int](10 * time.Second)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", 1)
+	c.SetUntil("b", 2, now.Add(2*time.Second))
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected cached a=1, got %d %t", v, ok)
+	}
+
+	now = now.Add(3 * time.Second)
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("expected b to expire at its deadline")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected a to still be cached")
+	}
+
+	c.Delete("a")
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected a to be invalidated")
+	}
+
+	c.Set("c", 3)
+	now = now.Add(11 * time.Second)
+	if _, ok := c.Get("c"); ok {
+		t.Fatal("expected c to expire after ttl")
+	}
+}
+
+func TestTTL_BoundsEntries(t *testing.T) {
+	c := New[int, int](time.Minute)
+	c.maxEntries = 2
+	c.Set(1, 1)
+	c.Set(2, 2)
+	c.Set(3, 3)
+	if len(c.items) > 2 {
+		t.Fatalf("expected at most 2 entries, got %d", len(c.items))
+	}
+	if v, ok := c.Get(3); !ok || v != 3 {
+		t.Fatal("expected newest entry to be cached")
+	}
+}
@@ -4,11 +4,25 @@ package config
 import (
 	"bufio"
 	"fmt"
+	"net"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/heartbeat"
+)
+
+// Catch-all modes for the nginx default server.
+const (
+	// CatchAllDrop closes the connection without a response (444).
+	CatchAllDrop = "drop"
+	// CatchAllRedirect redirects to the panel domain.
+	CatchAllRedirect = "redirect"
+	// CatchAllLanding serves the catch-all landing page.
+	CatchAllLanding = "landing"
 )
 
 // Config is the runtime configuration for aiPanel.
@@ -19,17 +33,178 @@ type Config struct {
 	DevFrontendProxy  string
 	SessionCookieName string
 	SessionTTL        time.Duration
+	LogRequestBodies  bool
+	LogSampleRate     float64
+	LogFormat         string
+	LogLevel          string
+	LogFile           string
+	LogMaxSizeMB      int
+	LogMaxBackups     int
+	LogLevels         map[string]string
+	SMTPHost          string
+	SMTPPort          int
+	SMTPUsername      string
+	SMTPPassword      string
+	SMTPFrom          string
+	SMTPTLSMode       string
+	ACMEEmail         string
+	ACMEStaging       bool
+	ACMEWebroot       string
+	// CertRenewalHeartbeatURL is a healthchecks.io or Uptime Kuma push URL
+	// pinged after each daily certificate renewal pass.
+	CertRenewalHeartbeatURL string
+	// PanelDomain is the host name nginx serves the panel on; empty when
+	// the panel is reached by IP.
+	PanelDomain       string
+	PITRRetentionDays int
+	// CatchAllMode is how nginx answers requests for hosts no site serves:
+	// CatchAllDrop, CatchAllRedirect or CatchAllLanding.
+	CatchAllMode string
+	// AdminToolsManifestURL pins the phpMyAdmin/pgAdmin releases offered as
+	// upgrades; an empty value uses the manifest published with aiPanel.
+	AdminToolsManifestURL string
+	// PanelReleaseManifestURL lists the latest panel release for
+	// "aipanel update self" and the update banner; an empty value uses the
+	// manifest published with aiPanel releases.
+	PanelReleaseManifestURL string
+	// CABundlePath is a PEM file of extra CA certificates trusted next to
+	// the system roots, for networks behind a TLS-inspecting proxy.
+	CABundlePath string
+	// ListenAddrs are bound in addition to Addr, e.g. a WireGuard address
+	// next to the loopback address nginx proxies to. Entries take the same
+	// forms as Addr plus "iface:<name>:<port>", which binds every address
+	// of a network interface.
+	ListenAddrs []string
+	// TrustedProxies lists IPs and CIDR ranges whose X-Forwarded-For and
+	// CF-Connecting-IP headers are believed when resolving the client IP.
+	TrustedProxies []string
+	// Cloudflare settings: the API token needs Zone:Read, DNS:Edit and
+	// Cache Purge on the zones of hosted sites; DNS records point at the
+	// origin addresses.
+	CloudflareAPIToken   string
+	CloudflareOriginIPv4 string
+	CloudflareOriginIPv6 string
+	// WPScanAPIToken enables the daily vulnerability check of WordPress
+	// core, plugins and themes against the WPScan database.
+	WPScanAPIToken string
+	// Registrar selects the domain registrar integration used to check
+	// availability and set nameservers and glue records: "namecheap",
+	// "ovh", or empty to disable it.
+	Registrar         string
+	NamecheapAPIUser  string
+	NamecheapAPIKey   string
+	NamecheapUsername string
+	// NamecheapClientIP is the whitelisted address API calls come from.
+	NamecheapClientIP string
+	// OVHEndpoint is the API base URL of the OVH region the account lives
+	// in; OVHSubsidiary is the country the availability cart is opened in.
+	OVHEndpoint          string
+	OVHApplicationKey    string
+	OVHApplicationSecret string
+	OVHConsumerKey       string
+	OVHSubsidiary        string
+	// WebTerminalEnabled exposes a shell as the site user over WebSocket
+	// to panel admins. Off unless explicitly enabled.
+	WebTerminalEnabled bool
+	// CompressResponses gzips API and frontend responses whose content
+	// type is listed in CompressTypes when the client accepts gzip.
+	CompressResponses bool
+	CompressTypes     []string
+	// MaxRequestBodyMB bounds request bodies; upload endpoints use
+	// MaxUploadMB instead.
+	MaxRequestBodyMB int
+	MaxUploadMB      int
+	// RequestTimeout bounds API requests; it must stay below the server
+	// WriteTimeout (15s). ProvisioningTimeout applies to routes that run
+	// nginx, certbot or database tools and to uploads.
+	RequestTimeout      time.Duration
+	ProvisioningTimeout time.Duration
+	// SiteHealthChecks probes new sites over HTTP and their PHP-FPM pool
+	// before CreateSite succeeds; a site that is not serving is rolled back.
+	SiteHealthChecks bool
+	// NginxStatusURL is the loopback stub_status location polled for
+	// connection and request rate stats; empty disables polling.
+	NginxStatusURL string
+	// ErrorAlertThresholdPercent is the share of 5xx answers over the
+	// rolling window above which site owners are alerted; sites may
+	// override it. Zero disables the alerts.
+	ErrorAlertThresholdPercent float64
+	// MetricsExportFormat enables pushing the metrics registry to an
+	// external TSDB: "prometheus" (remote_write) or "influx" (line
+	// protocol). Empty disables the export.
+	MetricsExportFormat string
+	// MetricsExportURL is the remote_write or InfluxDB write endpoint.
+	MetricsExportURL string
+	// MetricsExportToken is sent as a bearer token (Prometheus) or an
+	// InfluxDB v2 API token; MetricsExportUsername and
+	// MetricsExportPassword use basic auth instead.
+	MetricsExportToken    string
+	MetricsExportUsername string
+	MetricsExportPassword string
+	// MetricsExportInterval is the time between pushes.
+	MetricsExportInterval time.Duration
+	// VarnishAddr is the host:port of varnishd that sites with the
+	// varnish page cache are proxied to.
+	VarnishAddr string
+	// VarnishBackendPort is the loopback port nginx serves those sites on
+	// for Varnish; the VCL backend must point at it.
+	VarnishBackendPort int
+	// SSHPort is kept open by the firewall baseline next to 80, 443 and
+	// the panel port.
+	SSHPort int
+	// FirewallConfirmWindow is how long a firewall change stays live
+	// without being confirmed before the previous rules come back.
+	FirewallConfirmWindow time.Duration
+	// LoginMaxFailures is how many failed logins for one email within
+	// LoginFailureWindow lock that email out; 0 disables the email lockout.
+	LoginMaxFailures int
+	// LoginMaxFailuresPerIP is the same threshold for one client address;
+	// it is higher because offices and NATs share an address. 0 disables it.
+	LoginMaxFailuresPerIP int
+	// LoginFailureWindow is how long failed logins are counted.
+	LoginFailureWindow time.Duration
+	// LoginLockout is how long a locked email or address is refused.
+	LoginLockout time.Duration
 }
 
 // Load reads defaults from a simple key/value YAML file and applies AIPANEL_* env overrides.
 func Load(path string) (Config, error) {
 	cfg := Config{
-		Addr:              ":8080",
-		Env:               "dev",
-		DataDir:           "./data",
-		DevFrontendProxy:  "http://localhost:5173",
-		SessionCookieName: "aipanel_session",
-		SessionTTL:        24 * time.Hour,
+		Addr:                       ":8080",
+		Env:                        "dev",
+		DataDir:                    "./data",
+		DevFrontendProxy:           "http://localhost:5173",
+		SessionCookieName:          "aipanel_session",
+		SessionTTL:                 24 * time.Hour,
+		LogSampleRate:              1,
+		LogFormat:                  "json",
+		LogMaxSizeMB:               100,
+		LogMaxBackups:              5,
+		SMTPPort:                   587,
+		SMTPTLSMode:                "starttls",
+		ACMEWebroot:                "/var/www/letsencrypt",
+		CatchAllMode:               CatchAllDrop,
+		PITRRetentionDays:          7,
+		TrustedProxies:             []string{"127.0.0.0/8", "::1/128"},
+		CompressResponses:          true,
+		MaxRequestBodyMB:           10,
+		MaxUploadMB:                2048,
+		RequestTimeout:             10 * time.Second,
+		ProvisioningTimeout:        5 * time.Minute,
+		SiteHealthChecks:           true,
+		OVHEndpoint:                "https://eu.api.ovh.com/1.0",
+		OVHSubsidiary:              "FR",
+		NginxStatusURL:             "http://127.0.0.1:8089/nginx_status",
+		ErrorAlertThresholdPercent: 5,
+		MetricsExportInterval:      60 * time.Second,
+		VarnishAddr:                "127.0.0.1:6081",
+		VarnishBackendPort:         8088,
+		SSHPort:                    22,
+		FirewallConfirmWindow:      5 * time.Minute,
+		LoginMaxFailures:           5,
+		LoginMaxFailuresPerIP:      20,
+		LoginFailureWindow:         15 * time.Minute,
+		LoginLockout:               15 * time.Minute,
 	}
 
 	if path != "" {
@@ -45,15 +220,192 @@ func Load(path string) (Config, error) {
 	if cfg.Addr == "" {
 		return Config{}, fmt.Errorf("addr cannot be empty")
 	}
+	if strings.HasPrefix(cfg.Addr, unixAddrPrefix) {
+		socketPath, ok := UnixSocketPath(cfg.Addr)
+		if !ok || !filepath.IsAbs(socketPath) {
+			return Config{}, fmt.Errorf("addr unix socket path must be absolute")
+		}
+	}
+	for _, addr := range cfg.ListenAddrs {
+		if err := ValidateListenAddr(addr); err != nil {
+			return Config{}, fmt.Errorf("listen_addrs: %w", err)
+		}
+	}
+	for _, entry := range cfg.TrustedProxies {
+		if !isIPOrPrefix(entry) {
+			return Config{}, fmt.Errorf("trusted_proxies: invalid entry %q", entry)
+		}
+	}
+	if v := cfg.CloudflareOriginIPv4; v != "" {
+		if addr, err := netip.ParseAddr(v); err != nil || !addr.Is4() {
+			return Config{}, fmt.Errorf("cloudflare_origin_ipv4 must be an IPv4 address")
+		}
+	}
+	if v := cfg.CloudflareOriginIPv6; v != "" {
+		if addr, err := netip.ParseAddr(v); err != nil || !addr.Is6() || addr.Is4In6() {
+			return Config{}, fmt.Errorf("cloudflare_origin_ipv6 must be an IPv6 address")
+		}
+	}
+	switch cfg.Registrar {
+	case "", "namecheap", "ovh":
+	default:
+		return Config{}, fmt.Errorf("registrar must be namecheap, ovh or empty")
+	}
 	if cfg.DataDir == "" {
 		return Config{}, fmt.Errorf("data_dir cannot be empty")
 	}
 	if cfg.SessionTTL <= 0 {
 		return Config{}, fmt.Errorf("session_ttl_hours must be > 0")
 	}
+	if cfg.LogSampleRate <= 0 || cfg.LogSampleRate > 1 {
+		return Config{}, fmt.Errorf("log_sample_rate must be in (0, 1]")
+	}
+	if cfg.ErrorAlertThresholdPercent < 0 || cfg.ErrorAlertThresholdPercent > 100 {
+		return Config{}, fmt.Errorf("error_alert_threshold_percent must be in [0, 100]")
+	}
+	switch cfg.MetricsExportFormat {
+	case "":
+	case "prometheus", "influx":
+		if cfg.MetricsExportURL == "" {
+			return Config{}, fmt.Errorf("metrics_export_url is required when metrics_export_format is set")
+		}
+	default:
+		return Config{}, fmt.Errorf("metrics_export_format must be prometheus, influx or empty")
+	}
+	switch strings.ToLower(cfg.LogFormat) {
+	case "json", "text", "journald":
+	default:
+		return Config{}, fmt.Errorf("log_format must be one of json, text, journald")
+	}
+	if cfg.LogLevel != "" && !isLogLevel(cfg.LogLevel) {
+		return Config{}, fmt.Errorf("log_level must be one of debug, info, warn, error")
+	}
+	for module, level := range cfg.LogLevels {
+		if !isLogLevel(level) {
+			return Config{}, fmt.Errorf("log_levels.%s must be one of debug, info, warn, error", module)
+		}
+	}
+	switch strings.ToLower(cfg.SMTPTLSMode) {
+	case "none", "starttls", "tls":
+	default:
+		return Config{}, fmt.Errorf("smtp_tls_mode must be one of none, starttls, tls")
+	}
+	cfg.CatchAllMode = strings.ToLower(strings.TrimSpace(cfg.CatchAllMode))
+	switch cfg.CatchAllMode {
+	case CatchAllDrop, CatchAllRedirect, CatchAllLanding:
+	default:
+		return Config{}, fmt.Errorf("catchall_mode must be one of drop, redirect, landing")
+	}
+	if cfg.SMTPPort <= 0 || cfg.SMTPPort > 65535 {
+		return Config{}, fmt.Errorf("smtp_port must be in 1-65535")
+	}
+	if _, _, err := net.SplitHostPort(cfg.VarnishAddr); err != nil {
+		return Config{}, fmt.Errorf("varnish_addr must be host:port")
+	}
+	if cfg.VarnishBackendPort <= 0 || cfg.VarnishBackendPort > 65535 {
+		return Config{}, fmt.Errorf("varnish_backend_port must be in 1-65535")
+	}
+	if cfg.SSHPort <= 0 || cfg.SSHPort > 65535 {
+		return Config{}, fmt.Errorf("ssh_port must be in 1-65535")
+	}
+	if cfg.FirewallConfirmWindow < time.Minute || cfg.FirewallConfirmWindow > 24*time.Hour {
+		return Config{}, fmt.Errorf("firewall_confirm_minutes must be in 1-1440")
+	}
+	if cfg.LoginMaxFailures < 0 || cfg.LoginMaxFailuresPerIP < 0 {
+		return Config{}, fmt.Errorf("login_max_failures and login_max_failures_per_ip must be >= 0 (0 disables)")
+	}
+	if cfg.LoginFailureWindow < time.Minute || cfg.LoginFailureWindow > 24*time.Hour {
+		return Config{}, fmt.Errorf("login_failure_window_minutes must be in 1-1440")
+	}
+	if cfg.LoginLockout < time.Minute || cfg.LoginLockout > 24*time.Hour {
+		return Config{}, fmt.Errorf("login_lockout_minutes must be in 1-1440")
+	}
+	if err := heartbeat.Validate(cfg.CertRenewalHeartbeatURL); err != nil {
+		return Config{}, fmt.Errorf("cert_renewal_heartbeat_url: %w", err)
+	}
 	return cfg, nil
 }
 
+const unixAddrPrefix = "unix:"
+
+// UnixSocketPath returns the socket path when addr uses the "unix:" scheme
+// (e.g. "unix:/run/aipanel.sock").
+func UnixSocketPath(addr string) (string, bool) {
+	a := strings.TrimSpace(addr)
+	if !strings.HasPrefix(a, unixAddrPrefix) {
+		return "", false
+	}
+	socketPath := strings.TrimSpace(strings.TrimPrefix(a, unixAddrPrefix))
+	if socketPath == "" {
+		return "", false
+	}
+	return socketPath, true
+}
+
+const interfaceAddrPrefix = "iface:"
+
+// InterfaceListenAddr returns the interface name and port when addr uses the
+// "iface:" scheme (e.g. "iface:wg0:8080").
+func InterfaceListenAddr(addr string) (string, string, bool) {
+	a := strings.TrimSpace(addr)
+	if !strings.HasPrefix(a, interfaceAddrPrefix) {
+		return "", "", false
+	}
+	name, port, ok := strings.Cut(strings.TrimPrefix(a, interfaceAddrPrefix), ":")
+	if !ok || strings.TrimSpace(name) == "" || strings.TrimSpace(port) == "" {
+		return "", "", false
+	}
+	return strings.TrimSpace(name), strings.TrimSpace(port), true
+}
+
+// ValidateListenAddr checks one panel listen address: "host:port",
+// "unix:/abs/path" or "iface:<name>:<port>".
+func ValidateListenAddr(addr string) error {
+	a := strings.TrimSpace(addr)
+	port := ""
+	switch {
+	case strings.HasPrefix(a, unixAddrPrefix):
+		socketPath, ok := UnixSocketPath(a)
+		if !ok || !filepath.IsAbs(socketPath) {
+			return fmt.Errorf("%q: unix socket path must be absolute", addr)
+		}
+		return nil
+	case strings.HasPrefix(a, interfaceAddrPrefix):
+		_, p, ok := InterfaceListenAddr(a)
+		if !ok {
+			return fmt.Errorf("%q: expected iface:<name>:<port>", addr)
+		}
+		port = p
+	default:
+		_, p, err := net.SplitHostPort(a)
+		if err != nil {
+			return fmt.Errorf("%q: %w", addr, err)
+		}
+		port = p
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return fmt.Errorf("%q: port must be in 1-65535", addr)
+	}
+	return nil
+}
+
+func isIPOrPrefix(value string) bool {
+	if _, err := netip.ParsePrefix(value); err == nil {
+		return true
+	}
+	_, err := netip.ParseAddr(value)
+	return err == nil
+}
+
+func isLogLevel(level string) bool {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug", "info", "warn", "warning", "error":
+		return true
+	default:
+		return false
+	}
+}
+
 func normalizeDataDir(cfg *Config, configPath string) error {
 	if cfg.DataDir == "" {
 		return nil
@@ -89,11 +441,20 @@ func mergeFromFile(cfg *Config, path string) error {
 	}()
 
 	scanner := bufio.NewScanner(f)
+	// blockKey is set while reading indented "name: value" entries of a map key
+	// or "- value" items of a list key written in block style (a key followed
+	// by no value).
+	blockKey := ""
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
+		if item, ok := strings.CutPrefix(line, "- "); ok && isListKey(blockKey) {
+			applyListEntry(cfg, blockKey, strings.Trim(strings.TrimSpace(item), `"'`))
+			continue
+		}
 		idx := strings.Index(line, ":")
 		if idx <= 0 {
 			continue
@@ -101,6 +462,21 @@ func mergeFromFile(cfg *Config, path string) error {
 		key := strings.TrimSpace(line[:idx])
 		val := strings.TrimSpace(line[idx+1:])
 		val = strings.Trim(val, `"'`)
+		indented := strings.HasPrefix(raw, " ") || strings.HasPrefix(raw, "\t")
+		if indented && blockKey != "" {
+			applyMapEntry(cfg, blockKey, key, val)
+			continue
+		}
+		blockKey = ""
+		if val == "" && isMapKey(key) {
+			blockKey = key
+			continue
+		}
+		if val == "" && isListKey(key) {
+			applyKey(cfg, key, "")
+			blockKey = key
+			continue
+		}
 		applyKey(cfg, key, val)
 	}
 	if err := scanner.Err(); err != nil {
@@ -116,6 +492,8 @@ func mergeFromEnv(cfg *Config) {
 	}
 	maps := []envMap{
 		{key: "AIPANEL_ADDR", set: func(v string) { cfg.Addr = v }},
+		{key: "AIPANEL_LISTEN_ADDRS", set: func(v string) { cfg.ListenAddrs = parseInlineList(v) }},
+		{key: "AIPANEL_TRUSTED_PROXIES", set: func(v string) { cfg.TrustedProxies = parseInlineList(v) }},
 		{key: "AIPANEL_ENV", set: func(v string) { cfg.Env = v }},
 		{key: "AIPANEL_DATA_DIR", set: func(v string) { cfg.DataDir = v }},
 		{key: "AIPANEL_DEV_FRONTEND_PROXY", set: func(v string) { cfg.DevFrontendProxy = v }},
@@ -125,6 +503,124 @@ func mergeFromEnv(cfg *Config) {
 				cfg.SessionTTL = time.Duration(h) * time.Hour
 			}
 		}},
+		{key: "AIPANEL_LOG_REQUEST_BODIES", set: func(v string) { cfg.LogRequestBodies = parseBool(v, cfg.LogRequestBodies) }},
+		{key: "AIPANEL_LOG_SAMPLE_RATE", set: func(v string) { cfg.LogSampleRate = parseFloat(v, cfg.LogSampleRate) }},
+		{key: "AIPANEL_LOG_FORMAT", set: func(v string) { cfg.LogFormat = v }},
+		{key: "AIPANEL_LOG_LEVEL", set: func(v string) { cfg.LogLevel = v }},
+		{key: "AIPANEL_LOG_FILE", set: func(v string) { cfg.LogFile = v }},
+		{key: "AIPANEL_LOG_LEVELS", set: func(v string) { cfg.LogLevels = parseInlineMap(v) }},
+		{key: "AIPANEL_SMTP_HOST", set: func(v string) { cfg.SMTPHost = v }},
+		{key: "AIPANEL_SMTP_PORT", set: func(v string) {
+			if n, err := strconv.Atoi(v); err == nil {
+				cfg.SMTPPort = n
+			}
+		}},
+		{key: "AIPANEL_SMTP_USERNAME", set: func(v string) { cfg.SMTPUsername = v }},
+		{key: "AIPANEL_SMTP_PASSWORD", set: func(v string) { cfg.SMTPPassword = v }},
+		{key: "AIPANEL_SMTP_FROM", set: func(v string) { cfg.SMTPFrom = v }},
+		{key: "AIPANEL_SMTP_TLS_MODE", set: func(v string) { cfg.SMTPTLSMode = v }},
+		{key: "AIPANEL_ACME_EMAIL", set: func(v string) { cfg.ACMEEmail = v }},
+		{key: "AIPANEL_ACME_STAGING", set: func(v string) { cfg.ACMEStaging = parseBool(v, cfg.ACMEStaging) }},
+		{key: "AIPANEL_ACME_WEBROOT", set: func(v string) { cfg.ACMEWebroot = v }},
+		{key: "AIPANEL_PANEL_DOMAIN", set: func(v string) { cfg.PanelDomain = v }},
+		{key: "AIPANEL_CATCHALL_MODE", set: func(v string) { cfg.CatchAllMode = v }},
+		{key: "AIPANEL_CERT_RENEWAL_HEARTBEAT_URL", set: func(v string) { cfg.CertRenewalHeartbeatURL = v }},
+		{key: "AIPANEL_PITR_RETENTION_DAYS", set: func(v string) {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				cfg.PITRRetentionDays = n
+			}
+		}},
+		{key: "AIPANEL_ADMIN_TOOLS_MANIFEST_URL", set: func(v string) { cfg.AdminToolsManifestURL = v }},
+		{key: "AIPANEL_PANEL_RELEASE_MANIFEST_URL", set: func(v string) { cfg.PanelReleaseManifestURL = v }},
+		{key: "AIPANEL_CA_BUNDLE_PATH", set: func(v string) { cfg.CABundlePath = v }},
+		{key: "AIPANEL_CLOUDFLARE_API_TOKEN", set: func(v string) { cfg.CloudflareAPIToken = v }},
+		{key: "AIPANEL_CLOUDFLARE_ORIGIN_IPV4", set: func(v string) { cfg.CloudflareOriginIPv4 = v }},
+		{key: "AIPANEL_CLOUDFLARE_ORIGIN_IPV6", set: func(v string) { cfg.CloudflareOriginIPv6 = v }},
+		{key: "AIPANEL_WPSCAN_API_TOKEN", set: func(v string) { cfg.WPScanAPIToken = v }},
+		{key: "AIPANEL_REGISTRAR", set: func(v string) { cfg.Registrar = strings.ToLower(v) }},
+		{key: "AIPANEL_NAMECHEAP_API_USER", set: func(v string) { cfg.NamecheapAPIUser = v }},
+		{key: "AIPANEL_NAMECHEAP_API_KEY", set: func(v string) { cfg.NamecheapAPIKey = v }},
+		{key: "AIPANEL_NAMECHEAP_USERNAME", set: func(v string) { cfg.NamecheapUsername = v }},
+		{key: "AIPANEL_NAMECHEAP_CLIENT_IP", set: func(v string) { cfg.NamecheapClientIP = v }},
+		{key: "AIPANEL_OVH_ENDPOINT", set: func(v string) { cfg.OVHEndpoint = v }},
+		{key: "AIPANEL_OVH_APPLICATION_KEY", set: func(v string) { cfg.OVHApplicationKey = v }},
+		{key: "AIPANEL_OVH_APPLICATION_SECRET", set: func(v string) { cfg.OVHApplicationSecret = v }},
+		{key: "AIPANEL_OVH_CONSUMER_KEY", set: func(v string) { cfg.OVHConsumerKey = v }},
+		{key: "AIPANEL_OVH_SUBSIDIARY", set: func(v string) { cfg.OVHSubsidiary = v }},
+		{key: "AIPANEL_WEB_TERMINAL_ENABLED", set: func(v string) { cfg.WebTerminalEnabled = parseBool(v, cfg.WebTerminalEnabled) }},
+		{key: "AIPANEL_NGINX_STATUS_URL", set: func(v string) { cfg.NginxStatusURL = v }},
+		{key: "AIPANEL_ERROR_ALERT_THRESHOLD_PERCENT", set: func(v string) {
+			cfg.ErrorAlertThresholdPercent = parseFloat(v, cfg.ErrorAlertThresholdPercent)
+		}},
+		{key: "AIPANEL_METRICS_EXPORT_FORMAT", set: func(v string) { cfg.MetricsExportFormat = strings.ToLower(v) }},
+		{key: "AIPANEL_METRICS_EXPORT_URL", set: func(v string) { cfg.MetricsExportURL = v }},
+		{key: "AIPANEL_METRICS_EXPORT_TOKEN", set: func(v string) { cfg.MetricsExportToken = v }},
+		{key: "AIPANEL_METRICS_EXPORT_USERNAME", set: func(v string) { cfg.MetricsExportUsername = v }},
+		{key: "AIPANEL_METRICS_EXPORT_PASSWORD", set: func(v string) { cfg.MetricsExportPassword = v }},
+		{key: "AIPANEL_METRICS_EXPORT_INTERVAL_SECONDS", set: func(v string) {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				cfg.MetricsExportInterval = time.Duration(n) * time.Second
+			}
+		}},
+		{key: "AIPANEL_VARNISH_ADDR", set: func(v string) { cfg.VarnishAddr = v }},
+		{key: "AIPANEL_VARNISH_BACKEND_PORT", set: func(v string) {
+			if n, err := strconv.Atoi(v); err == nil {
+				cfg.VarnishBackendPort = n
+			}
+		}},
+		{key: "AIPANEL_SSH_PORT", set: func(v string) {
+			if n, err := strconv.Atoi(v); err == nil {
+				cfg.SSHPort = n
+			}
+		}},
+		{key: "AIPANEL_FIREWALL_CONFIRM_MINUTES", set: func(v string) {
+			if n, err := strconv.Atoi(v); err == nil {
+				cfg.FirewallConfirmWindow = time.Duration(n) * time.Minute
+			}
+		}},
+		{key: "AIPANEL_LOGIN_MAX_FAILURES", set: func(v string) {
+			if n, err := strconv.Atoi(v); err == nil {
+				cfg.LoginMaxFailures = n
+			}
+		}},
+		{key: "AIPANEL_LOGIN_MAX_FAILURES_PER_IP", set: func(v string) {
+			if n, err := strconv.Atoi(v); err == nil {
+				cfg.LoginMaxFailuresPerIP = n
+			}
+		}},
+		{key: "AIPANEL_LOGIN_FAILURE_WINDOW_MINUTES", set: func(v string) {
+			if n, err := strconv.Atoi(v); err == nil {
+				cfg.LoginFailureWindow = time.Duration(n) * time.Minute
+			}
+		}},
+		{key: "AIPANEL_LOGIN_LOCKOUT_MINUTES", set: func(v string) {
+			if n, err := strconv.Atoi(v); err == nil {
+				cfg.LoginLockout = time.Duration(n) * time.Minute
+			}
+		}},
+		{key: "AIPANEL_SITE_HEALTH_CHECKS", set: func(v string) { cfg.SiteHealthChecks = parseBool(v, cfg.SiteHealthChecks) }},
+		{key: "AIPANEL_COMPRESS_RESPONSES", set: func(v string) { cfg.CompressResponses = parseBool(v, cfg.CompressResponses) }},
+		{key: "AIPANEL_COMPRESS_TYPES", set: func(v string) { cfg.CompressTypes = parseInlineList(v) }},
+		{key: "AIPANEL_MAX_REQUEST_BODY_MB", set: func(v string) {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				cfg.MaxRequestBodyMB = n
+			}
+		}},
+		{key: "AIPANEL_MAX_UPLOAD_MB", set: func(v string) {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				cfg.MaxUploadMB = n
+			}
+		}},
+		{key: "AIPANEL_REQUEST_TIMEOUT_SECONDS", set: func(v string) {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				cfg.RequestTimeout = time.Duration(n) * time.Second
+			}
+		}},
+		{key: "AIPANEL_PROVISIONING_TIMEOUT_SECONDS", set: func(v string) {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				cfg.ProvisioningTimeout = time.Duration(n) * time.Second
+			}
+		}},
 	}
 	for _, m := range maps {
 		if v, ok := os.LookupEnv(m.key); ok {
@@ -137,6 +633,10 @@ func applyKey(cfg *Config, key, val string) {
 	switch key {
 	case "addr":
 		cfg.Addr = val
+	case "listen_addrs":
+		cfg.ListenAddrs = parseInlineList(val)
+	case "trusted_proxies":
+		cfg.TrustedProxies = parseInlineList(val)
 	case "env":
 		cfg.Env = val
 	case "data_dir":
@@ -149,5 +649,252 @@ func applyKey(cfg *Config, key, val string) {
 		if h, err := strconv.Atoi(val); err == nil && h > 0 {
 			cfg.SessionTTL = time.Duration(h) * time.Hour
 		}
+	case "log_request_bodies":
+		cfg.LogRequestBodies = parseBool(val, cfg.LogRequestBodies)
+	case "log_sample_rate":
+		cfg.LogSampleRate = parseFloat(val, cfg.LogSampleRate)
+	case "log_format":
+		cfg.LogFormat = val
+	case "log_level":
+		cfg.LogLevel = val
+	case "log_file":
+		cfg.LogFile = val
+	case "log_max_size_mb":
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			cfg.LogMaxSizeMB = n
+		}
+	case "log_max_backups":
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			cfg.LogMaxBackups = n
+		}
+	case "log_levels":
+		cfg.LogLevels = parseInlineMap(val)
+	case "smtp_host":
+		cfg.SMTPHost = val
+	case "smtp_port":
+		if n, err := strconv.Atoi(val); err == nil {
+			cfg.SMTPPort = n
+		}
+	case "smtp_username":
+		cfg.SMTPUsername = val
+	case "smtp_password":
+		cfg.SMTPPassword = val
+	case "smtp_from":
+		cfg.SMTPFrom = val
+	case "smtp_tls_mode":
+		cfg.SMTPTLSMode = val
+	case "acme_email":
+		cfg.ACMEEmail = val
+	case "acme_staging":
+		cfg.ACMEStaging = parseBool(val, cfg.ACMEStaging)
+	case "acme_webroot":
+		cfg.ACMEWebroot = val
+	case "panel_domain":
+		cfg.PanelDomain = val
+	case "catchall_mode":
+		cfg.CatchAllMode = val
+	case "cert_renewal_heartbeat_url":
+		cfg.CertRenewalHeartbeatURL = val
+	case "pitr_retention_days":
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			cfg.PITRRetentionDays = n
+		}
+	case "nginx_status_url":
+		cfg.NginxStatusURL = val
+	case "admin_tools_manifest_url":
+		cfg.AdminToolsManifestURL = val
+	case "panel_release_manifest_url":
+		cfg.PanelReleaseManifestURL = val
+	case "ca_bundle_path":
+		cfg.CABundlePath = val
+	case "cloudflare_api_token":
+		cfg.CloudflareAPIToken = val
+	case "cloudflare_origin_ipv4":
+		cfg.CloudflareOriginIPv4 = val
+	case "cloudflare_origin_ipv6":
+		cfg.CloudflareOriginIPv6 = val
+	case "wpscan_api_token":
+		cfg.WPScanAPIToken = val
+	case "registrar":
+		cfg.Registrar = strings.ToLower(val)
+	case "namecheap_api_user":
+		cfg.NamecheapAPIUser = val
+	case "namecheap_api_key":
+		cfg.NamecheapAPIKey = val
+	case "namecheap_username":
+		cfg.NamecheapUsername = val
+	case "namecheap_client_ip":
+		cfg.NamecheapClientIP = val
+	case "ovh_endpoint":
+		cfg.OVHEndpoint = val
+	case "ovh_application_key":
+		cfg.OVHApplicationKey = val
+	case "ovh_application_secret":
+		cfg.OVHApplicationSecret = val
+	case "ovh_consumer_key":
+		cfg.OVHConsumerKey = val
+	case "ovh_subsidiary":
+		cfg.OVHSubsidiary = val
+	case "web_terminal_enabled":
+		cfg.WebTerminalEnabled = parseBool(val, cfg.WebTerminalEnabled)
+	case "error_alert_threshold_percent":
+		cfg.ErrorAlertThresholdPercent = parseFloat(val, cfg.ErrorAlertThresholdPercent)
+	case "metrics_export_format":
+		cfg.MetricsExportFormat = strings.ToLower(val)
+	case "metrics_export_url":
+		cfg.MetricsExportURL = val
+	case "metrics_export_token":
+		cfg.MetricsExportToken = val
+	case "metrics_export_username":
+		cfg.MetricsExportUsername = val
+	case "metrics_export_password":
+		cfg.MetricsExportPassword = val
+	case "metrics_export_interval_seconds":
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			cfg.MetricsExportInterval = time.Duration(n) * time.Second
+		}
+	case "varnish_addr":
+		cfg.VarnishAddr = val
+	case "varnish_backend_port":
+		if n, err := strconv.Atoi(val); err == nil {
+			cfg.VarnishBackendPort = n
+		}
+	case "ssh_port":
+		if n, err := strconv.Atoi(val); err == nil {
+			cfg.SSHPort = n
+		}
+	case "firewall_confirm_minutes":
+		if n, err := strconv.Atoi(val); err == nil {
+			cfg.FirewallConfirmWindow = time.Duration(n) * time.Minute
+		}
+	case "login_max_failures":
+		if n, err := strconv.Atoi(val); err == nil {
+			cfg.LoginMaxFailures = n
+		}
+	case "login_max_failures_per_ip":
+		if n, err := strconv.Atoi(val); err == nil {
+			cfg.LoginMaxFailuresPerIP = n
+		}
+	case "login_failure_window_minutes":
+		if n, err := strconv.Atoi(val); err == nil {
+			cfg.LoginFailureWindow = time.Duration(n) * time.Minute
+		}
+	case "login_lockout_minutes":
+		if n, err := strconv.Atoi(val); err == nil {
+			cfg.LoginLockout = time.Duration(n) * time.Minute
+		}
+	case "site_health_checks":
+		cfg.SiteHealthChecks = parseBool(val, cfg.SiteHealthChecks)
+	case "compress_responses":
+		cfg.CompressResponses = parseBool(val, cfg.CompressResponses)
+	case "compress_types":
+		cfg.CompressTypes = parseInlineList(val)
+	case "max_request_body_mb":
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			cfg.MaxRequestBodyMB = n
+		}
+	case "max_upload_mb":
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			cfg.MaxUploadMB = n
+		}
+	case "request_timeout_seconds":
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			cfg.RequestTimeout = time.Duration(n) * time.Second
+		}
+	case "provisioning_timeout_seconds":
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			cfg.ProvisioningTimeout = time.Duration(n) * time.Second
+		}
+	}
+}
+
+func isMapKey(key string) bool {
+	switch key {
+	case "log_levels":
+		return true
+	default:
+		return false
+	}
+}
+
+func isListKey(key string) bool {
+	switch key {
+	case "listen_addrs", "trusted_proxies":
+		return true
+	default:
+		return false
+	}
+}
+
+func applyListEntry(cfg *Config, key, val string) {
+	switch key {
+	case "listen_addrs":
+		cfg.ListenAddrs = append(cfg.ListenAddrs, val)
+	case "trusted_proxies":
+		cfg.TrustedProxies = append(cfg.TrustedProxies, val)
+	}
+}
+
+func applyMapEntry(cfg *Config, key, name, val string) {
+	switch key {
+	case "log_levels":
+		if cfg.LogLevels == nil {
+			cfg.LogLevels = map[string]string{}
+		}
+		cfg.LogLevels[name] = val
+	}
+}
+
+// parseInlineMap parses "{a: b, c: d}" or "a=b,c=d" into a map.
+func parseInlineMap(val string) map[string]string {
+	v := strings.TrimSpace(val)
+	v = strings.TrimPrefix(v, "{")
+	v = strings.TrimSuffix(v, "}")
+	out := map[string]string{}
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		idx := strings.IndexAny(part, ":=")
+		if idx <= 0 {
+			continue
+		}
+		name := strings.Trim(strings.TrimSpace(part[:idx]), `"'`)
+		entry := strings.Trim(strings.TrimSpace(part[idx+1:]), `"'`)
+		if name != "" {
+			out[name] = entry
+		}
+	}
+	return out
+}
+
+// parseInlineList parses "[a, b]" or "a,b" into a slice.
+func parseInlineList(val string) []string {
+	v := strings.TrimSpace(val)
+	v = strings.TrimPrefix(v, "[")
+	v = strings.TrimSuffix(v, "]")
+	var out []string
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.Trim(strings.TrimSpace(part), `"'`); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func parseBool(val string, fallback bool) bool {
+	b, err := strconv.ParseBool(strings.TrimSpace(val))
+	if err != nil {
+		return fallback
+	}
+	return b
+}
+
+func parseFloat(val string, fallback float64) float64 {
+	f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+	if err != nil {
+		return fallback
 	}
+	return f
 }
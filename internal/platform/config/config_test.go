@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestLoad_ConfigFileAndEnvOverride(t *testing.T) {
@@ -46,3 +47,228 @@ session_ttl_hours: 12
 		t.Fatalf("expected ttl from env to be 48h, got %dh", got)
 	}
 }
+
+func TestLoad_UnixSocketAddr(t *testing.T) {
+	t.Setenv("AIPANEL_ADDR", "unix:/run/aipanel.sock")
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	socketPath, ok := UnixSocketPath(cfg.Addr)
+	if !ok || socketPath != "/run/aipanel.sock" {
+		t.Fatalf("expected unix socket path /run/aipanel.sock, got %q (ok=%t)", socketPath, ok)
+	}
+
+	t.Setenv("AIPANEL_ADDR", "unix:relative.sock")
+	if _, err := Load(""); err == nil {
+		t.Fatal("expected relative unix socket path to be rejected")
+	}
+}
+
+func TestLoad_ListenAddrs(t *testing.T) {
+	dir := t.TempDir()
+	block := filepath.Join(dir, "block.yaml")
+	content := "listen_addrs:\n  - \"10.8.0.1:8080\"\n  - iface:wg0:8443\nenv: \"prod\"\n"
+	if err := os.WriteFile(block, []byte(content), 0o600); err != nil {
+		t.Fatalf("write config file: %v", err)
+	}
+	cfg, err := Load(block)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if len(cfg.ListenAddrs) != 2 || cfg.ListenAddrs[0] != "10.8.0.1:8080" || cfg.ListenAddrs[1] != "iface:wg0:8443" || cfg.Env != "prod" {
+		t.Fatalf("unexpected block config: %#v env=%q", cfg.ListenAddrs, cfg.Env)
+	}
+	if name, port, ok := InterfaceListenAddr(cfg.ListenAddrs[1]); !ok || name != "wg0" || port != "8443" {
+		t.Fatalf("unexpected interface address: %q %q %t", name, port, ok)
+	}
+
+	t.Setenv("AIPANEL_LISTEN_ADDRS", "[127.0.0.1:8080, unix:/run/aipanel-extra.sock]")
+	cfg, err = Load(block)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if len(cfg.ListenAddrs) != 2 || cfg.ListenAddrs[1] != "unix:/run/aipanel-extra.sock" {
+		t.Fatalf("expected env override, got %#v", cfg.ListenAddrs)
+	}
+
+	for _, bad := range []string{"10.8.0.1", "iface:wg0", "10.8.0.1:99999", "unix:relative.sock"} {
+		t.Setenv("AIPANEL_LISTEN_ADDRS", bad)
+		if _, err := Load(""); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestLoad_TrustedProxies(t *testing.T) {
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if len(cfg.TrustedProxies) != 2 || cfg.TrustedProxies[0] != "127.0.0.0/8" {
+		t.Fatalf("expected loopback proxies trusted by default, got %#v", cfg.TrustedProxies)
+	}
+	t.Setenv("AIPANEL_TRUSTED_PROXIES", "127.0.0.1, 173.245.48.0/20")
+	if cfg, err = Load(""); err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if len(cfg.TrustedProxies) != 2 || cfg.TrustedProxies[1] != "173.245.48.0/20" {
+		t.Fatalf("expected env override, got %#v", cfg.TrustedProxies)
+	}
+	t.Setenv("AIPANEL_TRUSTED_PROXIES", "nginx")
+	if _, err := Load(""); err == nil {
+		t.Fatal("expected invalid trusted proxy to be rejected")
+	}
+}
+
+func TestLoad_CloudflareOrigins(t *testing.T) {
+	t.Setenv("AIPANEL_CLOUDFLARE_API_TOKEN", "token")
+	t.Setenv("AIPANEL_CLOUDFLARE_ORIGIN_IPV4", "203.0.113.10")
+	t.Setenv("AIPANEL_CLOUDFLARE_ORIGIN_IPV6", "2001:db8::10")
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if cfg.CloudflareAPIToken != "token" || cfg.CloudflareOriginIPv4 != "203.0.113.10" || cfg.CloudflareOriginIPv6 != "2001:db8::10" {
+		t.Fatalf("unexpected cloudflare settings: %+v", cfg)
+	}
+	t.Setenv("AIPANEL_CLOUDFLARE_ORIGIN_IPV4", "2001:db8::10")
+	if _, err := Load(""); err == nil {
+		t.Fatal("expected IPv6 address to be rejected as IPv4 origin")
+	}
+}
+
+func TestLoad_LogLevels(t *testing.T) {
+	dir := t.TempDir()
+	inline := filepath.Join(dir, "inline.yaml")
+	if err := os.WriteFile(inline, []byte("log_levels: {hosting: debug, iam: warn}\n"), 0o600); err != nil {
+		t.Fatalf("write config file: %v", err)
+	}
+	cfg, err := Load(inline)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if cfg.LogLevels["hosting"] != "debug" || cfg.LogLevels["iam"] != "warn" {
+		t.Fatalf("unexpected inline log levels: %#v", cfg.LogLevels)
+	}
+
+	block := filepath.Join(dir, "block.yaml")
+	if err := os.WriteFile(block, []byte("log_levels:\n  hosting: debug\nenv: \"prod\"\n"), 0o600); err != nil {
+		t.Fatalf("write config file: %v", err)
+	}
+	cfg, err = Load(block)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if len(cfg.LogLevels) != 1 || cfg.LogLevels["hosting"] != "debug" || cfg.Env != "prod" {
+		t.Fatalf("unexpected block config: %#v env=%q", cfg.LogLevels, cfg.Env)
+	}
+
+	if err := os.WriteFile(inline, []byte("log_levels: {hosting: loud}\n"), 0o600); err != nil {
+		t.Fatalf("write config file: %v", err)
+	}
+	if _, err := Load(inline); err == nil {
+		t.Fatal("expected invalid module log level to be rejected")
+	}
+}
+
+func TestLoad_SMTPSettings(t *testing.T) {
+	t.Setenv("AIPANEL_SMTP_PASSWORD", "from-env")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "panel.yaml")
+	if err := os.WriteFile(path, []byte(`
+smtp_host: "smtp.example.com"
+smtp_port: 465
+smtp_username: "panel@example.com"
+smtp_password: "from-file"
+smtp_from: "aiPanel <panel@example.com>"
+smtp_tls_mode: "tls"
+`), 0o600); err != nil {
+		t.Fatalf("write config file: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if cfg.SMTPHost != "smtp.example.com" || cfg.SMTPPort != 465 || cfg.SMTPTLSMode != "tls" {
+		t.Fatalf("unexpected smtp settings: %+v", cfg)
+	}
+	if cfg.SMTPUsername != "panel@example.com" || cfg.SMTPFrom != "aiPanel <panel@example.com>" {
+		t.Fatalf("unexpected smtp identity: %q %q", cfg.SMTPUsername, cfg.SMTPFrom)
+	}
+	if cfg.SMTPPassword != "from-env" {
+		t.Fatalf("expected smtp password from env, got %q", cfg.SMTPPassword)
+	}
+
+	t.Setenv("AIPANEL_SMTP_TLS_MODE", "ssl")
+	if _, err := Load(path); err == nil {
+		t.Fatal("expected invalid smtp_tls_mode to be rejected")
+	}
+}
+
+func TestLoad_WebTerminalIsOptIn(t *testing.T) {
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if cfg.WebTerminalEnabled {
+		t.Fatal("expected web terminal disabled by default")
+	}
+	t.Setenv("AIPANEL_WEB_TERMINAL_ENABLED", "true")
+	if cfg, err = Load(""); err != nil || !cfg.WebTerminalEnabled {
+		t.Fatalf("expected env opt-in, got %t (%v)", cfg.WebTerminalEnabled, err)
+	}
+}
+
+func TestLoad_LoginLockoutCanBeDisabled(t *testing.T) {
+	t.Setenv("AIPANEL_LOGIN_MAX_FAILURES", "0")
+	cfg, err := Load("")
+	if err != nil || cfg.LoginMaxFailures != 0 {
+		t.Fatalf("expected a zero threshold accepted, got %d (%v)", cfg.LoginMaxFailures, err)
+	}
+	t.Setenv("AIPANEL_LOGIN_MAX_FAILURES_PER_IP", "-1")
+	if _, err := Load(""); err == nil {
+		t.Fatal("expected a negative threshold to be rejected")
+	}
+}
+
+func TestLoad_CatchAllMode(t *testing.T) {
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if cfg.CatchAllMode != CatchAllDrop {
+		t.Fatalf("expected catch-all to drop by default, got %q", cfg.CatchAllMode)
+	}
+	t.Setenv("AIPANEL_CATCHALL_MODE", "Landing")
+	if cfg, err = Load(""); err != nil || cfg.CatchAllMode != CatchAllLanding {
+		t.Fatalf("expected landing mode, got %q (%v)", cfg.CatchAllMode, err)
+	}
+	t.Setenv("AIPANEL_CATCHALL_MODE", "404")
+	if _, err := Load(""); err == nil {
+		t.Fatal("expected invalid catchall_mode to be rejected")
+	}
+}
+
+func TestLoad_MetricsExport(t *testing.T) {
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if cfg.MetricsExportFormat != "" || cfg.MetricsExportInterval != time.Minute {
+		t.Fatalf("expected the export off by default, got %q every %s", cfg.MetricsExportFormat, cfg.MetricsExportInterval)
+	}
+	t.Setenv("AIPANEL_METRICS_EXPORT_FORMAT", "Influx")
+	if _, err := Load(""); err == nil {
+		t.Fatal("expected a format without an url to be rejected")
+	}
+	t.Setenv("AIPANEL_METRICS_EXPORT_URL", "http://127.0.0.1:8086/api/v2/write?org=ops&bucket=panel&precision=s")
+	t.Setenv("AIPANEL_METRICS_EXPORT_INTERVAL_SECONDS", "15")
+	if cfg, err = Load(""); err != nil || cfg.MetricsExportFormat != "influx" || cfg.MetricsExportInterval != 15*time.Second {
+		t.Fatalf("expected influx every 15s, got %q every %s (%v)", cfg.MetricsExportFormat, cfg.MetricsExportInterval, err)
+	}
+	t.Setenv("AIPANEL_METRICS_EXPORT_FORMAT", "graphite")
+	if _, err := Load(""); err == nil {
+		t.Fatal("expected invalid metrics_export_format to be rejected")
+	}
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SetFileKeys sets top-level scalar keys in the config file at path,
+// keeping every other line, comment and key order. Keys missing from the
+// file are appended; a missing file is created with mode 0600.
+func SetFileKeys(path string, values map[string]string) error {
+	mode := os.FileMode(0o600)
+	//nolint:gosec // G304: path is the panel config chosen by the operator.
+	raw, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if info, statErr := os.Stat(path); statErr == nil {
+			mode = info.Mode().Perm()
+		}
+	case os.IsNotExist(err):
+	default:
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	pending := make(map[string]string, len(values))
+	for key, val := range values {
+		pending[key] = val
+	}
+	var lines []string
+	if text := strings.TrimRight(string(raw), "\n"); text != "" {
+		lines = strings.Split(text, "\n")
+	}
+	for n, line := range lines {
+		if line == "" || line[0] == ' ' || line[0] == '\t' || line[0] == '#' {
+			continue
+		}
+		key, _, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		if val, found := pending[key]; found {
+			lines[n] = fmt.Sprintf("%s: %q", key, val)
+			delete(pending, key)
+		}
+	}
+	rest := make([]string, 0, len(pending))
+	for key := range pending {
+		rest = append(rest, key)
+	}
+	sort.Strings(rest)
+	for _, key := range rest {
+		lines = append(lines, fmt.Sprintf("%s: %q", key, pending[key]))
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".panel-config-*")
+	if err != nil {
+		return fmt.Errorf("write config file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := tmp.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write config file: %w", err)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write config file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write config file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write config file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetFileKeys_KeepsOtherLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "panel.yaml")
+	err := os.WriteFile(path, []byte(`# panel config
+addr: "127.0.0.1:8080"
+panel_domain: "old.example.com"
+listen_addrs:
+  - "10.0.0.1:8080"
+smtp_host: "mail.example.com"
+`), 0o640)
+	if err != nil {
+		t.Fatalf("write config file: %v", err)
+	}
+
+	if err := SetFileKeys(path, map[string]string{
+		"panel_domain": "panel.example.com",
+		"acme_email":   "ops@example.com",
+	}); err != nil {
+		t.Fatalf("set keys: %v", err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read config file: %v", err)
+	}
+	want := `# panel config
+addr: "127.0.0.1:8080"
+panel_domain: "panel.example.com"
+listen_addrs:
+  - "10.0.0.1:8080"
+smtp_host: "mail.example.com"
+acme_email: "ops@example.com"
+`
+	if string(raw) != want {
+		t.Fatalf("unexpected config:\n%s", raw)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0o640 {
+		t.Fatalf("expected mode 0640 to be kept, got %v (%v)", info.Mode().Perm(), err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if cfg.PanelDomain != "panel.example.com" || len(cfg.ListenAddrs) != 1 || cfg.SMTPHost != "mail.example.com" {
+		t.Fatalf("unexpected config after update: %+v", cfg)
+	}
+}
@@ -0,0 +1,48 @@
+package etag
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMatches(t *testing.T) {
+	current := For("site", 7, time.Unix(1700000000, 0))
+	if current != `"site-7-1700000000"` {
+		t.Fatalf("unexpected tag %s", current)
+	}
+	tests := []struct {
+		ifMatch string
+		want    bool
+	}{
+		{"", true},
+		{"*", true},
+		{current, true},
+		{"W/" + current, true},
+		{`"site-7-1699999999", ` + current, true},
+		{`"site-7-1699999999"`, false},
+		{`"site-7-1699999999",`, false},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodPut, "/api/sites/7/limits", nil)
+		if tc.ifMatch != "" {
+			r.Header.Set("If-Match", tc.ifMatch)
+		}
+		if got := Matches(r, current); got != tc.want {
+			t.Fatalf("Matches(If-Match: %s) = %v, want %v", tc.ifMatch, got, tc.want)
+		}
+	}
+}
+
+func TestCheck_RejectsStaleVersion(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPut, "/api/proxies/1", nil)
+	r.Header.Set("If-Match", `"proxy-1-1"`)
+	rec := httptest.NewRecorder()
+	if Check(rec, r, `"proxy-1-2"`) {
+		t.Fatal("expected stale version to be rejected")
+	}
+	if rec.Code != http.StatusPreconditionFailed || rec.Header().Get("ETag") != `"proxy-1-2"` {
+		t.Fatalf("unexpected response %d, ETag %q", rec.Code, rec.Header().Get("ETag"))
+	}
+}
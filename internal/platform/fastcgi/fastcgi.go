@@ -0,0 +1,161 @@
+// Package fastcgi is a minimal FastCGI client for talking to PHP-FPM pools
+// directly over their socket (ping and status pages), bypassing nginx.
+package fastcgi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+const (
+	version1 = 1
+
+	typeBeginRequest = 1
+	typeEndRequest   = 3
+	typeParams       = 4
+	typeStdin        = 5
+	typeStdout       = 6
+	typeStderr       = 7
+
+	roleResponder = 1
+	requestID     = 1
+
+	// maxResponseBytes bounds stdout and stderr; status pages are small.
+	maxResponseBytes = 1 << 20
+)
+
+// ErrResponseTooLarge is returned when the server sends more than 1 MiB.
+var ErrResponseTooLarge = errors.New("fastcgi response too large")
+
+// Response is the parsed CGI answer of a FastCGI responder.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// Stderr holds what the application logged for this request.
+	Stderr string
+}
+
+// Do sends one request without a body to the FastCGI server at
+// network/address (e.g. "unix", "/run/php/site.sock") and reads the
+// whole response. params are the CGI variables, such as SCRIPT_FILENAME.
+func Do(ctx context.Context, network, address string, params map[string]string) (Response, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return Response{}, fmt.Errorf("dial %s: %w", address, err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	var req bytes.Buffer
+	writeRecord(&req, typeBeginRequest, []byte{0, roleResponder, 0, 0, 0, 0, 0, 0})
+	writeRecord(&req, typeParams, encodeParams(params))
+	writeRecord(&req, typeParams, nil)
+	writeRecord(&req, typeStdin, nil)
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return Response{}, fmt.Errorf("write request: %w", err)
+	}
+
+	stdout, stderr, err := readResponse(bufio.NewReader(conn))
+	if err != nil {
+		return Response{}, err
+	}
+	resp, err := parseCGI(stdout)
+	if err != nil {
+		return Response{}, err
+	}
+	resp.Stderr = string(stderr)
+	return resp, nil
+}
+
+func writeRecord(w *bytes.Buffer, recType byte, content []byte) {
+	padding := (8 - len(content)%8) % 8
+	header := [8]byte{version1, recType}
+	binary.BigEndian.PutUint16(header[2:4], requestID)
+	binary.BigEndian.PutUint16(header[4:6], uint16(len(content))) //nolint:gosec // params are far below 64 KiB.
+	header[6] = byte(padding)
+	w.Write(header[:])
+	w.Write(content)
+	w.Write(make([]byte, padding))
+}
+
+func encodeParams(params map[string]string) []byte {
+	var b bytes.Buffer
+	for name, value := range params {
+		writeLength(&b, len(name))
+		writeLength(&b, len(value))
+		b.WriteString(name)
+		b.WriteString(value)
+	}
+	return b.Bytes()
+}
+
+func writeLength(b *bytes.Buffer, n int) {
+	if n < 128 {
+		b.WriteByte(byte(n))
+		return
+	}
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], uint32(n)|1<<31) //nolint:gosec // lengths are bounded by the record size.
+	b.Write(buf[:])
+}
+
+func readResponse(r *bufio.Reader) (stdout, stderr []byte, err error) {
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, nil, fmt.Errorf("read record header: %w", err)
+		}
+		length := int(binary.BigEndian.Uint16(header[4:6]))
+		content := make([]byte, length+int(header[6]))
+		if _, err := io.ReadFull(r, content); err != nil {
+			return nil, nil, fmt.Errorf("read record: %w", err)
+		}
+		content = content[:length]
+		switch header[1] {
+		case typeStdout:
+			stdout = append(stdout, content...)
+		case typeStderr:
+			stderr = append(stderr, content...)
+		case typeEndRequest:
+			return stdout, stderr, nil
+		}
+		if len(stdout)+len(stderr) > maxResponseBytes {
+			return nil, nil, ErrResponseTooLarge
+		}
+	}
+}
+
+// parseCGI splits a CGI response into status, headers and body. A missing
+// Status header means 200.
+func parseCGI(raw []byte) (Response, error) {
+	tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(raw)))
+	mime, err := tp.ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Response{}, fmt.Errorf("parse response headers: %w", err)
+	}
+	body, _ := io.ReadAll(tp.R)
+	resp := Response{Status: http.StatusOK, Header: http.Header(mime), Body: body}
+	if status := resp.Header.Get("Status"); status != "" {
+		code, _, _ := strings.Cut(status, " ")
+		if resp.Status, err = strconv.Atoi(code); err != nil {
+			return Response{}, fmt.Errorf("parse response status %q", status)
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,67 @@
+package fastcgi
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serve(t *testing.T, h http.Handler) string {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "fpm.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	go func() {
+		_ = fcgi.Serve(ln, h)
+	}()
+	return sock
+}
+
+func TestDo(t *testing.T) {
+	sock := serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		env := fcgi.ProcessEnv(r)
+		if r.URL.Path != "/aipanel-ping" || env["SCRIPT_FILENAME"] != "/aipanel-ping" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Pool", strings.Repeat("p", 200))
+		_, _ = w.Write([]byte("pong"))
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := Do(ctx, "unix", sock, map[string]string{
+		"REQUEST_METHOD":  "GET",
+		"REQUEST_URI":     "/aipanel-ping",
+		"SCRIPT_NAME":     "/aipanel-ping",
+		"SCRIPT_FILENAME": "/aipanel-ping",
+		"SERVER_PROTOCOL": "HTTP/1.1",
+	})
+	if err != nil {
+		t.Fatalf("do: %v", err)
+	}
+	if resp.Status != http.StatusOK || string(resp.Body) != "pong" || len(resp.Header.Get("X-Pool")) != 200 {
+		t.Fatalf("unexpected response: %d %q %v", resp.Status, resp.Body, resp.Header)
+	}
+
+	resp, err = Do(ctx, "unix", sock, map[string]string{"REQUEST_METHOD": "GET", "REQUEST_URI": "/missing", "SERVER_PROTOCOL": "HTTP/1.1"})
+	if err != nil || resp.Status != http.StatusNotFound {
+		t.Fatalf("expected 404, got %+v (%v)", resp, err)
+	}
+}
+
+func TestDo_DialError(t *testing.T) {
+	if _, err := Do(context.Background(), "unix", filepath.Join(t.TempDir(), "missing.sock"), nil); err == nil {
+		t.Fatal("expected dial error")
+	}
+}
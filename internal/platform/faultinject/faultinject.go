@@ -0,0 +1,190 @@
+// Package faultinject fails selected runner commands or file writes on demand
+// so rollback paths can be exercised in tests and staging environments.
+//
+// Faults are inactive unless rules are installed with Install or loaded from
+// the AIPANEL_FAULTS environment variable, e.g.:
+//
+//	AIPANEL_FAULTS="cmd=systemctl reload@2;write=/etc/nginx/sites-available/"
+//
+// "cmd" rules match a substring of the joined command line, "write" rules a
+// substring of the written path. An optional "@N" fails only the Nth match.
+package faultinject
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/robsonek/aiPanel/internal/platform/systemd"
+)
+
+// EnvVar holds fault rules for LoadFromEnv.
+const EnvVar = "AIPANEL_FAULTS"
+
+// Rule kinds.
+const (
+	KindCommand = "cmd"
+	KindWrite   = "write"
+)
+
+// ErrInjected is returned (wrapped) by every injected failure.
+var ErrInjected = errors.New("injected fault")
+
+// Rule fails operations whose command line or path contains Match.
+type Rule struct {
+	Kind  string
+	Match string
+	// Nth fails only the Nth matching operation (1-based); 0 fails all matches.
+	Nth int
+}
+
+type activeRule struct {
+	Rule
+	seen int
+}
+
+var (
+	mu    sync.Mutex
+	rules []*activeRule
+)
+
+// Install replaces active rules and returns a func restoring the previous set.
+func Install(newRules ...Rule) (restore func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	prev := rules
+	rules = make([]*activeRule, 0, len(newRules))
+	for _, r := range newRules {
+		rules = append(rules, &activeRule{Rule: r})
+	}
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		rules = prev
+	}
+}
+
+// Enabled reports whether any rule is active.
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return len(rules) > 0
+}
+
+// LoadFromEnv installs rules from AIPANEL_FAULTS when set.
+func LoadFromEnv() error {
+	raw := strings.TrimSpace(os.Getenv(EnvVar))
+	if raw == "" {
+		return nil
+	}
+	parsed, err := ParseRules(raw)
+	if err != nil {
+		return err
+	}
+	Install(parsed...)
+	return nil
+}
+
+// ParseRules parses "kind=match[@N];..." into rules.
+func ParseRules(raw string) ([]Rule, error) {
+	var out []Rule
+	for _, part := range strings.Split(raw, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kind, match, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid fault rule %q: expected kind=match", part)
+		}
+		kind = strings.TrimSpace(kind)
+		if kind != KindCommand && kind != KindWrite {
+			return nil, fmt.Errorf("invalid fault rule %q: unknown kind %q", part, kind)
+		}
+		rule := Rule{Kind: kind, Match: match}
+		if idx := strings.LastIndex(match, "@"); idx >= 0 {
+			if n, err := strconv.Atoi(match[idx+1:]); err == nil && n > 0 {
+				rule.Match = match[:idx]
+				rule.Nth = n
+			}
+		}
+		if strings.TrimSpace(rule.Match) == "" {
+			return nil, fmt.Errorf("invalid fault rule %q: empty match", part)
+		}
+		out = append(out, rule)
+	}
+	return out, nil
+}
+
+// Command returns an injected error if a rule matches the command line.
+func Command(name string, args ...string) error {
+	line := strings.TrimSpace(name + " " + strings.Join(args, " "))
+	if hit(KindCommand, line) {
+		return fmt.Errorf("%w: command %q", ErrInjected, line)
+	}
+	return nil
+}
+
+// Write returns an injected error if a rule matches the file path.
+func Write(path string) error {
+	if hit(KindWrite, path) {
+		return fmt.Errorf("%w: write %s", ErrInjected, path)
+	}
+	return nil
+}
+
+func hit(kind, target string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, r := range rules {
+		if r.Kind != kind || !strings.Contains(target, r.Match) {
+			continue
+		}
+		r.seen++
+		if r.Nth == 0 || r.seen == r.Nth {
+			return true
+		}
+	}
+	return false
+}
+
+// WrapRunner returns a runner that consults command rules before delegating.
+// Live streaming is preserved when the wrapped runner supports it.
+func WrapRunner(inner systemd.Runner) systemd.Runner {
+	if live, ok := inner.(systemd.LiveRunner); ok {
+		return liveRunner{runner: runner{inner: inner}, live: live}
+	}
+	return runner{inner: inner}
+}
+
+type runner struct {
+	inner systemd.Runner
+}
+
+func (r runner) Run(ctx context.Context, name string, args ...string) (string, error) {
+	if err := Command(name, args...); err != nil {
+		return "", err
+	}
+	return r.inner.Run(ctx, name, args...)
+}
+
+type liveRunner struct {
+	runner
+	live systemd.LiveRunner
+}
+
+func (r liveRunner) RunLive(
+	ctx context.Context,
+	name string,
+	args []string,
+	onLine func(line string, isStderr bool),
+) (string, error) {
+	if err := Command(name, args...); err != nil {
+		return "", err
+	}
+	return r.live.RunLive(ctx, name, args, onLine)
+}
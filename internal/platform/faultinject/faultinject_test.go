@@ -0,0 +1,70 @@
+package faultinject
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type recordingRunner struct {
+	commands []string
+}
+
+func (r *recordingRunner) Run(_ context.Context, name string, _ ...string) (string, error) {
+	r.commands = append(r.commands, name)
+	return "", nil
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules("cmd=systemctl reload@2; write=/etc/nginx/")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("expected 2 rules, got %d", len(rules))
+	}
+	if rules[0] != (Rule{Kind: KindCommand, Match: "systemctl reload", Nth: 2}) {
+		t.Fatalf("unexpected command rule: %+v", rules[0])
+	}
+	if rules[1] != (Rule{Kind: KindWrite, Match: "/etc/nginx/"}) {
+		t.Fatalf("unexpected write rule: %+v", rules[1])
+	}
+	if _, err := ParseRules("disk=/tmp"); err == nil {
+		t.Fatal("expected unknown kind to be rejected")
+	}
+}
+
+func TestWrapRunner_FailsNthMatchingCommand(t *testing.T) {
+	restore := Install(Rule{Kind: KindCommand, Match: "systemctl reload", Nth: 2})
+	defer restore()
+
+	inner := &recordingRunner{}
+	r := WrapRunner(inner)
+	ctx := context.Background()
+	if _, err := r.Run(ctx, "systemctl", "reload", "nginx"); err != nil {
+		t.Fatalf("first reload should pass: %v", err)
+	}
+	if _, err := r.Run(ctx, "systemctl", "reload", "nginx"); !errors.Is(err, ErrInjected) {
+		t.Fatalf("second reload should be injected, got %v", err)
+	}
+	if _, err := r.Run(ctx, "systemctl", "reload", "nginx"); err != nil {
+		t.Fatalf("third reload should pass: %v", err)
+	}
+	if len(inner.commands) != 2 {
+		t.Fatalf("expected injected call to skip inner runner, got %v", inner.commands)
+	}
+}
+
+func TestWrite_RestoreDisablesRules(t *testing.T) {
+	restore := Install(Rule{Kind: KindWrite, Match: "/etc/nginx/"})
+	if err := Write("/etc/nginx/sites-available/a.conf"); !errors.Is(err, ErrInjected) {
+		t.Fatalf("expected injected write failure, got %v", err)
+	}
+	restore()
+	if Enabled() {
+		t.Fatal("expected no active rules after restore")
+	}
+	if err := Write("/etc/nginx/sites-available/a.conf"); err != nil {
+		t.Fatalf("expected write to pass after restore: %v", err)
+	}
+}
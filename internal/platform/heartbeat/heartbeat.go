@@ -0,0 +1,142 @@
+// Package heartbeat reports the outcome of scheduled work to external
+// dead-man-switch monitors such as healthchecks.io checks and Uptime Kuma
+// push monitors. The monitor alerts when pings stop arriving, which also
+// covers the whole server being down.
+package heartbeat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// timeout bounds one ping; a slow monitor must not hold up a job.
+	timeout = 10 * time.Second
+	// maxURLLength bounds stored heartbeat URLs.
+	maxURLLength = 2048
+	// maxMessage bounds the failure text sent with a ping.
+	maxMessage = 1000
+)
+
+// client is built on first use, after cabundle.Apply has extended the
+// roots of http.DefaultTransport, so monitors behind a TLS-inspecting
+// proxy verify.
+var client = sync.OnceValue(newClient)
+
+// newClient clones http.DefaultTransport, keeping its roots and proxy, and
+// refuses to connect to loopback, link-local and unspecified addresses: a
+// monitor on this host cannot notice the host going down, and cron job
+// owners must not be able to make the panel call local services.
+func newClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout: timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip != nil && localIP(ip) && !allowLocal {
+				return fmt.Errorf("heartbeat: refusing to connect to local address %s", host)
+			}
+			return nil
+		},
+	}).DialContext
+	transport.TLSHandshakeTimeout = timeout
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// allowLocal lets tests ping an httptest server on loopback.
+var allowLocal bool
+
+// Validate checks a heartbeat URL. An empty URL disables pings.
+func Validate(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	if len(raw) > maxURLLength {
+		return fmt.Errorf("invalid heartbeat URL: longer than %d bytes", maxURLLength)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid heartbeat URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid heartbeat URL: scheme must be http or https")
+	}
+	if u.Hostname() == "" || u.User != nil {
+		return fmt.Errorf("invalid heartbeat URL: expected http(s)://host/path without credentials")
+	}
+	if ip := net.ParseIP(u.Hostname()); (ip != nil && localIP(ip) && !allowLocal) || strings.EqualFold(u.Hostname(), "localhost") {
+		return fmt.Errorf("invalid heartbeat URL: must point at a monitor outside this host")
+	}
+	return nil
+}
+
+// Ping reports one run to the monitor at raw; runErr nil is a success.
+// Uptime Kuma push URLs (those with a status query parameter) get
+// status=up|down and msg set. Any other URL is treated as a
+// healthchecks.io check: success pings the URL, failure pings URL/fail
+// with the error as the request body. An empty raw does nothing.
+func Ping(ctx context.Context, raw string, runErr error) error {
+	if raw == "" {
+		return nil
+	}
+	if err := Validate(raw); err != nil {
+		return err
+	}
+	u, _ := url.Parse(raw)
+	message := "OK"
+	if runErr != nil {
+		message = runErr.Error()
+		if len(message) > maxMessage {
+			message = message[:maxMessage]
+		}
+	}
+
+	method := http.MethodGet
+	var body io.Reader
+	if q := u.Query(); q.Has("status") {
+		q.Set("status", "up")
+		if runErr != nil {
+			q.Set("status", "down")
+		}
+		q.Set("msg", message)
+		u.RawQuery = q.Encode()
+	} else if runErr != nil {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/fail"
+		u.RawPath = ""
+		method = http.MethodPost
+		body = strings.NewReader(message)
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return fmt.Errorf("heartbeat: %w", err)
+	}
+	req.Header.Set("User-Agent", "aiPanel-heartbeat")
+	resp, err := client().Do(req)
+	if err != nil {
+		return fmt.Errorf("heartbeat %s: %w", u.Host, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("heartbeat %s: unexpected status %s", u.Host, resp.Status)
+	}
+	return nil
+}
+
+func localIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast()
+}
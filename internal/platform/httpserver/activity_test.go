@@ -0,0 +1,51 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestSiteActivity_FollowsSiteAccess(t *testing.T) {
+	srv := newAccessTestServer(t)
+	admin := srv.login("admin@example.com")
+	if err := srv.store.ExecAudit(context.Background(), `
+INSERT INTO audit_events(actor, action, details, site_id, database_id, created_at) VALUES
+  ('admin@example.com','hosting.site.create','domain=client.example',1,0,1),
+  ('system','database.backup','db=shop,engine=mariadb',1,7,2),
+  ('admin@example.com','hosting.site.create','domain=other.example',2,0,3);`); err != nil {
+		t.Fatalf("seed audit events: %v", err)
+	}
+
+	rec := srv.do(admin, http.MethodGet, "/api/sites/1/activity", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("site activity: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Events []struct {
+			Action     string `json:"action"`
+			DatabaseID int64  `json:"database_id"`
+		} `json:"events"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode activity: %v", err)
+	}
+	if len(body.Events) != 2 || body.Events[0].Action != "database.backup" || body.Events[0].DatabaseID != 7 {
+		t.Fatalf("expected the site and database events, got %+v", body.Events)
+	}
+
+	if rec := srv.do(admin, http.MethodPost, "/api/users", `{"email":"viewer@agency.example","password":"supersecret123"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("create user: expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	viewer := srv.login("viewer@agency.example")
+	if rec := srv.do(viewer, http.MethodGet, "/api/sites/1/activity", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("activity without access: expected 404, got %d", rec.Code)
+	}
+	if rec := srv.do(admin, http.MethodPut, "/api/users/2/grants/1", `{"actions":["read"]}`); rec.Code != http.StatusOK {
+		t.Fatalf("grant read: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := srv.do(viewer, http.MethodGet, "/api/sites/1/activity", ""); rec.Code != http.StatusOK {
+		t.Fatalf("activity with read grant: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+}
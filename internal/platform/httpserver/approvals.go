@@ -0,0 +1,310 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/robsonek/aiPanel/internal/modules/backup"
+	"github.com/robsonek/aiPanel/internal/modules/database"
+	"github.com/robsonek/aiPanel/internal/modules/hosting"
+	"github.com/robsonek/aiPanel/internal/modules/iam"
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/internal/platform/jsonstream"
+)
+
+// Operations held for a second admin when two-person approval is on.
+const (
+	opSiteDelete        = "site.delete"
+	opDatabaseDelete    = "database.delete"
+	opDatabaseRestore   = "database.restore"
+	opSiteBackupRestore = "site.backup.restore"
+	opBackupRunRestore  = "backup.run.restore"
+)
+
+// approvalJob runs an approved operation in the job queue.
+const approvalJob = "approval.run"
+
+// heldOperation is the payload of an approval: the ids the route was
+// called with and its request body, without client-held backup keys.
+type heldOperation struct {
+	ID   int64           `json:"id"`
+	Ref  string          `json:"ref,omitempty"`
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+// approvedRunner runs an approved operation on behalf of its requester.
+// identity is a client-held backup key sent with the approval, since the
+// one sent with the original request was not kept.
+type approvedRunner func(ctx context.Context, op heldOperation, requester, identity string) (any, error)
+
+// approvalJobPayload names the approval an approval.run job carries out.
+type approvalJobPayload struct {
+	ApprovalID int64 `json:"approval_id"`
+}
+
+// heldIdentities keeps the client-held backup keys sent with approvals
+// until their job picks them up. They live in memory only, so they never
+// reach queue.db; a job that runs after a panel restart goes without one.
+type heldIdentities struct {
+	mu   sync.Mutex
+	byID map[int64]string
+}
+
+func (h *heldIdentities) put(id int64, identity string) {
+	if identity == "" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.byID[id] = identity
+}
+
+func (h *heldIdentities) take(id int64) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	identity := h.byID[id]
+	delete(h.byID, id)
+	return identity
+}
+
+// holdForApproval answers 202 with a pending approval instead of running
+// the request when two-person approval is on. It returns false, leaving
+// the body unread, when the request should run now.
+func holdForApproval(w http.ResponseWriter, r *http.Request, iamSvc *iam.Service, operation, target string, id int64, ref string) bool {
+	if !iamSvc.ApprovalRequired(r.Context()) {
+		return false
+	}
+	op := heldOperation{ID: id, Ref: ref}
+	raw, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return true
+	}
+	if op.Body, err = withoutIdentity(raw); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return true
+	}
+	payload, _ := json.Marshal(op)
+	u, _ := userFromContext(r.Context())
+	approval, err := iamSvc.RequestApproval(r.Context(), operation, target, payload, u.Email)
+	switch {
+	case errors.Is(err, iam.ErrNoApprover):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, "failed to request approval", http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusAccepted, map[string]any{"approval": approval})
+	}
+	return true
+}
+
+func withoutIdentity(raw []byte) (json.RawMessage, error) {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	delete(fields, "identity")
+	return json.Marshal(fields)
+}
+
+// approvalRunners maps each held operation to the service call it stands
+// for. Operations of services the panel runs without are left out.
+func approvalRunners(hostingSvc *hosting.Service, databaseSvc *database.Service, backups *backup.Service) map[string]approvedRunner {
+	runners := map[string]approvedRunner{}
+	if hostingSvc != nil {
+		runners[opSiteDelete] = func(ctx context.Context, op heldOperation, requester, _ string) (any, error) {
+			return nil, hostingSvc.DeleteSite(ctx, op.ID, requester)
+		}
+	}
+	if databaseSvc != nil {
+		runners[opDatabaseDelete] = func(ctx context.Context, op heldOperation, requester, _ string) (any, error) {
+			return nil, databaseSvc.DeleteDatabase(ctx, op.ID, requester)
+		}
+		runners[opDatabaseRestore] = func(ctx context.Context, op heldOperation, requester, _ string) (any, error) {
+			var req database.RestoreRequest
+			if len(op.Body) > 0 {
+				if err := json.Unmarshal(op.Body, &req); err != nil {
+					return nil, fmt.Errorf("decode held request: %w", err)
+				}
+			}
+			req.Actor = requester
+			return databaseSvc.RestoreToTime(ctx, op.ID, req)
+		}
+	}
+	if backups != nil {
+		paths := func(op heldOperation) ([]string, error) {
+			var req struct {
+				Paths []string `json:"paths"`
+			}
+			if len(op.Body) > 0 {
+				if err := json.Unmarshal(op.Body, &req); err != nil {
+					return nil, fmt.Errorf("decode held request: %w", err)
+				}
+			}
+			return req.Paths, nil
+		}
+		runners[opSiteBackupRestore] = func(ctx context.Context, op heldOperation, requester, identity string) (any, error) {
+			p, err := paths(op)
+			if err != nil {
+				return nil, err
+			}
+			return backups.RestoreFiles(ctx, op.ID, op.Ref, p, identity, requester)
+		}
+		runners[opBackupRunRestore] = func(ctx context.Context, op heldOperation, requester, identity string) (any, error) {
+			p, err := paths(op)
+			if err != nil {
+				return nil, err
+			}
+			return backups.RestoreRun(ctx, op.ID, p, identity, requester)
+		}
+	}
+	return runners
+}
+
+// runApprovalJob returns the approval.run handler. It runs the approved
+// operation as its requester and records the outcome on the approval.
+func runApprovalJob(log *slog.Logger, iamSvc *iam.Service, runners map[string]approvedRunner, identities *heldIdentities) jobqueue.Handler {
+	return func(ctx context.Context, job jobqueue.Job) error {
+		var payload approvalJobPayload
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return fmt.Errorf("decode approval job: %w", err)
+		}
+		identity := identities.take(payload.ApprovalID)
+		approval, err := iamSvc.GetApproval(ctx, payload.ApprovalID)
+		if err != nil {
+			return err
+		}
+		if approval.Status != iam.ApprovalApproved {
+			return fmt.Errorf("approval %d is %s", approval.ID, approval.Status)
+		}
+		var op heldOperation
+		run, ok := runners[approval.Operation]
+		if !ok {
+			err = fmt.Errorf("operation %s is not available", approval.Operation)
+		} else if err = json.Unmarshal(approval.Payload, &op); err != nil {
+			err = fmt.Errorf("decode held operation: %w", err)
+		}
+		if err == nil {
+			_, err = run(ctx, op, approval.RequestedBy, identity)
+		}
+		if err != nil {
+			log.Warn("approved operation failed", "approval_id", approval.ID, "job_id", job.ID, "operation", approval.Operation, "error", err.Error())
+			iamSvc.FailApproval(context.WithoutCancel(ctx), approval.ID, err)
+			return err
+		}
+		iamSvc.CompleteApproval(ctx, approval.ID)
+		log.Info("approved operation done", "approval_id", approval.ID, "job_id", job.ID, "operation", approval.Operation, "requested_by", approval.RequestedBy)
+		return nil
+	}
+}
+
+func registerApprovalRoutes(mux *http.ServeMux, cfg config.Config, log *slog.Logger, iamSvc *iam.Service, runners map[string]approvedRunner, jobs *jobqueue.Queue) {
+	identities := &heldIdentities{byID: map[int64]string{}}
+	if jobs != nil {
+		jobs.Register(approvalJob, runApprovalJob(log, iamSvc, runners, identities))
+	}
+
+	// GET /api/approvals?status=pending lists held destructive operations.
+	mux.Handle("/api/approvals", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		approvals, err := iamSvc.ListApprovals(r.Context(), r.URL.Query().Get("status"))
+		if err != nil {
+			http.Error(w, "failed to list approvals", http.StatusInternalServerError)
+			return
+		}
+		jsonstream.List(w, r, "approvals", approvals)
+	})))
+
+	// POST /api/approvals/{id}/approve queues the held operation to run as
+	// its requester and answers 202 with the job id, {"identity": ""}
+	// carrying a client-held backup key when a restore needs one;
+	// POST /api/approvals/{id}/reject drops it.
+	mux.Handle("/api/approvals/", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/approvals/"), "/"), "/")
+		if len(parts) != 2 || (parts[1] != "approve" && parts[1] != "reject") {
+			http.NotFound(w, r)
+			return
+		}
+		id, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid approval id", http.StatusBadRequest)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		u, _ := userFromContext(r.Context())
+		if parts[1] == "reject" {
+			approval, err := iamSvc.RejectOperation(r.Context(), id, u.Email)
+			if err != nil {
+				writeApprovalError(w, err)
+				return
+			}
+			log.Info("operation rejected", "actor", u.Email, "approval_id", id, "operation", approval.Operation)
+			writeJSON(w, http.StatusOK, map[string]any{"approval": approval})
+			return
+		}
+
+		if jobs == nil {
+			http.Error(w, "job queue is not available", http.StatusServiceUnavailable)
+			return
+		}
+		var req struct {
+			Identity string `json:"identity"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		approval, err := iamSvc.ApproveOperation(r.Context(), id, u.Email)
+		if err != nil {
+			writeApprovalError(w, err)
+			return
+		}
+		identities.put(id, req.Identity)
+		jobID, err := jobs.Enqueue(r.Context(), approvalJob, approvalJobPayload{ApprovalID: id})
+		if err != nil {
+			identities.take(id)
+			iamSvc.FailApproval(r.Context(), id, fmt.Errorf("queue approved operation: %w", err))
+			http.Error(w, "failed to queue approved operation", http.StatusInternalServerError)
+			return
+		}
+		if err := iamSvc.SetApprovalJob(r.Context(), id, jobID); err != nil {
+			log.Warn("record approval job failed", "approval_id", id, "job_id", jobID, "error", err.Error())
+		} else {
+			approval.JobID = jobID
+		}
+		log.Info("operation approved", "actor", u.Email, "approval_id", id, "operation", approval.Operation, "requested_by", approval.RequestedBy, "job_id", jobID)
+		writeJSON(w, http.StatusAccepted, map[string]any{"approval": approval, "job_id": jobID})
+	})))
+}
+
+func writeApprovalError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, iam.ErrApprovalNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, iam.ErrSelfApproval):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, iam.ErrApprovalNotPending), errors.Is(err, iam.ErrApprovalExpired):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "failed to decide approval", http.StatusInternalServerError)
+	}
+}
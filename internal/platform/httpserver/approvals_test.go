@@ -0,0 +1,84 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/modules/iam"
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/internal/platform/settings"
+)
+
+func TestApprovals_HoldSiteDeletion(t *testing.T) {
+	ctx := context.Background()
+	srv := newAccessTestServer(t)
+	policy := settings.New(srv.store, nil, settings.Builtin(config.Config{})...)
+	srv.iam.SetSettings(policy)
+	if _, err := srv.iam.CreateUser(ctx, "second@example.com", "supersecret123", iam.RoleAdmin); err != nil {
+		t.Fatalf("create second admin: %v", err)
+	}
+	if _, err := policy.Update(ctx, settings.Security, map[string]json.RawMessage{"two_person_approval": json.RawMessage(`true`)}, "admin@example.com"); err != nil {
+		t.Fatalf("enable approval: %v", err)
+	}
+	admin, second := srv.login("admin@example.com"), srv.login("second@example.com")
+
+	held := func() iam.Approval {
+		t.Helper()
+		rec := srv.do(admin, http.MethodDelete, "/api/sites/1", "")
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("expected 202, got %d: %s", rec.Code, rec.Body)
+		}
+		var resp struct {
+			Approval iam.Approval `json:"approval"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode approval: %v", err)
+		}
+		if resp.Approval.Operation != opSiteDelete || resp.Approval.Status != iam.ApprovalPending {
+			t.Fatalf("unexpected approval: %+v", resp.Approval)
+		}
+		return resp.Approval
+	}
+
+	first := held()
+	if rec := srv.do(admin, http.MethodPost, "/api/approvals/"+strconv.FormatInt(first.ID, 10)+"/approve", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected self approval refused, got %d", rec.Code)
+	}
+	if rec := srv.do(second, http.MethodPost, "/api/approvals/"+strconv.FormatInt(first.ID, 10)+"/reject", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected reject, got %d: %s", rec.Code, rec.Body)
+	}
+
+	// The approved deletion is queued; the test hosting service has no
+	// nginx, so the job fails and the approval records why.
+	next := held()
+	rec := srv.do(second, http.MethodPost, "/api/approvals/"+strconv.FormatInt(next.ID, 10)+"/approve", "")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected queued operation, got %d: %s", rec.Code, rec.Body)
+	}
+	var queued struct {
+		Approval iam.Approval `json:"approval"`
+		JobID    int64        `json:"job_id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &queued); err != nil {
+		t.Fatalf("decode approve response: %v", err)
+	}
+	if queued.JobID == 0 || queued.Approval.JobID != queued.JobID || queued.Approval.Status != iam.ApprovalApproved {
+		t.Fatalf("unexpected approve response: %s", rec.Body)
+	}
+	srv.jobs.RunPending(ctx)
+	job, err := srv.jobs.Get(ctx, queued.JobID)
+	if err != nil || job.Status != jobqueue.StatusFailed {
+		t.Fatalf("expected failed job, got %+v (%v)", job, err)
+	}
+	got, err := srv.iam.GetApproval(ctx, next.ID)
+	if err != nil || got.Status != iam.ApprovalFailed || got.DecidedBy != "second@example.com" || got.Error == "" || got.JobID != queued.JobID {
+		t.Fatalf("unexpected approval: %+v (%v)", got, err)
+	}
+	if rec := srv.do(second, http.MethodGet, "/api/approvals?status=rejected", ""); rec.Code != http.StatusOK || !json.Valid(rec.Body.Bytes()) {
+		t.Fatalf("list approvals: %d", rec.Code)
+	}
+}
@@ -0,0 +1,121 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/modules/database"
+	"github.com/robsonek/aiPanel/internal/modules/hosting"
+	"github.com/robsonek/aiPanel/internal/modules/iam"
+	"github.com/robsonek/aiPanel/internal/platform/jsonstream"
+)
+
+// adminOnlySiteSubresources change how a site is hosted rather than what
+// it serves; only admins reach them.
+var adminOnlySiteSubresources = map[string]bool{
+	"limits":    true,
+	"isolation": true,
+	"registrar": true,
+}
+
+// siteAction maps a request on /api/sites/{id}[/{sub}] to the action it
+// needs.
+func siteAction(method, sub string) string {
+	switch {
+	case adminOnlySiteSubresources[sub], sub == "" && method == http.MethodDelete:
+		return iam.ActionAdmin
+	case sub == "terminal":
+		return iam.ActionTerminal
+	case sub == "cloudflare/purge" || sub == "page-cache/purge":
+		return iam.ActionCachePurge
+	case method == http.MethodGet || method == http.MethodHead:
+		return iam.ActionRead
+	case sub == "databases":
+		return iam.ActionDatabases
+	case sub == "cron" || strings.HasPrefix(sub, "cron/"),
+		sub == "workers" || strings.HasPrefix(sub, "workers/"):
+		return iam.ActionCron
+	case sub == "wordpress" || strings.HasPrefix(sub, "wordpress/"):
+		return iam.ActionWordPress
+	default:
+		return iam.ActionWrite
+	}
+}
+
+// authorizeSite checks that the caller may perform action on a site, from
+// its role, organization memberships, grants and API token scope. Sites
+// the caller cannot see at all answer 404 so their existence does not
+// leak. It writes the error response and returns false when the request
+// is denied.
+func authorizeSite(w http.ResponseWriter, r *http.Request, iamSvc *iam.Service, siteID int64, action string) bool {
+	u, _ := userFromContext(r.Context())
+	actions, err := iamSvc.SiteActions(r.Context(), u, siteID)
+	if err != nil {
+		http.Error(w, "failed to check site access", http.StatusInternalServerError)
+		return false
+	}
+	if len(actions) == 0 {
+		http.Error(w, "site not found", http.StatusNotFound)
+		return false
+	}
+	if !actions[action] {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// databaseSecretSubresources hand out credentials or data dumps, so
+// reading them needs the databases action.
+var databaseSecretSubresources = map[string]bool{
+	"connection": true,
+	"download":   true,
+}
+
+// authorizeDatabase applies authorizeSite to the site owning the database
+// in an /api/databases/{id} path.
+func authorizeDatabase(w http.ResponseWriter, r *http.Request, iamSvc *iam.Service, databaseSvc *database.Service) bool {
+	u, _ := userFromContext(r.Context())
+	if u.AllowsAdmin() {
+		return true
+	}
+	id, sub, err := database.ParseDatabaseSubresource(r.URL.Path)
+	if err != nil {
+		if id, err = database.ParseDatabaseID(r.URL.Path); err != nil {
+			http.Error(w, "invalid database id", http.StatusBadRequest)
+			return false
+		}
+	}
+	siteID, err := databaseSvc.DatabaseSiteID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, database.ErrDatabaseNotFound) {
+			http.Error(w, "database not found", http.StatusNotFound)
+			return false
+		}
+		http.Error(w, "failed to check database access", http.StatusInternalServerError)
+		return false
+	}
+	action := iam.ActionDatabases
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && !databaseSecretSubresources[sub] {
+		action = iam.ActionRead
+	}
+	return authorizeSite(w, r, iamSvc, siteID, action)
+}
+
+// listVisibleSites serves GET /api/sites to callers that do not see every
+// site: organization members, users with grants and site-limited tokens.
+func listVisibleSites(w http.ResponseWriter, r *http.Request, hostingSvc *hosting.Service, ids map[int64]bool) {
+	sites, err := hostingSvc.ListSites(r.Context())
+	if err != nil {
+		http.Error(w, "failed to list sites", http.StatusInternalServerError)
+		return
+	}
+	visible := make([]hosting.Site, 0, len(ids))
+	for _, site := range sites {
+		if ids[site.ID] {
+			visible = append(visible, site)
+		}
+	}
+	jsonstream.List(w, r, "sites", visible)
+}
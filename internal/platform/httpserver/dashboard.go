@@ -0,0 +1,97 @@
+package httpserver
+
+import (
+	"net/http"
+
+	"github.com/robsonek/aiPanel/internal/modules/backup"
+	"github.com/robsonek/aiPanel/internal/modules/hosting"
+	"github.com/robsonek/aiPanel/internal/modules/iam"
+	"github.com/robsonek/aiPanel/internal/platform/config"
+)
+
+// Dashboard health levels, worst last.
+const (
+	healthOK       = "ok"
+	healthWarning  = "warning"
+	healthCritical = "critical"
+)
+
+// siteHealth is one site on the dashboard. Backup is nil when the panel
+// runs without the backup service.
+type siteHealth struct {
+	hosting.SiteRollup
+	Backup *backup.Freshness `json:"backup,omitempty"`
+	Status string            `json:"status"`
+}
+
+// rollupStatus grades a site: critical when it is down or about to be
+// (failed health check, expired certificate, a worker not running),
+// warning for what needs attention soon.
+func rollupStatus(s siteHealth) string {
+	switch {
+	case s.Health == hosting.CheckFail, s.Certificate.Status == hosting.CertExpired, s.Workers.Down > 0:
+		return healthCritical
+	case s.Cron.Failing > 0, s.Certificate.Status == hosting.CertExpiring,
+		s.Backup != nil && (s.Backup.Stale || s.Backup.LastStatus == backup.RunFailed):
+		return healthWarning
+	default:
+		return healthOK
+	}
+}
+
+func registerDashboardRoutes(mux *http.ServeMux, cfg config.Config, iamSvc *iam.Service, hostingSvc *hosting.Service, backups *backup.Service) {
+	// GET /api/dashboard/health rolls up workers, cron results, backup
+	// freshness and certificates of the caller's sites in one response.
+	mux.Handle("/api/dashboard/health", requireAuth(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		u, _ := userFromContext(r.Context())
+		ids, all, err := iamSvc.VisibleSites(r.Context(), u)
+		if err != nil {
+			http.Error(w, "failed to list sites", http.StatusInternalServerError)
+			return
+		}
+		if all {
+			ids = nil
+		}
+		rollups, err := hostingSvc.SiteRollups(r.Context(), ids)
+		if err != nil {
+			http.Error(w, "failed to roll up site health", http.StatusInternalServerError)
+			return
+		}
+		var freshness map[int64]backup.Freshness
+		if backups != nil {
+			if freshness, err = backups.SiteFreshness(r.Context()); err != nil {
+				http.Error(w, "failed to read backup freshness", http.StatusInternalServerError)
+				return
+			}
+		}
+		counts := map[string]int{healthOK: 0, healthWarning: 0, healthCritical: 0}
+		overall := healthOK
+		sites := make([]siteHealth, 0, len(rollups))
+		for _, rollup := range rollups {
+			site := siteHealth{SiteRollup: rollup}
+			if backups != nil {
+				// A site never backed up is as stale as it gets.
+				f, ok := freshness[rollup.SiteID]
+				if !ok {
+					f = backup.Freshness{Stale: true}
+				}
+				site.Backup = &f
+			}
+			site.Status = rollupStatus(site)
+			counts[site.Status]++
+			if site.Status == healthCritical || (site.Status == healthWarning && overall == healthOK) {
+				overall = site.Status
+			}
+			sites = append(sites, site)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"status": overall,
+			"counts": counts,
+			"sites":  sites,
+		})
+	})))
+}
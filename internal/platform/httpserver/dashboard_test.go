@@ -0,0 +1,74 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/modules/backup"
+	"github.com/robsonek/aiPanel/internal/modules/hosting"
+	"github.com/robsonek/aiPanel/internal/platform/config"
+)
+
+func TestDashboardHealth_RollsUpVisibleSites(t *testing.T) {
+	ctx := context.Background()
+	srv := newAccessTestServer(t)
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	cfg := config.Config{Env: "test", SessionCookieName: "aipanel_session", SessionTTL: time.Hour}
+	srv.handler = NewHandler(cfg, log, srv.iam, hosting.NewService(srv.store, cfg, log, nil, nil, nil), nil,
+		HandlerOptions{Backups: backup.NewService(srv.store, log, backup.Options{})})
+	if err := backup.RecordRun(ctx, srv.store, backup.BackupRun{Kind: backup.KindSiteFiles, SiteID: 1}, nil); err != nil {
+		t.Fatalf("record backup run: %v", err)
+	}
+	if err := srv.store.ExecPanel(ctx, "UPDATE sites SET health_status = 'pass' WHERE id = 1;"); err != nil {
+		t.Fatalf("set health: %v", err)
+	}
+
+	type body struct {
+		Status string         `json:"status"`
+		Counts map[string]int `json:"counts"`
+		Sites  []struct {
+			SiteID int64             `json:"site_id"`
+			Status string            `json:"status"`
+			Backup *backup.Freshness `json:"backup"`
+		} `json:"sites"`
+	}
+	get := func(token string) body {
+		t.Helper()
+		rec := srv.do(token, http.MethodGet, "/api/dashboard/health", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		var b body
+		if err := json.Unmarshal(rec.Body.Bytes(), &b); err != nil {
+			t.Fatalf("decode dashboard health: %v", err)
+		}
+		return b
+	}
+
+	admin := srv.login("admin@example.com")
+	all := get(admin)
+	if all.Status != "warning" || all.Counts["ok"] != 1 || all.Counts["warning"] != 1 || len(all.Sites) != 2 {
+		t.Fatalf("unexpected rollup: %+v", all)
+	}
+	for _, site := range all.Sites {
+		if site.Backup == nil || site.Backup.Stale != (site.SiteID == 2) {
+			t.Fatalf("unexpected backup freshness for site %d: %+v", site.SiteID, site.Backup)
+		}
+	}
+
+	if rec := srv.do(admin, http.MethodPost, "/api/users", `{"email":"viewer@agency.example","password":"supersecret123"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("create user: expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := srv.do(admin, http.MethodPut, "/api/users/2/grants/1", `{"actions":["read"]}`); rec.Code != http.StatusOK {
+		t.Fatalf("grant read: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	mine := get(srv.login("viewer@agency.example"))
+	if mine.Status != "ok" || len(mine.Sites) != 1 || mine.Sites[0].SiteID != 1 {
+		t.Fatalf("expected the granted site only, got %+v", mine)
+	}
+}
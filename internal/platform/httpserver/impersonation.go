@@ -0,0 +1,87 @@
+package httpserver
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/modules/iam"
+	"github.com/robsonek/aiPanel/internal/platform/config"
+)
+
+// impersonatorHeader marks every response served to an impersonation
+// session, so the frontend can show a banner and logs tell the requests
+// apart.
+const impersonatorHeader = "X-Aipanel-Impersonator"
+
+// originCookieName keeps the admin's own session while it impersonates a
+// user, to be restored when the impersonation ends.
+func originCookieName(cfg config.Config) string {
+	return cfg.SessionCookieName + "_origin"
+}
+
+func sessionCookie(cfg config.Config, r *http.Request, name, value string, expires time.Time) *http.Cookie {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   useSecureCookie(cfg.Env, r),
+		SameSite: http.SameSiteLaxMode,
+		Expires:  expires,
+	}
+	if value == "" {
+		c.MaxAge = -1
+	}
+	return c
+}
+
+// startImpersonation serves POST /api/users/{id}/impersonate: the browser
+// switches to a session as the user and keeps the admin session aside.
+func startImpersonation(w http.ResponseWriter, r *http.Request, cfg config.Config, log *slog.Logger, iamSvc *iam.Service, userID int64) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	admin, _ := userFromContext(r.Context())
+	session, err := iamSvc.Impersonate(r.Context(), admin, userID)
+	if err != nil {
+		writeAccessError(w, err)
+		return
+	}
+	log.Warn("impersonation started", "admin", admin.Email, "user", session.User.Email)
+	http.SetCookie(w, sessionCookie(cfg, r, originCookieName(cfg), readSessionToken(r, cfg.SessionCookieName), time.Time{}))
+	http.SetCookie(w, sessionCookie(cfg, r, cfg.SessionCookieName, session.Token, session.ExpiresAt))
+	w.Header().Set(impersonatorHeader, admin.Email)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"user":       session.User,
+		"token":      session.Token,
+		"expires_at": session.ExpiresAt,
+	})
+}
+
+func registerImpersonationRoutes(mux *http.ServeMux, cfg config.Config, log *slog.Logger, iamSvc *iam.Service) {
+	// POST /api/auth/impersonation/stop ends the impersonation session and
+	// puts the admin session back.
+	mux.Handle("/api/auth/impersonation/stop", requireAuth(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		u, _ := userFromContext(r.Context())
+		if err := iamSvc.EndImpersonation(r.Context(), u, readSessionToken(r, cfg.SessionCookieName)); err != nil {
+			http.Error(w, "not an impersonation session", http.StatusBadRequest)
+			return
+		}
+		log.Warn("impersonation ended", "admin", u.Impersonator, "user", u.Email)
+		origin, err := r.Cookie(originCookieName(cfg))
+		if err != nil || origin.Value == "" {
+			http.SetCookie(w, sessionCookie(cfg, r, cfg.SessionCookieName, "", time.Time{}))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.SetCookie(w, sessionCookie(cfg, r, cfg.SessionCookieName, origin.Value, time.Time{}))
+		http.SetCookie(w, sessionCookie(cfg, r, originCookieName(cfg), "", time.Time{}))
+		w.WriteHeader(http.StatusNoContent)
+	})))
+}
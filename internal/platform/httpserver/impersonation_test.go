@@ -0,0 +1,72 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestImpersonation_AuditsBothIdentities(t *testing.T) {
+	srv := newAccessTestServer(t)
+	admin := srv.login("admin@example.com")
+	for _, req := range []struct{ method, path, body string }{
+		{http.MethodPost, "/api/users", `{"email":"owner@client.example","password":"supersecret123"}`},
+		{http.MethodPost, "/api/users", `{"email":"dev@client.example","password":"supersecret123"}`},
+		{http.MethodPost, "/api/orgs", `{"name":"Client"}`},
+		{http.MethodPut, "/api/orgs/1/members", `{"email":"owner@client.example","role":"owner"}`},
+	} {
+		if rec := srv.do(admin, req.method, req.path, req.body); rec.Code >= 300 {
+			t.Fatalf("%s %s: %d %s", req.method, req.path, rec.Code, rec.Body)
+		}
+	}
+
+	if rec := srv.do(admin, http.MethodPost, "/api/users/1/impersonate", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected admins not to be impersonated, got %d", rec.Code)
+	}
+	rec := srv.do(admin, http.MethodPost, "/api/users/2/impersonate", "")
+	if rec.Code != http.StatusOK || rec.Header().Get(impersonatorHeader) != "admin@example.com" {
+		t.Fatalf("impersonate: expected 200 with the impersonator header, got %d %v", rec.Code, rec.Header())
+	}
+	var session string
+	for _, c := range rec.Result().Cookies() {
+		switch c.Name {
+		case "aipanel_session":
+			session = c.Value
+		case "aipanel_session_origin":
+			if c.Value != admin {
+				t.Fatalf("expected the admin session to be kept aside, got %q", c.Value)
+			}
+		}
+	}
+
+	rec = srv.do(session, http.MethodGet, "/api/auth/me", "")
+	if !strings.Contains(rec.Body.String(), `"impersonator":"admin@example.com"`) || rec.Header().Get(impersonatorHeader) == "" {
+		t.Fatalf("expected the session to be marked, got %s", rec.Body)
+	}
+	if rec := srv.do(session, http.MethodGet, "/api/users", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected the user's view, got %d", rec.Code)
+	}
+	if rec := srv.do(session, http.MethodPost, "/api/tokens", `{"name":"x"}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected token creation to be refused while impersonating, got %d", rec.Code)
+	}
+	if rec := srv.do(session, http.MethodPut, "/api/orgs/1/members", `{"email":"dev@client.example","role":"viewer"}`); rec.Code != http.StatusOK {
+		t.Fatalf("member change: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	rows, err := srv.store.QueryAuditJSON(context.Background(),
+		"SELECT actor, impersonator FROM audit_events WHERE action = 'iam.org.member.set' ORDER BY id DESC LIMIT 1;")
+	if err != nil || len(rows) != 1 || rows[0]["actor"] != "owner@client.example" || rows[0]["impersonator"] != "admin@example.com" {
+		t.Fatalf("expected both identities in the audit event, got %v (%v)", rows, err)
+	}
+
+	rec = srv.do(session, http.MethodPost, "/api/auth/impersonation/stop", "")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("stop: expected 204, got %d", rec.Code)
+	}
+	if rec := srv.do(session, http.MethodGet, "/api/auth/me", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the impersonation session to end, got %d", rec.Code)
+	}
+	if rec := srv.do(admin, http.MethodPost, "/api/auth/impersonation/stop", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 outside an impersonation session, got %d", rec.Code)
+	}
+}
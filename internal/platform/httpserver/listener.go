@@ -0,0 +1,152 @@
+package httpserver
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+)
+
+// systemdListenFDStart is the first file descriptor passed by systemd socket activation.
+const systemdListenFDStart = 3
+
+// unixSocketGroup is the group allowed to connect to the panel unix socket (nginx workers).
+const unixSocketGroup = "www-data"
+
+// Listen returns the panel listener. A socket passed by systemd socket
+// activation takes precedence; otherwise addr is bound as a unix socket
+// ("unix:/path") or a TCP address.
+func Listen(addr string) (net.Listener, error) {
+	if ln, ok, err := systemdListener(); ok || err != nil {
+		return ln, err
+	}
+	if socketPath, ok := config.UnixSocketPath(addr); ok {
+		return listenUnix(socketPath)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen tcp %s: %w", addr, err)
+	}
+	return ln, nil
+}
+
+// ListenAll returns the listener for addr, as Listen does, followed by
+// listeners for every extra address. An "iface:<name>:<port>" entry binds
+// each address currently assigned to the interface. Listeners opened before
+// a failure are closed.
+func ListenAll(addr string, extra []string) ([]net.Listener, error) {
+	primary, err := Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	listeners := []net.Listener{primary}
+	closeAll := func() {
+		for _, ln := range listeners {
+			_ = ln.Close()
+		}
+	}
+	for _, a := range extra {
+		addrs := []string{a}
+		if name, port, ok := config.InterfaceListenAddr(a); ok {
+			if addrs, err = interfaceAddrs(name, port); err != nil {
+				closeAll()
+				return nil, err
+			}
+		}
+		for _, bind := range addrs {
+			var ln net.Listener
+			if socketPath, ok := config.UnixSocketPath(bind); ok {
+				ln, err = listenUnix(socketPath)
+			} else if ln, err = net.Listen("tcp", bind); err != nil {
+				err = fmt.Errorf("listen tcp %s: %w", bind, err)
+			}
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+			listeners = append(listeners, ln)
+		}
+	}
+	return listeners, nil
+}
+
+// interfaceAddrs returns host:port for each address of an interface.
+// IPv6 link-local addresses are skipped since they need a zone to bind.
+func interfaceAddrs(name, port string) ([]string, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("listen on interface %s: %w", name, err)
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("list addresses of interface %s: %w", name, err)
+	}
+	var out []string
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		out = append(out, net.JoinHostPort(ipNet.IP.String(), port))
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("listen on interface %s: no usable addresses", name)
+	}
+	return out, nil
+}
+
+func systemdListener() (net.Listener, bool, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, false, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, false, nil
+	}
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(uintptr(systemdListenFDStart), "systemd-socket")
+	if f == nil {
+		return nil, true, fmt.Errorf("systemd socket activation: invalid file descriptor")
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, true, fmt.Errorf("systemd socket activation: %w", err)
+	}
+	return ln, true, nil
+}
+
+func listenUnix(socketPath string) (net.Listener, error) {
+	if info, err := os.Lstat(socketPath); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("listen unix %s: path exists and is not a socket", socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return nil, fmt.Errorf("remove stale socket %s: %w", socketPath, err)
+		}
+	}
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("listen unix %s: %w", socketPath, err)
+	}
+	//nolint:gosec // G302: nginx workers need group access to the socket.
+	if err := os.Chmod(socketPath, 0o660); err != nil {
+		_ = ln.Close()
+		return nil, fmt.Errorf("chmod socket %s: %w", socketPath, err)
+	}
+	if grp, err := user.LookupGroup(unixSocketGroup); err == nil {
+		if gid, convErr := strconv.Atoi(grp.Gid); convErr == nil {
+			_ = os.Chown(socketPath, -1, gid)
+		}
+	}
+	return ln, nil
+}
@@ -0,0 +1,91 @@
+package httpserver
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListen_UnixSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "aipanel.sock")
+
+	ln, err := Listen("unix:" + socketPath)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	if ln.Addr().Network() != "unix" {
+		t.Fatalf("expected unix listener, got %q", ln.Addr().Network())
+	}
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatalf("stat socket: %v", err)
+	}
+	if info.Mode().Perm() != 0o660 {
+		t.Fatalf("expected socket mode 0660, got %o", info.Mode().Perm())
+	}
+	// Keep the socket file around to simulate a crash; binding again must replace it.
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = ln.Close()
+	ln, err = Listen("unix:" + socketPath)
+	if err != nil {
+		t.Fatalf("listen again: %v", err)
+	}
+	_ = ln.Close()
+}
+
+func TestListen_UnixSocketRefusesRegularFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "not-a-socket")
+	if err := os.WriteFile(path, []byte("x"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if _, err := Listen("unix:" + path); err == nil {
+		t.Fatal("expected error for non-socket path")
+	}
+}
+
+func TestListen_TCP(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer func() {
+		_ = ln.Close()
+	}()
+	if ln.Addr().Network() != "tcp" {
+		t.Fatalf("expected tcp listener, got %q", ln.Addr().Network())
+	}
+}
+
+func TestListenAll_ExtraAddrs(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "aipanel.sock")
+	listeners, err := ListenAll("127.0.0.1:0", []string{"unix:" + socketPath, "iface:lo:0"})
+	if err != nil {
+		t.Fatalf("listen all: %v", err)
+	}
+	defer func() {
+		for _, ln := range listeners {
+			_ = ln.Close()
+		}
+	}()
+	if len(listeners) < 3 {
+		t.Fatalf("expected primary, unix and loopback interface listeners, got %d", len(listeners))
+	}
+	if listeners[1].Addr().Network() != "unix" {
+		t.Fatalf("expected unix listener second, got %q", listeners[1].Addr().Network())
+	}
+	for _, ln := range listeners[2:] {
+		tcp, ok := ln.Addr().(*net.TCPAddr)
+		if !ok || !tcp.IP.IsLoopback() {
+			t.Fatalf("expected loopback interface listener, got %v", ln.Addr())
+		}
+	}
+
+	primary := filepath.Join(t.TempDir(), "primary.sock")
+	if _, err := ListenAll("unix:"+primary, []string{"iface:aipanel-missing0:8080"}); err == nil {
+		t.Fatal("expected unknown interface to be rejected")
+	}
+	if _, err := os.Stat(primary); !os.IsNotExist(err) {
+		t.Fatalf("expected primary listener to be closed after failure, stat err=%v", err)
+	}
+}
@@ -0,0 +1,275 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/modules/iam"
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/jsonstream"
+)
+
+func registerOrgRoutes(mux *http.ServeMux, cfg config.Config, log *slog.Logger, iamSvc *iam.Service) {
+	// GET/POST /api/users lists and creates panel users; organization
+	// members are users with the "user" role.
+	mux.Handle("/api/users", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			users, err := iamSvc.ListUsers(r.Context())
+			if err != nil {
+				http.Error(w, "failed to list users", http.StatusInternalServerError)
+				return
+			}
+			jsonstream.List(w, r, "users", users)
+		case http.MethodPost:
+			u, _ := userFromContext(r.Context())
+			var req struct {
+				Email    string `json:"email"`
+				Password string `json:"password"`
+				Role     string `json:"role"`
+			}
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+			if req.Role == "" {
+				req.Role = iam.RoleUser
+			}
+			created, err := iamSvc.CreateUser(r.Context(), req.Email, req.Password, req.Role)
+			if err != nil {
+				if strings.Contains(err.Error(), "already exists") {
+					http.Error(w, err.Error(), http.StatusConflict)
+					return
+				}
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Info("user created", "actor", u.Email, "email", created.Email, "role", created.Role)
+			writeJSON(w, http.StatusCreated, map[string]any{"user": created})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+
+	// /api/users/{id}/grants lists a user's site grants;
+	// PUT/DELETE /api/users/{id}/grants/{site_id} sets or revokes one.
+	// POST /api/users/{id}/impersonate opens an impersonation session.
+	mux.Handle("/api/users/", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, _ := userFromContext(r.Context())
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/users/"), "/"), "/")
+		userID, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || userID <= 0 {
+			http.Error(w, "invalid user id", http.StatusBadRequest)
+			return
+		}
+		if len(parts) == 2 && parts[1] == "impersonate" {
+			startImpersonation(w, r, cfg, log, iamSvc, userID)
+			return
+		}
+		if len(parts) < 2 || len(parts) > 3 || parts[1] != "grants" {
+			http.NotFound(w, r)
+			return
+		}
+		if len(parts) == 2 {
+			if r.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			grants, err := iamSvc.ListGrants(r.Context(), userID)
+			if err != nil {
+				writeAccessError(w, err)
+				return
+			}
+			jsonstream.List(w, r, "grants", grants)
+			return
+		}
+		siteID, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil || siteID <= 0 {
+			http.Error(w, "invalid site id", http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			var req struct {
+				Actions []string `json:"actions"`
+			}
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+			grant, err := iamSvc.GrantSite(r.Context(), userID, siteID, req.Actions, u.Email)
+			if err != nil {
+				writeAccessError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"grant": grant})
+		case http.MethodDelete:
+			if err := iamSvc.RevokeSite(r.Context(), userID, siteID, u.Email); err != nil {
+				writeAccessError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+
+	mux.Handle("/api/orgs", requireAuth(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, _ := userFromContext(r.Context())
+		switch r.Method {
+		case http.MethodGet:
+			orgs, err := iamSvc.ListOrgs(r.Context(), u)
+			if err != nil {
+				http.Error(w, "failed to list organizations", http.StatusInternalServerError)
+				return
+			}
+			jsonstream.List(w, r, "organizations", orgs)
+		case http.MethodPost:
+			if !u.AllowsAdmin() {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			var req struct {
+				Name string `json:"name"`
+			}
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+			org, err := iamSvc.CreateOrg(r.Context(), req.Name, u.Email)
+			if err != nil {
+				writeAccessError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusCreated, map[string]any{"organization": org})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+
+	mux.Handle("/api/orgs/", requireAuth(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleOrg(w, r, iamSvc)
+	})))
+}
+
+// handleOrg serves:
+//
+//	GET    /api/orgs/{id}
+//	DELETE /api/orgs/{id}
+//	PUT    /api/orgs/{id}/members              {"email", "role"}
+//	DELETE /api/orgs/{id}/members/{user_id}
+//	PUT    /api/orgs/{id}/sites/{site_id}
+//	DELETE /api/orgs/{id}/sites/{site_id}
+//
+// Members may read their organization and owners manage its members;
+// creating, deleting and assigning sites is left to admins.
+func handleOrg(w http.ResponseWriter, r *http.Request, iamSvc *iam.Service) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/orgs/"), "/"), "/")
+	orgID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || orgID <= 0 || len(parts) > 3 {
+		http.Error(w, "invalid organization path", http.StatusBadRequest)
+		return
+	}
+	var childID int64
+	if len(parts) == 3 {
+		if childID, err = strconv.ParseInt(parts[2], 10, 64); err != nil || childID <= 0 {
+			http.Error(w, "invalid organization path", http.StatusBadRequest)
+			return
+		}
+	}
+	u, _ := userFromContext(r.Context())
+	role, err := iamSvc.OrgRole(r.Context(), u, orgID)
+	if err != nil {
+		http.Error(w, "failed to check organization access", http.StatusInternalServerError)
+		return
+	}
+	if role == "" {
+		http.Error(w, "organization not found", http.StatusNotFound)
+		return
+	}
+	// Membership changes need an unrestricted session or token.
+	canManage := role == iam.RoleAdmin || (role == iam.OrgRoleOwner && !u.Scope.Narrowed())
+	sub := ""
+	if len(parts) > 1 {
+		sub = parts[1]
+	}
+
+	var org iam.Organization
+	switch {
+	case sub == "" && len(parts) == 1 && r.Method == http.MethodGet:
+		org, err = iamSvc.GetOrg(r.Context(), orgID)
+	case sub == "" && len(parts) == 1 && r.Method == http.MethodDelete:
+		if role != iam.RoleAdmin {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if err := iamSvc.DeleteOrg(r.Context(), orgID, u.Email); err != nil {
+			writeAccessError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case sub == "members" && len(parts) == 2 && r.Method == http.MethodPut:
+		if !canManage {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		var req struct {
+			Email string `json:"email"`
+			Role  string `json:"role"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		org, err = iamSvc.SetOrgMember(r.Context(), orgID, req.Email, req.Role, u.Email)
+	case sub == "members" && len(parts) == 3 && r.Method == http.MethodDelete:
+		if !canManage {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		org, err = iamSvc.RemoveOrgMember(r.Context(), orgID, childID, u.Email)
+	case sub == "sites" && len(parts) == 3 && (r.Method == http.MethodPut || r.Method == http.MethodDelete):
+		if role != iam.RoleAdmin {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if r.Method == http.MethodPut {
+			org, err = iamSvc.AssignSite(r.Context(), orgID, childID, u.Email)
+		} else {
+			org, err = iamSvc.UnassignSite(r.Context(), orgID, childID, u.Email)
+		}
+	case sub == "" || ((sub == "members" || sub == "sites") && len(parts) <= 3):
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		writeAccessError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"organization": org})
+}
+
+func writeAccessError(w http.ResponseWriter, err error) {
+	msg := err.Error()
+	switch {
+	case errors.Is(err, iam.ErrOrgNotFound), errors.Is(err, iam.ErrUserNotFound), errors.Is(err, iam.ErrTokenNotFound):
+		http.Error(w, msg, http.StatusNotFound)
+	case errors.Is(err, iam.ErrCannotImpersonate):
+		http.Error(w, msg, http.StatusForbidden)
+	case strings.Contains(msg, "already exists"):
+		http.Error(w, msg, http.StatusConflict)
+	case strings.Contains(msg, "invalid"), strings.Contains(msg, "required"):
+		http.Error(w, msg, http.StatusBadRequest)
+	default:
+		http.Error(w, "access update failed", http.StatusInternalServerError)
+	}
+}
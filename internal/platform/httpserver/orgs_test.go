@@ -0,0 +1,158 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/modules/hosting"
+	"github.com/robsonek/aiPanel/internal/modules/iam"
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+// accessTestServer serves a panel with an admin and two sites,
+// client.example (id 1) and other.example (id 2).
+type accessTestServer struct {
+	t       *testing.T
+	handler http.Handler
+	iam     *iam.Service
+	store   *sqlite.Store
+	jobs    *jobqueue.Queue
+}
+
+func newAccessTestServer(t *testing.T) *accessTestServer {
+	t.Helper()
+	ctx := context.Background()
+	cfg := config.Config{
+		Addr:              ":8080",
+		Env:               "test",
+		DataDir:           t.TempDir(),
+		SessionCookieName: "aipanel_session",
+		SessionTTL:        time.Hour,
+	}
+	store := sqlite.New(cfg.DataDir)
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init sqlite: %v", err)
+	}
+	for _, domain := range []string{"client.example", "other.example"} {
+		if err := store.ExecPanel(ctx, "INSERT INTO sites(domain, root_dir, system_user, created_at, updated_at) VALUES('"+
+			domain+"','/var/www/"+domain+"/public_html','site_x',1,1);"); err != nil {
+			t.Fatalf("seed site: %v", err)
+		}
+	}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	iamSvc := iam.NewService(store, cfg, log)
+	if err := iamSvc.CreateAdmin(ctx, "admin@example.com", "supersecret123"); err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+	jobs := jobqueue.New(store, log)
+	return &accessTestServer{
+		t:       t,
+		handler: NewHandler(cfg, log, iamSvc, hosting.NewService(store, cfg, log, nil, nil, nil), nil, HandlerOptions{Jobs: jobs}),
+		iam:     iamSvc,
+		store:   store,
+		jobs:    jobs,
+	}
+}
+
+// login signs in with the test password and returns the session token.
+func (s *accessTestServer) login(email string) string {
+	s.t.Helper()
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/auth/login",
+		strings.NewReader(`{"email":"`+email+`","password":"supersecret123"}`)))
+	if rec.Code != http.StatusOK {
+		s.t.Fatalf("login %s: expected 200, got %d", email, rec.Code)
+	}
+	return rec.Result().Cookies()[0].Value
+}
+
+// do sends a request authenticated with a session or API token.
+func (s *accessTestServer) do(token, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestOrganizations_ScopeSiteAccess(t *testing.T) {
+	srv := newAccessTestServer(t)
+	login, do := srv.login, srv.do
+	admin := login("admin@example.com")
+
+	if rec := do(admin, http.MethodPost, "/api/users", `{"email":"dev@agency.example","password":"supersecret123"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("create user: expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(admin, http.MethodPost, "/api/orgs", `{"name":"Client"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("create org: expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(admin, http.MethodPut, "/api/orgs/1/members", `{"email":"dev@agency.example","role":"viewer"}`); rec.Code != http.StatusOK {
+		t.Fatalf("add member: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(admin, http.MethodPut, "/api/orgs/1/members", `{"email":"dev@agency.example","role":"root"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown role, got %d", rec.Code)
+	}
+	rec := do(admin, http.MethodPut, "/api/orgs/1/sites/1", "")
+	var resp struct {
+		Organization iam.Organization `json:"organization"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || len(resp.Organization.SiteIDs) != 1 || len(resp.Organization.Members) != 1 {
+		t.Fatalf("unexpected organization: %+v (%v)", resp.Organization, err)
+	}
+
+	user := login("dev@agency.example")
+	rec = do(user, http.MethodGet, "/api/sites", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "client.example") || strings.Contains(rec.Body.String(), "other.example") {
+		t.Fatalf("expected only the organization's site, got %d: %s", rec.Code, rec.Body)
+	}
+	for _, tc := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/api/sites/1", http.StatusOK},
+		{http.MethodGet, "/api/sites/2", http.StatusNotFound},
+		{http.MethodGet, "/api/sites/1/limits", http.StatusForbidden},
+		{http.MethodPut, "/api/sites/1/canonical-host", http.StatusForbidden},
+		{http.MethodDelete, "/api/sites/1", http.StatusForbidden},
+		{http.MethodPost, "/api/sites", http.StatusForbidden},
+		{http.MethodPost, "/api/orgs", http.StatusForbidden},
+		{http.MethodPut, "/api/orgs/1/members", http.StatusForbidden},
+		{http.MethodGet, "/api/orgs/1", http.StatusOK},
+		{http.MethodGet, "/api/orgs/2", http.StatusNotFound},
+		{http.MethodGet, "/api/users", http.StatusForbidden},
+		{http.MethodGet, "/api/auth/verify", http.StatusForbidden},
+	} {
+		if rec := do(user, tc.method, tc.path, "{}"); rec.Code != tc.want {
+			t.Fatalf("%s %s: expected %d, got %d: %s", tc.method, tc.path, tc.want, rec.Code, rec.Body)
+		}
+	}
+
+	if rec := do(admin, http.MethodPut, "/api/orgs/1/members", `{"email":"dev@agency.example","role":"owner"}`); rec.Code != http.StatusOK {
+		t.Fatalf("promote member: expected 200, got %d", rec.Code)
+	}
+	if rec := do(user, http.MethodPut, "/api/orgs/1/members", `{"email":"admin@example.com","role":"viewer"}`); rec.Code != http.StatusOK {
+		t.Fatalf("owner adds member: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(user, http.MethodPut, "/api/orgs/1/sites/2", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected owners not to assign sites, got %d", rec.Code)
+	}
+	if rec := do(user, http.MethodDelete, "/api/sites/1", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected owners not to delete sites, got %d", rec.Code)
+	}
+
+	if rec := do(admin, http.MethodDelete, "/api/orgs/1", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete org: expected 204, got %d", rec.Code)
+	}
+	if rec := do(user, http.MethodGet, "/api/sites/1", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected access to end with the organization, got %d", rec.Code)
+	}
+}
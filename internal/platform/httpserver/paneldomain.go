@@ -0,0 +1,121 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/modules/iam"
+	"github.com/robsonek/aiPanel/internal/platform/config"
+)
+
+// PanelDomainFunc points the panel vhost at domain and, with letsEncrypt,
+// issues its certificate. The setup wizard and the panel domain API call
+// it.
+type PanelDomainFunc func(ctx context.Context, domain string, letsEncrypt bool, email string) error
+
+// panelDomainPattern accepts the same host names as site domains.
+var panelDomainPattern = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?(?:\.[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?)+$`)
+
+// panelDomainTimeout bounds one vhost rewrite plus certificate issuance.
+const panelDomainTimeout = 10 * time.Minute
+
+// panelDomainState tracks the last panel domain change, which outlives
+// the request that starts it.
+type panelDomainState struct {
+	mu     sync.Mutex
+	domain string
+	status string // running, configured, failed
+	err    string
+}
+
+func (s *panelDomainState) set(domain, status, errMsg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.domain, s.status, s.err = domain, status, errMsg
+}
+
+func (s *panelDomainState) snapshot() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status == "" {
+		return nil
+	}
+	return map[string]string{"domain": s.domain, "status": s.status, "error": s.err}
+}
+
+// start runs fn in the background unless a change is already running,
+// and reports whether it started.
+func (s *panelDomainState) start(log *slog.Logger, fn PanelDomainFunc, domain string, letsEncrypt bool, email string) bool {
+	s.mu.Lock()
+	if s.status == "running" {
+		s.mu.Unlock()
+		return false
+	}
+	s.domain, s.status, s.err = domain, "running", ""
+	s.mu.Unlock()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), panelDomainTimeout)
+		defer cancel()
+		if err := fn(ctx, domain, letsEncrypt, email); err != nil {
+			log.Error("configure panel domain failed", "panel_domain", domain, "error", err.Error())
+			s.set(domain, "failed", err.Error())
+			return
+		}
+		log.Info("panel domain configured", "panel_domain", domain, "lets_encrypt", letsEncrypt)
+		s.set(domain, "configured", "")
+	}()
+	return true
+}
+
+func registerPanelDomainRoutes(mux *http.ServeMux, cfg config.Config, log *slog.Logger, iamSvc *iam.Service, fn PanelDomainFunc, state *panelDomainState) {
+	// GET /api/settings/panel-domain returns the configured domain and the
+	// last change; POST starts a change and is polled with GET.
+	mux.Handle("/api/settings/panel-domain", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			resp := map[string]any{"domain": cfg.PanelDomain}
+			if change := state.snapshot(); change != nil {
+				resp["change"] = change
+			}
+			writeJSON(w, http.StatusOK, resp)
+		case http.MethodPost:
+			u, _ := userFromContext(r.Context())
+			var req struct {
+				Domain           string `json:"domain"`
+				LetsEncrypt      bool   `json:"lets_encrypt"`
+				LetsEncryptEmail string `json:"lets_encrypt_email"`
+			}
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+			domain := strings.ToLower(strings.TrimSpace(req.Domain))
+			if !panelDomainPattern.MatchString(domain) {
+				http.Error(w, "invalid panel domain", http.StatusBadRequest)
+				return
+			}
+			email := strings.TrimSpace(req.LetsEncryptEmail)
+			if email == "" {
+				email = cfg.ACMEEmail
+			}
+			if req.LetsEncrypt && email == "" {
+				email = u.Email
+			}
+			if !state.start(log, fn, domain, req.LetsEncrypt, email) {
+				http.Error(w, "a panel domain change is already running", http.StatusConflict)
+				return
+			}
+			log.Info("panel domain change started", "actor", u.Email, "panel_domain", domain, "lets_encrypt", req.LetsEncrypt)
+			writeJSON(w, http.StatusAccepted, map[string]any{"change": state.snapshot()})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+}
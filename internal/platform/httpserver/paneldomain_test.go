@@ -0,0 +1,65 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+func TestPanelDomainEndpoint_RunsChangeInBackground(t *testing.T) {
+	release := make(chan struct{})
+	calls := make(chan string, 2)
+	handler, cookie := newAdminTestHandler(t, func(config.Config, *sqlite.Store) HandlerOptions {
+		return HandlerOptions{PanelDomain: func(_ context.Context, domain string, letsEncrypt bool, email string) error {
+			calls <- domain + " " + email
+			<-release
+			return nil
+		}}
+	})
+	do := func(method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/settings/panel-domain", strings.NewReader(body))
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPost, `{"domain":"not a domain"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid domain, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, `{"domain":"Panel.Example.com","lets_encrypt":true}`); rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := <-calls; got != "panel.example.com admin@example.com" {
+		t.Fatalf("unexpected change: %q", got)
+	}
+	if rec := do(http.MethodPost, `{"domain":"other.example.com"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 while a change runs, got %d", rec.Code)
+	}
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var resp struct {
+			Change map[string]string `json:"change"`
+		}
+		rec := do(http.MethodGet, "")
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode status: %v", err)
+		}
+		if resp.Change["status"] == "configured" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the change to finish, got %v", resp.Change)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,47 @@
+package httpserver
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/modules/iam"
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/jsonstream"
+)
+
+func registerSecurityRoutes(mux *http.ServeMux, cfg config.Config, log *slog.Logger, iamSvc *iam.Service) {
+	// GET /api/admin/security/lockouts lists emails and addresses locked
+	// out after failed logins; DELETE ?kind=email|ip&key=... lifts one.
+	mux.Handle("/api/admin/security/lockouts", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			lockouts, err := iamSvc.ListLockouts(r.Context())
+			if err != nil {
+				http.Error(w, "failed to list lockouts", http.StatusInternalServerError)
+				return
+			}
+			jsonstream.List(w, r, "lockouts", lockouts)
+		case http.MethodDelete:
+			u, _ := userFromContext(r.Context())
+			kind, key := r.URL.Query().Get("kind"), r.URL.Query().Get("key")
+			err := iamSvc.ClearLockout(r.Context(), kind, key, u.Email)
+			switch {
+			case errors.Is(err, iam.ErrLockoutNotFound):
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			case err != nil && (strings.Contains(err.Error(), "invalid") || strings.Contains(err.Error(), "required")):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			case err != nil:
+				http.Error(w, "failed to clear lockout", http.StatusInternalServerError)
+				return
+			}
+			log.Info("login lockout cleared", "actor", u.Email, "kind", kind, "key", key)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+}
@@ -0,0 +1,83 @@
+package httpserver
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/modules/iam"
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+func TestLogin_LockoutPerAddress(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Config{
+		Env:                   "test",
+		DataDir:               t.TempDir(),
+		SessionCookieName:     "aipanel_session",
+		SessionTTL:            time.Hour,
+		LoginMaxFailures:      5,
+		LoginMaxFailuresPerIP: 3,
+		LoginFailureWindow:    15 * time.Minute,
+		LoginLockout:          15 * time.Minute,
+	}
+	store := sqlite.New(cfg.DataDir)
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init sqlite: %v", err)
+	}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	iamSvc := iam.NewService(store, cfg, log)
+	if err := iamSvc.CreateAdmin(ctx, "admin@example.com", "supersecret123"); err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+	handler := NewHandler(cfg, log, iamSvc, nil, nil)
+
+	login := func(remoteAddr, email, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/login",
+			strings.NewReader(`{"email":"`+email+`","password":"`+password+`"}`))
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		if rec := login("203.0.113.9:5000", email, "guess"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", rec.Code)
+		}
+	}
+	rec := login("203.0.113.9:5001", "admin@example.com", "supersecret123")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "900" {
+		t.Fatalf("expected 429 with Retry-After, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	rec = login("198.51.100.4:5000", "admin@example.com", "supersecret123")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected another address to log in, got %d", rec.Code)
+	}
+	admin := rec.Result().Cookies()[0].Value
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+admin)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := do(http.MethodGet, "/api/admin/security/lockouts"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"key":"203.0.113.9"`) {
+		t.Fatalf("expected the address in lockouts, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodDelete, "/api/admin/security/lockouts?kind=ip&key=192.0.2.1"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown lockout, got %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/api/admin/security/lockouts?kind=ip&key=203.0.113.9"); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := login("203.0.113.9:5002", "admin@example.com", "supersecret123"); rec.Code != http.StatusOK {
+		t.Fatalf("expected login after clearing the lockout, got %d", rec.Code)
+	}
+}
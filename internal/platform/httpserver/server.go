@@ -4,22 +4,80 @@ package httpserver
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"log/slog"
+	"math"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	aipanel "github.com/robsonek/aiPanel"
+	"github.com/robsonek/aiPanel/internal/modules/backup"
 	"github.com/robsonek/aiPanel/internal/modules/database"
+	"github.com/robsonek/aiPanel/internal/modules/firewall"
 	"github.com/robsonek/aiPanel/internal/modules/hosting"
 	"github.com/robsonek/aiPanel/internal/modules/iam"
+	"github.com/robsonek/aiPanel/internal/modules/mailqueue"
+	"github.com/robsonek/aiPanel/internal/modules/migration"
+	"github.com/robsonek/aiPanel/internal/modules/monitor"
+	"github.com/robsonek/aiPanel/internal/modules/monitoring"
+	"github.com/robsonek/aiPanel/internal/modules/proxies"
+	"github.com/robsonek/aiPanel/internal/modules/system"
+	"github.com/robsonek/aiPanel/internal/modules/versionmgr"
 	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/internal/platform/mailer"
+	"github.com/robsonek/aiPanel/internal/platform/metrics"
 	"github.com/robsonek/aiPanel/internal/platform/middleware"
+	"github.com/robsonek/aiPanel/internal/platform/ports"
+	"github.com/robsonek/aiPanel/internal/platform/selfupdate"
+	"github.com/robsonek/aiPanel/internal/platform/settings"
+	"github.com/robsonek/aiPanel/internal/platform/templates"
 )
 
+// HandlerOptions wires optional services into NewHandler.
+type HandlerOptions struct {
+	Mailer     *mailer.Mailer
+	VersionMgr *versionmgr.Service
+	Monitoring *monitoring.Service
+	// Monitor serves the resource samples of the dashboard.
+	Monitor *monitor.Service
+	// PanelDomain lets the setup wizard and /api/settings/panel-domain
+	// move the panel to a domain; without it the wizard only creates the
+	// admin.
+	PanelDomain PanelDomainFunc
+	// Templates exposes the versioned nginx/php-fpm templates.
+	Templates *templates.Store
+	// Proxies manages reverse proxy hosts that are not sites.
+	Proxies *proxies.Service
+	// MailQueue exposes the Postfix queue and delivery log; its routes
+	// answer 409 on hosts without the mail module.
+	MailQueue *mailqueue.Service
+	// Ports lists the TCP ports reserved for panel-managed services.
+	Ports *ports.Allocator
+	// System manages host settings such as the clock.
+	System *system.Service
+	// Backups serves incremental file backups of site docroots.
+	Backups *backup.Service
+	// Migrations receives sites migrated from another panel.
+	Migrations *migration.Service
+	// Firewall manages the nftables rules of the host.
+	Firewall *firewall.Service
+	// Updater reports the running and the latest panel release.
+	Updater *selfupdate.Updater
+	// Settings holds the module settings edited under /api/settings/.
+	Settings *settings.Store
+	// Jobs runs operations approved under two-person approval; without
+	// it approving a held operation answers 503.
+	Jobs *jobqueue.Queue
+}
+
 // NewHandler creates the root HTTP handler for panel API and frontend.
 func NewHandler(
 	cfg config.Config,
@@ -27,7 +85,12 @@ func NewHandler(
 	iamSvc *iam.Service,
 	hostingSvc *hosting.Service,
 	databaseSvc *database.Service,
+	opts ...HandlerOptions,
 ) http.Handler {
+	var opt HandlerOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
 	mux := http.NewServeMux()
 	hostingHandler := hosting.NewHandler(hostingSvc)
 	databaseHandler := database.NewHandler(databaseSvc)
@@ -51,7 +114,13 @@ func NewHandler(
 		}
 
 		session, err := iamSvc.Login(r.Context(), req.Email, req.Password)
-		if err != nil {
+		var locked *iam.LockoutError
+		switch {
+		case errors.As(err, &locked):
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+			http.Error(w, "too many failed logins, try again later", http.StatusTooManyRequests)
+			return
+		case err != nil:
 			http.Error(w, "invalid credentials", http.StatusUnauthorized)
 			return
 		}
@@ -73,6 +142,12 @@ func NewHandler(
 		})
 	})
 
+	panelDomain := &panelDomainState{}
+	registerSetupRoutes(mux, cfg, log, iamSvc, opt.PanelDomain, panelDomain)
+	if opt.PanelDomain != nil {
+		registerPanelDomainRoutes(mux, cfg, log, iamSvc, opt.PanelDomain, panelDomain)
+	}
+
 	mux.Handle("/api/auth/logout", requireAuth(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -108,6 +183,20 @@ func NewHandler(
 		writeJSON(w, http.StatusOK, map[string]any{"user": u})
 	})))
 
+	// /api/auth/verify answers nginx auth_request subrequests guarding the
+	// database admin tools; any method is accepted since nginx forwards the
+	// method of the original request. The tools reach every database, so
+	// organization members are turned away.
+	mux.Handle("/api/auth/verify", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := userFromContext(r.Context())
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("X-Aipanel-User", u.Email)
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
 	mux.Handle("/api/admin/ping", requireAuth(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -118,7 +207,7 @@ func NewHandler(
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
-		if u.Role != "admin" {
+		if !u.AllowsAdmin() {
 			http.Error(w, "forbidden", http.StatusForbidden)
 			return
 		}
@@ -126,12 +215,28 @@ func NewHandler(
 	})))
 
 	if hostingSvc != nil {
-		mux.Handle("/api/sites", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Site routes are open to organization members; authorizeSite
+		// checks their role on the site.
+		mux.Handle("/api/sites", requireAuth(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			u, _ := userFromContext(r.Context())
+			if r.Method == http.MethodGet {
+				ids, all, err := iamSvc.VisibleSites(r.Context(), u)
+				if err != nil {
+					http.Error(w, "failed to list sites", http.StatusInternalServerError)
+					return
+				}
+				if !all {
+					listVisibleSites(w, r, hostingSvc, ids)
+					return
+				}
+			} else if !u.AllowsAdmin() {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
 			hostingHandler.HandleSites(w, r, u.Email)
 		})))
 
-		mux.Handle("/api/sites/", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Handle("/api/sites/", requireAuth(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			u, _ := userFromContext(r.Context())
 			if strings.HasSuffix(strings.Trim(r.URL.Path, "/"), "databases") {
 				if databaseSvc == nil {
@@ -143,46 +248,495 @@ func NewHandler(
 					http.Error(w, "invalid site id", http.StatusBadRequest)
 					return
 				}
+				if !authorizeSite(w, r, iamSvc, siteID, siteAction(r.Method, "databases")) {
+					return
+				}
 				databaseHandler.HandleSiteDatabases(w, r, siteID, u.Email)
 				return
 			}
+			if siteID, sub, err := hosting.ParseSiteSubresource(r.URL.Path); err == nil {
+				if !authorizeSite(w, r, iamSvc, siteID, siteAction(r.Method, sub)) {
+					return
+				}
+				switch sub {
+				case "deliverability":
+					hostingHandler.HandleSiteDeliverability(w, r, siteID)
+				case "health":
+					hostingHandler.HandleSiteHealth(w, r, siteID)
+				case "activity":
+					hostingHandler.HandleSiteActivity(w, r, siteID)
+				case "registrar":
+					hostingHandler.HandleSiteRegistrar(w, r, siteID, u.Email)
+				case "cloudflare":
+					hostingHandler.HandleSiteCloudflare(w, r, siteID, u.Email)
+				case "cloudflare/purge":
+					hostingHandler.HandleSiteCloudflarePurge(w, r, siteID, u.Email)
+				case "storage":
+					hostingHandler.HandleSiteStorage(w, r, siteID, u.Email)
+				case "limits":
+					hostingHandler.HandleSiteLimits(w, r, siteID, u.Email)
+				case "page-cache":
+					hostingHandler.HandleSitePageCache(w, r, siteID, u.Email)
+				case "page-cache/purge":
+					hostingHandler.HandleSitePageCachePurge(w, r, siteID, u.Email)
+				case "memcached":
+					hostingHandler.HandleSiteMemcached(w, r, siteID, u.Email)
+				case "env":
+					hostingHandler.HandleSiteEnv(w, r, siteID)
+				case "isolation":
+					hostingHandler.HandleSiteIsolation(w, r, siteID, u.Email)
+				case "sftp":
+					hostingHandler.HandleSiteSFTP(w, r, siteID, u.Email)
+				case "canonical-host":
+					hostingHandler.HandleSiteCanonicalHost(w, r, siteID, u.Email)
+				case "php-version":
+					hostingHandler.HandleSitePHPVersion(w, r, siteID, u.Email)
+				case "terminal":
+					hostingHandler.HandleSiteTerminal(w, r, siteID, u.Email)
+				default:
+					if sub == "cron" || strings.HasPrefix(sub, "cron/") {
+						hostingHandler.HandleSiteCron(w, r, siteID, sub, u.Email)
+						return
+					}
+					if sub == "workers" || strings.HasPrefix(sub, "workers/") {
+						hostingHandler.HandleSiteWorkers(w, r, siteID, sub, u.Email)
+						return
+					}
+					if sub == "php-fpm" || strings.HasPrefix(sub, "php-fpm/") {
+						hostingHandler.HandleSitePHPFPM(w, r, siteID, sub)
+						return
+					}
+					if sub == "error-budget" || strings.HasPrefix(sub, "error-budget/") {
+						hostingHandler.HandleSiteErrorBudget(w, r, siteID, sub, u.Email)
+						return
+					}
+					if sub == "wordpress" || strings.HasPrefix(sub, "wordpress/") {
+						hostingHandler.HandleSiteWordPress(w, r, siteID, sub, u.Email)
+						return
+					}
+					if sub == "backups" || strings.HasPrefix(sub, "backups/") {
+						if opt.Backups == nil {
+							http.Error(w, "backup service unavailable", http.StatusServiceUnavailable)
+							return
+						}
+						if parts := strings.Split(sub, "/"); r.Method == http.MethodPost && len(parts) == 3 && parts[2] == "restore" &&
+							holdForApproval(w, r, iamSvc, opSiteBackupRestore, fmt.Sprintf("site %d snapshot %s", siteID, parts[1]), siteID, parts[1]) {
+							return
+						}
+						backup.NewHandler(opt.Backups).HandleSiteBackups(w, r, siteID, sub, u.Email)
+						return
+					}
+					http.NotFound(w, r)
+				}
+				return
+			}
 			siteID, err := hosting.ParseSiteID(r.URL.Path)
 			if err != nil {
 				http.Error(w, "invalid site id", http.StatusBadRequest)
 				return
 			}
+			if !authorizeSite(w, r, iamSvc, siteID, siteAction(r.Method, "")) {
+				return
+			}
+			if r.Method == http.MethodDelete && holdForApproval(w, r, iamSvc, opSiteDelete, fmt.Sprintf("site %d", siteID), siteID, "") {
+				return
+			}
 			hostingHandler.HandleSiteByID(w, r, siteID, u.Email)
 		})))
+
+		mux.Handle("/api/tls/account", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _ := userFromContext(r.Context())
+			hostingHandler.HandleACMEAccount(w, r, u.Email)
+		})))
+		mux.Handle("/api/tls/certificates", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _ := userFromContext(r.Context())
+			hostingHandler.HandleCertificates(w, r, u.Email)
+		})))
+		mux.Handle("/api/tls/failures", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hostingHandler.HandleCertificateFailures(w, r)
+		})))
+		mux.Handle("/api/templates/preview", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hostingHandler.HandleTemplatePreview(w, r)
+		})))
+		templateRolloutsRoute := requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _ := userFromContext(r.Context())
+			hostingHandler.HandleTemplateRollouts(w, r, u.Email)
+		}))
+		mux.Handle("/api/templates/rollouts", templateRolloutsRoute)
+		mux.Handle("/api/templates/rollouts/", templateRolloutsRoute)
+		mux.Handle("/api/settings/catchall", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _ := userFromContext(r.Context())
+			hostingHandler.HandleCatchAll(w, r, u.Email)
+		})))
+		mux.Handle("/api/system/php-versions", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(hostingHandler.HandlePHPVersions)))
+		phpUpgradesRoute := requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _ := userFromContext(r.Context())
+			hostingHandler.HandlePHPUpgrades(w, r, u.Email)
+		}))
+		mux.Handle("/api/system/php-upgrades", phpUpgradesRoute)
+		mux.Handle("/api/system/php-upgrades/", phpUpgradesRoute)
+		mux.Handle("/api/domains/availability", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(hostingHandler.HandleDomainAvailability)))
 	}
 
 	if databaseSvc != nil {
-		mux.Handle("/api/databases/engines", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Handle("/api/databases/engines", requireAuth(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			databaseHandler.HandleDatabaseEngines(w, r)
 		})))
 
-		mux.Handle("/api/databases/", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Handle("/api/databases/", requireAuth(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			u, _ := userFromContext(r.Context())
+			if !authorizeDatabase(w, r, iamSvc, databaseSvc) {
+				return
+			}
+			if id, sub, err := database.ParseDatabaseSubresource(r.URL.Path); err == nil {
+				switch sub {
+				case "stats":
+					databaseHandler.HandleDatabaseStats(w, r, id)
+				case "activity":
+					databaseHandler.HandleDatabaseActivity(w, r, id)
+				case "connection":
+					databaseHandler.HandleDatabaseConnection(w, r, id, u.Email)
+				case "backup-schedule":
+					databaseHandler.HandleBackupSchedule(w, r, id, u.Email)
+				case "dumps":
+					databaseHandler.HandleDumps(w, r, id)
+				case "backup":
+					databaseHandler.HandleDatabaseBackup(w, r, id, u.Email)
+				case "download":
+					databaseHandler.HandleDatabaseDownload(w, r, id, u.Email)
+				case "pitr":
+					databaseHandler.HandleRecoveryWindow(w, r, id)
+				case "restore":
+					if r.Method == http.MethodPost && holdForApproval(w, r, iamSvc, opDatabaseRestore, fmt.Sprintf("database %d", id), id, "") {
+						return
+					}
+					databaseHandler.HandleRestore(w, r, id, u.Email)
+				case "clone":
+					databaseHandler.HandleDatabaseClone(w, r, id, u.Email)
+				default:
+					http.NotFound(w, r)
+				}
+				return
+			}
 			id, err := database.ParseDatabaseID(r.URL.Path)
 			if err != nil {
 				http.Error(w, "invalid database id", http.StatusBadRequest)
 				return
 			}
+			if r.Method == http.MethodDelete && holdForApproval(w, r, iamSvc, opDatabaseDelete, fmt.Sprintf("database %d", id), id, "") {
+				return
+			}
 			databaseHandler.HandleDatabaseByID(w, r, id, u.Email)
 		})))
 	}
 
+	if opt.VersionMgr != nil {
+		versionHandler := versionmgr.NewHandler(opt.VersionMgr)
+		mux.Handle("/api/system/runtime/components", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			versionHandler.HandleComponents(w, r)
+		})))
+		mux.Handle("/api/system/runtime/components/", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _ := userFromContext(r.Context())
+			component, action, err := versionmgr.ParseComponentAction(r.URL.Path)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			versionHandler.HandleComponentAction(w, r, component, action, u.Email)
+		})))
+		mux.Handle("/api/system/runtime/jobs/", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, err := versionmgr.ParseJobID(r.URL.Path)
+			if err != nil {
+				http.Error(w, "invalid job id", http.StatusBadRequest)
+				return
+			}
+			versionHandler.HandleJob(w, r, id)
+		})))
+		mux.Handle("/api/system/admin-tools", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			versionHandler.HandleAdminTools(w, r)
+		})))
+		mux.Handle("/api/system/admin-tools/", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _ := userFromContext(r.Context())
+			name, action, err := versionmgr.ParseAdminToolAction(r.URL.Path)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			versionHandler.HandleAdminToolAction(w, r, name, action, u.Email)
+		})))
+	}
+
+	if opt.Monitoring != nil {
+		monitoringHandler := monitoring.NewHandler(opt.Monitoring)
+		mux.Handle("/api/system/self-test", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(monitoringHandler.HandleSelfTest)))
+		mux.Handle("/api/system/stats", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(monitoringHandler.HandleStats)))
+	}
+
+	if opt.Monitor != nil {
+		monitorHandler := monitor.NewHandler(opt.Monitor)
+		mux.Handle("/api/monitor/summary", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(monitorHandler.HandleSummary)))
+		mux.Handle("/api/monitor/history", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(monitorHandler.HandleHistory)))
+	}
+
+	if opt.Updater != nil {
+		// GET /api/system/version reports the running and the latest panel
+		// release for the update banner. A failed manifest fetch still
+		// answers with the running version.
+		mux.Handle("/api/system/version", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			status, err := opt.Updater.Status(r.Context())
+			resp := map[string]any{"version": status}
+			if err != nil {
+				log.Warn("check panel release", "error", err)
+				resp["error"] = err.Error()
+			}
+			writeJSON(w, http.StatusOK, resp)
+		})))
+	}
+
+	if opt.System != nil {
+		systemHandler := system.NewHandler(opt.System)
+		mux.Handle("/api/system/time", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _ := userFromContext(r.Context())
+			systemHandler.HandleTime(w, r, u.Email)
+		})))
+		updatesRoute := requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _ := userFromContext(r.Context())
+			systemHandler.HandleUpdates(w, r, u.Email)
+		}))
+		mux.Handle("/api/system/updates", updatesRoute)
+		mux.Handle("/api/system/updates/", updatesRoute)
+		powerRoute := requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _ := userFromContext(r.Context())
+			systemHandler.HandlePower(w, r, u.Email)
+		}))
+		mux.Handle("/api/system/power", powerRoute)
+		mux.Handle("/api/system/power/", powerRoute)
+		retentionRoute := requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _ := userFromContext(r.Context())
+			systemHandler.HandleRetention(w, r, u.Email)
+		}))
+		mux.Handle("/api/system/retention", retentionRoute)
+		mux.Handle("/api/system/retention/", retentionRoute)
+	}
+
+	if opt.Backups != nil {
+		backupsRoute := requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _ := userFromContext(r.Context())
+			if parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/backups"), "/"), "/"); r.Method == http.MethodPost && len(parts) == 3 && parts[0] == "runs" && parts[2] == "restore" {
+				if runID, err := strconv.ParseInt(parts[1], 10, 64); err == nil && runID > 0 &&
+					holdForApproval(w, r, iamSvc, opBackupRunRestore, fmt.Sprintf("backup run %d", runID), runID, "") {
+					return
+				}
+			}
+			backup.NewHandler(opt.Backups).HandleBackups(w, r, u.Email)
+		}))
+		mux.Handle("/api/backups", backupsRoute)
+		mux.Handle("/api/backups/", backupsRoute)
+	}
+
+	if opt.Migrations != nil {
+		migrationsRoute := requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _ := userFromContext(r.Context())
+			migration.NewHandler(opt.Migrations).HandleMigrations(w, r, u.Email)
+		}))
+		mux.Handle("/api/migrations", migrationsRoute)
+		mux.Handle("/api/migrations/", migrationsRoute)
+	}
+
+	if opt.Ports != nil {
+		// GET /api/system/ports lists reservations with their live
+		// listener state.
+		mux.Handle("/api/system/ports", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			list, err := opt.Ports.List(r.Context())
+			if err != nil {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"ports": list})
+		})))
+	}
+
+	if opt.Proxies != nil {
+		proxiesHandler := proxies.NewHandler(opt.Proxies)
+		mux.Handle("/api/proxies", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _ := userFromContext(r.Context())
+			proxiesHandler.HandleProxies(w, r, u.Email)
+		})))
+		mux.Handle("/api/proxies/", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _ := userFromContext(r.Context())
+			proxiesHandler.HandleProxyByID(w, r, u.Email)
+		})))
+	}
+
+	if opt.Firewall != nil {
+		firewallHandler := firewall.NewHandler(opt.Firewall)
+		mux.Handle("/api/firewall", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(firewallHandler.HandleStatus)))
+		mux.Handle("/api/firewall/confirm", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _ := userFromContext(r.Context())
+			firewallHandler.HandleConfirm(w, r, u.Email)
+		})))
+		mux.Handle("/api/firewall/revert", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _ := userFromContext(r.Context())
+			firewallHandler.HandleRevert(w, r, u.Email)
+		})))
+		mux.Handle("/api/firewall/rules", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _ := userFromContext(r.Context())
+			firewallHandler.HandleRules(w, r, u.Email)
+		})))
+		mux.Handle("/api/firewall/rules/", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _ := userFromContext(r.Context())
+			firewallHandler.HandleRuleByID(w, r, u.Email)
+		})))
+	}
+
+	if opt.MailQueue != nil {
+		mailQueueHandler := mailqueue.NewHandler(opt.MailQueue)
+		queueRoute := requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _ := userFromContext(r.Context())
+			mailQueueHandler.HandleQueue(w, r, u.Email)
+		}))
+		mux.Handle("/api/mail/queue", queueRoute)
+		mux.Handle("/api/mail/queue/", queueRoute)
+		mux.Handle("/api/mail/log", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(mailQueueHandler.HandleLog)))
+	}
+
+	registerOrgRoutes(mux, cfg, log, iamSvc)
+	registerTokenRoutes(mux, cfg, log, iamSvc)
+	registerImpersonationRoutes(mux, cfg, log, iamSvc)
+	registerSecurityRoutes(mux, cfg, log, iamSvc)
+	registerApprovalRoutes(mux, cfg, log, iamSvc, approvalRunners(hostingSvc, databaseSvc, opt.Backups), opt.Jobs)
+	if hostingSvc != nil {
+		registerDashboardRoutes(mux, cfg, iamSvc, hostingSvc, opt.Backups)
+	}
+
+	if opt.Mailer != nil {
+		registerSMTPRoutes(mux, cfg, log, iamSvc, opt.Mailer)
+	}
+	if opt.Settings != nil {
+		registerSettingsRoutes(mux, cfg, log, iamSvc, opt.Settings)
+	}
+
+	if opt.Templates != nil {
+		var apply templateApplier
+		if hostingSvc != nil {
+			apply = func(ctx context.Context, name, actor string) error {
+				if !hosting.IsCatchAllTemplate(name) {
+					return nil
+				}
+				_, err := hostingSvc.ApplyCatchAll(ctx, actor)
+				return err
+			}
+		}
+		registerTemplateRoutes(mux, cfg, log, iamSvc, opt.Templates, apply)
+	}
+
 	frontend := frontendHandler(cfg, log)
 	mux.Handle("/", frontend)
 
+	// Load validated the entries, so parsing cannot fail here.
+	trustedProxies, _ := middleware.ParseTrustedProxies(cfg.TrustedProxies)
 	return middleware.Chain(
 		mux,
+		middleware.RealIPMiddleware(trustedProxies),
 		middleware.RequestIDMiddleware,
-		middleware.LoggingMiddleware(log),
+		middleware.LoggingMiddlewareWithOptions(log, middleware.LoggingOptions{
+			LogBodies:  cfg.LogRequestBodies,
+			SampleRate: cfg.LogSampleRate,
+			Metrics:    metrics.Default,
+		}),
+		middleware.BodyLimitMiddleware(bodyLimits(cfg)),
+		middleware.TimeoutMiddleware(middleware.TimeoutOptions{Budget: requestBudget(cfg)}),
 		middleware.CORSMiddleware,
 		middleware.RecoveryMiddleware(log),
+		compressMiddleware(cfg),
 	)
 }
 
+// uploadPaths are the multipart endpoints (backup import, file manager)
+// that may take max_upload_mb instead of max_request_body_mb. Handlers
+// behind them receive files with upload.Handler. Incoming migrations
+// upload in pieces but send the whole docroot listing in one plan.
+var uploadPaths = []string{"/api/backups/import", "/api/files/upload", "/api/migrations/"}
+
+func bodyLimits(cfg config.Config) middleware.BodyLimitOptions {
+	opts := middleware.BodyLimitOptions{
+		MaxBytes:  int64(cfg.MaxRequestBodyMB) << 20,
+		Overrides: map[string]int64{},
+	}
+	for _, path := range uploadPaths {
+		opts.Overrides[path] = int64(cfg.MaxUploadMB) << 20
+	}
+	return opts
+}
+
+// provisioningPaths run nginx, certbot, package or database tools when
+// called with a mutating method and get the provisioning budget. Work that
+// outlives it belongs on the job queue.
+var provisioningPaths = []string{
+	"/api/sites",
+	"/api/tls/",
+	"/api/proxies",
+	"/api/firewall",
+	"/api/databases/",
+	"/api/templates/preview",
+	"/api/system/runtime/",
+	"/api/system/admin-tools/",
+	"/api/system/self-test",
+	"/api/system/updates",
+	"/api/system/retention/prune",
+	"/api/settings/smtp/test",
+	"/api/setup",
+}
+
+// requestBudget picks the timeout of a request: none for WebSocket
+// upgrades and file downloads, the provisioning budget for uploads and
+// provisioning calls, and request_timeout_seconds for everything else.
+func requestBudget(cfg config.Config) func(*http.Request) time.Duration {
+	return func(r *http.Request) time.Duration {
+		path := r.URL.Path
+		switch {
+		case strings.EqualFold(r.Header.Get("Upgrade"), "websocket"),
+			r.Method == http.MethodGet && strings.HasSuffix(path, "/download"):
+			return 0
+		case hasAnyPrefix(path, uploadPaths):
+			return cfg.ProvisioningTimeout
+		case r.Method != http.MethodGet && r.Method != http.MethodHead && hasAnyPrefix(path, provisioningPaths):
+			return cfg.ProvisioningTimeout
+		// Reading WordPress state runs wp-cli several times and asks
+		// wordpress.org for core updates.
+		case strings.HasPrefix(path, "/api/sites/") && strings.HasSuffix(strings.TrimSuffix(path, "/"), "/wordpress"):
+			return cfg.ProvisioningTimeout
+		default:
+			return cfg.RequestTimeout
+		}
+	}
+}
+
+func hasAnyPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressMiddleware gzips responses unless compress_responses is off.
+func compressMiddleware(cfg config.Config) func(http.Handler) http.Handler {
+	if !cfg.CompressResponses {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return middleware.CompressMiddleware(middleware.CompressOptions{Types: cfg.CompressTypes})
+}
+
 type userCtxKey string
 
 const authUserKey userCtxKey = "auth_user"
@@ -196,6 +750,10 @@ func requireAuth(iamSvc *iam.Service, cookieName string, next http.Handler) http
 			return
 		}
 		ctx := context.WithValue(r.Context(), authUserKey, user)
+		if user.Impersonator != "" {
+			ctx = middleware.WithImpersonator(ctx, user.Impersonator)
+			w.Header().Set(impersonatorHeader, user.Impersonator)
+		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -207,7 +765,7 @@ func requireAdmin(iamSvc *iam.Service, cookieName string, next http.Handler) htt
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
-		if u.Role != "admin" {
+		if !u.AllowsAdmin() {
 			http.Error(w, "forbidden", http.StatusForbidden)
 			return
 		}
@@ -0,0 +1,100 @@
+package httpserver
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/modules/hosting"
+	"github.com/robsonek/aiPanel/internal/modules/iam"
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+const (
+	benchAdminEmail    = "bench@example.com"
+	benchAdminPassword = "benchmark-password"
+)
+
+func newBenchHandler(b *testing.B) (http.Handler, *http.Cookie) {
+	b.Helper()
+	cfg := config.Config{
+		Addr:              ":8080",
+		Env:               "test",
+		DataDir:           b.TempDir(),
+		SessionCookieName: "aipanel_session",
+		SessionTTL:        time.Hour,
+	}
+	store := sqlite.New(cfg.DataDir)
+	if err := store.Init(context.Background()); err != nil {
+		b.Fatalf("init sqlite: %v", err)
+	}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	iamSvc := iam.NewService(store, cfg, log)
+	if err := iamSvc.CreateAdmin(context.Background(), benchAdminEmail, benchAdminPassword); err != nil {
+		b.Fatalf("create admin: %v", err)
+	}
+	hostingSvc := hosting.NewService(store, cfg, log, nil, nil, nil)
+	handler := NewHandler(cfg, log, iamSvc, hostingSvc, nil)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newLoginRequest())
+	if rec.Code != http.StatusOK {
+		b.Fatalf("login: expected 200, got %d", rec.Code)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) == 0 {
+		b.Fatal("login: missing session cookie")
+	}
+	return handler, cookies[0]
+}
+
+func newLoginRequest() *http.Request {
+	body := `{"email":"` + benchAdminEmail + `","password":"` + benchAdminPassword + `"}`
+	return httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(body))
+}
+
+func BenchmarkLogin(b *testing.B) {
+	handler, _ := newBenchHandler(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, newLoginRequest())
+		if rec.Code != http.StatusOK {
+			b.Fatalf("expected 200, got %d", rec.Code)
+		}
+	}
+}
+
+func BenchmarkAuthenticatedMe(b *testing.B) {
+	handler, cookie := newBenchHandler(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/me", nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			b.Fatalf("expected 200, got %d", rec.Code)
+		}
+	}
+}
+
+func BenchmarkAuthenticatedListSites(b *testing.B) {
+	handler, cookie := newBenchHandler(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/api/sites", nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			b.Fatalf("expected 200, got %d", rec.Code)
+		}
+	}
+}
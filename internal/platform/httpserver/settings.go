@@ -0,0 +1,109 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/modules/iam"
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/jsonstream"
+	"github.com/robsonek/aiPanel/internal/platform/mailer"
+	"github.com/robsonek/aiPanel/internal/platform/settings"
+)
+
+func registerSMTPRoutes(mux *http.ServeMux, cfg config.Config, log *slog.Logger, iamSvc *iam.Service, m *mailer.Mailer) {
+	// POST /api/settings/smtp/test sends a test message; "to" defaults to the
+	// caller's own address.
+	mux.Handle("/api/settings/smtp/test", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		u, _ := userFromContext(r.Context())
+		var req struct {
+			To string `json:"to"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		to := strings.TrimSpace(req.To)
+		if to == "" {
+			to = u.Email
+		}
+		if err := m.SendTest(r.Context(), to); err != nil {
+			status := http.StatusBadGateway
+			if errors.Is(err, mailer.ErrNotConfigured) {
+				status = http.StatusServiceUnavailable
+			}
+			writeJSON(w, status, map[string]string{"status": "failed", "error": err.Error()})
+			return
+		}
+		log.Info("smtp test message sent", "actor", u.Email, "to", to)
+		writeJSON(w, http.StatusOK, map[string]string{"status": "sent", "to": to})
+	})))
+
+	mux.Handle("/api/settings/smtp/failures", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		failures, err := m.ListFailures(r.Context(), limit)
+		if err != nil {
+			http.Error(w, "failed to list mail failures", http.StatusInternalServerError)
+			return
+		}
+		jsonstream.List(w, r, "failures", failures)
+	})))
+}
+
+func registerSettingsRoutes(mux *http.ServeMux, cfg config.Config, log *slog.Logger, iamSvc *iam.Service, store *settings.Store) {
+	// GET /api/settings/{namespace} returns the namespace settings with
+	// their declarations; PUT/PATCH takes a partial object of keys to change,
+	// null resetting a key to its panel.yaml default. Paths registered more
+	// specifically (smtp, catchall, templates) keep their own handlers.
+	mux.Handle("/api/settings/", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/api/settings/")
+		ns, err := store.Namespace(name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			values, err := store.Get(r.Context(), ns.Name)
+			if err != nil {
+				http.Error(w, "failed to read settings", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"namespace": ns.Name, "settings": values, "fields": ns.Fields})
+		case http.MethodPut, http.MethodPatch:
+			u, _ := userFromContext(r.Context())
+			var changes map[string]json.RawMessage
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&changes); err != nil {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+			values, err := store.Update(r.Context(), ns.Name, changes, u.Email)
+			var invalid *settings.ValidationError
+			switch {
+			case errors.As(err, &invalid):
+				writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid settings", "fields": invalid.Fields})
+				return
+			case err != nil:
+				http.Error(w, "failed to update settings", http.StatusInternalServerError)
+				return
+			}
+			log.Info("settings updated", "actor", u.Email, "namespace", ns.Name)
+			writeJSON(w, http.StatusOK, map[string]any{"namespace": ns.Name, "settings": values})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+}
@@ -0,0 +1,127 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/modules/iam"
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/mailer"
+	"github.com/robsonek/aiPanel/internal/platform/settings"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+// newAdminTestHandler builds a handler over a fresh store and returns it with
+// an authenticated admin session cookie. build receives the store so optional
+// services can share it.
+func newAdminTestHandler(t *testing.T, build func(cfg config.Config, store *sqlite.Store) HandlerOptions) (http.Handler, *http.Cookie) {
+	t.Helper()
+	cfg := config.Config{
+		Addr:              ":8080",
+		Env:               "test",
+		DataDir:           t.TempDir(),
+		SessionCookieName: "aipanel_session",
+		SessionTTL:        time.Hour,
+		SMTPPort:          587,
+		SMTPTLSMode:       mailer.TLSModeNone,
+	}
+	store := sqlite.New(cfg.DataDir)
+	if err := store.Init(context.Background()); err != nil {
+		t.Fatalf("init sqlite: %v", err)
+	}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	iamSvc := iam.NewService(store, cfg, log)
+	if err := iamSvc.CreateAdmin(context.Background(), "admin@example.com", "supersecret123"); err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+	handler := NewHandler(cfg, log, iamSvc, nil, nil, build(cfg, store))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/auth/login",
+		strings.NewReader(`{"email":"admin@example.com","password":"supersecret123"}`)))
+	if rec.Code != http.StatusOK || len(rec.Result().Cookies()) == 0 {
+		t.Fatalf("login: expected 200 with cookie, got %d", rec.Code)
+	}
+	return handler, rec.Result().Cookies()[0]
+}
+
+func TestSMTPTestEndpoint_RecordsFailureWhenUnconfigured(t *testing.T) {
+	handler, cookie := newAdminTestHandler(t, func(cfg config.Config, store *sqlite.Store) HandlerOptions {
+		return HandlerOptions{Mailer: mailer.New(cfg, store, slog.New(slog.NewJSONHandler(io.Discard, nil)))}
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/settings/smtp/test", strings.NewReader(`{}`))
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 for unconfigured relay, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/settings/smtp/failures", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var resp struct {
+		Failures []mailer.Failure `json:"failures"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode failures: %v", err)
+	}
+	if len(resp.Failures) != 1 || resp.Failures[0].Recipient != "admin@example.com" {
+		t.Fatalf("expected failure recorded for caller address, got %+v", resp.Failures)
+	}
+}
+
+func TestSettingsEndpoint(t *testing.T) {
+	handler, cookie := newAdminTestHandler(t, func(cfg config.Config, store *sqlite.Store) HandlerOptions {
+		log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+		return HandlerOptions{
+			Mailer:   mailer.New(cfg, store, log),
+			Settings: settings.New(store, log, settings.Builtin(cfg)...),
+		}
+	})
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodGet, "/api/settings/nope", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown namespace, got %d", rec.Code)
+	}
+	rec := do(http.MethodPatch, "/api/settings/backup", `{"retention": 1000}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"retention"`) {
+		t.Fatalf("expected field error, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPatch, "/api/settings/backup", `{"retention": 14}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = do(http.MethodGet, "/api/settings/backup", "")
+	var resp struct {
+		Settings map[string]any   `json:"settings"`
+		Fields   []settings.Field `json:"fields"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode settings: %v", err)
+	}
+	if resp.Settings["retention"] != float64(14) || len(resp.Fields) != 2 {
+		t.Fatalf("unexpected settings: %+v", resp)
+	}
+	// Specific settings routes keep their handlers.
+	if rec := do(http.MethodGet, "/api/settings/smtp/failures", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected smtp failures route, got %d", rec.Code)
+	}
+}
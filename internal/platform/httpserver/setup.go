@@ -0,0 +1,232 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/modules/iam"
+	"github.com/robsonek/aiPanel/internal/platform/config"
+)
+
+func registerSetupRoutes(mux *http.ServeMux, cfg config.Config, log *slog.Logger, iamSvc *iam.Service, setupDomain PanelDomainFunc, domainState *panelDomainState) {
+	// /setup serves the first-boot wizard until the first admin exists.
+	mux.HandleFunc("/setup", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		required, err := iamSvc.SetupRequired(r.Context())
+		if err != nil {
+			http.Error(w, "failed to check setup state", http.StatusInternalServerError)
+			return
+		}
+		if !required {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		_, _ = io.WriteString(w, setupPage)
+	})
+
+	// GET /api/setup reports whether the wizard is still needed and how the
+	// domain step started by it is going.
+	mux.HandleFunc("/api/setup", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			required, err := iamSvc.SetupRequired(r.Context())
+			if err != nil {
+				http.Error(w, "failed to check setup state", http.StatusInternalServerError)
+				return
+			}
+			resp := map[string]any{"required": required, "domain_supported": setupDomain != nil}
+			if domain := domainState.snapshot(); domain != nil {
+				resp["domain"] = domain
+			}
+			writeJSON(w, http.StatusOK, resp)
+		case http.MethodPost:
+			handleSetup(w, r, cfg, log, iamSvc, setupDomain, domainState)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func handleSetup(
+	w http.ResponseWriter,
+	r *http.Request,
+	cfg config.Config,
+	log *slog.Logger,
+	iamSvc *iam.Service,
+	setupDomain PanelDomainFunc,
+	domainState *panelDomainState,
+) {
+	var req struct {
+		Token            string `json:"token"`
+		Email            string `json:"email"`
+		Password         string `json:"password"`
+		PanelDomain      string `json:"panel_domain"`
+		LetsEncrypt      bool   `json:"lets_encrypt"`
+		LetsEncryptEmail string `json:"lets_encrypt_email"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	domain := strings.ToLower(strings.TrimSpace(req.PanelDomain))
+	if domain != "" && !panelDomainPattern.MatchString(domain) {
+		http.Error(w, "invalid panel domain", http.StatusBadRequest)
+		return
+	}
+	if domain != "" && setupDomain == nil {
+		http.Error(w, "panel domain cannot be configured from this server", http.StatusBadRequest)
+		return
+	}
+	if req.LetsEncrypt && domain == "" {
+		http.Error(w, "lets_encrypt requires panel_domain", http.StatusBadRequest)
+		return
+	}
+	tlsEmail := strings.TrimSpace(req.LetsEncryptEmail)
+	if req.LetsEncrypt && tlsEmail == "" {
+		tlsEmail = strings.TrimSpace(req.Email)
+	}
+
+	session, err := iamSvc.CompleteSetup(r.Context(), req.Token, req.Email, req.Password)
+	switch {
+	case errors.Is(err, iam.ErrSetupComplete):
+		http.Error(w, "setup already completed", http.StatusConflict)
+		return
+	case errors.Is(err, iam.ErrInvalidSetupToken):
+		http.Error(w, "invalid setup token", http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Info("setup completed", "email", session.User.Email, "panel_domain", domain, "lets_encrypt", req.LetsEncrypt)
+	http.SetCookie(w, &http.Cookie{
+		Name:     cfg.SessionCookieName,
+		Value:    session.Token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   useSecureCookie(cfg.Env, r),
+		SameSite: http.SameSiteLaxMode,
+		Expires:  session.ExpiresAt,
+	})
+
+	resp := map[string]any{
+		"user": map[string]any{
+			"id":    session.User.ID,
+			"email": session.User.Email,
+			"role":  session.User.Role,
+		},
+	}
+	if domain != "" {
+		// Certificate issuance takes longer than a request may; the wizard
+		// polls GET /api/setup for the outcome.
+		domainState.start(log, setupDomain, domain, req.LetsEncrypt, tlsEmail)
+		resp["domain"] = domainState.snapshot()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// setupPage is self-contained so the wizard works before the frontend
+// bundle is reachable (e.g. before nginx serves the panel domain). The
+// token travels in the URL fragment, which browsers never send.
+const setupPage = `<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>aiPanel setup</title>
+<style>
+body{font-family:system-ui,sans-serif;max-width:28rem;margin:3rem auto;padding:0 1rem;color:#1f2937}
+label{display:block;margin:.75rem 0 .25rem;font-weight:600}
+input[type=text],input[type=email],input[type=password]{width:100%;padding:.5rem;box-sizing:border-box}
+button{margin-top:1.25rem;padding:.6rem 1rem}
+.hint{color:#6b7280;font-size:.875rem}
+#message{margin-top:1rem;white-space:pre-wrap}
+</style>
+</head>
+<body>
+<h1>aiPanel setup</h1>
+<p class="hint">Create the first admin account. The setup token was printed by the installer.</p>
+<form id="setup">
+<label for="token">Setup token</label>
+<input id="token" type="password" autocomplete="off" required>
+<label for="email">Admin email</label>
+<input id="email" type="email" autocomplete="username" required>
+<label for="password">Password</label>
+<input id="password" type="password" autocomplete="new-password" minlength="10" required>
+<label for="confirm">Confirm password</label>
+<input id="confirm" type="password" autocomplete="new-password" minlength="10" required>
+<div id="domain-fields">
+<label for="domain">Panel domain <span class="hint">(optional)</span></label>
+<input id="domain" type="text" placeholder="panel.example.com">
+<label><input id="tls" type="checkbox"> Issue a Let's Encrypt certificate</label>
+</div>
+<button type="submit">Finish setup</button>
+</form>
+<div id="message"></div>
+<script>
+(function () {
+  var form = document.getElementById("setup");
+  var message = document.getElementById("message");
+  var token = new URLSearchParams(location.hash.slice(1)).get("token");
+  if (token) {
+    document.getElementById("token").value = token;
+    history.replaceState(null, "", location.pathname);
+  }
+  fetch("/api/setup").then(function (r) { return r.json(); }).then(function (s) {
+    if (!s.domain_supported) { document.getElementById("domain-fields").hidden = true; }
+  });
+  function poll() {
+    fetch("/api/setup").then(function (r) { return r.json(); }).then(function (s) {
+      var d = s.domain || {};
+      if (d.status === "running") { setTimeout(poll, 3000); return; }
+      if (d.status === "failed") {
+        message.textContent = "Admin created, but configuring " + d.domain + " failed:\n" + d.error + "\nSign in and retry from the panel.";
+        return;
+      }
+      message.textContent = "Done. Opening the panel at " + d.domain + "...";
+      location.href = (document.getElementById("tls").checked ? "https://" : "http://") + d.domain + "/";
+    });
+  }
+  form.addEventListener("submit", function (e) {
+    e.preventDefault();
+    var password = document.getElementById("password").value;
+    if (password !== document.getElementById("confirm").value) {
+      message.textContent = "Passwords do not match.";
+      return;
+    }
+    var body = {
+      token: document.getElementById("token").value.trim(),
+      email: document.getElementById("email").value.trim(),
+      password: password,
+      panel_domain: document.getElementById("domain").value.trim(),
+      lets_encrypt: document.getElementById("tls").checked
+    };
+    message.textContent = "Saving...";
+    fetch("/api/setup", {method: "POST", headers: {"Content-Type": "application/json"}, body: JSON.stringify(body)})
+      .then(function (r) {
+        if (!r.ok) { return r.text().then(function (t) { throw new Error(t.trim()); }); }
+        return r.json();
+      })
+      .then(function (resp) {
+        form.hidden = true;
+        if (!resp.domain) { location.href = "/"; return; }
+        message.textContent = "Admin created. Configuring " + resp.domain.domain + "...";
+        poll();
+      })
+      .catch(function (err) { message.textContent = err.message; });
+  });
+})();
+</script>
+</body>
+</html>
+`
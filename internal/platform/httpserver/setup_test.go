@@ -0,0 +1,94 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/modules/iam"
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+func TestSetupWizard_CreatesFirstAdminOnce(t *testing.T) {
+	cfg := config.Config{
+		Addr:              ":8080",
+		Env:               "test",
+		DataDir:           t.TempDir(),
+		SessionCookieName: "aipanel_session",
+		SessionTTL:        time.Hour,
+	}
+	store := sqlite.New(cfg.DataDir)
+	if err := store.Init(context.Background()); err != nil {
+		t.Fatalf("init sqlite: %v", err)
+	}
+	token := strings.Repeat("cd", 16)
+	if err := iam.WriteSetupToken(cfg.DataDir, token); err != nil {
+		t.Fatalf("write setup token: %v", err)
+	}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	domains := make(chan string, 1)
+	handler := NewHandler(cfg, log, iam.NewService(store, cfg, log), nil, nil, HandlerOptions{
+		PanelDomain: func(_ context.Context, domain string, letsEncrypt bool, email string) error {
+			domains <- domain + " " + email
+			return nil
+		},
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/setup", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "aiPanel setup") {
+		t.Fatalf("expected the wizard page, got %d", rec.Code)
+	}
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/setup", strings.NewReader(body)))
+		return rec
+	}
+	if rec := post(`{"token":"wrong","email":"admin@example.com","password":"supersecret123"}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a wrong token, got %d", rec.Code)
+	}
+	if rec := post(`{"token":"` + token + `","email":"admin@example.com","password":"supersecret123","panel_domain":"bad domain;"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid domain, got %d", rec.Code)
+	}
+
+	rec = post(`{"token":"` + token + `","email":"admin@example.com","password":"supersecret123","panel_domain":"Panel.Example.com","lets_encrypt":true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(rec.Result().Cookies()) == 0 {
+		t.Fatal("expected a session cookie for the new admin")
+	}
+	select {
+	case got := <-domains:
+		if got != "panel.example.com admin@example.com" {
+			t.Fatalf("unexpected domain setup call: %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the panel domain to be configured")
+	}
+
+	if rec := post(`{"token":"` + token + `","email":"other@example.com","password":"supersecret123"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 once setup is done, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/setup", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected /setup to redirect once setup is done, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/setup", nil))
+	var status struct {
+		Required bool `json:"required"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil || status.Required {
+		t.Fatalf("expected setup not to be required, got %s (%v)", rec.Body.String(), err)
+	}
+}
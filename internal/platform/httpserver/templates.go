@@ -0,0 +1,148 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/modules/iam"
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/templates"
+)
+
+// maxTemplateBytes bounds an imported template.
+const maxTemplateBytes = 1 << 20
+
+// templateApplier regenerates the live config rendered from a changed
+// template; nil leaves regeneration to the next provisioning run.
+type templateApplier func(ctx context.Context, name, actor string) error
+
+func registerTemplateRoutes(mux *http.ServeMux, cfg config.Config, log *slog.Logger, iamSvc *iam.Service, store *templates.Store, apply templateApplier) {
+	// GET /api/settings/templates lists the shipped templates and whether
+	// they were edited or drifted from a newer release.
+	mux.Handle("/api/settings/templates", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		list, err := store.List()
+		if err != nil {
+			http.Error(w, "failed to list templates", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"templates": list})
+	})))
+
+	// /api/settings/templates/{name}: GET exports, PUT imports.
+	// /api/settings/templates/{name}/diff, /merge and /reset work on the
+	// edits relative to the shipped template.
+	mux.Handle("/api/settings/templates/", requireAdmin(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, _ := userFromContext(r.Context())
+		name, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/settings/templates/"), "/")
+		if name == "" || strings.Contains(action, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+			content, status, err := store.Get(name)
+			if err != nil {
+				writeTemplateError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"template": status, "content": content})
+		case action == "" && r.Method == http.MethodPut:
+			var req struct {
+				Content string `json:"content"`
+			}
+			if err := json.NewDecoder(io.LimitReader(r.Body, maxTemplateBytes)).Decode(&req); err != nil {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+			if err := store.Put(name, req.Content); err != nil {
+				writeTemplateError(w, err)
+				return
+			}
+			log.Info("template replaced", "actor", u.Email, "template", name)
+			if !applyTemplate(w, r, apply, name, u.Email) {
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"status": "saved"})
+		case action == "diff" && r.Method == http.MethodGet:
+			diff, err := store.Diff(name)
+			if err != nil {
+				writeTemplateError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, diff)
+		case action == "merge" && r.Method == http.MethodPost:
+			var req struct {
+				Apply bool `json:"apply"`
+			}
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+			merged, conflicts, err := store.Merge(name, req.Apply)
+			resp := map[string]any{"content": merged, "conflicts": conflicts, "applied": false}
+			switch {
+			case errors.Is(err, templates.ErrConflict):
+				writeJSON(w, http.StatusConflict, resp)
+				return
+			case err != nil:
+				writeTemplateError(w, err)
+				return
+			}
+			if req.Apply {
+				resp["applied"] = true
+				log.Info("template merged", "actor", u.Email, "template", name)
+				if !applyTemplate(w, r, apply, name, u.Email) {
+					return
+				}
+			}
+			writeJSON(w, http.StatusOK, resp)
+		case action == "reset" && r.Method == http.MethodPost:
+			if err := store.Reset(name); err != nil {
+				writeTemplateError(w, err)
+				return
+			}
+			log.Info("template reset to shipped version", "actor", u.Email, "template", name)
+			if !applyTemplate(w, r, apply, name, u.Email) {
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"status": "reset"})
+		case action == "" || action == "diff" || action == "merge" || action == "reset":
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		default:
+			http.NotFound(w, r)
+		}
+	})))
+}
+
+// applyTemplate regenerates the config of a changed template. The template
+// stays saved when that fails, so the operator can fix or reset it.
+func applyTemplate(w http.ResponseWriter, r *http.Request, apply templateApplier, name, actor string) bool {
+	if apply == nil {
+		return true
+	}
+	if err := apply(r.Context(), name, actor); err != nil {
+		http.Error(w, "template saved but applying it failed: "+err.Error(), http.StatusUnprocessableEntity)
+		return false
+	}
+	return true
+}
+
+func writeTemplateError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, templates.ErrNotFound):
+		http.Error(w, "template not found", http.StatusNotFound)
+	case errors.Is(err, templates.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "template operation failed", http.StatusInternalServerError)
+	}
+}
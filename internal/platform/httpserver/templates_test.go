@@ -0,0 +1,67 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+	"github.com/robsonek/aiPanel/internal/platform/templates"
+)
+
+func TestTemplateEndpoints_MergeDriftedTemplate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "nginx_vhost.conf.tmpl")
+	store := templates.New(dir)
+	if _, err := store.Install("nginx_vhost.conf.tmpl", path, "listen 80;\nroot {{.Root}};\n"); err != nil {
+		t.Fatalf("install: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("listen 80;\nroot {{.Root}};\nclient_max_body_size 64m;\n"), 0o644); err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+	if _, err := store.Install("nginx_vhost.conf.tmpl", path, "listen 80;\nlisten [::]:80;\nroot {{.Root}};\n"); err != nil {
+		t.Fatalf("upgrade: %v", err)
+	}
+	handler, cookie := newAdminTestHandler(t, func(config.Config, *sqlite.Store) HandlerOptions {
+		return HandlerOptions{Templates: store}
+	})
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodGet, "/api/settings/templates", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"state":"drift"`) {
+		t.Fatalf("expected a drifted template, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodGet, "/api/settings/templates/nope.tmpl/diff", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown template, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPut, "/api/settings/templates/nginx_vhost.conf.tmpl", `{"content":"{{.Root"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unparsable template, got %d", rec.Code)
+	}
+
+	rec = do(http.MethodPost, "/api/settings/templates/nginx_vhost.conf.tmpl/merge", `{"apply":true}`)
+	var merge struct {
+		Content   string `json:"content"`
+		Conflicts int    `json:"conflicts"`
+		Applied   bool   `json:"applied"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &merge); err != nil || rec.Code != http.StatusOK || !merge.Applied {
+		t.Fatalf("expected an applied merge, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if want := "listen 80;\nlisten [::]:80;\nroot {{.Root}};\nclient_max_body_size 64m;\n"; merge.Content != want {
+		t.Fatalf("unexpected merge result:\n%s", merge.Content)
+	}
+	if raw, _ := os.ReadFile(path); string(raw) != merge.Content {
+		t.Fatalf("expected merged template on disk, got:\n%s", raw)
+	}
+}
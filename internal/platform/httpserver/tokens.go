@@ -0,0 +1,86 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/robsonek/aiPanel/internal/modules/iam"
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/jsonstream"
+)
+
+// registerTokenRoutes serves the caller's own API tokens:
+//
+//	GET    /api/tokens
+//	POST   /api/tokens        {"name", "site_ids", "actions", "expires_in_days"}
+//	DELETE /api/tokens/{id}
+//
+// Tokens are managed from a session only, so a leaked token cannot mint
+// or revoke others, and never while impersonating, so an admin cannot
+// leave a credential behind in a user's name.
+func registerTokenRoutes(mux *http.ServeMux, cfg config.Config, log *slog.Logger, iamSvc *iam.Service) {
+	sessionOnly := func(next http.HandlerFunc) http.Handler {
+		return requireAuth(iamSvc, cfg.SessionCookieName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _ := userFromContext(r.Context())
+			if u.Scope != nil {
+				http.Error(w, "api tokens cannot manage api tokens", http.StatusForbidden)
+				return
+			}
+			if u.Impersonator != "" {
+				http.Error(w, "api tokens cannot be managed while impersonating", http.StatusForbidden)
+				return
+			}
+			next(w, r)
+		}))
+	}
+
+	mux.Handle("/api/tokens", sessionOnly(func(w http.ResponseWriter, r *http.Request) {
+		u, _ := userFromContext(r.Context())
+		switch r.Method {
+		case http.MethodGet:
+			tokens, err := iamSvc.ListAPITokens(r.Context(), u)
+			if err != nil {
+				http.Error(w, "failed to list api tokens", http.StatusInternalServerError)
+				return
+			}
+			jsonstream.List(w, r, "tokens", tokens)
+		case http.MethodPost:
+			var req iam.CreateAPITokenRequest
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+			token, secret, err := iamSvc.CreateAPIToken(r.Context(), u, req)
+			if err != nil {
+				writeAccessError(w, err)
+				return
+			}
+			log.Info("api token created", "actor", u.Email, "token_id", token.ID)
+			writeJSON(w, http.StatusCreated, map[string]any{"token": token, "secret": secret})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	mux.Handle("/api/tokens/", sessionOnly(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		u, _ := userFromContext(r.Context())
+		id, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/tokens/"), "/"), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid token id", http.StatusBadRequest)
+			return
+		}
+		if err := iamSvc.RevokeAPIToken(r.Context(), u, id); err != nil {
+			writeAccessError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
@@ -0,0 +1,89 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAPITokens_NarrowAccess(t *testing.T) {
+	srv := newAccessTestServer(t)
+	admin := srv.login("admin@example.com")
+
+	createToken := func(session, body string) string {
+		t.Helper()
+		rec := srv.do(session, http.MethodPost, "/api/tokens", body)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("create token: expected 201, got %d: %s", rec.Code, rec.Body)
+		}
+		var resp struct {
+			Secret string `json:"secret"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || !strings.HasPrefix(resp.Secret, "aipt_") {
+			t.Fatalf("unexpected token response: %+v (%v)", resp, err)
+		}
+		return resp.Secret
+	}
+	if rec := srv.do(admin, http.MethodPost, "/api/tokens", `{"name":"ci","actions":["deploy"]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown action, got %d", rec.Code)
+	}
+
+	purge := createToken(admin, `{"name":"ci","site_ids":[1],"actions":["read","cache.purge"]}`)
+	rec := srv.do(purge, http.MethodGet, "/api/sites", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "client.example") || strings.Contains(rec.Body.String(), "other.example") {
+		t.Fatalf("expected the token's site only, got %d: %s", rec.Code, rec.Body)
+	}
+	for _, tc := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/api/sites/1", http.StatusOK},
+		{http.MethodGet, "/api/sites/2", http.StatusNotFound},
+		{http.MethodPut, "/api/sites/1/canonical-host", http.StatusForbidden},
+		{http.MethodPost, "/api/sites/1/cron", http.StatusForbidden},
+		{http.MethodGet, "/api/sites/1/limits", http.StatusForbidden},
+		{http.MethodGet, "/api/users", http.StatusForbidden},
+		{http.MethodGet, "/api/tokens", http.StatusForbidden},
+	} {
+		if rec := srv.do(purge, tc.method, tc.path, "{}"); rec.Code != tc.want {
+			t.Fatalf("%s %s: expected %d, got %d: %s", tc.method, tc.path, tc.want, rec.Code, rec.Body)
+		}
+	}
+
+	full := createToken(admin, `{"name":"automation"}`)
+	if rec := srv.do(full, http.MethodGet, "/api/users", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected an unrestricted admin token to reach admin routes, got %d", rec.Code)
+	}
+
+	// Grants give a user without an organization read access to one site,
+	// and the user's tokens cannot go beyond it.
+	if rec := srv.do(admin, http.MethodPost, "/api/users", `{"email":"ops@example.com","password":"supersecret123"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("create user: expected 201, got %d", rec.Code)
+	}
+	if rec := srv.do(admin, http.MethodPut, "/api/users/2/grants/2", `{"actions":["read"]}`); rec.Code != http.StatusOK {
+		t.Fatalf("grant: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := srv.do(admin, http.MethodPut, "/api/users/2/grants/2", `{"actions":["admin"]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected admin not to be grantable, got %d", rec.Code)
+	}
+	ops := srv.login("ops@example.com")
+	opsToken := createToken(ops, `{"name":"logs","actions":["read","cron"]}`)
+	rec = srv.do(opsToken, http.MethodGet, "/api/sites", "")
+	if !strings.Contains(rec.Body.String(), "other.example") || strings.Contains(rec.Body.String(), "client.example") {
+		t.Fatalf("expected the granted site only, got %s", rec.Body)
+	}
+	if rec := srv.do(opsToken, http.MethodPost, "/api/sites/2/cron", "{}"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected the token not to exceed the grant, got %d", rec.Code)
+	}
+	if rec := srv.do(ops, http.MethodDelete, "/api/tokens/1", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected another user's token to be hidden, got %d", rec.Code)
+	}
+
+	if rec := srv.do(admin, http.MethodDelete, "/api/tokens/1", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("revoke: expected 204, got %d", rec.Code)
+	}
+	if rec := srv.do(purge, http.MethodGet, "/api/sites/1", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a revoked token to be rejected, got %d", rec.Code)
+	}
+}
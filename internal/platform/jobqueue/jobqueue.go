@@ -0,0 +1,387 @@
+// Package jobqueue provides an SQLite-based async job queue.
+package jobqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+// Job statuses.
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+const (
+	defaultPollInterval = 5 * time.Second
+	// maxLogChunk bounds one ReadLog response.
+	maxLogChunk = 256 * 1024
+)
+
+// ErrJobNotFound indicates a missing job row.
+var ErrJobNotFound = errors.New("job not found")
+
+// Job is one queued unit of work.
+type Job struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	Status    string          `json:"status"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// Handler processes one job; a returned error marks the job failed.
+type Handler func(ctx context.Context, job Job) error
+
+// Queue stores jobs in queue.db and runs them with registered handlers.
+// Jobs are processed one at a time, in insertion order. Handlers may write
+// progress output to a per-job log file under <data dir>/job-logs.
+type Queue struct {
+	store        *sqlite.Store
+	log          *slog.Logger
+	logDir       string
+	now          func() time.Time
+	pollInterval time.Duration
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	// runMu serializes claim+run so a job is never picked up twice.
+	runMu sync.Mutex
+	wake  chan struct{}
+}
+
+// New creates a queue backed by store.QueueDB.
+func New(store *sqlite.Store, log *slog.Logger) *Queue {
+	if log == nil {
+		log = slog.Default()
+	}
+	return &Queue{
+		store:        store,
+		log:          log,
+		logDir:       filepath.Join(store.DataDir, "job-logs"),
+		now:          time.Now,
+		pollInterval: defaultPollInterval,
+		handlers:     map[string]Handler{},
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Register sets the handler for a job type.
+func (q *Queue) Register(jobType string, h Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[jobType] = h
+}
+
+// Enqueue stores a job with a JSON-encoded payload and returns its id.
+func (q *Queue) Enqueue(ctx context.Context, jobType string, payload any) (int64, error) {
+	jobType = strings.TrimSpace(jobType)
+	if jobType == "" {
+		return 0, fmt.Errorf("job type is required")
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("encode job payload: %w", err)
+	}
+	now := q.now().Unix()
+	rows, err := q.store.QueryQueueWriteJSON(ctx, fmt.Sprintf(`
+INSERT INTO jobs(type, status, payload, created_at, updated_at)
+VALUES('%s','%s','%s',%d,%d)
+RETURNING id;`, sqlEscape(jobType), StatusQueued, sqlEscape(string(raw)), now, now))
+	if err != nil {
+		return 0, fmt.Errorf("enqueue job: %w", err)
+	}
+	if len(rows) == 0 {
+		return 0, fmt.Errorf("enqueue job: no id returned")
+	}
+	id, err := toInt64(rows[0]["id"])
+	if err != nil {
+		return 0, fmt.Errorf("parse job id: %w", err)
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return id, nil
+}
+
+// Get returns a job by id.
+func (q *Queue) Get(ctx context.Context, id int64) (Job, error) {
+	rows, err := q.store.QueryQueueJSON(ctx, fmt.Sprintf(`
+SELECT id, type, status, payload, attempts, last_error, created_at, updated_at
+FROM jobs
+WHERE id = %d;`, id))
+	if err != nil {
+		return Job{}, fmt.Errorf("get job: %w", err)
+	}
+	if len(rows) == 0 {
+		return Job{}, ErrJobNotFound
+	}
+	return parseJob(rows[0])
+}
+
+// LogWriter opens the job's log file for appending.
+func (q *Queue) LogWriter(id int64) (io.WriteCloser, error) {
+	if err := os.MkdirAll(q.logDir, 0o750); err != nil {
+		return nil, fmt.Errorf("create job log dir: %w", err)
+	}
+	f, err := os.OpenFile(q.logPath(id), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open job log: %w", err)
+	}
+	return f, nil
+}
+
+// ReadLog returns job log output starting at offset and the offset to pass
+// on the next call, so clients can follow a running job by polling.
+func (q *Queue) ReadLog(id int64, offset int64) (string, int64, error) {
+	if offset < 0 {
+		offset = 0
+	}
+	f, err := os.Open(q.logPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", offset, nil
+		}
+		return "", offset, fmt.Errorf("open job log: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	buf := make([]byte, maxLogChunk)
+	n, err := f.ReadAt(buf, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", offset, fmt.Errorf("read job log: %w", err)
+	}
+	return string(buf[:n]), offset + int64(n), nil
+}
+
+func (q *Queue) logPath(id int64) string {
+	return filepath.Join(q.logDir, fmt.Sprintf("%d.log", id))
+}
+
+// Pause stops the queue from starting new jobs; running jobs finish and
+// queued ones wait. The mark lives on disk so that a CLI process can drain
+// the queue of the running panel.
+func (q *Queue) Pause() error {
+	if err := os.WriteFile(q.pausePath(), nil, 0o600); err != nil {
+		return fmt.Errorf("pause job queue: %w", err)
+	}
+	return nil
+}
+
+// Resume lets the queue start jobs again.
+func (q *Queue) Resume() error {
+	if err := os.Remove(q.pausePath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("resume job queue: %w", err)
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Paused reports whether the queue is paused.
+func (q *Queue) Paused() bool {
+	_, err := os.Stat(q.pausePath())
+	return err == nil
+}
+
+func (q *Queue) pausePath() string {
+	return filepath.Join(q.store.DataDir, "job-queue.paused")
+}
+
+// Active returns queued and running jobs, oldest first.
+func (q *Queue) Active(ctx context.Context) ([]Job, error) {
+	rows, err := q.store.QueryQueueJSON(ctx, fmt.Sprintf(`
+SELECT id, type, status, payload, attempts, last_error, created_at, updated_at
+FROM jobs
+WHERE status IN ('%s','%s')
+ORDER BY id;`, StatusQueued, StatusRunning))
+	if err != nil {
+		return nil, fmt.Errorf("list active jobs: %w", err)
+	}
+	jobs := make([]Job, 0, len(rows))
+	for _, row := range rows {
+		job, err := parseJob(row)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// RunPending runs queued jobs until none remain or the queue is paused and
+// returns how many ran.
+func (q *Queue) RunPending(ctx context.Context) (int, error) {
+	q.runMu.Lock()
+	defer q.runMu.Unlock()
+
+	ran := 0
+	for ctx.Err() == nil {
+		if q.Paused() {
+			return ran, nil
+		}
+		rows, err := q.store.QueryQueueJSON(ctx, fmt.Sprintf(`
+SELECT id, type, status, payload, attempts, last_error, created_at, updated_at
+FROM jobs
+WHERE status = '%s'
+ORDER BY id
+LIMIT 1;`, StatusQueued))
+		if err != nil {
+			return ran, fmt.Errorf("claim job: %w", err)
+		}
+		if len(rows) == 0 {
+			return ran, nil
+		}
+		job, err := parseJob(rows[0])
+		if err != nil {
+			return ran, err
+		}
+		q.run(ctx, job)
+		ran++
+	}
+	return ran, ctx.Err()
+}
+
+// Start processes jobs in the background until ctx is cancelled. Jobs left
+// running by a previous process are failed first; their handlers may have
+// stopped mid-way, so they are not retried blindly. A pause left by the
+// previous process (e.g. before a reboot) is lifted.
+func (q *Queue) Start(ctx context.Context) {
+	if err := q.Resume(); err != nil {
+		q.log.Error("resume job queue", "error", err.Error())
+	}
+	if err := q.store.ExecQueue(ctx, fmt.Sprintf(
+		"UPDATE jobs SET status='%s', last_error='interrupted by panel restart', updated_at=%d WHERE status='%s';",
+		StatusFailed, q.now().Unix(), StatusRunning,
+	)); err != nil {
+		q.log.Error("fail interrupted jobs", "error", err.Error())
+	}
+	go func() {
+		ticker := time.NewTicker(q.pollInterval)
+		defer ticker.Stop()
+		for {
+			if _, err := q.RunPending(ctx); err != nil && ctx.Err() == nil {
+				q.log.Error("run pending jobs", "error", err.Error())
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-q.wake:
+			}
+		}
+	}()
+}
+
+func (q *Queue) run(ctx context.Context, job Job) {
+	q.mu.Lock()
+	h, ok := q.handlers[job.Type]
+	q.mu.Unlock()
+
+	job.Attempts++
+	if err := q.setStatus(ctx, job.ID, StatusRunning, job.Attempts, ""); err != nil {
+		q.log.Error("mark job running", "job_id", job.ID, "error", err.Error())
+		return
+	}
+	var runErr error
+	if !ok {
+		runErr = fmt.Errorf("no handler registered for job type %q", job.Type)
+	} else {
+		runErr = safeRun(ctx, h, job)
+	}
+	status, lastErr := StatusDone, ""
+	if runErr != nil {
+		status, lastErr = StatusFailed, runErr.Error()
+		q.log.Warn("job failed", "job_id", job.ID, "type", job.Type, "error", lastErr)
+	}
+	if err := q.setStatus(context.WithoutCancel(ctx), job.ID, status, job.Attempts, lastErr); err != nil {
+		q.log.Error("mark job finished", "job_id", job.ID, "error", err.Error())
+	}
+}
+
+func safeRun(ctx context.Context, h Handler, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return h(ctx, job)
+}
+
+func (q *Queue) setStatus(ctx context.Context, id int64, status string, attempts int, lastErr string) error {
+	return q.store.ExecQueue(ctx, fmt.Sprintf(
+		"UPDATE jobs SET status='%s', attempts=%d, last_error='%s', updated_at=%d WHERE id=%d;",
+		status, attempts, sqlEscape(lastErr), q.now().Unix(), id,
+	))
+}
+
+func parseJob(row map[string]any) (Job, error) {
+	id, err := toInt64(row["id"])
+	if err != nil {
+		return Job{}, fmt.Errorf("parse job id: %w", err)
+	}
+	attempts, err := toInt64(row["attempts"])
+	if err != nil {
+		return Job{}, fmt.Errorf("parse job attempts: %w", err)
+	}
+	createdAt, err := toInt64(row["created_at"])
+	if err != nil {
+		return Job{}, fmt.Errorf("parse job created_at: %w", err)
+	}
+	updatedAt, err := toInt64(row["updated_at"])
+	if err != nil {
+		return Job{}, fmt.Errorf("parse job updated_at: %w", err)
+	}
+	return Job{
+		ID:        id,
+		Type:      fmt.Sprint(row["type"]),
+		Status:    fmt.Sprint(row["status"]),
+		Payload:   json.RawMessage(fmt.Sprint(row["payload"])),
+		Attempts:  int(attempts),
+		LastError: fmt.Sprint(row["last_error"]),
+		CreatedAt: time.Unix(createdAt, 0).UTC(),
+		UpdatedAt: time.Unix(updatedAt, 0).UTC(),
+	}, nil
+}
+
+func sqlEscape(in string) string {
+	return strings.ReplaceAll(in, "'", "''")
+}
+
+func toInt64(v any) (int64, error) {
+	switch t := v.(type) {
+	case float64:
+		return int64(t), nil
+	case int64:
+		return t, nil
+	case string:
+		i, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return i, nil
+	default:
+		return 0, fmt.Errorf("unsupported int conversion type %T", v)
+	}
+}
@@ -0,0 +1,124 @@
+package jobqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+func newTestQueue(t *testing.T) *Queue {
+	t.Helper()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(context.Background()); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	return New(store, nil)
+}
+
+func TestQueue_RunPendingRecordsOutcome(t *testing.T) {
+	ctx := context.Background()
+	q := newTestQueue(t)
+	var seen []string
+	q.Register("echo", func(_ context.Context, job Job) error {
+		var p struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return err
+		}
+		seen = append(seen, p.Name)
+		if p.Name == "bad" {
+			return errors.New("handler failed")
+		}
+		return nil
+	})
+
+	okID, err := q.Enqueue(ctx, "echo", map[string]string{"name": "it's ok"})
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	badID, _ := q.Enqueue(ctx, "echo", map[string]string{"name": "bad"})
+	orphanID, _ := q.Enqueue(ctx, "unknown", nil)
+
+	ran, err := q.RunPending(ctx)
+	if err != nil || ran != 3 {
+		t.Fatalf("expected 3 jobs run, got %d (%v)", ran, err)
+	}
+	if len(seen) != 2 || seen[0] != "it's ok" || seen[1] != "bad" {
+		t.Fatalf("expected jobs in insertion order, got %v", seen)
+	}
+
+	ok, err := q.Get(ctx, okID)
+	if err != nil || ok.Status != StatusDone || ok.Attempts != 1 {
+		t.Fatalf("unexpected ok job: %+v (%v)", ok, err)
+	}
+	bad, _ := q.Get(ctx, badID)
+	if bad.Status != StatusFailed || bad.LastError != "handler failed" {
+		t.Fatalf("unexpected failed job: %+v", bad)
+	}
+	orphan, _ := q.Get(ctx, orphanID)
+	if orphan.Status != StatusFailed {
+		t.Fatalf("expected unhandled job type to fail, got %+v", orphan)
+	}
+	if ran, _ := q.RunPending(ctx); ran != 0 {
+		t.Fatalf("expected finished jobs not to rerun, got %d", ran)
+	}
+	if _, err := q.Get(ctx, 999); !errors.Is(err, ErrJobNotFound) {
+		t.Fatalf("expected ErrJobNotFound, got %v", err)
+	}
+}
+
+func TestQueue_ReadLogFollowsOffset(t *testing.T) {
+	q := newTestQueue(t)
+	if out, next, err := q.ReadLog(1, 0); err != nil || out != "" || next != 0 {
+		t.Fatalf("expected empty log before first write, got %q %d %v", out, next, err)
+	}
+	w, err := q.LogWriter(1)
+	if err != nil {
+		t.Fatalf("open log: %v", err)
+	}
+	_, _ = w.Write([]byte("first\n"))
+	out, next, err := q.ReadLog(1, 0)
+	if err != nil || out != "first\n" {
+		t.Fatalf("unexpected first read %q (%v)", out, err)
+	}
+	_, _ = w.Write([]byte("second\n"))
+	_ = w.Close()
+	out, _, err = q.ReadLog(1, next)
+	if err != nil || out != "second\n" {
+		t.Fatalf("expected only new output, got %q (%v)", out, err)
+	}
+}
+
+func TestQueue_PauseHoldsQueuedJobs(t *testing.T) {
+	ctx := context.Background()
+	q := newTestQueue(t)
+	q.Register("noop", func(context.Context, Job) error { return nil })
+	if err := q.Pause(); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+	// A second queue over the same data dir, as the CLI would open.
+	other := New(q.store, nil)
+	if !other.Paused() {
+		t.Fatal("expected pause to be visible to another queue")
+	}
+	if _, err := q.Enqueue(ctx, "noop", nil); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if ran, err := q.RunPending(ctx); err != nil || ran != 0 {
+		t.Fatalf("expected paused queue to run nothing, got %d (%v)", ran, err)
+	}
+	active, err := q.Active(ctx)
+	if err != nil || len(active) != 1 || active[0].Status != StatusQueued {
+		t.Fatalf("expected one queued job, got %+v (%v)", active, err)
+	}
+	if err := q.Resume(); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if ran, err := q.RunPending(ctx); err != nil || ran != 1 {
+		t.Fatalf("expected resumed queue to run the job, got %d (%v)", ran, err)
+	}
+}
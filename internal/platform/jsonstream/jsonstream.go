@@ -0,0 +1,78 @@
+// Package jsonstream writes list responses one item at a time instead of
+// encoding the whole body in memory, as a JSON object or as NDJSON.
+package jsonstream
+
+import (
+	"encoding/json"
+	"iter"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// NDJSONType is the media type of newline-delimited JSON.
+const NDJSONType = "application/x-ndjson"
+
+// flushEvery bounds how many items are buffered before a flush.
+const flushEvery = 100
+
+// WantsNDJSON reports whether the client prefers NDJSON over a JSON
+// document, either with "Accept: application/x-ndjson" or ?format=ndjson.
+func WantsNDJSON(r *http.Request) bool {
+	if strings.EqualFold(r.URL.Query().Get("format"), "ndjson") {
+		return true
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part)); err == nil && mediaType == NDJSONType {
+			return true
+		}
+	}
+	return false
+}
+
+// List writes items as {"<key>": [...]} with status 200, or as one JSON
+// value per line when the client asked for NDJSON.
+func List[T any](w http.ResponseWriter, r *http.Request, key string, items []T) {
+	Seq(w, r, key, slices.Values(items))
+}
+
+// Seq is List for items produced on the fly. Errors after the first item
+// cannot change the status, so producers should fail before yielding.
+func Seq[T any](w http.ResponseWriter, r *http.Request, key string, items iter.Seq[T]) {
+	ndjson := WantsNDJSON(r)
+	if ndjson {
+		w.Header().Set("Content-Type", NDJSONType)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	if !ndjson {
+		name, _ := json.Marshal(key)
+		_, _ = w.Write([]byte("{" + string(name) + ":["))
+	}
+	n := 0
+	for item := range items {
+		if r.Context().Err() != nil {
+			return
+		}
+		if !ndjson && n > 0 {
+			_, _ = w.Write([]byte(","))
+		}
+		// Encode appends a newline, which is the NDJSON separator and
+		// insignificant whitespace inside the array.
+		if err := enc.Encode(item); err != nil {
+			return
+		}
+		n++
+		if n%flushEvery == 0 {
+			_ = rc.Flush()
+		}
+	}
+	if !ndjson {
+		_, _ = w.Write([]byte("]}\n"))
+	}
+}
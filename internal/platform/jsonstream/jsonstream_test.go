@@ -0,0 +1,47 @@
+package jsonstream
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type item struct {
+	ID int `json:"id"`
+}
+
+func TestList(t *testing.T) {
+	items := []item{{ID: 1}, {ID: 2}, {ID: 3}}
+
+	rec := httptest.NewRecorder()
+	List(rec, httptest.NewRequest(http.MethodGet, "/api/sites", nil), "sites", items)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	var doc map[string][]item
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decode document: %v (%s)", err, rec.Body.String())
+	}
+	if len(doc["sites"]) != 3 || doc["sites"][2].ID != 3 {
+		t.Fatalf("unexpected document: %+v", doc)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/sites", nil)
+	req.Header.Set("Accept", "application/x-ndjson")
+	rec = httptest.NewRecorder()
+	List(rec, req, "sites", items)
+	if ct := rec.Header().Get("Content-Type"); ct != NDJSONType {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	if got := rec.Body.String(); got != "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n" {
+		t.Fatalf("unexpected ndjson body %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	List(rec, httptest.NewRequest(http.MethodGet, "/api/sites", nil), "sites", []item(nil))
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"sites":[]}` {
+		t.Fatalf("expected an empty array, got %q", got)
+	}
+}
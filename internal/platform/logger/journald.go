@@ -0,0 +1,78 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+)
+
+// journaldHandler writes logfmt lines prefixed with a syslog priority
+// ("<6>...") that systemd-journald parses from service stdout. The timestamp
+// is omitted because journald records its own.
+type journaldHandler struct {
+	out   io.Writer
+	mu    *sync.Mutex
+	buf   *bytes.Buffer
+	inner slog.Handler
+}
+
+func newJournaldHandler(out io.Writer, opts *slog.HandlerOptions) *journaldHandler {
+	buf := &bytes.Buffer{}
+	hopts := *opts
+	hopts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+			return slog.Attr{}
+		}
+		return a
+	}
+	return &journaldHandler{
+		out:   out,
+		mu:    &sync.Mutex{},
+		buf:   buf,
+		inner: slog.NewTextHandler(buf, &hopts),
+	}
+}
+
+func (h *journaldHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *journaldHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buf.Reset()
+	if err := h.inner.Handle(ctx, r); err != nil {
+		return err
+	}
+	line := make([]byte, 0, h.buf.Len()+4)
+	line = append(line, '<')
+	line = strconv.AppendInt(line, int64(syslogPriority(r.Level)), 10)
+	line = append(line, '>')
+	line = append(line, h.buf.Bytes()...)
+	_, err := h.out.Write(line)
+	return err
+}
+
+func (h *journaldHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &journaldHandler{out: h.out, mu: h.mu, buf: h.buf, inner: h.inner.WithAttrs(attrs)}
+}
+
+func (h *journaldHandler) WithGroup(name string) slog.Handler {
+	return &journaldHandler{out: h.out, mu: h.mu, buf: h.buf, inner: h.inner.WithGroup(name)}
+}
+
+func syslogPriority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
@@ -2,11 +2,65 @@
 package logger
 
 import (
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"strings"
+	"sync"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+)
+
+// Supported log formats.
+const (
+	FormatJSON     = "json"
+	FormatText     = "text"
+	FormatJournald = "journald"
 )
 
+const (
+	defaultMaxSizeMB  = 100
+	defaultMaxBackups = 5
+)
+
+// Options configures log format, level and destination.
+type Options struct {
+	Env string
+	// Format is json (default), text or journald.
+	Format string
+	// Level is debug, info, warn or error. Empty selects debug for dev, info otherwise.
+	Level string
+	// File is stdout (default), stderr or a file path rotated by size.
+	File       string
+	MaxSizeMB  int
+	MaxBackups int
+	// ModuleLevels overrides Level for records from loggers tagged with
+	// ModuleKey (see ForModule), e.g. {"hosting": "debug"}.
+	ModuleLevels map[string]string
+}
+
+// ModuleKey is the attribute used to route records to per-module levels.
+const ModuleKey = "module"
+
+// ForModule tags log with the module name used by per-module level overrides.
+func ForModule(log *slog.Logger, module string) *slog.Logger {
+	return log.With(ModuleKey, module)
+}
+
+// FromConfig maps panel configuration to logger options.
+func FromConfig(cfg config.Config) Options {
+	return Options{
+		Env:          cfg.Env,
+		Format:       cfg.LogFormat,
+		Level:        cfg.LogLevel,
+		File:         cfg.LogFile,
+		MaxSizeMB:    cfg.LogMaxSizeMB,
+		MaxBackups:   cfg.LogMaxBackups,
+		ModuleLevels: cfg.LogLevels,
+	}
+}
+
 // New returns a JSON logger configured for the given environment.
 func New(env string) *slog.Logger {
 	level := slog.LevelInfo
@@ -16,3 +70,130 @@ func New(env string) *slog.Logger {
 	h := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})
 	return slog.New(h)
 }
+
+// Controller switches the output of a configured logger at runtime.
+// Loggers derived via With/WithGroup follow the switch as well.
+type Controller struct {
+	mu    sync.Mutex
+	state *swapState
+}
+
+// Configure builds a logger from opts and returns a controller for reconfiguration.
+func Configure(opts Options) (*slog.Logger, *Controller, error) {
+	c := &Controller{state: &swapState{}}
+	if err := c.Apply(opts); err != nil {
+		return nil, nil, err
+	}
+	return slog.New(&swapHandler{state: c.state}), c, nil
+}
+
+// Apply replaces the active handler with one built from opts. The previous
+// log file is closed once records already being written to it finish.
+func (c *Controller) Apply(opts Options) error {
+	h, closer, err := buildHandler(opts)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if prev := c.state.set(h, &output{closer: closer}); prev != nil {
+		_ = prev.retire()
+	}
+	return nil
+}
+
+// Close releases the active log file, if any, after in-flight records.
+func (c *Controller) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state.retire()
+}
+
+func buildHandler(opts Options) (slog.Handler, io.Closer, error) {
+	level, err := parseLevel(opts.Level, opts.Env)
+	if err != nil {
+		return nil, nil, err
+	}
+	moduleLevels := make(map[string]slog.Level, len(opts.ModuleLevels))
+	minLevel := level
+	for module, name := range opts.ModuleLevels {
+		l, err := ParseLevel(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("log level for module %s: %w", module, err)
+		}
+		moduleLevels[strings.ToLower(strings.TrimSpace(module))] = l
+		minLevel = min(minLevel, l)
+	}
+	out, closer, err := openDestination(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	hopts := &slog.HandlerOptions{Level: minLevel}
+	var h slog.Handler
+	switch strings.ToLower(strings.TrimSpace(opts.Format)) {
+	case "", FormatJSON:
+		h = slog.NewJSONHandler(out, hopts)
+	case FormatText:
+		h = slog.NewTextHandler(out, hopts)
+	case FormatJournald:
+		h = newJournaldHandler(out, hopts)
+	default:
+		if closer != nil {
+			_ = closer.Close()
+		}
+		return nil, nil, fmt.Errorf("unsupported log format: %s", opts.Format)
+	}
+	if len(moduleLevels) > 0 {
+		h = &moduleLevelHandler{inner: h, defaultLevel: level, levels: moduleLevels, level: level}
+	}
+	return h, closer, nil
+}
+
+func openDestination(opts Options) (io.Writer, io.Closer, error) {
+	dest := strings.TrimSpace(opts.File)
+	switch strings.ToLower(dest) {
+	case "", "stdout":
+		return os.Stdout, nil, nil
+	case "stderr":
+		return os.Stderr, nil, nil
+	}
+	maxSize := opts.MaxSizeMB
+	if maxSize <= 0 {
+		maxSize = defaultMaxSizeMB
+	}
+	maxBackups := opts.MaxBackups
+	if maxBackups <= 0 {
+		maxBackups = defaultMaxBackups
+	}
+	f, err := openRotatingFile(dest, int64(maxSize)<<20, maxBackups)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open log file: %w", err)
+	}
+	return f, f, nil
+}
+
+// ParseLevel converts a level name into a slog level.
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unsupported log level: %s", name)
+	}
+}
+
+func parseLevel(name, env string) (slog.Level, error) {
+	if strings.TrimSpace(name) == "" {
+		if strings.EqualFold(env, "dev") {
+			return slog.LevelDebug, nil
+		}
+		return slog.LevelInfo, nil
+	}
+	return ParseLevel(name)
+}
@@ -0,0 +1,195 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigure_FileOutputAndRuntimeSwitch(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "panel.json.log")
+	textPath := filepath.Join(dir, "panel.text.log")
+
+	log, ctl, err := Configure(Options{Env: "prod", Format: FormatJSON, File: jsonPath})
+	if err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+	defer func() {
+		_ = ctl.Close()
+	}()
+	derived := log.With("module", "hosting")
+	derived.Debug("hidden")
+	derived.Info("first")
+
+	if err := ctl.Apply(Options{Env: "prod", Format: FormatText, Level: "debug", File: textPath}); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	derived.Debug("second")
+
+	jsonOut := readFile(t, jsonPath)
+	if !strings.Contains(jsonOut, `"msg":"first"`) || !strings.Contains(jsonOut, `"module":"hosting"`) {
+		t.Fatalf("unexpected json log: %s", jsonOut)
+	}
+	if strings.Contains(jsonOut, "hidden") {
+		t.Fatalf("expected debug record to be filtered at info level: %s", jsonOut)
+	}
+	textOut := readFile(t, textPath)
+	if !strings.Contains(textOut, "msg=second") || !strings.Contains(textOut, "module=hosting") {
+		t.Fatalf("expected derived logger to follow switch to text output: %s", textOut)
+	}
+}
+
+// blockingHandler holds every record until release is closed.
+type blockingHandler struct {
+	slog.Handler
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *blockingHandler) Handle(ctx context.Context, r slog.Record) error {
+	close(h.started)
+	<-h.release
+	return h.Handler.Handle(ctx, r)
+}
+
+type closeRecorder struct {
+	closed chan struct{}
+}
+
+func (c *closeRecorder) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestSwapHandler_ClosesRetiredOutputAfterInFlightRecord(t *testing.T) {
+	state := &swapState{}
+	old := &blockingHandler{Handler: slog.NewTextHandler(&bytes.Buffer{}, nil), started: make(chan struct{}), release: make(chan struct{})}
+	closer := &closeRecorder{closed: make(chan struct{})}
+	state.set(old, &output{closer: closer})
+	log := slog.New(&swapHandler{state: state})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		log.Info("in flight")
+	}()
+	<-old.started
+
+	var next bytes.Buffer
+	if prev := state.set(slog.NewTextHandler(&next, nil), &output{}); prev.retire() != nil {
+		t.Fatal("retire failed")
+	}
+	log.Info("after swap")
+	select {
+	case <-closer.closed:
+		t.Fatal("expected the old output kept open while a record is written to it")
+	default:
+	}
+	if !strings.Contains(next.String(), "after swap") {
+		t.Fatalf("expected new records on the new output, got %q", next.String())
+	}
+
+	close(old.release)
+	<-done
+	select {
+	case <-closer.closed:
+	default:
+		t.Fatal("expected the old output closed after the in-flight record")
+	}
+}
+
+func TestConfigure_RejectsUnknownFormat(t *testing.T) {
+	if _, _, err := Configure(Options{Format: "xml"}); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
+
+func TestJournaldHandler_PrefixesPriority(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(newJournaldHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	log.Warn("disk almost full", "free_mb", 10)
+
+	got := buf.String()
+	if !strings.HasPrefix(got, "<4>") {
+		t.Fatalf("expected warning priority prefix, got %q", got)
+	}
+	if strings.Contains(got, "time=") || strings.Contains(got, "level=") {
+		t.Fatalf("expected time and level to be left to journald, got %q", got)
+	}
+}
+
+func TestRotatingFile_RotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "panel.log")
+	rf, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer func() {
+		_ = rf.Close()
+	}()
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if got := readFile(t, path); got != "dddddddd\n" {
+		t.Fatalf("unexpected active file %q", got)
+	}
+	if got := readFile(t, path+".1"); got != "cccccccc\n" {
+		t.Fatalf("unexpected first backup %q", got)
+	}
+	if got := readFile(t, path+".2"); got != "bbbbbbbb\n" {
+		t.Fatalf("unexpected second backup %q", got)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("expected backups to be capped at 2")
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return string(b)
+}
+
+func TestConfigure_ModuleLevelOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "panel.log")
+	log, ctl, err := Configure(Options{
+		Env:          "prod",
+		Level:        "info",
+		File:         path,
+		ModuleLevels: map[string]string{"hosting": "debug", "iam": "warn"},
+	})
+	if err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+	defer func() {
+		_ = ctl.Close()
+	}()
+
+	ForModule(log, "hosting").Debug("hosting-debug")
+	ForModule(log, "iam").Info("iam-info")
+	ForModule(log, "iam").Warn("iam-warn")
+	ForModule(log, "database").Debug("database-debug")
+	log.Info("root-info")
+
+	out := readFile(t, path)
+	for _, want := range []string{"hosting-debug", "iam-warn", "root-info"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %s in log output: %s", want, out)
+		}
+	}
+	for _, unwanted := range []string{"iam-info", "database-debug"} {
+		if strings.Contains(out, unwanted) {
+			t.Fatalf("expected %s to be filtered: %s", unwanted, out)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+)
+
+// moduleLevelHandler filters records by the level configured for the module
+// the logger was tagged with. The inner handler runs at the lowest configured
+// level so overrides can raise verbosity for a single module.
+type moduleLevelHandler struct {
+	inner        slog.Handler
+	defaultLevel slog.Level
+	levels       map[string]slog.Level
+	level        slog.Level
+}
+
+func (h *moduleLevelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level && h.inner.Enabled(ctx, level)
+}
+
+func (h *moduleLevelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *moduleLevelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	level := h.level
+	for _, a := range attrs {
+		if a.Key != ModuleKey {
+			continue
+		}
+		module := strings.ToLower(strings.TrimSpace(a.Value.String()))
+		if l, ok := h.levels[module]; ok {
+			level = l
+		} else {
+			level = h.defaultLevel
+		}
+	}
+	return &moduleLevelHandler{
+		inner:        h.inner.WithAttrs(attrs),
+		defaultLevel: h.defaultLevel,
+		levels:       h.levels,
+		level:        level,
+	}
+}
+
+func (h *moduleLevelHandler) WithGroup(name string) slog.Handler {
+	return &moduleLevelHandler{
+		inner:        h.inner.WithGroup(name),
+		defaultLevel: h.defaultLevel,
+		levels:       h.levels,
+		level:        h.level,
+	}
+}
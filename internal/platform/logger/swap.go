@@ -0,0 +1,166 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// output is the destination of one installed root handler. It counts the
+// records being written to it, so a file retired by a swap is closed only
+// once the last of them has finished.
+type output struct {
+	mu      sync.Mutex
+	closer  io.Closer
+	refs    int
+	retired bool
+}
+
+func (o *output) acquire() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.refs++
+}
+
+func (o *output) release() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.refs--
+	if o.retired && o.refs == 0 {
+		_ = o.closeLocked()
+	}
+}
+
+// retire closes the destination now when nothing is writing to it and
+// otherwise leaves that to the last in-flight record.
+func (o *output) retire() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.retired = true
+	if o.refs > 0 {
+		return nil
+	}
+	return o.closeLocked()
+}
+
+func (o *output) closeLocked() error {
+	if o.closer == nil {
+		return nil
+	}
+	err := o.closer.Close()
+	o.closer = nil
+	return err
+}
+
+// swapState holds the active root handler and a generation counter so
+// derived handlers can rebuild their attribute chain after a swap.
+type swapState struct {
+	mu         sync.RWMutex
+	root       slog.Handler
+	out        *output
+	generation uint64
+}
+
+// set installs h writing to out and returns the output it replaced.
+func (s *swapState) set(h slog.Handler, out *output) *output {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.out
+	s.root = h
+	s.out = out
+	s.generation++
+	return prev
+}
+
+func (s *swapState) current() (slog.Handler, uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.root, s.generation
+}
+
+// acquire is current for a record about to be written: the returned
+// output stays open until it is released.
+func (s *swapState) acquire() (slog.Handler, uint64, *output) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.out != nil {
+		s.out.acquire()
+	}
+	return s.root, s.generation, s.out
+}
+
+// retire retires the active output, see output.retire.
+func (s *swapState) retire() error {
+	s.mu.RLock()
+	out := s.out
+	s.mu.RUnlock()
+	if out == nil {
+		return nil
+	}
+	return out.retire()
+}
+
+// handlerOp is a recorded WithAttrs or WithGroup call replayed on the root handler.
+type handlerOp struct {
+	group string
+	attrs []slog.Attr
+}
+
+type swapHandler struct {
+	state *swapState
+	ops   []handlerOp
+
+	mu         sync.Mutex
+	cached     slog.Handler
+	generation uint64
+}
+
+func (h *swapHandler) resolve(root slog.Handler, gen uint64) slog.Handler {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cached != nil && h.generation == gen {
+		return h.cached
+	}
+	out := root
+	for _, op := range h.ops {
+		if op.group != "" {
+			out = out.WithGroup(op.group)
+			continue
+		}
+		out = out.WithAttrs(op.attrs)
+	}
+	h.cached = out
+	h.generation = gen
+	return out
+}
+
+func (h *swapHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.resolve(h.state.current()).Enabled(ctx, level)
+}
+
+func (h *swapHandler) Handle(ctx context.Context, r slog.Record) error {
+	root, gen, out := h.state.acquire()
+	if out != nil {
+		defer out.release()
+	}
+	return h.resolve(root, gen).Handle(ctx, r)
+}
+
+func (h *swapHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(handlerOp{attrs: attrs})
+}
+
+func (h *swapHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(handlerOp{group: name})
+}
+
+func (h *swapHandler) with(op handlerOp) *swapHandler {
+	ops := make([]handlerOp, 0, len(h.ops)+1)
+	ops = append(ops, h.ops...)
+	ops = append(ops, op)
+	return &swapHandler{state: h.state, ops: ops}
+}
@@ -0,0 +1,324 @@
+// Package mailer sends panel e-mail (password resets, alerts) through the SMTP
+// relay configured in panel.yaml and records failed deliveries in panel.db.
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+// TLS modes accepted in smtp_tls_mode.
+const (
+	TLSModeNone     = "none"
+	TLSModeStartTLS = "starttls"
+	TLSModeTLS      = "tls"
+)
+
+const (
+	defaultSendTimeout  = 30 * time.Second
+	defaultFailureLimit = 50
+	maxFailureLimit     = 500
+)
+
+// ErrNotConfigured is returned when smtp_host or smtp_from is empty.
+var ErrNotConfigured = errors.New("smtp relay is not configured")
+
+// Settings describes the SMTP relay.
+type Settings struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	TLSMode  string
+}
+
+// SettingsFromConfig extracts SMTP settings from panel config.
+func SettingsFromConfig(cfg config.Config) Settings {
+	return Settings{
+		Host:     strings.TrimSpace(cfg.SMTPHost),
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     strings.TrimSpace(cfg.SMTPFrom),
+		TLSMode:  strings.ToLower(strings.TrimSpace(cfg.SMTPTLSMode)),
+	}
+}
+
+// Message is a plain-text e-mail.
+type Message struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Failure is a recorded delivery failure.
+type Failure struct {
+	ID        int64     `json:"id"`
+	Recipient string    `json:"recipient"`
+	Subject   string    `json:"subject"`
+	Error     string    `json:"error"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Mailer delivers messages through the configured relay.
+type Mailer struct {
+	settings Settings
+	store    *sqlite.Store
+	log      *slog.Logger
+	now      func() time.Time
+	timeout  time.Duration
+}
+
+// New creates a Mailer from panel config. store may be nil, in which case
+// failures are only logged.
+func New(cfg config.Config, store *sqlite.Store, log *slog.Logger) *Mailer {
+	if log == nil {
+		log = slog.Default()
+	}
+	return &Mailer{
+		settings: SettingsFromConfig(cfg),
+		store:    store,
+		log:      log,
+		now:      time.Now,
+		timeout:  defaultSendTimeout,
+	}
+}
+
+// Configured reports whether a relay host and sender are set.
+func (m *Mailer) Configured() bool {
+	return m.settings.Host != "" && m.settings.From != ""
+}
+
+// Send delivers msg and records a failure row when delivery fails.
+func (m *Mailer) Send(ctx context.Context, msg Message) error {
+	err := m.deliver(ctx, msg)
+	if err != nil {
+		m.log.Warn("mail delivery failed", "to", strings.Join(msg.To, ","), "subject", msg.Subject, "error", err.Error())
+		m.recordFailure(ctx, msg, err)
+	}
+	return err
+}
+
+// SendTest sends a short message confirming the relay settings work.
+func (m *Mailer) SendTest(ctx context.Context, to string) error {
+	return m.Send(ctx, Message{
+		To:      []string{to},
+		Subject: "aiPanel SMTP test",
+		Body: "This is a test message from aiPanel.\n\n" +
+			fmt.Sprintf("Relay: %s:%d (%s)\n", m.settings.Host, m.settings.Port, m.settings.TLSMode),
+	})
+}
+
+// ListFailures returns the most recent delivery failures, newest first.
+func (m *Mailer) ListFailures(ctx context.Context, limit int) ([]Failure, error) {
+	if m.store == nil {
+		return []Failure{}, nil
+	}
+	if limit <= 0 {
+		limit = defaultFailureLimit
+	}
+	if limit > maxFailureLimit {
+		limit = maxFailureLimit
+	}
+	rows, err := m.store.QueryPanelJSON(ctx, fmt.Sprintf(`
+SELECT id, recipient, subject, error, created_at
+FROM mail_failures
+ORDER BY id DESC
+LIMIT %d;`, limit))
+	if err != nil {
+		return nil, fmt.Errorf("list mail failures: %w", err)
+	}
+	out := make([]Failure, 0, len(rows))
+	for _, row := range rows {
+		id, err := toInt64(row["id"])
+		if err != nil {
+			return nil, fmt.Errorf("parse mail failure id: %w", err)
+		}
+		createdAt, err := toInt64(row["created_at"])
+		if err != nil {
+			return nil, fmt.Errorf("parse mail failure created_at: %w", err)
+		}
+		out = append(out, Failure{
+			ID:        id,
+			Recipient: fmt.Sprint(row["recipient"]),
+			Subject:   fmt.Sprint(row["subject"]),
+			Error:     fmt.Sprint(row["error"]),
+			CreatedAt: time.Unix(createdAt, 0).UTC(),
+		})
+	}
+	return out, nil
+}
+
+func (m *Mailer) deliver(ctx context.Context, msg Message) error {
+	if !m.Configured() {
+		return ErrNotConfigured
+	}
+	if len(msg.To) == 0 {
+		return fmt.Errorf("recipient is required")
+	}
+	from, err := mail.ParseAddress(m.settings.From)
+	if err != nil {
+		return fmt.Errorf("invalid smtp_from: %w", err)
+	}
+	recipients := make([]string, 0, len(msg.To))
+	for _, to := range msg.To {
+		addr, err := mail.ParseAddress(strings.TrimSpace(to))
+		if err != nil {
+			return fmt.Errorf("invalid recipient %q: %w", to, err)
+		}
+		recipients = append(recipients, addr.Address)
+	}
+
+	conn, err := m.dial(ctx)
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = m.now().Add(m.timeout)
+	}
+	_ = conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, m.settings.Host)
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("smtp handshake: %w", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if m.settings.TLSMode == TLSModeStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("smtp server does not support STARTTLS")
+		}
+		if err := client.StartTLS(m.tlsConfig()); err != nil {
+			return fmt.Errorf("smtp starttls: %w", err)
+		}
+	}
+	if m.settings.Username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("smtp server does not support AUTH")
+		}
+		auth := smtp.PlainAuth("", m.settings.Username, m.settings.Password, m.settings.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return fmt.Errorf("smtp MAIL FROM: %w", err)
+	}
+	for _, rcpt := range recipients {
+		if err := client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("smtp RCPT TO %s: %w", rcpt, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	if _, err := w.Write(m.buildMessage(from, recipients, msg)); err != nil {
+		_ = w.Close()
+		return fmt.Errorf("smtp write message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp finish message: %w", err)
+	}
+	if err := client.Quit(); err != nil {
+		return fmt.Errorf("smtp QUIT: %w", err)
+	}
+	return nil
+}
+
+func (m *Mailer) dial(ctx context.Context) (net.Conn, error) {
+	addr := net.JoinHostPort(m.settings.Host, strconv.Itoa(m.settings.Port))
+	dialer := &net.Dialer{Timeout: m.timeout}
+	if m.settings.TLSMode == TLSModeTLS {
+		conn, err := (&tls.Dialer{NetDialer: dialer, Config: m.tlsConfig()}).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("smtp connect %s (tls): %w", addr, err)
+		}
+		return conn, nil
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("smtp connect %s: %w", addr, err)
+	}
+	return conn, nil
+}
+
+func (m *Mailer) tlsConfig() *tls.Config {
+	return &tls.Config{ServerName: m.settings.Host, MinVersion: tls.VersionTLS12}
+}
+
+func (m *Mailer) buildMessage(from *mail.Address, to []string, msg Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from.String() + "\r\n")
+	b.WriteString("To: " + strings.Join(to, ", ") + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", headerValue(msg.Subject)) + "\r\n")
+	b.WriteString("Date: " + m.now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	body := strings.ReplaceAll(msg.Body, "\r\n", "\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+func (m *Mailer) recordFailure(ctx context.Context, msg Message, sendErr error) {
+	if m.store == nil {
+		return
+	}
+	sql := fmt.Sprintf(
+		"INSERT INTO mail_failures(recipient, subject, error, created_at) VALUES('%s','%s','%s',%d);",
+		sqlEscape(strings.Join(msg.To, ", ")),
+		sqlEscape(msg.Subject),
+		sqlEscape(sendErr.Error()),
+		m.now().Unix(),
+	)
+	// Record even when the request context was cancelled mid-send.
+	if err := m.store.ExecPanel(context.WithoutCancel(ctx), sql); err != nil {
+		m.log.Error("record mail failure", "error", err.Error())
+	}
+}
+
+// headerValue strips line breaks so values cannot inject extra headers.
+func headerValue(v string) string {
+	return strings.Join(strings.Fields(strings.NewReplacer("\r", " ", "\n", " ").Replace(v)), " ")
+}
+
+func sqlEscape(in string) string {
+	return strings.ReplaceAll(in, "'", "''")
+}
+
+func toInt64(v any) (int64, error) {
+	switch t := v.(type) {
+	case float64:
+		return int64(t), nil
+	case int64:
+		return t, nil
+	case string:
+		i, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return i, nil
+	default:
+		return 0, fmt.Errorf("unsupported int conversion type %T", v)
+	}
+}
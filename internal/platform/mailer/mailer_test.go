@@ -0,0 +1,166 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+// startFakeSMTP serves one plain SMTP session and sends the DATA payload on
+// the returned channel. rejectRcpt makes every RCPT TO fail with 550.
+func startFakeSMTP(t *testing.T, rejectRcpt bool) (string, int, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		tp := textproto.NewConn(conn)
+		_ = tp.PrintfLine("220 fake ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line + " ")[0]); cmd {
+			case "EHLO", "HELO":
+				_ = tp.PrintfLine("250-fake\r\n250 8BITMIME")
+			case "MAIL":
+				_ = tp.PrintfLine("250 ok")
+			case "RCPT":
+				if rejectRcpt {
+					_ = tp.PrintfLine("550 no such user")
+					continue
+				}
+				_ = tp.PrintfLine("250 ok")
+			case "DATA":
+				_ = tp.PrintfLine("354 go ahead")
+				body, err := tp.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				received <- string(body)
+				_ = tp.PrintfLine("250 queued")
+			case "QUIT":
+				_ = tp.PrintfLine("221 bye")
+				return
+			default:
+				_ = tp.PrintfLine("250 ok")
+			}
+		}
+	}()
+	host, portRaw, _ := net.SplitHostPort(ln.Addr().String())
+	port, _ := strconv.Atoi(portRaw)
+	return host, port, received
+}
+
+func newTestMailer(t *testing.T, host string, port int) *Mailer {
+	t.Helper()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(context.Background()); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	cfg := config.Config{
+		SMTPHost:    host,
+		SMTPPort:    port,
+		SMTPFrom:    "aiPanel <panel@example.com>",
+		SMTPTLSMode: TLSModeNone,
+	}
+	return New(cfg, store, slog.New(slog.NewJSONHandler(io.Discard, nil)))
+}
+
+func TestSendTest_DeliversThroughRelay(t *testing.T) {
+	host, port, received := startFakeSMTP(t, false)
+	m := newTestMailer(t, host, port)
+
+	if err := m.SendTest(context.Background(), "admin@example.com"); err != nil {
+		t.Fatalf("send test: %v", err)
+	}
+	body := <-received
+	for _, want := range []string{
+		"From: \"aiPanel\" <panel@example.com>",
+		"To: admin@example.com",
+		"Subject: aiPanel SMTP test",
+		"This is a test message from aiPanel.",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in message:\n%s", want, body)
+		}
+	}
+	failures, err := m.ListFailures(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("list failures: %v", err)
+	}
+	if len(failures) != 0 {
+		t.Fatalf("expected no failures, got %+v", failures)
+	}
+}
+
+func TestSend_RecordsDeliveryFailures(t *testing.T) {
+	host, port, _ := startFakeSMTP(t, true)
+	m := newTestMailer(t, host, port)
+
+	err := m.Send(context.Background(), Message{
+		To:      []string{"nobody@example.com"},
+		Subject: "Password reset\r\nBcc: victim@example.com",
+		Body:    "reset link",
+	})
+	if err == nil || !strings.Contains(err.Error(), "550") {
+		t.Fatalf("expected rcpt rejection, got %v", err)
+	}
+
+	unconfigured := New(config.Config{SMTPTLSMode: TLSModeNone}, m.store, m.log)
+	if err := unconfigured.SendTest(context.Background(), "admin@example.com"); !errors.Is(err, ErrNotConfigured) {
+		t.Fatalf("expected ErrNotConfigured, got %v", err)
+	}
+
+	failures, err := m.ListFailures(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("list failures: %v", err)
+	}
+	if len(failures) != 2 {
+		t.Fatalf("expected 2 failures, got %+v", failures)
+	}
+	if failures[0].Error != ErrNotConfigured.Error() {
+		t.Fatalf("expected newest failure first, got %+v", failures[0])
+	}
+	if failures[1].Recipient != "nobody@example.com" || !strings.Contains(failures[1].Error, "no such user") {
+		t.Fatalf("unexpected recorded failure: %+v", failures[1])
+	}
+}
+
+func TestBuildMessage_StripsHeaderInjection(t *testing.T) {
+	m := New(config.Config{SMTPFrom: "panel@example.com"}, nil, nil)
+	raw := string(m.buildMessage(
+		&mail.Address{Address: "panel@example.com"},
+		[]string{"admin@example.com"},
+		Message{Subject: "Hi\r\nBcc: victim@example.com", Body: "line1\nline2"},
+	))
+	if strings.Contains(raw, "\r\nBcc:") {
+		t.Fatalf("expected subject line breaks to be stripped:\n%s", raw)
+	}
+	if !strings.Contains(raw, "line1\r\nline2") {
+		t.Fatalf("expected CRLF body line endings:\n%s", raw)
+	}
+}
@@ -0,0 +1,268 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats.
+const (
+	// FormatPrometheus pushes a snappy-compressed protobuf WriteRequest to
+	// a Prometheus remote_write receiver (Prometheus, Mimir, VictoriaMetrics).
+	FormatPrometheus = "prometheus"
+	// FormatInflux posts InfluxDB line protocol, to the v1 /write or the
+	// v2 /api/v2/write endpoint.
+	FormatInflux = "influx"
+)
+
+const exportTimeout = 10 * time.Second
+
+// ExportOptions configures an Exporter. Token is sent as a bearer token
+// to Prometheus receivers and as "Token <token>" to InfluxDB; Username and
+// Password use basic auth instead. Labels are added to every series.
+type ExportOptions struct {
+	Format   string
+	URL      string
+	Token    string
+	Username string
+	Password string
+	Labels   map[string]string
+	Client   *http.Client
+}
+
+// Exporter pushes registry snapshots to an external time series database.
+type Exporter struct {
+	format   string
+	url      string
+	token    string
+	username string
+	password string
+	labels   map[string]string
+	client   *http.Client
+}
+
+// series is one flattened sample: counters and gauges map to one series,
+// histograms to _bucket, _sum and _count series.
+type series struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// NewExporter validates opts and returns an exporter.
+func NewExporter(opts ExportOptions) (*Exporter, error) {
+	switch opts.Format {
+	case FormatPrometheus, FormatInflux:
+	default:
+		return nil, fmt.Errorf("invalid export format %q: must be %s or %s", opts.Format, FormatPrometheus, FormatInflux)
+	}
+	u, err := url.Parse(opts.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid export url %q", opts.URL)
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: exportTimeout}
+	}
+	return &Exporter{
+		format:   opts.Format,
+		url:      opts.URL,
+		token:    opts.Token,
+		username: opts.Username,
+		password: opts.Password,
+		labels:   copyLabels(opts.Labels),
+		client:   opts.Client,
+	}, nil
+}
+
+// Push sends every series of snap stamped with at.
+func (e *Exporter) Push(ctx context.Context, snap Snapshot, at time.Time) error {
+	all := e.flatten(snap)
+	if len(all) == 0 {
+		return nil
+	}
+	var (
+		body []byte
+		auth string
+	)
+	header := http.Header{}
+	switch e.format {
+	case FormatPrometheus:
+		body = snappyEncode(encodeWriteRequest(all, at.UnixMilli()))
+		header.Set("Content-Type", "application/x-protobuf")
+		header.Set("Content-Encoding", "snappy")
+		header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+		auth = "Bearer "
+	case FormatInflux:
+		body = encodeLineProtocol(all, at.Unix())
+		header.Set("Content-Type", "text/plain; charset=utf-8")
+		auth = "Token "
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("export metrics: %w", err)
+	}
+	req.Header = header
+	switch {
+	case e.username != "":
+		req.SetBasicAuth(e.username, e.password)
+	case e.token != "":
+		req.Header.Set("Authorization", auth+e.token)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("export metrics: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("export metrics: %s answered %d: %s", e.url, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (e *Exporter) flatten(snap Snapshot) []series {
+	out := make([]series, 0, len(snap.Counters)+len(snap.Gauges))
+	add := func(name string, labels map[string]string, value float64) {
+		merged := copyLabels(e.labels)
+		if merged == nil {
+			merged = map[string]string{}
+		}
+		for k, v := range labels {
+			merged[k] = v
+		}
+		out = append(out, series{name: name, labels: merged, value: value})
+	}
+	for _, c := range snap.Counters {
+		add(c.Name, c.Labels, float64(c.Value))
+	}
+	for _, g := range snap.Gauges {
+		add(g.Name, g.Labels, g.Value)
+	}
+	for _, h := range snap.Histograms {
+		for i, count := range h.Counts {
+			le := "+Inf"
+			if i < len(h.Buckets) {
+				le = strconv.FormatFloat(h.Buckets[i], 'g', -1, 64)
+			}
+			labels := copyLabels(h.Labels)
+			if labels == nil {
+				labels = map[string]string{}
+			}
+			labels["le"] = le
+			add(h.Name+"_bucket", labels, float64(count))
+		}
+		add(h.Name+"_sum", h.Labels, h.Sum)
+		add(h.Name+"_count", h.Labels, float64(h.Count))
+	}
+	return out
+}
+
+// encodeWriteRequest encodes a prometheus.WriteRequest:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+//
+// Labels are sorted by name with __name__ among them, as receivers expect.
+func encodeWriteRequest(all []series, ms int64) []byte {
+	var req []byte
+	for _, s := range all {
+		names := make([]string, 0, len(s.labels)+1)
+		values := map[string]string{"__name__": s.name}
+		names = append(names, "__name__")
+		for k, v := range s.labels {
+			names = append(names, k)
+			values[k] = v
+		}
+		sort.Strings(names)
+		var ts []byte
+		for _, name := range names {
+			var label []byte
+			label = appendBytesField(label, 1, []byte(name))
+			label = appendBytesField(label, 2, []byte(values[name]))
+			ts = appendBytesField(ts, 1, label)
+		}
+		var sample []byte
+		sample = append(sample, 1<<3|1)
+		sample = binary.LittleEndian.AppendUint64(sample, math.Float64bits(s.value))
+		sample = append(sample, 2<<3)
+		sample = binary.AppendUvarint(sample, uint64(ms))
+		ts = appendBytesField(ts, 2, sample)
+		req = appendBytesField(req, 1, ts)
+	}
+	return req
+}
+
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// snappyEncode frames src as a snappy block made of literals only. It
+// does not compress, but every snappy decoder accepts it and it keeps the
+// panel free of a compression dependency; pushes are small.
+func snappyEncode(src []byte) []byte {
+	dst := binary.AppendUvarint(make([]byte, 0, len(src)+len(src)/65536*3+16), uint64(len(src)))
+	for len(src) > 0 {
+		n := min(len(src), 65536)
+		switch {
+		case n <= 60:
+			dst = append(dst, byte(n-1)<<2)
+		case n <= 256:
+			dst = append(dst, 60<<2, byte(n-1))
+		default:
+			dst = append(dst, 61<<2, byte(n-1), byte((n-1)>>8))
+		}
+		dst = append(dst, src[:n]...)
+		src = src[n:]
+	}
+	return dst
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// encodeLineProtocol writes one line per series with the value in a
+// "value" field; NaN and infinite values, which InfluxDB rejects, are
+// skipped.
+func encodeLineProtocol(all []series, sec int64) []byte {
+	var b bytes.Buffer
+	for _, s := range all {
+		if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+			continue
+		}
+		b.WriteString(influxMeasurementEscaper.Replace(s.name))
+		for _, k := range sortedKeys(s.labels) {
+			if s.labels[k] == "" {
+				continue
+			}
+			b.WriteByte(',')
+			b.WriteString(influxTagEscaper.Replace(k))
+			b.WriteByte('=')
+			b.WriteString(influxTagEscaper.Replace(s.labels[k]))
+		}
+		b.WriteString(" value=")
+		b.WriteString(strconv.FormatFloat(s.value, 'g', -1, 64))
+		b.WriteByte(' ')
+		b.WriteString(strconv.FormatInt(sec, 10))
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,190 @@
+package metrics
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type pushed struct {
+	header http.Header
+	body   []byte
+}
+
+func newPushServer(t *testing.T, status int) (*httptest.Server, *[]pushed) {
+	t.Helper()
+	got := &[]pushed{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*got = append(*got, pushed{header: r.Header.Clone(), body: body})
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, got
+}
+
+func testSnapshot() Snapshot {
+	r := NewRegistry()
+	r.AddCounter("site_requests_total", map[string]string{"site": "example.com"}, 120)
+	r.SetGauge("site_error_rate_percent", map[string]string{"site": "example.com"}, 2.5)
+	r.SetGauge("nginx_active_connections", nil, math.NaN())
+	r.Observe("http_request_duration_seconds", map[string]string{"route": "/api/sites"}, []float64{0.1, 1}, 0.3)
+	return r.Snapshot()
+}
+
+// readSnappyLiterals undoes snappyEncode; it accepts literal-only blocks.
+func readSnappyLiterals(t *testing.T, b []byte) []byte {
+	t.Helper()
+	n, k := binary.Uvarint(b)
+	b = b[k:]
+	var out []byte
+	for len(b) > 0 {
+		tag := b[0]
+		if tag&3 != 0 {
+			t.Fatalf("unexpected snappy copy element %#x", tag)
+		}
+		size := int(tag>>2) + 1
+		b = b[1:]
+		switch tag >> 2 {
+		case 60:
+			size, b = int(b[0])+1, b[1:]
+		case 61:
+			size, b = int(binary.LittleEndian.Uint16(b))+1, b[2:]
+		}
+		out, b = append(out, b[:size]...), b[size:]
+	}
+	if uint64(len(out)) != n {
+		t.Fatalf("snappy length %d, decoded %d", n, len(out))
+	}
+	return out
+}
+
+// readFields splits a protobuf message into its length-delimited fields
+// and fixed64/varint values.
+func readFields(t *testing.T, b []byte) map[uint64][][]byte {
+	t.Helper()
+	fields := map[uint64][][]byte{}
+	for len(b) > 0 {
+		key, k := binary.Uvarint(b)
+		b = b[k:]
+		switch key & 7 {
+		case 0:
+			_, k = binary.Uvarint(b)
+			fields[key>>3] = append(fields[key>>3], b[:k])
+			b = b[k:]
+		case 1:
+			fields[key>>3] = append(fields[key>>3], b[:8])
+			b = b[8:]
+		case 2:
+			size, k := binary.Uvarint(b)
+			fields[key>>3] = append(fields[key>>3], b[k:k+int(size)])
+			b = b[k+int(size):]
+		default:
+			t.Fatalf("unexpected wire type in key %d", key)
+		}
+	}
+	return fields
+}
+
+func TestExporter_PrometheusRemoteWrite(t *testing.T) {
+	srv, got := newPushServer(t, http.StatusNoContent)
+	exp, err := NewExporter(ExportOptions{Format: FormatPrometheus, URL: srv.URL, Token: "secret", Labels: map[string]string{"instance": "web1"}})
+	if err != nil {
+		t.Fatalf("new exporter: %v", err)
+	}
+	at := time.UnixMilli(1792144800123)
+	if err := exp.Push(context.Background(), testSnapshot(), at); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if len(*got) != 1 {
+		t.Fatalf("expected one push, got %d", len(*got))
+	}
+	h := (*got)[0].header
+	if h.Get("Content-Encoding") != "snappy" || h.Get("Content-Type") != "application/x-protobuf" ||
+		h.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" || h.Get("Authorization") != "Bearer secret" {
+		t.Fatalf("unexpected headers %v", h)
+	}
+
+	series := map[string]float64{}
+	for _, ts := range readFields(t, readSnappyLiterals(t, (*got)[0].body))[1] {
+		fields := readFields(t, ts)
+		var labels []string
+		for _, l := range fields[1] {
+			kv := readFields(t, l)
+			labels = append(labels, string(kv[1][0])+"="+string(kv[2][0]))
+		}
+		sample := readFields(t, fields[2][0])
+		if ms, _ := binary.Uvarint(sample[2][0]); ms != 1792144800123 {
+			t.Fatalf("unexpected timestamp %d", ms)
+		}
+		series[strings.Join(labels, ",")] = math.Float64frombits(binary.LittleEndian.Uint64(sample[1][0]))
+	}
+	want := map[string]float64{
+		"__name__=site_requests_total,instance=web1,site=example.com":                       120,
+		"__name__=site_error_rate_percent,instance=web1,site=example.com":                   2.5,
+		"__name__=http_request_duration_seconds_bucket,instance=web1,le=1,route=/api/sites": 1,
+		"__name__=http_request_duration_seconds_count,instance=web1,route=/api/sites":       1,
+	}
+	for key, value := range want {
+		if v, ok := series[key]; !ok || v != value {
+			t.Fatalf("expected %s = %v, got %v in %v", key, value, v, series)
+		}
+	}
+	if len(series) != 8 {
+		t.Fatalf("expected 8 series, got %d: %v", len(series), series)
+	}
+}
+
+func TestExporter_InfluxLineProtocol(t *testing.T) {
+	srv, got := newPushServer(t, http.StatusNoContent)
+	exp, err := NewExporter(ExportOptions{Format: FormatInflux, URL: srv.URL + "/api/v2/write", Token: "secret",
+		Labels: map[string]string{"instance": "web 1"}})
+	if err != nil {
+		t.Fatalf("new exporter: %v", err)
+	}
+	if err := exp.Push(context.Background(), testSnapshot(), time.Unix(1792144800, 0)); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if h := (*got)[0].header; h.Get("Authorization") != "Token secret" {
+		t.Fatalf("unexpected headers %v", h)
+	}
+	body := string((*got)[0].body)
+	for _, line := range []string{
+		`site_requests_total,instance=web\ 1,site=example.com value=120 1792144800`,
+		`site_error_rate_percent,instance=web\ 1,site=example.com value=2.5 1792144800`,
+		`http_request_duration_seconds_bucket,instance=web\ 1,le=+Inf,route=/api/sites value=1 1792144800`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("expected %q in\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, "nginx_active_connections") {
+		t.Fatalf("expected the NaN gauge skipped, got\n%s", body)
+	}
+}
+
+func TestExporter_Errors(t *testing.T) {
+	if _, err := NewExporter(ExportOptions{Format: "graphite", URL: "http://127.0.0.1"}); err == nil {
+		t.Fatal("expected an unknown format rejected")
+	}
+	if _, err := NewExporter(ExportOptions{Format: FormatInflux, URL: "127.0.0.1:8086"}); err == nil {
+		t.Fatal("expected an url without a scheme rejected")
+	}
+	srv, _ := newPushServer(t, http.StatusBadRequest)
+	exp, err := NewExporter(ExportOptions{Format: FormatInflux, URL: srv.URL, Username: "panel", Password: "pw"})
+	if err != nil {
+		t.Fatalf("new exporter: %v", err)
+	}
+	if err := exp.Push(context.Background(), testSnapshot(), time.Now()); err == nil || !strings.Contains(err.Error(), "answered 400") {
+		t.Fatalf("expected the rejected push reported, got %v", err)
+	}
+	if err := exp.Push(context.Background(), Snapshot{}, time.Now()); err != nil {
+		t.Fatalf("expected an empty snapshot skipped, got %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+// Package metrics provides in-process counters and histograms for panel internals.
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultDurationBuckets are histogram upper bounds (seconds) for request latency.
+var DefaultDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Default is the process-wide registry used by HTTP middleware and exporters.
+var Default = NewRegistry()
+
+// Registry stores labelled counters, gauges and histograms.
+type Registry struct {
+	mu         sync.Mutex
+	counters   map[string]*counter
+	gauges     map[string]*gauge
+	histograms map[string]*histogram
+}
+
+// CounterSample is a point-in-time counter value.
+type CounterSample struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  uint64            `json:"value"`
+}
+
+// GaugeSample is the last value set on a gauge.
+type GaugeSample struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// HistogramSample is a point-in-time histogram value. Counts[i] is the
+// number of observations <= Buckets[i]; the final entry of Counts is +Inf.
+type HistogramSample struct {
+	Name    string            `json:"name"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Buckets []float64         `json:"buckets"`
+	Counts  []uint64          `json:"counts"`
+	Count   uint64            `json:"count"`
+	Sum     float64           `json:"sum"`
+}
+
+// Snapshot contains all registry samples sorted by name and labels.
+type Snapshot struct {
+	Counters   []CounterSample   `json:"counters"`
+	Gauges     []GaugeSample     `json:"gauges"`
+	Histograms []HistogramSample `json:"histograms"`
+}
+
+type counter struct {
+	name   string
+	labels map[string]string
+	value  uint64
+}
+
+type gauge struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+type histogram struct {
+	name    string
+	labels  map[string]string
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		counters:   map[string]*counter{},
+		gauges:     map[string]*gauge{},
+		histograms: map[string]*histogram{},
+	}
+}
+
+// IncCounter increments the counter identified by name and labels.
+func (r *Registry) IncCounter(name string, labels map[string]string) {
+	if r == nil {
+		return
+	}
+	key := seriesKey(name, labels)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.counters[key]
+	if !ok {
+		c = &counter{name: name, labels: copyLabels(labels)}
+		r.counters[key] = c
+	}
+	c.value++
+}
+
+// AddCounter adds delta to the counter identified by name and labels, for
+// collectors that count in batches.
+func (r *Registry) AddCounter(name string, labels map[string]string, delta uint64) {
+	if r == nil {
+		return
+	}
+	key := seriesKey(name, labels)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.counters[key]
+	if !ok {
+		c = &counter{name: name, labels: copyLabels(labels)}
+		r.counters[key] = c
+	}
+	c.value += delta
+}
+
+// SetGauge sets the gauge identified by name and labels to value.
+func (r *Registry) SetGauge(name string, labels map[string]string, value float64) {
+	if r == nil {
+		return
+	}
+	key := seriesKey(name, labels)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	g, ok := r.gauges[key]
+	if !ok {
+		g = &gauge{name: name, labels: copyLabels(labels)}
+		r.gauges[key] = g
+	}
+	g.value = value
+}
+
+// Observe records value in the histogram identified by name and labels.
+// Buckets are fixed on first observation; nil uses DefaultDurationBuckets.
+func (r *Registry) Observe(name string, labels map[string]string, buckets []float64, value float64) {
+	if r == nil {
+		return
+	}
+	if len(buckets) == 0 {
+		buckets = DefaultDurationBuckets
+	}
+	key := seriesKey(name, labels)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.histograms[key]
+	if !ok {
+		h = &histogram{
+			name:    name,
+			labels:  copyLabels(labels),
+			buckets: append([]float64(nil), buckets...),
+			counts:  make([]uint64, len(buckets)+1),
+		}
+		r.histograms[key] = h
+	}
+	idx := sort.SearchFloat64s(h.buckets, value)
+	for i := idx; i < len(h.counts); i++ {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += value
+}
+
+// Snapshot returns a copy of all series.
+func (r *Registry) Snapshot() Snapshot {
+	if r == nil {
+		return Snapshot{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := Snapshot{
+		Counters:   make([]CounterSample, 0, len(r.counters)),
+		Gauges:     make([]GaugeSample, 0, len(r.gauges)),
+		Histograms: make([]HistogramSample, 0, len(r.histograms)),
+	}
+	for _, key := range sortedKeys(r.counters) {
+		c := r.counters[key]
+		out.Counters = append(out.Counters, CounterSample{Name: c.name, Labels: copyLabels(c.labels), Value: c.value})
+	}
+	for _, key := range sortedKeys(r.gauges) {
+		g := r.gauges[key]
+		out.Gauges = append(out.Gauges, GaugeSample{Name: g.name, Labels: copyLabels(g.labels), Value: g.value})
+	}
+	for _, key := range sortedKeys(r.histograms) {
+		h := r.histograms[key]
+		out.Histograms = append(out.Histograms, HistogramSample{
+			Name:    h.name,
+			Labels:  copyLabels(h.labels),
+			Buckets: append([]float64(nil), h.buckets...),
+			Counts:  append([]uint64(nil), h.counts...),
+			Count:   h.count,
+			Sum:     h.sum,
+		})
+	}
+	return out
+}
+
+func seriesKey(name string, labels map[string]string) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, k := range sortedKeys(labels) {
+		b.WriteByte('|')
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+	}
+	return b.String()
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(labels))
+	for k, v := range labels {
+		out[k] = v
+	}
+	return out
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// BodyLimitOptions controls BodyLimitMiddleware.
+type BodyLimitOptions struct {
+	// MaxBytes bounds request bodies unless a prefix in Overrides matches.
+	MaxBytes int64
+	// Overrides maps path prefixes (e.g. upload endpoints) to their own
+	// limit; the longest matching prefix wins.
+	Overrides map[string]int64
+}
+
+// BodyLimitMiddleware rejects requests whose declared Content-Length is
+// over the limit with 413 and caps the body of all others, so chunked
+// uploads fail once they cross it instead of filling memory or disk.
+func BodyLimitMiddleware(opts BodyLimitOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit := opts.limitFor(r.URL.Path)
+			if limit <= 0 || r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.ContentLength > limit {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (o BodyLimitOptions) limitFor(path string) int64 {
+	limit, matched := o.MaxBytes, 0
+	for prefix, n := range o.Overrides {
+		if len(prefix) > matched && strings.HasPrefix(path, prefix) {
+			limit, matched = n, len(prefix)
+		}
+	}
+	return limit
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	h := BodyLimitMiddleware(BodyLimitOptions{
+		MaxBytes:  8,
+		Overrides: map[string]int64{"/api/files/upload": 64},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	cases := []struct {
+		name    string
+		path    string
+		body    string
+		chunked bool
+		want    int
+	}{
+		{name: "small body", path: "/api/sites", body: "{}", want: http.StatusNoContent},
+		{name: "declared length over limit", path: "/api/sites", body: strings.Repeat("x", 9), want: http.StatusRequestEntityTooLarge},
+		{name: "chunked body over limit", path: "/api/sites", body: strings.Repeat("x", 9), chunked: true, want: http.StatusRequestEntityTooLarge},
+		{name: "upload path override", path: "/api/files/upload", body: strings.Repeat("x", 32), want: http.StatusNoContent},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+			if tc.chunked {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Fatalf("expected %d, got %d (%s)", tc.want, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
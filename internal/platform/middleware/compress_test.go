@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressMiddleware(t *testing.T) {
+	body := strings.Repeat(`{"domain":"example.com"}`, 100)
+	cases := []struct {
+		name        string
+		contentType string
+		accept      string
+		upgrade     string
+		wantGzip    bool
+	}{
+		{name: "json is compressed", contentType: "application/json", accept: "gzip, deflate", wantGzip: true},
+		{name: "text wildcard matches", contentType: "text/plain; charset=utf-8", accept: "gzip", wantGzip: true},
+		{name: "client without gzip", contentType: "application/json", accept: "br"},
+		{name: "gzip refused with q=0", contentType: "application/json", accept: "gzip;q=0"},
+		{name: "binary type is not allowlisted", contentType: "application/vnd.sqlite3", accept: "gzip"},
+		{name: "websocket upgrade passes through", contentType: "application/json", accept: "gzip", upgrade: "websocket"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := CompressMiddleware(CompressOptions{})(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", tc.contentType)
+				_, _ = io.WriteString(w, body)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/api/sites", nil)
+			req.Header.Set("Accept-Encoding", tc.accept)
+			if tc.upgrade != "" {
+				req.Header.Set("Upgrade", tc.upgrade)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			gotGzip := rec.Header().Get("Content-Encoding") == "gzip"
+			if gotGzip != tc.wantGzip {
+				t.Fatalf("expected gzip=%t, got headers %v", tc.wantGzip, rec.Header())
+			}
+			got := rec.Body.String()
+			if gotGzip {
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatalf("gzip reader: %v", err)
+				}
+				raw, err := io.ReadAll(zr)
+				if err != nil {
+					t.Fatalf("read gzip body: %v", err)
+				}
+				got = string(raw)
+			}
+			if got != body {
+				t.Fatalf("body mismatch: got %d bytes", len(got))
+			}
+		})
+	}
+}
+
+func TestCompressMiddlewareSkipsSmallAndEmptyResponses(t *testing.T) {
+	h := CompressMiddleware(CompressOptions{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/empty" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", "2")
+		_, _ = io.WriteString(w, "{}")
+	}))
+	for _, path := range []string{"/small", "/empty"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Header().Get("Content-Encoding") != "" {
+			t.Fatalf("%s: expected no compression, got %v", path, rec.Header())
+		}
+		if rec.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatalf("%s: expected Vary: Accept-Encoding", path)
+		}
+	}
+}
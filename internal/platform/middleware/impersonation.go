@@ -0,0 +1,18 @@
+package middleware
+
+import "context"
+
+const impersonatorKey ctxKey = "impersonator"
+
+// WithImpersonator marks ctx as acting on behalf of another user, the
+// admin identified by email, so audit events can name both identities.
+func WithImpersonator(ctx context.Context, email string) context.Context {
+	return context.WithValue(ctx, impersonatorKey, email)
+}
+
+// Impersonator returns the admin impersonating the request's user, or ""
+// for ordinary requests.
+func Impersonator(ctx context.Context) string {
+	v, _ := ctx.Value(impersonatorKey).(string)
+	return v
+}
@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/metrics"
+)
+
+const (
+	defaultMaxLoggedBodyBytes = 4096
+	redactedValue             = "[REDACTED]"
+)
+
+// sensitiveKeyFragments marks JSON keys whose values are never logged.
+var sensitiveKeyFragments = []string{
+	"password",
+	"passwd",
+	"secret",
+	"token",
+	"authorization",
+	"api_key",
+	"apikey",
+	"private_key",
+	"cookie",
+}
+
+// LoggingOptions controls request logging and metrics collection.
+type LoggingOptions struct {
+	// LogBodies logs redacted request bodies of mutating /api/ calls.
+	LogBodies bool
+	// MaxBodyBytes caps how much of a request body is captured for logging.
+	MaxBodyBytes int
+	// SampleRate is the fraction (0..1] of successful requests that are logged.
+	// Requests answered with status >= 400 are always logged.
+	SampleRate float64
+	// Metrics receives request counters and duration histograms (nil disables).
+	Metrics *metrics.Registry
+}
+
+// LoggingMiddlewareWithOptions logs request metadata using slog, with optional
+// sampling, redacted body logging and status/duration metrics.
+func LoggingMiddlewareWithOptions(log *slog.Logger, opts LoggingOptions) func(http.Handler) http.Handler {
+	if opts.MaxBodyBytes <= 0 {
+		opts.MaxBodyBytes = defaultMaxLoggedBodyBytes
+	}
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		opts.SampleRate = 1
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			var body []byte
+			if opts.LogBodies && shouldLogBody(r) {
+				body = captureBody(r, opts.MaxBodyBytes)
+			}
+			rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rw, r)
+			duration := time.Since(start)
+
+			recordRequestMetrics(opts.Metrics, r.Method, rw.status, duration)
+			if rw.status < http.StatusBadRequest && !sampled(opts.SampleRate) {
+				return
+			}
+			attrs := []any{
+				"request_id", RequestID(r.Context()),
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", rw.status,
+				"duration_ms", duration.Milliseconds(),
+				"remote_addr", r.RemoteAddr,
+			}
+			if body != nil {
+				attrs = append(attrs, "body", RedactBody(body))
+			}
+			if opts.SampleRate < 1 {
+				attrs = append(attrs, "sample_rate", opts.SampleRate)
+			}
+			log.Info("http_request", attrs...)
+		})
+	}
+}
+
+// RedactBody returns a log-safe rendering of a request body. JSON values
+// under sensitive keys are replaced; non-JSON payloads are summarized.
+func RedactBody(body []byte) string {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return ""
+	}
+	var v any
+	if err := json.Unmarshal(trimmed, &v); err != nil {
+		return "[non-json body: " + strconv.Itoa(len(body)) + " bytes]"
+	}
+	out, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return "[unencodable body]"
+	}
+	return string(out)
+}
+
+func redactValue(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, val := range t {
+			if isSensitiveKey(k) {
+				t[k] = redactedValue
+				continue
+			}
+			t[k] = redactValue(val)
+		}
+		return t
+	case []any:
+		for i := range t {
+			t[i] = redactValue(t[i])
+		}
+		return t
+	default:
+		return v
+	}
+}
+
+func isSensitiveKey(key string) bool {
+	k := strings.ToLower(key)
+	for _, fragment := range sensitiveKeyFragments {
+		if strings.Contains(k, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+func shouldLogBody(r *http.Request) bool {
+	if r.Body == nil || !strings.HasPrefix(r.URL.Path, "/api/") {
+		return false
+	}
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// captureBody reads up to limit bytes and restores them in front of the
+// remaining body so handlers still see the full payload.
+func captureBody(r *http.Request, limit int) []byte {
+	buf, err := io.ReadAll(io.LimitReader(r.Body, int64(limit)))
+	if err != nil {
+		return nil
+	}
+	r.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(buf), r.Body), Closer: r.Body}
+	return buf
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func sampled(rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	//nolint:gosec // G404: log sampling does not need a cryptographic source.
+	return rand.Float64() < rate
+}
+
+func recordRequestMetrics(reg *metrics.Registry, method string, status int, duration time.Duration) {
+	if reg == nil {
+		return
+	}
+	reg.IncCounter("http_requests_total", map[string]string{
+		"method": method,
+		"status": strconv.Itoa(status),
+	})
+	reg.Observe("http_request_duration_seconds", map[string]string{
+		"method":       method,
+		"status_class": strconv.Itoa(status/100) + "xx",
+	}, metrics.DefaultDurationBuckets, duration.Seconds())
+}
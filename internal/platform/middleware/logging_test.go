@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/metrics"
+)
+
+func TestRedactBody(t *testing.T) {
+	got := RedactBody([]byte(`{"email":"admin@example.com","password":"secret123","nested":{"api_token":"abc"},"list":[{"db_password":"x"}]}`))
+	for _, leaked := range []string{"secret123", `"abc"`, `"x"`} {
+		if strings.Contains(got, leaked) {
+			t.Fatalf("expected %s to be redacted, got %s", leaked, got)
+		}
+	}
+	if !strings.Contains(got, "admin@example.com") {
+		t.Fatalf("expected non-sensitive fields to be kept, got %s", got)
+	}
+	if got := RedactBody([]byte("password=secret")); strings.Contains(got, "secret") {
+		t.Fatalf("expected non-json body to be summarized, got %s", got)
+	}
+}
+
+func TestLoggingMiddlewareWithOptions_LogsRedactedBodyAndMetrics(t *testing.T) {
+	var logs bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&logs, nil))
+	reg := metrics.NewRegistry()
+
+	var seenBody string
+	h := LoggingMiddlewareWithOptions(log, LoggingOptions{LogBodies: true, Metrics: reg})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			seenBody = string(b)
+			w.WriteHeader(http.StatusCreated)
+		}),
+	)
+
+	body := `{"email":"admin@example.com","password":"secret123"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(body))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if seenBody != body {
+		t.Fatalf("expected handler to receive full body, got %q", seenBody)
+	}
+	if strings.Contains(logs.String(), "secret123") {
+		t.Fatalf("expected password to be redacted in logs: %s", logs.String())
+	}
+	if !strings.Contains(logs.String(), redactedValue) {
+		t.Fatalf("expected redacted body in logs: %s", logs.String())
+	}
+
+	snap := reg.Snapshot()
+	if len(snap.Counters) != 1 || snap.Counters[0].Labels["status"] != "201" || snap.Counters[0].Value != 1 {
+		t.Fatalf("unexpected counters: %+v", snap.Counters)
+	}
+	if len(snap.Histograms) != 1 || snap.Histograms[0].Count != 1 {
+		t.Fatalf("unexpected histograms: %+v", snap.Histograms)
+	}
+}
+
+func TestLoggingMiddlewareWithOptions_SamplingKeepsErrors(t *testing.T) {
+	var logs bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&logs, nil))
+	status := http.StatusOK
+	h := LoggingMiddlewareWithOptions(log, LoggingOptions{SampleRate: 0.0000001})(
+		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(status)
+		}),
+	)
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/sites", nil))
+	if logs.Len() != 0 {
+		t.Fatalf("expected successful request to be sampled out, got %s", logs.String())
+	}
+
+	status = http.StatusInternalServerError
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/sites", nil))
+	if !strings.Contains(logs.String(), `"status":500`) {
+		t.Fatalf("expected error response to be logged, got %s", logs.String())
+	}
+}
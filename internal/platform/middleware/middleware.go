@@ -61,21 +61,7 @@ func CORSMiddleware(next http.Handler) http.Handler {
 
 // LoggingMiddleware logs request metadata using slog.
 func LoggingMiddleware(log *slog.Logger) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			start := time.Now()
-			rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
-			next.ServeHTTP(rw, r)
-			log.Info("http_request",
-				"request_id", RequestID(r.Context()),
-				"method", r.Method,
-				"path", r.URL.Path,
-				"status", rw.status,
-				"duration_ms", time.Since(start).Milliseconds(),
-				"remote_addr", r.RemoteAddr,
-			)
-		})
-	}
+	return LoggingMiddlewareWithOptions(log, LoggingOptions{})
 }
 
 // Chain applies middleware in order.
@@ -96,6 +82,12 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap exposes the underlying writer to http.ResponseController, so
+// handlers behind the logging middleware can flush and hijack.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func newRequestID() string {
 	b := make([]byte, 12)
 	if _, err := rand.Read(b); err != nil {
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const clientIPKey ctxKey = "client_ip"
+
+// ClientIP returns the client address resolved by RealIPMiddleware, or ""
+// outside of a request.
+func ClientIP(ctx context.Context) string {
+	v, _ := ctx.Value(clientIPKey).(string)
+	return v
+}
+
+// ParseTrustedProxies parses IP addresses and CIDR ranges of proxies whose
+// forwarding headers are believed.
+func ParseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	out := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+			}
+			out = append(out, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		addr = addr.Unmap()
+		out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return out, nil
+}
+
+// RealIPMiddleware replaces r.RemoteAddr with the client address when the
+// request arrives through trusted proxies, so logs, audit entries and rate
+// limits see the client rather than nginx.
+//
+// X-Forwarded-For is walked from the right, skipping trusted hops; the
+// first untrusted address is the client, since anything further left was
+// written by the client itself. CF-Connecting-IP, then X-Real-IP, are only
+// used when every hop is trusted, e.g. Cloudflare ranges are listed as
+// trusted proxies. Unix socket peers count as trusted: only nginx can
+// connect to the panel socket.
+func RealIPMiddleware(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := resolveClientIP(r, trusted); ip != "" {
+				r.RemoteAddr = ip
+				r = r.WithContext(context.WithValue(r.Context(), clientIPKey, ip))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func resolveClientIP(r *http.Request, trusted []netip.Prefix) string {
+	peer, ok := parseIP(r.RemoteAddr)
+	if ok && !isTrusted(peer, trusted) {
+		return peer.String()
+	}
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, hopOK := parseIP(hops[i])
+		if !hopOK {
+			continue
+		}
+		if !isTrusted(hop, trusted) {
+			return hop.String()
+		}
+	}
+	for _, header := range []string{"CF-Connecting-IP", "X-Real-IP"} {
+		if ip, headerOK := parseIP(r.Header.Get(header)); headerOK {
+			return ip.String()
+		}
+	}
+	if ok {
+		return peer.String()
+	}
+	return ""
+}
+
+// parseIP accepts a bare address or host:port.
+func parseIP(value string) (netip.Addr, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return netip.Addr{}, false
+	}
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(value, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
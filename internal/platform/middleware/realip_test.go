@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIPMiddleware(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"127.0.0.0/8", "::1", "173.245.48.0/20"})
+	if err != nil {
+		t.Fatalf("parse trusted proxies: %v", err)
+	}
+	cases := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{name: "direct client ignores headers", remote: "198.51.100.7:5000", headers: map[string]string{"X-Forwarded-For": "10.0.0.1"}, want: "198.51.100.7"},
+		{name: "nginx forwards client", remote: "127.0.0.1:40000", headers: map[string]string{"X-Forwarded-For": "203.0.113.9"}, want: "203.0.113.9"},
+		{name: "spoofed prefix is skipped", remote: "127.0.0.1:40000", headers: map[string]string{"X-Forwarded-For": "1.2.3.4, 203.0.113.9"}, want: "203.0.113.9"},
+		{name: "cloudflare hop is trusted", remote: "[::1]:40000", headers: map[string]string{"X-Forwarded-For": "203.0.113.9, 173.245.48.10"}, want: "203.0.113.9"},
+		{name: "cf header behind trusted chain", remote: "127.0.0.1:40000", headers: map[string]string{"X-Forwarded-For": "173.245.48.10", "CF-Connecting-IP": "2001:db8::5"}, want: "2001:db8::5"},
+		{name: "cf header from untrusted hop is ignored", remote: "127.0.0.1:40000", headers: map[string]string{"X-Forwarded-For": "198.51.100.7", "CF-Connecting-IP": "1.2.3.4"}, want: "198.51.100.7"},
+		{name: "unix socket peer uses real ip", remote: "@", headers: map[string]string{"X-Real-IP": "203.0.113.9"}, want: "203.0.113.9"},
+		{name: "loopback without headers", remote: "127.0.0.1:40000", want: "127.0.0.1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var remote, ctxIP string
+			h := RealIPMiddleware(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				remote = r.RemoteAddr
+				ctxIP = ClientIP(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remote
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			if remote != tc.want || ctxIP != tc.want {
+				t.Fatalf("expected %s, got remote=%q ctx=%q", tc.want, remote, ctxIP)
+			}
+		})
+	}
+
+	if _, err := ParseTrustedProxies([]string{"not-a-cidr"}); err == nil {
+		t.Fatal("expected invalid trusted proxy to be rejected")
+	}
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// timeoutSlack is added to the connection deadlines so the handler can
+// still write its answer after the request context expired.
+const timeoutSlack = 5 * time.Second
+
+// TimeoutOptions controls TimeoutMiddleware.
+type TimeoutOptions struct {
+	// Budget returns the time a request may take; 0 disables the timeout
+	// (WebSocket upgrades, downloads of arbitrary size).
+	Budget func(*http.Request) time.Duration
+}
+
+// TimeoutMiddleware gives every request a context deadline so sqlite and
+// runner calls are cancelled before the connection is cut, and moves the
+// connection deadlines to match the budget so longer routes are not
+// truncated by the server's WriteTimeout. A handler that fails with a 5xx
+// after its budget ran out is answered with 503 instead.
+func TimeoutMiddleware(opts TimeoutOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var budget time.Duration
+			if opts.Budget != nil {
+				budget = opts.Budget(r)
+			}
+			if budget <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			deadline := time.Now().Add(budget + timeoutSlack)
+			rc := http.NewResponseController(w)
+			_ = rc.SetReadDeadline(deadline)
+			_ = rc.SetWriteDeadline(deadline)
+
+			ctx, cancel := context.WithTimeout(r.Context(), budget)
+			defer cancel()
+			tw := &timeoutWriter{ResponseWriter: w, ctx: ctx}
+			next.ServeHTTP(tw, r.WithContext(ctx))
+			if !tw.wroteHeader && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				tw.timeout()
+			}
+		})
+	}
+}
+
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	if tw.wroteHeader {
+		return
+	}
+	if code >= http.StatusInternalServerError && errors.Is(tw.ctx.Err(), context.DeadlineExceeded) {
+		tw.timeout()
+		return
+	}
+	tw.wroteHeader = true
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	if tw.timedOut {
+		// The handler's own error body is replaced by the timeout answer.
+		return len(p), nil
+	}
+	return tw.ResponseWriter.Write(p)
+}
+
+func (tw *timeoutWriter) timeout() {
+	tw.wroteHeader = true
+	tw.timedOut = true
+	h := tw.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	tw.ResponseWriter.WriteHeader(http.StatusServiceUnavailable)
+	_, _ = tw.ResponseWriter.Write([]byte("request timed out\n"))
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeoutMiddleware(t *testing.T) {
+	budget := func(r *http.Request) time.Duration {
+		if r.URL.Path == "/ws" {
+			return 0
+		}
+		return 20 * time.Millisecond
+	}
+	h := TimeoutMiddleware(TimeoutOptions{Budget: budget})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fast":
+			_, _ = w.Write([]byte("ok"))
+		case "/ws":
+			if _, ok := r.Context().Deadline(); ok {
+				t.Error("exempt request must not get a deadline")
+			}
+		case "/slow-error":
+			<-r.Context().Done()
+			http.Error(w, "failed to list sites: context deadline exceeded", http.StatusInternalServerError)
+		case "/slow-silent":
+			<-r.Context().Done()
+		}
+	}))
+	cases := []struct {
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{path: "/fast", wantCode: http.StatusOK, wantBody: "ok"},
+		{path: "/ws", wantCode: http.StatusOK},
+		{path: "/slow-error", wantCode: http.StatusServiceUnavailable, wantBody: "request timed out\n"},
+		{path: "/slow-silent", wantCode: http.StatusServiceUnavailable, wantBody: "request timed out\n"},
+	}
+	for _, tc := range cases {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != tc.wantCode || (tc.wantBody != "" && rec.Body.String() != tc.wantBody) {
+			t.Fatalf("%s: got %d %q", tc.path, rec.Code, rec.Body.String())
+		}
+		if strings.Contains(rec.Body.String(), "failed to list sites") {
+			t.Fatalf("%s: handler error leaked after timeout", tc.path)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+// Package notify defines the channel modules use to alert the operator.
+// The panel wires it to mail and the alert webhook at startup.
+package notify
+
+import "context"
+
+// Func delivers an operator alert (e.g. by mail).
+type Func func(ctx context.Context, subject, body string) error
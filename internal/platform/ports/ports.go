@@ -0,0 +1,223 @@
+// Package ports tracks which TCP ports the panel has handed out, so that a
+// service is refused before it is bound instead of failing in systemd.
+package ports
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+var (
+	// ErrReserved is returned when another owner holds the port in panel.db.
+	ErrReserved = errors.New("port is reserved")
+	// ErrInUse is returned when a process outside the panel listens on it.
+	ErrInUse = errors.New("port is already in use")
+)
+
+// Reservation is one port recorded in panel.db.
+type Reservation struct {
+	Port      int       `json:"port"`
+	Owner     string    `json:"owner"`
+	CreatedAt time.Time `json:"created_at"`
+	// Listening reports whether something accepts connections on the port
+	// right now; filled by List.
+	Listening bool `json:"listening"`
+}
+
+// Allocator reserves ports for panel-managed services.
+type Allocator struct {
+	store *sqlite.Store
+	now   func() time.Time
+	// listening is overridable in tests.
+	listening func(port int) bool
+}
+
+// New returns an allocator backed by panel.db.
+func New(store *sqlite.Store) *Allocator {
+	return &Allocator{store: store, now: time.Now, listening: Listening}
+}
+
+// Reserve records port for owner. It fails when another owner holds the
+// port or when a process is already listening on it. Reserving a port the
+// owner already holds is a no-op.
+func (a *Allocator) Reserve(ctx context.Context, owner string, port int) error {
+	return a.reserve(ctx, owner, port, true)
+}
+
+// Claim records port for owner without probing for listeners. It is meant
+// for services that are already running on the port.
+func (a *Allocator) Claim(ctx context.Context, owner string, port int) error {
+	return a.reserve(ctx, owner, port, false)
+}
+
+// Allocate reserves the first free port in [from, to] for owner. A port the
+// owner already holds in the range is returned as is.
+func (a *Allocator) Allocate(ctx context.Context, owner string, from, to int) (int, error) {
+	if err := validPort(from); err != nil {
+		return 0, err
+	}
+	if err := validPort(to); err != nil {
+		return 0, err
+	}
+	if from > to {
+		return 0, fmt.Errorf("invalid port range %d-%d", from, to)
+	}
+	taken, err := a.owners(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for port := from; port <= to; port++ {
+		if taken[port] == owner {
+			return port, nil
+		}
+	}
+	for port := from; port <= to; port++ {
+		if _, ok := taken[port]; ok || a.listening(port) {
+			continue
+		}
+		if err := a.Claim(ctx, owner, port); err != nil {
+			return 0, err
+		}
+		return port, nil
+	}
+	return 0, fmt.Errorf("no free port in range %d-%d", from, to)
+}
+
+// Release drops every port held by owner.
+func (a *Allocator) Release(ctx context.Context, owner string) error {
+	owner = strings.TrimSpace(owner)
+	if owner == "" {
+		return fmt.Errorf("port owner is required")
+	}
+	if err := a.store.ExecPanel(ctx, fmt.Sprintf(
+		"DELETE FROM port_reservations WHERE owner = '%s';", sqlEscape(owner),
+	)); err != nil {
+		return fmt.Errorf("release ports of %s: %w", owner, err)
+	}
+	return nil
+}
+
+// List returns every reservation ordered by port, with the live listener
+// state of each.
+func (a *Allocator) List(ctx context.Context) ([]Reservation, error) {
+	rows, err := a.store.QueryPanelJSON(ctx, "SELECT port, owner, created_at FROM port_reservations ORDER BY port;")
+	if err != nil {
+		return nil, fmt.Errorf("list port reservations: %w", err)
+	}
+	out := make([]Reservation, 0, len(rows))
+	for _, row := range rows {
+		r := Reservation{
+			Port:      int(toInt64(row["port"])),
+			CreatedAt: time.Unix(toInt64(row["created_at"]), 0).UTC(),
+		}
+		r.Owner, _ = row["owner"].(string)
+		r.Listening = a.listening(r.Port)
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+func (a *Allocator) reserve(ctx context.Context, owner string, port int, probe bool) error {
+	owner = strings.TrimSpace(owner)
+	if owner == "" {
+		return fmt.Errorf("port owner is required")
+	}
+	if err := validPort(port); err != nil {
+		return err
+	}
+	rows, err := a.store.QueryPanelJSON(ctx, fmt.Sprintf(
+		"SELECT owner FROM port_reservations WHERE port = %d;", port,
+	))
+	if err != nil {
+		return fmt.Errorf("look up port %d: %w", port, err)
+	}
+	if len(rows) > 0 {
+		holder, _ := rows[0]["owner"].(string)
+		if holder == owner {
+			return nil
+		}
+		return fmt.Errorf("%w: port %d is reserved for %s", ErrReserved, port, holder)
+	}
+	if probe && a.listening(port) {
+		return fmt.Errorf("%w: port %d is already in use by a process outside the panel", ErrInUse, port)
+	}
+	if err := a.store.ExecPanel(ctx, fmt.Sprintf(
+		"INSERT INTO port_reservations(port, owner, created_at) VALUES(%d, '%s', %d);",
+		port, sqlEscape(owner), a.now().Unix(),
+	)); err != nil {
+		return fmt.Errorf("reserve port %d for %s: %w", port, owner, err)
+	}
+	return nil
+}
+
+func (a *Allocator) owners(ctx context.Context) (map[int]string, error) {
+	rows, err := a.store.QueryPanelJSON(ctx, "SELECT port, owner FROM port_reservations;")
+	if err != nil {
+		return nil, fmt.Errorf("list port reservations: %w", err)
+	}
+	out := make(map[int]string, len(rows))
+	for _, row := range rows {
+		owner, _ := row["owner"].(string)
+		out[int(toInt64(row["port"]))] = owner
+	}
+	return out, nil
+}
+
+// Listening reports whether a process accepts TCP connections on port on
+// any local address. It binds the wildcard address and treats
+// EADDRINUSE as a listener.
+func Listening(port int) bool {
+	ln, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(port)))
+	if err != nil {
+		return errors.Is(err, syscall.EADDRINUSE)
+	}
+	_ = ln.Close()
+	return false
+}
+
+// PortOf returns the TCP port of a host:port listen address, or 0 when addr
+// has none (unix sockets, interface names).
+func PortOf(addr string) int {
+	_, p, err := net.SplitHostPort(strings.TrimSpace(addr))
+	if err != nil {
+		return 0
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil || validPort(port) != nil {
+		return 0
+	}
+	return port
+}
+
+func validPort(port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %d", port)
+	}
+	return nil
+}
+
+func sqlEscape(in string) string {
+	return strings.ReplaceAll(in, "'", "''")
+}
+
+func toInt64(v any) int64 {
+	switch t := v.(type) {
+	case float64:
+		return int64(t)
+	case int64:
+		return t
+	case string:
+		i, _ := strconv.ParseInt(t, 10, 64)
+		return i
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,110 @@
+package ports
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+func newTestAllocator(t *testing.T, busy ...int) *Allocator {
+	t.Helper()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(context.Background()); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	a := New(store)
+	a.listening = func(port int) bool {
+		for _, p := range busy {
+			if p == port {
+				return true
+			}
+		}
+		return false
+	}
+	return a
+}
+
+func TestReserve_ConflictsWithOtherOwner(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAllocator(t)
+	if err := a.Reserve(ctx, "pgadmin", 5050); err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+	if err := a.Reserve(ctx, "pgadmin", 5050); err != nil {
+		t.Fatalf("re-reserve by same owner: %v", err)
+	}
+	err := a.Reserve(ctx, "panel", 5050)
+	if !errors.Is(err, ErrReserved) {
+		t.Fatalf("expected ErrReserved, got %v", err)
+	}
+	if got := err.Error(); got != "port is reserved: port 5050 is reserved for pgadmin" {
+		t.Fatalf("unexpected message %q", got)
+	}
+	if err := a.Release(ctx, "pgadmin"); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if err := a.Reserve(ctx, "panel", 5050); err != nil {
+		t.Fatalf("reserve after release: %v", err)
+	}
+}
+
+func TestReserve_RejectsForeignListener(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAllocator(t, 8080)
+	if err := a.Reserve(ctx, "panel", 8080); !errors.Is(err, ErrInUse) {
+		t.Fatalf("expected ErrInUse, got %v", err)
+	}
+	if err := a.Claim(ctx, "panel", 8080); err != nil {
+		t.Fatalf("claim running service: %v", err)
+	}
+	list, err := a.List(ctx)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(list) != 1 || list[0].Port != 8080 || list[0].Owner != "panel" || !list[0].Listening {
+		t.Fatalf("unexpected reservations: %+v", list)
+	}
+	if err := a.Reserve(ctx, "panel", 0); err == nil {
+		t.Fatal("expected invalid port error")
+	}
+}
+
+func TestAllocate_SkipsTakenPorts(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAllocator(t, 3001)
+	if err := a.Reserve(ctx, "app-a", 3000); err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+	port, err := a.Allocate(ctx, "app-b", 3000, 3005)
+	if err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	if port != 3002 {
+		t.Fatalf("expected 3002, got %d", port)
+	}
+	again, err := a.Allocate(ctx, "app-b", 3000, 3005)
+	if err != nil || again != 3002 {
+		t.Fatalf("expected owner to keep 3002, got %d, %v", again, err)
+	}
+	if _, err := a.Allocate(ctx, "app-c", 3000, 3001); err == nil {
+		t.Fatal("expected exhausted range error")
+	}
+}
+
+func TestListening_DetectsBoundPort(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+	if !Listening(port) {
+		t.Fatalf("expected port %d to be reported as listening", port)
+	}
+	if PortOf("127.0.0.1:5050") != 5050 || PortOf("unix:/run/aipanel.sock") != 0 {
+		t.Fatal("unexpected PortOf result")
+	}
+}
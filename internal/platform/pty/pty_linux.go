@@ -0,0 +1,122 @@
+//go:build linux
+
+package pty
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+// Start runs cmd with a new pseudo-terminal as its controlling terminal and
+// stdio, and returns the master side.
+func Start(cmd *exec.Cmd) (*os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open ptmx: %w", err)
+	}
+	var unlock int32
+	if err := ioctl(master, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		_ = master.Close()
+		return nil, fmt.Errorf("unlock pty: %w", err)
+	}
+	var n uint32
+	if err := ioctl(master, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		_ = master.Close()
+		return nil, fmt.Errorf("get pty number: %w", err)
+	}
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		_ = master.Close()
+		return nil, fmt.Errorf("open pty slave: %w", err)
+	}
+	defer func() { _ = slave.Close() }()
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0
+	if err := cmd.Start(); err != nil {
+		_ = master.Close()
+		return nil, err
+	}
+	return master, nil
+}
+
+// Setsize sets the window size of the terminal; the foreground process
+// receives SIGWINCH.
+func Setsize(master *os.File, rows, cols int) error {
+	if rows <= 0 || cols <= 0 || rows > 0xffff || cols > 0xffff {
+		return fmt.Errorf("invalid terminal size %dx%d", cols, rows)
+	}
+	ws := struct{ Row, Col, X, Y uint16 }{Row: uint16(rows), Col: uint16(cols)}
+	return ioctl(master, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+}
+
+// ioctl goes through SyscallConn: File.Fd would switch the master to
+// blocking mode and break read deadlines.
+func ioctl(f *os.File, req, arg uintptr) error {
+	raw, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	if err := raw.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Echo reports whether the terminal echoes input. Programs turn echo off
+// while reading passwords.
+func Echo(master *os.File) (bool, error) {
+	var termios syscall.Termios
+	if err := ioctl(master, syscall.TCGETS, uintptr(unsafe.Pointer(&termios))); err != nil {
+		return false, err
+	}
+	return termios.Lflag&syscall.ECHO != 0, nil
+}
+
+// DisableEcho turns off echo on the terminal f, e.g. while a password is
+// typed, and returns a function that restores the previous settings.
+func DisableEcho(f *os.File) (func() error, error) {
+	var termios syscall.Termios
+	if err := ioctl(f, syscall.TCGETS, uintptr(unsafe.Pointer(&termios))); err != nil {
+		return nil, err
+	}
+	saved := termios
+	// ECHONL keeps the newline visible so the next prompt starts on its own line.
+	termios.Lflag &^= syscall.ECHO
+	termios.Lflag |= syscall.ECHONL
+	if err := ioctl(f, syscall.TCSETS, uintptr(unsafe.Pointer(&termios))); err != nil {
+		return nil, err
+	}
+	return func() error {
+		return ioctl(f, syscall.TCSETS, uintptr(unsafe.Pointer(&saved)))
+	}, nil
+}
+
+// IsTerminal reports whether f is a terminal.
+func IsTerminal(f *os.File) bool {
+	var termios syscall.Termios
+	return ioctl(f, syscall.TCGETS, uintptr(unsafe.Pointer(&termios))) == nil
+}
+
+// Getsize returns the window size of the terminal f.
+func Getsize(f *os.File) (rows, cols int, err error) {
+	var ws struct{ Row, Col, X, Y uint16 }
+	if err := ioctl(f, syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws))); err != nil {
+		return 0, 0, err
+	}
+	return int(ws.Row), int(ws.Col), nil
+}
@@ -0,0 +1,81 @@
+//go:build linux
+
+package pty
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestStartRunsCommandOnTerminal(t *testing.T) {
+	if _, err := os.Stat("/dev/ptmx"); err != nil {
+		t.Skip("no /dev/ptmx")
+	}
+	cmd := exec.Command("/bin/sh", "-c", "test -t 0 && read -r line && stty size")
+	master, err := Start(cmd)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer func() { _ = master.Close() }()
+	if err := Setsize(master, 40, 120); err != nil {
+		t.Fatalf("setsize: %v", err)
+	}
+	if echo, err := Echo(master); err != nil || !echo {
+		t.Fatalf("expected echo on a fresh terminal, got %t (%v)", echo, err)
+	}
+	// The command waits for a line so it reads the size set above.
+	if _, err := master.Write([]byte("go\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	var out bytes.Buffer
+	buf := make([]byte, 256)
+	for {
+		n, err := master.Read(buf)
+		out.Write(buf[:n])
+		if err != nil {
+			break
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("command failed: %v (output %q)", err, out.String())
+	}
+	if !bytes.Contains(out.Bytes(), []byte("40 120")) {
+		t.Fatalf("expected terminal size in output, got %q", out.String())
+	}
+}
+
+func TestDisableEchoRestoresSettings(t *testing.T) {
+	if _, err := os.Stat("/dev/ptmx"); err != nil {
+		t.Skip("no /dev/ptmx")
+	}
+	cmd := exec.Command("/bin/sh", "-c", "read -r line")
+	master, err := Start(cmd)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer func() {
+		_ = master.Close()
+		_ = cmd.Wait()
+	}()
+	if !IsTerminal(master) {
+		t.Fatal("expected the master to be a terminal")
+	}
+
+	restore, err := DisableEcho(master)
+	if err != nil {
+		t.Fatalf("disable echo: %v", err)
+	}
+	if echo, err := Echo(master); err != nil || echo {
+		t.Fatalf("expected echo off, got %t (%v)", echo, err)
+	}
+	if err := restore(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if echo, err := Echo(master); err != nil || !echo {
+		t.Fatalf("expected echo restored, got %t (%v)", echo, err)
+	}
+	_, _ = master.Write([]byte("done\n"))
+}
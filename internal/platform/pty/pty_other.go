@@ -0,0 +1,38 @@
+//go:build !linux
+
+package pty
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Start is not supported on this platform.
+func Start(_ *exec.Cmd) (*os.File, error) {
+	return nil, ErrUnsupported
+}
+
+// Setsize is not supported on this platform.
+func Setsize(_ *os.File, _, _ int) error {
+	return ErrUnsupported
+}
+
+// Echo is not supported on this platform.
+func Echo(_ *os.File) (bool, error) {
+	return false, ErrUnsupported
+}
+
+// DisableEcho is not supported on this platform.
+func DisableEcho(_ *os.File) (func() error, error) {
+	return nil, ErrUnsupported
+}
+
+// IsTerminal always reports false on this platform.
+func IsTerminal(_ *os.File) bool {
+	return false
+}
+
+// Getsize is not supported on this platform.
+func Getsize(_ *os.File) (int, int, error) {
+	return 0, 0, ErrUnsupported
+}
@@ -0,0 +1,119 @@
+// Package scheduler runs named panel tasks on recurring schedules.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// NextFunc returns the first run time strictly after now.
+type NextFunc func(now time.Time) time.Time
+
+// Task is a scheduled unit of work.
+type Task func(ctx context.Context) error
+
+type entry struct {
+	name string
+	next NextFunc
+	run  Task
+}
+
+// Scheduler runs registered tasks in their own goroutines.
+type Scheduler struct {
+	log *slog.Logger
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]entry
+	started bool
+}
+
+// New creates an empty scheduler.
+func New(log *slog.Logger) *Scheduler {
+	if log == nil {
+		log = slog.Default()
+	}
+	return &Scheduler{log: log, now: time.Now, entries: map[string]entry{}}
+}
+
+// Add registers a task. Tasks must be added before Start.
+func (s *Scheduler) Add(name string, next NextFunc, run Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return fmt.Errorf("scheduler already started")
+	}
+	if _, ok := s.entries[name]; ok {
+		return fmt.Errorf("task %q already registered", name)
+	}
+	s.entries[name] = entry{name: name, next: next, run: run}
+	return nil
+}
+
+// RunNow runs a registered task synchronously.
+func (s *Scheduler) RunNow(ctx context.Context, name string) error {
+	s.mu.Lock()
+	e, ok := s.entries[name]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("task %q is not registered", name)
+	}
+	return e.run(ctx)
+}
+
+// Start launches every registered task until ctx is cancelled.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	s.started = true
+	entries := make([]entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	s.mu.Unlock()
+
+	for _, e := range entries {
+		go s.loop(ctx, e)
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, e entry) {
+	for {
+		now := s.now()
+		at := e.next(now)
+		s.log.Debug("task scheduled", "task", e.name, "at", at.Format(time.RFC3339))
+		timer := time.NewTimer(at.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		start := s.now()
+		if err := e.run(ctx); err != nil {
+			s.log.Error("scheduled task failed", "task", e.name, "error", err.Error())
+			continue
+		}
+		s.log.Info("scheduled task finished", "task", e.name, "duration_ms", s.now().Sub(start).Milliseconds())
+	}
+}
+
+// Daily runs once a day at hour:minute local time.
+func Daily(hour, minute int) NextFunc {
+	return func(now time.Time) time.Time {
+		at := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+		return at
+	}
+}
+
+// Every runs at a fixed interval after each check.
+func Every(d time.Duration) NextFunc {
+	return func(now time.Time) time.Time {
+		return now.Add(d)
+	}
+}
@@ -0,0 +1,52 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDaily_NextRun(t *testing.T) {
+	next := Daily(3, 30)
+	before := time.Date(2026, 5, 10, 1, 0, 0, 0, time.UTC)
+	if got := next(before); !got.Equal(time.Date(2026, 5, 10, 3, 30, 0, 0, time.UTC)) {
+		t.Fatalf("expected same-day run, got %v", got)
+	}
+	at := time.Date(2026, 5, 10, 3, 30, 0, 0, time.UTC)
+	if got := next(at); !got.Equal(time.Date(2026, 5, 11, 3, 30, 0, 0, time.UTC)) {
+		t.Fatalf("expected next-day run, got %v", got)
+	}
+}
+
+func TestScheduler_RunsTasksUntilCancelled(t *testing.T) {
+	s := New(nil)
+	var runs atomic.Int32
+	if err := s.Add("tick", Every(5*time.Millisecond), func(context.Context) error {
+		runs.Add(1)
+		return errors.New("keeps running after failures")
+	}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := s.Add("tick", Every(time.Hour), func(context.Context) error { return nil }); err == nil {
+		t.Fatal("expected duplicate task name to be rejected")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Start(ctx)
+	deadline := time.Now().Add(2 * time.Second)
+	for runs.Load() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected repeated runs, got %d", runs.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := s.Add("late", Every(time.Hour), func(context.Context) error { return nil }); err == nil {
+		t.Fatal("expected Add after Start to fail")
+	}
+	if err := s.RunNow(ctx, "missing"); err == nil {
+		t.Fatal("expected unknown task to fail")
+	}
+}
@@ -0,0 +1,481 @@
+// Package selfupdate replaces the panel binary with a newer release listed
+// in the release manifest. A release is installed only once its sha256
+// and detached GPG signature check out. The signing key is never taken
+// from the manifest: its fingerprint is compiled into the binary and the
+// key itself is read from a local file, so whoever serves the manifest
+// cannot substitute their own.
+package selfupdate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/cache"
+	"github.com/robsonek/aiPanel/internal/platform/systemd"
+)
+
+const (
+	// DefaultManifestURL is the manifest published with every release.
+	DefaultManifestURL = "https://github.com/robsonek/aiPanel/releases/latest/download/release.json"
+	// DefaultBinaryPath is where the installer puts the panel binary.
+	DefaultBinaryPath = "/usr/local/bin/aipanel"
+	// DefaultUnit is the systemd unit restarted after the swap.
+	DefaultUnit = "aipanel.service"
+	// DefaultReleaseKeyPath is the armored release signing key installed
+	// with the panel.
+	DefaultReleaseKeyPath = "/etc/aipanel/release-key.asc"
+	// checkTTL bounds how often Status fetches the manifest.
+	checkTTL = time.Hour
+	// maxBinarySize guards the download against a runaway response.
+	maxBinarySize = 512 << 20
+)
+
+var (
+	// ErrUpToDate indicates the running binary is already the latest release.
+	ErrUpToDate = errors.New("panel is up to date")
+	// ErrNoBinary indicates a release without a binary for this platform.
+	ErrNoBinary = errors.New("release has no binary for this platform")
+)
+
+// ReleaseKeyFingerprint is the full fingerprint of the release signing
+// key, set by release builds with -ldflags
+// "-X github.com/robsonek/aiPanel/internal/platform/selfupdate.ReleaseKeyFingerprint=...".
+// Development builds leave it empty and cannot self-update.
+var ReleaseKeyFingerprint = ""
+
+// ReleaseKey is the armored public release key, base64-encoded so it fits
+// in one -X flag. Release builds set it next to ReleaseKeyFingerprint and
+// the installer writes it to DefaultReleaseKeyPath.
+var ReleaseKey = ""
+
+var (
+	sha256Pattern      = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+	fingerprintPattern = regexp.MustCompile(`^[0-9A-F]{40}$|^[0-9A-F]{64}$`)
+)
+
+// Manifest lists the latest release and its binary per platform, keyed
+// like "linux-amd64".
+type Manifest struct {
+	SchemaVersion int               `json:"schema_version"`
+	Version       string            `json:"version"`
+	Binaries      map[string]Binary `json:"binaries"`
+}
+
+// Binary is one release binary. It must match SHA256 and carry a detached
+// signature made by the trusted release key. PublicKeyFingerprint is
+// informational; a manifest naming any other key is rejected.
+type Binary struct {
+	URL                  string `json:"url"`
+	SHA256               string `json:"sha256"`
+	SignatureURL         string `json:"signature_url"`
+	PublicKeyFingerprint string `json:"public_key_fingerprint"`
+}
+
+// Status is the running and the latest released version. Latest is empty
+// until a manifest was fetched.
+type Status struct {
+	Current         string    `json:"current"`
+	Latest          string    `json:"latest,omitempty"`
+	UpdateAvailable bool      `json:"update_available"`
+	CheckedAt       time.Time `json:"checked_at,omitzero"`
+}
+
+// Options overrides where releases come from and what gets replaced.
+type Options struct {
+	// ManifestURL is an http(s) URL or a local path; defaults to
+	// DefaultManifestURL.
+	ManifestURL string
+	BinaryPath  string
+	Unit        string
+	// Platform selects the manifest binary; defaults to GOOS-GOARCH.
+	Platform string
+	Client   *http.Client
+	// KeyPath is the armored release key imported for verification;
+	// defaults to DefaultReleaseKeyPath.
+	KeyPath string
+	// KeyFingerprint is the key signatures must verify against; defaults
+	// to ReleaseKeyFingerprint.
+	KeyFingerprint string
+}
+
+// Updater checks for and installs panel releases.
+type Updater struct {
+	current string
+	runner  systemd.Runner
+	opts    Options
+	checks  *cache.TTL[string, Status]
+}
+
+// New creates an updater for the running version current.
+func New(current string, runner systemd.Runner, opts Options) *Updater {
+	if runner == nil {
+		runner = systemd.ExecRunner{}
+	}
+	if strings.TrimSpace(opts.ManifestURL) == "" {
+		opts.ManifestURL = DefaultManifestURL
+	}
+	if strings.TrimSpace(opts.BinaryPath) == "" {
+		opts.BinaryPath = DefaultBinaryPath
+	}
+	if strings.TrimSpace(opts.Unit) == "" {
+		opts.Unit = DefaultUnit
+	}
+	if strings.TrimSpace(opts.Platform) == "" {
+		opts.Platform = runtime.GOOS + "-" + runtime.GOARCH
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Minute}
+	}
+	if strings.TrimSpace(opts.KeyPath) == "" {
+		opts.KeyPath = DefaultReleaseKeyPath
+	}
+	if strings.TrimSpace(opts.KeyFingerprint) == "" {
+		opts.KeyFingerprint = ReleaseKeyFingerprint
+	}
+	opts.KeyFingerprint = normalizeFingerprint(opts.KeyFingerprint)
+	return &Updater{current: current, runner: runner, opts: opts, checks: cache.New[string, Status](checkTTL)}
+}
+
+// Status reports the running and latest version, fetching the manifest at
+// most once per checkTTL.
+func (u *Updater) Status(ctx context.Context) (Status, error) {
+	if status, ok := u.checks.Get(u.opts.ManifestURL); ok {
+		return status, nil
+	}
+	status, _, err := u.Check(ctx)
+	if err != nil {
+		return Status{Current: u.current}, err
+	}
+	return status, nil
+}
+
+// Check fetches the manifest now and compares it with the running version.
+func (u *Updater) Check(ctx context.Context) (Status, Manifest, error) {
+	manifest, err := u.fetchManifest(ctx)
+	if err != nil {
+		return Status{}, Manifest{}, err
+	}
+	status := Status{
+		Current:         u.current,
+		Latest:          manifest.Version,
+		UpdateAvailable: Newer(manifest.Version, u.current),
+		CheckedAt:       time.Now().UTC(),
+	}
+	u.checks.Set(u.opts.ManifestURL, status)
+	return status, manifest, nil
+}
+
+// Apply installs the latest release: it downloads the binary next to
+// BinaryPath, verifies its checksum and signature, renames it over the
+// running binary (the old one stays as BinaryPath.previous) and restarts
+// the panel unit. force reinstalls a release that is not newer, which is
+// also needed to leave a development build.
+func (u *Updater) Apply(ctx context.Context, force bool, logf func(format string, args ...any)) (Status, error) {
+	if logf == nil {
+		logf = func(string, ...any) {}
+	}
+	status, manifest, err := u.Check(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+	if !status.UpdateAvailable && !force {
+		return status, fmt.Errorf("%w: %s", ErrUpToDate, u.current)
+	}
+	binary, ok := manifest.Binaries[u.opts.Platform]
+	if !ok {
+		return status, fmt.Errorf("%w: %s %s", ErrNoBinary, manifest.Version, u.opts.Platform)
+	}
+	if !fingerprintPattern.MatchString(u.opts.KeyFingerprint) {
+		return status, errors.New("no trusted release key fingerprint is configured in this build")
+	}
+	if named := normalizeFingerprint(binary.PublicKeyFingerprint); named != "" && named != u.opts.KeyFingerprint {
+		return status, fmt.Errorf("release manifest names key %s, trusted release key is %s", named, u.opts.KeyFingerprint)
+	}
+
+	// The download lands in the target directory so the final rename
+	// cannot cross filesystems.
+	dir := filepath.Dir(u.opts.BinaryPath)
+	tmp, err := os.CreateTemp(dir, ".aipanel-update-*")
+	if err != nil {
+		return status, fmt.Errorf("create download file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer func() {
+		_ = os.Remove(tmpPath)
+	}()
+	logf("downloading %s %s", manifest.Version, binary.URL)
+	sum, err := u.download(ctx, binary.URL, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return status, fmt.Errorf("download release binary: %w", err)
+	}
+	if !strings.EqualFold(sum, binary.SHA256) {
+		return status, fmt.Errorf("release binary checksum mismatch: expected %s got %s", binary.SHA256, sum)
+	}
+	logf("checksum verified: %s", sum)
+	if err := u.verifySignature(ctx, binary, tmpPath); err != nil {
+		return status, err
+	}
+	logf("signature verified with key %s", u.opts.KeyFingerprint)
+
+	//nolint:gosec // The panel binary is executable by everyone, as installed.
+	if err := os.Chmod(tmpPath, 0o755); err != nil {
+		return status, fmt.Errorf("chmod release binary: %w", err)
+	}
+	previous := u.opts.BinaryPath + ".previous"
+	_ = os.Remove(previous)
+	if err := os.Link(u.opts.BinaryPath, previous); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return status, fmt.Errorf("keep previous binary: %w", err)
+	}
+	if err := os.Rename(tmpPath, u.opts.BinaryPath); err != nil {
+		return status, fmt.Errorf("replace panel binary: %w", err)
+	}
+	logf("installed %s at %s", manifest.Version, u.opts.BinaryPath)
+	installed := Status{Current: manifest.Version, Latest: manifest.Version, CheckedAt: status.CheckedAt}
+	if err := systemd.Restart(ctx, u.runner, u.opts.Unit); err != nil {
+		return installed, fmt.Errorf("restart %s: %w", u.opts.Unit, err)
+	}
+	logf("restarted %s", u.opts.Unit)
+	return installed, nil
+}
+
+func (u *Updater) fetchManifest(ctx context.Context) (Manifest, error) {
+	ref := u.opts.ManifestURL
+	var raw []byte
+	if isHTTP(ref) {
+		var buf strings.Builder
+		if err := u.get(ctx, ref, &limitedWriter{w: &buf, n: 1 << 20}); err != nil {
+			return Manifest{}, fmt.Errorf("fetch release manifest: %w", err)
+		}
+		raw = []byte(buf.String())
+	} else {
+		var err error
+		// The manifest location comes from panel configuration.
+		//nolint:gosec // G304
+		if raw, err = os.ReadFile(strings.TrimPrefix(ref, "file://")); err != nil {
+			return Manifest{}, fmt.Errorf("read release manifest: %w", err)
+		}
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return Manifest{}, fmt.Errorf("parse release manifest: %w", err)
+	}
+	if err := manifest.validate(); err != nil {
+		return Manifest{}, fmt.Errorf("release manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+func (m Manifest) validate() error {
+	if m.SchemaVersion != 1 {
+		return fmt.Errorf("unsupported schema_version %d", m.SchemaVersion)
+	}
+	if strings.TrimSpace(m.Version) == "" {
+		return errors.New("version is required")
+	}
+	for platform, b := range m.Binaries {
+		if strings.TrimSpace(b.URL) == "" {
+			return fmt.Errorf("%s: url is required", platform)
+		}
+		if !sha256Pattern.MatchString(b.SHA256) {
+			return fmt.Errorf("%s: sha256 must be 64 hex characters", platform)
+		}
+		if strings.TrimSpace(b.SignatureURL) == "" {
+			return fmt.Errorf("%s: signature_url is required", platform)
+		}
+	}
+	return nil
+}
+
+// download writes url to w and returns the sha256 of what it wrote.
+func (u *Updater) download(ctx context.Context, url string, w io.Writer) (string, error) {
+	h := sha256.New()
+	if err := u.get(ctx, url, &limitedWriter{w: io.MultiWriter(w, h), n: maxBinarySize}); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (u *Updater) get(ctx context.Context, url string, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := u.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// verifySignature checks the detached signature with gpg in a throwaway
+// keyring holding only the local release key. gpg exiting zero is not
+// enough: its VALIDSIG status line must name the trusted fingerprint
+// exactly, as the signing key or as its primary key.
+func (u *Updater) verifySignature(ctx context.Context, binary Binary, path string) error {
+	sig, err := os.CreateTemp("", "aipanel-update-signature-*")
+	if err != nil {
+		return fmt.Errorf("create signature file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(sig.Name())
+	}()
+	err = u.get(ctx, binary.SignatureURL, &limitedWriter{w: sig, n: 1 << 20})
+	if closeErr := sig.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("download release signature: %w", err)
+	}
+	gnupgHome, err := os.MkdirTemp("", "aipanel-update-gpg-*")
+	if err != nil {
+		return fmt.Errorf("create gpg home: %w", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(gnupgHome)
+	}()
+	commands := []string{
+		"export GNUPGHOME=" + shellQuote(gnupgHome),
+		"gpg --batch --import " + shellQuote(u.opts.KeyPath),
+		"gpg --batch --status-fd 1 --verify " + shellQuote(sig.Name()) + " " + shellQuote(path),
+	}
+	out, err := u.runner.Run(ctx, "bash", "-lc", strings.Join(commands, " && "))
+	if err != nil {
+		return fmt.Errorf("verify release signature: %w", err)
+	}
+	if !validSignatureBy(out, u.opts.KeyFingerprint) {
+		return fmt.Errorf("verify release signature: no valid signature by %s", u.opts.KeyFingerprint)
+	}
+	return nil
+}
+
+// validSignatureBy reports whether gpg status output carries a VALIDSIG
+// line whose signing key or primary key fingerprint is fingerprint.
+func validSignatureBy(status, fingerprint string) bool {
+	for _, line := range strings.Split(status, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != "[GNUPG:]" || fields[1] != "VALIDSIG" {
+			continue
+		}
+		if fields[2] == fingerprint || (len(fields) >= 12 && fields[11] == fingerprint) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteReleaseKey writes the release key compiled into this build to
+// path. It reports false, writing nothing, for builds without one.
+func WriteReleaseKey(path string) (bool, error) {
+	if strings.TrimSpace(ReleaseKey) == "" {
+		return false, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(ReleaseKey))
+	if err != nil {
+		return false, fmt.Errorf("decode release key: %w", err)
+	}
+	if !strings.Contains(string(key), "BEGIN PGP PUBLIC KEY BLOCK") {
+		return false, errors.New("compiled-in release key is not an armored public key")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return false, fmt.Errorf("create release key dir: %w", err)
+	}
+	//nolint:gosec // G306: the release key is public.
+	if err := os.WriteFile(path, key, 0o644); err != nil {
+		return false, fmt.Errorf("write release key: %w", err)
+	}
+	return true, nil
+}
+
+func normalizeFingerprint(fp string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(fp), " ", ""))
+}
+
+// Newer reports whether release version latest is newer than current.
+// Versions are dotted numbers with an optional "v" prefix; a current
+// version that is not one, such as "dev", is never offered an update.
+func Newer(latest, current string) bool {
+	l, ok := parseVersion(latest)
+	if !ok {
+		return false
+	}
+	c, ok := parseVersion(current)
+	if !ok {
+		return false
+	}
+	for i := 0; i < len(l) || i < len(c); i++ {
+		var x, y int
+		if i < len(l) {
+			x = l[i]
+		}
+		if i < len(c) {
+			y = c[i]
+		}
+		if x != y {
+			return x > y
+		}
+	}
+	return false
+}
+
+func parseVersion(v string) ([]int, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if v == "" {
+		return nil, false
+	}
+	parts := strings.Split(v, ".")
+	out := make([]int, 0, len(parts))
+	for _, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		out = append(out, n)
+	}
+	return out, true
+}
+
+type limitedWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.n {
+		return 0, errors.New("response too large")
+	}
+	l.n -= int64(len(p))
+	return l.w.Write(p)
+}
+
+func isHTTP(ref string) bool {
+	return strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://")
+}
+
+// shellQuote single-quotes s for /bin/sh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
@@ -0,0 +1,231 @@
+package selfupdate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	trustedKey = "0123456789ABCDEF0123456789ABCDEF01234567"
+	otherKey   = "FEDCBA9876543210FEDCBA9876543210FEDCBA98"
+)
+
+// fakeRunner answers gpg --verify with a VALIDSIG status line for signedBy,
+// or no VALIDSIG line at all when it is empty.
+type fakeRunner struct {
+	commands []string
+	signedBy string
+}
+
+func (f *fakeRunner) Run(_ context.Context, name string, args ...string) (string, error) {
+	command := strings.TrimSpace(name + " " + strings.Join(args, " "))
+	f.commands = append(f.commands, command)
+	if strings.Contains(command, "--verify") && f.signedBy != "" {
+		return "[GNUPG:] GOODSIG 0123456789ABCDEF release\n[GNUPG:] VALIDSIG " + f.signedBy +
+			" 2026-10-01 1790000000 0 4 0 1 10 00 " + f.signedBy + "\n", nil
+	}
+	return "", nil
+}
+
+func newReleaseServer(t *testing.T, version string, binary []byte, sum string) *httptest.Server {
+	t.Helper()
+	return newReleaseServerWithKey(t, version, binary, sum, trustedKey)
+}
+
+func newReleaseServerWithKey(t *testing.T, version string, binary []byte, sum, key string) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/release.json", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(Manifest{
+			SchemaVersion: 1,
+			Version:       version,
+			Binaries: map[string]Binary{"linux-amd64": {
+				URL:                  srv.URL + "/aipanel",
+				SHA256:               sum,
+				SignatureURL:         srv.URL + "/aipanel.asc",
+				PublicKeyFingerprint: key,
+			}},
+		})
+	})
+	mux.HandleFunc("/aipanel", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(binary)
+	})
+	mux.HandleFunc("/aipanel.asc", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("signature"))
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestUpdater_Apply(t *testing.T) {
+	ctx := context.Background()
+	release := []byte("new panel binary")
+	srv := newReleaseServer(t, "v1.3.0", release, checksum(release))
+	binaryPath := filepath.Join(t.TempDir(), "aipanel")
+	if err := os.WriteFile(binaryPath, []byte("old panel binary"), 0o600); err != nil {
+		t.Fatalf("write binary: %v", err)
+	}
+	runner := &fakeRunner{signedBy: trustedKey}
+	u := New("v1.2.9", runner, Options{ManifestURL: srv.URL + "/release.json", BinaryPath: binaryPath, Platform: "linux-amd64", KeyFingerprint: trustedKey})
+
+	status, err := u.Status(ctx)
+	if err != nil || status.Latest != "v1.3.0" || !status.UpdateAvailable {
+		t.Fatalf("unexpected status: %+v (%v)", status, err)
+	}
+	installed, err := u.Apply(ctx, false, nil)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if installed.Current != "v1.3.0" {
+		t.Fatalf("unexpected installed status: %+v", installed)
+	}
+	if got, _ := os.ReadFile(binaryPath); string(got) != string(release) { //nolint:gosec // test reads file in temp dir.
+		t.Fatalf("expected new binary, got %q", got)
+	}
+	if got, _ := os.ReadFile(binaryPath + ".previous"); string(got) != "old panel binary" { //nolint:gosec // test reads file in temp dir.
+		t.Fatalf("expected previous binary kept, got %q", got)
+	}
+	if info, err := os.Stat(binaryPath); err != nil || info.Mode().Perm() != 0o755 {
+		t.Fatalf("expected executable binary, got %v (%v)", info, err)
+	}
+	joined := strings.Join(runner.commands, "\n")
+	if !strings.Contains(joined, "gpg --batch --status-fd 1 --verify") || !strings.HasSuffix(joined, "systemctl restart aipanel.service") {
+		t.Fatalf("expected signature check then restart, got:\n%s", joined)
+	}
+	if !strings.Contains(joined, "gpg --batch --import '/etc/aipanel/release-key.asc'") || strings.Contains(joined, "keyserver") {
+		t.Fatalf("expected the local release key imported without keyserver lookups, got:\n%s", joined)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(binaryPath))
+	if len(entries) != 2 {
+		t.Fatalf("expected download file cleaned up, got %v", entries)
+	}
+}
+
+func TestUpdater_ApplyRejectsChecksumMismatch(t *testing.T) {
+	ctx := context.Background()
+	srv := newReleaseServer(t, "v2.0.0", []byte("tampered"), checksum([]byte("original")))
+	binaryPath := filepath.Join(t.TempDir(), "aipanel")
+	if err := os.WriteFile(binaryPath, []byte("old"), 0o600); err != nil {
+		t.Fatalf("write binary: %v", err)
+	}
+	runner := &fakeRunner{signedBy: trustedKey}
+	u := New("v1.0.0", runner, Options{ManifestURL: srv.URL + "/release.json", BinaryPath: binaryPath, Platform: "linux-amd64", KeyFingerprint: trustedKey})
+	if _, err := u.Apply(ctx, false, nil); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+	if got, _ := os.ReadFile(binaryPath); string(got) != "old" { //nolint:gosec // test reads file in temp dir.
+		t.Fatalf("expected binary untouched, got %q", got)
+	}
+	if len(runner.commands) != 0 {
+		t.Fatalf("expected no commands, got %v", runner.commands)
+	}
+}
+
+func TestUpdater_ApplyRejectsUntrustedKey(t *testing.T) {
+	ctx := context.Background()
+	release := []byte("attacker binary")
+	binaryPath := filepath.Join(t.TempDir(), "aipanel")
+	if err := os.WriteFile(binaryPath, []byte("old"), 0o600); err != nil {
+		t.Fatalf("write binary: %v", err)
+	}
+
+	// The manifest names the attacker's key and gpg would accept it.
+	srv := newReleaseServerWithKey(t, "v2.0.0", release, checksum(release), otherKey)
+	runner := &fakeRunner{signedBy: otherKey}
+	u := New("v1.0.0", runner, Options{ManifestURL: srv.URL + "/release.json", BinaryPath: binaryPath, Platform: "linux-amd64", KeyFingerprint: trustedKey})
+	if _, err := u.Apply(ctx, false, nil); err == nil || !strings.Contains(err.Error(), "names key "+otherKey) {
+		t.Fatalf("expected the manifest key rejected, got %v", err)
+	}
+	if len(runner.commands) != 0 {
+		t.Fatalf("expected nothing verified or restarted, got %v", runner.commands)
+	}
+
+	// A manifest naming the trusted key does not help when the signature
+	// was made by another one.
+	srv = newReleaseServer(t, "v2.0.0", release, checksum(release))
+	u = New("v1.0.0", runner, Options{ManifestURL: srv.URL + "/release.json", BinaryPath: binaryPath, Platform: "linux-amd64", KeyFingerprint: trustedKey})
+	if _, err := u.Apply(ctx, false, nil); err == nil || !strings.Contains(err.Error(), "no valid signature by "+trustedKey) {
+		t.Fatalf("expected a signature by another key rejected, got %v", err)
+	}
+	if got, _ := os.ReadFile(binaryPath); string(got) != "old" { //nolint:gosec // test reads file in temp dir.
+		t.Fatalf("expected binary untouched, got %q", got)
+	}
+
+	// Without a fingerprint compiled in, nothing is trusted.
+	u = New("v1.0.0", runner, Options{ManifestURL: srv.URL + "/release.json", BinaryPath: binaryPath, Platform: "linux-amd64"})
+	if _, err := u.Apply(ctx, false, nil); err == nil || !strings.Contains(err.Error(), "no trusted release key") {
+		t.Fatalf("expected a build without a release key refused, got %v", err)
+	}
+}
+
+func TestUpdater_ApplyUpToDate(t *testing.T) {
+	ctx := context.Background()
+	release := []byte("same")
+	srv := newReleaseServer(t, "v1.0.0", release, checksum(release))
+	u := New("1.0.0", &fakeRunner{}, Options{ManifestURL: srv.URL + "/release.json", BinaryPath: filepath.Join(t.TempDir(), "aipanel"), Platform: "linux-amd64"})
+	if _, err := u.Apply(ctx, false, nil); !errors.Is(err, ErrUpToDate) {
+		t.Fatalf("expected ErrUpToDate, got %v", err)
+	}
+	u.opts.Platform = "linux-riscv64"
+	if _, err := u.Apply(ctx, true, nil); !errors.Is(err, ErrNoBinary) {
+		t.Fatalf("expected ErrNoBinary, got %v", err)
+	}
+}
+
+func TestWriteReleaseKey(t *testing.T) {
+	saved := ReleaseKey
+	t.Cleanup(func() { ReleaseKey = saved })
+	path := filepath.Join(t.TempDir(), "etc", "release-key.asc")
+
+	ReleaseKey = ""
+	if written, err := WriteReleaseKey(path); written || err != nil {
+		t.Fatalf("expected nothing written without a compiled-in key, got %v (%v)", written, err)
+	}
+	key := "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nmQINBG...\n-----END PGP PUBLIC KEY BLOCK-----\n"
+	ReleaseKey = base64.StdEncoding.EncodeToString([]byte(key))
+	if written, err := WriteReleaseKey(path); !written || err != nil {
+		t.Fatalf("write release key: %v (%v)", written, err)
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != key { //nolint:gosec // test reads file in temp dir.
+		t.Fatalf("unexpected release key %q (%v)", b, err)
+	}
+	ReleaseKey = base64.StdEncoding.EncodeToString([]byte("not a key"))
+	if _, err := WriteReleaseKey(path); err == nil {
+		t.Fatal("expected a non-key payload rejected")
+	}
+}
+
+func TestNewer(t *testing.T) {
+	for _, tc := range []struct {
+		latest, current string
+		want            bool
+	}{
+		{"v1.10.0", "v1.9.3", true},
+		{"1.2", "v1.2.0", false},
+		{"v1.2.1", "1.2", true},
+		{"v1.0.0", "v1.0.1", false},
+		{"v2.0.0", "dev", false},
+		{"nightly", "v1.0.0", false},
+	} {
+		if got := Newer(tc.latest, tc.current); got != tc.want {
+			t.Errorf("Newer(%q, %q) = %v, want %v", tc.latest, tc.current, got, tc.want)
+		}
+	}
+}
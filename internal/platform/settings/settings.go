@@ -0,0 +1,456 @@
+// Package settings keeps module settings that admins change from the panel
+// (backup defaults, the ACME contact, notification channels, security
+// toggles) in panel.db. Every namespace declares its keys with a type and a
+// default taken from panel.yaml, so a key never changed in the panel keeps
+// behaving as configured.
+package settings
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/middleware"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+// Built-in namespaces.
+const (
+	Backup        = "backup"
+	ACME          = "acme"
+	Notifications = "notifications"
+	Security      = "security"
+)
+
+// Setting types.
+const (
+	TypeBool   = "bool"
+	TypeInt    = "int"
+	TypeString = "string"
+	TypeEmail  = "email"
+	TypeEmails = "emails"
+	TypeURL    = "url"
+)
+
+// ErrUnknownNamespace indicates a namespace no module declared.
+var ErrUnknownNamespace = errors.New("unknown settings namespace")
+
+// ValidationError lists the rejected keys of an update with the reason.
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+": "+e.Fields[k])
+	}
+	return "invalid settings: " + strings.Join(parts, "; ")
+}
+
+// Field is one setting of a namespace.
+type Field struct {
+	Key     string `json:"key"`
+	Type    string `json:"type"`
+	Default any    `json:"default"`
+	// Min and Max bound TypeInt values.
+	Min int `json:"min,omitempty"`
+	Max int `json:"max,omitempty"`
+	// Secret values are left out of the audit trail.
+	Secret bool   `json:"secret,omitempty"`
+	Doc    string `json:"doc"`
+}
+
+// Namespace groups the settings of one module.
+type Namespace struct {
+	Name   string  `json:"name"`
+	Fields []Field `json:"fields"`
+}
+
+func (n Namespace) field(key string) (Field, bool) {
+	for _, f := range n.Fields {
+		if f.Key == key {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// Builtin declares the panel namespaces, with defaults from cfg.
+func Builtin(cfg config.Config) []Namespace {
+	pitrDays := cfg.PITRRetentionDays
+	if pitrDays <= 0 {
+		pitrDays = 7
+	}
+	return []Namespace{
+		{Name: Backup, Fields: []Field{
+			{Key: "retention", Type: TypeInt, Default: 7, Min: 1, Max: 365,
+				Doc: "Dumps kept by a new database backup schedule that does not set its own retention."},
+			{Key: "pitr_retention_days", Type: TypeInt, Default: pitrDays, Min: 1, Max: 365,
+				Doc: "Days of binlogs and WAL kept for point-in-time recovery."},
+		}},
+		{Name: ACME, Fields: []Field{
+			{Key: "email", Type: TypeEmail, Default: strings.TrimSpace(cfg.ACMEEmail),
+				Doc: "Let's Encrypt account contact, also the admin address for alerts."},
+			{Key: "staging", Type: TypeBool, Default: cfg.ACMEStaging,
+				Doc: "Issue certificates from the Let's Encrypt staging environment."},
+		}},
+		{Name: Notifications, Fields: []Field{
+			{Key: "emails", Type: TypeEmails, Default: []string{},
+				Doc: "Addresses that receive operator alerts; empty sends them to the ACME contact."},
+			{Key: "webhook_url", Type: TypeURL, Default: "", Secret: true,
+				Doc: "URL that receives every operator alert as a JSON POST."},
+		}},
+		{Name: Security, Fields: []Field{
+			{Key: "web_terminal", Type: TypeBool, Default: cfg.WebTerminalEnabled,
+				Doc: "Allow the browser terminal that opens a shell as the site user."},
+			{Key: "login_max_failures", Type: TypeInt, Default: cfg.LoginMaxFailures, Min: 0, Max: 1000,
+				Doc: "Failed logins for one email within the window before it is locked out; 0 disables the lockout."},
+			{Key: "login_max_failures_per_ip", Type: TypeInt, Default: cfg.LoginMaxFailuresPerIP, Min: 0, Max: 10000,
+				Doc: "Failed logins from one address within the window before it is locked out; 0 disables the lockout."},
+			{Key: "two_person_approval", Type: TypeBool, Default: false,
+				Doc: "Hold site and database deletions and restores until a second admin approves them."},
+			{Key: "approval_window_minutes", Type: TypeInt, Default: 60, Min: 5, Max: 1440,
+				Doc: "Minutes a held operation waits for approval before it expires."},
+		}},
+	}
+}
+
+// Store reads and writes settings. Values are loaded once and cached;
+// Update refreshes the cache.
+type Store struct {
+	store      *sqlite.Store
+	log        *slog.Logger
+	namespaces map[string]Namespace
+	order      []string
+	now        func() time.Time
+
+	mu     sync.Mutex
+	values map[string]map[string]any
+}
+
+// New creates a settings store for namespaces.
+func New(store *sqlite.Store, log *slog.Logger, namespaces ...Namespace) *Store {
+	if log == nil {
+		log = slog.Default()
+	}
+	s := &Store{store: store, log: log, namespaces: map[string]Namespace{}, now: time.Now}
+	for _, ns := range namespaces {
+		s.namespaces[ns.Name] = ns
+		s.order = append(s.order, ns.Name)
+	}
+	return s
+}
+
+// Namespaces returns the declared namespaces in declaration order.
+func (s *Store) Namespaces() []Namespace {
+	out := make([]Namespace, 0, len(s.order))
+	for _, name := range s.order {
+		out = append(out, s.namespaces[name])
+	}
+	return out
+}
+
+// Namespace returns the declaration of namespace.
+func (s *Store) Namespace(namespace string) (Namespace, error) {
+	ns, ok := s.namespaces[namespace]
+	if !ok {
+		return Namespace{}, ErrUnknownNamespace
+	}
+	return ns, nil
+}
+
+// Get returns every setting of namespace, defaults filled in.
+func (s *Store) Get(ctx context.Context, namespace string) (map[string]any, error) {
+	ns, ok := s.namespaces[namespace]
+	if !ok {
+		return nil, ErrUnknownNamespace
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(ctx); err != nil {
+		return nil, err
+	}
+	out := make(map[string]any, len(ns.Fields))
+	for _, f := range ns.Fields {
+		out[f.Key] = s.lookup(ns.Name, f)
+	}
+	return out, nil
+}
+
+// Update applies changes to namespace and audits every changed key. A null
+// value resets the key to its default. Nothing is written unless every key
+// validates.
+func (s *Store) Update(ctx context.Context, namespace string, changes map[string]json.RawMessage, actor string) (map[string]any, error) {
+	ns, ok := s.namespaces[namespace]
+	if !ok {
+		return nil, ErrUnknownNamespace
+	}
+	invalid := map[string]string{}
+	set := map[string]any{}
+	var reset []string
+	for key, raw := range changes {
+		f, ok := ns.field(key)
+		if !ok {
+			invalid[key] = "unknown setting"
+			continue
+		}
+		if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+			reset = append(reset, key)
+			continue
+		}
+		v, err := f.decode(raw)
+		if err != nil {
+			invalid[key] = err.Error()
+			continue
+		}
+		set[key] = v
+	}
+	if len(invalid) > 0 {
+		return nil, &ValidationError{Fields: invalid}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(ctx); err != nil {
+		return nil, err
+	}
+	before := make(map[string]any, len(ns.Fields))
+	for _, f := range ns.Fields {
+		before[f.Key] = s.lookup(ns.Name, f)
+	}
+	now := s.now().Unix()
+	var sql strings.Builder
+	sql.WriteString("BEGIN;\n")
+	for key, v := range set {
+		raw, _ := json.Marshal(v)
+		fmt.Fprintf(&sql, `INSERT INTO settings(namespace, key, value, updated_by, updated_at)
+VALUES('%[1]s','%[2]s','%[3]s','%[4]s',%[5]d)
+ON CONFLICT(namespace, key) DO UPDATE SET value=excluded.value, updated_by=excluded.updated_by, updated_at=excluded.updated_at;
+`, sqlEscape(ns.Name), sqlEscape(key), sqlEscape(string(raw)), sqlEscape(actor), now)
+	}
+	for _, key := range reset {
+		fmt.Fprintf(&sql, "DELETE FROM settings WHERE namespace='%s' AND key='%s';\n", sqlEscape(ns.Name), sqlEscape(key))
+	}
+	sql.WriteString("COMMIT;")
+	if err := s.store.ExecPanel(ctx, sql.String()); err != nil {
+		return nil, fmt.Errorf("update %s settings: %w", ns.Name, err)
+	}
+	s.values = nil
+	if err := s.load(ctx); err != nil {
+		return nil, err
+	}
+
+	after := make(map[string]any, len(ns.Fields))
+	var changed []string
+	for _, f := range ns.Fields {
+		after[f.Key] = s.lookup(ns.Name, f)
+		if sameValue(before[f.Key], after[f.Key]) {
+			continue
+		}
+		if f.Secret {
+			changed = append(changed, f.Key+" changed")
+			continue
+		}
+		changed = append(changed, fmt.Sprintf("%s: %s -> %s", f.Key, auditValue(before[f.Key]), auditValue(after[f.Key])))
+	}
+	if len(changed) > 0 {
+		s.audit(ctx, actor, "settings.update", "namespace="+ns.Name+" "+strings.Join(changed, "; "))
+	}
+	return after, nil
+}
+
+// Bool returns a TypeBool setting.
+func (s *Store) Bool(ctx context.Context, namespace, key string) bool {
+	v, _ := s.value(ctx, namespace, key).(bool)
+	return v
+}
+
+// Int returns a TypeInt setting.
+func (s *Store) Int(ctx context.Context, namespace, key string) int {
+	v, _ := s.value(ctx, namespace, key).(int)
+	return v
+}
+
+// String returns a TypeString, TypeEmail or TypeURL setting.
+func (s *Store) String(ctx context.Context, namespace, key string) string {
+	v, _ := s.value(ctx, namespace, key).(string)
+	return v
+}
+
+// Strings returns a TypeEmails setting.
+func (s *Store) Strings(ctx context.Context, namespace, key string) []string {
+	v, _ := s.value(ctx, namespace, key).([]string)
+	return slices.Clone(v)
+}
+
+// value falls back to the default when panel.db cannot be read, so a
+// broken store never turns a setting off.
+func (s *Store) value(ctx context.Context, namespace, key string) any {
+	ns, ok := s.namespaces[namespace]
+	if !ok {
+		return nil
+	}
+	f, ok := ns.field(key)
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(ctx); err != nil {
+		s.log.Warn("read settings", "namespace", namespace, "error", err.Error())
+		return f.Default
+	}
+	return s.lookup(namespace, f)
+}
+
+func (s *Store) lookup(namespace string, f Field) any {
+	if v, ok := s.values[namespace][f.Key]; ok {
+		return v
+	}
+	return f.Default
+}
+
+// load reads every stored setting unless cached. Callers hold s.mu.
+func (s *Store) load(ctx context.Context) error {
+	if s.values != nil {
+		return nil
+	}
+	rows, err := s.store.QueryPanelJSON(ctx, "SELECT namespace, key, value FROM settings;")
+	if err != nil {
+		return fmt.Errorf("load settings: %w", err)
+	}
+	values := map[string]map[string]any{}
+	for _, row := range rows {
+		namespace, _ := row["namespace"].(string)
+		key, _ := row["key"].(string)
+		raw, _ := row["value"].(string)
+		ns, ok := s.namespaces[namespace]
+		if !ok {
+			continue
+		}
+		f, ok := ns.field(key)
+		if !ok {
+			continue
+		}
+		v, err := f.decode(json.RawMessage(raw))
+		if err != nil {
+			s.log.Warn("ignore stored setting", "namespace", namespace, "key", key, "error", err.Error())
+			continue
+		}
+		if values[namespace] == nil {
+			values[namespace] = map[string]any{}
+		}
+		values[namespace][key] = v
+	}
+	s.values = values
+	return nil
+}
+
+// decode parses and validates a JSON value for the field.
+func (f Field) decode(raw json.RawMessage) (any, error) {
+	switch f.Type {
+	case TypeBool:
+		var v bool
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, errors.New("must be true or false")
+		}
+		return v, nil
+	case TypeInt:
+		var v int
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, errors.New("must be a whole number")
+		}
+		if v < f.Min || (f.Max > 0 && v > f.Max) {
+			return nil, fmt.Errorf("must be between %d and %d", f.Min, f.Max)
+		}
+		return v, nil
+	case TypeString, TypeEmail, TypeURL:
+		var v string
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, errors.New("must be a string")
+		}
+		v = strings.TrimSpace(v)
+		if v == "" {
+			return v, nil
+		}
+		switch f.Type {
+		case TypeEmail:
+			if !validEmail(v) {
+				return nil, fmt.Errorf("invalid email %q", v)
+			}
+		case TypeURL:
+			u, err := url.Parse(v)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("must be an http(s) URL")
+			}
+		}
+		return v, nil
+	case TypeEmails:
+		var v []string
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, errors.New("must be a list of email addresses")
+		}
+		out := make([]string, 0, len(v))
+		for _, addr := range v {
+			addr = strings.TrimSpace(addr)
+			if !validEmail(addr) {
+				return nil, fmt.Errorf("invalid email %q", addr)
+			}
+			if !slices.Contains(out, addr) {
+				out = append(out, addr)
+			}
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %q", f.Type)
+	}
+}
+
+func validEmail(v string) bool {
+	addr, err := mail.ParseAddress(v)
+	return err == nil && addr.Address == v
+}
+
+func sameValue(a, b any) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return bytes.Equal(x, y)
+}
+
+func auditValue(v any) string {
+	if list, ok := v.([]string); ok {
+		return "[" + strings.Join(list, ",") + "]"
+	}
+	if s, ok := v.(string); ok && s == "" {
+		return `""`
+	}
+	return fmt.Sprint(v)
+}
+
+func (s *Store) audit(ctx context.Context, actor, action, details string) {
+	_ = s.store.ExecAudit(ctx, fmt.Sprintf(
+		"INSERT INTO audit_events(actor, action, details, remote_ip, impersonator, created_at) VALUES('%s','%s','%s','%s','%s',%d);",
+		sqlEscape(actor), sqlEscape(action), sqlEscape(details),
+		sqlEscape(middleware.ClientIP(ctx)), sqlEscape(middleware.Impersonator(ctx)), s.now().Unix()))
+}
+
+func sqlEscape(in string) string {
+	return strings.ReplaceAll(in, "'", "''")
+}
@@ -0,0 +1,118 @@
+package settings
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/platform/config"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+func newTestStore(t *testing.T, cfg config.Config) (*Store, *sqlite.Store) {
+	t.Helper()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(context.Background()); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	return New(store, slog.New(slog.NewJSONHandler(io.Discard, nil)), Builtin(cfg)...), store
+}
+
+func changes(t *testing.T, raw string) map[string]json.RawMessage {
+	t.Helper()
+	var out map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		t.Fatalf("decode changes: %v", err)
+	}
+	return out
+}
+
+func TestStore_DefaultsFromConfig(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, config.Config{ACMEEmail: "ops@example.com", WebTerminalEnabled: true, LoginMaxFailures: 5})
+
+	if got := s.String(ctx, ACME, "email"); got != "ops@example.com" {
+		t.Fatalf("expected acme email default, got %q", got)
+	}
+	if !s.Bool(ctx, Security, "web_terminal") || s.Int(ctx, Security, "login_max_failures") != 5 {
+		t.Fatal("expected security defaults from config")
+	}
+	if got := s.Int(ctx, Backup, "pitr_retention_days"); got != 7 {
+		t.Fatalf("expected built-in pitr default, got %d", got)
+	}
+	values, err := s.Get(ctx, Notifications)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if emails, ok := values["emails"].([]string); !ok || len(emails) != 0 {
+		t.Fatalf("expected empty email list, got %#v", values["emails"])
+	}
+	if _, err := s.Get(ctx, "nope"); !errors.Is(err, ErrUnknownNamespace) {
+		t.Fatalf("expected ErrUnknownNamespace, got %v", err)
+	}
+}
+
+func TestStore_UpdateValidatesAndAudits(t *testing.T) {
+	ctx := context.Background()
+	s, store := newTestStore(t, config.Config{ACMEEmail: "ops@example.com"})
+
+	_, err := s.Update(ctx, Backup, changes(t, `{"retention": 0, "pitr_retention_days": "x", "bogus": 1}`), "admin@example.com")
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) || len(invalid.Fields) != 3 {
+		t.Fatalf("expected three invalid fields, got %v", err)
+	}
+	if _, err := s.Update(ctx, Notifications, changes(t, `{"emails": ["not-an-address"]}`), "admin@example.com"); !errors.As(err, &invalid) {
+		t.Fatalf("expected invalid email, got %v", err)
+	}
+
+	values, err := s.Update(ctx, ACME, changes(t, `{"email": " certs@example.com ", "staging": true}`), "admin@example.com")
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if values["email"] != "certs@example.com" || values["staging"] != true {
+		t.Fatalf("unexpected values: %v", values)
+	}
+	if _, err := s.Update(ctx, Notifications, changes(t, `{"emails": ["a@example.com", "a@example.com"], "webhook_url": "https://hooks.example.com/t0ken"}`), "admin@example.com"); err != nil {
+		t.Fatalf("update notifications: %v", err)
+	}
+	if got := s.Strings(ctx, Notifications, "emails"); len(got) != 1 || got[0] != "a@example.com" {
+		t.Fatalf("expected deduplicated emails, got %v", got)
+	}
+
+	// A fresh store reads what was written; null resets to the default.
+	reloaded := New(store, nil, Builtin(config.Config{ACMEEmail: "ops@example.com"})...)
+	if got := reloaded.String(ctx, ACME, "email"); got != "certs@example.com" {
+		t.Fatalf("expected stored email, got %q", got)
+	}
+	if _, err := reloaded.Update(ctx, ACME, changes(t, `{"email": null}`), "admin@example.com"); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if got := reloaded.String(ctx, ACME, "email"); got != "ops@example.com" {
+		t.Fatalf("expected default after reset, got %q", got)
+	}
+
+	rows, err := store.QueryAuditJSON(ctx, "SELECT details FROM audit_events WHERE action='settings.update' ORDER BY id;")
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("expected three audit events, got %v (%v)", rows, err)
+	}
+	first, _ := rows[0]["details"].(string)
+	if !strings.Contains(first, "email: ops@example.com -> certs@example.com") || !strings.Contains(first, "staging: false -> true") {
+		t.Fatalf("unexpected audit details: %q", first)
+	}
+	second, _ := rows[1]["details"].(string)
+	if strings.Contains(second, "t0ken") || !strings.Contains(second, "webhook_url changed") {
+		t.Fatalf("expected secret left out of audit, got %q", second)
+	}
+
+	// A zero lockout threshold disables that counter, as in panel.yaml.
+	if _, err := s.Update(ctx, Security, changes(t, `{"login_max_failures": 0}`), "admin@example.com"); err != nil {
+		t.Fatalf("expected zero threshold accepted, got %v", err)
+	}
+	if _, err := s.Update(ctx, Security, changes(t, `{"login_max_failures_per_ip": -1}`), "admin@example.com"); !errors.As(err, &invalid) {
+		t.Fatalf("expected negative threshold rejected, got %v", err)
+	}
+}
@@ -9,8 +9,29 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
+const (
+	// busyTimeoutMS is applied to every sqlite3 invocation; the pragma is
+	// per-connection, so setting it once in Init does not carry over.
+	busyTimeoutMS = 5000
+	// lockedRetries bounds retries of writes that still hit "database is locked"
+	// (e.g. when another process holds the write lock past the busy timeout).
+	lockedRetries = 3
+)
+
+// writeLocks serializes writes per database file within the process. Each
+// sqlite3 invocation is its own connection, so there is no pool to share;
+// queuing writers here keeps concurrent requests from racing for the lock.
+var writeLocks sync.Map
+
+func writeLock(dbPath string) *sync.Mutex {
+	mu, _ := writeLocks.LoadOrStore(filepath.Clean(dbPath), &sync.Mutex{})
+	return mu.(*sync.Mutex)
+}
+
 // Store holds paths for panel databases and provides basic SQL helpers.
 type Store struct {
 	DataDir string
@@ -54,10 +75,81 @@ CREATE TABLE IF NOT EXISTS sessions (
   user_id INTEGER NOT NULL,
   expires_at INTEGER NOT NULL,
   created_at INTEGER NOT NULL,
+  impersonator_id INTEGER NOT NULL DEFAULT 0,
   FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
 );
 CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
 CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
+CREATE TABLE IF NOT EXISTS login_failures (
+  kind TEXT NOT NULL,
+  key TEXT NOT NULL,
+  failures INTEGER NOT NULL,
+  first_failed_at INTEGER NOT NULL,
+  locked_until INTEGER NOT NULL DEFAULT 0,
+  updated_at INTEGER NOT NULL,
+  PRIMARY KEY(kind, key)
+);
+CREATE TABLE IF NOT EXISTS organizations (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL UNIQUE,
+  created_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS organization_members (
+  org_id INTEGER NOT NULL,
+  user_id INTEGER NOT NULL,
+  role TEXT NOT NULL,
+  created_at INTEGER NOT NULL,
+  PRIMARY KEY(org_id, user_id),
+  FOREIGN KEY(org_id) REFERENCES organizations(id) ON DELETE CASCADE,
+  FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_organization_members_user_id ON organization_members(user_id);
+CREATE TABLE IF NOT EXISTS organization_sites (
+  site_id INTEGER PRIMARY KEY,
+  org_id INTEGER NOT NULL,
+  created_at INTEGER NOT NULL,
+  FOREIGN KEY(site_id) REFERENCES sites(id) ON DELETE CASCADE,
+  FOREIGN KEY(org_id) REFERENCES organizations(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_organization_sites_org_id ON organization_sites(org_id);
+CREATE TABLE IF NOT EXISTS user_site_grants (
+  user_id INTEGER NOT NULL,
+  site_id INTEGER NOT NULL,
+  actions TEXT NOT NULL,
+  created_at INTEGER NOT NULL,
+  PRIMARY KEY(user_id, site_id),
+  FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY(site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_user_site_grants_site_id ON user_site_grants(site_id);
+CREATE TABLE IF NOT EXISTS api_tokens (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL,
+  name TEXT NOT NULL,
+  token_hash TEXT NOT NULL UNIQUE,
+  site_ids TEXT NOT NULL DEFAULT '',
+  actions TEXT NOT NULL DEFAULT '',
+  expires_at INTEGER NOT NULL DEFAULT 0,
+  last_used_at INTEGER NOT NULL DEFAULT 0,
+  created_at INTEGER NOT NULL,
+  FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
+CREATE TABLE IF NOT EXISTS approvals (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  operation TEXT NOT NULL,
+  target TEXT NOT NULL,
+  payload TEXT NOT NULL DEFAULT '',
+  requested_by TEXT NOT NULL,
+  status TEXT NOT NULL,
+  decided_by TEXT NOT NULL DEFAULT '',
+  error TEXT NOT NULL DEFAULT '',
+  job_id INTEGER NOT NULL DEFAULT 0,
+  created_at INTEGER NOT NULL,
+  expires_at INTEGER NOT NULL,
+  decided_at INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_approvals_status ON approvals(status);
 CREATE TABLE IF NOT EXISTS sites (
   id INTEGER PRIMARY KEY AUTOINCREMENT,
   domain TEXT NOT NULL UNIQUE,
@@ -65,6 +157,10 @@ CREATE TABLE IF NOT EXISTS sites (
   php_version TEXT NOT NULL DEFAULT '8.5',
   system_user TEXT NOT NULL,
   status TEXT NOT NULL DEFAULT 'active',
+  open_basedir_relaxed INTEGER NOT NULL DEFAULT 0,
+  canonical_host TEXT NOT NULL DEFAULT '',
+  health_status TEXT NOT NULL DEFAULT '',
+  health_report TEXT NOT NULL DEFAULT '',
   created_at INTEGER NOT NULL,
   updated_at INTEGER NOT NULL
 );
@@ -80,10 +176,422 @@ CREATE TABLE IF NOT EXISTS site_databases (
 );
 CREATE INDEX IF NOT EXISTS idx_site_databases_site_id ON site_databases(site_id);
 CREATE UNIQUE INDEX IF NOT EXISTS idx_site_databases_engine_name ON site_databases(db_engine, db_name);
+
+CREATE TABLE IF NOT EXISTS site_cloudflare (
+  site_id INTEGER PRIMARY KEY,
+  zone_id TEXT NOT NULL,
+  zone_name TEXT NOT NULL,
+  proxied INTEGER NOT NULL DEFAULT 0,
+  record_ids TEXT NOT NULL DEFAULT '',
+  updated_at INTEGER NOT NULL,
+  FOREIGN KEY(site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS site_registrar (
+  site_id INTEGER PRIMARY KEY,
+  registrar TEXT NOT NULL,
+  nameservers TEXT NOT NULL DEFAULT '',
+  glue TEXT NOT NULL DEFAULT '',
+  updated_at INTEGER NOT NULL,
+  FOREIGN KEY(site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS site_wordpress (
+  site_id INTEGER PRIMARY KEY,
+  auto_update_core TEXT NOT NULL DEFAULT '',
+  auto_update_plugins INTEGER NOT NULL DEFAULT 0,
+  auto_update_themes INTEGER NOT NULL DEFAULT 0,
+  hardening TEXT NOT NULL DEFAULT '',
+  vulnerabilities TEXT NOT NULL DEFAULT '',
+  scanned_at INTEGER NOT NULL DEFAULT 0,
+  updated_at INTEGER NOT NULL,
+  FOREIGN KEY(site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS site_storage (
+  site_id INTEGER PRIMARY KEY,
+  endpoint TEXT NOT NULL,
+  region TEXT NOT NULL,
+  bucket TEXT NOT NULL,
+  access_key TEXT NOT NULL,
+  secret_key TEXT NOT NULL,
+  path_style INTEGER NOT NULL DEFAULT 0,
+  updated_at INTEGER NOT NULL,
+  FOREIGN KEY(site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS site_limits (
+  site_id INTEGER PRIMARY KEY,
+  cpu_quota_percent INTEGER NOT NULL DEFAULT 0,
+  memory_max_mb INTEGER NOT NULL DEFAULT 0,
+  tasks_max INTEGER NOT NULL DEFAULT 0,
+  updated_at INTEGER NOT NULL,
+  FOREIGN KEY(site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS site_page_cache (
+  site_id INTEGER PRIMARY KEY,
+  backend TEXT NOT NULL,
+  ttl_seconds INTEGER NOT NULL,
+  updated_at INTEGER NOT NULL,
+  FOREIGN KEY(site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS site_memcached (
+  site_id INTEGER PRIMARY KEY,
+  memory_mb INTEGER NOT NULL,
+  max_connections INTEGER NOT NULL,
+  updated_at INTEGER NOT NULL,
+  FOREIGN KEY(site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS site_workers (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  site_id INTEGER NOT NULL,
+  name TEXT NOT NULL,
+  command TEXT NOT NULL,
+  processes INTEGER NOT NULL DEFAULT 1,
+  restart TEXT NOT NULL DEFAULT 'always',
+  restart_sec INTEGER NOT NULL DEFAULT 5,
+  stop_timeout_sec INTEGER NOT NULL DEFAULT 30,
+  running INTEGER NOT NULL DEFAULT 1,
+  created_at INTEGER NOT NULL,
+  updated_at INTEGER NOT NULL,
+  UNIQUE(site_id, name),
+  FOREIGN KEY(site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS site_sftp (
+  site_id INTEGER PRIMARY KEY,
+  public_key TEXT NOT NULL,
+  fingerprint TEXT NOT NULL,
+  created_at INTEGER NOT NULL,
+  updated_at INTEGER NOT NULL,
+  FOREIGN KEY(site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS site_error_budgets (
+  site_id INTEGER PRIMARY KEY,
+  threshold_percent REAL,
+  alerting INTEGER NOT NULL DEFAULT 0,
+  alerted_at INTEGER NOT NULL DEFAULT 0,
+  updated_at INTEGER NOT NULL DEFAULT 0,
+  FOREIGN KEY(site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS site_error_rates (
+  site_id INTEGER NOT NULL,
+  minute INTEGER NOT NULL,
+  requests INTEGER NOT NULL,
+  errors INTEGER NOT NULL,
+  PRIMARY KEY(site_id, minute),
+  FOREIGN KEY(site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS site_cron_jobs (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  site_id INTEGER NOT NULL,
+  command TEXT NOT NULL,
+  interval_minutes INTEGER NOT NULL,
+  timeout_seconds INTEGER NOT NULL,
+  alert_after INTEGER NOT NULL,
+  enabled INTEGER NOT NULL DEFAULT 1,
+  next_run_at INTEGER NOT NULL,
+  last_run_at INTEGER NOT NULL DEFAULT 0,
+  last_exit_code INTEGER,
+  consecutive_failures INTEGER NOT NULL DEFAULT 0,
+  heartbeat_url TEXT NOT NULL DEFAULT '',
+  created_at INTEGER NOT NULL,
+  updated_at INTEGER NOT NULL,
+  FOREIGN KEY(site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_site_cron_jobs_due ON site_cron_jobs(enabled, next_run_at);
+
+CREATE TABLE IF NOT EXISTS site_cron_runs (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  cron_id INTEGER NOT NULL,
+  started_at INTEGER NOT NULL,
+  duration_ms INTEGER NOT NULL,
+  exit_code INTEGER NOT NULL,
+  output TEXT NOT NULL DEFAULT '',
+  FOREIGN KEY(cron_id) REFERENCES site_cron_jobs(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_site_cron_runs_cron ON site_cron_runs(cron_id, id);
+
+CREATE TABLE IF NOT EXISTS self_test_runs (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  started_at INTEGER NOT NULL,
+  ok INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS self_test_results (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  run_id INTEGER NOT NULL,
+  check_name TEXT NOT NULL,
+  ok INTEGER NOT NULL,
+  detail TEXT NOT NULL DEFAULT '',
+  duration_ms INTEGER NOT NULL,
+  consecutive_failures INTEGER NOT NULL DEFAULT 0,
+  FOREIGN KEY(run_id) REFERENCES self_test_runs(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_self_test_results_run ON self_test_results(run_id);
+CREATE INDEX IF NOT EXISTS idx_self_test_results_check ON self_test_results(check_name, id);
+
+CREATE TABLE IF NOT EXISTS self_test_probe (
+  id INTEGER PRIMARY KEY CHECK (id = 1),
+  token TEXT NOT NULL,
+  written_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS resource_samples (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  collected_at INTEGER NOT NULL,
+  cpu_percent REAL NOT NULL,
+  memory_total INTEGER NOT NULL,
+  memory_used INTEGER NOT NULL,
+  disk_total INTEGER NOT NULL,
+  disk_used INTEGER NOT NULL,
+  sites TEXT NOT NULL DEFAULT '{}',
+  units TEXT NOT NULL DEFAULT '{}'
+);
+CREATE INDEX IF NOT EXISTS idx_resource_samples_collected ON resource_samples(collected_at);
+
+CREATE TABLE IF NOT EXISTS mail_failures (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  recipient TEXT NOT NULL,
+  subject TEXT NOT NULL,
+  error TEXT NOT NULL,
+  created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_mail_failures_created_at ON mail_failures(created_at);
+
+CREATE TABLE IF NOT EXISTS certificate_failures (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  domain TEXT NOT NULL,
+  staging INTEGER NOT NULL DEFAULT 0,
+  error TEXT NOT NULL,
+  created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_certificate_failures_domain ON certificate_failures(domain, created_at);
+
+CREATE TABLE IF NOT EXISTS runtime_components (
+  name TEXT PRIMARY KEY,
+  enabled INTEGER NOT NULL DEFAULT 1,
+  updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS admin_tools (
+  name TEXT PRIMARY KEY,
+  installed_version TEXT NOT NULL DEFAULT '',
+  latest_version TEXT NOT NULL DEFAULT '',
+  release_json TEXT NOT NULL DEFAULT '',
+  checked_at INTEGER NOT NULL DEFAULT 0,
+  updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS certificate_renewals (
+  domain TEXT PRIMARY KEY,
+  consecutive_failures INTEGER NOT NULL DEFAULT 0,
+  last_error TEXT NOT NULL DEFAULT '',
+  last_attempt_at INTEGER NOT NULL DEFAULT 0,
+  last_success_at INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS database_backup_schedules (
+  database_id INTEGER PRIMARY KEY,
+  interval_minutes INTEGER NOT NULL,
+  retention INTEGER NOT NULL,
+  enabled INTEGER NOT NULL DEFAULT 1,
+  next_run_at INTEGER NOT NULL,
+  last_run_at INTEGER NOT NULL DEFAULT 0,
+  last_error TEXT NOT NULL DEFAULT '',
+  heartbeat_url TEXT NOT NULL DEFAULT '',
+  updated_at INTEGER NOT NULL,
+  FOREIGN KEY(database_id) REFERENCES site_databases(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS backup_recipients (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL,
+  public_key TEXT NOT NULL UNIQUE,
+  created_by TEXT NOT NULL DEFAULT '',
+  created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS backup_settings (
+  id INTEGER PRIMARY KEY CHECK (id = 1),
+  site_retention INTEGER NOT NULL DEFAULT 0,
+  panel_retention INTEGER NOT NULL DEFAULT 0,
+  remote_endpoint TEXT NOT NULL DEFAULT '',
+  remote_region TEXT NOT NULL DEFAULT '',
+  remote_bucket TEXT NOT NULL DEFAULT '',
+  remote_prefix TEXT NOT NULL DEFAULT '',
+  remote_access_key TEXT NOT NULL DEFAULT '',
+  remote_secret_key TEXT NOT NULL DEFAULT '',
+  remote_path_style INTEGER NOT NULL DEFAULT 0,
+  allow_plaintext INTEGER NOT NULL DEFAULT 0,
+  updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS backup_runs (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  kind TEXT NOT NULL,
+  target TEXT NOT NULL DEFAULT '',
+  site_id INTEGER NOT NULL DEFAULT 0,
+  database_id INTEGER NOT NULL DEFAULT 0,
+  trigger_name TEXT NOT NULL DEFAULT '',
+  status TEXT NOT NULL,
+  location TEXT NOT NULL DEFAULT '',
+  bytes INTEGER NOT NULL DEFAULT 0,
+  error TEXT NOT NULL DEFAULT '',
+  started_at INTEGER NOT NULL,
+  finished_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_backup_runs_kind ON backup_runs(kind, id);
+
+CREATE TABLE IF NOT EXISTS backup_remote_objects (
+  object_key TEXT PRIMARY KEY,
+  size INTEGER NOT NULL,
+  uploaded_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS site_migrations (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  domain TEXT NOT NULL UNIQUE,
+  site_id INTEGER NOT NULL,
+  source TEXT NOT NULL DEFAULT '',
+  status TEXT NOT NULL,
+  files INTEGER NOT NULL DEFAULT 0,
+  bytes INTEGER NOT NULL DEFAULT 0,
+  created_at INTEGER NOT NULL,
+  updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS php_upgrades (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  job_id INTEGER NOT NULL DEFAULT 0,
+  from_version TEXT NOT NULL DEFAULT '',
+  to_version TEXT NOT NULL DEFAULT '',
+  status TEXT NOT NULL,
+  sites TEXT NOT NULL DEFAULT '[]',
+  error TEXT NOT NULL DEFAULT '',
+  actor TEXT NOT NULL DEFAULT '',
+  created_at INTEGER NOT NULL,
+  updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS template_rollouts (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  job_id INTEGER NOT NULL DEFAULT 0,
+  template TEXT NOT NULL,
+  sha256 TEXT NOT NULL DEFAULT '',
+  diff TEXT NOT NULL DEFAULT '',
+  status TEXT NOT NULL,
+  canaries TEXT NOT NULL DEFAULT '[]',
+  promoted INTEGER NOT NULL DEFAULT 0,
+  error TEXT NOT NULL DEFAULT '',
+  actor TEXT NOT NULL DEFAULT '',
+  created_at INTEGER NOT NULL,
+  updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS proxy_hosts (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  host TEXT NOT NULL UNIQUE,
+  upstream TEXT NOT NULL,
+  tls INTEGER NOT NULL DEFAULT 0,
+  websocket INTEGER NOT NULL DEFAULT 0,
+  allow_list TEXT NOT NULL DEFAULT '',
+  created_at INTEGER NOT NULL,
+  updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS firewall_rules (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  protocol TEXT NOT NULL,
+  port INTEGER NOT NULL,
+  source TEXT NOT NULL DEFAULT '',
+  action TEXT NOT NULL,
+  comment TEXT NOT NULL DEFAULT '',
+  created_at INTEGER NOT NULL,
+  updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS firewall_state (
+  id INTEGER PRIMARY KEY CHECK (id = 1),
+  pending_until INTEGER NOT NULL DEFAULT 0,
+  confirmed_rules TEXT NOT NULL DEFAULT '[]',
+  updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS os_update_settings (
+  id INTEGER PRIMARY KEY CHECK (id = 1),
+  auto_security INTEGER NOT NULL DEFAULT 0,
+  window_start_hour INTEGER NOT NULL DEFAULT 3,
+  window_hours INTEGER NOT NULL DEFAULT 2,
+  last_auto_run_at INTEGER NOT NULL DEFAULT 0,
+  updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS retention_settings (
+  id INTEGER PRIMARY KEY CHECK (id = 1),
+  audit_days INTEGER NOT NULL,
+  metrics_days INTEGER NOT NULL,
+  jobs_days INTEGER NOT NULL,
+  logs_days INTEGER NOT NULL,
+  log_max_size_mb INTEGER NOT NULL,
+  last_run_at INTEGER NOT NULL DEFAULT 0,
+  updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS port_reservations (
+  port INTEGER PRIMARY KEY,
+  owner TEXT NOT NULL,
+  created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS settings (
+  namespace TEXT NOT NULL,
+  key TEXT NOT NULL,
+  value TEXT NOT NULL,
+  updated_by TEXT NOT NULL DEFAULT '',
+  updated_at INTEGER NOT NULL,
+  PRIMARY KEY(namespace, key)
+);
 `
 	if err := s.exec(ctx, s.PanelDB, panelSchema); err != nil {
 		return fmt.Errorf("apply panel schema: %w", err)
 	}
+	if err := s.ensureColumns(ctx, s.PanelDB, "sessions", []columnDef{
+		{name: "impersonator_id", def: "INTEGER NOT NULL DEFAULT 0"},
+	}); err != nil {
+		return fmt.Errorf("migrate panel schema: %w", err)
+	}
+	if err := s.ensureColumns(ctx, s.PanelDB, "sites", []columnDef{
+		{name: "open_basedir_relaxed", def: "INTEGER NOT NULL DEFAULT 0"},
+		{name: "canonical_host", def: "TEXT NOT NULL DEFAULT ''"},
+		{name: "health_status", def: "TEXT NOT NULL DEFAULT ''"},
+		{name: "health_report", def: "TEXT NOT NULL DEFAULT ''"},
+	}); err != nil {
+		return fmt.Errorf("migrate panel schema: %w", err)
+	}
+	if err := s.ensureColumns(ctx, s.PanelDB, "approvals", []columnDef{
+		{name: "job_id", def: "INTEGER NOT NULL DEFAULT 0"},
+	}); err != nil {
+		return fmt.Errorf("migrate panel schema: %w", err)
+	}
+	if err := s.ensureColumns(ctx, s.PanelDB, "backup_settings", []columnDef{
+		{name: "allow_plaintext", def: "INTEGER NOT NULL DEFAULT 0"},
+	}); err != nil {
+		return fmt.Errorf("migrate panel schema: %w", err)
+	}
+	for _, table := range []string{"site_cron_jobs", "database_backup_schedules"} {
+		if err := s.ensureColumns(ctx, s.PanelDB, table, []columnDef{
+			{name: "heartbeat_url", def: "TEXT NOT NULL DEFAULT ''"},
+		}); err != nil {
+			return fmt.Errorf("migrate panel schema: %w", err)
+		}
+	}
 
 	auditSchema := `
 CREATE TABLE IF NOT EXISTS audit_events (
@@ -91,6 +599,10 @@ CREATE TABLE IF NOT EXISTS audit_events (
   actor TEXT NOT NULL,
   action TEXT NOT NULL,
   details TEXT NOT NULL,
+  remote_ip TEXT NOT NULL DEFAULT '',
+  impersonator TEXT NOT NULL DEFAULT '',
+  site_id INTEGER NOT NULL DEFAULT 0,
+  database_id INTEGER NOT NULL DEFAULT 0,
   created_at INTEGER NOT NULL
 );
 CREATE INDEX IF NOT EXISTS idx_audit_created_at ON audit_events(created_at);
@@ -98,6 +610,22 @@ CREATE INDEX IF NOT EXISTS idx_audit_created_at ON audit_events(created_at);
 	if err := s.exec(ctx, s.AuditDB, auditSchema); err != nil {
 		return fmt.Errorf("apply audit schema: %w", err)
 	}
+	if err := s.ensureColumns(ctx, s.AuditDB, "audit_events", []columnDef{
+		{name: "remote_ip", def: "TEXT NOT NULL DEFAULT ''"},
+		{name: "impersonator", def: "TEXT NOT NULL DEFAULT ''"},
+		{name: "site_id", def: "INTEGER NOT NULL DEFAULT 0"},
+		{name: "database_id", def: "INTEGER NOT NULL DEFAULT 0"},
+	}); err != nil {
+		return fmt.Errorf("migrate audit schema: %w", err)
+	}
+	// The indexes follow the columns, which older audit.db files only get
+	// from ensureColumns.
+	if err := s.exec(ctx, s.AuditDB, `
+CREATE INDEX IF NOT EXISTS idx_audit_site_id ON audit_events(site_id, id);
+CREATE INDEX IF NOT EXISTS idx_audit_database_id ON audit_events(database_id, id);
+`); err != nil {
+		return fmt.Errorf("index audit schema: %w", err)
+	}
 
 	queueSchema := `
 CREATE TABLE IF NOT EXISTS jobs (
@@ -112,6 +640,13 @@ CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs(status);
 	if err := s.exec(ctx, s.QueueDB, queueSchema); err != nil {
 		return fmt.Errorf("apply queue schema: %w", err)
 	}
+	if err := s.ensureColumns(ctx, s.QueueDB, "jobs", []columnDef{
+		{name: "attempts", def: "INTEGER NOT NULL DEFAULT 0"},
+		{name: "last_error", def: "TEXT NOT NULL DEFAULT ''"},
+		{name: "updated_at", def: "INTEGER NOT NULL DEFAULT 0"},
+	}); err != nil {
+		return fmt.Errorf("migrate queue schema: %w", err)
+	}
 
 	return nil
 }
@@ -126,22 +661,180 @@ func (s *Store) QueryPanelJSON(ctx context.Context, sql string) ([]map[string]an
 	return s.queryJSON(ctx, s.PanelDB, sql)
 }
 
+// QueryPanelWriteJSON runs a write that returns rows, such as INSERT ...
+// RETURNING, against panel.db. Like ExecPanel it holds the write lock and
+// retries while the database is locked.
+func (s *Store) QueryPanelWriteJSON(ctx context.Context, sql string) ([]map[string]any, error) {
+	return s.writeQueryJSON(ctx, s.PanelDB, sql)
+}
+
+// ExecQueue executes a write SQL statement against queue.db.
+func (s *Store) ExecQueue(ctx context.Context, sql string) error {
+	return s.exec(ctx, s.QueueDB, sql)
+}
+
+// QueryQueueJSON runs a SELECT against queue.db and parses JSON output.
+func (s *Store) QueryQueueJSON(ctx context.Context, sql string) ([]map[string]any, error) {
+	return s.queryJSON(ctx, s.QueueDB, sql)
+}
+
+// QueryQueueWriteJSON runs a write that returns rows against queue.db,
+// holding the write lock like ExecQueue.
+func (s *Store) QueryQueueWriteJSON(ctx context.Context, sql string) ([]map[string]any, error) {
+	return s.writeQueryJSON(ctx, s.QueueDB, sql)
+}
+
 // ExecAudit inserts/updates audit data.
 func (s *Store) ExecAudit(ctx context.Context, sql string) error {
 	return s.exec(ctx, s.AuditDB, sql)
 }
 
-func (s *Store) exec(ctx context.Context, dbPath, sql string) error {
-	cmd := exec.CommandContext(ctx, "sqlite3", dbPath, sql)
-	out, err := cmd.CombinedOutput()
+// QueryAuditJSON runs a SELECT against audit.db and parses JSON output.
+func (s *Store) QueryAuditJSON(ctx context.Context, sql string) ([]map[string]any, error) {
+	return s.queryJSON(ctx, s.AuditDB, sql)
+}
+
+// BackupPanel writes a consistent copy of panel.db to dest with the sqlite3
+// online backup API, so running writers never leave it half-applied.
+func (s *Store) BackupPanel(ctx context.Context, dest string) error {
+	cmd := fmt.Sprintf(".backup '%s'", strings.ReplaceAll(dest, "'", "''"))
+	out, err := exec.CommandContext(ctx, "sqlite3", "-cmd", timeoutCommand(), s.PanelDB, cmd).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("sqlite3 backup: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// IntegrityCheck runs PRAGMA integrity_check on every panel database and
+// returns problems keyed by database file name (empty when all are "ok").
+func (s *Store) IntegrityCheck(ctx context.Context) (map[string][]string, error) {
+	problems := map[string][]string{}
+	for _, db := range []string{s.PanelDB, s.AuditDB, s.QueueDB} {
+		rows, err := s.queryJSON(ctx, db, "PRAGMA integrity_check;")
+		if err != nil {
+			return nil, fmt.Errorf("integrity check %s: %w", filepath.Base(db), err)
+		}
+		for _, row := range rows {
+			for _, v := range row {
+				msg := strings.TrimSpace(fmt.Sprint(v))
+				if msg != "" && msg != "ok" {
+					problems[filepath.Base(db)] = append(problems[filepath.Base(db)], msg)
+				}
+			}
+		}
+	}
+	return problems, nil
+}
+
+type columnDef struct {
+	name string
+	def  string
+}
+
+// ensureColumns adds columns missing from tables created by older releases;
+// CREATE TABLE IF NOT EXISTS leaves existing tables untouched.
+func (s *Store) ensureColumns(ctx context.Context, dbPath, table string, cols []columnDef) error {
+	rows, err := s.queryJSON(ctx, dbPath, fmt.Sprintf("PRAGMA table_info(%s);", table))
 	if err != nil {
-		return fmt.Errorf("sqlite3 exec: %w: %s", err, strings.TrimSpace(string(out)))
+		return err
+	}
+	existing := map[string]bool{}
+	for _, row := range rows {
+		existing[fmt.Sprint(row["name"])] = true
+	}
+	for _, c := range cols {
+		if existing[c.name] {
+			continue
+		}
+		if err := s.exec(ctx, dbPath, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, c.name, c.def)); err != nil {
+			return fmt.Errorf("add column %s.%s: %w", table, c.name, err)
+		}
 	}
 	return nil
 }
 
+func (s *Store) exec(ctx context.Context, dbPath, sql string) error {
+	mu := writeLock(dbPath)
+	mu.Lock()
+	defer mu.Unlock()
+
+	sql = inTransaction(sql)
+	var lastErr error
+	for attempt := 0; attempt < lockedRetries; attempt++ {
+		cmd := exec.CommandContext(ctx, "sqlite3", "-cmd", timeoutCommand(), dbPath, sql)
+		out, err := cmd.CombinedOutput()
+		if err == nil {
+			return nil
+		}
+		lastErr = fmt.Errorf("sqlite3 exec: %w: %s", err, strings.TrimSpace(string(out)))
+		if !isLockedOutput(out) || !waitLocked(ctx, attempt) {
+			return lastErr
+		}
+	}
+	return lastErr
+}
+
+// writeQueryJSON is queryJSON for statements that write, serialized and
+// retried like exec.
+func (s *Store) writeQueryJSON(ctx context.Context, dbPath, sql string) ([]map[string]any, error) {
+	mu := writeLock(dbPath)
+	mu.Lock()
+	defer mu.Unlock()
+
+	sql = inTransaction(sql)
+	var lastErr error
+	for attempt := 0; attempt < lockedRetries; attempt++ {
+		rows, err := s.queryJSON(ctx, dbPath, sql)
+		if err == nil {
+			return rows, nil
+		}
+		lastErr = err
+		if !isLockedOutput([]byte(err.Error())) || !waitLocked(ctx, attempt) {
+			return nil, lastErr
+		}
+	}
+	return nil, lastErr
+}
+
+// inTransaction wraps a write script in BEGIN IMMEDIATE ... COMMIT, so a
+// retry after "database is locked" never re-runs statements that already
+// took effect: sqlite3 stops at the failing statement and the open
+// transaction rolls back when it exits. Scripts that open their own
+// transaction, and PRAGMAs, which cannot run inside one, are left as is.
+func inTransaction(sql string) string {
+	body := strings.TrimSpace(sql)
+	head := strings.ToUpper(body)
+	if strings.HasPrefix(head, "BEGIN") || strings.HasPrefix(head, "PRAGMA") {
+		return sql
+	}
+	if !strings.HasSuffix(body, ";") {
+		body += ";"
+	}
+	return "BEGIN IMMEDIATE;\n" + body + "\nCOMMIT;"
+}
+
+// waitLocked backs off before retrying a locked write and reports false
+// when ctx ends first.
+func waitLocked(ctx context.Context, attempt int) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(time.Duration(attempt+1) * 100 * time.Millisecond):
+		return true
+	}
+}
+
+func timeoutCommand() string {
+	return fmt.Sprintf(".timeout %d", busyTimeoutMS)
+}
+
+func isLockedOutput(out []byte) bool {
+	msg := strings.ToLower(string(out))
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "database is busy")
+}
+
 func (s *Store) queryJSON(ctx context.Context, dbPath, sql string) ([]map[string]any, error) {
-	cmd := exec.CommandContext(ctx, "sqlite3", "-json", dbPath, sql)
+	cmd := exec.CommandContext(ctx, "sqlite3", "-json", "-cmd", timeoutCommand(), dbPath, sql)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return nil, fmt.Errorf("sqlite3 query: %w: %s", err, strings.TrimSpace(string(out)))
@@ -2,7 +2,9 @@ package sqlite
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 )
 
 func TestStoreInit_AllowsSameDatabaseNameAcrossEngines(t *testing.T) {
@@ -41,3 +43,89 @@ ORDER BY id;`)
 		t.Fatalf("expected 2 rows for shared_db across engines, got %d", len(rows))
 	}
 }
+
+func TestStoreExec_ConcurrentWritesAreSerialized(t *testing.T) {
+	ctx := context.Background()
+	store := New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+
+	const writers = 8
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			errs <- store.ExecAudit(ctx, fmt.Sprintf(
+				"INSERT INTO audit_events(actor, action, details, created_at) VALUES('test', 'write', '%d', %d);", i, i))
+		}(i)
+	}
+	for i := 0; i < writers; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("concurrent write: %v", err)
+		}
+	}
+
+	rows, err := store.queryJSON(ctx, store.AuditDB, "SELECT COUNT(*) AS n FROM audit_events;")
+	if err != nil {
+		t.Fatalf("count rows: %v", err)
+	}
+	if len(rows) != 1 || rows[0]["n"] != float64(writers) {
+		t.Fatalf("expected %d rows, got %v", writers, rows)
+	}
+}
+
+func TestStoreQueryPanelWriteJSON_HoldsWriteLock(t *testing.T) {
+	ctx := context.Background()
+	store := New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+
+	mu := writeLock(store.PanelDB)
+	mu.Lock()
+	done := make(chan []map[string]any, 1)
+	go func() {
+		rows, err := store.QueryPanelWriteJSON(ctx, `
+INSERT INTO sites(domain, root_dir, php_version, system_user, status, created_at, updated_at)
+VALUES('returning.example.com', '/var/www/returning.example.com/public_html', '8.5', 'site_returning', 'active', 1, 1)
+RETURNING id;`)
+		if err != nil {
+			t.Errorf("insert returning: %v", err)
+		}
+		done <- rows
+	}()
+	select {
+	case <-done:
+		t.Fatal("expected the write to wait for the panel write lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+	mu.Unlock()
+	rows := <-done
+	if len(rows) != 1 || rows[0]["id"] != float64(1) {
+		t.Fatalf("expected the returned id, got %v", rows)
+	}
+}
+
+func TestStoreExec_FailedScriptLeavesNothingBehind(t *testing.T) {
+	ctx := context.Background()
+	store := New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+
+	// The second insert fails; the first must not stay applied, or a
+	// retry of the script after "database is locked" would repeat it.
+	err := store.ExecAudit(ctx, `
+INSERT INTO audit_events(actor, action, details, created_at) VALUES('test', 'first', '', 1);
+INSERT INTO no_such_table(x) VALUES(1)`)
+	if err == nil {
+		t.Fatal("expected the script to fail")
+	}
+	rows, err := store.QueryAuditJSON(ctx, "SELECT COUNT(*) AS n FROM audit_events;")
+	if err != nil || len(rows) != 1 || rows[0]["n"] != float64(0) {
+		t.Fatalf("expected no audit event, got %v (%v)", rows, err)
+	}
+	if got := inTransaction("PRAGMA journal_mode=WAL;"); got != "PRAGMA journal_mode=WAL;" {
+		t.Fatalf("expected PRAGMA left outside a transaction, got %q", got)
+	}
+}
@@ -154,6 +154,12 @@ func EnableNow(ctx context.Context, runner Runner, unit string) error {
 	return err
 }
 
+// DisableNow disables and stops a unit.
+func DisableNow(ctx context.Context, runner Runner, unit string) error {
+	_, err := runner.Run(ctx, "systemctl", "disable", "--now", unit)
+	return err
+}
+
 // Restart restarts a unit.
 func Restart(ctx context.Context, runner Runner, unit string) error {
 	_, err := runner.Run(ctx, "systemctl", "restart", unit)
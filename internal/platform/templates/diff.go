@@ -0,0 +1,224 @@
+package templates
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines around each unified hunk.
+const diffContext = 3
+
+// splitLines splits s into lines that keep their trailing newline, so
+// joining them gives s back.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// lcsMatch maps each line of a to the line of b it is paired with in a
+// longest common subsequence, or -1.
+func lcsMatch(a, b []string) []int {
+	n, m := len(a), len(b)
+	table := make([][]int, n+1)
+	for i := range table {
+		table[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				table[i][j] = table[i+1][j+1] + 1
+			case table[i+1][j] >= table[i][j+1]:
+				table[i][j] = table[i+1][j]
+			default:
+				table[i][j] = table[i][j+1]
+			}
+		}
+	}
+	match := make([]int, n)
+	for i := range match {
+		match[i] = -1
+	}
+	for i, j := 0, 0; i < n && j < m; {
+		switch {
+		case a[i] == b[j]:
+			match[i] = j
+			i++
+			j++
+		case table[i+1][j] >= table[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return match
+}
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+func diffLines(a, b []string) []diffOp {
+	match := lcsMatch(a, b)
+	ops := make([]diffOp, 0, len(a)+len(b))
+	j := 0
+	for i, line := range a {
+		if match[i] < 0 {
+			ops = append(ops, diffOp{'-', line})
+			continue
+		}
+		for ; j < match[i]; j++ {
+			ops = append(ops, diffOp{'+', b[j]})
+		}
+		ops = append(ops, diffOp{' ', line})
+		j++
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// UnifiedDiff renders the changes from a to b as a unified diff with the
+// given file labels, or "" when they are equal.
+func UnifiedDiff(fromLabel, toLabel, a, b string) string {
+	if a == b {
+		return ""
+	}
+	ops := diffLines(splitLines(a), splitLines(b))
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromLabel, toLabel)
+	for start := 0; start < len(ops); {
+		// Skip to the next change, keeping diffContext lines before it.
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		begin := max(first-diffContext, start)
+		// Extend the hunk while changes are closer than 2*diffContext.
+		end := first
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContext {
+				end = min(end+diffContext, len(ops))
+				break
+			}
+			end = run
+		}
+		aStart, bStart := 1, 1
+		for _, op := range ops[:begin] {
+			if op.kind != '+' {
+				aStart++
+			}
+			if op.kind != '-' {
+				bStart++
+			}
+		}
+		aLen, bLen := 0, 0
+		for _, op := range ops[begin:end] {
+			if op.kind != '+' {
+				aLen++
+			}
+			if op.kind != '-' {
+				bLen++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aStart, aLen, bStart, bLen)
+		for _, op := range ops[begin:end] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = end
+	}
+	return out.String()
+}
+
+// Merge three-way merges local and shipped changes made on top of base.
+// Regions changed differently on both sides are wrapped in conflict
+// markers and counted.
+func Merge(base, local, shipped string) (string, int) {
+	b, l, s := splitLines(base), splitLines(local), splitLines(shipped)
+	toLocal, toShipped := lcsMatch(b, l), lcsMatch(b, s)
+	var out strings.Builder
+	conflicts := 0
+	i, j, k := 0, 0, 0
+	for {
+		// The next base line kept by both sides ends the unstable chunk.
+		stable := i
+		for stable < len(b) && (toLocal[stable] < 0 || toShipped[stable] < 0) {
+			stable++
+		}
+		lEnd, sEnd := len(l), len(s)
+		if stable < len(b) {
+			lEnd, sEnd = toLocal[stable], toShipped[stable]
+		}
+		baseChunk, localChunk, shippedChunk := b[i:stable], l[j:lEnd], s[k:sEnd]
+		switch {
+		case equalLines(baseChunk, localChunk):
+			writeLines(&out, shippedChunk)
+		case equalLines(baseChunk, shippedChunk), equalLines(localChunk, shippedChunk):
+			writeLines(&out, localChunk)
+		default:
+			conflicts++
+			out.WriteString("<<<<<<< local\n")
+			writeLines(&out, terminated(localChunk))
+			out.WriteString("=======\n")
+			writeLines(&out, terminated(shippedChunk))
+			out.WriteString(">>>>>>> shipped\n")
+		}
+		if stable == len(b) {
+			break
+		}
+		out.WriteString(b[stable])
+		i, j, k = stable+1, lEnd+1, sEnd+1
+	}
+	return out.String(), conflicts
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for n := range a {
+		if a[n] != b[n] {
+			return false
+		}
+	}
+	return true
+}
+
+// terminated makes sure conflict markers start on their own line.
+func terminated(lines []string) []string {
+	if n := len(lines); n > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+		out := append([]string(nil), lines...)
+		out[n-1] += "\n"
+		return out
+	}
+	return lines
+}
+
+func writeLines(out *strings.Builder, lines []string) {
+	for _, line := range lines {
+		out.WriteString(line)
+	}
+}
@@ -0,0 +1,387 @@
+// Package templates tracks the nginx/php-fpm templates the installer ships
+// to /etc/aipanel/templates so operator edits survive upgrades: shipped
+// versions are hashed and kept next to the live files, drift is reported,
+// and local edits can be diffed against and merged with a new release.
+package templates
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// DefaultDir holds the live templates read by the hosting adapters.
+const DefaultDir = "/etc/aipanel/templates"
+
+const (
+	manifestFile = ".manifest.json"
+	shippedDir   = ".shipped"
+	baseSuffix   = ".base"
+)
+
+// Template states.
+const (
+	// StatePristine means the live file is the shipped template.
+	StatePristine = "pristine"
+	// StateCustomized means the live file was edited and the shipped
+	// template has not changed since.
+	StateCustomized = "customized"
+	// StateDrift means the live file was edited and a newer template was
+	// shipped since; the edits should be merged with it.
+	StateDrift = "drift"
+	// StateMissing means the live file was deleted.
+	StateMissing = "missing"
+)
+
+// Install outcomes.
+const (
+	Installed = "installed"
+	Updated   = "updated"
+	Unchanged = "unchanged"
+	Kept      = "kept"
+	// Drifted means local edits were kept on top of an older release.
+	Drifted = "drifted"
+)
+
+var (
+	// ErrNotFound is returned for a template the installer never shipped.
+	ErrNotFound = errors.New("template not found")
+	// ErrConflict is returned when a merge needs manual resolution.
+	ErrConflict = errors.New("template merge has conflicts")
+	// ErrInvalid is returned for content that would break rendering.
+	ErrInvalid = errors.New("invalid template")
+)
+
+// entry records one template. Shipped is the hash of the newest shipped
+// body; Base is the hash of the shipped body the live file derives from.
+// They differ while local edits predate the newest release. An empty Base
+// means the origin of a pre-existing edit is unknown.
+type entry struct {
+	Path    string `json:"path"`
+	Shipped string `json:"shipped_sha256"`
+	Base    string `json:"base_sha256"`
+}
+
+// Status describes one tracked template.
+type Status struct {
+	Name          string `json:"name"`
+	Path          string `json:"path"`
+	State         string `json:"state"`
+	SHA256        string `json:"sha256,omitempty"`
+	ShippedSHA256 string `json:"shipped_sha256"`
+	BaseSHA256    string `json:"base_sha256,omitempty"`
+}
+
+// Diff shows how a template differs from what was shipped.
+type Diff struct {
+	Name string `json:"name"`
+	// Local is the operator's edits: the shipped body against the live file.
+	Local string `json:"local"`
+	// Upstream is what the newest release changed under those edits; it is
+	// empty unless the template drifted.
+	Upstream string `json:"upstream,omitempty"`
+}
+
+// Store manages the templates under one directory.
+type Store struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// New returns a store for dir.
+func New(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Install writes body to path unless the live file carries local edits,
+// in which case only the shipped copy is refreshed and the edits are kept.
+// It returns one of Installed, Updated, Unchanged, Kept or Drifted.
+func (s *Store) Install(name, path, body string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	manifest, err := s.loadManifest()
+	if err != nil {
+		return "", err
+	}
+	shippedHash := hashOf(body)
+	e, tracked := manifest[name]
+	e.Path = path
+
+	current, err := os.ReadFile(path) //nolint:gosec // G304: path is an installer template path.
+	outcome := ""
+	switch {
+	case os.IsNotExist(err):
+		outcome = Installed
+	case err != nil:
+		return "", fmt.Errorf("read template %s: %w", name, err)
+	case string(current) == body:
+		outcome = Unchanged
+	case tracked && hashOf(string(current)) == e.Base:
+		outcome = Updated
+	default:
+		outcome = Kept
+	}
+
+	if outcome == Kept {
+		if tracked && e.Base != "" && e.Base == e.Shipped && shippedHash != e.Shipped {
+			// Keep the release the edits were made against for merging.
+			if err := copyFile(s.shippedPath(name), s.shippedPath(name)+baseSuffix); err != nil {
+				return "", fmt.Errorf("keep base of template %s: %w", name, err)
+			}
+		}
+		e.Shipped = shippedHash
+		if e.Base != e.Shipped {
+			outcome = Drifted
+		}
+	} else {
+		if outcome != Unchanged {
+			if err := writeFile(path, body, 0o644); err != nil {
+				return "", fmt.Errorf("write template %s: %w", name, err)
+			}
+		}
+		e.Shipped, e.Base = shippedHash, shippedHash
+		_ = os.Remove(s.shippedPath(name) + baseSuffix)
+	}
+	if err := writeFile(s.shippedPath(name), body, 0o644); err != nil {
+		return "", fmt.Errorf("write shipped template %s: %w", name, err)
+	}
+	manifest[name] = e
+	if err := s.saveManifest(manifest); err != nil {
+		return "", err
+	}
+	return outcome, nil
+}
+
+// List returns the state of every tracked template, sorted by name.
+func (s *Store) List() ([]Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	manifest, err := s.loadManifest()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Status, 0, len(manifest))
+	for name, e := range manifest {
+		out = append(out, statusOf(name, e))
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].Name < out[b].Name })
+	return out, nil
+}
+
+// Get returns the live content and state of a template.
+func (s *Store) Get(name string) (string, Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, err := s.entry(name)
+	if err != nil {
+		return "", Status{}, err
+	}
+	content, err := os.ReadFile(e.Path) //nolint:gosec // G304: path comes from the manifest.
+	if err != nil && !os.IsNotExist(err) {
+		return "", Status{}, fmt.Errorf("read template %s: %w", name, err)
+	}
+	return string(content), statusOf(name, e), nil
+}
+
+// Diff returns the local edits of a template and, when it drifted, the
+// shipped changes they have to be merged with.
+func (s *Store) Diff(name string) (Diff, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, err := s.entry(name)
+	if err != nil {
+		return Diff{}, err
+	}
+	live, shipped, base, err := s.versions(name, e)
+	if err != nil {
+		return Diff{}, err
+	}
+	d := Diff{Name: name, Local: UnifiedDiff("shipped/"+name, "local/"+name, shipped, live)}
+	if e.Base != e.Shipped {
+		d.Upstream = UnifiedDiff("base/"+name, "shipped/"+name, base, shipped)
+	}
+	return d, nil
+}
+
+// Merge three-way merges the local edits with the newest shipped template
+// and returns the result with the number of conflicts. With apply and no
+// conflicts the result replaces the live file; conflicts return
+// ErrConflict and leave it untouched.
+func (s *Store) Merge(name string, apply bool) (string, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, err := s.entry(name)
+	if err != nil {
+		return "", 0, err
+	}
+	live, shipped, base, err := s.versions(name, e)
+	if err != nil {
+		return "", 0, err
+	}
+	merged, conflicts := Merge(base, live, shipped)
+	if !apply {
+		return merged, conflicts, nil
+	}
+	if conflicts > 0 {
+		return merged, conflicts, ErrConflict
+	}
+	return merged, 0, s.write(name, e, merged)
+}
+
+// Put replaces the live template, e.g. with a manually resolved merge or
+// an imported customization, and marks it as based on the newest release.
+func (s *Store) Put(name, content string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, err := s.entry(name)
+	if err != nil {
+		return err
+	}
+	return s.write(name, e, content)
+}
+
+// Reset replaces the live template with the newest shipped one.
+func (s *Store) Reset(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, err := s.entry(name)
+	if err != nil {
+		return err
+	}
+	shipped, err := os.ReadFile(s.shippedPath(name))
+	if err != nil {
+		return fmt.Errorf("read shipped template %s: %w", name, err)
+	}
+	return s.write(name, e, string(shipped))
+}
+
+func (s *Store) write(name string, e entry, content string) error {
+	if strings.Contains(content, "<<<<<<< local") {
+		return fmt.Errorf("%w: %s still contains conflict markers", ErrInvalid, name)
+	}
+	if _, err := template.New(name).Parse(content); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if err := writeFile(e.Path, content, 0o644); err != nil {
+		return fmt.Errorf("write template %s: %w", name, err)
+	}
+	manifest, err := s.loadManifest()
+	if err != nil {
+		return err
+	}
+	e.Base = e.Shipped
+	manifest[name] = e
+	_ = os.Remove(s.shippedPath(name) + baseSuffix)
+	return s.saveManifest(manifest)
+}
+
+// versions returns the live, newest shipped and base bodies of a template.
+func (s *Store) versions(name string, e entry) (string, string, string, error) {
+	live, err := os.ReadFile(e.Path) //nolint:gosec // G304: path comes from the manifest.
+	if err != nil && !os.IsNotExist(err) {
+		return "", "", "", fmt.Errorf("read template %s: %w", name, err)
+	}
+	shipped, err := os.ReadFile(s.shippedPath(name))
+	if err != nil {
+		return "", "", "", fmt.Errorf("read shipped template %s: %w", name, err)
+	}
+	base := shipped
+	if e.Base != e.Shipped {
+		// An unknown base merges as if both sides added everything.
+		base = nil
+		if e.Base != "" {
+			if base, err = os.ReadFile(s.shippedPath(name) + baseSuffix); err != nil {
+				return "", "", "", fmt.Errorf("read base of template %s: %w", name, err)
+			}
+		}
+	}
+	return string(live), string(shipped), string(base), nil
+}
+
+func (s *Store) entry(name string) (entry, error) {
+	manifest, err := s.loadManifest()
+	if err != nil {
+		return entry{}, err
+	}
+	e, ok := manifest[name]
+	if !ok {
+		return entry{}, ErrNotFound
+	}
+	return e, nil
+}
+
+func (s *Store) shippedPath(name string) string {
+	return filepath.Join(s.dir, shippedDir, filepath.Base(name))
+}
+
+func (s *Store) loadManifest() (map[string]entry, error) {
+	manifest := map[string]entry{}
+	raw, err := os.ReadFile(filepath.Join(s.dir, manifestFile))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read template manifest: %w", err)
+	}
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return nil, fmt.Errorf("parse template manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+func (s *Store) saveManifest(manifest map[string]entry) error {
+	raw, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode template manifest: %w", err)
+	}
+	if err := writeFile(filepath.Join(s.dir, manifestFile), string(raw)+"\n", 0o644); err != nil {
+		return fmt.Errorf("write template manifest: %w", err)
+	}
+	return nil
+}
+
+func statusOf(name string, e entry) Status {
+	st := Status{Name: name, Path: e.Path, ShippedSHA256: e.Shipped, BaseSHA256: e.Base}
+	content, err := os.ReadFile(e.Path) //nolint:gosec // G304: path comes from the manifest.
+	switch {
+	case err != nil:
+		st.State = StateMissing
+		return st
+	case hashOf(string(content)) == e.Base && e.Base == e.Shipped:
+		st.State = StatePristine
+	case e.Base == e.Shipped:
+		st.State = StateCustomized
+	default:
+		st.State = StateDrift
+	}
+	st.SHA256 = hashOf(string(content))
+	return st
+}
+
+func hashOf(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+func copyFile(src, dst string) error {
+	raw, err := os.ReadFile(src) //nolint:gosec // G304: both paths live in the template dir.
+	if err != nil {
+		return err
+	}
+	return writeFile(dst, string(raw), 0o644)
+}
+
+func writeFile(path, content string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(content), mode)
+}
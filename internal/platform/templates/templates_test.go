@@ -0,0 +1,98 @@
+package templates
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	releaseOne = "server {\n    listen 80;\n    root {{.Root}};\n    index index.php;\n}\n"
+	releaseTwo = "server {\n    listen 80;\n    listen [::]:80;\n    root {{.Root}};\n    index index.php;\n}\n"
+	localEdit  = "server {\n    listen 80;\n    root {{.Root}};\n    index index.php index.html;\n}\n"
+)
+
+func TestInstall_KeepsEditsAndMergesNewRelease(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "nginx_vhost.conf.tmpl")
+	store := New(dir)
+
+	if outcome, err := store.Install("nginx_vhost.conf.tmpl", path, releaseOne); err != nil || outcome != Installed {
+		t.Fatalf("first install: %q, %v", outcome, err)
+	}
+	if err := os.WriteFile(path, []byte(localEdit), 0o644); err != nil {
+		t.Fatalf("edit template: %v", err)
+	}
+	if outcome, err := store.Install("nginx_vhost.conf.tmpl", path, releaseTwo); err != nil || outcome != Drifted {
+		t.Fatalf("upgrade: %q, %v", outcome, err)
+	}
+	if raw, _ := os.ReadFile(path); string(raw) != localEdit {
+		t.Fatalf("expected local edit to survive the upgrade, got:\n%s", raw)
+	}
+
+	list, err := store.List()
+	if err != nil || len(list) != 1 || list[0].State != StateDrift {
+		t.Fatalf("expected one drifted template, got %+v (%v)", list, err)
+	}
+	diff, err := store.Diff("nginx_vhost.conf.tmpl")
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	if !strings.Contains(diff.Local, "+    index index.php index.html;") || !strings.Contains(diff.Upstream, "+    listen [::]:80;") {
+		t.Fatalf("unexpected diff: %+v", diff)
+	}
+
+	merged, conflicts, err := store.Merge("nginx_vhost.conf.tmpl", true)
+	if err != nil || conflicts != 0 {
+		t.Fatalf("merge: %d conflicts, %v", conflicts, err)
+	}
+	want := "server {\n    listen 80;\n    listen [::]:80;\n    root {{.Root}};\n    index index.php index.html;\n}\n"
+	if merged != want {
+		t.Fatalf("unexpected merge:\n%s", merged)
+	}
+	if list, _ := store.List(); list[0].State != StateCustomized {
+		t.Fatalf("expected customized after merge, got %s", list[0].State)
+	}
+}
+
+func TestInstall_UpdatesPristineTemplate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "phpfpm_pool.conf.tmpl")
+	store := New(dir)
+	if _, err := store.Install("phpfpm_pool.conf.tmpl", path, releaseOne); err != nil {
+		t.Fatalf("install: %v", err)
+	}
+	if outcome, err := store.Install("phpfpm_pool.conf.tmpl", path, releaseTwo); err != nil || outcome != Updated {
+		t.Fatalf("upgrade: %q, %v", outcome, err)
+	}
+	if list, _ := store.List(); list[0].State != StatePristine {
+		t.Fatalf("expected pristine, got %s", list[0].State)
+	}
+}
+
+func TestMerge_ReportsConflicts(t *testing.T) {
+	local := strings.Replace(releaseOne, "listen 80;", "listen 8080;", 1)
+	shipped := strings.Replace(releaseOne, "listen 80;", "listen 81;", 1)
+	merged, conflicts := Merge(releaseOne, local, shipped)
+	if conflicts != 1 || !strings.Contains(merged, "<<<<<<< local\n    listen 8080;\n=======\n    listen 81;\n>>>>>>> shipped\n") {
+		t.Fatalf("expected one conflict, got %d:\n%s", conflicts, merged)
+	}
+
+	dir := t.TempDir()
+	store := New(dir)
+	path := filepath.Join(dir, "t.tmpl")
+	if _, err := store.Install("t.tmpl", path, releaseOne); err != nil {
+		t.Fatalf("install: %v", err)
+	}
+	if err := store.Put("t.tmpl", merged); err == nil {
+		t.Fatal("expected conflict markers to be rejected")
+	}
+	if err := store.Put("t.tmpl", "{{.Root"); err == nil {
+		t.Fatal("expected an unparsable template to be rejected")
+	}
+	if err := store.Put("missing.tmpl", releaseOne); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
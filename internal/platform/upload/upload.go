@@ -0,0 +1,220 @@
+// Package upload streams multipart uploads to temporary files so large
+// files (backup imports, file manager uploads) never sit in memory, and
+// guarantees the temporary files are removed.
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// dirPrefix names per-request directories so CleanStale only touches
+	// what this package created.
+	dirPrefix = "upload-"
+	// maxFieldBytes bounds a non-file form value.
+	maxFieldBytes   = 64 << 10
+	defaultMaxFiles = 16
+)
+
+var (
+	// ErrTooLarge is returned when a file or the request body is over its limit.
+	ErrTooLarge = errors.New("upload too large")
+	// ErrNotMultipart is returned for requests without a multipart body.
+	ErrNotMultipart = errors.New("request is not multipart/form-data")
+)
+
+// Dir returns the directory uploads are staged in for a data dir.
+func Dir(dataDir string) string {
+	return filepath.Join(dataDir, "tmp", "uploads")
+}
+
+// Options controls Receive.
+type Options struct {
+	// Dir holds the per-request temporary directories.
+	Dir string
+	// MaxFileBytes bounds each file; 0 leaves files to the body limit.
+	MaxFileBytes int64
+	// MaxFiles bounds the number of file parts (default 16).
+	MaxFiles int
+}
+
+// File is an uploaded file stored at Path until Cleanup.
+type File struct {
+	Field    string `json:"field"`
+	Filename string `json:"filename"`
+	Path     string `json:"-"`
+	Size     int64  `json:"size"`
+}
+
+// Upload holds the form values and files of one request.
+type Upload struct {
+	Fields map[string]string
+	Files  []File
+	dir    string
+}
+
+// Receive reads a multipart body part by part, writing file parts to a
+// fresh temporary directory. On error nothing is left behind; on success
+// the caller must call Cleanup, after moving out the files it keeps.
+func Receive(r *http.Request, opts Options) (*Upload, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, ErrNotMultipart
+	}
+	if opts.MaxFiles <= 0 {
+		opts.MaxFiles = defaultMaxFiles
+	}
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create upload dir: %w", err)
+	}
+	dir, err := os.MkdirTemp(opts.Dir, dirPrefix+"*")
+	if err != nil {
+		return nil, fmt.Errorf("create upload dir: %w", err)
+	}
+	u := &Upload{Fields: map[string]string{}, dir: dir}
+	if err := u.read(mr, opts); err != nil {
+		_ = u.Cleanup()
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			return nil, ErrTooLarge
+		}
+		return nil, err
+	}
+	return u, nil
+}
+
+func (u *Upload) read(mr *multipart.Reader, opts Options) error {
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read multipart body: %w", err)
+		}
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFieldBytes+1))
+			_ = part.Close()
+			if err != nil {
+				return fmt.Errorf("read form field: %w", err)
+			}
+			if len(value) > maxFieldBytes {
+				return fmt.Errorf("%w: form field %s", ErrTooLarge, part.FormName())
+			}
+			u.Fields[part.FormName()] = string(value)
+			continue
+		}
+		if len(u.Files) >= opts.MaxFiles {
+			_ = part.Close()
+			return fmt.Errorf("%w: more than %d files", ErrTooLarge, opts.MaxFiles)
+		}
+		f, err := u.store(part, opts.MaxFileBytes)
+		_ = part.Close()
+		if err != nil {
+			return err
+		}
+		u.Files = append(u.Files, f)
+	}
+}
+
+func (u *Upload) store(part *multipart.Part, maxBytes int64) (File, error) {
+	name := filepath.Base(strings.ReplaceAll(part.FileName(), `\`, "/"))
+	if name == "." || name == "/" || name == ".." {
+		return File{}, fmt.Errorf("invalid file name %q", part.FileName())
+	}
+	// Files are numbered on disk so equal client names cannot collide.
+	path := filepath.Join(u.dir, fmt.Sprintf("%d-%s", len(u.Files), name))
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600) //nolint:gosec // G304: path is inside our temp dir.
+	if err != nil {
+		return File{}, fmt.Errorf("create upload file: %w", err)
+	}
+	var src io.Reader = part
+	if maxBytes > 0 {
+		src = io.LimitReader(part, maxBytes+1)
+	}
+	n, copyErr := io.Copy(out, src)
+	closeErr := out.Close()
+	switch {
+	case copyErr != nil:
+		return File{}, fmt.Errorf("write upload file: %w", copyErr)
+	case closeErr != nil:
+		return File{}, fmt.Errorf("write upload file: %w", closeErr)
+	case maxBytes > 0 && n > maxBytes:
+		return File{}, fmt.Errorf("%w: %s is over %d bytes", ErrTooLarge, name, maxBytes)
+	}
+	return File{Field: part.FormName(), Filename: name, Path: path, Size: n}, nil
+}
+
+// Cleanup removes the temporary files that were not moved away. It is
+// safe to call more than once.
+func (u *Upload) Cleanup() error {
+	if u == nil || u.dir == "" {
+		return nil
+	}
+	err := os.RemoveAll(u.dir)
+	u.dir = ""
+	return err
+}
+
+// Handler receives the upload, calls fn and cleans up after it returns,
+// even when fn panics. Receive errors are answered with 413 or 400.
+func Handler(opts Options, fn func(http.ResponseWriter, *http.Request, *Upload)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		u, err := Receive(r, opts)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrTooLarge):
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			case errors.Is(err, ErrNotMultipart):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				http.Error(w, "failed to receive upload", http.StatusBadRequest)
+			}
+			return
+		}
+		defer func() {
+			_ = u.Cleanup()
+		}()
+		fn(w, r, u)
+	})
+}
+
+// CleanStale removes upload directories under dir older than maxAge, left
+// behind by a crash or a killed process. It returns how many were removed.
+func CleanStale(dir string, maxAge time.Duration) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read upload dir: %w", err)
+	}
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), dirPrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return removed, fmt.Errorf("remove stale upload: %w", err)
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,100 @@
+package upload
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func multipartRequest(t *testing.T, files map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if err := mw.WriteField("site_id", "7"); err != nil {
+		t.Fatalf("write field: %v", err)
+	}
+	for name, content := range files {
+		fw, err := mw.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatalf("create form file: %v", err)
+		}
+		_, _ = fw.Write([]byte(content))
+	}
+	_ = mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/files/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestHandler_StreamsFilesAndCleansUp(t *testing.T) {
+	dir := t.TempDir()
+	var staged string
+	h := Handler(Options{Dir: dir}, func(w http.ResponseWriter, _ *http.Request, u *Upload) {
+		if u.Fields["site_id"] != "7" || len(u.Files) != 1 || u.Files[0].Filename != "backup.tar.gz" {
+			t.Errorf("unexpected upload: %+v", u)
+		}
+		staged = u.Files[0].Path
+		raw, err := os.ReadFile(staged) //nolint:gosec // test reads its own upload.
+		if err != nil || string(raw) != "archive" {
+			t.Errorf("unexpected staged file %q (%v)", raw, err)
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, multipartRequest(t, map[string]string{"../../backup.tar.gz": "archive"}))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	if !strings.HasPrefix(staged, dir) {
+		t.Fatalf("expected the file to be staged under %s, got %s", dir, staged)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("expected temp files to be removed, found %d entries", len(entries))
+	}
+}
+
+func TestHandler_RejectsOversizedFile(t *testing.T) {
+	dir := t.TempDir()
+	h := Handler(Options{Dir: dir, MaxFileBytes: 4}, func(http.ResponseWriter, *http.Request, *Upload) {
+		t.Error("handler must not run for an oversized upload")
+	})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, multipartRequest(t, map[string]string{"big.bin": "0123456789"}))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", rec.Code)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("expected partial upload to be removed, found %d entries", len(entries))
+	}
+}
+
+func TestCleanStale(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, dirPrefix+"old")
+	fresh := filepath.Join(dir, dirPrefix+"fresh")
+	other := filepath.Join(dir, "keep")
+	for _, d := range []string{old, fresh, other} {
+		if err := os.Mkdir(d, 0o700); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+	past := time.Now().Add(-48 * time.Hour)
+	_ = os.Chtimes(old, past, past)
+	_ = os.Chtimes(other, past, past)
+
+	removed, err := CleanStale(dir, 24*time.Hour)
+	if err != nil || removed != 1 {
+		t.Fatalf("expected one removal, got %d (%v)", removed, err)
+	}
+	for path, want := range map[string]bool{old: false, fresh: true, other: true} {
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Fatalf("%s: expected exists=%t", path, want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func echoServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		for {
+			typ, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(typ, msg); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func dial(t *testing.T, srv *httptest.Server, origin string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	host := strings.TrimPrefix(srv.URL, "http://")
+	conn, err := net.Dial("tcp", host)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	req := "GET / HTTP/1.1\r\nHost: " + host + "\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+	if origin != "" {
+		req += "Origin: " + origin + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		t.Fatalf("write handshake: %v", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("read handshake: %v", err)
+	}
+	return conn, br, resp
+}
+
+func writeClientFrame(t *testing.T, conn net.Conn, opcode byte, payload []byte) {
+	t.Helper()
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode}
+	if len(payload) < 126 {
+		frame = append(frame, 0x80|byte(len(payload)))
+	} else {
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatalf("write frame: %v", err)
+	}
+}
+
+func readServerFrame(t *testing.T, br *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		var ext [2]byte
+		if _, err := io.ReadFull(br, ext[:]); err != nil {
+			t.Fatalf("read frame length: %v", err)
+		}
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatalf("read payload: %v", err)
+	}
+	return header[0] & 0x0f, payload
+}
+
+func TestUpgradeAndEcho(t *testing.T) {
+	srv := echoServer(t)
+	conn, br, resp := dial(t, srv, srv.URL)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	// Accept key from the RFC 6455 example handshake.
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected accept key %q", got)
+	}
+
+	writeClientFrame(t, conn, PingMessage, []byte("hi"))
+	if op, payload := readServerFrame(t, br); op != PongMessage || string(payload) != "hi" {
+		t.Fatalf("expected pong, got op=%d payload=%q", op, payload)
+	}
+
+	large := []byte(strings.Repeat("x", 300))
+	writeClientFrame(t, conn, BinaryMessage, large)
+	if op, payload := readServerFrame(t, br); op != BinaryMessage || string(payload) != string(large) {
+		t.Fatalf("unexpected echo: op=%d len=%d", op, len(payload))
+	}
+
+	// A fragmented text message is reassembled.
+	mask := []byte{0, 0, 0, 0}
+	if _, err := conn.Write(append(append([]byte{TextMessage, 0x80 | 3}, mask...), "hel"...)); err != nil {
+		t.Fatalf("write fragment: %v", err)
+	}
+	writeClientFrame(t, conn, 0, []byte("lo"))
+	if op, payload := readServerFrame(t, br); op != TextMessage || string(payload) != "hello" {
+		t.Fatalf("unexpected reassembled message: op=%d payload=%q", op, payload)
+	}
+
+	writeClientFrame(t, conn, CloseMessage, []byte{0x03, 0xe8})
+	if op, _ := readServerFrame(t, br); op != CloseMessage {
+		t.Fatalf("expected close echo, got op=%d", op)
+	}
+}
+
+func TestUpgradeRejectsCrossOrigin(t *testing.T) {
+	srv := echoServer(t)
+	_, _, resp := dial(t, srv, "https://evil.example")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 for cross-origin upgrade, got %d", resp.StatusCode)
+	}
+}
+
+func TestUpgradeRequiresWebSocketRequest(t *testing.T) {
+	srv := echoServer(t)
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for plain request, got %d", resp.StatusCode)
+	}
+}
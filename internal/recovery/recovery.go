@@ -0,0 +1,421 @@
+// Package recovery rebuilds a panel from its backup dir on a fresh server:
+// panel.db, sites, docroots, databases and certificates.
+package recovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/robsonek/aiPanel/internal/modules/backup"
+	"github.com/robsonek/aiPanel/internal/modules/database"
+	"github.com/robsonek/aiPanel/internal/modules/hosting"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+// ErrNotFresh indicates a restore onto a panel that already has sites.
+var ErrNotFresh = errors.New("panel already has sites; restore onto a fresh install or pass --force")
+
+// Sites recreates sites from restored panel rows.
+type Sites interface {
+	ReprovisionSite(ctx context.Context, id int64, actor string) (hosting.Site, error)
+	IssueCertificate(ctx context.Context, req hosting.IssueCertificateRequest) error
+}
+
+// Databases recreates site databases from restored panel rows.
+type Databases interface {
+	ReprovisionDatabase(ctx context.Context, id int64, actor string) (database.ReprovisionResult, error)
+}
+
+// Files restores site docroots from the file backup repository.
+type Files interface {
+	RestoreLatest(ctx context.Context, siteID int64, identity, actor string) (backup.Snapshot, backup.RestoreResult, error)
+}
+
+// Options configures a restore.
+type Options struct {
+	// From is a copy of <data_dir>/backups: a directory, a file:// URL, or
+	// a .tar.gz archive given as a path or an http(s) URL.
+	From string
+	// Identity is the exported install key or a client escrow key
+	// (AIPANEL-BACKUP-SK:...). It becomes this panel's install key.
+	Identity string
+	// Force restores over a panel that already has sites.
+	Force bool
+	// SkipCerts leaves certificates to be issued later.
+	SkipCerts bool
+	Actor     string
+}
+
+// Step is the outcome of restoring one site, database or certificate.
+type Step struct {
+	Target string `json:"target"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// DatabaseStep is a restored database with its new credentials.
+type DatabaseStep struct {
+	Step
+	Connection *database.ConnectionInfo `json:"connection,omitempty"`
+}
+
+// Report summarizes a restore.
+type Report struct {
+	Source       string         `json:"source"`
+	PanelBackup  string         `json:"panel_backup"`
+	Users        int            `json:"users"`
+	Sites        []Step         `json:"sites"`
+	Databases    []DatabaseStep `json:"databases"`
+	Certificates []Step         `json:"certificates"`
+}
+
+// Failures returns the number of steps that did not complete.
+func (r Report) Failures() int {
+	n := 0
+	for _, s := range r.Sites {
+		if s.Error != "" {
+			n++
+		}
+	}
+	for _, s := range r.Databases {
+		if s.Error != "" {
+			n++
+		}
+	}
+	for _, s := range r.Certificates {
+		if s.Error != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Restorer runs disaster recovery restores.
+type Restorer struct {
+	store     *sqlite.Store
+	sites     Sites
+	databases Databases
+	files     Files
+}
+
+// New returns a Restorer. The services must query store on every call, as
+// panel.db is replaced under them.
+func New(store *sqlite.Store, sites Sites, databases Databases, files Files) *Restorer {
+	return &Restorer{store: store, sites: sites, databases: databases, files: files}
+}
+
+// Run replays the newest panel.db backup from opts.From and rebuilds the
+// server from it. Errors before panel.db is replaced leave the panel as it
+// was; failures after that are recorded per step in the report, so one
+// broken site does not stop the rest.
+func (r *Restorer) Run(ctx context.Context, opts Options) (Report, error) {
+	if r.store == nil || r.sites == nil || r.databases == nil || r.files == nil {
+		return Report{}, fmt.Errorf("restorer is not configured")
+	}
+	report := Report{Source: opts.From}
+	if !opts.Force {
+		rows, err := r.store.QueryPanelJSON(ctx, "SELECT COUNT(*) AS n FROM sites;")
+		if err != nil {
+			return report, fmt.Errorf("count sites: %w", err)
+		}
+		if len(rows) > 0 && intValue(rows[0]["n"]) > 0 {
+			return report, ErrNotFresh
+		}
+	}
+	if err := r.adoptIdentity(opts.Identity, opts.Force); err != nil {
+		return report, err
+	}
+
+	staging, err := os.MkdirTemp(r.store.DataDir, ".restore-")
+	if err != nil {
+		return report, fmt.Errorf("create restore staging dir: %w", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(staging)
+	}()
+	src, err := fetch(ctx, opts.From, staging)
+	if err != nil {
+		return report, err
+	}
+	if err := copyTree(src, backup.Dir(r.store.DataDir)); err != nil {
+		return report, err
+	}
+	panelBackup, err := r.replayPanel(ctx)
+	if err != nil {
+		return report, err
+	}
+	report.PanelBackup = filepath.Base(panelBackup)
+	if rows, err := r.store.QueryPanelJSON(ctx, "SELECT COUNT(*) AS n FROM users;"); err == nil && len(rows) > 0 {
+		report.Users = intValue(rows[0]["n"])
+	}
+
+	sites, err := r.store.QueryPanelJSON(ctx, "SELECT id, domain FROM sites ORDER BY id;")
+	if err != nil {
+		return report, fmt.Errorf("list sites: %w", err)
+	}
+	for _, row := range sites {
+		report.Sites = append(report.Sites, r.restoreSite(ctx, int64(intValue(row["id"])), stringValue(row["domain"]), opts))
+	}
+	dbs, err := r.store.QueryPanelJSON(ctx, "SELECT id FROM site_databases ORDER BY id;")
+	if err != nil {
+		return report, fmt.Errorf("list databases: %w", err)
+	}
+	for _, row := range dbs {
+		report.Databases = append(report.Databases, r.restoreDatabase(ctx, int64(intValue(row["id"])), opts.Actor))
+	}
+	if !opts.SkipCerts {
+		for i, row := range sites {
+			domain := stringValue(row["domain"])
+			if report.Sites[i].Error != "" {
+				continue
+			}
+			step := Step{Target: domain, Detail: "issued"}
+			if err := r.sites.IssueCertificate(ctx, hosting.IssueCertificateRequest{Domain: domain, Actor: opts.Actor}); err != nil {
+				step = Step{Target: domain, Error: err.Error()}
+			}
+			report.Certificates = append(report.Certificates, step)
+		}
+	}
+	r.writeAudit(ctx, opts.Actor, fmt.Sprintf("source=%s panel_backup=%s sites=%d databases=%d failures=%d",
+		opts.From, report.PanelBackup, len(report.Sites), len(report.Databases), report.Failures()))
+	return report, nil
+}
+
+// restoreSite restores the docroot before recreating the site, so the
+// recursive chown of provisioning maps the snapshot's owners, numeric ids
+// of the old server, onto the new system user.
+func (r *Restorer) restoreSite(ctx context.Context, id int64, domain string, opts Options) Step {
+	step := Step{Target: domain}
+	snap, result, restoreErr := r.files.RestoreLatest(ctx, id, opts.Identity, opts.Actor)
+	if _, err := r.sites.ReprovisionSite(ctx, id, opts.Actor); err != nil {
+		step.Error = "reprovision: " + err.Error()
+		return step
+	}
+	switch {
+	case errors.Is(restoreErr, backup.ErrSnapshotNotFound):
+		step.Error = "no file snapshot; the docroot is empty"
+	case restoreErr != nil:
+		step.Error = "restore files: " + restoreErr.Error()
+	default:
+		step.Detail = fmt.Sprintf("snapshot %s (%d files)", snap.ID, result.Files)
+	}
+	return step
+}
+
+func (r *Restorer) restoreDatabase(ctx context.Context, id int64, actor string) DatabaseStep {
+	res, err := r.databases.ReprovisionDatabase(ctx, id, actor)
+	step := DatabaseStep{Step: Step{Target: res.Database.DBName}}
+	if step.Target == "" {
+		step.Target = fmt.Sprintf("database %d", id)
+	}
+	if res.Connection.Engine != "" {
+		conn := res.Connection
+		step.Connection = &conn
+	}
+	switch {
+	case err != nil:
+		step.Error = err.Error()
+	case res.Dump != "":
+		step.Detail = "dump " + res.Dump
+	case res.Database.DBEngine == database.DBEngineSQLite:
+		step.Detail = "restored with the site files"
+	default:
+		step.Error = "no dump found; the database is empty"
+	}
+	return step
+}
+
+// adoptIdentity makes identity the install key, so every backup encrypted
+// to it opens and new backups stay readable with the same key. An existing
+// different key is only replaced with force, and is kept next to it.
+func (r *Restorer) adoptIdentity(identity string, force bool) error {
+	identity = strings.TrimSpace(identity)
+	if identity == "" {
+		return nil
+	}
+	id, err := backup.ParseIdentity(identity)
+	if err != nil {
+		return fmt.Errorf("invalid restore: identity: %w", err)
+	}
+	path := backup.KeyFile(r.store.DataDir)
+	//nolint:gosec // G304: the key path is derived from the panel data dir.
+	raw, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		current, err := backup.ParseIdentity(string(raw))
+		if err == nil && current.String() == id.String() {
+			return nil
+		}
+		if !force {
+			return fmt.Errorf("%s already holds a different backup key; pass --force to replace it", path)
+		}
+		if err := os.Rename(path, fmt.Sprintf("%s.%d", path, time.Now().Unix())); err != nil {
+			return fmt.Errorf("keep current backup key: %w", err)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("read backup key: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(id.String()+"\n"), 0o600); err != nil {
+		return fmt.Errorf("write backup key: %w", err)
+	}
+	return nil
+}
+
+// replayPanel replaces panel.db with its newest backup. Sessions are
+// dropped: they belonged to the old server.
+func (r *Restorer) replayPanel(ctx context.Context) (string, error) {
+	backups, err := backup.PanelBackups(backup.PanelDir(r.store.DataDir))
+	if err != nil {
+		return "", err
+	}
+	if len(backups) == 0 {
+		return "", backup.ErrNoPanelBackup
+	}
+	newest := backups[len(backups)-1]
+	if _, err := os.Stat(newest + backup.ChecksumSuffix); err == nil {
+		if err := backup.VerifyChecksum(newest); err != nil {
+			return "", fmt.Errorf("%s: %w", filepath.Base(newest), err)
+		}
+	}
+	keys, err := backup.LoadKeyring(ctx, r.store)
+	if err != nil {
+		return "", err
+	}
+	in, err := backup.OpenFile(newest, keys)
+	if err != nil {
+		if errors.Is(err, backup.ErrNoKey) {
+			return "", fmt.Errorf("%s: %w; pass --identity-file with the exported install key or a client escrow key", filepath.Base(newest), err)
+		}
+		return "", err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	tmp := r.store.PanelDB + ".restore"
+	if err := writeFile(tmp, in, 0o600, time.Time{}); err != nil {
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("decrypt %s: %w", filepath.Base(newest), err)
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(r.store.PanelDB + suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			_ = os.Remove(tmp)
+			return "", fmt.Errorf("remove panel.db%s: %w", suffix, err)
+		}
+	}
+	if err := os.Rename(tmp, r.store.PanelDB); err != nil {
+		return "", fmt.Errorf("replace panel.db: %w", err)
+	}
+	if err := r.store.Init(ctx); err != nil {
+		return "", fmt.Errorf("migrate restored panel.db: %w", err)
+	}
+	if err := r.store.ExecPanel(ctx, "DELETE FROM sessions;"); err != nil {
+		return "", fmt.Errorf("drop restored sessions: %w", err)
+	}
+	return newest, nil
+}
+
+func (r *Restorer) writeAudit(ctx context.Context, actor, details string) {
+	if strings.TrimSpace(actor) == "" {
+		actor = "system"
+	}
+	_ = r.store.ExecAudit(ctx, fmt.Sprintf(
+		"INSERT INTO audit_events(actor, action, details, created_at) VALUES('%s','panel.restore','%s',%d);",
+		sqlEscape(actor), sqlEscape(details), time.Now().Unix()))
+}
+
+// copyTree copies the backup dir src into dst, keeping modification times
+// (dump retention and recovery order go by them). Files already in dst are
+// left alone; a restore from dst itself copies nothing.
+func copyTree(src, dst string) error {
+	srcAbs, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	dstAbs, err := filepath.Abs(dst)
+	if err != nil {
+		return err
+	}
+	if srcAbs == dstAbs {
+		return nil
+	}
+	return filepath.WalkDir(srcAbs, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcAbs, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dstAbs, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o700)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if _, err := os.Lstat(target); err == nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		//nolint:gosec // G304: p is inside the backup being restored.
+		in, err := os.Open(p)
+		if err != nil {
+			return fmt.Errorf("copy backup: %w", err)
+		}
+		defer func() {
+			_ = in.Close()
+		}()
+		if err := writeFile(target, in, 0o600, info.ModTime()); err != nil {
+			_ = os.Remove(target)
+			return fmt.Errorf("copy backup %s: %w", rel, err)
+		}
+		return nil
+	})
+}
+
+func writeFile(path string, r io.Reader, perm os.FileMode, modTime time.Time) error {
+	//nolint:gosec // G304: callers pass paths under the panel data dir.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || modTime.IsZero() {
+		return err
+	}
+	return os.Chtimes(path, modTime, modTime)
+}
+
+func intValue(v any) int {
+	switch n := v.(type) {
+	case float64:
+		return int(n)
+	case int64:
+		return int(n)
+	case int:
+		return n
+	}
+	return 0
+}
+
+func stringValue(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+func sqlEscape(in string) string {
+	return strings.ReplaceAll(in, "'", "''")
+}
@@ -0,0 +1,187 @@
+package recovery
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robsonek/aiPanel/internal/modules/backup"
+	"github.com/robsonek/aiPanel/internal/modules/database"
+	"github.com/robsonek/aiPanel/internal/modules/hosting"
+	"github.com/robsonek/aiPanel/internal/platform/jobqueue"
+	"github.com/robsonek/aiPanel/internal/platform/sqlite"
+)
+
+type fakeSites struct {
+	reprovisioned []int64
+	certs         []string
+}
+
+func (f *fakeSites) ReprovisionSite(_ context.Context, id int64, _ string) (hosting.Site, error) {
+	f.reprovisioned = append(f.reprovisioned, id)
+	return hosting.Site{ID: id}, nil
+}
+
+func (f *fakeSites) IssueCertificate(_ context.Context, req hosting.IssueCertificateRequest) error {
+	f.certs = append(f.certs, req.Domain)
+	return nil
+}
+
+type fakeDatabases struct{}
+
+func (fakeDatabases) ReprovisionDatabase(_ context.Context, id int64, _ string) (database.ReprovisionResult, error) {
+	return database.ReprovisionResult{
+		Database:   database.SiteDatabase{ID: id, DBName: "shop", DBUser: "shop_user", DBEngine: "mariadb"},
+		Dump:       "shop-20260101-000000.sql",
+		Connection: database.ConnectionInfo{Engine: "mariadb", Database: "shop", User: "shop_user", Password: "new-secret"},
+	}, nil
+}
+
+// newSourcePanel builds the panel being recovered: one site with a docroot
+// snapshot, a database row, a session, and an encrypted panel.db backup.
+func newSourcePanel(t *testing.T, docroot string) *sqlite.Store {
+	t.Helper()
+	ctx := context.Background()
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init source store: %v", err)
+	}
+	if err := os.MkdirAll(docroot, 0o755); err != nil {
+		t.Fatalf("mkdir docroot: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(docroot, "index.php"), []byte("<?php echo 1;"), 0o640); err != nil {
+		t.Fatalf("write docroot: %v", err)
+	}
+	seed := `
+INSERT INTO users(id, email, password_hash, role, created_at) VALUES(1, 'admin@example.com', 'x', 'admin', 1);
+INSERT INTO sessions(token, user_id, expires_at, created_at) VALUES('old-session-token', 1, 4000000000, 1);
+INSERT INTO sites(id, domain, root_dir, php_version, system_user, status, created_at, updated_at)
+VALUES(1, 'example.com', '` + docroot + `', '8.3', 'site_example_com', 'active', 1, 1);
+INSERT INTO site_databases(site_id, db_name, db_user, db_engine, created_at) VALUES(1, 'shop', 'shop_user', 'mariadb', 1);
+`
+	if err := store.ExecPanel(ctx, seed); err != nil {
+		t.Fatalf("seed source: %v", err)
+	}
+	svc := backup.NewService(store, nil, backup.Options{})
+	queue := jobqueue.New(store, nil)
+	svc.RegisterJobs(queue)
+	if _, err := svc.BackupSite(ctx, 1, "admin@example.com"); err != nil {
+		t.Fatalf("queue site backup: %v", err)
+	}
+	if _, err := queue.RunPending(ctx); err != nil {
+		t.Fatalf("run site backup: %v", err)
+	}
+	if _, err := svc.BackupPanel(ctx, backup.TriggerScheduled); err != nil {
+		t.Fatalf("backup panel: %v", err)
+	}
+	return store
+}
+
+func TestRestorer_RebuildsFreshInstallFromBackups(t *testing.T) {
+	ctx := context.Background()
+	docroot := filepath.Join(t.TempDir(), "example.com", "public_html")
+	source := newSourcePanel(t, docroot)
+	key, err := os.ReadFile(backup.KeyFile(source.DataDir))
+	if err != nil {
+		t.Fatalf("read source key: %v", err)
+	}
+	// The server is gone: only the backups and the exported key remain.
+	if err := os.RemoveAll(docroot); err != nil {
+		t.Fatalf("remove docroot: %v", err)
+	}
+
+	store := sqlite.New(t.TempDir())
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	sites := &fakeSites{}
+	restorer := New(store, sites, fakeDatabases{}, backup.NewService(store, nil, backup.Options{}))
+
+	if _, err := restorer.Run(ctx, Options{From: backup.Dir(source.DataDir), Actor: "cli"}); err == nil || !errors.Is(err, backup.ErrNoKey) {
+		t.Fatalf("expected restore without the key to fail with ErrNoKey, got %v", err)
+	}
+	if err := os.Remove(backup.KeyFile(store.DataDir)); err != nil {
+		t.Fatalf("remove generated key: %v", err)
+	}
+
+	report, err := restorer.Run(ctx, Options{From: "file://" + backup.Dir(source.DataDir), Identity: string(key), Actor: "cli"})
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if report.Failures() != 0 || report.Users != 1 || len(report.Sites) != 1 || len(report.Databases) != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if raw, err := os.ReadFile(filepath.Join(docroot, "index.php")); err != nil || string(raw) != "<?php echo 1;" {
+		t.Fatalf("expected docroot restored, got %q err=%v", raw, err)
+	}
+	if len(sites.reprovisioned) != 1 || sites.reprovisioned[0] != 1 || len(sites.certs) != 1 || sites.certs[0] != "example.com" {
+		t.Fatalf("expected site 1 reprovisioned and certified, got %+v", sites)
+	}
+	if c := report.Databases[0].Connection; c == nil || c.Password != "new-secret" {
+		t.Fatalf("expected new database credentials in the report, got %+v", report.Databases[0])
+	}
+	if rows, _ := store.QueryPanelJSON(ctx, "SELECT token FROM sessions;"); len(rows) != 0 {
+		t.Fatalf("expected sessions of the old server dropped, got %+v", rows)
+	}
+	if got, _ := os.ReadFile(backup.KeyFile(store.DataDir)); strings.TrimSpace(string(got)) != strings.TrimSpace(string(key)) {
+		t.Fatalf("expected the identity adopted as install key")
+	}
+	rows, err := store.QueryAuditJSON(ctx, "SELECT action FROM audit_events WHERE action = 'panel.restore';")
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("expected one panel.restore audit event, got %+v err=%v", rows, err)
+	}
+
+	if _, err := restorer.Run(ctx, Options{From: backup.Dir(source.DataDir), Identity: string(key)}); !errors.Is(err, ErrNotFresh) {
+		t.Fatalf("expected a second restore to need --force, got %v", err)
+	}
+}
+
+func TestFetch_ExtractsArchivesAndRejectsEscapes(t *testing.T) {
+	ctx := context.Background()
+	writeArchive := func(files map[string]string) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "backup.tar.gz")
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatalf("create archive: %v", err)
+		}
+		gz := gzip.NewWriter(f)
+		tw := tar.NewWriter(gz)
+		for name, body := range files {
+			if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+				t.Fatalf("write header: %v", err)
+			}
+			if _, err := tw.Write([]byte(body)); err != nil {
+				t.Fatalf("write body: %v", err)
+			}
+		}
+		_ = tw.Close()
+		_ = gz.Close()
+		_ = f.Close()
+		return path
+	}
+
+	root, err := fetch(ctx, writeArchive(map[string]string{"backups/panel/panel-20260101-000000.db": "db"}), t.TempDir())
+	if err != nil {
+		t.Fatalf("fetch archive: %v", err)
+	}
+	if raw, err := os.ReadFile(filepath.Join(root, "panel", "panel-20260101-000000.db")); err != nil || string(raw) != "db" {
+		t.Fatalf("expected the backups dir located inside the archive, got %q err=%v", raw, err)
+	}
+
+	staging := t.TempDir()
+	if _, err := fetch(ctx, writeArchive(map[string]string{"panel/x.db": "db", "../escape": "x"}), staging); err == nil {
+		t.Fatalf("expected an entry outside the archive to be rejected")
+	}
+	if _, err := os.Stat(filepath.Join(staging, "escape")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("escaping entry was written: %v", err)
+	}
+	if _, err := fetch(ctx, "ftp://example.com/backup.tar.gz", t.TempDir()); err == nil {
+		t.Fatalf("expected unsupported scheme to fail")
+	}
+}
@@ -0,0 +1,181 @@
+package recovery
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxArchiveSize caps a downloaded backup archive (64 GiB).
+const maxArchiveSize = 64 << 30
+
+// fetch makes the backup at from available as a local backup dir: a
+// directory (or file:// URL) is used as is, a .tar.gz archive — local or
+// over http(s) — is extracted into staging.
+func fetch(ctx context.Context, from, staging string) (string, error) {
+	from = strings.TrimSpace(from)
+	if from == "" {
+		return "", fmt.Errorf("invalid restore: backup url is required")
+	}
+	u, err := url.Parse(from)
+	if err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		archive := filepath.Join(staging, "backup.tar.gz")
+		if err := download(ctx, from, archive); err != nil {
+			return "", err
+		}
+		return extract(archive, filepath.Join(staging, "backup"))
+	}
+	path := from
+	if err == nil && u.Scheme == "file" {
+		path = u.Path
+	} else if err == nil && u.Scheme != "" {
+		return "", fmt.Errorf("invalid restore: unsupported backup url scheme %q", u.Scheme)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("open backup: %w", err)
+	}
+	if info.IsDir() {
+		return locateRoot(path)
+	}
+	return extract(path, filepath.Join(staging, "backup"))
+}
+
+func download(ctx context.Context, from, dest string) error {
+	ctx, cancel := context.WithTimeout(ctx, 6*time.Hour)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, from, nil)
+	if err != nil {
+		return fmt.Errorf("invalid restore: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("download backup: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download backup: %s", resp.Status)
+	}
+	//nolint:gosec // G304: dest is inside the restore staging dir.
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("download backup: %w", err)
+	}
+	n, err := io.Copy(f, io.LimitReader(resp.Body, maxArchiveSize+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("download backup: %w", err)
+	}
+	if n > maxArchiveSize {
+		return fmt.Errorf("download backup: archive exceeds %d bytes", int64(maxArchiveSize))
+	}
+	return nil
+}
+
+// extract unpacks a .tar.gz backup archive into dest. Only directories and
+// regular files are accepted, and every name must stay inside dest: an
+// archive fetched from elsewhere is not trusted to write anywhere else.
+func extract(archive, dest string) (string, error) {
+	//nolint:gosec // G304: archive is the operator-supplied backup.
+	f, err := os.Open(archive)
+	if err != nil {
+		return "", fmt.Errorf("open backup archive: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return "", fmt.Errorf("invalid backup archive: %w", err)
+	}
+	if err := os.MkdirAll(dest, 0o700); err != nil {
+		return "", fmt.Errorf("create restore staging dir: %w", err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("invalid backup archive: %w", err)
+		}
+		name := filepath.FromSlash(strings.TrimPrefix(hdr.Name, "./"))
+		if name == "" || name == "." {
+			continue
+		}
+		if !filepath.IsLocal(name) {
+			return "", fmt.Errorf("invalid backup archive: entry %q escapes the archive", hdr.Name)
+		}
+		target := filepath.Join(dest, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o700); err != nil {
+				return "", fmt.Errorf("extract %s: %w", hdr.Name, err)
+			}
+		case tar.TypeReg:
+			if err := extractFile(tr, target, hdr.ModTime); err != nil {
+				return "", fmt.Errorf("extract %s: %w", hdr.Name, err)
+			}
+		default:
+			return "", fmt.Errorf("invalid backup archive: entry %q is not a file or directory", hdr.Name)
+		}
+	}
+	return locateRoot(dest)
+}
+
+func extractFile(r io.Reader, target string, modTime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+		return err
+	}
+	//nolint:gosec // G304: target was checked to stay inside the staging dir.
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(target, modTime, modTime)
+}
+
+// locateRoot finds the backup dir in dir: dir itself when it holds panel.db
+// copies, or its only subdirectory that does (an archive of "backups/").
+func locateRoot(dir string) (string, error) {
+	if isBackupRoot(dir) {
+		return dir, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("read backup: %w", err)
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		sub := filepath.Join(dir, entries[0].Name())
+		if isBackupRoot(sub) {
+			return sub, nil
+		}
+	}
+	return "", fmt.Errorf("invalid restore: %s holds no panel.db backup (expected a copy of <data_dir>/backups)", dir)
+}
+
+func isBackupRoot(dir string) bool {
+	info, err := os.Stat(filepath.Join(dir, "panel"))
+	return err == nil && info.IsDir()
+}
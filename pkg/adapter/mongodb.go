@@ -0,0 +1,25 @@
+package adapter
+
+import "context"
+
+// MongoDB defines operations required to manage MongoDB databases and users.
+type MongoDB interface {
+	CreateDatabase(ctx context.Context, dbName string) error
+	DropDatabase(ctx context.Context, dbName string) error
+	CreateUser(ctx context.Context, username, password, dbName string) error
+	DropUser(ctx context.Context, username string) error
+	SetPassword(ctx context.Context, username, password string) error
+	Dump(ctx context.Context, dbName, dest string) error
+	Restore(ctx context.Context, dbName, owner, src string) error
+	IsRunning(ctx context.Context) (bool, error)
+	Stats(ctx context.Context, dbName string) (DocumentDBStats, error)
+}
+
+// DocumentDBStats contains storage statistics of one document database.
+type DocumentDBStats struct {
+	Collections int64 `json:"collections"`
+	Objects     int64 `json:"objects"`
+	DataSize    int64 `json:"data_size"`
+	StorageSize int64 `json:"storage_size"`
+	IndexSize   int64 `json:"index_size"`
+}
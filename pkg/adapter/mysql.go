@@ -0,0 +1,15 @@
+package adapter
+
+import "context"
+
+// MySQL defines operations required to manage MySQL databases and users.
+type MySQL interface {
+	CreateDatabase(ctx context.Context, dbName string) error
+	DropDatabase(ctx context.Context, dbName string) error
+	CreateUser(ctx context.Context, username, password, dbName string) error
+	DropUser(ctx context.Context, username string) error
+	SetPassword(ctx context.Context, username, password string) error
+	Dump(ctx context.Context, dbName, dest string) error
+	Restore(ctx context.Context, dbName, owner, src string) error
+	IsRunning(ctx context.Context) (bool, error)
+}
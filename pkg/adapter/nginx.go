@@ -8,6 +8,53 @@ type SiteConfig struct {
 	RootDir    string
 	PHPVersion string
 	SystemUser string
+	// Env holds extra environment variables for the site PHP pool.
+	Env map[string]string
+	// MediaUpstream, when set, is the object storage URL nginx proxies
+	// /media/ to.
+	MediaUpstream string
+	// Limits, when set, confines the site processes to a systemd slice.
+	Limits *ResourceLimits
+	// TmpDir is the private temporary directory of the site PHP pool.
+	TmpDir string
+	// RelaxOpenBasedir widens open_basedir from the docroot to the whole
+	// site home and the shared /tmp, for apps keeping code outside it.
+	RelaxOpenBasedir bool
+	// ServerName is the host the site is served on; empty means Domain.
+	ServerName string
+	// RedirectHosts are permanently redirected to ServerName.
+	RedirectHosts []string
+	// PageCache, when set, caches rendered pages in front of the PHP pool.
+	PageCache *PageCache
+}
+
+// Page cache backends.
+const (
+	// PageCacheFastCGI caches PHP responses in nginx (fastcgi_cache).
+	PageCacheFastCGI = "fastcgi"
+	// PageCacheVarnish proxies the site through varnishd, which fetches
+	// from nginx on a loopback backend port.
+	PageCacheVarnish = "varnish"
+)
+
+// PageCache configures the full-page cache of one site.
+type PageCache struct {
+	Backend    string
+	TTLSeconds int
+	// Dir holds the fastcgi_cache files of the site.
+	Dir string
+	// VarnishAddr is the host:port of varnishd and BackendPort the
+	// loopback port nginx serves the site to it on.
+	VarnishAddr string
+	BackendPort int
+}
+
+// ResourceLimits are systemd slice limits for one site. Zero fields are
+// unlimited.
+type ResourceLimits struct {
+	CPUQuotaPercent int
+	MemoryMaxMB     int
+	TasksMax        int
 }
 
 // Nginx defines operations required to manage per-site vhost config.
@@ -0,0 +1,23 @@
+package adapter
+
+import (
+	"context"
+	"time"
+)
+
+// PointInTimeRecovery is implemented by engines that archive their change
+// log (MariaDB binary log, PostgreSQL WAL) and can replay it to a timestamp.
+type PointInTimeRecovery interface {
+	// RestoreToTime loads base into dbName and replays archived changes up
+	// to target. base is a logical dump for MariaDB and a cluster base
+	// backup for PostgreSQL.
+	RestoreToTime(ctx context.Context, dbName, base string, target time.Time) error
+	// PruneArchive removes archived changes older than before.
+	PruneArchive(ctx context.Context, before time.Time) error
+}
+
+// BaseBackup is implemented by engines whose point-in-time recovery starts
+// from a physical copy of the whole cluster.
+type BaseBackup interface {
+	BaseBackup(ctx context.Context, dest string) error
+}
@@ -8,5 +8,8 @@ type PostgreSQL interface {
 	DropDatabase(ctx context.Context, dbName string) error
 	CreateUser(ctx context.Context, username, password, dbName string) error
 	DropUser(ctx context.Context, username string) error
+	SetPassword(ctx context.Context, username, password string) error
+	Dump(ctx context.Context, dbName, dest string) error
+	Restore(ctx context.Context, dbName, owner, src string) error
 	IsRunning(ctx context.Context) (bool, error)
 }
@@ -0,0 +1,15 @@
+package adapter
+
+import "context"
+
+// Registrar defines the domain registrar operations the panel needs to
+// point a registered domain at its own nameservers.
+type Registrar interface {
+	// Name identifies the registrar in API responses and audit entries.
+	Name() string
+	CheckAvailability(ctx context.Context, domain string) (bool, error)
+	SetNameservers(ctx context.Context, domain string, nameservers []string) error
+	// SetGlueRecord creates or updates the glue addresses of a nameserver
+	// host under domain.
+	SetGlueRecord(ctx context.Context, domain, host string, ips []string) error
+}
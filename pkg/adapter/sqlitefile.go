@@ -0,0 +1,10 @@
+package adapter
+
+import "context"
+
+// SQLiteFile defines operations required to manage file-backed SQLite databases.
+type SQLiteFile interface {
+	CreateFile(ctx context.Context, path, owner string) error
+	RemoveFile(ctx context.Context, path string) error
+	Backup(ctx context.Context, path, dest, owner string) error
+}
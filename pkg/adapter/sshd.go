@@ -0,0 +1,22 @@
+package adapter
+
+import "context"
+
+// SFTPAccount is an SFTP-only login of a site user, jailed to ChrootDir.
+type SFTPAccount struct {
+	User string
+	// ChrootDir must be owned by root and writable by no one else, as
+	// sshd requires of a chroot.
+	ChrootDir string
+	// StartDir is where sessions start, relative to ChrootDir.
+	StartDir       string
+	AuthorizedKeys []string
+}
+
+// SSHD defines operations required to manage per-site SFTP logins.
+type SSHD interface {
+	WriteAccount(ctx context.Context, account SFTPAccount) error
+	RemoveAccount(ctx context.Context, user string) error
+	TestConfig(ctx context.Context) error
+	Reload(ctx context.Context) error
+}
@@ -0,0 +1,170 @@
+//go:build e2e
+
+// Package e2e runs the real installer inside a Debian 13 systemd container,
+// provisions a site and database through the API and checks the served vhost.
+//
+// Run with:
+//
+//	make e2e
+//
+// Environment:
+//
+//	AIPANEL_E2E_ENGINE  container engine binary (default: podman, then docker)
+//	AIPANEL_E2E_KEEP=1  keep the container after the run for debugging
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	imageTag      = "aipanel-e2e:debian13"
+	adminEmail    = "e2e@example.com"
+	adminPassword = "e2e-admin-password"
+	siteDomain    = "e2e.example.test"
+	panelURL      = "http://127.0.0.1:8080"
+	cookieJar     = "/tmp/aipanel-e2e.cookies"
+)
+
+type engine struct {
+	bin string
+}
+
+func TestInstallProvisionAndServe(t *testing.T) {
+	eng := findEngine(t)
+	repoRoot := repoRoot(t)
+	ctx := context.Background()
+
+	binPath := filepath.Join(t.TempDir(), "aipanel")
+	build := exec.CommandContext(ctx, "go", "build", "-o", binPath, "./cmd/aipanel")
+	build.Dir = repoRoot
+	build.Env = append(os.Environ(), "CGO_ENABLED=0", "GOOS=linux")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("build panel binary: %v\n%s", err, out)
+	}
+
+	eng.mustRun(t, ctx, 20*time.Minute, "build", "-t", imageTag, "-f", filepath.Join(repoRoot, "test", "e2e", "Containerfile"), filepath.Join(repoRoot, "test", "e2e"))
+	name := fmt.Sprintf("aipanel-e2e-%d", time.Now().UnixNano())
+	eng.mustRun(t, ctx, 2*time.Minute, "run", "--detach", "--privileged", "--name", name, "--cgroupns=host", "-v", "/sys/fs/cgroup:/sys/fs/cgroup:rw", imageTag)
+	t.Cleanup(func() {
+		if os.Getenv("AIPANEL_E2E_KEEP") == "1" {
+			t.Logf("keeping container %s", name)
+			return
+		}
+		_, _ = eng.run(context.Background(), time.Minute, "rm", "-f", name)
+	})
+	eng.waitForSystemd(t, ctx, name)
+
+	eng.mustRun(t, ctx, time.Minute, "cp", binPath, name+":/usr/local/bin/aipanel")
+	eng.mustRun(t, ctx, time.Minute, "exec", name, "mkdir", "-p", "/etc/aipanel")
+	eng.mustRun(t, ctx, time.Minute, "cp", filepath.Join(repoRoot, "configs", "sources", "lock.json"), name+":/etc/aipanel/sources.lock.json")
+
+	// Source builds of the runtime dominate the run time.
+	eng.mustRun(t, ctx, 90*time.Minute, "exec", name, "/usr/local/bin/aipanel", "install",
+		"--admin-email", adminEmail,
+		"--admin-password", adminPassword,
+		"--runtime-lock-path", "/etc/aipanel/sources.lock.json",
+	)
+
+	eng.curl(t, ctx, name, "-c", cookieJar, "-H", "Content-Type: application/json",
+		"-d", fmt.Sprintf(`{"email":%q,"password":%q}`, adminEmail, adminPassword),
+		panelURL+"/api/auth/login")
+
+	var created struct {
+		Site struct {
+			ID int64 `json:"id"`
+		} `json:"site"`
+	}
+	out := eng.curl(t, ctx, name, "-b", cookieJar, "-H", "Content-Type: application/json",
+		"-d", fmt.Sprintf(`{"domain":%q}`, siteDomain),
+		panelURL+"/api/sites")
+	if err := json.Unmarshal([]byte(out), &created); err != nil || created.Site.ID == 0 {
+		t.Fatalf("create site: unexpected response %q (%v)", out, err)
+	}
+
+	out = eng.curl(t, ctx, name, "-b", cookieJar, "-H", "Content-Type: application/json",
+		"-d", `{"db_name":"e2e_app","db_engine":"mariadb"}`,
+		fmt.Sprintf("%s/api/sites/%d/databases", panelURL, created.Site.ID))
+	if !strings.Contains(out, `"password"`) {
+		t.Fatalf("create database: unexpected response %q", out)
+	}
+
+	out = eng.curl(t, ctx, name, "-H", "Host: "+siteDomain, "http://127.0.0.1/")
+	if !strings.Contains(out, siteDomain) {
+		t.Fatalf("expected vhost to serve bootstrap page for %s, got %q", siteDomain, out)
+	}
+}
+
+func findEngine(t *testing.T) engine {
+	t.Helper()
+	candidates := []string{"podman", "docker"}
+	if v := strings.TrimSpace(os.Getenv("AIPANEL_E2E_ENGINE")); v != "" {
+		candidates = []string{v}
+	}
+	for _, c := range candidates {
+		if p, err := exec.LookPath(c); err == nil {
+			return engine{bin: p}
+		}
+	}
+	t.Skip("no container engine found (podman or docker)")
+	return engine{}
+}
+
+func repoRoot(t *testing.T) string {
+	t.Helper()
+	_, file, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("resolve test file path")
+	}
+	return filepath.Clean(filepath.Join(filepath.Dir(file), "..", ".."))
+}
+
+func (e engine) run(ctx context.Context, timeout time.Duration, args ...string) (string, error) {
+	cctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(cctx, e.bin, args...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	return out.String(), err
+}
+
+func (e engine) mustRun(t *testing.T, ctx context.Context, timeout time.Duration, args ...string) string {
+	t.Helper()
+	out, err := e.run(ctx, timeout, args...)
+	if err != nil {
+		t.Fatalf("%s %s: %v\n%s", filepath.Base(e.bin), strings.Join(args, " "), err, out)
+	}
+	return out
+}
+
+func (e engine) curl(t *testing.T, ctx context.Context, name string, args ...string) string {
+	t.Helper()
+	full := append([]string{"exec", name, "curl", "-fsS", "--max-time", "120"}, args...)
+	return e.mustRun(t, ctx, 3*time.Minute, full...)
+}
+
+func (e engine) waitForSystemd(t *testing.T, ctx context.Context, name string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Minute)
+	for time.Now().Before(deadline) {
+		out, _ := e.run(ctx, 30*time.Second, "exec", name, "systemctl", "is-system-running")
+		state := strings.TrimSpace(out)
+		if state == "running" || state == "degraded" {
+			return
+		}
+		time.Sleep(2 * time.Second)
+	}
+	t.Fatalf("systemd did not boot in container %s", name)
+}
@@ -0,0 +1,94 @@
+// k6 load test for the panel API.
+//
+// Usage:
+//   BASE_URL=http://127.0.0.1:8080 ADMIN_EMAIL=admin@example.com ADMIN_PASSWORD=... k6 run test/loadtest/k6.js
+//
+// Site creation is opt-in (CREATE_SITES=1) and must only target a disposable
+// instance (dev VM or container) because it provisions real vhosts and users.
+import http from "k6/http";
+import { check, fail } from "k6";
+
+const BASE_URL = __ENV.BASE_URL || "http://127.0.0.1:8080";
+const ADMIN_EMAIL = __ENV.ADMIN_EMAIL || "admin@example.com";
+const ADMIN_PASSWORD = __ENV.ADMIN_PASSWORD || "";
+const CREATE_SITES = __ENV.CREATE_SITES === "1";
+
+const scenarios = {
+  login: {
+    executor: "constant-arrival-rate",
+    exec: "login",
+    rate: 2,
+    timeUnit: "1s",
+    duration: __ENV.DURATION || "30s",
+    preAllocatedVUs: 4,
+  },
+  browse: {
+    executor: "constant-vus",
+    exec: "browse",
+    vus: Number(__ENV.VUS || 10),
+    duration: __ENV.DURATION || "30s",
+  },
+};
+if (CREATE_SITES) {
+  scenarios.create_site = {
+    executor: "per-vu-iterations",
+    exec: "createSite",
+    vus: 2,
+    iterations: 5,
+  };
+}
+
+// CI-friendly thresholds: k6 exits non-zero when any of them is crossed.
+export const options = {
+  scenarios,
+  thresholds: {
+    http_req_failed: ["rate<0.01"],
+    "http_req_duration{scenario:browse}": ["p(95)<150"],
+    "http_req_duration{scenario:login}": ["p(95)<500"],
+    "http_req_duration{scenario:create_site}": ["p(95)<5000"],
+  },
+};
+
+export function setup() {
+  if (!ADMIN_PASSWORD) {
+    fail("ADMIN_PASSWORD is required");
+  }
+  const res = doLogin();
+  if (res.status !== 200) {
+    fail(`login failed with status ${res.status}`);
+  }
+  return { cookie: res.cookies.aipanel_session[0].value };
+}
+
+function doLogin() {
+  return http.post(
+    `${BASE_URL}/api/auth/login`,
+    JSON.stringify({ email: ADMIN_EMAIL, password: ADMIN_PASSWORD }),
+    { headers: { "Content-Type": "application/json" } },
+  );
+}
+
+function authParams(data) {
+  return { cookies: { aipanel_session: data.cookie } };
+}
+
+export function login() {
+  check(doLogin(), { "login 200": (r) => r.status === 200 });
+}
+
+export function browse(data) {
+  const params = authParams(data);
+  check(http.get(`${BASE_URL}/api/auth/me`, params), { "me 200": (r) => r.status === 200 });
+  check(http.get(`${BASE_URL}/api/sites`, params), { "sites 200": (r) => r.status === 200 });
+}
+
+export function createSite(data) {
+  const domain = `lt-${__VU}-${__ITER}-${Date.now()}.example.test`;
+  const params = Object.assign(authParams(data), { headers: { "Content-Type": "application/json" } });
+  const res = http.post(`${BASE_URL}/api/sites`, JSON.stringify({ domain }), params);
+  check(res, { "create site 201": (r) => r.status === 201 });
+  if (res.status === 201) {
+    const id = res.json("site.id");
+    http.del(`${BASE_URL}/api/sites/${id}`, null, authParams(data));
+  }
+}
@@ -9,7 +9,7 @@ type SiteOption = {
 
 type CreateDatabaseFormProps = {
   sites: SiteOption[]
-  availableEngines: Array<'mariadb' | 'postgres'>
+  availableEngines: Array<'mariadb' | 'mysql' | 'postgres' | 'mongodb' | 'sqlite'>
   selectedSiteID: number | null
   onSelectSite: (siteID: number) => void
   onCreated: (password: string) => void
@@ -24,7 +24,7 @@ export function CreateDatabaseForm({
 }: CreateDatabaseFormProps) {
   const { t } = useTranslation()
   const [dbName, setDBName] = useState('')
-  const [dbEngine, setDBEngine] = useState<'mariadb' | 'postgres' | ''>('')
+  const [dbEngine, setDBEngine] = useState<'mariadb' | 'mysql' | 'postgres' | 'mongodb' | 'sqlite' | ''>('')
   const [submitting, setSubmitting] = useState(false)
   const [error, setError] = useState<string | null>(null)
 
@@ -115,7 +115,7 @@ export function CreateDatabaseForm({
         <select
           className="w-full rounded-md border border-[var(--border-subtle)] bg-[var(--bg-canvas)] px-3 py-2 text-sm outline-none focus:ring-2 focus:ring-[var(--focus-ring)]"
           value={dbEngine}
-          onChange={(e) => setDBEngine(e.target.value as 'mariadb' | 'postgres')}
+          onChange={(e) => setDBEngine(e.target.value as 'mariadb' | 'mysql' | 'postgres' | 'mongodb' | 'sqlite')}
           disabled={availableEngines.length === 0}
         >
           {availableEngines.length === 0 ? (
@@ -123,7 +123,15 @@ export function CreateDatabaseForm({
           ) : null}
           {availableEngines.map((engine) => (
             <option key={engine} value={engine}>
-              {engine === 'mariadb' ? t('databases.create.engineMariaDB') : t('databases.create.enginePostgreSQL')}
+              {engine === 'mariadb'
+                ? t('databases.create.engineMariaDB')
+                : engine === 'mysql'
+                  ? t('databases.create.engineMySQL')
+                  : engine === 'mongodb'
+                    ? t('databases.create.engineMongoDB')
+                    : engine === 'sqlite'
+                      ? t('databases.create.engineSQLite')
+                      : t('databases.create.enginePostgreSQL')}
             </option>
           ))}
         </select>
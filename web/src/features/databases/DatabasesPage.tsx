@@ -7,7 +7,7 @@ type Site = {
   domain: string
 }
 
-type DatabaseEngine = 'mariadb' | 'postgres'
+type DatabaseEngine = 'mariadb' | 'mysql' | 'postgres' | 'mongodb' | 'sqlite'
 
 type Database = {
   id: number
@@ -50,7 +50,7 @@ export function DatabasesPage() {
     }
     const payload = (await res.json()) as { engines: string[] }
     const engines = (payload.engines ?? []).filter((engine): engine is DatabaseEngine =>
-      engine === 'mariadb' || engine === 'postgres',
+      engine === 'mariadb' || engine === 'mysql' || engine === 'postgres' || engine === 'mongodb' || engine === 'sqlite',
     )
     setAvailableEngines(engines)
   }, [])
@@ -179,7 +179,15 @@ export function DatabasesPage() {
                     <td className="px-2 py-3">{item.db_engine}</td>
                     <td className="px-2 py-3">{sitesByID.get(item.site_id) || '-'}</td>
                     <td className="px-2 py-3">{new Date(item.created_at).toLocaleString()}</td>
-                    <td className="px-2 py-3">
+                    <td className="flex gap-2 px-2 py-3">
+                      {item.db_engine === 'sqlite' ? (
+                        <a
+                          href={`/api/databases/${item.id}/download`}
+                          className="rounded-md border border-[var(--border-subtle)] px-2 py-1 text-xs hover:bg-[var(--bg-canvas)]"
+                        >
+                          {t('databases.download')}
+                        </a>
+                      ) : null}
                       <button
                         type="button"
                         className="rounded-md border border-[var(--state-danger)]/40 px-2 py-1 text-xs text-[var(--state-danger)] hover:bg-[var(--state-danger)]/10"